@@ -2,16 +2,19 @@ package bridge
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
-	"math/rand"
-	"net/http"
+	"net"
+	"net/url"
+	"sync/atomic"
 	"time"
 
 	"github.com/Prescott-Data/nexus-framework/nexus-bridge/pkg/auth"
 	"github.com/Prescott-Data/nexus-framework/nexus-bridge/telemetry"
 	"github.com/gorilla/websocket"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 )
 
 // Bridge manages persistent connections.
@@ -25,6 +28,22 @@ type Bridge struct {
 	messageSizeLimit int64
 	writeTimeout     time.Duration
 	pingInterval     time.Duration
+	sendQueueDepth   int
+	closeTimeout     time.Duration
+	onRefresh        RefreshPolicy
+	proxyURL         *url.URL
+	netDial          dialFunc
+	tlsConfig        *tls.Config
+	heartbeat        Heartbeat
+	tracer           Tracer
+	// configErr records an invalid option; the Maintain methods return it.
+	configErr error
+
+	status  *statusHub
+	closers *closers
+	// stateChanged, if set, is called with every state published. A Pool
+	// uses it to track health.
+	stateChanged func(ConnectionState)
 }
 
 // New creates a new Bridge with optional configurations.
@@ -34,6 +53,7 @@ func New(oauthClient auth.TokenProvider, opts ...Option) *Bridge {
 		oauthClient: oauthClient,
 		logger:      &nopLogger{},
 		metrics:     &nopMetrics{},
+		tracer:      nopTracer{},
 		retryPolicy: RetryPolicy{
 			MinBackoff: 2 * time.Second,
 			MaxBackoff: 30 * time.Second,
@@ -44,6 +64,10 @@ func New(oauthClient auth.TokenProvider, opts ...Option) *Bridge {
 		messageSizeLimit: 65536, // 64KB
 		writeTimeout:     10 * time.Second,
 		pingInterval:     30 * time.Second,
+		sendQueueDepth:   1,
+		closeTimeout:     5 * time.Second,
+		status:           newStatusHub(),
+		closers:          newClosers(),
 	}
 
 	// Apply all the functional options provided by the user
@@ -57,12 +81,22 @@ func New(oauthClient auth.TokenProvider, opts ...Option) *Bridge {
 // NewStandard creates a new Bridge with production-ready defaults:
 // - Structured JSON logging (Slog) to Stdout
 // - Prometheus metrics registered to the default registry
+// - OpenTelemetry spans exported over OTLP, if OTEL_EXPORTER_OTLP_ENDPOINT
+// or OTEL_EXPORTER_OTLP_TRACES_ENDPOINT is set (see telemetry.StartTracing)
 func NewStandard(oauthClient auth.TokenProvider, agentLabels map[string]string, opts ...Option) *Bridge {
+	logger := telemetry.NewLogger()
 	// Prepend telemetry options so user can still override them if needed (though unlikely)
 	defaultOpts := []Option{
-		WithLogger(telemetry.NewLogger()),
+		WithLogger(logger),
 		WithMetrics(telemetry.NewMetrics(nil, agentLabels)), // nil = use default registry
 	}
+	if telemetry.TracingConfigured() {
+		if err := telemetry.StartTracing(context.Background(), agentLabels); err != nil {
+			logger.Error(err, "Tracing disabled")
+		} else {
+			defaultOpts = append(defaultOpts, WithTracer(telemetry.NewTracer(nil)))
+		}
+	}
 	// Combine defaults + user opts
 	finalOpts := append(defaultOpts, opts...)
 	return New(oauthClient, finalOpts...)
@@ -70,9 +104,34 @@ func NewStandard(oauthClient auth.TokenProvider, agentLabels map[string]string,
 
 // MaintainWebSocket is the main entry point. It runs a loop that attempts
 // to establish and manage a connection, with a backoff policy for retries.
-func (b *Bridge) MaintainWebSocket(ctx context.Context, connectionID string, endpointURL string, handler Handler) error {
+// It returns on a permanent error, once the RetryPolicy's Budget is spent
+// (ErrRetryBudgetExhausted), when ctx is cancelled, or with nil after Close.
+func (b *Bridge) MaintainWebSocket(ctx context.Context, connectionID string, endpointURL string, handler Handler) (err error) {
+	b.setState(ConnectionState{ConnectionID: connectionID, Phase: PhaseConnecting})
+	defer func() {
+		b.setState(ConnectionState{ConnectionID: connectionID, Phase: PhaseClosed, Err: err})
+	}()
+	cl := b.closers.register(connectionID)
+	defer b.closers.unregister(connectionID, cl)
+	if err := b.checkConfig(); err != nil {
+		return err
+	}
+	retry := newRetryState(b.retryPolicy)
 	for {
-		err := b.manageConnection(ctx, connectionID, endpointURL, handler)
+		var sess session
+		err := b.manageConnection(ctx, connectionID, endpointURL, handler, cl.requests, &sess)
+		if cm, ok := b.metrics.(ConnectionMetrics); ok && !sess.connectedAt.IsZero() {
+			cm.ObserveSession(time.Since(sess.connectedAt), sess.code(err))
+		}
+		if errors.Is(err, errClosedByCaller) {
+			b.logger.Info("Connection closed by caller", "connectionID", connectionID)
+			return nil
+		}
+		if errors.Is(err, errRedial) {
+			// Not a failure: dial again right away with the new credentials.
+			b.setState(ConnectionState{ConnectionID: connectionID, Phase: PhaseReconnecting, NextRetry: time.Now()})
+			continue
+		}
 		if err != nil {
 			var permanentErr *PermanentError
 			if errors.As(err, &permanentErr) {
@@ -89,10 +148,34 @@ func (b *Bridge) MaintainWebSocket(ctx context.Context, connectionID string, end
 			b.metrics.SetConnectionStatus(0)
 			return ctx.Err()
 		default:
-			// Connection dropped for a recoverable reason, wait and retry.
-			backoff := b.calculateBackoff()
-			b.logger.Info("Reconnecting", "connectionID", connectionID, "after", backoff)
-			time.Sleep(backoff)
+		}
+
+		// Connection dropped for a recoverable reason, wait and retry.
+		var uptime time.Duration
+		if !sess.connectedAt.IsZero() {
+			uptime = time.Since(sess.connectedAt)
+		}
+		backoff, budgetErr := retry.failed(uptime, err)
+		if budgetErr != nil {
+			b.logger.Error(budgetErr, "Retry budget exhausted; will not retry", "connectionID", connectionID)
+			b.metrics.SetConnectionStatus(0)
+			return budgetErr
+		}
+		b.setState(ConnectionState{
+			ConnectionID: connectionID, Phase: PhaseReconnecting,
+			Attempt: retry.failures, NextRetry: time.Now().Add(backoff), Err: err,
+		})
+		b.logger.Info("Reconnecting", "connectionID", connectionID, "attempt", retry.failures, "after", backoff)
+		select {
+		case <-ctx.Done():
+			b.logger.Info("Context cancelled during backoff; shutting down bridge", "connectionID", connectionID)
+			b.metrics.SetConnectionStatus(0)
+			return ctx.Err()
+		case req := <-cl.requests:
+			b.logger.Info("Connection closed by caller during backoff", "connectionID", connectionID)
+			req.result <- nil
+			return nil
+		case <-time.After(backoff):
 		}
 	}
 }
@@ -105,6 +188,9 @@ func (b *Bridge) MaintainWebSocket(ctx context.Context, connectionID string, end
 //   - run returns nil (clean exit)
 //   - run returns ErrInteractionRequired (user must re-authenticate)
 //   - run returns a *PermanentError
+//   - the connection is suspended (ErrConnectionSuspended), whether run
+//     returns it or the credentials hit it fetching a token
+//   - the RetryPolicy's Budget is spent (ErrRetryBudgetExhausted)
 //   - context is cancelled
 func (b *Bridge) MaintainGRPCConnection(
 	ctx context.Context,
@@ -112,13 +198,27 @@ func (b *Bridge) MaintainGRPCConnection(
 	target string,
 	run func(ctx context.Context, conn *grpc.ClientConn) error,
 	opts ...grpc.DialOption,
-) error {
-	backoff := b.retryPolicy.MinBackoff
+) (err error) {
+	b.setState(ConnectionState{ConnectionID: connectionID, Phase: PhaseConnecting})
+	defer func() {
+		b.setState(ConnectionState{ConnectionID: connectionID, Phase: PhaseClosed, Err: err})
+	}()
+	if err := b.checkConfig(); err != nil {
+		return err
+	}
+	retry := newRetryState(b.retryPolicy)
 	attempt := 0
+	var (
+		wait    time.Duration
+		lastErr error
+	)
 
 	for {
 		if attempt > 0 {
-			wait := b.applyJitter(backoff)
+			b.setState(ConnectionState{
+				ConnectionID: connectionID, Phase: PhaseReconnecting,
+				Attempt: retry.failures, NextRetry: time.Now().Add(wait), Err: lastErr,
+			})
 			b.logger.Info("Reconnecting gRPC", "target", target, "attempt", attempt, "after", wait)
 			select {
 			case <-ctx.Done():
@@ -130,21 +230,43 @@ func (b *Bridge) MaintainGRPCConnection(
 		attempt++
 
 		creds := NewBridgeCredentials(b.oauthClient, connectionID, b.refreshBuffer, b.logger)
-		dialOpts := append(opts, grpc.WithPerRPCCredentials(creds))
+		creds.tracer = b.tracer
+		if cm, ok := b.metrics.(CredentialMetrics); ok {
+			creds.metrics = cm
+		}
+		// The Bridge's dialer and TLS come first, so opts take precedence.
+		var dialOpts []grpc.DialOption
+		if b.customDial() {
+			dialOpts = append(dialOpts, grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+				return b.dial(ctx, "tcp", addr)
+			}))
+		}
+		if b.tlsConfig != nil {
+			dialOpts = append(dialOpts, grpc.WithTransportCredentials(credentials.NewTLS(b.tlsConfig.Clone())))
+		}
+		dialOpts = append(dialOpts, opts...)
+		dialOpts = append(dialOpts, grpc.WithPerRPCCredentials(creds))
 
 		b.logger.Info("Dialing gRPC target", "target", target, "attempt", attempt)
 		conn, err := grpc.NewClient(target, dialOpts...)
 		if err != nil {
 			b.logger.Error(err, "Failed to dial gRPC target", "target", target, "attempt", attempt)
-			backoff = b.growBackoff(backoff)
+			lastErr = err
+			if wait, err = retry.failed(0, err); err != nil {
+				b.logger.Error(err, "Retry budget exhausted; stopping gRPC bridge", "connectionID", connectionID)
+				return err
+			}
 			continue
 		}
 
 		b.metrics.IncConnections()
 		b.metrics.SetConnectionStatus(1)
+		b.setState(ConnectionState{ConnectionID: connectionID, Phase: PhaseConnected})
 		b.logger.Info("gRPC connection established", "target", target)
 
+		started := time.Now()
 		err = run(ctx, conn)
+		uptime := time.Since(started)
 
 		conn.Close()
 		b.metrics.SetConnectionStatus(0)
@@ -166,18 +288,35 @@ func (b *Bridge) MaintainGRPCConnection(
 			return err
 		}
 
+		// gRPC reports credential errors as a status without the cause, so
+		// the credentials remember a suspension themselves.
+		if serr := creds.suspended(); serr != nil || errors.Is(err, ErrConnectionSuspended) {
+			if serr == nil {
+				serr = err
+			}
+			b.logger.Error(serr, "Connection suspended; stopping gRPC retry", "connectionID", connectionID)
+			return NewPermanentError(serr)
+		}
+
 		if ctx.Err() != nil {
 			b.logger.Info("Context cancelled; shutting down gRPC bridge", "connectionID", connectionID)
 			return ctx.Err()
 		}
 
 		b.logger.Error(err, "gRPC run loop exited with error; will retry", "connectionID", connectionID, "attempt", attempt)
-		backoff = b.growBackoff(backoff)
+		lastErr = err
+		if wait, err = retry.failed(uptime, err); err != nil {
+			b.logger.Error(err, "Retry budget exhausted; stopping gRPC bridge", "connectionID", connectionID)
+			return err
+		}
 	}
 }
 
 // manageConnection handles a single connection lifecycle: get token, connect, and operate.
-func (b *Bridge) manageConnection(ctx context.Context, connectionID string, endpointURL string, handler Handler) error {
+// sess records when the WebSocket is established and how it closes. A
+// request on closeReqs closes the connection gracefully and returns
+// errClosedByCaller.
+func (b *Bridge) manageConnection(ctx context.Context, connectionID string, endpointURL string, handler Handler, closeReqs <-chan closeRequest, sess *session) error {
 	// Step 1: Get an initial token.
 	token, err := b.oauthClient.GetToken(ctx, connectionID)
 	if err != nil {
@@ -187,24 +326,27 @@ func (b *Bridge) manageConnection(ctx context.Context, connectionID string, endp
 	b.logger.Info("Successfully obtained initial token", "connectionID", connectionID)
 
 	// Step 2: Establish the WebSocket connection.
-	// We create a dummy request to let the auth package inject the credentials.
-	req, err := http.NewRequest("GET", endpointURL, nil)
+	// The request is rebuilt from the original endpoint URL on every attempt so
+	// query-param and header credentials always reflect the current token.
+	req, err := auth.NewAuthenticatedRequest("GET", endpointURL, token)
 	if err != nil {
-		return fmt.Errorf("failed to create request for auth injection: %w", err)
-	}
-
-	// Apply the authentication strategy.
-	if err := auth.ApplyAuthentication(req, token.Strategy, token.Credentials); err != nil {
 		return NewPermanentError(fmt.Errorf("failed to apply authentication strategy: %w", err))
 	}
 
 	// Dial uses the headers and the potentially modified URL (for query params).
-	conn, _, err := b.dialer.Dial(req.URL.String(), req.Header)
+	dialCtx, endSpan := b.tracer.Start(ctx, "bridge.websocket.dial", "connection.id", connectionID, "endpoint", req.URL.Host)
+	dialStarted := time.Now()
+	conn, _, err := b.wsDialer().DialContext(dialCtx, req.URL.String(), req.Header)
+	endSpan(err)
+	if cm, ok := b.metrics.(ConnectionMetrics); ok {
+		cm.ObserveConnect(time.Since(dialStarted), err)
+	}
 	if err != nil {
 		// WebSocket dialing errors are typically recoverable, so we don't wrap this.
 		return fmt.Errorf("failed to establish WebSocket connection: %w", err)
 	}
 	defer conn.Close()
+	sess.connectedAt = time.Now()
 
 	conn.SetReadLimit(b.messageSizeLimit)
 	conn.SetPongHandler(func(string) error {
@@ -215,33 +357,76 @@ func (b *Bridge) manageConnection(ctx context.Context, connectionID string, endp
 
 	b.metrics.IncConnections()
 	b.metrics.SetConnectionStatus(1)
+	b.setState(ConnectionState{ConnectionID: connectionID, Phase: PhaseConnected})
 	b.logger.Info("Successfully established WebSocket connection", "connectionID", connectionID, "endpoint", endpointURL)
 
 	// --- Concurrency and Shutdown Management ---
 	done := make(chan struct{})       // Channel to signal shutdown to goroutines
-	writeChan := make(chan []byte, 1) // Channel for thread-safe writes
+	writerDone := make(chan struct{}) // Closed once the write pump has exited
+	flushChan := make(chan flushRequest)
+	sender := newSender(b.sendQueueDepth, done, handler, b.metrics)
+	// stop shuts the goroutines down and waits until every queued message
+	// has been written or reported, so none leaks into the next session.
+	stop := func() {
+		close(done)
+		<-writerDone
+	}
 
-	// Step 3: Call OnConnect, providing a thread-safe send function.
-	sendFunc := func(message []byte) error {
-		select {
-		case writeChan <- message:
-			return nil
-		case <-done:
-			return fmt.Errorf("connection is closed")
+	// Step 3: Start the "write pump" and "ping" goroutine for thread-safe writes and health checks.
+	go func() {
+		defer close(writerDone)
+		defer sender.close()
+		pingTicker := time.NewTicker(b.pingInterval)
+		defer pingTicker.Stop()
+
+		for {
+			select {
+			case m := <-sender.queue:
+				conn.SetWriteDeadline(time.Now().Add(b.writeTimeout))
+				err := conn.WriteMessage(int(m.msgType), m.message)
+				if err != nil {
+					b.logger.Error(err, "Error writing to WebSocket", "connectionID", connectionID)
+				}
+				sender.finish(m, err)
+			case f := <-flushChan:
+				f.result <- b.flushAndClose(conn, sender, f.deadline, connectionID)
+			case <-pingTicker.C:
+				conn.SetWriteDeadline(time.Now().Add(b.writeTimeout))
+				if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+					b.logger.Error(err, "Error sending ping", "connectionID", connectionID)
+					// Assume the connection is dead; closing it ends the read
+					// pump, and with it the session.
+					conn.Close()
+				}
+			case <-done:
+				return
+			}
 		}
+	}()
+
+	// Step 4: Call OnConnect, providing a thread-safe send function.
+	if h, ok := handler.(SenderHandler); ok {
+		h.OnConnectSender(sender)
+	} else {
+		handler.OnConnect(sender.Send)
 	}
-	handler.OnConnect(sendFunc)
 
 	// Step 4.1: Start the "read pump" goroutine.
+	onBinary := handler.OnMessage
+	if h, ok := handler.(BinaryHandler); ok {
+		onBinary = h.OnBinaryMessage
+	}
+	heartbeat := newHeartbeatState(b.heartbeat, sender)
 	readErrChan := make(chan error, 1)
 	go func() {
 		defer close(readErrChan)
 		for {
-			_, message, err := conn.ReadMessage()
+			messageType, message, err := conn.ReadMessage()
 			if err != nil {
 				// Check if the error is a permanent close code.
 				var closeErr *websocket.CloseError
 				if errors.As(err, &closeErr) {
+					sess.closeCode.Store(int32(closeErr.Code))
 					if permanentCloseCodes[closeErr.Code] {
 						readErrChan <- NewPermanentError(err)
 						return
@@ -253,30 +438,13 @@ func (b *Bridge) manageConnection(ctx context.Context, connectionID string, endp
 				}
 				return
 			}
-			handler.OnMessage(message)
-		}
-	}()
-
-	// Step 4.2: Start the "write pump" and "ping" goroutine for thread-safe writes and health checks.
-	go func() {
-		pingTicker := time.NewTicker(b.pingInterval)
-		defer pingTicker.Stop()
-
-		for {
-			select {
-			case message := <-writeChan:
-				conn.SetWriteDeadline(time.Now().Add(b.writeTimeout))
-				if err := conn.WriteMessage(websocket.TextMessage, message); err != nil {
-					b.logger.Error(err, "Error writing to WebSocket", "connectionID", connectionID)
-				}
-			case <-pingTicker.C:
-				conn.SetWriteDeadline(time.Now().Add(b.writeTimeout))
-				if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
-					b.logger.Error(err, "Error sending ping", "connectionID", connectionID)
-					return // Assume connection is dead if ping fails.
-				}
-			case <-done:
-				return
+			if heartbeat.intercept(message) {
+				continue
+			}
+			if messageType == websocket.BinaryMessage {
+				onBinary(message)
+			} else {
+				handler.OnMessage(message)
 			}
 		}
 	}()
@@ -286,11 +454,16 @@ func (b *Bridge) manageConnection(ctx context.Context, connectionID string, endp
 	refreshErrChan := make(chan error, 1)
 	refreshing := false
 	var timer *time.Timer
+	heartbeatC, stopHeartbeats := heartbeat.ticker()
+	defer stopHeartbeats()
 
 	for {
 		var refreshTimerC <-chan time.Time
 		b.logger.Info("Event loop start", "refreshing", refreshing)
 
+		if timer != nil {
+			timer.Stop()
+		}
 		if !refreshing {
 			expiresIn := time.Until(time.Unix(token.ExpiresAt, 0))
 			refreshIn := expiresIn - b.refreshBuffer
@@ -299,7 +472,7 @@ func (b *Bridge) manageConnection(ctx context.Context, connectionID string, endp
 			if refreshIn <= 0 {
 				b.logger.Info("Token expired or nearing expiry, forcing reconnect", "connectionID", connectionID)
 				err := fmt.Errorf("token refresh required")
-				close(done)
+				stop()
 				b.metrics.IncDisconnects()
 				b.metrics.SetConnectionStatus(0)
 				handler.OnDisconnect(err)
@@ -316,7 +489,7 @@ func (b *Bridge) manageConnection(ctx context.Context, connectionID string, endp
 			if timer != nil {
 				timer.Stop()
 			}
-			close(done)
+			stop()
 			return ctx.Err()
 
 		case err, ok := <-readErrChan:
@@ -327,19 +500,46 @@ func (b *Bridge) manageConnection(ctx context.Context, connectionID string, endp
 			if timer != nil {
 				timer.Stop()
 			}
-			close(done)
+			stop()
 			b.metrics.IncDisconnects()
 			b.metrics.SetConnectionStatus(0)
 			handler.OnDisconnect(err)
 			return err
 
+		case <-heartbeatC:
+			if err := heartbeat.beat(); err != nil {
+				b.logger.Error(err, "Heartbeats unacknowledged; reconnecting", "connectionID", connectionID)
+				stop()
+				b.metrics.IncDisconnects()
+				b.metrics.SetConnectionStatus(0)
+				handler.OnDisconnect(err)
+				return err
+			}
+
+		case req := <-closeReqs:
+			b.logger.Info("Select case: close requested")
+			if timer != nil {
+				timer.Stop()
+			}
+			closeCtx, endSpan := b.tracer.Start(req.ctx, "bridge.websocket.close", "connection.id", connectionID)
+			err := b.closeHandshake(closeCtx, flushChan, readErrChan)
+			endSpan(err)
+			stop()
+			b.metrics.IncDisconnects()
+			b.metrics.SetConnectionStatus(0)
+			handler.OnDisconnect(nil)
+			req.result <- err
+			return errClosedByCaller
+
 		case <-refreshTimerC: // This case is disabled if refreshTimerC is nil
 			b.logger.Info("Select case: refresh timer fired")
 			refreshing = true
 			b.metrics.IncTokenRefreshes()
 			b.logger.Info("Starting background token refresh", "connectionID", connectionID)
 			go func() {
+				ctx, endSpan := b.tracer.Start(ctx, "bridge.token.refresh", "connection.id", connectionID)
 				refreshedToken, refreshErr := b.oauthClient.RefreshConnection(ctx, connectionID)
+				endSpan(refreshErr)
 				if refreshErr != nil {
 					refreshErrChan <- refreshErr
 				} else {
@@ -350,40 +550,147 @@ func (b *Bridge) manageConnection(ctx context.Context, connectionID string, endp
 		case refreshedToken := <-refreshResultChan:
 			b.logger.Info("Select case: refresh result received")
 			refreshing = false
+			if b.redialOnRefresh(token, refreshedToken) {
+				// The credentials were baked into the handshake; the live
+				// connection keeps using the old ones, so reconnect with the new.
+				b.logger.Info("Credentials refreshed; reconnecting to apply them", "connectionID", connectionID)
+				err := errRedial
+				stop()
+				b.metrics.IncDisconnects()
+				b.metrics.SetConnectionStatus(0)
+				handler.OnDisconnect(err)
+				return err
+			}
 			b.logger.Info("Successfully refreshed token in-place", "connectionID", connectionID)
 			token = refreshedToken
 
 		case refreshErr := <-refreshErrChan:
 			b.logger.Info("Select case: refresh error received")
 			refreshing = false
+			if errors.Is(refreshErr, ErrConnectionSuspended) {
+				b.logger.Error(refreshErr, "Connection suspended; closing until it is resumed", "connectionID", connectionID)
+				err := NewPermanentError(refreshErr)
+				stop()
+				b.metrics.IncDisconnects()
+				b.metrics.SetConnectionStatus(0)
+				handler.OnDisconnect(err)
+				return err
+			}
 			b.logger.Error(refreshErr, "Failed to refresh token in-place; will allow connection to drop on expiry", "connectionID", connectionID)
 		}
 	}
 }
 
-// growBackoff doubles the current backoff, capping at MaxBackoff.
-func (b *Bridge) growBackoff(current time.Duration) time.Duration {
-	next := current * 2
-	if next > b.retryPolicy.MaxBackoff || next <= 0 {
-		return b.retryPolicy.MaxBackoff
+// closeHandshake has the write pump flush and send a close frame, then
+// waits for the read pump to see the peer close its side.
+func (b *Bridge) closeHandshake(ctx context.Context, flushChan chan<- flushRequest, readErrChan <-chan error) error {
+	ctx, cancel := context.WithTimeout(ctx, b.closeTimeout)
+	defer cancel()
+	deadline, _ := ctx.Deadline()
+	result := make(chan error, 1)
+	select {
+	case flushChan <- flushRequest{deadline: deadline, result: result}:
+	case <-ctx.Done():
+		return fmt.Errorf("close frame not sent: %w", ctx.Err())
+	}
+	select {
+	case err := <-result:
+		if err != nil {
+			return fmt.Errorf("failed to send close frame: %w", err)
+		}
+	case <-ctx.Done():
+		return fmt.Errorf("close frame not sent: %w", ctx.Err())
+	}
+	select {
+	case <-readErrChan:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("peer did not acknowledge close: %w", ctx.Err())
 	}
-	return next
 }
 
-// applyJitter adds random jitter to a duration to prevent thundering herd
-// when multiple agents reconnect simultaneously after a gateway restart.
-func (b *Bridge) applyJitter(d time.Duration) time.Duration {
-	if b.retryPolicy.Jitter <= 0 {
-		return d
+// flushAndClose writes the messages queued on sender, which accepts no
+// more, then a normal-closure frame. It runs on the write pump, so it keeps
+// draining the queue while senders still in flight give up, and gives up
+// itself at deadline; messages left queued then fail when the pump stops.
+func (b *Bridge) flushAndClose(conn *websocket.Conn, sender *Sender, deadline time.Time, connectionID string) error {
+	idle := sender.seal()
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+	write := func(m outbound) {
+		conn.SetWriteDeadline(deadline)
+		err := conn.WriteMessage(int(m.msgType), m.message)
+		if err != nil {
+			b.logger.Error(err, "Error writing to WebSocket", "connectionID", connectionID)
+		}
+		sender.finish(m, err)
+	}
+	for idle != nil {
+		select {
+		case m := <-sender.queue:
+			write(m)
+		case <-idle:
+			idle = nil
+		case <-timer.C:
+			return fmt.Errorf("queued messages not flushed: %w", context.DeadlineExceeded)
+		}
+	}
+	for {
+		select {
+		case m := <-sender.queue:
+			write(m)
+		default:
+			conn.SetWriteDeadline(deadline)
+			return conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+		}
 	}
-	return d + time.Duration(rand.Int63n(int64(b.retryPolicy.Jitter)))
 }
 
-// calculateBackoff returns a flat backoff with jitter (used by MaintainWebSocket).
-func (b *Bridge) calculateBackoff() time.Duration {
-	backoff := b.retryPolicy.MinBackoff + time.Duration(rand.Int63n(int64(b.retryPolicy.Jitter)))
-	if backoff > b.retryPolicy.MaxBackoff {
-		return b.retryPolicy.MaxBackoff
+// redialOnRefresh reports whether the WebSocket must reconnect to present
+// the refreshed token next, per the Bridge's RefreshPolicy.
+func (b *Bridge) redialOnRefresh(prev, next *auth.Token) bool {
+	switch b.onRefresh {
+	case RefreshKeepAlive:
+		return false
+	case RefreshReconnect:
+		return auth.CredentialsChanged(prev, next)
+	default:
+		return auth.CredentialsRotated(prev, next)
+	}
+}
+
+// wsDialer returns the WebSocket dialer with the Bridge's proxy, net dialer
+// and TLS configuration applied.
+func (b *Bridge) wsDialer() *websocket.Dialer {
+	if !b.customDial() && b.tlsConfig == nil {
+		return b.dialer
+	}
+	d := *b.dialer
+	if b.customDial() {
+		d.Proxy = nil
+		d.NetDialContext = b.dial
+	}
+	if b.tlsConfig != nil {
+		d.TLSClientConfig = b.tlsConfig
+	}
+	return &d
+}
+
+// session is one WebSocket connection made by manageConnection.
+type session struct {
+	connectedAt time.Time
+	// closeCode is the code of the peer's close frame, set by the read pump.
+	closeCode atomic.Int32
+}
+
+// code returns the WebSocket close code that ended the session with err,
+// or 0 if no close frame was exchanged.
+func (s *session) code(err error) int {
+	if code := s.closeCode.Load(); code != 0 {
+		return int(code)
+	}
+	if errors.Is(err, errClosedByCaller) {
+		return websocket.CloseNormalClosure
 	}
-	return backoff
+	return 0
 }
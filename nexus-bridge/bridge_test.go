@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"sync"
@@ -15,6 +16,9 @@ import (
 	"github.com/gorilla/websocket"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	oauthsdk "github.com/Prescott-Data/nexus-framework/nexus-sdk"
 )
 
 // --- Mocks ---
@@ -447,6 +451,159 @@ func TestBridge_TokenRefreshWithoutDisconnect(t *testing.T) {
 	}
 }
 
+func TestBridge_QueryParamKeyRotationReconnects(t *testing.T) {
+	t.Parallel()
+
+	var current atomic.Value
+	current.Store("key-1")
+	token := func() *auth.Token {
+		return &auth.Token{
+			Strategy:    auth.AuthStrategy{Type: "query_param", Config: map[string]interface{}{"param_name": "api_key"}},
+			Credentials: auth.Credentials{"api_key": current.Load().(string)},
+			ExpiresAt:   time.Now().Add(3 * time.Second).Unix(),
+		}
+	}
+	authClient := &mockTokenProvider{
+		getTokenFunc: func(ctx context.Context, connectionID string) (*auth.Token, error) { return token(), nil },
+		refreshConnectionFunc: func(ctx context.Context, connectionID string) (*auth.Token, error) {
+			current.Store("key-2") // Key rotated mid-session.
+			return token(), nil
+		},
+	}
+
+	handshakes := make(chan []string, 4)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handshakes <- r.URL.Query()["api_key"]
+		conn, _ := upgrader.Upgrade(w, r, nil)
+		defer conn.Close()
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	retryPolicy := RetryPolicy{MinBackoff: 10 * time.Millisecond, MaxBackoff: 20 * time.Millisecond, Jitter: 5 * time.Millisecond}
+	bridge := New(authClient, WithRetryPolicy(retryPolicy), WithRefreshBuffer(1*time.Second), WithLogger(&testLogger{t: t}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 8*time.Second)
+	defer cancel()
+	// The endpoint carries a stale key that must be replaced, never duplicated.
+	go bridge.MaintainWebSocket(ctx, "conn-123", "ws"+server.URL[4:]+"?api_key=stale", &mockHandler{})
+
+	for i, want := range []string{"key-1", "key-2"} {
+		select {
+		case got := <-handshakes:
+			if len(got) != 1 || got[0] != want {
+				t.Fatalf("handshake %d: expected api_key=[%s], got %v", i+1, want, got)
+			}
+		case <-time.After(4 * time.Second):
+			t.Fatalf("timed out waiting for handshake %d", i+1)
+		}
+	}
+}
+
+func TestBridge_OnRefreshPolicy(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name     string
+		strategy string
+		policy   RefreshPolicy
+		redial   bool
+	}{
+		{"auto keeps oauth2", "oauth2", RefreshAuto, false},
+		{"reconnect re-presents oauth2", "oauth2", RefreshReconnect, true},
+		{"keep-alive ignores rotation", "header", RefreshKeepAlive, false},
+		{"auto reconnects on rotation", "header", RefreshAuto, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			var refreshes atomic.Int32
+			token := func() *auth.Token {
+				cred := fmt.Sprintf("cred-%d", refreshes.Load())
+				return &auth.Token{
+					Strategy:    auth.AuthStrategy{Type: tt.strategy, Config: map[string]interface{}{"header_name": "Authorization"}},
+					Credentials: auth.Credentials{"access_token": cred, "api_key": cred},
+					ExpiresAt:   time.Now().Add(3 * time.Second).Unix(),
+				}
+			}
+			refreshed := make(chan struct{}, 4)
+			authClient := &mockTokenProvider{
+				getTokenFunc: func(ctx context.Context, connectionID string) (*auth.Token, error) { return token(), nil },
+				refreshConnectionFunc: func(ctx context.Context, connectionID string) (*auth.Token, error) {
+					refreshes.Add(1)
+					refreshed <- struct{}{}
+					return token(), nil
+				},
+			}
+			handshakes := make(chan string, 4)
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				handshakes <- r.Header.Get("Authorization")
+				conn, _ := upgrader.Upgrade(w, r, nil)
+				defer conn.Close()
+				<-r.Context().Done()
+			}))
+			defer server.Close()
+
+			b := New(authClient, WithOnRefresh(tt.policy), WithRefreshBuffer(time.Second), WithLogger(&testLogger{t: t}))
+			ctx, cancel := context.WithTimeout(context.Background(), 8*time.Second)
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				b.MaintainWebSocket(ctx, "conn-1", "ws"+server.URL[4:], &mockHandler{})
+			}()
+			defer func() { cancel(); <-done }()
+
+			first := <-handshakes
+			<-refreshed
+			select {
+			case second := <-handshakes:
+				if !tt.redial {
+					t.Fatalf("re-dialed after refresh (%q then %q)", first, second)
+				}
+				if second == first {
+					t.Errorf("re-dial presented the old credentials %q", second)
+				}
+			case <-time.After(500 * time.Millisecond):
+				if tt.redial {
+					t.Fatal("no re-dial after refresh")
+				}
+			}
+		})
+	}
+}
+
+func TestBridgeCredentials_StaticKeyRotation(t *testing.T) {
+	var calls int32
+	authClient := &mockTokenProvider{
+		getTokenFunc: func(ctx context.Context, connectionID string) (*auth.Token, error) {
+			n := atomic.AddInt32(&calls, 1)
+			return &auth.Token{
+				Strategy:    auth.AuthStrategy{Type: "header", Config: map[string]interface{}{"header_name": "X-API-Key"}},
+				Credentials: auth.Credentials{"api_key": fmt.Sprintf("key-%d", n)},
+			}, nil
+		},
+	}
+
+	creds := NewBridgeCredentials(authClient, "conn-123", 20*time.Millisecond, &testLogger{t: t})
+
+	md, err := creds.GetRequestMetadata(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if md["x-api-key"] != "key-1" {
+		t.Fatalf("expected key-1, got %q", md["x-api-key"])
+	}
+
+	time.Sleep(40 * time.Millisecond)
+
+	md, err = creds.GetRequestMetadata(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if md["x-api-key"] != "key-2" {
+		t.Errorf("expected rotated key-2 after refresh buffer, got %q", md["x-api-key"])
+	}
+}
+
 // --- gRPC retry loop tests ---
 
 func grpcRetryPolicy() RetryPolicy {
@@ -679,3 +836,86 @@ func TestGRPC_BackoffGrowsExponentially(t *testing.T) {
 		}
 	}
 }
+
+// suspendedErr is what a TokenProvider wrapping the SDK returns for a
+// suspended connection.
+var suspendedErr = fmt.Errorf("get token: %w", &oauthsdk.APIError{StatusCode: 423, Code: "connection_suspended"})
+
+func TestBridge_SuspendedOnRefreshIsPermanent(t *testing.T) {
+	t.Parallel()
+
+	disconnectChan := make(chan error, 1)
+	authClient := &mockTokenProvider{
+		getTokenFunc: func(ctx context.Context, connectionID string) (*auth.Token, error) {
+			return &auth.Token{
+				Strategy:    auth.AuthStrategy{Type: "oauth2"},
+				Credentials: auth.Credentials{"access_token": "initial-token"},
+				ExpiresAt:   time.Now().Add(2 * time.Second).Unix(),
+			}, nil
+		},
+		refreshConnectionFunc: func(ctx context.Context, connectionID string) (*auth.Token, error) {
+			return nil, suspendedErr
+		},
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, _ := upgrader.Upgrade(w, r, nil)
+		defer conn.Close()
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	handler := &mockHandler{onDisconnect: func(err error) { disconnectChan <- err }}
+	bridge := New(authClient, WithMetrics(&mockMetrics{}), WithRefreshBuffer(100*time.Millisecond), WithLogger(&testLogger{t: t}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := bridge.MaintainWebSocket(ctx, "conn-123", "ws"+server.URL[4:], handler)
+
+	var permErr *PermanentError
+	if !errors.As(err, &permErr) || !errors.Is(err, ErrConnectionSuspended) {
+		t.Fatalf("expected permanent ErrConnectionSuspended, got: %v", err)
+	}
+	select {
+	case <-disconnectChan:
+	default:
+		t.Error("OnDisconnect was not called")
+	}
+}
+
+func TestGRPC_SuspendedStopsRetry(t *testing.T) {
+	t.Parallel()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := grpc.NewServer()
+	go srv.Serve(lis)
+	defer srv.Stop()
+
+	authClient := &mockTokenProvider{
+		getTokenFunc: func(ctx context.Context, connectionID string) (*auth.Token, error) {
+			return nil, suspendedErr
+		},
+	}
+	metrics := &mockMetrics{}
+	b := New(authClient, WithMetrics(metrics), WithRetryPolicy(grpcRetryPolicy()), WithLogger(&testLogger{t: t}))
+
+	// gRPC only reports a status for the failed credentials.
+	run := func(ctx context.Context, conn *grpc.ClientConn) error {
+		return conn.Invoke(ctx, "/test.Service/Call", &emptypb.Empty{}, &emptypb.Empty{})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err = b.MaintainGRPCConnection(ctx, "conn-1", lis.Addr().String(),
+		run, grpc.WithTransportCredentials(insecure.NewCredentials()))
+
+	var permErr *PermanentError
+	if !errors.As(err, &permErr) || !errors.Is(err, ErrConnectionSuspended) {
+		t.Fatalf("expected permanent ErrConnectionSuspended, got: %v", err)
+	}
+	if atomic.LoadInt32(&metrics.connections) != 1 {
+		t.Errorf("expected exactly 1 connection (no retry), got %d", metrics.connections)
+	}
+}
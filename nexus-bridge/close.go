@@ -0,0 +1,100 @@
+package bridge
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrNotMaintained is returned by Bridge.Close for a connection ID the
+// bridge is not maintaining a WebSocket for.
+var ErrNotMaintained = errors.New("bridge: connection is not being maintained")
+
+// errClosedByCaller ends a session that Bridge.Close shut down.
+var errClosedByCaller = errors.New("connection closed by caller")
+
+// closeRequest asks a MaintainWebSocket loop to close gracefully.
+type closeRequest struct {
+	ctx    context.Context
+	result chan error
+}
+
+// flushRequest asks the write pump to write the queued messages and then a
+// close frame, by deadline.
+type flushRequest struct {
+	deadline time.Time
+	result   chan error
+}
+
+// closers routes Close calls to the MaintainWebSocket loops of a Bridge and
+// of its copies in a Pool.
+type closers struct {
+	mu    sync.Mutex
+	loops map[string]*closer
+}
+
+type closer struct {
+	requests chan closeRequest
+	stopped  chan struct{}
+}
+
+func newClosers() *closers {
+	return &closers{loops: map[string]*closer{}}
+}
+
+func (c *closers) register(connectionID string) *closer {
+	cl := &closer{requests: make(chan closeRequest), stopped: make(chan struct{})}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.loops[connectionID] = cl
+	return cl
+}
+
+func (c *closers) unregister(connectionID string, cl *closer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.loops[connectionID] == cl {
+		delete(c.loops, connectionID)
+	}
+	close(cl.stopped)
+}
+
+func (c *closers) lookup(connectionID string) *closer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.loops[connectionID]
+}
+
+// Close gracefully closes the WebSocket that MaintainWebSocket maintains
+// for connectionID and stops maintaining it; MaintainWebSocket then returns
+// nil. Queued messages are written first, then a normal-closure frame, and
+// Close waits for the peer to close its side before the Handler's
+// OnDisconnect is called with nil. The wait is bounded by ctx and by
+// WithCloseTimeout; if it runs out, or the close frame cannot be written,
+// the connection is still closed and the error returned.
+//
+// A connection waiting to reconnect just stops. Close returns
+// ErrNotMaintained if the bridge is not maintaining connectionID.
+func (b *Bridge) Close(ctx context.Context, connectionID string) error {
+	cl := b.closers.lookup(connectionID)
+	if cl == nil {
+		return ErrNotMaintained
+	}
+	req := closeRequest{ctx: ctx, result: make(chan error, 1)}
+	select {
+	case cl.requests <- req:
+		select {
+		case err := <-req.result:
+			return err
+		case <-ctx.Done():
+			// The loop goes on closing the connection with the same
+			// expired ctx, so it does not outlive the caller for long.
+			return ctx.Err()
+		}
+	case <-cl.stopped:
+		return ErrNotMaintained
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,207 @@
+package bridge
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestBridge_CloseFlushesAndHandshakes(t *testing.T) {
+	t.Parallel()
+	received := make(chan string, 10)
+	closeCode := make(chan int, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			_, msg, err := conn.ReadMessage()
+			var ce *websocket.CloseError
+			if errors.As(err, &ce) {
+				closeCode <- ce.Code // the default close handler acknowledges it
+				return
+			}
+			if err != nil {
+				return
+			}
+			received <- string(msg)
+		}
+	}))
+	defer server.Close()
+
+	senders := make(chan *Sender, 1)
+	disconnects := make(chan error, 1)
+	handler := &senderHandler{onConnectSender: func(s *Sender) { senders <- s }}
+	handler.onDisconnect = func(err error) { disconnects <- err }
+	b := New(validTokenProvider(), WithSendQueueDepth(4), WithLogger(&testLogger{t: t}))
+	status := b.Status("conn-1")
+	done := make(chan error, 1)
+	go func() { done <- b.MaintainWebSocket(t.Context(), "conn-1", "ws"+server.URL[4:], handler) }()
+
+	s := <-senders
+	for _, m := range []string{"a", "b", "c"} {
+		if err := s.Send([]byte(m)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := b.Close(t.Context(), "conn-1"); err != nil {
+		t.Fatalf("Close = %v, want nil after the peer acknowledged", err)
+	}
+
+	for _, want := range []string{"a", "b", "c"} {
+		if got := <-received; got != want {
+			t.Errorf("received %q, want %q", got, want)
+		}
+	}
+	if code := <-closeCode; code != websocket.CloseNormalClosure {
+		t.Errorf("close code = %d, want %d", code, websocket.CloseNormalClosure)
+	}
+	if err := <-disconnects; err != nil {
+		t.Errorf("OnDisconnect(%v), want nil", err)
+	}
+	if err := <-done; err != nil {
+		t.Errorf("MaintainWebSocket = %v, want nil after Close", err)
+	}
+	var last ConnectionState
+	for st := range status {
+		last = st
+	}
+	if last.Phase != PhaseClosed || last.Err != nil {
+		t.Errorf("last state = %+v, want closed without error", last)
+	}
+	if err := s.Send([]byte("late")); !errors.Is(err, ErrConnectionClosed) {
+		t.Errorf("Send after Close = %v, want ErrConnectionClosed", err)
+	}
+	if err := b.Close(t.Context(), "conn-1"); !errors.Is(err, ErrNotMaintained) {
+		t.Errorf("second Close = %v, want ErrNotMaintained", err)
+	}
+}
+
+func TestBridge_CloseTimesOutWithoutAck(t *testing.T) {
+	t.Parallel()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		<-r.Context().Done() // never reads, so never acknowledges
+	}))
+	defer server.Close()
+
+	connected := make(chan struct{}, 1)
+	handler := &mockHandler{onConnect: func(func([]byte) error) { connected <- struct{}{} }}
+	b := New(validTokenProvider(), WithCloseTimeout(100*time.Millisecond), WithLogger(&testLogger{t: t}))
+	done := make(chan error, 1)
+	go func() { done <- b.MaintainWebSocket(t.Context(), "conn-1", "ws"+server.URL[4:], handler) }()
+	<-connected
+
+	if err := b.Close(t.Context(), "conn-1"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Close = %v, want context.DeadlineExceeded", err)
+	}
+	if err := <-done; err != nil {
+		t.Errorf("MaintainWebSocket = %v, want nil after Close", err)
+	}
+}
+
+func TestBridge_CloseDuringBackoff(t *testing.T) {
+	t.Parallel()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	b := New(validTokenProvider(), WithRetryPolicy(RetryPolicy{MinBackoff: time.Minute, MaxBackoff: time.Minute}),
+		WithLogger(&testLogger{t: t}))
+	status := b.Status("conn-1")
+	done := make(chan error, 1)
+	go func() { done <- b.MaintainWebSocket(t.Context(), "conn-1", "ws"+server.URL[4:], &mockHandler{}) }()
+	for st := range status {
+		if st.Phase == PhaseReconnecting {
+			break
+		}
+	}
+
+	if err := b.Close(t.Context(), "conn-1"); err != nil {
+		t.Errorf("Close = %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Errorf("MaintainWebSocket = %v, want nil after Close", err)
+	}
+	if err := b.Close(t.Context(), "unknown"); !errors.Is(err, ErrNotMaintained) {
+		t.Errorf("Close(unknown) = %v, want ErrNotMaintained", err)
+	}
+}
+
+func TestBridge_CloseWithBlockedSenders(t *testing.T) {
+	t.Parallel()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	defer server.Close()
+
+	senders := make(chan *Sender, 1)
+	handler := &senderHandler{onConnectSender: func(s *Sender) { senders <- s }}
+	b := New(validTokenProvider(), WithSendQueueDepth(1), WithLogger(&testLogger{t: t}))
+	done := make(chan error, 1)
+	go func() { done <- b.MaintainWebSocket(t.Context(), "conn-1", "ws"+server.URL[4:], handler) }()
+	s := <-senders
+
+	// Enough senders that the queue is full and most of them are blocked
+	// in Send when Close seals it.
+	const n = 16
+	stopped := make(chan error, n)
+	for i := 0; i < n; i++ {
+		go func() {
+			for {
+				if err := s.Send([]byte("m")); err != nil {
+					stopped <- err
+					return
+				}
+			}
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(t.Context(), 2*time.Second)
+	defer cancel()
+	closed := make(chan error, 1)
+	go func() { closed <- b.Close(ctx, "conn-1") }()
+	select {
+	case err := <-closed:
+		if err != nil && !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Close = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close did not return within its ctx")
+	}
+	for i := 0; i < n; i++ {
+		select {
+		case err := <-stopped:
+			if !errors.Is(err, ErrConnectionClosed) {
+				t.Errorf("Send = %v, want ErrConnectionClosed", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%d senders still blocked after Close", n-i)
+		}
+	}
+	if err := <-done; err != nil {
+		t.Errorf("MaintainWebSocket = %v, want nil after Close", err)
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"fmt"
 
 	"github.com/gorilla/websocket"
+
+	oauthsdk "github.com/Prescott-Data/nexus-framework/nexus-sdk"
 )
 
 // ErrInteractionRequired is returned when the gateway responds with 409
@@ -12,6 +14,16 @@ import (
 // must stop retrying — reconnecting will never succeed without user action.
 var ErrInteractionRequired = errors.New("interaction required: user must re-authenticate")
 
+// ErrConnectionSuspended matches the gateway's 423 connection_suspended: an
+// operator suspended the connection and tokens are withheld until it is
+// resumed. It is the SDK's sentinel, so a TokenProvider that wraps SDK
+// errors with %w needs no translation. The bridge treats it as permanent.
+var ErrConnectionSuspended = oauthsdk.ErrConnectionSuspended
+
+// errRedial ends a session that must reconnect to present refreshed
+// credentials; MaintainWebSocket dials again without backoff.
+var errRedial = errors.New("credentials refreshed; reconnecting")
+
 // permanentCloseCodes contains WebSocket close codes that should not be retried.
 var permanentCloseCodes = map[int]bool{
 	websocket.CloseNormalClosure:           true,
@@ -2,6 +2,7 @@ package bridge
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"sync"
 	"time"
@@ -9,16 +10,44 @@ import (
 	"github.com/Prescott-Data/nexus-framework/nexus-bridge/pkg/auth"
 )
 
+// credentialFetchTimeout bounds a token fetch shared by several RPCs, which
+// cannot use any one caller's context.
+const credentialFetchTimeout = 30 * time.Second
+
+// CredentialMetrics is implemented by Metrics collectors that also time
+// token fetches for gRPC credentials. When the Bridge's Metrics implement
+// it, every fetch from the TokenProvider is observed with its outcome.
+type CredentialMetrics interface {
+	ObserveCredentialFetch(d time.Duration, err error)
+}
+
 // BridgeCredentials implements credentials.PerRPCCredentials to automatically
 // inject authentication metadata into gRPC calls managed by the Bridge.
+//
+// Tokens are cached. Within refreshBuffer of its expiry a token is still
+// served while a background fetch replaces it, so RPCs only wait for a
+// token when there is none or it has expired. Concurrent RPCs share a
+// single fetch.
 type BridgeCredentials struct {
 	oauthClient   auth.TokenProvider
 	connectionID  string
 	refreshBuffer time.Duration
 	logger        Logger
+	metrics       CredentialMetrics
+	tracer        Tracer
+
+	mu           sync.RWMutex
+	cachedToken  *auth.Token
+	fetchedAt    time.Time
+	suspendedErr error
+	inflight     *tokenFetch
+}
 
-	mu          sync.RWMutex
-	cachedToken *auth.Token
+// tokenFetch is a TokenProvider call that RPCs wait on together.
+type tokenFetch struct {
+	done  chan struct{}
+	token *auth.Token
+	err   error
 }
 
 // NewBridgeCredentials creates a new PerRPCCredentials handler.
@@ -28,6 +57,7 @@ func NewBridgeCredentials(client auth.TokenProvider, connectionID string, refres
 		connectionID:  connectionID,
 		refreshBuffer: refreshBuffer,
 		logger:        logger,
+		tracer:        nopTracer{},
 	}
 }
 
@@ -47,33 +77,83 @@ func (c *BridgeCredentials) GetRequestMetadata(ctx context.Context, uri ...strin
 }
 
 func (c *BridgeCredentials) getValidToken(ctx context.Context) (*auth.Token, error) {
-	c.mu.RLock()
-	token := c.cachedToken
-	c.mu.RUnlock()
+	c.mu.Lock()
+	token, fetchedAt := c.cachedToken, c.fetchedAt
+	switch {
+	case token != nil && !c.isExpired(token, fetchedAt):
+		c.mu.Unlock()
+		return token, nil
+	case token != nil && token.ExpiresAt != 0 && time.Now().Before(time.Unix(token.ExpiresAt, 0)):
+		// Refresh ahead: the token is still valid, so serve it while the
+		// next one is fetched.
+		c.startFetchLocked(ctx)
+		c.mu.Unlock()
+		return token, nil
+	}
+	f := c.startFetchLocked(ctx)
+	c.mu.Unlock()
 
-	if token == nil || c.isExpired(token) {
-		c.mu.Lock()
-		defer c.mu.Unlock()
+	select {
+	case <-f.done:
+		return f.token, f.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
 
-		if c.cachedToken != nil && !c.isExpired(c.cachedToken) {
-			return c.cachedToken, nil
+// startFetchLocked returns the fetch in flight, starting one if there is
+// none. c.mu must be held.
+func (c *BridgeCredentials) startFetchLocked(ctx context.Context) *tokenFetch {
+	if c.inflight != nil {
+		return c.inflight
+	}
+	f := &tokenFetch{done: make(chan struct{})}
+	c.inflight = f
+	go func() {
+		fetchCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), credentialFetchTimeout)
+		defer cancel()
+		c.logger.Info("Refreshing token for gRPC call", "connectionID", c.connectionID)
+		fetchCtx, endSpan := c.tracer.Start(fetchCtx, "bridge.token.fetch", "connection.id", c.connectionID)
+		started := time.Now()
+		token, err := c.oauthClient.GetToken(fetchCtx, c.connectionID)
+		endSpan(err)
+		if c.metrics != nil {
+			c.metrics.ObserveCredentialFetch(time.Since(started), err)
 		}
 
-		c.logger.Info("Refreshing token for gRPC call", "connectionID", c.connectionID)
-		newToken, err := c.oauthClient.GetToken(ctx, c.connectionID)
+		c.mu.Lock()
 		if err != nil {
-			return nil, err
+			c.logger.Error(err, "Failed to fetch token for gRPC call", "connectionID", c.connectionID)
+			if errors.Is(err, ErrConnectionSuspended) {
+				c.suspendedErr = err
+			}
+		} else {
+			c.suspendedErr = nil
+			c.cachedToken = token
+			c.fetchedAt = time.Now()
 		}
-		c.cachedToken = newToken
-		return newToken, nil
-	}
+		c.inflight = nil
+		c.mu.Unlock()
+
+		f.token, f.err = token, err
+		close(f.done)
+	}()
+	return f
+}
 
-	return token, nil
+// suspended returns the error of the last token fetch if it found the
+// connection suspended, else nil.
+func (c *BridgeCredentials) suspended() error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.suspendedErr
 }
 
-func (c *BridgeCredentials) isExpired(t *auth.Token) bool {
+// isExpired reports whether t should be re-fetched. Static credentials carry
+// no expiry, so they are re-fetched every refreshBuffer to pick up rotations.
+func (c *BridgeCredentials) isExpired(t *auth.Token, fetchedAt time.Time) bool {
 	if t.ExpiresAt == 0 {
-		return false
+		return c.refreshBuffer > 0 && time.Since(fetchedAt) > c.refreshBuffer
 	}
 	return time.Now().After(time.Unix(t.ExpiresAt, 0).Add(-c.refreshBuffer))
 }
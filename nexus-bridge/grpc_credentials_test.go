@@ -0,0 +1,172 @@
+package bridge
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-bridge/pkg/auth"
+)
+
+// credentialMetrics records credential fetches.
+type credentialMetrics struct {
+	mu     sync.Mutex
+	ok     int
+	failed int
+}
+
+func (m *credentialMetrics) ObserveCredentialFetch(d time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err != nil {
+		m.failed++
+	} else {
+		m.ok++
+	}
+}
+
+func bearerToken(access string, expiresIn time.Duration) *auth.Token {
+	return &auth.Token{
+		Strategy:    auth.AuthStrategy{Type: "oauth2"},
+		Credentials: auth.Credentials{"access_token": access},
+		ExpiresAt:   time.Now().Add(expiresIn).Unix(),
+	}
+}
+
+func TestBridgeCredentials_ConcurrentRPCsShareOneFetch(t *testing.T) {
+	t.Parallel()
+	var calls int32
+	release := make(chan struct{})
+	authClient := &mockTokenProvider{
+		getTokenFunc: func(ctx context.Context, connectionID string) (*auth.Token, error) {
+			atomic.AddInt32(&calls, 1)
+			<-release
+			return bearerToken("tok", time.Hour), nil
+		},
+	}
+	creds := NewBridgeCredentials(authClient, "conn-1", time.Minute, &testLogger{t: t})
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := creds.GetRequestMetadata(context.Background())
+			errs <- err
+		}()
+	}
+	waitFor(t, "a fetch", func() bool { return atomic.LoadInt32(&calls) == 1 })
+	close(release)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("GetToken called %d times, want 1", n)
+	}
+}
+
+func TestBridgeCredentials_RefreshAhead(t *testing.T) {
+	t.Parallel()
+	var calls int32
+	second := make(chan struct{})
+	authClient := &mockTokenProvider{
+		getTokenFunc: func(ctx context.Context, connectionID string) (*auth.Token, error) {
+			if atomic.AddInt32(&calls, 1) == 1 {
+				return bearerToken("old", 30*time.Second), nil
+			}
+			<-second
+			return bearerToken("new", time.Hour), nil
+		},
+	}
+	// The first token is inside the refresh buffer as soon as it arrives.
+	creds := NewBridgeCredentials(authClient, "conn-1", time.Minute, &testLogger{t: t})
+	metrics := &credentialMetrics{}
+	creds.metrics = metrics
+
+	if md, err := creds.GetRequestMetadata(context.Background()); err != nil || md["authorization"] != "Bearer old" {
+		t.Fatalf("first call = %v, %v", md, err)
+	}
+	// Still valid: served at once while the replacement is fetched.
+	if md, err := creds.GetRequestMetadata(context.Background()); err != nil || md["authorization"] != "Bearer old" {
+		t.Fatalf("second call = %v, %v", md, err)
+	}
+	waitFor(t, "the background fetch", func() bool { return atomic.LoadInt32(&calls) == 2 })
+	close(second)
+	waitFor(t, "the new token", func() bool {
+		md, err := creds.GetRequestMetadata(context.Background())
+		return err == nil && md["authorization"] == "Bearer new"
+	})
+	if n := atomic.LoadInt32(&calls); n != 2 {
+		t.Errorf("GetToken called %d times, want 2", n)
+	}
+	metrics.mu.Lock()
+	defer metrics.mu.Unlock()
+	if metrics.ok != 2 || metrics.failed != 0 {
+		t.Errorf("fetches observed: ok=%d failed=%d", metrics.ok, metrics.failed)
+	}
+}
+
+func TestBridgeCredentials_FailedFetchIsObservedAndRetried(t *testing.T) {
+	t.Parallel()
+	var calls int32
+	authClient := &mockTokenProvider{
+		getTokenFunc: func(ctx context.Context, connectionID string) (*auth.Token, error) {
+			if atomic.AddInt32(&calls, 1) == 1 {
+				return nil, errors.New("gateway unavailable")
+			}
+			return bearerToken("tok", time.Hour), nil
+		},
+	}
+	creds := NewBridgeCredentials(authClient, "conn-1", time.Minute, &testLogger{t: t})
+	metrics := &credentialMetrics{}
+	creds.metrics = metrics
+
+	if _, err := creds.GetRequestMetadata(context.Background()); err == nil {
+		t.Fatal("expected the first fetch to fail")
+	}
+	if _, err := creds.GetRequestMetadata(context.Background()); err != nil {
+		t.Fatalf("second call = %v, want a fresh fetch", err)
+	}
+	metrics.mu.Lock()
+	defer metrics.mu.Unlock()
+	if metrics.ok != 1 || metrics.failed != 1 {
+		t.Errorf("fetches observed: ok=%d failed=%d", metrics.ok, metrics.failed)
+	}
+}
+
+func TestBridgeCredentials_CallerCancelDoesNotAbortSharedFetch(t *testing.T) {
+	t.Parallel()
+	release := make(chan struct{})
+	var fetchErr atomic.Value
+	authClient := &mockTokenProvider{
+		getTokenFunc: func(ctx context.Context, connectionID string) (*auth.Token, error) {
+			<-release
+			if err := ctx.Err(); err != nil {
+				fetchErr.Store(err)
+			}
+			return bearerToken("tok", time.Hour), nil
+		},
+	}
+	creds := NewBridgeCredentials(authClient, "conn-1", time.Minute, &testLogger{t: t})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := creds.GetRequestMetadata(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("cancelled caller = %v, want context.Canceled", err)
+	}
+	close(release)
+	if _, err := creds.GetRequestMetadata(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := fetchErr.Load(); err != nil {
+		t.Errorf("shared fetch saw the caller's cancellation: %v", err)
+	}
+}
@@ -8,9 +8,18 @@ type Handler interface {
 	OnConnect(send func(message []byte) error)
 
 	// OnMessage is called when a new message is received from the connection.
+	// Binary messages arrive here too unless the Handler implements
+	// BinaryHandler.
 	OnMessage(message []byte)
 
 	// OnDisconnect is called when the connection is lost. The bridge will
 	// automatically attempt to reconnect.
 	OnDisconnect(err error)
 }
+
+// BinaryHandler is an optional interface for a Handler that tells text and
+// binary frames apart. When a Handler implements it, binary messages go to
+// OnBinaryMessage and only text messages to OnMessage.
+type BinaryHandler interface {
+	OnBinaryMessage(message []byte)
+}
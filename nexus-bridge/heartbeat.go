@@ -0,0 +1,97 @@
+package bridge
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// ErrHeartbeatTimeout ends a WebSocket session whose heartbeats went
+// unacknowledged for Heartbeat.Timeout. The bridge reconnects.
+var ErrHeartbeatTimeout = errors.New("bridge: heartbeat not acknowledged")
+
+// Heartbeat configures application-level keepalives on WebSocket
+// connections, for providers that expect messages such as
+// {"type":"ping"} in addition to WebSocket ping frames.
+type Heartbeat struct {
+	// Interval between heartbeats. Zero disables sending them.
+	Interval time.Duration
+	// Payload returns the next heartbeat message.
+	Payload func() []byte
+	// MessageType of heartbeats and replies; zero means TextMessage.
+	MessageType MessageType
+	// IsAck reports whether an incoming message acknowledges a heartbeat.
+	// Acknowledgements are not passed to the Handler. If IsAck is nil, no
+	// acknowledgements are expected.
+	IsAck func(message []byte) bool
+	// Timeout is how long a connection may go without an acknowledgement
+	// before it is considered dead. Zero means twice the Interval.
+	Timeout time.Duration
+	// Reply answers the provider's own application-level pings: when it
+	// returns ok, reply is sent back and the message is not passed to the
+	// Handler.
+	Reply func(message []byte) (reply []byte, ok bool)
+}
+
+func (h Heartbeat) messageType() MessageType {
+	if h.MessageType == 0 {
+		return TextMessage
+	}
+	return h.MessageType
+}
+
+func (h Heartbeat) timeout() time.Duration {
+	if h.Timeout > 0 {
+		return h.Timeout
+	}
+	return 2 * h.Interval
+}
+
+// heartbeatState tracks one session's heartbeats.
+type heartbeatState struct {
+	cfg     Heartbeat
+	sender  *Sender
+	lastAck atomic.Int64 // unix nanoseconds
+}
+
+func newHeartbeatState(cfg Heartbeat, sender *Sender) *heartbeatState {
+	h := &heartbeatState{cfg: cfg, sender: sender}
+	h.lastAck.Store(time.Now().UnixNano())
+	return h
+}
+
+// ticker returns the channel heartbeats are due on, or nil if they are off,
+// and a function to stop it.
+func (h *heartbeatState) ticker() (<-chan time.Time, func()) {
+	if h.cfg.Interval <= 0 || h.cfg.Payload == nil {
+		return nil, func() {}
+	}
+	t := time.NewTicker(h.cfg.Interval)
+	return t.C, t.Stop
+}
+
+// intercept handles heartbeat traffic on the read pump, reporting whether
+// message was consumed.
+func (h *heartbeatState) intercept(message []byte) bool {
+	if h.cfg.IsAck != nil && h.cfg.IsAck(message) {
+		h.lastAck.Store(time.Now().UnixNano())
+		return true
+	}
+	if h.cfg.Reply != nil {
+		if reply, ok := h.cfg.Reply(message); ok {
+			h.sender.offer(outbound{msgType: h.cfg.messageType(), message: reply})
+			return true
+		}
+	}
+	return false
+}
+
+// beat sends a heartbeat, or returns ErrHeartbeatTimeout if acknowledgements
+// have stopped. A heartbeat that finds the send queue full is skipped.
+func (h *heartbeatState) beat() error {
+	if h.cfg.IsAck != nil && time.Since(time.Unix(0, h.lastAck.Load())) > h.cfg.timeout() {
+		return ErrHeartbeatTimeout
+	}
+	h.sender.offer(outbound{msgType: h.cfg.messageType(), message: h.cfg.Payload()})
+	return nil
+}
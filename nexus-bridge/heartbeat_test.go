@@ -0,0 +1,127 @@
+package bridge
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func jsonHeartbeat() Heartbeat {
+	return Heartbeat{
+		Interval: 20 * time.Millisecond,
+		Payload:  func() []byte { return []byte(`{"type":"ping"}`) },
+		IsAck:    func(m []byte) bool { return string(m) == `{"type":"pong"}` },
+		Timeout:  100 * time.Millisecond,
+		Reply: func(m []byte) ([]byte, bool) {
+			return []byte(`{"type":"server_pong"}`), string(m) == `{"type":"server_ping"}`
+		},
+	}
+}
+
+func TestHeartbeat_AcknowledgedKeepsConnection(t *testing.T) {
+	t.Parallel()
+	var pings int32
+	serverPong := make(chan struct{}, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.WriteMessage(1, []byte(`{"type":"server_ping"}`))
+		conn.WriteMessage(1, []byte(`data`))
+		for {
+			_, msg, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			switch string(msg) {
+			case `{"type":"ping"}`:
+				atomic.AddInt32(&pings, 1)
+				conn.WriteMessage(1, []byte(`{"type":"pong"}`))
+			case `{"type":"server_pong"}`:
+				serverPong <- struct{}{}
+			}
+		}
+	}))
+	defer server.Close()
+
+	messages := make(chan string, 10)
+	var disconnects int32
+	handler := &mockHandler{
+		onMessage:    func(m []byte) { messages <- string(m) },
+		onDisconnect: func(error) { atomic.AddInt32(&disconnects, 1) },
+	}
+	b := New(validTokenProvider(), WithHeartbeat(jsonHeartbeat()))
+	ctx, cancel := context.WithCancel(t.Context())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		b.MaintainWebSocket(ctx, "conn-1", "ws"+server.URL[4:], handler)
+	}()
+	defer func() { cancel(); <-done }()
+
+	<-serverPong
+	time.Sleep(300 * time.Millisecond) // several heartbeat timeouts
+	if n := atomic.LoadInt32(&pings); n < 5 {
+		t.Errorf("server saw %d heartbeats, want at least 5", n)
+	}
+	if n := atomic.LoadInt32(&disconnects); n != 0 {
+		t.Errorf("%d disconnects with acknowledged heartbeats", n)
+	}
+	if got := <-messages; got != "data" {
+		t.Errorf("handler got %q, want only application messages", got)
+	}
+	if len(messages) != 0 {
+		t.Errorf("handler got heartbeat traffic: %q", <-messages)
+	}
+}
+
+func TestHeartbeat_UnacknowledgedReconnects(t *testing.T) {
+	t.Parallel()
+	var dials int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&dials, 1)
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for { // read, never acknowledge
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	defer server.Close()
+
+	disconnected := make(chan error, 1)
+	handler := &mockHandler{onDisconnect: func(err error) {
+		select {
+		case disconnected <- err:
+		default:
+		}
+	}}
+	b := New(validTokenProvider(), WithHeartbeat(jsonHeartbeat()), WithRetryPolicy(grpcRetryPolicy()))
+	ctx, cancel := context.WithCancel(t.Context())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		b.MaintainWebSocket(ctx, "conn-1", "ws"+server.URL[4:], handler)
+	}()
+	defer func() { cancel(); <-done }()
+
+	select {
+	case err := <-disconnected:
+		if !errors.Is(err, ErrHeartbeatTimeout) {
+			t.Fatalf("OnDisconnect(%v), want ErrHeartbeatTimeout", err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("connection not dropped without heartbeat acks")
+	}
+	waitFor(t, "a reconnect", func() bool { return atomic.LoadInt32(&dials) >= 2 })
+}
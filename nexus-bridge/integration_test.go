@@ -70,7 +70,7 @@ func (a *SDKAdapter) convertToken(resp *oauthsdk.TokenResponse) *auth.Token {
 	}
 }
 
-// MockBroker represents the Dromos Gateway/Broker API.
+// MockBroker represents the Nexus Gateway/Broker API.
 type MockBroker struct {
 	server    *httptest.Server
 	responses map[string]map[string]interface{}
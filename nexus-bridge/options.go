@@ -1,6 +1,9 @@
 package bridge
 
 import (
+	"context"
+	"net"
+	"net/url"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -38,13 +41,41 @@ func (m *nopMetrics) SetConnectionStatus(status float64) {}
 
 // --- Configuration ---
 
-// RetryPolicy defines the backoff strategy for reconnections.
+// RetryPolicy defines the backoff strategy for reconnections. The wait
+// after the n-th failed attempt in a row is MinBackoff * Multiplier^(n-1),
+// capped at MaxBackoff, plus a random share of Jitter.
 type RetryPolicy struct {
 	MinBackoff time.Duration
 	MaxBackoff time.Duration
 	Jitter     time.Duration
+	// Multiplier grows the backoff per consecutive failure. Values below 1,
+	// including zero, mean 2; 1 keeps it at MinBackoff.
+	Multiplier float64
+	// ResetAfter is how long a connection must stay up for the backoff and
+	// the budget to start over. Zero means MaxBackoff.
+	ResetAfter time.Duration
+	// Budget bounds consecutive failures; once spent, the bridge stops with
+	// ErrRetryBudgetExhausted. The zero value retries forever.
+	Budget RetryBudget
 }
 
+// RefreshPolicy decides what happens to an open WebSocket after its token
+// is refreshed.
+type RefreshPolicy int
+
+const (
+	// RefreshAuto chooses by strategy type: OAuth2 connections keep their
+	// socket, as providers check the token at the handshake; connections
+	// with static credentials (header, query_param, ...) reconnect only when
+	// the refresh rotated them.
+	RefreshAuto RefreshPolicy = iota
+	// RefreshKeepAlive keeps the socket open after every refresh.
+	RefreshKeepAlive
+	// RefreshReconnect re-dials whenever a refresh returns new credentials,
+	// for providers that check the token for the life of the connection.
+	RefreshReconnect
+)
+
 // Option is a function that configures a Bridge.
 type Option func(*Bridge)
 
@@ -107,3 +138,55 @@ func WithPingInterval(interval time.Duration) Option {
 		b.pingInterval = interval
 	}
 }
+
+// WithSendQueueDepth sets how many outbound messages may wait to be written
+// before Send blocks. Defaults to 1.
+func WithSendQueueDepth(depth int) Option {
+	return func(b *Bridge) {
+		if depth >= 0 {
+			b.sendQueueDepth = depth
+		}
+	}
+}
+
+// WithCloseTimeout bounds how long Close waits to flush queued messages
+// and for the peer to acknowledge the close frame. Defaults to 5 seconds.
+func WithCloseTimeout(timeout time.Duration) Option {
+	return func(b *Bridge) {
+		b.closeTimeout = timeout
+	}
+}
+
+// WithOnRefresh sets what WebSocket connections do after a token refresh.
+// Defaults to RefreshAuto; a Pool can override it per connection.
+func WithOnRefresh(policy RefreshPolicy) Option {
+	return func(b *Bridge) {
+		b.onRefresh = policy
+	}
+}
+
+// WithProxy routes WebSocket and gRPC dials through the proxy at proxyURL:
+// http:// or https:// for HTTP CONNECT, socks5:// or socks5h:// for SOCKS5.
+// User info in the URL authenticates to the proxy. Without it, both
+// transports follow HTTPS_PROXY, HTTP_PROXY and NO_PROXY (HTTP CONNECT only).
+func WithProxy(proxyURL *url.URL) Option {
+	return func(b *Bridge) {
+		b.proxyURL = proxyURL
+	}
+}
+
+// WithNetDialer sets the function that opens TCP connections for WebSocket
+// and gRPC dials, or to the proxy when WithProxy is set.
+func WithNetDialer(dial func(ctx context.Context, network, addr string) (net.Conn, error)) Option {
+	return func(b *Bridge) {
+		b.netDial = dial
+	}
+}
+
+// WithHeartbeat enables application-level heartbeats on WebSocket
+// connections; see Heartbeat.
+func WithHeartbeat(h Heartbeat) Option {
+	return func(b *Bridge) {
+		b.heartbeat = h
+	}
+}
@@ -0,0 +1,131 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// dpopKeyField is the oauth2 strategy config member holding the
+// connection's DPoP key, a private P-256 JWK, when its access token is
+// DPoP-bound (RFC 9449).
+const dpopKeyField = "dpop_jwk"
+
+// dpopKey is the key DPoP proofs are signed with.
+type dpopKey struct {
+	priv *ecdsa.PrivateKey
+	// public is the public JWK embedded in every proof.
+	public map[string]string
+}
+
+// parseDPoPKey reads the private JWK the Broker hands out.
+func parseDPoPKey(v interface{}) (*dpopKey, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("invalid DPoP key: %w", err)
+	}
+	var jwk struct {
+		Kty string `json:"kty"`
+		Crv string `json:"crv"`
+		X   string `json:"x"`
+		Y   string `json:"y"`
+		D   string `json:"d"`
+	}
+	if err := json.Unmarshal(data, &jwk); err != nil {
+		return nil, fmt.Errorf("invalid DPoP key: %w", err)
+	}
+	if jwk.Kty != "EC" || jwk.Crv != "P-256" {
+		return nil, fmt.Errorf("unsupported DPoP key: %s %s", jwk.Kty, jwk.Crv)
+	}
+	var coords [3]*big.Int
+	for i, s := range []string{jwk.X, jwk.Y, jwk.D} {
+		b, err := base64.RawURLEncoding.DecodeString(s)
+		if err != nil || len(b) == 0 {
+			return nil, fmt.Errorf("invalid DPoP key: bad coordinate")
+		}
+		coords[i] = new(big.Int).SetBytes(b)
+	}
+	priv := &ecdsa.PrivateKey{
+		PublicKey: ecdsa.PublicKey{Curve: elliptic.P256(), X: coords[0], Y: coords[1]},
+		D:         coords[2],
+	}
+	if !priv.Curve.IsOnCurve(priv.X, priv.Y) {
+		return nil, fmt.Errorf("invalid DPoP key: point not on curve")
+	}
+	return &dpopKey{priv: priv, public: map[string]string{"kty": "EC", "crv": "P-256", "x": jwk.X, "y": jwk.Y}}, nil
+}
+
+// proof returns a DPoP proof for a request with method to target that
+// presents accessToken.
+func (k *dpopKey) proof(method string, target *url.URL, accessToken string, now time.Time) (string, error) {
+	htu := *target
+	htu.RawQuery, htu.Fragment, htu.User = "", "", nil
+	// A WebSocket handshake is an HTTP request to the same URI.
+	switch htu.Scheme {
+	case "ws":
+		htu.Scheme = "http"
+	case "wss":
+		htu.Scheme = "https"
+	}
+
+	jti := make([]byte, 16)
+	if _, err := rand.Read(jti); err != nil {
+		return "", err
+	}
+	ath := sha256.Sum256([]byte(accessToken))
+	header, err := json.Marshal(map[string]interface{}{"typ": "dpop+jwt", "alg": "ES256", "jwk": k.public})
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(map[string]interface{}{
+		"jti": base64.RawURLEncoding.EncodeToString(jti),
+		"htm": method,
+		"htu": htu.String(),
+		"iat": now.Unix(),
+		"ath": base64.RawURLEncoding.EncodeToString(ath[:]),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(signingInput))
+	r, s, err := ecdsa.Sign(rand.Reader, k.priv, digest[:])
+	if err != nil {
+		return "", fmt.Errorf("failed to sign DPoP proof: %w", err)
+	}
+	// JWS ES256 signatures are R and S as fixed-size big-endian integers.
+	sig := make([]byte, 64)
+	r.FillBytes(sig[:32])
+	s.FillBytes(sig[32:])
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// applyDPoPAuth presents a DPoP-bound access token: the token in the
+// Authorization header under the DPoP scheme, and a proof of possession of
+// the connection's key in the DPoP header.
+func applyDPoPAuth(req *http.Request, keyConfig interface{}, creds Credentials) error {
+	key, err := parseDPoPKey(keyConfig)
+	if err != nil {
+		return err
+	}
+	accessToken, _ := creds["access_token"].(string)
+	if accessToken == "" {
+		return fmt.Errorf("credential field 'access_token' is empty or not a string")
+	}
+	proof, err := key.proof(req.Method, req.URL, accessToken, time.Now())
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "DPoP "+accessToken)
+	req.Header.Set("DPoP", proof)
+	return nil
+}
@@ -0,0 +1,73 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testDPoPJWK(t *testing.T) (*ecdsa.PrivateKey, map[string]interface{}) {
+	t.Helper()
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	enc := func(n *big.Int) string { return base64.RawURLEncoding.EncodeToString(n.FillBytes(make([]byte, 32))) }
+	return priv, map[string]interface{}{"kty": "EC", "crv": "P-256", "x": enc(priv.X), "y": enc(priv.Y), "d": enc(priv.D), "alg": "ES256"}
+}
+
+func TestApplyAuthentication_DPoP(t *testing.T) {
+	priv, jwk := testDPoPJWK(t)
+	req, err := http.NewRequest("GET", "wss://api.example/stream?x=1", nil)
+	require.NoError(t, err)
+
+	strategy := AuthStrategy{Type: "oauth2", Config: map[string]interface{}{"dpop_jwk": jwk}}
+	require.NoError(t, ApplyAuthentication(req, strategy, Credentials{"access_token": "bound-token"}))
+	assert.Equal(t, "DPoP bound-token", req.Header.Get("Authorization"))
+
+	parts := strings.Split(req.Header.Get("DPoP"), ".")
+	require.Len(t, parts, 3)
+	var header struct {
+		Typ string            `json:"typ"`
+		Alg string            `json:"alg"`
+		JWK map[string]string `json:"jwk"`
+	}
+	var claims map[string]interface{}
+	for i, v := range []interface{}{&header, &claims} {
+		b, err := base64.RawURLEncoding.DecodeString(parts[i])
+		require.NoError(t, err)
+		require.NoError(t, json.Unmarshal(b, v))
+	}
+	assert.Equal(t, "dpop+jwt", header.Typ)
+	assert.Equal(t, "ES256", header.Alg)
+	assert.NotContains(t, header.JWK, "d", "the proof must embed only the public key")
+	assert.Equal(t, "GET", claims["htm"])
+	assert.Equal(t, "https://api.example/stream", claims["htu"])
+	ath := sha256.Sum256([]byte("bound-token"))
+	assert.Equal(t, base64.RawURLEncoding.EncodeToString(ath[:]), claims["ath"])
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	require.NoError(t, err)
+	require.Len(t, sig, 64)
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+	assert.True(t, ecdsa.Verify(&priv.PublicKey, digest[:], r, s), "the proof signature must verify")
+}
+
+func TestApplyAuthentication_DPoPInvalidKey(t *testing.T) {
+	req, _ := http.NewRequest("GET", "https://api.example/", nil)
+	strategy := AuthStrategy{Type: "oauth2", Config: map[string]interface{}{"dpop_jwk": map[string]interface{}{"kty": "RSA"}}}
+	assert.Error(t, ApplyAuthentication(req, strategy, Credentials{"access_token": "t"}))
+
+	_, jwk := testDPoPJWK(t)
+	_, err := GetGRPCMetadata(AuthStrategy{Type: "oauth2", Config: map[string]interface{}{"dpop_jwk": jwk}}, Credentials{"access_token": "t"})
+	assert.Error(t, err)
+}
@@ -90,9 +90,10 @@ func applyQueryAuth(req *http.Request, config map[string]interface{}, creds Cred
 		return fmt.Errorf("credential field '%s' is empty or not a string", credField)
 	}
 
-	// 4. Safely append the parameter to the request.
+	// 4. Set the parameter, replacing any stale value already in the URL so a
+	// rotated key never travels alongside the old one.
 	q := req.URL.Query()
-	q.Add(paramName, valStr)
+	q.Set(paramName, valStr)
 	req.URL.RawQuery = q.Encode()
 
 	return nil
@@ -291,6 +292,51 @@ func applyAWSSigV4(req *http.Request, config map[string]interface{}, creds Crede
 	return nil
 }
 
+// NewAuthenticatedRequest builds a fresh request for rawURL and applies the
+// token's strategy to it. Callers that reconnect should build a new request
+// from the original URL each time rather than reusing a previously
+// authenticated one, so rotated credentials are always picked up.
+func NewAuthenticatedRequest(method, rawURL string, token *Token) (*http.Request, error) {
+	req, err := http.NewRequest(method, rawURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request for auth injection: %w", err)
+	}
+	if err := ApplyAuthentication(req, token.Strategy, token.Credentials); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// CredentialsRotated reports whether next carries different static credentials
+// than prev. OAuth2 access tokens are expected to change on every refresh and
+// are not considered a rotation.
+func CredentialsRotated(prev, next *Token) bool {
+	if prev != nil && next != nil && prev.Strategy.Type == "oauth2" && next.Strategy.Type == "oauth2" {
+		return false
+	}
+	return CredentialsChanged(prev, next)
+}
+
+// CredentialsChanged reports whether next carries a different strategy type
+// or different credentials than prev, refreshed OAuth2 access tokens included.
+func CredentialsChanged(prev, next *Token) bool {
+	if prev == nil || next == nil {
+		return false
+	}
+	if prev.Strategy.Type != next.Strategy.Type {
+		return true
+	}
+	if len(prev.Credentials) != len(next.Credentials) {
+		return true
+	}
+	for k, v := range next.Credentials {
+		if fmt.Sprint(prev.Credentials[k]) != fmt.Sprint(v) {
+			return true
+		}
+	}
+	return false
+}
+
 // ApplyAuthentication applies the authentication strategy to the request.
 func ApplyAuthentication(req *http.Request, strategy AuthStrategy, creds Credentials) error {
 	switch strategy.Type {
@@ -305,6 +351,9 @@ func ApplyAuthentication(req *http.Request, strategy AuthStrategy, creds Credent
 	case "aws_sigv4":
 		return applyAWSSigV4(req, strategy.Config, creds)
 	case "oauth2":
+		if key, ok := strategy.Config[dpopKeyField]; ok {
+			return applyDPoPAuth(req, key, creds)
+		}
 		// OAuth2 is just a specific configuration of Header auth
 		oauthConfig := map[string]interface{}{
 			"header_name":      "Authorization",
@@ -329,6 +378,12 @@ func GetGRPCMetadata(strategy AuthStrategy, creds Credentials) (map[string]strin
 
 	case "header", "oauth2":
 
+		// A DPoP proof names the HTTP method and URI of the request, which
+		// gRPC metadata is not built for.
+		if _, ok := strategy.Config[dpopKeyField]; ok && strategy.Type == "oauth2" {
+			return nil, fmt.Errorf("DPoP-bound tokens are not supported for gRPC")
+		}
+
 		// Determine the key (default "authorization")
 
 		key := "authorization" // Default
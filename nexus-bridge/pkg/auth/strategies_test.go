@@ -337,4 +337,105 @@ func TestApplyGRPCAuthentication(t *testing.T) {
 			}
 		})
 	}
-}
\ No newline at end of file
+}
+func TestNewAuthenticatedRequest_Rotation(t *testing.T) {
+	const endpoint = "wss://api.example.com/stream?api_key=old-value"
+
+	tests := []struct {
+		name     string
+		strategy AuthStrategy
+		oldCreds Credentials
+		newCreds Credentials
+		// visible is false for signing strategies whose secret never appears on the wire.
+		visible bool
+	}{
+		{
+			name:     "header",
+			strategy: AuthStrategy{Type: "header", Config: map[string]interface{}{"header_name": "X-API-Key"}},
+			oldCreds: Credentials{"api_key": "old-value"},
+			newCreds: Credentials{"api_key": "new-value"},
+			visible:  true,
+		},
+		{
+			name:     "query_param",
+			strategy: AuthStrategy{Type: "query_param", Config: map[string]interface{}{"param_name": "api_key"}},
+			oldCreds: Credentials{"api_key": "old-value"},
+			newCreds: Credentials{"api_key": "new-value"},
+			visible:  true,
+		},
+		{
+			name:     "basic_auth",
+			strategy: AuthStrategy{Type: "basic_auth"},
+			oldCreds: Credentials{"username": "svc", "password": "old-value"},
+			newCreds: Credentials{"username": "svc", "password": "new-value"},
+		},
+		{
+			name:     "oauth2",
+			strategy: AuthStrategy{Type: "oauth2"},
+			oldCreds: Credentials{"access_token": "old-value"},
+			newCreds: Credentials{"access_token": "new-value"},
+			visible:  true,
+		},
+		{
+			name:     "hmac_payload",
+			strategy: AuthStrategy{Type: "hmac_payload", Config: map[string]interface{}{"header_name": "X-Sig"}},
+			oldCreds: Credentials{"api_secret": "old-value"},
+			newCreds: Credentials{"api_secret": "new-value"},
+		},
+		{
+			name:     "aws_sigv4",
+			strategy: AuthStrategy{Type: "aws_sigv4", Config: map[string]interface{}{"service": "execute-api"}},
+			oldCreds: Credentials{"access_key": "AKIAOLD", "secret_key": "old-value"},
+			newCreds: Credentials{"access_key": "AKIANEW", "secret_key": "new-value"},
+		},
+	}
+
+	wire := func(req *http.Request) string {
+		var buf bytes.Buffer
+		buf.WriteString(req.URL.String())
+		for k, v := range req.Header {
+			if k == "X-Amz-Date" {
+				continue
+			}
+			buf.WriteString(k + ":" + v[0] + "\n")
+		}
+		return buf.String()
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			oldReq, err := NewAuthenticatedRequest("GET", endpoint, &Token{Strategy: tt.strategy, Credentials: tt.oldCreds})
+			assert.NoError(t, err)
+			newReq, err := NewAuthenticatedRequest("GET", endpoint, &Token{Strategy: tt.strategy, Credentials: tt.newCreds})
+			assert.NoError(t, err)
+
+			assert.NotEqual(t, wire(oldReq), wire(newReq), "rotated credentials must change the request")
+			if tt.visible {
+				assert.Contains(t, wire(newReq), "new-value")
+			}
+			if tt.strategy.Type == "query_param" {
+				assert.Equal(t, []string{"new-value"}, newReq.URL.Query()["api_key"], "stale query value must be replaced, not duplicated")
+			}
+		})
+	}
+}
+
+func TestCredentialsRotated(t *testing.T) {
+	key := func(v string) *Token {
+		return &Token{Strategy: AuthStrategy{Type: "query_param"}, Credentials: Credentials{"api_key": v}}
+	}
+	bearer := func(v string) *Token {
+		return &Token{Strategy: AuthStrategy{Type: "oauth2"}, Credentials: Credentials{"access_token": v}}
+	}
+
+	assert.False(t, CredentialsRotated(key("a"), key("a")))
+	assert.True(t, CredentialsRotated(key("a"), key("b")))
+	assert.False(t, CredentialsRotated(bearer("a"), bearer("b")), "oauth2 refreshes are not rotations")
+	assert.True(t, CredentialsRotated(bearer("a"), key("a")), "strategy change is a rotation")
+	assert.False(t, CredentialsRotated(nil, key("a")))
+
+	assert.True(t, CredentialsChanged(bearer("a"), bearer("b")), "a refreshed access token is a change")
+	assert.False(t, CredentialsChanged(bearer("a"), bearer("a")))
+	assert.True(t, CredentialsChanged(key("a"), key("b")))
+	assert.False(t, CredentialsChanged(nil, key("a")))
+}
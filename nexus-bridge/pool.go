@@ -0,0 +1,340 @@
+package bridge
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrPoolClosed is returned by Pool.Add after Shutdown.
+	ErrPoolClosed = errors.New("bridge: pool is shut down")
+	// ErrConnectionExists is returned by Pool.Add for a connection ID the
+	// pool is already maintaining.
+	ErrConnectionExists = errors.New("bridge: connection already in pool")
+	// ErrConnectionNotInPool is returned by Pool.Remove for an unknown
+	// connection ID.
+	ErrConnectionNotInPool = errors.New("bridge: connection not in pool")
+)
+
+// ConnectionSpec describes a WebSocket connection for a Pool to maintain.
+type ConnectionSpec struct {
+	ConnectionID string
+	EndpointURL  string
+	Handler      Handler
+	// OnRefresh overrides the Bridge's RefreshPolicy (WithOnRefresh) for
+	// this connection; RefreshAuto keeps the Bridge's.
+	OnRefresh RefreshPolicy
+}
+
+// ConnectionHealth reports a pooled connection.
+type ConnectionHealth struct {
+	ConnectionID string
+	EndpointURL  string
+	// Phase is PhaseConnecting, PhaseConnected, PhaseReconnecting, or
+	// PhaseClosed after a permanent error. A closed connection stays in the
+	// pool until it is removed or added again.
+	Phase Phase
+	// Since is when the connection entered Phase.
+	Since time.Time
+	// Attempt and NextRetry describe a reconnecting connection.
+	Attempt   int
+	NextRetry time.Time
+	// Connects and Disconnects count WebSocket sessions since it was added.
+	Connects    int
+	Disconnects int
+	// LastError is the error that ended the latest attempt, if any; for a
+	// closed connection, the permanent error.
+	LastError error
+}
+
+// PoolStats counts a Pool's connections by phase.
+type PoolStats struct {
+	Total        int
+	Connecting   int
+	Connected    int
+	Reconnecting int
+	Closed       int
+}
+
+// PoolMetrics is implemented by Metrics collectors that also report the
+// size of a Pool. When the Bridge's Metrics implement it, the pool reports
+// the number of connections in each Phase on every change.
+type PoolMetrics interface {
+	SetPoolConnections(state string, count int)
+}
+
+// Pool maintains many WebSocket connections over one Bridge, each in its
+// own goroutine with the Bridge's retry policy. Connections can be added
+// and removed while the pool runs.
+//
+// Counters of the Bridge's Metrics (connections, disconnects, refreshes)
+// add up across the pool. Its connection status is 1 while at least one
+// pooled connection is open. Bridge.Status reports pooled connections too.
+type Pool struct {
+	bridge *Bridge
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu     sync.Mutex
+	conns  map[string]*pooledConn
+	closed bool
+	wg     sync.WaitGroup
+}
+
+type pooledConn struct {
+	spec   ConnectionSpec
+	cancel context.CancelFunc
+	done   chan struct{}
+	health ConnectionHealth
+}
+
+// NewPool creates an empty Pool whose connections use b.
+func NewPool(b *Bridge) *Pool {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Pool{bridge: b, ctx: ctx, cancel: cancel, conns: map[string]*pooledConn{}}
+}
+
+// Add starts maintaining spec. A closed connection with the same ID is
+// replaced; any other is ErrConnectionExists.
+func (p *Pool) Add(spec ConnectionSpec) error {
+	if spec.ConnectionID == "" || spec.EndpointURL == "" || spec.Handler == nil {
+		return errors.New("bridge: connection spec needs ConnectionID, EndpointURL and Handler")
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return ErrPoolClosed
+	}
+	if old, ok := p.conns[spec.ConnectionID]; ok && old.health.Phase != PhaseClosed {
+		return ErrConnectionExists
+	}
+	ctx, cancel := context.WithCancel(p.ctx)
+	pc := &pooledConn{
+		spec:   spec,
+		cancel: cancel,
+		done:   make(chan struct{}),
+		health: ConnectionHealth{
+			ConnectionID: spec.ConnectionID,
+			EndpointURL:  spec.EndpointURL,
+			Phase:        PhaseConnecting,
+			Since:        time.Now(),
+		},
+	}
+	p.conns[spec.ConnectionID] = pc
+	p.publishLocked()
+
+	b := *p.bridge
+	b.metrics = pooledMetrics{p.bridge.metrics}
+	if spec.OnRefresh != RefreshAuto {
+		b.onRefresh = spec.OnRefresh
+	}
+	b.stateChanged = func(s ConnectionState) {
+		if s.Phase == PhaseClosed && ctx.Err() != nil {
+			return // removed or shut down
+		}
+		p.update(pc, func(h *ConnectionHealth) { h.apply(s) })
+	}
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		defer close(pc.done)
+		if err := b.MaintainWebSocket(ctx, spec.ConnectionID, spec.EndpointURL, spec.Handler); err != nil && ctx.Err() == nil {
+			p.bridge.logger.Error(err, "Pooled connection closed", "connectionID", spec.ConnectionID)
+		}
+	}()
+	return nil
+}
+
+// Remove stops maintaining the connection, closing it gracefully if open
+// (see Bridge.Close), and waits for its goroutine to exit.
+func (p *Pool) Remove(connectionID string) error {
+	p.mu.Lock()
+	pc, ok := p.conns[connectionID]
+	if ok {
+		delete(p.conns, connectionID)
+		p.publishLocked()
+	}
+	p.mu.Unlock()
+	if !ok {
+		return ErrConnectionNotInPool
+	}
+	p.bridge.Close(context.Background(), connectionID)
+	pc.cancel()
+	<-pc.done
+	return nil
+}
+
+// Shutdown closes every connection gracefully (see Bridge.Close) and waits
+// for them to exit, or for ctx to be done; connections that have not closed
+// by then are dropped. The pool accepts no connections afterwards.
+func (p *Pool) Shutdown(ctx context.Context) error {
+	p.mu.Lock()
+	p.closed = true
+	ids := make([]string, 0, len(p.conns))
+	for id := range p.conns {
+		ids = append(ids, id)
+	}
+	p.mu.Unlock()
+
+	var closing sync.WaitGroup
+	for _, id := range ids {
+		closing.Add(1)
+		go func() {
+			defer closing.Done()
+			p.bridge.Close(ctx, id)
+		}()
+	}
+	closing.Wait()
+	p.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	p.mu.Lock()
+	p.conns = map[string]*pooledConn{}
+	p.publishLocked()
+	p.mu.Unlock()
+	return nil
+}
+
+// Health reports the connection, if the pool has it.
+func (p *Pool) Health(connectionID string) (ConnectionHealth, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	pc, ok := p.conns[connectionID]
+	if !ok {
+		return ConnectionHealth{}, false
+	}
+	return pc.health, true
+}
+
+// Connections reports every connection in the pool, ordered by ID.
+func (p *Pool) Connections() []ConnectionHealth {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	out := make([]ConnectionHealth, 0, len(p.conns))
+	for _, pc := range p.conns {
+		out = append(out, pc.health)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ConnectionID < out[j].ConnectionID })
+	return out
+}
+
+// Stats counts the pool's connections by state.
+func (p *Pool) Stats() PoolStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.statsLocked()
+}
+
+func (p *Pool) statsLocked() PoolStats {
+	s := PoolStats{Total: len(p.conns)}
+	for _, pc := range p.conns {
+		switch pc.health.Phase {
+		case PhaseConnecting:
+			s.Connecting++
+		case PhaseConnected:
+			s.Connected++
+		case PhaseReconnecting:
+			s.Reconnecting++
+		case PhaseClosed:
+			s.Closed++
+		}
+	}
+	return s
+}
+
+// update applies fn to pc's health, unless pc was removed meanwhile.
+func (p *Pool) update(pc *pooledConn, fn func(*ConnectionHealth)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.conns[pc.spec.ConnectionID] != pc {
+		return
+	}
+	fn(&pc.health)
+	p.publishLocked()
+}
+
+// publishLocked reports the pool's state to the Bridge's Metrics.
+func (p *Pool) publishLocked() {
+	stats := p.statsLocked()
+	status := 0.0
+	if stats.Connected > 0 {
+		status = 1
+	}
+	p.bridge.metrics.SetConnectionStatus(status)
+	if pm, ok := p.bridge.metrics.(PoolMetrics); ok {
+		pm.SetPoolConnections(string(PhaseConnecting), stats.Connecting)
+		pm.SetPoolConnections(string(PhaseConnected), stats.Connected)
+		pm.SetPoolConnections(string(PhaseReconnecting), stats.Reconnecting)
+		pm.SetPoolConnections(string(PhaseClosed), stats.Closed)
+	}
+}
+
+// apply moves the health to the connection state s.
+func (h *ConnectionHealth) apply(s ConnectionState) {
+	if h.Phase == PhaseConnected && s.Phase != PhaseConnected {
+		h.Disconnects++
+	}
+	if s.Phase == PhaseConnected {
+		h.Connects++
+	}
+	if h.Phase != s.Phase {
+		h.Since = s.At
+	}
+	h.Phase = s.Phase
+	h.Attempt = s.Attempt
+	h.NextRetry = s.NextRetry
+	if s.Err != nil {
+		h.LastError = s.Err
+	}
+}
+
+// pooledMetrics forwards a pooled connection's counters, SendMetrics
+// included, to the Bridge's Metrics; the pool sets the connection status
+// for all of them.
+type pooledMetrics struct {
+	Metrics
+}
+
+func (pooledMetrics) SetConnectionStatus(float64) {}
+
+func (m pooledMetrics) IncMessagesQueued() {
+	if sm, ok := m.Metrics.(SendMetrics); ok {
+		sm.IncMessagesQueued()
+	}
+}
+
+func (m pooledMetrics) IncMessagesDropped() {
+	if sm, ok := m.Metrics.(SendMetrics); ok {
+		sm.IncMessagesDropped()
+	}
+}
+
+func (m pooledMetrics) ObserveConnect(d time.Duration, err error) {
+	if cm, ok := m.Metrics.(ConnectionMetrics); ok {
+		cm.ObserveConnect(d, err)
+	}
+}
+
+func (m pooledMetrics) ObserveSession(d time.Duration, closeCode int) {
+	if cm, ok := m.Metrics.(ConnectionMetrics); ok {
+		cm.ObserveSession(d, closeCode)
+	}
+}
+
+func (m pooledMetrics) AddSendQueueDepth(delta int) {
+	if qm, ok := m.Metrics.(QueueMetrics); ok {
+		qm.AddSendQueueDepth(delta)
+	}
+}
@@ -0,0 +1,201 @@
+package bridge
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-bridge/pkg/auth"
+)
+
+// poolMetrics records the pool gauges on top of mockMetrics.
+type poolMetrics struct {
+	mockMetrics
+	mu    sync.Mutex
+	gauge map[string]int
+}
+
+func (m *poolMetrics) SetPoolConnections(state string, count int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.gauge[state] = count
+}
+
+func (m *poolMetrics) get(state string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.gauge[state]
+}
+
+// waitFor polls cond until it holds or the test times out.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func newPoolFixture(t *testing.T) (*Pool, *poolMetrics, string) {
+	t.Helper()
+	authClient := &mockTokenProvider{
+		getTokenFunc: func(ctx context.Context, connectionID string) (*auth.Token, error) {
+			if connectionID == "revoked" {
+				return nil, errors.New("connection not active")
+			}
+			return &auth.Token{
+				Strategy:    auth.AuthStrategy{Type: "oauth2"},
+				Credentials: auth.Credentials{"access_token": "tok-" + connectionID},
+				ExpiresAt:   time.Now().Add(time.Hour).Unix(),
+			}, nil
+		},
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	metrics := &poolMetrics{gauge: map[string]int{}}
+	b := New(authClient, WithMetrics(metrics), WithRetryPolicy(grpcRetryPolicy()), WithLogger(&testLogger{t: t}))
+	pool := NewPool(b)
+	t.Cleanup(func() { pool.Shutdown(context.Background()) })
+	return pool, metrics, "ws" + server.URL[4:]
+}
+
+func TestPool_AddRemoveShutdown(t *testing.T) {
+	t.Parallel()
+	pool, metrics, url := newPoolFixture(t)
+
+	var mu sync.Mutex
+	disconnected := map[string]bool{}
+	spec := func(id string) ConnectionSpec {
+		return ConnectionSpec{ConnectionID: id, EndpointURL: url, Handler: &mockHandler{
+			onDisconnect: func(error) {
+				mu.Lock()
+				disconnected[id] = true
+				mu.Unlock()
+			},
+		}}
+	}
+	for _, id := range []string{"a", "b", "c"} {
+		if err := pool.Add(spec(id)); err != nil {
+			t.Fatalf("Add(%s): %v", id, err)
+		}
+	}
+	if err := pool.Add(spec("a")); !errors.Is(err, ErrConnectionExists) {
+		t.Fatalf("duplicate Add: got %v, want ErrConnectionExists", err)
+	}
+	waitFor(t, "3 connected", func() bool { return pool.Stats().Connected == 3 })
+	if metrics.get("connected") != 3 || metrics.connectionStatus.Load() != 1.0 {
+		t.Errorf("metrics: connected=%d status=%v", metrics.get("connected"), metrics.connectionStatus.Load())
+	}
+
+	h, ok := pool.Health("b")
+	if !ok || h.Phase != PhaseConnected || h.Connects != 1 || h.EndpointURL != url {
+		t.Errorf("Health(b) = %+v, %v", h, ok)
+	}
+
+	if err := pool.Remove("b"); err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+	if _, ok := pool.Health("b"); ok {
+		t.Error("removed connection still reported")
+	}
+	if err := pool.Remove("b"); !errors.Is(err, ErrConnectionNotInPool) {
+		t.Errorf("second Remove: got %v, want ErrConnectionNotInPool", err)
+	}
+	conns := pool.Connections()
+	if len(conns) != 2 || conns[0].ConnectionID != "a" || conns[1].ConnectionID != "c" {
+		t.Errorf("Connections() = %+v", conns)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	if err := pool.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+	if s := pool.Stats(); s.Total != 0 {
+		t.Errorf("stats after shutdown = %+v", s)
+	}
+	if metrics.get("connected") != 0 || metrics.connectionStatus.Load() != 0.0 {
+		t.Errorf("metrics after shutdown: connected=%d status=%v", metrics.get("connected"), metrics.connectionStatus.Load())
+	}
+	if err := pool.Add(spec("d")); !errors.Is(err, ErrPoolClosed) {
+		t.Errorf("Add after Shutdown: got %v, want ErrPoolClosed", err)
+	}
+}
+
+func TestPool_PermanentErrorStopsConnection(t *testing.T) {
+	t.Parallel()
+	pool, metrics, url := newPoolFixture(t)
+
+	if err := pool.Add(ConnectionSpec{ConnectionID: "revoked", EndpointURL: url, Handler: &mockHandler{}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := pool.Add(ConnectionSpec{ConnectionID: "ok", EndpointURL: url, Handler: &mockHandler{}}); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "revoked to stop", func() bool {
+		h, _ := pool.Health("revoked")
+		return h.Phase == PhaseClosed
+	})
+	waitFor(t, "ok to connect", func() bool { return pool.Stats().Connected == 1 })
+
+	h, _ := pool.Health("revoked")
+	var permErr *PermanentError
+	if !errors.As(h.LastError, &permErr) {
+		t.Errorf("LastError = %v, want a PermanentError", h.LastError)
+	}
+	if s := pool.Stats(); s.Total != 2 || s.Closed != 1 {
+		t.Errorf("stats = %+v", s)
+	}
+	if metrics.get("closed") != 1 {
+		t.Errorf("closed gauge = %d", metrics.get("closed"))
+	}
+
+	// A closed connection may be added again.
+	if err := pool.Add(ConnectionSpec{ConnectionID: "revoked", EndpointURL: url, Handler: &mockHandler{}}); err != nil {
+		t.Errorf("re-Add of a closed connection: %v", err)
+	}
+}
+
+func TestPool_TracksReconnects(t *testing.T) {
+	t.Parallel()
+	pool, _, url := newPoolFixture(t)
+
+	// A failed dial is reported while the pool keeps retrying.
+	if err := pool.Add(ConnectionSpec{ConnectionID: "a", EndpointURL: "ws://127.0.0.1:1", Handler: &mockHandler{}}); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "a to fail", func() bool {
+		h, _ := pool.Health("a")
+		return h.Phase == PhaseReconnecting && h.LastError != nil
+	})
+	if err := pool.Remove("a"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := pool.Add(ConnectionSpec{ConnectionID: "a", EndpointURL: url, Handler: &mockHandler{}}); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "a to connect", func() bool {
+		h, _ := pool.Health("a")
+		return h.Phase == PhaseConnected
+	})
+}
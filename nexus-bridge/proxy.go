@@ -0,0 +1,135 @@
+package bridge
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+// dialFunc dials a network address; it is the shape of
+// net.Dialer.DialContext.
+type dialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// Dial implements proxy.Dialer.
+func (f dialFunc) Dial(network, addr string) (net.Conn, error) {
+	return f(context.Background(), network, addr)
+}
+
+// DialContext implements proxy.ContextDialer.
+func (f dialFunc) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	return f(ctx, network, addr)
+}
+
+// customDial reports whether WithProxy or WithNetDialer replaced the
+// transports' own dialing.
+func (b *Bridge) customDial() bool {
+	return b.proxyURL != nil || b.netDial != nil
+}
+
+// dial connects to addr with the Bridge's net dialer, through its proxy if
+// one is set.
+func (b *Bridge) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	forward := b.netDial
+	if forward == nil {
+		forward = (&net.Dialer{}).DialContext
+	}
+	if b.proxyURL == nil {
+		return forward(ctx, network, addr)
+	}
+	switch b.proxyURL.Scheme {
+	case "socks5", "socks5h":
+		var auth *proxy.Auth
+		if u := b.proxyURL.User; u != nil {
+			password, _ := u.Password()
+			auth = &proxy.Auth{User: u.Username(), Password: password}
+		}
+		d, err := proxy.SOCKS5("tcp", b.proxyURL.Host, auth, dialFunc(forward))
+		if err != nil {
+			return nil, fmt.Errorf("bridge: SOCKS5 proxy: %w", err)
+		}
+		return d.(proxy.ContextDialer).DialContext(ctx, network, addr)
+	case "http", "https":
+		return dialConnect(ctx, b.proxyURL, forward, addr)
+	default:
+		return nil, fmt.Errorf("bridge: unsupported proxy scheme %q", b.proxyURL.Scheme)
+	}
+}
+
+// dialConnect opens a tunnel to addr with an HTTP CONNECT request to the
+// proxy at proxyURL, authenticating with its user info if present.
+func dialConnect(ctx context.Context, proxyURL *url.URL, forward dialFunc, addr string) (net.Conn, error) {
+	proxyAddr := proxyURL.Host
+	if proxyURL.Port() == "" {
+		port := "80"
+		if proxyURL.Scheme == "https" {
+			port = "443"
+		}
+		proxyAddr = net.JoinHostPort(proxyURL.Hostname(), port)
+	}
+	conn, err := forward(ctx, "tcp", proxyAddr)
+	if err != nil {
+		return nil, fmt.Errorf("bridge: dial proxy: %w", err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+		defer conn.SetDeadline(time.Time{})
+	}
+	if proxyURL.Scheme == "https" {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: proxyURL.Hostname()})
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("bridge: proxy TLS handshake: %w", err)
+		}
+		conn = tlsConn
+	}
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if u := proxyURL.User; u != nil {
+		password, _ := u.Password()
+		creds := base64.StdEncoding.EncodeToString([]byte(u.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+creds)
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("bridge: proxy CONNECT: %w", err)
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("bridge: proxy CONNECT: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("bridge: proxy CONNECT to %s: %s", addr, resp.Status)
+	}
+	if br.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, r: br}, nil
+	}
+	return conn, nil
+}
+
+// bufferedConn is a tunnel whose first bytes were read along with the
+// proxy's response.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
@@ -0,0 +1,229 @@
+package bridge
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// pipe copies between a and b until either side closes.
+func pipe(a, b net.Conn) {
+	go func() {
+		io.Copy(a, b)
+		a.Close()
+	}()
+	io.Copy(b, a)
+	b.Close()
+}
+
+// newConnectProxy starts an HTTP CONNECT proxy that requires the given
+// Proxy-Authorization, counting the tunnels it opens.
+func newConnectProxy(t *testing.T, user, password string) (*url.URL, *int32) {
+	t.Helper()
+	var tunnels int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect {
+			http.Error(w, "CONNECT only", http.StatusMethodNotAllowed)
+			return
+		}
+		// Proxy-Authorization has the same form as Authorization.
+		auth := &http.Request{Header: http.Header{"Authorization": r.Header["Proxy-Authorization"]}}
+		if u, p, ok := auth.BasicAuth(); !ok || u != user || p != password {
+			http.Error(w, "proxy auth required", http.StatusProxyAuthRequired)
+			return
+		}
+		upstream, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		client, _, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			upstream.Close()
+			return
+		}
+		client.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+		atomic.AddInt32(&tunnels, 1)
+		pipe(client, upstream)
+	}))
+	t.Cleanup(srv.Close)
+	u, _ := url.Parse(srv.URL)
+	u.User = url.UserPassword(user, password)
+	return u, &tunnels
+}
+
+// newSOCKS5Proxy starts a SOCKS5 proxy requiring username/password auth.
+func newSOCKS5Proxy(t *testing.T, user, password string) (*url.URL, *int32) {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { lis.Close() })
+	var tunnels int32
+	go func() {
+		for {
+			c, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				if upstream, err := socks5Handshake(c, user, password); err == nil {
+					atomic.AddInt32(&tunnels, 1)
+					pipe(c, upstream)
+				} else {
+					c.Close()
+				}
+			}()
+		}
+	}()
+	return &url.URL{Scheme: "socks5", Host: lis.Addr().String(), User: url.UserPassword(user, password)}, &tunnels
+}
+
+func socks5Handshake(c net.Conn, user, password string) (net.Conn, error) {
+	buf := make([]byte, 256)
+	// Greeting: VER NMETHODS METHODS; choose username/password (0x02).
+	if _, err := io.ReadFull(c, buf[:2]); err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(c, buf[:buf[1]]); err != nil {
+		return nil, err
+	}
+	c.Write([]byte{5, 2})
+	// Auth: VER ULEN UNAME PLEN PASSWD.
+	if _, err := io.ReadFull(c, buf[:2]); err != nil {
+		return nil, err
+	}
+	u := make([]byte, buf[1])
+	io.ReadFull(c, u)
+	io.ReadFull(c, buf[:1])
+	p := make([]byte, buf[0])
+	io.ReadFull(c, p)
+	if string(u) != user || string(p) != password {
+		c.Write([]byte{1, 1})
+		return nil, errors.New("bad credentials")
+	}
+	c.Write([]byte{1, 0})
+	// Request: VER CMD RSV ATYP DST.ADDR DST.PORT.
+	if _, err := io.ReadFull(c, buf[:4]); err != nil {
+		return nil, err
+	}
+	var host string
+	switch buf[3] {
+	case 1:
+		io.ReadFull(c, buf[:4])
+		host = net.IP(buf[:4]).String()
+	case 3:
+		io.ReadFull(c, buf[:1])
+		name := make([]byte, buf[0])
+		io.ReadFull(c, name)
+		host = string(name)
+	default:
+		return nil, errors.New("unsupported address type")
+	}
+	io.ReadFull(c, buf[:2])
+	upstream, err := net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(buf[:2])))))
+	if err != nil {
+		c.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+		return nil, err
+	}
+	c.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+	return upstream, nil
+}
+
+func TestBridge_WebSocketThroughProxy(t *testing.T) {
+	t.Parallel()
+	connectURL, connectTunnels := newConnectProxy(t, "agent", "s3cret")
+	socksURL, socksTunnels := newSOCKS5Proxy(t, "agent", "s3cret")
+	for _, tt := range []struct {
+		name    string
+		proxy   *url.URL
+		tunnels *int32
+	}{
+		{"http connect", connectURL, connectTunnels},
+		{"socks5", socksURL, socksTunnels},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				conn, err := upgrader.Upgrade(w, r, nil)
+				if err != nil {
+					return
+				}
+				defer conn.Close()
+				<-r.Context().Done()
+			}))
+			defer server.Close()
+
+			connected := make(chan struct{}, 1)
+			handler := &mockHandler{onConnect: func(func([]byte) error) { connected <- struct{}{} }}
+			b := New(validTokenProvider(), WithProxy(tt.proxy), WithLogger(&testLogger{t: t}))
+			ctx, cancel := context.WithCancel(t.Context())
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				b.MaintainWebSocket(ctx, "conn-1", "ws"+server.URL[4:], handler)
+			}()
+			defer func() { cancel(); <-done }()
+
+			<-connected
+			if atomic.LoadInt32(tt.tunnels) == 0 {
+				t.Error("connected without going through the proxy")
+			}
+		})
+	}
+}
+
+func TestBridge_ProxyAuthRejected(t *testing.T) {
+	t.Parallel()
+	proxyURL, _ := newConnectProxy(t, "agent", "s3cret")
+	proxyURL.User = url.UserPassword("agent", "wrong")
+	b := New(validTokenProvider(), WithProxy(proxyURL))
+	_, err := b.dial(t.Context(), "tcp", "127.0.0.1:1")
+	if err == nil || !strings.Contains(err.Error(), "407") {
+		t.Fatalf("dial = %v, want a 407 from the proxy", err)
+	}
+}
+
+func TestGRPC_ThroughProxy(t *testing.T) {
+	t.Parallel()
+	proxyURL, tunnels := newConnectProxy(t, "agent", "s3cret")
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := grpc.NewServer()
+	grpc_health_v1.RegisterHealthServer(srv, health.NewServer())
+	go srv.Serve(lis)
+	defer srv.Stop()
+
+	b := New(validTokenProvider(), WithProxy(proxyURL), WithRetryPolicy(grpcRetryPolicy()), WithLogger(&testLogger{t: t}))
+	run := func(ctx context.Context, conn *grpc.ClientConn) error {
+		_, err := grpc_health_v1.NewHealthClient(conn).Check(ctx, &grpc_health_v1.HealthCheckRequest{})
+		if err != nil {
+			return NewPermanentError(err)
+		}
+		return nil
+	}
+	if err := b.MaintainGRPCConnection(t.Context(), "conn-1", "passthrough:///"+lis.Addr().String(), run,
+		grpc.WithTransportCredentials(insecure.NewCredentials())); err != nil {
+		t.Fatalf("MaintainGRPCConnection: %v", err)
+	}
+	if atomic.LoadInt32(tunnels) == 0 {
+		t.Error("gRPC connected without going through the proxy")
+	}
+}
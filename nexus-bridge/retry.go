@@ -0,0 +1,89 @@
+package bridge
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// ErrRetryBudgetExhausted is returned, inside a *PermanentError, when a
+// connection failed more often or for longer than RetryPolicy.Budget
+// allows. It wraps the error of the last attempt.
+var ErrRetryBudgetExhausted = errors.New("retry budget exhausted")
+
+// RetryBudget bounds a run of consecutive failed connection attempts. Zero
+// fields are unlimited.
+type RetryBudget struct {
+	// MaxAttempts is the number of failed attempts in a row after which
+	// the bridge gives up.
+	MaxAttempts int
+	// MaxElapsed is how long after the first failure of a run the bridge
+	// gives up.
+	MaxElapsed time.Duration
+}
+
+// retryState tracks consecutive failed attempts under a RetryPolicy.
+type retryState struct {
+	policy   RetryPolicy
+	failures int
+	since    time.Time
+	now      func() time.Time
+}
+
+func newRetryState(policy RetryPolicy) *retryState {
+	return &retryState{policy: policy, now: time.Now}
+}
+
+// failed records a failed attempt whose connection stayed up for uptime (0
+// if it never came up) and returns how long to wait before the next one. A
+// connection that stayed up for the policy's reset period starts a new run
+// of failures. Once the budget is spent it returns a *PermanentError
+// wrapping ErrRetryBudgetExhausted and cause.
+func (r *retryState) failed(uptime time.Duration, cause error) (time.Duration, error) {
+	if uptime > 0 && uptime >= r.resetAfter() {
+		r.failures = 0
+	}
+	now := r.now()
+	if r.failures == 0 {
+		r.since = now
+	}
+	r.failures++
+
+	budget := r.policy.Budget
+	if budget.MaxAttempts > 0 && r.failures >= budget.MaxAttempts {
+		return 0, NewPermanentError(fmt.Errorf("%w after %d failed attempts: %w", ErrRetryBudgetExhausted, r.failures, cause))
+	}
+	if budget.MaxElapsed > 0 && now.Sub(r.since) >= budget.MaxElapsed {
+		return 0, NewPermanentError(fmt.Errorf("%w after failing for %s: %w", ErrRetryBudgetExhausted, now.Sub(r.since).Round(time.Millisecond), cause))
+	}
+	return r.delay(r.failures), nil
+}
+
+// delay is the wait after the n-th failure in a row: MinBackoff grown by
+// Multiplier per earlier failure, capped at MaxBackoff, plus jitter so that
+// agents reconnecting after a gateway restart do not all retry at once.
+func (r *retryState) delay(n int) time.Duration {
+	p := r.policy
+	mult := p.Multiplier
+	if mult < 1 {
+		mult = 2
+	}
+	d := float64(p.MinBackoff) * math.Pow(mult, float64(n-1))
+	if p.MaxBackoff > 0 && (d > float64(p.MaxBackoff) || math.IsInf(d, 0)) {
+		d = float64(p.MaxBackoff)
+	}
+	backoff := time.Duration(d)
+	if p.Jitter > 0 {
+		backoff += time.Duration(rand.Int63n(int64(p.Jitter)))
+	}
+	return backoff
+}
+
+func (r *retryState) resetAfter() time.Duration {
+	if r.policy.ResetAfter > 0 {
+		return r.policy.ResetAfter
+	}
+	return r.policy.MaxBackoff
+}
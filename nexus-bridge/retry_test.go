@@ -0,0 +1,124 @@
+package bridge
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-bridge/pkg/auth"
+)
+
+func TestRetryState_ExponentialBackoff(t *testing.T) {
+	r := newRetryState(RetryPolicy{MinBackoff: time.Second, MaxBackoff: 10 * time.Second})
+	var got []time.Duration
+	for i := 0; i < 6; i++ {
+		d, err := r.failed(0, errors.New("down"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, d)
+	}
+	want := []time.Duration{1 * time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second, 10 * time.Second}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("backoffs = %v, want %v", got, want)
+		}
+	}
+
+	flat := newRetryState(RetryPolicy{MinBackoff: time.Second, MaxBackoff: 10 * time.Second, Multiplier: 1})
+	flat.failed(0, nil)
+	if d, _ := flat.failed(0, nil); d != time.Second {
+		t.Errorf("multiplier 1: second backoff = %v, want 1s", d)
+	}
+}
+
+func TestRetryState_JitterWithinBounds(t *testing.T) {
+	r := newRetryState(RetryPolicy{MinBackoff: time.Second, MaxBackoff: time.Second, Jitter: 100 * time.Millisecond})
+	for i := 0; i < 20; i++ {
+		d, _ := r.failed(0, nil)
+		if d < time.Second || d >= 1100*time.Millisecond {
+			t.Fatalf("backoff %v outside [1s, 1.1s)", d)
+		}
+	}
+}
+
+func TestRetryState_ResetAfterStableConnection(t *testing.T) {
+	r := newRetryState(RetryPolicy{MinBackoff: time.Second, MaxBackoff: 30 * time.Second, ResetAfter: time.Minute})
+	r.failed(0, nil)
+	r.failed(0, nil)
+	if d, _ := r.failed(59*time.Second, nil); d != 4*time.Second {
+		t.Errorf("short-lived connection: backoff = %v, want 4s", d)
+	}
+	if d, _ := r.failed(time.Minute, nil); d != time.Second {
+		t.Errorf("stable connection: backoff = %v, want reset to 1s", d)
+	}
+
+	// Without ResetAfter a connection must last MaxBackoff.
+	r = newRetryState(RetryPolicy{MinBackoff: time.Second, MaxBackoff: 30 * time.Second})
+	r.failed(0, nil)
+	if d, _ := r.failed(30*time.Second, nil); d != time.Second {
+		t.Errorf("default reset: backoff = %v, want 1s", d)
+	}
+}
+
+func TestRetryState_Budget(t *testing.T) {
+	cause := errors.New("dial refused")
+	r := newRetryState(RetryPolicy{MinBackoff: time.Millisecond, MaxBackoff: time.Second, Budget: RetryBudget{MaxAttempts: 3}})
+	for i := 0; i < 2; i++ {
+		if _, err := r.failed(0, cause); err != nil {
+			t.Fatalf("attempt %d: %v", i+1, err)
+		}
+	}
+	_, err := r.failed(0, cause)
+	var permErr *PermanentError
+	if !errors.As(err, &permErr) || !errors.Is(err, ErrRetryBudgetExhausted) || !errors.Is(err, cause) {
+		t.Fatalf("third failure: got %v, want permanent ErrRetryBudgetExhausted wrapping the cause", err)
+	}
+
+	now := time.Now()
+	r = newRetryState(RetryPolicy{MinBackoff: time.Millisecond, MaxBackoff: time.Second, Budget: RetryBudget{MaxElapsed: time.Minute}})
+	r.now = func() time.Time { return now }
+	if _, err := r.failed(0, cause); err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(30 * time.Second)
+	if _, err := r.failed(0, cause); err != nil {
+		t.Fatal(err)
+	}
+	// A stable connection starts a new run, so the clock starts over.
+	now = now.Add(40 * time.Second)
+	if _, err := r.failed(2*time.Second, cause); err != nil {
+		t.Fatalf("after a stable connection: %v", err)
+	}
+	now = now.Add(time.Minute)
+	if _, err := r.failed(0, cause); !errors.Is(err, ErrRetryBudgetExhausted) {
+		t.Fatalf("after a minute of failures: got %v", err)
+	}
+}
+
+func TestBridge_RetryBudgetStopsWebSocket(t *testing.T) {
+	t.Parallel()
+	authClient := &mockTokenProvider{
+		getTokenFunc: func(ctx context.Context, connectionID string) (*auth.Token, error) {
+			return &auth.Token{
+				Strategy:    auth.AuthStrategy{Type: "oauth2"},
+				Credentials: auth.Credentials{"access_token": "tok"},
+				ExpiresAt:   time.Now().Add(time.Hour).Unix(),
+			}, nil
+		},
+	}
+	metrics := &mockMetrics{}
+	b := New(authClient, WithMetrics(metrics), WithLogger(&testLogger{t: t}), WithRetryPolicy(RetryPolicy{
+		MinBackoff: time.Millisecond,
+		MaxBackoff: 10 * time.Millisecond,
+		Budget:     RetryBudget{MaxAttempts: 3},
+	}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := b.MaintainWebSocket(ctx, "conn-1", "ws://127.0.0.1:1", &mockHandler{})
+	if !errors.Is(err, ErrRetryBudgetExhausted) {
+		t.Fatalf("got %v, want ErrRetryBudgetExhausted", err)
+	}
+}
@@ -0,0 +1,229 @@
+package bridge
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// ErrConnectionClosed is returned by a Sender once its connection has
+// closed, and reported for messages that were still queued at that point.
+var ErrConnectionClosed = errors.New("connection is closed")
+
+// ErrInvalidMessageType is returned by Sender.SendMessage for a type other
+// than TextMessage or BinaryMessage.
+var ErrInvalidMessageType = errors.New("bridge: message type must be TextMessage or BinaryMessage")
+
+// MessageType is the type of a WebSocket data frame.
+type MessageType int
+
+const (
+	// TextMessage is a UTF-8 text frame, the type Send writes.
+	TextMessage MessageType = websocket.TextMessage
+	// BinaryMessage is a binary frame, for protobuf or audio payloads.
+	BinaryMessage MessageType = websocket.BinaryMessage
+)
+
+// SenderHandler is an optional interface for a Handler that wants write
+// acknowledgements. When a Handler implements it, the bridge calls
+// OnConnectSender instead of OnConnect on every new connection.
+type SenderHandler interface {
+	OnConnectSender(s *Sender)
+}
+
+// SendErrorHandler is an optional interface for a Handler: OnSendError is
+// called with every message passed to Send that was not written, because
+// the write failed or because the connection closed with it still queued.
+// Messages passed to SendWithResult report their error to the caller
+// instead. OnSendError runs on the goroutine that writes to the
+// connection, so it must not wait for a Send to return.
+type SendErrorHandler interface {
+	OnSendError(message []byte, err error)
+}
+
+// SendMetrics is implemented by Metrics collectors that also count
+// outbound messages. When the Bridge's Metrics implement it, every message
+// queued on a Sender and every message that was not written is counted.
+type SendMetrics interface {
+	IncMessagesQueued()
+	IncMessagesDropped()
+}
+
+// Sender writes messages to one WebSocket connection, in order, through a
+// queue of WithSendQueueDepth messages. It is safe for concurrent use and
+// stops accepting messages once the connection closes.
+type Sender struct {
+	queue   chan outbound
+	done    <-chan struct{}
+	onError func(message []byte, err error)
+	metrics SendMetrics
+	depth   QueueMetrics
+
+	mu      sync.Mutex
+	closed  bool
+	pending sync.WaitGroup
+	// sealed is closed by seal, waking senders blocked on a full queue.
+	sealed   chan struct{}
+	sealOnce sync.Once
+}
+
+type outbound struct {
+	msgType MessageType
+	message []byte
+	result  chan error // nil for Send
+}
+
+func newSender(depth int, done <-chan struct{}, handler Handler, metrics Metrics) *Sender {
+	s := &Sender{queue: make(chan outbound, depth), done: done, sealed: make(chan struct{})}
+	if h, ok := handler.(SendErrorHandler); ok {
+		s.onError = h.OnSendError
+	}
+	if m, ok := metrics.(SendMetrics); ok {
+		s.metrics = m
+	}
+	if m, ok := metrics.(QueueMetrics); ok {
+		s.depth = m
+	}
+	return s
+}
+
+// Send queues message, blocking while the queue is full, and returns once
+// it is queued. A write that fails later goes to the Handler's OnSendError.
+// It returns ErrConnectionClosed if the connection closes first.
+func (s *Sender) Send(message []byte) error {
+	return s.enqueue(outbound{msgType: TextMessage, message: message})
+}
+
+// SendWithResult queues message and blocks until it is written to the
+// connection or fails. It returns the write error, or ErrConnectionClosed
+// if the connection closed before the message was written.
+func (s *Sender) SendWithResult(message []byte) error {
+	return s.SendMessageWithResult(TextMessage, message)
+}
+
+// SendMessage is Send for a message of type t.
+func (s *Sender) SendMessage(t MessageType, message []byte) error {
+	if !t.valid() {
+		return ErrInvalidMessageType
+	}
+	return s.enqueue(outbound{msgType: t, message: message})
+}
+
+// SendMessageWithResult is SendWithResult for a message of type t.
+func (s *Sender) SendMessageWithResult(t MessageType, message []byte) error {
+	if !t.valid() {
+		return ErrInvalidMessageType
+	}
+	result := make(chan error, 1)
+	if err := s.enqueue(outbound{msgType: t, message: message, result: result}); err != nil {
+		return err
+	}
+	return <-result
+}
+
+func (t MessageType) valid() bool {
+	return t == TextMessage || t == BinaryMessage
+}
+
+func (s *Sender) enqueue(m outbound) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return ErrConnectionClosed
+	}
+	s.pending.Add(1)
+	s.mu.Unlock()
+	defer s.pending.Done()
+
+	select {
+	case s.queue <- m:
+		s.queued()
+		return nil
+	case <-s.done:
+		return ErrConnectionClosed
+	case <-s.sealed:
+		return ErrConnectionClosed
+	}
+}
+
+// offer queues m if there is room, without blocking; the bridge uses it
+// for its own messages, such as heartbeats.
+func (s *Sender) offer(m outbound) bool {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return false
+	}
+	s.pending.Add(1)
+	s.mu.Unlock()
+	defer s.pending.Done()
+
+	select {
+	case s.queue <- m:
+		s.queued()
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *Sender) queued() {
+	if s.metrics != nil {
+		s.metrics.IncMessagesQueued()
+	}
+	if s.depth != nil {
+		s.depth.AddSendQueueDepth(1)
+	}
+}
+
+// finish reports the outcome of writing m, which has left the queue.
+func (s *Sender) finish(m outbound, err error) {
+	if s.depth != nil {
+		s.depth.AddSendQueueDepth(-1)
+	}
+	if m.result != nil {
+		m.result <- err
+	}
+	if err == nil {
+		return
+	}
+	if s.metrics != nil {
+		s.metrics.IncMessagesDropped()
+	}
+	if m.result == nil && s.onError != nil {
+		s.onError(m.message, err)
+	}
+}
+
+// seal rejects further messages and wakes the senders blocked on a full
+// queue. The returned channel is closed once every enqueue in flight has
+// returned; from then on the queue only shrinks. Until then the caller
+// keeps draining the queue, since a sender may still be putting a message
+// on it.
+func (s *Sender) seal() <-chan struct{} {
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+	s.sealOnce.Do(func() { close(s.sealed) })
+	idle := make(chan struct{})
+	go func() {
+		s.pending.Wait()
+		close(idle)
+	}()
+	return idle
+}
+
+// close rejects further messages and fails those still queued. It is
+// called by the write pump once done is closed.
+func (s *Sender) close() {
+	<-s.seal() // done is closed, so no enqueue stays blocked
+	for {
+		select {
+		case m := <-s.queue:
+			s.finish(m, ErrConnectionClosed)
+		default:
+			return
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package bridge
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// senderHandler is a mockHandler that takes the connection's Sender.
+type senderHandler struct {
+	mockHandler
+	onConnectSender func(s *Sender)
+	mu              sync.Mutex
+	sendErrs        []error
+}
+
+func (h *senderHandler) OnConnectSender(s *Sender) { h.onConnectSender(s) }
+
+func (h *senderHandler) OnSendError(message []byte, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.sendErrs = append(h.sendErrs, err)
+}
+
+// sendMetrics records the message counters on top of mockMetrics.
+type sendMetrics struct {
+	mockMetrics
+	queued, dropped int32
+}
+
+func (m *sendMetrics) IncMessagesQueued()  { atomic.AddInt32(&m.queued, 1) }
+func (m *sendMetrics) IncMessagesDropped() { atomic.AddInt32(&m.dropped, 1) }
+
+func TestSender_SendWithResult(t *testing.T) {
+	t.Parallel()
+	received := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		_, msg, err := conn.ReadMessage()
+		if err == nil {
+			received <- string(msg)
+		}
+		conn.Close() // end the session
+	}))
+	defer server.Close()
+
+	sent := make(chan error, 1)
+	senders := make(chan *Sender, 1)
+	handler := &senderHandler{onConnectSender: func(s *Sender) {
+		sent <- s.SendWithResult([]byte("hello"))
+		senders <- s
+	}}
+	metrics := &sendMetrics{}
+	b := New(validTokenProvider(), WithMetrics(metrics), WithRetryPolicy(RetryPolicy{Budget: RetryBudget{MaxAttempts: 1}}),
+		WithLogger(&testLogger{t: t}))
+	err := b.MaintainWebSocket(t.Context(), "conn-1", "ws"+server.URL[4:], handler)
+	if !errors.Is(err, ErrRetryBudgetExhausted) {
+		t.Fatalf("MaintainWebSocket = %v, want ErrRetryBudgetExhausted", err)
+	}
+
+	if err := <-sent; err != nil {
+		t.Fatalf("SendWithResult = %v, want nil once written", err)
+	}
+	if got := <-received; got != "hello" {
+		t.Errorf("server received %q", got)
+	}
+	if err := (<-senders).Send([]byte("late")); !errors.Is(err, ErrConnectionClosed) {
+		t.Errorf("Send after close = %v, want ErrConnectionClosed", err)
+	}
+	if atomic.LoadInt32(&metrics.queued) != 1 || atomic.LoadInt32(&metrics.dropped) != 0 {
+		t.Errorf("queued=%d dropped=%d, want 1 and 0", metrics.queued, metrics.dropped)
+	}
+}
+
+func TestSender_CloseFailsQueuedMessages(t *testing.T) {
+	t.Parallel()
+	done := make(chan struct{})
+	handler := &senderHandler{}
+	metrics := &sendMetrics{}
+	s := newSender(4, done, handler, metrics)
+
+	if err := s.Send([]byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Send([]byte("b")); err != nil {
+		t.Fatal(err)
+	}
+	result := make(chan error, 1)
+	go func() { result <- s.SendWithResult([]byte("c")) }()
+	waitFor(t, "3 queued", func() bool { return atomic.LoadInt32(&metrics.queued) == 3 })
+
+	close(done)
+	s.close()
+
+	if err := <-result; !errors.Is(err, ErrConnectionClosed) {
+		t.Errorf("SendWithResult = %v, want ErrConnectionClosed", err)
+	}
+	handler.mu.Lock()
+	defer handler.mu.Unlock()
+	if len(handler.sendErrs) != 2 || !errors.Is(handler.sendErrs[0], ErrConnectionClosed) {
+		t.Errorf("OnSendError got %v, want 2 x ErrConnectionClosed", handler.sendErrs)
+	}
+	if atomic.LoadInt32(&metrics.dropped) != 3 {
+		t.Errorf("dropped = %d, want 3", metrics.dropped)
+	}
+	if err := s.Send([]byte("d")); !errors.Is(err, ErrConnectionClosed) {
+		t.Errorf("Send after close = %v, want ErrConnectionClosed", err)
+	}
+}
+
+// binaryHandler records text and binary messages separately.
+type binaryHandler struct {
+	senderHandler
+	text, binary chan []byte
+}
+
+func (h *binaryHandler) OnMessage(message []byte)       { h.text <- message }
+func (h *binaryHandler) OnBinaryMessage(message []byte) { h.binary <- message }
+
+func TestSender_BinaryMessages(t *testing.T) {
+	t.Parallel()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for { // echo each frame with its type
+			mt, msg, err := conn.ReadMessage()
+			if err != nil || conn.WriteMessage(mt, msg) != nil {
+				return
+			}
+		}
+	}))
+	defer server.Close()
+
+	sendErrs := make(chan error, 3)
+	handler := &binaryHandler{text: make(chan []byte, 1), binary: make(chan []byte, 1)}
+	handler.onConnectSender = func(s *Sender) {
+		sendErrs <- s.SendMessageWithResult(BinaryMessage, []byte{0x00, 0xff})
+		sendErrs <- s.SendMessage(TextMessage, []byte("text"))
+		sendErrs <- s.SendMessage(MessageType(9), []byte("ping?"))
+	}
+	b := New(validTokenProvider(), WithLogger(&testLogger{t: t}))
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	go b.MaintainWebSocket(ctx, "conn-1", "ws"+server.URL[4:], handler)
+
+	for i, want := range []error{nil, nil, ErrInvalidMessageType} {
+		if err := <-sendErrs; !errors.Is(err, want) {
+			t.Errorf("send %d = %v, want %v", i, err, want)
+		}
+	}
+	if got := <-handler.binary; !bytes.Equal(got, []byte{0x00, 0xff}) {
+		t.Errorf("OnBinaryMessage got %v", got)
+	}
+	if got := <-handler.text; string(got) != "text" {
+		t.Errorf("OnMessage got %q", got)
+	}
+}
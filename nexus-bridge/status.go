@@ -0,0 +1,145 @@
+package bridge
+
+import (
+	"sync"
+	"time"
+)
+
+// Phase is the stage of a connection's lifecycle.
+type Phase string
+
+const (
+	// PhaseConnecting: the first connection attempt has not finished.
+	PhaseConnecting Phase = "connecting"
+	// PhaseConnected: the connection is established.
+	PhaseConnected Phase = "connected"
+	// PhaseReconnecting: an attempt failed or the connection dropped; the
+	// next attempt starts at NextRetry.
+	PhaseReconnecting Phase = "reconnecting"
+	// PhaseClosed: the bridge stopped maintaining the connection.
+	PhaseClosed Phase = "closed"
+)
+
+// ConnectionState is a connection's state as published on Bridge.Status.
+type ConnectionState struct {
+	ConnectionID string
+	Phase        Phase
+	// At is when the connection entered this state.
+	At time.Time
+	// Attempt counts the failed attempts in a row (PhaseReconnecting).
+	Attempt int
+	// NextRetry is when the next attempt starts (PhaseReconnecting).
+	NextRetry time.Time
+	// Err is why the last attempt failed (PhaseReconnecting) or why the
+	// bridge stopped (PhaseClosed): a permanent error, the context's error,
+	// or nil when a gRPC run function returned nil.
+	Err error
+}
+
+// statusBuffer is how many states a subscriber may fall behind; when it is
+// full the oldest pending state is dropped, so a slow reader always sees
+// the latest one.
+const statusBuffer = 8
+
+// closedStateTTL is how long a closed connection's final state is kept for
+// late subscribers. After that the connection is forgotten, so a bridge
+// that maintains many short-lived connections does not keep them all.
+const closedStateTTL = time.Minute
+
+// statusHub fans connection states out to subscribers.
+type statusHub struct {
+	mu     sync.Mutex
+	latest map[string]ConnectionState
+	subs   map[string][]chan ConnectionState
+	// pruned is when latest was last swept for expired closed states.
+	pruned time.Time
+}
+
+func newStatusHub() *statusHub {
+	return &statusHub{latest: map[string]ConnectionState{}, subs: map[string][]chan ConnectionState{}}
+}
+
+// Status returns a channel of connectionID's states, starting with the
+// current one if the bridge has seen the connection. The channel is closed
+// after a PhaseClosed state; subscribe again to follow a connection that
+// is maintained anew. A closed connection's final state is delivered to
+// subscribers for a minute after it closed; later ones wait for it to be
+// maintained again. A reader that falls behind misses intermediate
+// states but always receives the latest.
+func (b *Bridge) Status(connectionID string) <-chan ConnectionState {
+	return b.status.subscribe(connectionID)
+}
+
+func (h *statusHub) subscribe(connectionID string) <-chan ConnectionState {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.prune(time.Now())
+	ch := make(chan ConnectionState, statusBuffer)
+	latest, seen := h.latest[connectionID]
+	if seen && !expired(latest, time.Now()) {
+		ch <- latest
+		if latest.Phase == PhaseClosed {
+			close(ch)
+			return ch
+		}
+	}
+	h.subs[connectionID] = append(h.subs[connectionID], ch)
+	return ch
+}
+
+func (h *statusHub) publish(s ConnectionState) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.prune(time.Now())
+	h.latest[s.ConnectionID] = s
+	subs := h.subs[s.ConnectionID]
+	for _, ch := range subs {
+		select {
+		case ch <- s:
+		default:
+			// Full: drop the oldest state to make room for this one.
+			select {
+			case <-ch:
+			default:
+			}
+			ch <- s
+		}
+		if s.Phase == PhaseClosed {
+			close(ch)
+		}
+	}
+	if s.Phase == PhaseClosed {
+		delete(h.subs, s.ConnectionID)
+	}
+}
+
+// prune forgets the connections that closed more than closedStateTTL ago.
+// It sweeps at most once per closedStateTTL, so a closed state may outlive
+// it by as much again.
+func (h *statusHub) prune(now time.Time) {
+	if now.Sub(h.pruned) < closedStateTTL {
+		return
+	}
+	h.pruned = now
+	for id, s := range h.latest {
+		if expired(s, now) {
+			delete(h.latest, id)
+		}
+	}
+}
+
+// expired reports whether s is a closed state older than closedStateTTL.
+func expired(s ConnectionState, now time.Time) bool {
+	return s.Phase == PhaseClosed && now.Sub(s.At) > closedStateTTL
+}
+
+// setState publishes connectionID's new state.
+func (b *Bridge) setState(s ConnectionState) {
+	if s.At.IsZero() {
+		s.At = time.Now()
+	}
+	b.status.publish(s)
+	if b.stateChanged != nil {
+		b.stateChanged(s)
+	}
+}
@@ -0,0 +1,175 @@
+package bridge
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-bridge/pkg/auth"
+)
+
+func validTokenProvider() *mockTokenProvider {
+	return &mockTokenProvider{
+		getTokenFunc: func(ctx context.Context, connectionID string) (*auth.Token, error) {
+			return &auth.Token{
+				Strategy:    auth.AuthStrategy{Type: "oauth2"},
+				Credentials: auth.Credentials{"access_token": "tok"},
+				ExpiresAt:   time.Now().Add(time.Hour).Unix(),
+			}, nil
+		},
+	}
+}
+
+// nextState receives from ch or fails the test after a timeout.
+func nextState(t *testing.T, ch <-chan ConnectionState) ConnectionState {
+	t.Helper()
+	select {
+	case s, ok := <-ch:
+		if !ok {
+			t.Fatal("status channel closed early")
+		}
+		return s
+	case <-time.After(3 * time.Second):
+		t.Fatal("timed out waiting for a connection state")
+	}
+	return ConnectionState{}
+}
+
+func TestStatus_WebSocketLifecycle(t *testing.T) {
+	t.Parallel()
+	var dials int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		if atomic.AddInt32(&dials, 1) == 1 {
+			return // drop the first session
+		}
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	b := New(validTokenProvider(), WithRetryPolicy(grpcRetryPolicy()), WithLogger(&testLogger{t: t}))
+	status := b.Status("conn-1")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- b.MaintainWebSocket(ctx, "conn-1", "ws"+server.URL[4:], &mockHandler{}) }()
+
+	if s := nextState(t, status); s.Phase != PhaseConnecting || s.ConnectionID != "conn-1" || s.At.IsZero() {
+		t.Fatalf("first state = %+v, want connecting", s)
+	}
+	if s := nextState(t, status); s.Phase != PhaseConnected {
+		t.Fatalf("second state = %+v, want connected", s)
+	}
+	s := nextState(t, status)
+	if s.Phase != PhaseReconnecting || s.Attempt != 1 || s.Err == nil || !s.NextRetry.After(s.At) {
+		t.Fatalf("third state = %+v, want reconnecting attempt 1 with an error and a retry time", s)
+	}
+	if s := nextState(t, status); s.Phase != PhaseConnected {
+		t.Fatalf("fourth state = %+v, want connected", s)
+	}
+
+	cancel()
+	<-done
+	if s := nextState(t, status); s.Phase != PhaseClosed || !errors.Is(s.Err, context.Canceled) {
+		t.Fatalf("last state = %+v, want closed with context.Canceled", s)
+	}
+	if _, ok := <-status; ok {
+		t.Fatal("status channel not closed after PhaseClosed")
+	}
+
+	// A late subscriber gets the final state and a closed channel.
+	late := b.Status("conn-1")
+	if s := nextState(t, late); s.Phase != PhaseClosed {
+		t.Fatalf("late state = %+v, want closed", s)
+	}
+	if _, ok := <-late; ok {
+		t.Fatal("late status channel not closed")
+	}
+}
+
+func TestStatus_GRPCCleanExit(t *testing.T) {
+	t.Parallel()
+	b := New(validTokenProvider(), WithRetryPolicy(grpcRetryPolicy()), WithLogger(&testLogger{t: t}))
+	status := b.Status("conn-1")
+
+	run := func(ctx context.Context, conn *grpc.ClientConn) error { return nil }
+	if err := b.MaintainGRPCConnection(context.Background(), "conn-1", "passthrough:///localhost:0",
+		run, grpc.WithTransportCredentials(insecure.NewCredentials())); err != nil {
+		t.Fatalf("MaintainGRPCConnection: %v", err)
+	}
+
+	var phases []Phase
+	var last ConnectionState
+	for s := range status {
+		phases = append(phases, s.Phase)
+		last = s
+	}
+	want := []Phase{PhaseConnecting, PhaseConnected, PhaseClosed}
+	if len(phases) != len(want) {
+		t.Fatalf("phases = %v, want %v", phases, want)
+	}
+	for i := range want {
+		if phases[i] != want[i] {
+			t.Fatalf("phases = %v, want %v", phases, want)
+		}
+	}
+	if last.Err != nil {
+		t.Errorf("closed Err = %v, want nil", last.Err)
+	}
+}
+
+func TestStatusHub_SlowReaderGetsLatest(t *testing.T) {
+	t.Parallel()
+	h := newStatusHub()
+	ch := h.subscribe("c")
+	for i := 1; i <= statusBuffer+3; i++ {
+		h.publish(ConnectionState{ConnectionID: "c", Phase: PhaseReconnecting, Attempt: i})
+	}
+	var last ConnectionState
+	for n := 0; n < statusBuffer; n++ {
+		last = <-ch
+	}
+	if last.Attempt != statusBuffer+3 {
+		t.Errorf("last attempt = %d, want %d", last.Attempt, statusBuffer+3)
+	}
+}
+
+func TestStatusHub_ForgetsClosedConnections(t *testing.T) {
+	t.Parallel()
+	h := newStatusHub()
+	now := time.Now()
+	h.publish(ConnectionState{ConnectionID: "old", Phase: PhaseClosed, At: now.Add(-2 * closedStateTTL)})
+	h.publish(ConnectionState{ConnectionID: "recent", Phase: PhaseClosed, At: now})
+	h.publish(ConnectionState{ConnectionID: "live", Phase: PhaseConnected, At: now.Add(-2 * closedStateTTL)})
+
+	// A late subscriber to a long-closed connection waits for it anew.
+	select {
+	case s := <-h.subscribe("old"):
+		t.Fatalf("expired state delivered: %+v", s)
+	default:
+	}
+	if s, ok := <-h.subscribe("recent"); !ok || s.Phase != PhaseClosed {
+		t.Fatalf("recent state = %+v, %v; want closed", s, ok)
+	}
+
+	h.pruned = time.Time{}
+	h.publish(ConnectionState{ConnectionID: "live", Phase: PhaseReconnecting, At: now})
+	if _, ok := h.latest["old"]; ok {
+		t.Error("expired closed state kept")
+	}
+	if _, ok := h.latest["recent"]; !ok {
+		t.Error("recent closed state dropped")
+	}
+}
@@ -1,6 +1,9 @@
 package telemetry
 
 import (
+	"strconv"
+	"time"
+
 	"github.com/prometheus/client_golang/prometheus"
 )
 
@@ -10,6 +13,14 @@ type PromMetrics struct {
 	disconnects    prometheus.Counter
 	tokenRefreshes prometheus.Counter
 	connStatus     prometheus.Gauge
+	poolConns      *prometheus.GaugeVec
+	msgsQueued     prometheus.Counter
+	msgsDropped    prometheus.Counter
+	credFetches    *prometheus.HistogramVec
+	connects       *prometheus.HistogramVec
+	sessions       prometheus.Histogram
+	closes         *prometheus.CounterVec
+	queueDepth     prometheus.Gauge
 }
 
 // NewMetrics creates and registers standard bridge metrics.
@@ -44,12 +55,71 @@ func NewMetrics(registry prometheus.Registerer, agentLabels map[string]string) *
 			Help:        "Current status of the connection (1 = connected, 0 = disconnected).",
 			ConstLabels: agentLabels,
 		}),
+		poolConns: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace:   "bridge",
+			Name:        "pool_connections",
+			Help:        "Connections maintained by a bridge.Pool, by state.",
+			ConstLabels: agentLabels,
+		}, []string{"state"}),
+		msgsQueued: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace:   "bridge",
+			Name:        "messages_queued_total",
+			Help:        "Total number of outbound WebSocket messages queued for writing.",
+			ConstLabels: agentLabels,
+		}),
+		msgsDropped: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace:   "bridge",
+			Name:        "messages_dropped_total",
+			Help:        "Total number of outbound WebSocket messages that were not written.",
+			ConstLabels: agentLabels,
+		}),
+		credFetches: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace:   "bridge",
+			Name:        "credential_fetch_duration_seconds",
+			Help:        "Duration of token fetches for gRPC credentials, by result (ok or error).",
+			ConstLabels: agentLabels,
+			Buckets:     prometheus.DefBuckets,
+		}, []string{"result"}),
+		connects: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace:   "bridge",
+			Name:        "connect_duration_seconds",
+			Help:        "Duration of WebSocket dials including the handshake, by result (ok or error).",
+			ConstLabels: agentLabels,
+			Buckets:     prometheus.DefBuckets,
+		}, []string{"result"}),
+		sessions: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace:   "bridge",
+			Name:        "session_duration_seconds",
+			Help:        "How long WebSocket connections stayed up.",
+			ConstLabels: agentLabels,
+			Buckets:     []float64{1, 10, 60, 300, 900, 3600, 4 * 3600, 12 * 3600, 24 * 3600},
+		}),
+		closes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   "bridge",
+			Name:        "closes_total",
+			Help:        "Total number of WebSocket sessions ended, by close code (none if no close frame was exchanged).",
+			ConstLabels: agentLabels,
+		}, []string{"code"}),
+		queueDepth: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   "bridge",
+			Name:        "send_queue_depth",
+			Help:        "Outbound WebSocket messages waiting to be written, over all connections.",
+			ConstLabels: agentLabels,
+		}),
 	}
 
 	registry.MustRegister(m.connections)
 	registry.MustRegister(m.disconnects)
 	registry.MustRegister(m.tokenRefreshes)
 	registry.MustRegister(m.connStatus)
+	registry.MustRegister(m.poolConns)
+	registry.MustRegister(m.msgsQueued)
+	registry.MustRegister(m.msgsDropped)
+	registry.MustRegister(m.credFetches)
+	registry.MustRegister(m.connects)
+	registry.MustRegister(m.sessions)
+	registry.MustRegister(m.closes)
+	registry.MustRegister(m.queueDepth)
 
 	return m
 }
@@ -69,3 +139,51 @@ func (m *PromMetrics) IncTokenRefreshes() {
 func (m *PromMetrics) SetConnectionStatus(status float64) {
 	m.connStatus.Set(status)
 }
+
+// SetPoolConnections implements bridge.PoolMetrics.
+func (m *PromMetrics) SetPoolConnections(state string, count int) {
+	m.poolConns.WithLabelValues(state).Set(float64(count))
+}
+
+// IncMessagesQueued implements bridge.SendMetrics.
+func (m *PromMetrics) IncMessagesQueued() {
+	m.msgsQueued.Inc()
+}
+
+// IncMessagesDropped implements bridge.SendMetrics.
+func (m *PromMetrics) IncMessagesDropped() {
+	m.msgsDropped.Inc()
+}
+
+// ObserveCredentialFetch implements bridge.CredentialMetrics.
+func (m *PromMetrics) ObserveCredentialFetch(d time.Duration, err error) {
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	m.credFetches.WithLabelValues(result).Observe(d.Seconds())
+}
+
+// ObserveConnect implements bridge.ConnectionMetrics.
+func (m *PromMetrics) ObserveConnect(d time.Duration, err error) {
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	m.connects.WithLabelValues(result).Observe(d.Seconds())
+}
+
+// ObserveSession implements bridge.ConnectionMetrics.
+func (m *PromMetrics) ObserveSession(d time.Duration, closeCode int) {
+	m.sessions.Observe(d.Seconds())
+	code := "none"
+	if closeCode != 0 {
+		code = strconv.Itoa(closeCode)
+	}
+	m.closes.WithLabelValues(code).Inc()
+}
+
+// AddSendQueueDepth implements bridge.QueueMetrics.
+func (m *PromMetrics) AddSendQueueDepth(delta int) {
+	m.queueDepth.Add(float64(delta))
+}
@@ -1,7 +1,9 @@
 package telemetry
 
 import (
+	"errors"
 	"testing"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 )
@@ -75,3 +77,112 @@ func TestNewMetrics_NilLabels(t *testing.T) {
 		}
 	}
 }
+
+func TestSetPoolConnections(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	m := NewMetrics(registry, nil)
+
+	m.SetPoolConnections("connected", 3)
+	m.SetPoolConnections("closed", 1)
+
+	got := map[string]float64{}
+	metricFamilies, _ := registry.Gather()
+	for _, mf := range metricFamilies {
+		if mf.GetName() != "bridge_pool_connections" {
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			got[m.GetLabel()[0].GetValue()] = m.GetGauge().GetValue()
+		}
+	}
+	if got["connected"] != 3 || got["closed"] != 1 {
+		t.Errorf("bridge_pool_connections = %v", got)
+	}
+}
+
+func TestSendMetrics(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	m := NewMetrics(registry, nil)
+
+	m.IncMessagesQueued()
+	m.IncMessagesQueued()
+	m.IncMessagesDropped()
+
+	got := map[string]float64{}
+	metricFamilies, _ := registry.Gather()
+	for _, mf := range metricFamilies {
+		for _, m := range mf.GetMetric() {
+			got[mf.GetName()] = m.GetCounter().GetValue()
+		}
+	}
+	if got["bridge_messages_queued_total"] != 2 || got["bridge_messages_dropped_total"] != 1 {
+		t.Errorf("counters = %v", got)
+	}
+}
+
+func TestObserveCredentialFetch(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	m := NewMetrics(registry, nil)
+
+	m.ObserveCredentialFetch(20*time.Millisecond, nil)
+	m.ObserveCredentialFetch(time.Second, errors.New("gateway down"))
+	m.ObserveCredentialFetch(time.Second, errors.New("gateway down"))
+
+	got := map[string]uint64{}
+	metricFamilies, _ := registry.Gather()
+	for _, mf := range metricFamilies {
+		if mf.GetName() != "bridge_credential_fetch_duration_seconds" {
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			got[m.GetLabel()[0].GetValue()] = m.GetHistogram().GetSampleCount()
+		}
+	}
+	if got["ok"] != 1 || got["error"] != 2 {
+		t.Errorf("bridge_credential_fetch_duration_seconds counts = %v", got)
+	}
+}
+
+func TestConnectionMetrics(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	m := NewMetrics(registry, nil)
+
+	m.ObserveConnect(50*time.Millisecond, nil)
+	m.ObserveConnect(time.Second, errors.New("connection refused"))
+	m.ObserveSession(time.Minute, 1001)
+	m.ObserveSession(time.Hour, 0)
+	m.AddSendQueueDepth(3)
+	m.AddSendQueueDepth(-1)
+
+	connects := map[string]uint64{}
+	closes := map[string]float64{}
+	var sessions uint64
+	var depth float64
+	metricFamilies, _ := registry.Gather()
+	for _, mf := range metricFamilies {
+		for _, m := range mf.GetMetric() {
+			switch mf.GetName() {
+			case "bridge_connect_duration_seconds":
+				connects[m.GetLabel()[0].GetValue()] = m.GetHistogram().GetSampleCount()
+			case "bridge_session_duration_seconds":
+				sessions = m.GetHistogram().GetSampleCount()
+			case "bridge_closes_total":
+				closes[m.GetLabel()[0].GetValue()] = m.GetCounter().GetValue()
+			case "bridge_send_queue_depth":
+				depth = m.GetGauge().GetValue()
+			}
+		}
+	}
+	if connects["ok"] != 1 || connects["error"] != 1 {
+		t.Errorf("bridge_connect_duration_seconds counts = %v", connects)
+	}
+	if sessions != 2 {
+		t.Errorf("bridge_session_duration_seconds count = %d, want 2", sessions)
+	}
+	if closes["1001"] != 1 || closes["none"] != 1 {
+		t.Errorf("bridge_closes_total = %v", closes)
+	}
+	if depth != 2 {
+		t.Errorf("bridge_send_queue_depth = %v, want 2", depth)
+	}
+}
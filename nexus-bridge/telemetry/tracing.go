@@ -0,0 +1,124 @@
+package telemetry
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/Prescott-Data/nexus-framework/nexus-bridge"
+
+// Tracer implements the bridge.Tracer interface using OpenTelemetry.
+type Tracer struct {
+	tracer trace.Tracer
+}
+
+// NewTracer creates a Tracer that starts spans from provider.
+// If provider is nil, it uses the global TracerProvider.
+func NewTracer(provider trace.TracerProvider) *Tracer {
+	if provider == nil {
+		provider = otel.GetTracerProvider()
+	}
+	return &Tracer{tracer: provider.Tracer(instrumentationName)}
+}
+
+// Start begins a span. keysAndValues alternate string keys and values,
+// as for the Logger.
+func (t *Tracer) Start(ctx context.Context, name string, keysAndValues ...interface{}) (context.Context, func(err error)) {
+	ctx, span := t.tracer.Start(ctx, name, trace.WithAttributes(attributes(keysAndValues)...))
+	return ctx, func(err error) {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}
+}
+
+func attributes(keysAndValues []interface{}) []attribute.KeyValue {
+	attrs := make([]attribute.KeyValue, 0, len(keysAndValues)/2)
+	for i := 0; i+1 < len(keysAndValues); i += 2 {
+		key := fmt.Sprint(keysAndValues[i])
+		switch v := keysAndValues[i+1].(type) {
+		case string:
+			attrs = append(attrs, attribute.String(key, v))
+		case int:
+			attrs = append(attrs, attribute.Int(key, v))
+		case int64:
+			attrs = append(attrs, attribute.Int64(key, v))
+		case bool:
+			attrs = append(attrs, attribute.Bool(key, v))
+		default:
+			attrs = append(attrs, attribute.String(key, fmt.Sprint(v)))
+		}
+	}
+	return attrs
+}
+
+// TracingConfigured reports whether an OTLP exporter endpoint is set in
+// the environment, through OTEL_EXPORTER_OTLP_ENDPOINT or
+// OTEL_EXPORTER_OTLP_TRACES_ENDPOINT.
+func TracingConfigured() bool {
+	return os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != "" ||
+		os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") != ""
+}
+
+var (
+	tracingMu sync.Mutex
+	provider  *sdktrace.TracerProvider
+)
+
+// StartTracing installs a TracerProvider that batches spans to an OTLP/HTTP
+// exporter as the global provider. The exporter is configured by the
+// standard OTEL_EXPORTER_OTLP_* environment variables, and agentLabels are
+// added to the resource attributes. Calling it again is a no-op; call
+// ShutdownTracing before exit to flush buffered spans.
+func StartTracing(ctx context.Context, agentLabels map[string]string) error {
+	tracingMu.Lock()
+	defer tracingMu.Unlock()
+	if provider != nil {
+		return nil
+	}
+
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to create OTLP trace exporter: %w", err)
+	}
+	labels := make([]attribute.KeyValue, 0, len(agentLabels))
+	for k, v := range agentLabels {
+		labels = append(labels, attribute.String(k, v))
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(labels...))
+	if err != nil {
+		return fmt.Errorf("failed to build trace resource: %w", err)
+	}
+
+	provider = sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+	return nil
+}
+
+// ShutdownTracing flushes and stops the TracerProvider installed by
+// StartTracing, if any.
+func ShutdownTracing(ctx context.Context) error {
+	tracingMu.Lock()
+	p := provider
+	provider = nil
+	tracingMu.Unlock()
+	if p == nil {
+		return nil
+	}
+	return p.Shutdown(ctx)
+}
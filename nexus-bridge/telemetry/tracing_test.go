@@ -0,0 +1,78 @@
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTracer(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	tracer := NewTracer(provider)
+
+	ctx, end := tracer.Start(context.Background(), "bridge.websocket.dial", "connection.id", "conn-1", "attempt", 2)
+	_, endChild := tracer.Start(ctx, "bridge.token.fetch")
+	endChild(errors.New("gateway down"))
+	end(nil)
+
+	spans := exporter.GetSpans()
+	if len(spans) != 2 {
+		t.Fatalf("exported %d spans, want 2", len(spans))
+	}
+	child, parent := spans[0], spans[1]
+	if parent.Name != "bridge.websocket.dial" || parent.Status.Code == codes.Error {
+		t.Errorf("parent span = %q with status %v", parent.Name, parent.Status)
+	}
+	want := map[attribute.Key]attribute.Value{
+		"connection.id": attribute.StringValue("conn-1"),
+		"attempt":       attribute.IntValue(2),
+	}
+	for _, kv := range parent.Attributes {
+		if w, ok := want[kv.Key]; ok && w != kv.Value {
+			t.Errorf("attribute %s = %v, want %v", kv.Key, kv.Value.Emit(), w.Emit())
+		}
+		delete(want, kv.Key)
+	}
+	if len(want) != 0 {
+		t.Errorf("missing attributes %v", want)
+	}
+	if child.Parent.SpanID() != parent.SpanContext.SpanID() {
+		t.Error("child span is not parented to the dial span")
+	}
+	if child.Status.Code != codes.Error || child.Status.Description != "gateway down" {
+		t.Errorf("child span status = %v, want the error", child.Status)
+	}
+}
+
+func TestTracingConfigured(t *testing.T) {
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "")
+	t.Setenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "")
+	if TracingConfigured() {
+		t.Error("TracingConfigured() with no endpoint")
+	}
+	t.Setenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "http://localhost:4318/v1/traces")
+	if !TracingConfigured() {
+		t.Error("TracingConfigured() = false with a traces endpoint")
+	}
+}
+
+func TestStartTracing(t *testing.T) {
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "http://127.0.0.1:1")
+	if err := StartTracing(context.Background(), map[string]string{"agent_id": "test-agent"}); err != nil {
+		t.Fatalf("StartTracing: %v", err)
+	}
+	_, end := NewTracer(nil).Start(context.Background(), "bridge.websocket.dial")
+	end(nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 0)
+	defer cancel()
+	ShutdownTracing(ctx) // the collector is unreachable; only check it returns
+	if err := ShutdownTracing(context.Background()); err != nil {
+		t.Errorf("second ShutdownTracing: %v", err)
+	}
+}
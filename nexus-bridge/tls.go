@@ -0,0 +1,68 @@
+package bridge
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+)
+
+// WithTLSConfig sets the TLS configuration for WebSocket (wss://) dials and
+// for gRPC, where it becomes the transport credentials: custom root CAs,
+// ServerName for SNI, MinVersion and so on. cfg is cloned. WithCACert and
+// WithInsecureSkipVerify adjust the configuration set so far, so pass
+// WithTLSConfig before them.
+//
+// A grpc.WithTransportCredentials passed to MaintainGRPCConnection takes
+// precedence for that connection.
+func WithTLSConfig(cfg *tls.Config) Option {
+	return func(b *Bridge) {
+		b.tlsConfig = cfg.Clone()
+	}
+}
+
+// WithCACert trusts the PEM-encoded CA certificates in pem in addition to
+// the system roots, for providers whose certificates a private CA issued.
+// If pem holds no certificate, MaintainWebSocket and MaintainGRPCConnection
+// fail with a *PermanentError.
+func WithCACert(pem []byte) Option {
+	return func(b *Bridge) {
+		cfg := b.tlsConfigForUpdate()
+		if cfg.RootCAs == nil {
+			pool, err := x509.SystemCertPool()
+			if err != nil {
+				pool = x509.NewCertPool()
+			}
+			cfg.RootCAs = pool
+		}
+		if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+			b.configErr = errors.Join(b.configErr, errors.New("bridge: WithCACert: no certificate found in PEM data"))
+		}
+	}
+}
+
+// WithInsecureSkipVerify disables verification of the server's certificate
+// chain and host name. It is meant for development against self-signed
+// endpoints only.
+func WithInsecureSkipVerify() Option {
+	return func(b *Bridge) {
+		b.tlsConfigForUpdate().InsecureSkipVerify = true
+	}
+}
+
+// tlsConfigForUpdate returns the Bridge's TLS configuration, creating it
+// with a TLS 1.2 minimum if no option has set one yet.
+func (b *Bridge) tlsConfigForUpdate() *tls.Config {
+	if b.tlsConfig == nil {
+		b.tlsConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+	return b.tlsConfig
+}
+
+// checkConfig reports an invalid option as a permanent error.
+func (b *Bridge) checkConfig() error {
+	if b.configErr != nil {
+		return NewPermanentError(fmt.Errorf("invalid bridge configuration: %w", b.configErr))
+	}
+	return nil
+}
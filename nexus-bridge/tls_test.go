@@ -0,0 +1,107 @@
+package bridge
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/pem"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// newTLSWebSocketServer starts a wss:// endpoint with a self-signed
+// certificate, returning its URL and the certificate as PEM.
+func newTLSWebSocketServer(t *testing.T, maxVersion uint16) (string, []byte) {
+	t.Helper()
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		conn.Close()
+	}))
+	server.TLS = &tls.Config{MaxVersion: maxVersion}
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	return "wss" + server.URL[5:], certPEM
+}
+
+func TestBridge_TLSOptions(t *testing.T) {
+	t.Parallel()
+	url, certPEM := newTLSWebSocketServer(t, 0)
+	url12, certPEM12 := newTLSWebSocketServer(t, tls.VersionTLS12)
+
+	tests := []struct {
+		name   string
+		url    string
+		opts   []Option
+		wantOK bool
+	}{
+		{"untrusted certificate", url, nil, false},
+		{"private CA", url, []Option{WithCACert(certPEM)}, true},
+		{"insecure skip verify", url, []Option{WithInsecureSkipVerify()}, true},
+		{"min version above server", url12, []Option{WithTLSConfig(&tls.Config{MinVersion: tls.VersionTLS13}), WithCACert(certPEM12)}, false},
+		{"min version met", url12, []Option{WithTLSConfig(&tls.Config{MinVersion: tls.VersionTLS12}), WithCACert(certPEM12)}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := New(validTokenProvider(), tt.opts...)
+			conn, _, err := b.wsDialer().DialContext(t.Context(), tt.url, nil)
+			if err == nil {
+				conn.Close()
+			}
+			if (err == nil) != tt.wantOK {
+				t.Errorf("dial error = %v, want success %v", err, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestBridge_InvalidCACertIsPermanent(t *testing.T) {
+	t.Parallel()
+	b := New(validTokenProvider(), WithCACert([]byte("not a certificate")))
+	err := b.MaintainWebSocket(t.Context(), "conn-1", "wss://example.invalid", &mockHandler{})
+	var permanentErr *PermanentError
+	if !errors.As(err, &permanentErr) {
+		t.Fatalf("MaintainWebSocket = %v, want a *PermanentError", err)
+	}
+}
+
+func TestGRPC_TLSConfig(t *testing.T) {
+	t.Parallel()
+	// Borrow a self-signed certificate from an httptest TLS server.
+	issuer := httptest.NewTLSServer(http.NotFoundHandler())
+	defer issuer.Close()
+	cert := issuer.TLS.Certificates[0]
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: issuer.Certificate().Raw})
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := grpc.NewServer(grpc.Creds(credentials.NewServerTLSFromCert(&cert)))
+	grpc_health_v1.RegisterHealthServer(srv, health.NewServer())
+	go srv.Serve(lis)
+	defer srv.Stop()
+
+	// httptest certificates are issued for example.com and 127.0.0.1.
+	b := New(validTokenProvider(), WithCACert(certPEM), WithRetryPolicy(grpcRetryPolicy()), WithLogger(&testLogger{t: t}))
+	run := func(ctx context.Context, conn *grpc.ClientConn) error {
+		_, err := grpc_health_v1.NewHealthClient(conn).Check(ctx, &grpc_health_v1.HealthCheckRequest{})
+		if err != nil {
+			return NewPermanentError(err)
+		}
+		return nil
+	}
+	if err := b.MaintainGRPCConnection(t.Context(), "conn-1", "passthrough:///"+lis.Addr().String(), run); err != nil {
+		t.Fatalf("MaintainGRPCConnection over TLS: %v", err)
+	}
+}
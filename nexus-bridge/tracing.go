@@ -0,0 +1,45 @@
+package bridge
+
+import (
+	"context"
+	"time"
+)
+
+// Tracer starts spans around the bridge's dials, handshakes and token
+// fetches. telemetry.NewTracer adapts an OpenTelemetry TracerProvider.
+type Tracer interface {
+	// Start begins a span named name, a child of any span in ctx, with the
+	// given attributes. The returned function ends it, recording err if it
+	// is not nil.
+	Start(ctx context.Context, name string, keysAndValues ...interface{}) (context.Context, func(err error))
+}
+
+type nopTracer struct{}
+
+func (nopTracer) Start(ctx context.Context, name string, keysAndValues ...interface{}) (context.Context, func(error)) {
+	return ctx, func(error) {}
+}
+
+// WithTracer sets the Tracer for the Bridge. By default no spans are
+// recorded.
+func WithTracer(tracer Tracer) Option {
+	return func(b *Bridge) {
+		b.tracer = tracer
+	}
+}
+
+// ConnectionMetrics is implemented by Metrics collectors that also time
+// WebSocket connections. When the Bridge's Metrics implement it, every
+// dial and every session that ends is observed. closeCode is the
+// WebSocket close code of the session's end, or 0 when the connection
+// ended without a close frame.
+type ConnectionMetrics interface {
+	ObserveConnect(d time.Duration, err error)
+	ObserveSession(d time.Duration, closeCode int)
+}
+
+// QueueMetrics is implemented by Metrics collectors that also gauge the
+// messages waiting in send queues, summed over all connections.
+type QueueMetrics interface {
+	AddSendQueueDepth(delta int)
+}
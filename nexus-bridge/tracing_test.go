@@ -0,0 +1,144 @@
+package bridge
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+type recordedSpan struct {
+	name  string
+	attrs []interface{}
+	err   error
+}
+
+type recordingTracer struct {
+	mu    sync.Mutex
+	spans []recordedSpan
+}
+
+func (t *recordingTracer) Start(ctx context.Context, name string, keysAndValues ...interface{}) (context.Context, func(error)) {
+	return ctx, func(err error) {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		t.spans = append(t.spans, recordedSpan{name: name, attrs: keysAndValues, err: err})
+	}
+}
+
+func (t *recordingTracer) ended(name string) []recordedSpan {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var spans []recordedSpan
+	for _, s := range t.spans {
+		if s.name == name {
+			spans = append(spans, s)
+		}
+	}
+	return spans
+}
+
+type connectionMetrics struct {
+	mockMetrics
+	mu         sync.Mutex
+	connects   []error
+	closeCodes []int
+	depth      int
+}
+
+func (m *connectionMetrics) ObserveConnect(d time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.connects = append(m.connects, err)
+}
+
+func (m *connectionMetrics) ObserveSession(d time.Duration, closeCode int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.closeCodes = append(m.closeCodes, closeCode)
+}
+
+func (m *connectionMetrics) AddSendQueueDepth(delta int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.depth += delta
+}
+
+func (m *connectionMetrics) snapshot() (connects []error, closeCodes []int, depth int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]error(nil), m.connects...), append([]int(nil), m.closeCodes...), m.depth
+}
+
+func TestBridge_TracingAndConnectionMetrics(t *testing.T) {
+	t.Parallel()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		if _, _, err := conn.ReadMessage(); err != nil {
+			return
+		}
+		conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, "restarting"))
+		conn.ReadMessage() // wait for the bridge to hang up
+	}))
+	defer server.Close()
+
+	tracer := &recordingTracer{}
+	metrics := &connectionMetrics{}
+	var once sync.Once
+	handler := &senderHandler{onConnectSender: func(s *Sender) {
+		once.Do(func() { s.Send([]byte("hello")) })
+	}}
+	b := New(validTokenProvider(), WithTracer(tracer), WithMetrics(metrics), WithRetryPolicy(grpcRetryPolicy()))
+	ctx, cancel := context.WithCancel(t.Context())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		b.MaintainWebSocket(ctx, "conn-1", "ws"+server.URL[4:], handler)
+	}()
+	defer func() { cancel(); <-done }()
+
+	waitFor(t, "a session to end", func() bool {
+		_, closeCodes, _ := metrics.snapshot()
+		return len(closeCodes) >= 1
+	})
+
+	connects, closeCodes, depth := metrics.snapshot()
+	if len(connects) == 0 || connects[0] != nil {
+		t.Errorf("ObserveConnect errors = %v, want a successful dial", connects)
+	}
+	if closeCodes[0] != websocket.CloseGoingAway {
+		t.Errorf("ObserveSession close code = %d, want %d", closeCodes[0], websocket.CloseGoingAway)
+	}
+	if depth != 0 {
+		t.Errorf("send queue depth = %d after the message was written, want 0", depth)
+	}
+	dials := tracer.ended("bridge.websocket.dial")
+	if len(dials) == 0 {
+		t.Fatal("no bridge.websocket.dial span")
+	}
+	if dials[0].err != nil || len(dials[0].attrs) < 2 || dials[0].attrs[1] != "conn-1" {
+		t.Errorf("dial span = %+v, want a successful span for conn-1", dials[0])
+	}
+}
+
+func TestSession_Code(t *testing.T) {
+	var s session
+	if got := s.code(errClosedByCaller); got != websocket.CloseNormalClosure {
+		t.Errorf("code(errClosedByCaller) = %d, want %d", got, websocket.CloseNormalClosure)
+	}
+	if got := s.code(nil); got != 0 {
+		t.Errorf("code(nil) = %d, want 0", got)
+	}
+	s.closeCode.Store(4001)
+	if got := s.code(errClosedByCaller); got != 4001 {
+		t.Errorf("code with a peer close frame = %d, want 4001", got)
+	}
+}
@@ -0,0 +1,10 @@
+// Package api embeds the Broker's OpenAPI specification, which is maintained
+// by hand in openapi.yaml alongside the handlers it describes.
+package api
+
+import _ "embed"
+
+// Spec is the OpenAPI 3.0 document, in YAML.
+//
+//go:embed openapi.yaml
+var Spec []byte
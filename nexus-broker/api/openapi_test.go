@@ -0,0 +1,161 @@
+package api
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+// routes are the API routes registered in cmd/nexus-broker. Operator-only
+// routes (/metrics, /admin, /debug) are not part of the spec.
+var routes = []string{
+	"GET /audit",
+	"GET /audit-events",
+	"GET /auth/callback",
+	"GET /auth/capture-schema",
+	"POST /auth/capture-credential",
+	"GET /auth/delegate",
+	"POST /auth/consent-spec",
+	"GET /providers",
+	"POST /providers",
+	"POST /providers/validate",
+	"GET /providers/metadata",
+	"GET /providers/by-name/{name}",
+	"DELETE /providers/by-name/{name}",
+	"GET /providers/{id}",
+	"PUT /providers/{id}",
+	"PATCH /providers/{id}",
+	"DELETE /providers/{id}",
+	"GET /providers/{id}/audit",
+	"GET /providers/{id}/registration",
+	"POST /providers/{id}/registration/rotate",
+	"POST /providers/{id}/restore",
+	"DELETE /providers/{id}/purge",
+	"GET /connections/stale",
+	"GET /connections/{connectionID}",
+	"GET /connections/{connectionID}/status",
+	"GET /connections/{connectionID}/token",
+	"POST /connections/{connectionID}/refresh",
+	"POST /connections/{connectionID}/exchange",
+	"POST /connections/{connectionID}/grants",
+	"POST /grants/redeem",
+	"POST /connections/{connectionID}/revoke",
+	"POST /connections/{connectionID}/suspend",
+	"POST /connections/{connectionID}/resume",
+	"POST /connections/{connectionID}/reauthorize",
+	"GET /workspaces/{workspaceID}/users/{user}/connections",
+	"POST /workspaces/{workspaceID}/users/{user}/deprovision",
+	"GET /health",
+	"GET /version",
+	"GET /healthz",
+	"GET /readyz",
+	"GET /openapi.json",
+	"GET /docs",
+}
+
+type operation struct {
+	Parameters []parameter    `yaml:"parameters"`
+	Responses  map[string]any `yaml:"responses"`
+}
+
+type parameter struct {
+	In   string `yaml:"in"`
+	Name string `yaml:"name"`
+}
+
+func loadSpec(t *testing.T) (map[string]any, map[string]map[string]operation) {
+	t.Helper()
+	var doc map[string]any
+	if err := yaml.Unmarshal(Spec, &doc); err != nil {
+		t.Fatalf("spec does not parse: %v", err)
+	}
+	var typed struct {
+		Paths map[string]map[string]operation `yaml:"paths"`
+	}
+	if err := yaml.Unmarshal(Spec, &typed); err != nil {
+		t.Fatalf("paths do not parse: %v", err)
+	}
+	return doc, typed.Paths
+}
+
+func TestSpec_IsValid(t *testing.T) {
+	doc, paths := loadSpec(t)
+	if v, _ := doc["openapi"].(string); !strings.HasPrefix(v, "3.0.") {
+		t.Fatalf("expected an OpenAPI 3.0 document, got %q", v)
+	}
+
+	pathParam := regexp.MustCompile(`\{([^}]+)\}`)
+	for path, ops := range paths {
+		for method, op := range ops {
+			if len(op.Responses) == 0 {
+				t.Errorf("%s %s has no responses", method, path)
+			}
+			declared := map[string]bool{}
+			for _, p := range op.Parameters {
+				if p.In == "path" {
+					declared[p.Name] = true
+				}
+			}
+			for _, m := range pathParam.FindAllStringSubmatch(path, -1) {
+				if !declared[m[1]] {
+					t.Errorf("%s %s does not declare path parameter %s", method, path, m[1])
+				}
+			}
+		}
+	}
+
+	var walk func(v any)
+	walk = func(v any) {
+		switch v := v.(type) {
+		case map[string]any:
+			if ref, ok := v["$ref"].(string); ok && resolve(doc, ref) == nil {
+				t.Errorf("unresolved $ref %s", ref)
+			}
+			for _, e := range v {
+				walk(e)
+			}
+		case []any:
+			for _, e := range v {
+				walk(e)
+			}
+		}
+	}
+	walk(doc)
+}
+
+func TestSpec_CoversRoutes(t *testing.T) {
+	_, paths := loadSpec(t)
+	want := map[string]bool{}
+	for _, route := range routes {
+		want[route] = true
+		method, path, _ := strings.Cut(route, " ")
+		if _, ok := paths[path][strings.ToLower(method)]; !ok {
+			t.Errorf("%s is not documented", route)
+		}
+	}
+	for path, ops := range paths {
+		for method := range ops {
+			if route := strings.ToUpper(method) + " " + path; !want[route] {
+				t.Errorf("%s is documented but not routed", route)
+			}
+		}
+	}
+}
+
+// resolve follows a local JSON pointer such as #/components/schemas/Name.
+func resolve(doc map[string]any, ref string) any {
+	if !strings.HasPrefix(ref, "#/") {
+		return nil
+	}
+	var cur any = doc
+	for _, part := range strings.Split(ref[2:], "/") {
+		m, ok := cur.(map[string]any)
+		if !ok {
+			return nil
+		}
+		cur = m[strings.ReplaceAll(strings.ReplaceAll(part, "~1", "/"), "~0", "~")]
+	}
+	return cur
+}
@@ -0,0 +1,65 @@
+// Command cleanup runs the broker's retention sweep once, using the same
+// RETENTION_* settings as the broker. With -dry-run it reports how many rows
+// each policy would delete without changing anything.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/lib/pq"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/internal/store"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/config"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/retention"
+)
+
+func main() {
+	configPath := flag.String("config", os.Getenv("CONFIG_FILE"), "optional YAML config file")
+	dryRun := flag.Bool("dry-run", false, "count rows that would be deleted without deleting them")
+	flag.Parse()
+
+	dsn, rc, err := config.LoadRetention(*configPath)
+	if err != nil {
+		log.Fatalf("config: %v", err)
+	}
+
+	if backend := store.BackendOf(dsn); backend != store.BackendPostgres {
+		log.Fatalf("retention sweeps need Postgres, not %s", backend)
+	}
+	db, err := sqlx.Connect("postgres", dsn)
+	if err != nil {
+		log.Fatalf("connect: %v", err)
+	}
+	defer db.Close()
+
+	res, err := retention.Sweep(context.Background(), db, retention.Policy{
+		FailedConnectionTTL:  rc.FailedConnections,
+		ExpiredConnectionTTL: rc.ExpiredConnections,
+		AuditEventTTL:        rc.AuditEvents,
+	}, *dryRun)
+
+	verb := "deleted"
+	if *dryRun {
+		verb = "would delete"
+	}
+	kinds := make([]string, 0, len(res))
+	for kind := range res {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	for _, kind := range kinds {
+		fmt.Printf("%s %d %s\n", verb, res[kind], kind)
+	}
+	if len(kinds) == 0 {
+		fmt.Println("all retention TTLs are 0; nothing to do")
+	}
+	if err != nil {
+		log.Fatalf("sweep: %v", err)
+	}
+}
@@ -0,0 +1,118 @@
+// Command migrate-provider-secrets encrypts provider secrets written before
+// they were sealed at rest: client secrets and the defaults of secret
+// credential_schema properties. It is safe to re-run: values that are
+// already sealed are skipped, and the broker reads both forms, so it can run
+// while the broker is serving.
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/config"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/provider"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/vault"
+	_ "github.com/lib/pq"
+)
+
+func main() {
+	dsn := flag.String("dsn", "", "Postgres DSN (overrides DATABASE_URL)")
+	dryRun := flag.Bool("dry-run", false, "count providers with plaintext secrets without writing")
+	flag.Parse()
+
+	url := *dsn
+	if url == "" {
+		url = os.Getenv("DATABASE_URL")
+	}
+	if url == "" {
+		log.Fatal("DATABASE_URL or -dsn is required")
+	}
+	key, err := config.ValidateKey("ENCRYPTION_KEY", os.Getenv("ENCRYPTION_KEY"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	db, err := sql.Open("postgres", url)
+	if err != nil {
+		log.Fatalf("connect: %v", err)
+	}
+	defer db.Close()
+
+	if err := db.Ping(); err != nil {
+		log.Fatalf("ping: %v", err)
+	}
+
+	// Soft-deleted providers are included: a restore brings their secrets back.
+	rows, err := db.Query(`SELECT id, COALESCE(client_secret, ''), params FROM provider_profiles`)
+	if err != nil {
+		log.Fatalf("query providers: %v", err)
+	}
+
+	type legacyRow struct {
+		id, clientSecret string
+		params           *json.RawMessage
+	}
+	var legacy []legacyRow
+	total := 0
+	for rows.Next() {
+		var r legacyRow
+		if err := rows.Scan(&r.id, &r.clientSecret, &r.params); err != nil {
+			log.Fatalf("scan provider: %v", err)
+		}
+		total++
+		if (r.clientSecret != "" && !vault.IsSealedSecret(r.clientSecret)) || provider.HasPlaintextSecrets(r.params) {
+			legacy = append(legacy, r)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		log.Fatalf("read providers: %v", err)
+	}
+	rows.Close()
+
+	migrated, skipped := 0, 0
+	for _, r := range legacy {
+		if *dryRun {
+			migrated++
+			continue
+		}
+		secret := r.clientSecret
+		if !vault.IsSealedSecret(secret) {
+			if secret, err = vault.SealSecret(key, secret, "client_secret"); err != nil {
+				log.Fatalf("provider %s: encrypt client secret: %v", r.id, err)
+			}
+		}
+		params, err := provider.SealParams(key, r.params)
+		if err != nil {
+			log.Fatalf("provider %s: %v", r.id, err)
+		}
+		// Only replace the exact values read, so a concurrent update that
+		// already wrote sealed secrets wins.
+		res, err := db.Exec(`
+			UPDATE provider_profiles SET client_secret = NULLIF($1, ''), params = $2
+			WHERE id = $3 AND COALESCE(client_secret, '') = $4 AND params IS NOT DISTINCT FROM $5`,
+			secret, params, r.id, r.clientSecret, r.params,
+		)
+		if err != nil {
+			log.Fatalf("provider %s: update: %v", r.id, err)
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			log.Printf("provider %s: changed while migrating, skipped", r.id)
+			skipped++
+			continue
+		}
+		migrated++
+	}
+
+	verb := "encrypted"
+	if *dryRun {
+		verb = "would encrypt"
+	}
+	fmt.Printf("%s secrets of %d of %d providers (%d changed concurrently; run again)\n", verb, migrated, total, skipped)
+	if skipped > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,107 @@
+// Command migrate-token-aad re-encrypts token rows written before tokens were
+// bound to their connection, so that every stored ciphertext only decrypts for
+// its own connection and workspace. It is safe to re-run: rows that are
+// already bound are skipped. Once it reports zero remaining rows, set
+// REQUIRE_BOUND_TOKENS=true on the broker.
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/config"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/vault"
+	_ "github.com/lib/pq"
+)
+
+func main() {
+	dsn := flag.String("dsn", "", "Postgres DSN (overrides DATABASE_URL)")
+	dryRun := flag.Bool("dry-run", false, "decrypt and count legacy rows without writing")
+	flag.Parse()
+
+	url := *dsn
+	if url == "" {
+		url = os.Getenv("DATABASE_URL")
+	}
+	if url == "" {
+		log.Fatal("DATABASE_URL or -dsn is required")
+	}
+	key, err := config.ValidateKey("ENCRYPTION_KEY", os.Getenv("ENCRYPTION_KEY"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	db, err := sql.Open("postgres", url)
+	if err != nil {
+		log.Fatalf("connect: %v", err)
+	}
+	defer db.Close()
+
+	if err := db.Ping(); err != nil {
+		log.Fatalf("ping: %v", err)
+	}
+
+	rows, err := db.Query(`
+		SELECT t.connection_id, c.workspace_id, t.encrypted_data
+		FROM tokens t
+		JOIN connections c ON c.id = t.connection_id
+		WHERE t.encrypted_data NOT LIKE 'v2:%'`)
+	if err != nil {
+		log.Fatalf("query tokens: %v", err)
+	}
+
+	type legacyRow struct {
+		connectionID, workspaceID, ciphertext string
+	}
+	var legacy []legacyRow
+	for rows.Next() {
+		var r legacyRow
+		if err := rows.Scan(&r.connectionID, &r.workspaceID, &r.ciphertext); err != nil {
+			log.Fatalf("scan token: %v", err)
+		}
+		legacy = append(legacy, r)
+	}
+	if err := rows.Err(); err != nil {
+		log.Fatalf("read tokens: %v", err)
+	}
+	rows.Close()
+
+	migrated, failed := 0, 0
+	for _, r := range legacy {
+		plaintext, err := vault.OpenToken(key, r.ciphertext, r.connectionID, r.workspaceID, true)
+		if err != nil {
+			log.Printf("connection %s: decrypt failed: %v", r.connectionID, err)
+			failed++
+			continue
+		}
+		if *dryRun {
+			migrated++
+			continue
+		}
+		sealed, err := vault.SealToken(key, plaintext, r.connectionID, r.workspaceID)
+		if err != nil {
+			log.Fatalf("connection %s: encrypt: %v", r.connectionID, err)
+		}
+		// Only replace the exact value read, so a concurrent refresh that
+		// already wrote a bound token wins.
+		if _, err := db.Exec(
+			"UPDATE tokens SET encrypted_data = $1 WHERE connection_id = $2 AND encrypted_data = $3",
+			sealed, r.connectionID, r.ciphertext,
+		); err != nil {
+			log.Fatalf("connection %s: update: %v", r.connectionID, err)
+		}
+		migrated++
+	}
+
+	verb := "re-encrypted"
+	if *dryRun {
+		verb = "would re-encrypt"
+	}
+	fmt.Printf("%s %d of %d legacy token rows (%d failed to decrypt)\n", verb, migrated, len(legacy), failed)
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
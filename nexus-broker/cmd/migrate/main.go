@@ -1,23 +1,36 @@
+// Command migrate manages the broker's schema using the migrations embedded
+// in the binary (see pkg/migrate). The DSN's scheme picks the Postgres,
+// MySQL or SQLite migrations, as it picks the backend of nexus-broker.
+//
+//	migrate [-dsn DSN] up                   apply pending migrations
+//	migrate [-dsn DSN] down [-steps N]      roll back the last N migrations
+//	migrate [-dsn DSN] status               list migrations
+//	migrate [-dsn DSN] baseline -version N  mark 0..N applied without running them
 package main
 
 import (
-	"database/sql"
+	"context"
 	"flag"
 	"fmt"
-	"io/ioutil"
 	"log"
 	"os"
+	"text/tabwriter"
 
-	_ "github.com/lib/pq"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/internal/store"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/config"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/migrate"
 )
 
 func main() {
-	file := flag.String("file", "", "Path to SQL migration file to apply")
-	dsn := flag.String("dsn", "", "Postgres DSN (overrides DATABASE_URL)")
+	dsn := flag.String("dsn", "", "database URL: postgres://, mysql:// or sqlite: (overrides DATABASE_URL)")
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "usage: migrate [-dsn DSN] up | down [-steps N] | status | baseline -version N")
+		flag.PrintDefaults()
+	}
 	flag.Parse()
-
-	if *file == "" {
-		log.Fatal("-file is required")
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
 	}
 
 	url := *dsn
@@ -28,24 +41,75 @@ func main() {
 		log.Fatal("DATABASE_URL or -dsn is required")
 	}
 
-	sqlBytes, err := ioutil.ReadFile(*file)
+	db, backend, err := store.OpenURL(url, config.DBPoolConfig{})
 	if err != nil {
-		log.Fatalf("read migration: %v", err)
+		log.Fatalf("connect: %v", err)
 	}
+	defer db.Close()
 
-	db, err := sql.Open("postgres", url)
+	fsys, dialect := backend.Migrations()
+	m, err := migrate.NewForDialect(db.DB, fsys, dialect)
 	if err != nil {
-		log.Fatalf("connect: %v", err)
+		log.Fatalf("load migrations: %v", err)
 	}
-	defer db.Close()
 
-	if err := db.Ping(); err != nil {
-		log.Fatalf("ping: %v", err)
+	ctx := context.Background()
+	cmd, args := flag.Arg(0), flag.Args()[1:]
+	switch cmd {
+	case "up":
+		done, err := m.Up(ctx)
+		report("applied", done, err)
+	case "down":
+		fs := flag.NewFlagSet("down", flag.ExitOnError)
+		steps := fs.Int("steps", 1, "number of migrations to roll back")
+		fs.Parse(args)
+		done, err := m.Down(ctx, *steps)
+		report("rolled back", done, err)
+	case "baseline":
+		fs := flag.NewFlagSet("baseline", flag.ExitOnError)
+		version := fs.Int("version", -1, "highest migration version already present in the database")
+		fs.Parse(args)
+		if *version < 0 {
+			log.Fatal("baseline: -version is required")
+		}
+		done, err := m.Baseline(ctx, *version)
+		report("recorded", done, err)
+	case "status":
+		status, err := m.Status(ctx)
+		if err != nil {
+			log.Fatalf("status: %v", err)
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "MIGRATION\tAPPLIED\tDOWN")
+		for _, s := range status {
+			applied := "pending"
+			if s.AppliedAt != nil {
+				applied = s.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			if s.Modified {
+				applied += " (modified)"
+			}
+			down := "no"
+			if s.Down != "" {
+				down = "yes"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\n", s.Name, applied, down)
+		}
+		w.Flush()
+	default:
+		flag.Usage()
+		os.Exit(2)
 	}
+}
 
-	if _, err := db.Exec(string(sqlBytes)); err != nil {
-		// Many ALTER IF NOT EXISTS are safe to re-run; surface error for visibility
-		log.Fatalf("exec migration: %v", err)
+func report(verb string, names []string, err error) {
+	for _, name := range names {
+		fmt.Println(verb+":", name)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(names) == 0 {
+		fmt.Println("nothing to do")
 	}
-	fmt.Println("migration applied:", *file)
 }
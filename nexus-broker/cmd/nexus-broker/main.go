@@ -2,39 +2,79 @@ package main
 
 import (
 	"context"
+	"flag"
 	"log"
+	"net"
+	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/internal/anomaly"
 	"github.com/Prescott-Data/nexus-framework/nexus-broker/internal/audit"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/internal/auditsink"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/internal/lock"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/internal/quota"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/internal/ratelimit"
+	authstore "github.com/Prescott-Data/nexus-framework/nexus-broker/internal/store"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/internal/usage"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/internal/webhook"
 	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/caching"
 	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/config"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/grpcapi"
 	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/handlers"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/httpclient"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/migrate"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/pages"
 	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/provider"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/redact"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/retention"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/router"
 	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/server"
-	"github.com/go-chi/chi/v5"
-	"github.com/go-redis/redis/v8"
+	"github.com/Prescott-Data/nexus-framework/nexus-common/health"
 	"github.com/jmoiron/sqlx"
-	_ "github.com/lib/pq"
+	"google.golang.org/grpc"
 )
 
-var Version = "dev"
+// Build metadata, set with -ldflags "-X main.Version=... -X main.GitCommit=...
+// -X main.BuildDate=...".
+var (
+	Version   = "dev"
+	GitCommit = "unknown"
+	BuildDate = "unknown"
+)
 
 func main() {
+	log.SetOutput(redact.NewWriter(os.Stderr))
 	if len(os.Args) > 1 && (os.Args[1] == "-v" || os.Args[1] == "--version") {
 		log.Printf("Nexus Broker version: %s", Version)
 		os.Exit(0)
 	}
 
-	cfg, err := config.Load()
+	configPath := flag.String("config", os.Getenv("CONFIG_FILE"), "path to a YAML config file (env vars override its values)")
+	printConfig := flag.Bool("print-config", false, "print the effective configuration with defaults and exit")
+	flag.Parse()
+
+	if *printConfig {
+		if err := config.PrintConfig(os.Stdout, *configPath); err != nil {
+			log.Fatalf("Fatal configuration error: %v", err)
+		}
+		os.Exit(0)
+	}
+
+	cfg, err := config.LoadFile(*configPath)
 	if err != nil {
 		log.Fatalf("Fatal configuration error: %v", err)
 	}
 
 	log.Printf("ENCRYPTION_KEY fingerprint: %s", config.KeyFingerprint(cfg.EncryptionKey))
-	log.Printf("STATE_KEY fingerprint: %s", config.KeyFingerprint(cfg.StateKey))
+	log.Printf("STATE_KEY fingerprint: %s (signing key ID %s, accepted %v)", config.KeyFingerprint(cfg.StateKey), cfg.StateKeys.CurrentKeyID(), cfg.StateKeys.KeyIDs())
 
-	db, err := sqlx.Connect("postgres", cfg.DatabaseURL)
+	// The scheme of DATABASE_URL picks the backend: Postgres, MySQL or
+	// SQLite.
+	db, backend, err := authstore.OpenURL(cfg.DatabaseURL, cfg.DBPool)
 	if err != nil {
 		log.Fatal("Failed to connect to database:", err)
 	}
@@ -43,84 +83,329 @@ func main() {
 	if err := db.Ping(); err != nil {
 		log.Fatal("Failed to ping database:", err)
 	}
-	log.Println("Successfully connected to database")
+	log.Printf("Successfully connected to database (%s)", backend)
+
+	var (
+		replica   *sqlx.DB
+		authStore authstore.Store
+		store     providerStore
+	)
+	switch backend {
+	case authstore.BackendPostgres:
+		if cfg.DatabaseReadURL != "" {
+			replica, err = authstore.Open(cfg.DatabaseReadURL, cfg.DBPool)
+			if err != nil {
+				log.Fatal("Failed to connect to read replica:", err)
+			}
+			defer replica.Close()
+			log.Println("Successfully connected to read replica")
+		}
+		authStore = authstore.NewPostgresWithReplica(db, replica).WithSecretKey(cfg.EncryptionKey)
+		store = provider.NewStore(db, cfg.EncryptionKey)
+	case authstore.BackendMySQL, authstore.BackendSQLite:
+		if cfg.DatabaseReadURL != "" {
+			log.Printf("DATABASE_READ_URL is ignored: read replicas need Postgres")
+		}
+		if backend == authstore.BackendMySQL {
+			authStore = authstore.NewMySQL(db).WithSecretKey(cfg.EncryptionKey)
+		} else {
+			authStore = authstore.NewSQLite(db).WithSecretKey(cfg.EncryptionKey)
+		}
+		store = provider.NewSQLStore(db, cfg.EncryptionKey)
+	}
 
-	opts, err := redis.ParseURL(cfg.RedisURL)
+	if cfg.AutoMigrate {
+		fsys, dialect := backend.Migrations()
+		m, err := migrate.NewForDialect(db.DB, fsys, dialect)
+		if err != nil {
+			log.Fatal("Failed to load migrations:", err)
+		}
+		applied, err := m.Up(context.Background())
+		for _, name := range applied {
+			log.Printf("Applied migration %s", name)
+		}
+		if err != nil {
+			log.Fatal("Failed to apply migrations:", err)
+		}
+	}
+
+	redisClient, err := caching.NewRedisClient(cfg.Redis)
 	if err != nil {
-		log.Fatal("Failed to parse REDIS_URL:", err)
+		log.Fatal("Failed to configure Redis:", err)
 	}
-	redisClient := redis.NewClient(opts)
 	if err := redisClient.Ping(context.Background()).Err(); err != nil {
-		log.Fatal("Failed to ping Redis:", err)
+		if cfg.Redis.Required {
+			log.Fatal("Failed to ping Redis:", err)
+		}
+		log.Printf("Redis unavailable, caching in memory until it recovers: %v", err)
+	} else {
+		log.Printf("Successfully connected to Redis (%s)", cfg.Redis.Mode)
 	}
-	log.Println("Successfully connected to Redis")
+	memoryCache := caching.NewMemoryCache(cfg.Redis.MemoryCacheSize)
+	localCache := caching.NewMemoryCache(cfg.Redis.MemoryCacheSize)
 
-	cachingClient := caching.NewCachingClient(redisClient, 1*time.Hour)
-
-	srv := server.NewServer(cfg.Port)
-	store := provider.NewStore(db)
-	auditSvc := audit.NewService(db)
+	// Provider traffic never reaches private addresses unless allowed, and
+	// optionally goes through an egress proxy and is restricted to the
+	// hosts of registered providers.
+	var egressProxy *url.URL
+	if cfg.Egress.ProxyURL != "" {
+		egressProxy, _ = url.Parse(cfg.Egress.ProxyURL) // validated by config.Load
+	}
+	guard, err := httpclient.NewAddressGuard(cfg.Egress.AllowPrivateNetworks, cfg.Egress.AllowedCIDRs)
+	if err != nil {
+		log.Fatal("Invalid egress configuration:", err)
+	}
+	var egress *httpclient.Allowlist
+	if cfg.Egress.Enforce {
+		egress = httpclient.NewAllowlist(cfg.Egress.AllowedHosts, store.EgressHosts)
+		if err := egress.Refresh(context.Background()); err != nil {
+			log.Printf("Failed to load provider hosts for the egress allowlist: %v", err)
+		}
+		log.Println("Egress allowlist enforced for provider calls")
+	}
 
-	providersHandler := handlers.NewProvidersHandler(store, auditSvc)
-	consentHandler := handlers.NewConsentHandler(handlers.ConsentHandlerConfig{
-		DB:                   db,
-		BaseURL:              cfg.BaseURL,
-		RedirectPath:         cfg.RedirectPath,
-		StateKey:             cfg.StateKey,
-		HTTPClient:           cachingClient,
-		EnforceReturnURL:     cfg.EnforceReturnURL,
-		AllowedReturnDomains: cfg.AllowedReturnDomains,
+	// One transport pool serves every call to identity providers; discovery
+	// documents and JWKS fetched through it are cached in a short-lived
+	// local tier and in Redis.
+	transports := httpclient.NewPool(httpclient.Config{
+		MaxConnsPerHost:     cfg.ProviderMaxConnsPerHost,
+		MaxIdleConnsPerHost: cfg.ProviderMaxIdleConnsPerHost,
+		Proxy:               egressProxy,
+		Egress:              egress,
+		Guard:               guard,
+		Cache: func(rt http.RoundTripper) http.RoundTripper {
+			return caching.NewTieredTransport(caching.TransportConfig{
+				Redis:    redisClient,
+				TTL:      1 * time.Hour,
+				Local:    localCache,
+				LocalTTL: cfg.Redis.LocalCacheTTL,
+				Fallback: memoryCache,
+			}, rt)
+		},
 	})
-	callbackHandler := handlers.NewCallbackHandler(handlers.CallbackHandlerConfig{
-		DB:                   db,
-		Audit:                auditSvc,
-		BaseURL:              cfg.BaseURL,
-		RedirectPath:         cfg.RedirectPath,
-		EncryptionKey:        cfg.EncryptionKey,
-		StateKey:             cfg.StateKey,
-		HTTPClient:           cachingClient,
-		EnforceReturnURL:     cfg.EnforceReturnURL,
-		AllowedReturnDomains: cfg.AllowedReturnDomains,
-	})
-	auditHandler := handlers.NewAuditHandler(db)
+	cachingClient, _ := transports.CachedClient("")
 
-	router := srv.Router()
-	router.Get("/auth/callback", callbackHandler.Handle)
-	router.Method("GET", "/metrics", server.MetricsHandler())
-	router.Get("/auth/capture-schema", callbackHandler.GetCaptureSchema)
-	router.Post("/auth/capture-credential", callbackHandler.SaveCredential)
+	srv := server.NewServer(cfg.Port, cfg.TrustedProxies)
+	auditSvc := audit.NewServiceWithStore(authStore)
+	auditShipper := newAuditShipper(cfg.AuditSinks)
+	auditSvc.AddPublisher(auditShipper)
+	notifier := webhook.New(cfg.WebhookURL, []byte(cfg.WebhookSecret), nil)
+	detector, err := newAnomalyDetector(cfg.Anomaly, notifier)
+	if err != nil {
+		log.Fatal("Invalid anomaly detection configuration:", err)
+	}
+	auditSvc.AddPublisher(detector)
+	hostedPages, err := pages.New(cfg.PagesDir)
+	if err != nil {
+		log.Fatal("Invalid PAGES_DIR:", err)
+	}
 
-	protected := router.With(
-		server.ApiKeyMiddleware(cfg.RequireAPIKey, cfg.APIKeys),
-		server.AllowlistMiddleware(cfg.RequireAllowlist, cfg.AllowedCIDRs),
-	)
-	protected.Get("/audit", auditHandler.List)
-	protected.Route("/providers", func(r chi.Router) {
-		r.Post("/", providersHandler.Register)
-		r.Get("/", providersHandler.List)
-		r.Get("/metadata", providersHandler.Metadata)
-		r.Get("/by-name/{name}", providersHandler.GetByName)
-		r.Delete("/by-name/{name}", providersHandler.DeleteByName)
-		r.Get("/{id}", providersHandler.Get)
-		r.Put("/{id}", providersHandler.Update)
-		r.Patch("/{id}", providersHandler.Patch)
-		r.Delete("/{id}", providersHandler.Delete)
-	})
-	protected.Post("/auth/consent-spec", consentHandler.GetSpec)
-	protected.Get("/connections/{connectionID}/token", callbackHandler.GetToken)
-	protected.Post("/connections/{connectionID}/refresh", callbackHandler.Refresh)
+	usageRecorder := usage.NewRecorder(authStore, cfg.UsageFlushInterval)
+	quotas := quota.New(authStore, quota.Limits{
+		MaxPendingConnections: cfg.WorkspaceLimits.MaxPendingConnections,
+		MaxActiveConnections:  cfg.WorkspaceLimits.MaxActiveConnections,
+		MaxRefreshesPerMinute: cfg.WorkspaceLimits.MaxRefreshesPerMinute,
+	}, quota.NewRedis(redisClient))
 
-	router.Get("/health", server.HealthHandler)
+	reload := func(ctx context.Context) (map[string]int, error) {
+		n, err := caching.Invalidate(ctx, redisClient)
+		return map[string]int{"http_cache": n, "memory_cache": memoryCache.Purge(), "local_cache": localCache.Purge()}, err
+	}
+	checks := []health.Check{
+		{Name: string(backend), Critical: true, Check: db.PingContext},
+		{Name: "redis", Check: func(ctx context.Context) error {
+			return redisClient.Ping(ctx).Err()
+		}},
+	}
+	if replica != nil {
+		checks = append(checks, health.Check{Name: "postgres_replica", Check: replica.PingContext})
+	}
+	brokerAPI, err := router.New(srv, cfg, router.Deps{
+		Store:           authStore,
+		Profiles:        store,
+		DB:              db,
+		Audit:           auditSvc,
+		Webhook:         notifier,
+		Quota:           quotas,
+		Usage:           usageRecorder,
+		RefreshLock:     lock.NewRedis(redisClient),
+		ProviderLimiter: ratelimit.NewRedis(redisClient),
+		HTTPClient:      cachingClient,
+		Transports:      transports,
+		Guard:           guard,
+		Pages:           hostedPages,
+		Build: server.BuildInfo{
+			Version:   Version,
+			GitCommit: GitCommit,
+			BuildDate: BuildDate,
+		},
+		Reload: reload,
+		Checks: checks,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	cleanupCtx, cleanupCancel := context.WithCancel(context.Background())
 	defer cleanupCancel()
-	go handlers.StartOrphanTokenCleanup(cleanupCtx, db, 1*time.Hour)
+	go handlers.StartOrphanTokenCleanup(cleanupCtx, authStore, 1*time.Hour)
+	go handlers.StartPendingExpiry(cleanupCtx, authStore, 5*time.Minute)
+	go handlers.StartGrantCleanup(cleanupCtx, authStore, 1*time.Hour)
+	// Provider audits and retention sweeps are written for Postgres; the
+	// other backends run without them.
+	if cfg.ProviderAuditInterval > 0 && brokerAPI.ProviderAuditor != nil {
+		go brokerAPI.ProviderAuditor.Start(cleanupCtx, cfg.ProviderAuditInterval)
+	} else if cfg.ProviderAuditInterval > 0 {
+		log.Printf("Provider audits are disabled: they need Postgres")
+	}
+	if cfg.Retention.Interval > 0 && backend != authstore.BackendPostgres {
+		log.Printf("Retention sweeps are disabled: they need Postgres")
+	} else if cfg.Retention.Interval > 0 {
+		go retention.Start(cleanupCtx, db, retention.Policy{
+			FailedConnectionTTL:  cfg.Retention.FailedConnections,
+			ExpiredConnectionTTL: cfg.Retention.ExpiredConnections,
+			AuditEventTTL:        cfg.Retention.AuditEvents,
+		}, cfg.Retention.Interval)
+	}
+	if cfg.UsageFlushInterval > 0 {
+		go usageRecorder.Start(cleanupCtx, cfg.UsageFlushInterval)
+	}
+	if cfg.RefreshRetry.Interval > 0 {
+		go brokerAPI.Callback.StartRefreshRetries(cleanupCtx, cfg.RefreshRetry.Interval)
+	}
 
 	log.Printf("Starting OAuth Broker server on port %s", cfg.Port)
 	log.Printf("Version: %s", Version)
 	log.Printf("Base URL: %s", cfg.BaseURL)
 
-	if err := srv.Start(); err != nil {
-		log.Fatal("Server failed to start:", err)
+	go func() {
+		if err := srv.Start(); err != nil {
+			log.Fatal("Server failed to start:", err)
+		}
+	}()
+
+	// The gRPC BrokerService serves the Gateway from the same handlers,
+	// middleware and drain state as REST.
+	var grpcSrv *grpc.Server
+	if cfg.GRPCPort != "" {
+		lis, err := net.Listen("tcp", ":"+cfg.GRPCPort)
+		if err != nil {
+			log.Fatal("Failed to listen for gRPC:", err)
+		}
+		grpcSrv = grpcapi.NewServer(brokerAPI, srv.Draining)
+		log.Printf("Starting gRPC BrokerService on port %s", cfg.GRPCPort)
+		go func() {
+			if err := grpcSrv.Serve(lis); err != nil {
+				log.Fatal("gRPC server failed:", err)
+			}
+		}()
+	}
+
+	// SIGHUP drops the discovery/JWKS cache. Graceful shutdown on
+	// SIGINT/SIGTERM: fail readiness and refuse new consent requests, give
+	// load balancers time to notice, then let in-flight requests (callback
+	// token exchanges in particular) finish.
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	for sig := range sigCh {
+		if sig != syscall.SIGHUP {
+			break
+		}
+		invalidated, err := reload(context.Background())
+		if err != nil {
+			log.Printf("SIGHUP: cache reload failed: %v", err)
+			continue
+		}
+		log.Printf("SIGHUP: caches reloaded %v", invalidated)
+	}
+	log.Printf("Shutting down: draining for %s", cfg.ShutdownDrainDelay)
+	srv.BeginDrain()
+	time.Sleep(cfg.ShutdownDrainDelay)
+
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer shutdownCancel()
+	if grpcSrv != nil {
+		go func() {
+			<-shutdownCtx.Done()
+			grpcSrv.Stop()
+		}()
+	}
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Graceful shutdown incomplete: %v", err)
+	}
+	if grpcSrv != nil {
+		grpcSrv.GracefulStop()
+	}
+	cleanupCancel()
+	if err := usageRecorder.Flush(shutdownCtx); err != nil {
+		log.Printf("Token usage not flushed: %v", err)
+	}
+	if err := auditShipper.Close(shutdownCtx); err != nil {
+		log.Printf("Audit sinks not flushed: %v", err)
+	}
+	if err := detector.Close(shutdownCtx); err != nil {
+		log.Printf("Security alert webhooks not sent: %v", err)
+	}
+	log.Println("Server stopped")
+}
+
+// providerStore is the provider registry of the configured backend.
+type providerStore interface {
+	router.ProfileStore
+	EgressHosts(ctx context.Context) ([]string, error)
+}
+
+// newAuditShipper builds the configured audit sinks; it returns nil when
+// none is configured.
+func newAuditShipper(c config.AuditSinkConfig) *auditsink.Shipper {
+	var sinks []auditsink.Sink
+	if c.WebhookURL != "" {
+		sinks = append(sinks, &auditsink.WebhookSink{URL: c.WebhookURL, Secret: []byte(c.WebhookSecret)})
+	}
+	if c.KafkaRESTURL != "" {
+		sinks = append(sinks, &auditsink.KafkaSink{RESTURL: c.KafkaRESTURL, Topic: c.KafkaTopic, Username: c.KafkaUsername, Password: c.KafkaPassword})
+	}
+	if c.SyslogAddr != "" {
+		sinks = append(sinks, &auditsink.SyslogSink{Network: c.SyslogNetwork, Addr: c.SyslogAddr, Format: c.SyslogFormat, Version: Version})
+	}
+	for _, s := range sinks {
+		log.Printf("Shipping audit events to %s", s.Name())
+	}
+	return auditsink.New(auditsink.Config{
+		BufferSize:    c.BufferSize,
+		BatchSize:     c.BatchSize,
+		FlushInterval: c.FlushInterval,
+		MaxRetries:    c.MaxRetries,
+		RetryBackoff:  c.RetryBackoff,
+	}, sinks...)
+}
+
+// newAnomalyDetector builds the enabled anomaly rules; it returns nil when
+// detection is off.
+func newAnomalyDetector(c config.AnomalyConfig, notifier *webhook.Notifier) (*anomaly.Detector, error) {
+	if !c.Enabled {
+		return nil, nil
+	}
+	var rules []anomaly.Rule
+	if c.StateFailureThreshold > 0 {
+		rules = append(rules, anomaly.StateFailuresByIP(c.StateFailureThreshold, c.StateFailureWindow, c.Cooldown))
+	}
+	if c.RefreshFailureThreshold > 0 {
+		rules = append(rules, anomaly.RefreshFailuresByProvider(c.RefreshFailureThreshold, c.RefreshFailureWindow, c.Cooldown))
+	}
+	if c.NewOrigin {
+		var resolver anomaly.Resolver = anomaly.PrefixResolver{}
+		if c.OriginTable != "" {
+			table, err := anomaly.LoadTable(c.OriginTable, resolver)
+			if err != nil {
+				return nil, err
+			}
+			resolver = table
+		}
+		rules = append(rules, anomaly.NewOriginRule(resolver))
+	}
+	for _, r := range rules {
+		log.Printf("Anomaly rule enabled: %s", r.Name())
 	}
+	return anomaly.NewDetector(notifier, rules...), nil
 }
@@ -0,0 +1,158 @@
+// Package anomaly flags suspicious patterns in the audit stream.
+//
+// A Detector receives every stored audit event (it is an audit.Publisher)
+// and runs it through a set of Rules. Each Alert a rule raises is logged,
+// counted in security_alerts_total and delivered as a "security.alert"
+// webhook. Rules keep their state in memory, bounded per rule, so a
+// restarted broker starts from a clean slate and each replica only sees
+// the events it stored itself; thresholds should be set with that in mind.
+package anomaly
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/internal/store"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/internal/webhook"
+)
+
+// AlertEvent is the webhook event alerts are delivered as.
+const AlertEvent = "security.alert"
+
+// Alert severities.
+const (
+	SeverityWarning  = "warning"
+	SeverityCritical = "critical"
+)
+
+// Alert describes one suspicious pattern.
+type Alert struct {
+	// Rule is the name of the rule that raised the alert.
+	Rule     string `json:"rule"`
+	Severity string `json:"severity"`
+	Summary  string `json:"summary"`
+	// Key is what the alert is about: an IP address, connection or
+	// provider, depending on the rule.
+	Key          string    `json:"key"`
+	ConnectionID string    `json:"connection_id,omitempty"`
+	IPAddress    string    `json:"ip_address,omitempty"`
+	Origin       string    `json:"origin,omitempty"`
+	Count        int       `json:"count,omitempty"`
+	Window       string    `json:"window,omitempty"`
+	DetectedAt   time.Time `json:"detected_at"`
+}
+
+// Rule inspects audit events. A Detector calls Evaluate for one event at a
+// time, so rules need no locking of their own.
+type Rule interface {
+	Name() string
+	// Evaluate returns the alerts raised by e, observed at now.
+	Evaluate(e store.AuditEvent, now time.Time) []Alert
+}
+
+var metricAlerts = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "security_alerts_total",
+	Help: "Security alerts raised by audit event anomaly rules",
+}, []string{"rule", "severity"})
+
+func init() {
+	prometheus.MustRegister(metricAlerts)
+}
+
+// Detector evaluates audit events against rules. A nil *Detector discards
+// events.
+type Detector struct {
+	rules    []Rule
+	notifier *webhook.Notifier
+	now      func() time.Time
+
+	mu sync.Mutex
+	wg sync.WaitGroup
+}
+
+// NewDetector returns a Detector running rules and delivering alerts to
+// notifier (which may be nil), or nil if there are no rules.
+func NewDetector(notifier *webhook.Notifier, rules ...Rule) *Detector {
+	if len(rules) == 0 {
+		return nil
+	}
+	return &Detector{rules: rules, notifier: notifier, now: time.Now}
+}
+
+// Publish evaluates e. Webhooks are sent in the background so the request
+// that stored e is not delayed.
+func (d *Detector) Publish(e store.AuditEvent) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	now := d.now()
+	var alerts []Alert
+	for _, r := range d.rules {
+		alerts = append(alerts, r.Evaluate(e, now)...)
+	}
+	d.mu.Unlock()
+
+	for _, a := range alerts {
+		a.DetectedAt = now.UTC()
+		metricAlerts.WithLabelValues(a.Rule, a.Severity).Inc()
+		if b, err := json.Marshal(a); err == nil {
+			log.Printf("SECURITY: %s alert: %s", a.Rule, b)
+		}
+		if d.notifier == nil {
+			continue
+		}
+		d.wg.Add(1)
+		go func(a Alert) {
+			defer d.wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+			if err := d.notifier.Send(ctx, AlertEvent, a); err != nil {
+				log.Printf("anomaly: %s webhook failed: %v", a.Rule, err)
+			}
+		}(a)
+	}
+}
+
+// Close waits for pending webhooks until ctx is done.
+func (d *Detector) Close(ctx context.Context) error {
+	if d == nil {
+		return nil
+	}
+	done := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// eventData returns field of e's JSON event data, or "".
+func eventData(e store.AuditEvent, field string) string {
+	if e.EventData == nil {
+		return ""
+	}
+	var data map[string]interface{}
+	if err := json.Unmarshal([]byte(*e.EventData), &data); err != nil {
+		return ""
+	}
+	v, _ := data[field].(string)
+	return v
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
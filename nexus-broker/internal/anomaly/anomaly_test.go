@@ -0,0 +1,168 @@
+package anomaly
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/internal/store"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/internal/webhook"
+)
+
+func fromIP(eventType, ip string) store.AuditEvent {
+	return store.AuditEvent{ID: uuid.New(), EventType: eventType, IPAddress: &ip}
+}
+
+func TestStateFailuresByIP_ThresholdAndCooldown(t *testing.T) {
+	r := StateFailuresByIP(3, time.Minute, 10*time.Minute)
+	now := time.Now()
+
+	assert.Empty(t, r.Evaluate(fromIP("state_verification_failed", "203.0.113.7"), now))
+	assert.Empty(t, r.Evaluate(fromIP("state_verification_failed", "198.51.100.1"), now), "other IPs count separately")
+	assert.Empty(t, r.Evaluate(fromIP("oauth_error", "203.0.113.7"), now), "other events are ignored")
+	assert.Empty(t, r.Evaluate(fromIP("state_verification_failed", "203.0.113.7"), now.Add(10*time.Second)))
+	alerts := r.Evaluate(fromIP("state_verification_failed", "203.0.113.7"), now.Add(20*time.Second))
+	require.Len(t, alerts, 1)
+	assert.Equal(t, "state_verification_failures", alerts[0].Rule)
+	assert.Equal(t, "203.0.113.7", alerts[0].Key)
+	assert.Equal(t, 3, alerts[0].Count)
+
+	for i := 0; i < 5; i++ {
+		assert.Empty(t, r.Evaluate(fromIP("state_verification_failed", "203.0.113.7"), now.Add(30*time.Second)), "cooling down")
+	}
+	assert.Len(t, r.Evaluate(fromIP("state_verification_failed", "203.0.113.7"), now.Add(11*time.Minute)), 0,
+		"older failures left the window")
+}
+
+func TestBurstRule_WindowSlides(t *testing.T) {
+	r := StateFailuresByIP(2, time.Minute, 0)
+	now := time.Now()
+	r.Evaluate(fromIP("state_verification_failed", "203.0.113.7"), now)
+	assert.Empty(t, r.Evaluate(fromIP("state_verification_failed", "203.0.113.7"), now.Add(2*time.Minute)))
+	assert.Len(t, r.Evaluate(fromIP("state_verification_failed", "203.0.113.7"), now.Add(150*time.Second)), 1)
+}
+
+func TestBurstRule_BoundsKeys(t *testing.T) {
+	r := StateFailuresByIP(2, time.Minute, 0)
+	r.MaxKeys = 2
+	now := time.Now()
+	r.Evaluate(fromIP("state_verification_failed", "192.0.2.1"), now)
+	r.Evaluate(fromIP("state_verification_failed", "192.0.2.2"), now)
+	r.Evaluate(fromIP("state_verification_failed", "192.0.2.3"), now)
+	assert.Len(t, r.keys, 2, "new keys are ignored while the table is full")
+
+	r.Evaluate(fromIP("state_verification_failed", "192.0.2.3"), now.Add(2*time.Minute))
+	assert.Len(t, r.keys, 1, "expired keys are pruned to make room")
+}
+
+func TestRefreshFailuresByProvider_KeysOnProviderID(t *testing.T) {
+	r := RefreshFailuresByProvider(2, time.Minute, time.Minute)
+	data := `{"provider_id":"p-1","status_code":"400"}`
+	now := time.Now()
+	assert.Empty(t, r.Evaluate(store.AuditEvent{EventType: "token_refresh_fatal", EventData: &data}, now))
+	alerts := r.Evaluate(store.AuditEvent{EventType: "token_refresh_failed", EventData: &data}, now)
+	require.Len(t, alerts, 1)
+	assert.Equal(t, "p-1", alerts[0].Key)
+	assert.Empty(t, r.Evaluate(store.AuditEvent{EventType: "token_refresh_fatal"}, now), "events without a provider are ignored")
+}
+
+func retrieved(conn uuid.UUID, ip string) store.AuditEvent {
+	e := fromIP("token_retrieved", ip)
+	e.ConnectionID = &conn
+	return e
+}
+
+func TestOriginRule_AlertsOnNewOrigin(t *testing.T) {
+	r := NewOriginRule(PrefixResolver{})
+	conn := uuid.New()
+	now := time.Now()
+
+	assert.Empty(t, r.Evaluate(retrieved(conn, "10.1.2.3"), now), "the first origin is the baseline")
+	assert.Empty(t, r.Evaluate(retrieved(conn, "10.1.2.200"), now), "same /24")
+	alerts := r.Evaluate(retrieved(conn, "203.0.113.9"), now)
+	require.Len(t, alerts, 1)
+	assert.Equal(t, conn.String(), alerts[0].ConnectionID)
+	assert.Equal(t, "203.0.113.0/24", alerts[0].Origin)
+	assert.Contains(t, alerts[0].Summary, "previously 10.1.2.0/24")
+	assert.Empty(t, r.Evaluate(retrieved(conn, "203.0.113.10"), now), "the new origin is remembered")
+
+	assert.Empty(t, r.Evaluate(retrieved(uuid.New(), "203.0.113.9"), now), "origins are per connection")
+}
+
+func TestOriginRule_BoundsOrigins(t *testing.T) {
+	r := NewOriginRule(PrefixResolver{})
+	r.MaxOrigins = 2
+	conn := uuid.New()
+	now := time.Now()
+	r.Evaluate(retrieved(conn, "192.0.2.1"), now)
+	r.Evaluate(retrieved(conn, "198.51.100.1"), now)
+	r.Evaluate(retrieved(conn, "203.0.113.1"), now)
+	assert.Len(t, r.Evaluate(retrieved(conn, "192.0.2.1"), now), 1, "the oldest origin was forgotten")
+}
+
+func TestPrefixResolver(t *testing.T) {
+	assert.Equal(t, "203.0.113.0/24", PrefixResolver{}.Origin(net.ParseIP("203.0.113.77")))
+	assert.Equal(t, "2001:db8:1::/48", PrefixResolver{}.Origin(net.ParseIP("2001:db8:1:2::1")))
+	assert.Equal(t, "203.0.112.0/20", PrefixResolver{IPv4Bits: 20}.Origin(net.ParseIP("203.0.113.77")))
+}
+
+func TestLoadTable_MostSpecificRangeWins(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "origins.csv")
+	require.NoError(t, os.WriteFile(path, []byte(`# cidr,origin
+203.0.112.0/20, AS64500
+203.0.113.0/24, AS64501
+2001:db8::/32, AS64502
+`), 0o600))
+
+	table, err := LoadTable(path, PrefixResolver{})
+	require.NoError(t, err)
+	assert.Equal(t, "AS64501", table.Origin(net.ParseIP("203.0.113.5")))
+	assert.Equal(t, "AS64500", table.Origin(net.ParseIP("203.0.114.5")))
+	assert.Equal(t, "AS64502", table.Origin(net.ParseIP("2001:db8:ffff::1")))
+	assert.Equal(t, "192.0.2.0/24", table.Origin(net.ParseIP("192.0.2.1")), "unlisted IPs use the fallback")
+
+	require.NoError(t, os.WriteFile(path, []byte("not-a-cidr,AS1\n"), 0o600))
+	_, err = LoadTable(path, nil)
+	assert.ErrorContains(t, err, "invalid CIDR")
+}
+
+func TestDetector_SendsSecurityAlertWebhook(t *testing.T) {
+	got := make(chan map[string]interface{}, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		got <- body
+	}))
+	defer srv.Close()
+
+	rule := StateFailuresByIP(1, time.Minute, time.Minute)
+	rule.RuleName = "detector_test"
+	d := NewDetector(webhook.New(srv.URL, nil, srv.Client()), rule)
+	d.Publish(fromIP("state_verification_failed", "203.0.113.7"))
+	require.NoError(t, d.Close(context.Background()))
+
+	body := <-got
+	assert.Equal(t, AlertEvent, body["event"])
+	data := body["data"].(map[string]interface{})
+	assert.Equal(t, "detector_test", data["rule"])
+	assert.Equal(t, "203.0.113.7", data["key"])
+	assert.Equal(t, 1.0, testutil.ToFloat64(metricAlerts.WithLabelValues("detector_test", SeverityWarning)))
+}
+
+func TestNilDetectorDiscards(t *testing.T) {
+	d := NewDetector(nil)
+	assert.Nil(t, d)
+	d.Publish(fromIP("state_verification_failed", "203.0.113.7"))
+	assert.NoError(t, d.Close(context.Background()))
+}
@@ -0,0 +1,145 @@
+package anomaly
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/internal/store"
+)
+
+// defaultMaxKeys bounds the keys a BurstRule tracks.
+const defaultMaxKeys = 10000
+
+// BurstRule alerts when Threshold events of EventTypes share a key within
+// Window, then stays quiet for that key for Cooldown.
+type BurstRule struct {
+	RuleName   string
+	Severity   string
+	EventTypes []string
+	// Key groups events; events with an empty key are ignored.
+	Key       func(e store.AuditEvent) string
+	Threshold int
+	Window    time.Duration
+	Cooldown  time.Duration
+	// Summary describes an alert about key.
+	Summary func(key string, n int, window time.Duration) string
+	// MaxKeys bounds the keys tracked at once; further keys are ignored
+	// until old ones expire. Zero means defaultMaxKeys.
+	MaxKeys int
+
+	keys map[string]*burst
+}
+
+type burst struct {
+	times     []time.Time
+	alertedAt time.Time
+}
+
+func (r *BurstRule) Name() string { return r.RuleName }
+
+func (r *BurstRule) Evaluate(e store.AuditEvent, now time.Time) []Alert {
+	if !r.matches(e.EventType) {
+		return nil
+	}
+	key := r.Key(e)
+	if key == "" {
+		return nil
+	}
+	if r.keys == nil {
+		r.keys = make(map[string]*burst)
+	}
+	b := r.keys[key]
+	if b == nil {
+		max := r.MaxKeys
+		if max <= 0 {
+			max = defaultMaxKeys
+		}
+		if len(r.keys) >= max {
+			r.prune(now)
+			if len(r.keys) >= max {
+				return nil
+			}
+		}
+		b = &burst{}
+		r.keys[key] = b
+	}
+
+	cutoff := now.Add(-r.Window)
+	kept := b.times[:0]
+	for _, t := range b.times {
+		if t.After(cutoff) {
+			kept = append(kept, t)
+		}
+	}
+	b.times = append(kept, now)
+	if len(b.times) > r.Threshold {
+		b.times = b.times[len(b.times)-r.Threshold:]
+	}
+	if len(b.times) < r.Threshold || (!b.alertedAt.IsZero() && now.Sub(b.alertedAt) < r.Cooldown) {
+		return nil
+	}
+	b.alertedAt = now
+	return []Alert{{
+		Rule:     r.RuleName,
+		Severity: r.Severity,
+		Summary:  r.Summary(key, len(b.times), r.Window),
+		Key:      key,
+		Count:    len(b.times),
+		Window:   r.Window.String(),
+	}}
+}
+
+func (r *BurstRule) matches(eventType string) bool {
+	for _, t := range r.EventTypes {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// prune forgets keys with no events in the window and no active cooldown.
+func (r *BurstRule) prune(now time.Time) {
+	for key, b := range r.keys {
+		last := b.times[len(b.times)-1]
+		if now.Sub(last) >= r.Window && (b.alertedAt.IsZero() || now.Sub(b.alertedAt) >= r.Cooldown) {
+			delete(r.keys, key)
+		}
+	}
+}
+
+// StateFailuresByIP alerts when one client IP fails OAuth state
+// verification threshold times within window, a sign of forged or
+// replayed callbacks.
+func StateFailuresByIP(threshold int, window, cooldown time.Duration) *BurstRule {
+	return &BurstRule{
+		RuleName:   "state_verification_failures",
+		Severity:   SeverityWarning,
+		EventTypes: []string{"state_verification_failed"},
+		Key:        func(e store.AuditEvent) string { return deref(e.IPAddress) },
+		Threshold:  threshold,
+		Window:     window,
+		Cooldown:   cooldown,
+		Summary: func(ip string, n int, window time.Duration) string {
+			return fmt.Sprintf("%d OAuth callbacks from %s failed state verification within %s", n, ip, window)
+		},
+	}
+}
+
+// RefreshFailuresByProvider alerts when threshold token refreshes against
+// one provider fail within window, e.g. after the provider revoked the
+// client or rotated its credentials.
+func RefreshFailuresByProvider(threshold int, window, cooldown time.Duration) *BurstRule {
+	return &BurstRule{
+		RuleName:   "refresh_failure_spike",
+		Severity:   SeverityWarning,
+		EventTypes: []string{"token_refresh_fatal", "token_refresh_failed"},
+		Key:        func(e store.AuditEvent) string { return eventData(e, "provider_id") },
+		Threshold:  threshold,
+		Window:     window,
+		Cooldown:   cooldown,
+		Summary: func(providerID string, n int, window time.Duration) string {
+			return fmt.Sprintf("%d token refreshes against provider %s failed within %s", n, providerID, window)
+		},
+	}
+}
@@ -0,0 +1,198 @@
+package anomaly
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/internal/store"
+)
+
+// Resolver maps a client IP to its origin, such as an ASN or country. An
+// empty origin is not tracked.
+type Resolver interface {
+	Origin(ip net.IP) string
+}
+
+// PrefixResolver uses the IP's network prefix as its origin: a coarse
+// stand-in for an ASN when no origin table is configured.
+type PrefixResolver struct {
+	// IPv4Bits and IPv6Bits are the prefix lengths; zero means /24 and /48.
+	IPv4Bits int
+	IPv6Bits int
+}
+
+func (p PrefixResolver) Origin(ip net.IP) string {
+	bits, size := p.IPv6Bits, 128
+	if ip4 := ip.To4(); ip4 != nil {
+		ip, bits, size = ip4, p.IPv4Bits, 32
+		if bits <= 0 {
+			bits = 24
+		}
+	} else if bits <= 0 {
+		bits = 48
+	}
+	mask := net.CIDRMask(bits, size)
+	return (&net.IPNet{IP: ip.Mask(mask), Mask: mask}).String()
+}
+
+// TableResolver looks origins up in a table of CIDR ranges, using the most
+// specific range containing the IP and Fallback for IPs in none.
+type TableResolver struct {
+	Fallback Resolver
+
+	// ranges maps a prefix length to the origins of the networks of that
+	// length, keyed by network; bits lists the lengths, longest first.
+	ranges map[int]map[string]string
+	bits   []int
+}
+
+// LoadTable reads a TableResolver from a CSV file of "cidr,origin" rows,
+// such as an IP-to-ASN or IP-to-country export. Blank lines and lines
+// starting with # are skipped.
+func LoadTable(path string, fallback Resolver) (*TableResolver, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	t := &TableResolver{Fallback: fallback, ranges: make(map[int]map[string]string)}
+	r := csv.NewReader(f)
+	r.Comment = '#'
+	r.FieldsPerRecord = 2
+	r.TrimLeadingSpace = true
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		_, network, err := net.ParseCIDR(strings.TrimSpace(rec[0]))
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid CIDR %q", path, rec[0])
+		}
+		ones, size := network.Mask.Size()
+		key := ones
+		if size == 128 {
+			key += 32 + 1 // keep IPv6 lengths apart from IPv4 ones
+		}
+		if t.ranges[key] == nil {
+			t.ranges[key] = make(map[string]string)
+			t.bits = append(t.bits, key)
+		}
+		t.ranges[key][network.String()] = strings.TrimSpace(rec[1])
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(t.bits)))
+	return t, nil
+}
+
+func (t *TableResolver) Origin(ip net.IP) string {
+	size, offset := 128, 32+1
+	if ip4 := ip.To4(); ip4 != nil {
+		ip, size, offset = ip4, 32, 0
+	}
+	for _, key := range t.bits {
+		ones := key - offset
+		if ones < 0 || ones > size {
+			continue
+		}
+		mask := net.CIDRMask(ones, size)
+		if origin, ok := t.ranges[key][(&net.IPNet{IP: ip.Mask(mask), Mask: mask}).String()]; ok {
+			return origin
+		}
+	}
+	if t.Fallback != nil {
+		return t.Fallback.Origin(ip)
+	}
+	return ""
+}
+
+const (
+	defaultMaxOrigins     = 32
+	defaultMaxConnections = 100000
+)
+
+// OriginRule alerts when a connection's token is retrieved from an origin
+// it has not been retrieved from before. The first origin seen for a
+// connection is its baseline and raises no alert.
+type OriginRule struct {
+	Resolver Resolver
+	// MaxOrigins bounds the origins remembered per connection, oldest
+	// forgotten first. Zero means defaultMaxOrigins.
+	MaxOrigins int
+	// MaxConnections bounds the connections tracked; beyond it an
+	// arbitrary connection is forgotten. Zero means defaultMaxConnections.
+	MaxConnections int
+
+	origins map[string][]string
+}
+
+// NewOriginRule returns an OriginRule resolving origins with r.
+func NewOriginRule(r Resolver) *OriginRule {
+	return &OriginRule{Resolver: r}
+}
+
+func (r *OriginRule) Name() string { return "token_retrieval_new_origin" }
+
+func (r *OriginRule) Evaluate(e store.AuditEvent, now time.Time) []Alert {
+	if e.EventType != "token_retrieved" || e.ConnectionID == nil {
+		return nil
+	}
+	ip := net.ParseIP(deref(e.IPAddress))
+	if ip == nil {
+		return nil
+	}
+	origin := r.Resolver.Origin(ip)
+	if origin == "" {
+		return nil
+	}
+	if r.origins == nil {
+		r.origins = make(map[string][]string)
+	}
+	conn := e.ConnectionID.String()
+	known, tracked := r.origins[conn]
+	if !tracked {
+		max := r.MaxConnections
+		if max <= 0 {
+			max = defaultMaxConnections
+		}
+		for c := range r.origins {
+			if len(r.origins) < max {
+				break
+			}
+			delete(r.origins, c)
+		}
+		r.origins[conn] = []string{origin}
+		return nil
+	}
+	for _, o := range known {
+		if o == origin {
+			return nil
+		}
+	}
+	max := r.MaxOrigins
+	if max <= 0 {
+		max = defaultMaxOrigins
+	}
+	if known = append(known, origin); len(known) > max {
+		known = known[len(known)-max:]
+	}
+	r.origins[conn] = known
+	return []Alert{{
+		Rule:         r.Name(),
+		Severity:     SeverityWarning,
+		Summary:      fmt.Sprintf("token of connection %s retrieved from new origin %s (previously %s)", conn, origin, strings.Join(known[:len(known)-1], ", ")),
+		Key:          conn,
+		ConnectionID: conn,
+		IPAddress:    ip.String(),
+		Origin:       origin,
+	}}
+}
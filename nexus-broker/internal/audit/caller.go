@@ -0,0 +1,34 @@
+package audit
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+// CallerHeader names the client on whose behalf a trusted service (the
+// Gateway) calls the Broker.
+const CallerHeader = "X-Nexus-Caller"
+
+type callerKey struct{}
+
+// CallerMiddleware records the X-Nexus-Caller header for Log to attach to
+// audit events as "caller". Only requests trusted reports true for, those
+// authenticated with a service's API key, may name a caller; the header is
+// ignored on any other request so clients cannot forge attribution.
+func CallerMiddleware(trusted func(r *http.Request) bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if caller := strings.TrimSpace(r.Header.Get(CallerHeader)); caller != "" && trusted(r) {
+				r = r.WithContext(context.WithValue(r.Context(), callerKey{}, caller))
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// CallerFrom returns the caller recorded by CallerMiddleware, or "".
+func CallerFrom(ctx context.Context) string {
+	caller, _ := ctx.Value(callerKey{}).(string)
+	return caller
+}
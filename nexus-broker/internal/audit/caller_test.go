@@ -0,0 +1,40 @@
+package audit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/internal/store"
+)
+
+func TestLog_RecordsCaller(t *testing.T) {
+	st := store.NewMemory()
+	svc := NewServiceWithStore(st)
+	data := map[string]interface{}{"provider": "acme"}
+
+	trusted := func(r *http.Request) bool { return r.Header.Get("X-API-Key") == "gateway-key" }
+
+	h := CallerMiddleware(trusted)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, svc.Log("token_retrieved", nil, data, r))
+	}))
+	req := httptest.NewRequest(http.MethodGet, "/connections/x/token", nil)
+	req.Header.Set("X-API-Key", "gateway-key")
+	req.Header.Set(CallerHeader, "billing")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/connections/x/token", nil))
+	forged := httptest.NewRequest(http.MethodGet, "/connections/x/token", nil)
+	forged.Header.Set(CallerHeader, "billing")
+	h.ServeHTTP(httptest.NewRecorder(), forged)
+
+	events := st.Events()
+	require.Len(t, events, 3)
+	require.NotNil(t, events[0].EventData)
+	assert.JSONEq(t, `{"provider":"acme","caller":"billing"}`, *events[0].EventData)
+	assert.JSONEq(t, `{"provider":"acme"}`, *events[1].EventData)
+	assert.JSONEq(t, `{"provider":"acme"}`, *events[2].EventData, "an unauthenticated request cannot name a caller")
+	assert.NotContains(t, data, "caller", "the caller's map is not modified")
+}
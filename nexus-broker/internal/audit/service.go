@@ -1,22 +1,43 @@
 package audit
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"net"
 	"net/http"
-	"strings"
 
 	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/internal/store"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/httputil"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/redact"
 )
 
 type Service struct {
-	db *sqlx.DB
+	store      store.AuditStore
+	publishers []Publisher
+}
+
+// Publisher receives each audit event once it is stored, for example to
+// ship it to a SIEM (see auditsink.Shipper). Publish must not block.
+type Publisher interface {
+	Publish(e store.AuditEvent)
 }
 
 func NewService(db *sqlx.DB) *Service {
-	return &Service{db: db}
+	return NewServiceWithStore(store.NewPostgres(db))
+}
+
+// NewServiceWithStore writes audit events to store.
+func NewServiceWithStore(s store.AuditStore) *Service {
+	return &Service{store: s}
+}
+
+// AddPublisher makes s hand every stored event to p, after the publishers
+// added before it. It must be called before s is used.
+func (s *Service) AddPublisher(p Publisher) {
+	s.publishers = append(s.publishers, p)
 }
 
 func (s *Service) Log(eventType string, connectionID *uuid.UUID, data map[string]interface{}, r *http.Request) error {
@@ -24,17 +45,11 @@ func (s *Service) Log(eventType string, connectionID *uuid.UUID, data map[string
 	var userAgent *string
 
 	if r != nil {
-		// Extract IP — validate with net.ParseIP to avoid storing arbitrary text in the inet column.
-		if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
-			ip := strings.TrimSpace(strings.Split(fwd, ",")[0])
-			if net.ParseIP(ip) != nil {
-				ipVal = &ip
-			}
-		} else {
-			host, _, err := net.SplitHostPort(r.RemoteAddr)
-			if err == nil && net.ParseIP(host) != nil {
-				ipVal = &host
-			}
+		// The server has already resolved RemoteAddr to the client IP past
+		// any trusted proxies; X-Forwarded-For is client-controlled.
+		if ip := httputil.RemoteIP(r); ip != nil {
+			addr := ip.String()
+			ipVal = &addr
 		}
 
 		// Extract User-Agent
@@ -44,6 +59,19 @@ func (s *Service) Log(eventType string, connectionID *uuid.UUID, data map[string
 		}
 	}
 
+	if r != nil {
+		if caller := CallerFrom(r.Context()); caller != "" {
+			if _, set := data["caller"]; !set {
+				withCaller := make(map[string]interface{}, len(data)+1)
+				for k, v := range data {
+					withCaller[k] = v
+				}
+				withCaller["caller"] = caller
+				data = withCaller
+			}
+		}
+	}
+
 	var eventDataJSON []byte
 	if data != nil {
 		var err error
@@ -53,15 +81,26 @@ func (s *Service) Log(eventType string, connectionID *uuid.UUID, data map[string
 		}
 	}
 
-	query := `
-		INSERT INTO audit_events (connection_id, event_type, event_data, ip_address, user_agent)
-		VALUES ($1, $2, $3, $4, $5)`
-
-	var eventDataArg interface{}
+	e := &store.AuditEvent{
+		ConnectionID: connectionID,
+		EventType:    eventType,
+		IPAddress:    ipVal,
+		UserAgent:    userAgent,
+	}
 	if len(eventDataJSON) > 0 {
-		eventDataArg = string(eventDataJSON)
+		eventData := redact.String(string(eventDataJSON))
+		e.EventData = &eventData
 	}
 
-	_, err := s.db.Exec(query, connectionID, eventType, eventDataArg, ipVal, userAgent)
-	return err
+	ctx := context.Background()
+	if r != nil {
+		ctx = r.Context()
+	}
+	if err := s.store.CreateAuditEvent(ctx, e); err != nil {
+		return err
+	}
+	for _, p := range s.publishers {
+		p.Publish(*e)
+	}
+	return nil
 }
@@ -0,0 +1,26 @@
+package audit
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/internal/store"
+)
+
+type publisherFunc func(e store.AuditEvent)
+
+func (f publisherFunc) Publish(e store.AuditEvent) { f(e) }
+
+func TestLog_PublishesStoredEvent(t *testing.T) {
+	svc := NewServiceWithStore(store.NewMemory())
+	var published []store.AuditEvent
+	svc.AddPublisher(publisherFunc(func(e store.AuditEvent) { published = append(published, e) }))
+
+	require.NoError(t, svc.Log("connection_revoked", nil, nil, nil))
+
+	require.Len(t, published, 1)
+	assert.Equal(t, "connection_revoked", published[0].EventType)
+	assert.False(t, published[0].CreatedAt.IsZero(), "the event is published after the store fills it in")
+}
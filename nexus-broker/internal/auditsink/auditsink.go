@@ -0,0 +1,311 @@
+// Package auditsink ships audit events to external systems such as a SIEM.
+//
+// Events are stored in audit_events first; a Shipper then hands copies to
+// each configured Sink from a bounded in-memory buffer, in batches, retrying
+// failed batches with exponential backoff, so a sink may see an event more
+// than once. A full buffer, a batch that keeps failing, or a crash loses
+// events from the sink (never from audit_events); each loss is counted in
+// audit_sink_events_dropped_total.
+package auditsink
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/internal/store"
+)
+
+// Sink delivers a batch of audit events to one external system.
+type Sink interface {
+	// Name identifies the sink in logs and metrics.
+	Name() string
+	// Send delivers events, oldest first. An error wrapped with Permanent
+	// drops the batch without retrying it.
+	Send(ctx context.Context, events []store.AuditEvent) error
+}
+
+// Reasons recorded in audit_sink_events_dropped_total.
+const (
+	DropBufferFull = "buffer_full"
+	DropSendFailed = "send_failed"
+)
+
+var (
+	metricSent = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "audit_sink_events_sent_total",
+		Help: "Audit events delivered to an external sink",
+	}, []string{"sink"})
+	metricDropped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "audit_sink_events_dropped_total",
+		Help: "Audit events not delivered to an external sink, by reason",
+	}, []string{"sink", "reason"})
+	metricRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "audit_sink_send_retries_total",
+		Help: "Retried audit sink batch deliveries",
+	}, []string{"sink"})
+	metricBuffered = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "audit_sink_buffered_events",
+		Help: "Audit events waiting in a sink's buffer",
+	}, []string{"sink"})
+)
+
+func init() {
+	prometheus.MustRegister(metricSent, metricDropped, metricRetries, metricBuffered)
+}
+
+// Config tunes buffering and retries. Zero fields other than MaxRetries
+// take the defaults below.
+type Config struct {
+	// BufferSize is how many events each sink buffers before new ones are
+	// dropped.
+	BufferSize int
+	// BatchSize is the most events sent in one batch.
+	BatchSize int
+	// FlushInterval is how long a partial batch waits for more events.
+	FlushInterval time.Duration
+	// MaxRetries is how many times a failed batch is retried before it is
+	// dropped; zero sends each batch once.
+	MaxRetries int
+	// RetryBackoff is the first delay between retries; it doubles up to
+	// maxRetryBackoff.
+	RetryBackoff time.Duration
+}
+
+const (
+	defaultBufferSize    = 10000
+	defaultBatchSize     = 100
+	defaultFlushInterval = 5 * time.Second
+	defaultRetryBackoff  = time.Second
+	maxRetryBackoff      = time.Minute
+)
+
+func (c Config) withDefaults() Config {
+	if c.BufferSize <= 0 {
+		c.BufferSize = defaultBufferSize
+	}
+	if c.BatchSize <= 0 {
+		c.BatchSize = defaultBatchSize
+	}
+	if c.FlushInterval <= 0 {
+		c.FlushInterval = defaultFlushInterval
+	}
+	if c.MaxRetries < 0 {
+		c.MaxRetries = 0
+	}
+	if c.RetryBackoff <= 0 {
+		c.RetryBackoff = defaultRetryBackoff
+	}
+	return c
+}
+
+// permanentError marks a Send failure that retrying cannot fix.
+type permanentError struct{ err error }
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// Permanent wraps err so the Shipper drops the batch instead of retrying.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return permanentError{err}
+}
+
+// Shipper fans audit events out to sinks. A nil *Shipper discards events,
+// so callers need not check whether any sink is configured.
+type Shipper struct {
+	cfg     Config
+	workers []*worker
+	wg      sync.WaitGroup
+
+	mu       sync.RWMutex
+	closed   bool
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+type worker struct {
+	sink  Sink
+	queue chan store.AuditEvent
+}
+
+// New starts a Shipper delivering to sinks, or returns nil if there are
+// none.
+func New(cfg Config, sinks ...Sink) *Shipper {
+	if len(sinks) == 0 {
+		return nil
+	}
+	s := &Shipper{cfg: cfg.withDefaults(), stop: make(chan struct{})}
+	for _, sink := range sinks {
+		w := &worker{sink: sink, queue: make(chan store.AuditEvent, s.cfg.BufferSize)}
+		s.workers = append(s.workers, w)
+		s.wg.Add(1)
+		go s.run(w)
+	}
+	return s
+}
+
+// Publish queues e for every sink without blocking. Sinks whose buffer is
+// full drop it.
+func (s *Shipper) Publish(e store.AuditEvent) {
+	if s == nil {
+		return
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		return
+	}
+	for _, w := range s.workers {
+		select {
+		case w.queue <- e:
+			metricBuffered.WithLabelValues(w.sink.Name()).Inc()
+		default:
+			metricDropped.WithLabelValues(w.sink.Name(), DropBufferFull).Inc()
+		}
+	}
+}
+
+// Close stops accepting events and flushes the buffered ones, giving up
+// on retries (and dropping what is left) when ctx is done.
+func (s *Shipper) Close(ctx context.Context) error {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	if !s.closed {
+		s.closed = true
+		for _, w := range s.workers {
+			close(w.queue)
+		}
+	}
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		s.stopOnce.Do(func() { close(s.stop) })
+		<-done
+		return ctx.Err()
+	}
+}
+
+func (s *Shipper) run(w *worker) {
+	defer s.wg.Done()
+	name := w.sink.Name()
+	ticker := time.NewTicker(s.cfg.FlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]store.AuditEvent, 0, s.cfg.BatchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		metricBuffered.WithLabelValues(name).Sub(float64(len(batch)))
+		select {
+		case <-s.stop:
+			metricDropped.WithLabelValues(name, DropSendFailed).Add(float64(len(batch)))
+		default:
+			s.deliver(w.sink, batch)
+		}
+		batch = batch[:0]
+	}
+	for {
+		select {
+		case e, ok := <-w.queue:
+			if !ok {
+				flush()
+				return
+			}
+			if batch = append(batch, e); len(batch) >= s.cfg.BatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// deliver sends batch, retrying with backoff until it succeeds, fails
+// permanently, runs out of retries or the Shipper is stopped.
+func (s *Shipper) deliver(sink Sink, batch []store.AuditEvent) {
+	name := sink.Name()
+	delay := s.cfg.RetryBackoff
+	for attempt := 0; ; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+		err := sink.Send(ctx, batch)
+		cancel()
+		if err == nil {
+			metricSent.WithLabelValues(name).Add(float64(len(batch)))
+			return
+		}
+		var perm permanentError
+		if errors.As(err, &perm) || attempt >= s.cfg.MaxRetries {
+			log.Printf("audit sink %s: dropping %d events: %v", name, len(batch), err)
+			metricDropped.WithLabelValues(name, DropSendFailed).Add(float64(len(batch)))
+			return
+		}
+		log.Printf("audit sink %s: send failed, retrying in %s: %v", name, delay, err)
+		metricRetries.WithLabelValues(name).Inc()
+		select {
+		case <-time.After(delay):
+		case <-s.stop:
+			metricDropped.WithLabelValues(name, DropSendFailed).Add(float64(len(batch)))
+			return
+		}
+		if delay *= 2; delay > maxRetryBackoff {
+			delay = maxRetryBackoff
+		}
+	}
+}
+
+// sendTimeout bounds each Send call.
+const sendTimeout = 30 * time.Second
+
+// Record is the JSON form of an audit event sent to sinks. Unlike the
+// stored row, event_data is embedded as an object when it holds JSON, so
+// SIEMs can index its fields.
+type Record struct {
+	ID           string          `json:"id"`
+	ConnectionID string          `json:"connection_id,omitempty"`
+	EventType    string          `json:"event_type"`
+	EventData    json.RawMessage `json:"event_data,omitempty"`
+	IPAddress    string          `json:"ip_address,omitempty"`
+	UserAgent    string          `json:"user_agent,omitempty"`
+	CreatedAt    time.Time       `json:"created_at"`
+}
+
+// NewRecord converts a stored audit event.
+func NewRecord(e store.AuditEvent) Record {
+	r := Record{ID: e.ID.String(), EventType: e.EventType, CreatedAt: e.CreatedAt.UTC()}
+	if e.ConnectionID != nil {
+		r.ConnectionID = e.ConnectionID.String()
+	}
+	if e.EventData != nil && *e.EventData != "" {
+		if json.Valid([]byte(*e.EventData)) {
+			r.EventData = json.RawMessage(*e.EventData)
+		} else {
+			r.EventData, _ = json.Marshal(*e.EventData)
+		}
+	}
+	if e.IPAddress != nil {
+		r.IPAddress = *e.IPAddress
+	}
+	if e.UserAgent != nil {
+		r.UserAgent = *e.UserAgent
+	}
+	return r
+}
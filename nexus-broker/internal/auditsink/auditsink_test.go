@@ -0,0 +1,258 @@
+package auditsink
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/internal/store"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/internal/webhook"
+)
+
+// fakeSink records batches and fails the first failures sends.
+type fakeSink struct {
+	name     string
+	mu       sync.Mutex
+	failures int
+	err      error
+	batches  [][]store.AuditEvent
+}
+
+func (f *fakeSink) Name() string { return f.name }
+
+func (f *fakeSink) Send(ctx context.Context, events []store.AuditEvent) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.failures > 0 {
+		f.failures--
+		return f.err
+	}
+	f.batches = append(f.batches, append([]store.AuditEvent(nil), events...))
+	return nil
+}
+
+func (f *fakeSink) sent() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := 0
+	for _, b := range f.batches {
+		n += len(b)
+	}
+	return n
+}
+
+func event(eventType string) store.AuditEvent {
+	return store.AuditEvent{ID: uuid.New(), EventType: eventType, CreatedAt: time.Now()}
+}
+
+func TestShipper_BatchesAndFlushesOnClose(t *testing.T) {
+	sink := &fakeSink{name: "batch-test"}
+	s := New(Config{BatchSize: 2, FlushInterval: time.Hour}, sink)
+	for i := 0; i < 5; i++ {
+		s.Publish(event("token_retrieved"))
+	}
+	require.NoError(t, s.Close(context.Background()))
+
+	require.Len(t, sink.batches, 3)
+	assert.Len(t, sink.batches[0], 2)
+	assert.Len(t, sink.batches[2], 1, "the partial batch is flushed on close")
+	assert.Equal(t, 5.0, testutil.ToFloat64(metricSent.WithLabelValues("batch-test")))
+
+	s.Publish(event("token_retrieved"))
+	assert.Equal(t, 5, sink.sent(), "events published after Close are discarded")
+}
+
+func TestShipper_RetriesThenDrops(t *testing.T) {
+	sink := &fakeSink{name: "retry-test", failures: 1, err: errors.New("unavailable")}
+	s := New(Config{BatchSize: 1, MaxRetries: 1, RetryBackoff: time.Millisecond}, sink)
+	s.Publish(event("a"))
+	require.NoError(t, s.Close(context.Background()))
+	assert.Equal(t, 1, sink.sent())
+	assert.Equal(t, 1.0, testutil.ToFloat64(metricRetries.WithLabelValues("retry-test")))
+
+	sink = &fakeSink{name: "drop-test", failures: 10, err: errors.New("unavailable")}
+	s = New(Config{BatchSize: 1, MaxRetries: 2, RetryBackoff: time.Millisecond}, sink)
+	s.Publish(event("a"))
+	require.NoError(t, s.Close(context.Background()))
+	assert.Equal(t, 0, sink.sent())
+	assert.Equal(t, 7, sink.failures, "one send and two retries")
+	assert.Equal(t, 1.0, testutil.ToFloat64(metricDropped.WithLabelValues("drop-test", DropSendFailed)))
+}
+
+func TestShipper_PermanentErrorIsNotRetried(t *testing.T) {
+	sink := &fakeSink{name: "permanent-test", failures: 10, err: Permanent(errors.New("bad request"))}
+	s := New(Config{BatchSize: 1, MaxRetries: 5, RetryBackoff: time.Millisecond}, sink)
+	s.Publish(event("a"))
+	require.NoError(t, s.Close(context.Background()))
+	assert.Equal(t, 9, sink.failures)
+}
+
+// blockingSink blocks every Send until release is closed.
+type blockingSink struct{ release chan struct{} }
+
+func (b *blockingSink) Name() string { return "full-test" }
+
+func (b *blockingSink) Send(ctx context.Context, events []store.AuditEvent) error {
+	<-b.release
+	return nil
+}
+
+func TestShipper_DropsWhenBufferFull(t *testing.T) {
+	sink := &blockingSink{release: make(chan struct{})}
+	s := New(Config{BufferSize: 1, BatchSize: 1}, sink)
+	// The worker takes the first event and blocks in Send; the second fills
+	// the buffer and the rest are dropped.
+	s.Publish(event("a"))
+	require.Eventually(t, func() bool { return len(s.workers[0].queue) == 0 }, time.Second, time.Millisecond)
+	for i := 0; i < 3; i++ {
+		s.Publish(event("a"))
+	}
+	assert.Equal(t, 2.0, testutil.ToFloat64(metricDropped.WithLabelValues("full-test", DropBufferFull)))
+	close(sink.release)
+	require.NoError(t, s.Close(context.Background()))
+}
+
+func TestNilShipperDiscards(t *testing.T) {
+	s := New(Config{})
+	assert.Nil(t, s)
+	s.Publish(event("a"))
+	assert.NoError(t, s.Close(context.Background()))
+}
+
+func TestNewRecord_EmbedsJSONEventData(t *testing.T) {
+	data := `{"provider":"google"}`
+	connID := uuid.New()
+	r := NewRecord(store.AuditEvent{ID: uuid.New(), ConnectionID: &connID, EventType: "token_retrieved", EventData: &data})
+	b, err := json.Marshal(r)
+	require.NoError(t, err)
+	assert.Contains(t, string(b), `"event_data":{"provider":"google"}`)
+	assert.Contains(t, string(b), connID.String())
+
+	text := "not json"
+	r = NewRecord(store.AuditEvent{EventData: &text})
+	assert.Equal(t, `"not json"`, string(r.EventData))
+}
+
+func TestWebhookSink_SignsBatch(t *testing.T) {
+	secret := []byte("shh")
+	var got struct {
+		Events []Record `json:"events"`
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		assert.Equal(t, "sha256="+webhook.Sign(secret, body), r.Header.Get(webhook.SignatureHeader))
+		require.NoError(t, json.Unmarshal(body, &got))
+	}))
+	defer srv.Close()
+
+	sink := &WebhookSink{URL: srv.URL, Secret: secret, Client: srv.Client()}
+	require.NoError(t, sink.Send(context.Background(), []store.AuditEvent{event("a"), event("b")}))
+	require.Len(t, got.Events, 2)
+	assert.Equal(t, "b", got.Events[1].EventType)
+}
+
+func TestWebhookSink_ClientErrorIsPermanent(t *testing.T) {
+	status := http.StatusBadRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+	sink := &WebhookSink{URL: srv.URL, Client: srv.Client()}
+
+	var perm permanentError
+	assert.ErrorAs(t, sink.Send(context.Background(), []store.AuditEvent{event("a")}), &perm)
+	status = http.StatusServiceUnavailable
+	err := sink.Send(context.Background(), []store.AuditEvent{event("a")})
+	require.Error(t, err)
+	assert.False(t, errors.As(err, &perm), "5xx is retried")
+}
+
+func TestKafkaSink_ProducesKeyedRecords(t *testing.T) {
+	connID := uuid.New()
+	var body struct {
+		Records []struct {
+			Key   string `json:"key"`
+			Value Record `json:"value"`
+		} `json:"records"`
+	}
+	fail := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/topics/nexus.audit", r.URL.Path)
+		assert.Equal(t, "application/vnd.kafka.json.v2+json", r.Header.Get("Content-Type"))
+		user, pass, _ := r.BasicAuth()
+		assert.Equal(t, "svc:secret", user+":"+pass)
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		if fail {
+			w.Write([]byte(`{"offsets":[{"partition":0,"offset":1},{"error_code":50003,"error":"Kafka error"}]}`))
+			return
+		}
+		w.Write([]byte(`{"offsets":[{"partition":0,"offset":1},{"partition":0,"offset":2}]}`))
+	}))
+	defer srv.Close()
+
+	e := event("connection_revoked")
+	e.ConnectionID = &connID
+	sink := &KafkaSink{RESTURL: srv.URL + "/", Topic: "nexus.audit", Username: "svc", Password: "secret", Client: srv.Client()}
+	require.NoError(t, sink.Send(context.Background(), []store.AuditEvent{e, event("provider.created")}))
+	require.Len(t, body.Records, 2)
+	assert.Equal(t, connID.String(), body.Records[0].Key)
+	assert.Equal(t, "provider.created", body.Records[1].Key, "events without a connection are keyed by type")
+
+	fail = true
+	assert.ErrorContains(t, sink.Send(context.Background(), []store.AuditEvent{e, e}), "1 of 2 records failed")
+}
+
+func TestSyslogSink_TCPOctetCountedCEF(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	got := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		n, _ := r.ReadString(' ')
+		size, _ := strconv.Atoi(strings.TrimSpace(n))
+		msg := make([]byte, size)
+		io.ReadFull(r, msg)
+		got <- string(msg)
+	}()
+
+	ua := "curl|7=8"
+	e := event("refresh_token_reuse_detected")
+	e.UserAgent = &ua
+	sink := &SyslogSink{Network: "tcp", Addr: ln.Addr().String(), Format: SyslogFormatCEF, Version: "1.4.0"}
+	require.NoError(t, sink.Send(context.Background(), []store.AuditEvent{e}))
+
+	msg := <-got
+	// Facility 13 (log audit) at warning severity.
+	assert.True(t, strings.HasPrefix(msg, "<108>1 "), msg)
+	assert.Contains(t, msg, " nexus-broker ")
+	assert.Contains(t, msg, "CEF:0|Prescott Data|Nexus Broker|1.4.0|refresh_token_reuse_detected|refresh_token_reuse_detected|8|")
+	assert.Contains(t, msg, `requestClientApplication=curl|7\=8`)
+}
+
+func TestCEFHeaderEscaping(t *testing.T) {
+	assert.Equal(t, `a\|b\\c`, cefHeader(`a|b\c`))
+	assert.Equal(t, `a\=b\nc`, cefValue("a=b\nc"))
+	assert.Equal(t, "provider.created", syslogMsgID("provider.created"))
+	assert.Equal(t, "a_b", syslogMsgID("a b"))
+}
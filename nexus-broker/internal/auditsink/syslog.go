@@ -0,0 +1,205 @@
+package auditsink
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/internal/store"
+)
+
+// Message formats of SyslogSink.
+const (
+	SyslogFormatCEF  = "cef"
+	SyslogFormatJSON = "json"
+)
+
+// syslogFacilityAudit is the RFC 5424 "log audit" facility.
+const syslogFacilityAudit = 13
+
+// Syslog severities used for audit events.
+const (
+	syslogWarning = 4
+	syslogNotice  = 5
+	syslogInfo    = 6
+)
+
+// SyslogSink writes each event as an RFC 5424 syslog message whose body is
+// either an ArcSight CEF record or the JSON Record. Over tcp and tls,
+// messages are framed with octet counting (RFC 6587, RFC 5425); over udp
+// each message is one datagram. The connection is opened lazily and
+// reopened after a write error.
+type SyslogSink struct {
+	// Network is udp, tcp or tls.
+	Network string
+	Addr    string
+	// Format is SyslogFormatCEF or SyslogFormatJSON.
+	Format string
+	// Version is reported as the CEF device version.
+	Version string
+	// TLSConfig is used with the tls network; nil verifies against the
+	// system roots.
+	TLSConfig *tls.Config
+
+	mu       sync.Mutex
+	conn     net.Conn
+	hostname string
+}
+
+func (s *SyslogSink) Name() string { return "syslog" }
+
+func (s *SyslogSink) Send(ctx context.Context, events []store.AuditEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		if err := s.dial(ctx); err != nil {
+			return err
+		}
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		s.conn.SetWriteDeadline(deadline)
+	}
+	for _, e := range events {
+		msg, err := s.message(e)
+		if err != nil {
+			return Permanent(err)
+		}
+		if s.Network != "udp" {
+			msg = strconv.Itoa(len(msg)) + " " + msg
+		}
+		if _, err := s.conn.Write([]byte(msg)); err != nil {
+			s.conn.Close()
+			s.conn = nil
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *SyslogSink) dial(ctx context.Context) error {
+	if s.hostname == "" {
+		s.hostname, _ = os.Hostname()
+		if s.hostname == "" {
+			s.hostname = "-"
+		}
+	}
+	d := &net.Dialer{Timeout: 10 * time.Second}
+	var err error
+	switch s.Network {
+	case "tls":
+		cfg := s.TLSConfig
+		if cfg == nil {
+			host, _, _ := net.SplitHostPort(s.Addr)
+			cfg = &tls.Config{ServerName: host, MinVersion: tls.VersionTLS12}
+		}
+		s.conn, err = (&tls.Dialer{NetDialer: d, Config: cfg}).DialContext(ctx, "tcp", s.Addr)
+	case "udp", "tcp":
+		s.conn, err = d.DialContext(ctx, s.Network, s.Addr)
+	default:
+		return Permanent(fmt.Errorf("unsupported syslog network %q", s.Network))
+	}
+	return err
+}
+
+// message formats e as an RFC 5424 message.
+func (s *SyslogSink) message(e store.AuditEvent) (string, error) {
+	var body string
+	if s.Format == SyslogFormatJSON {
+		b, err := json.Marshal(NewRecord(e))
+		if err != nil {
+			return "", err
+		}
+		body = string(b)
+	} else {
+		body = cefMessage(e, s.Version)
+	}
+	pri := syslogFacilityAudit*8 + syslogSeverity(e.EventType)
+	return fmt.Sprintf("<%d>1 %s %s nexus-broker %d %s - %s",
+		pri, e.CreatedAt.UTC().Format(time.RFC3339Nano), s.hostname, os.Getpid(), syslogMsgID(e.EventType), body), nil
+}
+
+// syslogMsgID fits an event type into the MSGID field (printable ASCII, at
+// most 32 characters).
+func syslogMsgID(eventType string) string {
+	id := strings.Map(func(r rune) rune {
+		if r < 33 || r > 126 {
+			return '_'
+		}
+		return r
+	}, eventType)
+	if len(id) > 32 {
+		id = id[:32]
+	}
+	if id == "" {
+		return "-"
+	}
+	return id
+}
+
+// suspiciousEvents are raised to syslog warning and CEF severity 8.
+var suspiciousEvents = map[string]bool{
+	"refresh_token_reuse_detected": true,
+}
+
+// noticeWords mark events, such as revocations and failures, raised to
+// syslog notice and CEF severity 5.
+var noticeWords = []string{"revoked", "deleted", "purged", "deprovisioned", "failed", "fatal", "error"}
+
+func syslogSeverity(eventType string) int {
+	if suspiciousEvents[eventType] {
+		return syslogWarning
+	}
+	for _, w := range noticeWords {
+		if strings.Contains(eventType, w) {
+			return syslogNotice
+		}
+	}
+	return syslogInfo
+}
+
+func cefSeverity(eventType string) int {
+	switch syslogSeverity(eventType) {
+	case syslogWarning:
+		return 8
+	case syslogNotice:
+		return 5
+	}
+	return 3
+}
+
+// cefMessage formats e as an ArcSight CEF record.
+func cefMessage(e store.AuditEvent, version string) string {
+	if version == "" {
+		version = "dev"
+	}
+	ext := []string{"rt=" + strconv.FormatInt(e.CreatedAt.UnixMilli(), 10), "externalId=" + cefValue(e.ID.String())}
+	if e.IPAddress != nil {
+		ext = append(ext, "src="+cefValue(*e.IPAddress))
+	}
+	if e.UserAgent != nil {
+		ext = append(ext, "requestClientApplication="+cefValue(*e.UserAgent))
+	}
+	if e.ConnectionID != nil {
+		ext = append(ext, "cs1Label=connectionId", "cs1="+cefValue(e.ConnectionID.String()))
+	}
+	if e.EventData != nil {
+		ext = append(ext, "msg="+cefValue(*e.EventData))
+	}
+	return fmt.Sprintf("CEF:0|Prescott Data|Nexus Broker|%s|%s|%s|%d|%s",
+		cefHeader(version), cefHeader(e.EventType), cefHeader(e.EventType), cefSeverity(e.EventType), strings.Join(ext, " "))
+}
+
+var (
+	cefHeaderEscaper = strings.NewReplacer(`\`, `\\`, `|`, `\|`, "\n", " ", "\r", " ")
+	cefValueEscaper  = strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\n", `\n`, "\r", `\r`)
+)
+
+func cefHeader(v string) string { return cefHeaderEscaper.Replace(v) }
+func cefValue(v string) string  { return cefValueEscaper.Replace(v) }
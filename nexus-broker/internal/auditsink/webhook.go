@@ -0,0 +1,67 @@
+package auditsink
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/internal/store"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/internal/webhook"
+)
+
+// WebhookSink POSTs each batch as {"events": [...]} to URL, signed like the
+// lifecycle webhooks with webhook.SignatureHeader when Secret is set.
+type WebhookSink struct {
+	URL    string
+	Secret []byte
+	// Client defaults to a client with a 10 second timeout.
+	Client *http.Client
+}
+
+func (s *WebhookSink) Name() string { return "webhook" }
+
+func (s *WebhookSink) Send(ctx context.Context, events []store.AuditEvent) error {
+	records := make([]Record, len(events))
+	for i, e := range events {
+		records[i] = NewRecord(e)
+	}
+	body, err := json.Marshal(map[string]interface{}{"events": records})
+	if err != nil {
+		return Permanent(fmt.Errorf("marshal: %w", err))
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL, bytes.NewReader(body))
+	if err != nil {
+		return Permanent(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if len(s.Secret) > 0 {
+		req.Header.Set(webhook.SignatureHeader, "sha256="+webhook.Sign(s.Secret, body))
+	}
+	client := s.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return checkStatus(resp)
+}
+
+// checkStatus turns a non-2xx response into an error, permanent for client
+// errors other than 408 and 429.
+func checkStatus(resp *http.Response) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	err := fmt.Errorf("endpoint returned %d", resp.StatusCode)
+	if resp.StatusCode >= 400 && resp.StatusCode < 500 &&
+		resp.StatusCode != http.StatusRequestTimeout && resp.StatusCode != http.StatusTooManyRequests {
+		return Permanent(err)
+	}
+	return err
+}
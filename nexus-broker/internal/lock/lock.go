@@ -0,0 +1,90 @@
+// Package lock provides named mutual-exclusion locks, held in Redis so they
+// are shared by every broker replica, or in process memory.
+package lock
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrTimeout is returned by Acquire when the lock could not be taken before
+// the context ended.
+var ErrTimeout = errors.New("lock: timed out waiting for lock")
+
+// pollInterval is how often a waiting Acquire retries a held lock.
+const pollInterval = 50 * time.Millisecond
+
+// Locker acquires named locks. A held lock expires after ttl even if it is
+// never released, so a crashed holder cannot block others forever.
+type Locker interface {
+	// Acquire blocks until the lock named key is held or ctx ends, and
+	// returns a function that releases it.
+	Acquire(ctx context.Context, key string, ttl time.Duration) (release func(), err error)
+}
+
+// Local is a Locker whose locks are only visible to this process.
+type Local struct {
+	mu    sync.Mutex
+	held  map[string]localLock
+	now   func() time.Time
+	token uint64
+}
+
+type localLock struct {
+	token   uint64
+	expires time.Time
+}
+
+var _ Locker = (*Local)(nil)
+
+// NewLocal creates an empty Local.
+func NewLocal() *Local {
+	return &Local{held: map[string]localLock{}, now: time.Now}
+}
+
+// Acquire implements Locker.
+func (l *Local) Acquire(ctx context.Context, key string, ttl time.Duration) (func(), error) {
+	for {
+		if token, ok := l.tryAcquire(key, ttl); ok {
+			return func() { l.release(key, token) }, nil
+		}
+		if err := wait(ctx); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (l *Local) tryAcquire(key string, ttl time.Duration) (uint64, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if h, ok := l.held[key]; ok && l.now().Before(h.expires) {
+		return 0, false
+	}
+	l.token++
+	l.held[key] = localLock{token: l.token, expires: l.now().Add(ttl)}
+	return l.token, true
+}
+
+// release frees key only if token still owns it, so a holder whose lock
+// expired cannot release a lock someone else has since taken.
+func (l *Local) release(key string, token uint64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.held[key].token == token {
+		delete(l.held, key)
+	}
+}
+
+// wait sleeps for pollInterval, returning ErrTimeout if ctx ends first.
+func wait(ctx context.Context) error {
+	t := time.NewTimer(pollInterval)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ErrTimeout
+	case <-t.C:
+		return nil
+	}
+}
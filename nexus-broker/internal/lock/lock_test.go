@@ -0,0 +1,95 @@
+package lock
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocal_ExcludesUntilReleasedOrExpired(t *testing.T) {
+	now := time.Now()
+	l := NewLocal()
+	l.now = func() time.Time { return now }
+
+	release, err := l.Acquire(context.Background(), "conn", time.Minute)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 120*time.Millisecond)
+	defer cancel()
+	_, err = l.Acquire(ctx, "conn", time.Minute)
+	assert.ErrorIs(t, err, ErrTimeout)
+
+	// Other keys are independent.
+	other, err := l.Acquire(context.Background(), "other", time.Minute)
+	require.NoError(t, err)
+	other()
+
+	release()
+	second, err := l.Acquire(context.Background(), "conn", time.Minute)
+	require.NoError(t, err)
+
+	// An expired lock can be taken, and the stale release is a no-op.
+	now = now.Add(2 * time.Minute)
+	third, err := l.Acquire(context.Background(), "conn", time.Minute)
+	require.NoError(t, err)
+	second()
+	_, held := l.tryAcquire("conn", time.Minute)
+	assert.False(t, held, "stale release must not free the new holder's lock")
+	third()
+}
+
+func TestRedis_SharedBetweenLockers(t *testing.T) {
+	mr, err := miniredis.Run()
+	require.NoError(t, err)
+	defer mr.Close()
+
+	a := NewRedis(redis.NewClient(&redis.Options{Addr: mr.Addr()}))
+	b := NewRedis(redis.NewClient(&redis.Options{Addr: mr.Addr()}))
+
+	release, err := a.Acquire(context.Background(), "conn", time.Minute)
+	require.NoError(t, err)
+	assert.True(t, mr.Exists("lock:conn"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 120*time.Millisecond)
+	defer cancel()
+	_, err = b.Acquire(ctx, "conn", time.Minute)
+	assert.ErrorIs(t, err, ErrTimeout)
+
+	got := make(chan error, 1)
+	go func() {
+		r, err := b.Acquire(context.Background(), "conn", time.Minute)
+		if err == nil {
+			r()
+		}
+		got <- err
+	}()
+	time.Sleep(60 * time.Millisecond)
+	release()
+	select {
+	case err := <-got:
+		assert.NoError(t, err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("waiter did not acquire the released lock")
+	}
+	assert.False(t, mr.Exists("lock:conn"))
+}
+
+func TestRedis_FallsBackToLocalWhenRedisDown(t *testing.T) {
+	mr, err := miniredis.Run()
+	require.NoError(t, err)
+	l := NewRedis(redis.NewClient(&redis.Options{Addr: mr.Addr(), MaxRetries: -1}))
+	mr.Close()
+
+	release, err := l.Acquire(context.Background(), "conn", time.Minute)
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 120*time.Millisecond)
+	defer cancel()
+	_, err = l.Acquire(ctx, "conn", time.Minute)
+	assert.ErrorIs(t, err, ErrTimeout)
+	release()
+}
@@ -0,0 +1,77 @@
+package lock
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// keyPrefix namespaces locks in Redis.
+const keyPrefix = "lock:"
+
+// releaseScript deletes the lock only if it still holds the caller's token.
+var releaseScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+
+// Redis is a Locker backed by SET NX PX, shared by every process using the
+// same Redis. While Redis is unreachable it falls back to a Local lock, so
+// callers in one process are still serialised.
+type Redis struct {
+	client   redis.UniversalClient
+	fallback *Local
+}
+
+var _ Locker = (*Redis)(nil)
+
+// NewRedis creates a Redis locker.
+func NewRedis(client redis.UniversalClient) *Redis {
+	return &Redis{client: client, fallback: NewLocal()}
+}
+
+// Acquire implements Locker.
+func (r *Redis) Acquire(ctx context.Context, key string, ttl time.Duration) (func(), error) {
+	token, err := newToken()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		ok, err := r.client.SetNX(ctx, keyPrefix+key, token, ttl).Result()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ErrTimeout
+			}
+			log.Printf("lock: redis unavailable, locking %s in process: %v", key, err)
+			return r.fallback.Acquire(ctx, key, ttl)
+		}
+		if ok {
+			return func() { r.release(key, token) }, nil
+		}
+		if err := wait(ctx); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// release uses its own context: the caller's may already be cancelled.
+func (r *Redis) release(key, token string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := releaseScript.Run(ctx, r.client, []string{keyPrefix + key}, token).Err(); err != nil {
+		log.Printf("lock: release %s: %v (it expires on its own)", key, err)
+	}
+}
+
+func newToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,109 @@
+package quota
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// keyPrefix namespaces quota counters in Redis.
+const keyPrefix = "quota:"
+
+// Counter counts events per key in fixed windows identified by their start
+// time.
+type Counter interface {
+	// Incr adds one to key's count in window and returns the new count.
+	// The count is dropped after ttl.
+	Incr(ctx context.Context, key string, window time.Time, ttl time.Duration) (int64, error)
+	// Count returns key's count in window.
+	Count(ctx context.Context, key string, window time.Time) (int64, error)
+}
+
+// Local is a Counter only visible to this process.
+type Local struct {
+	mu     sync.Mutex
+	counts map[string]localCount
+}
+
+type localCount struct {
+	window time.Time
+	n      int64
+}
+
+var _ Counter = (*Local)(nil)
+
+// NewLocal creates an empty Local.
+func NewLocal() *Local {
+	return &Local{counts: map[string]localCount{}}
+}
+
+// Incr implements Counter. Only the latest window of each key is kept.
+func (l *Local) Incr(ctx context.Context, key string, window time.Time, ttl time.Duration) (int64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	c := l.counts[key]
+	if !c.window.Equal(window) {
+		c = localCount{window: window}
+	}
+	c.n++
+	l.counts[key] = c
+	return c.n, nil
+}
+
+// Count implements Counter.
+func (l *Local) Count(ctx context.Context, key string, window time.Time) (int64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if c := l.counts[key]; c.window.Equal(window) {
+		return c.n, nil
+	}
+	return 0, nil
+}
+
+// Redis is a Counter shared by every process using the same Redis. While
+// Redis is unreachable it counts in process, so each replica still
+// enforces the limit on its own share of the traffic.
+type Redis struct {
+	client   redis.UniversalClient
+	fallback *Local
+}
+
+var _ Counter = (*Redis)(nil)
+
+// NewRedis creates a Redis counter.
+func NewRedis(client redis.UniversalClient) *Redis {
+	return &Redis{client: client, fallback: NewLocal()}
+}
+
+// Incr implements Counter.
+func (r *Redis) Incr(ctx context.Context, key string, window time.Time, ttl time.Duration) (int64, error) {
+	k := redisKey(key, window)
+	pipe := r.client.TxPipeline()
+	incr := pipe.Incr(ctx, k)
+	pipe.Expire(ctx, k, ttl)
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Printf("quota: redis unavailable, counting %s in process: %v", key, err)
+		return r.fallback.Incr(ctx, key, window, ttl)
+	}
+	return incr.Val(), nil
+}
+
+// Count implements Counter.
+func (r *Redis) Count(ctx context.Context, key string, window time.Time) (int64, error) {
+	n, err := r.client.Get(ctx, redisKey(key, window)).Int64()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	if err != nil {
+		return r.fallback.Count(ctx, key, window)
+	}
+	return n, nil
+}
+
+func redisKey(key string, window time.Time) string {
+	return keyPrefix + key + ":" + strconv.FormatInt(window.Unix(), 10)
+}
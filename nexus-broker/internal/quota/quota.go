@@ -0,0 +1,176 @@
+// Package quota enforces per-workspace limits on connections and refreshes,
+// so one runaway agent cannot exhaust a provider's rate limits, or the
+// broker, for every other workspace.
+//
+// Each limit has a configured default that a workspace override (see
+// store.WorkspaceLimitStore) replaces. Connection limits count rows in the
+// store; the refresh rate is counted in fixed one-minute windows shared by
+// every replica through a Counter.
+package quota
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/internal/store"
+)
+
+// Names of the limits, as reported in ExceededError and the admin API.
+const (
+	LimitPendingConnections = "max_pending_connections"
+	LimitActiveConnections  = "max_active_connections"
+	LimitRefreshesPerMinute = "max_refreshes_per_minute"
+)
+
+// refreshWindow is the window MaxRefreshesPerMinute is counted in.
+const refreshWindow = time.Minute
+
+// Limits are the quotas applying to a workspace. Zero means unlimited.
+type Limits struct {
+	MaxPendingConnections int `json:"max_pending_connections"`
+	MaxActiveConnections  int `json:"max_active_connections"`
+	MaxRefreshesPerMinute int `json:"max_refreshes_per_minute"`
+}
+
+// Usage is what a workspace currently consumes of its Limits.
+type Usage struct {
+	PendingConnections  int   `json:"pending_connections"`
+	ActiveConnections   int   `json:"active_connections"`
+	RefreshesThisMinute int64 `json:"refreshes_this_minute"`
+}
+
+// ExceededError reports the limit a request would exceed.
+type ExceededError struct {
+	Limit   string
+	Max     int
+	Current int64
+	// RetryAfter is when a rate limit frees up again; zero for connection
+	// limits, which free up only when connections end.
+	RetryAfter time.Duration
+}
+
+func (e *ExceededError) Error() string {
+	return fmt.Sprintf("workspace limit %s exceeded (%d of %d)", e.Limit, e.Current, e.Max)
+}
+
+// Enforcer checks requests against the limits of their workspace.
+type Enforcer struct {
+	store    store.WorkspaceLimitStore
+	defaults Limits
+	counter  Counter
+	now      func() time.Time
+}
+
+// New creates an Enforcer applying defaults to workspaces without
+// overrides in st. counter defaults to a Local one.
+func New(st store.WorkspaceLimitStore, defaults Limits, counter Counter) *Enforcer {
+	if counter == nil {
+		counter = NewLocal()
+	}
+	return &Enforcer{store: st, defaults: defaults, counter: counter, now: time.Now}
+}
+
+// Defaults returns the limits of workspaces without overrides.
+func (e *Enforcer) Defaults() Limits {
+	return e.defaults
+}
+
+// Effective returns the limits applying to workspaceID and its overrides,
+// nil if it has none.
+func (e *Enforcer) Effective(ctx context.Context, workspaceID string) (Limits, *store.WorkspaceLimits, error) {
+	l := e.defaults
+	o, err := e.store.GetWorkspaceLimits(ctx, workspaceID)
+	if errors.Is(err, store.ErrNotFound) {
+		return l, nil, nil
+	}
+	if err != nil {
+		return l, nil, err
+	}
+	for _, f := range []struct {
+		override *int
+		dst      *int
+	}{
+		{o.MaxPendingConnections, &l.MaxPendingConnections},
+		{o.MaxActiveConnections, &l.MaxActiveConnections},
+		{o.MaxRefreshesPerMinute, &l.MaxRefreshesPerMinute},
+	} {
+		if f.override != nil {
+			*f.dst = *f.override
+		}
+	}
+	return l, o, nil
+}
+
+// Usage returns what workspaceID currently consumes.
+func (e *Enforcer) Usage(ctx context.Context, workspaceID string) (Usage, error) {
+	var u Usage
+	var err error
+	if u.PendingConnections, u.ActiveConnections, err = e.store.CountWorkspaceConnections(ctx, workspaceID); err != nil {
+		return u, err
+	}
+	u.RefreshesThisMinute, err = e.counter.Count(ctx, refreshKey(workspaceID), e.window())
+	return u, err
+}
+
+// CheckNewConnection returns an *ExceededError if workspaceID may not start
+// another consent: it holds its maximum of pending or of active
+// connections.
+func (e *Enforcer) CheckNewConnection(ctx context.Context, workspaceID string) error {
+	l, _, err := e.Effective(ctx, workspaceID)
+	if err != nil {
+		return err
+	}
+	if l.MaxPendingConnections == 0 && l.MaxActiveConnections == 0 {
+		return nil
+	}
+	pending, active, err := e.store.CountWorkspaceConnections(ctx, workspaceID)
+	if err != nil {
+		return err
+	}
+	if l.MaxPendingConnections > 0 && pending >= l.MaxPendingConnections {
+		return &ExceededError{Limit: LimitPendingConnections, Max: l.MaxPendingConnections, Current: int64(pending)}
+	}
+	if l.MaxActiveConnections > 0 && active >= l.MaxActiveConnections {
+		return &ExceededError{Limit: LimitActiveConnections, Max: l.MaxActiveConnections, Current: int64(active)}
+	}
+	return nil
+}
+
+// AllowRefresh counts a refresh of one of workspaceID's connections and
+// returns an *ExceededError if the workspace is over its per-minute
+// maximum. Rejected refreshes count too, so a client retrying in a tight
+// loop stays throttled until the window ends.
+func (e *Enforcer) AllowRefresh(ctx context.Context, workspaceID string) error {
+	l, _, err := e.Effective(ctx, workspaceID)
+	if err != nil {
+		return err
+	}
+	if l.MaxRefreshesPerMinute == 0 {
+		return nil
+	}
+	window := e.window()
+	n, err := e.counter.Incr(ctx, refreshKey(workspaceID), window, 2*refreshWindow)
+	if err != nil {
+		return err
+	}
+	if n > int64(l.MaxRefreshesPerMinute) {
+		return &ExceededError{
+			Limit:      LimitRefreshesPerMinute,
+			Max:        l.MaxRefreshesPerMinute,
+			Current:    n,
+			RetryAfter: window.Add(refreshWindow).Sub(e.now()),
+		}
+	}
+	return nil
+}
+
+// window returns the start of the current refresh window.
+func (e *Enforcer) window() time.Time {
+	return e.now().Truncate(refreshWindow)
+}
+
+func refreshKey(workspaceID string) string {
+	return "refresh:" + workspaceID
+}
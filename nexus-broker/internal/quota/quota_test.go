@@ -0,0 +1,102 @@
+package quota
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/internal/store"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/connstate"
+)
+
+func intp(n int) *int { return &n }
+
+func TestCheckNewConnection(t *testing.T) {
+	ctx := context.Background()
+	st := store.NewMemory()
+	e := New(st, Limits{MaxPendingConnections: 2, MaxActiveConnections: 1}, nil)
+	put := func(ws string, s connstate.State) {
+		st.PutConnection(store.Connection{ID: uuid.New(), WorkspaceID: ws, Status: s, ExpiresAt: time.Now().Add(time.Minute)})
+	}
+
+	require.NoError(t, e.CheckNewConnection(ctx, "ws-1"))
+	put("ws-1", connstate.StatePending)
+	put("ws-1", connstate.StatePending)
+	put("ws-2", connstate.StateActive)
+
+	var exceeded *ExceededError
+	require.ErrorAs(t, e.CheckNewConnection(ctx, "ws-1"), &exceeded)
+	assert.Equal(t, LimitPendingConnections, exceeded.Limit)
+	assert.EqualValues(t, 2, exceeded.Current)
+
+	require.ErrorAs(t, e.CheckNewConnection(ctx, "ws-2"), &exceeded)
+	assert.Equal(t, LimitActiveConnections, exceeded.Limit)
+
+	// An override of 0 lifts the limit for that workspace only.
+	require.NoError(t, st.SetWorkspaceLimits(ctx, &store.WorkspaceLimits{WorkspaceID: "ws-2", MaxActiveConnections: intp(0)}))
+	assert.NoError(t, e.CheckNewConnection(ctx, "ws-2"))
+	l, o, err := e.Effective(ctx, "ws-2")
+	require.NoError(t, err)
+	require.NotNil(t, o)
+	assert.Equal(t, Limits{MaxPendingConnections: 2}, l)
+}
+
+func TestAllowRefresh(t *testing.T) {
+	ctx := context.Background()
+	e := New(store.NewMemory(), Limits{MaxRefreshesPerMinute: 2}, nil)
+	now := time.Date(2026, 5, 1, 12, 0, 10, 0, time.UTC)
+	e.now = func() time.Time { return now }
+
+	require.NoError(t, e.AllowRefresh(ctx, "ws-1"))
+	require.NoError(t, e.AllowRefresh(ctx, "ws-1"))
+	var exceeded *ExceededError
+	require.ErrorAs(t, e.AllowRefresh(ctx, "ws-1"), &exceeded)
+	assert.Equal(t, 50*time.Second, exceeded.RetryAfter)
+	assert.NoError(t, e.AllowRefresh(ctx, "ws-2"), "workspaces are counted separately")
+
+	u, err := e.Usage(ctx, "ws-1")
+	require.NoError(t, err)
+	assert.EqualValues(t, 3, u.RefreshesThisMinute)
+
+	now = now.Add(time.Minute)
+	assert.NoError(t, e.AllowRefresh(ctx, "ws-1"), "a new window starts from zero")
+}
+
+func TestRedisCounterSharedAndFallsBack(t *testing.T) {
+	ctx := context.Background()
+	mr, err := miniredis.Run()
+	require.NoError(t, err)
+	defer mr.Close()
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	a, b := NewRedis(client), NewRedis(client)
+	window := time.Now().Truncate(time.Minute)
+
+	n, err := a.Incr(ctx, "refresh:ws", window, time.Minute)
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, n)
+	n, err = b.Incr(ctx, "refresh:ws", window, time.Minute)
+	require.NoError(t, err)
+	assert.EqualValues(t, 2, n)
+	n, err = a.Count(ctx, "refresh:ws", window)
+	require.NoError(t, err)
+	assert.EqualValues(t, 2, n)
+
+	mr.Close()
+	n, err = a.Incr(ctx, "refresh:ws", window, time.Minute)
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, n, "counts in process while Redis is down")
+}
+
+func TestExceededErrorIsError(t *testing.T) {
+	var err error = &ExceededError{Limit: LimitActiveConnections, Max: 1, Current: 1}
+	var exceeded *ExceededError
+	assert.True(t, errors.As(err, &exceeded))
+	assert.Contains(t, err.Error(), LimitActiveConnections)
+}
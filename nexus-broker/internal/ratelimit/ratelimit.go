@@ -0,0 +1,150 @@
+// Package ratelimit paces requests per key with the generic cell rate
+// algorithm (a token bucket that stores a single timestamp). Limits are
+// held in Redis so every broker replica draws from the same budget, or in
+// process memory.
+package ratelimit
+
+import (
+	"context"
+	"log"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// keyPrefix namespaces limiter state in Redis.
+const keyPrefix = "ratelimit:"
+
+// Limit allows Rate requests per second on average, and bursts of up to
+// Burst requests at once.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// interval is the time one request uses up.
+func (l Limit) interval() time.Duration {
+	return time.Duration(float64(time.Second) / l.Rate)
+}
+
+// burst returns Burst, at least 1.
+func (l Limit) burst() int {
+	if l.Burst < 1 {
+		return 1
+	}
+	return l.Burst
+}
+
+// Limiter reserves request slots.
+type Limiter interface {
+	// Reserve takes the next slot under l for key and returns how long the
+	// caller must wait before using it. If that wait would exceed maxWait
+	// no slot is taken, ok is false and delay is the wait a slot would
+	// have needed.
+	Reserve(ctx context.Context, key string, l Limit, maxWait time.Duration) (delay time.Duration, ok bool, err error)
+}
+
+// Local is a Limiter only visible to this process.
+type Local struct {
+	mu  sync.Mutex
+	tat map[string]time.Time
+	now func() time.Time
+}
+
+var _ Limiter = (*Local)(nil)
+
+// NewLocal creates an empty Local.
+func NewLocal() *Local {
+	return &Local{tat: map[string]time.Time{}, now: time.Now}
+}
+
+// Reserve implements Limiter.
+func (l *Local) Reserve(ctx context.Context, key string, lim Limit, maxWait time.Duration) (time.Duration, bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	interval := lim.interval()
+	// tat is the theoretical arrival time: when the bucket is next empty.
+	tat := l.tat[key]
+	if tat.Before(now) {
+		tat = now
+	}
+	delay := tat.Add(interval).Add(-time.Duration(lim.burst()) * interval).Sub(now)
+	if delay < 0 {
+		delay = 0
+	}
+	if delay > maxWait {
+		return delay, false, nil
+	}
+	l.tat[key] = tat.Add(interval)
+	return delay, true, nil
+}
+
+// reserveScript is Local.Reserve in Redis. KEYS[1] holds the theoretical
+// arrival time in microseconds. ARGV: now, interval, burst and maxWait in
+// microseconds (burst as a count). Returns {delay, ok}.
+var reserveScript = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local interval = tonumber(ARGV[2])
+local burst = tonumber(ARGV[3])
+local max_wait = tonumber(ARGV[4])
+local tat = tonumber(redis.call("GET", KEYS[1]) or "0")
+if tat < now then tat = now end
+local delay = tat + interval - burst * interval - now
+if delay < 0 then delay = 0 end
+if delay > max_wait then return {delay, 0} end
+local new_tat = tat + interval
+redis.call("SET", KEYS[1], string.format("%.0f", new_tat), "PX", math.ceil((new_tat - now) / 1000) + 1000)
+return {delay, 1}`)
+
+// Redis is a Limiter shared by every process using the same Redis. The
+// callers' clocks are used, so replicas should be time-synchronised. While
+// Redis is unreachable it limits in process, so each replica still paces
+// its own requests.
+type Redis struct {
+	client   redis.UniversalClient
+	fallback *Local
+	now      func() time.Time
+}
+
+var _ Limiter = (*Redis)(nil)
+
+// NewRedis creates a Redis limiter.
+func NewRedis(client redis.UniversalClient) *Redis {
+	return &Redis{client: client, fallback: NewLocal(), now: time.Now}
+}
+
+// Reserve implements Limiter.
+func (r *Redis) Reserve(ctx context.Context, key string, l Limit, maxWait time.Duration) (time.Duration, bool, error) {
+	res, err := reserveScript.Run(ctx, r.client, []string{keyPrefix + key},
+		r.now().UnixMicro(),
+		l.interval().Microseconds(),
+		l.burst(),
+		clampMicros(maxWait),
+	).Slice()
+	if err != nil || len(res) != 2 {
+		log.Printf("ratelimit: redis unavailable, limiting %s in process: %v", key, err)
+		return r.fallback.Reserve(ctx, key, l, maxWait)
+	}
+	delay, _ := res[0].(int64)
+	ok, _ := res[1].(int64)
+	return time.Duration(delay) * time.Microsecond, ok == 1, nil
+}
+
+// clampMicros converts d to microseconds as a string, keeping it within
+// the range Lua numbers represent exactly.
+func clampMicros(d time.Duration) string {
+	us := d.Microseconds()
+	if us > 1<<52 {
+		us = 1 << 52
+	}
+	return strconv.FormatInt(us, 10)
+}
+
+// RetryAfter rounds d up to whole seconds for a Retry-After header.
+func RetryAfter(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
@@ -0,0 +1,81 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocalPacesAndRejects(t *testing.T) {
+	ctx := context.Background()
+	now := time.Unix(1700000000, 0)
+	l := NewLocal()
+	l.now = func() time.Time { return now }
+	lim := Limit{Rate: 2, Burst: 2}
+
+	for i := 0; i < 2; i++ {
+		delay, ok, err := l.Reserve(ctx, "p", lim, 0)
+		require.NoError(t, err)
+		assert.True(t, ok)
+		assert.Zero(t, delay, "the burst is free")
+	}
+	delay, ok, _ := l.Reserve(ctx, "p", lim, 0)
+	assert.False(t, ok)
+	assert.Equal(t, 500*time.Millisecond, delay)
+
+	delay, ok, _ = l.Reserve(ctx, "p", lim, time.Second)
+	assert.True(t, ok)
+	assert.Equal(t, 500*time.Millisecond, delay, "queued behind the burst")
+	delay, ok, _ = l.Reserve(ctx, "p", lim, time.Second)
+	assert.True(t, ok)
+	assert.Equal(t, time.Second, delay)
+
+	_, ok, _ = l.Reserve(ctx, "other", lim, 0)
+	assert.True(t, ok, "keys are limited separately")
+
+	now = now.Add(2 * time.Second)
+	delay, ok, _ = l.Reserve(ctx, "p", lim, 0)
+	assert.True(t, ok)
+	assert.Zero(t, delay, "the bucket refills")
+}
+
+func TestRedisSharedAndFallsBack(t *testing.T) {
+	ctx := context.Background()
+	mr, err := miniredis.Run()
+	require.NoError(t, err)
+	defer mr.Close()
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	now := time.Unix(1700000000, 0)
+	a, b := NewRedis(client), NewRedis(client)
+	a.now = func() time.Time { return now }
+	b.now = a.now
+	lim := Limit{Rate: 1, Burst: 1}
+
+	delay, ok, err := a.Reserve(ctx, "p", lim, 0)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Zero(t, delay)
+	delay, ok, err = b.Reserve(ctx, "p", lim, 0)
+	require.NoError(t, err)
+	assert.False(t, ok, "replicas share the budget")
+	assert.Equal(t, time.Second, delay)
+	delay, ok, err = b.Reserve(ctx, "p", lim, 2*time.Second)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, time.Second, delay)
+
+	mr.Close()
+	_, ok, err = a.Reserve(ctx, "p", lim, 0)
+	require.NoError(t, err)
+	assert.True(t, ok, "limits in process while Redis is down")
+}
+
+func TestRetryAfter(t *testing.T) {
+	assert.Equal(t, "1", RetryAfter(10*time.Millisecond))
+	assert.Equal(t, "2", RetryAfter(2*time.Second))
+}
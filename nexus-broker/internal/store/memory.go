@@ -0,0 +1,722 @@
+package store
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/connstate"
+)
+
+// Memory is an in-process Store for unit tests. It enforces the connection
+// state machine like Postgres does. Seed it with PutProvider and
+// PutConnection.
+type Memory struct {
+	mu          sync.Mutex
+	connections map[uuid.UUID]Connection
+	providers   map[uuid.UUID]memoryProvider
+	tokens      map[uuid.UUID]Token
+	refresh     map[uuid.UUID][]RefreshGeneration
+	reauth      map[uuid.UUID]Reauthorization
+	limits      map[string]WorkspaceLimits
+	approvals   map[scopeApprovalKey]ScopeApprovals
+	failures    map[uuid.UUID]RefreshFailure
+	suspensions map[uuid.UUID]Suspension
+	grants      map[uuid.UUID]Grant
+	errorCodes  map[uuid.UUID]string
+	events      []AuditEvent
+}
+
+type scopeApprovalKey struct {
+	workspaceID string
+	providerID  uuid.UUID
+}
+
+type memoryProvider struct {
+	Provider
+	workspaceIDs []string
+}
+
+var _ Store = (*Memory)(nil)
+
+// NewMemory creates an empty Memory store.
+func NewMemory() *Memory {
+	return &Memory{
+		connections: map[uuid.UUID]Connection{},
+		providers:   map[uuid.UUID]memoryProvider{},
+		tokens:      map[uuid.UUID]Token{},
+		refresh:     map[uuid.UUID][]RefreshGeneration{},
+		reauth:      map[uuid.UUID]Reauthorization{},
+		limits:      map[string]WorkspaceLimits{},
+		approvals:   map[scopeApprovalKey]ScopeApprovals{},
+		failures:    map[uuid.UUID]RefreshFailure{},
+		suspensions: map[uuid.UUID]Suspension{},
+		grants:      map[uuid.UUID]Grant{},
+		errorCodes:  map[uuid.UUID]string{},
+	}
+}
+
+// PutProvider adds or replaces a provider. workspaceIDs restricts it to
+// those workspaces; none makes it global.
+func (m *Memory) PutProvider(p Provider, workspaceIDs ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.providers[p.ID] = memoryProvider{Provider: p, workspaceIDs: workspaceIDs}
+}
+
+// PutConnection adds or replaces a connection as-is, whatever its status.
+func (m *Memory) PutConnection(c Connection) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.connections[c.ID] = c
+}
+
+// Events returns the audit events recorded so far, oldest first.
+func (m *Memory) Events() []AuditEvent {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]AuditEvent(nil), m.events...)
+}
+
+func (m *Memory) CreateConnection(ctx context.Context, c *Connection) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if c.ID == uuid.Nil {
+		c.ID = uuid.New()
+	}
+	if c.CreatedAt.IsZero() {
+		c.CreatedAt = time.Now()
+	}
+	c.Status = connstate.StatePending
+	m.connections[c.ID] = *c
+	return nil
+}
+
+func (m *Memory) GetConnection(ctx context.Context, id uuid.UUID) (*Connection, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c, ok := m.connections[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &c, nil
+}
+
+func (m *Memory) GetPendingConnection(ctx context.Context, id uuid.UUID) (*Connection, error) {
+	c, err := m.GetConnection(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if c.Status != connstate.StatePending || !c.ExpiresAt.After(time.Now()) {
+		return nil, ErrNotFound
+	}
+	return c, nil
+}
+
+func (m *Memory) UpdateStatus(ctx context.Context, id uuid.UUID, to connstate.State) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c, ok := m.connections[id]
+	if !ok {
+		return connstate.ErrNotFound
+	}
+	if c.Status == to {
+		return nil
+	}
+	if !c.Status.CanTransition(to) {
+		return &connstate.TransitionError{From: c.Status, To: to}
+	}
+	c.Status = to
+	m.connections[id] = c
+	return nil
+}
+
+func (m *Memory) FailConnection(ctx context.Context, id uuid.UUID, code string) error {
+	if err := m.UpdateStatus(ctx, id, connstate.StateFailed); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.errorCodes[id] = code
+	return nil
+}
+
+func (m *Memory) GetFailureCode(ctx context.Context, id uuid.UUID) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.errorCodes[id], nil
+}
+
+func (m *Memory) SetIdentity(ctx context.Context, id uuid.UUID, subject, email string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c, ok := m.connections[id]
+	if !ok {
+		return ErrNotFound
+	}
+	c.Subject, c.Email = subject, email
+	m.connections[id] = c
+	return nil
+}
+
+func (m *Memory) SetTenant(ctx context.Context, id uuid.UUID, tenant string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c, ok := m.connections[id]
+	if !ok {
+		return ErrNotFound
+	}
+	c.Tenant = tenant
+	m.connections[id] = c
+	return nil
+}
+
+func (m *Memory) ListUserConnections(ctx context.Context, workspaceID, user string) ([]Connection, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := []Connection{}
+	for _, c := range m.connections {
+		if c.WorkspaceID != workspaceID {
+			continue
+		}
+		if (c.Subject != "" && c.Subject == user) || (c.Email != "" && strings.EqualFold(c.Email, user)) {
+			out = append(out, c)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID.String() < out[j].ID.String() })
+	return out, nil
+}
+
+func (m *Memory) StartReauthorization(ctx context.Context, id uuid.UUID, r Reauthorization) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c, ok := m.connections[id]
+	if !ok || (c.Status != connstate.StateActive && c.Status != connstate.StateNeedsReauth) {
+		return ErrNotFound
+	}
+	m.reauth[id] = r
+	return nil
+}
+
+func (m *Memory) GetPendingReauthorization(ctx context.Context, id uuid.UUID) (*Connection, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c, ok := m.connections[id]
+	r, pending := m.reauth[id]
+	if !ok || !pending || !r.ExpiresAt.After(time.Now()) ||
+		(c.Status != connstate.StateActive && c.Status != connstate.StateNeedsReauth) {
+		return nil, ErrNotFound
+	}
+	c.CodeVerifier, c.Scopes, c.ReturnURL, c.ExpiresAt = r.CodeVerifier, r.Scopes, r.ReturnURL, r.ExpiresAt
+	return &c, nil
+}
+
+func (m *Memory) CompleteReauthorization(ctx context.Context, id uuid.UUID, scopes []string, encryptedData string, expiresAt *time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c, ok := m.connections[id]
+	r, pending := m.reauth[id]
+	if !ok || !pending || !r.ExpiresAt.After(time.Now()) {
+		return ErrNotFound
+	}
+	if c.Status != connstate.StateActive && !c.Status.CanTransition(connstate.StateActive) {
+		return &connstate.TransitionError{From: c.Status, To: connstate.StateActive}
+	}
+	c.Status, c.Scopes = connstate.StateActive, scopes
+	m.connections[id] = c
+	m.tokens[id] = Token{EncryptedData: encryptedData, ExpiresAt: expiresAt, WorkspaceID: c.WorkspaceID}
+	delete(m.reauth, id)
+	return nil
+}
+
+func (m *Memory) CancelReauthorization(ctx context.Context, id uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.reauth, id)
+	return nil
+}
+
+func (m *Memory) RecordTokenUsage(ctx context.Context, usage []TokenUsage) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, u := range usage {
+		c, ok := m.connections[u.ConnectionID]
+		if !ok {
+			continue
+		}
+		c.TokenRetrievals += u.Retrievals
+		if c.LastAccessedAt == nil || u.LastAccessedAt.After(*c.LastAccessedAt) {
+			at := u.LastAccessedAt
+			c.LastAccessedAt = &at
+		}
+		m.connections[u.ConnectionID] = c
+	}
+	return nil
+}
+
+func (m *Memory) SuspendConnection(ctx context.Context, s *Suspension) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c, ok := m.connections[s.ConnectionID]
+	if !ok {
+		return connstate.ErrNotFound
+	}
+	if c.Status != connstate.StateSuspended && !c.Status.CanTransition(connstate.StateSuspended) {
+		return &connstate.TransitionError{From: c.Status, To: connstate.StateSuspended}
+	}
+	c.Status = connstate.StateSuspended
+	m.connections[s.ConnectionID] = c
+	m.suspensions[s.ConnectionID] = *s
+	return nil
+}
+
+func (m *Memory) ResumeConnection(ctx context.Context, id uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c, ok := m.connections[id]
+	if !ok {
+		return connstate.ErrNotFound
+	}
+	if c.Status != connstate.StateSuspended {
+		return &connstate.TransitionError{From: c.Status, To: connstate.StateActive}
+	}
+	c.Status = connstate.StateActive
+	m.connections[id] = c
+	delete(m.suspensions, id)
+	return nil
+}
+
+func (m *Memory) GetSuspension(ctx context.Context, id uuid.UUID) (*Suspension, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.suspensions[id]
+	if c := m.connections[id]; !ok || c.Status != connstate.StateSuspended {
+		return nil, ErrNotFound
+	}
+	return &s, nil
+}
+
+func (m *Memory) ListStaleConnections(ctx context.Context, f StaleFilter) ([]Connection, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := []Connection{}
+	for _, c := range m.connections {
+		if c.Status != connstate.StateActive && c.Status != connstate.StateNeedsReauth {
+			continue
+		}
+		if f.WorkspaceID != "" && c.WorkspaceID != f.WorkspaceID {
+			continue
+		}
+		if lastUsed(c).Before(f.UnusedSince) {
+			out = append(out, c)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if a, b := lastUsed(out[i]), lastUsed(out[j]); !a.Equal(b) {
+			return a.Before(b)
+		}
+		return out[i].ID.String() < out[j].ID.String()
+	})
+	if f.Limit > 0 && len(out) > f.Limit {
+		out = out[:f.Limit]
+	}
+	return out, nil
+}
+
+func (m *Memory) ListAwaitingApproval(ctx context.Context, f ApprovalFilter) ([]Connection, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := []Connection{}
+	for _, c := range m.connections {
+		if c.Status != connstate.StateAwaitingApproval {
+			continue
+		}
+		if f.WorkspaceID != "" && c.WorkspaceID != f.WorkspaceID {
+			continue
+		}
+		out = append(out, c)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if !out[i].CreatedAt.Equal(out[j].CreatedAt) {
+			return out[i].CreatedAt.Before(out[j].CreatedAt)
+		}
+		return out[i].ID.String() < out[j].ID.String()
+	})
+	if f.Limit > 0 && len(out) > f.Limit {
+		out = out[:f.Limit]
+	}
+	return out, nil
+}
+
+// lastUsed is when c's token was last retrieved, or when c was created if
+// it never was.
+func lastUsed(c Connection) time.Time {
+	if c.LastAccessedAt != nil {
+		return *c.LastAccessedAt
+	}
+	return c.CreatedAt
+}
+
+func (m *Memory) GetProvider(ctx context.Context, id uuid.UUID) (*Provider, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p, ok := m.providers[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &p.Provider, nil
+}
+
+func (m *Memory) GetProviderForWorkspace(ctx context.Context, id uuid.UUID, workspaceID string) (*Provider, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p, ok := m.providers[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if len(p.workspaceIDs) == 0 {
+		return &p.Provider, nil
+	}
+	for _, ws := range p.workspaceIDs {
+		if ws == workspaceID {
+			return &p.Provider, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (m *Memory) SetTokenEndpointPreference(ctx context.Context, id uuid.UUID, source string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p, ok := m.providers[id]
+	if !ok {
+		return ErrNotFound
+	}
+	p.TokenEndpointPreference = source
+	m.providers[id] = p
+	return nil
+}
+
+func (m *Memory) SaveTokens(ctx context.Context, connectionID uuid.UUID, encryptedData string, expiresAt *time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c, ok := m.connections[connectionID]
+	if !ok {
+		return ErrNotFound
+	}
+	m.tokens[connectionID] = Token{EncryptedData: encryptedData, ExpiresAt: expiresAt, WorkspaceID: c.WorkspaceID}
+	return nil
+}
+
+func (m *Memory) GetTokens(ctx context.Context, connectionID uuid.UUID) (*Token, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t, ok := m.tokens[connectionID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &t, nil
+}
+
+func (m *Memory) DeleteTokens(ctx context.Context, connectionID uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.tokens, connectionID)
+	return nil
+}
+
+func (m *Memory) RecordRefreshToken(ctx context.Context, connectionID uuid.UUID, tokenHash string) (*RefreshGeneration, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	gens := m.refresh[connectionID]
+	now := time.Now()
+	if n := len(gens); n > 0 {
+		if gens[n-1].TokenHash == tokenHash {
+			g := gens[n-1]
+			return &g, nil
+		}
+		gens[n-1].RotatedAt = &now
+	}
+	g := RefreshGeneration{Generation: len(gens) + 1, TokenHash: tokenHash, CreatedAt: now}
+	m.refresh[connectionID] = append(gens, g)
+	return &g, nil
+}
+
+func (m *Memory) FindRefreshToken(ctx context.Context, connectionID uuid.UUID, tokenHash string) (*RefreshGeneration, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	gens := m.refresh[connectionID]
+	for i := len(gens) - 1; i >= 0; i-- {
+		if gens[i].TokenHash == tokenHash {
+			g := gens[i]
+			return &g, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (m *Memory) CreateAuditEvent(ctx context.Context, e *AuditEvent) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e.ID = uuid.New()
+	e.CreatedAt = time.Now()
+	m.events = append(m.events, *e)
+	return nil
+}
+
+func (m *Memory) ListAuditEvents(ctx context.Context, f AuditFilter) ([]AuditEvent, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := m.matchAuditEvents(f)
+	sort.SliceStable(out, func(i, j int) bool { return out[i].CreatedAt.After(out[j].CreatedAt) })
+	if f.Offset >= len(out) {
+		return []AuditEvent{}, nil
+	}
+	out = out[f.Offset:]
+	if f.Limit > 0 && len(out) > f.Limit {
+		out = out[:f.Limit]
+	}
+	return out, nil
+}
+
+func (m *Memory) StreamAuditEvents(ctx context.Context, f AuditFilter, fn func(*AuditEvent) error) error {
+	m.mu.Lock()
+	events := m.matchAuditEvents(f)
+	m.mu.Unlock()
+	sort.SliceStable(events, func(i, j int) bool { return events[i].CreatedAt.Before(events[j].CreatedAt) })
+	for i := range events {
+		if err := fn(&events[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Memory) matchAuditEvents(f AuditFilter) []AuditEvent {
+	out := []AuditEvent{}
+	for _, e := range m.events {
+		if f.EventType != "" && e.EventType != f.EventType {
+			continue
+		}
+		if f.ConnectionID != uuid.Nil && (e.ConnectionID == nil || *e.ConnectionID != f.ConnectionID) {
+			continue
+		}
+		if !f.Since.IsZero() && e.CreatedAt.Before(f.Since) {
+			continue
+		}
+		if !f.Until.IsZero() && !e.CreatedAt.Before(f.Until) {
+			continue
+		}
+		out = append(out, e)
+	}
+	return out
+}
+
+func (m *Memory) GetWorkspaceLimits(ctx context.Context, workspaceID string) (*WorkspaceLimits, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	l, ok := m.limits[workspaceID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &l, nil
+}
+
+func (m *Memory) SetWorkspaceLimits(ctx context.Context, l *WorkspaceLimits) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	l.UpdatedAt = time.Now()
+	m.limits[l.WorkspaceID] = *l
+	return nil
+}
+
+func (m *Memory) DeleteWorkspaceLimits(ctx context.Context, workspaceID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.limits, workspaceID)
+	return nil
+}
+
+func (m *Memory) GetScopeApprovals(ctx context.Context, workspaceID string, providerID uuid.UUID) (*ScopeApprovals, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	a, ok := m.approvals[scopeApprovalKey{workspaceID, providerID}]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	a.Scopes = append([]string(nil), a.Scopes...)
+	return &a, nil
+}
+
+func (m *Memory) SetScopeApprovals(ctx context.Context, a *ScopeApprovals) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	a.UpdatedAt = time.Now()
+	stored := *a
+	stored.Scopes = append([]string(nil), a.Scopes...)
+	m.approvals[scopeApprovalKey{a.WorkspaceID, a.ProviderID}] = stored
+	return nil
+}
+
+func (m *Memory) DeleteScopeApprovals(ctx context.Context, workspaceID string, providerID uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.approvals, scopeApprovalKey{workspaceID, providerID})
+	return nil
+}
+
+func (m *Memory) CreateGrant(ctx context.Context, g *Grant) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if g.ID == uuid.Nil {
+		g.ID = uuid.New()
+	}
+	g.CreatedAt = time.Now()
+	stored := *g
+	stored.Scopes = append([]string(nil), g.Scopes...)
+	m.grants[g.ID] = stored
+	return nil
+}
+
+func (m *Memory) RedeemGrant(ctx context.Context, id uuid.UUID, now time.Time) (*Grant, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	g, ok := m.grants[id]
+	switch {
+	case !ok:
+		return nil, ErrNotFound
+	case g.RedeemedAt != nil:
+		return nil, ErrGrantRedeemed
+	case !g.ExpiresAt.After(now):
+		return nil, ErrGrantExpired
+	}
+	g.RedeemedAt = &now
+	m.grants[id] = g
+	g.Scopes = append([]string(nil), g.Scopes...)
+	return &g, nil
+}
+
+func (m *Memory) CountWorkspaceConnections(ctx context.Context, workspaceID string) (int, int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var pending, active int
+	now := time.Now()
+	for _, c := range m.connections {
+		if c.WorkspaceID != workspaceID {
+			continue
+		}
+		switch {
+		case c.Status == connstate.StatePending && c.ExpiresAt.After(now):
+			pending++
+		case c.Status == connstate.StateActive:
+			active++
+		}
+	}
+	return pending, active, nil
+}
+
+// refreshFailure fills in f's connection fields. Callers hold m.mu.
+func (m *Memory) refreshFailure(f RefreshFailure) RefreshFailure {
+	c := m.connections[f.ConnectionID]
+	f.WorkspaceID, f.ProviderID = c.WorkspaceID, c.ProviderID
+	return f
+}
+
+func (m *Memory) GetRefreshFailure(ctx context.Context, connectionID uuid.UUID) (*RefreshFailure, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f, ok := m.failures[connectionID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	f = m.refreshFailure(f)
+	return &f, nil
+}
+
+func (m *Memory) SaveRefreshFailure(ctx context.Context, f *RefreshFailure) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.connections[f.ConnectionID]; !ok {
+		return ErrNotFound
+	}
+	m.failures[f.ConnectionID] = *f
+	return nil
+}
+
+func (m *Memory) DeleteRefreshFailure(ctx context.Context, connectionID uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.failures, connectionID)
+	return nil
+}
+
+func (m *Memory) ListRefreshFailures(ctx context.Context, f RefreshFailureFilter) ([]RefreshFailure, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var out []RefreshFailure
+	for _, rf := range m.failures {
+		rf = m.refreshFailure(rf)
+		if f.WorkspaceID != "" && rf.WorkspaceID != f.WorkspaceID {
+			continue
+		}
+		if !f.DueBefore.IsZero() && (rf.NextRetryAt == nil || rf.NextRetryAt.After(f.DueBefore)) {
+			continue
+		}
+		out = append(out, rf)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if !f.DueBefore.IsZero() {
+			return out[i].NextRetryAt.Before(*out[j].NextRetryAt)
+		}
+		return out[i].LastFailedAt.After(out[j].LastFailedAt)
+	})
+	if f.Limit > 0 && len(out) > f.Limit {
+		out = out[:f.Limit]
+	}
+	return out, nil
+}
+
+func (m *Memory) ExpirePendingConnections(ctx context.Context) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var n int64
+	now := time.Now()
+	for id, c := range m.connections {
+		if c.Status == connstate.StatePending && !c.ExpiresAt.After(now) {
+			c.Status = connstate.StateExpired
+			m.connections[id] = c
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (m *Memory) DeleteOrphanTokens(ctx context.Context) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var n int64
+	for id := range m.tokens {
+		if _, ok := m.connections[id]; !ok {
+			delete(m.tokens, id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (m *Memory) DeleteExpiredGrants(ctx context.Context, cutoff time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var n int64
+	for id, g := range m.grants {
+		if g.ExpiresAt.Before(cutoff) {
+			delete(m.grants, id)
+			n++
+		}
+	}
+	return n, nil
+}
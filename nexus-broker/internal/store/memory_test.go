@@ -0,0 +1,87 @@
+package store
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/connstate"
+)
+
+func TestMemory_UpdateStatusFollowsStateMachine(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+	c := &Connection{WorkspaceID: "ws-1", ExpiresAt: time.Now().Add(time.Minute)}
+	require.NoError(t, m.CreateConnection(ctx, c))
+
+	require.NoError(t, m.UpdateStatus(ctx, c.ID, connstate.StateActive))
+	require.NoError(t, m.UpdateStatus(ctx, c.ID, connstate.StateRevoked))
+
+	var te *connstate.TransitionError
+	assert.ErrorAs(t, m.UpdateStatus(ctx, c.ID, connstate.StateActive), &te)
+	assert.ErrorIs(t, m.UpdateStatus(ctx, uuid.New(), connstate.StateActive), connstate.ErrNotFound)
+
+	_, err := m.GetPendingConnection(ctx, c.ID)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestMemory_GetProviderForWorkspace(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+	global := Provider{ID: uuid.New(), Name: "global"}
+	private := Provider{ID: uuid.New(), Name: "private"}
+	m.PutProvider(global)
+	m.PutProvider(private, "ws-1")
+
+	_, err := m.GetProviderForWorkspace(ctx, global.ID, "ws-2")
+	assert.NoError(t, err)
+	_, err = m.GetProviderForWorkspace(ctx, private.ID, "ws-1")
+	assert.NoError(t, err)
+	_, err = m.GetProviderForWorkspace(ctx, private.ID, "ws-2")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestMemory_TokensTakeConnectionWorkspace(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+	id := uuid.New()
+
+	assert.ErrorIs(t, m.SaveTokens(ctx, id, "ct", nil), ErrNotFound)
+
+	m.PutConnection(Connection{ID: id, WorkspaceID: "ws-1", Status: connstate.StateActive})
+	require.NoError(t, m.SaveTokens(ctx, id, "ct", nil))
+	tok, err := m.GetTokens(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, "ct", tok.EncryptedData)
+	assert.Equal(t, "ws-1", tok.WorkspaceID)
+}
+
+func TestMemory_RefreshTokenGenerations(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+	id := uuid.New()
+
+	g1, err := m.RecordRefreshToken(ctx, id, "h1")
+	require.NoError(t, err)
+	assert.Equal(t, 1, g1.Generation)
+	again, err := m.RecordRefreshToken(ctx, id, "h1")
+	require.NoError(t, err)
+	assert.Equal(t, 1, again.Generation, "recording the current token is a no-op")
+
+	g2, err := m.RecordRefreshToken(ctx, id, "h2")
+	require.NoError(t, err)
+	assert.Equal(t, 2, g2.Generation)
+
+	old, err := m.FindRefreshToken(ctx, id, "h1")
+	require.NoError(t, err)
+	assert.NotNil(t, old.RotatedAt)
+	cur, err := m.FindRefreshToken(ctx, id, "h2")
+	require.NoError(t, err)
+	assert.Nil(t, cur.RotatedAt)
+	_, err = m.FindRefreshToken(ctx, id, "h3")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
@@ -0,0 +1,57 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithStatementTimeout(t *testing.T) {
+	tests := []struct {
+		name string
+		dsn  string
+		d    time.Duration
+		want string
+	}{
+		{"disabled", "postgres://u@db/app?sslmode=require", 0, "postgres://u@db/app?sslmode=require"},
+		{"url", "postgres://u@db/app?sslmode=require", 5 * time.Second, "postgres://u@db/app?sslmode=require&statement_timeout=5000"},
+		{"key value", "host=db dbname=app", 1500 * time.Millisecond, "host=db dbname=app statement_timeout=1500"},
+		{"already set", "postgres://u@db/app?statement_timeout=100", time.Second, "postgres://u@db/app?statement_timeout=100"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, withStatementTimeout(tt.dsn, tt.d))
+		})
+	}
+}
+
+func TestBackendOf(t *testing.T) {
+	tests := map[string]Backend{
+		"postgres://u@db/app":         BackendPostgres,
+		"postgresql://u@db/app":       BackendPostgres,
+		"host=db dbname=app":          BackendPostgres,
+		"mysql://u:p@db:3306/app":     BackendMySQL,
+		"sqlite:///var/lib/broker.db": BackendSQLite,
+		"sqlite3:broker.db":           BackendSQLite,
+		"SQLite::memory:":             BackendSQLite,
+	}
+	for dsn, want := range tests {
+		assert.Equal(t, want, BackendOf(dsn), dsn)
+	}
+}
+
+func TestMySQLDSN(t *testing.T) {
+	dsn, err := mysqlDSN("mysql://broker:p%40ss@db:3306/nexus?tls=true")
+	assert.NoError(t, err)
+	assert.Equal(t, "broker:p@ss@tcp(db:3306)/nexus?tls=true", dsn)
+
+	_, err = mysqlDSN("mysql:///nexus")
+	assert.Error(t, err)
+}
+
+func TestSQLitePath(t *testing.T) {
+	assert.Equal(t, "/var/lib/broker.db", sqlitePath("sqlite:///var/lib/broker.db"))
+	assert.Equal(t, "broker.db", sqlitePath("sqlite:broker.db"))
+	assert.Equal(t, ":memory:", sqlitePath("sqlite::memory:"))
+}
@@ -0,0 +1,783 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/connstate"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/provider"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/vault"
+)
+
+// Postgres implements Store on the broker's Postgres schema.
+type Postgres struct {
+	db *sqlx.DB
+	// reader serves lookups that tolerate replication lag; it is db when no
+	// replica is configured.
+	reader *sqlx.DB
+	// secretKey opens provider secrets sealed with vault.SealSecret.
+	secretKey []byte
+}
+
+var _ Store = (*Postgres)(nil)
+
+// NewPostgres creates a Postgres store.
+func NewPostgres(db *sqlx.DB) *Postgres {
+	return &Postgres{db: db, reader: db}
+}
+
+// NewPostgresWithReplica creates a Postgres store that reads providers,
+// tokens and connection status from replica. A row the replica does not have
+// yet is read again from db, so a connection or token written moments ago is
+// still found. Lookups that decide whether to release a token must pass a
+// ReadPrimary context, or a lagging replica could serve a connection that
+// was just revoked. A nil replica behaves like NewPostgres.
+func NewPostgresWithReplica(db, replica *sqlx.DB) *Postgres {
+	if replica == nil {
+		replica = db
+	}
+	return &Postgres{db: db, reader: replica}
+}
+
+// WithSecretKey makes s decrypt the provider secrets it reads with
+// key, the key the provider store seals them with. It returns s.
+func (s *Postgres) WithSecretKey(key []byte) *Postgres {
+	s.secretKey = key
+	return s
+}
+
+// read runs a single-row lookup against the replica, falling back to the
+// primary when the replica has no such row. Contexts marked with
+// ReadPrimary go straight to the primary.
+func read[T any](ctx context.Context, s *Postgres, lookup func(*sqlx.DB) (*T, error)) (*T, error) {
+	if readsPrimary(ctx) {
+		return lookup(s.db)
+	}
+	v, err := lookup(s.reader)
+	if err == ErrNotFound && s.reader != s.db {
+		return lookup(s.db)
+	}
+	return v, err
+}
+
+const connectionColumns = `id, workspace_id, provider_id, status, COALESCE(code_verifier, ''), scopes, return_url, expires_at,
+	COALESCE(subject, ''), COALESCE(email, ''), COALESCE(tenant, ''), created_at, token_retrievals, last_accessed_at`
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanConnection(row scanner) (*Connection, error) {
+	var c Connection
+	err := row.Scan(&c.ID, &c.WorkspaceID, &c.ProviderID, &c.Status, &c.CodeVerifier, pq.Array(&c.Scopes), &c.ReturnURL, &c.ExpiresAt,
+		&c.Subject, &c.Email, &c.Tenant, &c.CreatedAt, &c.TokenRetrievals, &c.LastAccessedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func (s *Postgres) CreateConnection(ctx context.Context, c *Connection) error {
+	if c.ID == uuid.Nil {
+		c.ID = uuid.New()
+	}
+	c.Status = connstate.StatePending
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO connections (id, workspace_id, provider_id, code_verifier, scopes, return_url, expires_at, tenant)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, NULLIF($8, ''))`,
+		c.ID, c.WorkspaceID, c.ProviderID, sql.NullString{String: c.CodeVerifier, Valid: c.CodeVerifier != ""},
+		pq.Array(c.Scopes), c.ReturnURL, c.ExpiresAt, c.Tenant)
+	return err
+}
+
+func (s *Postgres) GetConnection(ctx context.Context, id uuid.UUID) (*Connection, error) {
+	return read(ctx, s, func(db *sqlx.DB) (*Connection, error) {
+		return scanConnection(db.QueryRowContext(ctx,
+			`SELECT `+connectionColumns+` FROM connections WHERE id = $1`, id))
+	})
+}
+
+func (s *Postgres) GetPendingConnection(ctx context.Context, id uuid.UUID) (*Connection, error) {
+	return scanConnection(s.db.QueryRowContext(ctx,
+		`SELECT `+connectionColumns+` FROM connections WHERE id = $1 AND status = 'pending' AND expires_at > NOW()`, id))
+}
+
+func (s *Postgres) UpdateStatus(ctx context.Context, id uuid.UUID, to connstate.State) error {
+	_, err := connstate.Transition(ctx, s.db, id, to)
+	return err
+}
+
+func (s *Postgres) FailConnection(ctx context.Context, id uuid.UUID, code string) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := connstate.TransitionTx(ctx, tx, id, connstate.StateFailed); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE connections SET error_code = NULLIF($1, '') WHERE id = $2`, code, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *Postgres) GetFailureCode(ctx context.Context, id uuid.UUID) (string, error) {
+	var code string
+	err := s.db.QueryRowContext(ctx, `SELECT COALESCE(error_code, '') FROM connections WHERE id = $1`, id).Scan(&code)
+	if err == sql.ErrNoRows {
+		return "", ErrNotFound
+	}
+	return code, err
+}
+
+func (s *Postgres) SetIdentity(ctx context.Context, id uuid.UUID, subject, email string) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE connections SET subject = NULLIF($1, ''), email = NULLIF($2, '') WHERE id = $3`,
+		subject, email, id)
+	return err
+}
+
+func (s *Postgres) SetTenant(ctx context.Context, id uuid.UUID, tenant string) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE connections SET tenant = NULLIF($1, '') WHERE id = $2`, tenant, id)
+	return err
+}
+
+func (s *Postgres) ListUserConnections(ctx context.Context, workspaceID, user string) ([]Connection, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+connectionColumns+` FROM connections
+		WHERE workspace_id = $1 AND (subject = $2 OR lower(email) = lower($2))
+		ORDER BY created_at`, workspaceID, user)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanConnections(rows)
+}
+
+func scanConnections(rows *sql.Rows) ([]Connection, error) {
+	defer rows.Close()
+	conns := []Connection{}
+	for rows.Next() {
+		c, err := scanConnection(rows)
+		if err != nil {
+			return nil, err
+		}
+		conns = append(conns, *c)
+	}
+	return conns, rows.Err()
+}
+
+func (s *Postgres) StartReauthorization(ctx context.Context, id uuid.UUID, r Reauthorization) error {
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE connections SET reauth_code_verifier = $1, reauth_scopes = $2, reauth_return_url = $3, reauth_expires_at = $4,
+			updated_at = NOW()
+		WHERE id = $5 AND status IN ('active', 'needs_reauth')`,
+		r.CodeVerifier, pq.Array(r.Scopes), r.ReturnURL, r.ExpiresAt, id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *Postgres) GetPendingReauthorization(ctx context.Context, id uuid.UUID) (*Connection, error) {
+	return scanConnection(s.db.QueryRowContext(ctx, `
+		SELECT id, workspace_id, provider_id, status, reauth_code_verifier, reauth_scopes, reauth_return_url, reauth_expires_at,
+			COALESCE(subject, ''), COALESCE(email, ''), COALESCE(tenant, ''), created_at, token_retrievals, last_accessed_at
+		FROM connections
+		WHERE id = $1 AND status IN ('active', 'needs_reauth') AND reauth_expires_at > NOW()`, id))
+}
+
+func (s *Postgres) CompleteReauthorization(ctx context.Context, id uuid.UUID, scopes []string, encryptedData string, expiresAt *time.Time) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var pending bool
+	err = tx.QueryRowContext(ctx,
+		`SELECT reauth_expires_at > NOW() FROM connections WHERE id = $1 AND reauth_expires_at IS NOT NULL FOR UPDATE`, id).Scan(&pending)
+	if err == sql.ErrNoRows || (err == nil && !pending) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := connstate.TransitionTx(ctx, tx, id, connstate.StateActive); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, saveTokensSQL, id, encryptedData, expiresAt); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE connections SET scopes = $1, reauth_code_verifier = NULL, reauth_scopes = NULL, reauth_return_url = NULL,
+			reauth_expires_at = NULL, updated_at = NOW()
+		WHERE id = $2`, pq.Array(scopes), id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *Postgres) CancelReauthorization(ctx context.Context, id uuid.UUID) error {
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE connections SET reauth_code_verifier = NULL, reauth_scopes = NULL, reauth_return_url = NULL, reauth_expires_at = NULL
+		WHERE id = $1`, id)
+	return err
+}
+
+func (s *Postgres) RecordTokenUsage(ctx context.Context, usage []TokenUsage) error {
+	if len(usage) == 0 {
+		return nil
+	}
+	ids := make([]string, len(usage))
+	counts := make([]int64, len(usage))
+	times := make([]string, len(usage))
+	for i, u := range usage {
+		ids[i], counts[i], times[i] = u.ConnectionID.String(), u.Retrievals, u.LastAccessedAt.UTC().Format(time.RFC3339Nano)
+	}
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE connections c SET token_retrievals = c.token_retrievals + u.n,
+			last_accessed_at = GREATEST(c.last_accessed_at, u.at)
+		FROM unnest($1::uuid[], $2::bigint[], $3::timestamptz[]) AS u(id, n, at)
+		WHERE c.id = u.id`, pq.Array(ids), pq.Array(counts), pq.Array(times))
+	return err
+}
+
+func (s *Postgres) ListStaleConnections(ctx context.Context, f StaleFilter) ([]Connection, error) {
+	query := `SELECT ` + connectionColumns + ` FROM connections
+		WHERE status IN ('active', 'needs_reauth') AND COALESCE(last_accessed_at, created_at) < $1
+			AND ($2 = '' OR workspace_id = $2)
+		ORDER BY COALESCE(last_accessed_at, created_at), id`
+	args := []interface{}{f.UnusedSince, f.WorkspaceID}
+	if f.Limit > 0 {
+		query += ` LIMIT $3`
+		args = append(args, f.Limit)
+	}
+	rows, err := s.reader.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return scanConnections(rows)
+}
+
+func (s *Postgres) ListAwaitingApproval(ctx context.Context, f ApprovalFilter) ([]Connection, error) {
+	query := `SELECT ` + connectionColumns + ` FROM connections
+		WHERE status = 'awaiting_approval' AND ($1 = '' OR workspace_id = $1)
+		ORDER BY created_at, id`
+	args := []interface{}{f.WorkspaceID}
+	if f.Limit > 0 {
+		query += ` LIMIT $2`
+		args = append(args, f.Limit)
+	}
+	rows, err := s.reader.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return scanConnections(rows)
+}
+
+const providerColumns = `id, name, auth_type, COALESCE(auth_header, ''), COALESCE(auth_url, ''), COALESCE(token_url, ''),
+	COALESCE(client_id, ''), COALESCE(client_secret, ''), COALESCE(api_base_url, ''), COALESCE(user_info_endpoint, ''),
+	scopes, params, COALESCE(token_endpoint_preference, ''), COALESCE(ca_bundle, '')`
+
+func scanProvider(row scanner) (*Provider, error) {
+	var p Provider
+	err := row.Scan(&p.ID, &p.Name, &p.AuthType, &p.AuthHeader, &p.AuthURL, &p.TokenURL,
+		&p.ClientID, &p.ClientSecret, &p.APIBaseURL, &p.UserInfoEndpoint,
+		pq.Array(&p.Scopes), &p.Params, &p.TokenEndpointPreference, &p.CABundle)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func (s *Postgres) SuspendConnection(ctx context.Context, sp *Suspension) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := connstate.TransitionTx(ctx, tx, sp.ConnectionID, connstate.StateSuspended); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO connection_suspensions (connection_id, reason, suspended_by, suspended_at) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (connection_id) DO UPDATE SET reason = EXCLUDED.reason, suspended_by = EXCLUDED.suspended_by,
+			suspended_at = EXCLUDED.suspended_at`,
+		sp.ConnectionID, sp.Reason, sp.SuspendedBy, sp.SuspendedAt); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *Postgres) ResumeConnection(ctx context.Context, id uuid.UUID) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var status connstate.State
+	err = tx.QueryRowContext(ctx, `SELECT status FROM connections WHERE id = $1 FOR UPDATE`, id).Scan(&status)
+	if err == sql.ErrNoRows {
+		return connstate.ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	// Only resuming may reactivate a suspended connection, and resuming
+	// reactivates nothing else.
+	if status != connstate.StateSuspended {
+		return &connstate.TransitionError{From: status, To: connstate.StateActive}
+	}
+	if _, err := connstate.TransitionTx(ctx, tx, id, connstate.StateActive); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM connection_suspensions WHERE connection_id = $1`, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *Postgres) GetSuspension(ctx context.Context, id uuid.UUID) (*Suspension, error) {
+	var sp Suspension
+	err := s.db.QueryRowContext(ctx, `
+		SELECT sp.connection_id, sp.reason, sp.suspended_by, sp.suspended_at
+		FROM connection_suspensions sp JOIN connections c ON c.id = sp.connection_id
+		WHERE sp.connection_id = $1 AND c.status = 'suspended'`, id).
+		Scan(&sp.ConnectionID, &sp.Reason, &sp.SuspendedBy, &sp.SuspendedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &sp, nil
+}
+
+func (s *Postgres) GetProvider(ctx context.Context, id uuid.UUID) (*Provider, error) {
+	p, err := read(ctx, s, func(db *sqlx.DB) (*Provider, error) {
+		return scanProvider(db.QueryRowContext(ctx,
+			`SELECT `+providerColumns+` FROM provider_profiles WHERE id = $1`, id))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return p, openProviderSecrets(s.secretKey, p)
+}
+
+func (s *Postgres) GetProviderForWorkspace(ctx context.Context, id uuid.UUID, workspaceID string) (*Provider, error) {
+	p, err := read(ctx, s, func(db *sqlx.DB) (*Provider, error) {
+		return scanProvider(db.QueryRowContext(ctx,
+			`SELECT `+providerColumns+` FROM provider_profiles
+			WHERE id = $1 AND (cardinality(workspace_ids) = 0 OR $2 = ANY(workspace_ids))`, id, workspaceID))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return p, openProviderSecrets(s.secretKey, p)
+}
+
+// openProviderSecrets decrypts the client secret and the secret
+// credential_schema defaults of a provider with key. Secrets written before
+// encryption are plaintext and left as is.
+func openProviderSecrets(key []byte, p *Provider) error {
+	secret, err := vault.OpenSecret(key, p.ClientSecret, "client_secret")
+	if err != nil {
+		return fmt.Errorf("decrypt client secret of provider %s: %w", p.ID, err)
+	}
+	p.ClientSecret = secret
+	if p.Params, err = provider.OpenParams(key, p.Params); err != nil {
+		return fmt.Errorf("open params of provider %s: %w", p.ID, err)
+	}
+	return nil
+}
+
+func (s *Postgres) SetTokenEndpointPreference(ctx context.Context, id uuid.UUID, source string) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE provider_profiles SET token_endpoint_preference = $1 WHERE id = $2`, source, id)
+	return err
+}
+
+const saveTokensSQL = `
+	INSERT INTO tokens (connection_id, encrypted_data, expires_at)
+	VALUES ($1, $2, $3)
+	ON CONFLICT (connection_id)
+	DO UPDATE SET
+		encrypted_data = EXCLUDED.encrypted_data,
+		expires_at     = EXCLUDED.expires_at,
+		created_at     = NOW()`
+
+// SaveTokens upserts to maintain one row per connection (issue #25).
+func (s *Postgres) SaveTokens(ctx context.Context, connectionID uuid.UUID, encryptedData string, expiresAt *time.Time) error {
+	_, err := s.db.ExecContext(ctx, saveTokensSQL, connectionID, encryptedData, expiresAt)
+	return err
+}
+
+func (s *Postgres) GetTokens(ctx context.Context, connectionID uuid.UUID) (*Token, error) {
+	return read(ctx, s, func(db *sqlx.DB) (*Token, error) {
+		var t Token
+		err := db.QueryRowContext(ctx, `
+			SELECT t.encrypted_data, t.expires_at, c.workspace_id
+			FROM tokens t
+			JOIN connections c ON c.id = t.connection_id
+			WHERE t.connection_id = $1`, connectionID).Scan(&t.EncryptedData, &t.ExpiresAt, &t.WorkspaceID)
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		if err != nil {
+			return nil, err
+		}
+		return &t, nil
+	})
+}
+
+func (s *Postgres) DeleteTokens(ctx context.Context, connectionID uuid.UUID) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM tokens WHERE connection_id = $1`, connectionID)
+	return err
+}
+
+func (s *Postgres) RecordRefreshToken(ctx context.Context, connectionID uuid.UUID, tokenHash string) (*RefreshGeneration, error) {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Lock the connection row so concurrent recordings are numbered in turn.
+	if _, err := tx.ExecContext(ctx, `SELECT 1 FROM connections WHERE id = $1 FOR UPDATE`, connectionID); err != nil {
+		return nil, err
+	}
+	var current RefreshGeneration
+	err = tx.QueryRowContext(ctx, `
+		SELECT generation, token_hash, created_at, rotated_at FROM refresh_token_generations
+		WHERE connection_id = $1 ORDER BY generation DESC LIMIT 1`, connectionID).
+		Scan(&current.Generation, &current.TokenHash, &current.CreatedAt, &current.RotatedAt)
+	switch {
+	case err == sql.ErrNoRows:
+	case err != nil:
+		return nil, err
+	case current.TokenHash == tokenHash:
+		return &current, nil
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE refresh_token_generations SET rotated_at = NOW()
+		WHERE connection_id = $1 AND rotated_at IS NULL`, connectionID); err != nil {
+		return nil, err
+	}
+	next := RefreshGeneration{Generation: current.Generation + 1, TokenHash: tokenHash}
+	if err := tx.QueryRowContext(ctx, `
+		INSERT INTO refresh_token_generations (connection_id, generation, token_hash)
+		VALUES ($1, $2, $3) RETURNING created_at`, connectionID, next.Generation, tokenHash).Scan(&next.CreatedAt); err != nil {
+		return nil, err
+	}
+	return &next, tx.Commit()
+}
+
+// FindRefreshToken always reads the primary: a replica that has not seen
+// the latest rotation would hide a reuse.
+func (s *Postgres) FindRefreshToken(ctx context.Context, connectionID uuid.UUID, tokenHash string) (*RefreshGeneration, error) {
+	var g RefreshGeneration
+	err := s.db.QueryRowContext(ctx, `
+		SELECT generation, token_hash, created_at, rotated_at FROM refresh_token_generations
+		WHERE connection_id = $1 AND token_hash = $2 ORDER BY generation DESC LIMIT 1`, connectionID, tokenHash).
+		Scan(&g.Generation, &g.TokenHash, &g.CreatedAt, &g.RotatedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &g, nil
+}
+
+func (s *Postgres) CreateAuditEvent(ctx context.Context, e *AuditEvent) error {
+	return s.db.QueryRowxContext(ctx, `
+		INSERT INTO audit_events (connection_id, event_type, event_data, ip_address, user_agent)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at`,
+		e.ConnectionID, e.EventType, e.EventData, e.IPAddress, e.UserAgent).Scan(&e.ID, &e.CreatedAt)
+}
+
+func (s *Postgres) ListAuditEvents(ctx context.Context, f AuditFilter) ([]AuditEvent, error) {
+	query, args := auditEventsQuery(f)
+	args = append(args, f.Limit)
+	query += ` ORDER BY created_at DESC LIMIT $` + strconv.Itoa(len(args))
+	if f.Offset > 0 {
+		args = append(args, f.Offset)
+		query += ` OFFSET $` + strconv.Itoa(len(args))
+	}
+
+	events := []AuditEvent{}
+	if err := s.db.SelectContext(ctx, &events, query, args...); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+func (s *Postgres) StreamAuditEvents(ctx context.Context, f AuditFilter, fn func(*AuditEvent) error) error {
+	query, args := auditEventsQuery(f)
+	query += ` ORDER BY created_at ASC`
+
+	rows, err := s.db.QueryxContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var e AuditEvent
+		if err := rows.StructScan(&e); err != nil {
+			return err
+		}
+		if err := fn(&e); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// auditEventsQuery builds the SELECT and WHERE clauses for f's filters.
+func auditEventsQuery(f AuditFilter) (string, []interface{}) {
+	query := `SELECT id, connection_id, event_type, event_data, ip_address, user_agent, created_at
+			  FROM audit_events WHERE 1=1`
+	args := []interface{}{}
+
+	if f.EventType != "" {
+		args = append(args, f.EventType)
+		query += ` AND event_type = $` + strconv.Itoa(len(args))
+	}
+	if f.ConnectionID != uuid.Nil {
+		args = append(args, f.ConnectionID)
+		query += ` AND connection_id = $` + strconv.Itoa(len(args))
+	}
+	if !f.Since.IsZero() {
+		args = append(args, f.Since)
+		query += ` AND created_at >= $` + strconv.Itoa(len(args))
+	}
+	if !f.Until.IsZero() {
+		args = append(args, f.Until)
+		query += ` AND created_at < $` + strconv.Itoa(len(args))
+	}
+	return query, args
+}
+
+func (s *Postgres) GetWorkspaceLimits(ctx context.Context, workspaceID string) (*WorkspaceLimits, error) {
+	l := WorkspaceLimits{WorkspaceID: workspaceID}
+	err := s.reader.QueryRowContext(ctx, `
+		SELECT max_pending_connections, max_active_connections, max_refreshes_per_minute, updated_at
+		FROM workspace_limits WHERE workspace_id = $1`, workspaceID).
+		Scan(&l.MaxPendingConnections, &l.MaxActiveConnections, &l.MaxRefreshesPerMinute, &l.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &l, nil
+}
+
+func (s *Postgres) SetWorkspaceLimits(ctx context.Context, l *WorkspaceLimits) error {
+	return s.db.QueryRowContext(ctx, `
+		INSERT INTO workspace_limits (workspace_id, max_pending_connections, max_active_connections, max_refreshes_per_minute)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (workspace_id) DO UPDATE SET max_pending_connections = EXCLUDED.max_pending_connections,
+			max_active_connections = EXCLUDED.max_active_connections,
+			max_refreshes_per_minute = EXCLUDED.max_refreshes_per_minute, updated_at = NOW()
+		RETURNING updated_at`,
+		l.WorkspaceID, l.MaxPendingConnections, l.MaxActiveConnections, l.MaxRefreshesPerMinute).Scan(&l.UpdatedAt)
+}
+
+func (s *Postgres) DeleteWorkspaceLimits(ctx context.Context, workspaceID string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM workspace_limits WHERE workspace_id = $1`, workspaceID)
+	return err
+}
+
+func (s *Postgres) GetScopeApprovals(ctx context.Context, workspaceID string, providerID uuid.UUID) (*ScopeApprovals, error) {
+	a := ScopeApprovals{WorkspaceID: workspaceID, ProviderID: providerID}
+	err := s.reader.QueryRowContext(ctx, `
+		SELECT scopes, updated_at FROM scope_approvals WHERE workspace_id = $1 AND provider_id = $2`,
+		workspaceID, providerID).Scan(pq.Array(&a.Scopes), &a.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+func (s *Postgres) SetScopeApprovals(ctx context.Context, a *ScopeApprovals) error {
+	return s.db.QueryRowContext(ctx, `
+		INSERT INTO scope_approvals (workspace_id, provider_id, scopes)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (workspace_id, provider_id) DO UPDATE SET scopes = EXCLUDED.scopes, updated_at = NOW()
+		RETURNING updated_at`,
+		a.WorkspaceID, a.ProviderID, pq.Array(a.Scopes)).Scan(&a.UpdatedAt)
+}
+
+func (s *Postgres) DeleteScopeApprovals(ctx context.Context, workspaceID string, providerID uuid.UUID) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM scope_approvals WHERE workspace_id = $1 AND provider_id = $2`, workspaceID, providerID)
+	return err
+}
+
+func (s *Postgres) CreateGrant(ctx context.Context, g *Grant) error {
+	if g.ID == uuid.Nil {
+		g.ID = uuid.New()
+	}
+	return s.db.QueryRowContext(ctx, `
+		INSERT INTO connection_grants (id, connection_id, workspace_id, scopes, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING created_at`,
+		g.ID, g.ConnectionID, g.WorkspaceID, pq.Array(g.Scopes), g.ExpiresAt).Scan(&g.CreatedAt)
+}
+
+func (s *Postgres) RedeemGrant(ctx context.Context, id uuid.UUID, now time.Time) (*Grant, error) {
+	g := Grant{ID: id}
+	err := s.db.QueryRowContext(ctx, `
+		UPDATE connection_grants SET redeemed_at = $2
+		WHERE id = $1 AND redeemed_at IS NULL AND expires_at > $2
+		RETURNING connection_id, workspace_id, scopes, expires_at, created_at, redeemed_at`, id, now).
+		Scan(&g.ConnectionID, &g.WorkspaceID, pq.Array(&g.Scopes), &g.ExpiresAt, &g.CreatedAt, &g.RedeemedAt)
+	if err != sql.ErrNoRows {
+		if err != nil {
+			return nil, err
+		}
+		return &g, nil
+	}
+	// Tell why the grant was not redeemable.
+	var redeemed bool
+	err = s.db.QueryRowContext(ctx, `SELECT redeemed_at IS NOT NULL FROM connection_grants WHERE id = $1`, id).Scan(&redeemed)
+	switch {
+	case err == sql.ErrNoRows:
+		return nil, ErrNotFound
+	case err != nil:
+		return nil, err
+	case redeemed:
+		return nil, ErrGrantRedeemed
+	}
+	return nil, ErrGrantExpired
+}
+
+func (s *Postgres) ExpirePendingConnections(ctx context.Context) (int64, error) {
+	return connstate.ExpirePending(ctx, s.db)
+}
+
+// DeleteOrphanTokens uses NOT EXISTS rather than age-based deletion to
+// avoid removing valid tokens for slow-refreshing connections.
+func (s *Postgres) DeleteOrphanTokens(ctx context.Context) (int64, error) {
+	res, err := s.db.ExecContext(ctx, `
+		DELETE FROM tokens t
+		WHERE NOT EXISTS (
+			SELECT 1 FROM connections c WHERE c.id = t.connection_id
+		)`)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (s *Postgres) DeleteExpiredGrants(ctx context.Context, cutoff time.Time) (int64, error) {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM connection_grants WHERE expires_at < $1`, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (s *Postgres) CountWorkspaceConnections(ctx context.Context, workspaceID string) (int, int, error) {
+	var pending, active int
+	err := s.db.QueryRowContext(ctx, `
+		SELECT COUNT(*) FILTER (WHERE status = 'pending' AND expires_at > NOW()),
+			COUNT(*) FILTER (WHERE status = 'active')
+		FROM connections WHERE workspace_id = $1 AND status IN ('pending', 'active')`, workspaceID).
+		Scan(&pending, &active)
+	return pending, active, err
+}
+
+const refreshFailureColumns = `f.connection_id, c.workspace_id, c.provider_id, f.error_class, f.last_error, f.status_code,
+	f.attempts, f.first_failed_at, f.last_failed_at, f.next_retry_at`
+
+func scanRefreshFailure(row scanner) (*RefreshFailure, error) {
+	var f RefreshFailure
+	err := row.Scan(&f.ConnectionID, &f.WorkspaceID, &f.ProviderID, &f.ErrorClass, &f.LastError, &f.StatusCode,
+		&f.Attempts, &f.FirstFailedAt, &f.LastFailedAt, &f.NextRetryAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+func (s *Postgres) GetRefreshFailure(ctx context.Context, connectionID uuid.UUID) (*RefreshFailure, error) {
+	return scanRefreshFailure(s.db.QueryRowContext(ctx, `SELECT `+refreshFailureColumns+`
+		FROM refresh_failures f JOIN connections c ON c.id = f.connection_id
+		WHERE f.connection_id = $1`, connectionID))
+}
+
+func (s *Postgres) SaveRefreshFailure(ctx context.Context, f *RefreshFailure) error {
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO refresh_failures (connection_id, error_class, last_error, status_code, attempts, first_failed_at, last_failed_at, next_retry_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (connection_id) DO UPDATE SET error_class = EXCLUDED.error_class, last_error = EXCLUDED.last_error,
+			status_code = EXCLUDED.status_code, attempts = EXCLUDED.attempts, first_failed_at = EXCLUDED.first_failed_at,
+			last_failed_at = EXCLUDED.last_failed_at, next_retry_at = EXCLUDED.next_retry_at`,
+		f.ConnectionID, f.ErrorClass, f.LastError, f.StatusCode, f.Attempts, f.FirstFailedAt, f.LastFailedAt, f.NextRetryAt)
+	return err
+}
+
+func (s *Postgres) DeleteRefreshFailure(ctx context.Context, connectionID uuid.UUID) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM refresh_failures WHERE connection_id = $1`, connectionID)
+	return err
+}
+
+func (s *Postgres) ListRefreshFailures(ctx context.Context, f RefreshFailureFilter) ([]RefreshFailure, error) {
+	query := `SELECT ` + refreshFailureColumns + `
+		FROM refresh_failures f JOIN connections c ON c.id = f.connection_id
+		WHERE ($1 = '' OR c.workspace_id = $1)`
+	args := []interface{}{f.WorkspaceID}
+	if !f.DueBefore.IsZero() {
+		args = append(args, f.DueBefore)
+		query += ` AND f.next_retry_at <= $2 ORDER BY f.next_retry_at, f.connection_id`
+	} else {
+		query += ` ORDER BY f.last_failed_at DESC, f.connection_id`
+	}
+	if f.Limit > 0 {
+		args = append(args, f.Limit)
+		query += ` LIMIT $` + strconv.Itoa(len(args))
+	}
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []RefreshFailure
+	for rows.Next() {
+		rf, err := scanRefreshFailure(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, *rf)
+	}
+	return out, rows.Err()
+}
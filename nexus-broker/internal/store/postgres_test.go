@@ -0,0 +1,327 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/connstate"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/vault"
+)
+
+func newMockPostgres(t *testing.T) (*Postgres, sqlmock.Sqlmock) {
+	t.Helper()
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	return NewPostgres(sqlx.NewDb(db, "sqlmock")), mock
+}
+
+func connectionRows() *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"id", "workspace_id", "provider_id", "status", "code_verifier", "scopes", "return_url", "expires_at", "subject", "email", "tenant", "created_at", "token_retrievals", "last_accessed_at"})
+}
+
+func TestPostgres_GetPendingConnection(t *testing.T) {
+	s, mock := newMockPostgres(t)
+	id := uuid.New()
+	providerID := uuid.New()
+	expires := time.Now().Add(time.Minute)
+
+	mock.ExpectQuery(`FROM connections WHERE id = \$1 AND status = 'pending' AND expires_at > NOW\(\)`).
+		WithArgs(id).
+		WillReturnRows(connectionRows().
+			AddRow(id.String(), "ws-1", providerID.String(), "pending", "verifier", "{read,write}", "http://app/cb", expires, "", "", "organizations", time.Now(), 0, nil))
+
+	c, err := s.GetPendingConnection(context.Background(), id)
+	require.NoError(t, err)
+	assert.Equal(t, providerID, c.ProviderID)
+	assert.Equal(t, "verifier", c.CodeVerifier)
+	assert.Equal(t, "organizations", c.Tenant)
+	assert.Equal(t, []string{"read", "write"}, c.Scopes)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgres_NoRowsIsErrNotFound(t *testing.T) {
+	s, mock := newMockPostgres(t)
+	id := uuid.New()
+
+	mock.ExpectQuery(`FROM connections WHERE id = \$1`).WithArgs(id).WillReturnError(sql.ErrNoRows)
+	mock.ExpectQuery(`FROM provider_profiles WHERE id = \$1`).WithArgs(id).WillReturnError(sql.ErrNoRows)
+	mock.ExpectQuery(`FROM tokens t JOIN connections c`).WithArgs(id).WillReturnError(sql.ErrNoRows)
+
+	_, err := s.GetConnection(context.Background(), id)
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = s.GetProvider(context.Background(), id)
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = s.GetTokens(context.Background(), id)
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgres_ReplicaServesLookupsAndFallsBackToPrimary(t *testing.T) {
+	primaryDB, primary, err := sqlmock.New()
+	require.NoError(t, err)
+	t.Cleanup(func() { primaryDB.Close() })
+	replicaDB, replica, err := sqlmock.New()
+	require.NoError(t, err)
+	t.Cleanup(func() { replicaDB.Close() })
+	s := NewPostgresWithReplica(sqlx.NewDb(primaryDB, "sqlmock"), sqlx.NewDb(replicaDB, "sqlmock"))
+
+	id := uuid.New()
+	providerID := uuid.New()
+	replica.ExpectQuery(`FROM connections WHERE id = \$1`).WithArgs(id).
+		WillReturnRows(connectionRows().
+			AddRow(id.String(), "ws-1", providerID.String(), "active", "", "{}", "", time.Now(), "", "", "", time.Now(), 0, nil))
+	// A token the replica has not caught up with is read from the primary.
+	replica.ExpectQuery(`FROM tokens t`).WithArgs(id).WillReturnError(sql.ErrNoRows)
+	primary.ExpectQuery(`FROM tokens t`).WithArgs(id).
+		WillReturnRows(sqlmock.NewRows([]string{"encrypted_data", "expires_at", "workspace_id"}).AddRow("ct", nil, "ws-1"))
+	// ReadPrimary skips the replica altogether.
+	primary.ExpectQuery(`FROM tokens t`).WithArgs(id).
+		WillReturnRows(sqlmock.NewRows([]string{"encrypted_data", "expires_at", "workspace_id"}).AddRow("ct2", nil, "ws-1"))
+	primary.ExpectExec(`DELETE FROM tokens`).WithArgs(id).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	c, err := s.GetConnection(context.Background(), id)
+	require.NoError(t, err)
+	assert.EqualValues(t, "active", c.Status)
+	tok, err := s.GetTokens(context.Background(), id)
+	require.NoError(t, err)
+	assert.Equal(t, "ct", tok.EncryptedData)
+	tok, err = s.GetTokens(ReadPrimary(context.Background()), id)
+	require.NoError(t, err)
+	assert.Equal(t, "ct2", tok.EncryptedData)
+	require.NoError(t, s.DeleteTokens(context.Background(), id))
+
+	assert.NoError(t, replica.ExpectationsWereMet())
+	assert.NoError(t, primary.ExpectationsWereMet())
+}
+
+func TestPostgres_GetProviderOpensSealedSecret(t *testing.T) {
+	s, mock := newMockPostgres(t)
+	key := []byte("01234567890123456789012345678901")
+	s.WithSecretKey(key)
+	sealed, err := vault.SealSecret(key, "s3cret", "client_secret")
+	require.NoError(t, err)
+
+	id := uuid.New()
+	columns := []string{"id", "name", "auth_type", "auth_header", "auth_url", "token_url", "client_id", "client_secret",
+		"api_base_url", "user_info_endpoint", "scopes", "params", "token_endpoint_preference", "ca_bundle"}
+	mock.ExpectQuery(`FROM provider_profiles WHERE id = \$1`).WithArgs(id).
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow(id.String(), "acme", "oauth2", "", "", "", "client", sealed, "", "", "{}", nil, "", ""))
+	mock.ExpectQuery(`FROM provider_profiles WHERE id = \$1`).WithArgs(id).
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow(id.String(), "acme", "oauth2", "", "", "", "client", "legacy-plaintext", "", "", "{}", nil, "", ""))
+
+	p, err := s.GetProvider(context.Background(), id)
+	require.NoError(t, err)
+	assert.Equal(t, "s3cret", p.ClientSecret)
+	p, err = s.GetProvider(context.Background(), id)
+	require.NoError(t, err)
+	assert.Equal(t, "legacy-plaintext", p.ClientSecret)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgres_CreateConnectionAssignsIDAndPending(t *testing.T) {
+	s, mock := newMockPostgres(t)
+
+	mock.ExpectExec(`INSERT INTO connections`).
+		WithArgs(sqlmock.AnyArg(), "ws-1", sqlmock.AnyArg(), nil, sqlmock.AnyArg(), "http://app/cb", sqlmock.AnyArg(), "").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	c := &Connection{WorkspaceID: "ws-1", ProviderID: uuid.New(), ReturnURL: "http://app/cb", ExpiresAt: time.Now()}
+	require.NoError(t, s.CreateConnection(context.Background(), c))
+	assert.NotEqual(t, uuid.Nil, c.ID)
+	assert.EqualValues(t, "pending", c.Status)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgres_ListAuditEventsFilters(t *testing.T) {
+	s, mock := newMockPostgres(t)
+	since := time.Now().Add(-time.Hour)
+
+	mock.ExpectQuery(`FROM audit_events WHERE 1=1 AND event_type = \$1 AND created_at >= \$2 ORDER BY created_at DESC LIMIT \$3`).
+		WithArgs("token_refreshed", since, 10).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "connection_id", "event_type", "event_data", "ip_address", "user_agent", "created_at"}).
+			AddRow(uuid.New().String(), nil, "token_refreshed", nil, nil, nil, time.Now()))
+
+	events, err := s.ListAuditEvents(context.Background(), AuditFilter{EventType: "token_refreshed", Since: since, Limit: 10})
+	require.NoError(t, err)
+	assert.Len(t, events, 1)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgres_ListUserConnectionsMatchesSubjectOrEmail(t *testing.T) {
+	s, mock := newMockPostgres(t)
+
+	mock.ExpectQuery(`FROM connections WHERE workspace_id = \$1 AND \(subject = \$2 OR lower\(email\) = lower\(\$2\)\)`).
+		WithArgs("ws-1", "Alice@Example.com").
+		WillReturnRows(connectionRows().
+			AddRow(uuid.New().String(), "ws-1", uuid.New().String(), "active", "", "{}", "", time.Now(), "sub-1", "alice@example.com", "", time.Now(), 3, time.Now()).
+			AddRow(uuid.New().String(), "ws-1", uuid.New().String(), "revoked", "", "{}", "", time.Now(), "sub-1", "alice@example.com", "", time.Now(), 0, nil))
+
+	conns, err := s.ListUserConnections(context.Background(), "ws-1", "Alice@Example.com")
+	require.NoError(t, err)
+	require.Len(t, conns, 2)
+	assert.Equal(t, "sub-1", conns[0].Subject)
+	assert.EqualValues(t, "revoked", conns[1].Status)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgres_RecordRefreshTokenRotatesPrevious(t *testing.T) {
+	s, mock := newMockPostgres(t)
+	id := uuid.New()
+	genRows := func() *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"generation", "token_hash", "created_at", "rotated_at"})
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`SELECT 1 FROM connections WHERE id = \$1 FOR UPDATE`).WithArgs(id).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`FROM refresh_token_generations`).WithArgs(id).
+		WillReturnRows(genRows().AddRow(3, "old", time.Now(), nil))
+	mock.ExpectExec(`UPDATE refresh_token_generations SET rotated_at = NOW\(\)`).WithArgs(id).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`INSERT INTO refresh_token_generations`).WithArgs(id, 4, "new").
+		WillReturnRows(sqlmock.NewRows([]string{"created_at"}).AddRow(time.Now()))
+	mock.ExpectCommit()
+
+	g, err := s.RecordRefreshToken(context.Background(), id, "new")
+	require.NoError(t, err)
+	assert.Equal(t, 4, g.Generation)
+
+	// The current token is not recorded twice.
+	mock.ExpectBegin()
+	mock.ExpectExec(`SELECT 1 FROM connections`).WithArgs(id).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`FROM refresh_token_generations`).WithArgs(id).
+		WillReturnRows(genRows().AddRow(4, "new", time.Now(), nil))
+	mock.ExpectRollback()
+
+	g, err = s.RecordRefreshToken(context.Background(), id, "new")
+	require.NoError(t, err)
+	assert.Equal(t, 4, g.Generation)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgres_ListAuditEventsConnectionUntilOffset(t *testing.T) {
+	s, mock := newMockPostgres(t)
+	connID := uuid.New()
+	until := time.Now()
+
+	mock.ExpectQuery(`FROM audit_events WHERE 1=1 AND connection_id = \$1 AND created_at < \$2 ORDER BY created_at DESC LIMIT \$3 OFFSET \$4`).
+		WithArgs(connID, until, 10, 20).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "connection_id", "event_type", "event_data", "ip_address", "user_agent", "created_at"}))
+
+	_, err := s.ListAuditEvents(context.Background(), AuditFilter{ConnectionID: connID, Until: until, Limit: 10, Offset: 20})
+	require.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgres_StreamAuditEventsOldestFirst(t *testing.T) {
+	s, mock := newMockPostgres(t)
+
+	mock.ExpectQuery(`FROM audit_events WHERE 1=1 AND event_type = \$1 ORDER BY created_at ASC`).
+		WithArgs("token_retrieved").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "connection_id", "event_type", "event_data", "ip_address", "user_agent", "created_at"}).
+			AddRow(uuid.New().String(), nil, "token_retrieved", nil, nil, nil, time.Now().Add(-time.Minute)).
+			AddRow(uuid.New().String(), nil, "token_retrieved", nil, nil, nil, time.Now()))
+
+	var n int
+	err := s.StreamAuditEvents(context.Background(), AuditFilter{EventType: "token_retrieved", Limit: 1}, func(e *AuditEvent) error {
+		n++
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgres_RecordTokenUsage(t *testing.T) {
+	s, mock := newMockPostgres(t)
+	id := uuid.New()
+	at := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	mock.ExpectExec(`UPDATE connections c SET token_retrievals = c.token_retrievals \+ u.n`).
+		WithArgs(`{"`+id.String()+`"}`, "{3}", `{"2026-05-01T12:00:00Z"}`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	require.NoError(t, s.RecordTokenUsage(context.Background(), []TokenUsage{{ConnectionID: id, Retrievals: 3, LastAccessedAt: at}}))
+	require.NoError(t, s.RecordTokenUsage(context.Background(), nil))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgres_ListRefreshFailures_Due(t *testing.T) {
+	s, mock := newMockPostgres(t)
+	id, providerID := uuid.New(), uuid.New()
+	now := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+	next := now.Add(-time.Minute)
+
+	mock.ExpectQuery(`FROM refresh_failures f JOIN connections c .* AND f.next_retry_at <= \$2 ORDER BY f.next_retry_at, f.connection_id LIMIT \$3`).
+		WithArgs("", now, 10).
+		WillReturnRows(sqlmock.NewRows([]string{"connection_id", "workspace_id", "provider_id", "error_class", "last_error",
+			"status_code", "attempts", "first_failed_at", "last_failed_at", "next_retry_at"}).
+			AddRow(id.String(), "ws-1", providerID.String(), "network", "connection reset", 0, 2, now.Add(-time.Hour), now.Add(-2*time.Minute), next))
+
+	failures, err := s.ListRefreshFailures(context.Background(), RefreshFailureFilter{DueBefore: now, Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, failures, 1)
+	assert.Equal(t, id, failures[0].ConnectionID)
+	assert.Equal(t, providerID, failures[0].ProviderID)
+	assert.Equal(t, 2, failures[0].Attempts)
+	require.NotNil(t, failures[0].NextRetryAt)
+	assert.True(t, failures[0].NextRetryAt.Equal(next))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgres_ResumeConnectionOnlyFromSuspended(t *testing.T) {
+	s, mock := newMockPostgres(t)
+	id := uuid.New()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT status FROM connections WHERE id = \$1 FOR UPDATE`).WithArgs(id.String()).
+		WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow("needs_reauth"))
+	mock.ExpectRollback()
+	var te *connstate.TransitionError
+	require.ErrorAs(t, s.ResumeConnection(context.Background(), id), &te)
+	assert.Equal(t, connstate.StateNeedsReauth, te.From)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT status FROM connections WHERE id = \$1 FOR UPDATE`).WithArgs(id.String()).
+		WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow("suspended"))
+	mock.ExpectQuery(`SELECT status FROM connections WHERE id = \$1 FOR UPDATE`).WithArgs(id.String()).
+		WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow("suspended"))
+	mock.ExpectExec(`UPDATE connections SET status`).WithArgs("active", id.String()).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO connection_state_transitions`).WithArgs(id.String(), "suspended", "active").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`DELETE FROM connection_suspensions`).WithArgs(id.String()).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	require.NoError(t, s.ResumeConnection(context.Background(), id))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgres_FailConnectionRecordsCode(t *testing.T) {
+	s, mock := newMockPostgres(t)
+	id := uuid.New()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT status FROM connections WHERE id = \$1 FOR UPDATE`).WithArgs(id.String()).
+		WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow("pending"))
+	mock.ExpectExec(`UPDATE connections SET status`).WithArgs("failed", id.String()).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO connection_state_transitions`).WithArgs(id.String(), "pending", "failed").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE connections SET error_code`).WithArgs("token_exchange_failed", id.String()).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	require.NoError(t, s.FailConnection(context.Background(), id, "token_exchange_failed"))
+
+	// An active connection cannot fail, and keeps no code.
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT status FROM connections WHERE id = \$1 FOR UPDATE`).WithArgs(id.String()).
+		WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow("active"))
+	mock.ExpectRollback()
+	var te *connstate.TransitionError
+	require.ErrorAs(t, s.FailConnection(context.Background(), id, "token_exchange_failed"), &te)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,884 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/connstate"
+)
+
+// SQL implements Store on MySQL or SQLite, for deployments without
+// Postgres. Its schema is created by the migrations in migrations.MySQL
+// and migrations.SQLite (see migrate.NewForDialect), and provider profiles
+// are managed by provider.SQLStore. Timestamps are written by the store, in
+// UTC, and string lists are stored as JSON arrays.
+type SQL struct {
+	db      *sqlx.DB
+	dialect dialect
+	// secretKey opens provider secrets sealed with vault.SealSecret.
+	secretKey []byte
+}
+
+var _ Store = (*SQL)(nil)
+
+// NewMySQL creates a store on a MySQL database opened with OpenMySQL.
+func NewMySQL(db *sqlx.DB) *SQL {
+	return &SQL{db: db, dialect: mysqlDialect}
+}
+
+// NewSQLite creates a store on a SQLite database opened with OpenSQLite.
+func NewSQLite(db *sqlx.DB) *SQL {
+	return &SQL{db: db, dialect: sqliteDialect}
+}
+
+// WithSecretKey makes s decrypt the provider secrets it reads with key. It
+// returns s.
+func (s *SQL) WithSecretKey(key []byte) *SQL {
+	s.secretKey = key
+	return s
+}
+
+// dialect is what differs between the SQL databases.
+type dialect struct {
+	name string
+	// forUpdate locks the rows a SELECT in a transaction reads. SQLite
+	// locks the whole database when the transaction begins instead.
+	forUpdate string
+	// upsert turns an INSERT into one that updates cols of the row already
+	// holding key.
+	upsert func(key, cols []string) string
+}
+
+var mysqlDialect = dialect{
+	name:      "mysql",
+	forUpdate: " FOR UPDATE",
+	upsert: func(key, cols []string) string {
+		set := make([]string, len(cols))
+		for i, c := range cols {
+			set[i] = c + " = VALUES(" + c + ")"
+		}
+		return " ON DUPLICATE KEY UPDATE " + strings.Join(set, ", ")
+	},
+}
+
+var sqliteDialect = dialect{
+	name: "sqlite",
+	upsert: func(key, cols []string) string {
+		set := make([]string, len(cols))
+		for i, c := range cols {
+			set[i] = c + " = excluded." + c
+		}
+		return " ON CONFLICT (" + strings.Join(key, ", ") + ") DO UPDATE SET " + strings.Join(set, ", ")
+	},
+}
+
+func (s *SQL) now() time.Time { return time.Now().UTC() }
+
+// utc converts t for a query parameter. SQLite compares timestamps as
+// text, which only orders them when they share a zone.
+func utc(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	u := t.UTC()
+	return &u
+}
+
+// stringList stores a []string as a JSON array.
+type stringList []string
+
+func (l *stringList) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*l = nil
+		return nil
+	case string:
+		return json.Unmarshal([]byte(v), (*[]string)(l))
+	case []byte:
+		return json.Unmarshal(v, (*[]string)(l))
+	}
+	return fmt.Errorf("store: cannot scan %T into a string list", src)
+}
+
+func (l stringList) Value() (driver.Value, error) {
+	if l == nil {
+		return "[]", nil
+	}
+	b, err := json.Marshal([]string(l))
+	return string(b), err
+}
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+func scanSQLConnection(row scanner) (*Connection, error) {
+	var c Connection
+	err := row.Scan(&c.ID, &c.WorkspaceID, &c.ProviderID, &c.Status, &c.CodeVerifier, (*stringList)(&c.Scopes), &c.ReturnURL, &c.ExpiresAt,
+		&c.Subject, &c.Email, &c.Tenant, &c.CreatedAt, &c.TokenRetrievals, &c.LastAccessedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func scanSQLConnections(rows *sql.Rows) ([]Connection, error) {
+	defer rows.Close()
+	conns := []Connection{}
+	for rows.Next() {
+		c, err := scanSQLConnection(rows)
+		if err != nil {
+			return nil, err
+		}
+		conns = append(conns, *c)
+	}
+	return conns, rows.Err()
+}
+
+func (s *SQL) CreateConnection(ctx context.Context, c *Connection) error {
+	if c.ID == uuid.Nil {
+		c.ID = uuid.New()
+	}
+	c.Status = connstate.StatePending
+	now := s.now()
+	c.CreatedAt = now
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO connections (id, workspace_id, provider_id, status, code_verifier, scopes, return_url, expires_at, tenant,
+			created_at, updated_at, status_changed_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		c.ID, c.WorkspaceID, c.ProviderID, c.Status, nullString(c.CodeVerifier), stringList(c.Scopes), c.ReturnURL,
+		c.ExpiresAt.UTC(), nullString(c.Tenant), now, now, now)
+	return err
+}
+
+func (s *SQL) GetConnection(ctx context.Context, id uuid.UUID) (*Connection, error) {
+	return scanSQLConnection(s.db.QueryRowContext(ctx, `SELECT `+connectionColumns+` FROM connections WHERE id = ?`, id))
+}
+
+func (s *SQL) GetPendingConnection(ctx context.Context, id uuid.UUID) (*Connection, error) {
+	return scanSQLConnection(s.db.QueryRowContext(ctx,
+		`SELECT `+connectionColumns+` FROM connections WHERE id = ? AND status = 'pending' AND expires_at > ?`, id, s.now()))
+}
+
+// inTx runs fn in a transaction, committed if fn returns nil.
+func (s *SQL) inTx(ctx context.Context, fn func(tx *sqlx.Tx) error) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// transitionTx is connstate.TransitionTx for this schema.
+func (s *SQL) transitionTx(ctx context.Context, tx *sqlx.Tx, id uuid.UUID, to connstate.State) (connstate.State, error) {
+	var from connstate.State
+	err := tx.QueryRowContext(ctx, `SELECT status FROM connections WHERE id = ?`+s.dialect.forUpdate, id).Scan(&from)
+	if err == sql.ErrNoRows {
+		return "", connstate.ErrNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	if from == to {
+		return from, nil
+	}
+	if !from.CanTransition(to) {
+		return from, &connstate.TransitionError{From: from, To: to}
+	}
+	now := s.now()
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE connections SET status = ?, status_changed_at = ?, updated_at = ? WHERE id = ?`, to, now, now, id); err != nil {
+		return from, err
+	}
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO connection_state_transitions (connection_id, from_state, to_state, created_at) VALUES (?, ?, ?, ?)`,
+		id, from, to, now); err != nil {
+		return from, err
+	}
+	return from, nil
+}
+
+func (s *SQL) UpdateStatus(ctx context.Context, id uuid.UUID, to connstate.State) error {
+	return s.inTx(ctx, func(tx *sqlx.Tx) error {
+		_, err := s.transitionTx(ctx, tx, id, to)
+		return err
+	})
+}
+
+func (s *SQL) FailConnection(ctx context.Context, id uuid.UUID, code string) error {
+	return s.inTx(ctx, func(tx *sqlx.Tx) error {
+		if _, err := s.transitionTx(ctx, tx, id, connstate.StateFailed); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx, `UPDATE connections SET error_code = ? WHERE id = ?`, nullString(code), id)
+		return err
+	})
+}
+
+func (s *SQL) GetFailureCode(ctx context.Context, id uuid.UUID) (string, error) {
+	var code string
+	err := s.db.QueryRowContext(ctx, `SELECT COALESCE(error_code, '') FROM connections WHERE id = ?`, id).Scan(&code)
+	if err == sql.ErrNoRows {
+		return "", ErrNotFound
+	}
+	return code, err
+}
+
+func (s *SQL) SetIdentity(ctx context.Context, id uuid.UUID, subject, email string) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE connections SET subject = ?, email = ? WHERE id = ?`,
+		nullString(subject), nullString(email), id)
+	return err
+}
+
+func (s *SQL) SetTenant(ctx context.Context, id uuid.UUID, tenant string) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE connections SET tenant = ? WHERE id = ?`, nullString(tenant), id)
+	return err
+}
+
+func (s *SQL) ListUserConnections(ctx context.Context, workspaceID, user string) ([]Connection, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+connectionColumns+` FROM connections
+		WHERE workspace_id = ? AND (subject = ? OR lower(email) = lower(?))
+		ORDER BY created_at`, workspaceID, user, user)
+	if err != nil {
+		return nil, err
+	}
+	return scanSQLConnections(rows)
+}
+
+func (s *SQL) StartReauthorization(ctx context.Context, id uuid.UUID, r Reauthorization) error {
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE connections SET reauth_code_verifier = ?, reauth_scopes = ?, reauth_return_url = ?, reauth_expires_at = ?,
+			updated_at = ?
+		WHERE id = ? AND status IN ('active', 'needs_reauth')`,
+		r.CodeVerifier, stringList(r.Scopes), r.ReturnURL, r.ExpiresAt.UTC(), s.now(), id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *SQL) GetPendingReauthorization(ctx context.Context, id uuid.UUID) (*Connection, error) {
+	return scanSQLConnection(s.db.QueryRowContext(ctx, `
+		SELECT id, workspace_id, provider_id, status, reauth_code_verifier, reauth_scopes, reauth_return_url, reauth_expires_at,
+			COALESCE(subject, ''), COALESCE(email, ''), COALESCE(tenant, ''), created_at, token_retrievals, last_accessed_at
+		FROM connections
+		WHERE id = ? AND status IN ('active', 'needs_reauth') AND reauth_expires_at > ?`, id, s.now()))
+}
+
+func (s *SQL) CompleteReauthorization(ctx context.Context, id uuid.UUID, scopes []string, encryptedData string, expiresAt *time.Time) error {
+	return s.inTx(ctx, func(tx *sqlx.Tx) error {
+		var until time.Time
+		err := tx.QueryRowContext(ctx,
+			`SELECT reauth_expires_at FROM connections WHERE id = ? AND reauth_expires_at IS NOT NULL`+s.dialect.forUpdate, id).Scan(&until)
+		if err == sql.ErrNoRows || (err == nil && !until.After(s.now())) {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if _, err := s.transitionTx(ctx, tx, id, connstate.StateActive); err != nil {
+			return err
+		}
+		if err := s.saveTokens(ctx, tx, id, encryptedData, expiresAt); err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `
+			UPDATE connections SET scopes = ?, reauth_code_verifier = NULL, reauth_scopes = NULL, reauth_return_url = NULL,
+				reauth_expires_at = NULL, updated_at = ?
+			WHERE id = ?`, stringList(scopes), s.now(), id)
+		return err
+	})
+}
+
+func (s *SQL) CancelReauthorization(ctx context.Context, id uuid.UUID) error {
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE connections SET reauth_code_verifier = NULL, reauth_scopes = NULL, reauth_return_url = NULL, reauth_expires_at = NULL
+		WHERE id = ?`, id)
+	return err
+}
+
+func (s *SQL) RecordTokenUsage(ctx context.Context, usage []TokenUsage) error {
+	if len(usage) == 0 {
+		return nil
+	}
+	return s.inTx(ctx, func(tx *sqlx.Tx) error {
+		for _, u := range usage {
+			at := u.LastAccessedAt.UTC()
+			if _, err := tx.ExecContext(ctx, `
+				UPDATE connections SET token_retrievals = token_retrievals + ?,
+					last_accessed_at = CASE WHEN last_accessed_at IS NULL OR last_accessed_at < ? THEN ? ELSE last_accessed_at END
+				WHERE id = ?`, u.Retrievals, at, at, u.ConnectionID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *SQL) ListStaleConnections(ctx context.Context, f StaleFilter) ([]Connection, error) {
+	query := `SELECT ` + connectionColumns + ` FROM connections
+		WHERE status IN ('active', 'needs_reauth') AND COALESCE(last_accessed_at, created_at) < ?`
+	args := []interface{}{f.UnusedSince.UTC()}
+	if f.WorkspaceID != "" {
+		query += ` AND workspace_id = ?`
+		args = append(args, f.WorkspaceID)
+	}
+	query += ` ORDER BY COALESCE(last_accessed_at, created_at), id`
+	if f.Limit > 0 {
+		query += ` LIMIT ?`
+		args = append(args, f.Limit)
+	}
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return scanSQLConnections(rows)
+}
+
+func (s *SQL) ListAwaitingApproval(ctx context.Context, f ApprovalFilter) ([]Connection, error) {
+	query := `SELECT ` + connectionColumns + ` FROM connections WHERE status = 'awaiting_approval'`
+	var args []interface{}
+	if f.WorkspaceID != "" {
+		query += ` AND workspace_id = ?`
+		args = append(args, f.WorkspaceID)
+	}
+	query += ` ORDER BY created_at, id`
+	if f.Limit > 0 {
+		query += ` LIMIT ?`
+		args = append(args, f.Limit)
+	}
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return scanSQLConnections(rows)
+}
+
+func (s *SQL) SuspendConnection(ctx context.Context, sp *Suspension) error {
+	return s.inTx(ctx, func(tx *sqlx.Tx) error {
+		if _, err := s.transitionTx(ctx, tx, sp.ConnectionID, connstate.StateSuspended); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO connection_suspensions (connection_id, reason, suspended_by, suspended_at) VALUES (?, ?, ?, ?)`+
+			s.dialect.upsert([]string{"connection_id"}, []string{"reason", "suspended_by", "suspended_at"}),
+			sp.ConnectionID, sp.Reason, sp.SuspendedBy, sp.SuspendedAt.UTC())
+		return err
+	})
+}
+
+func (s *SQL) ResumeConnection(ctx context.Context, id uuid.UUID) error {
+	return s.inTx(ctx, func(tx *sqlx.Tx) error {
+		var status connstate.State
+		err := tx.QueryRowContext(ctx, `SELECT status FROM connections WHERE id = ?`+s.dialect.forUpdate, id).Scan(&status)
+		if err == sql.ErrNoRows {
+			return connstate.ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if status != connstate.StateSuspended {
+			return &connstate.TransitionError{From: status, To: connstate.StateActive}
+		}
+		if _, err := s.transitionTx(ctx, tx, id, connstate.StateActive); err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `DELETE FROM connection_suspensions WHERE connection_id = ?`, id)
+		return err
+	})
+}
+
+func (s *SQL) GetSuspension(ctx context.Context, id uuid.UUID) (*Suspension, error) {
+	var sp Suspension
+	err := s.db.QueryRowContext(ctx, `
+		SELECT sp.connection_id, sp.reason, sp.suspended_by, sp.suspended_at
+		FROM connection_suspensions sp JOIN connections c ON c.id = sp.connection_id
+		WHERE sp.connection_id = ? AND c.status = 'suspended'`, id).
+		Scan(&sp.ConnectionID, &sp.Reason, &sp.SuspendedBy, &sp.SuspendedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &sp, nil
+}
+
+// SaveProvider adds or replaces a provider profile. workspaceIDs restricts
+// it to those workspaces; none makes it global. Secrets are stored as
+// given, so seal them with vault.SealSecret and WithSecretKey to keep them
+// encrypted.
+func (s *SQL) SaveProvider(ctx context.Context, p *Provider, workspaceIDs ...string) error {
+	if p.ID == uuid.Nil {
+		p.ID = uuid.New()
+	}
+	var params sql.NullString
+	if p.Params != nil {
+		params = sql.NullString{String: string(*p.Params), Valid: true}
+	}
+	now := s.now()
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO provider_profiles (id, name, auth_type, auth_header, auth_url, token_url, client_id, client_secret,
+			api_base_url, user_info_endpoint, scopes, params, token_endpoint_preference, ca_bundle, workspace_ids,
+			created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`+
+		s.dialect.upsert([]string{"id"}, []string{"name", "auth_type", "auth_header", "auth_url", "token_url", "client_id",
+			"client_secret", "api_base_url", "user_info_endpoint", "scopes", "params", "token_endpoint_preference", "ca_bundle",
+			"workspace_ids", "updated_at"}),
+		p.ID, p.Name, p.AuthType, nullString(p.AuthHeader), nullString(p.AuthURL), nullString(p.TokenURL),
+		nullString(p.ClientID), nullString(p.ClientSecret), nullString(p.APIBaseURL), nullString(p.UserInfoEndpoint),
+		stringList(p.Scopes), params, nullString(p.TokenEndpointPreference), nullString(p.CABundle),
+		stringList(workspaceIDs), now, now)
+	return err
+}
+
+// getProvider returns the provider and the workspaces it is restricted to.
+func (s *SQL) getProvider(ctx context.Context, id uuid.UUID) (*Provider, []string, error) {
+	var (
+		p          Provider
+		params     sql.NullString
+		workspaces stringList
+	)
+	err := s.db.QueryRowContext(ctx, `SELECT `+providerColumns+`, workspace_ids FROM provider_profiles WHERE id = ?`, id).
+		Scan(&p.ID, &p.Name, &p.AuthType, &p.AuthHeader, &p.AuthURL, &p.TokenURL,
+			&p.ClientID, &p.ClientSecret, &p.APIBaseURL, &p.UserInfoEndpoint,
+			(*stringList)(&p.Scopes), &params, &p.TokenEndpointPreference, &p.CABundle, &workspaces)
+	if err == sql.ErrNoRows {
+		return nil, nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if params.Valid {
+		raw := json.RawMessage(params.String)
+		p.Params = &raw
+	}
+	if err := openProviderSecrets(s.secretKey, &p); err != nil {
+		return nil, nil, err
+	}
+	return &p, workspaces, nil
+}
+
+func (s *SQL) GetProvider(ctx context.Context, id uuid.UUID) (*Provider, error) {
+	p, _, err := s.getProvider(ctx, id)
+	return p, err
+}
+
+func (s *SQL) GetProviderForWorkspace(ctx context.Context, id uuid.UUID, workspaceID string) (*Provider, error) {
+	p, workspaces, err := s.getProvider(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(workspaces) == 0 {
+		return p, nil
+	}
+	for _, ws := range workspaces {
+		if ws == workspaceID {
+			return p, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (s *SQL) SetTokenEndpointPreference(ctx context.Context, id uuid.UUID, source string) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE provider_profiles SET token_endpoint_preference = ? WHERE id = ?`, source, id)
+	return err
+}
+
+func (s *SQL) saveTokens(ctx context.Context, db sqlx.ExecerContext, connectionID uuid.UUID, encryptedData string, expiresAt *time.Time) error {
+	_, err := db.ExecContext(ctx, `INSERT INTO tokens (connection_id, encrypted_data, expires_at, created_at) VALUES (?, ?, ?, ?)`+
+		s.dialect.upsert([]string{"connection_id"}, []string{"encrypted_data", "expires_at", "created_at"}),
+		connectionID, encryptedData, utc(expiresAt), s.now())
+	return err
+}
+
+func (s *SQL) SaveTokens(ctx context.Context, connectionID uuid.UUID, encryptedData string, expiresAt *time.Time) error {
+	return s.saveTokens(ctx, s.db, connectionID, encryptedData, expiresAt)
+}
+
+func (s *SQL) GetTokens(ctx context.Context, connectionID uuid.UUID) (*Token, error) {
+	var t Token
+	err := s.db.QueryRowContext(ctx, `
+		SELECT t.encrypted_data, t.expires_at, c.workspace_id
+		FROM tokens t
+		JOIN connections c ON c.id = t.connection_id
+		WHERE t.connection_id = ?`, connectionID).Scan(&t.EncryptedData, &t.ExpiresAt, &t.WorkspaceID)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+func (s *SQL) DeleteTokens(ctx context.Context, connectionID uuid.UUID) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM tokens WHERE connection_id = ?`, connectionID)
+	return err
+}
+
+func (s *SQL) RecordRefreshToken(ctx context.Context, connectionID uuid.UUID, tokenHash string) (*RefreshGeneration, error) {
+	var next RefreshGeneration
+	err := s.inTx(ctx, func(tx *sqlx.Tx) error {
+		// Lock the connection row so concurrent recordings are numbered in
+		// turn.
+		var one int
+		err := tx.QueryRowContext(ctx, `SELECT 1 FROM connections WHERE id = ?`+s.dialect.forUpdate, connectionID).Scan(&one)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+		var current RefreshGeneration
+		err = tx.QueryRowContext(ctx, `
+			SELECT generation, token_hash, created_at, rotated_at FROM refresh_token_generations
+			WHERE connection_id = ? ORDER BY generation DESC LIMIT 1`, connectionID).
+			Scan(&current.Generation, &current.TokenHash, &current.CreatedAt, &current.RotatedAt)
+		switch {
+		case err == sql.ErrNoRows:
+		case err != nil:
+			return err
+		case current.TokenHash == tokenHash:
+			next = current
+			return nil
+		}
+
+		now := s.now()
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE refresh_token_generations SET rotated_at = ?
+			WHERE connection_id = ? AND rotated_at IS NULL`, now, connectionID); err != nil {
+			return err
+		}
+		next = RefreshGeneration{Generation: current.Generation + 1, TokenHash: tokenHash, CreatedAt: now}
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO refresh_token_generations (connection_id, generation, token_hash, created_at)
+			VALUES (?, ?, ?, ?)`, connectionID, next.Generation, tokenHash, now)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &next, nil
+}
+
+func (s *SQL) FindRefreshToken(ctx context.Context, connectionID uuid.UUID, tokenHash string) (*RefreshGeneration, error) {
+	var g RefreshGeneration
+	err := s.db.QueryRowContext(ctx, `
+		SELECT generation, token_hash, created_at, rotated_at FROM refresh_token_generations
+		WHERE connection_id = ? AND token_hash = ? ORDER BY generation DESC LIMIT 1`, connectionID, tokenHash).
+		Scan(&g.Generation, &g.TokenHash, &g.CreatedAt, &g.RotatedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &g, nil
+}
+
+func (s *SQL) CreateAuditEvent(ctx context.Context, e *AuditEvent) error {
+	e.ID = uuid.New()
+	e.CreatedAt = s.now()
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO audit_events (id, connection_id, event_type, event_data, ip_address, user_agent, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		e.ID, e.ConnectionID, e.EventType, e.EventData, e.IPAddress, e.UserAgent, e.CreatedAt)
+	return err
+}
+
+func (s *SQL) ListAuditEvents(ctx context.Context, f AuditFilter) ([]AuditEvent, error) {
+	query, args := sqlAuditEventsQuery(f)
+	query += ` ORDER BY created_at DESC LIMIT ?`
+	args = append(args, f.Limit)
+	if f.Offset > 0 {
+		query += ` OFFSET ?`
+		args = append(args, f.Offset)
+	}
+
+	events := []AuditEvent{}
+	if err := s.db.SelectContext(ctx, &events, query, args...); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+func (s *SQL) StreamAuditEvents(ctx context.Context, f AuditFilter, fn func(*AuditEvent) error) error {
+	query, args := sqlAuditEventsQuery(f)
+	query += ` ORDER BY created_at ASC`
+
+	rows, err := s.db.QueryxContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var e AuditEvent
+		if err := rows.StructScan(&e); err != nil {
+			return err
+		}
+		if err := fn(&e); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// sqlAuditEventsQuery builds the SELECT and WHERE clauses for f's filters.
+func sqlAuditEventsQuery(f AuditFilter) (string, []interface{}) {
+	query := `SELECT id, connection_id, event_type, event_data, ip_address, user_agent, created_at
+			  FROM audit_events WHERE 1=1`
+	args := []interface{}{}
+
+	if f.EventType != "" {
+		query += ` AND event_type = ?`
+		args = append(args, f.EventType)
+	}
+	if f.ConnectionID != uuid.Nil {
+		query += ` AND connection_id = ?`
+		args = append(args, f.ConnectionID)
+	}
+	if !f.Since.IsZero() {
+		query += ` AND created_at >= ?`
+		args = append(args, f.Since.UTC())
+	}
+	if !f.Until.IsZero() {
+		query += ` AND created_at < ?`
+		args = append(args, f.Until.UTC())
+	}
+	return query, args
+}
+
+func (s *SQL) GetWorkspaceLimits(ctx context.Context, workspaceID string) (*WorkspaceLimits, error) {
+	l := WorkspaceLimits{WorkspaceID: workspaceID}
+	err := s.db.QueryRowContext(ctx, `
+		SELECT max_pending_connections, max_active_connections, max_refreshes_per_minute, updated_at
+		FROM workspace_limits WHERE workspace_id = ?`, workspaceID).
+		Scan(&l.MaxPendingConnections, &l.MaxActiveConnections, &l.MaxRefreshesPerMinute, &l.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &l, nil
+}
+
+func (s *SQL) SetWorkspaceLimits(ctx context.Context, l *WorkspaceLimits) error {
+	l.UpdatedAt = s.now()
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO workspace_limits (workspace_id, max_pending_connections, max_active_connections, max_refreshes_per_minute, updated_at)
+		VALUES (?, ?, ?, ?, ?)`+
+		s.dialect.upsert([]string{"workspace_id"}, []string{"max_pending_connections", "max_active_connections",
+			"max_refreshes_per_minute", "updated_at"}),
+		l.WorkspaceID, l.MaxPendingConnections, l.MaxActiveConnections, l.MaxRefreshesPerMinute, l.UpdatedAt)
+	return err
+}
+
+func (s *SQL) DeleteWorkspaceLimits(ctx context.Context, workspaceID string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM workspace_limits WHERE workspace_id = ?`, workspaceID)
+	return err
+}
+
+func (s *SQL) CountWorkspaceConnections(ctx context.Context, workspaceID string) (int, int, error) {
+	var pending, active int
+	err := s.db.QueryRowContext(ctx, `
+		SELECT COALESCE(SUM(CASE WHEN status = 'pending' AND expires_at > ? THEN 1 ELSE 0 END), 0),
+			COALESCE(SUM(CASE WHEN status = 'active' THEN 1 ELSE 0 END), 0)
+		FROM connections WHERE workspace_id = ? AND status IN ('pending', 'active')`, s.now(), workspaceID).
+		Scan(&pending, &active)
+	return pending, active, err
+}
+
+func (s *SQL) GetScopeApprovals(ctx context.Context, workspaceID string, providerID uuid.UUID) (*ScopeApprovals, error) {
+	a := ScopeApprovals{WorkspaceID: workspaceID, ProviderID: providerID}
+	err := s.db.QueryRowContext(ctx, `
+		SELECT scopes, updated_at FROM scope_approvals WHERE workspace_id = ? AND provider_id = ?`,
+		workspaceID, providerID).Scan((*stringList)(&a.Scopes), &a.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+func (s *SQL) SetScopeApprovals(ctx context.Context, a *ScopeApprovals) error {
+	a.UpdatedAt = s.now()
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO scope_approvals (workspace_id, provider_id, scopes, updated_at) VALUES (?, ?, ?, ?)`+
+		s.dialect.upsert([]string{"workspace_id", "provider_id"}, []string{"scopes", "updated_at"}),
+		a.WorkspaceID, a.ProviderID, stringList(a.Scopes), a.UpdatedAt)
+	return err
+}
+
+func (s *SQL) DeleteScopeApprovals(ctx context.Context, workspaceID string, providerID uuid.UUID) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM scope_approvals WHERE workspace_id = ? AND provider_id = ?`, workspaceID, providerID)
+	return err
+}
+
+func (s *SQL) GetRefreshFailure(ctx context.Context, connectionID uuid.UUID) (*RefreshFailure, error) {
+	return scanRefreshFailure(s.db.QueryRowContext(ctx, `SELECT `+refreshFailureColumns+`
+		FROM refresh_failures f JOIN connections c ON c.id = f.connection_id
+		WHERE f.connection_id = ?`, connectionID))
+}
+
+func (s *SQL) SaveRefreshFailure(ctx context.Context, f *RefreshFailure) error {
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO refresh_failures (connection_id, error_class, last_error, status_code, attempts, first_failed_at, last_failed_at, next_retry_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`+
+		s.dialect.upsert([]string{"connection_id"}, []string{"error_class", "last_error", "status_code", "attempts",
+			"first_failed_at", "last_failed_at", "next_retry_at"}),
+		f.ConnectionID, f.ErrorClass, f.LastError, f.StatusCode, f.Attempts, f.FirstFailedAt.UTC(), f.LastFailedAt.UTC(),
+		utc(f.NextRetryAt))
+	return err
+}
+
+func (s *SQL) DeleteRefreshFailure(ctx context.Context, connectionID uuid.UUID) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM refresh_failures WHERE connection_id = ?`, connectionID)
+	return err
+}
+
+func (s *SQL) ListRefreshFailures(ctx context.Context, f RefreshFailureFilter) ([]RefreshFailure, error) {
+	query := `SELECT ` + refreshFailureColumns + `
+		FROM refresh_failures f JOIN connections c ON c.id = f.connection_id WHERE 1=1`
+	var args []interface{}
+	if f.WorkspaceID != "" {
+		query += ` AND c.workspace_id = ?`
+		args = append(args, f.WorkspaceID)
+	}
+	if !f.DueBefore.IsZero() {
+		query += ` AND f.next_retry_at <= ? ORDER BY f.next_retry_at, f.connection_id`
+		args = append(args, f.DueBefore.UTC())
+	} else {
+		query += ` ORDER BY f.last_failed_at DESC, f.connection_id`
+	}
+	if f.Limit > 0 {
+		query += ` LIMIT ?`
+		args = append(args, f.Limit)
+	}
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []RefreshFailure
+	for rows.Next() {
+		rf, err := scanRefreshFailure(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, *rf)
+	}
+	return out, rows.Err()
+}
+
+func (s *SQL) CreateGrant(ctx context.Context, g *Grant) error {
+	if g.ID == uuid.Nil {
+		g.ID = uuid.New()
+	}
+	g.CreatedAt = s.now()
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO connection_grants (id, connection_id, workspace_id, scopes, expires_at, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		g.ID, g.ConnectionID, g.WorkspaceID, stringList(g.Scopes), g.ExpiresAt.UTC(), g.CreatedAt)
+	return err
+}
+
+func (s *SQL) RedeemGrant(ctx context.Context, id uuid.UUID, now time.Time) (*Grant, error) {
+	now = now.UTC()
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE connection_grants SET redeemed_at = ?
+		WHERE id = ? AND redeemed_at IS NULL AND expires_at > ?`, now, id, now)
+	if err != nil {
+		return nil, err
+	}
+	redeemedNow, err := res.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+
+	g := Grant{ID: id}
+	err = s.db.QueryRowContext(ctx, `
+		SELECT connection_id, workspace_id, scopes, expires_at, created_at, redeemed_at FROM connection_grants WHERE id = ?`, id).
+		Scan(&g.ConnectionID, &g.WorkspaceID, (*stringList)(&g.Scopes), &g.ExpiresAt, &g.CreatedAt, &g.RedeemedAt)
+	switch {
+	case err == sql.ErrNoRows:
+		return nil, ErrNotFound
+	case err != nil:
+		return nil, err
+	case redeemedNow == 1:
+		return &g, nil
+	case g.RedeemedAt != nil:
+		return nil, ErrGrantRedeemed
+	}
+	return nil, ErrGrantExpired
+}
+
+// ExpirePendingConnections selects the expired connections first, as
+// neither MySQL nor older SQLite can return the rows an UPDATE changed.
+func (s *SQL) ExpirePendingConnections(ctx context.Context) (int64, error) {
+	var n int64
+	err := s.inTx(ctx, func(tx *sqlx.Tx) error {
+		now := s.now()
+		var ids []uuid.UUID
+		if err := tx.SelectContext(ctx, &ids, `SELECT id FROM connections WHERE status = ? AND expires_at <= ?`+s.dialect.forUpdate,
+			connstate.StatePending, now); err != nil {
+			return err
+		}
+		for _, id := range ids {
+			if _, err := tx.ExecContext(ctx, `UPDATE connections SET status = ?, status_changed_at = ?, updated_at = ? WHERE id = ?`,
+				connstate.StateExpired, now, now, id); err != nil {
+				return err
+			}
+			if _, err := tx.ExecContext(ctx,
+				`INSERT INTO connection_state_transitions (connection_id, from_state, to_state, created_at) VALUES (?, ?, ?, ?)`,
+				id, connstate.StatePending, connstate.StateExpired, now); err != nil {
+				return err
+			}
+		}
+		n = int64(len(ids))
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	connstate.Count(connstate.StatePending, connstate.StateExpired, n)
+	return n, nil
+}
+
+func (s *SQL) DeleteOrphanTokens(ctx context.Context) (int64, error) {
+	res, err := s.db.ExecContext(ctx, `
+		DELETE FROM tokens
+		WHERE NOT EXISTS (SELECT 1 FROM connections c WHERE c.id = tokens.connection_id)`)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (s *SQL) DeleteExpiredGrants(ctx context.Context, cutoff time.Time) (int64, error) {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM connection_grants WHERE expires_at < ?`, cutoff.UTC())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,524 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/migrations"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/config"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/connstate"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/migrate"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/vault"
+)
+
+// sqlStores runs fn against a migrated SQLite store in memory, in cgo
+// builds, and against MySQL when NEXUS_TEST_MYSQL_DSN names a database. The
+// MySQL database is shared between tests, so each test uses its own
+// workspaces and IDs.
+func sqlStores(t *testing.T, fn func(t *testing.T, s *SQL)) {
+	t.Run("sqlite", func(t *testing.T) {
+		db, err := OpenSQLite(":memory:")
+		if errors.Is(err, ErrSQLiteUnavailable) {
+			t.Skip(err)
+		}
+		require.NoError(t, err)
+		t.Cleanup(func() { db.Close() })
+		migrateSQL(t, db, migrations.SQLite, migrate.SQLite)
+		fn(t, NewSQLite(db))
+	})
+	t.Run("mysql", func(t *testing.T) {
+		dsn := os.Getenv("NEXUS_TEST_MYSQL_DSN")
+		if dsn == "" {
+			t.Skip("NEXUS_TEST_MYSQL_DSN is not set")
+		}
+		db, err := OpenMySQL(dsn, config.DBPoolConfig{MaxOpenConns: 4})
+		require.NoError(t, err)
+		t.Cleanup(func() { db.Close() })
+		migrateSQL(t, db, migrations.MySQL, migrate.MySQL)
+		fn(t, NewMySQL(db))
+	})
+}
+
+// migrateSQL applies the migrations of a SQL store dialect to db.
+func migrateSQL(t *testing.T, db *sqlx.DB, fsys fs.FS, d migrate.Dialect) {
+	t.Helper()
+	m, err := migrate.NewForDialect(db.DB, fsys, d)
+	require.NoError(t, err)
+	_, err = m.Up(context.Background())
+	require.NoError(t, err)
+}
+
+func newSQLConnection(t *testing.T, s *SQL, workspaceID string) *Connection {
+	t.Helper()
+	c := &Connection{
+		WorkspaceID:  workspaceID,
+		ProviderID:   uuid.New(),
+		CodeVerifier: "verifier",
+		Scopes:       []string{"email", "profile"},
+		ReturnURL:    "https://app.example.com/done",
+		ExpiresAt:    time.Now().Add(time.Minute),
+	}
+	require.NoError(t, s.CreateConnection(context.Background(), c))
+	return c
+}
+
+func TestSQL_ConnectionLifecycle(t *testing.T) {
+	sqlStores(t, func(t *testing.T, s *SQL) {
+		ctx := context.Background()
+		ws := "ws-" + uuid.NewString()
+		c := newSQLConnection(t, s, ws)
+
+		got, err := s.GetPendingConnection(ctx, c.ID)
+		require.NoError(t, err)
+		assert.Equal(t, connstate.StatePending, got.Status)
+		assert.Equal(t, []string{"email", "profile"}, got.Scopes)
+		assert.Equal(t, "verifier", got.CodeVerifier)
+
+		pending, active, err := s.CountWorkspaceConnections(ctx, ws)
+		require.NoError(t, err)
+		assert.Equal(t, [2]int{1, 0}, [2]int{pending, active})
+
+		require.NoError(t, s.SetIdentity(ctx, c.ID, "sub-1", "User@Example.com"))
+		require.NoError(t, s.SetTenant(ctx, c.ID, "tenant-1"))
+		require.NoError(t, s.UpdateStatus(ctx, c.ID, connstate.StateActive))
+		_, err = s.GetPendingConnection(ctx, c.ID)
+		assert.ErrorIs(t, err, ErrNotFound)
+
+		got, err = s.GetConnection(ctx, c.ID)
+		require.NoError(t, err)
+		assert.Equal(t, connstate.StateActive, got.Status)
+		assert.Equal(t, "tenant-1", got.Tenant)
+
+		byEmail, err := s.ListUserConnections(ctx, ws, "user@example.com")
+		require.NoError(t, err)
+		require.Len(t, byEmail, 1)
+		assert.Equal(t, c.ID, byEmail[0].ID)
+		bySubject, err := s.ListUserConnections(ctx, ws, "sub-1")
+		require.NoError(t, err)
+		assert.Len(t, bySubject, 1)
+
+		pending, active, err = s.CountWorkspaceConnections(ctx, ws)
+		require.NoError(t, err)
+		assert.Equal(t, [2]int{0, 1}, [2]int{pending, active})
+
+		require.NoError(t, s.UpdateStatus(ctx, c.ID, connstate.StateRevoked))
+		var te *connstate.TransitionError
+		assert.ErrorAs(t, s.UpdateStatus(ctx, c.ID, connstate.StateActive), &te)
+		assert.ErrorIs(t, s.UpdateStatus(ctx, uuid.New(), connstate.StateActive), connstate.ErrNotFound)
+		_, err = s.GetConnection(ctx, uuid.New())
+		assert.ErrorIs(t, err, ErrNotFound)
+	})
+}
+
+func TestSQL_FailConnection(t *testing.T) {
+	sqlStores(t, func(t *testing.T, s *SQL) {
+		ctx := context.Background()
+		c := newSQLConnection(t, s, "ws-"+uuid.NewString())
+
+		code, err := s.GetFailureCode(ctx, c.ID)
+		require.NoError(t, err)
+		assert.Empty(t, code)
+
+		require.NoError(t, s.FailConnection(ctx, c.ID, "consent_denied"))
+		code, err = s.GetFailureCode(ctx, c.ID)
+		require.NoError(t, err)
+		assert.Equal(t, "consent_denied", code)
+		got, err := s.GetConnection(ctx, c.ID)
+		require.NoError(t, err)
+		assert.Equal(t, connstate.StateFailed, got.Status)
+
+		_, err = s.GetFailureCode(ctx, uuid.New())
+		assert.ErrorIs(t, err, ErrNotFound)
+	})
+}
+
+func TestSQL_Reauthorization(t *testing.T) {
+	sqlStores(t, func(t *testing.T, s *SQL) {
+		ctx := context.Background()
+		c := newSQLConnection(t, s, "ws-"+uuid.NewString())
+
+		r := Reauthorization{CodeVerifier: "v2", Scopes: []string{"email", "drive"}, ReturnURL: "https://app.example.com/again",
+			ExpiresAt: time.Now().Add(time.Minute)}
+		assert.ErrorIs(t, s.StartReauthorization(ctx, c.ID, r), ErrNotFound, "pending connections are not reauthorized")
+
+		require.NoError(t, s.UpdateStatus(ctx, c.ID, connstate.StateActive))
+		require.NoError(t, s.StartReauthorization(ctx, c.ID, r))
+		got, err := s.GetPendingReauthorization(ctx, c.ID)
+		require.NoError(t, err)
+		assert.Equal(t, "v2", got.CodeVerifier)
+		assert.Equal(t, r.Scopes, got.Scopes)
+		assert.Equal(t, r.ReturnURL, got.ReturnURL)
+
+		require.NoError(t, s.CompleteReauthorization(ctx, c.ID, r.Scopes, "sealed", nil))
+		got, err = s.GetConnection(ctx, c.ID)
+		require.NoError(t, err)
+		assert.Equal(t, r.Scopes, got.Scopes)
+		tok, err := s.GetTokens(ctx, c.ID)
+		require.NoError(t, err)
+		assert.Equal(t, "sealed", tok.EncryptedData)
+
+		_, err = s.GetPendingReauthorization(ctx, c.ID)
+		assert.ErrorIs(t, err, ErrNotFound)
+		assert.ErrorIs(t, s.CompleteReauthorization(ctx, c.ID, r.Scopes, "sealed", nil), ErrNotFound)
+
+		require.NoError(t, s.StartReauthorization(ctx, c.ID, r))
+		require.NoError(t, s.CancelReauthorization(ctx, c.ID))
+		_, err = s.GetPendingReauthorization(ctx, c.ID)
+		assert.ErrorIs(t, err, ErrNotFound)
+	})
+}
+
+func TestSQL_UsageAndStaleConnections(t *testing.T) {
+	sqlStores(t, func(t *testing.T, s *SQL) {
+		ctx := context.Background()
+		ws := "ws-" + uuid.NewString()
+		used := newSQLConnection(t, s, ws)
+		idle := newSQLConnection(t, s, ws)
+		for _, c := range []*Connection{used, idle} {
+			require.NoError(t, s.UpdateStatus(ctx, c.ID, connstate.StateActive))
+		}
+
+		at := time.Now().Add(time.Hour).Truncate(time.Millisecond)
+		require.NoError(t, s.RecordTokenUsage(ctx, []TokenUsage{{ConnectionID: used.ID, Retrievals: 2, LastAccessedAt: at}}))
+		require.NoError(t, s.RecordTokenUsage(ctx, []TokenUsage{{ConnectionID: used.ID, Retrievals: 3, LastAccessedAt: at.Add(-time.Minute)}}))
+		got, err := s.GetConnection(ctx, used.ID)
+		require.NoError(t, err)
+		assert.EqualValues(t, 5, got.TokenRetrievals)
+		require.NotNil(t, got.LastAccessedAt)
+		assert.True(t, got.LastAccessedAt.Equal(at), "an older retrieval keeps the latest time, got %v", got.LastAccessedAt)
+
+		stale, err := s.ListStaleConnections(ctx, StaleFilter{UnusedSince: time.Now().Add(time.Minute), WorkspaceID: ws})
+		require.NoError(t, err)
+		require.Len(t, stale, 1)
+		assert.Equal(t, idle.ID, stale[0].ID)
+	})
+}
+
+func TestSQL_AwaitingApproval(t *testing.T) {
+	sqlStores(t, func(t *testing.T, s *SQL) {
+		ctx := context.Background()
+		ws := "ws-" + uuid.NewString()
+		first := newSQLConnection(t, s, ws)
+		second := newSQLConnection(t, s, ws)
+		newSQLConnection(t, s, ws)
+		for _, c := range []*Connection{first, second} {
+			require.NoError(t, s.UpdateStatus(ctx, c.ID, connstate.StateAwaitingApproval))
+		}
+
+		queue, err := s.ListAwaitingApproval(ctx, ApprovalFilter{WorkspaceID: ws})
+		require.NoError(t, err)
+		assert.Len(t, queue, 2)
+		queue, err = s.ListAwaitingApproval(ctx, ApprovalFilter{WorkspaceID: ws, Limit: 1})
+		require.NoError(t, err)
+		assert.Len(t, queue, 1)
+	})
+}
+
+func TestSQL_SuspendAndResume(t *testing.T) {
+	sqlStores(t, func(t *testing.T, s *SQL) {
+		ctx := context.Background()
+		c := newSQLConnection(t, s, "ws-"+uuid.NewString())
+		require.NoError(t, s.UpdateStatus(ctx, c.ID, connstate.StateActive))
+
+		var te *connstate.TransitionError
+		assert.ErrorAs(t, s.ResumeConnection(ctx, c.ID), &te, "only suspended connections resume")
+
+		require.NoError(t, s.SuspendConnection(ctx, &Suspension{ConnectionID: c.ID, Reason: "abuse", SuspendedBy: "admin",
+			SuspendedAt: time.Now()}))
+		sp, err := s.GetSuspension(ctx, c.ID)
+		require.NoError(t, err)
+		assert.Equal(t, "abuse", sp.Reason)
+		assert.Equal(t, "admin", sp.SuspendedBy)
+
+		require.NoError(t, s.ResumeConnection(ctx, c.ID))
+		got, err := s.GetConnection(ctx, c.ID)
+		require.NoError(t, err)
+		assert.Equal(t, connstate.StateActive, got.Status)
+		_, err = s.GetSuspension(ctx, c.ID)
+		assert.ErrorIs(t, err, ErrNotFound)
+		assert.ErrorIs(t, s.ResumeConnection(ctx, uuid.New()), connstate.ErrNotFound)
+	})
+}
+
+func TestSQL_Providers(t *testing.T) {
+	sqlStores(t, func(t *testing.T, s *SQL) {
+		ctx := context.Background()
+		key := make([]byte, 32)
+		s.WithSecretKey(key)
+		sealed, err := vault.SealSecret(key, "client-secret", "client_secret")
+		require.NoError(t, err)
+		params := json.RawMessage(`{"token_max_retries":0}`)
+
+		global := &Provider{Name: "global", AuthType: "oauth2", ClientID: "id", ClientSecret: sealed,
+			Scopes: []string{"email"}, Params: &params}
+		require.NoError(t, s.SaveProvider(ctx, global))
+		private := &Provider{Name: "private", AuthType: "oauth2"}
+		require.NoError(t, s.SaveProvider(ctx, private, "ws-1"))
+
+		got, err := s.GetProvider(ctx, global.ID)
+		require.NoError(t, err)
+		assert.Equal(t, "client-secret", got.ClientSecret)
+		assert.Equal(t, []string{"email"}, got.Scopes)
+		require.NotNil(t, got.Params)
+		assert.JSONEq(t, string(params), string(*got.Params))
+
+		_, err = s.GetProviderForWorkspace(ctx, global.ID, "ws-2")
+		assert.NoError(t, err)
+		_, err = s.GetProviderForWorkspace(ctx, private.ID, "ws-1")
+		assert.NoError(t, err)
+		_, err = s.GetProviderForWorkspace(ctx, private.ID, "ws-2")
+		assert.ErrorIs(t, err, ErrNotFound)
+		_, err = s.GetProvider(ctx, uuid.New())
+		assert.ErrorIs(t, err, ErrNotFound)
+
+		require.NoError(t, s.SetTokenEndpointPreference(ctx, global.ID, "discovered"))
+		got, err = s.GetProvider(ctx, global.ID)
+		require.NoError(t, err)
+		assert.Equal(t, "discovered", got.TokenEndpointPreference)
+	})
+}
+
+func TestSQL_Tokens(t *testing.T) {
+	sqlStores(t, func(t *testing.T, s *SQL) {
+		ctx := context.Background()
+		ws := "ws-" + uuid.NewString()
+		c := newSQLConnection(t, s, ws)
+
+		_, err := s.GetTokens(ctx, c.ID)
+		assert.ErrorIs(t, err, ErrNotFound)
+
+		exp := time.Now().Add(time.Hour)
+		require.NoError(t, s.SaveTokens(ctx, c.ID, "first", nil))
+		require.NoError(t, s.SaveTokens(ctx, c.ID, "second", &exp))
+		tok, err := s.GetTokens(ctx, c.ID)
+		require.NoError(t, err)
+		assert.Equal(t, "second", tok.EncryptedData)
+		assert.Equal(t, ws, tok.WorkspaceID)
+		require.NotNil(t, tok.ExpiresAt)
+		assert.WithinDuration(t, exp, *tok.ExpiresAt, time.Millisecond)
+
+		require.NoError(t, s.DeleteTokens(ctx, c.ID))
+		_, err = s.GetTokens(ctx, c.ID)
+		assert.ErrorIs(t, err, ErrNotFound)
+	})
+}
+
+func TestSQL_RefreshTokenGenerations(t *testing.T) {
+	sqlStores(t, func(t *testing.T, s *SQL) {
+		ctx := context.Background()
+		c := newSQLConnection(t, s, "ws-"+uuid.NewString())
+
+		first, err := s.RecordRefreshToken(ctx, c.ID, "hash-1")
+		require.NoError(t, err)
+		assert.Equal(t, 1, first.Generation)
+		again, err := s.RecordRefreshToken(ctx, c.ID, "hash-1")
+		require.NoError(t, err)
+		assert.Equal(t, 1, again.Generation, "recording the current token again is a no-op")
+		second, err := s.RecordRefreshToken(ctx, c.ID, "hash-2")
+		require.NoError(t, err)
+		assert.Equal(t, 2, second.Generation)
+
+		old, err := s.FindRefreshToken(ctx, c.ID, "hash-1")
+		require.NoError(t, err)
+		assert.NotNil(t, old.RotatedAt)
+		current, err := s.FindRefreshToken(ctx, c.ID, "hash-2")
+		require.NoError(t, err)
+		assert.Nil(t, current.RotatedAt)
+		_, err = s.FindRefreshToken(ctx, c.ID, "unknown")
+		assert.ErrorIs(t, err, ErrNotFound)
+	})
+}
+
+func TestSQL_AuditEvents(t *testing.T) {
+	sqlStores(t, func(t *testing.T, s *SQL) {
+		ctx := context.Background()
+		conn := uuid.New()
+		data := `{"ok":true}`
+		for _, typ := range []string{"connection_created", "token_retrieved", "token_retrieved"} {
+			e := &AuditEvent{ConnectionID: &conn, EventType: typ, EventData: &data}
+			require.NoError(t, s.CreateAuditEvent(ctx, e))
+			assert.NotEqual(t, uuid.Nil, e.ID)
+			assert.False(t, e.CreatedAt.IsZero())
+		}
+
+		events, err := s.ListAuditEvents(ctx, AuditFilter{ConnectionID: conn, EventType: "token_retrieved", Limit: 10})
+		require.NoError(t, err)
+		assert.Len(t, events, 2)
+		events, err = s.ListAuditEvents(ctx, AuditFilter{ConnectionID: conn, Limit: 1, Offset: 1})
+		require.NoError(t, err)
+		require.Len(t, events, 1)
+		assert.Equal(t, data, *events[0].EventData)
+		events, err = s.ListAuditEvents(ctx, AuditFilter{ConnectionID: conn, Since: time.Now().Add(time.Minute), Limit: 10})
+		require.NoError(t, err)
+		assert.Empty(t, events)
+
+		var streamed []string
+		require.NoError(t, s.StreamAuditEvents(ctx, AuditFilter{ConnectionID: conn}, func(e *AuditEvent) error {
+			streamed = append(streamed, e.EventType)
+			return nil
+		}))
+		assert.Equal(t, []string{"connection_created", "token_retrieved", "token_retrieved"}, streamed)
+	})
+}
+
+func TestSQL_WorkspaceLimitsAndScopeApprovals(t *testing.T) {
+	sqlStores(t, func(t *testing.T, s *SQL) {
+		ctx := context.Background()
+		ws := "ws-" + uuid.NewString()
+
+		_, err := s.GetWorkspaceLimits(ctx, ws)
+		assert.ErrorIs(t, err, ErrNotFound)
+		one, two := 1, 2
+		require.NoError(t, s.SetWorkspaceLimits(ctx, &WorkspaceLimits{WorkspaceID: ws, MaxPendingConnections: &one}))
+		require.NoError(t, s.SetWorkspaceLimits(ctx, &WorkspaceLimits{WorkspaceID: ws, MaxActiveConnections: &two}))
+		l, err := s.GetWorkspaceLimits(ctx, ws)
+		require.NoError(t, err)
+		assert.Nil(t, l.MaxPendingConnections)
+		require.NotNil(t, l.MaxActiveConnections)
+		assert.Equal(t, 2, *l.MaxActiveConnections)
+		require.NoError(t, s.DeleteWorkspaceLimits(ctx, ws))
+		_, err = s.GetWorkspaceLimits(ctx, ws)
+		assert.ErrorIs(t, err, ErrNotFound)
+
+		providerID := uuid.New()
+		_, err = s.GetScopeApprovals(ctx, ws, providerID)
+		assert.ErrorIs(t, err, ErrNotFound)
+		require.NoError(t, s.SetScopeApprovals(ctx, &ScopeApprovals{WorkspaceID: ws, ProviderID: providerID, Scopes: []string{"email"}}))
+		require.NoError(t, s.SetScopeApprovals(ctx, &ScopeApprovals{WorkspaceID: ws, ProviderID: providerID, Scopes: []string{"email", "drive"}}))
+		a, err := s.GetScopeApprovals(ctx, ws, providerID)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"email", "drive"}, a.Scopes)
+		require.NoError(t, s.DeleteScopeApprovals(ctx, ws, providerID))
+		_, err = s.GetScopeApprovals(ctx, ws, providerID)
+		assert.ErrorIs(t, err, ErrNotFound)
+	})
+}
+
+func TestSQL_RefreshFailures(t *testing.T) {
+	sqlStores(t, func(t *testing.T, s *SQL) {
+		ctx := context.Background()
+		ws := "ws-" + uuid.NewString()
+		due := newSQLConnection(t, s, ws)
+		later := newSQLConnection(t, s, ws)
+		now := time.Now()
+
+		soon, tomorrow := now.Add(-time.Second), now.Add(24*time.Hour)
+		for c, next := range map[*Connection]*time.Time{due: &soon, later: &tomorrow} {
+			require.NoError(t, s.SaveRefreshFailure(ctx, &RefreshFailure{ConnectionID: c.ID, ErrorClass: "network",
+				LastError: "timeout", Attempts: 1, FirstFailedAt: now, LastFailedAt: now, NextRetryAt: next}))
+		}
+		require.NoError(t, s.SaveRefreshFailure(ctx, &RefreshFailure{ConnectionID: due.ID, ErrorClass: "server",
+			LastError: "502", StatusCode: 502, Attempts: 2, FirstFailedAt: now, LastFailedAt: now, NextRetryAt: &soon}))
+
+		f, err := s.GetRefreshFailure(ctx, due.ID)
+		require.NoError(t, err)
+		assert.Equal(t, 2, f.Attempts)
+		assert.Equal(t, 502, f.StatusCode)
+		assert.Equal(t, ws, f.WorkspaceID)
+		assert.Equal(t, due.ProviderID, f.ProviderID)
+
+		all, err := s.ListRefreshFailures(ctx, RefreshFailureFilter{WorkspaceID: ws})
+		require.NoError(t, err)
+		assert.Len(t, all, 2)
+		dueNow, err := s.ListRefreshFailures(ctx, RefreshFailureFilter{WorkspaceID: ws, DueBefore: now})
+		require.NoError(t, err)
+		require.Len(t, dueNow, 1)
+		assert.Equal(t, due.ID, dueNow[0].ConnectionID)
+
+		require.NoError(t, s.DeleteRefreshFailure(ctx, due.ID))
+		_, err = s.GetRefreshFailure(ctx, due.ID)
+		assert.ErrorIs(t, err, ErrNotFound)
+	})
+}
+
+func TestSQL_Grants(t *testing.T) {
+	sqlStores(t, func(t *testing.T, s *SQL) {
+		ctx := context.Background()
+		c := newSQLConnection(t, s, "ws-"+uuid.NewString())
+		now := time.Now()
+
+		g := &Grant{ConnectionID: c.ID, WorkspaceID: c.WorkspaceID, Scopes: []string{"email"}, ExpiresAt: now.Add(time.Minute)}
+		require.NoError(t, s.CreateGrant(ctx, g))
+		redeemed, err := s.RedeemGrant(ctx, g.ID, now)
+		require.NoError(t, err)
+		assert.Equal(t, c.ID, redeemed.ConnectionID)
+		assert.Equal(t, []string{"email"}, redeemed.Scopes)
+		assert.NotNil(t, redeemed.RedeemedAt)
+		_, err = s.RedeemGrant(ctx, g.ID, now)
+		assert.ErrorIs(t, err, ErrGrantRedeemed)
+
+		expired := &Grant{ConnectionID: c.ID, WorkspaceID: c.WorkspaceID, ExpiresAt: now.Add(-time.Minute)}
+		require.NoError(t, s.CreateGrant(ctx, expired))
+		_, err = s.RedeemGrant(ctx, expired.ID, now)
+		assert.ErrorIs(t, err, ErrGrantExpired)
+		_, err = s.RedeemGrant(ctx, uuid.New(), now)
+		assert.ErrorIs(t, err, ErrNotFound)
+	})
+}
+
+func TestSQL_Sweeps(t *testing.T) {
+	sqlStores(t, func(t *testing.T, s *SQL) {
+		ctx := context.Background()
+		ws := "ws-" + uuid.NewString()
+		pending := newSQLConnection(t, s, ws)
+		expired := &Connection{WorkspaceID: ws, ProviderID: uuid.New(), ReturnURL: "https://app.example.com/done", ExpiresAt: time.Now().Add(-time.Minute)}
+		require.NoError(t, s.CreateConnection(ctx, expired))
+
+		n, err := s.ExpirePendingConnections(ctx)
+		require.NoError(t, err)
+		assert.GreaterOrEqual(t, n, int64(1))
+		got, err := s.GetConnection(ctx, expired.ID)
+		require.NoError(t, err)
+		assert.Equal(t, connstate.StateExpired, got.Status)
+		got, err = s.GetConnection(ctx, pending.ID)
+		require.NoError(t, err)
+		assert.Equal(t, connstate.StatePending, got.Status)
+
+		g := &Grant{ConnectionID: pending.ID, WorkspaceID: ws, ExpiresAt: time.Now().Add(-48 * time.Hour)}
+		require.NoError(t, s.CreateGrant(ctx, g))
+		_, err = s.DeleteExpiredGrants(ctx, time.Now().Add(-24*time.Hour))
+		require.NoError(t, err)
+		_, err = s.RedeemGrant(ctx, g.ID, time.Now())
+		assert.ErrorIs(t, err, ErrNotFound)
+
+		_, err = s.DeleteOrphanTokens(ctx)
+		assert.NoError(t, err)
+	})
+}
+
+func newMockMySQL(t *testing.T) (*SQL, sqlmock.Sqlmock) {
+	t.Helper()
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	return NewMySQL(sqlx.NewDb(db, "sqlmock")), mock
+}
+
+func TestMySQL_LocksAndUpserts(t *testing.T) {
+	s, mock := newMockMySQL(t)
+	id := uuid.New()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT status FROM connections WHERE id = \? FOR UPDATE`).WithArgs(id).
+		WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow("pending"))
+	mock.ExpectExec(`UPDATE connections SET status = \?`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO connection_state_transitions`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	require.NoError(t, s.UpdateStatus(context.Background(), id, connstate.StateActive))
+
+	mock.ExpectExec(`INSERT INTO tokens .* ON DUPLICATE KEY UPDATE encrypted_data = VALUES\(encrypted_data\)`).
+		WithArgs(id, "ct", nil, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+	require.NoError(t, s.SaveTokens(context.Background(), id, "ct", nil))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,25 @@
+//go:build cgo
+
+package store
+
+import (
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// OpenSQLite opens the SQLite database at path for NewSQLite, creating it
+// if needed; ":memory:" opens a private in-memory database. SQLite allows
+// one writer at a time, so the pool holds a single connection and
+// transactions take the write lock when they begin.
+func OpenSQLite(path string) (*sqlx.DB, error) {
+	dsn := "file:" + path + "?_busy_timeout=5000&_txlock=immediate&_foreign_keys=on"
+	db, err := sqlx.Connect("sqlite3", dsn)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1)
+	// Keep the connection: closing it would drop an in-memory database.
+	db.SetConnMaxLifetime(0)
+	db.SetConnMaxIdleTime(0)
+	return db, nil
+}
@@ -0,0 +1,10 @@
+//go:build !cgo
+
+package store
+
+import "github.com/jmoiron/sqlx"
+
+// OpenSQLite returns ErrSQLiteUnavailable: the SQLite driver needs cgo.
+func OpenSQLite(path string) (*sqlx.DB, error) {
+	return nil, ErrSQLiteUnavailable
+}
@@ -0,0 +1,426 @@
+// Package store is the persistence layer behind the broker's HTTP handlers.
+// Handlers depend on the interfaces here rather than on SQL, so they can be
+// unit tested against Memory and backed by another database later.
+//
+// Postgres is the production implementation.
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/connstate"
+)
+
+// ErrNotFound is returned when a requested row does not exist.
+var ErrNotFound = errors.New("not found")
+
+// Errors returned by RedeemGrant for a grant that can no longer be
+// redeemed.
+var (
+	ErrGrantRedeemed = errors.New("grant already redeemed")
+	ErrGrantExpired  = errors.New("grant expired")
+)
+
+type readPrimaryKey struct{}
+
+// ReadPrimary marks ctx so lookups skip any read replica. Use it where a
+// lagging replica would give a wrong answer, e.g. re-reading a row another
+// replica has just written, or deciding whether to release a token.
+func ReadPrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, readPrimaryKey{}, true)
+}
+
+func readsPrimary(ctx context.Context) bool {
+	v, _ := ctx.Value(readPrimaryKey{}).(bool)
+	return v
+}
+
+// Connection is a row of the connections table.
+type Connection struct {
+	ID           uuid.UUID
+	WorkspaceID  string
+	ProviderID   uuid.UUID
+	Status       connstate.State
+	CodeVerifier string
+	Scopes       []string
+	ReturnURL    string
+	ExpiresAt    time.Time
+	// Subject and Email identify the user who authorised the connection,
+	// taken from the verified id_token. Empty for non-OIDC connections.
+	Subject string
+	Email   string
+	// Tenant is the Azure AD tenant the connection authorizes against:
+	// the one requested at consent, such as "organizations", until the
+	// id_token resolves it to the user's tenant ID. Empty for other
+	// providers and when the provider's configured tenant is used.
+	Tenant string
+	// CreatedAt is when consent was started.
+	CreatedAt time.Time
+	// TokenRetrievals counts successful token retrievals and
+	// LastAccessedAt is the latest one, nil if the token was never
+	// retrieved. Both lag by up to the usage flush interval.
+	TokenRetrievals int64
+	LastAccessedAt  *time.Time
+}
+
+// ApprovalFilter selects connections for ListAwaitingApproval.
+type ApprovalFilter struct {
+	// WorkspaceID restricts the queue to one workspace when set.
+	WorkspaceID string
+	// Limit caps the number of connections returned; 0 means no limit.
+	Limit int
+}
+
+// TokenUsage is a batch of token retrievals of one connection, added to its
+// usage counters by RecordTokenUsage.
+type TokenUsage struct {
+	ConnectionID uuid.UUID
+	Retrievals   int64
+	// LastAccessedAt is the latest retrieval in the batch.
+	LastAccessedAt time.Time
+}
+
+// StaleFilter selects connections for ListStaleConnections.
+type StaleFilter struct {
+	// UnusedSince is the cutoff: connections whose token was last retrieved
+	// before it, or never retrieved and created before it, are stale.
+	UnusedSince time.Time
+	// WorkspaceID restricts the report to one workspace when set.
+	WorkspaceID string
+	// Limit caps the number of connections returned; 0 means no limit.
+	Limit int
+}
+
+// WorkspaceLimits are a workspace's overrides of the configured quotas. A
+// nil field keeps the configured default; zero means unlimited.
+type WorkspaceLimits struct {
+	WorkspaceID           string
+	MaxPendingConnections *int
+	MaxActiveConnections  *int
+	MaxRefreshesPerMinute *int
+	UpdatedAt             time.Time
+}
+
+// ScopeApprovals are the scopes an administrator approved for a
+// workspace's consents to a provider whose scope policy requires approval.
+type ScopeApprovals struct {
+	WorkspaceID string
+	ProviderID  uuid.UUID
+	// Scopes are scopes or patterns, as in a scope policy.
+	Scopes    []string
+	UpdatedAt time.Time
+}
+
+// RefreshFailure is a connection whose last refresh failed, kept until a
+// refresh succeeds.
+type RefreshFailure struct {
+	ConnectionID uuid.UUID
+	// WorkspaceID and ProviderID are the connection's; SaveRefreshFailure
+	// ignores them.
+	WorkspaceID string
+	ProviderID  uuid.UUID
+	// ErrorClass says what kind of failure the last one was, e.g. network
+	// or invalid_grant.
+	ErrorClass string
+	LastError  string
+	// StatusCode is the provider's HTTP status, 0 if it did not answer.
+	StatusCode    int
+	Attempts      int
+	FirstFailedAt time.Time
+	LastFailedAt  time.Time
+	// NextRetryAt is when the refresh is retried automatically; nil once
+	// retries were given up.
+	NextRetryAt *time.Time
+}
+
+// RefreshFailureFilter selects failures for ListRefreshFailures.
+type RefreshFailureFilter struct {
+	// DueBefore selects only failures to retry at or before it, oldest
+	// first. Otherwise the latest failures come first.
+	DueBefore time.Time
+	// WorkspaceID restricts the list to one workspace when set.
+	WorkspaceID string
+	// Limit caps the number of failures returned; 0 means no limit.
+	Limit int
+}
+
+// Reauthorization is a consent in progress for an existing connection,
+// started by POST /connections/{id}/reauthorize.
+type Reauthorization struct {
+	CodeVerifier string
+	// Scopes are all the scopes requested, including the connection's
+	// current ones.
+	Scopes    []string
+	ReturnURL string
+	ExpiresAt time.Time
+}
+
+// Suspension records why an operator suspended a connection.
+type Suspension struct {
+	ConnectionID uuid.UUID
+	Reason       string
+	// SuspendedBy is the API caller that suspended it, empty if unknown.
+	SuspendedBy string
+	SuspendedAt time.Time
+}
+
+// Grant is a short-lived, single-use grant to a connection's access token,
+// issued by POST /connections/{id}/grants.
+type Grant struct {
+	ID           uuid.UUID
+	ConnectionID uuid.UUID
+	WorkspaceID  string
+	// Scopes are the connection's scopes the grant is limited to.
+	Scopes     []string
+	ExpiresAt  time.Time
+	CreatedAt  time.Time
+	RedeemedAt *time.Time
+}
+
+// Provider is the part of a provider profile the consent, callback and
+// token flows read.
+type Provider struct {
+	ID               uuid.UUID
+	Name             string
+	AuthType         string
+	AuthHeader       string
+	AuthURL          string
+	TokenURL         string
+	ClientID         string
+	ClientSecret     string
+	APIBaseURL       string
+	UserInfoEndpoint string
+	Scopes           []string
+	Params           *json.RawMessage
+	// TokenEndpointPreference is "discovered", "configured" or empty.
+	TokenEndpointPreference string
+	CABundle                string
+}
+
+// Token is a connection's encrypted token together with the workspace it
+// is bound to (see vault.OpenToken).
+type Token struct {
+	EncryptedData string
+	ExpiresAt     *time.Time
+	WorkspaceID   string
+}
+
+// RefreshGeneration is one refresh token a connection has held, identified
+// by an HMAC of the token. RotatedAt is set once a newer generation exists.
+type RefreshGeneration struct {
+	Generation int
+	TokenHash  string
+	CreatedAt  time.Time
+	RotatedAt  *time.Time
+}
+
+// AuditEvent is a row of the audit_events table.
+type AuditEvent struct {
+	ID           uuid.UUID  `db:"id" json:"id"`
+	ConnectionID *uuid.UUID `db:"connection_id" json:"connection_id,omitempty"`
+	EventType    string     `db:"event_type" json:"event_type"`
+	EventData    *string    `db:"event_data" json:"event_data,omitempty"`
+	IPAddress    *string    `db:"ip_address" json:"ip_address,omitempty"`
+	UserAgent    *string    `db:"user_agent" json:"user_agent,omitempty"`
+	CreatedAt    time.Time  `db:"created_at" json:"created_at"`
+}
+
+// AuditFilter narrows ListAuditEvents. Zero fields do not filter; events are
+// returned newest first, at most Limit of them after skipping Offset.
+type AuditFilter struct {
+	EventType    string
+	ConnectionID uuid.UUID
+	Since        time.Time
+	// Until is exclusive.
+	Until  time.Time
+	Limit  int
+	Offset int
+}
+
+// ConnectionStore persists connections.
+type ConnectionStore interface {
+	// CreateConnection inserts c as pending. A zero c.ID is replaced with a
+	// new UUID.
+	CreateConnection(ctx context.Context, c *Connection) error
+	GetConnection(ctx context.Context, id uuid.UUID) (*Connection, error)
+	// GetPendingConnection returns ErrNotFound unless the connection is
+	// pending and has not expired.
+	GetPendingConnection(ctx context.Context, id uuid.UUID) (*Connection, error)
+	// UpdateStatus moves a connection through the state machine. It returns
+	// connstate.ErrNotFound or a *connstate.TransitionError.
+	UpdateStatus(ctx context.Context, id uuid.UUID, to connstate.State) error
+	// FailConnection moves a connection to failed and records code, the
+	// reason its consent failed. It returns what UpdateStatus returns.
+	FailConnection(ctx context.Context, id uuid.UUID, code string) error
+	// GetFailureCode returns the code FailConnection recorded, or "" when
+	// there is none.
+	GetFailureCode(ctx context.Context, id uuid.UUID) (string, error)
+	// SetIdentity records the user who authorised the connection.
+	SetIdentity(ctx context.Context, id uuid.UUID, subject, email string) error
+	// SetTenant records the Azure AD tenant the connection resolved to.
+	SetTenant(ctx context.Context, id uuid.UUID, tenant string) error
+	// ListUserConnections returns the workspace's connections whose subject
+	// equals user or whose email matches it case-insensitively.
+	ListUserConnections(ctx context.Context, workspaceID, user string) ([]Connection, error)
+
+	// StartReauthorization records a re-consent of an active or
+	// needs_reauth connection, replacing any earlier one. It returns
+	// ErrNotFound if the connection is in another status.
+	StartReauthorization(ctx context.Context, id uuid.UUID, r Reauthorization) error
+	// GetPendingReauthorization returns the connection with the code
+	// verifier, scopes, return URL and expiry of its re-consent in place of
+	// its own, or ErrNotFound if none is in progress or it has expired.
+	GetPendingReauthorization(ctx context.Context, id uuid.UUID) (*Connection, error)
+	// CompleteReauthorization ends the connection's re-consent in one
+	// transaction: the token is replaced, the scopes set and the status made
+	// active. It returns ErrNotFound if no unexpired re-consent is in
+	// progress, or a *connstate.TransitionError.
+	CompleteReauthorization(ctx context.Context, id uuid.UUID, scopes []string, encryptedData string, expiresAt *time.Time) error
+	// CancelReauthorization discards the connection's re-consent, if any,
+	// leaving the connection as it was.
+	CancelReauthorization(ctx context.Context, id uuid.UUID) error
+	// RecordTokenUsage adds each batch to its connection's retrieval count
+	// and moves last_accessed_at forward to the batch's time. Unknown
+	// connections are skipped.
+	RecordTokenUsage(ctx context.Context, usage []TokenUsage) error
+	// ListStaleConnections returns active and needs_reauth connections
+	// unused since f.UnusedSince, least recently used first.
+	ListStaleConnections(ctx context.Context, f StaleFilter) ([]Connection, error)
+	// ListAwaitingApproval returns awaiting_approval connections, oldest
+	// first.
+	ListAwaitingApproval(ctx context.Context, f ApprovalFilter) ([]Connection, error)
+
+	// SuspendConnection moves the connection to suspended and records s in
+	// one transaction. It returns connstate.ErrNotFound or a
+	// *connstate.TransitionError.
+	SuspendConnection(ctx context.Context, s *Suspension) error
+	// ResumeConnection moves a suspended connection back to active and
+	// deletes its suspension. It returns connstate.ErrNotFound, or a
+	// *connstate.TransitionError if the connection is not suspended.
+	ResumeConnection(ctx context.Context, id uuid.UUID) error
+	// GetSuspension returns ErrNotFound unless the connection is suspended.
+	GetSuspension(ctx context.Context, id uuid.UUID) (*Suspension, error)
+}
+
+// ProviderStore reads provider profiles for the auth flows. Profile
+// management lives in pkg/provider.
+type ProviderStore interface {
+	GetProvider(ctx context.Context, id uuid.UUID) (*Provider, error)
+	// GetProviderForWorkspace returns ErrNotFound if the provider is
+	// restricted to other workspaces.
+	GetProviderForWorkspace(ctx context.Context, id uuid.UUID, workspaceID string) (*Provider, error)
+	SetTokenEndpointPreference(ctx context.Context, id uuid.UUID, source string) error
+}
+
+// TokenStore persists the single encrypted token row kept per connection.
+type TokenStore interface {
+	// SaveTokens replaces the connection's token, creating it if needed.
+	SaveTokens(ctx context.Context, connectionID uuid.UUID, encryptedData string, expiresAt *time.Time) error
+	// GetTokens returns ErrNotFound if the connection has no token.
+	GetTokens(ctx context.Context, connectionID uuid.UUID) (*Token, error)
+	// DeleteTokens removes the connection's token, if any.
+	DeleteTokens(ctx context.Context, connectionID uuid.UUID) error
+}
+
+// RefreshTokenStore tracks the generations of each connection's refresh
+// token, so reuse of a rotated token can be detected.
+type RefreshTokenStore interface {
+	// RecordRefreshToken makes tokenHash the connection's current
+	// generation, marking the previous one rotated, and returns it.
+	// Recording the current generation's hash again changes nothing.
+	RecordRefreshToken(ctx context.Context, connectionID uuid.UUID, tokenHash string) (*RefreshGeneration, error)
+	// FindRefreshToken returns the newest generation with tokenHash, or
+	// ErrNotFound.
+	FindRefreshToken(ctx context.Context, connectionID uuid.UUID, tokenHash string) (*RefreshGeneration, error)
+}
+
+// AuditStore persists audit events.
+type AuditStore interface {
+	CreateAuditEvent(ctx context.Context, e *AuditEvent) error
+	ListAuditEvents(ctx context.Context, f AuditFilter) ([]AuditEvent, error)
+	// StreamAuditEvents calls fn for every event matching f, oldest first,
+	// ignoring f.Limit and f.Offset. An error from fn stops the stream and
+	// is returned.
+	StreamAuditEvents(ctx context.Context, f AuditFilter, fn func(*AuditEvent) error) error
+}
+
+// WorkspaceLimitStore persists per-workspace quota overrides and counts
+// the connections they limit.
+type WorkspaceLimitStore interface {
+	// GetWorkspaceLimits returns ErrNotFound if the workspace has no
+	// overrides.
+	GetWorkspaceLimits(ctx context.Context, workspaceID string) (*WorkspaceLimits, error)
+	// SetWorkspaceLimits replaces the workspace's overrides.
+	SetWorkspaceLimits(ctx context.Context, l *WorkspaceLimits) error
+	// DeleteWorkspaceLimits removes the workspace's overrides, if any.
+	DeleteWorkspaceLimits(ctx context.Context, workspaceID string) error
+	// CountWorkspaceConnections returns how many unexpired pending and how
+	// many active connections the workspace has.
+	CountWorkspaceConnections(ctx context.Context, workspaceID string) (pending, active int, err error)
+}
+
+// ScopeApprovalStore persists the scopes approved per workspace and
+// provider.
+type ScopeApprovalStore interface {
+	// GetScopeApprovals returns ErrNotFound if nothing was approved.
+	GetScopeApprovals(ctx context.Context, workspaceID string, providerID uuid.UUID) (*ScopeApprovals, error)
+	// SetScopeApprovals replaces the approved scopes.
+	SetScopeApprovals(ctx context.Context, a *ScopeApprovals) error
+	// DeleteScopeApprovals removes the approved scopes, if any.
+	DeleteScopeApprovals(ctx context.Context, workspaceID string, providerID uuid.UUID) error
+}
+
+// RefreshFailureStore persists failed refreshes for retry and review.
+type RefreshFailureStore interface {
+	// GetRefreshFailure returns ErrNotFound if the connection's last
+	// refresh did not fail.
+	GetRefreshFailure(ctx context.Context, connectionID uuid.UUID) (*RefreshFailure, error)
+	// SaveRefreshFailure adds or replaces the connection's failure.
+	SaveRefreshFailure(ctx context.Context, f *RefreshFailure) error
+	// DeleteRefreshFailure removes the connection's failure, if any.
+	DeleteRefreshFailure(ctx context.Context, connectionID uuid.UUID) error
+	ListRefreshFailures(ctx context.Context, f RefreshFailureFilter) ([]RefreshFailure, error)
+}
+
+// GrantStore persists grants.
+type GrantStore interface {
+	// CreateGrant inserts g. A zero g.ID is replaced with a new UUID.
+	CreateGrant(ctx context.Context, g *Grant) error
+	// RedeemGrant marks the grant redeemed at now and returns it. Of
+	// concurrent redemptions only one succeeds; the others, and any later
+	// ones, get ErrGrantRedeemed. An expired grant gives ErrGrantExpired and
+	// an unknown one ErrNotFound.
+	RedeemGrant(ctx context.Context, id uuid.UUID, now time.Time) (*Grant, error)
+}
+
+// SweepStore removes what the broker's periodic cleanup jobs expire.
+type SweepStore interface {
+	// ExpirePendingConnections moves pending connections past their
+	// ExpiresAt to connstate.StateExpired and returns how many it moved.
+	ExpirePendingConnections(ctx context.Context) (int64, error)
+	// DeleteOrphanTokens deletes tokens whose connection no longer exists
+	// and returns how many it deleted.
+	DeleteOrphanTokens(ctx context.Context) (int64, error)
+	// DeleteExpiredGrants deletes grants that expired before cutoff,
+	// redeemed or not, and returns how many it deleted.
+	DeleteExpiredGrants(ctx context.Context, cutoff time.Time) (int64, error)
+}
+
+// Store is everything the auth handlers persist.
+type Store interface {
+	ConnectionStore
+	ProviderStore
+	TokenStore
+	RefreshTokenStore
+	AuditStore
+	WorkspaceLimitStore
+	ScopeApprovalStore
+	RefreshFailureStore
+	GrantStore
+	SweepStore
+}
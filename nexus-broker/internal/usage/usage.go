@@ -0,0 +1,114 @@
+// Package usage counts token retrievals per connection. Retrievals are
+// buffered in memory and added to the connections table in one statement
+// per flush, so a busy token endpoint costs one UPDATE per interval rather
+// than one per call.
+package usage
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/internal/store"
+)
+
+// Recorder buffers token retrievals until Flush writes them to a store.
+type Recorder struct {
+	store store.ConnectionStore
+	// sync writes every retrieval through immediately.
+	sync bool
+
+	mu      sync.Mutex
+	pending map[uuid.UUID]*store.TokenUsage
+}
+
+// NewRecorder creates a Recorder writing to st. With a zero flushInterval
+// every Record is written through immediately; otherwise the caller runs
+// Start with that interval and calls Flush on shutdown.
+func NewRecorder(st store.ConnectionStore, flushInterval time.Duration) *Recorder {
+	return &Recorder{store: st, sync: flushInterval <= 0, pending: map[uuid.UUID]*store.TokenUsage{}}
+}
+
+// Record counts one token retrieval of connectionID at time at. Errors of a
+// write-through Recorder are logged, not returned: usage accounting never
+// fails a token request.
+func (r *Recorder) Record(ctx context.Context, connectionID uuid.UUID, at time.Time) {
+	if r.sync {
+		u := []store.TokenUsage{{ConnectionID: connectionID, Retrievals: 1, LastAccessedAt: at}}
+		if err := r.store.RecordTokenUsage(ctx, u); err != nil {
+			log.Printf("usage: record %s: %v", connectionID, err)
+		}
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	u, ok := r.pending[connectionID]
+	if !ok {
+		u = &store.TokenUsage{ConnectionID: connectionID}
+		r.pending[connectionID] = u
+	}
+	u.Retrievals++
+	if at.After(u.LastAccessedAt) {
+		u.LastAccessedAt = at
+	}
+}
+
+// Flush writes the buffered retrievals. On error they are kept and retried
+// by the next Flush.
+func (r *Recorder) Flush(ctx context.Context) error {
+	r.mu.Lock()
+	if len(r.pending) == 0 {
+		r.mu.Unlock()
+		return nil
+	}
+	batch := make([]store.TokenUsage, 0, len(r.pending))
+	for _, u := range r.pending {
+		batch = append(batch, *u)
+	}
+	r.pending = map[uuid.UUID]*store.TokenUsage{}
+	r.mu.Unlock()
+
+	if err := r.store.RecordTokenUsage(ctx, batch); err != nil {
+		r.mu.Lock()
+		for _, u := range batch {
+			r.merge(u)
+		}
+		r.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// merge adds u back into the buffer; r.mu must be held.
+func (r *Recorder) merge(u store.TokenUsage) {
+	cur, ok := r.pending[u.ConnectionID]
+	if !ok {
+		r.pending[u.ConnectionID] = &u
+		return
+	}
+	cur.Retrievals += u.Retrievals
+	if u.LastAccessedAt.After(cur.LastAccessedAt) {
+		cur.LastAccessedAt = u.LastAccessedAt
+	}
+}
+
+// Start flushes every interval until ctx is cancelled. It does not flush on
+// the way out; call Flush once requests have drained.
+func (r *Recorder) Start(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := r.Flush(ctx); err != nil {
+				log.Printf("usage: flush failed: %v", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
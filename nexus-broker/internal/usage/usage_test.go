@@ -0,0 +1,64 @@
+package usage
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/internal/store"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/connstate"
+)
+
+// failingStore fails RecordTokenUsage while fail is set.
+type failingStore struct {
+	*store.Memory
+	fail bool
+}
+
+func (f *failingStore) RecordTokenUsage(ctx context.Context, u []store.TokenUsage) error {
+	if f.fail {
+		return errors.New("db down")
+	}
+	return f.Memory.RecordTokenUsage(ctx, u)
+}
+
+func TestRecorderBuffersUntilFlush(t *testing.T) {
+	ctx := context.Background()
+	st := &failingStore{Memory: store.NewMemory()}
+	id := uuid.New()
+	st.PutConnection(store.Connection{ID: id, Status: connstate.StateActive})
+	r := NewRecorder(st, time.Minute)
+
+	t0 := time.Now().Add(-time.Minute)
+	r.Record(ctx, id, t0.Add(time.Second))
+	r.Record(ctx, id, t0)
+	c, _ := st.GetConnection(ctx, id)
+	assert.Zero(t, c.TokenRetrievals, "written before Flush")
+
+	st.fail = true
+	require.Error(t, r.Flush(ctx))
+	r.Record(ctx, id, t0.Add(2*time.Second))
+	st.fail = false
+	require.NoError(t, r.Flush(ctx))
+
+	c, _ = st.GetConnection(ctx, id)
+	assert.EqualValues(t, 3, c.TokenRetrievals)
+	require.NotNil(t, c.LastAccessedAt)
+	assert.True(t, c.LastAccessedAt.Equal(t0.Add(2*time.Second)))
+}
+
+func TestRecorderWritesThroughWithoutInterval(t *testing.T) {
+	ctx := context.Background()
+	st := store.NewMemory()
+	id := uuid.New()
+	st.PutConnection(store.Connection{ID: id, Status: connstate.StateActive})
+
+	NewRecorder(st, 0).Record(ctx, id, time.Now())
+	c, _ := st.GetConnection(ctx, id)
+	assert.EqualValues(t, 1, c.TokenRetrievals)
+}
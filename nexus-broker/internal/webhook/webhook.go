@@ -0,0 +1,90 @@
+// Package webhook delivers broker events to an operator-configured HTTP
+// endpoint.
+//
+// Each event is POSTed as JSON:
+//
+//	{"event": "user.deprovisioned", "occurred_at": "...", "data": {...}}
+//
+// When a secret is configured the body is signed with HMAC-SHA256 and the
+// hex digest sent as "X-Nexus-Signature: sha256=<digest>".
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// SignatureHeader carries the HMAC-SHA256 signature of the request body.
+const SignatureHeader = "X-Nexus-Signature"
+
+// Notifier posts events to a single URL. A nil *Notifier discards events, so
+// callers need not check whether webhooks are configured.
+type Notifier struct {
+	url    string
+	secret []byte
+	client *http.Client
+}
+
+// New creates a Notifier for url, or returns nil if url is empty. A nil
+// client uses a client with a 10 second timeout.
+func New(url string, secret []byte, client *http.Client) *Notifier {
+	if url == "" {
+		return nil
+	}
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &Notifier{url: url, secret: secret, client: client}
+}
+
+type envelope struct {
+	Event      string      `json:"event"`
+	OccurredAt time.Time   `json:"occurred_at"`
+	Data       interface{} `json:"data"`
+}
+
+// Send delivers one event. Any non-2xx response is an error; Send does not
+// retry.
+func (n *Notifier) Send(ctx context.Context, event string, data interface{}) error {
+	if n == nil {
+		return nil
+	}
+	body, err := json.Marshal(envelope{Event: event, OccurredAt: time.Now().UTC(), Data: data})
+	if err != nil {
+		return fmt.Errorf("webhook: marshal %s: %w", event, err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("webhook: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if len(n.secret) > 0 {
+		req.Header.Set(SignatureHeader, "sha256="+Sign(n.secret, body))
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("webhook: %s: %w", event, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook: %s: endpoint returned %d", event, resp.StatusCode)
+	}
+	return nil
+}
+
+// Sign returns the hex HMAC-SHA256 of body under secret, as sent in
+// SignatureHeader. Receivers use it to verify deliveries.
+func Sign(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
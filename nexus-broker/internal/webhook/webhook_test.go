@@ -0,0 +1,46 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSend_SignsBody(t *testing.T) {
+	secret := []byte("shh")
+	var got envelope
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		assert.Equal(t, "sha256="+Sign(secret, body), r.Header.Get(SignatureHeader))
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		require.NoError(t, json.Unmarshal(body, &got))
+	}))
+	defer srv.Close()
+
+	n := New(srv.URL, secret, srv.Client())
+	require.NoError(t, n.Send(context.Background(), "user.deprovisioned", map[string]string{"user": "u-1"}))
+	assert.Equal(t, "user.deprovisioned", got.Event)
+	assert.Equal(t, map[string]interface{}{"user": "u-1"}, got.Data)
+}
+
+func TestSend_Non2xxIsError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer srv.Close()
+
+	err := New(srv.URL, nil, srv.Client()).Send(context.Background(), "x", nil)
+	assert.ErrorContains(t, err, "502")
+}
+
+func TestNilNotifierDiscards(t *testing.T) {
+	n := New("", []byte("secret"), nil)
+	assert.Nil(t, n)
+	assert.NoError(t, n.Send(context.Background(), "x", nil))
+}
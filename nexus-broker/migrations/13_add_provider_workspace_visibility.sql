@@ -0,0 +1,3 @@
+-- Empty workspace_ids means the provider is global (visible to every workspace).
+ALTER TABLE provider_profiles ADD COLUMN IF NOT EXISTS workspace_ids TEXT[] NOT NULL DEFAULT '{}';
+CREATE INDEX IF NOT EXISTS idx_provider_profiles_workspace_ids ON provider_profiles USING GIN (workspace_ids);
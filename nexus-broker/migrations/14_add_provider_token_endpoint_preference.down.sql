@@ -0,0 +1 @@
+ALTER TABLE provider_profiles DROP COLUMN IF EXISTS token_endpoint_preference;
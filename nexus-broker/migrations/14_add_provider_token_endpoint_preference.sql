@@ -0,0 +1,4 @@
+-- Records which token endpoint ('discovered' or 'configured') last completed a
+-- code exchange when OIDC discovery and token_url disagree. NULL means no
+-- preference; discovery is tried first.
+ALTER TABLE provider_profiles ADD COLUMN IF NOT EXISTS token_endpoint_preference VARCHAR(20);
@@ -0,0 +1,9 @@
+-- Results of the broker's scheduled provider health audits (see PROVIDER_AUDIT_INTERVAL).
+CREATE TABLE IF NOT EXISTS provider_audits (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    provider_id UUID NOT NULL REFERENCES provider_profiles(id) ON DELETE CASCADE,
+    status VARCHAR(10) NOT NULL, -- pass, warn, fail
+    checks JSONB NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_provider_audits_provider_created ON provider_audits (provider_id, created_at DESC);
@@ -0,0 +1,20 @@
+-- Explicit connection lifecycle (see pkg/connstate). The former 'attention'
+-- status is renamed to 'needs_reauth'.
+UPDATE connections SET status = 'needs_reauth' WHERE status = 'attention';
+
+ALTER TABLE connections ADD COLUMN IF NOT EXISTS status_changed_at TIMESTAMP WITH TIME ZONE;
+
+ALTER TABLE connections DROP CONSTRAINT IF EXISTS connections_status_check;
+ALTER TABLE connections ADD CONSTRAINT connections_status_check CHECK (status IN (
+    'pending', 'active', 'failed', 'cancelled', 'expired', 'needs_reauth', 'revoked', 'archived'
+));
+
+-- Audit trail of every status change made through the state machine.
+CREATE TABLE IF NOT EXISTS connection_state_transitions (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    connection_id UUID NOT NULL REFERENCES connections(id) ON DELETE CASCADE,
+    from_state VARCHAR(50) NOT NULL,
+    to_state VARCHAR(50) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_connection_state_transitions_connection ON connection_state_transitions (connection_id, created_at);
@@ -0,0 +1,3 @@
+-- PEM certificates trusted, in addition to the system roots, when the broker
+-- calls a provider's endpoints (providers behind a private CA).
+ALTER TABLE provider_profiles ADD COLUMN IF NOT EXISTS ca_bundle TEXT;
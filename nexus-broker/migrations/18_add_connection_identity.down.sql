@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_connections_workspace_email;
+DROP INDEX IF EXISTS idx_connections_workspace_subject;
+ALTER TABLE connections DROP COLUMN IF EXISTS email;
+ALTER TABLE connections DROP COLUMN IF EXISTS subject;
@@ -0,0 +1,7 @@
+-- User identity from the verified id_token (sub and email claims), so every
+-- connection a user authorised can be found and revoked when they leave.
+ALTER TABLE connections ADD COLUMN IF NOT EXISTS subject TEXT;
+ALTER TABLE connections ADD COLUMN IF NOT EXISTS email TEXT;
+
+CREATE INDEX IF NOT EXISTS idx_connections_workspace_subject ON connections (workspace_id, subject);
+CREATE INDEX IF NOT EXISTS idx_connections_workspace_email ON connections (workspace_id, lower(email));
@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS refresh_token_generations;
+
+UPDATE connections SET status = 'needs_reauth' WHERE status = 'compromised';
+ALTER TABLE connections DROP CONSTRAINT IF EXISTS connections_status_check;
+ALTER TABLE connections ADD CONSTRAINT connections_status_check CHECK (status IN (
+    'pending', 'active', 'failed', 'cancelled', 'expired', 'needs_reauth', 'revoked', 'archived'
+));
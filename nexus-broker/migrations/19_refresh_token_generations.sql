@@ -0,0 +1,18 @@
+-- Refresh token rotation tracking. Each refresh token a connection holds is
+-- one generation, stored as an HMAC of the token; a generation is rotated
+-- once the provider issues its successor. Seeing a rotated token again marks
+-- the connection 'compromised'.
+ALTER TABLE connections DROP CONSTRAINT IF EXISTS connections_status_check;
+ALTER TABLE connections ADD CONSTRAINT connections_status_check CHECK (status IN (
+    'pending', 'active', 'failed', 'cancelled', 'expired', 'needs_reauth', 'compromised', 'revoked', 'archived'
+));
+
+CREATE TABLE IF NOT EXISTS refresh_token_generations (
+    connection_id UUID NOT NULL REFERENCES connections(id) ON DELETE CASCADE,
+    generation INTEGER NOT NULL,
+    token_hash TEXT NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    rotated_at TIMESTAMP WITH TIME ZONE,
+    PRIMARY KEY (connection_id, generation)
+);
+CREATE INDEX IF NOT EXISTS idx_refresh_token_generations_hash ON refresh_token_generations (connection_id, token_hash);
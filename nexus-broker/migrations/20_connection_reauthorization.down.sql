@@ -0,0 +1,4 @@
+ALTER TABLE connections DROP COLUMN IF EXISTS reauth_expires_at;
+ALTER TABLE connections DROP COLUMN IF EXISTS reauth_return_url;
+ALTER TABLE connections DROP COLUMN IF EXISTS reauth_scopes;
+ALTER TABLE connections DROP COLUMN IF EXISTS reauth_code_verifier;
@@ -0,0 +1,8 @@
+-- Re-consent of an existing connection (POST /connections/{id}/reauthorize),
+-- e.g. to add scopes. The consent in progress is kept on the connection
+-- itself, so its ID, token and audit history stay in place until the
+-- callback swaps in the new token.
+ALTER TABLE connections ADD COLUMN IF NOT EXISTS reauth_code_verifier TEXT;
+ALTER TABLE connections ADD COLUMN IF NOT EXISTS reauth_scopes TEXT[];
+ALTER TABLE connections ADD COLUMN IF NOT EXISTS reauth_return_url TEXT;
+ALTER TABLE connections ADD COLUMN IF NOT EXISTS reauth_expires_at TIMESTAMP WITH TIME ZONE;
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_connections_last_accessed_at;
+ALTER TABLE connections DROP COLUMN IF EXISTS last_accessed_at;
+ALTER TABLE connections DROP COLUMN IF EXISTS token_retrievals;
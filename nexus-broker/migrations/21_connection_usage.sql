@@ -0,0 +1,8 @@
+-- Token usage per connection: how often its token has been retrieved and
+-- when it was last retrieved, so unused credentials can be found and
+-- revoked (GET /connections/stale). The broker buffers retrievals and adds
+-- them here periodically rather than updating the row on every call.
+ALTER TABLE connections ADD COLUMN IF NOT EXISTS token_retrievals BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE connections ADD COLUMN IF NOT EXISTS last_accessed_at TIMESTAMP WITH TIME ZONE;
+
+CREATE INDEX IF NOT EXISTS idx_connections_last_accessed_at ON connections (last_accessed_at);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_connections_workspace_status;
+DROP TABLE IF EXISTS workspace_limits;
@@ -0,0 +1,14 @@
+-- Per-workspace overrides of the configured quotas (WORKSPACE_MAX_*), set
+-- through the admin API. A NULL column keeps the configured default; 0 is
+-- unlimited.
+CREATE TABLE IF NOT EXISTS workspace_limits (
+    workspace_id TEXT PRIMARY KEY,
+    max_pending_connections INTEGER CHECK (max_pending_connections >= 0),
+    max_active_connections INTEGER CHECK (max_active_connections >= 0),
+    max_refreshes_per_minute INTEGER CHECK (max_refreshes_per_minute >= 0),
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+-- Quota checks count a workspace's pending and active connections.
+CREATE INDEX IF NOT EXISTS idx_connections_workspace_status ON connections (workspace_id, status);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_refresh_failures_next_retry;
+DROP TABLE IF EXISTS refresh_failures;
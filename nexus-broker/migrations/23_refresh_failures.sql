@@ -0,0 +1,16 @@
+-- Connections whose last refresh failed: the refresh dead-letter queue.
+-- Transient failures are retried with exponential backoff at next_retry_at;
+-- a NULL next_retry_at means retries were given up and the connection was
+-- marked needs_reauth. A successful refresh deletes the row.
+CREATE TABLE IF NOT EXISTS refresh_failures (
+    connection_id UUID PRIMARY KEY REFERENCES connections(id) ON DELETE CASCADE,
+    error_class TEXT NOT NULL,
+    last_error TEXT NOT NULL DEFAULT '',
+    status_code INTEGER NOT NULL DEFAULT 0,
+    attempts INTEGER NOT NULL DEFAULT 1,
+    first_failed_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    last_failed_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    next_retry_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS idx_refresh_failures_next_retry ON refresh_failures (next_retry_at) WHERE next_retry_at IS NOT NULL;
@@ -0,0 +1,5 @@
+UPDATE connections SET status = 'active' WHERE status = 'suspended';
+ALTER TABLE connections DROP CONSTRAINT IF EXISTS connections_status_check;
+ALTER TABLE connections ADD CONSTRAINT connections_status_check CHECK (status IN (
+    'pending', 'active', 'failed', 'cancelled', 'expired', 'needs_reauth', 'compromised', 'revoked', 'archived'
+));
@@ -0,0 +1,6 @@
+-- 'suspended': a connection an operator disabled for now. Its tokens are
+-- withheld until it is resumed.
+ALTER TABLE connections DROP CONSTRAINT IF EXISTS connections_status_check;
+ALTER TABLE connections ADD CONSTRAINT connections_status_check CHECK (status IN (
+    'pending', 'active', 'failed', 'cancelled', 'expired', 'needs_reauth', 'compromised', 'suspended', 'revoked', 'archived'
+));
@@ -0,0 +1,8 @@
+-- Why an operator suspended a connection. The row exists while the
+-- connection is suspended; resuming it deletes the row.
+CREATE TABLE IF NOT EXISTS connection_suspensions (
+    connection_id UUID PRIMARY KEY REFERENCES connections(id) ON DELETE CASCADE,
+    reason TEXT NOT NULL DEFAULT '',
+    suspended_by TEXT NOT NULL DEFAULT '',
+    suspended_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
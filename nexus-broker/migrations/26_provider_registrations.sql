@@ -0,0 +1,12 @@
+-- OAuth clients the broker registered for a provider with Dynamic Client
+-- Registration (RFC 7591). The client ID and secret live on the provider
+-- profile; this row holds what is needed to manage the client (RFC 7592).
+CREATE TABLE IF NOT EXISTS provider_registrations (
+    provider_id UUID PRIMARY KEY REFERENCES provider_profiles(id) ON DELETE CASCADE,
+    registration_client_uri TEXT NOT NULL DEFAULT '',
+    -- Sealed with ENCRYPTION_KEY like provider_profiles.client_secret.
+    registration_access_token TEXT NOT NULL DEFAULT '',
+    client_secret_expires_at TIMESTAMP WITH TIME ZONE,
+    registered_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    rotated_at TIMESTAMP WITH TIME ZONE
+);
@@ -0,0 +1,4 @@
+-- Azure AD tenant of a connection: the tenant requested at consent (common,
+-- organizations, consumers or a tenant ID), replaced by the user's tenant
+-- ID once the id_token resolves it. Refreshes and re-consents use it.
+ALTER TABLE connections ADD COLUMN IF NOT EXISTS tenant TEXT;
@@ -0,0 +1,10 @@
+-- Scopes an administrator approved for a workspace's consents to a provider
+-- whose scope_policy lists them under approval, set through the admin API.
+CREATE TABLE IF NOT EXISTS scope_approvals (
+    workspace_id TEXT NOT NULL,
+    provider_id UUID NOT NULL REFERENCES provider_profiles(id) ON DELETE CASCADE,
+    scopes TEXT[] NOT NULL DEFAULT '{}',
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (workspace_id, provider_id)
+);
@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS idx_connections_awaiting_approval;
+UPDATE connections SET status = 'failed' WHERE status IN ('awaiting_approval', 'rejected');
+ALTER TABLE connections DROP CONSTRAINT IF EXISTS connections_status_check;
+ALTER TABLE connections ADD CONSTRAINT connections_status_check CHECK (status IN (
+    'pending', 'active', 'failed', 'cancelled', 'expired', 'needs_reauth', 'compromised', 'suspended', 'revoked', 'archived'
+));
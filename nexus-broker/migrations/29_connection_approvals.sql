@@ -0,0 +1,10 @@
+-- 'awaiting_approval': a consent completed for a provider with
+-- requires_approval; its tokens are withheld until an approver approves it.
+-- 'rejected': refused by an approver, its tokens deleted.
+ALTER TABLE connections DROP CONSTRAINT IF EXISTS connections_status_check;
+ALTER TABLE connections ADD CONSTRAINT connections_status_check CHECK (status IN (
+    'pending', 'awaiting_approval', 'active', 'failed', 'cancelled', 'expired', 'rejected', 'needs_reauth', 'compromised', 'suspended', 'revoked', 'archived'
+));
+
+-- The approval queue lists awaiting connections oldest first.
+CREATE INDEX IF NOT EXISTS idx_connections_awaiting_approval ON connections (created_at) WHERE status = 'awaiting_approval';
@@ -0,0 +1,13 @@
+-- Single-use grants to a connection's access token, issued by
+-- POST /connections/{id}/grants and redeemed once through the Gateway.
+CREATE TABLE IF NOT EXISTS connection_grants (
+    id UUID PRIMARY KEY,
+    connection_id UUID NOT NULL REFERENCES connections(id) ON DELETE CASCADE,
+    workspace_id TEXT NOT NULL,
+    scopes TEXT[] NOT NULL DEFAULT '{}',
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    redeemed_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_connection_grants_expires_at ON connection_grants (expires_at);
@@ -0,0 +1,3 @@
+-- Why a consent failed: the code the callback sent to the return_url, such
+-- as token_exchange_failed, reported by GET /connections/{id}/status.
+ALTER TABLE connections ADD COLUMN IF NOT EXISTS error_code TEXT;
@@ -0,0 +1,31 @@
+// Package migrations embeds the broker's SQL schema migrations so the
+// binary can apply them itself. See pkg/migrate for the naming rules.
+package migrations
+
+import (
+	"embed"
+	"io/fs"
+)
+
+// FS holds every Postgres migration file in this directory.
+//
+//go:embed *.sql
+var FS embed.FS
+
+//go:embed mysql/*.sql sqlite/*.sql
+var dialects embed.FS
+
+// MySQL and SQLite hold the migrations of the SQL store (see store.SQL),
+// applied with migrate.NewForDialect.
+var (
+	MySQL  = sub("mysql")
+	SQLite = sub("sqlite")
+)
+
+func sub(dir string) fs.FS {
+	fsys, err := fs.Sub(dialects, dir)
+	if err != nil {
+		panic(err)
+	}
+	return fsys
+}
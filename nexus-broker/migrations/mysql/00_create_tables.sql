@@ -0,0 +1,146 @@
+-- Schema of the broker on MySQL: the tables of store.SQL and the provider
+-- registry. UUIDs are stored as text, string lists as JSON arrays and
+-- timestamps in UTC. Statements are run one at a time (see pkg/migrate).
+
+CREATE TABLE provider_profiles (
+    id CHAR(36) PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    auth_type VARCHAR(32) NOT NULL,
+    auth_header TEXT,
+    auth_url TEXT,
+    token_url TEXT,
+    issuer TEXT,
+    enable_discovery BOOLEAN NOT NULL DEFAULT FALSE,
+    client_id TEXT,
+    client_secret TEXT,
+    api_base_url TEXT,
+    user_info_endpoint TEXT,
+    scopes TEXT,
+    params TEXT,
+    description TEXT,
+    category VARCHAR(255),
+    token_endpoint_preference VARCHAR(32),
+    ca_bundle TEXT,
+    workspace_ids TEXT,
+    created_at DATETIME(6) NOT NULL,
+    updated_at DATETIME(6) NOT NULL,
+    deleted_at DATETIME(6),
+    -- Set while the provider is active: names are unique among active
+    -- providers only.
+    active_name VARCHAR(255) AS (CASE WHEN deleted_at IS NULL THEN name END) STORED,
+    UNIQUE KEY idx_provider_profiles_name_unique (active_name)
+);
+
+CREATE TABLE provider_registrations (
+    provider_id CHAR(36) PRIMARY KEY REFERENCES provider_profiles (id) ON DELETE CASCADE,
+    registration_client_uri TEXT NOT NULL,
+    registration_access_token TEXT NOT NULL,
+    client_secret_expires_at DATETIME(6),
+    registered_at DATETIME(6) NOT NULL,
+    rotated_at DATETIME(6)
+);
+
+CREATE TABLE connections (
+    id CHAR(36) PRIMARY KEY,
+    workspace_id VARCHAR(255) NOT NULL,
+    provider_id CHAR(36) NOT NULL,
+    status VARCHAR(32) NOT NULL,
+    code_verifier TEXT,
+    scopes TEXT,
+    return_url TEXT NOT NULL,
+    expires_at DATETIME(6) NOT NULL,
+    subject VARCHAR(255),
+    email VARCHAR(255),
+    tenant VARCHAR(255),
+    error_code VARCHAR(64),
+    reauth_code_verifier TEXT,
+    reauth_scopes TEXT,
+    reauth_return_url TEXT,
+    reauth_expires_at DATETIME(6),
+    token_retrievals BIGINT NOT NULL DEFAULT 0,
+    last_accessed_at DATETIME(6),
+    status_changed_at DATETIME(6) NOT NULL,
+    created_at DATETIME(6) NOT NULL,
+    updated_at DATETIME(6) NOT NULL
+);
+
+CREATE INDEX idx_connections_workspace ON connections (workspace_id, status);
+
+CREATE TABLE connection_state_transitions (
+    connection_id CHAR(36) NOT NULL,
+    from_state VARCHAR(32) NOT NULL,
+    to_state VARCHAR(32) NOT NULL,
+    created_at DATETIME(6) NOT NULL
+);
+
+CREATE TABLE tokens (
+    connection_id CHAR(36) PRIMARY KEY REFERENCES connections (id) ON DELETE CASCADE,
+    encrypted_data TEXT NOT NULL,
+    expires_at DATETIME(6),
+    created_at DATETIME(6) NOT NULL
+);
+
+CREATE TABLE refresh_token_generations (
+    connection_id CHAR(36) NOT NULL REFERENCES connections (id) ON DELETE CASCADE,
+    generation INTEGER NOT NULL,
+    token_hash VARCHAR(128) NOT NULL,
+    created_at DATETIME(6) NOT NULL,
+    rotated_at DATETIME(6),
+    PRIMARY KEY (connection_id, generation)
+);
+
+CREATE TABLE audit_events (
+    id CHAR(36) PRIMARY KEY,
+    connection_id CHAR(36),
+    event_type VARCHAR(64) NOT NULL,
+    event_data TEXT,
+    ip_address VARCHAR(64),
+    user_agent TEXT,
+    created_at DATETIME(6) NOT NULL
+);
+
+CREATE INDEX idx_audit_events_created_at ON audit_events (created_at);
+
+CREATE TABLE workspace_limits (
+    workspace_id VARCHAR(255) PRIMARY KEY,
+    max_pending_connections INTEGER,
+    max_active_connections INTEGER,
+    max_refreshes_per_minute INTEGER,
+    updated_at DATETIME(6) NOT NULL
+);
+
+CREATE TABLE scope_approvals (
+    workspace_id VARCHAR(255) NOT NULL,
+    provider_id CHAR(36) NOT NULL,
+    scopes TEXT NOT NULL,
+    updated_at DATETIME(6) NOT NULL,
+    PRIMARY KEY (workspace_id, provider_id)
+);
+
+CREATE TABLE refresh_failures (
+    connection_id CHAR(36) PRIMARY KEY REFERENCES connections (id) ON DELETE CASCADE,
+    error_class VARCHAR(32) NOT NULL,
+    last_error TEXT NOT NULL,
+    status_code INTEGER NOT NULL,
+    attempts INTEGER NOT NULL,
+    first_failed_at DATETIME(6) NOT NULL,
+    last_failed_at DATETIME(6) NOT NULL,
+    next_retry_at DATETIME(6)
+);
+
+CREATE TABLE connection_suspensions (
+    connection_id CHAR(36) PRIMARY KEY REFERENCES connections (id) ON DELETE CASCADE,
+    reason TEXT NOT NULL,
+    suspended_by VARCHAR(255) NOT NULL,
+    suspended_at DATETIME(6) NOT NULL
+);
+
+CREATE TABLE connection_grants (
+    id CHAR(36) PRIMARY KEY,
+    connection_id CHAR(36) NOT NULL REFERENCES connections (id) ON DELETE CASCADE,
+    workspace_id VARCHAR(255) NOT NULL,
+    scopes TEXT NOT NULL,
+    expires_at DATETIME(6) NOT NULL,
+    created_at DATETIME(6) NOT NULL,
+    redeemed_at DATETIME(6)
+);
@@ -0,0 +1,116 @@
+package authrequest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+)
+
+// requestObjectLifetime is how long a signed request object is valid. FAPI
+// allows at most 60 minutes.
+const requestObjectLifetime = 5 * time.Minute
+
+// Signer signs request objects with a provider profile's key.
+type Signer struct {
+	signer jose.Signer
+}
+
+// NewSigner parses a PEM private key (PKCS #8, PKCS #1 or SEC 1) for signing
+// request objects with alg. An empty alg defaults to RS256 for RSA keys and
+// ES256 for P-256 keys. keyID, if set, is sent as the kid header.
+func NewSigner(keyPEM, alg, keyID string) (*Signer, error) {
+	block, _ := pem.Decode([]byte(keyPEM))
+	if block == nil {
+		return nil, errors.New("no PEM private key found")
+	}
+	key, err := parsePrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	var algorithm jose.SignatureAlgorithm
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		switch alg {
+		case "", "RS256":
+			algorithm = jose.RS256
+		case "PS256":
+			algorithm = jose.PS256
+		default:
+			return nil, fmt.Errorf("unsupported algorithm %q for an RSA key", alg)
+		}
+	case *ecdsa.PrivateKey:
+		if k.Curve != elliptic.P256() {
+			return nil, errors.New("only P-256 EC keys are supported")
+		}
+		if alg != "" && alg != "ES256" {
+			return nil, fmt.Errorf("unsupported algorithm %q for an EC key", alg)
+		}
+		algorithm = jose.ES256
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", key)
+	}
+
+	signer, err := jose.NewSigner(
+		jose.SigningKey{Algorithm: algorithm, Key: jose.JSONWebKey{Key: key, KeyID: keyID}},
+		(&jose.SignerOptions{}).WithType("oauth-authz-req+jwt"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &Signer{signer: signer}, nil
+}
+
+func parsePrivateKey(der []byte) (interface{}, error) {
+	if key, err := x509.ParsePKCS8PrivateKey(der); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(der); err == nil {
+		return key, nil
+	}
+	return nil, errors.New("unrecognized private key")
+}
+
+// Sign returns a request object carrying the authorization request params,
+// issued by clientID for the provider identified by audience.
+func (s *Signer) Sign(params url.Values, clientID, audience string, now time.Time) (string, error) {
+	jti := make([]byte, 16)
+	if _, err := rand.Read(jti); err != nil {
+		return "", err
+	}
+	claims := make(map[string]interface{}, len(params)+6)
+	for k := range params {
+		claims[k] = params.Get(k)
+	}
+	claims["client_id"] = clientID
+	claims["iss"] = clientID
+	claims["aud"] = audience
+	claims["iat"] = now.Unix()
+	claims["nbf"] = now.Unix()
+	claims["exp"] = now.Add(requestObjectLifetime).Unix()
+	claims["jti"] = base64.RawURLEncoding.EncodeToString(jti)
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	obj, err := s.signer.Sign(payload)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign request object: %w", err)
+	}
+	return obj.CompactSerialize()
+}
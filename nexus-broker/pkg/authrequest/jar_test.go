@@ -0,0 +1,70 @@
+package authrequest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func ecKeyPEM(t *testing.T) (*ecdsa.PrivateKey, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	return key, string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+}
+
+func TestSigner_Sign(t *testing.T) {
+	key, keyPEM := ecKeyPEM(t)
+	signer, err := NewSigner(keyPEM, "", "key-1")
+	require.NoError(t, err)
+
+	now := time.Unix(1700000000, 0)
+	obj, err := signer.Sign(url.Values{"state": {"s"}, "scope": {"openid email"}}, "c-1", "https://idp.example", now)
+	require.NoError(t, err)
+
+	jws, err := jose.ParseSigned(obj, []jose.SignatureAlgorithm{jose.ES256})
+	require.NoError(t, err)
+	assert.Equal(t, "key-1", jws.Signatures[0].Header.KeyID)
+	assert.Equal(t, "oauth-authz-req+jwt", jws.Signatures[0].Header.ExtraHeaders["typ"])
+	payload, err := jws.Verify(&key.PublicKey)
+	require.NoError(t, err)
+
+	var claims map[string]interface{}
+	require.NoError(t, json.Unmarshal(payload, &claims))
+	assert.Equal(t, "s", claims["state"])
+	assert.Equal(t, "openid email", claims["scope"])
+	assert.Equal(t, "c-1", claims["iss"])
+	assert.Equal(t, "c-1", claims["client_id"])
+	assert.Equal(t, "https://idp.example", claims["aud"])
+	assert.Equal(t, float64(now.Add(requestObjectLifetime).Unix()), claims["exp"])
+	assert.NotEmpty(t, claims["jti"])
+}
+
+func TestNewSigner_RejectsBadKeys(t *testing.T) {
+	_, ecPEM := ecKeyPEM(t)
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	rsaPEM := string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)}))
+
+	_, err = NewSigner(rsaPEM, "PS256", "")
+	assert.NoError(t, err)
+	_, err = NewSigner(rsaPEM, "ES256", "")
+	assert.Error(t, err)
+	_, err = NewSigner(ecPEM, "RS256", "")
+	assert.Error(t, err)
+	_, err = NewSigner("not a key", "", "")
+	assert.Error(t, err)
+}
@@ -0,0 +1,46 @@
+package authrequest
+
+import "encoding/json"
+
+// SigningKeyParam is the provider param holding the PEM private key request
+// objects are signed with. It is a secret of the provider profile.
+const SigningKeyParam = "request_object_signing_key"
+
+// Options control how a provider's authorization requests are sent.
+type Options struct {
+	// PAR is nil to push requests when discovery advertises a PAR endpoint.
+	// The par param sets it: true requires pushing, false never pushes.
+	PAR *bool
+	// PAREndpoint overrides the discovered PAR endpoint.
+	PAREndpoint string
+	// SigningKey, if set, signs the request into a request object with
+	// SigningAlg, sending SigningKeyID as its kid.
+	SigningKey   string
+	SigningAlg   string
+	SigningKeyID string
+}
+
+// ParseOptions reads the Options from a provider's params. Params of the
+// wrong type are ignored.
+func ParseOptions(params *json.RawMessage) Options {
+	var o Options
+	var raw map[string]json.RawMessage
+	if params == nil || json.Unmarshal(*params, &raw) != nil {
+		return o
+	}
+	_ = json.Unmarshal(raw["par"], &o.PAR)
+	_ = json.Unmarshal(raw["pushed_authorization_request_endpoint"], &o.PAREndpoint)
+	_ = json.Unmarshal(raw[SigningKeyParam], &o.SigningKey)
+	_ = json.Unmarshal(raw["request_object_signing_alg"], &o.SigningAlg)
+	_ = json.Unmarshal(raw["request_object_signing_kid"], &o.SigningKeyID)
+	return o
+}
+
+// Signer returns the request object signer configured by o, or nil when no
+// signing key is set.
+func (o Options) Signer() (*Signer, error) {
+	if o.SigningKey == "" {
+		return nil, nil
+	}
+	return NewSigner(o.SigningKey, o.SigningAlg, o.SigningKeyID)
+}
@@ -0,0 +1,99 @@
+// Package authrequest sends OAuth authorization request parameters to
+// providers outside the authorization URL: pushed to the provider's PAR
+// endpoint (RFC 9126), or signed into a request object (JAR, RFC 9101).
+package authrequest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Pushed is a provider's answer to a pushed authorization request.
+type Pushed struct {
+	// RequestURI references the pushed parameters in the authorization URL.
+	RequestURI string `json:"request_uri"`
+	// ExpiresIn is the lifetime of RequestURI in seconds.
+	ExpiresIn int `json:"expires_in"`
+}
+
+// ClientAuth authenticates the client at the PAR endpoint, the same way as
+// at the token endpoint.
+type ClientAuth struct {
+	ClientID     string
+	ClientSecret string
+	// Basic sends the credentials in an Authorization header
+	// (client_secret_basic) instead of the form (client_secret_post).
+	Basic bool
+}
+
+// Error is an error response of a PAR endpoint.
+type Error struct {
+	Status      int
+	Code        string
+	Description string
+}
+
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("pushed authorization request failed with status %d", e.Status)
+	if e.Code != "" {
+		msg += ": " + e.Code
+	}
+	if e.Description != "" {
+		msg += ": " + e.Description
+	}
+	return msg
+}
+
+// Push posts the authorization request params to endpoint and returns the
+// request_uri that stands in for them.
+func Push(ctx context.Context, client *http.Client, endpoint string, params url.Values, auth ClientAuth) (*Pushed, error) {
+	form := url.Values{}
+	for k, v := range params {
+		form[k] = v
+	}
+	form.Set("client_id", auth.ClientID)
+	if !auth.Basic && auth.ClientSecret != "" {
+		form.Set("client_secret", auth.ClientSecret)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if auth.Basic {
+		req.SetBasicAuth(auth.ClientID, auth.ClientSecret)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		e := &Error{Status: resp.StatusCode}
+		var body struct {
+			Error            string `json:"error"`
+			ErrorDescription string `json:"error_description"`
+		}
+		if json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&body) == nil {
+			e.Code, e.Description = body.Error, body.ErrorDescription
+		}
+		return nil, e
+	}
+
+	var pushed Pushed
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&pushed); err != nil {
+		return nil, fmt.Errorf("invalid pushed authorization response: %w", err)
+	}
+	if strings.TrimSpace(pushed.RequestURI) == "" {
+		return nil, fmt.Errorf("invalid pushed authorization response: missing request_uri")
+	}
+	return &pushed, nil
+}
@@ -0,0 +1,52 @@
+package authrequest
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPush(t *testing.T) {
+	var form url.Values
+	var user, pass string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		form = r.PostForm
+		user, pass, _ = r.BasicAuth()
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(`{"request_uri": "urn:ietf:params:oauth:request_uri:abc", "expires_in": 60}`))
+	}))
+	defer srv.Close()
+
+	pushed, err := Push(context.Background(), srv.Client(), srv.URL, url.Values{"state": {"s"}, "scope": {"openid"}},
+		ClientAuth{ClientID: "c-1", ClientSecret: "secret", Basic: true})
+	require.NoError(t, err)
+	assert.Equal(t, "urn:ietf:params:oauth:request_uri:abc", pushed.RequestURI)
+	assert.Equal(t, 60, pushed.ExpiresIn)
+	assert.Equal(t, "c-1", form.Get("client_id"))
+	assert.Equal(t, "s", form.Get("state"))
+	assert.Empty(t, form.Get("client_secret"))
+	assert.Equal(t, "c-1", user)
+	assert.Equal(t, "secret", pass)
+}
+
+func TestPush_ErrorResponse(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error": "invalid_request", "error_description": "redirect_uri not registered"}`))
+	}))
+	defer srv.Close()
+
+	_, err := Push(context.Background(), srv.Client(), srv.URL, url.Values{}, ClientAuth{ClientID: "c-1"})
+	var parErr *Error
+	require.True(t, errors.As(err, &parErr))
+	assert.Equal(t, http.StatusBadRequest, parErr.Status)
+	assert.Equal(t, "invalid_request", parErr.Code)
+	assert.Equal(t, "redirect_uri not registered", parErr.Description)
+}
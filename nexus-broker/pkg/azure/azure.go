@@ -0,0 +1,123 @@
+// Package azure handles the tenants of Microsoft Entra ID (Azure AD) v2.0
+// endpoints. An endpoint names its tenant in the first path segment:
+// "common", "organizations", "consumers", a tenant ID or a verified domain.
+// The multi-tenant ones publish a templated issuer,
+// "https://login.microsoftonline.com/{tenantid}/v2.0", that an id_token
+// matches once {tenantid} is replaced by its tid claim.
+package azure
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Tenants of the multi-tenant endpoints.
+const (
+	// Common accepts work, school and personal Microsoft accounts.
+	Common = "common"
+	// Organizations accepts work and school accounts only.
+	Organizations = "organizations"
+	// Consumers accepts personal Microsoft accounts only.
+	Consumers = "consumers"
+)
+
+// ConsumersTenantID is the tenant ID of every personal Microsoft account.
+const ConsumersTenantID = "9188040d-6c67-4c5b-b112-36a304b66dad"
+
+// TenantPlaceholder stands for the tenant ID in a templated issuer.
+const TenantPlaceholder = "{tenantid}"
+
+// authorityHosts are the login hosts of the public and national clouds.
+var authorityHosts = map[string]bool{
+	"login.microsoftonline.com":        true,
+	"login.microsoftonline.us":         true,
+	"login.chinacloudapi.cn":           true,
+	"login.partner.microsoftonline.cn": true,
+}
+
+var (
+	tenantIDPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+	domainPattern   = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9-]*[a-zA-Z0-9])?(\.[a-zA-Z0-9]([a-zA-Z0-9-]*[a-zA-Z0-9])?)+$`)
+)
+
+// IsAuthority reports whether endpoint is on a Microsoft login host.
+func IsAuthority(endpoint string) bool {
+	u, err := url.Parse(strings.TrimSpace(endpoint))
+	return err == nil && authorityHosts[strings.ToLower(u.Hostname())]
+}
+
+// ValidTenant reports whether tenant can name an endpoint's tenant.
+func ValidTenant(tenant string) bool {
+	switch strings.ToLower(tenant) {
+	case Common, Organizations, Consumers:
+		return true
+	}
+	return tenantIDPattern.MatchString(tenant) || domainPattern.MatchString(tenant)
+}
+
+// Tenant returns the tenant endpoint names, or "" if it names none.
+func Tenant(endpoint string) string {
+	u, err := url.Parse(strings.TrimSpace(endpoint))
+	if err != nil {
+		return ""
+	}
+	segment, _, _ := strings.Cut(strings.TrimPrefix(u.Path, "/"), "/")
+	return segment
+}
+
+// WithTenant returns endpoint with its tenant replaced by tenant. Endpoints
+// that name no tenant are returned unchanged.
+func WithTenant(endpoint, tenant string) string {
+	u, err := url.Parse(strings.TrimSpace(endpoint))
+	if err != nil || tenant == "" {
+		return endpoint
+	}
+	segment, rest, found := strings.Cut(strings.TrimPrefix(u.Path, "/"), "/")
+	if segment == "" || !found {
+		return endpoint
+	}
+	u.Path = "/" + tenant + "/" + rest
+	u.RawPath = ""
+	return u.String()
+}
+
+// CheckIssuer validates the iss and tid claims of an id_token obtained from
+// tenant's endpoints, whose discovery document publishes issuer. A templated
+// issuer is matched with the token's tid in place of TenantPlaceholder.
+// Tokens from "organizations" must not be for a personal account, and
+// tokens from "consumers" must be.
+func CheckIssuer(issuer, tenant, iss, tid string) error {
+	if strings.Contains(issuer, TenantPlaceholder) {
+		if tid == "" {
+			return errors.New("id_token has no tid claim")
+		}
+		issuer = strings.ReplaceAll(issuer, TenantPlaceholder, tid)
+	}
+	if iss != issuer {
+		return fmt.Errorf("id_token issued by %q, not %q", iss, issuer)
+	}
+	switch strings.ToLower(tenant) {
+	case Organizations:
+		if strings.EqualFold(tid, ConsumersTenantID) {
+			return errors.New("id_token is for a personal account but only work and school accounts are allowed")
+		}
+	case Consumers:
+		if !strings.EqualFold(tid, ConsumersTenantID) {
+			return errors.New("id_token is for a work or school account but only personal accounts are allowed")
+		}
+	}
+	return nil
+}
+
+// ResolvedTenant returns the tenant a connection whose user is in tenant ID
+// tid authorizes against from then on. Personal accounts keep using the
+// consumers endpoints.
+func ResolvedTenant(tid string) string {
+	if strings.EqualFold(tid, ConsumersTenantID) {
+		return Consumers
+	}
+	return strings.ToLower(tid)
+}
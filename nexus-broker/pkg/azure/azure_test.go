@@ -0,0 +1,64 @@
+package azure
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const contosoTenantID = "72f988bf-86f1-41af-91ab-2d7cd011db47"
+
+func TestIsAuthority(t *testing.T) {
+	assert.True(t, IsAuthority("https://login.microsoftonline.com/common/oauth2/v2.0/authorize"))
+	assert.True(t, IsAuthority("https://login.microsoftonline.us/organizations/oauth2/v2.0/token"))
+	assert.False(t, IsAuthority("https://accounts.google.com/o/oauth2/v2/auth"))
+	assert.False(t, IsAuthority("https://login.microsoftonline.com.evil.example/common/oauth2/v2.0/authorize"))
+}
+
+func TestValidTenant(t *testing.T) {
+	for _, tenant := range []string{"common", "Organizations", "consumers", contosoTenantID, "contoso.onmicrosoft.com"} {
+		assert.True(t, ValidTenant(tenant), tenant)
+	}
+	for _, tenant := range []string{"", "contoso", "../common", "a/b", "common?x=1"} {
+		assert.False(t, ValidTenant(tenant), tenant)
+	}
+}
+
+func TestWithTenant(t *testing.T) {
+	assert.Equal(t,
+		"https://login.microsoftonline.com/"+contosoTenantID+"/oauth2/v2.0/token",
+		WithTenant("https://login.microsoftonline.com/common/oauth2/v2.0/token", contosoTenantID))
+	assert.Equal(t,
+		"https://login.microsoftonline.com/organizations/oauth2/v2.0/authorize?prompt=select_account",
+		WithTenant("https://login.microsoftonline.com/common/oauth2/v2.0/authorize?prompt=select_account", "organizations"))
+	assert.Equal(t, "https://login.microsoftonline.com/common/oauth2/v2.0/token",
+		WithTenant("https://login.microsoftonline.com/common/oauth2/v2.0/token", ""))
+	assert.Equal(t, "https://login.microsoftonline.com/", WithTenant("https://login.microsoftonline.com/", "consumers"))
+	assert.Equal(t, "organizations", Tenant(WithTenant("https://login.microsoftonline.com/common/oauth2/v2.0/token", "organizations")))
+}
+
+func TestCheckIssuer(t *testing.T) {
+	templated := "https://login.microsoftonline.com/{tenantid}/v2.0"
+	contoso := "https://login.microsoftonline.com/" + contosoTenantID + "/v2.0"
+	consumers := "https://login.microsoftonline.com/" + ConsumersTenantID + "/v2.0"
+
+	assert.NoError(t, CheckIssuer(templated, "common", contoso, contosoTenantID))
+	assert.NoError(t, CheckIssuer(templated, "common", consumers, ConsumersTenantID))
+	assert.NoError(t, CheckIssuer(contoso, contosoTenantID, contoso, contosoTenantID))
+
+	// The issuer must be the tenant the token is for.
+	assert.Error(t, CheckIssuer(templated, "common", contoso, ConsumersTenantID))
+	assert.Error(t, CheckIssuer(templated, "common", contoso, ""))
+	assert.Error(t, CheckIssuer(contoso, contosoTenantID, consumers, ConsumersTenantID))
+
+	// organizations and consumers restrict the kind of account.
+	assert.Error(t, CheckIssuer(templated, "organizations", consumers, ConsumersTenantID))
+	assert.NoError(t, CheckIssuer(templated, "organizations", contoso, contosoTenantID))
+	assert.Error(t, CheckIssuer(templated, "consumers", contoso, contosoTenantID))
+	assert.NoError(t, CheckIssuer(consumers, "consumers", consumers, ConsumersTenantID))
+}
+
+func TestResolvedTenant(t *testing.T) {
+	assert.Equal(t, contosoTenantID, ResolvedTenant(contosoTenantID))
+	assert.Equal(t, Consumers, ResolvedTenant(ConsumersTenantID))
+}
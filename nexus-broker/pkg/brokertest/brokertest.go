@@ -0,0 +1,145 @@
+// Package brokertest runs a Broker in-process for integration tests of the
+// services around it. It serves the production router (see pkg/router),
+// middleware included, but keeps its providers, connections and tokens in
+// memory, so it needs neither Postgres nor Redis.
+package brokertest
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/internal/store"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/config"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/provider"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/router"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/server"
+	"github.com/Prescott-Data/nexus-framework/nexus-common/state"
+)
+
+// Config configures the Broker. Zero fields take the defaults noted.
+type Config struct {
+	// BaseURL is where browsers reach the Broker, and the base of the OAuth
+	// redirect URI (the server's URL). Set it to the Gateway's URL when
+	// the Gateway proxies /auth/callback.
+	BaseURL string
+	// APIKey, when set, is required in X-API-Key on the routes the
+	// Gateway calls, as API_KEYS requires it in production.
+	APIKey string
+	// States signs and verifies the OAuth state (a random key). Share it
+	// with the Gateway, which verifies the states the Broker issues.
+	States *state.Keyring
+}
+
+// Provider is a provider profile to seed the Broker with.
+type Provider struct {
+	Name         string
+	AuthType     string // "oauth2" when empty
+	AuthURL      string
+	TokenURL     string
+	ClientID     string
+	ClientSecret string
+	UserInfoURL  string
+	Scopes       []string
+	// Params are the profile's params, e.g. {"token_max_retries":0}.
+	Params json.RawMessage
+	// WorkspaceIDs restricts the provider to those workspaces; empty makes
+	// it global.
+	WorkspaceIDs []string
+}
+
+// Broker is a running in-process Broker.
+type Broker struct {
+	// URL is the server's base URL.
+	URL string
+	// States is the keyring the Broker signs state with.
+	States *state.Keyring
+
+	store    *store.Memory
+	profiles *profiles
+}
+
+// New starts a Broker that is closed when t ends.
+func New(t testing.TB, cfg Config) *Broker {
+	t.Helper()
+	if cfg.States == nil {
+		key := make([]byte, 32)
+		rand.Read(key)
+		states, err := state.NewKeyring(state.Key{Secret: key}, nil)
+		if err != nil {
+			t.Fatalf("brokertest: %v", err)
+		}
+		cfg.States = states
+	}
+	encryptionKey := make([]byte, 32)
+	rand.Read(encryptionKey)
+
+	srv := server.NewServer("", 0)
+	ts := httptest.NewServer(srv.Router())
+	t.Cleanup(ts.Close)
+	if cfg.BaseURL == "" {
+		cfg.BaseURL = ts.URL
+	}
+
+	b := &Broker{URL: ts.URL, States: cfg.States, store: store.NewMemory(), profiles: newProfiles()}
+	_, err := router.New(srv, &config.BrokerConfig{
+		BaseURL:            cfg.BaseURL,
+		RedirectPath:       "/auth/callback",
+		EncryptionKey:      encryptionKey,
+		StateKeys:          cfg.States,
+		RequireAPIKey:      cfg.APIKey != "",
+		APIKeys:            map[string]struct{}{cfg.APIKey: {}},
+		HealthCheckTimeout: 2 * time.Second,
+	}, router.Deps{
+		Store:    b.store,
+		Profiles: b.profiles,
+		Build:    server.BuildInfo{Version: "brokertest"},
+	})
+	if err != nil {
+		t.Fatalf("brokertest: %v", err)
+	}
+	return b
+}
+
+// AddProvider stores p and returns its ID. Consents name it by this ID, or
+// through the Gateway by p.Name.
+func (b *Broker) AddProvider(p Provider) string {
+	if p.AuthType == "" {
+		p.AuthType = "oauth2"
+	}
+	var params *json.RawMessage
+	if len(p.Params) > 0 {
+		params = &p.Params
+	}
+	id := uuid.New()
+	b.store.PutProvider(store.Provider{
+		ID:               id,
+		Name:             p.Name,
+		AuthType:         p.AuthType,
+		AuthURL:          p.AuthURL,
+		TokenURL:         p.TokenURL,
+		ClientID:         p.ClientID,
+		ClientSecret:     p.ClientSecret,
+		UserInfoEndpoint: p.UserInfoURL,
+		Scopes:           p.Scopes,
+		Params:           params,
+	}, p.WorkspaceIDs...)
+	b.profiles.put(provider.Profile{
+		ID:               id,
+		Name:             p.Name,
+		AuthType:         p.AuthType,
+		AuthURL:          &p.AuthURL,
+		TokenURL:         &p.TokenURL,
+		ClientID:         &p.ClientID,
+		UserInfoEndpoint: p.UserInfoURL,
+		Scopes:           p.Scopes,
+		Params:           params,
+		WorkspaceIDs:     p.WorkspaceIDs,
+		UpdatedAt:        time.Now(),
+	})
+	return id.String()
+}
@@ -0,0 +1,132 @@
+package brokertest
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/provider"
+)
+
+// errReadOnly answers the provider management routes: a test seeds its
+// providers with Broker.AddProvider.
+var errReadOnly = errors.New("brokertest: providers are added with AddProvider")
+
+// profiles is an in-memory provider registry serving the read routes of
+// /providers.
+type profiles struct {
+	mu   sync.Mutex
+	byID map[uuid.UUID]provider.Profile
+}
+
+func newProfiles() *profiles {
+	return &profiles{byID: map[uuid.UUID]provider.Profile{}}
+}
+
+func (s *profiles) put(p provider.Profile) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.byID[p.ID] = p
+}
+
+func (s *profiles) GetProfile(id uuid.UUID) (*provider.Profile, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.byID[id]
+	if !ok {
+		return nil, provider.ErrNotFound
+	}
+	return &p, nil
+}
+
+func (s *profiles) GetProfileByName(name string) (*provider.Profile, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, p := range s.byID {
+		if strings.EqualFold(p.Name, name) {
+			return &p, nil
+		}
+	}
+	return nil, fmt.Errorf("provider '%s' not found", name)
+}
+
+func (s *profiles) ListProfiles(workspaceID string) ([]provider.ProfileList, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []provider.ProfileList
+	for _, p := range s.sorted() {
+		if p.VisibleTo(workspaceID) {
+			out = append(out, provider.ProfileList{ID: p.ID.String(), Name: p.Name})
+		}
+	}
+	return out, nil
+}
+
+func (s *profiles) ListProfilesWithDeleted(workspaceID string) ([]provider.ProfileList, error) {
+	return s.ListProfiles(workspaceID)
+}
+
+func (s *profiles) GetMetadata(workspaceID string) (map[string]map[string]provider.Metadata, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	result := make(map[string]map[string]provider.Metadata)
+	for _, p := range s.sorted() {
+		if !p.VisibleTo(workspaceID) {
+			continue
+		}
+		if result[p.AuthType] == nil {
+			result[p.AuthType] = make(map[string]provider.Metadata)
+		}
+		result[p.AuthType][p.Name] = provider.Metadata{
+			ID:               p.ID.String(),
+			APIBaseURL:       p.APIBaseURL,
+			UserInfoEndpoint: p.UserInfoEndpoint,
+			Scopes:           p.Scopes,
+			Description:      p.Description,
+			Category:         p.Category,
+		}
+	}
+	return result, nil
+}
+
+// sorted returns the profiles by name. s.mu must be held.
+func (s *profiles) sorted() []provider.Profile {
+	out := make([]provider.Profile, 0, len(s.byID))
+	for _, p := range s.byID {
+		out = append(out, p)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+func (s *profiles) GetRegistration(uuid.UUID) (*provider.Registration, error) {
+	return nil, provider.ErrNotFound
+}
+
+func (s *profiles) RegisterProfile(string) (*provider.Profile, error) { return nil, errReadOnly }
+
+func (s *profiles) RegisterProfileWithClient(string, *provider.Registration) (*provider.Profile, error) {
+	return nil, errReadOnly
+}
+
+func (s *profiles) UpdateProfile(*provider.Profile, string) error { return errReadOnly }
+
+func (s *profiles) PatchProfile(uuid.UUID, map[string]interface{}, string) (*provider.Profile, error) {
+	return nil, errReadOnly
+}
+
+func (s *profiles) DeleteProfile(uuid.UUID) error { return errReadOnly }
+
+func (s *profiles) DeleteProfileByName(string) (int64, error) { return 0, errReadOnly }
+
+func (s *profiles) RestoreProfile(uuid.UUID) error { return errReadOnly }
+
+func (s *profiles) PurgeProfile(uuid.UUID) (int64, error) { return 0, errReadOnly }
+
+func (s *profiles) RotateRegistration(*provider.Registration, string, string) error {
+	return errReadOnly
+}
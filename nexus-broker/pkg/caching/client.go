@@ -3,19 +3,132 @@ package caching
 import (
 	"bufio"
 	"bytes"
-	"io"
+	"context"
+	"log"
 	"net/http"
 	"net/http/httputil"
+	"sync"
 	"time"
 
 	"github.com/go-redis/redis/v8"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
-// cachingTransport is an http.RoundTripper that caches responses in Redis.
+// keyPrefix namespaces cached responses in Redis.
+const keyPrefix = "http:"
+
+// redisRetryInterval is how long the transport stops calling Redis after a
+// Redis error, so an outage costs one dial timeout per interval rather than
+// one per request.
+const redisRetryInterval = 10 * time.Second
+
+// Cache tiers, as reported in the tier label of http_cache_lookups_total.
+const (
+	tierLocal    = "local"
+	tierRedis    = "redis"
+	tierFallback = "fallback"
+)
+
+var (
+	cacheLookups = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_cache_lookups_total",
+		Help: "Cached HTTP response lookups by tier and result",
+	}, []string{"tier", "result"})
+	cacheFetches = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_cache_upstream_fetches_total",
+		Help: "Cache misses by whether they fetched upstream or shared a concurrent fetch",
+	}, []string{"result"})
+)
+
+func init() {
+	prometheus.MustRegister(cacheLookups, cacheFetches)
+}
+
+func observeLookup(tier string, hit bool) {
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	cacheLookups.WithLabelValues(tier, result).Inc()
+}
+
+// cachingTransport is an http.RoundTripper that caches responses in Redis,
+// optionally behind a short-lived process-local tier, and in fallback (when
+// set) while Redis is unreachable. Concurrent misses for the same URL share
+// one upstream request.
 type cachingTransport struct {
-	redisClient *redis.Client
+	redisClient redis.UniversalClient
+	local       *MemoryCache
+	localTTL    time.Duration
+	fallback    *MemoryCache
 	transport   http.RoundTripper
 	ttl         time.Duration
+	flight      flightGroup
+
+	mu        sync.Mutex
+	downUntil time.Time
+}
+
+func (t *cachingTransport) redisUp() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return time.Now().After(t.downUntil)
+}
+
+func (t *cachingTransport) markRedisDown(err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if time.Now().After(t.downUntil) {
+		log.Printf("caching: redis unavailable, retrying in %s: %v", redisRetryInterval, err)
+	}
+	t.downUntil = time.Now().Add(redisRetryInterval)
+}
+
+func (t *cachingTransport) get(ctx context.Context, key string) ([]byte, bool) {
+	if t.local != nil {
+		b, ok := t.local.Get(key)
+		observeLookup(tierLocal, ok)
+		if ok {
+			return b, true
+		}
+	}
+	if t.redisUp() {
+		b, err := t.redisClient.Get(ctx, key).Bytes()
+		if err == nil {
+			observeLookup(tierRedis, true)
+			if t.local != nil {
+				t.local.Set(key, b, t.localTTL)
+			}
+			return b, true
+		}
+		if err == redis.Nil {
+			observeLookup(tierRedis, false)
+			return nil, false
+		}
+		t.markRedisDown(err)
+	}
+	if t.fallback != nil {
+		b, ok := t.fallback.Get(key)
+		observeLookup(tierFallback, ok)
+		return b, ok
+	}
+	return nil, false
+}
+
+func (t *cachingTransport) set(ctx context.Context, key string, value []byte) {
+	if t.local != nil {
+		t.local.Set(key, value, t.localTTL)
+	}
+	if t.redisUp() {
+		err := t.redisClient.Set(ctx, key, value, t.ttl).Err()
+		if err == nil {
+			return
+		}
+		t.markRedisDown(err)
+	}
+	if t.fallback != nil {
+		t.fallback.Set(key, value, t.ttl)
+	}
 }
 
 // RoundTrip implements the http.RoundTripper interface.
@@ -24,53 +137,135 @@ func (t *cachingTransport) RoundTrip(req *http.Request) (*http.Response, error)
 		return t.transport.RoundTrip(req)
 	}
 
-	cacheKey := "http:" + req.URL.String()
+	cacheKey := keyPrefix + req.URL.String()
 
 	// Try to get the response from cache
-	cached, err := t.redisClient.Get(req.Context(), cacheKey).Bytes()
-	if err == nil {
+	if cached, ok := t.get(req.Context(), cacheKey); ok {
 		// Cache hit
 		b := bytes.NewBuffer(cached)
 		return http.ReadResponse(bufio.NewReader(b), req)
 	}
 
-	// Cache miss, call the real transport
-	resp, err := t.transport.RoundTrip(req)
+	// Cache miss, call the real transport unless a concurrent request for
+	// the same URL already is
+	dump, err, shared := t.flight.do(cacheKey, func() ([]byte, error) {
+		return t.fetch(req, cacheKey)
+	})
+	if shared {
+		cacheFetches.WithLabelValues("shared").Inc()
+	} else {
+		cacheFetches.WithLabelValues("fetched").Inc()
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	// Dump the response to bytes
-	dump, err := httputil.DumpResponse(resp, true)
+	// Each caller gets its own response read from the dump
+	b := bytes.NewBuffer(dump)
+	newResp, err := http.ReadResponse(bufio.NewReader(b), req)
 	if err != nil {
 		return nil, err
 	}
 
-	// Save the response to cache
-	err = t.redisClient.Set(req.Context(), cacheKey, dump, t.ttl).Err()
+	return newResp, nil
+}
+
+// fetch performs req upstream and caches the dumped response under key.
+func (t *cachingTransport) fetch(req *http.Request, key string) ([]byte, error) {
+	resp, err := t.transport.RoundTrip(req)
 	if err != nil {
-		// Log the error but don't fail the request
+		return nil, err
 	}
+	defer resp.Body.Close()
 
-	// Since DumpResponse consumes the body, we need to create a new one
-	resp.Body = io.NopCloser(bytes.NewBuffer(dump))
-	// We need to re-read the response to get the body back
-	b := bytes.NewBuffer(dump)
-	newResp, err := http.ReadResponse(bufio.NewReader(b), req)
+	// Dump the response to bytes
+	dump, err := httputil.DumpResponse(resp, true)
 	if err != nil {
 		return nil, err
 	}
 
-	return newResp, nil
+	// Save the response to cache; a cache failure never fails the request
+	t.set(req.Context(), key, dump)
+	return dump, nil
 }
 
 // NewCachingClient returns a new http.Client configured with the cachingTransport.
-func NewCachingClient(redisClient *redis.Client, cacheTTL time.Duration) *http.Client {
-	return &http.Client{
-		Transport: &cachingTransport{
-			redisClient: redisClient,
-			transport:   http.DefaultTransport,
-			ttl:         cacheTTL,
-		},
+func NewCachingClient(redisClient redis.UniversalClient, cacheTTL time.Duration) *http.Client {
+	return &http.Client{Transport: NewTransport(redisClient, http.DefaultTransport, cacheTTL)}
+}
+
+// NewTransport wraps base so GET responses are cached in Redis for cacheTTL.
+// While Redis is unreachable requests go straight to base.
+func NewTransport(redisClient redis.UniversalClient, base http.RoundTripper, cacheTTL time.Duration) http.RoundTripper {
+	return NewTransportWithFallback(redisClient, nil, base, cacheTTL)
+}
+
+// NewTransportWithFallback is NewTransport, except that while Redis is
+// unreachable responses are cached in fallback instead.
+func NewTransportWithFallback(redisClient redis.UniversalClient, fallback *MemoryCache, base http.RoundTripper, cacheTTL time.Duration) http.RoundTripper {
+	return NewTieredTransport(TransportConfig{Redis: redisClient, Fallback: fallback, TTL: cacheTTL}, base)
+}
+
+// TransportConfig configures NewTieredTransport.
+type TransportConfig struct {
+	Redis redis.UniversalClient
+	// TTL is how long responses are cached in Redis and Fallback.
+	TTL time.Duration
+	// Local, when set, is consulted before Redis and holds responses for
+	// LocalTTL. Keep LocalTTL short: entries are not shared between
+	// replicas and outlive a Redis invalidation until they expire or Local
+	// is purged.
+	Local    *MemoryCache
+	LocalTTL time.Duration
+	// Fallback, when set, caches responses while Redis is unreachable.
+	Fallback *MemoryCache
+}
+
+// NewTieredTransport wraps base so GET responses are cached in a local LRU,
+// then Redis, then (while Redis is down) a fallback LRU, per cfg.
+func NewTieredTransport(cfg TransportConfig, base http.RoundTripper) http.RoundTripper {
+	t := &cachingTransport{
+		redisClient: cfg.Redis,
+		localTTL:    cfg.LocalTTL,
+		fallback:    cfg.Fallback,
+		transport:   base,
+		ttl:         cfg.TTL,
+	}
+	if cfg.LocalTTL > 0 {
+		t.local = cfg.Local
+	}
+	return t
+}
+
+// Invalidate deletes every cached response (OIDC discovery documents, JWKS)
+// so the next request goes to the upstream. On a cluster every master is
+// scanned. It returns the number of keys removed.
+func Invalidate(ctx context.Context, redisClient redis.UniversalClient) (int, error) {
+	cluster, ok := redisClient.(*redis.ClusterClient)
+	if !ok {
+		return invalidateNode(ctx, redisClient)
+	}
+	var mu sync.Mutex
+	removed := 0
+	err := cluster.ForEachMaster(ctx, func(ctx context.Context, node *redis.Client) error {
+		n, err := invalidateNode(ctx, node)
+		mu.Lock()
+		removed += n
+		mu.Unlock()
+		return err
+	})
+	return removed, err
+}
+
+func invalidateNode(ctx context.Context, redisClient redis.UniversalClient) (int, error) {
+	removed := 0
+	iter := redisClient.Scan(ctx, 0, keyPrefix+"*", 100).Iterator()
+	for iter.Next(ctx) {
+		n, err := redisClient.Del(ctx, iter.Val()).Result()
+		if err != nil {
+			return removed, err
+		}
+		removed += int(n)
 	}
+	return removed, iter.Err()
 }
@@ -1,14 +1,18 @@
 package caching
 
 import (
+	"context"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/alicebob/miniredis/v2"
 	"github.com/go-redis/redis/v8"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -109,4 +113,132 @@ func TestCachingClient_PostRequest(t *testing.T) {
 	// Check that nothing was cached
 	keys := mr.Keys()
 	assert.Empty(t, keys, "cache should be empty for non-GET request")
-}
\ No newline at end of file
+}
+func TestInvalidate_RemovesCachedResponses(t *testing.T) {
+	mr, err := miniredis.Run()
+	assert.NoError(t, err)
+	defer mr.Close()
+
+	redisClient := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	assert.NoError(t, mr.Set("http:https://issuer/.well-known/openid-configuration", "cached"))
+	assert.NoError(t, mr.Set("http:https://issuer/jwks", "cached"))
+	assert.NoError(t, mr.Set("unrelated", "keep"))
+
+	n, err := Invalidate(context.Background(), redisClient)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.False(t, mr.Exists("http:https://issuer/jwks"))
+	assert.True(t, mr.Exists("unrelated"), "keys outside the cache prefix must survive")
+}
+
+func TestCachingClient_FallsBackToMemoryWhenRedisDown(t *testing.T) {
+	mr, err := miniredis.Run()
+	assert.NoError(t, err)
+	redisClient := redis.NewClient(&redis.Options{Addr: mr.Addr(), MaxRetries: -1})
+	mr.Close()
+
+	calls := 0
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Write([]byte("jwks"))
+	}))
+	defer upstream.Close()
+
+	fallback := NewMemoryCache(10)
+	client := &http.Client{Transport: NewTransportWithFallback(redisClient, fallback, http.DefaultTransport, time.Minute)}
+	for i := 0; i < 2; i++ {
+		resp, err := client.Get(upstream.URL)
+		assert.NoError(t, err)
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		assert.Equal(t, "jwks", string(body))
+	}
+	assert.Equal(t, 1, calls, "second request should be served from the in-memory cache")
+	assert.Equal(t, 1, fallback.Len())
+}
+
+func TestCachingClient_LocalTierServesBeforeRedis(t *testing.T) {
+	mr, err := miniredis.Run()
+	assert.NoError(t, err)
+	defer mr.Close()
+	redisClient := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("discovery"))
+	}))
+	defer upstream.Close()
+
+	local := NewMemoryCache(10)
+	client := &http.Client{Transport: NewTieredTransport(TransportConfig{
+		Redis: redisClient, TTL: time.Minute, Local: local, LocalTTL: time.Second,
+	}, http.DefaultTransport)}
+
+	resp, err := client.Get(upstream.URL)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, 1, local.Len())
+
+	// With Redis emptied, the local tier still answers.
+	mr.FlushAll()
+	before := testutil.ToFloat64(cacheLookups.WithLabelValues(tierLocal, "hit"))
+	resp, err = client.Get(upstream.URL)
+	assert.NoError(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, "discovery", string(body))
+	assert.Equal(t, before+1, testutil.ToFloat64(cacheLookups.WithLabelValues(tierLocal, "hit")))
+	assert.False(t, mr.Exists("http:"+upstream.URL), "a local hit should not touch Redis")
+
+	// Once the local entry is gone, Redis is consulted and refills it.
+	local.Purge()
+	mr.Set("http:"+upstream.URL, "HTTP/1.1 200 OK\r\nContent-Length: 6\r\n\r\ncached")
+	resp, err = client.Get(upstream.URL)
+	assert.NoError(t, err)
+	body, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, "cached", string(body))
+	assert.Equal(t, 1, local.Len())
+}
+
+func TestCachingClient_ConcurrentMissesShareOneFetch(t *testing.T) {
+	mr, err := miniredis.Run()
+	assert.NoError(t, err)
+	defer mr.Close()
+	redisClient := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+
+	var calls int32
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		w.Write([]byte("jwks"))
+	}))
+	defer upstream.Close()
+
+	client := NewCachingClient(redisClient, time.Minute)
+	const n = 10
+	var wg sync.WaitGroup
+	bodies := make([]string, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			resp, err := client.Get(upstream.URL)
+			if !assert.NoError(t, err) {
+				return
+			}
+			b, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			bodies[i] = string(b)
+		}(i)
+	}
+	// Let the requests pile up behind the first upstream call.
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	for _, b := range bodies {
+		assert.Equal(t, "jwks", b)
+	}
+}
@@ -0,0 +1,87 @@
+package caching
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// MemoryCache is a bounded, in-process LRU of cached responses. The caching
+// transport falls back to it while Redis is unreachable. It is safe for
+// concurrent use.
+type MemoryCache struct {
+	mu    sync.Mutex
+	size  int
+	ll    *list.List
+	items map[string]*list.Element
+	now   func() time.Time
+}
+
+type memoryEntry struct {
+	key     string
+	value   []byte
+	expires time.Time
+}
+
+// NewMemoryCache creates a MemoryCache holding at most size entries.
+func NewMemoryCache(size int) *MemoryCache {
+	if size < 1 {
+		size = 1
+	}
+	return &MemoryCache{size: size, ll: list.New(), items: map[string]*list.Element{}, now: time.Now}
+}
+
+// Get returns the value stored under key if it has not expired.
+func (c *MemoryCache) Get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	e := el.Value.(*memoryEntry)
+	if !c.now().Before(e.expires) {
+		c.ll.Remove(el)
+		delete(c.items, key)
+		return nil, false
+	}
+	c.ll.MoveToFront(el)
+	return e.value, true
+}
+
+// Set stores value under key for ttl, evicting the least recently used
+// entry when the cache is full.
+func (c *MemoryCache) Set(key string, value []byte, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	expires := c.now().Add(ttl)
+	if el, ok := c.items[key]; ok {
+		e := el.Value.(*memoryEntry)
+		e.value, e.expires = value, expires
+		c.ll.MoveToFront(el)
+		return
+	}
+	c.items[key] = c.ll.PushFront(&memoryEntry{key: key, value: value, expires: expires})
+	for c.ll.Len() > c.size {
+		oldest := c.ll.Back()
+		c.ll.Remove(oldest)
+		delete(c.items, oldest.Value.(*memoryEntry).key)
+	}
+}
+
+// Purge removes every entry and returns how many there were.
+func (c *MemoryCache) Purge() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := c.ll.Len()
+	c.ll.Init()
+	c.items = map[string]*list.Element{}
+	return n
+}
+
+// Len returns the number of entries, including expired ones not yet evicted.
+func (c *MemoryCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ll.Len()
+}
@@ -0,0 +1,39 @@
+package caching
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryCache_EvictsLeastRecentlyUsed(t *testing.T) {
+	c := NewMemoryCache(2)
+	c.Set("a", []byte("1"), time.Minute)
+	c.Set("b", []byte("2"), time.Minute)
+	_, _ = c.Get("a")
+	c.Set("c", []byte("3"), time.Minute)
+
+	_, ok := c.Get("b")
+	assert.False(t, ok, "b was least recently used and should be evicted")
+	v, ok := c.Get("a")
+	assert.True(t, ok)
+	assert.Equal(t, "1", string(v))
+	assert.Equal(t, 2, c.Len())
+	assert.Equal(t, 2, c.Purge())
+	assert.Equal(t, 0, c.Len())
+}
+
+func TestMemoryCache_Expiry(t *testing.T) {
+	now := time.Now()
+	c := NewMemoryCache(10)
+	c.now = func() time.Time { return now }
+	c.Set("a", []byte("1"), time.Minute)
+
+	_, ok := c.Get("a")
+	assert.True(t, ok)
+	now = now.Add(time.Minute)
+	_, ok = c.Get("a")
+	assert.False(t, ok, "entry should expire after its ttl")
+	assert.Equal(t, 0, c.Len())
+}
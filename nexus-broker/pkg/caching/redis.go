@@ -0,0 +1,34 @@
+package caching
+
+import (
+	"fmt"
+
+	"github.com/go-redis/redis/v8"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/config"
+)
+
+// NewRedisClient creates a client for the topology in cfg: a plain client
+// from cfg.URL, a Sentinel-managed failover client, or a cluster client. No
+// connection is made until the first command.
+func NewRedisClient(cfg config.RedisConfig) (redis.UniversalClient, error) {
+	switch cfg.Mode {
+	case config.RedisSentinel:
+		return redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:    cfg.SentinelMaster,
+			SentinelAddrs: cfg.Addrs,
+			Password:      cfg.Password,
+		}), nil
+	case config.RedisCluster:
+		return redis.NewClusterClient(&redis.ClusterOptions{
+			Addrs:    cfg.Addrs,
+			Password: cfg.Password,
+		}), nil
+	default:
+		opts, err := redis.ParseURL(cfg.URL)
+		if err != nil {
+			return nil, fmt.Errorf("parse REDIS_URL: %w", err)
+		}
+		return redis.NewClient(opts), nil
+	}
+}
@@ -0,0 +1,27 @@
+package caching
+
+import (
+	"testing"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/config"
+)
+
+func TestNewRedisClient_Topologies(t *testing.T) {
+	c, err := NewRedisClient(config.RedisConfig{Mode: config.RedisStandalone, URL: "redis://localhost:6379/2"})
+	assert.NoError(t, err)
+	assert.IsType(t, &redis.Client{}, c)
+
+	c, err = NewRedisClient(config.RedisConfig{Mode: config.RedisSentinel, Addrs: []string{"s1:26379"}, SentinelMaster: "mymaster"})
+	assert.NoError(t, err)
+	assert.IsType(t, &redis.Client{}, c, "sentinel mode uses a failover client")
+
+	c, err = NewRedisClient(config.RedisConfig{Mode: config.RedisCluster, Addrs: []string{"n1:6379", "n2:6379"}})
+	assert.NoError(t, err)
+	assert.IsType(t, &redis.ClusterClient{}, c)
+
+	_, err = NewRedisClient(config.RedisConfig{Mode: config.RedisStandalone, URL: "http://not-redis"})
+	assert.Error(t, err)
+}
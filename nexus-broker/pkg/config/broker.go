@@ -2,89 +2,429 @@ package config
 
 import (
 	"fmt"
+	"net"
 	"net/url"
 	"os"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-common/state"
 )
 
 // BrokerConfig holds all configuration for the nexus-broker service.
-// Populated once at startup from environment variables, then passed to all
-// subsystems. No other package should read os.Getenv directly.
+// Populated once at startup from an optional YAML file overridden by
+// environment variables, then passed to all subsystems. No other package
+// should read os.Getenv directly.
 type BrokerConfig struct {
 	Port        string
 	DatabaseURL string
 	BaseURL     string
-	RedisURL    string
+
+	// Listen port of the gRPC BrokerService; empty serves REST only
+	GRPCPort string
+
+	// Redis topology and cache degradation
+	Redis RedisConfig
 
 	EncryptionKey []byte
 	StateKey      []byte
 
+	// Signs OAuth state with StateKey and also accepts the other keys
+	// configured for a rotation
+	StateKeys *state.Keyring
+
+	// Reject token ciphertexts not bound to their connection (set after
+	// running cmd/migrate-token-aad)
+	RequireBoundTokens bool
+
 	RedirectPath string
 
 	// API key protection
 	RequireAPIKey bool
 	APIKeys       map[string]struct{}
 
-	// CIDR allowlist
+	// Operator access: admin key and optional pprof/runtime diagnostics
+	AdminAPIKey          string
+	EnableDebugEndpoints bool
+
+	// Serve the OpenAPI document at /openapi.json and Swagger UI at /docs
+	EnableAPIDocs bool
+
+	// Client IP allowlist. Entries are CIDRs or bare IPs. APIKeyCIDRs
+	// restricts individual API keys to their own ranges.
 	RequireAllowlist bool
-	AllowedCIDRs     string
+	AllowedCIDRs     []string
+	APIKeyCIDRs      map[string][]string
+
+	// TrustedProxies is how many reverse proxies in front of the broker
+	// append to X-Forwarded-For; the client IP is read past them.
+	TrustedProxies int
 
 	// Return URL enforcement
 	EnforceReturnURL     bool
 	AllowedReturnDomains []string
 
+	// PagesDir overrides the templates and message catalogs of the pages
+	// shown to end users. Empty serves the embedded ones.
+	PagesDir string
+
 	// DB SSL enforcement
 	EnforceDBSSL  bool
 	DBSSLMode     string
 	DBSSLRootCert string
+
+	// Optional read replica for lookups that tolerate replication lag
+	DatabaseReadURL string
+
+	// Pool sizing and statement timeout applied to every Postgres pool
+	DBPool DBPoolConfig
+
+	// Apply pending schema migrations on startup
+	AutoMigrate bool
+
+	// Interval between scheduled provider health audits; zero disables them
+	ProviderAuditInterval time.Duration
+
+	// Periodic deletion of finished connections and old audit events
+	Retention RetentionConfig
+
+	// How often buffered per-connection token usage is written to
+	// Postgres; zero writes every retrieval through
+	UsageFlushInterval time.Duration
+
+	// Default per-workspace quotas; the admin API overrides them per
+	// workspace
+	WorkspaceLimits WorkspaceLimitConfig
+
+	// Per-host connection limits for the shared provider transport pool
+	ProviderMaxConnsPerHost     int
+	ProviderMaxIdleConnsPerHost int
+
+	// Timeout and retries for provider token endpoint calls
+	TokenRequests TokenRequestConfig
+
+	// Background retries of failed refreshes before a connection is
+	// marked needs_reauth
+	RefreshRetry RefreshRetryConfig
+
+	// Outbound proxy and destination allowlist for provider calls
+	Egress EgressConfig
+
+	// Lifecycle event webhook; empty URL disables it
+	WebhookURL    string
+	WebhookSecret string
+
+	// External destinations audit events are shipped to
+	AuditSinks AuditSinkConfig
+
+	// Rules that flag suspicious audit event patterns
+	Anomaly AnomalyConfig
+
+	// Readiness probe timeout applied to each dependency check
+	HealthCheckTimeout time.Duration
+
+	// Graceful shutdown: how long readiness reports draining before the
+	// listener closes, and how long in-flight requests may take to finish.
+	ShutdownDrainDelay time.Duration
+	ShutdownTimeout    time.Duration
+}
+
+// RetentionConfig controls the retention sweep run by the broker and by
+// cmd/cleanup. A zero Interval disables the periodic sweep; a zero TTL keeps
+// those rows forever.
+type RetentionConfig struct {
+	Interval           time.Duration
+	FailedConnections  time.Duration
+	ExpiredConnections time.Duration
+	AuditEvents        time.Duration
+}
+
+// WorkspaceLimitConfig holds the limits every workspace gets unless the
+// admin API overrides them for it. Zero means unlimited.
+type WorkspaceLimitConfig struct {
+	// MaxPendingConnections caps unexpired consents in progress.
+	MaxPendingConnections int
+	// MaxActiveConnections caps active connections; consent-spec is
+	// refused once it is reached.
+	MaxActiveConnections int
+	// MaxRefreshesPerMinute caps POST /connections/{id}/refresh calls.
+	MaxRefreshesPerMinute int
 }
 
-// Load reads all configuration from environment variables, validates required
-// fields, and returns a fully populated BrokerConfig or a fatal error.
+// TokenRequestConfig bounds calls to provider token endpoints. A provider
+// may override Timeout and MaxRetries with its token_timeout and
+// token_max_retries params.
+type TokenRequestConfig struct {
+	// Timeout bounds each attempt.
+	Timeout time.Duration
+	// MaxRetries is how many times a failed attempt is retried (see
+	// handlers.sendTokenRequest for which failures qualify).
+	MaxRetries int
+	// Backoff is the base of the jittered exponential delay between
+	// attempts.
+	Backoff time.Duration
+}
+
+// RefreshRetryConfig controls how refreshes that failed for a transient
+// reason (network error, provider 5xx or rate limit) are retried in the
+// background.
+type RefreshRetryConfig struct {
+	// Interval is how often due retries run. Zero disables the background
+	// retries; failures are still recorded and can be retried through the
+	// admin API.
+	Interval time.Duration
+	// MaxAttempts is how many failures in a row mark the connection
+	// needs_reauth. Zero never gives up.
+	MaxAttempts int
+	// Backoff is the delay before the first retry, doubled for each later
+	// one up to an hour.
+	Backoff time.Duration
+}
+
+// EgressConfig controls how the broker reaches identity providers.
+type EgressConfig struct {
+	// ProxyURL is the proxy for all provider traffic. Empty uses
+	// HTTP_PROXY, HTTPS_PROXY and NO_PROXY from the environment.
+	ProxyURL string
+	// Enforce restricts provider calls to the hosts of registered provider
+	// endpoints plus AllowedHosts.
+	Enforce bool
+	// AllowedHosts are further hosts, or "*.domain" wildcards, allowed
+	// when Enforce is set, e.g. JWKS hosts known only from discovery.
+	AllowedHosts []string
+	// AllowPrivateNetworks lets provider URLs and connections use
+	// loopback, private and link-local addresses. Off by default to
+	// prevent SSRF through provider URLs.
+	AllowPrivateNetworks bool
+	// AllowedCIDRs exempt specific ranges from the private address block,
+	// e.g. an on-premises identity provider.
+	AllowedCIDRs []string
+}
+
+// AuditSinkConfig selects the external systems audit events are shipped to
+// and how they are buffered. Each sink is enabled by its address.
+type AuditSinkConfig struct {
+	WebhookURL    string
+	WebhookSecret string
+
+	// KafkaRESTURL is a Kafka REST Proxy; events are produced to KafkaTopic.
+	KafkaRESTURL  string
+	KafkaTopic    string
+	KafkaUsername string
+	KafkaPassword string
+
+	// SyslogAddr is host:port of a syslog receiver reached over
+	// SyslogNetwork (udp, tcp or tls) in SyslogFormat (cef or json).
+	SyslogAddr    string
+	SyslogNetwork string
+	SyslogFormat  string
+
+	BufferSize    int
+	BatchSize     int
+	FlushInterval time.Duration
+	MaxRetries    int
+	RetryBackoff  time.Duration
+}
+
+// AnomalyConfig tunes the audit event rules that raise security alerts.
+// A rule is disabled by a zero threshold.
+type AnomalyConfig struct {
+	Enabled bool
+
+	// StateFailureThreshold failed OAuth state verifications from one IP
+	// within StateFailureWindow raise an alert.
+	StateFailureThreshold int
+	StateFailureWindow    time.Duration
+
+	// RefreshFailureThreshold failed token refreshes against one provider
+	// within RefreshFailureWindow raise an alert.
+	RefreshFailureThreshold int
+	RefreshFailureWindow    time.Duration
+
+	// NewOrigin alerts on token retrievals from an origin a connection has
+	// not used before. OriginTable, if set, is a CSV of cidr,origin rows.
+	NewOrigin   bool
+	OriginTable string
+
+	// Cooldown suppresses repeated alerts about the same IP or provider.
+	Cooldown time.Duration
+}
+
+// Redis topologies accepted in REDIS_MODE.
+const (
+	RedisStandalone = "standalone"
+	RedisSentinel   = "sentinel"
+	RedisCluster    = "cluster"
+)
+
+// RedisConfig selects the Redis deployment used for discovery/JWKS caching.
+// Standalone connects to URL; sentinel and cluster connect to Addrs.
+type RedisConfig struct {
+	Mode           string
+	URL            string
+	Addrs          []string
+	SentinelMaster string
+	Password       string
+	// Required makes an unreachable Redis fatal at startup. Otherwise the
+	// broker starts and caches in a MemoryCacheSize-entry LRU until Redis
+	// recovers.
+	Required        bool
+	MemoryCacheSize int
+	// LocalCacheTTL keeps responses in a process-local LRU in front of
+	// Redis for this long. Zero disables the local tier.
+	LocalCacheTTL time.Duration
+}
+
+// DBPoolConfig sizes a Postgres connection pool. Zero durations mean no
+// limit.
+type DBPoolConfig struct {
+	MaxOpenConns     int
+	MaxIdleConns     int
+	ConnMaxLifetime  time.Duration
+	ConnMaxIdleTime  time.Duration
+	StatementTimeout time.Duration
+}
+
+// Load reads configuration from the file named by CONFIG_FILE (if any) and
+// the environment. See LoadFile.
 func Load() (*BrokerConfig, error) {
+	return LoadFile(os.Getenv("CONFIG_FILE"))
+}
+
+// LoadFile reads configuration from the YAML file at path (optional; empty
+// skips it), applies environment overrides and the defaults documented in
+// Settings, validates required fields, and returns a fully populated
+// BrokerConfig or a fatal error.
+func LoadFile(path string) (*BrokerConfig, error) {
+	src, err := newSource(path)
+	if err != nil {
+		return nil, err
+	}
+
 	cfg := &BrokerConfig{
-		Port:        envOr("PORT", "8080"),
-		DatabaseURL: envOr("DATABASE_URL", ""),
-		BaseURL:     envOr("BASE_URL", ""),
-		RedisURL:    envOr("REDIS_URL", "redis://localhost:6379/0"),
+		Port:        src.get("PORT"),
+		GRPCPort:    src.get("GRPC_PORT"),
+		DatabaseURL: src.get("DATABASE_URL"),
+		BaseURL:     src.get("BASE_URL"),
 
-		RedirectPath: envOr("REDIRECT_PATH", "/auth/callback"),
+		RedirectPath: src.get("REDIRECT_PATH"),
 
-		RequireAPIKey:    envBool("REQUIRE_API_KEY"),
-		RequireAllowlist: envBool("REQUIRE_ALLOWLIST"),
-		AllowedCIDRs:     envOr("ALLOWED_CIDRS", "127.0.0.1/32,::1/128"),
+		RequireBoundTokens: src.bool("REQUIRE_BOUND_TOKENS"),
 
-		EnforceReturnURL: envBool("ENFORCE_RETURN_URL"),
+		RequireAPIKey:    src.bool("REQUIRE_API_KEY"),
+		AdminAPIKey:      src.get("ADMIN_API_KEY"),
+		RequireAllowlist: src.bool("REQUIRE_ALLOWLIST"),
+		AllowedCIDRs:     src.list("ALLOWED_CIDRS"),
 
-		EnforceDBSSL:  envBool("ENFORCE_DB_SSL"),
-		DBSSLMode:     envOr("DB_SSLMODE", "require"),
-		DBSSLRootCert: strings.TrimSpace(os.Getenv("DB_SSLROOTCERT")),
+		EnforceReturnURL: src.bool("ENFORCE_RETURN_URL"),
+		PagesDir:         src.get("PAGES_DIR"),
+
+		EnableDebugEndpoints: src.bool("ENABLE_DEBUG_ENDPOINTS"),
+		EnableAPIDocs:        src.bool("ENABLE_API_DOCS"),
+
+		EnforceDBSSL:  src.bool("ENFORCE_DB_SSL"),
+		DBSSLMode:     src.get("DB_SSLMODE"),
+		DBSSLRootCert: src.get("DB_SSLROOTCERT"),
+
+		DatabaseReadURL: src.get("DATABASE_READ_URL"),
+
+		AutoMigrate: src.bool("AUTO_MIGRATE"),
+
+		WebhookURL:    src.get("WEBHOOK_URL"),
+		WebhookSecret: src.get("WEBHOOK_SECRET"),
+	}
+
+	cfg.ProviderAuditInterval, err = src.optionalDuration("PROVIDER_AUDIT_INTERVAL")
+	if err != nil {
+		return nil, err
+	}
+	cfg.Retention, err = src.retention()
+	if err != nil {
+		return nil, err
+	}
+	cfg.UsageFlushInterval, err = src.optionalDuration("USAGE_FLUSH_INTERVAL")
+	if err != nil {
+		return nil, err
+	}
+	cfg.WorkspaceLimits, err = src.workspaceLimits()
+	if err != nil {
+		return nil, err
+	}
+	cfg.DBPool, err = src.dbPool()
+	if err != nil {
+		return nil, err
+	}
+	cfg.TokenRequests, err = src.tokenRequests()
+	if err != nil {
+		return nil, err
+	}
+	cfg.RefreshRetry, err = src.refreshRetry()
+	if err != nil {
+		return nil, err
+	}
+	cfg.Egress, err = src.egress()
+	if err != nil {
+		return nil, err
+	}
+	cfg.Redis, err = src.redis()
+	if err != nil {
+		return nil, err
+	}
+	cfg.AuditSinks, err = src.auditSinks()
+	if err != nil {
+		return nil, err
+	}
+	cfg.Anomaly, err = src.anomaly()
+	if err != nil {
+		return nil, err
+	}
+	cfg.ProviderMaxConnsPerHost, err = src.positiveInt("PROVIDER_MAX_CONNS_PER_HOST")
+	if err != nil {
+		return nil, err
+	}
+	cfg.ProviderMaxIdleConnsPerHost, err = src.positiveInt("PROVIDER_MAX_IDLE_CONNS_PER_HOST")
+	if err != nil {
+		return nil, err
+	}
+	cfg.HealthCheckTimeout, err = src.duration("HEALTH_CHECK_TIMEOUT")
+	if err != nil {
+		return nil, err
+	}
+	cfg.ShutdownDrainDelay, err = src.duration("SHUTDOWN_DRAIN_DELAY")
+	if err != nil {
+		return nil, err
+	}
+	cfg.ShutdownTimeout, err = src.duration("SHUTDOWN_TIMEOUT")
+	if err != nil {
+		return nil, err
 	}
 
 	// Parse allowed return domains
-	if raw := strings.TrimSpace(os.Getenv("ALLOWED_RETURN_DOMAINS")); raw != "" {
-		for _, d := range strings.Split(raw, ",") {
-			d = strings.ToLower(strings.TrimSpace(d))
-			if d != "" {
-				cfg.AllowedReturnDomains = append(cfg.AllowedReturnDomains, d)
-			}
-		}
+	for _, d := range src.list("ALLOWED_RETURN_DOMAINS") {
+		cfg.AllowedReturnDomains = append(cfg.AllowedReturnDomains, strings.ToLower(d))
 	}
 
 	// Build API key allow-set
 	cfg.APIKeys = make(map[string]struct{})
-	if v := strings.TrimSpace(os.Getenv("API_KEYS")); v != "" {
-		for _, k := range strings.Split(v, ",") {
-			k = strings.TrimSpace(k)
-			if k != "" {
-				cfg.APIKeys[k] = struct{}{}
-			}
-		}
+	for _, k := range src.list("API_KEYS") {
+		cfg.APIKeys[k] = struct{}{}
 	}
-	if v := strings.TrimSpace(os.Getenv("API_KEY")); v != "" {
+	if v := src.get("API_KEY"); v != "" {
 		cfg.APIKeys[v] = struct{}{}
 	}
 
+	if err := validCIDRs("ALLOWED_CIDRS", cfg.AllowedCIDRs); err != nil {
+		return nil, err
+	}
+	if cfg.APIKeyCIDRs, err = src.apiKeyCIDRs(cfg.APIKeys); err != nil {
+		return nil, err
+	}
+	if cfg.TrustedProxies, err = src.nonNegativeInt("TRUSTED_PROXIES"); err != nil {
+		return nil, err
+	}
+
 	// Required fields
 	if cfg.DatabaseURL == "" {
 		return nil, fmt.Errorf("DATABASE_URL environment variable is required")
@@ -92,37 +432,399 @@ func Load() (*BrokerConfig, error) {
 	if cfg.BaseURL == "" {
 		return nil, fmt.Errorf("BASE_URL environment variable is required")
 	}
+	if !strings.HasPrefix(cfg.RedirectPath, "/") {
+		return nil, fmt.Errorf("REDIRECT_PATH must start with '/', got %q", cfg.RedirectPath)
+	}
+	if cfg.RequireAPIKey && len(cfg.APIKeys) == 0 {
+		return nil, fmt.Errorf("REQUIRE_API_KEY is true but neither API_KEYS nor API_KEY is set")
+	}
+	if cfg.EnableDebugEndpoints && cfg.AdminAPIKey == "" {
+		return nil, fmt.Errorf("ENABLE_DEBUG_ENDPOINTS is true but ADMIN_API_KEY is not set")
+	}
 
 	// Cryptographic keys
-	var err error
-	cfg.EncryptionKey, err = ValidateKey("ENCRYPTION_KEY", os.Getenv("ENCRYPTION_KEY"))
+	cfg.EncryptionKey, err = ValidateKey("ENCRYPTION_KEY", src.get("ENCRYPTION_KEY"))
 	if err != nil {
 		return nil, err
 	}
-	cfg.StateKey, err = ValidateKey("STATE_KEY", os.Getenv("STATE_KEY"))
-	if err != nil {
+	if cfg.StateKey, cfg.StateKeys, err = state.KeyringFromEnv(src.get); err != nil {
 		return nil, err
 	}
 
 	// Enforce DB SSL if configured
 	cfg.DatabaseURL = enforceDBSSL(cfg.DatabaseURL, cfg.EnforceDBSSL, cfg.DBSSLMode, cfg.DBSSLRootCert)
+	if cfg.DatabaseReadURL != "" {
+		cfg.DatabaseReadURL = enforceDBSSL(cfg.DatabaseReadURL, cfg.EnforceDBSSL, cfg.DBSSLMode, cfg.DBSSLRootCert)
+	}
 
 	return cfg, nil
 }
 
-func envOr(key, fallback string) string {
-	if v := os.Getenv(key); v != "" {
-		return v
+// Features lists the optional behaviour this configuration enables, sorted,
+// for GET /version.
+func (c *BrokerConfig) Features() []string {
+	features := []string{"redis_" + c.Redis.Mode}
+	for name, on := range map[string]bool{
+		"admin_api":            c.AdminAPIKey != "",
+		"allowlist":            c.RequireAllowlist,
+		"api_docs":             c.EnableAPIDocs,
+		"api_keys":             c.RequireAPIKey,
+		"auto_migrate":         c.AutoMigrate,
+		"bound_tokens":         c.RequireBoundTokens,
+		"debug_endpoints":      c.EnableDebugEndpoints,
+		"db_ssl":               c.EnforceDBSSL,
+		"egress_allowlist":     c.Egress.Enforce,
+		"grpc_api":             c.GRPCPort != "",
+		"provider_audits":      c.ProviderAuditInterval > 0,
+		"read_replica":         c.DatabaseReadURL != "",
+		"retention":            c.Retention.Interval > 0,
+		"return_url_allowlist": c.EnforceReturnURL,
+		"webhooks":             c.WebhookURL != "",
+		"audit_sink_webhook":   c.AuditSinks.WebhookURL != "",
+		"audit_sink_kafka":     c.AuditSinks.KafkaRESTURL != "",
+		"audit_sink_syslog":    c.AuditSinks.SyslogAddr != "",
+		"anomaly_detection":    c.Anomaly.Enabled,
+		"custom_pages":         c.PagesDir != "",
+	} {
+		if on {
+			features = append(features, name)
+		}
+	}
+	sort.Strings(features)
+	return features
+}
+
+// LoadRetention reads only DATABASE_URL and the retention settings, for
+// tools such as cmd/cleanup that do not need the broker's keys.
+func LoadRetention(path string) (string, RetentionConfig, error) {
+	src, err := newSource(path)
+	if err != nil {
+		return "", RetentionConfig{}, err
+	}
+	dsn := src.get("DATABASE_URL")
+	if dsn == "" {
+		return "", RetentionConfig{}, fmt.Errorf("DATABASE_URL environment variable is required")
+	}
+	rc, err := src.retention()
+	if err != nil {
+		return "", RetentionConfig{}, err
+	}
+	dsn = enforceDBSSL(dsn, src.bool("ENFORCE_DB_SSL"), src.get("DB_SSLMODE"), src.get("DB_SSLROOTCERT"))
+	return dsn, rc, nil
+}
+
+func (s source) retention() (RetentionConfig, error) {
+	var rc RetentionConfig
+	var err error
+	for _, f := range []struct {
+		key string
+		dst *time.Duration
+	}{
+		{"RETENTION_INTERVAL", &rc.Interval},
+		{"RETENTION_FAILED_CONNECTIONS", &rc.FailedConnections},
+		{"RETENTION_EXPIRED_CONNECTIONS", &rc.ExpiredConnections},
+		{"RETENTION_AUDIT_EVENTS", &rc.AuditEvents},
+	} {
+		if *f.dst, err = s.optionalDuration(f.key); err != nil {
+			return rc, err
+		}
+	}
+	return rc, nil
+}
+
+func (s source) workspaceLimits() (WorkspaceLimitConfig, error) {
+	var wl WorkspaceLimitConfig
+	var err error
+	for _, f := range []struct {
+		key string
+		dst *int
+	}{
+		{"WORKSPACE_MAX_PENDING_CONNECTIONS", &wl.MaxPendingConnections},
+		{"WORKSPACE_MAX_ACTIVE_CONNECTIONS", &wl.MaxActiveConnections},
+		{"WORKSPACE_MAX_REFRESHES_PER_MINUTE", &wl.MaxRefreshesPerMinute},
+	} {
+		if *f.dst, err = s.nonNegativeInt(f.key); err != nil {
+			return wl, err
+		}
+	}
+	return wl, nil
+}
+
+func (s source) tokenRequests() (TokenRequestConfig, error) {
+	var tc TokenRequestConfig
+	var err error
+	if tc.Timeout, err = s.duration("TOKEN_REQUEST_TIMEOUT"); err != nil {
+		return tc, err
+	}
+	if tc.MaxRetries, err = s.nonNegativeInt("TOKEN_REQUEST_MAX_RETRIES"); err != nil {
+		return tc, err
+	}
+	tc.Backoff, err = s.optionalDuration("TOKEN_REQUEST_BACKOFF")
+	return tc, err
+}
+
+func (s source) refreshRetry() (RefreshRetryConfig, error) {
+	var rc RefreshRetryConfig
+	var err error
+	if rc.Interval, err = s.optionalDuration("REFRESH_RETRY_INTERVAL"); err != nil {
+		return rc, err
+	}
+	if rc.MaxAttempts, err = s.nonNegativeInt("REFRESH_RETRY_MAX_ATTEMPTS"); err != nil {
+		return rc, err
+	}
+	rc.Backoff, err = s.duration("REFRESH_RETRY_BACKOFF")
+	return rc, err
+}
+
+func (s source) egress() (EgressConfig, error) {
+	ec := EgressConfig{
+		ProxyURL:     s.get("EGRESS_PROXY_URL"),
+		Enforce:      s.bool("EGRESS_ENFORCE_ALLOWLIST"),
+		AllowedHosts: s.list("EGRESS_ALLOWED_HOSTS"),
+
+		AllowPrivateNetworks: s.bool("EGRESS_ALLOW_PRIVATE_NETWORKS"),
+		AllowedCIDRs:         s.list("EGRESS_ALLOWED_CIDRS"),
+	}
+	for _, c := range ec.AllowedCIDRs {
+		if _, _, err := net.ParseCIDR(c); err != nil {
+			return ec, fmt.Errorf("EGRESS_ALLOWED_CIDRS: invalid CIDR %q", c)
+		}
+	}
+	if ec.ProxyURL != "" {
+		u, err := url.Parse(ec.ProxyURL)
+		if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "socks5") {
+			return ec, fmt.Errorf("EGRESS_PROXY_URL must be an http, https or socks5 URL, got %q", ec.ProxyURL)
+		}
+	}
+	return ec, nil
+}
+
+func (s source) auditSinks() (AuditSinkConfig, error) {
+	ac := AuditSinkConfig{
+		WebhookURL:    s.get("AUDIT_SINK_WEBHOOK_URL"),
+		WebhookSecret: s.get("AUDIT_SINK_WEBHOOK_SECRET"),
+		KafkaRESTURL:  s.get("AUDIT_SINK_KAFKA_REST_URL"),
+		KafkaTopic:    s.get("AUDIT_SINK_KAFKA_TOPIC"),
+		KafkaUsername: s.get("AUDIT_SINK_KAFKA_USERNAME"),
+		KafkaPassword: s.get("AUDIT_SINK_KAFKA_PASSWORD"),
+		SyslogAddr:    s.get("AUDIT_SINK_SYSLOG_ADDR"),
+		SyslogNetwork: strings.ToLower(s.get("AUDIT_SINK_SYSLOG_NETWORK")),
+		SyslogFormat:  strings.ToLower(s.get("AUDIT_SINK_SYSLOG_FORMAT")),
+	}
+	for _, key := range []string{"AUDIT_SINK_WEBHOOK_URL", "AUDIT_SINK_KAFKA_REST_URL"} {
+		if v := s.get(key); v != "" {
+			u, err := url.Parse(v)
+			if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+				return ac, fmt.Errorf("%s must be an http or https URL, got %q", key, v)
+			}
+		}
+	}
+	switch ac.SyslogNetwork {
+	case "udp", "tcp", "tls":
+	default:
+		return ac, fmt.Errorf("AUDIT_SINK_SYSLOG_NETWORK must be udp, tcp or tls, got %q", ac.SyslogNetwork)
+	}
+	switch ac.SyslogFormat {
+	case "cef", "json":
+	default:
+		return ac, fmt.Errorf("AUDIT_SINK_SYSLOG_FORMAT must be cef or json, got %q", ac.SyslogFormat)
+	}
+	if ac.SyslogAddr != "" {
+		if _, _, err := net.SplitHostPort(ac.SyslogAddr); err != nil {
+			return ac, fmt.Errorf("AUDIT_SINK_SYSLOG_ADDR must be host:port, got %q", ac.SyslogAddr)
+		}
+	}
+	var err error
+	if ac.BufferSize, err = s.positiveInt("AUDIT_SINK_BUFFER_SIZE"); err != nil {
+		return ac, err
+	}
+	if ac.BatchSize, err = s.positiveInt("AUDIT_SINK_BATCH_SIZE"); err != nil {
+		return ac, err
+	}
+	if ac.FlushInterval, err = s.duration("AUDIT_SINK_FLUSH_INTERVAL"); err != nil {
+		return ac, err
+	}
+	if ac.MaxRetries, err = s.nonNegativeInt("AUDIT_SINK_MAX_RETRIES"); err != nil {
+		return ac, err
+	}
+	ac.RetryBackoff, err = s.duration("AUDIT_SINK_RETRY_BACKOFF")
+	return ac, err
+}
+
+// apiKeyCIDRs parses API_KEY_ALLOWLISTS: comma-separated key=range|range
+// entries, each key one of keys. Errors name the key's position rather than
+// the key itself.
+func (s source) apiKeyCIDRs(keys map[string]struct{}) (map[string][]string, error) {
+	entries := s.list("API_KEY_ALLOWLISTS")
+	if len(entries) == 0 {
+		return nil, nil
+	}
+	out := make(map[string][]string, len(entries))
+	for i, entry := range entries {
+		key, ranges, ok := strings.Cut(entry, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("API_KEY_ALLOWLISTS entry %d must be key=range|range", i+1)
+		}
+		if _, known := keys[key]; !known {
+			return nil, fmt.Errorf("API_KEY_ALLOWLISTS entry %d names a key not in API_KEYS or API_KEY", i+1)
+		}
+		var cidrs []string
+		for _, r := range strings.Split(ranges, "|") {
+			if r = strings.TrimSpace(r); r != "" {
+				cidrs = append(cidrs, r)
+			}
+		}
+		if len(cidrs) == 0 {
+			return nil, fmt.Errorf("API_KEY_ALLOWLISTS entry %d lists no ranges", i+1)
+		}
+		if err := validCIDRs(fmt.Sprintf("API_KEY_ALLOWLISTS entry %d", i+1), cidrs); err != nil {
+			return nil, err
+		}
+		out[key] = append(out[key], cidrs...)
+	}
+	return out, nil
+}
+
+// validCIDRs checks that every entry is a CIDR or a bare IP address.
+func validCIDRs(name string, entries []string) error {
+	for _, e := range entries {
+		if net.ParseIP(e) != nil {
+			continue
+		}
+		if _, _, err := net.ParseCIDR(e); err != nil {
+			return fmt.Errorf("%s: invalid CIDR or IP %q", name, e)
+		}
+	}
+	return nil
+}
+
+func (s source) anomaly() (AnomalyConfig, error) {
+	ac := AnomalyConfig{
+		Enabled:     s.bool("ANOMALY_DETECTION"),
+		NewOrigin:   s.bool("ANOMALY_NEW_ORIGIN"),
+		OriginTable: s.get("ANOMALY_ORIGIN_TABLE"),
+	}
+	var err error
+	if ac.StateFailureThreshold, err = s.nonNegativeInt("ANOMALY_STATE_FAILURE_THRESHOLD"); err != nil {
+		return ac, err
+	}
+	if ac.StateFailureWindow, err = s.duration("ANOMALY_STATE_FAILURE_WINDOW"); err != nil {
+		return ac, err
+	}
+	if ac.RefreshFailureThreshold, err = s.nonNegativeInt("ANOMALY_REFRESH_FAILURE_THRESHOLD"); err != nil {
+		return ac, err
+	}
+	if ac.RefreshFailureWindow, err = s.duration("ANOMALY_REFRESH_FAILURE_WINDOW"); err != nil {
+		return ac, err
+	}
+	ac.Cooldown, err = s.optionalDuration("ANOMALY_ALERT_COOLDOWN")
+	return ac, err
+}
+
+func (s source) redis() (RedisConfig, error) {
+	rc := RedisConfig{
+		Mode:           strings.ToLower(s.get("REDIS_MODE")),
+		URL:            s.get("REDIS_URL"),
+		Addrs:          s.list("REDIS_ADDRS"),
+		SentinelMaster: s.get("REDIS_SENTINEL_MASTER"),
+		Password:       s.get("REDIS_PASSWORD"),
+		Required:       s.bool("REDIS_REQUIRED"),
+	}
+	switch rc.Mode {
+	case RedisStandalone:
+		if rc.URL == "" {
+			return rc, fmt.Errorf("REDIS_URL is required when REDIS_MODE is %s", rc.Mode)
+		}
+	case RedisSentinel:
+		if len(rc.Addrs) == 0 || rc.SentinelMaster == "" {
+			return rc, fmt.Errorf("REDIS_ADDRS and REDIS_SENTINEL_MASTER are required when REDIS_MODE is %s", rc.Mode)
+		}
+	case RedisCluster:
+		if len(rc.Addrs) == 0 {
+			return rc, fmt.Errorf("REDIS_ADDRS is required when REDIS_MODE is %s", rc.Mode)
+		}
+	default:
+		return rc, fmt.Errorf("REDIS_MODE must be standalone, sentinel or cluster, got %q", rc.Mode)
+	}
+	var err error
+	if rc.MemoryCacheSize, err = s.positiveInt("REDIS_MEMORY_CACHE_SIZE"); err != nil {
+		return rc, err
+	}
+	rc.LocalCacheTTL, err = s.optionalDuration("REDIS_LOCAL_CACHE_TTL")
+	return rc, err
+}
+
+func (s source) dbPool() (DBPoolConfig, error) {
+	var pc DBPoolConfig
+	var err error
+	if pc.MaxOpenConns, err = s.positiveInt("DB_MAX_OPEN_CONNS"); err != nil {
+		return pc, err
+	}
+	if pc.MaxIdleConns, err = s.positiveInt("DB_MAX_IDLE_CONNS"); err != nil {
+		return pc, err
+	}
+	if pc.MaxIdleConns > pc.MaxOpenConns {
+		return pc, fmt.Errorf("DB_MAX_IDLE_CONNS (%d) must not exceed DB_MAX_OPEN_CONNS (%d)", pc.MaxIdleConns, pc.MaxOpenConns)
+	}
+	for _, f := range []struct {
+		key string
+		dst *time.Duration
+	}{
+		{"DB_CONN_MAX_LIFETIME", &pc.ConnMaxLifetime},
+		{"DB_CONN_MAX_IDLE_TIME", &pc.ConnMaxIdleTime},
+		{"DB_STATEMENT_TIMEOUT", &pc.StatementTimeout},
+	} {
+		if *f.dst, err = s.optionalDuration(f.key); err != nil {
+			return pc, err
+		}
+	}
+	return pc, nil
+}
+
+// optionalDuration is duration, except that "0" is accepted and means off.
+func (s source) optionalDuration(key string) (time.Duration, error) {
+	if s.get(key) == "0" {
+		return 0, nil
+	}
+	return s.duration(key)
+}
+
+func (s source) duration(key string) (time.Duration, error) {
+	v := s.get(key)
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("%s must be a positive duration (e.g. 2s), got %q", key, v)
+	}
+	return d, nil
+}
+
+func (s source) positiveInt(key string) (int, error) {
+	v := s.get(key)
+	n, err := strconv.Atoi(v)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("%s must be a positive integer, got %q", key, v)
+	}
+	return n, nil
+}
+
+func (s source) nonNegativeInt(key string) (int, error) {
+	v := s.get(key)
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%s must be a non-negative integer, got %q", key, v)
 	}
-	return fallback
+	return n, nil
 }
 
-func envBool(key string) bool {
-	return strings.EqualFold(strings.TrimSpace(os.Getenv(key)), "true")
+func (s source) bool(key string) bool {
+	return strings.EqualFold(s.get(key), "true")
 }
 
+// enforceDBSSL sets sslmode (and sslrootcert) on a Postgres DSN. MySQL
+// and SQLite URLs are returned unchanged.
 func enforceDBSSL(dsn string, enforce bool, mode, rootCert string) string {
-	if !enforce {
+	if !enforce || strings.HasPrefix(dsn, "mysql:") || strings.HasPrefix(dsn, "sqlite") {
 		return dsn
 	}
 
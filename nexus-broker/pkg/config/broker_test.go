@@ -1,8 +1,13 @@
 package config
 
 import (
+	"bytes"
 	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
 func testKey() string {
@@ -94,3 +99,589 @@ func TestLoad_DBSSLEnforcement(t *testing.T) {
 		t.Error("expected DatabaseURL to have sslmode appended")
 	}
 }
+
+func TestEnforceDBSSL_LeavesOtherBackends(t *testing.T) {
+	for _, dsn := range []string{"mysql://u:p@db:3306/app", "sqlite:/var/lib/broker.db"} {
+		if got := enforceDBSSL(dsn, true, "require", ""); got != dsn {
+			t.Errorf("enforceDBSSL(%q) = %q, want it unchanged", dsn, got)
+		}
+	}
+}
+
+func TestLoad_HealthCheckTimeout(t *testing.T) {
+	t.Setenv("DATABASE_URL", "postgres://localhost/db")
+	t.Setenv("BASE_URL", "http://localhost")
+	t.Setenv("ENCRYPTION_KEY", testKey())
+	t.Setenv("STATE_KEY", testKey())
+	t.Setenv("HEALTH_CHECK_TIMEOUT", "500ms")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.HealthCheckTimeout != 500*time.Millisecond {
+		t.Errorf("expected 500ms, got %s", cfg.HealthCheckTimeout)
+	}
+
+	t.Setenv("HEALTH_CHECK_TIMEOUT", "soon")
+	if _, err := Load(); err == nil {
+		t.Fatal("expected error for invalid HEALTH_CHECK_TIMEOUT")
+	}
+}
+
+func TestLoad_StateKeys(t *testing.T) {
+	t.Setenv("DATABASE_URL", "postgres://localhost/db")
+	t.Setenv("BASE_URL", "http://localhost")
+	t.Setenv("ENCRYPTION_KEY", testKey())
+	t.Setenv("STATE_KEY", testKey())
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.StateKeys.TTL() != 10*time.Minute || len(cfg.StateKeys.KeyIDs()) != 1 {
+		t.Errorf("default keyring: TTL %s, keys %v", cfg.StateKeys.TTL(), cfg.StateKeys.KeyIDs())
+	}
+
+	previous := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32))
+	t.Setenv("STATE_KEY_ID", "2025-06")
+	t.Setenv("STATE_PREVIOUS_KEYS", "2025-01:"+previous)
+	t.Setenv("STATE_TTL", "5m")
+	cfg, err = Load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ids := cfg.StateKeys.KeyIDs(); strings.Join(ids, ",") != "2025-06,2025-01" {
+		t.Errorf("key IDs = %v", ids)
+	}
+	if cfg.StateKeys.TTL() != 5*time.Minute {
+		t.Errorf("TTL = %s, want 5m", cfg.StateKeys.TTL())
+	}
+
+	t.Setenv("STATE_KEYS", "2025-01:"+previous+","+testKey())
+	if _, err := Load(); err == nil || !strings.Contains(err.Error(), "STATE_KEYS and STATE_KEY") {
+		t.Errorf("expected conflict between STATE_KEYS and STATE_KEY, got %v", err)
+	}
+	t.Setenv("STATE_KEY", "")
+	t.Setenv("STATE_KEY_ID", "")
+	t.Setenv("STATE_PREVIOUS_KEYS", "")
+	cfg, err = Load()
+	if err != nil {
+		t.Fatalf("STATE_KEYS: unexpected error: %v", err)
+	}
+	if ids := cfg.StateKeys.KeyIDs(); len(ids) != 2 || ids[0] != "2025-01" || cfg.StateKeys.CurrentKeyID() != "2025-01" {
+		t.Errorf("STATE_KEYS key IDs = %v, current %s", ids, cfg.StateKeys.CurrentKeyID())
+	}
+	if !bytes.Equal(cfg.StateKey, bytes.Repeat([]byte{1}, 32)) {
+		t.Error("StateKey should be the first STATE_KEYS secret")
+	}
+	t.Setenv("STATE_KEYS", "")
+	t.Setenv("STATE_KEY", testKey())
+
+	for key, value := range map[string]string{
+		"STATE_KEYS":          ",",
+		"STATE_PREVIOUS_KEYS": "2025-01:short",
+		"STATE_KEY_ID":        "2025.06",
+		"STATE_TTL":           "0",
+	} {
+		t.Run(key, func(t *testing.T) {
+			t.Setenv(key, value)
+			if _, err := Load(); err == nil || !strings.Contains(err.Error(), key) {
+				t.Errorf("expected %s error, got %v", key, err)
+			}
+		})
+	}
+}
+
+func TestLoad_ProviderAuditInterval(t *testing.T) {
+	t.Setenv("DATABASE_URL", "postgres://localhost/db")
+	t.Setenv("BASE_URL", "http://localhost")
+	t.Setenv("ENCRYPTION_KEY", testKey())
+	t.Setenv("STATE_KEY", testKey())
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.ProviderAuditInterval != 6*time.Hour {
+		t.Errorf("expected default 6h, got %s", cfg.ProviderAuditInterval)
+	}
+
+	t.Setenv("PROVIDER_AUDIT_INTERVAL", "0")
+	if cfg, err = Load(); err != nil || cfg.ProviderAuditInterval != 0 {
+		t.Errorf("expected 0 to disable audits, got %v (err %v)", cfg, err)
+	}
+}
+
+func TestLoad_TokenRequests(t *testing.T) {
+	t.Setenv("DATABASE_URL", "postgres://localhost/db")
+	t.Setenv("BASE_URL", "http://localhost")
+	t.Setenv("ENCRYPTION_KEY", testKey())
+	t.Setenv("STATE_KEY", testKey())
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := TokenRequestConfig{Timeout: 30 * time.Second, MaxRetries: 2, Backoff: 250 * time.Millisecond}
+	if cfg.TokenRequests != want {
+		t.Errorf("expected defaults %+v, got %+v", want, cfg.TokenRequests)
+	}
+
+	t.Setenv("TOKEN_REQUEST_MAX_RETRIES", "0")
+	t.Setenv("TOKEN_REQUEST_BACKOFF", "0")
+	if cfg, err = Load(); err != nil || cfg.TokenRequests.MaxRetries != 0 || cfg.TokenRequests.Backoff != 0 {
+		t.Errorf("expected retries and backoff disabled, got %v (err %v)", cfg, err)
+	}
+
+	t.Setenv("TOKEN_REQUEST_MAX_RETRIES", "-1")
+	if _, err := Load(); err == nil {
+		t.Error("expected error for negative TOKEN_REQUEST_MAX_RETRIES")
+	}
+}
+
+func TestLoad_RefreshRetry(t *testing.T) {
+	t.Setenv("DATABASE_URL", "postgres://localhost/db")
+	t.Setenv("BASE_URL", "http://localhost")
+	t.Setenv("ENCRYPTION_KEY", testKey())
+	t.Setenv("STATE_KEY", testKey())
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := RefreshRetryConfig{Interval: time.Minute, MaxAttempts: 5, Backoff: time.Minute}
+	if cfg.RefreshRetry != want {
+		t.Errorf("expected defaults %+v, got %+v", want, cfg.RefreshRetry)
+	}
+
+	t.Setenv("REFRESH_RETRY_INTERVAL", "0")
+	t.Setenv("REFRESH_RETRY_MAX_ATTEMPTS", "0")
+	if cfg, err = Load(); err != nil || cfg.RefreshRetry.Interval != 0 || cfg.RefreshRetry.MaxAttempts != 0 {
+		t.Errorf("expected background retries disabled and no attempt cap, got %+v (err %v)", cfg.RefreshRetry, err)
+	}
+
+	t.Setenv("REFRESH_RETRY_BACKOFF", "0")
+	if _, err := Load(); err == nil {
+		t.Error("expected error for zero REFRESH_RETRY_BACKOFF")
+	}
+}
+
+func TestLoad_Egress(t *testing.T) {
+	t.Setenv("DATABASE_URL", "postgres://localhost/db")
+	t.Setenv("BASE_URL", "http://localhost")
+	t.Setenv("ENCRYPTION_KEY", testKey())
+	t.Setenv("STATE_KEY", testKey())
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.Egress.Enforce || cfg.Egress.ProxyURL != "" {
+		t.Errorf("expected no egress restrictions by default, got %+v", cfg.Egress)
+	}
+
+	t.Setenv("EGRESS_PROXY_URL", "http://proxy.internal:3128")
+	t.Setenv("EGRESS_ENFORCE_ALLOWLIST", "true")
+	t.Setenv("EGRESS_ALLOWED_HOSTS", "www.googleapis.com, *.okta.com")
+	if cfg, err = Load(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !cfg.Egress.Enforce || len(cfg.Egress.AllowedHosts) != 2 || cfg.Egress.AllowedHosts[1] != "*.okta.com" {
+		t.Errorf("unexpected egress config %+v", cfg.Egress)
+	}
+
+	t.Setenv("EGRESS_PROXY_URL", "proxy.internal:3128")
+	if _, err := Load(); err == nil {
+		t.Error("expected error for an EGRESS_PROXY_URL without scheme")
+	}
+	t.Setenv("EGRESS_PROXY_URL", "")
+
+	t.Setenv("EGRESS_ALLOWED_CIDRS", "10.20.0.0/16,not-a-cidr")
+	if _, err := Load(); err == nil {
+		t.Error("expected error for an invalid EGRESS_ALLOWED_CIDRS entry")
+	}
+}
+
+func TestLoad_AuditSinks(t *testing.T) {
+	t.Setenv("DATABASE_URL", "postgres://localhost/db")
+	t.Setenv("BASE_URL", "http://localhost")
+	t.Setenv("ENCRYPTION_KEY", testKey())
+	t.Setenv("STATE_KEY", testKey())
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ac := cfg.AuditSinks
+	if ac.WebhookURL != "" || ac.KafkaRESTURL != "" || ac.SyslogAddr != "" {
+		t.Errorf("expected no audit sinks by default, got %+v", ac)
+	}
+	if ac.KafkaTopic != "nexus.audit" || ac.SyslogNetwork != "udp" || ac.SyslogFormat != "cef" ||
+		ac.BufferSize != 10000 || ac.BatchSize != 100 || ac.FlushInterval != 5*time.Second ||
+		ac.MaxRetries != 5 || ac.RetryBackoff != time.Second {
+		t.Errorf("unexpected defaults %+v", ac)
+	}
+
+	t.Setenv("AUDIT_SINK_SYSLOG_ADDR", "siem.internal:6514")
+	t.Setenv("AUDIT_SINK_SYSLOG_NETWORK", "TLS")
+	t.Setenv("AUDIT_SINK_KAFKA_REST_URL", "http://kafka-rest:8082")
+	if cfg, err = Load(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.AuditSinks.SyslogNetwork != "tls" {
+		t.Errorf("expected tls, got %q", cfg.AuditSinks.SyslogNetwork)
+	}
+	if got := strings.Join(cfg.Features(), ","); !strings.Contains(got, "audit_sink_kafka,audit_sink_syslog") {
+		t.Errorf("expected audit sink features, got %s", got)
+	}
+
+	for key, bad := range map[string]string{
+		"AUDIT_SINK_SYSLOG_NETWORK": "quic",
+		"AUDIT_SINK_SYSLOG_FORMAT":  "leef",
+		"AUDIT_SINK_SYSLOG_ADDR":    "siem.internal",
+		"AUDIT_SINK_WEBHOOK_URL":    "hooks.example.com/audit",
+		"AUDIT_SINK_BATCH_SIZE":     "0",
+	} {
+		t.Run(key, func(t *testing.T) {
+			t.Setenv(key, bad)
+			if _, err := Load(); err == nil {
+				t.Errorf("expected error for %s=%q", key, bad)
+			}
+		})
+	}
+}
+
+func TestLoad_ClientAllowlists(t *testing.T) {
+	t.Setenv("DATABASE_URL", "postgres://localhost/db")
+	t.Setenv("BASE_URL", "http://localhost")
+	t.Setenv("ENCRYPTION_KEY", testKey())
+	t.Setenv("STATE_KEY", testKey())
+	t.Setenv("API_KEYS", "internal-key,partner-key")
+	t.Setenv("ALLOWED_CIDRS", "10.0.0.0/8, 192.0.2.7")
+	t.Setenv("API_KEY_ALLOWLISTS", "partner-key=203.0.113.0/24|198.51.100.4")
+	t.Setenv("TRUSTED_PROXIES", "2")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.Join(cfg.AllowedCIDRs, ",") != "10.0.0.0/8,192.0.2.7" {
+		t.Errorf("unexpected ALLOWED_CIDRS %v", cfg.AllowedCIDRs)
+	}
+	if got := strings.Join(cfg.APIKeyCIDRs["partner-key"], ","); got != "203.0.113.0/24,198.51.100.4" {
+		t.Errorf("unexpected partner-key ranges %q", got)
+	}
+	if cfg.TrustedProxies != 2 {
+		t.Errorf("expected 2 trusted proxies, got %d", cfg.TrustedProxies)
+	}
+
+	for key, bad := range map[string]string{
+		"ALLOWED_CIDRS":      "10.0.0.0/33",
+		"API_KEY_ALLOWLISTS": "unknown-key=10.0.0.0/8",
+		"TRUSTED_PROXIES":    "-1",
+	} {
+		t.Run(key, func(t *testing.T) {
+			t.Setenv(key, bad)
+			_, err := Load()
+			if err == nil {
+				t.Fatalf("expected error for %s=%q", key, bad)
+			}
+			if strings.Contains(err.Error(), "unknown-key") {
+				t.Errorf("error should not echo an API key: %v", err)
+			}
+		})
+	}
+}
+
+func TestLoad_Anomaly(t *testing.T) {
+	t.Setenv("DATABASE_URL", "postgres://localhost/db")
+	t.Setenv("BASE_URL", "http://localhost")
+	t.Setenv("ENCRYPTION_KEY", testKey())
+	t.Setenv("STATE_KEY", testKey())
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ac := cfg.Anomaly
+	if !ac.Enabled || !ac.NewOrigin || ac.StateFailureThreshold != 10 || ac.StateFailureWindow != 5*time.Minute ||
+		ac.RefreshFailureThreshold != 20 || ac.RefreshFailureWindow != 10*time.Minute || ac.Cooldown != 15*time.Minute {
+		t.Errorf("unexpected defaults %+v", ac)
+	}
+
+	t.Setenv("ANOMALY_STATE_FAILURE_THRESHOLD", "0")
+	t.Setenv("ANOMALY_ALERT_COOLDOWN", "0")
+	if cfg, err = Load(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.Anomaly.StateFailureThreshold != 0 || cfg.Anomaly.Cooldown != 0 {
+		t.Errorf("expected the rule and cooldown disabled, got %+v", cfg.Anomaly)
+	}
+
+	for key, bad := range map[string]string{
+		"ANOMALY_REFRESH_FAILURE_THRESHOLD": "-1",
+		"ANOMALY_STATE_FAILURE_WINDOW":      "0",
+	} {
+		t.Run(key, func(t *testing.T) {
+			t.Setenv(key, bad)
+			if _, err := Load(); err == nil {
+				t.Errorf("expected error for %s=%q", key, bad)
+			}
+		})
+	}
+}
+
+func TestLoad_ShutdownDurations(t *testing.T) {
+	t.Setenv("DATABASE_URL", "postgres://localhost/db")
+	t.Setenv("BASE_URL", "http://localhost")
+	t.Setenv("ENCRYPTION_KEY", testKey())
+	t.Setenv("STATE_KEY", testKey())
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.ShutdownDrainDelay != 5*time.Second || cfg.ShutdownTimeout != 20*time.Second {
+		t.Errorf("unexpected defaults: drain=%s timeout=%s", cfg.ShutdownDrainDelay, cfg.ShutdownTimeout)
+	}
+
+	t.Setenv("SHUTDOWN_TIMEOUT", "-1s")
+	if _, err := Load(); err == nil {
+		t.Fatal("expected error for invalid SHUTDOWN_TIMEOUT")
+	}
+}
+
+func TestLoad_DBPool(t *testing.T) {
+	t.Setenv("DATABASE_URL", "postgres://localhost/db")
+	t.Setenv("BASE_URL", "http://localhost")
+	t.Setenv("ENCRYPTION_KEY", testKey())
+	t.Setenv("STATE_KEY", testKey())
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := DBPoolConfig{MaxOpenConns: 25, MaxIdleConns: 10, ConnMaxLifetime: 30 * time.Minute, ConnMaxIdleTime: 5 * time.Minute}
+	if cfg.DBPool != want {
+		t.Errorf("unexpected defaults: %+v", cfg.DBPool)
+	}
+	if cfg.DatabaseReadURL != "" {
+		t.Errorf("expected no read replica by default, got %q", cfg.DatabaseReadURL)
+	}
+
+	t.Setenv("DB_STATEMENT_TIMEOUT", "15s")
+	t.Setenv("DATABASE_READ_URL", "postgres://replica/db")
+	t.Setenv("ENFORCE_DB_SSL", "true")
+	if cfg, err = Load(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.DBPool.StatementTimeout != 15*time.Second {
+		t.Errorf("expected 15s statement timeout, got %s", cfg.DBPool.StatementTimeout)
+	}
+	if !strings.Contains(cfg.DatabaseReadURL, "sslmode=require") {
+		t.Errorf("expected sslmode enforced on read replica, got %q", cfg.DatabaseReadURL)
+	}
+
+	t.Setenv("DB_MAX_IDLE_CONNS", "50")
+	if _, err := Load(); err == nil {
+		t.Fatal("expected error when DB_MAX_IDLE_CONNS exceeds DB_MAX_OPEN_CONNS")
+	}
+}
+
+func TestLoad_Redis(t *testing.T) {
+	t.Setenv("DATABASE_URL", "postgres://localhost/db")
+	t.Setenv("BASE_URL", "http://localhost")
+	t.Setenv("ENCRYPTION_KEY", testKey())
+	t.Setenv("STATE_KEY", testKey())
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.Redis.Mode != RedisStandalone || cfg.Redis.URL != "redis://localhost:6379/0" || cfg.Redis.Required || cfg.Redis.MemoryCacheSize != 1000 || cfg.Redis.LocalCacheTTL != 30*time.Second {
+		t.Errorf("unexpected defaults: %+v", cfg.Redis)
+	}
+
+	t.Setenv("REDIS_MODE", "sentinel")
+	if _, err := Load(); err == nil {
+		t.Fatal("expected error for sentinel mode without REDIS_ADDRS")
+	}
+	t.Setenv("REDIS_ADDRS", "s1:26379, s2:26379")
+	t.Setenv("REDIS_SENTINEL_MASTER", "mymaster")
+	if cfg, err = Load(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(cfg.Redis.Addrs) != 2 || cfg.Redis.Addrs[1] != "s2:26379" || cfg.Redis.SentinelMaster != "mymaster" {
+		t.Errorf("unexpected sentinel config: %+v", cfg.Redis)
+	}
+
+	t.Setenv("REDIS_MODE", "Cluster")
+	if cfg, err = Load(); err != nil || cfg.Redis.Mode != RedisCluster {
+		t.Errorf("expected cluster mode, got %+v (err %v)", cfg, err)
+	}
+
+	t.Setenv("REDIS_LOCAL_CACHE_TTL", "0")
+	if cfg, err = Load(); err != nil || cfg.Redis.LocalCacheTTL != 0 {
+		t.Errorf("expected local cache disabled, got %v (err %v)", cfg.Redis.LocalCacheTTL, err)
+	}
+
+	t.Setenv("REDIS_MODE", "replicated")
+	if _, err := Load(); err == nil {
+		t.Fatal("expected error for unknown REDIS_MODE")
+	}
+}
+
+func TestBrokerConfig_Features(t *testing.T) {
+	t.Setenv("DATABASE_URL", "postgres://localhost/db")
+	t.Setenv("BASE_URL", "http://localhost")
+	t.Setenv("ENCRYPTION_KEY", testKey())
+	t.Setenv("STATE_KEY", testKey())
+	t.Setenv("PROVIDER_AUDIT_INTERVAL", "0")
+	t.Setenv("RETENTION_INTERVAL", "0")
+	t.Setenv("ANOMALY_DETECTION", "false")
+	t.Setenv("ENABLE_API_DOCS", "false")
+	t.Setenv("AUTO_MIGRATE", "true")
+	t.Setenv("WEBHOOK_URL", "https://hooks.example.com")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got := strings.Join(cfg.Features(), ",")
+	if got != "auto_migrate,redis_standalone,webhooks" {
+		t.Errorf("unexpected features: %s", got)
+	}
+}
+
+func TestLoadRetention(t *testing.T) {
+	t.Setenv("DATABASE_URL", "postgres://localhost/db")
+
+	dsn, rc, err := LoadRetention("")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if dsn != "postgres://localhost/db" {
+		t.Errorf("unexpected dsn %q", dsn)
+	}
+	want := RetentionConfig{Interval: time.Hour, FailedConnections: 720 * time.Hour, ExpiredConnections: 168 * time.Hour}
+	if rc != want {
+		t.Errorf("unexpected defaults: %+v", rc)
+	}
+
+	t.Setenv("RETENTION_AUDIT_EVENTS", "2160h")
+	t.Setenv("RETENTION_EXPIRED_CONNECTIONS", "0")
+	if _, rc, err = LoadRetention(""); err != nil || rc.AuditEvents != 2160*time.Hour || rc.ExpiredConnections != 0 {
+		t.Errorf("unexpected overrides: %+v (err %v)", rc, err)
+	}
+
+	t.Setenv("RETENTION_FAILED_CONNECTIONS", "soon")
+	if _, _, err := LoadRetention(""); err == nil {
+		t.Fatal("expected error for invalid RETENTION_FAILED_CONNECTIONS")
+	}
+}
+
+func writeConfigFile(t *testing.T, body string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "broker.yaml")
+	if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadFile_EnvOverridesFile(t *testing.T) {
+	path := writeConfigFile(t, `
+port: 9000
+database_url: postgres://file/db
+base_url: http://file
+encryption_key: `+testKey()+`
+state_key: `+testKey()+`
+allowed_return_domains: [example.com, Foo.com]
+health_check_timeout: 3s
+`)
+	t.Setenv("PORT", "")
+	t.Setenv("DATABASE_URL", "")
+	t.Setenv("BASE_URL", "http://env")
+	t.Setenv("ENCRYPTION_KEY", "")
+	t.Setenv("STATE_KEY", "")
+	t.Setenv("ALLOWED_RETURN_DOMAINS", "")
+	t.Setenv("HEALTH_CHECK_TIMEOUT", "")
+
+	cfg, err := LoadFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.Port != "9000" {
+		t.Errorf("expected port from file, got %s", cfg.Port)
+	}
+	if cfg.BaseURL != "http://env" {
+		t.Errorf("expected BASE_URL from env to override file, got %s", cfg.BaseURL)
+	}
+	if len(cfg.AllowedReturnDomains) != 2 || cfg.AllowedReturnDomains[1] != "foo.com" {
+		t.Errorf("expected list from file, got %v", cfg.AllowedReturnDomains)
+	}
+	if cfg.HealthCheckTimeout != 3*time.Second {
+		t.Errorf("expected 3s from file, got %s", cfg.HealthCheckTimeout)
+	}
+}
+
+func TestLoadFile_Invalid(t *testing.T) {
+	if _, err := LoadFile(writeConfigFile(t, "databse_url: typo\n")); err == nil {
+		t.Error("expected error for unknown key")
+	}
+	if _, err := LoadFile(filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Error("expected error for missing file")
+	}
+
+	t.Setenv("DATABASE_URL", "postgres://localhost/db")
+	t.Setenv("BASE_URL", "http://localhost")
+	t.Setenv("ENCRYPTION_KEY", testKey())
+	t.Setenv("STATE_KEY", testKey())
+	t.Setenv("REDIRECT_PATH", "auth/callback")
+	if _, err := LoadFile(""); err == nil {
+		t.Error("expected error for REDIRECT_PATH without leading slash")
+	}
+}
+
+func TestPrintConfig_RedactsSecrets(t *testing.T) {
+	t.Setenv("STATE_KEY", testKey())
+	t.Setenv("PORT", "")
+
+	var buf bytes.Buffer
+	if err := PrintConfig(&buf, ""); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	if strings.Contains(out, testKey()) {
+		t.Error("secret value leaked in printed config")
+	}
+	if !strings.Contains(out, "state_key: <redacted>") {
+		t.Errorf("expected redacted state_key, got:\n%s", out)
+	}
+	if !strings.Contains(out, `port: "8080"`) {
+		t.Errorf("expected default port, got:\n%s", out)
+	}
+}
+
+func TestLoad_DebugEndpointsRequireAdminKey(t *testing.T) {
+	t.Setenv("DATABASE_URL", "postgres://localhost/db")
+	t.Setenv("BASE_URL", "http://localhost")
+	t.Setenv("ENCRYPTION_KEY", testKey())
+	t.Setenv("STATE_KEY", testKey())
+	t.Setenv("ENABLE_DEBUG_ENDPOINTS", "true")
+	t.Setenv("ADMIN_API_KEY", "")
+
+	if _, err := Load(); err == nil {
+		t.Fatal("expected error when debug endpoints are enabled without ADMIN_API_KEY")
+	}
+
+	t.Setenv("ADMIN_API_KEY", "admin-secret")
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !cfg.EnableDebugEndpoints || cfg.AdminAPIKey != "admin-secret" {
+		t.Errorf("unexpected admin config: %+v", cfg)
+	}
+}
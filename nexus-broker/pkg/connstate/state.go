@@ -0,0 +1,263 @@
+// Package connstate defines the lifecycle of a connection row and enforces
+// which status changes are allowed.
+package connstate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// State is the value stored in connections.status.
+type State string
+
+const (
+	// StatePending is a consent in progress; the user has not returned yet.
+	StatePending State = "pending"
+	// StateActive has usable credentials.
+	StateActive State = "active"
+	// StateAwaitingApproval completed consent for a provider that requires
+	// approval. Its tokens are stored but withheld until an approver
+	// approves it, which makes it active.
+	StateAwaitingApproval State = "awaiting_approval"
+	// StateRejected was refused by an approver; its tokens are deleted.
+	StateRejected State = "rejected"
+	// StateFailed is a consent whose code exchange or verification failed.
+	StateFailed State = "failed"
+	// StateCancelled is a consent abandoned before completion.
+	StateCancelled State = "cancelled"
+	// StateExpired is a consent not completed before expires_at.
+	StateExpired State = "expired"
+	// StateNeedsReauth has credentials the provider rejected; the user must
+	// consent again (POST /connections/{id}/reauthorize), which makes it
+	// active. Surfaced to clients as attention_required.
+	StateNeedsReauth State = "needs_reauth"
+	// StateCompromised had a refresh token reused after rotation, a sign
+	// its credentials were stolen. Tokens are withheld until it is revoked.
+	StateCompromised State = "compromised"
+	// StateSuspended was disabled by an operator. Tokens are withheld until
+	// it is resumed, which makes it active again.
+	StateSuspended State = "suspended"
+	// StateRevoked had its credentials revoked and must not be reactivated.
+	StateRevoked State = "revoked"
+	// StateArchived is retained for history only.
+	StateArchived State = "archived"
+)
+
+// transitions lists the states each state may move to. States without an
+// entry are terminal.
+var transitions = map[State][]State{
+	StatePending:          {StateActive, StateAwaitingApproval, StateFailed, StateCancelled, StateExpired},
+	StateAwaitingApproval: {StateActive, StateRejected, StateRevoked, StateArchived},
+	StateActive:           {StateNeedsReauth, StateCompromised, StateSuspended, StateRevoked, StateArchived},
+	StateNeedsReauth:      {StateActive, StateCompromised, StateRevoked, StateArchived},
+	StateCompromised:      {StateRevoked, StateArchived},
+	StateSuspended:        {StateActive, StateRevoked, StateArchived},
+	StateFailed:           {StateArchived},
+	StateCancelled:        {StateArchived},
+	StateExpired:          {StateArchived},
+	StateRejected:         {StateArchived},
+	StateRevoked:          {StateArchived},
+}
+
+// Reason is a machine-readable explanation of a connection's state for API
+// clients, telling apart what waiting fixes from what needs the user or an
+// operator.
+type Reason string
+
+const (
+	// ReasonAwaitingConsent: pending; the user has not finished consenting.
+	ReasonAwaitingConsent Reason = "awaiting_consent"
+	// ReasonAwaitingApproval: awaiting_approval; an approver must approve
+	// it.
+	ReasonAwaitingApproval Reason = "awaiting_approval"
+	// ReasonApprovalRejected: rejected by an approver; a new consent needs
+	// approval again.
+	ReasonApprovalRejected Reason = "approval_rejected"
+	// ReasonOK: active; tokens are served.
+	ReasonOK Reason = "ok"
+	// ReasonRefreshFailing: active, but its last refresh failed for a
+	// transient reason and is being retried. Retry later.
+	ReasonRefreshFailing Reason = "refresh_failing"
+	// ReasonConsentFailed: failed; start a new consent.
+	ReasonConsentFailed Reason = "consent_failed"
+	// ReasonConsentCancelled: cancelled; start a new consent.
+	ReasonConsentCancelled Reason = "consent_cancelled"
+	// ReasonConsentExpired: expired; start a new consent.
+	ReasonConsentExpired Reason = "consent_expired"
+	// ReasonReauthRequired: needs_reauth; the user must consent again.
+	ReasonReauthRequired Reason = "reauth_required"
+	// ReasonCompromised: compromised; revoke it and consent again.
+	ReasonCompromised Reason = "credentials_compromised"
+	// ReasonSuspended: suspended by an operator; wait for it to be resumed.
+	ReasonSuspended Reason = "suspended"
+	// ReasonRevoked: revoked; consent again for new credentials.
+	ReasonRevoked Reason = "revoked"
+	// ReasonArchived: archived; history only.
+	ReasonArchived Reason = "archived"
+)
+
+var reasons = map[State]Reason{
+	StatePending:          ReasonAwaitingConsent,
+	StateAwaitingApproval: ReasonAwaitingApproval,
+	StateActive:           ReasonOK,
+	StateFailed:           ReasonConsentFailed,
+	StateCancelled:        ReasonConsentCancelled,
+	StateExpired:          ReasonConsentExpired,
+	StateRejected:         ReasonApprovalRejected,
+	StateNeedsReauth:      ReasonReauthRequired,
+	StateCompromised:      ReasonCompromised,
+	StateSuspended:        ReasonSuspended,
+	StateRevoked:          ReasonRevoked,
+	StateArchived:         ReasonArchived,
+}
+
+// Reason returns the reason reported for a connection in state s. Callers
+// that know more, such as a failing refresh, may report a more specific
+// one.
+func (s State) Reason() Reason {
+	if r, ok := reasons[s]; ok {
+		return r
+	}
+	return Reason(s)
+}
+
+// CanTransition reports whether a connection in state s may move to next.
+func (s State) CanTransition(next State) bool {
+	for _, allowed := range transitions[s] {
+		if allowed == next {
+			return true
+		}
+	}
+	return false
+}
+
+// Live returns the states of connections that are active or may still
+// become active, such as pending, awaiting approval or suspended ones, in
+// a stable order. Their credentials must not be discarded.
+func Live() []State {
+	var live []State
+	for s := range reasons {
+		if s == StateActive || s.CanTransition(StateActive) {
+			live = append(live, s)
+		}
+	}
+	sort.Slice(live, func(i, j int) bool { return live[i] < live[j] })
+	return live
+}
+
+// ErrNotFound is returned by Transition when the connection does not exist.
+var ErrNotFound = errors.New("connection not found")
+
+// TransitionError is returned by Transition for a status change the state
+// machine does not allow.
+type TransitionError struct {
+	From State
+	To   State
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("illegal connection transition %s -> %s", e.From, e.To)
+}
+
+var (
+	metricTransitions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "connection_state_transitions_total",
+		Help: "Connection status changes by from/to state",
+	}, []string{"from", "to"})
+	metricRejected = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "connection_state_transitions_rejected_total",
+		Help: "Connection status changes rejected by the state machine",
+	}, []string{"from", "to"})
+)
+
+func init() {
+	prometheus.MustRegister(metricTransitions, metricRejected)
+}
+
+// Count adds n changes from one state to another to the transition
+// metrics, for stores that change statuses without Transition.
+func Count(from, to State, n int64) {
+	metricTransitions.WithLabelValues(string(from), string(to)).Add(float64(n))
+}
+
+// Transition moves a connection to state to, recording the change in
+// connection_state_transitions. Moving to the current state is a no-op.
+// It returns the previous state, a *TransitionError if the change is not
+// allowed, or ErrNotFound.
+func Transition(ctx context.Context, db *sqlx.DB, id uuid.UUID, to State) (State, error) {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	from, err := TransitionTx(ctx, tx, id, to)
+	if err != nil || from == to {
+		return from, err
+	}
+	if err := tx.Commit(); err != nil {
+		return from, err
+	}
+	metricTransitions.WithLabelValues(string(from), string(to)).Inc()
+	return from, nil
+}
+
+// TransitionTx is Transition inside tx, for status changes that must
+// commit together with other writes. The change is not counted in the
+// transition metrics.
+func TransitionTx(ctx context.Context, tx *sqlx.Tx, id uuid.UUID, to State) (State, error) {
+	var from State
+	err := tx.QueryRowContext(ctx, `SELECT status FROM connections WHERE id = $1 FOR UPDATE`, id).Scan(&from)
+	if err == sql.ErrNoRows {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	if from == to {
+		return from, nil
+	}
+	if !from.CanTransition(to) {
+		metricRejected.WithLabelValues(string(from), string(to)).Inc()
+		return from, &TransitionError{From: from, To: to}
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE connections SET status = $1, status_changed_at = NOW(), updated_at = NOW() WHERE id = $2`,
+		to, id); err != nil {
+		return from, err
+	}
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO connection_state_transitions (connection_id, from_state, to_state) VALUES ($1, $2, $3)`,
+		id, from, to); err != nil {
+		return from, err
+	}
+	return from, nil
+}
+
+// ExpirePending moves pending connections past their expires_at to
+// StateExpired and returns how many were expired.
+func ExpirePending(ctx context.Context, db *sqlx.DB) (int64, error) {
+	res, err := db.ExecContext(ctx, `
+		WITH expired AS (
+			UPDATE connections SET status = $1, status_changed_at = NOW(), updated_at = NOW()
+			WHERE status = $2 AND expires_at <= NOW()
+			RETURNING id
+		)
+		INSERT INTO connection_state_transitions (connection_id, from_state, to_state)
+		SELECT id, $2, $1 FROM expired`,
+		StateExpired, StatePending)
+	if err != nil {
+		return 0, err
+	}
+	n, _ := res.RowsAffected()
+	Count(StatePending, StateExpired, n)
+	return n, nil
+}
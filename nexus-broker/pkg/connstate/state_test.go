@@ -0,0 +1,154 @@
+package connstate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+func TestCanTransition(t *testing.T) {
+	cases := []struct {
+		from, to State
+		want     bool
+	}{
+		{StatePending, StateActive, true},
+		{StatePending, StateFailed, true},
+		{StatePending, StateExpired, true},
+		{StateActive, StateNeedsReauth, true},
+		{StateActive, StateRevoked, true},
+		{StateNeedsReauth, StateRevoked, true},
+		{StateRevoked, StateArchived, true},
+		{StateActive, StateCompromised, true},
+		{StateCompromised, StateRevoked, true},
+		{StateCompromised, StateActive, false},
+		{StateRevoked, StateActive, false},
+		{StateFailed, StateActive, false},
+		{StateExpired, StateActive, false},
+		{StateNeedsReauth, StateActive, true},
+		{StateActive, StatePending, false},
+		{StateArchived, StateActive, false},
+		{StateActive, StateSuspended, true},
+		{StateSuspended, StateActive, true},
+		{StateSuspended, StateRevoked, true},
+		{StateNeedsReauth, StateSuspended, false},
+		{StateSuspended, StateNeedsReauth, false},
+		{StatePending, StateAwaitingApproval, true},
+		{StateAwaitingApproval, StateActive, true},
+		{StateAwaitingApproval, StateRejected, true},
+		{StateRejected, StateActive, false},
+		{StateActive, StateAwaitingApproval, false},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.want, c.from.CanTransition(c.to), "%s -> %s", c.from, c.to)
+	}
+}
+
+func TestLive(t *testing.T) {
+	assert.Equal(t, []State{StateActive, StateAwaitingApproval, StateNeedsReauth, StatePending, StateSuspended}, Live())
+}
+
+func TestReason(t *testing.T) {
+	for s := range transitions {
+		assert.Contains(t, reasons, s, "%s has no reason", s)
+	}
+	assert.Equal(t, ReasonReauthRequired, StateNeedsReauth.Reason())
+	assert.Equal(t, ReasonArchived, StateArchived.Reason())
+}
+
+func newMockDB(t *testing.T) (*sqlx.DB, sqlmock.Sqlmock) {
+	t.Helper()
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	return sqlx.NewDb(db, "sqlmock"), mock
+}
+
+func TestTransition_Allowed(t *testing.T) {
+	db, mock := newMockDB(t)
+	id := uuid.New()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT status FROM connections WHERE id = \\$1 FOR UPDATE").
+		WithArgs(id).
+		WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow("active"))
+	mock.ExpectExec("UPDATE connections SET status = \\$1, status_changed_at = NOW\\(\\)").
+		WithArgs("needs_reauth", id).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO connection_state_transitions").
+		WithArgs(id, "active", "needs_reauth").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	from, err := Transition(context.Background(), db, id, StateNeedsReauth)
+	require.NoError(t, err)
+	assert.Equal(t, StateActive, from)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestTransition_IllegalRollsBack(t *testing.T) {
+	db, mock := newMockDB(t)
+	id := uuid.New()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT status FROM connections").
+		WithArgs(id).
+		WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow("revoked"))
+	mock.ExpectRollback()
+
+	from, err := Transition(context.Background(), db, id, StateActive)
+	var terr *TransitionError
+	require.True(t, errors.As(err, &terr), "got %v", err)
+	assert.Equal(t, StateRevoked, terr.From)
+	assert.Equal(t, StateActive, terr.To)
+	assert.Equal(t, StateRevoked, from)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestTransition_SameStateIsNoop(t *testing.T) {
+	db, mock := newMockDB(t)
+	id := uuid.New()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT status FROM connections").
+		WithArgs(id).
+		WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow("active"))
+	mock.ExpectRollback()
+
+	_, err := Transition(context.Background(), db, id, StateActive)
+	require.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestTransition_NotFound(t *testing.T) {
+	db, mock := newMockDB(t)
+	id := uuid.New()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT status FROM connections").
+		WithArgs(id).
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectRollback()
+
+	_, err := Transition(context.Background(), db, id, StateActive)
+	assert.Equal(t, ErrNotFound, err)
+}
+
+func TestExpirePending(t *testing.T) {
+	db, mock := newMockDB(t)
+
+	mock.ExpectExec("UPDATE connections SET status = \\$1").
+		WithArgs("expired", "pending").
+		WillReturnResult(sqlmock.NewResult(0, 2))
+
+	n, err := ExpirePending(context.Background(), db)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), n)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -18,6 +18,12 @@ type OIDCMetadata struct {
 	AuthorizationEndpoint string `json:"authorization_endpoint"`
 	TokenEndpoint         string `json:"token_endpoint"`
 	JWKSURI               string `json:"jwks_uri"`
+	// RegistrationEndpoint is set by providers that support Dynamic Client
+	// Registration (RFC 7591).
+	RegistrationEndpoint string `json:"registration_endpoint,omitempty"`
+	// PushedAuthorizationRequestEndpoint is set by providers that accept
+	// pushed authorization requests (RFC 9126).
+	PushedAuthorizationRequestEndpoint string `json:"pushed_authorization_request_endpoint,omitempty"`
 }
 
 var (
@@ -0,0 +1,106 @@
+// Package dpop creates DPoP proofs (RFC 9449). A proof binds the tokens a
+// provider issues to a key pair of the connection's own, so a leaked token
+// is of no use without the key.
+package dpop
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+)
+
+// HTTP headers of the protocol.
+const (
+	// Header carries the proof of a request.
+	Header = "DPoP"
+	// NonceHeader carries a nonce the server wants in the next proof.
+	NonceHeader = "DPoP-Nonce"
+)
+
+// TokenType is the token_type of a DPoP-bound access token.
+const TokenType = "DPoP"
+
+// Key is a connection's DPoP key pair: a P-256 key signing with ES256.
+type Key struct {
+	jwk    jose.JSONWebKey
+	signer jose.Signer
+}
+
+// Generate returns a new key.
+func Generate() (*Key, error) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return newKey(jose.JSONWebKey{Key: priv, Algorithm: string(jose.ES256), Use: "sig"})
+}
+
+// Parse reads a key from the private JWK that MarshalJSON wrote.
+func Parse(data []byte) (*Key, error) {
+	var jwk jose.JSONWebKey
+	if err := json.Unmarshal(data, &jwk); err != nil {
+		return nil, fmt.Errorf("invalid DPoP key: %w", err)
+	}
+	priv, ok := jwk.Key.(*ecdsa.PrivateKey)
+	if !ok || priv.Curve != elliptic.P256() {
+		return nil, errors.New("invalid DPoP key: not a P-256 private key")
+	}
+	return newKey(jwk)
+}
+
+func newKey(jwk jose.JSONWebKey) (*Key, error) {
+	signer, err := jose.NewSigner(
+		jose.SigningKey{Algorithm: jose.ES256, Key: jwk.Key},
+		(&jose.SignerOptions{EmbedJWK: true}).WithType("dpop+jwt"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &Key{jwk: jwk, signer: signer}, nil
+}
+
+// MarshalJSON writes the key as a private JWK.
+func (k *Key) MarshalJSON() ([]byte, error) {
+	return k.jwk.MarshalJSON()
+}
+
+// Proof returns a proof for a request with method to target, including
+// nonce if the server issued one.
+func (k *Key) Proof(method, target, nonce string, now time.Time) (string, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return "", err
+	}
+	u.RawQuery, u.Fragment = "", ""
+
+	jti := make([]byte, 16)
+	if _, err := rand.Read(jti); err != nil {
+		return "", err
+	}
+	claims := map[string]interface{}{
+		"jti": base64.RawURLEncoding.EncodeToString(jti),
+		"htm": method,
+		"htu": u.String(),
+		"iat": now.Unix(),
+	}
+	if nonce != "" {
+		claims["nonce"] = nonce
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	obj, err := k.signer.Sign(payload)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign DPoP proof: %w", err)
+	}
+	return obj.CompactSerialize()
+}
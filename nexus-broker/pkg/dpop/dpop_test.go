@@ -0,0 +1,63 @@
+package dpop
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKey_Proof(t *testing.T) {
+	key, err := Generate()
+	require.NoError(t, err)
+
+	now := time.Unix(1700000000, 0)
+	proof, err := key.Proof("POST", "https://idp.example/token?x=1#f", "n-1", now)
+	require.NoError(t, err)
+
+	jws, err := jose.ParseSigned(proof, []jose.SignatureAlgorithm{jose.ES256})
+	require.NoError(t, err)
+	header := jws.Signatures[0].Header
+	assert.Equal(t, "dpop+jwt", header.ExtraHeaders["typ"])
+	require.NotNil(t, header.JSONWebKey)
+	assert.True(t, header.JSONWebKey.IsPublic(), "the proof must embed only the public key")
+	payload, err := jws.Verify(header.JSONWebKey)
+	require.NoError(t, err)
+
+	var claims map[string]interface{}
+	require.NoError(t, json.Unmarshal(payload, &claims))
+	assert.Equal(t, "POST", claims["htm"])
+	assert.Equal(t, "https://idp.example/token", claims["htu"])
+	assert.Equal(t, "n-1", claims["nonce"])
+	assert.Equal(t, float64(now.Unix()), claims["iat"])
+	assert.NotEmpty(t, claims["jti"])
+
+	again, err := key.Proof("POST", "https://idp.example/token", "", now)
+	require.NoError(t, err)
+	assert.NotEqual(t, proof, again)
+}
+
+func TestKey_RoundTrip(t *testing.T) {
+	key, err := Generate()
+	require.NoError(t, err)
+	data, err := json.Marshal(key)
+	require.NoError(t, err)
+
+	var jwk map[string]interface{}
+	require.NoError(t, json.Unmarshal(data, &jwk))
+	assert.Equal(t, "EC", jwk["kty"])
+	assert.Equal(t, "P-256", jwk["crv"])
+	assert.NotEmpty(t, jwk["d"])
+
+	parsed, err := Parse(data)
+	require.NoError(t, err)
+	again, err := json.Marshal(parsed)
+	require.NoError(t, err)
+	assert.JSONEq(t, string(data), string(again))
+
+	_, err = Parse([]byte(`{"kty":"oct","k":"c2VjcmV0"}`))
+	assert.Error(t, err)
+}
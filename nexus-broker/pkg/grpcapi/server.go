@@ -0,0 +1,403 @@
+// Package grpcapi serves the Broker API over gRPC (nexus.broker.v1.BrokerService).
+//
+// Each RPC calls the logic of the REST route it mirrors (see router.API)
+// with typed requests and responses. Calls first pass the protected routes'
+// middleware, so gRPC callers get the same API key and allowlist checks,
+// audit events, drain behaviour and errors as REST callers. The REST
+// endpoints are unchanged.
+package grpcapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	brokerpb "github.com/Prescott-Data/nexus-framework/nexus-common/gen/go/api/proto/broker/v1"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/internal/audit"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/handlers"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/httputil"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/provider"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/redact"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/router"
+)
+
+// ErrorDomain is the ErrorInfo domain of Broker errors. The reason is the
+// REST error code and the http_status metadata entry the REST status.
+const ErrorDomain = "nexus-broker"
+
+// forwardedMetadata lists the metadata keys copied onto the request the
+// handlers are given as headers. X-Forwarded-For is not among them: the
+// client address comes from the gRPC peer alone, so a caller cannot claim
+// one the allowlist trusts.
+var forwardedMetadata = map[string]string{
+	"x-api-key":      "X-API-Key",
+	"x-nexus-caller": audit.CallerHeader,
+	"x-request-id":   "X-Request-Id",
+	"user-agent":     "User-Agent",
+}
+
+// Service implements brokerpb.BrokerServiceServer on top of the Broker's
+// handlers.
+type Service struct {
+	brokerpb.UnimplementedBrokerServiceServer
+	api      *router.API
+	draining func() bool
+}
+
+// NewService returns a Service calling api's handlers. ConsentSpec is
+// refused while draining reports true, as POST /auth/consent-spec is.
+func NewService(api *router.API, draining func() bool) *Service {
+	return &Service{api: api, draining: draining}
+}
+
+// NewServer returns a gRPC server with the BrokerService registered.
+func NewServer(api *router.API, draining func() bool, opts ...grpc.ServerOption) *grpc.Server {
+	s := grpc.NewServer(opts...)
+	brokerpb.RegisterBrokerServiceServer(s, NewService(api, draining))
+	return s
+}
+
+// ConsentSpec implements BrokerServiceServer.ConsentSpec.
+func (s *Service) ConsentSpec(ctx context.Context, req *brokerpb.ConsentSpecRequest) (*brokerpb.ConsentSpecResponse, error) {
+	r, err := s.authorize(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if s.draining != nil && s.draining() {
+		return nil, errorStatus(&handlers.Error{Status: http.StatusServiceUnavailable, Code: "shutting_down",
+			Message: "Server is shutting down, retry shortly", RetryAfter: "5"})
+	}
+	spec, err := s.api.Consent.Spec(r.Context(), handlers.ConsentSpecRequest{
+		WorkspaceID: req.GetWorkspaceId(),
+		ProviderID:  req.GetProviderId(),
+		Scopes:      req.GetScopes(),
+		ReturnURL:   req.GetReturnUrl(),
+		Tenant:      req.GetTenant(),
+		Subject:     req.GetSubject(),
+		SessionHash: req.GetSessionHash(),
+	})
+	if err != nil {
+		return nil, errorStatus(err)
+	}
+	return &brokerpb.ConsentSpecResponse{
+		AuthUrl:    spec.AuthURL,
+		State:      spec.State,
+		Scopes:     spec.Scopes,
+		ProviderId: spec.ProviderID,
+	}, nil
+}
+
+// GetToken implements BrokerServiceServer.GetToken.
+func (s *Service) GetToken(ctx context.Context, req *brokerpb.GetTokenRequest) (*brokerpb.TokenResponse, error) {
+	if req.GetConnectionId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "missing connection_id")
+	}
+	r, err := s.authorize(ctx)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.api.Callback.ConnectionToken(r, req.GetConnectionId())
+	if err != nil {
+		return nil, errorStatus(err)
+	}
+	token := tokenFields(resp.Credentials, resp.ExpiresAt)
+	if resp.Strategy != nil {
+		token.Strategy = &brokerpb.AuthStrategy{}
+		token.Strategy.Type, _ = resp.Strategy["type"].(string)
+		if config, ok := resp.Strategy["config"].(map[string]interface{}); ok {
+			if token.Strategy.Config, err = structpb.NewStruct(config); err != nil {
+				return nil, status.Errorf(codes.Internal, "encode strategy: %v", err)
+			}
+		}
+	}
+	if token.Credentials, err = structpb.NewStruct(resp.Credentials); err != nil {
+		return nil, status.Errorf(codes.Internal, "encode credentials: %v", err)
+	}
+	return &brokerpb.TokenResponse{Token: token}, nil
+}
+
+// Refresh implements BrokerServiceServer.Refresh.
+func (s *Service) Refresh(ctx context.Context, req *brokerpb.RefreshRequest) (*brokerpb.TokenResponse, error) {
+	if req.GetConnectionId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "missing connection_id")
+	}
+	r, err := s.authorize(ctx)
+	if err != nil {
+		return nil, err
+	}
+	tokens, err := s.api.Callback.RefreshConnection(r, req.GetConnectionId())
+	if err != nil {
+		return nil, errorStatus(err)
+	}
+	var expiry *time.Time
+	if secs, ok := tokens["expires_in"].(float64); ok && secs > 0 {
+		t := time.Now().Add(time.Duration(secs) * time.Second)
+		expiry = &t
+	}
+	return &brokerpb.TokenResponse{Token: tokenFields(tokens, expiry)}, nil
+}
+
+// tokenFields returns the Token with the standard OAuth 2.0 fields of
+// fields.
+func tokenFields(fields map[string]interface{}, expiry *time.Time) *brokerpb.Token {
+	str := func(key string) string {
+		v, _ := fields[key].(string)
+		return v
+	}
+	token := &brokerpb.Token{
+		AccessToken:  str("access_token"),
+		TokenType:    str("token_type"),
+		RefreshToken: str("refresh_token"),
+		IdToken:      str("id_token"),
+	}
+	if expiry != nil {
+		token.Expiry = timestamppb.New(*expiry)
+	}
+	return token
+}
+
+// GetConnectionStatus implements BrokerServiceServer.GetConnectionStatus.
+func (s *Service) GetConnectionStatus(ctx context.Context, req *brokerpb.GetConnectionStatusRequest) (*brokerpb.ConnectionStatusResponse, error) {
+	if req.GetConnectionId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "missing connection_id")
+	}
+	r, err := s.authorize(ctx)
+	if err != nil {
+		return nil, err
+	}
+	st, err := s.api.Connections.StatusOf(r.Context(), req.GetConnectionId())
+	if err != nil {
+		return nil, errorStatus(err)
+	}
+	return &brokerpb.ConnectionStatusResponse{Status: st.Status, Reason: st.Reason, ErrorCode: st.ErrorCode}, nil
+}
+
+// ResolveProvider implements BrokerServiceServer.ResolveProvider.
+func (s *Service) ResolveProvider(ctx context.Context, req *brokerpb.ResolveProviderRequest) (*brokerpb.ResolveProviderResponse, error) {
+	name := strings.TrimSpace(req.GetName())
+	if name == "" {
+		return nil, status.Error(codes.InvalidArgument, "missing name")
+	}
+	if _, err := s.authorize(ctx); err != nil {
+		return nil, err
+	}
+	workspaceID, err := workspace(req.GetWorkspaceId())
+	if err != nil {
+		return nil, err
+	}
+	p, err := s.api.Providers.ProviderByName(name, workspaceID)
+	if err != nil {
+		return nil, errorStatus(err)
+	}
+	return &brokerpb.ResolveProviderResponse{ProviderId: p.ID.String()}, nil
+}
+
+// GetMetadata implements BrokerServiceServer.GetMetadata.
+func (s *Service) GetMetadata(ctx context.Context, req *brokerpb.GetMetadataRequest) (*brokerpb.GetMetadataResponse, error) {
+	if _, err := s.authorize(ctx); err != nil {
+		return nil, err
+	}
+	workspaceID, err := workspace(req.GetWorkspaceId())
+	if err != nil {
+		return nil, err
+	}
+	byType, err := s.api.Providers.ProviderMetadata(workspaceID)
+	if err != nil {
+		return nil, errorStatus(err)
+	}
+	resp := &brokerpb.GetMetadataResponse{}
+	for _, authType := range sortedKeys(byType) {
+		byName := byType[authType]
+		for _, name := range sortedKeys(byName) {
+			m := byName[name]
+			resp.Providers = append(resp.Providers, &brokerpb.ProviderMetadata{
+				Id:               m.ID,
+				Name:             name,
+				AuthType:         authType,
+				ApiBaseUrl:       m.APIBaseURL,
+				UserInfoEndpoint: m.UserInfoEndpoint,
+				Scopes:           m.Scopes,
+				Description:      m.Description,
+				Category:         m.Category,
+			})
+		}
+	}
+	return resp, nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// workspace trims a request's workspace_id. Like the workspace_id query
+// parameter it must name one workspace; gRPC callers have no
+// all_workspaces.
+func workspace(workspaceID string) (string, error) {
+	workspaceID = strings.TrimSpace(workspaceID)
+	if workspaceID == provider.AllWorkspaces {
+		return "", errorStatus(&handlers.Error{Status: http.StatusBadRequest, Code: "invalid_workspace_id",
+			Message: "workspace_id must name one workspace, without all_workspaces"})
+	}
+	return workspaceID, nil
+}
+
+// authorize passes the call through the protected routes' middleware and
+// returns the request it lets through, for the handlers to audit: the
+// call's metadata as headers, the gRPC peer as RemoteAddr and the
+// authenticated caller in its context. A call the middleware refuses gets
+// the status of its answer.
+func (s *Service) authorize(ctx context.Context) (*http.Request, error) {
+	method, ok := grpc.Method(ctx)
+	if !ok {
+		method = "/"
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, method, http.NoBody)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "build request: %v", err)
+	}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		for key, header := range forwardedMetadata {
+			for _, v := range md.Get(key) {
+				req.Header.Add(header, v)
+			}
+		}
+	}
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		req.RemoteAddr = p.Addr.String()
+	}
+
+	var authorized *http.Request
+	rec := newRecorder()
+	s.api.Protect(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		authorized = r
+	})).ServeHTTP(rec, req)
+	if authorized == nil {
+		return nil, statusFromResponse(rec.status, rec.body.Bytes())
+	}
+	return authorized, nil
+}
+
+// errorStatus turns a handler failure into a status whose ErrorInfo keeps
+// the REST error code and HTTP status, so clients can reproduce the REST
+// response exactly.
+func errorStatus(err error) error {
+	var e *handlers.Error
+	if !errors.As(err, &e) {
+		return status.Errorf(codes.Internal, "%v", err)
+	}
+	md := map[string]string{"http_status": strconv.Itoa(e.Status)}
+	if e.RetryAfter != "" {
+		md["retry_after"] = e.RetryAfter
+	}
+	st := status.New(CodeFromHTTP(e.Status), redact.String(e.Message))
+	if detailed, err := st.WithDetails(&errdetails.ErrorInfo{
+		Reason:   e.Code,
+		Domain:   ErrorDomain,
+		Metadata: md,
+	}); err == nil {
+		st = detailed
+	}
+	return st.Err()
+}
+
+// statusFromResponse turns a REST error answer, as the middleware writes
+// it, into a status; see errorStatus.
+func statusFromResponse(httpStatus int, body []byte) error {
+	var apiErr httputil.APIError
+	_ = json.Unmarshal(body, &apiErr)
+	code := apiErr.Code
+	if code == "" {
+		code = apiErr.Error
+	}
+	if code == "" {
+		code = strings.ReplaceAll(strings.ToLower(http.StatusText(httpStatus)), " ", "_")
+	}
+	msg := apiErr.Detail
+	if msg == "" {
+		msg = apiErr.Message
+	}
+	if msg == "" {
+		msg = fmt.Sprintf("broker status %d", httpStatus)
+	}
+	return errorStatus(&handlers.Error{Status: httpStatus, Code: code, Message: msg})
+}
+
+// CodeFromHTTP maps a REST status to the closest gRPC code.
+func CodeFromHTTP(httpStatus int) codes.Code {
+	switch httpStatus {
+	case http.StatusBadRequest, http.StatusUnprocessableEntity:
+		return codes.InvalidArgument
+	case http.StatusUnauthorized:
+		return codes.Unauthenticated
+	case http.StatusForbidden:
+		return codes.PermissionDenied
+	case http.StatusNotFound:
+		return codes.NotFound
+	case http.StatusConflict:
+		return codes.AlreadyExists
+	case http.StatusGone, http.StatusPreconditionFailed:
+		return codes.FailedPrecondition
+	case http.StatusTooManyRequests:
+		return codes.ResourceExhausted
+	case http.StatusNotImplemented:
+		return codes.Unimplemented
+	case http.StatusServiceUnavailable, http.StatusBadGateway:
+		return codes.Unavailable
+	case http.StatusGatewayTimeout:
+		return codes.DeadlineExceeded
+	}
+	switch {
+	case httpStatus >= 400 && httpStatus < 500:
+		return codes.FailedPrecondition
+	default:
+		return codes.Internal
+	}
+}
+
+// recorder is the http.ResponseWriter handed to the middleware.
+type recorder struct {
+	header      http.Header
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func newRecorder() *recorder {
+	return &recorder{header: http.Header{}, status: http.StatusOK}
+}
+
+func (r *recorder) Header() http.Header { return r.header }
+
+func (r *recorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status = status
+		r.wroteHeader = true
+	}
+}
+
+func (r *recorder) Write(p []byte) (int, error) {
+	r.WriteHeader(http.StatusOK)
+	return r.body.Write(p)
+}
@@ -0,0 +1,310 @@
+package grpcapi
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	brokerpb "github.com/Prescott-Data/nexus-framework/nexus-common/gen/go/api/proto/broker/v1"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/internal/store"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/config"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/connstate"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/provider"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/router"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/server"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/vault"
+	"github.com/Prescott-Data/nexus-framework/nexus-common/state"
+)
+
+// testProfiles is the provider registry of the tests: the read methods the
+// RPCs use, over a fixed list.
+type testProfiles struct {
+	router.ProfileStore
+	list []provider.Profile
+}
+
+func (s *testProfiles) GetProfileByName(name string) (*provider.Profile, error) {
+	for _, p := range s.list {
+		if p.Name == name {
+			return &p, nil
+		}
+	}
+	return nil, provider.ErrNotFound
+}
+
+func (s *testProfiles) GetMetadata(workspaceID string) (map[string]map[string]provider.Metadata, error) {
+	out := map[string]map[string]provider.Metadata{}
+	for _, p := range s.list {
+		if out[p.AuthType] == nil {
+			out[p.AuthType] = map[string]provider.Metadata{}
+		}
+		out[p.AuthType][p.Name] = provider.Metadata{ID: p.ID.String(), Scopes: p.Scopes, Category: workspaceID}
+	}
+	return out, nil
+}
+
+// fixture is a Broker API over in-memory stores with two providers:
+// "static", an api_key provider, and "idp", an OAuth 2.0 provider whose
+// token endpoint answers refreshes.
+type fixture struct {
+	api   *router.API
+	store *store.Memory
+	key   []byte
+
+	staticID, idpID uuid.UUID
+}
+
+func newFixture(t *testing.T, configure func(*config.BrokerConfig)) *fixture {
+	t.Helper()
+	idp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token":"at-2","token_type":"Bearer","refresh_token":"rt-2","expires_in":3600}`))
+	}))
+	t.Cleanup(idp.Close)
+
+	states, err := state.NewKeyring(state.Key{Secret: []byte("0123456789abcdef0123456789abcdef")}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fixture{store: store.NewMemory(), key: make([]byte, 32), staticID: uuid.New(), idpID: uuid.New()}
+	f.store.PutProvider(store.Provider{ID: f.staticID, Name: "static", AuthType: "api_key"})
+	f.store.PutProvider(store.Provider{ID: f.idpID, Name: "idp", AuthType: "oauth2",
+		AuthURL: idp.URL + "/authorize", TokenURL: idp.URL + "/token", ClientID: "c", ClientSecret: "s"})
+	profiles := &testProfiles{list: []provider.Profile{
+		{ID: f.staticID, Name: "static", AuthType: "api_key"},
+		{ID: f.idpID, Name: "idp", AuthType: "oauth2", Scopes: []string{"openid"}},
+	}}
+
+	cfg := &config.BrokerConfig{
+		BaseURL:            "https://broker.example",
+		RedirectPath:       "/auth/callback",
+		EncryptionKey:      f.key,
+		StateKeys:          states,
+		RequireAPIKey:      true,
+		APIKeys:            map[string]struct{}{"k1": {}},
+		HealthCheckTimeout: 2 * time.Second,
+	}
+	if configure != nil {
+		configure(cfg)
+	}
+	f.api, err = router.New(server.NewServer("", 0), cfg, router.Deps{Store: f.store, Profiles: profiles})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+// connect stores an active connection to providerID holding tokens.
+func (f *fixture) connect(t *testing.T, providerID uuid.UUID, tokens map[string]any) string {
+	t.Helper()
+	id := uuid.New()
+	f.store.PutConnection(store.Connection{ID: id, WorkspaceID: "ws1", ProviderID: providerID, Status: connstate.StateActive})
+	plaintext, err := json.Marshal(tokens)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := vault.SealToken(f.key, plaintext, id.String(), "ws1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expiry := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	if err := f.store.SaveTokens(context.Background(), id, sealed, &expiry); err != nil {
+		t.Fatal(err)
+	}
+	return id.String()
+}
+
+func dial(t *testing.T, srv *grpc.Server) brokerpb.BrokerServiceClient {
+	t.Helper()
+	lis := bufconn.Listen(1 << 20)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return brokerpb.NewBrokerServiceClient(conn)
+}
+
+func withKey(ctx context.Context) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, "x-api-key", "k1", "x-nexus-caller", "svc-a")
+}
+
+func TestServiceCallsHandlers(t *testing.T) {
+	f := newFixture(t, nil)
+	client := dial(t, NewServer(f.api, nil))
+	ctx := withKey(context.Background())
+
+	spec, err := client.ConsentSpec(ctx, &brokerpb.ConsentSpecRequest{WorkspaceId: "ws1", ProviderId: f.staticID.String(), Scopes: []string{"read"}, ReturnUrl: "https://app.example/done"})
+	if err != nil {
+		t.Fatalf("ConsentSpec: %v", err)
+	}
+	if !strings.HasPrefix(spec.GetAuthUrl(), "https://broker.example/auth/capture-schema?state=") || spec.GetState() == "" || spec.GetProviderId() != f.staticID.String() || len(spec.GetScopes()) != 1 {
+		t.Errorf("ConsentSpec = %v", spec)
+	}
+
+	staticConn := f.connect(t, f.staticID, map[string]any{"api_key": "secret"})
+	resp, err := client.GetToken(ctx, &brokerpb.GetTokenRequest{ConnectionId: staticConn})
+	if err != nil {
+		t.Fatalf("GetToken: %v", err)
+	}
+	tok := resp.GetToken()
+	if tok.GetStrategy().GetType() != "header" || tok.GetStrategy().GetConfig().AsMap()["header_name"] != "X-API-Key" {
+		t.Errorf("GetToken strategy = %v", tok.GetStrategy())
+	}
+	if tok.GetCredentials().AsMap()["api_key"] != "secret" || tok.GetExpiry() == nil || tok.GetAccessToken() != "" {
+		t.Errorf("GetToken = %v", tok)
+	}
+	var audited bool
+	for _, e := range f.store.Events() {
+		if e.EventType == "token_retrieved" && e.EventData != nil && strings.Contains(*e.EventData, `"caller":"svc-a"`) {
+			audited = true
+		}
+	}
+	if !audited {
+		t.Errorf("token_retrieved event with the caller not recorded: %v", f.store.Events())
+	}
+
+	idpConn := f.connect(t, f.idpID, map[string]any{"access_token": "at-1", "token_type": "Bearer", "refresh_token": "rt-1"})
+	resp, err = client.GetToken(ctx, &brokerpb.GetTokenRequest{ConnectionId: idpConn})
+	if err != nil {
+		t.Fatalf("GetToken: %v", err)
+	}
+	if tok := resp.GetToken(); tok.GetAccessToken() != "at-1" || tok.GetRefreshToken() != "rt-1" || tok.GetTokenType() != "Bearer" || tok.GetStrategy().GetType() != "oauth2" {
+		t.Errorf("GetToken = %v", tok)
+	}
+
+	resp, err = client.Refresh(ctx, &brokerpb.RefreshRequest{ConnectionId: idpConn})
+	if err != nil {
+		t.Fatalf("Refresh: %v", err)
+	}
+	if tok := resp.GetToken(); tok.GetAccessToken() != "at-2" || tok.GetRefreshToken() != "rt-2" || tok.GetExpiry() == nil {
+		t.Errorf("Refresh = %v", tok)
+	}
+
+	cs, err := client.GetConnectionStatus(ctx, &brokerpb.GetConnectionStatusRequest{ConnectionId: idpConn})
+	if err != nil || cs.GetStatus() != string(connstate.StateActive) {
+		t.Errorf("GetConnectionStatus = %v, %v", cs, err)
+	}
+
+	p, err := client.ResolveProvider(ctx, &brokerpb.ResolveProviderRequest{Name: "idp"})
+	if err != nil || p.GetProviderId() != f.idpID.String() {
+		t.Errorf("ResolveProvider = %v, %v", p, err)
+	}
+
+	md, err := client.GetMetadata(ctx, &brokerpb.GetMetadataRequest{WorkspaceId: "ws1"})
+	if err != nil {
+		t.Fatalf("GetMetadata: %v", err)
+	}
+	got := md.GetProviders()
+	if len(got) != 2 || got[0].GetName() != "static" || got[0].GetAuthType() != "api_key" ||
+		got[1].GetName() != "idp" || got[1].GetId() != f.idpID.String() || got[1].GetCategory() != "ws1" || len(got[1].GetScopes()) != 1 {
+		t.Errorf("GetMetadata = %v", got)
+	}
+}
+
+func TestServiceReportsRESTErrors(t *testing.T) {
+	f := newFixture(t, nil)
+	draining := false
+	client := dial(t, NewServer(f.api, func() bool { return draining }))
+	staticConn := f.connect(t, f.staticID, map[string]any{"api_key": "secret"})
+	ctx := withKey(context.Background())
+
+	tests := []struct {
+		name       string
+		call       func() error
+		code       codes.Code
+		reason     string
+		httpStatus string
+	}{
+		{"missing key", func() error {
+			_, err := client.GetToken(context.Background(), &brokerpb.GetTokenRequest{ConnectionId: staticConn})
+			return err
+		}, codes.Unauthenticated, "missing_api_key", "401"},
+		{"unknown connection", func() error {
+			_, err := client.GetToken(ctx, &brokerpb.GetTokenRequest{ConnectionId: uuid.NewString()})
+			return err
+		}, codes.NotFound, "connection_not_found", "404"},
+		{"static token", func() error {
+			_, err := client.Refresh(ctx, &brokerpb.RefreshRequest{ConnectionId: staticConn})
+			return err
+		}, codes.InvalidArgument, "static_token", "400"},
+		{"all workspaces", func() error {
+			_, err := client.GetMetadata(ctx, &brokerpb.GetMetadataRequest{WorkspaceId: provider.AllWorkspaces})
+			return err
+		}, codes.InvalidArgument, "invalid_workspace_id", "400"},
+		{"draining", func() error {
+			draining = true
+			defer func() { draining = false }()
+			_, err := client.ConsentSpec(ctx, &brokerpb.ConsentSpecRequest{WorkspaceId: "ws1", ProviderId: f.staticID.String(), ReturnUrl: "https://app.example/done"})
+			return err
+		}, codes.Unavailable, "shutting_down", "503"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := status.Convert(tt.call())
+			if st.Code() != tt.code {
+				t.Fatalf("code = %v, want %v (%v)", st.Code(), tt.code, st.Message())
+			}
+			var info *errdetails.ErrorInfo
+			for _, d := range st.Details() {
+				if i, ok := d.(*errdetails.ErrorInfo); ok {
+					info = i
+				}
+			}
+			if info == nil || info.GetDomain() != ErrorDomain || info.GetReason() != tt.reason || info.GetMetadata()["http_status"] != tt.httpStatus {
+				t.Errorf("ErrorInfo = %v", info)
+			}
+		})
+	}
+}
+
+func TestServiceIgnoresForwardedFor(t *testing.T) {
+	// The Broker's router believes one proxy's X-Forwarded-For entry; a
+	// gRPC caller must not be able to supply one.
+	f := newFixture(t, func(cfg *config.BrokerConfig) {
+		cfg.RequireAllowlist = true
+		cfg.AllowedCIDRs = []string{"10.0.0.0/8"}
+	})
+
+	// bufconn has no IP address; the peer must be a real one, 127.0.0.1.
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	grpcSrv := NewServer(f.api, nil)
+	go grpcSrv.Serve(lis)
+	t.Cleanup(grpcSrv.Stop)
+	conn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	client := brokerpb.NewBrokerServiceClient(conn)
+
+	ctx := metadata.AppendToOutgoingContext(withKey(context.Background()), "x-forwarded-for", "10.1.2.3")
+	_, err = client.GetConnectionStatus(ctx, &brokerpb.GetConnectionStatusRequest{ConnectionId: uuid.NewString()})
+	if code := status.Code(err); code != codes.PermissionDenied {
+		t.Fatalf("code = %v, want %v", code, codes.PermissionDenied)
+	}
+}
@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/httputil"
+)
+
+// Error is a failed request as the REST API answers it. The handlers' typed
+// methods (ConsentHandler.Spec, CallbackHandler.ConnectionToken, ...) return
+// it so that the REST routes and the gRPC BrokerService report the same
+// failure the same way.
+type Error struct {
+	// Status is the HTTP status, Code the problem's error code.
+	Status  int
+	Code    string
+	Message string
+	// Details, when set, is the problem's details member.
+	Details map[string]interface{}
+	// RetryAfter, when set, is the Retry-After header, in seconds.
+	RetryAfter string
+}
+
+func (e *Error) Error() string { return e.Code + ": " + e.Message }
+
+// Write answers the request with e.
+func (e *Error) Write(w http.ResponseWriter) {
+	if e.RetryAfter != "" {
+		w.Header().Set("Retry-After", e.RetryAfter)
+	}
+	if e.Details != nil {
+		httputil.WriteErrorWithDetails(w, e.Status, e.Code, e.Message, e.Details)
+		return
+	}
+	httputil.WriteError(w, e.Status, e.Code, e.Message)
+}
+
+// writeError answers the request with err, which is an *Error unless
+// something unexpected failed.
+func writeError(w http.ResponseWriter, err error) {
+	var e *Error
+	if !errors.As(err, &e) {
+		e = &Error{Status: http.StatusInternalServerError, Code: "internal_error", Message: "Internal error"}
+	}
+	e.Write(w)
+}
@@ -0,0 +1,282 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/internal/audit"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/internal/store"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/internal/webhook"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/connstate"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/httputil"
+)
+
+// approvalParam is the provider param holding connections for approval.
+const approvalParam = "requires_approval"
+
+// maxRejectReason bounds the note an approver leaves on a rejection.
+const maxRejectReason = 500
+
+// requiresApproval reports whether the provider's requires_approval param
+// is set: its connections wait for an approver after consent.
+func requiresApproval(provider *store.Provider) bool {
+	if provider.Params == nil {
+		return false
+	}
+	var params map[string]interface{}
+	if err := json.Unmarshal(*provider.Params, &params); err != nil {
+		return false
+	}
+	on, _ := params[approvalParam].(bool)
+	return on
+}
+
+// ApprovalConnection is the data of the connection.approval_requested,
+// connection.approved and connection.rejected webhooks.
+type ApprovalConnection struct {
+	ConnectionID string   `json:"connection_id"`
+	WorkspaceID  string   `json:"workspace_id"`
+	ProviderID   string   `json:"provider_id"`
+	ProviderName string   `json:"provider_name,omitempty"`
+	Scopes       []string `json:"scopes"`
+	Email        string   `json:"email,omitempty"`
+	// DecidedBy is the approver, empty if unknown or not decided yet.
+	DecidedBy string `json:"decided_by,omitempty"`
+	// Reason is the approver's note on a rejection.
+	Reason     string    `json:"reason,omitempty"`
+	OccurredAt time.Time `json:"occurred_at"`
+}
+
+func approvalEvent(conn *store.Connection, providerName string) ApprovalConnection {
+	scopes := conn.Scopes
+	if scopes == nil {
+		scopes = []string{}
+	}
+	return ApprovalConnection{
+		ConnectionID: conn.ID.String(),
+		WorkspaceID:  conn.WorkspaceID,
+		ProviderID:   conn.ProviderID.String(),
+		ProviderName: providerName,
+		Scopes:       scopes,
+		Email:        conn.Email,
+		OccurredAt:   time.Now().UTC(),
+	}
+}
+
+// completeConsent moves a connection whose credentials were just stored
+// to active, or to awaiting_approval if its provider requires approval,
+// in which case a connection.approval_requested webhook is sent. It
+// returns the status to report to the return_url: "success" or
+// "awaiting_approval".
+func (h *CallbackHandler) completeConsent(ctx context.Context, r *http.Request, conn *store.Connection, provider *store.Provider) (string, error) {
+	if !requiresApproval(provider) {
+		return "success", h.updateConnectionStatus(ctx, conn.ID, connstate.StateActive)
+	}
+	if err := h.updateConnectionStatus(ctx, conn.ID, connstate.StateAwaitingApproval); err != nil {
+		return "", err
+	}
+	// The identity may have been recorded since conn was read.
+	if fresh, err := h.store.GetConnection(store.ReadPrimary(ctx), conn.ID); err == nil {
+		conn = fresh
+	}
+	h.logAuditEvent(&conn.ID, "approval_requested", map[string]string{"provider_id": conn.ProviderID.String(), "scopes": strings.Join(conn.Scopes, " ")}, r)
+	if err := h.webhook.Send(ctx, "connection.approval_requested", approvalEvent(conn, provider.Name)); err != nil {
+		log.Printf("approval: %v", err)
+	}
+	return string(connstate.StateAwaitingApproval), nil
+}
+
+// ApprovalsHandler serves the admin API approvers decide on connections
+// awaiting approval with.
+type ApprovalsHandler struct {
+	store   store.Store
+	audit   audit.Logger
+	webhook *webhook.Notifier
+}
+
+// ApprovalsHandlerConfig configures an ApprovalsHandler.
+type ApprovalsHandlerConfig struct {
+	// Store defaults to Postgres through DB.
+	Store store.Store
+	DB    *sqlx.DB
+	Audit audit.Logger
+	// Webhook receives connection.approved and connection.rejected events.
+	// Nil disables it.
+	Webhook *webhook.Notifier
+}
+
+// NewApprovalsHandler creates an ApprovalsHandler.
+func NewApprovalsHandler(cfg ApprovalsHandlerConfig) *ApprovalsHandler {
+	if cfg.Store == nil {
+		cfg.Store = store.NewPostgres(cfg.DB)
+	}
+	return &ApprovalsHandler{store: cfg.Store, audit: cfg.Audit, webhook: cfg.Webhook}
+}
+
+// List handles GET /admin/approvals: the connections awaiting approval,
+// oldest first, optionally of one workspace_id, at most limit (default 100,
+// max 1000).
+func (h *ApprovalsHandler) List(w http.ResponseWriter, r *http.Request) {
+	f := store.ApprovalFilter{WorkspaceID: strings.TrimSpace(r.URL.Query().Get("workspace_id")), Limit: 100}
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 1000 {
+			httputil.WriteError(w, http.StatusBadRequest, "invalid_limit", "limit must be between 1 and 1000")
+			return
+		}
+		f.Limit = n
+	}
+	conns, err := h.store.ListAwaitingApproval(r.Context(), f)
+	if err != nil {
+		log.Printf("approvals: list: %v", err)
+		httputil.WriteError(w, http.StatusInternalServerError, "list_failed", "Failed to list connections awaiting approval")
+		return
+	}
+	out := make([]ConnectionSummary, 0, len(conns))
+	for _, c := range conns {
+		out = append(out, summarizeConnection(c))
+	}
+	httputil.WriteJSON(w, http.StatusOK, map[string]interface{}{"connections": out, "count": len(out)})
+}
+
+// Approve handles POST /admin/approvals/{connectionID}/approve: a
+// connection awaiting approval becomes active and its tokens are served.
+// Approving an active connection succeeds without changes; any other
+// status is a 409.
+func (h *ApprovalsHandler) Approve(w http.ResponseWriter, r *http.Request) {
+	conn, ok := h.connection(w, r, "approve_failed")
+	if !ok {
+		return
+	}
+	previous := conn.Status
+	if previous != connstate.StateActive {
+		if previous != connstate.StateAwaitingApproval {
+			httputil.WriteError(w, http.StatusConflict, "invalid_transition", "A "+string(previous)+" connection cannot be approved")
+			return
+		}
+		if !h.transition(w, r, conn, connstate.StateActive, "approve_failed") {
+			return
+		}
+		h.decided(r, conn, "connection_approved", "connection.approved", "")
+	}
+	conn.Status = connstate.StateActive
+	httputil.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"connection":      summarizeConnection(*conn),
+		"previous_status": string(previous),
+	})
+}
+
+// Reject handles POST /admin/approvals/{connectionID}/reject: a connection
+// awaiting approval becomes rejected and its tokens are deleted. The
+// optional body {"reason": "..."} is passed on in the audit log and
+// webhook. Rejecting a rejected connection succeeds without changes; any
+// other status is a 409.
+func (h *ApprovalsHandler) Reject(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Reason string `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil && !errors.Is(err, io.EOF) {
+		httputil.WriteError(w, http.StatusBadRequest, "invalid_json", "Invalid JSON")
+		return
+	}
+	body.Reason = strings.TrimSpace(body.Reason)
+	if len(body.Reason) > maxRejectReason {
+		httputil.WriteError(w, http.StatusBadRequest, "invalid_request", "reason must be at most 500 characters")
+		return
+	}
+	conn, ok := h.connection(w, r, "reject_failed")
+	if !ok {
+		return
+	}
+	previous := conn.Status
+	if previous != connstate.StateRejected {
+		if previous != connstate.StateAwaitingApproval {
+			httputil.WriteError(w, http.StatusConflict, "invalid_transition", "A "+string(previous)+" connection cannot be rejected")
+			return
+		}
+		if !h.transition(w, r, conn, connstate.StateRejected, "reject_failed") {
+			return
+		}
+		if err := h.store.DeleteTokens(r.Context(), conn.ID); err != nil {
+			log.Printf("approvals: delete tokens of %s: %v", conn.ID, err)
+		}
+		h.decided(r, conn, "connection_rejected", "connection.rejected", body.Reason)
+	}
+	conn.Status = connstate.StateRejected
+	httputil.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"connection":      summarizeConnection(*conn),
+		"previous_status": string(previous),
+	})
+}
+
+// connection reads the connection of the path, answering the request if
+// it is invalid or unknown.
+func (h *ApprovalsHandler) connection(w http.ResponseWriter, r *http.Request, failCode string) (*store.Connection, bool) {
+	id, err := uuid.Parse(chi.URLParam(r, "connectionID"))
+	if err != nil {
+		httputil.WriteError(w, http.StatusBadRequest, "invalid_connection_id", "Invalid connection ID")
+		return nil, false
+	}
+	conn, err := h.store.GetConnection(r.Context(), id)
+	if errors.Is(err, store.ErrNotFound) {
+		httputil.WriteError(w, http.StatusNotFound, "connection_not_found", "Connection not found")
+		return nil, false
+	}
+	if err != nil {
+		log.Printf("approvals: get %s: %v", id, err)
+		httputil.WriteError(w, http.StatusInternalServerError, failCode, "Failed to look up the connection")
+		return nil, false
+	}
+	return conn, true
+}
+
+// transition moves conn to the approver's decision, answering the request
+// if the state machine or the store refuses.
+func (h *ApprovalsHandler) transition(w http.ResponseWriter, r *http.Request, conn *store.Connection, to connstate.State, failCode string) bool {
+	err := h.store.UpdateStatus(r.Context(), conn.ID, to)
+	var te *connstate.TransitionError
+	if errors.As(err, &te) {
+		// Another approver decided first.
+		httputil.WriteError(w, http.StatusConflict, "invalid_transition", "A "+string(te.From)+" connection cannot become "+string(to))
+		return false
+	}
+	if err != nil {
+		log.Printf("approvals: %s -> %s: %v", conn.ID, to, err)
+		httputil.WriteError(w, http.StatusInternalServerError, failCode, "Failed to update the connection")
+		return false
+	}
+	return true
+}
+
+// decided audits an approver's decision and sends its webhook.
+func (h *ApprovalsHandler) decided(r *http.Request, conn *store.Connection, auditEvent, webhookEvent, reason string) {
+	event := approvalEvent(conn, "")
+	event.DecidedBy = audit.CallerFrom(r.Context())
+	event.Reason = reason
+	if provider, err := h.store.GetProvider(r.Context(), conn.ProviderID); err == nil {
+		event.ProviderName = provider.Name
+	}
+	if h.audit != nil {
+		data := map[string]interface{}{"workspace_id": conn.WorkspaceID, "provider_id": conn.ProviderID.String()}
+		if reason != "" {
+			data["reason"] = reason
+		}
+		if err := h.audit.Log(auditEvent, &conn.ID, data, r); err != nil {
+			log.Printf("audit: failed to log %s (connection_id=%s): %v", auditEvent, conn.ID, err)
+		}
+	}
+	if err := h.webhook.Send(r.Context(), webhookEvent, event); err != nil {
+		log.Printf("approvals: %v", err)
+	}
+}
@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/internal/audit"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/internal/store"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/internal/webhook"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/connstate"
+	"github.com/Prescott-Data/nexus-framework/nexus-common/state"
+)
+
+// approvalFixture completes consents for an api_key provider requiring
+// approval and decides them through the admin API.
+type approvalFixture struct {
+	st       *store.Memory
+	key      []byte
+	callback *CallbackHandler
+	router   chi.Router
+	events   []ApprovalConnection
+	names    []string
+}
+
+func newApprovalFixture(t *testing.T) *approvalFixture {
+	f := &approvalFixture{st: store.NewMemory(), key: []byte("01234567890123456789012345678901")}
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Event string             `json:"event"`
+			Data  ApprovalConnection `json:"data"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		f.names = append(f.names, body.Event)
+		f.events = append(f.events, body.Data)
+	}))
+	t.Cleanup(hook.Close)
+	notifier := webhook.New(hook.URL, nil, hook.Client())
+
+	f.callback = NewCallbackHandler(CallbackHandlerConfig{
+		Store: f.st, Audit: audit.NewServiceWithStore(f.st), EncryptionKey: f.key, States: testStates(t, f.key),
+		HTTPClient: http.DefaultClient, Webhook: notifier,
+	})
+	approvals := NewApprovalsHandler(ApprovalsHandlerConfig{Store: f.st, Audit: audit.NewServiceWithStore(f.st), Webhook: notifier})
+	f.router = chi.NewRouter()
+	f.router.Get("/admin/approvals", approvals.List)
+	f.router.Post("/admin/approvals/{connectionID}/approve", approvals.Approve)
+	f.router.Post("/admin/approvals/{connectionID}/reject", approvals.Reject)
+	return f
+}
+
+// consent completes the consent of a new connection to a provider
+// requiring approval and returns its ID.
+func (f *approvalFixture) consent(t *testing.T) uuid.UUID {
+	t.Helper()
+	params := json.RawMessage(`{"requires_approval": true}`)
+	id := uuid.New()
+	seedConnection(f.st, id, connstate.StatePending, store.Provider{Name: "acme", AuthType: "api_key", Params: &params})
+	signed, err := f.callback.states.Sign(state.Data{Nonce: id.String(), IAT: time.Now()})
+	require.NoError(t, err)
+	body, _ := json.Marshal(map[string]interface{}{"state": signed, "credentials": map[string]interface{}{"api_key": "secret"}})
+	rr := httptest.NewRecorder()
+	f.callback.SaveCredential(rr, httptest.NewRequest("POST", "/auth/capture-credential", bytes.NewReader(body)))
+	require.Equal(t, http.StatusFound, rr.Code, rr.Body.String())
+	assert.Contains(t, rr.Header().Get("Location"), "status=awaiting_approval")
+	return id
+}
+
+func (f *approvalFixture) token(id uuid.UUID) *httptest.ResponseRecorder {
+	rr := httptest.NewRecorder()
+	f.callback.GetToken(rr, httptest.NewRequest("GET", "/connections/"+id.String()+"/token", nil))
+	return rr
+}
+
+func (f *approvalFixture) decide(id uuid.UUID, decision, body string) *httptest.ResponseRecorder {
+	rr := httptest.NewRecorder()
+	f.router.ServeHTTP(rr, httptest.NewRequest("POST", "/admin/approvals/"+id.String()+"/"+decision, strings.NewReader(body)))
+	return rr
+}
+
+func TestApproval_ApproveServesTokens(t *testing.T) {
+	f := newApprovalFixture(t)
+	id := f.consent(t)
+
+	conn, err := f.st.GetConnection(context.Background(), id)
+	require.NoError(t, err)
+	assert.Equal(t, connstate.StateAwaitingApproval, conn.Status)
+	require.Equal(t, []string{"connection.approval_requested"}, f.names)
+	assert.Equal(t, id.String(), f.events[0].ConnectionID)
+	assert.Equal(t, "acme", f.events[0].ProviderName)
+
+	rr := f.token(id)
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+	assert.Contains(t, rr.Body.String(), "approval_pending")
+
+	rr = httptest.NewRecorder()
+	f.router.ServeHTTP(rr, httptest.NewRequest("GET", "/admin/approvals?workspace_id=ws-1", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+	var list struct {
+		Connections []ConnectionSummary `json:"connections"`
+	}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &list))
+	require.Len(t, list.Connections, 1)
+	assert.Equal(t, id.String(), list.Connections[0].ConnectionID)
+
+	rr = f.decide(id, "approve", "")
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	assert.Equal(t, []string{"connection.approval_requested", "connection.approved"}, f.names)
+
+	rr = f.token(id)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	assert.Contains(t, rr.Body.String(), "secret")
+
+	// Approving again changes nothing; an approved connection cannot be
+	// rejected.
+	assert.Equal(t, http.StatusOK, f.decide(id, "approve", "").Code)
+	assert.Equal(t, http.StatusConflict, f.decide(id, "reject", "").Code)
+	assert.Len(t, f.names, 2)
+
+	events, err := f.st.ListAuditEvents(context.Background(), store.AuditFilter{})
+	require.NoError(t, err)
+	var types []string
+	for _, e := range events {
+		types = append(types, e.EventType)
+	}
+	assert.Contains(t, types, "approval_requested")
+	assert.Contains(t, types, "connection_approved")
+}
+
+func TestApproval_RejectDeletesTokens(t *testing.T) {
+	f := newApprovalFixture(t)
+	id := f.consent(t)
+
+	rr := f.decide(id, "reject", `{"reason": "Not needed for this project"}`)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	conn, err := f.st.GetConnection(context.Background(), id)
+	require.NoError(t, err)
+	assert.Equal(t, connstate.StateRejected, conn.Status)
+	_, err = f.st.GetTokens(context.Background(), id)
+	assert.ErrorIs(t, err, store.ErrNotFound)
+	require.Len(t, f.events, 2)
+	assert.Equal(t, "connection.rejected", f.names[1])
+	assert.Equal(t, "Not needed for this project", f.events[1].Reason)
+
+	rr = f.token(id)
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+	assert.Contains(t, rr.Body.String(), "approval_rejected")
+	assert.Equal(t, http.StatusConflict, f.decide(id, "approve", "").Code)
+}
+
+func TestApproval_NotRequired(t *testing.T) {
+	f := newApprovalFixture(t)
+	id := uuid.New()
+	seedConnection(f.st, id, connstate.StatePending, store.Provider{AuthType: "api_key"})
+	assert.Equal(t, http.StatusConflict, f.decide(id, "approve", "").Code)
+	assert.Equal(t, http.StatusConflict, f.decide(id, "reject", "").Code)
+	assert.Equal(t, http.StatusNotFound, f.decide(uuid.New(), "reject", "").Code)
+	assert.Empty(t, f.names)
+}
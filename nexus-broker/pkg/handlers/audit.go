@@ -1,23 +1,46 @@
 package handlers
 
 import (
+	"encoding/csv"
+	"encoding/json"
+	"log"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/internal/store"
 	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/httputil"
-	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/storage"
+	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
 )
 
+// Page sizes of GET /audit-events.
+const (
+	defaultAuditPageSize = 50
+	maxAuditPageSize     = 1000
+)
+
+// auditCSVHeader is the first row of a CSV export.
+var auditCSVHeader = []string{"id", "created_at", "event_type", "connection_id", "ip_address", "user_agent", "event_data"}
+
+// auditExportFlushEvery is how many exported events are buffered before
+// they are flushed to the client.
+const auditExportFlushEvery = 100
+
 // AuditHandler handles audit log queries
 type AuditHandler struct {
-	db *sqlx.DB
+	store store.AuditStore
 }
 
-// NewAuditHandler creates a new audit handler
+// NewAuditHandler creates a new audit handler backed by Postgres
 func NewAuditHandler(db *sqlx.DB) *AuditHandler {
-	return &AuditHandler{db: db}
+	return NewAuditHandlerWithStore(store.NewPostgres(db))
+}
+
+// NewAuditHandlerWithStore creates an audit handler that reads from store
+func NewAuditHandlerWithStore(s store.AuditStore) *AuditHandler {
+	return &AuditHandler{store: s}
 }
 
 // List handles GET /audit to retrieve recent audit events
@@ -33,41 +56,213 @@ func (h *AuditHandler) List(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	query := `SELECT id, connection_id, event_type, event_data, ip_address, user_agent, created_at 
-			  FROM audit_events WHERE 1=1`
-	args := []interface{}{}
-	argIndex := 1
-
-	if eventType != "" {
-		query += ` AND event_type = $` + strconv.Itoa(argIndex)
-		args = append(args, eventType)
-		argIndex++
-	}
-
+	filter := store.AuditFilter{EventType: eventType, Limit: limit}
 	if sinceStr != "" {
 		since, err := time.Parse(time.RFC3339, sinceStr)
 		if err != nil {
 			httputil.WriteError(w, http.StatusBadRequest, "invalid_since", "since parameter must be a valid RFC3339 timestamp")
 			return
 		}
-		query += ` AND created_at >= $` + strconv.Itoa(argIndex)
-		args = append(args, since)
-		argIndex++
+		filter.Since = since
+	}
+
+	events, err := h.store.ListAuditEvents(r.Context(), filter)
+	if err != nil {
+		httputil.WriteError(w, http.StatusInternalServerError, "query_failed", "Failed to query audit events")
+		return
+	}
+
+	httputil.WriteJSON(w, http.StatusOK, events)
+}
+
+// AuditEventsPage is a page of GET /audit-events.
+type AuditEventsPage struct {
+	Events   []store.AuditEvent `json:"events"`
+	Page     int                `json:"page"`
+	PageSize int                `json:"page_size"`
+	// NextPage is set when more events match the filters.
+	NextPage int `json:"next_page,omitempty"`
+}
+
+// Query handles GET /audit-events. Events can be filtered by connection_id,
+// event_type and the since (inclusive) and until (exclusive) RFC3339
+// timestamps. By default the events are returned newest first in pages of
+// page_size; format=ndjson or format=csv instead streams every matching
+// event, oldest first, as a download.
+func (h *AuditHandler) Query(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	var filter store.AuditFilter
+	filter.EventType = q.Get("event_type")
+	if v := q.Get("connection_id"); v != "" {
+		id, err := uuid.Parse(v)
+		if err != nil {
+			httputil.WriteError(w, http.StatusBadRequest, "invalid_connection_id", "connection_id must be a UUID")
+			return
+		}
+		filter.ConnectionID = id
+	}
+	for _, p := range []struct {
+		name string
+		dst  *time.Time
+	}{{"since", &filter.Since}, {"until", &filter.Until}} {
+		v := q.Get(p.name)
+		if v == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			httputil.WriteError(w, http.StatusBadRequest, "invalid_"+p.name, p.name+" parameter must be a valid RFC3339 timestamp")
+			return
+		}
+		*p.dst = t
+	}
+
+	switch format := q.Get("format"); format {
+	case "", "json":
+	case "ndjson", "csv":
+		h.export(w, r, filter, format)
+		return
+	default:
+		httputil.WriteError(w, http.StatusBadRequest, "invalid_format", "format must be json, ndjson or csv")
+		return
+	}
+
+	page, ok := positiveIntParam(w, q.Get("page"), 1, 0, "page")
+	if !ok {
+		return
+	}
+	pageSize, ok := positiveIntParam(w, q.Get("page_size"), defaultAuditPageSize, maxAuditPageSize, "page_size")
+	if !ok {
+		return
+	}
+
+	// Fetch one extra event to tell whether there is a next page.
+	filter.Limit, filter.Offset = pageSize+1, (page-1)*pageSize
+	events, err := h.store.ListAuditEvents(r.Context(), filter)
+	if err != nil {
+		httputil.WriteError(w, http.StatusInternalServerError, "query_failed", "Failed to query audit events")
+		return
+	}
+	resp := AuditEventsPage{Events: events, Page: page, PageSize: pageSize}
+	if len(events) > pageSize {
+		resp.Events, resp.NextPage = events[:pageSize], page+1
+	}
+	httputil.WriteJSON(w, http.StatusOK, resp)
+}
+
+// positiveIntParam parses a positive integer query parameter, writing a 400
+// when it is malformed or above max (if max > 0).
+func positiveIntParam(w http.ResponseWriter, v string, def, max int, name string) (int, bool) {
+	if v == "" {
+		return def, true
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 1 || (max > 0 && n > max) {
+		msg := name + " must be a positive integer"
+		if max > 0 {
+			msg += " of at most " + strconv.Itoa(max)
+		}
+		httputil.WriteError(w, http.StatusBadRequest, "invalid_"+name, msg)
+		return 0, false
 	}
+	return n, true
+}
 
-	query += ` ORDER BY created_at DESC LIMIT $` + strconv.Itoa(argIndex)
-	args = append(args, limit)
+// export streams the events matching filter as NDJSON or CSV. Once the
+// first event is written the status can no longer change, so a failure
+// part-way is only logged and ends the download early.
+func (h *AuditHandler) export(w http.ResponseWriter, r *http.Request, filter store.AuditFilter, format string) {
+	flusher, _ := w.(http.Flusher)
+	var cw *csv.Writer
+	if format == "csv" {
+		cw = csv.NewWriter(w)
+	}
+	started := false
+	start := func() error {
+		if started {
+			return nil
+		}
+		started = true
+		contentType := "application/x-ndjson"
+		if cw != nil {
+			contentType = "text/csv; charset=utf-8"
+		}
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Disposition", `attachment; filename="audit-events.`+format+`"`)
+		w.WriteHeader(http.StatusOK)
+		if cw != nil {
+			return cw.Write(auditCSVHeader)
+		}
+		return nil
+	}
+
+	var write func(e *store.AuditEvent) error
+	if cw != nil {
+		write = func(e *store.AuditEvent) error {
+			if err := start(); err != nil {
+				return err
+			}
+			connID := ""
+			if e.ConnectionID != nil {
+				connID = e.ConnectionID.String()
+			}
+			return cw.Write([]string{
+				e.ID.String(), e.CreatedAt.UTC().Format(time.RFC3339Nano), csvCell(e.EventType), connID,
+				csvCell(deref(e.IPAddress)), csvCell(deref(e.UserAgent)), csvCell(deref(e.EventData)),
+			})
+		}
+	} else {
+		enc := json.NewEncoder(w)
+		write = func(e *store.AuditEvent) error {
+			if err := start(); err != nil {
+				return err
+			}
+			return enc.Encode(e)
+		}
+	}
 
-	var events []storage.AuditEvent
-	if err := h.db.Select(&events, query, args...); err != nil {
+	n := 0
+	err := h.store.StreamAuditEvents(r.Context(), filter, func(e *store.AuditEvent) error {
+		if err := write(e); err != nil {
+			return err
+		}
+		if n++; n%auditExportFlushEvery == 0 {
+			if cw != nil {
+				cw.Flush()
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		return nil
+	})
+	if err != nil && !started {
 		httputil.WriteError(w, http.StatusInternalServerError, "query_failed", "Failed to query audit events")
 		return
 	}
+	if err != nil {
+		log.Printf("audit export: stopped after %d events: %v", n, err)
+		return
+	}
+	// With no events, this still sends the CSV header row.
+	start()
+	if cw != nil {
+		cw.Flush()
+	}
+}
 
-	// Make sure we return an empty array instead of null for no results
-	if events == nil {
-		events = []storage.AuditEvent{}
+// csvCell keeps spreadsheet applications from evaluating a cell, such as a
+// client-supplied User-Agent, as a formula.
+func csvCell(v string) string {
+	if v != "" && strings.ContainsRune("=+-@\t\r", rune(v[0])) {
+		return "'" + v
 	}
+	return v
+}
 
-	httputil.WriteJSON(w, http.StatusOK, events)
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
 }
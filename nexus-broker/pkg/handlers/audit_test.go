@@ -1,12 +1,16 @@
 package handlers_test
 
 import (
+	"context"
+	"encoding/csv"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/internal/store"
 	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/handlers"
 	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
@@ -172,3 +176,117 @@ func TestAuditList_LimitAboveMax_FallsBackToDefault(t *testing.T) {
 		t.Errorf("unmet sqlmock expectations: %v", err)
 	}
 }
+
+func newAuditEventsHandler(t *testing.T, events ...store.AuditEvent) *handlers.AuditHandler {
+	t.Helper()
+	st := store.NewMemory()
+	for i := range events {
+		if err := st.CreateAuditEvent(context.Background(), &events[i]); err != nil {
+			t.Fatalf("create audit event: %v", err)
+		}
+	}
+	return handlers.NewAuditHandlerWithStore(st)
+}
+
+func TestAuditQuery_FiltersAndPages(t *testing.T) {
+	connID := uuid.New()
+	other := uuid.New()
+	handler := newAuditEventsHandler(t,
+		store.AuditEvent{ConnectionID: &connID, EventType: "token_retrieved"},
+		store.AuditEvent{ConnectionID: &other, EventType: "token_retrieved"},
+		store.AuditEvent{ConnectionID: &connID, EventType: "token_refreshed"},
+		store.AuditEvent{ConnectionID: &connID, EventType: "token_retrieved"},
+	)
+
+	get := func(query string) handlers.AuditEventsPage {
+		t.Helper()
+		w := httptest.NewRecorder()
+		handler.Query(w, httptest.NewRequest(http.MethodGet, "/audit-events?"+query, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: expected 200, got %d: %s", query, w.Code, w.Body)
+		}
+		var page handlers.AuditEventsPage
+		if err := json.NewDecoder(w.Body).Decode(&page); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		return page
+	}
+
+	page := get("connection_id=" + connID.String() + "&event_type=token_retrieved&page_size=1")
+	if len(page.Events) != 1 || page.NextPage != 2 {
+		t.Fatalf("expected 1 event and next_page 2, got %d events, next_page %d", len(page.Events), page.NextPage)
+	}
+	page = get("connection_id=" + connID.String() + "&event_type=token_retrieved&page_size=1&page=2")
+	if len(page.Events) != 1 || page.NextPage != 0 {
+		t.Fatalf("expected the last event, got %d events, next_page %d", len(page.Events), page.NextPage)
+	}
+	if page.Events[0].EventType != "token_retrieved" || *page.Events[0].ConnectionID != connID {
+		t.Errorf("unexpected event %+v", page.Events[0])
+	}
+	if page := get("until=2000-01-01T00:00:00Z"); len(page.Events) != 0 {
+		t.Errorf("expected no events before until, got %d", len(page.Events))
+	}
+}
+
+func TestAuditQuery_InvalidParams_Return400(t *testing.T) {
+	handler := newAuditEventsHandler(t)
+	for _, query := range []string{"connection_id=nope", "since=yesterday", "until=tomorrow", "page=0", "page_size=1001", "format=xml"} {
+		w := httptest.NewRecorder()
+		handler.Query(w, httptest.NewRequest(http.MethodGet, "/audit-events?"+query, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", query, w.Code)
+		}
+	}
+}
+
+func TestAuditQuery_ExportNDJSON(t *testing.T) {
+	connID := uuid.New()
+	handler := newAuditEventsHandler(t,
+		store.AuditEvent{ConnectionID: &connID, EventType: "connection_created"},
+		store.AuditEvent{ConnectionID: &connID, EventType: "connection_revoked"},
+	)
+
+	w := httptest.NewRecorder()
+	handler.Query(w, httptest.NewRequest(http.MethodGet, "/audit-events?format=ndjson&connection_id="+connID.String(), nil))
+
+	if ct := w.Header().Get("Content-Type"); ct != "application/x-ndjson" {
+		t.Errorf("expected NDJSON content type, got %q", ct)
+	}
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines, got %d: %s", len(lines), w.Body)
+	}
+	var first store.AuditEvent
+	if err := json.Unmarshal([]byte(lines[0]), &first); err != nil {
+		t.Fatalf("decode line: %v", err)
+	}
+	if first.EventType != "connection_created" {
+		t.Errorf("expected oldest event first, got %s", first.EventType)
+	}
+}
+
+func TestAuditQuery_ExportCSVEscapesFormulas(t *testing.T) {
+	ua := "=HYPERLINK(\"http://evil\")"
+	handler := newAuditEventsHandler(t, store.AuditEvent{EventType: "token_retrieved", UserAgent: &ua})
+
+	w := httptest.NewRecorder()
+	handler.Query(w, httptest.NewRequest(http.MethodGet, "/audit-events?format=csv", nil))
+
+	records, err := csv.NewReader(w.Body).ReadAll()
+	if err != nil {
+		t.Fatalf("parse csv: %v", err)
+	}
+	if len(records) != 2 || records[0][0] != "id" {
+		t.Fatalf("expected a header and one row, got %v", records)
+	}
+	if got := records[1][5]; got != "'"+ua {
+		t.Errorf("expected escaped user agent, got %q", got)
+	}
+
+	// An empty export still has the header row.
+	w = httptest.NewRecorder()
+	handler.Query(w, httptest.NewRequest(http.MethodGet, "/audit-events?format=csv&event_type=none", nil))
+	if records, _ := csv.NewReader(w.Body).ReadAll(); len(records) != 1 {
+		t.Errorf("expected only the header row, got %v", records)
+	}
+}
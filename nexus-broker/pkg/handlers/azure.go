@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	gooidc "github.com/coreos/go-oidc/v3/oidc"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/internal/store"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/azure"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/discovery"
+	oidcutil "github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/oidc"
+)
+
+// azureAD reports whether provider is Azure AD (Microsoft Entra ID): its
+// endpoints are on a Microsoft login host, or its azure_ad param marks it
+// as such for hosts the broker does not know.
+func azureAD(provider *store.Provider) bool {
+	if azure.IsAuthority(provider.AuthURL) || azure.IsAuthority(provider.TokenURL) {
+		return true
+	}
+	if provider.Params == nil {
+		return false
+	}
+	var params struct {
+		AzureAD bool `json:"azure_ad"`
+	}
+	_ = json.Unmarshal(*provider.Params, &params)
+	return params.AzureAD
+}
+
+// withTenant returns a copy of an Azure AD provider whose endpoints name
+// tenant in place of the configured one. Other providers, and an empty
+// tenant, return provider itself.
+func withTenant(provider *store.Provider, tenant string) *store.Provider {
+	if tenant == "" || !azureAD(provider) {
+		return provider
+	}
+	p := *provider
+	p.AuthURL = azure.WithTenant(p.AuthURL, tenant)
+	p.TokenURL = azure.WithTenant(p.TokenURL, tenant)
+	return &p
+}
+
+// verifyIDToken verifies an id_token issued to provider. Azure AD v2.0
+// id_tokens are checked against the metadata of the tenant the provider's
+// endpoints name, whose issuer is templated for common, organizations and
+// consumers (see azure.CheckIssuer). Other id_tokens are checked against
+// the issuer they name.
+func verifyIDToken(ctx context.Context, client *http.Client, provider *store.Provider, raw, nonce string) (*gooidc.IDToken, error) {
+	if !azureAD(provider) || !strings.Contains(provider.AuthURL, "/oauth2/v2.0/") {
+		return oidcutil.VerifyIDToken(ctx, client, raw, provider.ClientID, nonce)
+	}
+	md, err := discovery.Discover(ctx, client, discovery.Hint{AuthURL: provider.AuthURL})
+	if err != nil {
+		return nil, fmt.Errorf("tenant discovery: %w", err)
+	}
+	tenant := azure.Tenant(provider.AuthURL)
+	return oidcutil.VerifyIDTokenWithKeys(ctx, client, raw, provider.ClientID, nonce, md.JWKSURI, func(idt *gooidc.IDToken) error {
+		return azure.CheckIssuer(md.Issuer, tenant, idt.Issuer, tenantID(idt))
+	})
+}
+
+// tenantID returns the tid claim of an Azure AD id_token.
+func tenantID(idt *gooidc.IDToken) string {
+	var claims struct {
+		TID string `json:"tid"`
+	}
+	_ = idt.Claims(&claims)
+	return claims.TID
+}
+
+// recordTenant stores the tenant of the user who authorized an Azure AD
+// connection, from its verified id_token, so refreshes and re-consents go
+// to that tenant's endpoints rather than a multi-tenant one.
+func (h *CallbackHandler) recordTenant(ctx context.Context, conn *store.Connection, idt *gooidc.IDToken) error {
+	tid := tenantID(idt)
+	if tid == "" {
+		return nil
+	}
+	tenant := azure.ResolvedTenant(tid)
+	if tenant == conn.Tenant {
+		return nil
+	}
+	return h.store.SetTenant(ctx, conn.ID, tenant)
+}
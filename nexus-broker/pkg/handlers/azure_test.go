@@ -0,0 +1,215 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/internal/store"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/azure"
+)
+
+const contosoTenantID = "72f988bf-86f1-41af-91ab-2d7cd011db47"
+
+// fakeAzureAD serves Azure AD v2.0 metadata, keys and token endpoints for
+// every tenant, issuing id_tokens for a user in tenant tid.
+type fakeAzureAD struct {
+	*httptest.Server
+	tid   string
+	nonce string
+	// tokenTenants are the tenants of the token requests received.
+	tokenTenants []string
+}
+
+func newFakeAzureAD(t *testing.T) *fakeAzureAD {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: jose.JSONWebKey{Key: key, KeyID: "k1"}}, nil)
+	require.NoError(t, err)
+
+	f := &fakeAzureAD{tid: contosoTenantID}
+	f.Server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		tenant, rest, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+		switch rest {
+		case "v2.0/.well-known/openid-configuration":
+			issuer := f.URL + "/" + tenant + "/v2.0"
+			if tenant == azure.Common || tenant == azure.Organizations {
+				issuer = f.URL + "/{tenantid}/v2.0"
+			}
+			json.NewEncoder(w).Encode(map[string]string{
+				"issuer":                 issuer,
+				"authorization_endpoint": f.URL + "/" + tenant + "/oauth2/v2.0/authorize",
+				"token_endpoint":         f.URL + "/" + tenant + "/oauth2/v2.0/token",
+				"jwks_uri":               f.URL + "/discovery/v2.0/keys",
+			})
+		case "v2.0/keys":
+			json.NewEncoder(w).Encode(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{{Key: &key.PublicKey, KeyID: "k1", Algorithm: "RS256", Use: "sig"}}})
+		case "oauth2/v2.0/token":
+			f.tokenTenants = append(f.tokenTenants, tenant)
+			claims, _ := json.Marshal(map[string]interface{}{
+				"iss": f.URL + "/" + f.tid + "/v2.0", "aud": "client-id", "sub": "user-1", "tid": f.tid,
+				"nonce": f.nonce, "iat": time.Now().Unix(), "exp": time.Now().Add(time.Hour).Unix(),
+			})
+			jws, err := signer.Sign(claims)
+			require.NoError(t, err)
+			idToken, _ := jws.CompactSerialize()
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"access_token": "at", "refresh_token": "rt", "expires_in": 3600, "id_token": idToken,
+			})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(f.Close)
+	return f
+}
+
+// caBundle returns the PEM certificate providers of f must trust.
+func (f *fakeAzureAD) caBundle() string {
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: f.Certificate().Raw}))
+}
+
+// azureConsent starts a consent to provider for tenant and returns the
+// state and the authorization URL.
+func azureConsent(t *testing.T, handler *ConsentHandler, providerID uuid.UUID, tenant string) (string, *url.URL) {
+	t.Helper()
+	body, _ := json.Marshal(map[string]interface{}{
+		"workspace_id": "ws-1",
+		"provider_id":  providerID.String(),
+		"scopes":       []string{"openid", "offline_access", "User.Read"},
+		"return_url":   "http://localhost:3000/callback",
+		"tenant":       tenant,
+	})
+	rr := httptest.NewRecorder()
+	handler.GetSpec(rr, httptest.NewRequest("POST", "/auth/consent-spec", bytes.NewReader(body)))
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	var spec ConsentSpec
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &spec))
+	u, err := url.Parse(spec.AuthURL)
+	require.NoError(t, err)
+	return spec.State, u
+}
+
+func TestAzureAD_TenantResolvedOnCallbackAndUsedForRefresh(t *testing.T) {
+	idp := newFakeAzureAD(t)
+	st := store.NewMemory()
+	key := []byte("01234567890123456789012345678901")
+	states := testStates(t, key)
+	consent := NewConsentHandler(ConsentHandlerConfig{
+		Store: st, BaseURL: "http://localhost:8080", RedirectPath: "/auth/callback", States: states,
+	})
+	callback := NewCallbackHandler(CallbackHandlerConfig{
+		Store: st, BaseURL: "http://localhost:8080", RedirectPath: "/auth/callback", EncryptionKey: key, States: states,
+	})
+	// The fake is not on a Microsoft login host, so azure_ad marks it.
+	params := json.RawMessage(`{"azure_ad": true}`)
+	provider := store.Provider{
+		ID: uuid.New(), Name: "microsoft", AuthType: "oauth2", ClientID: "client-id", ClientSecret: "secret",
+		AuthURL: idp.URL + "/common/oauth2/v2.0/authorize", TokenURL: idp.URL + "/common/oauth2/v2.0/token", Params: &params,
+		CABundle: idp.caBundle(),
+	}
+	st.PutProvider(provider)
+
+	signed, authURL := azureConsent(t, consent, provider.ID, "Organizations")
+	assert.Equal(t, "/organizations/oauth2/v2.0/authorize", authURL.Path)
+	assert.Empty(t, authURL.Query().Get("azure_ad"))
+	data, err := states.Verify(signed)
+	require.NoError(t, err)
+	connectionID := uuid.MustParse(data.Nonce)
+	conn, err := st.GetConnection(context.Background(), connectionID)
+	require.NoError(t, err)
+	assert.Equal(t, "organizations", conn.Tenant)
+
+	// The id_token's issuer matches the templated one, and the connection
+	// is pinned to the user's tenant.
+	idp.nonce = signed
+	rr := httptest.NewRecorder()
+	callback.Handle(rr, httptest.NewRequest("GET", "/auth/callback?code=abc&state="+url.QueryEscape(signed), nil))
+	require.Equal(t, http.StatusFound, rr.Code, rr.Body.String())
+	conn, err = st.GetConnection(context.Background(), connectionID)
+	require.NoError(t, err)
+	assert.Equal(t, contosoTenantID, conn.Tenant)
+	assert.Equal(t, "user-1", conn.Subject)
+
+	rr = httptest.NewRecorder()
+	callback.Refresh(rr, httptest.NewRequest("POST", "/connections/"+connectionID.String()+"/refresh", nil))
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	assert.Equal(t, []string{"organizations", contosoTenantID}, idp.tokenTenants)
+}
+
+func TestAzureAD_RejectsIDTokenOutsideTenant(t *testing.T) {
+	idp := newFakeAzureAD(t)
+	st := store.NewMemory()
+	key := []byte("01234567890123456789012345678901")
+	states := testStates(t, key)
+	consent := NewConsentHandler(ConsentHandlerConfig{
+		Store: st, BaseURL: "http://localhost:8080", RedirectPath: "/auth/callback", States: states,
+	})
+	callback := NewCallbackHandler(CallbackHandlerConfig{
+		Store: st, BaseURL: "http://localhost:8080", RedirectPath: "/auth/callback", EncryptionKey: key, States: states,
+	})
+	params := json.RawMessage(`{"azure_ad": true}`)
+	provider := store.Provider{
+		ID: uuid.New(), Name: "microsoft", AuthType: "oauth2", ClientID: "client-id",
+		AuthURL: idp.URL + "/common/oauth2/v2.0/authorize", TokenURL: idp.URL + "/common/oauth2/v2.0/token", Params: &params,
+		CABundle: idp.caBundle(),
+	}
+	st.PutProvider(provider)
+
+	for name, tc := range map[string]struct{ tenant, tid string }{
+		"personal account from organizations": {"organizations", azure.ConsumersTenantID},
+		"other tenant than requested":         {contosoTenantID, "0b3e8c2c-6a2f-4d8e-9d3c-8a1f2b3c4d5e"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			signed, _ := azureConsent(t, consent, provider.ID, tc.tenant)
+			idp.nonce, idp.tid = signed, tc.tid
+			rr := httptest.NewRecorder()
+			callback.Handle(rr, httptest.NewRequest("GET", "/auth/callback?code=abc&state="+url.QueryEscape(signed), nil))
+			assert.Equal(t, http.StatusFound, rr.Code, rr.Body.String())
+			assert.Contains(t, rr.Header().Get("Location"), "code=invalid_id_token")
+		})
+	}
+}
+
+func TestGetSpec_RejectsTenant(t *testing.T) {
+	st := store.NewMemory()
+	handler := NewConsentHandler(ConsentHandlerConfig{
+		Store: st, BaseURL: "http://localhost:8080", RedirectPath: "/auth/callback", States: testStates(t, []byte("test-key")),
+	})
+	google := store.Provider{ID: uuid.New(), AuthType: "oauth2", AuthURL: "https://accounts.google.com/o/oauth2/v2/auth", ClientID: "id"}
+	microsoft := store.Provider{ID: uuid.New(), AuthType: "oauth2", AuthURL: "https://login.microsoftonline.com/common/oauth2/v2.0/authorize", ClientID: "id"}
+	st.PutProvider(google)
+	st.PutProvider(microsoft)
+
+	for _, tc := range []struct {
+		provider uuid.UUID
+		tenant   string
+		code     string
+	}{
+		{google.ID, "organizations", "tenant_not_supported"},
+		{microsoft.ID, "../common", "invalid_tenant"},
+	} {
+		body, _ := json.Marshal(map[string]interface{}{
+			"workspace_id": "ws-1", "provider_id": tc.provider.String(), "return_url": "http://localhost:3000/callback", "tenant": tc.tenant,
+		})
+		rr := httptest.NewRecorder()
+		handler.GetSpec(rr, httptest.NewRequest("POST", "/auth/consent-spec", bytes.NewReader(body)))
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		assert.Contains(t, rr.Body.String(), tc.code)
+	}
+}
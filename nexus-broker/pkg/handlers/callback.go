@@ -1,61 +1,151 @@
 package handlers
 
 import (
-	"database/sql"
+	"context"
+	"crypto/subtle"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"log"
+	"mime"
 	"net/http"
 	"net/url"
 	"strings"
 	"time"
 
+	gooidc "github.com/coreos/go-oidc/v3/oidc"
 	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
-	"github.com/lib/pq"
 	"github.com/prometheus/client_golang/prometheus"
 
 	"github.com/Prescott-Data/nexus-framework/nexus-broker/internal/audit"
-	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/auth"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/internal/lock"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/internal/quota"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/internal/ratelimit"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/internal/store"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/internal/usage"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/internal/webhook"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/config"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/connstate"
 	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/discovery"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/dpop"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/httpclient"
 	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/httputil"
-	oidcutil "github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/oidc"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/pages"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/provider"
 	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/server"
 	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/vault"
+	"github.com/Prescott-Data/nexus-framework/nexus-common/state"
 )
 
 // CallbackHandler handles OAuth callback and token exchange
 type CallbackHandler struct {
-	db                    *sqlx.DB
+	store                 store.Store
 	audit                 *audit.Service
 	baseURL               string
 	redirectPath          string
 	encryptionKey         []byte
-	stateKey              []byte
-	httpClient            *http.Client
+	states                *state.Keyring
+	clients               providerClients
 	enforceReturnURL      bool
 	allowedReturnDomains  []string
+	allowLegacyTokens     bool
 	metricExchangeSuccess prometheus.Counter
 	metricExchangeError   prometheus.Counter
 	histogramExchangeDur  prometheus.Histogram
 	metricIDTokens        prometheus.Counter
 	metricTokenGet        *prometheus.CounterVec
+	metricRefreshShared   prometheus.Counter
+	metricRefreshReuse    *prometheus.CounterVec
+	refreshLock           lock.Locker
+	webhook               *webhook.Notifier
+	tokenRequests         config.TokenRequestConfig
+	refreshRetry          config.RefreshRetryConfig
+	usage                 *usage.Recorder
+	quota                 *quota.Enforcer
+	providerLimiter       ratelimit.Limiter
+	pages                 *pages.Pages
+}
+
+// A refresh holds its connection's lock for as long as its token request
+// may take under the provider's policy (tokenPolicy.maxDuration) plus
+// refreshLockMargin, and gives up refreshLockMargin/2 before the lock
+// expires, leaving time for the store writes around the request. A
+// concurrent refresh waits up to refreshLockWait for the lock.
+const (
+	refreshLockMargin = 15 * time.Second
+	refreshLockWait   = 35 * time.Second
+)
+
+// refreshLockTTL returns how long a refresh under pol holds its lock.
+func refreshLockTTL(pol tokenPolicy) time.Duration {
+	return pol.maxDuration() + refreshLockMargin
 }
 
 // CallbackHandlerConfig holds the dependencies for CallbackHandler
 type CallbackHandlerConfig struct {
+	// Store persists connections, tokens and provider lookups. Defaults to
+	// Postgres through DB.
+	Store         store.Store
 	DB            *sqlx.DB
 	Audit         *audit.Service
 	BaseURL       string
 	RedirectPath  string
 	EncryptionKey []byte
-	StateKey      []byte
-	HTTPClient    *http.Client
+	// States signs and verifies the OAuth state parameter.
+	States *state.Keyring
+	// HTTPClient is used for OIDC discovery and JWKS and may cache responses.
+	HTTPClient *http.Client
+	// Transports supplies pooled connections for token exchange, refresh and
+	// credential checks, and for providers with a CA bundle. Defaults to a
+	// private pool.
+	Transports *httpclient.Pool
 
 	EnforceReturnURL     bool
 	AllowedReturnDomains []string
+
+	// RequireBoundTokens rejects token ciphertexts written before tokens
+	// were bound to their connection. Enable once cmd/migrate-token-aad has
+	// re-encrypted existing rows.
+	RequireBoundTokens bool
+
+	// RefreshLock serialises refreshes of the same connection so a
+	// single-use refresh_token is exchanged once. Use a Redis locker when
+	// running several replicas. Defaults to an in-process lock.
+	RefreshLock lock.Locker
+
+	// Webhook receives a connection.compromised event when a rotated
+	// refresh token is reused, and a connection.approval_requested event
+	// when a consent awaits approval. Nil disables it.
+	Webhook *webhook.Notifier
+
+	// TokenRequests sets the timeout and retries of token exchange and
+	// refresh calls. Providers override it with the token_timeout and
+	// token_max_retries params.
+	TokenRequests config.TokenRequestConfig
+
+	// RefreshRetry sets how failed refreshes are retried before the
+	// connection is marked needs_reauth. A zero Backoff defaults to a
+	// minute.
+	RefreshRetry config.RefreshRetryConfig
+
+	// Usage counts token retrievals per connection. Defaults to writing
+	// each retrieval through to Store.
+	Usage *usage.Recorder
+
+	// Quota rate-limits refreshes per workspace. Nil disables the limit.
+	Quota *quota.Enforcer
+
+	// ProviderLimiter paces token exchange and refresh calls of providers
+	// with a token_rate_limit param. Use a Redis limiter when running
+	// several replicas so they share each provider's budget. Defaults to an
+	// in-process limiter.
+	ProviderLimiter ratelimit.Limiter
+
+	// Pages renders the capture form and error pages shown to browsers.
+	// Defaults to the embedded pages.
+	Pages *pages.Pages
 }
 
 // NewCallbackHandler creates a new callback handler
@@ -84,7 +174,17 @@ func NewCallbackHandler(cfg CallbackHandlerConfig) *CallbackHandler {
 		Help: "Token retrievals by provider and whether id_token present",
 	}, []string{"provider", "has_id_token"})
 
-	collectors := []prometheus.Collector{success, failure, hist, idTokens, tokenGet}
+	refreshShared := prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "oauth_refresh_shared_total",
+		Help: "Refresh requests answered with a token another concurrent refresh obtained",
+	})
+
+	refreshReuse := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "oauth_refresh_token_reuse_total",
+		Help: "Rotated refresh tokens seen again, by where they were seen",
+	}, []string{"source"})
+
+	collectors := []prometheus.Collector{success, failure, hist, idTokens, tokenGet, refreshShared, refreshReuse}
 	for _, c := range collectors {
 		if err := prometheus.Register(c); err != nil {
 			if _, ok := err.(prometheus.AlreadyRegisteredError); !ok {
@@ -93,21 +193,51 @@ func NewCallbackHandler(cfg CallbackHandlerConfig) *CallbackHandler {
 		}
 	}
 
+	if cfg.Store == nil {
+		cfg.Store = store.NewPostgres(cfg.DB).WithSecretKey(cfg.EncryptionKey)
+	}
+	if cfg.RefreshLock == nil {
+		cfg.RefreshLock = lock.NewLocal()
+	}
+	if cfg.Usage == nil {
+		cfg.Usage = usage.NewRecorder(cfg.Store, 0)
+	}
+	if cfg.ProviderLimiter == nil {
+		cfg.ProviderLimiter = ratelimit.NewLocal()
+	}
+	if cfg.RefreshRetry.Backoff <= 0 {
+		cfg.RefreshRetry.Backoff = time.Minute
+	}
+	if cfg.Pages == nil {
+		cfg.Pages = pages.Default()
+	}
+
 	return &CallbackHandler{
-		db:                    cfg.DB,
+		store:                 cfg.Store,
 		audit:                 cfg.Audit,
 		baseURL:               cfg.BaseURL,
 		redirectPath:          cfg.RedirectPath,
 		encryptionKey:         cfg.EncryptionKey,
-		stateKey:              cfg.StateKey,
-		httpClient:            cfg.HTTPClient,
+		states:                cfg.States,
+		clients:               newProviderClients(cfg.Transports, cfg.HTTPClient),
 		enforceReturnURL:      cfg.EnforceReturnURL,
 		allowedReturnDomains:  cfg.AllowedReturnDomains,
+		allowLegacyTokens:     !cfg.RequireBoundTokens,
 		metricExchangeSuccess: success,
 		metricExchangeError:   failure,
 		histogramExchangeDur:  hist,
 		metricIDTokens:        idTokens,
 		metricTokenGet:        tokenGet,
+		metricRefreshShared:   refreshShared,
+		metricRefreshReuse:    refreshReuse,
+		refreshLock:           cfg.RefreshLock,
+		webhook:               cfg.Webhook,
+		tokenRequests:         cfg.TokenRequests,
+		refreshRetry:          cfg.RefreshRetry,
+		usage:                 cfg.Usage,
+		quota:                 cfg.Quota,
+		providerLimiter:       cfg.ProviderLimiter,
+		pages:                 cfg.Pages,
 	}
 }
 
@@ -124,65 +254,54 @@ func (h *CallbackHandler) Handle(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if code == "" || state == "" {
-		httputil.WriteError(w, http.StatusBadRequest, "missing_params", "Missing code or state parameter")
+		h.writeFlowError(w, r, http.StatusBadRequest, "missing_params", "Missing code or state parameter", "")
 		return
 	}
 
 	// Verify state
-	stateData, err := auth.VerifyState(h.stateKey, state)
+	stateData, err := h.states.Verify(state)
 	if err != nil {
 		h.logAuditEvent(nil, "state_verification_failed", map[string]string{"error": err.Error()}, r)
-		httputil.WriteError(w, http.StatusBadRequest, "invalid_state", "Invalid state")
+		h.writeFlowError(w, r, http.StatusBadRequest, "invalid_state", "Invalid state", "")
 		return
 	}
 
 	// Get connection
 	connectionID, err := uuid.Parse(stateData.Nonce)
 	if err != nil {
-		httputil.WriteError(w, http.StatusBadRequest, "invalid_connection_id", "Invalid connection ID")
+		h.writeFlowError(w, r, http.StatusBadRequest, "invalid_connection_id", "Invalid connection ID", "")
 		return
 	}
 
-	var connection struct {
-		ID           string         `db:"id"`
-		CodeVerifier sql.NullString `db:"code_verifier"`
-		ReturnURL    string         `db:"return_url"`
-		ProviderID   string         `db:"provider_id"`
-		Scopes       []string       `db:"scopes"`
-	}
-
-	err = h.db.QueryRow(`
-		SELECT id, code_verifier, return_url, provider_id, scopes
-		FROM connections
-		WHERE id = $1 AND status = 'pending' AND expires_at > NOW()`,
-		connectionID).Scan(&connection.ID, &connection.CodeVerifier, &connection.ReturnURL, &connection.ProviderID, pq.Array(&connection.Scopes))
-
+	connection, reauth, err := h.flowConnection(r.Context(), connectionID)
 	if err != nil {
 		h.logAuditEvent(&connectionID, "connection_not_found", map[string]string{"error": err.Error()}, r)
-		httputil.WriteError(w, http.StatusNotFound, "connection_not_found", "Connection not found or expired")
+		h.writeFlowError(w, r, http.StatusNotFound, "connection_not_found", "Connection not found or expired", "")
 		return
 	}
 
-	// Get provider details
-	var provider struct {
-		TokenURL     sql.NullString   `db:"token_url"`
-		ClientID     sql.NullString   `db:"client_id"`
-		ClientSecret sql.NullString   `db:"client_secret"`
-		Name         string           `db:"name"`
-		AuthHeader   string           `db:"auth_header"`
-		Params       *json.RawMessage `db:"params"`
+	// A flow bound to a browser session is only completed by it. The flow
+	// is left pending, so the user can still complete it in their own
+	// browser.
+	if !sessionMatches(stateData, r) {
+		h.logAuditEvent(&connectionID, "session_mismatch", map[string]string{"step": "callback"}, r)
+		h.redirectFailure(w, r, connection, http.StatusForbidden, "session_mismatch", "The connection was requested from another browser session")
+		return
 	}
 
-	err = h.db.QueryRow(`
-		SELECT token_url, client_id, client_secret, name, COALESCE(auth_header, '') as auth_header, params
-		FROM provider_profiles WHERE id = $1`,
-		connection.ProviderID).Scan(&provider.TokenURL, &provider.ClientID, &provider.ClientSecret, &provider.Name, &provider.AuthHeader, &provider.Params)
+	// fail ends a failed flow and sends the user back with code.
+	fail := func(status int, code, message string) {
+		h.endFailedFlow(r.Context(), connectionID, reauth, code)
+		h.redirectFailure(w, r, connection, status, code, message)
+	}
 
+	provider, err := h.store.GetProvider(r.Context(), connection.ProviderID)
 	if err != nil {
 		h.logAuditEvent(&connectionID, "provider_not_found", map[string]string{"error": err.Error()}, r)
-		httputil.WriteError(w, http.StatusInternalServerError, "provider_not_found", "Provider not found")
+		h.redirectFailure(w, r, connection, http.StatusInternalServerError, "provider_not_found", "Provider not found")
 		return
 	}
+	provider = withTenant(provider, connection.Tenant)
 
 	// Compute redirect_uri to match the auth request
 	redirectPath := h.redirectPath
@@ -200,19 +319,48 @@ func (h *CallbackHandler) Handle(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	// Exchange code for tokens
-	start := time.Now()
-	useTokenURL := provider.TokenURL.String
-	if md, errD := discovery.Discover(r.Context(), h.httpClient, discovery.Hint{AuthURL: useTokenURL}); errD == nil && strings.TrimSpace(md.AuthorizationEndpoint) != "" {
-		useTokenURL = md.TokenEndpoint
+	pol := h.tokenPolicy(provider)
+	client, err := h.clients.client(provider.CABundle, pol.timeout)
+	if err != nil {
+		h.logAuditEvent(&connectionID, "token_exchange_failed", map[string]string{"error": err.Error()}, r)
+		fail(http.StatusInternalServerError, "token_exchange_failed", "Token exchange failed")
+		return
+	}
+	// Providers with the dpop param bind the tokens to a key pair that is
+	// new for every code exchange and kept with them.
+	if dpopEnabled(provider) {
+		if pol.dpop, err = dpop.Generate(); err != nil {
+			h.logAuditEvent(&connectionID, "token_exchange_failed", map[string]string{"error": err.Error()}, r)
+			fail(http.StatusInternalServerError, "token_exchange_failed", "Token exchange failed")
+			return
+		}
 	}
-	tokens, err := h.exchangeCodeForTokens(useTokenURL, provider.ClientID.String, provider.ClientSecret.String, code, connection.CodeVerifier.String, redirectURI, connection.Scopes, provider.AuthHeader, skipScopeOnExchange)
+
+	// Exchange code for tokens. When discovery and the configured token_url
+	// disagree, a 4xx from one endpoint is retried once against the other.
+	start := time.Now()
+	endpoints := h.tokenEndpoints(r, provider.CABundle, provider.TokenURL, provider.TokenEndpointPreference)
+	tokens, used, err := exchangeWithFallback(endpoints, func(ep tokenEndpoint) (map[string]interface{}, int, error) {
+		tokens, status, err := h.exchangeCodeForTokens(r.Context(), client, pol, ep.URL, provider.ClientID, provider.ClientSecret, code, connection.CodeVerifier, redirectURI, connection.Scopes, provider.AuthHeader, skipScopeOnExchange)
+		if err != nil && status >= 400 && status < 500 {
+			h.logAuditEvent(&connectionID, "token_endpoint_rejected", map[string]string{"endpoint": ep.Source, "status_code": fmt.Sprintf("%d", status)}, r)
+		}
+		return tokens, status, err
+	})
 	h.histogramExchangeDur.Observe(time.Since(start).Seconds())
+	if err == nil && len(endpoints) > 1 && used.Source != provider.TokenEndpointPreference {
+		h.recordTokenEndpoint(r.Context(), connection.ProviderID, used.Source)
+		h.logAuditEvent(&connectionID, "token_endpoint_preference_updated", map[string]string{"provider_id": connection.ProviderID.String(), "endpoint": used.Source}, r)
+	}
 	if err != nil {
 		h.logAuditEvent(&connectionID, "token_exchange_failed", map[string]string{"error": err.Error()}, r)
-		h.updateConnectionStatus(connectionID, "failed")
 		h.metricExchangeError.Inc()
-		httputil.WriteError(w, http.StatusInternalServerError, "token_exchange_failed", "Token exchange failed")
+		var limited *ProviderRateLimitedError
+		if errors.As(err, &limited) {
+			fail(http.StatusServiceUnavailable, "provider_rate_limited", "The provider's token endpoint is busy")
+			return
+		}
+		fail(http.StatusInternalServerError, "token_exchange_failed", "Token exchange failed")
 		return
 	}
 	h.metricExchangeSuccess.Inc()
@@ -221,48 +369,81 @@ func (h *CallbackHandler) Handle(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Verify OIDC id_token if present and openid scope requested
+	var identity *gooidc.IDToken
 	if raw, ok := tokens["id_token"].(string); ok && raw != "" {
 		if containsScope(connection.Scopes, "openid") {
-			if _, err := oidcutil.VerifyIDToken(r.Context(), h.httpClient, raw, provider.ClientID.String, state); err != nil {
+			jwksClient, err := h.clients.discoveryClient(provider.CABundle)
+			if err == nil {
+				identity, err = verifyIDToken(r.Context(), jwksClient, provider, raw, state)
+			}
+			if err != nil {
 				h.logAuditEvent(&connectionID, "id_token_verification_failed", map[string]string{"error": err.Error()}, r)
-				h.updateConnectionStatus(connectionID, "failed")
-				httputil.WriteError(w, http.StatusUnauthorized, "invalid_id_token", "Invalid id_token")
+				fail(http.StatusUnauthorized, "invalid_id_token", "Invalid id_token")
 				return
 			}
 		}
 	}
+	// A re-consent must come from the user who made the connection.
+	if reauth && identity != nil && connection.Subject != "" && identity.Subject != connection.Subject {
+		h.logAuditEvent(&connectionID, "reauthorization_identity_mismatch", map[string]string{"provider_id": connection.ProviderID.String()}, r)
+		fail(http.StatusConflict, "identity_mismatch", "Reauthorized as a different user than the connection's")
+		return
+	}
 
-	// Encrypt and store tokens
-	err = h.storeTokens(connectionID, tokens)
+	// Encrypt and store tokens. A re-consent swaps the new token in and
+	// activates the connection in one step.
+	if reauth {
+		err = h.completeReauthorization(r.Context(), connection, provider, withDPoPKey(tokens, pol.dpop))
+	} else {
+		err = h.storeTokens(r.Context(), connectionID, connection.WorkspaceID, withDPoPKey(tokens, pol.dpop))
+	}
 	if err != nil {
 		h.logAuditEvent(&connectionID, "token_storage_failed", map[string]string{"error": err.Error()}, r)
-		httputil.WriteError(w, http.StatusInternalServerError, "token_store_failed", "Failed to store tokens")
+		h.redirectFailure(w, r, connection, http.StatusInternalServerError, "token_store_failed", "Failed to store tokens")
 		return
 	}
 
-	// Update connection status
-	err = h.updateConnectionStatus(connectionID, "active")
-	if err != nil {
-		h.logAuditEvent(&connectionID, "status_update_failed", map[string]string{"error": err.Error()}, r)
+	// Attribute the connection to the user so it can be deprovisioned later
+	if identity != nil {
+		if err := h.recordIdentity(r.Context(), connectionID, identity); err != nil {
+			h.logAuditEvent(&connectionID, "identity_store_failed", map[string]string{"error": err.Error()}, r)
+		}
+		if azureAD(provider) {
+			if err := h.recordTenant(r.Context(), connection, identity); err != nil {
+				h.logAuditEvent(&connectionID, "tenant_store_failed", map[string]string{"error": err.Error()}, r)
+			}
+		}
 	}
 
-	// Log success
-	h.logAuditEvent(&connectionID, "oauth_flow_completed", map[string]string{"provider_id": connection.ProviderID}, r)
+	result := "success"
+	if reauth {
+		h.logAuditEvent(&connectionID, "connection_reauthorized", map[string]string{"provider_id": connection.ProviderID.String(), "scopes": strings.Join(connection.Scopes, " ")}, r)
+	} else {
+		// Update connection status
+		result, err = h.completeConsent(r.Context(), r, connection, provider)
+		if err != nil {
+			result = "success"
+			h.logAuditEvent(&connectionID, "status_update_failed", map[string]string{"error": err.Error()}, r)
+		}
+
+		// Log success
+		h.logAuditEvent(&connectionID, "oauth_flow_completed", map[string]string{"provider_id": connection.ProviderID.String()}, r)
+	}
 
 	// Redirect to return URL with success
 	if !server.IsReturnURLAllowed(connection.ReturnURL, h.enforceReturnURL, h.allowedReturnDomains) {
-		httputil.WriteError(w, http.StatusBadRequest, "return_url_not_allowed", "return_url not allowed")
+		h.writeFlowError(w, r, http.StatusBadRequest, "return_url_not_allowed", "return_url not allowed", connectionID.String())
 		return
 	}
 
 	returnURL, err := url.Parse(connection.ReturnURL)
 	if err != nil {
 		h.logAuditEvent(&connectionID, "invalid_return_url", map[string]string{"error": err.Error(), "return_url": connection.ReturnURL}, r)
-		httputil.WriteError(w, http.StatusInternalServerError, "invalid_return_url", "Invalid return_url")
+		h.writeFlowError(w, r, http.StatusInternalServerError, "invalid_return_url", "Invalid return_url", connectionID.String())
 		return
 	}
 	query := returnURL.Query()
-	query.Set("status", "success")
+	query.Set("status", result)
 	query.Set("connection_id", connectionID.String())
 	query.Set("provider", provider.Name)
 	returnURL.RawQuery = query.Encode()
@@ -270,45 +451,54 @@ func (h *CallbackHandler) Handle(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, returnURL.String(), http.StatusFound)
 }
 
-// GetCaptureSchema serves a JSON schema for the credential capture form.
+// GetCaptureSchema serves the credential capture form to browsers, and its
+// JSON schema to everyone else.
 func (h *CallbackHandler) GetCaptureSchema(w http.ResponseWriter, r *http.Request) {
 	state := r.URL.Query().Get("state")
 
 	// Verify state
-	stateData, err := auth.VerifyState(h.stateKey, state)
+	stateData, err := h.states.Verify(state)
 	if err != nil {
-		httputil.WriteError(w, http.StatusBadRequest, "invalid_state", "Invalid state")
+		h.writeFlowError(w, r, http.StatusBadRequest, "invalid_state", "Invalid state", "")
 		return
 	}
 
 	providerID, err := uuid.Parse(stateData.ProviderID)
 	if err != nil {
-		httputil.WriteError(w, http.StatusBadRequest, "invalid_provider_id", "Invalid provider ID in state")
+		h.writeFlowError(w, r, http.StatusBadRequest, "invalid_provider_id", "Invalid provider ID in state", "")
 		return
 	}
 
-	var provider struct {
-		Name   string           `db:"name"`
-		Params *json.RawMessage `db:"params"`
+	provider, err := h.store.GetProvider(r.Context(), providerID)
+	if err != nil {
+		h.writeFlowError(w, r, http.StatusNotFound, "provider_not_found", "Provider not found", "")
+		return
 	}
 
-	err = h.db.QueryRow("SELECT name, params FROM provider_profiles WHERE id = $1", providerID).Scan(&provider.Name, &provider.Params)
+	// Secret defaults are filled in by SaveCredential and never shown.
+	schema, defaults, err := splitCaptureSchema(provider)
 	if err != nil {
-		httputil.WriteError(w, http.StatusNotFound, "provider_not_found", "Provider not found")
+		h.writeFlowError(w, r, http.StatusInternalServerError, "params_parse_failed", "Failed to parse provider params", "")
+		return
+	}
+	if schema == nil {
+		h.writeFlowError(w, r, http.StatusNotFound, "schema_not_found", "Credential schema not found for this provider", "")
 		return
 	}
 
-	var params map[string]json.RawMessage
-	if provider.Params != nil {
-		if err := json.Unmarshal(*provider.Params, &params); err != nil {
-			httputil.WriteError(w, http.StatusInternalServerError, "params_parse_failed", "Failed to parse provider params")
+	if acceptsHTML(r) {
+		// The configured schema keeps the order its properties were
+		// written in, which the redacted one loses.
+		configured, _ := captureSchema(provider)
+		fields, err := h.captureFields(r, configured, defaults)
+		if err != nil {
+			h.writeFlowError(w, r, http.StatusInternalServerError, "params_parse_failed", "Failed to parse provider params", "")
 			return
 		}
-	}
-
-	schema, ok := params["credential_schema"]
-	if !ok {
-		httputil.WriteError(w, http.StatusNotFound, "schema_not_found", "Credential schema not found for this provider")
+		form := pages.CaptureForm{State: state, ProviderName: provider.Name, Fields: fields}
+		if err := h.pages.Render(w, r, http.StatusOK, pages.Capture, form); err != nil {
+			log.Printf("capture form: %v", err)
+		}
 		return
 	}
 
@@ -325,72 +515,143 @@ func (h *CallbackHandler) GetCaptureSchema(w http.ResponseWriter, r *http.Reques
 	httputil.WriteJSON(w, http.StatusOK, response)
 }
 
-// SaveCredential handles the submission of the credential capture form.
+// splitCaptureSchema returns the credential_schema of p without the defaults
+// of its secret properties, and those defaults by field. The schema is nil
+// when p has none.
+func splitCaptureSchema(p *store.Provider) (json.RawMessage, map[string]string, error) {
+	schema, err := captureSchema(p)
+	if err != nil || schema == nil {
+		return nil, nil, err
+	}
+	return provider.SplitSecretDefaults(schema)
+}
+
+// captureSchema returns the credential_schema of p as configured, or nil
+// when p has none.
+func captureSchema(p *store.Provider) (json.RawMessage, error) {
+	var params map[string]json.RawMessage
+	if p.Params != nil {
+		if err := json.Unmarshal(*p.Params, &params); err != nil {
+			return nil, err
+		}
+	}
+	return params["credential_schema"], nil
+}
+
+// SaveCredential handles the submission of the credential capture form,
+// either as JSON ({"state", "credentials"}) or as the hosted form posts it:
+// form fields holding state and one credential each.
 func (h *CallbackHandler) SaveCredential(w http.ResponseWriter, r *http.Request) {
 	var reqBody struct {
 		State       string                 `json:"state"`
 		Credentials map[string]interface{} `json:"credentials"`
 	}
-	if err := json.NewDecoder(r.Body).Decode(&reqBody); err != nil {
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "application/x-www-form-urlencoded" {
+		if err := r.ParseForm(); err != nil {
+			h.writeFlowError(w, r, http.StatusBadRequest, "invalid_form", "Invalid form body", "")
+			return
+		}
+		reqBody.State = r.PostForm.Get("state")
+		reqBody.Credentials = map[string]interface{}{}
+		for field := range r.PostForm {
+			if field != "state" {
+				reqBody.Credentials[field] = r.PostForm.Get(field)
+			}
+		}
+	} else if err := json.NewDecoder(r.Body).Decode(&reqBody); err != nil {
 		httputil.WriteError(w, http.StatusBadRequest, "invalid_json", "Invalid JSON body")
 		return
 	}
 
 	// Verify state
-	stateData, err := auth.VerifyState(h.stateKey, reqBody.State)
+	stateData, err := h.states.Verify(reqBody.State)
 	if err != nil {
-		httputil.WriteError(w, http.StatusBadRequest, "invalid_state", "Invalid state")
+		h.writeFlowError(w, r, http.StatusBadRequest, "invalid_state", "Invalid state", "")
 		return
 	}
 
 	connectionID, err := uuid.Parse(stateData.Nonce)
 	if err != nil {
-		httputil.WriteError(w, http.StatusBadRequest, "invalid_connection_id", "Invalid connection ID")
+		h.writeFlowError(w, r, http.StatusBadRequest, "invalid_connection_id", "Invalid connection ID", "")
 		return
 	}
 
-	var returnURL string
-	err = h.db.QueryRow("SELECT return_url FROM connections WHERE id = $1", connectionID).Scan(&returnURL)
+	if !sessionMatches(stateData, r) {
+		h.logAuditEvent(&connectionID, "session_mismatch", map[string]string{"step": "capture_credential"}, r)
+		h.writeFlowError(w, r, http.StatusForbidden, "session_mismatch", "The connection was requested from another browser session", connectionID.String())
+		return
+	}
+
+	connection, err := h.store.GetConnection(r.Context(), connectionID)
 	if err != nil {
-		httputil.WriteError(w, http.StatusNotFound, "connection_not_found", "Connection not found")
+		h.writeFlowError(w, r, http.StatusNotFound, "connection_not_found", "Connection not found", connectionID.String())
+		return
+	}
+	returnURL, workspaceID, status := connection.ReturnURL, connection.WorkspaceID, connection.Status
+	// Resubmitting to an already active connection is tolerated; anything
+	// else (revoked, expired, ...) must not receive new credentials, a
+	// suspended connection is only reactivated by resuming it and one
+	// awaiting approval by an approver.
+	if status == connstate.StateSuspended || status == connstate.StateAwaitingApproval ||
+		(status != connstate.StateActive && !status.CanTransition(connstate.StateActive)) {
+		h.writeFlowError(w, r, http.StatusConflict, "connection_not_pending", "Connection is "+string(status), connectionID.String())
 		return
 	}
 
 	// Validate credentials against the provider before storing
-	var authType, authHeader, apiBaseURL, userInfoEndpoint string
-	err = h.db.QueryRow(`
-		SELECT pp.auth_type, COALESCE(pp.auth_header, ''), COALESCE(pp.api_base_url, ''), COALESCE(pp.user_info_endpoint, '')
-		FROM connections c
-		JOIN provider_profiles pp ON pp.id = c.provider_id
-		WHERE c.id = $1`, connectionID).Scan(&authType, &authHeader, &apiBaseURL, &userInfoEndpoint)
+	provider, err := h.store.GetProvider(r.Context(), connection.ProviderID)
 	if err != nil {
-		httputil.WriteError(w, http.StatusInternalServerError, "provider_config_failed", "Failed to load provider config")
+		h.writeFlowError(w, r, http.StatusInternalServerError, "provider_config_failed", "Failed to load provider config", connectionID.String())
 		return
 	}
 
-	if userInfoEndpoint != "" && apiBaseURL != "" {
-		if err := validateCredentials(authType, authHeader, apiBaseURL, userInfoEndpoint, reqBody.Credentials); err != nil {
-			httputil.WriteError(w, http.StatusBadRequest, "invalid_credentials", "Invalid credentials: "+err.Error())
+	// Fields left empty take the secret defaults of the schema, which the
+	// capture form never received.
+	_, defaults, err := splitCaptureSchema(provider)
+	if err != nil {
+		h.writeFlowError(w, r, http.StatusInternalServerError, "provider_config_failed", "Failed to load provider config", connectionID.String())
+		return
+	}
+	for field, value := range defaults {
+		if reqBody.Credentials == nil {
+			reqBody.Credentials = map[string]interface{}{}
+		}
+		if v, ok := reqBody.Credentials[field]; !ok || v == "" {
+			reqBody.Credentials[field] = value
+		}
+	}
+
+	if provider.UserInfoEndpoint != "" && provider.APIBaseURL != "" {
+		client, err := h.clients.client(provider.CABundle, 10*time.Second)
+		if err != nil {
+			h.writeFlowError(w, r, http.StatusInternalServerError, "provider_config_failed", "Failed to load provider config", connectionID.String())
+			return
+		}
+		if err := validateCredentials(client, provider.AuthType, provider.AuthHeader, provider.APIBaseURL, provider.UserInfoEndpoint, reqBody.Credentials); err != nil {
+			h.writeFlowError(w, r, http.StatusBadRequest, "invalid_credentials", "Invalid credentials: "+err.Error(), connectionID.String())
 			return
 		}
 	}
 
-	err = h.storeTokens(connectionID, reqBody.Credentials)
+	err = h.storeTokens(r.Context(), connectionID, workspaceID, reqBody.Credentials)
 	if err != nil {
-		httputil.WriteError(w, http.StatusInternalServerError, "credential_store_failed", "Failed to store credentials")
+		h.writeFlowError(w, r, http.StatusInternalServerError, "credential_store_failed", "Failed to store credentials", connectionID.String())
 		return
 	}
 
-	if err := h.updateConnectionStatus(connectionID, "active"); err != nil {
-		httputil.WriteError(w, http.StatusInternalServerError, "status_update_failed", "Failed to update connection status")
-		return
+	result := "success"
+	if status != connstate.StateActive {
+		if result, err = h.completeConsent(r.Context(), r, connection, provider); err != nil {
+			h.writeFlowError(w, r, http.StatusInternalServerError, "status_update_failed", "Failed to update connection status", connectionID.String())
+			return
+		}
 	}
 
-	http.Redirect(w, r, returnURL+"?status=success&connection_id="+connectionID.String(), http.StatusFound)
+	http.Redirect(w, r, returnURL+"?status="+result+"&connection_id="+connectionID.String(), http.StatusFound)
 }
 
 // validateCredentials makes a test call to the provider's user_info_endpoint to verify the submitted credentials.
-func validateCredentials(authType, authHeader, apiBaseURL, userInfoEndpoint string, credentials map[string]interface{}) error {
+func validateCredentials(client *http.Client, authType, authHeader, apiBaseURL, userInfoEndpoint string, credentials map[string]interface{}) error {
 	testURL := strings.TrimRight(apiBaseURL, "/") + "/" + strings.TrimLeft(userInfoEndpoint, "/")
 
 	req, err := http.NewRequest(http.MethodGet, testURL, nil)
@@ -425,7 +686,6 @@ func validateCredentials(authType, authHeader, apiBaseURL, userInfoEndpoint stri
 		return nil
 	}
 
-	client := &http.Client{Timeout: 10 * time.Second}
 	resp, err := client.Do(req)
 	if err != nil {
 		return fmt.Errorf("could not reach provider to validate credentials")
@@ -458,105 +718,126 @@ func (h *CallbackHandler) GetToken(w http.ResponseWriter, r *http.Request) {
 		httputil.WriteError(w, http.StatusBadRequest, "invalid_path", "Invalid path")
 		return
 	}
-	connectionIDStr := pathParts[len(pathParts)-2] // /connections/{id}/token
-
-	connectionID, err := uuid.Parse(connectionIDStr)
+	token, err := h.ConnectionToken(r, pathParts[len(pathParts)-2]) // /connections/{id}/token
 	if err != nil {
-		h.logAuditEvent(nil, "token_retrieval_failed", map[string]string{"error": "invalid connection ID", "id": connectionIDStr}, r)
-		httputil.WriteError(w, http.StatusBadRequest, "invalid_connection_id", "Invalid connection ID")
+		writeError(w, err)
 		return
 	}
+	httputil.WriteJSON(w, http.StatusOK, token)
+}
+
+// TokenResponse is a connection's token as GET /connections/{id}/token
+// answers it.
+type TokenResponse struct {
+	// Strategy tells the Bridge how to attach Credentials to requests.
+	Strategy map[string]interface{}
+	// Credentials are the decrypted credentials, with expires_at and
+	// expired when the expiry is known.
+	Credentials map[string]interface{}
+	// ExpiresAt is when the access token expires, if known.
+	ExpiresAt *time.Time
+	// flatten repeats Credentials at the top level of the JSON body, as
+	// OAuth2 tokens have always been answered.
+	flatten bool
+}
 
-	// Check if connection exists and is active, and fetch provider config
-	var connection struct {
-		Status     string           `db:"status"`
-		ProviderID string           `db:"provider_id"`
-		AuthType   string           `db:"auth_type"`
-		Params     *json.RawMessage `db:"params"`
+// MarshalJSON writes the strategy and credentials members, next to the
+// credentials themselves for OAuth2 tokens.
+func (t *TokenResponse) MarshalJSON() ([]byte, error) {
+	body := make(map[string]interface{}, len(t.Credentials)+2)
+	if t.flatten {
+		for k, v := range t.Credentials {
+			body[k] = v
+		}
 	}
+	body["strategy"] = t.Strategy
+	body["credentials"] = t.Credentials
+	return json.Marshal(body)
+}
 
-	err = h.db.QueryRow(`
-		SELECT c.status, c.provider_id, p.auth_type, p.params
-		FROM connections c
-		JOIN provider_profiles p ON c.provider_id = p.id
-		WHERE c.id = $1`, connectionID).Scan(&connection.Status, &connection.ProviderID, &connection.AuthType, &connection.Params)
+// ConnectionToken returns the token of the connection connectionID names,
+// auditing the retrieval. r is the request being served. Failures are
+// *Error.
+func (h *CallbackHandler) ConnectionToken(r *http.Request, connectionIDStr string) (*TokenResponse, error) {
+	connectionID, err := uuid.Parse(connectionIDStr)
+	if err != nil {
+		h.logAuditEvent(nil, "token_retrieval_failed", map[string]string{"error": "invalid connection ID", "id": connectionIDStr}, r)
+		return nil, &Error{Status: http.StatusBadRequest, Code: "invalid_connection_id", Message: "Invalid connection ID"}
+	}
 
+	// Check if connection exists and is active, and fetch provider config.
+	// Whether a token may be released is decided on the primary: a lagging
+	// replica may not know yet that the connection was revoked or suspended,
+	// or that its token was refreshed.
+	connection, err := h.store.GetConnection(store.ReadPrimary(r.Context()), connectionID)
+	var provider *store.Provider
+	if err == nil {
+		provider, err = h.store.GetProvider(r.Context(), connection.ProviderID)
+	}
 	if err != nil {
 		h.logAuditEvent(&connectionID, "token_retrieval_failed", map[string]string{"error": "connection not found or db error", "id": connectionID.String()}, r)
-		httputil.WriteError(w, http.StatusNotFound, "connection_not_found", "Connection not found")
-		return
+		return nil, &Error{Status: http.StatusNotFound, Code: "connection_not_found", Message: "Connection not found"}
 	}
 
-	if connection.Status != "active" {
-		h.logAuditEvent(&connectionID, "token_retrieval_failed", map[string]string{"error": "connection not active", "status": connection.Status}, r)
-
-		if connection.Status == "attention" {
-			httputil.WriteJSON(w, http.StatusConflict, map[string]string{
-				"error":  "attention_required",
-				"detail": "Connection requires attention. The user must re-authenticate.",
-			})
-			return
-		}
-
-		httputil.WriteError(w, http.StatusForbidden, "connection_not_active", "Connection not active")
-		return
+	if connection.Status != connstate.StateActive {
+		h.logAuditEvent(&connectionID, "token_retrieval_failed", map[string]string{"error": "connection not active", "status": string(connection.Status)}, r)
+		return nil, h.notActiveError(r.Context(), connection)
 	}
 
 	// Get the encrypted token
-	var token struct {
-		EncryptedData string     `db:"encrypted_data"`
-		ExpiresAt     *time.Time `db:"expires_at"`
-	}
-
-	err = h.db.QueryRow("SELECT encrypted_data, expires_at FROM tokens WHERE connection_id = $1", connectionID).Scan(&token.EncryptedData, &token.ExpiresAt)
+	token, err := h.store.GetTokens(store.ReadPrimary(r.Context()), connectionID)
 	if err != nil {
 		h.logAuditEvent(&connectionID, "token_retrieval_failed", map[string]string{"error": "token not found"}, r)
-		httputil.WriteError(w, http.StatusNotFound, "token_not_found", "Token not found")
-		return
+		return nil, &Error{Status: http.StatusNotFound, Code: "token_not_found", Message: "Token not found"}
 	}
 
 	// Decrypt the token
-	decryptedData, err := vault.Decrypt(h.encryptionKey, token.EncryptedData)
+	decryptedData, err := vault.OpenToken(h.encryptionKey, token.EncryptedData, connectionID.String(), token.WorkspaceID, h.allowLegacyTokens)
 	if err != nil {
 		h.logAuditEvent(&connectionID, "token_retrieval_failed", map[string]string{"error": "decryption failed"}, r)
-		httputil.WriteError(w, http.StatusInternalServerError, "decrypt_failed", "Failed to decrypt token")
-		return
+		return nil, &Error{Status: http.StatusInternalServerError, Code: "decrypt_failed", Message: "Failed to decrypt token"}
 	}
 
 	// Parse the JSON token data (the credentials)
 	var credentials map[string]interface{}
 	if err := json.Unmarshal(decryptedData, &credentials); err != nil {
 		h.logAuditEvent(&connectionID, "token_retrieval_failed", map[string]string{"error": "invalid token format"}, r)
-		httputil.WriteError(w, http.StatusInternalServerError, "invalid_token_format", "Invalid token format")
-		return
+		return nil, &Error{Status: http.StatusInternalServerError, Code: "invalid_token_format", Message: "Invalid token format"}
 	}
 
+	// The DPoP key goes to the Bridge in the strategy, never as a credential.
+	dpopKey, hasDPoPKey := credentials[dpopKeyField]
+	delete(credentials, dpopKeyField)
+
 	// Add expiration info to credentials if available (for back-compat and ease of use)
 	if token.ExpiresAt != nil {
 		credentials["expires_at"] = token.ExpiresAt.Format(time.RFC3339)
 		credentials["expired"] = token.ExpiresAt.Before(time.Now())
 	}
 
-	// Construct the final response payload
-	response := make(map[string]interface{})
+	response := &TokenResponse{Credentials: credentials, ExpiresAt: token.ExpiresAt}
 
 	// 1. Determine Strategy
 	var strategy map[string]interface{}
 
-	if connection.AuthType == "oauth2" || connection.AuthType == "" {
+	// Service account tokens are OAuth2 bearer tokens too.
+	if provider.AuthType == "oauth2" || provider.AuthType == "" || provider.AuthType == authTypeServiceAccount {
 		strategy = map[string]interface{}{
 			"type": "oauth2",
 		}
-		// For backward compatibility: flatten credentials into the root for OAuth2
-		for k, v := range credentials {
-			response[k] = v
+		// A DPoP-bound token is only accepted with proofs signed by the
+		// connection's key.
+		if hasDPoPKey && dpopBound(credentials) {
+			strategy["config"] = map[string]interface{}{dpopKeyField: dpopKey}
 		}
+		// For backward compatibility: flatten credentials into the root for OAuth2
+		response.flatten = true
 	} else {
 		// Generic Provider: Look for auth_strategy in params
 		foundStrategy := false
-		if connection.Params != nil {
+		if provider.Params != nil {
 			var paramsMap map[string]interface{}
-			if err := json.Unmarshal(*connection.Params, &paramsMap); err == nil {
+			if err := json.Unmarshal(*provider.Params, &paramsMap); err == nil {
 				if s, ok := paramsMap["auth_strategy"].(map[string]interface{}); ok {
 					strategy = s
 					foundStrategy = true
@@ -567,35 +848,98 @@ func (h *CallbackHandler) GetToken(w http.ResponseWriter, r *http.Request) {
 		if !foundStrategy {
 			// Map high-level broker auth_types to default bridge strategies if possible
 			// This is a "best effort" mapping if the explicit config is missing
-			switch connection.AuthType {
+			switch provider.AuthType {
 			case "api_key":
-				strategy = map[string]interface{}{"type": "header", "config": map[string]string{"header_name": "X-API-Key", "credential_field": "api_key"}}
+				strategy = map[string]interface{}{"type": "header", "config": map[string]interface{}{"header_name": "X-API-Key", "credential_field": "api_key"}}
 			case "basic_auth":
 				strategy = map[string]interface{}{"type": "basic_auth"}
 			default:
-				strategy = map[string]interface{}{"type": connection.AuthType} // Hope for the best
+				strategy = map[string]interface{}{"type": provider.AuthType} // Hope for the best
 			}
 		}
 	}
 
-	response["strategy"] = strategy
-	response["credentials"] = credentials
+	response.Strategy = strategy
 
 	// Log successful retrieval
 	h.logAuditEvent(&connectionID, "token_retrieved", map[string]string{}, r)
+	h.usage.Record(r.Context(), connectionID, time.Now())
 
 	// Emit metric for token retrieval
 	hasID := "false"
 	if _, ok := credentials["id_token"]; ok {
 		hasID = "true"
 	}
-	h.metricTokenGet.WithLabelValues(connection.ProviderID, hasID).Inc()
+	h.metricTokenGet.WithLabelValues(connection.ProviderID.String(), hasID).Inc()
 
-	httputil.WriteJSON(w, http.StatusOK, response)
+	return response, nil
 }
 
-// exchangeCodeForTokens exchanges authorization code for access tokens
-func (h *CallbackHandler) exchangeCodeForTokens(tokenURL, clientID, clientSecret, code, codeVerifier, redirectURI string, scopes []string, authHeader string, skipScopeOnExchange bool) (map[string]interface{}, error) {
+// Token endpoint sources recorded in provider_profiles.token_endpoint_preference.
+const (
+	tokenEndpointDiscovered = "discovered"
+	tokenEndpointConfigured = "configured"
+)
+
+// tokenEndpoint is a candidate URL for the authorization code exchange.
+type tokenEndpoint struct {
+	URL    string
+	Source string
+}
+
+// tokenEndpoints returns the token endpoints to try for a provider: the one
+// from OIDC discovery and the configured token_url, deduplicated. Discovery
+// goes first unless the provider has recorded that the configured URL works.
+func (h *CallbackHandler) tokenEndpoints(r *http.Request, caBundle, configuredURL, preference string) []tokenEndpoint {
+	var endpoints []tokenEndpoint
+	client, err := h.clients.discoveryClient(caBundle)
+	if err != nil {
+		log.Printf("token endpoint discovery skipped: %v", err)
+	} else if md, err := discovery.Discover(r.Context(), client, discovery.Hint{AuthURL: configuredURL}); err == nil && strings.TrimSpace(md.AuthorizationEndpoint) != "" && strings.TrimSpace(md.TokenEndpoint) != "" {
+		endpoints = append(endpoints, tokenEndpoint{URL: md.TokenEndpoint, Source: tokenEndpointDiscovered})
+	}
+	if configuredURL != "" && (len(endpoints) == 0 || endpoints[0].URL != configuredURL) {
+		endpoints = append(endpoints, tokenEndpoint{URL: configuredURL, Source: tokenEndpointConfigured})
+	}
+	if len(endpoints) == 2 && preference == tokenEndpointConfigured {
+		endpoints[0], endpoints[1] = endpoints[1], endpoints[0]
+	}
+	return endpoints
+}
+
+// exchangeWithFallback calls exchange for each endpoint in order, moving on
+// only when the previous endpoint rejected the request with a 4xx. Network
+// errors and 5xx responses are returned as-is since retrying elsewhere would
+// not help. It returns the endpoint that produced the result.
+func exchangeWithFallback(endpoints []tokenEndpoint, exchange func(tokenEndpoint) (map[string]interface{}, int, error)) (map[string]interface{}, tokenEndpoint, error) {
+	if len(endpoints) == 0 {
+		return nil, tokenEndpoint{}, fmt.Errorf("no token endpoint configured")
+	}
+	var (
+		tokens map[string]interface{}
+		status int
+		err    error
+	)
+	for i, ep := range endpoints {
+		tokens, status, err = exchange(ep)
+		if err == nil || status < 400 || status >= 500 || i == len(endpoints)-1 {
+			return tokens, ep, err
+		}
+	}
+	return tokens, endpoints[len(endpoints)-1], err
+}
+
+// recordTokenEndpoint remembers which token endpoint worked so the next
+// exchange for the provider tries it first. Failures are logged only.
+func (h *CallbackHandler) recordTokenEndpoint(ctx context.Context, providerID uuid.UUID, source string) {
+	if err := h.store.SetTokenEndpointPreference(ctx, providerID, source); err != nil {
+		log.Printf("Failed to record token endpoint preference for provider %s: %v", providerID, err)
+	}
+}
+
+// exchangeCodeForTokens exchanges authorization code for access tokens.
+// The returned status code is 0 when no HTTP response was received.
+func (h *CallbackHandler) exchangeCodeForTokens(ctx context.Context, client *http.Client, pol tokenPolicy, tokenURL, clientID, clientSecret, code, codeVerifier, redirectURI string, scopes []string, authHeader string, skipScopeOnExchange bool) (map[string]interface{}, int, error) {
 	data := url.Values{}
 	data.Set("grant_type", "authorization_code")
 	data.Set("code", code)
@@ -625,9 +969,9 @@ func (h *CallbackHandler) exchangeCodeForTokens(tokenURL, clientID, clientSecret
 		data.Set("scope", strings.Join(scopes, " "))
 	}
 
-	req, err := http.NewRequest("POST", tokenURL, strings.NewReader(data.Encode()))
+	req, err := http.NewRequestWithContext(ctx, "POST", tokenURL, strings.NewReader(data.Encode()))
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
@@ -638,55 +982,51 @@ func (h *CallbackHandler) exchangeCodeForTokens(tokenURL, clientID, clientSecret
 		req.SetBasicAuth(clientID, clientSecret)
 	}
 
-	client := &http.Client{Timeout: 30 * time.Second}
-	resp, err := client.Do(req)
+	resp, err := sendTokenRequest(ctx, client, req, pol, grantAuthorizationCode)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("token exchange failed: %s", string(body))
+		return nil, resp.StatusCode, fmt.Errorf("token exchange failed: %s", readTokenError(resp))
 	}
 
-	var tokens map[string]interface{}
-	if err := json.NewDecoder(resp.Body).Decode(&tokens); err != nil {
-		return nil, err
+	tokens, err := decodeTokenResponse(resp)
+	if err != nil {
+		return nil, resp.StatusCode, err
 	}
 
-	return tokens, nil
+	return tokens, resp.StatusCode, nil
 }
 
 // refreshTokens refreshes using a refresh_token
-func (h *CallbackHandler) refreshTokens(tokenURL, clientID, clientSecret, refreshToken string) (map[string]interface{}, int, error) {
+func (h *CallbackHandler) refreshTokens(ctx context.Context, client *http.Client, pol tokenPolicy, tokenURL, clientID, clientSecret, refreshToken string) (map[string]interface{}, int, error) {
 	data := url.Values{}
 	data.Set("grant_type", "refresh_token")
 	data.Set("refresh_token", refreshToken)
 	data.Set("client_id", clientID)
 	data.Set("client_secret", clientSecret)
 
-	req, err := http.NewRequest("POST", tokenURL, strings.NewReader(data.Encode()))
+	req, err := http.NewRequestWithContext(ctx, "POST", tokenURL, strings.NewReader(data.Encode()))
 	if err != nil {
 		return nil, 0, err
 	}
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 	req.Header.Set("Accept", "application/json") // Ensure JSON response
 
-	client := &http.Client{Timeout: 30 * time.Second}
-	resp, err := client.Do(req)
+	resp, err := sendTokenRequest(ctx, client, req, pol, grantRefreshToken)
 	if err != nil {
 		return nil, 0, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, resp.StatusCode, fmt.Errorf("token refresh failed: %s", string(body))
+		return nil, resp.StatusCode, fmt.Errorf("token refresh failed: %s", readTokenError(resp))
 	}
 
-	var tokens map[string]interface{}
-	if err := json.NewDecoder(resp.Body).Decode(&tokens); err != nil {
+	tokens, err := decodeTokenResponse(resp)
+	if err != nil {
 		return nil, resp.StatusCode, err
 	}
 	return tokens, resp.StatusCode, nil
@@ -700,136 +1040,258 @@ func (h *CallbackHandler) Refresh(w http.ResponseWriter, r *http.Request) {
 		httputil.WriteError(w, http.StatusBadRequest, "invalid_path", "Invalid path")
 		return
 	}
-	idStr := parts[len(parts)-2]
-	connectionID, err := uuid.Parse(idStr)
+	newTokens, err := h.RefreshConnection(r, parts[len(parts)-2])
 	if err != nil {
-		httputil.WriteError(w, http.StatusBadRequest, "invalid_connection_id", "Invalid connection ID")
+		writeError(w, err)
 		return
 	}
+	httputil.WriteJSON(w, http.StatusOK, newTokens)
+}
 
-	var conn struct {
-		ProviderID string `db:"provider_id"`
-		AuthType   string `db:"auth_type"`
+// RefreshConnection refreshes the token of the connection connectionID
+// names and returns the provider's token response. r is the request being
+// served. Failures are *Error.
+func (h *CallbackHandler) RefreshConnection(r *http.Request, idStr string) (map[string]interface{}, error) {
+	connectionID, err := uuid.Parse(idStr)
+	if err != nil {
+		return nil, &Error{Status: http.StatusBadRequest, Code: "invalid_connection_id", Message: "Invalid connection ID"}
 	}
-	err = h.db.QueryRow(`
-		SELECT c.provider_id, p.auth_type 
-		FROM connections c
-		JOIN provider_profiles p ON c.provider_id = p.id
-		WHERE c.id=$1 AND c.status='active'`, connectionID).Scan(&conn.ProviderID, &conn.AuthType)
 
+	conn, err := h.store.GetConnection(store.ReadPrimary(r.Context()), connectionID)
+	if err == nil && conn.Status == connstate.StateSuspended {
+		return nil, suspendedError(r.Context(), h.store, connectionID)
+	}
+	if err != nil || conn.Status != connstate.StateActive {
+		return nil, &Error{Status: http.StatusNotFound, Code: "connection_not_found", Message: "Connection not active or not found"}
+	}
+	if h.quota != nil {
+		if err := h.quota.AllowRefresh(r.Context(), conn.WorkspaceID); err != nil {
+			return nil, quotaError(conn.WorkspaceID, err)
+		}
+	}
+	newTokens, rerr := h.refresh(r.Context(), r, conn)
+	if rerr != nil {
+		return nil, rerr.apiError()
+	}
+	return newTokens, nil
+}
+
+// refreshError is a refresh that did not produce a token, as it is
+// answered.
+type refreshError struct {
+	status  int
+	code    string
+	message string
+	// err is the failed provider call, if that is what failed.
+	err error
+}
+
+// apiError is the answer to e.
+func (e *refreshError) apiError() *Error {
+	if limited := providerRateLimitedError(e.err); limited != nil {
+		return limited
+	}
+	return &Error{Status: e.status, Code: e.code, Message: e.message}
+}
+
+func (e *refreshError) write(w http.ResponseWriter) {
+	e.apiError().Write(w)
+}
+
+// refresh exchanges conn's refresh token for a new token and stores it.
+// Provider failures are recorded in the refresh dead-letter queue (see
+// recordRefreshFailure) and a success clears it. r is the request being
+// served, nil for background retries.
+func (h *CallbackHandler) refresh(ctx context.Context, r *http.Request, conn *store.Connection) (map[string]interface{}, *refreshError) {
+	connectionID := conn.ID
+	provider, err := h.store.GetProvider(ctx, conn.ProviderID)
 	if err != nil {
-		httputil.WriteError(w, http.StatusNotFound, "connection_not_found", "Connection not active or not found")
-		return
+		return nil, &refreshError{status: http.StatusInternalServerError, code: "provider_not_found", message: "Provider not found"}
 	}
+	provider = withTenant(provider, conn.Tenant)
 
 	// Check the auth type right away
-	switch conn.AuthType {
+	switch provider.AuthType {
 	case "api_key", "basic_auth":
 		// Static tokens cannot be refreshed.
-		httputil.WriteError(w, http.StatusBadRequest, "static_token", "This connection uses a static token and cannot be refreshed")
-		return // Stop execution here
+		return nil, &refreshError{status: http.StatusBadRequest, code: "static_token", message: "This connection uses a static token and cannot be refreshed"}
 	case "oauth2", "":
 		// This is an OAuth2 provider, continue with the *existing* refresh logic
-		var provider struct {
-			TokenURL     sql.NullString `db:"token_url"`
-			ClientID     sql.NullString `db:"client_id"`
-			ClientSecret sql.NullString `db:"client_secret"`
-		}
-		err = h.db.QueryRow("SELECT token_url, client_id, client_secret FROM provider_profiles WHERE id=$1", conn.ProviderID).Scan(&provider.TokenURL, &provider.ClientID, &provider.ClientSecret)
-		if err != nil {
-			httputil.WriteError(w, http.StatusInternalServerError, "provider_not_found", "Provider not found")
-			return
-		}
-		var tokenRow struct {
-			EncryptedData string `db:"encrypted_data"`
-		}
-		err = h.db.QueryRow("SELECT encrypted_data FROM tokens WHERE connection_id=$1", connectionID).Scan(&tokenRow.EncryptedData)
-		if err != nil {
-			httputil.WriteError(w, http.StatusNotFound, "token_not_found", "Token not found")
-			return
-		}
-		plaintext, err := vault.Decrypt(h.encryptionKey, tokenRow.EncryptedData)
-		if err != nil {
-			httputil.WriteError(w, http.StatusInternalServerError, "decrypt_failed", "Decrypt failed")
-			return
-		}
-		var current map[string]interface{}
-		if err := json.Unmarshal(plaintext, &current); err != nil {
-			httputil.WriteError(w, http.StatusInternalServerError, "token_parse_failed", "Token parse failed")
-			return
-		}
-		refreshToken, _ := current["refresh_token"].(string)
-		if refreshToken == "" {
-			httputil.WriteError(w, http.StatusBadRequest, "no_refresh_token", "No refresh_token available")
-			return
-		}
-		// Refresh
-		newTokens, statusCode, err := h.refreshTokens(provider.TokenURL.String, provider.ClientID.String, provider.ClientSecret.String, refreshToken)
-		if err != nil {
-			// Check for unrecoverable errors (400-499 usually implies invalid_grant, revoked, or expired)
-			if statusCode >= 400 && statusCode < 500 {
-				h.logAuditEvent(&connectionID, "token_refresh_fatal", map[string]string{"error": err.Error(), "status_code": fmt.Sprintf("%d", statusCode)}, r)
-				h.updateConnectionStatus(connectionID, "attention")
-
-				httputil.WriteJSON(w, http.StatusConflict, map[string]string{
-					"error":  "attention_required",
-					"detail": "The connection credentials are invalid or expired and cannot be refreshed. User re-consent is required.",
-				})
-				return
-			}
+	case authTypeServiceAccount:
+		return h.refreshDelegated(ctx, r, conn, provider)
+	default:
+		return nil, &refreshError{status: http.StatusInternalServerError, code: "unsupported_auth_type", message: "Unsupported provider auth_type"}
+	}
 
-			// For 5xx or network errors, we don't change state, just fail the request (Agent will retry)
-			httputil.WriteError(w, http.StatusBadGateway, "upstream_error", err.Error())
-			return
+	pol := h.tokenPolicy(provider)
+	client, err := h.clients.client(provider.CABundle, pol.timeout)
+	if err != nil {
+		return nil, &refreshError{status: http.StatusInternalServerError, code: "provider_config_failed", message: "Failed to load provider config"}
+	}
+	seen, err := h.store.GetTokens(ctx, connectionID)
+	if err != nil {
+		return nil, &refreshError{status: http.StatusNotFound, code: "token_not_found", message: "Token not found"}
+	}
+	// Only one refresh per connection runs at a time: providers that
+	// rotate refresh tokens reject the second exchange of the same one.
+	ttl := refreshLockTTL(pol)
+	lockCtx, cancel := context.WithTimeout(ctx, refreshLockWait)
+	release, err := h.refreshLock.Acquire(lockCtx, "refresh:"+connectionID.String(), ttl)
+	cancel()
+	if err != nil {
+		return nil, &refreshError{status: http.StatusServiceUnavailable, code: "refresh_in_progress", message: "Another refresh of this connection is still running; retry shortly"}
+	}
+	defer release()
+	// Finish before the lock expires, or a concurrent refresh could spend
+	// the same single-use refresh token. Background retries have no other
+	// deadline.
+	ctx, cancelRefresh := context.WithTimeout(ctx, ttl-refreshLockMargin/2)
+	defer cancelRefresh()
+	// Re-read from the primary: the lock holder may have stored a new
+	// token that a replica has not seen yet.
+	tokenRow, err := h.store.GetTokens(store.ReadPrimary(ctx), connectionID)
+	if err != nil {
+		return nil, &refreshError{status: http.StatusNotFound, code: "token_not_found", message: "Token not found"}
+	}
+	plaintext, err := vault.OpenToken(h.encryptionKey, tokenRow.EncryptedData, connectionID.String(), tokenRow.WorkspaceID, h.allowLegacyTokens)
+	if err != nil {
+		return nil, &refreshError{status: http.StatusInternalServerError, code: "decrypt_failed", message: "Decrypt failed"}
+	}
+	var current map[string]interface{}
+	if err := json.Unmarshal(plaintext, &current); err != nil {
+		return nil, &refreshError{status: http.StatusInternalServerError, code: "token_parse_failed", message: "Token parse failed"}
+	}
+	dpopKey, err := takeDPoPKey(current)
+	if err != nil {
+		return nil, &refreshError{status: http.StatusInternalServerError, code: "token_parse_failed", message: "Token parse failed"}
+	}
+	pol.dpop = dpopKey
+	if tokenRow.EncryptedData != seen.EncryptedData {
+		// A concurrent refresh finished while we waited; its token is
+		// as fresh as ours would be.
+		h.metricRefreshShared.Inc()
+		return current, nil
+	}
+	refreshToken, _ := current["refresh_token"].(string)
+	if refreshToken == "" {
+		return nil, &refreshError{status: http.StatusBadRequest, code: "no_refresh_token", message: "No refresh_token available"}
+	}
+	if g := h.rotatedGeneration(ctx, connectionID, refreshToken); g != nil {
+		return nil, h.markCompromised(ctx, r, conn, reuseStored, g)
+	}
+	// Refresh
+	newTokens, statusCode, err := h.refreshTokens(ctx, client, pol, provider.TokenURL, provider.ClientID, provider.ClientSecret, refreshToken)
+	if err != nil {
+		class := refreshErrorClass(statusCode)
+		// Check for unrecoverable errors (400-499 usually implies invalid_grant, revoked, or expired)
+		if class == refreshClassRejected {
+			h.logAuditEvent(&connectionID, "token_refresh_fatal", map[string]string{"error": err.Error(), "status_code": fmt.Sprintf("%d", statusCode), "provider_id": conn.ProviderID.String()}, r)
+			h.recordRefreshFailure(ctx, r, conn, class, statusCode, err)
+			return nil, &refreshError{status: http.StatusConflict, code: "attention_required",
+				message: "The connection credentials are invalid or expired and cannot be refreshed. User re-consent is required."}
 		}
-		// Store new tokens
-		if err := h.storeTokens(connectionID, newTokens); err != nil {
-			httputil.WriteError(w, http.StatusInternalServerError, "token_store_failed", "Store refreshed token failed")
-			return
+
+		// For 5xx, network errors and rate limits, we don't change state, just fail the request (Agent will retry)
+		h.logAuditEvent(&connectionID, "token_refresh_failed", map[string]string{"error": err.Error(), "status_code": fmt.Sprintf("%d", statusCode), "provider_id": conn.ProviderID.String()}, r)
+		h.recordRefreshFailure(ctx, r, conn, class, statusCode, err)
+		return nil, &refreshError{status: http.StatusBadGateway, code: "upstream_error", message: err.Error(), err: err}
+	}
+	if rt, _ := newTokens["refresh_token"].(string); rt != "" && rt != refreshToken {
+		if g := h.rotatedGeneration(ctx, connectionID, rt); g != nil {
+			return nil, h.markCompromised(ctx, r, conn, reuseProvider, g)
 		}
-		httputil.WriteJSON(w, http.StatusOK, newTokens)
-	default:
-		httputil.WriteError(w, http.StatusInternalServerError, "unsupported_auth_type", "Unsupported provider auth_type")
-		return
 	}
+	// Store new tokens
+	if err := h.storeTokens(ctx, connectionID, tokenRow.WorkspaceID, withDPoPKey(newTokens, dpopKey)); err != nil {
+		return nil, &refreshError{status: http.StatusInternalServerError, code: "token_store_failed", message: "Store refreshed token failed"}
+	}
+	h.clearRefreshFailure(ctx, r, conn)
+	return newTokens, nil
 }
 
-// storeTokens encrypts and upserts a single token row per connection.
-// Uses INSERT ... ON CONFLICT to atomically replace any previous token,
-// preventing unbounded row accumulation (issue #25).
-func (h *CallbackHandler) storeTokens(connectionID uuid.UUID, tokens map[string]interface{}) error {
-	tokenJSON, err := json.Marshal(tokens)
-	if err != nil {
+// recordIdentity stores the verified id_token's sub and email claims on the
+// connection.
+func (h *CallbackHandler) recordIdentity(ctx context.Context, connectionID uuid.UUID, idt *gooidc.IDToken) error {
+	var claims struct {
+		Email string `json:"email"`
+	}
+	if err := idt.Claims(&claims); err != nil {
 		return err
 	}
+	return h.store.SetIdentity(ctx, connectionID, idt.Subject, claims.Email)
+}
 
-	encryptedData, err := vault.Encrypt(h.encryptionKey, tokenJSON)
+// storeTokens encrypts and upserts a single token row per connection.
+// The TokenStore replaces any previous token atomically, preventing
+// unbounded row accumulation (issue #25). The ciphertext is bound
+// to the connection and workspace (see vault.SealToken). A refresh token
+// in tokens becomes the connection's current generation.
+func (h *CallbackHandler) storeTokens(ctx context.Context, connectionID uuid.UUID, workspaceID string, tokens map[string]interface{}) error {
+	encryptedData, expiresAt, err := h.sealTokens(connectionID, workspaceID, tokens)
 	if err != nil {
 		return err
 	}
+	if err := h.store.SaveTokens(ctx, connectionID, encryptedData, expiresAt); err != nil {
+		return err
+	}
+	h.recordRefreshToken(ctx, connectionID, tokens)
+	return nil
+}
 
+// sealTokens encrypts tokens for the connection and returns the ciphertext
+// with the access token's expiry, if the provider gave one.
+func (h *CallbackHandler) sealTokens(connectionID uuid.UUID, workspaceID string, tokens map[string]interface{}) (string, *time.Time, error) {
+	tokenJSON, err := json.Marshal(tokens)
+	if err != nil {
+		return "", nil, err
+	}
+	encryptedData, err := vault.SealToken(h.encryptionKey, tokenJSON, connectionID.String(), workspaceID)
+	if err != nil {
+		return "", nil, err
+	}
 	var expiresAt *time.Time
 	if expiresIn, ok := tokens["expires_in"].(float64); ok {
 		expiry := time.Now().Add(time.Duration(expiresIn) * time.Second)
 		expiresAt = &expiry
 	}
+	return encryptedData, expiresAt, nil
+}
 
-	_, err = h.db.Exec(`
-		INSERT INTO tokens (connection_id, encrypted_data, expires_at)
-		VALUES ($1, $2, $3)
-		ON CONFLICT (connection_id)
-		DO UPDATE SET
-			encrypted_data = EXCLUDED.encrypted_data,
-			expires_at     = EXCLUDED.expires_at,
-			created_at     = NOW()`,
-		connectionID, encryptedData, expiresAt)
+// flowConnection returns the connection a callback completes: a pending
+// connection, or one with a re-consent started by Reauthorize, in which case
+// reauth is true.
+func (h *CallbackHandler) flowConnection(ctx context.Context, id uuid.UUID) (conn *store.Connection, reauth bool, err error) {
+	conn, err = h.store.GetPendingConnection(ctx, id)
+	if errors.Is(err, store.ErrNotFound) {
+		if c, errR := h.store.GetPendingReauthorization(ctx, id); errR == nil {
+			return c, true, nil
+		}
+	}
+	return conn, false, err
+}
 
-	return err
+// endFailedFlow ends a failed flow: a new connection becomes failed with
+// code, while a re-consent is discarded and the existing connection left as
+// it was.
+func (h *CallbackHandler) endFailedFlow(ctx context.Context, id uuid.UUID, reauth bool, code string) {
+	if reauth {
+		if err := h.store.CancelReauthorization(ctx, id); err != nil {
+			log.Printf("connection %s: cancel reauthorization: %v", id, err)
+		}
+		return
+	}
+	if err := h.store.FailConnection(ctx, id, code); err != nil {
+		log.Printf("connection %s: status -> %s: %v", id, connstate.StateFailed, err)
+	}
 }
 
-// updateConnectionStatus updates the connection status
-func (h *CallbackHandler) updateConnectionStatus(connectionID uuid.UUID, status string) error {
-	_, err := h.db.Exec("UPDATE connections SET status = $1, updated_at = NOW() WHERE id = $2", status, connectionID)
+// updateConnectionStatus moves the connection to status through the
+// connection state machine, which rejects illegal transitions.
+func (h *CallbackHandler) updateConnectionStatus(ctx context.Context, connectionID uuid.UUID, status connstate.State) error {
+	err := h.store.UpdateStatus(ctx, connectionID, status)
+	if err != nil {
+		log.Printf("connection %s: status -> %s: %v", connectionID, status, err)
+	}
 	return err
 }
 
@@ -850,13 +1312,51 @@ func (h *CallbackHandler) logAuditEvent(connectionID *uuid.UUID, eventType strin
 	}
 }
 
+// sessionMatches reports whether the request completing a flow comes from
+// the browser session the flow is bound to: the Gateway forwards the
+// session from its cookie in state.SessionHeader, and its hash must be the
+// state's SessionHash. Unbound flows always match.
+func sessionMatches(data *state.Data, r *http.Request) bool {
+	if data.SessionHash == "" {
+		return true
+	}
+	session := r.Header.Get(state.SessionHeader)
+	return session != "" && subtle.ConstantTimeCompare([]byte(state.HashSession(session)), []byte(data.SessionHash)) == 1
+}
+
 // handleError handles OAuth errors
 func (h *CallbackHandler) handleError(w http.ResponseWriter, r *http.Request, errorType, description string) {
-	// Log the error
-	h.logAuditEvent(nil, "oauth_error", map[string]string{
+	// A user who declines consent is told so; any other error is the
+	// provider's.
+	code := "oauth_error"
+	if errorType == "access_denied" {
+		code = "consent_denied"
+	}
+	message := fmt.Sprintf("OAuth error: %s - %s", errorType, description)
+
+	// Providers send the state back with the error, which names the flow
+	// to end. Without a valid one there is no return_url to go back to.
+	var connection *store.Connection
+	reauth := false
+	if stateData, err := h.states.Verify(r.URL.Query().Get("state")); err == nil && sessionMatches(stateData, r) {
+		if id, err := uuid.Parse(stateData.Nonce); err == nil {
+			connection, reauth, _ = h.flowConnection(r.Context(), id)
+		}
+	}
+
+	var connectionID *uuid.UUID
+	if connection != nil {
+		connectionID = &connection.ID
+	}
+	h.logAuditEvent(connectionID, "oauth_error", map[string]string{
 		"error":       errorType,
 		"description": description,
 	}, r)
 
-	httputil.WriteError(w, http.StatusBadRequest, "oauth_error", fmt.Sprintf("OAuth error: %s - %s", errorType, description))
+	if connection == nil {
+		h.writeFlowError(w, r, http.StatusBadRequest, code, message, "")
+		return
+	}
+	h.endFailedFlow(r.Context(), connection.ID, reauth, code)
+	h.redirectFailure(w, r, connection, http.StatusBadRequest, code, message)
 }
@@ -4,45 +4,65 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
-	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/auth"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/internal/store"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/connstate"
 	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/vault"
+	"github.com/Prescott-Data/nexus-framework/nexus-common/state"
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
-	"github.com/jmoiron/sqlx"
 	"github.com/stretchr/testify/assert"
-	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"github.com/stretchr/testify/require"
 )
 
-func TestRefresh_StaticKeyProvider(t *testing.T) {
-	db, mock, err := sqlmock.New()
-	assert.NoError(t, err)
-	defer db.Close()
+// testStates returns a keyring holding only key.
+func testStates(t testing.TB, key []byte) *state.Keyring {
+	t.Helper()
+	k, err := state.NewKeyring(state.Key{Secret: key}, nil)
+	require.NoError(t, err)
+	return k
+}
+
+var refreshConnectionID = uuid.MustParse("b1b1b1b1-b1b1-b1b1-b1b1-b1b1b1b1b1b1")
+
+// seedConnection stores a provider of the given auth type and a connection
+// to it in st.
+func seedConnection(st *store.Memory, id uuid.UUID, status connstate.State, provider store.Provider) {
+	if provider.ID == uuid.Nil {
+		provider.ID = uuid.New()
+	}
+	st.PutProvider(provider)
+	st.PutConnection(store.Connection{
+		ID:          id,
+		WorkspaceID: "ws-1",
+		ProviderID:  provider.ID,
+		Status:      status,
+		ReturnURL:   "http://localhost:3000/callback",
+		ExpiresAt:   time.Now().Add(10 * time.Minute),
+	})
+}
 
-	sqlxDB := sqlx.NewDb(db, "sqlmock")
+func TestRefresh_StaticKeyProvider(t *testing.T) {
+	st := store.NewMemory()
 	handler := NewCallbackHandler(CallbackHandlerConfig{
-		DB:            sqlxDB,
+		Store:         st,
 		BaseURL:       "http://localhost:8080",
 		RedirectPath:  "/auth/callback",
 		EncryptionKey: []byte("test-key"),
-		StateKey:      []byte("test-key"),
+		States:        testStates(t, []byte("test-key")),
 		HTTPClient:    http.DefaultClient,
 	})
 
-	// Mock the initial query to find the connection
-
-	rows := sqlmock.NewRows([]string{"provider_id", "auth_type"}).
-		AddRow(uuid.New().String(), "api_key") // Use a new UUID for provider_id
-
-	mock.ExpectQuery("SELECT c.provider_id, p.auth_type FROM connections c JOIN provider_profiles p ON c.provider_id = p.id WHERE c.id=\\$1 AND c.status='active'").
-		WithArgs(uuid.MustParse("b1b1b1b1-b1b1-b1b1-b1b1-b1b1b1b1b1b1")). // Match the connection ID from the request
-		WillReturnRows(rows)
+	seedConnection(st, refreshConnectionID, connstate.StateActive, store.Provider{AuthType: "api_key"})
 
 	req, err := http.NewRequest("POST", "/connections/b1b1b1b1-b1b1-b1b1-b1b1-b1b1b1b1b1b1/refresh", nil)
 	assert.NoError(t, err)
@@ -59,12 +79,26 @@ func TestRefresh_StaticKeyProvider(t *testing.T) {
 	assert.Contains(t, rr.Body.String(), "This connection uses a static token and cannot be refreshed")
 }
 
-func TestRefresh_OAuth2Provider(t *testing.T) {
-	db, mock, err := sqlmock.New()
-	assert.NoError(t, err)
-	defer db.Close()
+func TestRefresh_InactiveConnectionNotFound(t *testing.T) {
+	st := store.NewMemory()
+	handler := NewCallbackHandler(CallbackHandlerConfig{
+		Store:         st,
+		EncryptionKey: []byte("01234567890123456789012345678901"),
+		HTTPClient:    http.DefaultClient,
+	})
+
+	seedConnection(st, refreshConnectionID, connstate.StateRevoked, store.Provider{AuthType: "oauth2"})
+
+	req := httptest.NewRequest("POST", "/connections/b1b1b1b1-b1b1-b1b1-b1b1-b1b1b1b1b1b1/refresh", nil)
+	rr := httptest.NewRecorder()
+	handler.Refresh(rr, req)
 
-	sqlxDB := sqlx.NewDb(db, "sqlmock")
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	assert.Contains(t, rr.Body.String(), "connection_not_found")
+}
+
+func TestRefresh_OAuth2Provider(t *testing.T) {
+	st := store.NewMemory()
 
 	// Mock the external HTTP call to the provider's token URL
 	mockProviderServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -73,41 +107,29 @@ func TestRefresh_OAuth2Provider(t *testing.T) {
 	}))
 	defer mockProviderServer.Close()
 
+	key := []byte("01234567890123456789012345678901")
 	handler := NewCallbackHandler(CallbackHandlerConfig{
-		DB:            sqlxDB,
+		Store:         st,
 		BaseURL:       "http://localhost:8080",
 		RedirectPath:  "/auth/callback",
-		EncryptionKey: []byte("01234567890123456789012345678901"),
-		StateKey:      []byte("01234567890123456789012345678901"),
+		EncryptionKey: key,
+		States:        testStates(t, key),
 		HTTPClient:    mockProviderServer.Client(),
 	})
 
-	// Mock the initial query to find the connection
-
-	rows := sqlmock.NewRows([]string{"provider_id", "auth_type"}).
-		AddRow(uuid.New().String(), "oauth2")
-	mock.ExpectQuery("SELECT c.provider_id, p.auth_type FROM connections c JOIN provider_profiles p ON c.provider_id = p.id WHERE c.id=\\$1 AND c.status='active'").
-		WithArgs(uuid.MustParse("b1b1b1b1-b1b1-b1b1-b1b1-b1b1b1b1b1b1")).
-		WillReturnRows(rows)
-
-	mock.ExpectQuery("SELECT token_url, client_id, client_secret FROM provider_profiles WHERE id=\\$1").
-		WithArgs(sqlmock.AnyArg()).
-		WillReturnRows(sqlmock.NewRows([]string{"token_url", "client_id", "client_secret"}).
-			AddRow(mockProviderServer.URL, "test-client-id", "test-client-secret"))
-
-		// Encrypt the token before mocking the query
-
-	tokenData := map[string]interface{}{"refresh_token": "test-refresh-token"}
-	tokenJSON, _ := json.Marshal(tokenData)
-	encryptedToken, err := vault.Encrypt([]byte("01234567890123456789012345678901"), tokenJSON)
-	assert.NoError(t, err)
-
-	mock.ExpectQuery("SELECT encrypted_data FROM tokens WHERE connection_id=\\$1").
-		WithArgs(sqlmock.AnyArg()).
-		WillReturnRows(sqlmock.NewRows([]string{"encrypted_data"}).AddRow(encryptedToken))
+	seedConnection(st, refreshConnectionID, connstate.StateActive, store.Provider{
+		AuthType:     "oauth2",
+		TokenURL:     mockProviderServer.URL,
+		ClientID:     "test-client-id",
+		ClientSecret: "test-client-secret",
+	})
 
-	mock.ExpectExec("INSERT INTO tokens").
-		WillReturnResult(sqlmock.NewResult(1, 1))
+	// A legacy (unbound) ciphertext is still accepted until
+	// RequireBoundTokens is set.
+	tokenJSON, _ := json.Marshal(map[string]interface{}{"refresh_token": "test-refresh-token"})
+	encryptedToken, err := vault.Encrypt(key, tokenJSON)
+	require.NoError(t, err)
+	require.NoError(t, st.SaveTokens(context.Background(), refreshConnectionID, encryptedToken, nil))
 
 	req, err := http.NewRequest("POST", "/connections/b1b1b1b1-b1b1-b1b1-b1b1-b1b1b1b1b1b1/refresh", nil)
 	assert.NoError(t, err)
@@ -121,45 +143,40 @@ func TestRefresh_OAuth2Provider(t *testing.T) {
 	handler.Refresh(rr, req)
 
 	assert.Equal(t, http.StatusOK, rr.Code)
+
+	// The refreshed token replaces the legacy one, now bound to the connection.
+	tok, err := st.GetTokens(context.Background(), refreshConnectionID)
+	require.NoError(t, err)
+	plain, err := vault.OpenToken(key, tok.EncryptedData, refreshConnectionID.String(), "ws-1", false)
+	require.NoError(t, err)
+	assert.Contains(t, string(plain), "new-access-token")
 }
 
 func TestGetCaptureSchema(t *testing.T) {
-	db, mock, err := sqlmock.New()
-	assert.NoError(t, err)
-	defer db.Close()
-
-	sqlxDB := sqlx.NewDb(db, "sqlmock")
+	st := store.NewMemory()
 	// Use a real key for signing/verifying state
 	stateKey := []byte("01234567890123456789012345678901")
 	handler := NewCallbackHandler(CallbackHandlerConfig{
-		DB:            sqlxDB,
+		Store:         st,
 		BaseURL:       "http://localhost:8080",
 		RedirectPath:  "/auth/callback",
 		EncryptionKey: nil,
-		StateKey:      stateKey,
+		States:        testStates(t, stateKey),
 		HTTPClient:    http.DefaultClient,
 	})
 
 	providerID := uuid.New()
-	stateData := auth.StateData{
+	stateData := state.Data{
 		ProviderID: providerID.String(),
 		Nonce:      "test-nonce",
 		IAT:        time.Now(),
 	}
-	signedState, err := auth.SignState(stateKey, stateData)
+	signedState, err := testStates(t, stateKey).Sign(stateData)
 	assert.NoError(t, err)
 
-	// Mock the database query
 	mockSchema := `{"type":"object","properties":{"api_key":{"type":"string"}}}`
-	mockParams := json.RawMessage(`{"credential_schema":` + mockSchema + `}`)
-	mockParamsBytes, _ := json.Marshal(mockParams)
-
-	rows := sqlmock.NewRows([]string{"name", "params"}).
-		AddRow("Test Provider", mockParamsBytes)
-
-	mock.ExpectQuery("SELECT name, params FROM provider_profiles WHERE id = \\$1").
-		WithArgs(providerID).
-		WillReturnRows(rows)
+	params := json.RawMessage(`{"credential_schema":` + mockSchema + `}`)
+	st.PutProvider(store.Provider{ID: providerID, Name: "Test Provider", AuthType: "api_key", Params: &params})
 
 	// Create the request
 	req, err := http.NewRequest("GET", "/auth/capture-schema?state="+url.QueryEscape(signedState), nil)
@@ -184,51 +201,27 @@ func TestGetCaptureSchema(t *testing.T) {
 }
 
 func TestSaveCredential_ValidState(t *testing.T) {
-	db, mock, err := sqlmock.New()
-	assert.NoError(t, err)
-	defer db.Close()
-
-	sqlxDB := sqlx.NewDb(db, "sqlmock")
+	st := store.NewMemory()
 	stateKey := []byte("01234567890123456789012345678901")
 	encryptionKey := []byte("01234567890123456789012345678901")
 	handler := NewCallbackHandler(CallbackHandlerConfig{
-		DB:            sqlxDB,
+		Store:         st,
 		BaseURL:       "http://localhost:8080",
 		RedirectPath:  "/auth/callback",
 		EncryptionKey: encryptionKey,
-		StateKey:      stateKey,
+		States:        testStates(t, stateKey),
 		HTTPClient:    http.DefaultClient,
 	})
 
 	connectionID := uuid.New()
-	stateData := auth.StateData{
+	seedConnection(st, connectionID, connstate.StatePending, store.Provider{AuthType: "api_key"})
+	stateData := state.Data{
 		Nonce: connectionID.String(),
 		IAT:   time.Now(),
 	}
-	signedState, err := auth.SignState(stateKey, stateData)
+	signedState, err := testStates(t, stateKey).Sign(stateData)
 	assert.NoError(t, err)
 
-	// Mock DB calls
-	mock.ExpectQuery("SELECT return_url FROM connections WHERE id = \\$1").
-		WithArgs(connectionID).
-		WillReturnRows(sqlmock.NewRows([]string{"return_url"}).AddRow("http://localhost:3000/callback"))
-
-	// Mock the provider config lookup for credential validation
-	mock.ExpectQuery("SELECT pp.auth_type").
-		WithArgs(connectionID).
-		WillReturnRows(sqlmock.NewRows([]string{"auth_type", "auth_header", "api_base_url", "user_info_endpoint"}).
-			AddRow("api_key", "", "", ""))
-
-	// 1. Mock the call to storeTokens (upsert)
-	mock.ExpectExec(
-		"INSERT INTO tokens",
-	).WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
-
-	// 2. Mock the call to updateConnectionStatus
-	mock.ExpectExec(
-		"UPDATE connections SET status = \\$1, updated_at = NOW\\(\\) WHERE id = \\$2",
-	).WithArgs("active", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
-
 	// Create request body
 	creds := map[string]interface{}{"api_key": "test-key"}
 	body := map[string]interface{}{
@@ -249,6 +242,116 @@ func TestSaveCredential_ValidState(t *testing.T) {
 	assert.Contains(t, location, "http://localhost:3000/callback")
 	assert.Contains(t, location, "status=success")
 	assert.Contains(t, location, "connection_id="+connectionID.String())
+
+	// The stored ciphertext must be bound to this connection and workspace.
+	tok, err := st.GetTokens(context.Background(), connectionID)
+	require.NoError(t, err)
+	_, err = vault.OpenToken(encryptionKey, tok.EncryptedData, connectionID.String(), "ws-1", false)
+	assert.NoError(t, err)
+
+	conn, err := st.GetConnection(context.Background(), connectionID)
+	require.NoError(t, err)
+	assert.Equal(t, connstate.StateActive, conn.Status)
+}
+
+func TestGetCaptureSchema_HidesSecretDefaults(t *testing.T) {
+	st := store.NewMemory()
+	stateKey := []byte("01234567890123456789012345678901")
+	handler := NewCallbackHandler(CallbackHandlerConfig{
+		Store:        st,
+		BaseURL:      "http://localhost:8080",
+		RedirectPath: "/auth/callback",
+		States:       testStates(t, stateKey),
+		HTTPClient:   http.DefaultClient,
+	})
+
+	providerID := uuid.New()
+	signedState, err := testStates(t, stateKey).Sign(state.Data{ProviderID: providerID.String(), Nonce: "test-nonce", IAT: time.Now()})
+	require.NoError(t, err)
+
+	params := json.RawMessage(`{"credential_schema":{"type":"object","properties":{
+		"username":{"type":"string","default":"svc-nexus"},
+		"password":{"type":"string","format":"password","default":"hunter2"}}}}`)
+	st.PutProvider(store.Provider{ID: providerID, Name: "Test Provider", AuthType: "basic_auth", Params: &params})
+
+	req := httptest.NewRequest("GET", "/auth/capture-schema?state="+url.QueryEscape(signedState), nil)
+	rr := httptest.NewRecorder()
+	handler.GetCaptureSchema(rr, req)
+
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.NotContains(t, rr.Body.String(), "hunter2")
+	assert.Contains(t, rr.Body.String(), "svc-nexus")
+}
+
+func TestSaveCredential_FillsSecretDefaults(t *testing.T) {
+	st := store.NewMemory()
+	stateKey := []byte("01234567890123456789012345678901")
+	encryptionKey := []byte("01234567890123456789012345678901")
+	handler := NewCallbackHandler(CallbackHandlerConfig{
+		Store:         st,
+		BaseURL:       "http://localhost:8080",
+		RedirectPath:  "/auth/callback",
+		EncryptionKey: encryptionKey,
+		States:        testStates(t, stateKey),
+		HTTPClient:    http.DefaultClient,
+	})
+
+	params := json.RawMessage(`{"credential_schema":{"type":"object","properties":{
+		"username":{"type":"string"},
+		"password":{"type":"string","writeOnly":true,"default":"hunter2"}}}}`)
+	connectionID := uuid.New()
+	seedConnection(st, connectionID, connstate.StatePending, store.Provider{AuthType: "basic_auth", Params: &params})
+	signedState, err := testStates(t, stateKey).Sign(state.Data{Nonce: connectionID.String(), IAT: time.Now()})
+	require.NoError(t, err)
+
+	body, _ := json.Marshal(map[string]interface{}{
+		"state":       signedState,
+		"credentials": map[string]interface{}{"username": "alice"},
+	})
+	rr := httptest.NewRecorder()
+	handler.SaveCredential(rr, httptest.NewRequest("POST", "/auth/capture-credential", bytes.NewReader(body)))
+	require.Equal(t, http.StatusFound, rr.Code)
+
+	tok, err := st.GetTokens(context.Background(), connectionID)
+	require.NoError(t, err)
+	plain, err := vault.OpenToken(encryptionKey, tok.EncryptedData, connectionID.String(), "ws-1", false)
+	require.NoError(t, err)
+	var creds map[string]interface{}
+	require.NoError(t, json.Unmarshal(plain, &creds))
+	assert.Equal(t, "alice", creds["username"])
+	assert.Equal(t, "hunter2", creds["password"])
+}
+
+func TestSaveCredential_RevokedConnectionRejected(t *testing.T) {
+	st := store.NewMemory()
+	stateKey := []byte("01234567890123456789012345678901")
+	handler := NewCallbackHandler(CallbackHandlerConfig{
+		Store:         st,
+		BaseURL:       "http://localhost:8080",
+		RedirectPath:  "/auth/callback",
+		EncryptionKey: []byte("01234567890123456789012345678901"),
+		States:        testStates(t, stateKey),
+		HTTPClient:    http.DefaultClient,
+	})
+
+	connectionID := uuid.New()
+	seedConnection(st, connectionID, connstate.StateRevoked, store.Provider{AuthType: "api_key"})
+	signedState, err := testStates(t, stateKey).Sign(state.Data{Nonce: connectionID.String(), IAT: time.Now()})
+	assert.NoError(t, err)
+
+	jsonBody, _ := json.Marshal(map[string]interface{}{
+		"state":       signedState,
+		"credentials": map[string]interface{}{"api_key": "test-key"},
+	})
+	req := httptest.NewRequest("POST", "/auth/capture-credential", bytes.NewBuffer(jsonBody))
+
+	rr := httptest.NewRecorder()
+	handler.SaveCredential(rr, req)
+
+	assert.Equal(t, http.StatusConflict, rr.Code)
+	assert.Contains(t, rr.Body.String(), "connection_not_pending")
+	_, err = st.GetTokens(context.Background(), connectionID)
+	assert.ErrorIs(t, err, store.ErrNotFound)
 }
 
 func TestSaveCredential_InvalidState(t *testing.T) {
@@ -257,7 +360,7 @@ func TestSaveCredential_InvalidState(t *testing.T) {
 		BaseURL:       "http://localhost:8080",
 		RedirectPath:  "/auth/callback",
 		EncryptionKey: nil,
-		StateKey:      []byte("test-key"),
+		States:        testStates(t, []byte("test-key")),
 		HTTPClient:    http.DefaultClient,
 	})
 
@@ -285,7 +388,6 @@ func TestSaveCredential_InvalidJSON(t *testing.T) {
 		BaseURL:       "http://localhost:8080",
 		RedirectPath:  "/auth/callback",
 		EncryptionKey: nil,
-		StateKey:      nil,
 		HTTPClient:    http.DefaultClient,
 	})
 
@@ -299,3 +401,195 @@ func TestSaveCredential_InvalidJSON(t *testing.T) {
 	assert.Equal(t, http.StatusBadRequest, rr.Code)
 	assert.Contains(t, rr.Body.String(), "Invalid JSON body")
 }
+
+func TestRefresh_RejectsTokenCopiedFromAnotherConnection(t *testing.T) {
+	st := store.NewMemory()
+	key := []byte("01234567890123456789012345678901")
+	handler := NewCallbackHandler(CallbackHandlerConfig{
+		Store:         st,
+		EncryptionKey: key,
+		States:        testStates(t, key),
+		HTTPClient:    http.DefaultClient,
+	})
+
+	seedConnection(st, refreshConnectionID, connstate.StateActive, store.Provider{
+		AuthType: "oauth2", TokenURL: "http://unused", ClientID: "id", ClientSecret: "secret",
+	})
+
+	// Ciphertext sealed for a different connection, then copied into this row.
+	stolen, err := vault.SealToken(key, []byte(`{"refresh_token":"victim"}`), uuid.New().String(), "ws-1")
+	require.NoError(t, err)
+	require.NoError(t, st.SaveTokens(context.Background(), refreshConnectionID, stolen, nil))
+
+	req := httptest.NewRequest("POST", "/connections/b1b1b1b1-b1b1-b1b1-b1b1-b1b1b1b1b1b1/refresh", nil)
+	rr := httptest.NewRecorder()
+	handler.Refresh(rr, req)
+
+	assert.Equal(t, http.StatusInternalServerError, rr.Code)
+	assert.Contains(t, rr.Body.String(), "decrypt_failed")
+}
+
+func TestTokenEndpoints_DiscoveredFirstUnlessConfiguredPreferred(t *testing.T) {
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 srv.URL,
+			"authorization_endpoint": srv.URL + "/authorize",
+			"token_endpoint":         srv.URL + "/discovered/token",
+			"jwks_uri":               srv.URL + "/jwks",
+		})
+	}))
+	defer srv.Close()
+
+	handler := NewCallbackHandler(CallbackHandlerConfig{HTTPClient: srv.Client()})
+	req := httptest.NewRequest("GET", "/auth/callback", nil)
+	configured := srv.URL + "/oauth/token"
+
+	eps := handler.tokenEndpoints(req, "", configured, "")
+	assert.Equal(t, []tokenEndpoint{
+		{URL: srv.URL + "/discovered/token", Source: tokenEndpointDiscovered},
+		{URL: configured, Source: tokenEndpointConfigured},
+	}, eps)
+
+	eps = handler.tokenEndpoints(req, "", configured, tokenEndpointConfigured)
+	assert.Equal(t, tokenEndpointConfigured, eps[0].Source)
+	assert.Equal(t, tokenEndpointDiscovered, eps[1].Source)
+
+	// Identical URLs collapse to a single attempt.
+	eps = handler.tokenEndpoints(req, "", srv.URL+"/discovered/token", "")
+	assert.Len(t, eps, 1)
+}
+
+func TestExchangeWithFallback(t *testing.T) {
+	endpoints := []tokenEndpoint{
+		{URL: "https://idp.example.com/discovered", Source: tokenEndpointDiscovered},
+		{URL: "https://idp.example.com/configured", Source: tokenEndpointConfigured},
+	}
+
+	t.Run("retries alternate on 4xx", func(t *testing.T) {
+		var tried []string
+		tokens, used, err := exchangeWithFallback(endpoints, func(ep tokenEndpoint) (map[string]interface{}, int, error) {
+			tried = append(tried, ep.Source)
+			if ep.Source == tokenEndpointDiscovered {
+				return nil, http.StatusBadRequest, assert.AnError
+			}
+			return map[string]interface{}{"access_token": "at"}, http.StatusOK, nil
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, "at", tokens["access_token"])
+		assert.Equal(t, tokenEndpointConfigured, used.Source)
+		assert.Equal(t, []string{tokenEndpointDiscovered, tokenEndpointConfigured}, tried)
+	})
+
+	t.Run("does not retry on 5xx or network errors", func(t *testing.T) {
+		for _, status := range []int{http.StatusBadGateway, 0} {
+			calls := 0
+			_, used, err := exchangeWithFallback(endpoints, func(ep tokenEndpoint) (map[string]interface{}, int, error) {
+				calls++
+				return nil, status, assert.AnError
+			})
+			assert.Error(t, err)
+			assert.Equal(t, 1, calls)
+			assert.Equal(t, tokenEndpointDiscovered, used.Source)
+		}
+	})
+
+	t.Run("returns last error when both reject", func(t *testing.T) {
+		calls := 0
+		_, used, err := exchangeWithFallback(endpoints, func(ep tokenEndpoint) (map[string]interface{}, int, error) {
+			calls++
+			return nil, http.StatusUnauthorized, assert.AnError
+		})
+		assert.Error(t, err)
+		assert.Equal(t, 2, calls)
+		assert.Equal(t, tokenEndpointConfigured, used.Source)
+	})
+}
+
+func TestRefresh_ConcurrentRefreshesExchangeOnce(t *testing.T) {
+	st := store.NewMemory()
+
+	var calls int32
+	release := make(chan struct{})
+	mockProviderServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&calls, 1)
+		<-release
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"access_token": "access-%d", "refresh_token": "refresh-%d", "expires_in": 3600}`, n, n)
+	}))
+	defer mockProviderServer.Close()
+
+	key := []byte("01234567890123456789012345678901")
+	handler := NewCallbackHandler(CallbackHandlerConfig{
+		Store:         st,
+		EncryptionKey: key,
+		States:        testStates(t, key),
+		HTTPClient:    mockProviderServer.Client(),
+	})
+	seedConnection(st, refreshConnectionID, connstate.StateActive, store.Provider{
+		AuthType: "oauth2", TokenURL: mockProviderServer.URL, ClientID: "id", ClientSecret: "secret",
+	})
+	sealed, err := vault.SealToken(key, []byte(`{"refresh_token":"single-use"}`), refreshConnectionID.String(), "ws-1")
+	require.NoError(t, err)
+	require.NoError(t, st.SaveTokens(context.Background(), refreshConnectionID, sealed, nil))
+
+	const n = 3
+	var wg sync.WaitGroup
+	recorders := make([]*httptest.ResponseRecorder, n)
+	for i := range recorders {
+		recorders[i] = httptest.NewRecorder()
+		wg.Add(1)
+		go func(rr *httptest.ResponseRecorder) {
+			defer wg.Done()
+			req := httptest.NewRequest("POST", "/connections/b1b1b1b1-b1b1-b1b1-b1b1-b1b1b1b1b1b1/refresh", nil)
+			handler.Refresh(rr, req)
+		}(recorders[i])
+	}
+	// Let every request reach the provider or the lock before answering.
+	time.Sleep(150 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls), "the refresh_token must be exchanged once")
+	for _, rr := range recorders {
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Contains(t, rr.Body.String(), `"access_token":"access-1"`)
+	}
+}
+
+func TestRefresh_FormEncodedTokenResponse(t *testing.T) {
+	st := store.NewMemory()
+	var accept string
+	mockProviderServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		accept = r.Header.Get("Accept")
+		// GitHub's legacy default.
+		w.Header().Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+		io.WriteString(w, "access_token=gho_new&expires_in=28800&refresh_token=ghr_new&token_type=bearer")
+	}))
+	defer mockProviderServer.Close()
+
+	key := []byte("01234567890123456789012345678901")
+	handler := NewCallbackHandler(CallbackHandlerConfig{
+		Store:         st,
+		EncryptionKey: key,
+		States:        testStates(t, key),
+		HTTPClient:    mockProviderServer.Client(),
+	})
+	seedConnection(st, refreshConnectionID, connstate.StateActive, store.Provider{
+		AuthType: "oauth2", TokenURL: mockProviderServer.URL, ClientID: "id", ClientSecret: "secret",
+	})
+	sealed, err := vault.SealToken(key, []byte(`{"refresh_token":"ghr_old"}`), refreshConnectionID.String(), "ws-1")
+	require.NoError(t, err)
+	require.NoError(t, st.SaveTokens(context.Background(), refreshConnectionID, sealed, nil))
+
+	rr := httptest.NewRecorder()
+	handler.Refresh(rr, httptest.NewRequest("POST", "/connections/b1b1b1b1-b1b1-b1b1-b1b1-b1b1b1b1b1b1/refresh", nil))
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "application/json", accept)
+	assert.JSONEq(t, `{"access_token":"gho_new","expires_in":28800,"refresh_token":"ghr_new","token_type":"bearer"}`, rr.Body.String())
+	tok, err := st.GetTokens(context.Background(), refreshConnectionID)
+	require.NoError(t, err)
+	assert.NotNil(t, tok.ExpiresAt, "expires_in from the form body sets the token expiry")
+}
@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/pages"
+)
+
+// captureFields lists the inputs of the capture form for the properties of
+// schema, in the order the schema declares them. A property's label is the
+// catalog message field.<name> in r's language when there is one, else its
+// title, else its name. Secret properties with a default (by field in
+// secretDefaults) are optional and never prefilled.
+func (h *CallbackHandler) captureFields(r *http.Request, schema json.RawMessage, secretDefaults map[string]string) ([]pages.Field, error) {
+	var s struct {
+		Properties json.RawMessage `json:"properties"`
+		Required   []string        `json:"required"`
+	}
+	if err := json.Unmarshal(schema, &s); err != nil {
+		return nil, err
+	}
+	names, err := objectKeys(s.Properties)
+	if err != nil {
+		return nil, err
+	}
+	var properties map[string]struct {
+		Title       string `json:"title"`
+		Description string `json:"description"`
+		Format      string `json:"format"`
+		WriteOnly   bool   `json:"writeOnly"`
+		Default     any    `json:"default"`
+	}
+	if err := json.Unmarshal(s.Properties, &properties); err != nil {
+		return nil, err
+	}
+	required := map[string]bool{}
+	for _, name := range s.Required {
+		required[name] = true
+	}
+
+	tag := h.pages.Language(r)
+	fields := make([]pages.Field, 0, len(names))
+	for _, name := range names {
+		prop := properties[name]
+		field := pages.Field{Name: name, Label: name, Description: prop.Description, Type: "text", Required: required[name]}
+		if label, ok := h.pages.Lookup(tag, "field."+name); ok {
+			field.Label = label
+		} else if prop.Title != "" {
+			field.Label = prop.Title
+		}
+		switch {
+		case prop.WriteOnly || prop.Format == "password":
+			field.Type = "password"
+		case prop.Format == "email":
+			field.Type = "email"
+		case prop.Format == "uri" || prop.Format == "url":
+			field.Type = "url"
+		}
+		if _, ok := secretDefaults[name]; ok {
+			field.Required = false
+			field.HasDefault = true
+		} else if prop.Default != nil && field.Type != "password" {
+			field.Value = fmt.Sprint(prop.Default)
+		}
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+// objectKeys returns the keys of the JSON object raw in document order, or
+// none when raw is empty.
+func objectKeys(raw json.RawMessage) ([]string, error) {
+	if len(raw) == 0 {
+		return nil, nil
+	}
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &object); err != nil {
+		return nil, err
+	}
+	// The decoder reads each key and then skips its value.
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	if _, err := dec.Token(); err != nil {
+		return nil, err
+	}
+	keys := make([]string, 0, len(object))
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		var skip json.RawMessage
+		if err := dec.Decode(&skip); err != nil {
+			return nil, err
+		}
+		// A repeated key appears once, where it first did.
+		if _, ok := object[key.(string)]; ok {
+			keys = append(keys, key.(string))
+			delete(object, key.(string))
+		}
+	}
+	return keys, nil
+}
@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/internal/store"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/connstate"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/vault"
+	"github.com/Prescott-Data/nexus-framework/nexus-common/state"
+)
+
+func TestCaptureForm(t *testing.T) {
+	st := store.NewMemory()
+	stateKey := []byte("01234567890123456789012345678901")
+	encryptionKey := []byte("01234567890123456789012345678901")
+	handler := NewCallbackHandler(CallbackHandlerConfig{
+		Store:         st,
+		BaseURL:       "http://localhost:8080",
+		RedirectPath:  "/auth/callback",
+		EncryptionKey: encryptionKey,
+		States:        testStates(t, stateKey),
+		HTTPClient:    http.DefaultClient,
+	})
+
+	params := json.RawMessage(`{"credential_schema":{"type":"object","required":["username","password"],"properties":{
+		"username":{"type":"string","title":"User name","default":"svc-nexus"},
+		"password":{"type":"string","format":"password","default":"hunter2"},
+		"api_key":{"type":"string","writeOnly":true,"description":"From the account settings"}}}}`)
+	connectionID := uuid.New()
+	provider := store.Provider{ID: uuid.New(), Name: "Files", AuthType: "basic_auth", Params: &params}
+	seedConnection(st, connectionID, connstate.StatePending, provider)
+	signed, err := testStates(t, stateKey).Sign(state.Data{ProviderID: provider.ID.String(), Nonce: connectionID.String(), IAT: time.Now()})
+	require.NoError(t, err)
+
+	req := httptest.NewRequest("GET", "/auth/capture-schema?state="+url.QueryEscape(signed), nil)
+	req.Header.Set("Accept", "text/html")
+	req.Header.Set("Accept-Language", "de-DE,de;q=0.9")
+	rr := httptest.NewRecorder()
+	handler.GetCaptureSchema(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	page := rr.Body.String()
+	assert.Equal(t, "de", rr.Header().Get("Content-Language"))
+	assert.Contains(t, page, "Files verbinden")
+	assert.Contains(t, page, `value="`+signed+`"`)
+	assert.NotContains(t, page, "hunter2")
+
+	// Fields keep the schema's order, labels and input types.
+	assert.Less(t, strings.Index(page, `name="username"`), strings.Index(page, `name="password"`))
+	assert.Less(t, strings.Index(page, `name="password"`), strings.Index(page, `name="api_key"`))
+	assert.Contains(t, page, `<label for="field-username">User name</label>`)
+	assert.Contains(t, page, `name="username" type="text" value="svc-nexus" required>`)
+	assert.Contains(t, page, `name="password" type="password">`)
+	assert.Contains(t, page, `name="api_key" type="password">`)
+	assert.Contains(t, page, "From the account settings")
+
+	// The form posts its fields, and an empty secret takes its default.
+	form := url.Values{"state": {signed}, "username": {"alice"}, "password": {""}}
+	post := httptest.NewRequest("POST", "/auth/capture-credential", strings.NewReader(form.Encode()))
+	post.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	post.Header.Set("Accept", "text/html")
+	rr = httptest.NewRecorder()
+	handler.SaveCredential(rr, post)
+	require.Equal(t, http.StatusFound, rr.Code, rr.Body.String())
+	assert.Contains(t, rr.Header().Get("Location"), "status=success")
+
+	tok, err := st.GetTokens(context.Background(), connectionID)
+	require.NoError(t, err)
+	plain, err := vault.OpenToken(encryptionKey, tok.EncryptedData, connectionID.String(), "ws-1", false)
+	require.NoError(t, err)
+	var creds map[string]interface{}
+	require.NoError(t, json.Unmarshal(plain, &creds))
+	assert.Equal(t, "alice", creds["username"])
+	assert.Equal(t, "hunter2", creds["password"])
+
+	// Posting to a revoked connection shows the error page.
+	post = httptest.NewRequest("POST", "/auth/capture-credential", strings.NewReader(form.Encode()))
+	post.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	post.Header.Set("Accept", "text/html")
+	require.NoError(t, st.UpdateStatus(context.Background(), connectionID, connstate.StateRevoked))
+	rr = httptest.NewRecorder()
+	handler.SaveCredential(rr, post)
+	assert.Equal(t, http.StatusConflict, rr.Code)
+	assert.Contains(t, rr.Body.String(), "<code>connection_not_pending</code>")
+}
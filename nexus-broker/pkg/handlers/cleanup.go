@@ -5,31 +5,27 @@ import (
 	"log"
 	"time"
 
-	"github.com/jmoiron/sqlx"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/internal/store"
 )
 
 // StartOrphanTokenCleanup periodically removes token rows whose parent
 // connection has been deleted. This is a safety net for rows that the UPSERT
 // logic cannot reach (e.g. a connection was deleted but its token row lingers).
-// The query uses a LEFT JOIN rather than age-based deletion to avoid removing
-// valid tokens for slow-refreshing connections.
-func StartOrphanTokenCleanup(ctx context.Context, db *sqlx.DB, interval time.Duration) {
+// Tokens are matched to missing connections rather than deleted by age to
+// avoid removing valid tokens for slow-refreshing connections.
+func StartOrphanTokenCleanup(ctx context.Context, s store.SweepStore, interval time.Duration) {
 	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
 	for {
 		select {
 		case <-ticker.C:
-			result, err := db.ExecContext(ctx, `
-				DELETE FROM tokens t
-				WHERE NOT EXISTS (
-					SELECT 1 FROM connections c WHERE c.id = t.connection_id
-				)`)
+			rows, err := s.DeleteOrphanTokens(ctx)
 			if err != nil {
 				log.Printf("orphan token cleanup failed: %v", err)
 				continue
 			}
-			if rows, _ := result.RowsAffected(); rows > 0 {
+			if rows > 0 {
 				log.Printf("orphan token cleanup: deleted %d orphaned rows", rows)
 			}
 		case <-ctx.Done():
@@ -37,3 +33,49 @@ func StartOrphanTokenCleanup(ctx context.Context, db *sqlx.DB, interval time.Dur
 		}
 	}
 }
+
+// StartPendingExpiry periodically moves pending connections whose consent
+// window has passed to the expired state.
+func StartPendingExpiry(ctx context.Context, s store.SweepStore, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			n, err := s.ExpirePendingConnections(ctx)
+			if err != nil {
+				log.Printf("pending connection expiry failed: %v", err)
+				continue
+			}
+			if n > 0 {
+				log.Printf("pending connection expiry: expired %d connections", n)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// StartGrantCleanup periodically deletes grants that expired more than a
+// day ago, redeemed or not.
+func StartGrantCleanup(ctx context.Context, s store.SweepStore, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			rows, err := s.DeleteExpiredGrants(ctx, time.Now().Add(-24*time.Hour))
+			if err != nil {
+				log.Printf("grant cleanup failed: %v", err)
+				continue
+			}
+			if rows > 0 {
+				log.Printf("grant cleanup: deleted %d expired grants", rows)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
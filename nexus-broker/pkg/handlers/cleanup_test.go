@@ -8,6 +8,8 @@ import (
 	"github.com/jmoiron/sqlx"
 	"github.com/stretchr/testify/assert"
 	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/internal/store"
 )
 
 func TestStartOrphanTokenCleanup_DeletesOrphans(t *testing.T) {
@@ -23,7 +25,7 @@ func TestStartOrphanTokenCleanup_DeletesOrphans(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 
 	// Use a longer interval to ensure it only ticks once during our sleep
-	go StartOrphanTokenCleanup(ctx, sqlxDB, 200*time.Millisecond)
+	go StartOrphanTokenCleanup(ctx, store.NewPostgres(sqlxDB), 200*time.Millisecond)
 
 	// Wait enough for the first tick to fire, but less than two ticks
 	time.Sleep(300 * time.Millisecond)
@@ -46,7 +48,7 @@ func TestStartOrphanTokenCleanup_StopsOnContextCancel(t *testing.T) {
 	done := make(chan struct{})
 
 	go func() {
-		StartOrphanTokenCleanup(ctx, sqlxDB, 1*time.Hour)
+		StartOrphanTokenCleanup(ctx, store.NewPostgres(sqlxDB), 1*time.Hour)
 		close(done)
 	}()
 
@@ -0,0 +1,282 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/internal/audit"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/internal/store"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/connstate"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/httputil"
+)
+
+// ConnectionsHandler serves operator views of connections: looking one up,
+// listing a user's connections in a workspace, reporting unused ones and
+// revoking a single connection.
+type ConnectionsHandler struct {
+	store store.Store
+	audit audit.Logger
+}
+
+// ConnectionsHandlerConfig configures a ConnectionsHandler.
+type ConnectionsHandlerConfig struct {
+	// Store defaults to Postgres through DB.
+	Store store.Store
+	DB    *sqlx.DB
+	Audit audit.Logger
+}
+
+// NewConnectionsHandler creates a ConnectionsHandler.
+func NewConnectionsHandler(cfg ConnectionsHandlerConfig) *ConnectionsHandler {
+	if cfg.Store == nil {
+		cfg.Store = store.NewPostgres(cfg.DB)
+	}
+	return &ConnectionsHandler{store: cfg.Store, audit: cfg.Audit}
+}
+
+// ConnectionSummary describes a connection without its credentials.
+type ConnectionSummary struct {
+	ConnectionID string   `json:"connection_id"`
+	WorkspaceID  string   `json:"workspace_id"`
+	ProviderID   string   `json:"provider_id"`
+	Status       string   `json:"status"`
+	Scopes       []string `json:"scopes"`
+	Subject      string   `json:"subject,omitempty"`
+	Email        string   `json:"email,omitempty"`
+	// TokenRetrievals and LastAccessedAt lag by up to the usage flush
+	// interval.
+	TokenRetrievals int64      `json:"token_retrievals"`
+	LastAccessedAt  *time.Time `json:"last_accessed_at,omitempty"`
+}
+
+func summarizeConnection(c store.Connection) ConnectionSummary {
+	scopes := c.Scopes
+	if scopes == nil {
+		scopes = []string{}
+	}
+	return ConnectionSummary{
+		ConnectionID:    c.ID.String(),
+		WorkspaceID:     c.WorkspaceID,
+		ProviderID:      c.ProviderID.String(),
+		Status:          string(c.Status),
+		Scopes:          scopes,
+		Subject:         c.Subject,
+		Email:           c.Email,
+		TokenRetrievals: c.TokenRetrievals,
+		LastAccessedAt:  c.LastAccessedAt,
+	}
+}
+
+// Get handles GET /connections/{connectionID}.
+func (h *ConnectionsHandler) Get(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "connectionID"))
+	if err != nil {
+		httputil.WriteError(w, http.StatusBadRequest, "invalid_connection_id", "Invalid connection ID")
+		return
+	}
+	conn, err := h.store.GetConnection(r.Context(), id)
+	if errors.Is(err, store.ErrNotFound) {
+		httputil.WriteError(w, http.StatusNotFound, "connection_not_found", "Connection not found")
+		return
+	}
+	if err != nil {
+		log.Printf("connections: get %s: %v", id, err)
+		httputil.WriteError(w, http.StatusInternalServerError, "get_failed", "Failed to look up the connection")
+		return
+	}
+	httputil.WriteJSON(w, http.StatusOK, summarizeConnection(*conn))
+}
+
+// ConnectionStatus is the body of GET /connections/{connectionID}/status.
+type ConnectionStatus struct {
+	ConnectionID string `json:"connection_id"`
+	Status       string `json:"status"`
+	// Reason tells clients what, if anything, brings the connection back:
+	// waiting (refresh_failing, suspended) or the user consenting again
+	// (reauth_required, consent_expired, ...).
+	Reason string `json:"reason"`
+	// ErrorCode is why a failed consent failed, the code its return_url
+	// was given, e.g. token_exchange_failed.
+	ErrorCode string `json:"error_code,omitempty"`
+}
+
+// Status handles GET /connections/{connectionID}/status: the connection's
+// status and the reason for it. An active connection whose last refresh
+// failed and is due for a retry reports refresh_failing.
+func (h *ConnectionsHandler) Status(w http.ResponseWriter, r *http.Request) {
+	out, err := h.StatusOf(r.Context(), chi.URLParam(r, "connectionID"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	httputil.WriteJSON(w, http.StatusOK, out)
+}
+
+// StatusOf returns the status of the connection connectionID names.
+// Failures are *Error.
+func (h *ConnectionsHandler) StatusOf(ctx context.Context, connectionID string) (*ConnectionStatus, error) {
+	id, err := uuid.Parse(connectionID)
+	if err != nil {
+		return nil, &Error{Status: http.StatusBadRequest, Code: "invalid_connection_id", Message: "Invalid connection ID"}
+	}
+	conn, err := h.store.GetConnection(ctx, id)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, &Error{Status: http.StatusNotFound, Code: "connection_not_found", Message: "Connection not found"}
+	}
+	if err != nil {
+		log.Printf("connections: get %s: %v", id, err)
+		return nil, &Error{Status: http.StatusInternalServerError, Code: "get_failed", Message: "Failed to look up the connection"}
+	}
+	reason := conn.Status.Reason()
+	if conn.Status == connstate.StateActive {
+		rf, err := h.store.GetRefreshFailure(ctx, id)
+		switch {
+		case err == nil && rf.NextRetryAt != nil:
+			reason = connstate.ReasonRefreshFailing
+		case err != nil && !errors.Is(err, store.ErrNotFound):
+			// The status itself is known; only the detail is lost.
+			log.Printf("connections: get refresh failure %s: %v", id, err)
+		}
+	}
+	out := &ConnectionStatus{
+		ConnectionID: id.String(),
+		Status:       string(conn.Status),
+		Reason:       string(reason),
+	}
+	if conn.Status == connstate.StateFailed {
+		if out.ErrorCode, err = h.store.GetFailureCode(ctx, id); err != nil {
+			log.Printf("connections: get failure code %s: %v", id, err)
+		}
+	}
+	return out, nil
+}
+
+// defaultStaleAfter is how long a connection's token must go unretrieved
+// before GET /connections/stale reports it, unless unused_for says
+// otherwise.
+const defaultStaleAfter = 30 * 24 * time.Hour
+
+// Stale handles GET /connections/stale: active and needs_reauth
+// connections whose token has not been retrieved for unused_for (a Go
+// duration, default 720h), least recently used first. A connection never
+// used counts from its creation. workspace_id narrows the report and limit
+// (default 100, at most 1000) caps it.
+func (h *ConnectionsHandler) Stale(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	unusedFor := defaultStaleAfter
+	if v := q.Get("unused_for"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			httputil.WriteError(w, http.StatusBadRequest, "invalid_request", "unused_for must be a positive duration such as 720h")
+			return
+		}
+		unusedFor = d
+	}
+	limit := 100
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > 1000 {
+			httputil.WriteError(w, http.StatusBadRequest, "invalid_request", "limit must be between 1 and 1000")
+			return
+		}
+		limit = n
+	}
+	cutoff := time.Now().Add(-unusedFor)
+	conns, err := h.store.ListStaleConnections(r.Context(), store.StaleFilter{
+		UnusedSince: cutoff,
+		WorkspaceID: strings.TrimSpace(q.Get("workspace_id")),
+		Limit:       limit,
+	})
+	if err != nil {
+		log.Printf("connections: stale report: %v", err)
+		httputil.WriteError(w, http.StatusInternalServerError, "list_failed", "Failed to list stale connections")
+		return
+	}
+	out := make([]ConnectionSummary, 0, len(conns))
+	for _, c := range conns {
+		out = append(out, summarizeConnection(c))
+	}
+	httputil.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"unused_since": cutoff.UTC().Format(time.RFC3339),
+		"connections":  out,
+	})
+}
+
+// ListUserConnections handles GET
+// /workspaces/{workspaceID}/users/{user}/connections. user is matched like
+// Deprovision does: the id_token subject, or the email case-insensitively.
+func (h *ConnectionsHandler) ListUserConnections(w http.ResponseWriter, r *http.Request) {
+	workspaceID := chi.URLParam(r, "workspaceID")
+	user, err := url.PathUnescape(chi.URLParam(r, "user"))
+	user = strings.TrimSpace(user)
+	if err != nil || workspaceID == "" || user == "" {
+		httputil.WriteError(w, http.StatusBadRequest, "invalid_request", "workspace ID and user are required")
+		return
+	}
+	conns, err := h.store.ListUserConnections(r.Context(), workspaceID, user)
+	if err != nil {
+		log.Printf("connections: list for workspace=%s: %v", workspaceID, err)
+		httputil.WriteError(w, http.StatusInternalServerError, "list_failed", "Failed to list connections")
+		return
+	}
+	out := make([]ConnectionSummary, 0, len(conns))
+	for _, c := range conns {
+		out = append(out, summarizeConnection(c))
+	}
+	httputil.WriteJSON(w, http.StatusOK, out)
+}
+
+// Revoke handles POST /connections/{connectionID}/revoke: the connection
+// moves to revoked and its stored token is deleted. Revoking a revoked
+// connection succeeds without changes; a connection that cannot be revoked
+// from its current status (pending, failed, ...) is a 409.
+func (h *ConnectionsHandler) Revoke(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "connectionID"))
+	if err != nil {
+		httputil.WriteError(w, http.StatusBadRequest, "invalid_connection_id", "Invalid connection ID")
+		return
+	}
+	conn, err := h.store.GetConnection(r.Context(), id)
+	if errors.Is(err, store.ErrNotFound) {
+		httputil.WriteError(w, http.StatusNotFound, "connection_not_found", "Connection not found")
+		return
+	}
+	if err != nil {
+		log.Printf("connections: get %s: %v", id, err)
+		httputil.WriteError(w, http.StatusInternalServerError, "revoke_failed", "Failed to look up the connection")
+		return
+	}
+	previous := conn.Status
+	if previous != connstate.StateRevoked {
+		if !previous.CanTransition(connstate.StateRevoked) {
+			httputil.WriteError(w, http.StatusConflict, "invalid_transition", "A "+string(previous)+" connection cannot be revoked")
+			return
+		}
+		if err := revokeConnection(r.Context(), h.store, id); err != nil {
+			log.Printf("connections: revoke %s: %v", id, err)
+			httputil.WriteError(w, http.StatusInternalServerError, "revoke_failed", "Failed to revoke the connection")
+			return
+		}
+		if h.audit != nil {
+			data := map[string]interface{}{"workspace_id": conn.WorkspaceID, "provider_id": conn.ProviderID.String(), "previous_status": string(previous)}
+			if err := h.audit.Log("connection_revoked", &id, data, r); err != nil {
+				log.Printf("audit: failed to log connection_revoked (connection_id=%s): %v", id, err)
+			}
+		}
+	}
+	conn.Status = connstate.StateRevoked
+	httputil.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"connection":      summarizeConnection(*conn),
+		"previous_status": string(previous),
+	})
+}
@@ -0,0 +1,179 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/internal/audit"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/internal/store"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/connstate"
+)
+
+func connectionsRouter(h *ConnectionsHandler) http.Handler {
+	r := chi.NewRouter()
+	r.Get("/workspaces/{workspaceID}/users/{user}/connections", h.ListUserConnections)
+	r.Get("/connections/stale", h.Stale)
+	r.Get("/connections/{connectionID}", h.Get)
+	r.Get("/connections/{connectionID}/status", h.Status)
+	r.Post("/connections/{connectionID}/revoke", h.Revoke)
+	r.Post("/connections/{connectionID}/suspend", h.Suspend)
+	r.Post("/connections/{connectionID}/resume", h.Resume)
+	return r
+}
+
+func TestListUserConnections(t *testing.T) {
+	st := store.NewMemory()
+	h := NewConnectionsHandler(ConnectionsHandlerConfig{Store: st})
+	active := seedUserConnection(t, st, "ws-1", connstate.StateActive, "sub-1", "alice@example.com")
+	seedUserConnection(t, st, "ws-2", connstate.StateActive, "sub-1", "alice@example.com")
+	seedUserConnection(t, st, "ws-1", connstate.StateActive, "sub-2", "bob@example.com")
+
+	rr := httptest.NewRecorder()
+	connectionsRouter(h).ServeHTTP(rr, httptest.NewRequest("GET", "/workspaces/ws-1/users/Alice%40Example.com/connections", nil))
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	var out []ConnectionSummary
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &out))
+	require.Len(t, out, 1)
+	assert.Equal(t, active.String(), out[0].ConnectionID)
+	assert.Equal(t, "active", out[0].Status)
+	assert.NotContains(t, rr.Body.String(), "ciphertext")
+}
+
+func TestRevokeConnection(t *testing.T) {
+	st := store.NewMemory()
+	h := NewConnectionsHandler(ConnectionsHandlerConfig{Store: st, Audit: audit.NewServiceWithStore(st)})
+	id := seedUserConnection(t, st, "ws-1", connstate.StateActive, "sub-1", "")
+
+	revoke := func(id string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		connectionsRouter(h).ServeHTTP(rr, httptest.NewRequest("POST", "/connections/"+id+"/revoke", nil))
+		return rr
+	}
+
+	rr := revoke(id.String())
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	assert.Contains(t, rr.Body.String(), `"previous_status":"active"`)
+	conn, err := st.GetConnection(context.Background(), id)
+	require.NoError(t, err)
+	assert.Equal(t, connstate.StateRevoked, conn.Status)
+	_, err = st.GetTokens(context.Background(), id)
+	assert.ErrorIs(t, err, store.ErrNotFound)
+
+	events, err := st.ListAuditEvents(context.Background(), store.AuditFilter{EventType: "connection_revoked"})
+	require.NoError(t, err)
+	assert.Len(t, events, 1)
+
+	// Idempotent.
+	rr = revoke(id.String())
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"previous_status":"revoked"`)
+
+	pending := seedUserConnection(t, st, "ws-1", connstate.StatePending, "", "")
+	assert.Equal(t, http.StatusConflict, revoke(pending.String()).Code)
+	assert.Equal(t, http.StatusNotFound, revoke(uuid.NewString()).Code)
+	assert.Equal(t, http.StatusBadRequest, revoke("nope").Code)
+}
+
+func TestGetConnectionIncludesUsage(t *testing.T) {
+	st := store.NewMemory()
+	h := NewConnectionsHandler(ConnectionsHandlerConfig{Store: st})
+	id := seedUserConnection(t, st, "ws-1", connstate.StateActive, "sub-1", "")
+	at := time.Now().Add(-time.Hour).UTC().Truncate(time.Second)
+	require.NoError(t, st.RecordTokenUsage(context.Background(), []store.TokenUsage{{ConnectionID: id, Retrievals: 4, LastAccessedAt: at}}))
+
+	rr := httptest.NewRecorder()
+	connectionsRouter(h).ServeHTTP(rr, httptest.NewRequest("GET", "/connections/"+id.String(), nil))
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	var out ConnectionSummary
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &out))
+	assert.EqualValues(t, 4, out.TokenRetrievals)
+	require.NotNil(t, out.LastAccessedAt)
+	assert.True(t, out.LastAccessedAt.Equal(at))
+
+	rr = httptest.NewRecorder()
+	connectionsRouter(h).ServeHTTP(rr, httptest.NewRequest("GET", "/connections/"+uuid.NewString(), nil))
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+func TestConnectionStatus(t *testing.T) {
+	st := store.NewMemory()
+	h := NewConnectionsHandler(ConnectionsHandlerConfig{Store: st})
+	status := func(id string) (int, ConnectionStatus) {
+		rr := httptest.NewRecorder()
+		connectionsRouter(h).ServeHTTP(rr, httptest.NewRequest("GET", "/connections/"+id+"/status", nil))
+		var out ConnectionStatus
+		json.Unmarshal(rr.Body.Bytes(), &out)
+		return rr.Code, out
+	}
+
+	active := seedUserConnection(t, st, "ws-1", connstate.StateActive, "", "")
+	code, out := status(active.String())
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, ConnectionStatus{ConnectionID: active.String(), Status: "active", Reason: "ok"}, out)
+
+	next := time.Now().Add(time.Minute)
+	require.NoError(t, st.SaveRefreshFailure(context.Background(), &store.RefreshFailure{
+		ConnectionID: active, ErrorClass: "network", Attempts: 1, NextRetryAt: &next,
+	}))
+	_, out = status(active.String())
+	assert.Equal(t, "refresh_failing", out.Reason)
+
+	reauth := seedUserConnection(t, st, "ws-1", connstate.StateNeedsReauth, "", "")
+	_, out = status(reauth.String())
+	assert.Equal(t, "needs_reauth", out.Status)
+	assert.Equal(t, "reauth_required", out.Reason)
+
+	failed := seedUserConnection(t, st, "ws-1", connstate.StatePending, "", "")
+	require.NoError(t, st.FailConnection(context.Background(), failed, "token_exchange_failed"))
+	_, out = status(failed.String())
+	assert.Equal(t, ConnectionStatus{ConnectionID: failed.String(), Status: "failed", Reason: "consent_failed", ErrorCode: "token_exchange_failed"}, out)
+
+	code, _ = status(uuid.NewString())
+	assert.Equal(t, http.StatusNotFound, code)
+	code, _ = status("nope")
+	assert.Equal(t, http.StatusBadRequest, code)
+}
+
+func TestStaleConnections(t *testing.T) {
+	st := store.NewMemory()
+	h := NewConnectionsHandler(ConnectionsHandlerConfig{Store: st})
+	ctx := context.Background()
+	recent := seedUserConnection(t, st, "ws-1", connstate.StateActive, "sub-1", "")
+	old := seedUserConnection(t, st, "ws-1", connstate.StateActive, "sub-2", "")
+	never := seedUserConnection(t, st, "ws-1", connstate.StateNeedsReauth, "sub-3", "")
+	seedUserConnection(t, st, "ws-1", connstate.StateRevoked, "sub-4", "")
+	seedUserConnection(t, st, "ws-2", connstate.StateActive, "sub-5", "")
+	require.NoError(t, st.RecordTokenUsage(ctx, []store.TokenUsage{
+		{ConnectionID: recent, Retrievals: 1, LastAccessedAt: time.Now().Add(-time.Hour)},
+		{ConnectionID: old, Retrievals: 9, LastAccessedAt: time.Now().Add(-48 * time.Hour)},
+	}))
+
+	get := func(query string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		connectionsRouter(h).ServeHTTP(rr, httptest.NewRequest("GET", "/connections/stale"+query, nil))
+		return rr
+	}
+
+	rr := get("?unused_for=24h&workspace_id=ws-1")
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	var out struct {
+		Connections []ConnectionSummary `json:"connections"`
+	}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &out))
+	require.Len(t, out.Connections, 2)
+	assert.Equal(t, never.String(), out.Connections[0].ConnectionID, "never used sorts first")
+	assert.Equal(t, old.String(), out.Connections[1].ConnectionID)
+
+	assert.Equal(t, http.StatusBadRequest, get("?unused_for=soon").Code)
+	assert.Equal(t, http.StatusBadRequest, get("?limit=0").Code)
+}
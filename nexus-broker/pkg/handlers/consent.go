@@ -1,24 +1,32 @@
 package handlers
 
 import (
-	"database/sql"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"net/mail"
 	"net/url"
 	"strings"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
-	"github.com/lib/pq"
 	"github.com/prometheus/client_golang/prometheus"
 
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/internal/quota"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/internal/store"
 	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/auth"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/authrequest"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/azure"
 	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/discovery"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/httpclient"
 	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/httputil"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/scopepolicy"
 	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/server"
+	"github.com/Prescott-Data/nexus-framework/nexus-common/state"
 )
 
 // ConsentSpec represents the response for consent specification
@@ -31,27 +39,39 @@ type ConsentSpec struct {
 
 // ConsentHandler handles OAuth consent flow
 type ConsentHandler struct {
-	db                   *sqlx.DB
+	store                store.Store
 	baseURL              string
 	redirectPath         string
-	stateKey             []byte
-	httpClient           *http.Client
+	states               *state.Keyring
+	clients              providerClients
 	enforceReturnURL     bool
 	allowedReturnDomains []string
 	consentsMetric       prometheus.Counter
 	consentsOpenID       prometheus.Counter
+	quota                *quota.Enforcer
 }
 
 // ConsentHandlerConfig holds the dependencies for ConsentHandler
 type ConsentHandlerConfig struct {
+	// Store persists connections and provider lookups. Defaults to Postgres
+	// through DB.
+	Store        store.Store
 	DB           *sqlx.DB
 	BaseURL      string
 	RedirectPath string
-	StateKey     []byte
-	HTTPClient   *http.Client
+	// States signs and verifies the OAuth state parameter.
+	States *state.Keyring
+	// HTTPClient is used for OIDC discovery and may cache responses.
+	HTTPClient *http.Client
+	// Transports serves discovery for providers with a CA bundle.
+	Transports *httpclient.Pool
 
 	EnforceReturnURL     bool
 	AllowedReturnDomains []string
+
+	// Quota refuses consents beyond a workspace's connection limits. Nil
+	// disables the limits.
+	Quota *quota.Enforcer
 }
 
 // NewConsentHandler creates a new consent handler
@@ -74,157 +94,195 @@ func NewConsentHandler(cfg ConsentHandlerConfig) *ConsentHandler {
 		}
 	}
 
+	if cfg.Store == nil {
+		cfg.Store = store.NewPostgres(cfg.DB)
+	}
+
 	return &ConsentHandler{
-		db:                   cfg.DB,
+		store:                cfg.Store,
 		baseURL:              cfg.BaseURL,
 		redirectPath:         cfg.RedirectPath,
-		stateKey:             cfg.StateKey,
-		httpClient:           cfg.HTTPClient,
+		states:               cfg.States,
+		clients:              newProviderClients(cfg.Transports, cfg.HTTPClient),
 		enforceReturnURL:     cfg.EnforceReturnURL,
 		allowedReturnDomains: cfg.AllowedReturnDomains,
 		consentsMetric:       metric,
 		consentsOpenID:       metricOpenID,
+		quota:                cfg.Quota,
 	}
 }
 
+// ConsentSpecRequest is the body of POST /auth/consent-spec.
+type ConsentSpecRequest struct {
+	WorkspaceID string   `json:"workspace_id"`
+	ProviderID  string   `json:"provider_id"`
+	Scopes      []string `json:"scopes"`
+	ReturnURL   string   `json:"return_url"`
+	// Tenant is the Azure AD tenant to authorize against, in place of
+	// the one the provider's endpoints name.
+	Tenant string `json:"tenant"`
+	// Subject is the email of the user a service account connection
+	// acts as through domain-wide delegation.
+	Subject string `json:"subject"`
+	// SessionHash binds the flow to the browser session it hashes;
+	// the callback then completes only for that session.
+	SessionHash string `json:"session_hash"`
+}
+
 // GetSpec handles POST /auth/consent-spec
 func (h *ConsentHandler) GetSpec(w http.ResponseWriter, r *http.Request) {
-	var request struct {
-		WorkspaceID string   `json:"workspace_id"`
-		ProviderID  string   `json:"provider_id"`
-		Scopes      []string `json:"scopes"`
-		ReturnURL   string   `json:"return_url"`
-	}
-
+	var request ConsentSpecRequest
 	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
 		httputil.WriteError(w, http.StatusBadRequest, "invalid_json", "Invalid JSON")
 		return
 	}
+	spec, err := h.Spec(r.Context(), request)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	httputil.WriteJSON(w, http.StatusOK, spec)
+}
 
+// Spec starts a consent: it creates the pending connection and returns the
+// URL the user is sent to. Failures are *Error.
+func (h *ConsentHandler) Spec(ctx context.Context, request ConsentSpecRequest) (*ConsentSpec, error) {
 	// Validate required fields
 	if request.WorkspaceID == "" || request.ProviderID == "" || request.ReturnURL == "" {
-		httputil.WriteError(w, http.StatusBadRequest, "missing_fields", "Missing required fields")
-		return
+		return nil, &Error{Status: http.StatusBadRequest, Code: "missing_fields", Message: "Missing required fields"}
 	}
 	// Validate return URL domain if enforced
 	if !server.IsReturnURLAllowed(request.ReturnURL, h.enforceReturnURL, h.allowedReturnDomains) {
-		httputil.WriteError(w, http.StatusBadRequest, "return_url_not_allowed", "return_url not allowed")
-		return
+		return nil, &Error{Status: http.StatusBadRequest, Code: "return_url_not_allowed", Message: "return_url not allowed"}
 	}
 
 	// Get provider profile
-	var provider struct {
-		ID       uuid.UUID        `db:"id"`
-		Name     string           `db:"name"`
-		AuthType string           `db:"auth_type"`
-		AuthURL  sql.NullString   `db:"auth_url"`
-		ClientID sql.NullString   `db:"client_id"`
-		Scopes   []string         `db:"scopes"`
-		Params   *json.RawMessage `db:"params"`
-	}
-
-	err := h.db.QueryRow(
-		"SELECT id, name, auth_type, auth_url, client_id, scopes, params FROM provider_profiles WHERE id = $1",
-		request.ProviderID,
-	).Scan(&provider.ID, &provider.Name, &provider.AuthType, &provider.AuthURL, &provider.ClientID, pq.Array(&provider.Scopes), &provider.Params)
+	providerID, err := uuid.Parse(request.ProviderID)
+	var provider *store.Provider
+	if err == nil {
+		provider, err = h.store.GetProviderForWorkspace(ctx, providerID, request.WorkspaceID)
+	}
 	if err != nil {
 		log.Printf("/auth/consent-spec provider lookup error: %v", err)
-		httputil.WriteError(w, http.StatusNotFound, "provider_not_found", "Provider not found")
-		return
+		return nil, &Error{Status: http.StatusNotFound, Code: "provider_not_found", Message: "Provider not found"}
+	}
+	tenant := strings.ToLower(strings.TrimSpace(request.Tenant))
+	if tenant != "" {
+		if !azureAD(provider) {
+			return nil, &Error{Status: http.StatusBadRequest, Code: "tenant_not_supported", Message: "tenant is only supported for Azure AD providers"}
+		}
+		if !azure.ValidTenant(tenant) {
+			return nil, &Error{Status: http.StatusBadRequest, Code: "invalid_tenant", Message: "tenant must be common, organizations, consumers, a tenant ID or a domain"}
+		}
+		provider = withTenant(provider, tenant)
+	}
+	subject := strings.TrimSpace(request.Subject)
+	if subject != "" {
+		if provider.AuthType != authTypeServiceAccount {
+			return nil, &Error{Status: http.StatusBadRequest, Code: "subject_not_supported", Message: "subject is only supported for service account providers"}
+		}
+		if addr, err := mail.ParseAddress(subject); err != nil || addr.Address != subject {
+			return nil, &Error{Status: http.StatusBadRequest, Code: "invalid_subject", Message: "subject must be an email address"}
+		}
+	}
+	sessionHash := strings.TrimSpace(request.SessionHash)
+	if sessionHash != "" {
+		// A service account flow completes without a browser, so there is
+		// no session to check.
+		if provider.AuthType == authTypeServiceAccount {
+			return nil, &Error{Status: http.StatusBadRequest, Code: "session_binding_unsupported", Message: "session binding is not supported for service account providers"}
+		}
+		if !state.ValidSessionHash(sessionHash) {
+			return nil, &Error{Status: http.StatusBadRequest, Code: "invalid_session_hash", Message: "session_hash must be the base64url SHA-256 of the session"}
+		}
+	}
+	if request.Scopes, err = h.applyScopePolicy(ctx, provider, request.WorkspaceID, request.Scopes); err != nil {
+		return nil, scopePolicyError(err)
+	}
+	if h.quota != nil {
+		if err := h.quota.CheckNewConnection(ctx, request.WorkspaceID); err != nil {
+			return nil, quotaError(request.WorkspaceID, err)
+		}
 	}
 
+	var spec *ConsentSpec
 	switch provider.AuthType {
 	case "oauth2", "":
 		// Generate PKCE
 		codeVerifier, codeChallenge, err := auth.GeneratePKCE()
 		if err != nil {
-			httputil.WriteError(w, http.StatusInternalServerError, "pkce_failed", "Failed to generate PKCE")
-			return
+			return nil, &Error{Status: http.StatusInternalServerError, Code: "pkce_failed", Message: "Failed to generate PKCE"}
 		}
 
 		// Create connection record
 		connectionID := uuid.New()
-		expiresAt := time.Now().Add(10 * time.Minute)
-
-		_, err = h.db.Exec(`
-			INSERT INTO connections (id, workspace_id, provider_id, code_verifier, scopes, return_url, expires_at)
-			VALUES ($1, $2, $3, $4, $5, $6, $7)`,
-			connectionID, request.WorkspaceID, request.ProviderID, codeVerifier, pq.Array(request.Scopes), request.ReturnURL, expiresAt)
+		err = h.store.CreateConnection(ctx, &store.Connection{
+			ID:           connectionID,
+			WorkspaceID:  request.WorkspaceID,
+			ProviderID:   provider.ID,
+			CodeVerifier: codeVerifier,
+			Scopes:       request.Scopes,
+			ReturnURL:    request.ReturnURL,
+			ExpiresAt:    time.Now().Add(h.states.TTL()),
+			Tenant:       tenant,
+		})
 		if err != nil {
-			httputil.WriteError(w, http.StatusInternalServerError, "connection_create_failed", "Failed to create connection")
-			return
+			return nil, &Error{Status: http.StatusInternalServerError, Code: "connection_create_failed", Message: "Failed to create connection"}
 		}
 
 		// Generate signed state
-		stateData := auth.StateData{
+		stateData := state.Data{
 			WorkspaceID: request.WorkspaceID,
 			ProviderID:  request.ProviderID,
 			Nonce:       connectionID.String(),
 			IAT:         time.Now(),
+			SessionHash: sessionHash,
 		}
 
-		signedState, err := auth.SignState(h.stateKey, stateData)
+		signedState, err := h.states.Sign(stateData)
 		if err != nil {
-			httputil.WriteError(w, http.StatusInternalServerError, "state_sign_failed", "Failed to sign state")
-			return
-		}
-
-		// Attempt OIDC discovery to use the provider's authorization_endpoint
-		// Only if 'openid' scope is requested to avoid overwriting standard OAuth2 endpoints (e.g. Slack)
-		useAuthURL := provider.AuthURL.String
-		hasOpenID := false
-		for _, s := range request.Scopes {
-			if strings.EqualFold(s, "openid") {
-				hasOpenID = true
-				break
-			}
-		}
-
-		if hasOpenID && useAuthURL != "" {
-			if md, errD := discovery.Discover(r.Context(), h.httpClient, discovery.Hint{AuthURL: useAuthURL}); errD == nil && strings.TrimSpace(md.AuthorizationEndpoint) != "" {
-				useAuthURL = md.AuthorizationEndpoint
-			}
+			return nil, &Error{Status: http.StatusInternalServerError, Code: "state_sign_failed", Message: "Failed to sign state"}
 		}
 
 		// Build auth URL
-		authURL, err := h.buildAuthURL(useAuthURL, provider.ClientID.String, signedState, codeChallenge, request.Scopes, provider.Params)
+		authURL, err := h.authURL(ctx, provider, signedState, codeChallenge, request.Scopes, nil)
 		if err != nil {
-			httputil.WriteError(w, http.StatusInternalServerError, "auth_url_failed", "Failed to build auth URL")
-			return
+			log.Printf("/auth/consent-spec auth URL for provider %s: %v", provider.ID, err)
+			return nil, authURLError(err)
 		}
 
-		response := ConsentSpec{
+		spec = &ConsentSpec{
 			AuthURL:    authURL,
 			State:      signedState,
 			Scopes:     request.Scopes,
 			ProviderID: request.ProviderID,
 		}
-
-		httputil.WriteJSON(w, http.StatusOK, response)
 	case "api_key", "basic_auth":
 		// Create Connection
 		connectionID := uuid.New()
-		expiresAt := time.Now().Add(10 * time.Minute)
-		_, err = h.db.Exec(`
-			INSERT INTO connections (id, workspace_id, provider_id, scopes, return_url, expires_at)
-			VALUES ($1, $2, $3, $4, $5, $6)`,
-			connectionID, request.WorkspaceID, request.ProviderID, pq.Array(request.Scopes), request.ReturnURL, expiresAt)
+		err = h.store.CreateConnection(ctx, &store.Connection{
+			ID:          connectionID,
+			WorkspaceID: request.WorkspaceID,
+			ProviderID:  provider.ID,
+			Scopes:      request.Scopes,
+			ReturnURL:   request.ReturnURL,
+			ExpiresAt:   time.Now().Add(h.states.TTL()),
+		})
 		if err != nil {
-			httputil.WriteError(w, http.StatusInternalServerError, "connection_create_failed", "Failed to create connection")
-			return
+			return nil, &Error{Status: http.StatusInternalServerError, Code: "connection_create_failed", Message: "Failed to create connection"}
 		}
 
 		// Generate State
-		stateData := auth.StateData{
+		stateData := state.Data{
 			WorkspaceID: request.WorkspaceID,
 			ProviderID:  request.ProviderID,
 			Nonce:       connectionID.String(),
 			IAT:         time.Now(),
+			SessionHash: sessionHash,
 		}
-		signedState, err := auth.SignState(h.stateKey, stateData)
+		signedState, err := h.states.Sign(stateData)
 		if err != nil {
-			httputil.WriteError(w, http.StatusInternalServerError, "state_sign_failed", "Failed to sign state")
-			return
+			return nil, &Error{Status: http.StatusInternalServerError, Code: "state_sign_failed", Message: "Failed to sign state"}
 		}
 
 		// Build Internal URL to the schema endpoint
@@ -236,17 +294,57 @@ func (h *ConsentHandler) GetSpec(w http.ResponseWriter, r *http.Request) {
 		q.Set("state", signedState)
 		u.RawQuery = q.Encode()
 
-		response := ConsentSpec{
+		spec = &ConsentSpec{
 			AuthURL:    u.String(),
 			State:      signedState,
 			Scopes:     request.Scopes,
 			ProviderID: request.ProviderID,
 		}
+	case authTypeServiceAccount:
+		// The delegated user is the connection's user, so deprovisioning
+		// them revokes it.
+		connectionID := uuid.New()
+		err = h.store.CreateConnection(ctx, &store.Connection{
+			ID:          connectionID,
+			WorkspaceID: request.WorkspaceID,
+			ProviderID:  provider.ID,
+			Scopes:      request.Scopes,
+			ReturnURL:   request.ReturnURL,
+			ExpiresAt:   time.Now().Add(h.states.TTL()),
+		})
+		if err == nil && subject != "" {
+			err = h.store.SetIdentity(ctx, connectionID, "", subject)
+		}
+		if err != nil {
+			return nil, &Error{Status: http.StatusInternalServerError, Code: "connection_create_failed", Message: "Failed to create connection"}
+		}
+
+		stateData := state.Data{
+			WorkspaceID: request.WorkspaceID,
+			ProviderID:  request.ProviderID,
+			Nonce:       connectionID.String(),
+			IAT:         time.Now(),
+		}
+		signedState, err := h.states.Sign(stateData)
+		if err != nil {
+			return nil, &Error{Status: http.StatusInternalServerError, Code: "state_sign_failed", Message: "Failed to sign state"}
+		}
+
+		// Nobody consents: the URL mints the first token (see
+		// CallbackHandler.Delegate).
+		u, _ := url.Parse(strings.TrimSuffix(h.baseURL, "/") + delegatePath)
+		q := u.Query()
+		q.Set("state", signedState)
+		u.RawQuery = q.Encode()
 
-		httputil.WriteJSON(w, http.StatusOK, response)
+		spec = &ConsentSpec{
+			AuthURL:    u.String(),
+			State:      signedState,
+			Scopes:     request.Scopes,
+			ProviderID: request.ProviderID,
+		}
 	default:
-		httputil.WriteError(w, http.StatusBadRequest, "unsupported_auth_type", "Unsupported provider auth_type")
-		return
+		return nil, &Error{Status: http.StatusBadRequest, Code: "unsupported_auth_type", Message: "Unsupported provider auth_type"}
 	}
 
 	// increment metric after successful response
@@ -258,22 +356,140 @@ func (h *ConsentHandler) GetSpec(w http.ResponseWriter, r *http.Request) {
 			break
 		}
 	}
+	return spec, nil
 }
 
-// buildAuthURL constructs the OAuth authorization URL
-func (h *ConsentHandler) buildAuthURL(providerAuthURL, clientID, state, codeChallenge string, scopes []string, providerParams *json.RawMessage) (string, error) {
-	baseURL := strings.TrimSuffix(h.baseURL, "/")
-	redirectPath := h.redirectPath
+// redirectURI is the OAuth callback URL registered with providers.
+func (h *ConsentHandler) redirectURI() string {
+	return strings.TrimSuffix(h.baseURL, "/") + h.redirectPath
+}
 
-	if providerAuthURL == "" {
-		return "", fmt.Errorf("provider auth_url is required for OAuth2")
+// parTimeout bounds a pushed authorization request.
+const parTimeout = 10 * time.Second
+
+// authURL builds the provider authorization URL for a consent. granted are
+// scopes the connection already holds (see buildAuthURL). When openid is
+// requested, OIDC discovery may replace the configured auth_url with the
+// provider's authorization_endpoint; it is not attempted otherwise, to
+// avoid overwriting standard OAuth2 endpoints (e.g. Slack).
+//
+// When the provider accepts pushed authorization requests the parameters
+// are pushed to it and the URL only carries client_id and request_uri. With
+// a request_object_signing_key param they are sent as a signed request
+// object. See authrequest.Options for the params controlling both.
+func (h *ConsentHandler) authURL(ctx context.Context, provider *store.Provider, signedState, codeChallenge string, scopes, granted []string) (string, error) {
+	opts := authrequest.ParseOptions(provider.Params)
+	signer, err := opts.Signer()
+	if err != nil {
+		return "", fmt.Errorf("request object signing key: %w", err)
+	}
+	requirePAR := opts.PAR != nil && *opts.PAR
+	parEndpoint := ""
+	if opts.PAR == nil || requirePAR {
+		parEndpoint = opts.PAREndpoint
+	}
+
+	// Without openid, discovery only runs when PAR is required or a
+	// request object needs the issuer as its audience.
+	openid := containsScope(scopes, "openid")
+	discoverPAR := parEndpoint == "" && (opts.PAR == nil && openid || requirePAR)
+	useAuthURL := provider.AuthURL
+	audience := ""
+	if useAuthURL != "" && (openid || discoverPAR || signer != nil) {
+		if client, errC := h.clients.discoveryClient(provider.CABundle); errC == nil {
+			if md, errD := discovery.Discover(ctx, client, discovery.Hint{AuthURL: useAuthURL}); errD == nil {
+				if openid && strings.TrimSpace(md.AuthorizationEndpoint) != "" {
+					useAuthURL = md.AuthorizationEndpoint
+				}
+				if discoverPAR {
+					parEndpoint = strings.TrimSpace(md.PushedAuthorizationRequestEndpoint)
+				}
+				audience = md.Issuer
+			}
+		}
+	}
+	if parEndpoint == "" && requirePAR {
+		return "", fmt.Errorf("pushed authorization requests are required but no pushed_authorization_request_endpoint is known")
+	}
+	if parEndpoint == "" && signer == nil {
+		return buildAuthURL(h.redirectURI(), useAuthURL, provider.ClientID, signedState, codeChallenge, scopes, granted, provider.Params)
+	}
+
+	u, err := parseAuthURL(useAuthURL)
+	if err != nil {
+		return "", err
+	}
+	params := authRequestParams(h.redirectURI(), provider.ClientID, signedState, codeChallenge, scopes, granted, provider.Params)
+	if signer != nil {
+		if audience == "" {
+			audience = u.Scheme + "://" + u.Host
+		}
+		obj, err := signer.Sign(params, provider.ClientID, audience, time.Now())
+		if err != nil {
+			return "", err
+		}
+		params = url.Values{"client_id": {provider.ClientID}, "request": {obj}}
+	}
+	if parEndpoint != "" {
+		client, err := h.clients.client(provider.CABundle, parTimeout)
+		if err != nil {
+			return "", err
+		}
+		pushed, err := authrequest.Push(ctx, client, parEndpoint, params, authrequest.ClientAuth{
+			ClientID:     provider.ClientID,
+			ClientSecret: provider.ClientSecret,
+			Basic:        strings.EqualFold(provider.AuthHeader, "client_secret_basic") || strings.EqualFold(provider.AuthHeader, "Basic"),
+		})
+		if err != nil {
+			return "", err
+		}
+		params = url.Values{"client_id": {provider.ClientID}, "request_uri": {pushed.RequestURI}}
+	}
+	q := u.Query()
+	for k, v := range params {
+		q[k] = v
+	}
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// authURLError is the answer to a failure of authURL: 502 when the
+// provider refused the pushed authorization request, 500 otherwise.
+func authURLError(err error) *Error {
+	var parErr *authrequest.Error
+	if errors.As(err, &parErr) {
+		return &Error{Status: http.StatusBadGateway, Code: "pushed_authorization_failed", Message: parErr.Error()}
 	}
+	return &Error{Status: http.StatusInternalServerError, Code: "auth_url_failed", Message: "Failed to build auth URL"}
+}
 
-	u, err := url.Parse(providerAuthURL)
+// buildAuthURL constructs the OAuth authorization URL. granted are scopes
+// already granted to the connection being reauthorized: for providers with
+// the incremental_auth param they are left out of the scope parameter and
+// include_granted_scopes=true asks the provider to keep them.
+func buildAuthURL(redirectURI, providerAuthURL, clientID, state, codeChallenge string, scopes, granted []string, providerParams *json.RawMessage) (string, error) {
+	u, err := parseAuthURL(providerAuthURL)
 	if err != nil {
 		return "", err
 	}
+	q := u.Query()
+	for k, v := range authRequestParams(redirectURI, clientID, state, codeChallenge, scopes, granted, providerParams) {
+		q[k] = v
+	}
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+func parseAuthURL(providerAuthURL string) (*url.URL, error) {
+	if providerAuthURL == "" {
+		return nil, fmt.Errorf("provider auth_url is required for OAuth2")
+	}
+	return url.Parse(providerAuthURL)
+}
 
+// authRequestParams returns the parameters of an authorization request (see
+// buildAuthURL).
+func authRequestParams(redirectURI, clientID, state, codeChallenge string, scopes, granted []string, providerParams *json.RawMessage) url.Values {
 	skipScopeOnAuth := false
 	if providerParams != nil {
 		var paramsMap map[string]interface{}
@@ -284,14 +500,24 @@ func (h *ConsentHandler) buildAuthURL(providerAuthURL, clientID, state, codeChal
 		}
 	}
 
-	q := u.Query()
+	q := url.Values{}
 	q.Set("client_id", clientID)
-	q.Set("redirect_uri", baseURL+redirectPath)
+	q.Set("redirect_uri", redirectURI)
 	q.Set("response_type", "code")
 
+	requested := scopes
+	if len(granted) > 0 && incrementalAuth(providerParams) {
+		// Only the delta is consented to; with nothing new (a plain
+		// re-consent) every scope is requested again.
+		if delta := scopeDelta(scopes, granted); len(delta) > 0 {
+			requested = delta
+		}
+		q.Set("include_granted_scopes", "true")
+	}
+
 	if !skipScopeOnAuth {
-		if len(scopes) > 0 {
-			q.Set("scope", strings.Join(scopes, " "))
+		if len(requested) > 0 {
+			q.Set("scope", strings.Join(requested, " "))
 		} else {
 			// Backwards compatibility or provider defaults might expect an empty scope parameter,
 			// but we only set it if not explicitly skipping.
@@ -316,14 +542,68 @@ func (h *ConsentHandler) buildAuthURL(providerAuthURL, clientID, state, codeChal
 	}
 
 	if providerParams != nil && len(*providerParams) > 0 {
-		var params map[string]string
+		// Only string params are sent; flags such as skip_scope_on_auth and
+		// incremental_auth must not drop the others.
+		var params map[string]interface{}
 		if err := json.Unmarshal(*providerParams, &params); err == nil {
 			for key, value := range params {
-				q.Set(key, value)
+				str, ok := value.(string)
+				if !ok || brokerParams[key] {
+					continue
+				}
+				q.Set(key, str)
 			}
 		}
 	}
+	return q
+}
 
-	u.RawQuery = q.Encode()
-	return u.String(), nil
+// brokerParams are provider params read by the broker itself, never sent
+// to the provider's authorization endpoint.
+var brokerParams = map[string]bool{
+	"token_timeout":       true,
+	"token_max_retries":   true,
+	"token_rate_limit":    true,
+	"token_rate_burst":    true,
+	"token_rate_max_wait": true,
+	"incremental_auth":    true,
+	"token_exchange":      true,
+	"token_exchange_url":  true,
+	"dpop":                true,
+	"azure_ad":            true,
+	scopepolicy.Param:     true,
+	approvalParam:         true,
+	// authrequest.Options
+	"par":                                   true,
+	"pushed_authorization_request_endpoint": true,
+	authrequest.SigningKeyParam:             true,
+	"request_object_signing_alg":            true,
+	"request_object_signing_kid":            true,
+}
+
+// incrementalAuth reports whether the provider's incremental_auth param is
+// set: it supports Google-style include_granted_scopes, so a re-consent
+// only needs to request the scopes the connection does not hold yet.
+func incrementalAuth(providerParams *json.RawMessage) bool {
+	if providerParams == nil {
+		return false
+	}
+	var params map[string]interface{}
+	if err := json.Unmarshal(*providerParams, &params); err != nil {
+		return false
+	}
+	on, _ := params["incremental_auth"].(bool)
+	return on
+}
+
+// scopeDelta returns the scopes not in granted, compared
+// case-insensitively.
+func scopeDelta(scopes, granted []string) []string {
+	var delta []string
+	for _, s := range scopes {
+		if !containsScope(granted, s) {
+			delta = append(delta, s)
+		}
+	}
+	return delta
 }
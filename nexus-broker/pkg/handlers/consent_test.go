@@ -2,7 +2,13 @@ package handlers
 
 import (
 	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
 	"encoding/json"
+	"encoding/pem"
 	"fmt"
 	"io"
 	"net/http"
@@ -11,17 +17,16 @@ import (
 	"strings"
 	"testing"
 
-	"github.com/jmoiron/sqlx"
+	"github.com/go-jose/go-jose/v4"
+	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
-	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/internal/store"
 )
 
 func TestGetSpec_OAuth2(t *testing.T) {
-	db, mock, err := sqlmock.New()
-	assert.NoError(t, err)
-	defer db.Close()
-
-	sqlxDB := sqlx.NewDb(db, "sqlmock")
+	st := store.NewMemory()
 
 	// Use httptest.NewServer to create a real mock server for provider discovery
 	mockProviderServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -33,23 +38,23 @@ func TestGetSpec_OAuth2(t *testing.T) {
 
 	// Pass the test server's client to the handler
 	handler := NewConsentHandler(ConsentHandlerConfig{
-		DB:           sqlxDB,
+		Store:        st,
 		BaseURL:      "http://localhost:8080",
 		RedirectPath: "/auth/callback",
-		StateKey:     []byte("test-key"),
+		States:       testStates(t, []byte("test-key")),
 		HTTPClient:   mockProviderServer.Client(),
 	})
 
-	paramsJSON := []byte(`{"access_type": "offline", "prompt": "consent"}`)
-
-	rows := sqlmock.NewRows([]string{"id", "name", "auth_type", "auth_url", "client_id", "scopes", "params"}).
-		AddRow("a0a0a0a0-a0a0-a0a0-a0a0-a0a0a0a0a0a0", "Test OAuth2 Provider", "oauth2", "http://provider.com/auth", "test-client-id", "{openid}", paramsJSON)
-	mock.ExpectQuery("SELECT id, name, auth_type, auth_url, client_id, scopes, params FROM provider_profiles WHERE id = \\$1").
-		WithArgs("a0a0a0a0-a0a0-a0a0-a0a0-a0a0a0a0a0a0").
-		WillReturnRows(rows)
-
-	mock.ExpectExec("INSERT INTO connections").
-		WillReturnResult(sqlmock.NewResult(1, 1))
+	params := json.RawMessage(`{"access_type": "offline", "prompt": "consent"}`)
+	st.PutProvider(store.Provider{
+		ID:       uuid.MustParse("a0a0a0a0-a0a0-a0a0-a0a0-a0a0a0a0a0a0"),
+		Name:     "Test OAuth2 Provider",
+		AuthType: "oauth2",
+		AuthURL:  "http://provider.com/auth",
+		ClientID: "test-client-id",
+		Scopes:   []string{"openid"},
+		Params:   &params,
+	})
 
 	body := map[string]interface{}{
 		"workspace_id": "ws-123",
@@ -78,31 +83,35 @@ func TestGetSpec_OAuth2(t *testing.T) {
 	assert.NotEmpty(t, q.Get("code_challenge"), "authUrl should contain a code_challenge")
 	assert.Equal(t, "offline", q.Get("access_type"))
 	assert.Equal(t, "consent", q.Get("prompt"))
+
+	// The state nonce is the new pending connection, which keeps the PKCE
+	// verifier for the callback.
+	stateData, err := testStates(t, []byte("test-key")).Verify(response.State)
+	require.NoError(t, err)
+	conn, err := st.GetPendingConnection(context.Background(), uuid.MustParse(stateData.Nonce))
+	require.NoError(t, err)
+	assert.Equal(t, "ws-123", conn.WorkspaceID)
+	assert.NotEmpty(t, conn.CodeVerifier)
 }
 
 func TestGetSpec_StaticKey(t *testing.T) {
-	db, mock, err := sqlmock.New()
-	assert.NoError(t, err)
-	defer db.Close()
-
-	sqlxDB := sqlx.NewDb(db, "sqlmock")
+	st := store.NewMemory()
 	// For static key tests, we can pass a default client as no external calls are made.
 	handler := NewConsentHandler(ConsentHandlerConfig{
-		DB:           sqlxDB,
+		Store:        st,
 		BaseURL:      "http://localhost:8080",
 		RedirectPath: "/auth/callback",
-		StateKey:     []byte("test-key"),
+		States:       testStates(t, []byte("test-key")),
 		HTTPClient:   http.DefaultClient,
 	})
 
-	rows := sqlmock.NewRows([]string{"id", "name", "auth_type", "auth_url", "client_id", "scopes", "params"}).
-		AddRow("b1b1b1b1-b1b1-b1b1-b1b1-b1b1b1b1b1b1", "Test API", "api_key", nil, nil, "{}", []byte("{}"))
-	mock.ExpectQuery("SELECT id, name, auth_type, auth_url, client_id, scopes, params FROM provider_profiles WHERE id = \\$1").
-		WithArgs("b1b1b1b1-b1b1-b1b1-b1b1-b1b1b1b1b1b1").
-		WillReturnRows(rows)
-
-	mock.ExpectExec("INSERT INTO connections").
-		WillReturnResult(sqlmock.NewResult(1, 1))
+	params := json.RawMessage(`{}`)
+	st.PutProvider(store.Provider{
+		ID:       uuid.MustParse("b1b1b1b1-b1b1-b1b1-b1b1-b1b1b1b1b1b1"),
+		Name:     "Test API",
+		AuthType: "api_key",
+		Params:   &params,
+	}, "ws-123")
 
 	body := map[string]interface{}{
 		"workspace_id": "ws-123",
@@ -128,11 +137,7 @@ func TestGetSpec_StaticKey(t *testing.T) {
 }
 
 func TestGetSpec_MixedOAuth2_Discovery(t *testing.T) {
-	// Setup mock DB
-	db, mock, err := sqlmock.New()
-	assert.NoError(t, err)
-	defer db.Close()
-	sqlxDB := sqlx.NewDb(db, "sqlmock")
+	st := store.NewMemory()
 
 	// Setup mock Provider Server (simulating Slack)
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -154,31 +159,29 @@ func TestGetSpec_MixedOAuth2_Discovery(t *testing.T) {
 
 	// Handler under test
 	handler := NewConsentHandler(ConsentHandlerConfig{
-		DB:           sqlxDB,
+		Store:        st,
 		BaseURL:      "http://localhost:8080",
 		RedirectPath: "/auth/callback",
-		StateKey:     []byte("test-key"),
+		States:       testStates(t, []byte("test-key")),
 		HTTPClient:   ts.Client(),
 	})
 
 	// Define the configured (legacy) auth URL
 	configuredAuthURL := ts.URL + "/oauth/v2/authorize"
 
-	// 1. Mock DB Provider Query
-
-	rows := sqlmock.NewRows([]string{"id", "name", "auth_type", "auth_url", "client_id", "scopes", "params"}).
-		AddRow("00000000-0000-0000-0000-000000000000", "Slack", "oauth2", configuredAuthURL, "slack-client", "{chat:write}", []byte("{}"))
-
-	// Use regex to avoid strict string matching issues with sqlmock
-	mock.ExpectQuery("SELECT .* FROM provider_profiles WHERE id = .*").
-		WithArgs("00000000-0000-0000-0000-000000000000").
-		WillReturnRows(rows)
-
-	// 2. Mock Connection Insert
-	mock.ExpectExec("INSERT INTO connections").
-		WillReturnResult(sqlmock.NewResult(1, 1))
+	// 1. Seed the provider
+	params := json.RawMessage(`{}`)
+	st.PutProvider(store.Provider{
+		ID:       uuid.Nil,
+		Name:     "Slack",
+		AuthType: "oauth2",
+		AuthURL:  configuredAuthURL,
+		ClientID: "slack-client",
+		Scopes:   []string{"chat:write"},
+		Params:   &params,
+	})
 
-	// 3. Make Request WITHOUT 'openid' scope
+	// 2. Make Request WITHOUT 'openid' scope
 	body := map[string]interface{}{
 		"workspace_id": "ws-123",
 		"provider_id":  "00000000-0000-0000-0000-000000000000",
@@ -192,7 +195,7 @@ func TestGetSpec_MixedOAuth2_Discovery(t *testing.T) {
 	rr := httptest.NewRecorder()
 	handler.GetSpec(rr, req)
 
-	// 4. Validate Response
+	// 3. Validate Response
 	assert.Equal(t, http.StatusOK, rr.Code)
 
 	var response ConsentSpec
@@ -209,3 +212,172 @@ func TestGetSpec_MixedOAuth2_Discovery(t *testing.T) {
 		t.Errorf("Expected AuthURL to start with configured URL %s, but got %s", configuredAuthURL, response.AuthURL)
 	}
 }
+
+func TestGetSpec_ProviderRestrictedToOtherWorkspace(t *testing.T) {
+	st := store.NewMemory()
+	handler := NewConsentHandler(ConsentHandlerConfig{
+		Store:      st,
+		BaseURL:    "http://localhost:8080",
+		States:     testStates(t, []byte("test-key")),
+		HTTPClient: http.DefaultClient,
+	})
+
+	providerID := uuid.New()
+	st.PutProvider(store.Provider{ID: providerID, Name: "Private", AuthType: "api_key"}, "ws-other")
+
+	for _, providerParam := range []string{providerID.String(), "not-a-uuid"} {
+		jsonBody, _ := json.Marshal(map[string]interface{}{
+			"workspace_id": "ws-123",
+			"provider_id":  providerParam,
+			"return_url":   "http://localhost:3000/callback",
+		})
+		rr := httptest.NewRecorder()
+		handler.GetSpec(rr, httptest.NewRequest("POST", "/auth/consent-spec", bytes.NewReader(jsonBody)))
+
+		assert.Equal(t, http.StatusNotFound, rr.Code, providerParam)
+		assert.Contains(t, rr.Body.String(), "provider_not_found")
+	}
+}
+
+// parProvider serves OIDC discovery advertising a PAR endpoint and records
+// the pushed form.
+func parProvider(t *testing.T, pushed *url.Values) *httptest.Server {
+	t.Helper()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			fmt.Fprintf(w, `{"issuer": "http://%[1]s", "authorization_endpoint": "http://%[1]s/authorize",
+				"jwks_uri": "http://%[1]s/jwks", "pushed_authorization_request_endpoint": "http://%[1]s/par"}`, r.Host)
+		case "/par":
+			require.NoError(t, r.ParseForm())
+			*pushed = r.PostForm
+			w.WriteHeader(http.StatusCreated)
+			io.WriteString(w, `{"request_uri": "urn:ietf:params:oauth:request_uri:abc", "expires_in": 60}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func getSpecAuthURL(t *testing.T, handler *ConsentHandler, providerID uuid.UUID, scopes []string) *url.URL {
+	t.Helper()
+	body, _ := json.Marshal(map[string]interface{}{
+		"workspace_id": "ws-123",
+		"provider_id":  providerID.String(),
+		"scopes":       scopes,
+		"return_url":   "http://localhost:3000/callback",
+	})
+	rr := httptest.NewRecorder()
+	handler.GetSpec(rr, httptest.NewRequest("POST", "/auth/consent-spec", bytes.NewReader(body)))
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	var response ConsentSpec
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	u, err := url.Parse(response.AuthURL)
+	require.NoError(t, err)
+	return u
+}
+
+func TestGetSpec_PushedAuthorizationRequest(t *testing.T) {
+	var pushed url.Values
+	ts := parProvider(t, &pushed)
+	st := store.NewMemory()
+	handler := NewConsentHandler(ConsentHandlerConfig{
+		Store:        st,
+		BaseURL:      "http://localhost:8080",
+		RedirectPath: "/auth/callback",
+		States:       testStates(t, []byte("test-key")),
+		HTTPClient:   ts.Client(),
+	})
+	params := json.RawMessage(`{"prompt": "consent"}`)
+	id := uuid.New()
+	st.PutProvider(store.Provider{
+		ID: id, Name: "bank", AuthType: "oauth2", AuthURL: ts.URL + "/authorize",
+		ClientID: "bank-client", ClientSecret: "bank-secret", Params: &params,
+	})
+
+	u := getSpecAuthURL(t, handler, id, []string{"openid", "accounts"})
+
+	// Only the request_uri travels through the browser.
+	assert.Equal(t, ts.URL+"/authorize", u.Scheme+"://"+u.Host+u.Path)
+	assert.Equal(t, url.Values{"client_id": {"bank-client"}, "request_uri": {"urn:ietf:params:oauth:request_uri:abc"}}, u.Query())
+	assert.Equal(t, "bank-secret", pushed.Get("client_secret"))
+	assert.Equal(t, "openid accounts", pushed.Get("scope"))
+	assert.Equal(t, "consent", pushed.Get("prompt"))
+	assert.NotEmpty(t, pushed.Get("code_challenge"))
+	assert.NotEmpty(t, pushed.Get("state"))
+}
+
+func TestGetSpec_SignedRequestObject(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	keyPEM := string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+
+	var pushed url.Values
+	ts := parProvider(t, &pushed)
+	st := store.NewMemory()
+	handler := NewConsentHandler(ConsentHandlerConfig{
+		Store:        st,
+		BaseURL:      "http://localhost:8080",
+		RedirectPath: "/auth/callback",
+		States:       testStates(t, []byte("test-key")),
+		HTTPClient:   ts.Client(),
+	})
+	params, _ := json.Marshal(map[string]interface{}{"par": true, "request_object_signing_key": keyPEM, "request_object_signing_kid": "k1"})
+	raw := json.RawMessage(params)
+	id := uuid.New()
+	st.PutProvider(store.Provider{
+		ID: id, Name: "bank", AuthType: "oauth2", AuthURL: ts.URL + "/authorize",
+		ClientID: "bank-client", ClientSecret: "bank-secret", AuthHeader: "client_secret_basic", Params: &raw,
+	})
+
+	// PAR is required, so discovery runs even without openid.
+	u := getSpecAuthURL(t, handler, id, []string{"accounts"})
+	assert.Equal(t, "urn:ietf:params:oauth:request_uri:abc", u.Query().Get("request_uri"))
+
+	assert.Empty(t, pushed.Get("scope"), "parameters travel only inside the request object")
+	assert.Empty(t, pushed.Get("client_secret"))
+	jws, err := jose.ParseSigned(pushed.Get("request"), []jose.SignatureAlgorithm{jose.ES256})
+	require.NoError(t, err)
+	payload, err := jws.Verify(&key.PublicKey)
+	require.NoError(t, err)
+	var claims map[string]interface{}
+	require.NoError(t, json.Unmarshal(payload, &claims))
+	assert.Equal(t, ts.URL, claims["aud"])
+	assert.Equal(t, "accounts", claims["scope"])
+	assert.Equal(t, "http://localhost:8080/auth/callback", claims["redirect_uri"])
+	assert.NotContains(t, claims, "request_object_signing_key")
+	assert.NotContains(t, claims, "request_object_signing_kid")
+}
+
+func TestGetSpec_PushedAuthorizationRequestRefused(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		io.WriteString(w, `{"error": "invalid_client"}`)
+	}))
+	defer ts.Close()
+	st := store.NewMemory()
+	handler := NewConsentHandler(ConsentHandlerConfig{
+		Store:        st,
+		BaseURL:      "http://localhost:8080",
+		RedirectPath: "/auth/callback",
+		States:       testStates(t, []byte("test-key")),
+		HTTPClient:   ts.Client(),
+	})
+	params := json.RawMessage(`{"pushed_authorization_request_endpoint": "` + ts.URL + `/par"}`)
+	id := uuid.New()
+	st.PutProvider(store.Provider{ID: id, Name: "bank", AuthType: "oauth2", AuthURL: ts.URL + "/authorize", ClientID: "bank-client", Params: &params})
+
+	body, _ := json.Marshal(map[string]interface{}{
+		"workspace_id": "ws-123", "provider_id": id.String(), "scopes": []string{"accounts"}, "return_url": "http://localhost:3000/callback",
+	})
+	rr := httptest.NewRecorder()
+	handler.GetSpec(rr, httptest.NewRequest("POST", "/auth/consent-spec", bytes.NewReader(body)))
+
+	assert.Equal(t, http.StatusBadGateway, rr.Code)
+	assert.Contains(t, rr.Body.String(), "pushed_authorization_failed")
+	assert.Contains(t, rr.Body.String(), "invalid_client")
+}
@@ -0,0 +1,172 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/internal/audit"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/internal/store"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/internal/webhook"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/connstate"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/httputil"
+)
+
+// Deprovision outcomes reported per connection.
+const (
+	deprovisionRevoked = "revoked"
+	deprovisionSkipped = "skipped"
+	deprovisionFailed  = "failed"
+)
+
+// DeprovisionHandler revokes every connection a user authorised in a
+// workspace, for offboarding driven by an HR or SCIM system.
+type DeprovisionHandler struct {
+	store   store.Store
+	audit   audit.Logger
+	webhook *webhook.Notifier
+}
+
+// DeprovisionHandlerConfig configures a DeprovisionHandler.
+type DeprovisionHandlerConfig struct {
+	// Store defaults to Postgres through DB.
+	Store store.Store
+	DB    *sqlx.DB
+	Audit audit.Logger
+	// Webhook receives a user.deprovisioned event per request. Nil disables it.
+	Webhook *webhook.Notifier
+}
+
+// NewDeprovisionHandler creates a DeprovisionHandler.
+func NewDeprovisionHandler(cfg DeprovisionHandlerConfig) *DeprovisionHandler {
+	if cfg.Store == nil {
+		cfg.Store = store.NewPostgres(cfg.DB)
+	}
+	return &DeprovisionHandler{store: cfg.Store, audit: cfg.Audit, webhook: cfg.Webhook}
+}
+
+// DeprovisionedConnection is one connection in a DeprovisionReport.
+type DeprovisionedConnection struct {
+	ConnectionID   string `json:"connection_id"`
+	ProviderID     string `json:"provider_id"`
+	PreviousStatus string `json:"previous_status"`
+	// Result is "revoked", "skipped" (already inactive) or "failed".
+	Result string `json:"result"`
+	Error  string `json:"error,omitempty"`
+}
+
+// DeprovisionReport is the response of POST
+// /workspaces/{workspaceID}/users/{user}/deprovision.
+type DeprovisionReport struct {
+	WorkspaceID      string                    `json:"workspace_id"`
+	User             string                    `json:"user"`
+	Revoked          int                       `json:"revoked"`
+	Skipped          int                       `json:"skipped"`
+	Failed           int                       `json:"failed"`
+	Connections      []DeprovisionedConnection `json:"connections"`
+	WebhookDelivered bool                      `json:"webhook_delivered"`
+	CompletedAt      time.Time                 `json:"completed_at"`
+}
+
+// Deprovision handles POST /workspaces/{workspaceID}/users/{user}/deprovision.
+// user is matched against the id_token subject, or the email claim
+// case-insensitively. Active and needs_reauth connections are revoked and
+// their stored tokens deleted; connections in any other state are skipped.
+// The request is idempotent: repeating it revokes nothing new.
+func (h *DeprovisionHandler) Deprovision(w http.ResponseWriter, r *http.Request) {
+	workspaceID := chi.URLParam(r, "workspaceID")
+	// chi matches the escaped path when one is present, e.g. an email
+	// sent as alice%40example.com.
+	user, err := url.PathUnescape(chi.URLParam(r, "user"))
+	user = strings.TrimSpace(user)
+	if err != nil || workspaceID == "" || user == "" {
+		httputil.WriteError(w, http.StatusBadRequest, "invalid_request", "workspace ID and user are required")
+		return
+	}
+
+	conns, err := h.store.ListUserConnections(r.Context(), workspaceID, user)
+	if err != nil {
+		log.Printf("deprovision: list connections for workspace=%s: %v", workspaceID, err)
+		httputil.WriteError(w, http.StatusInternalServerError, "deprovision_failed", "Failed to look up the user's connections")
+		return
+	}
+
+	report := DeprovisionReport{
+		WorkspaceID: workspaceID,
+		User:        user,
+		Connections: make([]DeprovisionedConnection, 0, len(conns)),
+	}
+	for _, c := range conns {
+		entry := DeprovisionedConnection{
+			ConnectionID:   c.ID.String(),
+			ProviderID:     c.ProviderID.String(),
+			PreviousStatus: string(c.Status),
+		}
+		if !c.Status.CanTransition(connstate.StateRevoked) {
+			entry.Result = deprovisionSkipped
+			report.Skipped++
+		} else if err := h.revoke(r.Context(), c.ID); err != nil {
+			entry.Result = deprovisionFailed
+			entry.Error = err.Error()
+			report.Failed++
+		} else {
+			entry.Result = deprovisionRevoked
+			report.Revoked++
+			h.logAudit("connection_deprovisioned", &c.ID, map[string]interface{}{
+				"workspace_id":    workspaceID,
+				"provider_id":     entry.ProviderID,
+				"previous_status": entry.PreviousStatus,
+			}, r)
+		}
+		report.Connections = append(report.Connections, entry)
+	}
+	report.CompletedAt = time.Now().UTC()
+
+	h.logAudit("user_deprovisioned", nil, map[string]interface{}{
+		"workspace_id": workspaceID,
+		"user":         user,
+		"revoked":      report.Revoked,
+		"skipped":      report.Skipped,
+		"failed":       report.Failed,
+	}, r)
+
+	if h.webhook != nil {
+		if err := h.webhook.Send(r.Context(), "user.deprovisioned", report); err != nil {
+			log.Printf("deprovision: %v", err)
+		} else {
+			report.WebhookDelivered = true
+		}
+	}
+
+	httputil.WriteJSON(w, http.StatusOK, report)
+}
+
+func (h *DeprovisionHandler) revoke(ctx context.Context, id uuid.UUID) error {
+	return revokeConnection(ctx, h.store, id)
+}
+
+// revokeConnection moves the connection to revoked before deleting its
+// token, so a concurrent refresh cannot store a new one for an active
+// connection.
+func revokeConnection(ctx context.Context, st store.Store, id uuid.UUID) error {
+	if err := st.UpdateStatus(ctx, id, connstate.StateRevoked); err != nil {
+		return err
+	}
+	return st.DeleteTokens(ctx, id)
+}
+
+func (h *DeprovisionHandler) logAudit(eventType string, connectionID *uuid.UUID, data map[string]interface{}, r *http.Request) {
+	if h.audit == nil {
+		return
+	}
+	if err := h.audit.Log(eventType, connectionID, data, r); err != nil {
+		log.Printf("audit: failed to log %s (connection_id=%v): %v", eventType, connectionID, err)
+	}
+}
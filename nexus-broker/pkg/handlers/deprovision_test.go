@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/internal/audit"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/internal/store"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/internal/webhook"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/connstate"
+)
+
+func seedUserConnection(t *testing.T, st *store.Memory, workspaceID string, status connstate.State, subject, email string) uuid.UUID {
+	t.Helper()
+	id := uuid.New()
+	st.PutConnection(store.Connection{
+		ID:          id,
+		WorkspaceID: workspaceID,
+		ProviderID:  uuid.New(),
+		Status:      status,
+		ExpiresAt:   time.Now().Add(time.Minute),
+		Subject:     subject,
+		Email:       email,
+	})
+	require.NoError(t, st.SaveTokens(context.Background(), id, "ciphertext", nil))
+	return id
+}
+
+func deprovision(h *DeprovisionHandler, workspaceID, user string) *httptest.ResponseRecorder {
+	r := chi.NewRouter()
+	r.Post("/workspaces/{workspaceID}/users/{user}/deprovision", h.Deprovision)
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, httptest.NewRequest("POST", "/workspaces/"+workspaceID+"/users/"+user+"/deprovision", nil))
+	return rr
+}
+
+func TestDeprovision_RevokesUserConnections(t *testing.T) {
+	st := store.NewMemory()
+	var delivered map[string]interface{}
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		json.Unmarshal(body, &delivered)
+	}))
+	defer hook.Close()
+
+	h := NewDeprovisionHandler(DeprovisionHandlerConfig{
+		Store:   st,
+		Audit:   audit.NewServiceWithStore(st),
+		Webhook: webhook.New(hook.URL, []byte("secret"), hook.Client()),
+	})
+
+	active := seedUserConnection(t, st, "ws-1", connstate.StateActive, "sub-1", "alice@example.com")
+	reauth := seedUserConnection(t, st, "ws-1", connstate.StateNeedsReauth, "", "Alice@Example.com")
+	revoked := seedUserConnection(t, st, "ws-1", connstate.StateRevoked, "sub-1", "")
+	otherWorkspace := seedUserConnection(t, st, "ws-2", connstate.StateActive, "sub-1", "alice@example.com")
+	otherUser := seedUserConnection(t, st, "ws-1", connstate.StateActive, "sub-2", "bob@example.com")
+
+	rr := deprovision(h, "ws-1", "alice%40example.com")
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	var report DeprovisionReport
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &report))
+	assert.Equal(t, "alice@example.com", report.User)
+	assert.Equal(t, 2, report.Revoked)
+	assert.Equal(t, 0, report.Skipped)
+	assert.Equal(t, 0, report.Failed)
+	assert.True(t, report.WebhookDelivered)
+	assert.Equal(t, "user.deprovisioned", delivered["event"])
+
+	for _, id := range []uuid.UUID{active, reauth} {
+		c, err := st.GetConnection(context.Background(), id)
+		require.NoError(t, err)
+		assert.Equal(t, connstate.StateRevoked, c.Status)
+		_, err = st.GetTokens(context.Background(), id)
+		assert.ErrorIs(t, err, store.ErrNotFound)
+	}
+	for _, id := range []uuid.UUID{revoked, otherWorkspace, otherUser} {
+		_, err := st.GetTokens(context.Background(), id)
+		assert.NoError(t, err, "connection %s should be untouched", id)
+	}
+
+	// Matching by subject picks up the already-revoked connection as skipped.
+	rr = deprovision(h, "ws-1", "sub-1")
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &report))
+	assert.Equal(t, 0, report.Revoked)
+	assert.Equal(t, 2, report.Skipped)
+
+	var types []string
+	for _, e := range st.Events() {
+		types = append(types, e.EventType)
+	}
+	assert.Equal(t, []string{"connection_deprovisioned", "connection_deprovisioned", "user_deprovisioned", "user_deprovisioned"}, types)
+}
+
+func TestDeprovision_UnknownUserIsEmptyReport(t *testing.T) {
+	h := NewDeprovisionHandler(DeprovisionHandlerConfig{Store: store.NewMemory()})
+
+	rr := deprovision(h, "ws-1", "nobody")
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	var report DeprovisionReport
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &report))
+	assert.Empty(t, report.Connections)
+	assert.False(t, report.WebhookDelivered)
+}
@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/internal/store"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/dpop"
+)
+
+// dpopKeyField is the member of a connection's stored token that holds its
+// DPoP key as a private JWK. It is removed from every token the Broker
+// answers with; GetToken hands the key to the Bridge in the strategy.
+const dpopKeyField = "dpop_jwk"
+
+// dpopEnabled reports whether provider has the dpop param, which makes the
+// Broker bind the connections' tokens to a key with DPoP proofs.
+func dpopEnabled(provider *store.Provider) bool {
+	if provider.Params == nil {
+		return false
+	}
+	var params struct {
+		DPoP bool `json:"dpop"`
+	}
+	return json.Unmarshal(*provider.Params, &params) == nil && params.DPoP
+}
+
+// takeDPoPKey removes the connection's DPoP key from tokens and returns it,
+// or nil if the connection has none.
+func takeDPoPKey(tokens map[string]interface{}) (*dpop.Key, error) {
+	raw, ok := tokens[dpopKeyField]
+	if !ok {
+		return nil, nil
+	}
+	delete(tokens, dpopKeyField)
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+	return dpop.Parse(data)
+}
+
+// withDPoPKey returns tokens as they are stored: with key, if it is set.
+// tokens itself is not changed.
+func withDPoPKey(tokens map[string]interface{}, key *dpop.Key) map[string]interface{} {
+	if key == nil {
+		return tokens
+	}
+	stored := make(map[string]interface{}, len(tokens)+1)
+	for k, v := range tokens {
+		stored[k] = v
+	}
+	stored[dpopKeyField] = key
+	return stored
+}
+
+// dpopBound reports whether tokens hold a DPoP-bound access token.
+func dpopBound(tokens map[string]interface{}) bool {
+	tokenType, _ := tokens["token_type"].(string)
+	return strings.EqualFold(tokenType, dpop.TokenType)
+}
+
+// dpopNonceChallenge returns the nonce a token endpoint asked DPoP proofs
+// to carry by refusing a request with use_dpop_nonce. For any other
+// response the body is left readable.
+func dpopNonceChallenge(resp *http.Response) (string, bool) {
+	nonce := resp.Header.Get(dpop.NonceHeader)
+	if resp.StatusCode != http.StatusBadRequest || nonce == "" {
+		return "", false
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxTokenErrorBytes))
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	var e struct {
+		Error string `json:"error"`
+	}
+	if json.Unmarshal(body, &e) != nil || e.Error != "use_dpop_nonce" {
+		return "", false
+	}
+	return nonce, true
+}
@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"context"
+	"crypto"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/internal/store"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/connstate"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/dpop"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/vault"
+	"github.com/Prescott-Data/nexus-framework/nexus-common/state"
+)
+
+// dpopProvider is a token endpoint that requires DPoP proofs carrying its
+// nonce and records the public keys of the proofs it accepted.
+type dpopProvider struct {
+	*httptest.Server
+	keys []string
+}
+
+func newDPoPProvider(t *testing.T) *dpopProvider {
+	p := &dpopProvider{}
+	p.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Path, "/.well-known/") {
+			http.NotFound(w, r)
+			return
+		}
+		jws, err := jose.ParseSigned(r.Header.Get(dpop.Header), []jose.SignatureAlgorithm{jose.ES256})
+		require.NoError(t, err)
+		jwk := jws.Signatures[0].Header.JSONWebKey
+		payload, err := jws.Verify(jwk)
+		require.NoError(t, err)
+		var claims map[string]interface{}
+		require.NoError(t, json.Unmarshal(payload, &claims))
+		assert.Equal(t, "POST", claims["htm"])
+		assert.Equal(t, p.URL+"/token", claims["htu"])
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set(dpop.NonceHeader, "n-1")
+		if claims["nonce"] != "n-1" {
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, `{"error": "use_dpop_nonce"}`)
+			return
+		}
+		thumb, _ := jwk.Thumbprint(crypto.SHA256)
+		p.keys = append(p.keys, string(thumb))
+		io.WriteString(w, `{"access_token": "bound-token", "token_type": "DPoP", "refresh_token": "r-2", "expires_in": 3600}`)
+	}))
+	t.Cleanup(p.Close)
+	return p
+}
+
+func TestDPoP_CodeExchangeRefreshAndGetToken(t *testing.T) {
+	provider := newDPoPProvider(t)
+	st := store.NewMemory()
+	key := []byte("01234567890123456789012345678901")
+	states := testStates(t, key)
+	handler := NewCallbackHandler(CallbackHandlerConfig{
+		Store:         st,
+		BaseURL:       "http://localhost:8080",
+		RedirectPath:  "/auth/callback",
+		EncryptionKey: key,
+		States:        states,
+		HTTPClient:    provider.Client(),
+	})
+	params := json.RawMessage(`{"dpop": true}`)
+	seedConnection(st, refreshConnectionID, connstate.StatePending, store.Provider{
+		Name: "bank", AuthType: "oauth2", TokenURL: provider.URL + "/token", ClientID: "id", ClientSecret: "secret", Params: &params,
+	})
+
+	signed, err := states.Sign(state.Data{Nonce: refreshConnectionID.String(), IAT: time.Now()})
+	require.NoError(t, err)
+	rr := httptest.NewRecorder()
+	handler.Handle(rr, httptest.NewRequest("GET", "/auth/callback?code=abc&state="+url.QueryEscape(signed), nil))
+	require.Equal(t, http.StatusFound, rr.Code, rr.Body.String())
+
+	// The key is stored with the token, encrypted.
+	tok, err := st.GetTokens(context.Background(), refreshConnectionID)
+	require.NoError(t, err)
+	plain, err := vault.OpenToken(key, tok.EncryptedData, refreshConnectionID.String(), "ws-1", false)
+	require.NoError(t, err)
+	assert.Contains(t, string(plain), dpopKeyField)
+
+	// A refresh proves possession of the same key, and does not return it.
+	rr = httptest.NewRecorder()
+	handler.Refresh(rr, httptest.NewRequest("POST", "/connections/"+refreshConnectionID.String()+"/refresh", nil))
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	assert.NotContains(t, rr.Body.String(), dpopKeyField)
+	require.Len(t, provider.keys, 2)
+	assert.Equal(t, provider.keys[0], provider.keys[1], "the refresh must use the connection's key")
+
+	// The Bridge gets the key in the strategy, not with the credentials.
+	rr = httptest.NewRecorder()
+	handler.GetToken(rr, httptest.NewRequest("GET", "/connections/"+refreshConnectionID.String()+"/token", nil))
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	var out struct {
+		Strategy struct {
+			Type   string                     `json:"type"`
+			Config map[string]json.RawMessage `json:"config"`
+		} `json:"strategy"`
+		Credentials map[string]interface{} `json:"credentials"`
+		DPoPKey     interface{}            `json:"dpop_jwk"`
+	}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &out))
+	assert.Equal(t, "oauth2", out.Strategy.Type)
+	assert.Equal(t, "bound-token", out.Credentials["access_token"])
+	assert.NotContains(t, out.Credentials, dpopKeyField)
+	assert.Nil(t, out.DPoPKey)
+	_, err = dpop.Parse(out.Strategy.Config[dpopKeyField])
+	assert.NoError(t, err)
+}
+
+func TestDPoPNonceChallenge_KeepsOtherErrors(t *testing.T) {
+	resp := &http.Response{
+		StatusCode: http.StatusBadRequest,
+		Header:     http.Header{dpop.NonceHeader: {"n-2"}},
+		Body:       io.NopCloser(strings.NewReader(`{"error": "invalid_grant"}`)),
+	}
+	_, ok := dpopNonceChallenge(resp)
+	assert.False(t, ok)
+	body, _ := io.ReadAll(resp.Body)
+	assert.JSONEq(t, `{"error": "invalid_grant"}`, string(body))
+}
@@ -0,0 +1,269 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/internal/store"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/connstate"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/httputil"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/vault"
+)
+
+// Token exchange (RFC 8693) identifiers.
+const (
+	grantTypeTokenExchange = "urn:ietf:params:oauth:grant-type:token-exchange"
+	tokenTypePrefix        = "urn:ietf:params:oauth:token-type:"
+)
+
+// ExchangeRequest is the body of POST /connections/{id}/exchange. Every
+// member is optional.
+type ExchangeRequest struct {
+	// SubjectTokenType names the connection's token to exchange:
+	// "access_token" (the default) or "id_token".
+	SubjectTokenType string `json:"subject_token_type"`
+	// Audience and Resource name the service the new token is for.
+	Audience string `json:"audience"`
+	Resource string `json:"resource"`
+	// Scopes narrows the new token; providers refuse scopes beyond the
+	// subject token's.
+	Scopes []string `json:"scopes"`
+	// RequestedTokenType is a token type URI, or its short name such as
+	// "access_token" or "jwt".
+	RequestedTokenType string `json:"requested_token_type"`
+	// ActorToken and ActorTokenType make the exchange a delegation: the new
+	// token says the actor acts on behalf of the connection's user.
+	ActorToken     string `json:"actor_token"`
+	ActorTokenType string `json:"actor_token_type"`
+}
+
+// Exchange handles POST /connections/{connectionID}/exchange: it trades the
+// connection's token at the provider's token endpoint for another one,
+// typically down-scoped or for another audience, and returns it. The new
+// token is not stored and the connection keeps its own. Only providers with
+// the token_exchange param support it; token_exchange_url overrides their
+// token_url.
+func (h *CallbackHandler) Exchange(w http.ResponseWriter, r *http.Request) {
+	connectionID, err := uuid.Parse(chi.URLParam(r, "connectionID"))
+	if err != nil {
+		httputil.WriteError(w, http.StatusBadRequest, "invalid_connection_id", "Invalid connection ID")
+		return
+	}
+	var in ExchangeRequest
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil && err != io.EOF {
+		httputil.WriteError(w, http.StatusBadRequest, "invalid_json", "Invalid JSON")
+		return
+	}
+	if in.SubjectTokenType == "" {
+		in.SubjectTokenType = "access_token"
+	}
+	if in.SubjectTokenType != "access_token" && in.SubjectTokenType != "id_token" {
+		httputil.WriteError(w, http.StatusBadRequest, "invalid_request", "subject_token_type must be access_token or id_token")
+		return
+	}
+	if (in.ActorToken == "") != (in.ActorTokenType == "") {
+		httputil.WriteError(w, http.StatusBadRequest, "invalid_request", "actor_token and actor_token_type must be sent together")
+		return
+	}
+
+	conn, err := h.store.GetConnection(store.ReadPrimary(r.Context()), connectionID)
+	var provider *store.Provider
+	if err == nil {
+		provider, err = h.store.GetProvider(r.Context(), conn.ProviderID)
+	}
+	if err != nil {
+		httputil.WriteError(w, http.StatusNotFound, "connection_not_found", "Connection not found")
+		return
+	}
+	if conn.Status != connstate.StateActive {
+		h.notActiveError(r.Context(), conn).Write(w)
+		return
+	}
+	provider = withTenant(provider, conn.Tenant)
+	endpoint, ok := tokenExchangeURL(provider)
+	if !ok {
+		httputil.WriteError(w, http.StatusBadRequest, "token_exchange_unsupported", "The provider does not support token exchange")
+		return
+	}
+
+	tokens, ok := h.currentTokens(w, r, conn, in.SubjectTokenType == "access_token")
+	if !ok {
+		return
+	}
+	subject, _ := tokens[in.SubjectTokenType].(string)
+	if subject == "" {
+		httputil.WriteError(w, http.StatusConflict, "subject_token_unavailable", "The connection holds no "+in.SubjectTokenType)
+		return
+	}
+
+	pol := h.tokenPolicy(provider)
+	client, err := h.clients.client(provider.CABundle, pol.timeout)
+	if err != nil {
+		httputil.WriteError(w, http.StatusInternalServerError, "provider_config_failed", "Failed to load provider config")
+		return
+	}
+	exchanged, status, err := h.exchangeToken(r.Context(), client, pol, endpoint, provider, subject, in)
+	if err != nil {
+		h.logAuditEvent(&connectionID, "token_exchange_request_failed", map[string]string{"error": err.Error(), "status_code": fmt.Sprintf("%d", status), "audience": in.Audience}, r)
+		if e := providerRateLimitedError(err); e != nil {
+			e.Write(w)
+			return
+		}
+		if status >= 400 && status < 500 {
+			httputil.WriteError(w, http.StatusBadRequest, "token_exchange_rejected", err.Error())
+			return
+		}
+		httputil.WriteError(w, http.StatusBadGateway, "upstream_error", err.Error())
+		return
+	}
+
+	h.logAuditEvent(&connectionID, "token_exchanged", map[string]string{
+		"audience": in.Audience, "resource": in.Resource, "scope": strings.Join(in.Scopes, " "),
+		"delegated": fmt.Sprintf("%t", in.ActorToken != ""),
+	}, r)
+	httputil.WriteJSON(w, http.StatusOK, exchanged)
+}
+
+// tokenExchangeURL returns the endpoint token exchanges with provider go
+// to, and false if the provider does not support them.
+func tokenExchangeURL(provider *store.Provider) (string, bool) {
+	if (provider.AuthType != "oauth2" && provider.AuthType != "") || provider.Params == nil {
+		return "", false
+	}
+	var params struct {
+		TokenExchange    bool   `json:"token_exchange"`
+		TokenExchangeURL string `json:"token_exchange_url"`
+	}
+	if json.Unmarshal(*provider.Params, &params) != nil || !params.TokenExchange {
+		return "", false
+	}
+	if params.TokenExchangeURL != "" {
+		return params.TokenExchangeURL, true
+	}
+	return provider.TokenURL, provider.TokenURL != ""
+}
+
+// currentTokens returns the connection's decrypted token, refreshed first
+// when fresh is set and the access token has expired. It reads from the
+// primary, as the token is about to be released. Failures are answered on
+// w.
+func (h *CallbackHandler) currentTokens(w http.ResponseWriter, r *http.Request, conn *store.Connection, fresh bool) (map[string]interface{}, bool) {
+	token, err := h.store.GetTokens(store.ReadPrimary(r.Context()), conn.ID)
+	if err != nil {
+		httputil.WriteError(w, http.StatusNotFound, "token_not_found", "Token not found")
+		return nil, false
+	}
+	if fresh && token.ExpiresAt != nil && token.ExpiresAt.Before(time.Now()) {
+		tokens, rerr := h.refresh(r.Context(), r, conn)
+		if rerr != nil {
+			rerr.write(w)
+			return nil, false
+		}
+		return tokens, true
+	}
+	plaintext, err := vault.OpenToken(h.encryptionKey, token.EncryptedData, conn.ID.String(), token.WorkspaceID, h.allowLegacyTokens)
+	if err != nil {
+		httputil.WriteError(w, http.StatusInternalServerError, "decrypt_failed", "Failed to decrypt token")
+		return nil, false
+	}
+	var tokens map[string]interface{}
+	if err := json.Unmarshal(plaintext, &tokens); err != nil {
+		httputil.WriteError(w, http.StatusInternalServerError, "invalid_token_format", "Invalid token format")
+		return nil, false
+	}
+	return tokens, true
+}
+
+// exchangeToken sends a token exchange request for subject, authenticating
+// the client like a code exchange. The returned status code is 0 when no
+// HTTP response was received.
+func (h *CallbackHandler) exchangeToken(ctx context.Context, client *http.Client, pol tokenPolicy, endpoint string, provider *store.Provider, subject string, in ExchangeRequest) (map[string]interface{}, int, error) {
+	data := url.Values{}
+	data.Set("grant_type", grantTypeTokenExchange)
+	data.Set("subject_token", subject)
+	data.Set("subject_token_type", tokenTypePrefix+in.SubjectTokenType)
+	if in.Audience != "" {
+		data.Set("audience", in.Audience)
+	}
+	if in.Resource != "" {
+		data.Set("resource", in.Resource)
+	}
+	if len(in.Scopes) > 0 {
+		data.Set("scope", strings.Join(in.Scopes, " "))
+	}
+	if in.RequestedTokenType != "" {
+		data.Set("requested_token_type", tokenTypeURI(in.RequestedTokenType))
+	}
+	if in.ActorToken != "" {
+		data.Set("actor_token", in.ActorToken)
+		data.Set("actor_token_type", tokenTypeURI(in.ActorTokenType))
+	}
+
+	useBasicAuth := strings.EqualFold(provider.AuthHeader, "client_secret_basic") || strings.EqualFold(provider.AuthHeader, "Basic")
+	if !useBasicAuth {
+		data.Set("client_id", provider.ClientID)
+		if provider.ClientSecret != "" {
+			data.Set("client_secret", provider.ClientSecret)
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, strings.NewReader(data.Encode()))
+	if err != nil {
+		return nil, 0, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if useBasicAuth {
+		req.SetBasicAuth(provider.ClientID, provider.ClientSecret)
+	}
+
+	resp, err := sendTokenRequest(ctx, client, req, pol, grantTokenExchange)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, resp.StatusCode, fmt.Errorf("token exchange failed: %s", readTokenError(resp))
+	}
+	tokens, err := decodeTokenResponse(resp)
+	if err != nil {
+		return nil, resp.StatusCode, err
+	}
+	return tokens, resp.StatusCode, nil
+}
+
+// tokenTypeURI expands a short token type name such as "jwt" to its URI.
+func tokenTypeURI(t string) string {
+	if strings.Contains(t, ":") {
+		return t
+	}
+	return tokenTypePrefix + t
+}
+
+// notActiveError is the answer to a request for the token of a connection
+// that is not active.
+func (h *CallbackHandler) notActiveError(ctx context.Context, conn *store.Connection) *Error {
+	switch conn.Status {
+	case connstate.StateNeedsReauth:
+		return &Error{Status: http.StatusConflict, Code: "attention_required", Message: "Connection requires attention. The user must re-authenticate."}
+	case connstate.StateCompromised:
+		return &Error{Status: http.StatusConflict, Code: "connection_compromised", Message: "A refresh token for this connection was reused after rotation. Tokens are withheld until it is revoked."}
+	case connstate.StateSuspended:
+		return suspendedError(ctx, h.store, conn.ID)
+	case connstate.StateAwaitingApproval:
+		return &Error{Status: http.StatusForbidden, Code: "approval_pending", Message: "Connection is awaiting approval. Tokens are withheld until an approver approves it."}
+	case connstate.StateRejected:
+		return &Error{Status: http.StatusForbidden, Code: "approval_rejected", Message: "An approver rejected the connection."}
+	default:
+		return &Error{Status: http.StatusForbidden, Code: "connection_not_active", Message: "Connection not active"}
+	}
+}
@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/internal/store"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/connstate"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/vault"
+)
+
+// newExchangeHandler seeds an active connection holding tokens to a
+// provider with the given params, whose token endpoint is tokenURL.
+func newExchangeHandler(t *testing.T, tokenURL, params string, tokens map[string]interface{}) *CallbackHandler {
+	t.Helper()
+	st := store.NewMemory()
+	key := []byte("01234567890123456789012345678901")
+	handler := NewCallbackHandler(CallbackHandlerConfig{Store: st, EncryptionKey: key, HTTPClient: http.DefaultClient})
+	raw := json.RawMessage(params)
+	seedConnection(st, refreshConnectionID, connstate.StateActive, store.Provider{
+		AuthType: "oauth2", TokenURL: tokenURL, ClientID: "agent-client", ClientSecret: "agent-secret", Params: &raw,
+	})
+	tokenJSON, _ := json.Marshal(tokens)
+	sealed, err := vault.SealToken(key, tokenJSON, refreshConnectionID.String(), "ws-1")
+	require.NoError(t, err)
+	expiresAt := time.Now().Add(time.Hour)
+	require.NoError(t, st.SaveTokens(context.Background(), refreshConnectionID, sealed, &expiresAt))
+	return handler
+}
+
+func exchange(handler *CallbackHandler, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", "/connections/"+refreshConnectionID.String()+"/exchange", bytes.NewReader([]byte(body)))
+	rr := httptest.NewRecorder()
+	handler.Exchange(rr, withURLParam(req, "connectionID", refreshConnectionID.String()))
+	return rr
+}
+
+func TestExchange(t *testing.T) {
+	var form url.Values
+	provider := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		form = r.PostForm
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"access_token": "narrow-token", "issued_token_type": "urn:ietf:params:oauth:token-type:access_token", "token_type": "Bearer", "expires_in": 300}`)
+	}))
+	defer provider.Close()
+	handler := newExchangeHandler(t, provider.URL, `{"token_exchange": true}`, map[string]interface{}{"access_token": "broad-token"})
+
+	rr := exchange(handler, `{"audience": "https://files.example", "scopes": ["files.read"], "requested_token_type": "access_token"}`)
+
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	assert.Contains(t, rr.Body.String(), "narrow-token")
+	assert.Equal(t, grantTypeTokenExchange, form.Get("grant_type"))
+	assert.Equal(t, "broad-token", form.Get("subject_token"))
+	assert.Equal(t, "urn:ietf:params:oauth:token-type:access_token", form.Get("subject_token_type"))
+	assert.Equal(t, "urn:ietf:params:oauth:token-type:access_token", form.Get("requested_token_type"))
+	assert.Equal(t, "https://files.example", form.Get("audience"))
+	assert.Equal(t, "files.read", form.Get("scope"))
+	assert.Equal(t, "agent-secret", form.Get("client_secret"))
+	assert.Empty(t, form.Get("actor_token"))
+}
+
+func TestExchange_Unsupported(t *testing.T) {
+	handler := newExchangeHandler(t, "http://provider.invalid/token", `{}`, map[string]interface{}{"access_token": "broad-token"})
+
+	rr := exchange(handler, `{"audience": "https://files.example"}`)
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), "token_exchange_unsupported")
+}
+
+func TestExchange_Rejected(t *testing.T) {
+	provider := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		io.WriteString(w, `{"error": "invalid_target"}`)
+	}))
+	defer provider.Close()
+	handler := newExchangeHandler(t, "", `{"token_exchange": true, "token_exchange_url": "`+provider.URL+`"}`, map[string]interface{}{"access_token": "broad-token"})
+
+	rr := exchange(handler, `{"audience": "https://elsewhere.example"}`)
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), "token_exchange_rejected")
+	assert.Contains(t, rr.Body.String(), "invalid_target")
+}
+
+func TestExchange_NoSubjectToken(t *testing.T) {
+	handler := newExchangeHandler(t, "http://provider.invalid/token", `{"token_exchange": true}`, map[string]interface{}{"access_token": "broad-token"})
+
+	rr := exchange(handler, `{"subject_token_type": "id_token"}`)
+
+	assert.Equal(t, http.StatusConflict, rr.Code)
+	assert.Contains(t, rr.Body.String(), "subject_token_unavailable")
+}
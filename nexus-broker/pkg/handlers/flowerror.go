@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"golang.org/x/text/language"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/internal/store"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/httputil"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/pages"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/server"
+)
+
+// writeFlowError answers a failed consent that cannot be sent back to its
+// return_url: browsers get the error page in their language, other clients
+// the JSON error httputil.WriteError writes. connectionID is shown on the
+// page when known.
+func (h *CallbackHandler) writeFlowError(w http.ResponseWriter, r *http.Request, status int, code, message, connectionID string) {
+	if !acceptsHTML(r) {
+		httputil.WriteError(w, status, code, message)
+		return
+	}
+	page := pages.ErrorPage{Code: code, MessageKey: "error." + code, ConnectionID: connectionID}
+	if _, ok := h.pages.Lookup(language.English, page.MessageKey); !ok {
+		page.MessageKey = "error.default"
+	}
+	if err := h.pages.Render(w, r, status, pages.Error, page); err != nil {
+		log.Printf("error page: %v", err)
+	}
+}
+
+// acceptsHTML reports whether r comes from a browser navigating to the
+// page, which asks for text/html.
+func acceptsHTML(r *http.Request) bool {
+	for _, v := range strings.Split(r.Header.Get("Accept"), ",") {
+		if mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(v)); err == nil && mediaType == "text/html" {
+			return true
+		}
+	}
+	return false
+}
+
+// redirectFailure sends the browser of a failed consent back to conn's
+// return_url with status=error, code and connection_id, so the application
+// can tell the user what happened. When the return_url may not be used the
+// error is written by writeFlowError instead.
+func (h *CallbackHandler) redirectFailure(w http.ResponseWriter, r *http.Request, conn *store.Connection, status int, code, message string) {
+	returnURL, err := url.Parse(conn.ReturnURL)
+	if err != nil || conn.ReturnURL == "" || !server.IsReturnURLAllowed(conn.ReturnURL, h.enforceReturnURL, h.allowedReturnDomains) {
+		h.writeFlowError(w, r, status, code, message, conn.ID.String())
+		return
+	}
+	query := returnURL.Query()
+	query.Set("status", "error")
+	query.Set("code", code)
+	query.Set("connection_id", conn.ID.String())
+	returnURL.RawQuery = query.Encode()
+	http.Redirect(w, r, returnURL.String(), http.StatusFound)
+}
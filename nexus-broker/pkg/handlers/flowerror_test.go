@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"html"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/text/language"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/internal/store"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/connstate"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/pages"
+)
+
+func TestCallback_FailureRedirectsWithCode(t *testing.T) {
+	tokens := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error": "invalid_grant"}`))
+	}))
+	defer tokens.Close()
+	consent, callback, st, states := newSessionHandlers(t)
+	provider := store.Provider{
+		ID: uuid.New(), Name: "files", AuthType: "oauth2", ClientID: "client-id", ClientSecret: "secret",
+		AuthURL: "http://provider.invalid/authorize", TokenURL: tokens.URL,
+	}
+	st.PutProvider(provider)
+
+	start := func() (string, uuid.UUID) {
+		rr := boundConsent(t, consent, provider.ID, "")
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		var spec ConsentSpec
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &spec))
+		data, err := states.Verify(spec.State)
+		require.NoError(t, err)
+		return spec.State, uuid.MustParse(data.Nonce)
+	}
+
+	for name, tc := range map[string]struct{ query, code string }{
+		"token exchange fails": {"code=abc", "token_exchange_failed"},
+		"user declines":        {"error=access_denied", "consent_denied"},
+		"provider error":       {"error=server_error", "oauth_error"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			signed, id := start()
+			rr := httptest.NewRecorder()
+			callback.Handle(rr, httptest.NewRequest("GET", "/auth/callback?"+tc.query+"&state="+url.QueryEscape(signed), nil))
+			require.Equal(t, http.StatusFound, rr.Code, rr.Body.String())
+			loc, err := url.Parse(rr.Header().Get("Location"))
+			require.NoError(t, err)
+			assert.Equal(t, "localhost:3000", loc.Host)
+			assert.Equal(t, url.Values{"status": {"error"}, "code": {tc.code}, "connection_id": {id.String()}}, loc.Query())
+
+			conn, err := st.GetConnection(context.Background(), id)
+			require.NoError(t, err)
+			assert.Equal(t, connstate.StateFailed, conn.Status)
+			code, err := st.GetFailureCode(context.Background(), id)
+			require.NoError(t, err)
+			assert.Equal(t, tc.code, code)
+		})
+	}
+}
+
+func TestCallback_ErrorPage(t *testing.T) {
+	_, callback, _, _ := newSessionHandlers(t)
+	get := func(accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/auth/callback?code=abc&state=forged", nil)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		rr := httptest.NewRecorder()
+		callback.Handle(rr, req)
+		return rr
+	}
+
+	// Browsers, with no return_url to go back to, get a page.
+	rr := get("text/html,application/xhtml+xml;q=0.9,*/*;q=0.8")
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Equal(t, "text/html; charset=utf-8", rr.Header().Get("Content-Type"))
+	assert.Contains(t, rr.Body.String(), "<code>invalid_state</code>")
+	assert.Contains(t, rr.Body.String(), html.EscapeString(pages.Default().Message(language.English, "error.invalid_state")))
+
+	// In the language the browser prefers.
+	req := httptest.NewRequest("GET", "/auth/callback?code=abc&state=forged", nil)
+	req.Header.Set("Accept", "text/html")
+	req.Header.Set("Accept-Language", "fr-CA,fr;q=0.9")
+	rr = httptest.NewRecorder()
+	callback.Handle(rr, req)
+	assert.Equal(t, "fr", rr.Header().Get("Content-Language"))
+	assert.Contains(t, rr.Body.String(), `<html lang="fr">`)
+	assert.Contains(t, rr.Body.String(), html.EscapeString(pages.Default().Message(language.French, "error.invalid_state")))
+
+	// Everyone else keeps getting the JSON error.
+	rr = get("")
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Header().Get("Content-Type"), "json")
+	assert.Contains(t, rr.Body.String(), `"invalid_state"`)
+}
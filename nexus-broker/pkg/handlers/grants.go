@@ -0,0 +1,300 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/internal/store"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/connstate"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/httputil"
+)
+
+// grantPrefix starts every grant token: "nxg.<grant id>.<base64url MAC>".
+const grantPrefix = "nxg."
+
+// Lifetime of a grant, settable per grant up to maxGrantTTL.
+const (
+	defaultGrantTTL = 5 * time.Minute
+	maxGrantTTL     = time.Hour
+)
+
+// GrantRequest is the body of POST /connections/{id}/grants. Every member
+// is optional.
+type GrantRequest struct {
+	// Scopes limits the grant to some of the connection's scopes; it
+	// defaults to all of them.
+	Scopes []string `json:"scopes"`
+	// TTLSeconds is how long the grant can be redeemed: 300 by default, at
+	// most 3600.
+	TTLSeconds int `json:"ttl_seconds"`
+}
+
+// GrantResponse is a grant issued by POST /connections/{id}/grants.
+type GrantResponse struct {
+	// Grant is the token to hand to the downstream service. It is shown
+	// only once.
+	Grant        string    `json:"grant"`
+	GrantID      string    `json:"grant_id"`
+	ConnectionID string    `json:"connection_id"`
+	Scopes       []string  `json:"scopes"`
+	ExpiresAt    time.Time `json:"expires_at"`
+}
+
+// signGrant returns the grant token of a grant. The MAC, keyed like the
+// refresh token hashes, stops tokens being forged from leaked grant IDs.
+func (h *CallbackHandler) signGrant(id uuid.UUID) string {
+	return grantPrefix + id.String() + "." + base64.RawURLEncoding.EncodeToString(h.grantMAC(id))
+}
+
+// verifyGrant returns the ID of a grant token signed by signGrant.
+func (h *CallbackHandler) verifyGrant(token string) (uuid.UUID, bool) {
+	parts := strings.Split(strings.TrimPrefix(token, grantPrefix), ".")
+	if !strings.HasPrefix(token, grantPrefix) || len(parts) != 2 {
+		return uuid.Nil, false
+	}
+	id, err := uuid.Parse(parts[0])
+	if err != nil {
+		return uuid.Nil, false
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || !hmac.Equal(mac, h.grantMAC(id)) {
+		return uuid.Nil, false
+	}
+	return id, true
+}
+
+func (h *CallbackHandler) grantMAC(id uuid.UUID) []byte {
+	mac := hmac.New(sha256.New, h.encryptionKey)
+	mac.Write([]byte("grant:" + id.String()))
+	return mac.Sum(nil)
+}
+
+// CreateGrant handles POST /connections/{connectionID}/grants: it issues a
+// signed grant a downstream service redeems once, before it expires, for
+// the connection's access token. The refresh token never leaves the
+// Broker. Only OAuth connections get grants; a grant for fewer scopes than
+// the connection holds needs a provider supporting token exchange, which
+// down-scopes the token on redemption.
+func (h *CallbackHandler) CreateGrant(w http.ResponseWriter, r *http.Request) {
+	connectionID, err := uuid.Parse(chi.URLParam(r, "connectionID"))
+	if err != nil {
+		httputil.WriteError(w, http.StatusBadRequest, "invalid_connection_id", "Invalid connection ID")
+		return
+	}
+	var in GrantRequest
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil && !errors.Is(err, io.EOF) {
+		httputil.WriteError(w, http.StatusBadRequest, "invalid_json", "Invalid JSON")
+		return
+	}
+	ttl := defaultGrantTTL
+	if in.TTLSeconds != 0 {
+		ttl = time.Duration(in.TTLSeconds) * time.Second
+		if ttl < 0 || ttl > maxGrantTTL {
+			httputil.WriteError(w, http.StatusBadRequest, "invalid_ttl", "ttl_seconds must be between 1 and 3600")
+			return
+		}
+	}
+
+	conn, err := h.store.GetConnection(r.Context(), connectionID)
+	var provider *store.Provider
+	if err == nil {
+		provider, err = h.store.GetProvider(r.Context(), conn.ProviderID)
+	}
+	if err != nil {
+		httputil.WriteError(w, http.StatusNotFound, "connection_not_found", "Connection not found")
+		return
+	}
+	if conn.Status != connstate.StateActive {
+		h.notActiveError(r.Context(), conn).Write(w)
+		return
+	}
+	if provider.AuthType != "oauth2" && provider.AuthType != "" && provider.AuthType != authTypeServiceAccount {
+		httputil.WriteError(w, http.StatusBadRequest, "grant_unsupported", "Grants are only issued for OAuth connections")
+		return
+	}
+
+	scopes := in.Scopes
+	if len(scopes) == 0 {
+		scopes = conn.Scopes
+	}
+	var ungranted []string
+	for _, s := range scopes {
+		if !containsScope(conn.Scopes, s) {
+			ungranted = append(ungranted, s)
+		}
+	}
+	if len(ungranted) > 0 {
+		httputil.WriteErrorWithDetails(w, http.StatusBadRequest, "scope_not_granted", "The connection does not hold "+strings.Join(ungranted, ", "), map[string]interface{}{"scopes": ungranted})
+		return
+	}
+	if narrowsScopes(scopes, conn.Scopes) {
+		if _, ok := tokenExchangeURL(withTenant(provider, conn.Tenant)); !ok {
+			httputil.WriteError(w, http.StatusBadRequest, "scope_narrowing_unsupported", "The provider does not support token exchange, so a grant must cover all of the connection's scopes")
+			return
+		}
+	}
+
+	grant := &store.Grant{
+		ConnectionID: conn.ID,
+		WorkspaceID:  conn.WorkspaceID,
+		Scopes:       scopes,
+		ExpiresAt:    time.Now().Add(ttl).UTC().Truncate(time.Second),
+	}
+	if err := h.store.CreateGrant(r.Context(), grant); err != nil {
+		log.Printf("grants: create for %s: %v", conn.ID, err)
+		httputil.WriteError(w, http.StatusInternalServerError, "grant_failed", "Failed to issue the grant")
+		return
+	}
+	h.logAuditEvent(&connectionID, "grant_issued", map[string]string{
+		"grant_id": grant.ID.String(), "scope": strings.Join(scopes, " "), "expires_at": grant.ExpiresAt.Format(time.RFC3339),
+	}, r)
+	httputil.WriteJSON(w, http.StatusCreated, GrantResponse{
+		Grant:        h.signGrant(grant.ID),
+		GrantID:      grant.ID.String(),
+		ConnectionID: conn.ID.String(),
+		Scopes:       scopes,
+		ExpiresAt:    grant.ExpiresAt,
+	})
+}
+
+// RedeemGrant handles POST /grants/redeem with the body {"grant": "..."}:
+// it answers a grant issued by CreateGrant, once, with the connection's
+// access token, refreshed if it has expired and down-scoped by token
+// exchange when the grant is narrower than the connection. The response
+// never carries a refresh token.
+func (h *CallbackHandler) RedeemGrant(w http.ResponseWriter, r *http.Request) {
+	var in struct {
+		Grant string `json:"grant"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		httputil.WriteError(w, http.StatusBadRequest, "invalid_json", "Invalid JSON")
+		return
+	}
+	id, ok := h.verifyGrant(strings.TrimSpace(in.Grant))
+	if !ok {
+		h.logAuditEvent(nil, "grant_redeem_failed", map[string]string{"error": "invalid grant"}, r)
+		httputil.WriteError(w, http.StatusBadRequest, "invalid_grant", "Invalid grant")
+		return
+	}
+	grant, err := h.store.RedeemGrant(r.Context(), id, time.Now())
+	if err != nil {
+		h.writeGrantError(w, r, id, err)
+		return
+	}
+
+	conn, err := h.store.GetConnection(store.ReadPrimary(r.Context()), grant.ConnectionID)
+	var provider *store.Provider
+	if err == nil {
+		provider, err = h.store.GetProvider(r.Context(), conn.ProviderID)
+	}
+	if err != nil {
+		httputil.WriteError(w, http.StatusNotFound, "connection_not_found", "Connection not found")
+		return
+	}
+	if conn.Status != connstate.StateActive {
+		h.logAuditEvent(&conn.ID, "grant_redeem_failed", map[string]string{"grant_id": id.String(), "error": "connection not active", "status": string(conn.Status)}, r)
+		h.notActiveError(r.Context(), conn).Write(w)
+		return
+	}
+	tokens, ok := h.currentTokens(w, r, conn, true)
+	if !ok {
+		return
+	}
+	accessToken, _ := tokens["access_token"].(string)
+	if accessToken == "" {
+		httputil.WriteError(w, http.StatusConflict, "subject_token_unavailable", "The connection holds no access_token")
+		return
+	}
+
+	var out map[string]interface{}
+	if narrowsScopes(grant.Scopes, conn.Scopes) {
+		if out, ok = h.downscope(w, r, conn, provider, accessToken, grant.Scopes); !ok {
+			return
+		}
+		delete(out, "refresh_token")
+	} else {
+		out = map[string]interface{}{"access_token": accessToken, "scope": strings.Join(grant.Scopes, " ")}
+		if t, ok := tokens["token_type"]; ok {
+			out["token_type"] = t
+		}
+		if t, err := h.store.GetTokens(store.ReadPrimary(r.Context()), conn.ID); err == nil && t.ExpiresAt != nil {
+			out["expires_at"] = t.ExpiresAt.Format(time.RFC3339)
+		}
+	}
+	out["connection_id"] = conn.ID.String()
+	out["grant_id"] = id.String()
+
+	h.logAuditEvent(&conn.ID, "grant_redeemed", map[string]string{"grant_id": id.String(), "scope": strings.Join(grant.Scopes, " ")}, r)
+	h.usage.Record(r.Context(), conn.ID, time.Now())
+	httputil.WriteJSON(w, http.StatusOK, out)
+}
+
+// downscope exchanges accessToken for one limited to scopes. Failures are
+// answered on w.
+func (h *CallbackHandler) downscope(w http.ResponseWriter, r *http.Request, conn *store.Connection, provider *store.Provider, accessToken string, scopes []string) (map[string]interface{}, bool) {
+	provider = withTenant(provider, conn.Tenant)
+	endpoint, ok := tokenExchangeURL(provider)
+	if !ok {
+		httputil.WriteError(w, http.StatusConflict, "scope_narrowing_unsupported", "The provider no longer supports token exchange")
+		return nil, false
+	}
+	pol := h.tokenPolicy(provider)
+	client, err := h.clients.client(provider.CABundle, pol.timeout)
+	if err != nil {
+		httputil.WriteError(w, http.StatusInternalServerError, "provider_config_failed", "Failed to load provider config")
+		return nil, false
+	}
+	exchanged, status, err := h.exchangeToken(r.Context(), client, pol, endpoint, provider, accessToken, ExchangeRequest{SubjectTokenType: "access_token", Scopes: scopes})
+	if err != nil {
+		h.logAuditEvent(&conn.ID, "token_exchange_request_failed", map[string]string{"error": err.Error(), "status_code": fmt.Sprintf("%d", status), "scope": strings.Join(scopes, " ")}, r)
+		if e := providerRateLimitedError(err); e != nil {
+			e.Write(w)
+			return nil, false
+		}
+		if status >= 400 && status < 500 {
+			httputil.WriteError(w, http.StatusBadRequest, "token_exchange_rejected", err.Error())
+			return nil, false
+		}
+		httputil.WriteError(w, http.StatusBadGateway, "upstream_error", err.Error())
+		return nil, false
+	}
+	return exchanged, true
+}
+
+// writeGrantError answers a redemption the store refused.
+func (h *CallbackHandler) writeGrantError(w http.ResponseWriter, r *http.Request, id uuid.UUID, err error) {
+	h.logAuditEvent(nil, "grant_redeem_failed", map[string]string{"grant_id": id.String(), "error": err.Error()}, r)
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		httputil.WriteError(w, http.StatusBadRequest, "invalid_grant", "Invalid grant")
+	case errors.Is(err, store.ErrGrantRedeemed):
+		httputil.WriteError(w, http.StatusConflict, "grant_already_redeemed", "The grant was already redeemed")
+	case errors.Is(err, store.ErrGrantExpired):
+		httputil.WriteError(w, http.StatusGone, "grant_expired", "The grant has expired")
+	default:
+		log.Printf("grants: redeem %s: %v", id, err)
+		httputil.WriteError(w, http.StatusInternalServerError, "redeem_failed", "Failed to redeem the grant")
+	}
+}
+
+// narrowsScopes reports whether scopes leaves out any of held.
+func narrowsScopes(scopes, held []string) bool {
+	for _, s := range held {
+		if !containsScope(scopes, s) {
+			return true
+		}
+	}
+	return false
+}
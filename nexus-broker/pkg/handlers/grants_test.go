@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/internal/store"
+)
+
+// newGrantHandler is newExchangeHandler with the connection holding scopes.
+func newGrantHandler(t *testing.T, tokenURL, params string, scopes ...string) (*CallbackHandler, *store.Memory) {
+	t.Helper()
+	handler := newExchangeHandler(t, tokenURL, params, map[string]interface{}{
+		"access_token": "broad-token", "token_type": "Bearer", "refresh_token": "long-lived",
+	})
+	st := handler.store.(*store.Memory)
+	conn, err := st.GetConnection(context.Background(), refreshConnectionID)
+	require.NoError(t, err)
+	conn.Scopes = scopes
+	st.PutConnection(*conn)
+	return handler, st
+}
+
+func createGrant(handler *CallbackHandler, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", "/connections/"+refreshConnectionID.String()+"/grants", strings.NewReader(body))
+	rr := httptest.NewRecorder()
+	handler.CreateGrant(rr, withURLParam(req, "connectionID", refreshConnectionID.String()))
+	return rr
+}
+
+func redeemGrant(handler *CallbackHandler, grant string) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(map[string]string{"grant": grant})
+	rr := httptest.NewRecorder()
+	handler.RedeemGrant(rr, httptest.NewRequest("POST", "/grants/redeem", bytes.NewReader(body)))
+	return rr
+}
+
+func TestGrant_RedeemedOnce(t *testing.T) {
+	handler, _ := newGrantHandler(t, "http://provider.invalid/token", `{}`, "files.read", "files.write")
+
+	rr := createGrant(handler, "")
+	require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+	var grant GrantResponse
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &grant))
+	assert.True(t, strings.HasPrefix(grant.Grant, grantPrefix))
+	assert.Equal(t, []string{"files.read", "files.write"}, grant.Scopes)
+	assert.WithinDuration(t, time.Now().Add(defaultGrantTTL), grant.ExpiresAt, 2*time.Second)
+
+	rr = redeemGrant(handler, grant.Grant)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	var token map[string]interface{}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &token))
+	assert.Equal(t, "broad-token", token["access_token"])
+	assert.Equal(t, "files.read files.write", token["scope"])
+	assert.Equal(t, grant.GrantID, token["grant_id"])
+	assert.NotContains(t, rr.Body.String(), "long-lived")
+
+	rr = redeemGrant(handler, grant.Grant)
+	assert.Equal(t, http.StatusConflict, rr.Code)
+	assert.Contains(t, rr.Body.String(), "grant_already_redeemed")
+}
+
+func TestGrant_Invalid(t *testing.T) {
+	handler, st := newGrantHandler(t, "http://provider.invalid/token", `{}`, "files.read")
+
+	rr := createGrant(handler, `{"ttl_seconds": 60}`)
+	require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+	var grant GrantResponse
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &grant))
+
+	// A tampered MAC and a grant of another key are refused before the
+	// store is consulted.
+	rr = redeemGrant(handler, grant.Grant[:len(grant.Grant)-2]+"AA")
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), "invalid_grant")
+	other := NewCallbackHandler(CallbackHandlerConfig{Store: st, EncryptionKey: []byte("another-key-another-key-another!!")})
+	rr = redeemGrant(handler, other.signGrant(uuid.MustParse(grant.GrantID)))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	// An expired grant is refused.
+	expired := &store.Grant{ConnectionID: refreshConnectionID, WorkspaceID: "ws-1", ExpiresAt: time.Now().Add(-time.Second)}
+	require.NoError(t, st.CreateGrant(context.Background(), expired))
+	rr = redeemGrant(handler, handler.signGrant(expired.ID))
+	assert.Equal(t, http.StatusGone, rr.Code)
+	assert.Contains(t, rr.Body.String(), "grant_expired")
+}
+
+func TestGrant_Scopes(t *testing.T) {
+	var form url.Values
+	provider := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		form = r.PostForm
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"access_token": "narrow-token", "token_type": "Bearer", "expires_in": 300, "refresh_token": "narrow-refresh"}`)
+	}))
+	defer provider.Close()
+
+	// Without token exchange a grant covers every scope.
+	handler, _ := newGrantHandler(t, provider.URL, `{}`, "files.read", "files.write")
+	rr := createGrant(handler, `{"scopes": ["files.read"]}`)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), "scope_narrowing_unsupported")
+
+	handler, _ = newGrantHandler(t, provider.URL, `{"token_exchange": true}`, "files.read", "files.write")
+	rr = createGrant(handler, `{"scopes": ["files.admin"]}`)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), "scope_not_granted")
+	rr = createGrant(handler, `{"ttl_seconds": 7200}`)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), "invalid_ttl")
+
+	rr = createGrant(handler, `{"scopes": ["files.read"]}`)
+	require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+	var grant GrantResponse
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &grant))
+
+	rr = redeemGrant(handler, grant.Grant)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	assert.Contains(t, rr.Body.String(), "narrow-token")
+	assert.NotContains(t, rr.Body.String(), "refresh_token")
+	assert.Equal(t, "broad-token", form.Get("subject_token"))
+	assert.Equal(t, "files.read", form.Get("scope"))
+}
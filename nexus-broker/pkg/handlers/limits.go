@@ -0,0 +1,175 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/internal/audit"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/internal/quota"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/internal/store"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/httputil"
+)
+
+// quotaError is the answer to a request refused by quota: 429
+// limit_exceeded for an *quota.ExceededError, with Retry-After for rate
+// limits, and 500 if the limits could not be checked.
+func quotaError(workspaceID string, err error) *Error {
+	var exceeded *quota.ExceededError
+	if !errors.As(err, &exceeded) {
+		log.Printf("quota: check workspace=%s: %v", workspaceID, err)
+		return &Error{Status: http.StatusInternalServerError, Code: "limit_check_failed", Message: "Failed to check workspace limits"}
+	}
+	e := &Error{Status: http.StatusTooManyRequests, Code: "limit_exceeded",
+		Message: "Workspace limit " + exceeded.Limit + " reached",
+		Details: map[string]interface{}{"limit": exceeded.Limit, "max": exceeded.Max, "current": exceeded.Current}}
+	if exceeded.RetryAfter > 0 {
+		e.RetryAfter = strconv.Itoa(int(math.Ceil(exceeded.RetryAfter.Seconds())))
+	}
+	return e
+}
+
+// LimitsHandler serves the admin API for per-workspace quotas.
+type LimitsHandler struct {
+	quota *quota.Enforcer
+	store store.WorkspaceLimitStore
+	audit audit.Logger
+}
+
+// NewLimitsHandler creates a LimitsHandler. st must be the store q reads
+// overrides from.
+func NewLimitsHandler(q *quota.Enforcer, st store.WorkspaceLimitStore, auditLogger audit.Logger) *LimitsHandler {
+	return &LimitsHandler{quota: q, store: st, audit: auditLogger}
+}
+
+// workspaceLimitsView is the body of the limits endpoints.
+type workspaceLimitsView struct {
+	WorkspaceID string `json:"workspace_id"`
+	// Limits are the limits in force: Overrides where set, else Defaults.
+	Limits    quota.Limits   `json:"limits"`
+	Defaults  quota.Limits   `json:"defaults"`
+	Overrides limitOverrides `json:"overrides"`
+	Usage     quota.Usage    `json:"usage"`
+	UpdatedAt *time.Time     `json:"updated_at,omitempty"`
+}
+
+// limitOverrides is the body of PUT /admin/workspaces/{workspaceID}/limits;
+// a null or missing field keeps the default.
+type limitOverrides struct {
+	MaxPendingConnections *int `json:"max_pending_connections"`
+	MaxActiveConnections  *int `json:"max_active_connections"`
+	MaxRefreshesPerMinute *int `json:"max_refreshes_per_minute"`
+}
+
+// Get handles GET /admin/workspaces/{workspaceID}/limits.
+func (h *LimitsHandler) Get(w http.ResponseWriter, r *http.Request) {
+	workspaceID := strings.TrimSpace(chi.URLParam(r, "workspaceID"))
+	if workspaceID == "" {
+		httputil.WriteError(w, http.StatusBadRequest, "invalid_request", "workspace ID is required")
+		return
+	}
+	h.writeView(w, r, workspaceID)
+}
+
+// Put handles PUT /admin/workspaces/{workspaceID}/limits, replacing the
+// workspace's overrides.
+func (h *LimitsHandler) Put(w http.ResponseWriter, r *http.Request) {
+	workspaceID := strings.TrimSpace(chi.URLParam(r, "workspaceID"))
+	if workspaceID == "" {
+		httputil.WriteError(w, http.StatusBadRequest, "invalid_request", "workspace ID is required")
+		return
+	}
+	var body limitOverrides
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		httputil.WriteError(w, http.StatusBadRequest, "invalid_json", "Invalid JSON")
+		return
+	}
+	for name, v := range map[string]*int{
+		quota.LimitPendingConnections: body.MaxPendingConnections,
+		quota.LimitActiveConnections:  body.MaxActiveConnections,
+		quota.LimitRefreshesPerMinute: body.MaxRefreshesPerMinute,
+	} {
+		if v != nil && *v < 0 {
+			httputil.WriteError(w, http.StatusBadRequest, "invalid_limit", name+" must not be negative")
+			return
+		}
+	}
+	err := h.store.SetWorkspaceLimits(r.Context(), &store.WorkspaceLimits{
+		WorkspaceID:           workspaceID,
+		MaxPendingConnections: body.MaxPendingConnections,
+		MaxActiveConnections:  body.MaxActiveConnections,
+		MaxRefreshesPerMinute: body.MaxRefreshesPerMinute,
+	})
+	if err != nil {
+		log.Printf("limits: set workspace=%s: %v", workspaceID, err)
+		httputil.WriteError(w, http.StatusInternalServerError, "update_failed", "Failed to save workspace limits")
+		return
+	}
+	h.logChange("workspace_limits.updated", workspaceID, &body, r)
+	h.writeView(w, r, workspaceID)
+}
+
+// Delete handles DELETE /admin/workspaces/{workspaceID}/limits: the
+// workspace returns to the defaults.
+func (h *LimitsHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	workspaceID := strings.TrimSpace(chi.URLParam(r, "workspaceID"))
+	if workspaceID == "" {
+		httputil.WriteError(w, http.StatusBadRequest, "invalid_request", "workspace ID is required")
+		return
+	}
+	if err := h.store.DeleteWorkspaceLimits(r.Context(), workspaceID); err != nil {
+		log.Printf("limits: delete workspace=%s: %v", workspaceID, err)
+		httputil.WriteError(w, http.StatusInternalServerError, "delete_failed", "Failed to delete workspace limits")
+		return
+	}
+	h.logChange("workspace_limits.deleted", workspaceID, nil, r)
+	h.writeView(w, r, workspaceID)
+}
+
+func (h *LimitsHandler) writeView(w http.ResponseWriter, r *http.Request, workspaceID string) {
+	limits, overrides, err := h.quota.Effective(r.Context(), workspaceID)
+	var usage quota.Usage
+	if err == nil {
+		usage, err = h.quota.Usage(r.Context(), workspaceID)
+	}
+	if err != nil {
+		log.Printf("limits: get workspace=%s: %v", workspaceID, err)
+		httputil.WriteError(w, http.StatusInternalServerError, "get_failed", "Failed to load workspace limits")
+		return
+	}
+	view := workspaceLimitsView{
+		WorkspaceID: workspaceID,
+		Limits:      limits,
+		Defaults:    h.quota.Defaults(),
+		Usage:       usage,
+	}
+	if overrides != nil {
+		view.Overrides = limitOverrides{
+			MaxPendingConnections: overrides.MaxPendingConnections,
+			MaxActiveConnections:  overrides.MaxActiveConnections,
+			MaxRefreshesPerMinute: overrides.MaxRefreshesPerMinute,
+		}
+		view.UpdatedAt = &overrides.UpdatedAt
+	}
+	httputil.WriteJSON(w, http.StatusOK, view)
+}
+
+func (h *LimitsHandler) logChange(event, workspaceID string, overrides *limitOverrides, r *http.Request) {
+	if h.audit == nil {
+		return
+	}
+	data := map[string]interface{}{"workspace_id": workspaceID}
+	if overrides != nil {
+		data["overrides"] = overrides
+	}
+	if err := h.audit.Log(event, nil, data, r); err != nil {
+		log.Printf("audit: failed to log %s (workspace_id=%s): %v", event, workspaceID, err)
+	}
+}
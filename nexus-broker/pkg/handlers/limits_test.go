@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/internal/quota"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/internal/store"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/connstate"
+)
+
+func TestWorkspaceLimitsAdminAPI(t *testing.T) {
+	st := store.NewMemory()
+	q := quota.New(st, quota.Limits{MaxActiveConnections: 10, MaxRefreshesPerMinute: 60}, nil)
+	h := NewLimitsHandler(q, st, nil)
+	r := chi.NewRouter()
+	r.Get("/admin/workspaces/{workspaceID}/limits", h.Get)
+	r.Put("/admin/workspaces/{workspaceID}/limits", h.Put)
+	r.Delete("/admin/workspaces/{workspaceID}/limits", h.Delete)
+	seedUserConnection(t, st, "ws-1", connstate.StateActive, "sub-1", "")
+
+	call := func(method, body string) (*httptest.ResponseRecorder, workspaceLimitsView) {
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, httptest.NewRequest(method, "/admin/workspaces/ws-1/limits", bytes.NewBufferString(body)))
+		var view workspaceLimitsView
+		_ = json.Unmarshal(rr.Body.Bytes(), &view)
+		return rr, view
+	}
+
+	rr, view := call("GET", "")
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	assert.Equal(t, 10, view.Limits.MaxActiveConnections)
+	assert.Equal(t, 1, view.Usage.ActiveConnections)
+	assert.Nil(t, view.Overrides.MaxActiveConnections)
+
+	rr, view = call("PUT", `{"max_active_connections": 1, "max_refreshes_per_minute": null}`)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	assert.Equal(t, 1, view.Limits.MaxActiveConnections)
+	assert.Equal(t, 60, view.Limits.MaxRefreshesPerMinute, "null keeps the default")
+	require.NotNil(t, view.Overrides.MaxActiveConnections)
+
+	rr, _ = call("PUT", `{"max_pending_connections": -1}`)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	rr, view = call("DELETE", "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, 10, view.Limits.MaxActiveConnections)
+}
+
+func TestGetSpec_WorkspaceLimitExceeded(t *testing.T) {
+	st := store.NewMemory()
+	providerID := uuid.New()
+	st.PutProvider(store.Provider{ID: providerID, Name: "p", AuthType: "oauth2", AuthURL: "http://provider.example/auth", ClientID: "c"})
+	st.PutConnection(store.Connection{ID: uuid.New(), WorkspaceID: "ws-1", Status: connstate.StatePending, ExpiresAt: time.Now().Add(time.Minute)})
+	h := NewConsentHandler(ConsentHandlerConfig{
+		Store:        st,
+		BaseURL:      "http://localhost:8080",
+		RedirectPath: "/auth/callback",
+		States:       testStates(t, []byte("test-key")),
+		Quota:        quota.New(st, quota.Limits{MaxPendingConnections: 1}, nil),
+	})
+
+	body, _ := json.Marshal(map[string]interface{}{
+		"workspace_id": "ws-1", "provider_id": providerID.String(), "return_url": "http://localhost:3000/cb",
+	})
+	rr := httptest.NewRecorder()
+	h.GetSpec(rr, httptest.NewRequest("POST", "/auth/consent-spec", bytes.NewReader(body)))
+	require.Equal(t, http.StatusTooManyRequests, rr.Code, rr.Body.String())
+	assert.Contains(t, rr.Body.String(), `"error":"limit_exceeded"`)
+	assert.Contains(t, rr.Body.String(), `"limit":"max_pending_connections"`)
+}
@@ -0,0 +1,186 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/httputil"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/provider"
+)
+
+// providerAuditRetention bounds how long audit results are kept.
+const providerAuditRetention = 30 * 24 * time.Hour
+
+// ProviderAudit is one stored result of a scheduled provider audit.
+type ProviderAudit struct {
+	ProviderID uuid.UUID       `db:"provider_id" json:"provider_id"`
+	Status     string          `db:"status" json:"status"`
+	Checks     json.RawMessage `db:"checks" json:"checks"`
+	CreatedAt  time.Time       `db:"created_at" json:"created_at"`
+}
+
+// ProviderAuditor periodically audits every provider with
+// ProviderValidator.Audit, stores the results in provider_audits, and
+// exports failing providers to Prometheus.
+type ProviderAuditor struct {
+	db        *sqlx.DB
+	store     provider.ProfileStorer
+	validator *ProviderValidator
+
+	failing      *prometheus.GaugeVec
+	failingTotal prometheus.Gauge
+	lastRun      prometheus.Gauge
+}
+
+// NewProviderAuditor creates a new provider auditor
+func NewProviderAuditor(db *sqlx.DB, store provider.ProfileStorer, validator *ProviderValidator) *ProviderAuditor {
+	a := &ProviderAuditor{
+		db:        db,
+		store:     store,
+		validator: validator,
+		failing: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "provider_audit_failing",
+			Help: "1 if the provider failed its most recent audit, 0 otherwise",
+		}, []string{"provider"}),
+		failingTotal: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "provider_audit_failing_providers",
+			Help: "Number of providers that failed the most recent audit run",
+		}),
+		lastRun: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "provider_audit_last_run_timestamp_seconds",
+			Help: "Unix time the last provider audit run completed",
+		}),
+	}
+	a.failing = registerCollector(a.failing).(*prometheus.GaugeVec)
+	a.failingTotal = registerCollector(a.failingTotal).(prometheus.Gauge)
+	a.lastRun = registerCollector(a.lastRun).(prometheus.Gauge)
+	return a
+}
+
+// registerCollector registers c, returning the already registered collector
+// when an identical one exists so repeated construction in tests works.
+func registerCollector(c prometheus.Collector) prometheus.Collector {
+	if err := prometheus.Register(c); err != nil {
+		if are, ok := err.(prometheus.AlreadyRegisteredError); ok {
+			return are.ExistingCollector
+		}
+		panic(err)
+	}
+	return c
+}
+
+// Start audits all providers immediately and then every interval until ctx
+// is cancelled.
+func (a *ProviderAuditor) Start(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if failed, err := a.RunOnce(ctx); err != nil {
+			log.Printf("provider audit failed: %v", err)
+		} else if failed > 0 {
+			log.Printf("provider audit: %d providers failing", failed)
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// RunOnce audits every provider, records the results, and returns how many
+// providers failed.
+func (a *ProviderAuditor) RunOnce(ctx context.Context) (int, error) {
+	profiles, err := a.store.ListProfiles(provider.AllWorkspaces)
+	if err != nil {
+		return 0, err
+	}
+
+	// Reset so deleted providers stop being reported.
+	a.failing.Reset()
+	failed := 0
+	for _, item := range profiles {
+		if ctx.Err() != nil {
+			return failed, ctx.Err()
+		}
+		id, err := uuid.Parse(item.ID)
+		if err != nil {
+			continue
+		}
+		p, err := a.store.GetProfile(id)
+		if err != nil {
+			log.Printf("provider audit: load %s: %v", item.Name, err)
+			continue
+		}
+
+		report := a.validator.Audit(ctx, p)
+		status := report.Status()
+		checks, _ := json.Marshal(report.Checks)
+		if _, err := a.db.ExecContext(ctx,
+			`INSERT INTO provider_audits (provider_id, status, checks) VALUES ($1, $2, $3)`,
+			p.ID, status, checks); err != nil {
+			log.Printf("provider audit: store result for %s: %v", p.Name, err)
+		}
+
+		if status == CheckFail {
+			failed++
+			a.failing.WithLabelValues(p.Name).Set(1)
+		} else {
+			a.failing.WithLabelValues(p.Name).Set(0)
+		}
+	}
+
+	if _, err := a.db.ExecContext(ctx,
+		`DELETE FROM provider_audits WHERE created_at < $1`,
+		time.Now().Add(-providerAuditRetention)); err != nil {
+		log.Printf("provider audit: prune old results: %v", err)
+	}
+
+	a.failingTotal.Set(float64(failed))
+	a.lastRun.SetToCurrentTime()
+	return failed, nil
+}
+
+// Get handles GET /providers/{id}/audit to return the most recent audit
+// results for a provider, newest first (limit defaults to 10, max 100).
+func (a *ProviderAuditor) Get(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		httputil.WriteError(w, http.StatusBadRequest, "invalid_provider_id", "Invalid provider ID")
+		return
+	}
+
+	limit := 10
+	if v := r.URL.Query().Get("limit"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 && n <= 100 {
+			limit = n
+		}
+	}
+
+	var audits []ProviderAudit
+	if err := a.db.Select(&audits, `
+		SELECT provider_id, status, checks, created_at
+		FROM provider_audits WHERE provider_id = $1
+		ORDER BY created_at DESC LIMIT $2`, id, limit); err != nil {
+		httputil.WriteError(w, http.StatusInternalServerError, "query_failed", "Failed to query provider audits")
+		return
+	}
+	if audits == nil {
+		audits = []ProviderAudit{}
+	}
+
+	httputil.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"provider_id": id,
+		"audits":      audits,
+	})
+}
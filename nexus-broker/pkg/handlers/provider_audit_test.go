@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/provider"
+)
+
+func TestProviderAuditor_RunOnce(t *testing.T) {
+	idp := newFakeIDP(t, false)
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	healthyID, brokenID := uuid.New(), uuid.New()
+	store := new(MockStore)
+	store.On("ListProfiles", provider.AllWorkspaces).Return([]provider.ProfileList{
+		{ID: healthyID.String(), Name: "healthy"},
+		{ID: brokenID.String(), Name: "broken"},
+	}, nil)
+	store.On("GetProfile", healthyID).Return(&provider.Profile{
+		ID: healthyID, Name: "healthy", AuthType: "oauth2", Scopes: []string{"read"},
+		AuthURL: ptr(idp.URL + "/authorize"), TokenURL: ptr(idp.URL + "/token"),
+	}, nil)
+	store.On("GetProfile", brokenID).Return(&provider.Profile{
+		ID: brokenID, Name: "broken", AuthType: "oauth2", Scopes: []string{"read"},
+		AuthURL: ptr(idp.URL + "/authorize"), TokenURL: ptr(idp.URL + "/missing"),
+	}, nil)
+
+	mock.ExpectExec("INSERT INTO provider_audits").
+		WithArgs(healthyID, CheckPass, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO provider_audits").
+		WithArgs(brokenID, CheckFail, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("DELETE FROM provider_audits WHERE created_at <").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	auditor := NewProviderAuditor(sqlx.NewDb(db, "sqlmock"), store, newTestValidator("http://localhost:8080"))
+	failed, err := auditor.RunOnce(context.Background())
+
+	require.NoError(t, err)
+	assert.Equal(t, 1, failed)
+	assert.Equal(t, 1.0, testutil.ToFloat64(auditor.failing.WithLabelValues("broken")))
+	assert.Equal(t, 0.0, testutil.ToFloat64(auditor.failing.WithLabelValues("healthy")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(auditor.failingTotal))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestProviderAuditor_Get(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	id := uuid.New()
+	mock.ExpectQuery("SELECT provider_id, status, checks, created_at FROM provider_audits WHERE provider_id = \\$1").
+		WithArgs(id, 10).
+		WillReturnRows(sqlmock.NewRows([]string{"provider_id", "status", "checks", "created_at"}).
+			AddRow(id.String(), CheckFail, []byte(`[{"name":"token_endpoint","status":"fail","message":"HTTP 404"}]`), time.Now()))
+
+	auditor := NewProviderAuditor(sqlx.NewDb(db, "sqlmock"), new(MockStore), newTestValidator("http://localhost:8080"))
+	req := httptest.NewRequest(http.MethodGet, "/providers/"+id.String()+"/audit", nil)
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("id", id.String())
+	req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+	rr := httptest.NewRecorder()
+
+	auditor.Get(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"status":"fail"`)
+	assert.Contains(t, rr.Body.String(), `"name":"token_endpoint"`)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -2,12 +2,16 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"strconv"
 	"strings"
 
 	"github.com/Prescott-Data/nexus-framework/nexus-broker/internal/audit"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/httpclient"
 	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/httputil"
 	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/provider"
 
@@ -17,13 +21,53 @@ import (
 
 // ProvidersHandler handles provider-related HTTP requests
 type ProvidersHandler struct {
-	store provider.ProfileStorer
-	audit audit.Logger
+	store     provider.ProfileStorer
+	audit     audit.Logger
+	guard     *httpclient.AddressGuard
+	registrar *ClientRegistrar
 }
 
-// NewProvidersHandler creates a new providers handler
-func NewProvidersHandler(store provider.ProfileStorer, auditSvc audit.Logger) *ProvidersHandler {
-	return &ProvidersHandler{store: store, audit: auditSvc}
+// NewProvidersHandler creates a new providers handler. guard, if set,
+// rejects provider URLs that resolve to private, loopback or link-local
+// addresses.
+func NewProvidersHandler(store provider.ProfileStorer, auditSvc audit.Logger, guard *httpclient.AddressGuard) *ProvidersHandler {
+	return &ProvidersHandler{store: store, audit: auditSvc, guard: guard}
+}
+
+// WithRegistrar lets POST /providers register the provider's OAuth client
+// with Dynamic Client Registration when the body asks for it. It returns h.
+func (h *ProvidersHandler) WithRegistrar(registrar *ClientRegistrar) *ProvidersHandler {
+	h.registrar = registrar
+	return h
+}
+
+// urlFields are the profile fields the broker sends requests to.
+var urlFields = []string{"auth_url", "token_url", "issuer", "api_base_url"}
+
+// checkURLs validates the endpoint URLs of a profile, given as field name
+// to value, and writes a 400 unsafe_provider_url for the first one the
+// guard rejects. It reports whether all passed.
+func (h *ProvidersHandler) checkURLs(w http.ResponseWriter, r *http.Request, urls map[string]string) bool {
+	if h.guard == nil {
+		return true
+	}
+	for _, field := range urlFields {
+		if err := h.guard.ValidateURL(r.Context(), urls[field]); err != nil {
+			httputil.WriteError(w, http.StatusBadRequest, "unsafe_provider_url", field+": "+err.Error())
+			return false
+		}
+	}
+	return true
+}
+
+// profileURLs returns the endpoint URLs of p keyed by field name.
+func profileURLs(p *provider.Profile) map[string]string {
+	return map[string]string{
+		"auth_url":     derefString(p.AuthURL),
+		"token_url":    derefString(p.TokenURL),
+		"issuer":       derefString(p.Issuer),
+		"api_base_url": p.APIBaseURL,
+	}
 }
 
 // Get handles GET /providers/{id} to retrieve a provider profile
@@ -39,10 +83,31 @@ func (h *ProvidersHandler) Get(w http.ResponseWriter, r *http.Request) {
 		httputil.WriteError(w, http.StatusNotFound, "provider_not_found", "Provider not found")
 		return
 	}
-	httputil.WriteJSON(w, http.StatusOK, profile)
+	w.Header().Set("ETag", profile.ETag())
+	httputil.WriteJSON(w, http.StatusOK, profile.Public())
+}
+
+// writeUpdateError answers a failed UpdateProfile or PatchProfile. fallback
+// is the code of unexpected failures.
+func writeUpdateError(w http.ResponseWriter, err error, fallback string) {
+	switch {
+	case errors.Is(err, provider.ErrNotFound):
+		httputil.WriteError(w, http.StatusNotFound, "provider_not_found", "Provider not found")
+	case errors.Is(err, provider.ErrPreconditionFailed):
+		httputil.WriteError(w, http.StatusPreconditionFailed, "precondition_failed", "Provider was modified since it was read; fetch it again and retry")
+	case errors.Is(err, provider.ErrNameTaken):
+		httputil.WriteError(w, http.StatusConflict, "provider_name_taken", "Another active provider uses this name")
+	case errors.Is(err, provider.ErrInvalidProfile):
+		httputil.WriteError(w, http.StatusBadRequest, "invalid_profile", err.Error())
+	default:
+		httputil.WriteError(w, http.StatusInternalServerError, fallback, "Failed to update provider profile")
+	}
 }
 
-// Update handles PUT /providers/{id} to update a provider profile
+// Update handles PUT /providers/{id} to replace a provider profile. The
+// stored client secret is kept when the body omits client_secret and
+// cleared when it is null. An If-Match header makes the update conditional
+// on the profile's ETag.
 func (h *ProvidersHandler) Update(w http.ResponseWriter, r *http.Request) {
 	idStr := chi.URLParam(r, "id")
 	id, err := uuid.Parse(idStr)
@@ -52,15 +117,30 @@ func (h *ProvidersHandler) Update(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var profile provider.Profile
-	if err := json.NewDecoder(r.Body).Decode(&profile); err != nil {
+	var members map[string]json.RawMessage
+	body, err := io.ReadAll(r.Body)
+	if err == nil {
+		err = json.Unmarshal(body, &members)
+	}
+	if err == nil {
+		err = json.Unmarshal(body, &profile)
+	}
+	if err != nil {
 		httputil.WriteError(w, http.StatusBadRequest, "invalid_json", "Invalid JSON")
 		return
 	}
+	if secret, ok := members["client_secret"]; ok && string(secret) == "null" {
+		cleared := ""
+		profile.ClientSecret = &cleared
+	}
 
 	profile.ID = id
+	if !h.checkURLs(w, r, profileURLs(&profile)) {
+		return
+	}
 
-	if err := h.store.UpdateProfile(&profile); err != nil {
-		httputil.WriteError(w, http.StatusInternalServerError, "update_failed", "Failed to update provider profile")
+	if err := h.store.UpdateProfile(&profile, r.Header.Get("If-Match")); err != nil {
+		writeUpdateError(w, err, "update_failed")
 		return
 	}
 
@@ -70,10 +150,13 @@ func (h *ProvidersHandler) Update(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	w.Header().Set("ETag", profile.ETag())
 	w.WriteHeader(http.StatusOK)
 }
 
-// Patch handles PATCH /providers/{id} to update specific fields of a provider profile
+// Patch handles PATCH /providers/{id}, applying a JSON merge patch to a
+// provider profile. An If-Match header makes it conditional on the
+// profile's ETag.
 func (h *ProvidersHandler) Patch(w http.ResponseWriter, r *http.Request) {
 	idStr := chi.URLParam(r, "id")
 	id, err := uuid.Parse(idStr)
@@ -88,8 +171,19 @@ func (h *ProvidersHandler) Patch(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := h.store.PatchProfile(id, updates); err != nil {
-		httputil.WriteError(w, http.StatusInternalServerError, "patch_failed", "Failed to patch provider profile")
+	urls := map[string]string{}
+	for _, field := range urlFields {
+		if v, ok := updates[field].(string); ok {
+			urls[field] = v
+		}
+	}
+	if !h.checkURLs(w, r, urls) {
+		return
+	}
+
+	patched, err := h.store.PatchProfile(id, updates, r.Header.Get("If-Match"))
+	if err != nil {
+		writeUpdateError(w, err, "patch_failed")
 		return
 	}
 
@@ -109,6 +203,7 @@ func (h *ProvidersHandler) Patch(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	w.Header().Set("ETag", patched.ETag())
 	w.WriteHeader(http.StatusOK)
 }
 
@@ -135,49 +230,115 @@ func (h *ProvidersHandler) Delete(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
-// Register handles POST /providers for registering a new provider profile
+// Restore handles POST /providers/{id}/restore to undo a soft delete
+func (h *ProvidersHandler) Restore(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		httputil.WriteError(w, http.StatusBadRequest, "invalid_provider_id", "Invalid provider ID")
+		return
+	}
+
+	switch err := h.store.RestoreProfile(id); {
+	case errors.Is(err, provider.ErrNotFound):
+		httputil.WriteError(w, http.StatusNotFound, "provider_not_found", "Provider not found")
+		return
+	case errors.Is(err, provider.ErrNotDeleted):
+		httputil.WriteError(w, http.StatusConflict, "provider_not_deleted", "Provider is not deleted")
+		return
+	case errors.Is(err, provider.ErrNameTaken):
+		httputil.WriteError(w, http.StatusConflict, "provider_name_taken", "Another active provider uses this name")
+		return
+	case err != nil:
+		httputil.WriteError(w, http.StatusInternalServerError, "restore_failed", "Failed to restore provider profile")
+		return
+	}
+
+	if h.audit != nil {
+		if err := h.audit.Log("provider.restored", nil, map[string]interface{}{"provider_id": id.String()}, r); err != nil {
+			log.Printf("audit: failed to log provider.restored for provider_id=%v: %v", id, err)
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// Purge handles DELETE /providers/{id}/purge to permanently remove a
+// soft-deleted provider. It is refused while the provider has live connections.
+func (h *ProvidersHandler) Purge(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		httputil.WriteError(w, http.StatusBadRequest, "invalid_provider_id", "Invalid provider ID")
+		return
+	}
+
+	connections, err := h.store.PurgeProfile(id)
+	var inUse *provider.ConnectionsInUseError
+	switch {
+	case errors.Is(err, provider.ErrNotFound):
+		httputil.WriteError(w, http.StatusNotFound, "provider_not_found", "Provider not found")
+		return
+	case errors.Is(err, provider.ErrNotDeleted):
+		httputil.WriteError(w, http.StatusConflict, "provider_not_deleted", "Provider must be deleted before it can be purged")
+		return
+	case errors.As(err, &inUse):
+		httputil.WriteError(w, http.StatusConflict, "provider_has_connections",
+			fmt.Sprintf("Provider has %d pending, active or needs_reauth connection(s)", inUse.Count))
+		return
+	case err != nil:
+		httputil.WriteError(w, http.StatusInternalServerError, "purge_failed", "Failed to purge provider profile")
+		return
+	}
+
+	if h.audit != nil {
+		if err := h.audit.Log("provider.purged", nil, map[string]interface{}{"provider_id": id.String(), "connections_deleted": connections}, r); err != nil {
+			log.Printf("audit: failed to log provider.purged for provider_id=%v: %v", id, err)
+		}
+	}
+
+	httputil.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"id":                  id,
+		"connections_deleted": connections,
+	})
+}
+
+// Register handles POST /providers for registering a new provider profile.
+// With a "registration" member the provider's OAuth client is registered
+// first with Dynamic Client Registration (see ClientRegistrar).
 func (h *ProvidersHandler) Register(w http.ResponseWriter, r *http.Request) {
 	var request struct {
-		Profile json.RawMessage `json:"profile"`
+		Profile      json.RawMessage            `json:"profile"`
+		Registration *ClientRegistrationRequest `json:"registration"`
 	}
 
 	// Decode request
 	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
-		httputil.WriteJSON(w, http.StatusBadRequest, map[string]string{
-			"error":   "invalid_json",
-			"message": "Invalid JSON payload",
-		})
+		httputil.WriteError(w, http.StatusBadRequest, "invalid_json", "Invalid JSON payload")
 		return
 	}
 
 	if request.Profile == nil {
-		httputil.WriteJSON(w, http.StatusBadRequest, map[string]string{
-			"error":   "missing_profile",
-			"message": "Missing 'profile' key in JSON",
-		})
+		httputil.WriteError(w, http.StatusBadRequest, "missing_profile", "Missing 'profile' key in JSON")
+		return
+	}
+
+	if request.Registration != nil {
+		if h.registrar == nil {
+			httputil.WriteError(w, http.StatusBadRequest, "invalid_request", "Dynamic client registration is not enabled")
+			return
+		}
+		h.registrar.register(w, r, request.Profile, request.Registration)
+		return
+	}
+
+	// Invalid profiles are left for RegisterProfile to report.
+	if parsed, err := provider.ParseProfile(string(request.Profile)); err == nil && !h.checkURLs(w, r, profileURLs(parsed)) {
 		return
 	}
 
 	// Register the profile using the store
 	profile, err := h.store.RegisterProfile(string(request.Profile))
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-
-		// Default error key
-		errorKey := "provider_creation_failed"
-
-		// Use error prefix from store if present
-		if strings.Contains(err.Error(), "name:") || strings.Contains(err.Error(), "invalid provider name") {
-			errorKey = "invalid_provider_name"
-		} else if strings.Contains(err.Error(), "missing required field") {
-			field := strings.Split(err.Error(), ":")[1]
-			errorKey = "missing_" + strings.TrimSpace(field)
-		}
-
-		httputil.WriteJSON(w, http.StatusBadRequest, map[string]string{
-			"error":   errorKey,
-			"message": err.Error(),
-		})
+		writeRegisterError(w, err)
 		return
 	}
 
@@ -193,9 +354,37 @@ func (h *ProvidersHandler) Register(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-// List handles GET /providers to list provider ids and names
+// writeRegisterError answers a failed profile registration with a 400 whose
+// code names the offending field where the store's error does.
+func writeRegisterError(w http.ResponseWriter, err error) {
+	// Default error key
+	errorKey := "provider_creation_failed"
+
+	// Use error prefix from store if present
+	if strings.Contains(err.Error(), "name:") || strings.Contains(err.Error(), "invalid provider name") {
+		errorKey = "invalid_provider_name"
+	} else if strings.Contains(err.Error(), "missing required field") {
+		field := strings.Split(err.Error(), ":")[1]
+		errorKey = "missing_" + strings.TrimSpace(field)
+	}
+
+	httputil.WriteError(w, http.StatusBadRequest, errorKey, err.Error())
+}
+
+// List handles GET /providers to list provider ids and names. It lists the
+// global providers and those restricted to the workspace_id query parameter;
+// all_workspaces=true lists every provider instead. include_deleted=true also
+// lists soft-deleted providers with their deleted_at.
 func (h *ProvidersHandler) List(w http.ResponseWriter, r *http.Request) {
-	rows, err := h.store.ListProfiles()
+	workspaceID, ok := providerWorkspace(w, r)
+	if !ok {
+		return
+	}
+	list := h.store.ListProfiles
+	if includeDeleted, _ := strconv.ParseBool(r.URL.Query().Get("include_deleted")); includeDeleted {
+		list = h.store.ListProfilesWithDeleted
+	}
+	rows, err := list(workspaceID)
 	if err != nil {
 		httputil.WriteError(w, http.StatusInternalServerError, "list_failed", "Failed to list providers")
 		return
@@ -203,24 +392,57 @@ func (h *ProvidersHandler) List(w http.ResponseWriter, r *http.Request) {
 	httputil.WriteJSON(w, http.StatusOK, rows)
 }
 
-// GetByName handles GET /providers/by-name/{name}
+// providerWorkspace returns the workspace whose providers a request lists:
+// the workspace_id query parameter, or provider.AllWorkspaces for
+// all_workspaces=true. Without either only global providers are visible.
+func providerWorkspace(w http.ResponseWriter, r *http.Request) (string, bool) {
+	q := r.URL.Query()
+	workspaceID := strings.TrimSpace(q.Get("workspace_id"))
+	all, _ := strconv.ParseBool(q.Get("all_workspaces"))
+	switch {
+	case workspaceID == provider.AllWorkspaces || (all && workspaceID != ""):
+		httputil.WriteError(w, http.StatusBadRequest, "invalid_workspace_id", "workspace_id must name one workspace, without all_workspaces")
+		return "", false
+	case all:
+		return provider.AllWorkspaces, true
+	}
+	return workspaceID, true
+}
+
+// GetByName handles GET /providers/by-name/{name}. Like List, it finds only
+// providers visible to the workspace_id or all_workspaces query parameters.
 func (h *ProvidersHandler) GetByName(w http.ResponseWriter, r *http.Request) {
 	name := chi.URLParam(r, "name")
 	if name == "" {
 		httputil.WriteError(w, http.StatusBadRequest, "missing_name", "missing name")
 		return
 	}
+	workspaceID, ok := providerWorkspace(w, r)
+	if !ok {
+		return
+	}
+	profile, err := h.ProviderByName(name, workspaceID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	httputil.WriteJSON(w, http.StatusOK, map[string]string{"id": profile.ID.String()})
+}
 
+// ProviderByName returns the provider called name, case-insensitively, if
+// it is visible to workspaceID. Failures are *Error.
+func (h *ProvidersHandler) ProviderByName(name, workspaceID string) (*provider.Profile, error) {
 	// Normalize to lowercase
 	name = strings.ToLower(strings.TrimSpace(name))
 
 	profile, err := h.store.GetProfileByName(name)
 	if err != nil {
-		httputil.WriteError(w, http.StatusNotFound, "provider_not_found", err.Error())
-		return
+		return nil, &Error{Status: http.StatusNotFound, Code: "provider_not_found", Message: err.Error()}
 	}
-
-	httputil.WriteJSON(w, http.StatusOK, map[string]string{"id": profile.ID.String()})
+	if !profile.VisibleTo(workspaceID) {
+		return nil, &Error{Status: http.StatusNotFound, Code: "provider_not_found", Message: fmt.Sprintf("provider '%s' not found", name)}
+	}
+	return profile, nil
 }
 
 // DeleteByName handles DELETE /providers/by-name/{name} to delete ALL providers with that name
@@ -251,12 +473,27 @@ func (h *ProvidersHandler) DeleteByName(w http.ResponseWriter, r *http.Request)
 	httputil.WriteJSON(w, http.StatusOK, map[string]string{"message": fmt.Sprintf("Deleted %d provider(s)", rowsAffected)})
 }
 
-// Metadata handles GET /providers/metadata to retrieve grouped integration config
+// Metadata handles GET /providers/metadata to retrieve grouped integration config,
+// filtered by the workspace_id and all_workspaces query parameters like List.
 func (h *ProvidersHandler) Metadata(w http.ResponseWriter, r *http.Request) {
-	metadata, err := h.store.GetMetadata()
+	workspaceID, ok := providerWorkspace(w, r)
+	if !ok {
+		return
+	}
+	metadata, err := h.ProviderMetadata(workspaceID)
 	if err != nil {
-		httputil.WriteError(w, http.StatusInternalServerError, "metadata_failed", "Failed to retrieve metadata")
+		writeError(w, err)
 		return
 	}
 	httputil.WriteJSON(w, http.StatusOK, metadata)
 }
+
+// ProviderMetadata returns the metadata of the providers visible to
+// workspaceID, grouped by auth type and then by name. Failures are *Error.
+func (h *ProvidersHandler) ProviderMetadata(workspaceID string) (map[string]map[string]provider.Metadata, error) {
+	metadata, err := h.store.GetMetadata(workspaceID)
+	if err != nil {
+		return nil, &Error{Status: http.StatusInternalServerError, Code: "metadata_failed", Message: "Failed to retrieve metadata"}
+	}
+	return metadata, nil
+}
@@ -5,14 +5,17 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/httpclient"
 	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/provider"
 
 	"github.com/go-chi/chi/v5"
@@ -47,14 +50,17 @@ func (m *MockStore) GetProfileByName(name string) (*provider.Profile, error) {
 	return args.Get(0).(*provider.Profile), args.Error(1)
 }
 
-func (m *MockStore) UpdateProfile(p *provider.Profile) error {
-	args := m.Called(p)
+func (m *MockStore) UpdateProfile(p *provider.Profile, ifMatch string) error {
+	args := m.Called(p, ifMatch)
 	return args.Error(0)
 }
 
-func (m *MockStore) PatchProfile(id uuid.UUID, updates map[string]interface{}) error {
-	args := m.Called(id, updates)
-	return args.Error(0)
+func (m *MockStore) PatchProfile(id uuid.UUID, patch map[string]interface{}, ifMatch string) (*provider.Profile, error) {
+	args := m.Called(id, patch, ifMatch)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*provider.Profile), args.Error(1)
 }
 
 func (m *MockStore) DeleteProfile(id uuid.UUID) error {
@@ -67,20 +73,38 @@ func (m *MockStore) DeleteProfileByName(name string) (int64, error) {
 	return args.Get(0).(int64), args.Error(1)
 }
 
-func (m *MockStore) ListProfiles() ([]provider.ProfileList, error) {
-	args := m.Called()
+func (m *MockStore) ListProfiles(workspaceID string) ([]provider.ProfileList, error) {
+	args := m.Called(workspaceID)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
 	}
 	return args.Get(0).([]provider.ProfileList), args.Error(1)
 }
 
-func (m *MockStore) GetMetadata() (map[string]map[string]interface{}, error) {
-	args := m.Called()
+func (m *MockStore) ListProfilesWithDeleted(workspaceID string) ([]provider.ProfileList, error) {
+	args := m.Called(workspaceID)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
 	}
-	return args.Get(0).(map[string]map[string]interface{}), args.Error(1)
+	return args.Get(0).([]provider.ProfileList), args.Error(1)
+}
+
+func (m *MockStore) RestoreProfile(id uuid.UUID) error {
+	args := m.Called(id)
+	return args.Error(0)
+}
+
+func (m *MockStore) PurgeProfile(id uuid.UUID) (int64, error) {
+	args := m.Called(id)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockStore) GetMetadata(workspaceID string) (map[string]map[string]provider.Metadata, error) {
+	args := m.Called(workspaceID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(map[string]map[string]provider.Metadata), args.Error(1)
 }
 
 func ptr(s string) *string {
@@ -90,7 +114,7 @@ func ptr(s string) *string {
 func TestRegisterProvider_Success(t *testing.T) {
 	// 1. Mocks the provider.Store.
 	mockStore := new(MockStore)
-	handler := NewProvidersHandler(mockStore, nil)
+	handler := NewProvidersHandler(mockStore, nil, nil)
 
 	// 2. Mocks the store.RegisterProfile method to return a valid Profile.
 	expectedProfile := &provider.Profile{
@@ -145,7 +169,7 @@ func TestRegisterProvider_Success(t *testing.T) {
 func TestRegisterProvider_StoreError(t *testing.T) {
 	// 1. Mocks the provider.Store.
 	mockStore := new(MockStore)
-	handler := NewProvidersHandler(mockStore, nil)
+	handler := NewProvidersHandler(mockStore, nil, nil)
 
 	// 2. Mocks the store.RegisterProfile method to return an error.
 	expectedError := errors.New("validation failed")
@@ -173,7 +197,7 @@ func TestRegisterProvider_StoreError(t *testing.T) {
 func TestRegisterProvider_InvalidJSON(t *testing.T) {
 	// 1. Mocks the provider.Store.
 	mockStore := new(MockStore)
-	handler := NewProvidersHandler(mockStore, nil)
+	handler := NewProvidersHandler(mockStore, nil, nil)
 
 	// 2. Sends a POST request with invalid JSON.
 	req, err := http.NewRequest("POST", "/providers", bytes.NewReader([]byte("invalid json")))
@@ -191,6 +215,171 @@ func TestRegisterProvider_InvalidJSON(t *testing.T) {
 	assert.Equal(t, http.StatusBadRequest, rr.Code)
 }
 
+func TestRegisterProvider_RejectsInternalURLs(t *testing.T) {
+	mockStore := new(MockStore)
+	guard, err := httpclient.NewAddressGuard(false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := NewProvidersHandler(mockStore, nil, guard)
+
+	body := map[string]interface{}{"profile": map[string]interface{}{
+		"name":             "metadata",
+		"client_id":        "id",
+		"client_secret":    "secret",
+		"issuer":           "http://169.254.169.254/latest",
+		"enable_discovery": true,
+		"scopes":           []string{"openid"},
+	}}
+	jsonBody, _ := json.Marshal(body)
+	req, _ := http.NewRequest("POST", "/providers", bytes.NewReader(jsonBody))
+
+	rr := httptest.NewRecorder()
+	handler.Register(rr, req)
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), "unsafe_provider_url")
+	assert.Contains(t, rr.Body.String(), "issuer")
+	mockStore.AssertNotCalled(t, "RegisterProfile", mock.Anything)
+}
+
+func TestPatchProvider_RejectsInternalURLs(t *testing.T) {
+	mockStore := new(MockStore)
+	guard, err := httpclient.NewAddressGuard(false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := NewProvidersHandler(mockStore, nil, guard)
+
+	testID := uuid.New()
+	jsonBody, _ := json.Marshal(map[string]interface{}{"token_url": "http://10.0.0.8/token"})
+	req, _ := http.NewRequest("PATCH", "/providers/"+testID.String(), bytes.NewReader(jsonBody))
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("id", testID.String())
+	req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+
+	rr := httptest.NewRecorder()
+	handler.Patch(rr, req)
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), "unsafe_provider_url")
+	mockStore.AssertNotCalled(t, "PatchProfile", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestPatchProvider_ForwardsIfMatchAndReturnsETag(t *testing.T) {
+	mockStore := new(MockStore)
+	handler := NewProvidersHandler(mockStore, nil, nil)
+
+	testID := uuid.New()
+	patched := &provider.Profile{ID: testID, UpdatedAt: time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)}
+	mockStore.On("PatchProfile", testID, map[string]interface{}{"description": nil}, `"abc"`).Return(patched, nil)
+
+	req, _ := http.NewRequest("PATCH", "/providers/"+testID.String(), bytes.NewReader([]byte(`{"description": null}`)))
+	req.Header.Set("If-Match", `"abc"`)
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("id", testID.String())
+	req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+
+	rr := httptest.NewRecorder()
+	handler.Patch(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, patched.ETag(), rr.Header().Get("ETag"))
+	mockStore.AssertExpectations(t)
+}
+
+func TestUpdateProvider_PreconditionFailed(t *testing.T) {
+	mockStore := new(MockStore)
+	handler := NewProvidersHandler(mockStore, nil, nil)
+
+	testID := uuid.New()
+	mockStore.On("UpdateProfile", mock.AnythingOfType("*provider.Profile"), `"stale"`).Return(provider.ErrPreconditionFailed)
+
+	req, _ := http.NewRequest("PUT", "/providers/"+testID.String(), bytes.NewReader([]byte(`{"name": "acme"}`)))
+	req.Header.Set("If-Match", `"stale"`)
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("id", testID.String())
+	req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+
+	rr := httptest.NewRecorder()
+	handler.Update(rr, req)
+
+	assert.Equal(t, http.StatusPreconditionFailed, rr.Code)
+	assert.Contains(t, rr.Body.String(), "precondition_failed")
+}
+
+func TestPatchProvider_InvalidResult(t *testing.T) {
+	mockStore := new(MockStore)
+	handler := NewProvidersHandler(mockStore, nil, nil)
+
+	testID := uuid.New()
+	mockStore.On("PatchProfile", testID, mock.Anything, "").Return(nil, fmt.Errorf("%w: name: missing required field", provider.ErrInvalidProfile))
+
+	req, _ := http.NewRequest("PATCH", "/providers/"+testID.String(), bytes.NewReader([]byte(`{"name": null}`)))
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("id", testID.String())
+	req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+
+	rr := httptest.NewRecorder()
+	handler.Patch(rr, req)
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), "invalid_profile")
+	assert.Contains(t, rr.Body.String(), "name: missing required field")
+}
+
+func TestGetProvider_OmitsClientSecret(t *testing.T) {
+	mockStore := new(MockStore)
+	handler := NewProvidersHandler(mockStore, nil, nil)
+
+	testID := uuid.New()
+	mockStore.On("GetProfile", testID).Return(&provider.Profile{ID: testID, Name: "acme", ClientID: ptr("c"), ClientSecret: ptr("s3cret")}, nil)
+
+	req, _ := http.NewRequest("GET", "/providers/"+testID.String(), nil)
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("id", testID.String())
+	req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+
+	rr := httptest.NewRecorder()
+	handler.Get(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.NotContains(t, rr.Body.String(), "s3cret")
+	var body map[string]interface{}
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &body))
+	assert.Equal(t, true, body["client_secret_set"])
+	assert.NotContains(t, body, "client_secret")
+}
+
+func TestUpdateProvider_ClientSecretNullClearsOmittedKeeps(t *testing.T) {
+	testID := uuid.New()
+	for body, want := range map[string]*string{
+		`{"name": "acme", "client_secret": null}`:  ptr(""),
+		`{"name": "acme"}`:                         nil,
+		`{"name": "acme", "client_secret": "new"}`: ptr("new"),
+	} {
+		mockStore := new(MockStore)
+		handler := NewProvidersHandler(mockStore, nil, nil)
+		mockStore.On("UpdateProfile", mock.MatchedBy(func(p *provider.Profile) bool {
+			if want == nil {
+				return p.ClientSecret == nil
+			}
+			return p.ClientSecret != nil && *p.ClientSecret == *want
+		}), "").Return(nil)
+
+		req, _ := http.NewRequest("PUT", "/providers/"+testID.String(), bytes.NewReader([]byte(body)))
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", testID.String())
+		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+
+		rr := httptest.NewRecorder()
+		handler.Update(rr, req)
+
+		assert.Equal(t, http.StatusOK, rr.Code, body)
+		mockStore.AssertExpectations(t)
+	}
+}
+
 // --- Audit mock ---
 
 // MockAuditLogger is a mock implementation of the audit.Logger interface.
@@ -206,7 +395,7 @@ func (m *MockAuditLogger) Log(eventType string, connectionID *uuid.UUID, data ma
 func TestRegisterProvider_AuditsCreation(t *testing.T) {
 	mockStore := new(MockStore)
 	mockAudit := new(MockAuditLogger)
-	handler := NewProvidersHandler(mockStore, mockAudit)
+	handler := NewProvidersHandler(mockStore, mockAudit, nil)
 
 	expectedProfile := &provider.Profile{
 		ID:       uuid.New(),
@@ -231,10 +420,10 @@ func TestRegisterProvider_AuditsCreation(t *testing.T) {
 func TestPatchProvider_AuditRedactsSecrets(t *testing.T) {
 	mockStore := new(MockStore)
 	mockAudit := new(MockAuditLogger)
-	handler := NewProvidersHandler(mockStore, mockAudit)
+	handler := NewProvidersHandler(mockStore, mockAudit, nil)
 
 	testID := uuid.New()
-	mockStore.On("PatchProfile", testID, mock.AnythingOfType("map[string]interface {}")).Return(nil)
+	mockStore.On("PatchProfile", testID, mock.AnythingOfType("map[string]interface {}"), "").Return(&provider.Profile{ID: testID}, nil)
 	mockAudit.On("Log", "provider.updated", (*uuid.UUID)(nil), mock.AnythingOfType("map[string]interface {}"), mock.AnythingOfType("*http.Request")).Return(nil)
 
 	updates := map[string]interface{}{
@@ -270,3 +459,209 @@ func TestPatchProvider_AuditRedactsSecrets(t *testing.T) {
 		return true
 	}), mock.AnythingOfType("*http.Request"))
 }
+
+func TestListProviders_FiltersByWorkspace(t *testing.T) {
+	mockStore := new(MockStore)
+	handler := NewProvidersHandler(mockStore, nil, nil)
+
+	visible := []provider.ProfileList{{ID: uuid.NewString(), Name: "google"}}
+	mockStore.On("ListProfiles", "ws-a").Return(visible, nil)
+
+	req, _ := http.NewRequest("GET", "/providers?workspace_id=ws-a", nil)
+	rr := httptest.NewRecorder()
+	handler.List(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var got []provider.ProfileList
+	assert.NoError(t, json.NewDecoder(rr.Body).Decode(&got))
+	assert.Equal(t, visible, got)
+	mockStore.AssertExpectations(t)
+}
+
+func TestMetadata_FiltersByWorkspace(t *testing.T) {
+	mockStore := new(MockStore)
+	handler := NewProvidersHandler(mockStore, nil, nil)
+
+	mockStore.On("GetMetadata", "ws-b").Return(map[string]map[string]provider.Metadata{}, nil)
+
+	req, _ := http.NewRequest("GET", "/providers/metadata?workspace_id=ws-b", nil)
+	rr := httptest.NewRecorder()
+	handler.Metadata(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	mockStore.AssertExpectations(t)
+}
+
+func TestListProviders_IncludeDeleted(t *testing.T) {
+	mockStore := new(MockStore)
+	handler := NewProvidersHandler(mockStore, nil, nil)
+
+	deletedAt := time.Now().UTC().Truncate(time.Second)
+	all := []provider.ProfileList{{ID: uuid.NewString(), Name: "google", DeletedAt: &deletedAt}}
+	mockStore.On("ListProfilesWithDeleted", "").Return(all, nil)
+
+	req, _ := http.NewRequest("GET", "/providers?include_deleted=true", nil)
+	rr := httptest.NewRecorder()
+	handler.List(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"deleted_at"`)
+	mockStore.AssertExpectations(t)
+	mockStore.AssertNotCalled(t, "ListProfiles", mock.Anything)
+}
+
+func TestListProviders_NoWorkspaceListsGlobalOnly(t *testing.T) {
+	mockStore := new(MockStore)
+	handler := NewProvidersHandler(mockStore, nil, nil)
+
+	mockStore.On("ListProfiles", "").Return([]provider.ProfileList{}, nil)
+
+	req, _ := http.NewRequest("GET", "/providers", nil)
+	rr := httptest.NewRecorder()
+	handler.List(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	mockStore.AssertExpectations(t)
+	mockStore.AssertNotCalled(t, "ListProfiles", provider.AllWorkspaces)
+}
+
+func TestListProviders_AllWorkspaces(t *testing.T) {
+	mockStore := new(MockStore)
+	handler := NewProvidersHandler(mockStore, nil, nil)
+
+	mockStore.On("GetMetadata", provider.AllWorkspaces).Return(map[string]map[string]provider.Metadata{}, nil)
+
+	req, _ := http.NewRequest("GET", "/providers/metadata?all_workspaces=true", nil)
+	rr := httptest.NewRecorder()
+	handler.Metadata(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	mockStore.AssertExpectations(t)
+}
+
+func TestListProviders_RejectsWildcardWorkspace(t *testing.T) {
+	mockStore := new(MockStore)
+	handler := NewProvidersHandler(mockStore, nil, nil)
+
+	for _, query := range []string{"workspace_id=*", "workspace_id=ws-a&all_workspaces=true"} {
+		req, _ := http.NewRequest("GET", "/providers?"+query, nil)
+		rr := httptest.NewRecorder()
+		handler.List(rr, req)
+
+		assert.Equal(t, http.StatusBadRequest, rr.Code, query)
+		assert.Contains(t, rr.Body.String(), "invalid_workspace_id")
+	}
+	mockStore.AssertNotCalled(t, "ListProfiles", mock.Anything)
+}
+
+func TestGetByName_HidesProvidersOfOtherWorkspaces(t *testing.T) {
+	mockStore := new(MockStore)
+	handler := NewProvidersHandler(mockStore, nil, nil)
+
+	id := uuid.New()
+	mockStore.On("GetProfileByName", "internal").Return(&provider.Profile{ID: id, Name: "internal", WorkspaceIDs: []string{"ws-a"}}, nil)
+
+	tests := []struct {
+		query string
+		want  int
+	}{
+		{"workspace_id=ws-a", http.StatusOK},
+		{"all_workspaces=true", http.StatusOK},
+		{"workspace_id=ws-b", http.StatusNotFound},
+		{"", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest("GET", "/providers/by-name/internal?"+tt.query, nil)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("name", "internal")
+		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+		rr := httptest.NewRecorder()
+		handler.GetByName(rr, req)
+
+		assert.Equal(t, tt.want, rr.Code, tt.query)
+		if tt.want == http.StatusOK {
+			assert.Contains(t, rr.Body.String(), id.String(), tt.query)
+		} else {
+			assert.Contains(t, rr.Body.String(), "provider_not_found", tt.query)
+		}
+	}
+}
+
+func withProviderID(req *http.Request, id uuid.UUID) *http.Request {
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("id", id.String())
+	return req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+}
+
+func TestRestoreProvider(t *testing.T) {
+	cases := []struct {
+		name     string
+		storeErr error
+		status   int
+		code     string
+	}{
+		{"restored", nil, http.StatusOK, ""},
+		{"not found", provider.ErrNotFound, http.StatusNotFound, "provider_not_found"},
+		{"not deleted", provider.ErrNotDeleted, http.StatusConflict, "provider_not_deleted"},
+		{"name taken", provider.ErrNameTaken, http.StatusConflict, "provider_name_taken"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			mockStore := new(MockStore)
+			mockAudit := new(MockAuditLogger)
+			handler := NewProvidersHandler(mockStore, mockAudit, nil)
+
+			id := uuid.New()
+			mockStore.On("RestoreProfile", id).Return(c.storeErr)
+			mockAudit.On("Log", "provider.restored", (*uuid.UUID)(nil), mock.Anything, mock.Anything).Return(nil)
+
+			req, _ := http.NewRequest("POST", "/providers/"+id.String()+"/restore", nil)
+			rr := httptest.NewRecorder()
+			handler.Restore(rr, withProviderID(req, id))
+
+			assert.Equal(t, c.status, rr.Code)
+			assert.Contains(t, rr.Body.String(), c.code)
+			if c.storeErr == nil {
+				mockAudit.AssertNumberOfCalls(t, "Log", 1)
+			} else {
+				mockAudit.AssertNotCalled(t, "Log", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+			}
+		})
+	}
+}
+
+func TestPurgeProvider(t *testing.T) {
+	mockStore := new(MockStore)
+	mockAudit := new(MockAuditLogger)
+	handler := NewProvidersHandler(mockStore, mockAudit, nil)
+
+	id := uuid.New()
+	mockStore.On("PurgeProfile", id).Return(int64(3), nil)
+	mockAudit.On("Log", "provider.purged", (*uuid.UUID)(nil), mock.Anything, mock.Anything).Return(nil)
+
+	req, _ := http.NewRequest("DELETE", "/providers/"+id.String()+"/purge", nil)
+	rr := httptest.NewRecorder()
+	handler.Purge(rr, withProviderID(req, id))
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"connections_deleted":3`)
+	mockAudit.AssertCalled(t, "Log", "provider.purged", (*uuid.UUID)(nil), mock.MatchedBy(func(data map[string]interface{}) bool {
+		return data["provider_id"] == id.String() && data["connections_deleted"] == int64(3)
+	}), mock.Anything)
+}
+
+func TestPurgeProvider_LiveConnectionsConflict(t *testing.T) {
+	mockStore := new(MockStore)
+	handler := NewProvidersHandler(mockStore, nil, nil)
+
+	id := uuid.New()
+	mockStore.On("PurgeProfile", id).Return(int64(0), &provider.ConnectionsInUseError{Count: 2})
+
+	req, _ := http.NewRequest("DELETE", "/providers/"+id.String()+"/purge", nil)
+	rr := httptest.NewRecorder()
+	handler.Purge(rr, withProviderID(req, id))
+
+	assert.Equal(t, http.StatusConflict, rr.Code)
+	assert.Contains(t, rr.Body.String(), "provider_has_connections")
+	assert.Contains(t, rr.Body.String(), "2 pending")
+}
@@ -0,0 +1,181 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/internal/store"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/auth"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/connstate"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/httputil"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/server"
+	"github.com/Prescott-Data/nexus-framework/nexus-common/state"
+)
+
+// Reauthorize handles POST /connections/{connectionID}/reauthorize: it
+// starts a new consent for an existing OAuth2 connection, requesting the
+// union of the connection's scopes and the scopes in the body. The
+// connection keeps its ID, workspace and current token until the callback
+// succeeds; the new token then replaces the old one and the connection
+// becomes active. The body is optional and return_url defaults to the
+// connection's. Providers with the incremental_auth param are asked only
+// for the scopes the connection does not hold yet.
+func (h *ConsentHandler) Reauthorize(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "connectionID"))
+	if err != nil {
+		httputil.WriteError(w, http.StatusBadRequest, "invalid_connection_id", "Invalid connection ID")
+		return
+	}
+	var request struct {
+		Scopes    []string `json:"scopes"`
+		ReturnURL string   `json:"return_url"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil && err != io.EOF {
+		httputil.WriteError(w, http.StatusBadRequest, "invalid_json", "Invalid JSON")
+		return
+	}
+
+	conn, err := h.store.GetConnection(r.Context(), id)
+	if errors.Is(err, store.ErrNotFound) {
+		httputil.WriteError(w, http.StatusNotFound, "connection_not_found", "Connection not found")
+		return
+	}
+	if err != nil {
+		log.Printf("reauthorize: get connection %s: %v", id, err)
+		httputil.WriteError(w, http.StatusInternalServerError, "reauthorize_failed", "Failed to look up the connection")
+		return
+	}
+	if conn.Status != connstate.StateActive && conn.Status != connstate.StateNeedsReauth {
+		httputil.WriteError(w, http.StatusConflict, "invalid_transition", "A "+string(conn.Status)+" connection cannot be reauthorized")
+		return
+	}
+	provider, err := h.store.GetProvider(r.Context(), conn.ProviderID)
+	if err != nil {
+		log.Printf("reauthorize: provider lookup for connection %s: %v", id, err)
+		httputil.WriteError(w, http.StatusNotFound, "provider_not_found", "Provider not found")
+		return
+	}
+	if provider.AuthType != "oauth2" && provider.AuthType != "" {
+		httputil.WriteError(w, http.StatusBadRequest, "unsupported_auth_type", "Only OAuth2 connections can be reauthorized")
+		return
+	}
+	provider = withTenant(provider, conn.Tenant)
+
+	returnURL := request.ReturnURL
+	if returnURL == "" {
+		returnURL = conn.ReturnURL
+	}
+	if !server.IsReturnURLAllowed(returnURL, h.enforceReturnURL, h.allowedReturnDomains) {
+		httputil.WriteError(w, http.StatusBadRequest, "return_url_not_allowed", "return_url not allowed")
+		return
+	}
+	scopes, err := h.applyScopePolicy(r.Context(), provider, conn.WorkspaceID, unionScopes(conn.Scopes, request.Scopes))
+	if err != nil {
+		scopePolicyError(err).Write(w)
+		return
+	}
+
+	codeVerifier, codeChallenge, err := auth.GeneratePKCE()
+	if err != nil {
+		httputil.WriteError(w, http.StatusInternalServerError, "pkce_failed", "Failed to generate PKCE")
+		return
+	}
+	err = h.store.StartReauthorization(r.Context(), id, store.Reauthorization{
+		CodeVerifier: codeVerifier,
+		Scopes:       scopes,
+		ReturnURL:    returnURL,
+		ExpiresAt:    time.Now().Add(h.states.TTL()),
+	})
+	if errors.Is(err, store.ErrNotFound) {
+		// The connection changed status since it was read.
+		httputil.WriteError(w, http.StatusConflict, "invalid_transition", "The connection can no longer be reauthorized")
+		return
+	}
+	if err != nil {
+		log.Printf("reauthorize: start for connection %s: %v", id, err)
+		httputil.WriteError(w, http.StatusInternalServerError, "reauthorize_failed", "Failed to start reauthorization")
+		return
+	}
+
+	signedState, err := h.states.Sign(state.Data{
+		WorkspaceID: conn.WorkspaceID,
+		ProviderID:  conn.ProviderID.String(),
+		Nonce:       id.String(),
+		IAT:         time.Now(),
+	})
+	if err != nil {
+		httputil.WriteError(w, http.StatusInternalServerError, "state_sign_failed", "Failed to sign state")
+		return
+	}
+	authURL, err := h.authURL(r.Context(), provider, signedState, codeChallenge, scopes, conn.Scopes)
+	if err != nil {
+		log.Printf("reauthorize: auth URL for connection %s: %v", id, err)
+		authURLError(err).Write(w)
+		return
+	}
+
+	httputil.WriteJSON(w, http.StatusOK, ConsentSpec{
+		AuthURL:    authURL,
+		State:      signedState,
+		Scopes:     scopes,
+		ProviderID: conn.ProviderID.String(),
+	})
+	h.consentsMetric.Inc()
+	if containsScope(scopes, "openid") {
+		h.consentsOpenID.Inc()
+	}
+}
+
+// unionScopes returns current followed by the requested scopes it does not
+// already contain, compared case-insensitively.
+func unionScopes(current, requested []string) []string {
+	out := make([]string, 0, len(current)+len(requested))
+	for _, list := range [][]string{current, requested} {
+		for _, s := range list {
+			s = strings.TrimSpace(s)
+			if s != "" && !containsScope(out, s) {
+				out = append(out, s)
+			}
+		}
+	}
+	return out
+}
+
+// completeReauthorization swaps tokens in as the connection's token and its
+// re-consent's scopes as its scopes, and makes it active. With incremental
+// authorization the scopes the provider reports as granted are merged in,
+// since they include grants kept from earlier consents. The refresh lock is
+// held so a refresh of the old grant cannot overwrite the new token.
+func (h *CallbackHandler) completeReauthorization(ctx context.Context, conn *store.Connection, provider *store.Provider, tokens map[string]interface{}) error {
+	scopes := conn.Scopes
+	if granted, ok := tokens["scope"].(string); ok && incrementalAuth(provider.Params) {
+		scopes = unionScopes(scopes, strings.Fields(granted))
+	}
+	encryptedData, expiresAt, err := h.sealTokens(conn.ID, conn.WorkspaceID, tokens)
+	if err != nil {
+		return err
+	}
+	lockCtx, cancel := context.WithTimeout(ctx, refreshLockWait)
+	release, err := h.refreshLock.Acquire(lockCtx, "refresh:"+conn.ID.String(), refreshLockMargin)
+	cancel()
+	if err != nil {
+		return fmt.Errorf("acquire refresh lock: %w", err)
+	}
+	defer release()
+
+	if err := h.store.CompleteReauthorization(ctx, conn.ID, scopes, encryptedData, expiresAt); err != nil {
+		return err
+	}
+	h.recordRefreshToken(ctx, conn.ID, tokens)
+	return nil
+}
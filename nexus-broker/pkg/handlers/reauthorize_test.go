@@ -0,0 +1,240 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/internal/store"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/connstate"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/vault"
+)
+
+var reauthConnectionID = uuid.MustParse("c2c2c2c2-c2c2-c2c2-c2c2-c2c2c2c2c2c2")
+
+// reauthorize calls h.Reauthorize for reauthConnectionID with body.
+func reauthorize(h *ConsentHandler, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", "/connections/"+reauthConnectionID.String()+"/reauthorize", strings.NewReader(body))
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("connectionID", reauthConnectionID.String())
+	req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+	rr := httptest.NewRecorder()
+	h.Reauthorize(rr, req)
+	return rr
+}
+
+// seedReauthConnection stores an OAuth2 connection with scopes [read] and a
+// token sealed with key.
+func seedReauthConnection(t *testing.T, st *store.Memory, key []byte, status connstate.State, tokenURL string) {
+	t.Helper()
+	seedConnection(st, reauthConnectionID, status, store.Provider{
+		AuthType: "oauth2",
+		AuthURL:  "http://provider.example/auth",
+		TokenURL: tokenURL,
+		ClientID: "client-id",
+	})
+	c, err := st.GetConnection(context.Background(), reauthConnectionID)
+	require.NoError(t, err)
+	c.Scopes = []string{"read"}
+	st.PutConnection(*c)
+	sealed, err := vault.SealToken(key, []byte(`{"access_token":"old-access-token"}`), reauthConnectionID.String(), "ws-1")
+	require.NoError(t, err)
+	require.NoError(t, st.SaveTokens(context.Background(), reauthConnectionID, sealed, nil))
+}
+
+func TestReauthorize_StartsConsentWithUnionOfScopes(t *testing.T) {
+	st := store.NewMemory()
+	key := []byte("01234567890123456789012345678901")
+	seedReauthConnection(t, st, key, connstate.StateNeedsReauth, "")
+	h := NewConsentHandler(ConsentHandlerConfig{
+		Store:        st,
+		BaseURL:      "http://localhost:8080",
+		RedirectPath: "/auth/callback",
+		States:       testStates(t, key),
+		HTTPClient:   http.DefaultClient,
+	})
+
+	rr := reauthorize(h, `{"scopes": ["write", "READ"]}`)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	var spec ConsentSpec
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &spec))
+	assert.Equal(t, []string{"read", "write"}, spec.Scopes)
+	authURL, err := url.Parse(spec.AuthURL)
+	require.NoError(t, err)
+	assert.Equal(t, "read write", authURL.Query().Get("scope"))
+
+	stateData, err := testStates(t, key).Verify(spec.State)
+	require.NoError(t, err)
+	assert.Equal(t, reauthConnectionID.String(), stateData.Nonce)
+	assert.Equal(t, "ws-1", stateData.WorkspaceID)
+
+	// The connection itself is untouched until the callback.
+	conn, err := st.GetConnection(context.Background(), reauthConnectionID)
+	require.NoError(t, err)
+	assert.Equal(t, connstate.StateNeedsReauth, conn.Status)
+	assert.Equal(t, []string{"read"}, conn.Scopes)
+	pending, err := st.GetPendingReauthorization(context.Background(), reauthConnectionID)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"read", "write"}, pending.Scopes)
+	assert.Equal(t, "http://localhost:3000/callback", pending.ReturnURL)
+}
+
+func TestReauthorize_Rejects(t *testing.T) {
+	key := []byte("01234567890123456789012345678901")
+	cases := []struct {
+		name   string
+		seed   func(*store.Memory)
+		status int
+		code   string
+	}{
+		{"missing connection", func(*store.Memory) {}, http.StatusNotFound, "connection_not_found"},
+		{"revoked connection", func(st *store.Memory) {
+			seedReauthConnection(t, st, key, connstate.StateRevoked, "")
+		}, http.StatusConflict, "invalid_transition"},
+		{"static credentials", func(st *store.Memory) {
+			seedConnection(st, reauthConnectionID, connstate.StateActive, store.Provider{AuthType: "api_key"})
+		}, http.StatusBadRequest, "unsupported_auth_type"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			st := store.NewMemory()
+			tc.seed(st)
+			h := NewConsentHandler(ConsentHandlerConfig{Store: st, States: testStates(t, key), HTTPClient: http.DefaultClient})
+			rr := reauthorize(h, "")
+			assert.Equal(t, tc.status, rr.Code)
+			assert.Contains(t, rr.Body.String(), tc.code)
+		})
+	}
+}
+
+// TestReauthorize_CallbackSwapsToken runs a reauthorization through the
+// callback: the new token and scopes replace the old ones under the same
+// connection ID, and a failed exchange leaves the connection as it was.
+func TestReauthorize_CallbackSwapsToken(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		response string
+		status   int
+	}{
+		{"success", `{"access_token": "new-access-token", "expires_in": 3600}`, http.StatusOK},
+		{"exchange rejected", `{"error": "invalid_grant"}`, http.StatusBadRequest},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			provider := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(tc.status)
+				io.WriteString(w, tc.response)
+			}))
+			defer provider.Close()
+
+			st := store.NewMemory()
+			key := []byte("01234567890123456789012345678901")
+			seedReauthConnection(t, st, key, connstate.StateNeedsReauth, provider.URL)
+			states := testStates(t, key)
+			consent := NewConsentHandler(ConsentHandlerConfig{Store: st, States: states, HTTPClient: provider.Client()})
+			callback := NewCallbackHandler(CallbackHandlerConfig{
+				Store:         st,
+				BaseURL:       "http://localhost:8080",
+				RedirectPath:  "/auth/callback",
+				EncryptionKey: key,
+				States:        states,
+				HTTPClient:    provider.Client(),
+			})
+
+			rr := reauthorize(consent, `{"scopes": ["write"]}`)
+			require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+			var spec ConsentSpec
+			require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &spec))
+
+			rr = httptest.NewRecorder()
+			callback.Handle(rr, httptest.NewRequest("GET", "/auth/callback?code=abc&state="+url.QueryEscape(spec.State), nil))
+
+			conn, err := st.GetConnection(context.Background(), reauthConnectionID)
+			require.NoError(t, err)
+			tok, err := st.GetTokens(context.Background(), reauthConnectionID)
+			require.NoError(t, err)
+			plain, err := vault.OpenToken(key, tok.EncryptedData, reauthConnectionID.String(), "ws-1", false)
+			require.NoError(t, err)
+			_, err = st.GetPendingReauthorization(context.Background(), reauthConnectionID)
+			assert.ErrorIs(t, err, store.ErrNotFound, "the re-consent should be over")
+
+			if tc.status != http.StatusOK {
+				assert.Equal(t, http.StatusFound, rr.Code)
+				assert.Contains(t, rr.Header().Get("Location"), "code=token_exchange_failed")
+				assert.Equal(t, connstate.StateNeedsReauth, conn.Status)
+				assert.Equal(t, []string{"read"}, conn.Scopes)
+				assert.Contains(t, string(plain), "old-access-token")
+				return
+			}
+			require.Equal(t, http.StatusFound, rr.Code, rr.Body.String())
+			assert.Contains(t, rr.Header().Get("Location"), "connection_id="+reauthConnectionID.String())
+			assert.Equal(t, connstate.StateActive, conn.Status)
+			assert.Equal(t, []string{"read", "write"}, conn.Scopes)
+			assert.Contains(t, string(plain), "new-access-token")
+		})
+	}
+}
+
+// TestReauthorize_IncrementalAuth asks a provider with the incremental_auth
+// param for the new scopes only, and records the scopes it reports granted.
+func TestReauthorize_IncrementalAuth(t *testing.T) {
+	provider := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"access_token": "new-access-token", "scope": "read write calendar"}`)
+	}))
+	defer provider.Close()
+
+	st := store.NewMemory()
+	key := []byte("01234567890123456789012345678901")
+	params := json.RawMessage(`{"incremental_auth": true, "access_type": "offline"}`)
+	seedReauthConnection(t, st, key, connstate.StateActive, provider.URL)
+	p, err := st.GetProvider(context.Background(), mustConnection(t, st).ProviderID)
+	require.NoError(t, err)
+	p.Params = &params
+	st.PutProvider(*p)
+
+	states := testStates(t, key)
+	consent := NewConsentHandler(ConsentHandlerConfig{Store: st, States: states, HTTPClient: provider.Client()})
+	rr := reauthorize(consent, `{"scopes": ["write"]}`)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	var spec ConsentSpec
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &spec))
+	assert.Equal(t, []string{"read", "write"}, spec.Scopes)
+	authURL, err := url.Parse(spec.AuthURL)
+	require.NoError(t, err)
+	q := authURL.Query()
+	assert.Equal(t, "write", q.Get("scope"), "only the delta is requested")
+	assert.Equal(t, "true", q.Get("include_granted_scopes"))
+	assert.Equal(t, "offline", q.Get("access_type"), "string params are still sent")
+	assert.False(t, q.Has("incremental_auth"))
+
+	callback := NewCallbackHandler(CallbackHandlerConfig{
+		Store:         st,
+		BaseURL:       "http://localhost:8080",
+		RedirectPath:  "/auth/callback",
+		EncryptionKey: key,
+		States:        states,
+		HTTPClient:    provider.Client(),
+	})
+	rr = httptest.NewRecorder()
+	callback.Handle(rr, httptest.NewRequest("GET", "/auth/callback?code=abc&state="+url.QueryEscape(spec.State), nil))
+	require.Equal(t, http.StatusFound, rr.Code, rr.Body.String())
+	assert.Equal(t, []string{"read", "write", "calendar"}, mustConnection(t, st).Scopes)
+}
+
+func mustConnection(t *testing.T, st *store.Memory) *store.Connection {
+	t.Helper()
+	c, err := st.GetConnection(context.Background(), reauthConnectionID)
+	require.NoError(t, err)
+	return c
+}
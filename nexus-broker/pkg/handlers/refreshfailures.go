@@ -0,0 +1,303 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/internal/store"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/connstate"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/httputil"
+)
+
+// Error classes of refresh failures, as stored in refresh_failures.
+const (
+	// refreshClassNetwork is a token request that got no answer.
+	refreshClassNetwork = "network"
+	// refreshClassProviderError is a 5xx from the token endpoint.
+	refreshClassProviderError = "provider_error"
+	// refreshClassRateLimited is a 429 from the token endpoint.
+	refreshClassRateLimited = "rate_limited"
+	// refreshClassRejected is any other 4xx, usually invalid_grant: the
+	// refresh token is revoked or expired. It is not retried.
+	refreshClassRejected = "rejected"
+)
+
+const (
+	// maxRefreshRetryBackoff caps the delay between retries of a failed
+	// refresh.
+	maxRefreshRetryBackoff = time.Hour
+	// refreshRetryBatch is how many due failures one background run retries.
+	refreshRetryBatch = 100
+)
+
+// NeedsReauthConnection is the data of a connection.needs_reauth webhook,
+// sent when a connection's refresh was rejected or failed too often.
+type NeedsReauthConnection struct {
+	ConnectionID string    `json:"connection_id"`
+	WorkspaceID  string    `json:"workspace_id"`
+	ProviderID   string    `json:"provider_id"`
+	ErrorClass   string    `json:"error_class"`
+	LastError    string    `json:"last_error"`
+	Attempts     int       `json:"attempts"`
+	MarkedAt     time.Time `json:"marked_at"`
+}
+
+// refreshErrorClass classifies a failed refresh token request by the
+// provider's status code, 0 if it did not answer.
+func refreshErrorClass(statusCode int) string {
+	switch {
+	case statusCode == http.StatusTooManyRequests:
+		return refreshClassRateLimited
+	case statusCode >= 400 && statusCode < 500:
+		return refreshClassRejected
+	case statusCode >= 500:
+		return refreshClassProviderError
+	}
+	return refreshClassNetwork
+}
+
+// refreshRetryDelay is the wait after the attempts-th failure in a row:
+// base doubled for each earlier failure, at most maxRefreshRetryBackoff.
+func refreshRetryDelay(base time.Duration, attempts int) time.Duration {
+	d := base
+	for i := 1; i < attempts && d < maxRefreshRetryBackoff; i++ {
+		d *= 2
+	}
+	if d > maxRefreshRetryBackoff {
+		d = maxRefreshRetryBackoff
+	}
+	return d
+}
+
+// recordRefreshFailure adds a failed refresh of conn to the dead-letter
+// queue and schedules its retry. A rejected refresh, or one failing for the
+// MaxAttempts-th time in a row, is not retried: the connection is marked
+// needs_reauth and a connection.needs_reauth webhook sent. Requests the
+// broker itself held back under the provider's token_rate_limit never
+// reached the provider and are not recorded. Store failures are logged
+// only.
+func (h *CallbackHandler) recordRefreshFailure(ctx context.Context, r *http.Request, conn *store.Connection, class string, statusCode int, cause error) {
+	var limited *ProviderRateLimitedError
+	if errors.As(cause, &limited) {
+		return
+	}
+	now := time.Now()
+	f, err := h.store.GetRefreshFailure(ctx, conn.ID)
+	if err != nil {
+		if !errors.Is(err, store.ErrNotFound) {
+			log.Printf("connection %s: load refresh failure: %v", conn.ID, err)
+		}
+		f = &store.RefreshFailure{ConnectionID: conn.ID, FirstFailedAt: now}
+	}
+	f.Attempts++
+	f.ErrorClass = class
+	f.LastError = cause.Error()
+	f.StatusCode = statusCode
+	f.LastFailedAt = now
+	f.NextRetryAt = nil
+	giveUp := class == refreshClassRejected || (h.refreshRetry.MaxAttempts > 0 && f.Attempts >= h.refreshRetry.MaxAttempts)
+	if !giveUp {
+		next := now.Add(refreshRetryDelay(h.refreshRetry.Backoff, f.Attempts))
+		f.NextRetryAt = &next
+	}
+	if err := h.store.SaveRefreshFailure(ctx, f); err != nil {
+		log.Printf("connection %s: record refresh failure: %v", conn.ID, err)
+	}
+	if !giveUp || conn.Status == connstate.StateNeedsReauth {
+		return
+	}
+
+	h.updateConnectionStatus(ctx, conn.ID, connstate.StateNeedsReauth)
+	if class != refreshClassRejected {
+		h.logAuditEvent(&conn.ID, "refresh_retries_exhausted", map[string]string{
+			"error_class": class,
+			"attempts":    strconv.Itoa(f.Attempts),
+			"provider_id": conn.ProviderID.String(),
+		}, r)
+	}
+	event := NeedsReauthConnection{
+		ConnectionID: conn.ID.String(),
+		WorkspaceID:  conn.WorkspaceID,
+		ProviderID:   conn.ProviderID.String(),
+		ErrorClass:   class,
+		LastError:    f.LastError,
+		Attempts:     f.Attempts,
+		MarkedAt:     now.UTC(),
+	}
+	if err := h.webhook.Send(ctx, "connection.needs_reauth", event); err != nil {
+		log.Printf("refresh: %v", err)
+	}
+}
+
+// clearRefreshFailure removes conn from the dead-letter queue after a
+// successful refresh, reactivating it if it had been marked needs_reauth.
+func (h *CallbackHandler) clearRefreshFailure(ctx context.Context, r *http.Request, conn *store.Connection) {
+	if err := h.store.DeleteRefreshFailure(ctx, conn.ID); err != nil {
+		log.Printf("connection %s: clear refresh failure: %v", conn.ID, err)
+	}
+	if conn.Status != connstate.StateNeedsReauth {
+		return
+	}
+	if err := h.updateConnectionStatus(ctx, conn.ID, connstate.StateActive); err == nil {
+		h.logAuditEvent(&conn.ID, "connection_refresh_recovered", map[string]string{"provider_id": conn.ProviderID.String()}, r)
+	}
+}
+
+// RetryRefreshFailures retries the refreshes whose next retry is due and
+// returns how many it attempted. Failures of connections that can no
+// longer be refreshed, e.g. revoked ones, are dropped.
+func (h *CallbackHandler) RetryRefreshFailures(ctx context.Context) (int, error) {
+	due, err := h.store.ListRefreshFailures(ctx, store.RefreshFailureFilter{DueBefore: time.Now(), Limit: refreshRetryBatch})
+	if err != nil {
+		return 0, err
+	}
+	retried := 0
+	for _, f := range due {
+		if ctx.Err() != nil {
+			break
+		}
+		conn, err := h.store.GetConnection(ctx, f.ConnectionID)
+		if err != nil || conn.Status != connstate.StateActive {
+			if err := h.store.DeleteRefreshFailure(ctx, f.ConnectionID); err != nil {
+				log.Printf("connection %s: drop refresh failure: %v", f.ConnectionID, err)
+			}
+			continue
+		}
+		// Another replica may have retried it since it was listed.
+		if cur, err := h.store.GetRefreshFailure(ctx, f.ConnectionID); err != nil || cur.NextRetryAt == nil || cur.NextRetryAt.After(time.Now()) {
+			continue
+		}
+		retried++
+		if _, rerr := h.refresh(ctx, nil, conn); rerr != nil {
+			log.Printf("connection %s: refresh retry %d failed: %s", f.ConnectionID, f.Attempts, rerr.code)
+		}
+	}
+	return retried, nil
+}
+
+// StartRefreshRetries runs RetryRefreshFailures every interval until ctx is
+// cancelled.
+func (h *CallbackHandler) StartRefreshRetries(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if _, err := h.RetryRefreshFailures(ctx); err != nil {
+				log.Printf("refresh retries: %v", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// refreshFailureView is a refresh dead-letter queue entry in the admin API.
+type refreshFailureView struct {
+	ConnectionID  string    `json:"connection_id"`
+	WorkspaceID   string    `json:"workspace_id"`
+	ProviderID    string    `json:"provider_id"`
+	ErrorClass    string    `json:"error_class"`
+	LastError     string    `json:"last_error"`
+	StatusCode    int       `json:"status_code,omitempty"`
+	Attempts      int       `json:"attempts"`
+	FirstFailedAt time.Time `json:"first_failed_at"`
+	LastFailedAt  time.Time `json:"last_failed_at"`
+	// NextRetryAt is null once retries were given up.
+	NextRetryAt *time.Time `json:"next_retry_at"`
+}
+
+func viewRefreshFailure(f store.RefreshFailure) refreshFailureView {
+	return refreshFailureView{
+		ConnectionID:  f.ConnectionID.String(),
+		WorkspaceID:   f.WorkspaceID,
+		ProviderID:    f.ProviderID.String(),
+		ErrorClass:    f.ErrorClass,
+		LastError:     f.LastError,
+		StatusCode:    f.StatusCode,
+		Attempts:      f.Attempts,
+		FirstFailedAt: f.FirstFailedAt,
+		LastFailedAt:  f.LastFailedAt,
+		NextRetryAt:   f.NextRetryAt,
+	}
+}
+
+// ListRefreshFailures handles GET /admin/refresh-failures: the latest
+// failures first, or with due=true those whose retry is due, oldest first.
+func (h *CallbackHandler) ListRefreshFailures(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	filter := store.RefreshFailureFilter{WorkspaceID: strings.TrimSpace(q.Get("workspace_id")), Limit: 100}
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > 1000 {
+			httputil.WriteError(w, http.StatusBadRequest, "invalid_request", "limit must be between 1 and 1000")
+			return
+		}
+		filter.Limit = n
+	}
+	if q.Get("due") == "true" {
+		filter.DueBefore = time.Now()
+	}
+	failures, err := h.store.ListRefreshFailures(r.Context(), filter)
+	if err != nil {
+		log.Printf("refresh failures: list: %v", err)
+		httputil.WriteError(w, http.StatusInternalServerError, "list_failed", "Failed to list refresh failures")
+		return
+	}
+	out := make([]refreshFailureView, 0, len(failures))
+	for _, f := range failures {
+		out = append(out, viewRefreshFailure(f))
+	}
+	httputil.WriteJSON(w, http.StatusOK, map[string]interface{}{"failures": out})
+}
+
+// RetryRefreshFailure handles POST
+// /admin/refresh-failures/{connectionID}/retry: it refreshes the connection
+// now, whatever its next retry time. A connection marked needs_reauth by
+// the failure is reactivated if the refresh succeeds, e.g. after the
+// provider's client secret was corrected. The new token is not returned.
+func (h *CallbackHandler) RetryRefreshFailure(w http.ResponseWriter, r *http.Request) {
+	connectionID, err := uuid.Parse(chi.URLParam(r, "connectionID"))
+	if err != nil {
+		httputil.WriteError(w, http.StatusBadRequest, "invalid_connection_id", "Invalid connection ID")
+		return
+	}
+	if _, err := h.store.GetRefreshFailure(r.Context(), connectionID); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			httputil.WriteError(w, http.StatusNotFound, "refresh_failure_not_found", "No failed refresh recorded for this connection")
+			return
+		}
+		log.Printf("refresh failures: get %s: %v", connectionID, err)
+		httputil.WriteError(w, http.StatusInternalServerError, "get_failed", "Failed to load refresh failure")
+		return
+	}
+	conn, err := h.store.GetConnection(r.Context(), connectionID)
+	if err != nil {
+		httputil.WriteError(w, http.StatusNotFound, "connection_not_found", "Connection not found")
+		return
+	}
+	if conn.Status != connstate.StateActive && conn.Status != connstate.StateNeedsReauth {
+		httputil.WriteErrorWithDetails(w, http.StatusConflict, "connection_not_refreshable",
+			"Only active and needs_reauth connections can be refreshed", map[string]interface{}{"status": conn.Status})
+		return
+	}
+	h.logAuditEvent(&connectionID, "refresh_retry_requested", map[string]string{"provider_id": conn.ProviderID.String()}, r)
+	if _, rerr := h.refresh(r.Context(), r, conn); rerr != nil {
+		rerr.write(w)
+		return
+	}
+	httputil.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"connection_id": connectionID.String(),
+		"status":        connstate.StateActive,
+		"refreshed":     true,
+	})
+}
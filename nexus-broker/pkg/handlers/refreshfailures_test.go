@@ -0,0 +1,193 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/internal/audit"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/internal/store"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/internal/webhook"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/config"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/connstate"
+)
+
+// refreshFailureFixture is an active connection whose provider answers
+// refreshes with status, 200 issuing a token.
+type refreshFailureFixture struct {
+	st      *store.Memory
+	handler *CallbackHandler
+	status  int32
+	mu      sync.Mutex
+	events  []NeedsReauthConnection
+}
+
+func newRefreshFailureFixture(t *testing.T, maxAttempts int) *refreshFailureFixture {
+	f := &refreshFailureFixture{st: store.NewMemory(), status: http.StatusOK}
+	key := []byte("01234567890123456789012345678901")
+
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s := int(atomic.LoadInt32(&f.status)); s != http.StatusOK {
+			w.WriteHeader(s)
+			io.WriteString(w, `{"error":"invalid_grant"}`)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"access_token":"new","refresh_token":"rt-0"}`)
+	}))
+	t.Cleanup(tokenServer.Close)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Event string                `json:"event"`
+			Data  NeedsReauthConnection `json:"data"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		if body.Event == "connection.needs_reauth" {
+			f.mu.Lock()
+			f.events = append(f.events, body.Data)
+			f.mu.Unlock()
+		}
+	}))
+	t.Cleanup(hook.Close)
+
+	f.handler = NewCallbackHandler(CallbackHandlerConfig{
+		Store:         f.st,
+		Audit:         audit.NewServiceWithStore(f.st),
+		EncryptionKey: key,
+		States:        testStates(t, key),
+		HTTPClient:    tokenServer.Client(),
+		Webhook:       webhook.New(hook.URL, nil, hook.Client()),
+		RefreshRetry:  config.RefreshRetryConfig{MaxAttempts: maxAttempts, Backoff: time.Minute},
+	})
+	seedConnection(f.st, refreshConnectionID, connstate.StateActive, store.Provider{
+		AuthType: "oauth2", TokenURL: tokenServer.URL, ClientID: "id", ClientSecret: "secret",
+	})
+	require.NoError(t, f.handler.storeTokens(context.Background(), refreshConnectionID, "ws-1",
+		map[string]interface{}{"access_token": "at-0", "refresh_token": "rt-0"}))
+	return f
+}
+
+func (f *refreshFailureFixture) refresh() *httptest.ResponseRecorder {
+	rr := httptest.NewRecorder()
+	f.handler.Refresh(rr, httptest.NewRequest("POST", "/connections/"+refreshConnectionID.String()+"/refresh", nil))
+	return rr
+}
+
+func (f *refreshFailureFixture) admin(method, path string) *httptest.ResponseRecorder {
+	r := chi.NewRouter()
+	r.Get("/admin/refresh-failures", f.handler.ListRefreshFailures)
+	r.Post("/admin/refresh-failures/{connectionID}/retry", f.handler.RetryRefreshFailure)
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, httptest.NewRequest(method, path, nil))
+	return rr
+}
+
+func (f *refreshFailureFixture) connStatus(t *testing.T) connstate.State {
+	c, err := f.st.GetConnection(context.Background(), refreshConnectionID)
+	require.NoError(t, err)
+	return c.Status
+}
+
+func TestRefreshFailure_TransientIsRetriedThenGivenUp(t *testing.T) {
+	f := newRefreshFailureFixture(t, 2)
+	atomic.StoreInt32(&f.status, http.StatusServiceUnavailable)
+
+	before := time.Now()
+	require.Equal(t, http.StatusBadGateway, f.refresh().Code)
+	rf, err := f.st.GetRefreshFailure(context.Background(), refreshConnectionID)
+	require.NoError(t, err)
+	assert.Equal(t, refreshClassProviderError, rf.ErrorClass)
+	assert.Equal(t, http.StatusServiceUnavailable, rf.StatusCode)
+	assert.Equal(t, 1, rf.Attempts)
+	require.NotNil(t, rf.NextRetryAt)
+	assert.WithinDuration(t, before.Add(time.Minute), *rf.NextRetryAt, 5*time.Second)
+	assert.Equal(t, connstate.StateActive, f.connStatus(t))
+
+	require.Equal(t, http.StatusBadGateway, f.refresh().Code)
+	assert.Equal(t, connstate.StateNeedsReauth, f.connStatus(t), "the second failure in a row gives up")
+	rf, err = f.st.GetRefreshFailure(context.Background(), refreshConnectionID)
+	require.NoError(t, err)
+	assert.Equal(t, 2, rf.Attempts)
+	assert.Nil(t, rf.NextRetryAt)
+	require.Len(t, f.events, 1)
+	assert.Equal(t, refreshConnectionID.String(), f.events[0].ConnectionID)
+	assert.Equal(t, refreshClassProviderError, f.events[0].ErrorClass)
+
+	rr := f.admin("GET", "/admin/refresh-failures")
+	require.Equal(t, http.StatusOK, rr.Code)
+	var list struct {
+		Failures []refreshFailureView `json:"failures"`
+	}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &list))
+	require.Len(t, list.Failures, 1)
+	assert.Equal(t, "ws-1", list.Failures[0].WorkspaceID)
+	assert.Equal(t, 2, list.Failures[0].Attempts)
+
+	// The provider recovers and an admin retries: the connection is back.
+	atomic.StoreInt32(&f.status, http.StatusOK)
+	rr = f.admin("POST", "/admin/refresh-failures/"+refreshConnectionID.String()+"/retry")
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	assert.NotContains(t, rr.Body.String(), "access_token", "the admin API does not hand out tokens")
+	assert.Equal(t, connstate.StateActive, f.connStatus(t))
+	_, err = f.st.GetRefreshFailure(context.Background(), refreshConnectionID)
+	assert.ErrorIs(t, err, store.ErrNotFound)
+
+	rr = f.admin("POST", "/admin/refresh-failures/"+refreshConnectionID.String()+"/retry")
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+func TestRefreshFailure_RejectedIsNotRetried(t *testing.T) {
+	f := newRefreshFailureFixture(t, 5)
+	atomic.StoreInt32(&f.status, http.StatusBadRequest)
+
+	rr := f.refresh()
+	require.Equal(t, http.StatusConflict, rr.Code)
+	assert.Contains(t, rr.Body.String(), "attention_required")
+	assert.Equal(t, connstate.StateNeedsReauth, f.connStatus(t))
+
+	rf, err := f.st.GetRefreshFailure(context.Background(), refreshConnectionID)
+	require.NoError(t, err)
+	assert.Equal(t, refreshClassRejected, rf.ErrorClass)
+	assert.Nil(t, rf.NextRetryAt)
+	require.Len(t, f.events, 1)
+	assert.Equal(t, refreshClassRejected, f.events[0].ErrorClass)
+}
+
+func TestRetryRefreshFailures_RetriesDueOnly(t *testing.T) {
+	f := newRefreshFailureFixture(t, 0)
+	atomic.StoreInt32(&f.status, http.StatusTooManyRequests)
+	require.Equal(t, http.StatusBadGateway, f.refresh().Code)
+	rf, err := f.st.GetRefreshFailure(context.Background(), refreshConnectionID)
+	require.NoError(t, err)
+	assert.Equal(t, refreshClassRateLimited, rf.ErrorClass)
+
+	atomic.StoreInt32(&f.status, http.StatusOK)
+	n, err := f.handler.RetryRefreshFailures(context.Background())
+	require.NoError(t, err)
+	assert.Zero(t, n, "not due yet")
+
+	past := time.Now().Add(-time.Second)
+	rf.NextRetryAt = &past
+	require.NoError(t, f.st.SaveRefreshFailure(context.Background(), rf))
+	n, err = f.handler.RetryRefreshFailures(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	_, err = f.st.GetRefreshFailure(context.Background(), refreshConnectionID)
+	assert.ErrorIs(t, err, store.ErrNotFound, "a successful retry clears the failure")
+}
+
+func TestRefreshRetryDelay(t *testing.T) {
+	assert.Equal(t, time.Minute, refreshRetryDelay(time.Minute, 1))
+	assert.Equal(t, 4*time.Minute, refreshRetryDelay(time.Minute, 3))
+	assert.Equal(t, maxRefreshRetryBackoff, refreshRetryDelay(time.Minute, 40))
+}
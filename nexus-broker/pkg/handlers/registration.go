@@ -0,0 +1,340 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/internal/audit"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/discovery"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/httpclient"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/httputil"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/provider"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/registration"
+)
+
+// registrationTimeout bounds each call to a provider's registration or
+// client configuration endpoint.
+const registrationTimeout = 30 * time.Second
+
+// ClientRegistrationRequest is the "registration" member of POST /providers.
+type ClientRegistrationRequest struct {
+	// Endpoint is the provider's registration endpoint. It defaults to the
+	// registration_endpoint advertised in the issuer's discovery document.
+	Endpoint string `json:"endpoint"`
+	// InitialAccessToken authorizes the registration at providers that
+	// require one. It is not stored.
+	InitialAccessToken string `json:"initial_access_token"`
+	// Metadata is merged over the client metadata the broker sends.
+	Metadata map[string]interface{} `json:"metadata"`
+}
+
+// ClientRegistrar registers the OAuth client of new providers with Dynamic
+// Client Registration (RFC 7591) and rotates its credentials through the
+// client configuration endpoint (RFC 7592).
+type ClientRegistrar struct {
+	store       provider.RegistrationStorer
+	audit       audit.Logger
+	guard       *httpclient.AddressGuard
+	redirectURI string
+	clients     providerClients
+}
+
+// ClientRegistrarConfig holds the dependencies for ClientRegistrar
+type ClientRegistrarConfig struct {
+	Store provider.RegistrationStorer
+	Audit audit.Logger
+	// Guard, if set, rejects provider URLs that resolve to private,
+	// loopback or link-local addresses.
+	Guard        *httpclient.AddressGuard
+	BaseURL      string
+	RedirectPath string
+	// HTTPClient is used for OIDC discovery and may cache responses.
+	HTTPClient *http.Client
+	// Transports supplies pooled connections for registration calls.
+	// Defaults to a private pool.
+	Transports *httpclient.Pool
+}
+
+// NewClientRegistrar creates a new client registrar
+func NewClientRegistrar(cfg ClientRegistrarConfig) *ClientRegistrar {
+	return &ClientRegistrar{
+		store:       cfg.Store,
+		audit:       cfg.Audit,
+		guard:       cfg.Guard,
+		redirectURI: strings.TrimSuffix(cfg.BaseURL, "/") + cfg.RedirectPath,
+		clients:     newProviderClients(cfg.Transports, cfg.HTTPClient),
+	}
+}
+
+// register registers an OAuth client for the oauth2 profile in profileJSON,
+// which must leave client_id and client_secret unset, and then saves the
+// profile with the issued credentials. A client whose profile cannot be
+// saved is deregistered again.
+func (c *ClientRegistrar) register(w http.ResponseWriter, r *http.Request, profileJSON json.RawMessage, req *ClientRegistrationRequest) {
+	var members map[string]interface{}
+	if err := json.Unmarshal(profileJSON, &members); err != nil {
+		httputil.WriteError(w, http.StatusBadRequest, "invalid_json", "Invalid JSON payload")
+		return
+	}
+	if id, _ := members["client_id"].(string); id != "" {
+		httputil.WriteError(w, http.StatusBadRequest, "invalid_request", "client_id must not be set when the client is registered dynamically")
+		return
+	}
+
+	// Validate the profile before anything is registered at the provider.
+	members["client_id"], members["client_secret"] = "pending", "pending"
+	pending, _ := json.Marshal(members)
+	p, err := provider.ParseProfile(string(pending))
+	if err != nil {
+		writeRegisterError(w, err)
+		return
+	}
+	if p.AuthType != "" && p.AuthType != "oauth2" {
+		httputil.WriteError(w, http.StatusBadRequest, "unsupported_auth_type", "Dynamic client registration requires auth_type oauth2")
+		return
+	}
+	if !c.checkURLs(w, r, profileURLs(p)) {
+		return
+	}
+
+	endpoint := strings.TrimSpace(req.Endpoint)
+	if endpoint == "" {
+		endpoint = c.discoverEndpoint(r.Context(), p)
+	}
+	if endpoint == "" {
+		httputil.WriteError(w, http.StatusBadRequest, "missing_fields", "registration.endpoint: required when the issuer does not advertise a registration_endpoint")
+		return
+	}
+	if !c.checkURLs(w, r, map[string]string{"registration.endpoint": endpoint}) {
+		return
+	}
+
+	client, err := c.clients.client(p.CABundle, registrationTimeout)
+	if err != nil {
+		httputil.WriteError(w, http.StatusInternalServerError, "provider_config_failed", "Failed to load provider config")
+		return
+	}
+	registered, err := registration.Register(r.Context(), client, endpoint, req.InitialAccessToken, c.clientMetadata(p, req.Metadata))
+	if err != nil {
+		writeRegistrationError(w, err)
+		return
+	}
+	if registered.ClientSecret == "" {
+		c.deregister(r.Context(), client, registered)
+		httputil.WriteError(w, http.StatusBadGateway, "client_registration_failed", "The provider registered a client without a client_secret")
+		return
+	}
+
+	members["client_id"], members["client_secret"] = registered.ClientID, registered.ClientSecret
+	final, _ := json.Marshal(members)
+	reg := &provider.Registration{
+		ClientURI:             registered.RegistrationClientURI,
+		AccessToken:           registered.RegistrationAccessToken,
+		ClientSecretExpiresAt: secretExpiry(registered.ClientSecretExpiresAt),
+	}
+	profile, err := c.store.RegisterProfileWithClient(string(final), reg)
+	if err != nil {
+		c.deregister(r.Context(), client, registered)
+		writeRegisterError(w, err)
+		return
+	}
+
+	if c.audit != nil {
+		if err := c.audit.Log("provider.created", nil, map[string]interface{}{"provider_id": profile.ID.String(), "name": profile.Name, "client_registered": true}, r); err != nil {
+			log.Printf("audit: failed to log provider.created for provider_id=%v: %v", profile.ID, err)
+		}
+	}
+
+	httputil.WriteJSON(w, http.StatusCreated, map[string]interface{}{
+		"id":           profile.ID,
+		"message":      "Provider profile created successfully",
+		"client_id":    registered.ClientID,
+		"registration": reg,
+	})
+}
+
+// GetRegistration handles GET /providers/{id}/registration, describing the
+// client registered for the provider. The registration access token is
+// never returned.
+func (c *ClientRegistrar) GetRegistration(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		httputil.WriteError(w, http.StatusBadRequest, "invalid_provider_id", "Invalid provider ID")
+		return
+	}
+	reg, err := c.store.GetRegistration(id)
+	if err != nil {
+		writeGetRegistrationError(w, err)
+		return
+	}
+	httputil.WriteJSON(w, http.StatusOK, reg)
+}
+
+// Rotate handles POST /providers/{id}/registration/rotate. It updates the
+// provider's client registration through the client configuration endpoint
+// and stores whatever new client secret and registration access token the
+// provider issues.
+func (c *ClientRegistrar) Rotate(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		httputil.WriteError(w, http.StatusBadRequest, "invalid_provider_id", "Invalid provider ID")
+		return
+	}
+	reg, err := c.store.GetRegistration(id)
+	if err != nil {
+		writeGetRegistrationError(w, err)
+		return
+	}
+	if reg.ClientURI == "" || reg.AccessToken == "" {
+		httputil.WriteError(w, http.StatusConflict, "registration_not_manageable", "The provider did not issue a client configuration endpoint for this client")
+		return
+	}
+	p, err := c.store.GetProfile(id)
+	if err != nil {
+		httputil.WriteError(w, http.StatusNotFound, "provider_not_found", "Provider not found")
+		return
+	}
+
+	client, err := c.clients.client(p.CABundle, registrationTimeout)
+	if err != nil {
+		httputil.WriteError(w, http.StatusInternalServerError, "provider_config_failed", "Failed to load provider config")
+		return
+	}
+	rotated, err := registration.Rotate(r.Context(), client, reg.ClientURI, reg.AccessToken)
+	if err != nil {
+		writeRegistrationError(w, err)
+		return
+	}
+
+	secretRotated := p.ClientSecret == nil || rotated.ClientSecret != *p.ClientSecret
+	reg.ClientURI = rotated.RegistrationClientURI
+	reg.AccessToken = rotated.RegistrationAccessToken
+	reg.ClientSecretExpiresAt = secretExpiry(rotated.ClientSecretExpiresAt)
+	if err := c.store.RotateRegistration(reg, rotated.ClientID, rotated.ClientSecret); err != nil {
+		if errors.Is(err, provider.ErrNotFound) {
+			httputil.WriteError(w, http.StatusNotFound, "provider_not_found", "Provider not found")
+			return
+		}
+		// The provider may already have invalidated the old credentials.
+		log.Printf("registration: provider %s rotated its client but storing the new credentials failed: %v", id, err)
+		httputil.WriteError(w, http.StatusInternalServerError, "rotate_failed", "Failed to store the rotated client credentials")
+		return
+	}
+
+	if c.audit != nil {
+		if err := c.audit.Log("provider.client_rotated", nil, map[string]interface{}{"provider_id": id.String(), "secret_rotated": secretRotated}, r); err != nil {
+			log.Printf("audit: failed to log provider.client_rotated for provider_id=%v: %v", id, err)
+		}
+	}
+
+	httputil.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"client_id":      rotated.ClientID,
+		"secret_rotated": secretRotated,
+		"registration":   reg,
+	})
+}
+
+// clientMetadata is the client metadata registered for p: the broker's
+// callback as the only redirect URI, the authorization code and refresh
+// token grants, the profile's scopes and the token endpoint authentication
+// the broker uses for it, overridden by extra.
+func (c *ClientRegistrar) clientMetadata(p *provider.Profile, extra map[string]interface{}) map[string]interface{} {
+	authMethod := "client_secret_post"
+	if strings.EqualFold(p.AuthHeader, "client_secret_basic") || strings.EqualFold(p.AuthHeader, "Basic") {
+		authMethod = "client_secret_basic"
+	}
+	metadata := map[string]interface{}{
+		"client_name":                p.Name,
+		"redirect_uris":              []string{c.redirectURI},
+		"grant_types":                []string{"authorization_code", "refresh_token"},
+		"response_types":             []string{"code"},
+		"token_endpoint_auth_method": authMethod,
+	}
+	if len(p.Scopes) > 0 {
+		metadata["scope"] = strings.Join(p.Scopes, " ")
+	}
+	for k, v := range extra {
+		metadata[k] = v
+	}
+	return metadata
+}
+
+// discoverEndpoint returns the registration endpoint advertised by the
+// profile's issuer, or "" if there is none.
+func (c *ClientRegistrar) discoverEndpoint(ctx context.Context, p *provider.Profile) string {
+	issuer := derefString(p.Issuer)
+	if issuer == "" {
+		return ""
+	}
+	client, err := c.clients.discoveryClient(p.CABundle)
+	if err != nil {
+		return ""
+	}
+	md, err := discovery.Discover(ctx, client, discovery.Hint{Issuer: issuer})
+	if err != nil {
+		log.Printf("registration: discovery for %s failed: %v", issuer, err)
+		return ""
+	}
+	return md.RegistrationEndpoint
+}
+
+// checkURLs writes a 400 unsafe_provider_url for the first URL the guard
+// rejects and reports whether all passed.
+func (c *ClientRegistrar) checkURLs(w http.ResponseWriter, r *http.Request, urls map[string]string) bool {
+	if c.guard == nil {
+		return true
+	}
+	for field, u := range urls {
+		if err := c.guard.ValidateURL(r.Context(), u); err != nil {
+			httputil.WriteError(w, http.StatusBadRequest, "unsafe_provider_url", field+": "+err.Error())
+			return false
+		}
+	}
+	return true
+}
+
+// deregister removes a client that could not be used. Failures are logged
+// only; the client is then left registered at the provider.
+func (c *ClientRegistrar) deregister(ctx context.Context, client *http.Client, registered *registration.Client) {
+	if registered.RegistrationClientURI == "" || registered.RegistrationAccessToken == "" {
+		log.Printf("registration: client %s cannot be deregistered; remove it at the provider", registered.ClientID)
+		return
+	}
+	if err := registration.Delete(ctx, client, registered.RegistrationClientURI, registered.RegistrationAccessToken); err != nil {
+		log.Printf("registration: failed to deregister client %s: %v", registered.ClientID, err)
+	}
+}
+
+// secretExpiry converts client_secret_expires_at, where 0 means never.
+func secretExpiry(expiresAt int64) *time.Time {
+	if expiresAt <= 0 {
+		return nil
+	}
+	t := time.Unix(expiresAt, 0).UTC()
+	return &t
+}
+
+func writeRegistrationError(w http.ResponseWriter, err error) {
+	msg := "The provider could not be reached"
+	var regErr *registration.Error
+	if errors.As(err, &regErr) {
+		msg = regErr.Error()
+	}
+	httputil.WriteError(w, http.StatusBadGateway, "client_registration_failed", msg)
+}
+
+func writeGetRegistrationError(w http.ResponseWriter, err error) {
+	if errors.Is(err, provider.ErrNotFound) {
+		httputil.WriteError(w, http.StatusNotFound, "registration_not_found", "The provider's client was not registered by the broker")
+		return
+	}
+	httputil.WriteError(w, http.StatusInternalServerError, "get_failed", "Failed to load the client registration")
+}
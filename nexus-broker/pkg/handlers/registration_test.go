@@ -0,0 +1,202 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/provider"
+)
+
+func (m *MockStore) RegisterProfileWithClient(profileJSON string, reg *provider.Registration) (*provider.Profile, error) {
+	args := m.Called(profileJSON, reg)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*provider.Profile), args.Error(1)
+}
+
+func (m *MockStore) GetRegistration(providerID uuid.UUID) (*provider.Registration, error) {
+	args := m.Called(providerID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*provider.Registration), args.Error(1)
+}
+
+func (m *MockStore) RotateRegistration(reg *provider.Registration, clientID, clientSecret string) error {
+	args := m.Called(reg, clientID, clientSecret)
+	return args.Error(0)
+}
+
+// withURLParam sets the chi route parameter key on req.
+func withURLParam(req *http.Request, key, value string) *http.Request {
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add(key, value)
+	return req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+}
+
+// fakeRegistrationServer answers registrations with client c-1 and counts
+// the deregistrations it receives.
+func fakeRegistrationServer(t *testing.T, got *map[string]interface{}, deleted *int) *httptest.Server {
+	t.Helper()
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			_ = json.NewDecoder(r.Body).Decode(got)
+			w.WriteHeader(http.StatusCreated)
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{
+				"client_id": "c-1", "client_secret": "s-1",
+				"registration_access_token": "rat-1", "registration_client_uri": srv.URL + "/register/c-1",
+			})
+		case http.MethodDelete:
+			*deleted++
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func newTestRegistrar(store provider.RegistrationStorer) *ClientRegistrar {
+	return NewClientRegistrar(ClientRegistrarConfig{
+		Store:        store,
+		BaseURL:      "https://broker.example",
+		RedirectPath: "/auth/callback",
+		HTTPClient:   http.DefaultClient,
+	})
+}
+
+func registrationBody(endpoint string) []byte {
+	body, _ := json.Marshal(map[string]interface{}{
+		"profile": map[string]interface{}{
+			"name":      "acme",
+			"auth_type": "oauth2",
+			"auth_url":  "https://idp.example/authorize",
+			"token_url": "https://idp.example/token",
+			"scopes":    []string{"openid", "email"},
+		},
+		"registration": map[string]interface{}{
+			"endpoint": endpoint,
+			"metadata": map[string]interface{}{"logo_uri": "https://example.com/logo.png"},
+		},
+	})
+	return body
+}
+
+func TestRegisterProvider_DynamicClientRegistration(t *testing.T) {
+	var sent map[string]interface{}
+	deleted := 0
+	idp := fakeRegistrationServer(t, &sent, &deleted)
+
+	mockStore := new(MockStore)
+	id := uuid.New()
+	mockStore.On("RegisterProfileWithClient", mock.AnythingOfType("string"), mock.AnythingOfType("*provider.Registration")).
+		Return(&provider.Profile{ID: id, Name: "acme"}, nil)
+	handler := NewProvidersHandler(mockStore, nil, nil).WithRegistrar(newTestRegistrar(mockStore))
+
+	rr := httptest.NewRecorder()
+	handler.Register(rr, httptest.NewRequest("POST", "/providers", bytes.NewReader(registrationBody(idp.URL))))
+
+	require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+	assert.Equal(t, []interface{}{"https://broker.example/auth/callback"}, sent["redirect_uris"])
+	assert.Equal(t, "openid email", sent["scope"])
+	assert.Equal(t, "client_secret_post", sent["token_endpoint_auth_method"])
+	assert.Equal(t, "https://example.com/logo.png", sent["logo_uri"])
+
+	profileJSON := mockStore.Calls[0].Arguments.String(0)
+	reg := mockStore.Calls[0].Arguments.Get(1).(*provider.Registration)
+	var profile map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(profileJSON), &profile))
+	assert.Equal(t, "c-1", profile["client_id"])
+	assert.Equal(t, "s-1", profile["client_secret"])
+	assert.Equal(t, "rat-1", reg.AccessToken)
+	assert.Equal(t, idp.URL+"/register/c-1", reg.ClientURI)
+	assert.NotContains(t, rr.Body.String(), "rat-1")
+	assert.Zero(t, deleted)
+}
+
+func TestRegisterProvider_DynamicClientRegistrationDeregistersOnStoreError(t *testing.T) {
+	var sent map[string]interface{}
+	deleted := 0
+	idp := fakeRegistrationServer(t, &sent, &deleted)
+
+	mockStore := new(MockStore)
+	mockStore.On("RegisterProfileWithClient", mock.Anything, mock.Anything).
+		Return(nil, errors.New("name: provider with name 'acme' already exists"))
+	handler := NewProvidersHandler(mockStore, nil, nil).WithRegistrar(newTestRegistrar(mockStore))
+
+	rr := httptest.NewRecorder()
+	handler.Register(rr, httptest.NewRequest("POST", "/providers", bytes.NewReader(registrationBody(idp.URL))))
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Equal(t, 1, deleted)
+}
+
+func TestRegisterProvider_DynamicClientRegistrationRejectsClientID(t *testing.T) {
+	mockStore := new(MockStore)
+	handler := NewProvidersHandler(mockStore, nil, nil).WithRegistrar(newTestRegistrar(mockStore))
+
+	body, _ := json.Marshal(map[string]interface{}{
+		"profile":      map[string]interface{}{"name": "acme", "client_id": "preset"},
+		"registration": map[string]interface{}{"endpoint": "https://idp.example/register"},
+	})
+	rr := httptest.NewRecorder()
+	handler.Register(rr, httptest.NewRequest("POST", "/providers", bytes.NewReader(body)))
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	mockStore.AssertNotCalled(t, "RegisterProfileWithClient", mock.Anything, mock.Anything)
+}
+
+func TestRotateRegistration(t *testing.T) {
+	idp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			_, _ = w.Write([]byte(`{"client_id": "c-1", "client_secret": "s-1", "redirect_uris": ["https://broker.example/auth/callback"]}`))
+		case http.MethodPut:
+			_, _ = w.Write([]byte(`{"client_id": "c-1", "client_secret": "s-2", "registration_access_token": "rat-2"}`))
+		}
+	}))
+	defer idp.Close()
+
+	id := uuid.New()
+	mockStore := new(MockStore)
+	mockStore.On("GetRegistration", id).Return(&provider.Registration{ProviderID: id, ClientURI: idp.URL + "/register/c-1", AccessToken: "rat-1"}, nil)
+	mockStore.On("GetProfile", id).Return(&provider.Profile{ID: id, Name: "acme", ClientSecret: ptr("s-1")}, nil)
+	mockStore.On("RotateRegistration", mock.AnythingOfType("*provider.Registration"), "c-1", "s-2").Return(nil)
+	registrar := newTestRegistrar(mockStore)
+
+	rr := httptest.NewRecorder()
+	registrar.Rotate(rr, withURLParam(httptest.NewRequest("POST", "/providers/"+id.String()+"/registration/rotate", nil), "id", id.String()))
+
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	reg := mockStore.Calls[2].Arguments.Get(0).(*provider.Registration)
+	assert.Equal(t, "rat-2", reg.AccessToken)
+	var resp map[string]interface{}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	assert.Equal(t, true, resp["secret_rotated"])
+	assert.NotContains(t, rr.Body.String(), "s-2")
+}
+
+func TestRotateRegistration_NotRegistered(t *testing.T) {
+	id := uuid.New()
+	mockStore := new(MockStore)
+	mockStore.On("GetRegistration", id).Return(nil, provider.ErrNotFound)
+
+	rr := httptest.NewRecorder()
+	newTestRegistrar(mockStore).Rotate(rr, withURLParam(httptest.NewRequest("POST", "/", nil), "id", id.String()))
+
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	assert.Contains(t, rr.Body.String(), "registration_not_found")
+}
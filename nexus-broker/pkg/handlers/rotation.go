@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/internal/store"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/connstate"
+)
+
+// Where a reused refresh token was seen, as reported in the source label of
+// oauth_refresh_token_reuse_total.
+const (
+	// reuseStored is a rotated token found in the connection's token row,
+	// e.g. restored from a backup or written back by an attacker.
+	reuseStored = "stored"
+	// reuseProvider is a rotated token handed out again by the provider.
+	reuseProvider = "provider"
+)
+
+// CompromisedConnection is the data of a connection.compromised webhook.
+type CompromisedConnection struct {
+	ConnectionID string    `json:"connection_id"`
+	WorkspaceID  string    `json:"workspace_id"`
+	ProviderID   string    `json:"provider_id"`
+	Source       string    `json:"source"`
+	Generation   int       `json:"generation"`
+	RotatedAt    time.Time `json:"rotated_at"`
+	DetectedAt   time.Time `json:"detected_at"`
+}
+
+// refreshTokenHash identifies a refresh token in refresh_token_generations
+// without storing it. It is keyed so the table alone cannot be used to
+// confirm a guessed token.
+func (h *CallbackHandler) refreshTokenHash(token string) string {
+	mac := hmac.New(sha256.New, h.encryptionKey)
+	mac.Write([]byte(token))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// recordRefreshToken makes the refresh token in tokens, if any, the
+// connection's current generation. Failures are logged only: tracking must
+// not break consent or refresh.
+func (h *CallbackHandler) recordRefreshToken(ctx context.Context, connectionID uuid.UUID, tokens map[string]interface{}) {
+	rt, _ := tokens["refresh_token"].(string)
+	if rt == "" {
+		return
+	}
+	if _, err := h.store.RecordRefreshToken(ctx, connectionID, h.refreshTokenHash(rt)); err != nil {
+		log.Printf("connection %s: record refresh token generation: %v", connectionID, err)
+	}
+}
+
+// rotatedGeneration returns the generation of token if it has already been
+// rotated out, or nil. Lookup errors are logged and treated as no reuse.
+func (h *CallbackHandler) rotatedGeneration(ctx context.Context, connectionID uuid.UUID, token string) *store.RefreshGeneration {
+	g, err := h.store.FindRefreshToken(ctx, connectionID, h.refreshTokenHash(token))
+	if err != nil {
+		if err != store.ErrNotFound {
+			log.Printf("connection %s: look up refresh token generation: %v", connectionID, err)
+		}
+		return nil
+	}
+	if g.RotatedAt == nil {
+		return nil
+	}
+	return g
+}
+
+// markCompromised moves the connection to compromised, raises the alarm
+// through the audit log, metrics and webhook, and returns the answer to
+// the refresh that found the reuse.
+func (h *CallbackHandler) markCompromised(ctx context.Context, r *http.Request, conn *store.Connection, source string, g *store.RefreshGeneration) *refreshError {
+	h.metricRefreshReuse.WithLabelValues(source).Inc()
+	h.updateConnectionStatus(ctx, conn.ID, connstate.StateCompromised)
+	log.Printf("SECURITY: connection %s: refresh token generation %d reused after rotation (%s)", conn.ID, g.Generation, source)
+
+	event := CompromisedConnection{
+		ConnectionID: conn.ID.String(),
+		WorkspaceID:  conn.WorkspaceID,
+		ProviderID:   conn.ProviderID.String(),
+		Source:       source,
+		Generation:   g.Generation,
+		RotatedAt:    g.RotatedAt.UTC(),
+		DetectedAt:   time.Now().UTC(),
+	}
+	h.logAuditEvent(&conn.ID, "refresh_token_reuse_detected", map[string]string{
+		"severity":   "high",
+		"source":     source,
+		"generation": strconv.Itoa(g.Generation),
+		"rotated_at": event.RotatedAt.Format(time.RFC3339),
+	}, r)
+	if err := h.webhook.Send(ctx, "connection.compromised", event); err != nil {
+		log.Printf("refresh: %v", err)
+	}
+
+	return &refreshError{status: http.StatusConflict, code: "connection_compromised",
+		message: "A refresh token for this connection was reused after rotation. The connection is locked; revoke it and ask the user to consent again."}
+}
@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/internal/audit"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/internal/store"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/internal/webhook"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/connstate"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/vault"
+)
+
+// rotationFixture is a connection whose provider rotates refresh tokens,
+// issuing rt-1, rt-2, ... on successive refreshes unless issue overrides it.
+type rotationFixture struct {
+	st      *store.Memory
+	handler *CallbackHandler
+	key     []byte
+	issued  int
+	issue   func(n int) string
+	events  []string
+}
+
+func newRotationFixture(t *testing.T) *rotationFixture {
+	f := &rotationFixture{st: store.NewMemory(), key: []byte("01234567890123456789012345678901")}
+	f.issue = func(n int) string { return fmt.Sprintf("rt-%d", n) }
+
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.issued++
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token":  fmt.Sprintf("at-%d", f.issued),
+			"refresh_token": f.issue(f.issued),
+		})
+	}))
+	t.Cleanup(tokenServer.Close)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Event string `json:"event"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		f.events = append(f.events, body.Event)
+	}))
+	t.Cleanup(hook.Close)
+
+	f.handler = NewCallbackHandler(CallbackHandlerConfig{
+		Store:         f.st,
+		Audit:         audit.NewServiceWithStore(f.st),
+		EncryptionKey: f.key,
+		States:        testStates(t, f.key),
+		HTTPClient:    tokenServer.Client(),
+		Webhook:       webhook.New(hook.URL, nil, hook.Client()),
+	})
+	seedConnection(f.st, refreshConnectionID, connstate.StateActive, store.Provider{
+		AuthType: "oauth2", TokenURL: tokenServer.URL, ClientID: "id", ClientSecret: "secret",
+	})
+	// The consent stored generation 1.
+	require.NoError(t, f.handler.storeTokens(context.Background(), refreshConnectionID, "ws-1",
+		map[string]interface{}{"access_token": "at-0", "refresh_token": "rt-0"}))
+	return f
+}
+
+func (f *rotationFixture) refresh() *httptest.ResponseRecorder {
+	rr := httptest.NewRecorder()
+	f.handler.Refresh(rr, httptest.NewRequest("POST", "/connections/"+refreshConnectionID.String()+"/refresh", nil))
+	return rr
+}
+
+func (f *rotationFixture) status(t *testing.T) connstate.State {
+	c, err := f.st.GetConnection(context.Background(), refreshConnectionID)
+	require.NoError(t, err)
+	return c.Status
+}
+
+func TestRefresh_RotationIsTrackedPerGeneration(t *testing.T) {
+	f := newRotationFixture(t)
+
+	require.Equal(t, http.StatusOK, f.refresh().Code)
+	require.Equal(t, http.StatusOK, f.refresh().Code)
+
+	g, err := f.st.FindRefreshToken(context.Background(), refreshConnectionID, f.handler.refreshTokenHash("rt-2"))
+	require.NoError(t, err)
+	assert.Equal(t, 3, g.Generation)
+	assert.Nil(t, g.RotatedAt)
+	g, err = f.st.FindRefreshToken(context.Background(), refreshConnectionID, f.handler.refreshTokenHash("rt-0"))
+	require.NoError(t, err)
+	assert.NotNil(t, g.RotatedAt)
+	assert.Equal(t, connstate.StateActive, f.status(t))
+}
+
+func TestRefresh_StoredRotatedTokenMarksCompromised(t *testing.T) {
+	f := newRotationFixture(t)
+	require.Equal(t, http.StatusOK, f.refresh().Code)
+
+	// The token row is rolled back to the rotated rt-0.
+	sealed, err := vault.SealToken(f.key, []byte(`{"refresh_token":"rt-0"}`), refreshConnectionID.String(), "ws-1")
+	require.NoError(t, err)
+	require.NoError(t, f.st.SaveTokens(context.Background(), refreshConnectionID, sealed, nil))
+
+	rr := f.refresh()
+	assert.Equal(t, http.StatusConflict, rr.Code)
+	assert.Contains(t, rr.Body.String(), "connection_compromised")
+	assert.Equal(t, 1, f.issued, "the rotated token must not be sent to the provider")
+	assert.Equal(t, connstate.StateCompromised, f.status(t))
+	assert.Equal(t, []string{"connection.compromised"}, f.events)
+
+	events, err := f.st.ListAuditEvents(context.Background(), store.AuditFilter{EventType: "refresh_token_reuse_detected"})
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Contains(t, *events[0].EventData, `"severity":"high"`)
+	assert.Contains(t, *events[0].EventData, `"source":"stored"`)
+
+	// Tokens are withheld from a compromised connection.
+	rr = httptest.NewRecorder()
+	f.handler.GetToken(rr, httptest.NewRequest("GET", "/connections/"+refreshConnectionID.String()+"/token", nil))
+	assert.Equal(t, http.StatusConflict, rr.Code)
+	assert.Contains(t, rr.Body.String(), "connection_compromised")
+}
+
+func TestRefresh_ProviderReissuingRotatedTokenMarksCompromised(t *testing.T) {
+	f := newRotationFixture(t)
+	require.Equal(t, http.StatusOK, f.refresh().Code)
+
+	// The provider hands back generation 1's token.
+	f.issue = func(int) string { return "rt-0" }
+	rr := f.refresh()
+	assert.Equal(t, http.StatusConflict, rr.Code)
+	assert.Equal(t, connstate.StateCompromised, f.status(t))
+	assert.Equal(t, []string{"connection.compromised"}, f.events)
+}
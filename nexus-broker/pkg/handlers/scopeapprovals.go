@@ -0,0 +1,213 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/internal/audit"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/internal/store"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/httputil"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/scopepolicy"
+)
+
+// errScopePolicy is a provider whose scope_policy no longer parses.
+var errScopePolicy = errors.New("invalid scope policy")
+
+// applyScopePolicy checks scopes against the provider's scope policy, if
+// it has one, and returns them with the policy's required scopes added. A
+// refusal is a *scopepolicy.Violation.
+func (h *ConsentHandler) applyScopePolicy(ctx context.Context, provider *store.Provider, workspaceID string, scopes []string) ([]string, error) {
+	policy, err := scopepolicy.Parse(provider.Params)
+	if err != nil {
+		log.Printf("scope policy of provider %s: %v", provider.ID, err)
+		return nil, errScopePolicy
+	}
+	if policy == nil {
+		return scopes, nil
+	}
+	var approved []string
+	if len(policy.Approval) > 0 {
+		a, err := h.store.GetScopeApprovals(ctx, workspaceID, provider.ID)
+		switch {
+		case err == nil:
+			approved = a.Scopes
+		case !errors.Is(err, store.ErrNotFound):
+			return nil, fmt.Errorf("scope approvals: %w", err)
+		}
+	}
+	return policy.Apply(scopes, approved)
+}
+
+// scopePolicyError is the answer to a consent refused by
+// applyScopePolicy: 400 for scopes the policy refuses, 403 for scopes
+// awaiting an administrator's approval. details.scopes lists the scopes at
+// fault.
+func scopePolicyError(err error) *Error {
+	var v *scopepolicy.Violation
+	switch {
+	case errors.As(err, &v):
+		status := http.StatusBadRequest
+		if v.Code == scopepolicy.CodeApprovalRequired {
+			status = http.StatusForbidden
+		}
+		return &Error{Status: status, Code: v.Code, Message: v.Error(), Details: map[string]interface{}{"scopes": v.Scopes}}
+	case errors.Is(err, errScopePolicy):
+		return &Error{Status: http.StatusInternalServerError, Code: "provider_config_failed", Message: "The provider's scope_policy is invalid"}
+	default:
+		log.Printf("scope policy: %v", err)
+		return &Error{Status: http.StatusInternalServerError, Code: "scope_check_failed", Message: "Failed to check the scope policy"}
+	}
+}
+
+// ScopeApprovalsHandler serves the admin API for the scopes approved per
+// workspace and provider.
+type ScopeApprovalsHandler struct {
+	store scopeApprovalsStore
+	audit audit.Logger
+}
+
+type scopeApprovalsStore interface {
+	store.ScopeApprovalStore
+	GetProvider(ctx context.Context, id uuid.UUID) (*store.Provider, error)
+}
+
+// NewScopeApprovalsHandler creates a ScopeApprovalsHandler.
+func NewScopeApprovalsHandler(st store.Store, auditLogger audit.Logger) *ScopeApprovalsHandler {
+	return &ScopeApprovalsHandler{store: st, audit: auditLogger}
+}
+
+// scopeApprovalsView is the body of the scope approval endpoints.
+type scopeApprovalsView struct {
+	WorkspaceID string `json:"workspace_id"`
+	ProviderID  string `json:"provider_id"`
+	// Scopes are the approved scopes and patterns.
+	Scopes []string `json:"scopes"`
+	// RequiresApproval are the patterns of the provider's scope policy
+	// needing approval.
+	RequiresApproval []string   `json:"requires_approval"`
+	UpdatedAt        *time.Time `json:"updated_at,omitempty"`
+}
+
+// Get handles GET /admin/workspaces/{workspaceID}/providers/{providerID}/scope-approvals.
+func (h *ScopeApprovalsHandler) Get(w http.ResponseWriter, r *http.Request) {
+	workspaceID, provider, ok := h.target(w, r)
+	if !ok {
+		return
+	}
+	h.writeView(w, r, workspaceID, provider)
+}
+
+// Put handles PUT /admin/workspaces/{workspaceID}/providers/{providerID}/scope-approvals,
+// replacing the approved scopes with those of the body:
+// {"scopes": ["https://www.googleapis.com/auth/drive"]}.
+func (h *ScopeApprovalsHandler) Put(w http.ResponseWriter, r *http.Request) {
+	workspaceID, provider, ok := h.target(w, r)
+	if !ok {
+		return
+	}
+	var body struct {
+		Scopes []string `json:"scopes"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		httputil.WriteError(w, http.StatusBadRequest, "invalid_json", "Invalid JSON")
+		return
+	}
+	if err := scopepolicy.ValidApprovals(body.Scopes); err != nil {
+		httputil.WriteError(w, http.StatusBadRequest, "invalid_scopes", err.Error())
+		return
+	}
+	scopes := body.Scopes
+	if scopes == nil {
+		scopes = []string{}
+	}
+	err := h.store.SetScopeApprovals(r.Context(), &store.ScopeApprovals{WorkspaceID: workspaceID, ProviderID: provider.ID, Scopes: scopes})
+	if err != nil {
+		log.Printf("scope approvals: set workspace=%s provider=%s: %v", workspaceID, provider.ID, err)
+		httputil.WriteError(w, http.StatusInternalServerError, "update_failed", "Failed to save scope approvals")
+		return
+	}
+	h.logChange("scope_approvals.updated", workspaceID, provider.ID, scopes, r)
+	h.writeView(w, r, workspaceID, provider)
+}
+
+// Delete handles DELETE /admin/workspaces/{workspaceID}/providers/{providerID}/scope-approvals,
+// withdrawing every approval. Existing connections keep their scopes.
+func (h *ScopeApprovalsHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	workspaceID, provider, ok := h.target(w, r)
+	if !ok {
+		return
+	}
+	if err := h.store.DeleteScopeApprovals(r.Context(), workspaceID, provider.ID); err != nil {
+		log.Printf("scope approvals: delete workspace=%s provider=%s: %v", workspaceID, provider.ID, err)
+		httputil.WriteError(w, http.StatusInternalServerError, "delete_failed", "Failed to delete scope approvals")
+		return
+	}
+	h.logChange("scope_approvals.deleted", workspaceID, provider.ID, nil, r)
+	h.writeView(w, r, workspaceID, provider)
+}
+
+// target reads the workspace and provider of the path, answering the
+// request if they are invalid.
+func (h *ScopeApprovalsHandler) target(w http.ResponseWriter, r *http.Request) (string, *store.Provider, bool) {
+	workspaceID := strings.TrimSpace(chi.URLParam(r, "workspaceID"))
+	if workspaceID == "" {
+		httputil.WriteError(w, http.StatusBadRequest, "invalid_request", "workspace ID is required")
+		return "", nil, false
+	}
+	providerID, err := uuid.Parse(chi.URLParam(r, "providerID"))
+	if err != nil {
+		httputil.WriteError(w, http.StatusBadRequest, "invalid_provider_id", "Invalid provider ID")
+		return "", nil, false
+	}
+	provider, err := h.store.GetProvider(r.Context(), providerID)
+	if err != nil {
+		httputil.WriteError(w, http.StatusNotFound, "provider_not_found", "Provider not found")
+		return "", nil, false
+	}
+	return workspaceID, provider, true
+}
+
+func (h *ScopeApprovalsHandler) writeView(w http.ResponseWriter, r *http.Request, workspaceID string, provider *store.Provider) {
+	view := scopeApprovalsView{
+		WorkspaceID:      workspaceID,
+		ProviderID:       provider.ID.String(),
+		Scopes:           []string{},
+		RequiresApproval: []string{},
+	}
+	if policy, err := scopepolicy.Parse(provider.Params); err == nil && policy != nil && policy.Approval != nil {
+		view.RequiresApproval = policy.Approval
+	}
+	a, err := h.store.GetScopeApprovals(r.Context(), workspaceID, provider.ID)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		log.Printf("scope approvals: get workspace=%s provider=%s: %v", workspaceID, provider.ID, err)
+		httputil.WriteError(w, http.StatusInternalServerError, "get_failed", "Failed to load scope approvals")
+		return
+	}
+	if a != nil {
+		view.Scopes = a.Scopes
+		view.UpdatedAt = &a.UpdatedAt
+	}
+	httputil.WriteJSON(w, http.StatusOK, view)
+}
+
+func (h *ScopeApprovalsHandler) logChange(event, workspaceID string, providerID uuid.UUID, scopes []string, r *http.Request) {
+	if h.audit == nil {
+		return
+	}
+	data := map[string]interface{}{"workspace_id": workspaceID, "provider_id": providerID.String()}
+	if scopes != nil {
+		data["scopes"] = scopes
+	}
+	if err := h.audit.Log(event, nil, data, r); err != nil {
+		log.Printf("audit: failed to log %s (workspace_id=%s): %v", event, workspaceID, err)
+	}
+}
@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/internal/store"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/connstate"
+)
+
+// policyProvider is an OAuth2 provider with a scope policy.
+func policyProvider() store.Provider {
+	params := json.RawMessage(`{"scope_policy": {
+		"allowed": ["openid", "email", "repo:*"],
+		"denied": ["repo:delete"],
+		"required": ["openid"],
+		"approval": ["repo:write"]
+	}}`)
+	return store.Provider{
+		ID:       uuid.New(),
+		AuthType: "oauth2",
+		AuthURL:  "https://provider.example/auth",
+		ClientID: "client-id",
+		Params:   &params,
+	}
+}
+
+func TestGetSpec_ScopePolicy(t *testing.T) {
+	st := store.NewMemory()
+	handler := NewConsentHandler(ConsentHandlerConfig{
+		Store: st, BaseURL: "http://localhost:8080", RedirectPath: "/auth/callback", States: testStates(t, []byte("test-key")),
+	})
+	provider := policyProvider()
+	st.PutProvider(provider)
+
+	getSpec := func(scopes ...string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(map[string]interface{}{
+			"workspace_id": "ws-1", "provider_id": provider.ID.String(), "scopes": scopes, "return_url": "http://localhost:3000/callback",
+		})
+		rr := httptest.NewRecorder()
+		handler.GetSpec(rr, httptest.NewRequest("POST", "/auth/consent-spec", bytes.NewReader(body)))
+		return rr
+	}
+	errorOf := func(rr *httptest.ResponseRecorder) (string, []interface{}) {
+		var body struct {
+			Error   string                 `json:"error"`
+			Details map[string]interface{} `json:"details"`
+		}
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &body))
+		scopes, _ := body.Details["scopes"].([]interface{})
+		return body.Error, scopes
+	}
+
+	rr := getSpec("email", "repo:read")
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	var spec ConsentSpec
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &spec))
+	assert.Equal(t, []string{"email", "repo:read", "openid"}, spec.Scopes, "required scopes are added")
+	assert.Contains(t, spec.AuthURL, "scope=email+repo%3Aread+openid")
+
+	rr = getSpec("repo:delete", "calendar")
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	code, scopes := errorOf(rr)
+	assert.Equal(t, "scope_denied", code)
+	assert.Equal(t, []interface{}{"repo:delete"}, scopes)
+
+	rr = getSpec("calendar")
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	code, _ = errorOf(rr)
+	assert.Equal(t, "scope_not_allowed", code)
+
+	rr = getSpec("repo:write")
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+	code, scopes = errorOf(rr)
+	assert.Equal(t, "scope_approval_required", code)
+	assert.Equal(t, []interface{}{"repo:write"}, scopes)
+
+	// Approval holds for the workspace it was given to only.
+	require.NoError(t, st.SetScopeApprovals(context.Background(), &store.ScopeApprovals{WorkspaceID: "ws-1", ProviderID: provider.ID, Scopes: []string{"repo:write"}}))
+	rr = getSpec("repo:write")
+	assert.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	require.NoError(t, st.SetScopeApprovals(context.Background(), &store.ScopeApprovals{WorkspaceID: "ws-2", ProviderID: provider.ID, Scopes: []string{"repo:write"}}))
+	require.NoError(t, st.DeleteScopeApprovals(context.Background(), "ws-1", provider.ID))
+	rr = getSpec("repo:write")
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+}
+
+func TestReauthorize_ScopePolicy(t *testing.T) {
+	st := store.NewMemory()
+	key := []byte("01234567890123456789012345678901")
+	handler := NewConsentHandler(ConsentHandlerConfig{
+		Store: st, BaseURL: "http://localhost:8080", RedirectPath: "/auth/callback", States: testStates(t, key),
+	})
+	seedConnection(st, reauthConnectionID, connstate.StateActive, policyProvider())
+
+	rr := reauthorize(handler, `{"scopes": ["repo:delete"]}`)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), "scope_denied")
+
+	rr = reauthorize(handler, `{"scopes": ["repo:read"]}`)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	var spec ConsentSpec
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &spec))
+	assert.Equal(t, []string{"repo:read", "openid"}, spec.Scopes)
+}
+
+func TestScopeApprovalsAdminAPI(t *testing.T) {
+	st := store.NewMemory()
+	provider := policyProvider()
+	st.PutProvider(provider)
+	h := NewScopeApprovalsHandler(st, nil)
+	r := chi.NewRouter()
+	r.Get("/admin/workspaces/{workspaceID}/providers/{providerID}/scope-approvals", h.Get)
+	r.Put("/admin/workspaces/{workspaceID}/providers/{providerID}/scope-approvals", h.Put)
+	r.Delete("/admin/workspaces/{workspaceID}/providers/{providerID}/scope-approvals", h.Delete)
+	path := "/admin/workspaces/ws-1/providers/" + provider.ID.String() + "/scope-approvals"
+
+	call := func(method, path, body string) (*httptest.ResponseRecorder, scopeApprovalsView) {
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, httptest.NewRequest(method, path, bytes.NewBufferString(body)))
+		var view scopeApprovalsView
+		_ = json.Unmarshal(rr.Body.Bytes(), &view)
+		return rr, view
+	}
+
+	rr, view := call("GET", path, "")
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	assert.Empty(t, view.Scopes)
+	assert.Equal(t, []string{"repo:write"}, view.RequiresApproval)
+	assert.Nil(t, view.UpdatedAt)
+
+	rr, view = call("PUT", path, `{"scopes": ["repo:write"]}`)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	assert.Equal(t, []string{"repo:write"}, view.Scopes)
+	assert.NotNil(t, view.UpdatedAt)
+	a, err := st.GetScopeApprovals(context.Background(), "ws-1", provider.ID)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"repo:write"}, a.Scopes)
+
+	rr, _ = call("PUT", path, `{"scopes": [""]}`)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	rr, _ = call("GET", "/admin/workspaces/ws-1/providers/"+uuid.NewString()+"/scope-approvals", "")
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	rr, view = call("DELETE", path, "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Empty(t, view.Scopes)
+	_, err = st.GetScopeApprovals(context.Background(), "ws-1", provider.ID)
+	assert.ErrorIs(t, err, store.ErrNotFound)
+}
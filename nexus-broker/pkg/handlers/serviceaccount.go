@@ -0,0 +1,187 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/internal/store"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/httputil"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/server"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/serviceaccount"
+)
+
+// authTypeServiceAccount is the auth_type of providers holding a Google
+// service account key. Their connections get tokens minted for the
+// connection's delegated user instead of a user's consent.
+const authTypeServiceAccount = "service_account"
+
+// delegatePath completes the consent of a service account connection.
+const delegatePath = "/auth/delegate"
+
+// delegatedTokenURL returns the token endpoint of a service account
+// provider: its token_url, else the one in its key.
+func delegatedTokenURL(provider *store.Provider, key *serviceaccount.Key) string {
+	if provider.TokenURL != "" {
+		return provider.TokenURL
+	}
+	if key.TokenURI != "" {
+		return key.TokenURI
+	}
+	return serviceaccount.DefaultTokenURL
+}
+
+// mintDelegatedToken requests a token for conn's scopes from the token
+// endpoint of a service account provider, acting as conn's delegated user
+// (its Email). It returns the provider's status code alongside any error.
+func (h *CallbackHandler) mintDelegatedToken(ctx context.Context, provider *store.Provider, conn *store.Connection) (map[string]interface{}, int, error) {
+	key, err := serviceaccount.FromParams(provider.Params)
+	if err != nil {
+		return nil, 0, fmt.Errorf("service account key: %w", err)
+	}
+	pol := h.tokenPolicy(provider)
+	client, err := h.clients.client(provider.CABundle, pol.timeout)
+	if err != nil {
+		return nil, 0, err
+	}
+	tokenURL := delegatedTokenURL(provider, key)
+	assertion, err := key.Assertion(conn.Email, conn.Scopes, tokenURL, time.Now())
+	if err != nil {
+		return nil, 0, err
+	}
+	data := url.Values{}
+	data.Set("grant_type", serviceaccount.GrantType)
+	data.Set("assertion", assertion)
+
+	req, err := http.NewRequestWithContext(ctx, "POST", tokenURL, strings.NewReader(data.Encode()))
+	if err != nil {
+		return nil, 0, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := sendTokenRequest(ctx, client, req, pol, grantJWTBearer)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, resp.StatusCode, fmt.Errorf("token request failed: %s", readTokenError(resp))
+	}
+	tokens, err := decodeTokenResponse(resp)
+	if err != nil {
+		return nil, resp.StatusCode, err
+	}
+	return tokens, resp.StatusCode, nil
+}
+
+// Delegate handles GET /auth/delegate, the authUrl of a service account
+// connection. Nobody is asked for consent: the first token is minted for
+// the delegated user right away, and the caller redirected to return_url as
+// after an OAuth consent. A server-side request completes it as well as a
+// browser.
+func (h *CallbackHandler) Delegate(w http.ResponseWriter, r *http.Request) {
+	stateData, err := h.states.Verify(r.URL.Query().Get("state"))
+	if err != nil {
+		h.logAuditEvent(nil, "state_verification_failed", map[string]string{"error": err.Error()}, r)
+		httputil.WriteError(w, http.StatusBadRequest, "invalid_state", "Invalid state")
+		return
+	}
+	connectionID, err := uuid.Parse(stateData.Nonce)
+	if err != nil {
+		httputil.WriteError(w, http.StatusBadRequest, "invalid_connection_id", "Invalid connection ID")
+		return
+	}
+	connection, err := h.store.GetPendingConnection(r.Context(), connectionID)
+	if err != nil {
+		h.logAuditEvent(&connectionID, "connection_not_found", map[string]string{"error": err.Error()}, r)
+		httputil.WriteError(w, http.StatusNotFound, "connection_not_found", "Connection not found or expired")
+		return
+	}
+	provider, err := h.store.GetProvider(r.Context(), connection.ProviderID)
+	if err != nil {
+		h.logAuditEvent(&connectionID, "provider_not_found", map[string]string{"error": err.Error()}, r)
+		httputil.WriteError(w, http.StatusInternalServerError, "provider_not_found", "Provider not found")
+		return
+	}
+	if provider.AuthType != authTypeServiceAccount {
+		httputil.WriteError(w, http.StatusBadRequest, "unsupported_auth_type", "Only service account connections are delegated")
+		return
+	}
+
+	tokens, status, err := h.mintDelegatedToken(r.Context(), provider, connection)
+	if err != nil {
+		h.logAuditEvent(&connectionID, "delegation_failed", map[string]string{"error": err.Error(), "status_code": fmt.Sprintf("%d", status), "subject": connection.Email}, r)
+		var limited *ProviderRateLimitedError
+		if errors.As(err, &limited) {
+			h.endFailedFlow(r.Context(), connectionID, false, "provider_rate_limited")
+			providerRateLimitedError(err).Write(w)
+			return
+		}
+		h.endFailedFlow(r.Context(), connectionID, false, "delegation_failed")
+		httputil.WriteError(w, http.StatusBadGateway, "delegation_failed", "The provider refused a token for the delegated user")
+		return
+	}
+	if err := h.storeTokens(r.Context(), connectionID, connection.WorkspaceID, tokens); err != nil {
+		h.logAuditEvent(&connectionID, "token_storage_failed", map[string]string{"error": err.Error()}, r)
+		httputil.WriteError(w, http.StatusInternalServerError, "token_store_failed", "Failed to store tokens")
+		return
+	}
+	result, err := h.completeConsent(r.Context(), r, connection, provider)
+	if err != nil {
+		result = "success"
+		h.logAuditEvent(&connectionID, "status_update_failed", map[string]string{"error": err.Error()}, r)
+	}
+	h.logAuditEvent(&connectionID, "delegation_completed", map[string]string{"provider_id": connection.ProviderID.String(), "subject": connection.Email}, r)
+
+	if !server.IsReturnURLAllowed(connection.ReturnURL, h.enforceReturnURL, h.allowedReturnDomains) {
+		httputil.WriteError(w, http.StatusBadRequest, "return_url_not_allowed", "return_url not allowed")
+		return
+	}
+	returnURL, err := url.Parse(connection.ReturnURL)
+	if err != nil {
+		h.logAuditEvent(&connectionID, "invalid_return_url", map[string]string{"error": err.Error(), "return_url": connection.ReturnURL}, r)
+		httputil.WriteError(w, http.StatusInternalServerError, "invalid_return_url", "Invalid return_url")
+		return
+	}
+	query := returnURL.Query()
+	query.Set("status", result)
+	query.Set("connection_id", connectionID.String())
+	query.Set("provider", provider.Name)
+	returnURL.RawQuery = query.Encode()
+
+	http.Redirect(w, r, returnURL.String(), http.StatusFound)
+}
+
+// refreshDelegated mints a new token for a service account connection and
+// stores it. There is no refresh token: the assertion is signed anew. A
+// rejected request, typically delegation revoked by the domain's
+// administrator, needs attention like a rejected refresh token.
+func (h *CallbackHandler) refreshDelegated(ctx context.Context, r *http.Request, conn *store.Connection, provider *store.Provider) (map[string]interface{}, *refreshError) {
+	connectionID := conn.ID
+	tokens, statusCode, err := h.mintDelegatedToken(ctx, provider, conn)
+	if err != nil {
+		class := refreshErrorClass(statusCode)
+		if class == refreshClassRejected {
+			h.logAuditEvent(&connectionID, "token_refresh_fatal", map[string]string{"error": err.Error(), "status_code": fmt.Sprintf("%d", statusCode), "provider_id": conn.ProviderID.String()}, r)
+			h.recordRefreshFailure(ctx, r, conn, class, statusCode, err)
+			return nil, &refreshError{status: http.StatusConflict, code: "attention_required",
+				message: "The provider refused a token for the delegated user. Check the service account's domain-wide delegation."}
+		}
+		h.logAuditEvent(&connectionID, "token_refresh_failed", map[string]string{"error": err.Error(), "status_code": fmt.Sprintf("%d", statusCode), "provider_id": conn.ProviderID.String()}, r)
+		h.recordRefreshFailure(ctx, r, conn, class, statusCode, err)
+		return nil, &refreshError{status: http.StatusBadGateway, code: "upstream_error", message: err.Error(), err: err}
+	}
+	if err := h.storeTokens(ctx, connectionID, conn.WorkspaceID, tokens); err != nil {
+		return nil, &refreshError{status: http.StatusInternalServerError, code: "token_store_failed", message: "Store refreshed token failed"}
+	}
+	h.clearRefreshFailure(ctx, r, conn)
+	return tokens, nil
+}
@@ -0,0 +1,207 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/internal/store"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/connstate"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/serviceaccount"
+	"github.com/Prescott-Data/nexus-framework/nexus-common/state"
+)
+
+// fakeGoogleToken is a token endpoint accepting JWT bearer assertions
+// signed with key. It refuses delegation to users not in allowed.
+type fakeGoogleToken struct {
+	*httptest.Server
+	key     *rsa.PrivateKey
+	allowed map[string]bool
+	// claims are those of the assertions received.
+	claims []map[string]interface{}
+}
+
+func newFakeGoogleToken(t *testing.T) *fakeGoogleToken {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	f := &fakeGoogleToken{key: key, allowed: map[string]bool{"jane@example.com": true}}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.FormValue("grant_type") != serviceaccount.GrantType {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "unsupported_grant_type"})
+			return
+		}
+		jws, err := jose.ParseSigned(r.FormValue("assertion"), []jose.SignatureAlgorithm{jose.RS256})
+		require.NoError(t, err)
+		payload, err := jws.Verify(&key.PublicKey)
+		require.NoError(t, err)
+		var claims map[string]interface{}
+		require.NoError(t, json.Unmarshal(payload, &claims))
+		f.claims = append(f.claims, claims)
+		if sub, _ := claims["sub"].(string); !f.allowed[sub] {
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{"error": "unauthorized_client", "error_description": "Client is unauthorized to retrieve access tokens using this method"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"access_token": "ya29.delegated", "token_type": "Bearer", "expires_in": 3599})
+	}))
+	t.Cleanup(f.Close)
+	return f
+}
+
+// provider returns a service account provider whose key f trusts.
+func (f *fakeGoogleToken) provider(t *testing.T) store.Provider {
+	der, err := x509.MarshalPKCS8PrivateKey(f.key)
+	require.NoError(t, err)
+	keyFile, err := json.Marshal(map[string]string{
+		"type":         "service_account",
+		"client_email": "automation@project.iam.gserviceaccount.com",
+		"private_key":  string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+	})
+	require.NoError(t, err)
+	params, err := json.Marshal(map[string]string{serviceaccount.KeyParam: string(keyFile)})
+	require.NoError(t, err)
+	raw := json.RawMessage(params)
+	return store.Provider{ID: uuid.New(), Name: "google-workspace", AuthType: "service_account", TokenURL: f.URL, Params: &raw}
+}
+
+func TestServiceAccount_DelegatesToSubject(t *testing.T) {
+	google := newFakeGoogleToken(t)
+	st := store.NewMemory()
+	key := []byte("01234567890123456789012345678901")
+	states := testStates(t, key)
+	consent := NewConsentHandler(ConsentHandlerConfig{
+		Store: st, BaseURL: "http://localhost:8080", RedirectPath: "/auth/callback", States: states,
+	})
+	callback := NewCallbackHandler(CallbackHandlerConfig{
+		Store: st, BaseURL: "http://localhost:8080", RedirectPath: "/auth/callback", EncryptionKey: key, States: states,
+	})
+	provider := google.provider(t)
+	st.PutProvider(provider)
+
+	body, _ := json.Marshal(map[string]interface{}{
+		"workspace_id": "ws-1",
+		"provider_id":  provider.ID.String(),
+		"scopes":       []string{"https://www.googleapis.com/auth/gmail.readonly"},
+		"return_url":   "http://localhost:3000/callback",
+		"subject":      "jane@example.com",
+	})
+	rr := httptest.NewRecorder()
+	consent.GetSpec(rr, httptest.NewRequest("POST", "/auth/consent-spec", bytes.NewReader(body)))
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	var spec ConsentSpec
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &spec))
+	authURL, err := url.Parse(spec.AuthURL)
+	require.NoError(t, err)
+	assert.Equal(t, "/auth/delegate", authURL.Path)
+
+	// Following the authUrl mints the first token; no user is involved.
+	rr = httptest.NewRecorder()
+	callback.Delegate(rr, httptest.NewRequest("GET", authURL.RequestURI(), nil))
+	require.Equal(t, http.StatusFound, rr.Code, rr.Body.String())
+	assert.Contains(t, rr.Header().Get("Location"), "status=success")
+	require.Len(t, google.claims, 1)
+	assert.Equal(t, "jane@example.com", google.claims[0]["sub"])
+	assert.Equal(t, "automation@project.iam.gserviceaccount.com", google.claims[0]["iss"])
+	assert.Equal(t, "https://www.googleapis.com/auth/gmail.readonly", google.claims[0]["scope"])
+	assert.Equal(t, google.URL, google.claims[0]["aud"])
+
+	data, err := states.Verify(spec.State)
+	require.NoError(t, err)
+	connectionID := uuid.MustParse(data.Nonce)
+	conn, err := st.GetConnection(context.Background(), connectionID)
+	require.NoError(t, err)
+	assert.Equal(t, connstate.StateActive, conn.Status)
+	assert.Equal(t, "jane@example.com", conn.Email)
+
+	rr = httptest.NewRecorder()
+	callback.GetToken(rr, httptest.NewRequest("GET", "/connections/"+connectionID.String()+"/token", nil))
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	var token map[string]interface{}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &token))
+	assert.Equal(t, "ya29.delegated", token["access_token"])
+	assert.Equal(t, map[string]interface{}{"type": "oauth2"}, token["strategy"])
+
+	// A refresh signs a new assertion for the same user.
+	rr = httptest.NewRecorder()
+	callback.Refresh(rr, httptest.NewRequest("POST", "/connections/"+connectionID.String()+"/refresh", nil))
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	require.Len(t, google.claims, 2)
+	assert.Equal(t, "jane@example.com", google.claims[1]["sub"])
+
+	// Once delegation is revoked the connection needs attention.
+	delete(google.allowed, "jane@example.com")
+	rr = httptest.NewRecorder()
+	callback.Refresh(rr, httptest.NewRequest("POST", "/connections/"+connectionID.String()+"/refresh", nil))
+	assert.Equal(t, http.StatusConflict, rr.Code)
+	assert.Contains(t, rr.Body.String(), "attention_required")
+	conn, err = st.GetConnection(context.Background(), connectionID)
+	require.NoError(t, err)
+	assert.Equal(t, connstate.StateNeedsReauth, conn.Status)
+}
+
+func TestServiceAccount_DelegationRefused(t *testing.T) {
+	google := newFakeGoogleToken(t)
+	st := store.NewMemory()
+	key := []byte("01234567890123456789012345678901")
+	states := testStates(t, key)
+	callback := NewCallbackHandler(CallbackHandlerConfig{
+		Store: st, BaseURL: "http://localhost:8080", RedirectPath: "/auth/callback", EncryptionKey: key, States: states,
+	})
+	provider := google.provider(t)
+	seedConnection(st, refreshConnectionID, connstate.StatePending, provider)
+	require.NoError(t, st.SetIdentity(context.Background(), refreshConnectionID, "", "mallory@example.com"))
+	signed, err := states.Sign(state.Data{Nonce: refreshConnectionID.String(), IAT: time.Now()})
+	require.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+	callback.Delegate(rr, httptest.NewRequest("GET", "/auth/delegate?state="+url.QueryEscape(signed), nil))
+	assert.Equal(t, http.StatusBadGateway, rr.Code)
+	assert.Contains(t, rr.Body.String(), "delegation_failed")
+	conn, err := st.GetConnection(context.Background(), refreshConnectionID)
+	require.NoError(t, err)
+	assert.Equal(t, connstate.StateFailed, conn.Status)
+}
+
+func TestGetSpec_RejectsSubject(t *testing.T) {
+	st := store.NewMemory()
+	handler := NewConsentHandler(ConsentHandlerConfig{
+		Store: st, BaseURL: "http://localhost:8080", RedirectPath: "/auth/callback", States: testStates(t, []byte("test-key")),
+	})
+	oauth := store.Provider{ID: uuid.New(), AuthType: "oauth2", AuthURL: "https://accounts.google.com/o/oauth2/v2/auth", ClientID: "id"}
+	sa := store.Provider{ID: uuid.New(), AuthType: "service_account"}
+	st.PutProvider(oauth)
+	st.PutProvider(sa)
+
+	for _, tc := range []struct {
+		provider uuid.UUID
+		subject  string
+		code     string
+	}{
+		{oauth.ID, "jane@example.com", "subject_not_supported"},
+		{sa.ID, "Jane <jane@example.com>", "invalid_subject"},
+	} {
+		body, _ := json.Marshal(map[string]interface{}{
+			"workspace_id": "ws-1", "provider_id": tc.provider.String(), "return_url": "http://localhost:3000/callback", "subject": tc.subject,
+		})
+		rr := httptest.NewRecorder()
+		handler.GetSpec(rr, httptest.NewRequest("POST", "/auth/consent-spec", bytes.NewReader(body)))
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		assert.Contains(t, rr.Body.String(), tc.code)
+	}
+}
@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/internal/store"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/connstate"
+	"github.com/Prescott-Data/nexus-framework/nexus-common/state"
+)
+
+// boundConsent starts a consent for providerID bound to sessionHash.
+func boundConsent(t *testing.T, consent *ConsentHandler, providerID uuid.UUID, sessionHash string) *httptest.ResponseRecorder {
+	t.Helper()
+	body, _ := json.Marshal(map[string]interface{}{
+		"workspace_id": "ws-1",
+		"provider_id":  providerID.String(),
+		"return_url":   "http://localhost:3000/callback",
+		"session_hash": sessionHash,
+	})
+	rr := httptest.NewRecorder()
+	consent.GetSpec(rr, httptest.NewRequest("POST", "/auth/consent-spec", bytes.NewReader(body)))
+	return rr
+}
+
+func newSessionHandlers(t *testing.T) (*ConsentHandler, *CallbackHandler, *store.Memory, *state.Keyring) {
+	t.Helper()
+	st := store.NewMemory()
+	key := []byte("01234567890123456789012345678901")
+	states := testStates(t, key)
+	consent := NewConsentHandler(ConsentHandlerConfig{
+		Store: st, BaseURL: "http://localhost:8080", RedirectPath: "/auth/callback", States: states,
+	})
+	callback := NewCallbackHandler(CallbackHandlerConfig{
+		Store: st, BaseURL: "http://localhost:8080", RedirectPath: "/auth/callback", EncryptionKey: key, States: states,
+	})
+	return consent, callback, st, states
+}
+
+func TestSessionBinding_Callback(t *testing.T) {
+	tokens := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"access_token": "at", "token_type": "Bearer", "expires_in": 3600}`)
+	}))
+	defer tokens.Close()
+	consent, callback, st, states := newSessionHandlers(t)
+	provider := store.Provider{
+		ID: uuid.New(), Name: "files", AuthType: "oauth2", ClientID: "client-id", ClientSecret: "secret",
+		AuthURL: "http://provider.invalid/authorize", TokenURL: tokens.URL,
+	}
+	st.PutProvider(provider)
+
+	session, err := state.NewSession()
+	require.NoError(t, err)
+	rr := boundConsent(t, consent, provider.ID, state.HashSession(session))
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	var spec ConsentSpec
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &spec))
+	data, err := states.Verify(spec.State)
+	require.NoError(t, err)
+	assert.Equal(t, state.HashSession(session), data.SessionHash)
+	connectionID := uuid.MustParse(data.Nonce)
+
+	complete := func(session string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/auth/callback?code=abc&state="+url.QueryEscape(spec.State), nil)
+		if session != "" {
+			req.Header.Set(state.SessionHeader, session)
+		}
+		rr := httptest.NewRecorder()
+		callback.Handle(rr, req)
+		return rr
+	}
+
+	// Another browser, with no session or another one, cannot complete the
+	// flow, and the flow stays open for the user.
+	other, _ := state.NewSession()
+	for _, s := range []string{"", other} {
+		rr = complete(s)
+		assert.Equal(t, http.StatusFound, rr.Code)
+		assert.Contains(t, rr.Header().Get("Location"), "code=session_mismatch")
+	}
+	conn, err := st.GetConnection(context.Background(), connectionID)
+	require.NoError(t, err)
+	assert.Equal(t, connstate.StatePending, conn.Status)
+
+	rr = complete(session)
+	require.Equal(t, http.StatusFound, rr.Code, rr.Body.String())
+	conn, err = st.GetConnection(context.Background(), connectionID)
+	require.NoError(t, err)
+	assert.Equal(t, connstate.StateActive, conn.Status)
+}
+
+func TestSessionBinding_SaveCredential(t *testing.T) {
+	consent, callback, st, states := newSessionHandlers(t)
+	provider := store.Provider{ID: uuid.New(), Name: "api", AuthType: "api_key"}
+	st.PutProvider(provider)
+
+	session, _ := state.NewSession()
+	rr := boundConsent(t, consent, provider.ID, state.HashSession(session))
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	var spec ConsentSpec
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &spec))
+
+	save := func(session string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(map[string]interface{}{"state": spec.State, "credentials": map[string]string{"api_key": "k"}})
+		req := httptest.NewRequest("POST", "/auth/capture-credential", bytes.NewReader(body))
+		req.Header.Set(state.SessionHeader, session)
+		rr := httptest.NewRecorder()
+		callback.SaveCredential(rr, req)
+		return rr
+	}
+	other, _ := state.NewSession()
+	rr = save(other)
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+	assert.Contains(t, rr.Body.String(), "session_mismatch")
+
+	rr = save(session)
+	assert.Equal(t, http.StatusFound, rr.Code, rr.Body.String())
+	data, err := states.Verify(spec.State)
+	require.NoError(t, err)
+	conn, err := st.GetConnection(context.Background(), uuid.MustParse(data.Nonce))
+	require.NoError(t, err)
+	assert.Equal(t, connstate.StateActive, conn.Status)
+}
+
+func TestSessionBinding_Refused(t *testing.T) {
+	consent, _, st, _ := newSessionHandlers(t)
+	oauth := store.Provider{ID: uuid.New(), Name: "files", AuthType: "oauth2", AuthURL: "http://provider.invalid/authorize"}
+	serviceAccount := store.Provider{ID: uuid.New(), Name: "google-sa", AuthType: authTypeServiceAccount}
+	st.PutProvider(oauth)
+	st.PutProvider(serviceAccount)
+
+	rr := boundConsent(t, consent, oauth.ID, "not-a-hash")
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), "invalid_session_hash")
+
+	session, _ := state.NewSession()
+	rr = boundConsent(t, consent, serviceAccount.ID, state.HashSession(session))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), "session_binding_unsupported")
+}
@@ -0,0 +1,165 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/internal/audit"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/internal/store"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/connstate"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/httputil"
+)
+
+// maxSuspendReason bounds the note an operator leaves on a suspension.
+const maxSuspendReason = 500
+
+// SuspensionView describes why and since when a connection is suspended.
+type SuspensionView struct {
+	Reason      string    `json:"reason"`
+	SuspendedBy string    `json:"suspended_by,omitempty"`
+	SuspendedAt time.Time `json:"suspended_at"`
+}
+
+// Suspend handles POST /connections/{connectionID}/suspend: the connection
+// moves to suspended and its tokens are withheld (423) until it is resumed,
+// without deleting them. The optional body {"reason": "..."} is reported
+// to callers refused a token. Suspending a suspended connection succeeds
+// without changes; only active connections can be suspended, others are a
+// 409.
+func (h *ConnectionsHandler) Suspend(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "connectionID"))
+	if err != nil {
+		httputil.WriteError(w, http.StatusBadRequest, "invalid_connection_id", "Invalid connection ID")
+		return
+	}
+	var body struct {
+		Reason string `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil && !errors.Is(err, io.EOF) {
+		httputil.WriteError(w, http.StatusBadRequest, "invalid_json", "Invalid JSON")
+		return
+	}
+	body.Reason = strings.TrimSpace(body.Reason)
+	if len(body.Reason) > maxSuspendReason {
+		httputil.WriteError(w, http.StatusBadRequest, "invalid_request", "reason must be at most 500 characters")
+		return
+	}
+	conn, err := h.store.GetConnection(r.Context(), id)
+	if errors.Is(err, store.ErrNotFound) {
+		httputil.WriteError(w, http.StatusNotFound, "connection_not_found", "Connection not found")
+		return
+	}
+	if err != nil {
+		log.Printf("connections: get %s: %v", id, err)
+		httputil.WriteError(w, http.StatusInternalServerError, "suspend_failed", "Failed to look up the connection")
+		return
+	}
+	previous := conn.Status
+	if previous != connstate.StateSuspended {
+		if !previous.CanTransition(connstate.StateSuspended) {
+			httputil.WriteError(w, http.StatusConflict, "invalid_transition", "A "+string(previous)+" connection cannot be suspended")
+			return
+		}
+		err := h.store.SuspendConnection(r.Context(), &store.Suspension{
+			ConnectionID: id,
+			Reason:       body.Reason,
+			SuspendedBy:  audit.CallerFrom(r.Context()),
+			SuspendedAt:  time.Now().UTC(),
+		})
+		var te *connstate.TransitionError
+		if errors.As(err, &te) {
+			httputil.WriteError(w, http.StatusConflict, "invalid_transition", "A "+string(te.From)+" connection cannot be suspended")
+			return
+		}
+		if err != nil {
+			log.Printf("connections: suspend %s: %v", id, err)
+			httputil.WriteError(w, http.StatusInternalServerError, "suspend_failed", "Failed to suspend the connection")
+			return
+		}
+		if h.audit != nil {
+			data := map[string]interface{}{"workspace_id": conn.WorkspaceID, "provider_id": conn.ProviderID.String(), "previous_status": string(previous), "reason": body.Reason}
+			if err := h.audit.Log("connection_suspended", &id, data, r); err != nil {
+				log.Printf("audit: failed to log connection_suspended (connection_id=%s): %v", id, err)
+			}
+		}
+	}
+	resp := map[string]interface{}{"previous_status": string(previous)}
+	if s, err := h.store.GetSuspension(r.Context(), id); err == nil {
+		resp["suspension"] = SuspensionView{Reason: s.Reason, SuspendedBy: s.SuspendedBy, SuspendedAt: s.SuspendedAt}
+	} else {
+		log.Printf("connections: get suspension %s: %v", id, err)
+	}
+	conn.Status = connstate.StateSuspended
+	resp["connection"] = summarizeConnection(*conn)
+	httputil.WriteJSON(w, http.StatusOK, resp)
+}
+
+// Resume handles POST /connections/{connectionID}/resume: a suspended
+// connection becomes active again with the tokens it had. Resuming an
+// active connection succeeds without changes; any other status is a 409.
+func (h *ConnectionsHandler) Resume(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "connectionID"))
+	if err != nil {
+		httputil.WriteError(w, http.StatusBadRequest, "invalid_connection_id", "Invalid connection ID")
+		return
+	}
+	conn, err := h.store.GetConnection(r.Context(), id)
+	if errors.Is(err, store.ErrNotFound) {
+		httputil.WriteError(w, http.StatusNotFound, "connection_not_found", "Connection not found")
+		return
+	}
+	if err != nil {
+		log.Printf("connections: get %s: %v", id, err)
+		httputil.WriteError(w, http.StatusInternalServerError, "resume_failed", "Failed to look up the connection")
+		return
+	}
+	previous := conn.Status
+	if previous != connstate.StateActive {
+		err := h.store.ResumeConnection(r.Context(), id)
+		var te *connstate.TransitionError
+		if errors.As(err, &te) {
+			httputil.WriteError(w, http.StatusConflict, "invalid_transition", "A "+string(te.From)+" connection cannot be resumed")
+			return
+		}
+		if err != nil {
+			log.Printf("connections: resume %s: %v", id, err)
+			httputil.WriteError(w, http.StatusInternalServerError, "resume_failed", "Failed to resume the connection")
+			return
+		}
+		if h.audit != nil {
+			data := map[string]interface{}{"workspace_id": conn.WorkspaceID, "provider_id": conn.ProviderID.String()}
+			if err := h.audit.Log("connection_resumed", &id, data, r); err != nil {
+				log.Printf("audit: failed to log connection_resumed (connection_id=%s): %v", id, err)
+			}
+		}
+	}
+	conn.Status = connstate.StateActive
+	httputil.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"connection":      summarizeConnection(*conn),
+		"previous_status": string(previous),
+	})
+}
+
+// suspendedError is the answer to a token request for a suspended
+// connection: 423 connection_suspended, with the operator's reason when it
+// is on record.
+func suspendedError(ctx context.Context, st store.ConnectionStore, id uuid.UUID) *Error {
+	details := map[string]interface{}{"reason": ""}
+	if s, err := st.GetSuspension(ctx, id); err == nil {
+		details["reason"] = s.Reason
+		details["suspended_at"] = s.SuspendedAt
+	} else if !errors.Is(err, store.ErrNotFound) {
+		log.Printf("connections: get suspension %s: %v", id, err)
+	}
+	return &Error{Status: http.StatusLocked, Code: "connection_suspended",
+		Message: "Connection is suspended; tokens are withheld until it is resumed", Details: details}
+}
@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/internal/audit"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/internal/store"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/connstate"
+)
+
+func TestSuspendAndResume(t *testing.T) {
+	f := newRefreshFailureFixture(t, 0)
+	h := NewConnectionsHandler(ConnectionsHandlerConfig{Store: f.st, Audit: audit.NewServiceWithStore(f.st)})
+	post := func(path, body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		connectionsRouter(h).ServeHTTP(rr, httptest.NewRequest("POST", path, strings.NewReader(body)))
+		return rr
+	}
+	token := func() *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		f.handler.GetToken(rr, httptest.NewRequest("GET", "/connections/"+refreshConnectionID.String()+"/token", nil))
+		return rr
+	}
+	id := refreshConnectionID.String()
+
+	rr := post("/connections/"+id+"/suspend", `{"reason":"security review"}`)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	assert.Contains(t, rr.Body.String(), `"previous_status":"active"`)
+	assert.Contains(t, rr.Body.String(), `"reason":"security review"`)
+	assert.Equal(t, connstate.StateSuspended, f.connStatus(t))
+
+	rr = token()
+	require.Equal(t, http.StatusLocked, rr.Code)
+	var apiErr struct {
+		Error   string                 `json:"error"`
+		Details map[string]interface{} `json:"details"`
+	}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &apiErr))
+	assert.Equal(t, "connection_suspended", apiErr.Error)
+	assert.Equal(t, "security review", apiErr.Details["reason"])
+	assert.Equal(t, http.StatusLocked, f.refresh().Code)
+
+	// Suspending again keeps the original suspension.
+	rr = post("/connections/"+id+"/suspend", `{"reason":"other"}`)
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"previous_status":"suspended"`)
+	assert.Contains(t, rr.Body.String(), `"reason":"security review"`)
+
+	rr = post("/connections/"+id+"/resume", "")
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	assert.Contains(t, rr.Body.String(), `"previous_status":"suspended"`)
+	assert.Equal(t, http.StatusOK, token().Code)
+	_, err := f.st.GetSuspension(context.Background(), refreshConnectionID)
+	assert.ErrorIs(t, err, store.ErrNotFound)
+
+	rr = post("/connections/"+id+"/resume", "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"previous_status":"active"`)
+
+	events, err := f.st.ListAuditEvents(context.Background(), store.AuditFilter{})
+	require.NoError(t, err)
+	var types []string
+	for _, e := range events {
+		if strings.HasPrefix(e.EventType, "connection_") {
+			types = append(types, e.EventType)
+		}
+	}
+	assert.ElementsMatch(t, []string{"connection_suspended", "connection_resumed"}, types)
+}
+
+func TestSuspend_Errors(t *testing.T) {
+	st := store.NewMemory()
+	h := NewConnectionsHandler(ConnectionsHandlerConfig{Store: st})
+	post := func(path, body string) int {
+		rr := httptest.NewRecorder()
+		connectionsRouter(h).ServeHTTP(rr, httptest.NewRequest("POST", path, strings.NewReader(body)))
+		return rr.Code
+	}
+	pending := seedUserConnection(t, st, "ws-1", connstate.StatePending, "", "").String()
+	active := seedUserConnection(t, st, "ws-1", connstate.StateActive, "", "").String()
+
+	assert.Equal(t, http.StatusConflict, post("/connections/"+pending+"/suspend", ""))
+	assert.Equal(t, http.StatusConflict, post("/connections/"+pending+"/resume", ""))
+	assert.Equal(t, http.StatusNotFound, post("/connections/"+uuid.NewString()+"/suspend", ""))
+	assert.Equal(t, http.StatusNotFound, post("/connections/"+uuid.NewString()+"/resume", ""))
+	assert.Equal(t, http.StatusBadRequest, post("/connections/not-a-uuid/suspend", ""))
+	assert.Equal(t, http.StatusBadRequest, post("/connections/"+active+"/suspend", `{"reason":`))
+	assert.Equal(t, http.StatusBadRequest, post("/connections/"+active+"/suspend", `{"reason":"`+strings.Repeat("x", 501)+`"}`))
+}
@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/redact"
+)
+
+// Limits on what a provider's token endpoint may return. Real responses
+// are a few kilobytes with a dozen fields; anything far beyond that is a
+// misbehaving or hostile endpoint and is rejected rather than stored.
+const (
+	maxTokenResponseBytes = 1 << 20
+	maxTokenFields        = 64
+	// maxTokenErrorBytes bounds how much of an error response is quoted
+	// in the returned error (and so in audit events and logs).
+	maxTokenErrorBytes = 4 << 10
+)
+
+// decodeTokenResponse reads a successful token endpoint response into the
+// token map. The body must be at most maxTokenResponseBytes and decode to
+// at most maxTokenFields fields with a non-empty string access_token. A
+// JSON object is accepted under application/json, any +json type, or a
+// missing, text/plain or text/javascript type (sent by some providers).
+// application/x-www-form-urlencoded bodies, which GitHub returns when a
+// client omits Accept, are converted by formTokens. Any other content type
+// is rejected by name. An OAuth error in a 200 response is returned as an
+// error.
+func decodeTokenResponse(resp *http.Response) (map[string]interface{}, error) {
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxTokenResponseBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("read token response: %w", err)
+	}
+	if len(body) > maxTokenResponseBytes {
+		return nil, fmt.Errorf("token response exceeds %d bytes", maxTokenResponseBytes)
+	}
+
+	contentType := resp.Header.Get("Content-Type")
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	var tokens map[string]interface{}
+	switch {
+	case mediaType == "application/x-www-form-urlencoded":
+		if tokens, err = formTokens(body); err != nil {
+			return nil, err
+		}
+	case mediaType == "application/json", strings.HasSuffix(mediaType, "+json"),
+		mediaType == "", mediaType == "text/plain", mediaType == "text/javascript":
+		if !bytes.HasPrefix(bytes.TrimSpace(body), []byte("{")) {
+			return nil, fmt.Errorf("token response is not a JSON object (content type %q)", contentType)
+		}
+		if err := json.Unmarshal(body, &tokens); err != nil {
+			return nil, fmt.Errorf("token response is not a JSON object: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported token response content type %q", contentType)
+	}
+
+	if len(tokens) > maxTokenFields {
+		return nil, fmt.Errorf("token response has %d fields, more than %d", len(tokens), maxTokenFields)
+	}
+	if code, _ := tokens["error"].(string); code != "" {
+		desc, _ := tokens["error_description"].(string)
+		return nil, fmt.Errorf("token endpoint returned error %s: %s", code, desc)
+	}
+	if at, ok := tokens["access_token"].(string); !ok || at == "" {
+		return nil, fmt.Errorf("token response has no access_token")
+	}
+	return tokens, nil
+}
+
+// formTokens converts a form-encoded token response into the map a JSON
+// response would have produced: one string per field, except that
+// expires_in and other numeric lifetimes become float64 like
+// encoding/json's numbers, so expiry handling works the same.
+func formTokens(body []byte) (map[string]interface{}, error) {
+	values, err := url.ParseQuery(string(body))
+	if err != nil {
+		return nil, fmt.Errorf("token response is not valid form encoding: %w", err)
+	}
+	tokens := make(map[string]interface{}, len(values))
+	for k, v := range values {
+		if len(v) == 0 {
+			continue
+		}
+		tokens[k] = v[0]
+		if k == "expires_in" || strings.HasSuffix(k, "_expires_in") {
+			if n, err := strconv.ParseFloat(v[0], 64); err == nil {
+				tokens[k] = n
+			}
+		}
+	}
+	return tokens, nil
+}
+
+// readTokenError returns the start of an error response body, with any
+// credentials the provider echoed back redacted, for use in an error message.
+func readTokenError(resp *http.Response) string {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxTokenErrorBytes))
+	return redact.String(string(body))
+}
@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func tokenResponse(contentType, body string) *http.Response {
+	h := http.Header{}
+	if contentType != "" {
+		h.Set("Content-Type", contentType)
+	}
+	return &http.Response{StatusCode: http.StatusOK, Header: h, Body: io.NopCloser(strings.NewReader(body))}
+}
+
+func TestDecodeTokenResponse(t *testing.T) {
+	manyFields := make([]string, maxTokenFields+1)
+	for i := range manyFields {
+		manyFields[i] = `"f` + strings.Repeat("x", i) + `": 1`
+	}
+
+	cases := []struct {
+		name, contentType, body, wantErr string
+	}{
+		{name: "json", contentType: "application/json; charset=utf-8", body: `{"access_token":"a","expires_in":3600}`},
+		{name: "vendor json", contentType: "application/vnd.api+json", body: `{"access_token":"a"}`},
+		{name: "json as text", contentType: "text/plain", body: ` {"access_token":"a"}`},
+		{name: "no content type", body: `{"access_token":"a"}`},
+		{name: "html", contentType: "text/html", body: `<html>`, wantErr: `unsupported token response content type "text/html"`},
+		{name: "form encoded", contentType: "application/x-www-form-urlencoded; charset=utf-8", body: `access_token=a&scope=repo%2Cuser&token_type=bearer`},
+		{name: "form encoded error", contentType: "application/x-www-form-urlencoded", body: `error=bad_verification_code&error_description=The+code+is+incorrect`, wantErr: "bad_verification_code: The code is incorrect"},
+		{name: "json error with 200", contentType: "application/json", body: `{"error":"bad_verification_code"}`, wantErr: "returned error bad_verification_code"},
+		{name: "xml", contentType: "application/xml", body: `<OAuth/>`, wantErr: `unsupported token response content type "application/xml"`},
+		{name: "text not json", contentType: "text/plain", body: `access_token=a`, wantErr: "not a JSON object"},
+		{name: "array", contentType: "application/json", body: `[1,2]`, wantErr: "not a JSON object"},
+		{name: "no access token", contentType: "application/json", body: `{"token_type":"bearer"}`, wantErr: "no access_token"},
+		{name: "non-string access token", contentType: "application/json", body: `{"access_token":42}`, wantErr: "no access_token"},
+		{name: "too many fields", contentType: "application/json", body: `{"access_token":"a",` + strings.Join(manyFields, ",") + `}`, wantErr: "more than 64"},
+		{name: "too large", contentType: "application/json", body: `{"access_token":"` + strings.Repeat("a", maxTokenResponseBytes) + `"}`, wantErr: "exceeds"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			tokens, err := decodeTokenResponse(tokenResponse(c.contentType, c.body))
+			if c.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), c.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, "a", tokens["access_token"])
+		})
+	}
+}
+
+func TestDecodeTokenResponse_FormMatchesJSONShape(t *testing.T) {
+	tokens, err := decodeTokenResponse(tokenResponse("application/x-www-form-urlencoded",
+		"access_token=gho_abc&expires_in=28800&refresh_token=ghr_def&refresh_token_expires_in=15897600&scope=&token_type=bearer"))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"access_token":             "gho_abc",
+		"expires_in":               float64(28800),
+		"refresh_token":            "ghr_def",
+		"refresh_token_expires_in": float64(15897600),
+		"scope":                    "",
+		"token_type":               "bearer",
+	}, tokens)
+}
+
+func TestReadTokenError_Truncates(t *testing.T) {
+	msg := readTokenError(tokenResponse("text/plain", strings.Repeat("e", 2*maxTokenErrorBytes)))
+	assert.Len(t, msg, maxTokenErrorBytes)
+}
@@ -0,0 +1,328 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/internal/ratelimit"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/internal/store"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/config"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/dpop"
+)
+
+// Grant types, as reported in the grant label of the token request metrics.
+const (
+	grantAuthorizationCode = "authorization_code"
+	grantRefreshToken      = "refresh_token"
+	grantTokenExchange     = "token_exchange"
+	grantJWTBearer         = "jwt_bearer"
+)
+
+// maxTokenBackoff caps the delay between two token request attempts.
+const maxTokenBackoff = 5 * time.Second
+
+var (
+	tokenRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "oauth_token_request_duration_seconds",
+		Help:    "Duration of token endpoint attempts by provider, grant and outcome",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"provider", "grant", "outcome"})
+	tokenRequestRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "oauth_token_request_retries_total",
+		Help: "Token endpoint attempts retried after a network error or 5xx, by provider and grant",
+	}, []string{"provider", "grant"})
+	tokenRequestsThrottled = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "oauth_token_requests_throttled_total",
+		Help: "Token endpoint attempts delayed or rejected by the provider's token_rate_limit, by provider, grant and outcome",
+	}, []string{"provider", "grant", "outcome"})
+)
+
+func init() {
+	prometheus.MustRegister(tokenRequestDuration, tokenRequestRetries, tokenRequestsThrottled)
+}
+
+// defaultTokenRateMaxWait is how long a token request waits for a slot
+// under its provider's token_rate_limit before it is rejected, unless the
+// provider sets token_rate_max_wait.
+const defaultTokenRateMaxWait = 5 * time.Second
+
+// tokenPolicy is how token requests to one provider are timed, retried and
+// paced: the deployment's config.TokenRequestConfig with the provider's
+// token_timeout, token_max_retries and token_rate_* params applied.
+type tokenPolicy struct {
+	provider   string
+	timeout    time.Duration
+	maxRetries int
+	backoff    time.Duration
+
+	// rate paces attempts to the provider's token endpoint, shared by
+	// every replica through limiter under rateKey. A zero rate.Rate or nil
+	// limiter does not pace.
+	rate    ratelimit.Limit
+	maxWait time.Duration
+	rateKey string
+	limiter ratelimit.Limiter
+
+	// dpop, if set, signs a DPoP proof into every attempt. It is the
+	// connection's key, so it is set per request rather than per provider.
+	dpop *dpop.Key
+}
+
+// tokenPolicyFor applies p's overrides to the handler defaults. token_timeout
+// and token_rate_max_wait are duration strings ("10s") or numbers of
+// seconds; token_max_retries and token_rate_burst non-negative integers and
+// token_rate_limit requests per second, as numbers or numeric strings.
+// Invalid values are ignored.
+func tokenPolicyFor(defaults config.TokenRequestConfig, p *store.Provider) tokenPolicy {
+	pol := tokenPolicy{
+		provider:   p.Name,
+		timeout:    defaults.Timeout,
+		maxRetries: defaults.MaxRetries,
+		backoff:    defaults.Backoff,
+		maxWait:    defaultTokenRateMaxWait,
+		rateKey:    "token:" + p.ID.String(),
+	}
+	if pol.timeout <= 0 {
+		pol.timeout = 30 * time.Second
+	}
+	if p.Params == nil {
+		return pol
+	}
+	var params map[string]interface{}
+	if err := json.Unmarshal(*p.Params, &params); err != nil {
+		return pol
+	}
+	if d, ok := durationParam(params["token_timeout"]); ok && d > 0 {
+		pol.timeout = d
+	}
+	if n, ok := numberParam(params["token_max_retries"]); ok && n >= 0 {
+		pol.maxRetries = int(n)
+	}
+	if n, ok := numberParam(params["token_rate_limit"]); ok && n > 0 {
+		pol.rate.Rate = n
+		pol.rate.Burst = int(math.Ceil(n))
+	}
+	if n, ok := numberParam(params["token_rate_burst"]); ok && n >= 1 {
+		pol.rate.Burst = int(n)
+	}
+	if d, ok := durationParam(params["token_rate_max_wait"]); ok && d >= 0 {
+		pol.maxWait = d
+	}
+	return pol
+}
+
+// tokenPolicy returns the policy for token requests to p, paced by the
+// handler's provider limiter.
+func (h *CallbackHandler) tokenPolicy(p *store.Provider) tokenPolicy {
+	pol := tokenPolicyFor(h.tokenRequests, p)
+	pol.limiter = h.providerLimiter
+	return pol
+}
+
+// durationParam reads a provider param given as a duration string or a
+// number of seconds.
+func durationParam(v interface{}) (time.Duration, bool) {
+	switch v := v.(type) {
+	case string:
+		d, err := time.ParseDuration(v)
+		return d, err == nil
+	case float64:
+		return time.Duration(v * float64(time.Second)), true
+	}
+	return 0, false
+}
+
+// numberParam reads a provider param given as a number or numeric string.
+func numberParam(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case string:
+		n, err := strconv.ParseFloat(v, 64)
+		return n, err == nil
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+// ProviderRateLimitedError is returned for a token request that would have
+// waited longer than the provider's token_rate_max_wait for a slot under
+// its token_rate_limit. The request was not sent.
+type ProviderRateLimitedError struct {
+	Provider   string
+	RetryAfter time.Duration
+}
+
+func (e *ProviderRateLimitedError) Error() string {
+	return fmt.Sprintf("token endpoint of provider %q is rate limited by the broker; retry in %s", e.Provider, e.RetryAfter.Round(time.Millisecond))
+}
+
+// providerRateLimitedError is the answer 503 provider_rate_limited, with
+// Retry-After, if err is a *ProviderRateLimitedError, and nil otherwise.
+func providerRateLimitedError(err error) *Error {
+	var limited *ProviderRateLimitedError
+	if !errors.As(err, &limited) {
+		return nil
+	}
+	return &Error{Status: http.StatusServiceUnavailable, Code: "provider_rate_limited",
+		Message:    "The provider's token endpoint is busy; the broker is pacing requests to stay under its rate limit. Retry after the Retry-After delay",
+		Details:    map[string]interface{}{"provider": limited.Provider, "retry_after_ms": limited.RetryAfter.Milliseconds()},
+		RetryAfter: ratelimit.RetryAfter(limited.RetryAfter)}
+}
+
+// pace waits for a slot under pol.rate, or returns a
+// *ProviderRateLimitedError if none is free within pol.maxWait. When the
+// limiter fails the request goes ahead unpaced.
+func (pol tokenPolicy) pace(ctx context.Context, grant string) error {
+	if pol.rate.Rate <= 0 || pol.limiter == nil {
+		return nil
+	}
+	delay, ok, err := pol.limiter.Reserve(ctx, pol.rateKey, pol.rate, pol.maxWait)
+	if err != nil {
+		log.Printf("token rate limit for %s unavailable, not pacing: %v", pol.provider, err)
+		return nil
+	}
+	if !ok {
+		tokenRequestsThrottled.WithLabelValues(pol.provider, grant, "rejected").Inc()
+		return &ProviderRateLimitedError{Provider: pol.provider, RetryAfter: delay}
+	}
+	if delay <= 0 {
+		return nil
+	}
+	tokenRequestsThrottled.WithLabelValues(pol.provider, grant, "delayed").Inc()
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// sendTokenRequest sends req, retrying with jittered exponential backoff
+// while attempts remain. Every attempt is paced by pol.rate first. A network error is retried for any grant. A 5xx
+// is retried only for refresh and assertions: an authorization code may
+// already have been redeemed by a request that failed after reaching the
+// provider, and redeeming it twice can revoke the tokens it issued. The caller closes
+// the returned response's body.
+func sendTokenRequest(ctx context.Context, client *http.Client, req *http.Request, pol tokenPolicy, grant string) (*http.Response, error) {
+	resp, err := sendTokenAttempts(ctx, client, req, pol, grant, "")
+	if err != nil || pol.dpop == nil {
+		return resp, err
+	}
+	// A provider that wants a nonce in DPoP proofs refuses the first
+	// request with use_dpop_nonce and issues one (RFC 9449 section 8).
+	if nonce, ok := dpopNonceChallenge(resp); ok {
+		resp.Body.Close()
+		return sendTokenAttempts(ctx, client, req, pol, grant, nonce)
+	}
+	return resp, nil
+}
+
+// sendTokenAttempts sends req until an attempt succeeds or must not be
+// retried, with nonce in the DPoP proof of each attempt if pol.dpop is set.
+func sendTokenAttempts(ctx context.Context, client *http.Client, req *http.Request, pol tokenPolicy, grant, nonce string) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		if err := pol.pace(ctx, grant); err != nil {
+			return nil, err
+		}
+		try := req.Clone(ctx)
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			try.Body = body
+		}
+		if pol.dpop != nil {
+			// Each attempt needs a proof of its own: providers reject a
+			// proof whose jti they have seen.
+			proof, err := pol.dpop.Proof(try.Method, try.URL.String(), nonce, time.Now())
+			if err != nil {
+				return nil, err
+			}
+			try.Header.Set(dpop.Header, proof)
+		}
+
+		start := time.Now()
+		resp, err := client.Do(try)
+		outcome := "ok"
+		retryable := false
+		switch {
+		case err != nil:
+			outcome, retryable = "network_error", true
+		case resp.StatusCode >= 500:
+			outcome, retryable = "5xx", grant == grantRefreshToken || grant == grantJWTBearer
+		case resp.StatusCode >= 400:
+			outcome = "4xx"
+		}
+		tokenRequestDuration.WithLabelValues(pol.provider, grant, outcome).Observe(time.Since(start).Seconds())
+
+		if !retryable || attempt >= pol.maxRetries || ctx.Err() != nil {
+			return resp, err
+		}
+		if resp != nil {
+			resp.Body.Close()
+		}
+		tokenRequestRetries.WithLabelValues(pol.provider, grant).Inc()
+
+		t := time.NewTimer(tokenBackoff(pol.backoff, attempt))
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return nil, ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// tokenBackoff returns a random delay in [0, base*2^attempt), capped at
+// maxTokenBackoff ("full jitter").
+func tokenBackoff(base time.Duration, attempt int) time.Duration {
+	d := tokenBackoffLimit(base, attempt)
+	if d <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(d)))
+}
+
+// tokenBackoffLimit is the bound tokenBackoff draws the delay after attempt
+// from: base*2^attempt, capped at maxTokenBackoff.
+func tokenBackoffLimit(base time.Duration, attempt int) time.Duration {
+	if base <= 0 {
+		return 0
+	}
+	d := base << attempt
+	if d <= 0 || d > maxTokenBackoff {
+		d = maxTokenBackoff
+	}
+	return d
+}
+
+// maxDuration is the longest sendTokenRequest can take under pol: every
+// attempt waits out its pace and times out, with the longest backoff
+// between them, and all of it twice when a DPoP nonce challenge restarts
+// the attempts.
+func (pol tokenPolicy) maxDuration() time.Duration {
+	var d time.Duration
+	for attempt := 0; attempt <= pol.maxRetries; attempt++ {
+		d += pol.timeout
+		if pol.rate.Rate > 0 {
+			d += pol.maxWait
+		}
+		if attempt < pol.maxRetries {
+			d += tokenBackoffLimit(pol.backoff, attempt)
+		}
+	}
+	return 2 * d
+}
@@ -0,0 +1,239 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/internal/store"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/config"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/connstate"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/vault"
+)
+
+func TestTokenPolicyFor(t *testing.T) {
+	defaults := config.TokenRequestConfig{Timeout: 20 * time.Second, MaxRetries: 2, Backoff: time.Second}
+	params := func(s string) *json.RawMessage {
+		raw := json.RawMessage(s)
+		return &raw
+	}
+
+	cases := []struct {
+		name        string
+		params      *json.RawMessage
+		wantTimeout time.Duration
+		wantRetries int
+	}{
+		{"no params", nil, 20 * time.Second, 2},
+		{"duration string", params(`{"token_timeout":"5s","token_max_retries":"0"}`), 5 * time.Second, 0},
+		{"numbers", params(`{"token_timeout":90,"token_max_retries":4}`), 90 * time.Second, 4},
+		{"invalid values ignored", params(`{"token_timeout":"soon","token_max_retries":-1}`), 20 * time.Second, 2},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			pol := tokenPolicyFor(defaults, &store.Provider{Name: "acme", Params: tc.params})
+			assert.Equal(t, tc.wantTimeout, pol.timeout)
+			assert.Equal(t, tc.wantRetries, pol.maxRetries)
+			assert.Equal(t, time.Second, pol.backoff)
+		})
+	}
+
+	pol := tokenPolicyFor(config.TokenRequestConfig{}, &store.Provider{Name: "acme"})
+	assert.Equal(t, 30*time.Second, pol.timeout, "an unset timeout falls back to 30s")
+}
+
+func TestTokenBackoff(t *testing.T) {
+	assert.Zero(t, tokenBackoff(0, 3))
+	for attempt := 0; attempt < 10; attempt++ {
+		d := tokenBackoff(100*time.Millisecond, attempt)
+		assert.GreaterOrEqual(t, d, time.Duration(0))
+		assert.Less(t, d, maxTokenBackoff)
+	}
+}
+
+func TestTokenPolicy_MaxDuration(t *testing.T) {
+	pol := tokenPolicyFor(config.TokenRequestConfig{Timeout: 30 * time.Second, MaxRetries: 2, Backoff: time.Second}, &store.Provider{Name: "acme"})
+	// Three 30s attempts with backoffs bounded by 1s and 2s, doubled for a
+	// DPoP nonce challenge.
+	assert.Equal(t, 186*time.Second, pol.maxDuration())
+	assert.Greater(t, refreshLockTTL(pol), pol.maxDuration())
+
+	pol.rate.Rate = 1
+	pol.maxWait = 5 * time.Second
+	assert.Equal(t, 216*time.Second, pol.maxDuration(), "each attempt may first wait out its pace")
+}
+
+func TestSendTokenRequest_RetriesOnlyWhatIsSafe(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		assert.Equal(t, "grant_type=x", string(body), "every attempt resends the body")
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+	pol := tokenPolicy{provider: "acme", timeout: time.Second, maxRetries: 2}
+
+	send := func(grant string) *http.Response {
+		atomic.StoreInt32(&calls, 0)
+		req, err := http.NewRequest("POST", srv.URL, strings.NewReader("grant_type=x"))
+		require.NoError(t, err)
+		resp, err := sendTokenRequest(context.Background(), srv.Client(), req, pol, grant)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp
+	}
+
+	resp := send(grantRefreshToken)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.EqualValues(t, 2, atomic.LoadInt32(&calls), "refresh is retried after a 5xx")
+
+	resp = send(grantAuthorizationCode)
+	assert.Equal(t, http.StatusBadGateway, resp.StatusCode)
+	assert.EqualValues(t, 1, atomic.LoadInt32(&calls), "a code exchange is never retried after a response")
+}
+
+type flakyTransport struct {
+	failures int32
+	calls    int32
+	next     http.RoundTripper
+}
+
+func (f *flakyTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if atomic.AddInt32(&f.calls, 1) <= f.failures {
+		return nil, errors.New("connection reset by peer")
+	}
+	return f.next.RoundTrip(r)
+}
+
+func TestSendTokenRequest_RetriesNetworkErrors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	transport := &flakyTransport{failures: 2, next: srv.Client().Transport}
+	client := &http.Client{Transport: transport}
+	req, err := http.NewRequest("POST", srv.URL, strings.NewReader("grant_type=authorization_code"))
+	require.NoError(t, err)
+
+	resp, err := sendTokenRequest(context.Background(), client, req, tokenPolicy{provider: "acme", maxRetries: 2}, grantAuthorizationCode)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.EqualValues(t, 3, transport.calls)
+
+	transport.calls = 0
+	req, err = http.NewRequest("POST", srv.URL, strings.NewReader("grant_type=authorization_code"))
+	require.NoError(t, err)
+	_, err = sendTokenRequest(context.Background(), client, req, tokenPolicy{provider: "acme", maxRetries: 1}, grantAuthorizationCode)
+	assert.Error(t, err, "gives up once retries are spent")
+	assert.EqualValues(t, 2, transport.calls)
+}
+
+func TestRefresh_RetriesProviderOutage(t *testing.T) {
+	st := store.NewMemory()
+	var calls int32
+	mockProviderServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"access_token":"new","refresh_token":"r2"}`)
+	}))
+	defer mockProviderServer.Close()
+
+	key := []byte("01234567890123456789012345678901")
+	handler := NewCallbackHandler(CallbackHandlerConfig{
+		Store:         st,
+		EncryptionKey: key,
+		States:        testStates(t, key),
+		HTTPClient:    mockProviderServer.Client(),
+		TokenRequests: config.TokenRequestConfig{Timeout: time.Second, MaxRetries: 1},
+	})
+	seedConnection(st, refreshConnectionID, connstate.StateActive, store.Provider{
+		AuthType: "oauth2", TokenURL: mockProviderServer.URL, ClientID: "id", ClientSecret: "secret",
+	})
+	sealed, err := vault.SealToken(key, []byte(`{"refresh_token":"r1"}`), refreshConnectionID.String(), "ws-1")
+	require.NoError(t, err)
+	require.NoError(t, st.SaveTokens(context.Background(), refreshConnectionID, sealed, nil))
+
+	rr := httptest.NewRecorder()
+	handler.Refresh(rr, httptest.NewRequest("POST", "/connections/b1b1b1b1-b1b1-b1b1-b1b1-b1b1b1b1b1b1/refresh", nil))
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.EqualValues(t, 2, atomic.LoadInt32(&calls))
+}
+
+func TestTokenPolicyFor_RateLimit(t *testing.T) {
+	params := func(s string) *store.Provider {
+		raw := json.RawMessage(s)
+		return &store.Provider{Name: "acme", Params: &raw}
+	}
+
+	pol := tokenPolicyFor(config.TokenRequestConfig{}, params(`{"token_rate_limit":2.5}`))
+	assert.Equal(t, 2.5, pol.rate.Rate)
+	assert.Equal(t, 3, pol.rate.Burst, "burst defaults to the rate rounded up")
+	assert.Equal(t, defaultTokenRateMaxWait, pol.maxWait)
+
+	pol = tokenPolicyFor(config.TokenRequestConfig{}, params(`{"token_rate_limit":"10","token_rate_burst":"1","token_rate_max_wait":"0s"}`))
+	assert.Equal(t, 10.0, pol.rate.Rate)
+	assert.Equal(t, 1, pol.rate.Burst)
+	assert.Zero(t, pol.maxWait)
+
+	pol = tokenPolicyFor(config.TokenRequestConfig{}, params(`{"token_rate_limit":-1,"token_rate_burst":0}`))
+	assert.Zero(t, pol.rate.Rate, "invalid values leave the provider unpaced")
+}
+
+func TestRefresh_ProviderRateLimited(t *testing.T) {
+	st := store.NewMemory()
+	var calls int32
+	mockProviderServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"access_token":"new","refresh_token":"r1"}`)
+	}))
+	defer mockProviderServer.Close()
+
+	key := []byte("01234567890123456789012345678901")
+	handler := NewCallbackHandler(CallbackHandlerConfig{
+		Store:         st,
+		EncryptionKey: key,
+		States:        testStates(t, key),
+		HTTPClient:    mockProviderServer.Client(),
+		TokenRequests: config.TokenRequestConfig{Timeout: time.Second},
+	})
+	params := json.RawMessage(`{"token_rate_limit":0.1,"token_rate_burst":1,"token_rate_max_wait":"0s"}`)
+	seedConnection(st, refreshConnectionID, connstate.StateActive, store.Provider{
+		Name: "acme", AuthType: "oauth2", TokenURL: mockProviderServer.URL, ClientID: "id", ClientSecret: "secret", Params: &params,
+	})
+	sealed, err := vault.SealToken(key, []byte(`{"refresh_token":"r1"}`), refreshConnectionID.String(), "ws-1")
+	require.NoError(t, err)
+	require.NoError(t, st.SaveTokens(context.Background(), refreshConnectionID, sealed, nil))
+
+	refresh := func() *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		handler.Refresh(rr, httptest.NewRequest("POST", "/connections/b1b1b1b1-b1b1-b1b1-b1b1-b1b1b1b1b1b1/refresh", nil))
+		return rr
+	}
+
+	require.Equal(t, http.StatusOK, refresh().Code)
+	rr := refresh()
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+	assert.Contains(t, rr.Body.String(), "provider_rate_limited")
+	assert.NotEmpty(t, rr.Header().Get("Retry-After"))
+	assert.EqualValues(t, 1, atomic.LoadInt32(&calls), "the throttled refresh never reaches the provider")
+}
@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/httpclient"
+)
+
+// providerClients resolves the HTTP clients used to reach a provider, so
+// every outbound call shares the pooled transports and honors the
+// provider's CA bundle.
+type providerClients struct {
+	pool *httpclient.Pool
+	// discovery serves providers without a CA bundle; it may cache.
+	discovery *http.Client
+}
+
+// newProviderClients falls back to a default pool, and to an uncached
+// discovery client from it, when either dependency is nil.
+func newProviderClients(pool *httpclient.Pool, discovery *http.Client) providerClients {
+	if pool == nil {
+		pool = httpclient.NewPool(httpclient.Config{})
+	}
+	if discovery == nil {
+		discovery, _ = pool.Client("", 10*time.Second)
+	}
+	return providerClients{pool: pool, discovery: discovery}
+}
+
+// discoveryClient returns the client for OIDC discovery and JWKS.
+func (c providerClients) discoveryClient(caBundle string) (*http.Client, error) {
+	if strings.TrimSpace(caBundle) == "" {
+		return c.discovery, nil
+	}
+	return c.pool.CachedClient(caBundle)
+}
+
+// client returns an uncached client for token, credential and probe calls.
+func (c providerClients) client(caBundle string, timeout time.Duration) (*http.Client, error) {
+	return c.pool.Client(caBundle, timeout)
+}
@@ -0,0 +1,331 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/auth"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/discovery"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/httpclient"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/httputil"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/provider"
+)
+
+// Check statuses reported by ProviderValidator.
+const (
+	CheckPass = "pass"
+	CheckWarn = "warn"
+	CheckFail = "fail"
+	CheckSkip = "skip"
+)
+
+// ValidationCheck is the outcome of one preflight check.
+type ValidationCheck struct {
+	Name    string `json:"name"`
+	Status  string `json:"status"`
+	Message string `json:"message"`
+}
+
+// ValidationReport is the response of POST /providers/validate. Valid is
+// false when any check failed; warnings do not affect it.
+type ValidationReport struct {
+	Valid       bool              `json:"valid"`
+	RedirectURI string            `json:"redirect_uri"`
+	TestAuthURL string            `json:"test_auth_url,omitempty"`
+	Checks      []ValidationCheck `json:"checks"`
+}
+
+func (r *ValidationReport) add(name, status, format string, args ...interface{}) {
+	r.Checks = append(r.Checks, ValidationCheck{Name: name, Status: status, Message: fmt.Sprintf(format, args...)})
+	if status == CheckFail {
+		r.Valid = false
+	}
+}
+
+// Status summarises the report as its worst check status: fail, warn or pass.
+func (r *ValidationReport) Status() string {
+	status := CheckPass
+	for _, c := range r.Checks {
+		switch c.Status {
+		case CheckFail:
+			return CheckFail
+		case CheckWarn:
+			status = CheckWarn
+		}
+	}
+	return status
+}
+
+// ProviderValidator checks a provider profile against the live identity
+// provider without saving it.
+type ProviderValidator struct {
+	redirectURI  string
+	clients      providerClients
+	probeTimeout time.Duration
+}
+
+// ProviderValidatorConfig holds the dependencies for ProviderValidator
+type ProviderValidatorConfig struct {
+	BaseURL      string
+	RedirectPath string
+	// HTTPClient is used for OIDC discovery and may cache responses.
+	HTTPClient *http.Client
+	// Transports supplies pooled connections for probes and for providers
+	// with a CA bundle. Defaults to a private pool.
+	Transports *httpclient.Pool
+	// ProbeTimeout bounds each endpoint probe. Defaults to 10s.
+	ProbeTimeout time.Duration
+}
+
+// NewProviderValidator creates a new provider validator
+func NewProviderValidator(cfg ProviderValidatorConfig) *ProviderValidator {
+	timeout := cfg.ProbeTimeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	return &ProviderValidator{
+		redirectURI:  strings.TrimSuffix(cfg.BaseURL, "/") + cfg.RedirectPath,
+		clients:      newProviderClients(cfg.Transports, cfg.HTTPClient),
+		probeTimeout: timeout,
+	}
+}
+
+// Validate handles POST /providers/validate. It accepts the same body as
+// POST /providers and always answers 200 with a ValidationReport once the
+// body parses.
+func (v *ProviderValidator) Validate(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Profile json.RawMessage `json:"profile"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		httputil.WriteError(w, http.StatusBadRequest, "invalid_json", "Invalid JSON payload")
+		return
+	}
+	if request.Profile == nil {
+		httputil.WriteError(w, http.StatusBadRequest, "missing_profile", "Missing 'profile' key in JSON")
+		return
+	}
+	httputil.WriteJSON(w, http.StatusOK, v.Check(r.Context(), string(request.Profile)))
+}
+
+// Check runs the preflight checks for a profile: required fields, scope
+// formatting, OIDC discovery, authorization and token endpoint reachability,
+// and a test authorization request carrying the broker's redirect URI.
+func (v *ProviderValidator) Check(ctx context.Context, profileJSON string) *ValidationReport {
+	report := v.newReport()
+	p, err := provider.ParseProfile(profileJSON)
+	if err != nil {
+		report.add("profile", CheckFail, "%v", err)
+		return report
+	}
+	report.add("profile", CheckPass, "required fields present")
+
+	if authURL, ok := v.checkEndpoints(ctx, report, p); ok {
+		v.checkRedirectURI(ctx, report, p, authURL)
+	}
+	return report
+}
+
+// Audit runs the checks suited to a scheduled health audit of a saved
+// profile: scopes, discovery and endpoint liveness. Unlike Check it sends
+// no authorization request.
+func (v *ProviderValidator) Audit(ctx context.Context, p *provider.Profile) *ValidationReport {
+	report := v.newReport()
+	v.checkEndpoints(ctx, report, p)
+	return report
+}
+
+func (v *ProviderValidator) newReport() *ValidationReport {
+	return &ValidationReport{Valid: true, RedirectURI: v.redirectURI, Checks: []ValidationCheck{}}
+}
+
+// checkEndpoints checks scopes, discovery, and the authorization and token
+// endpoints of an OAuth2 profile. It returns the authorization endpoint in
+// use and false for auth types without OAuth endpoints.
+func (v *ProviderValidator) checkEndpoints(ctx context.Context, report *ValidationReport, p *provider.Profile) (string, bool) {
+	if p.AuthType != "" && p.AuthType != "oauth2" {
+		report.add("endpoints", CheckSkip, "auth_type %s has no OAuth endpoints to check", p.AuthType)
+		return "", false
+	}
+
+	checkScopes(report, p.Scopes)
+
+	authURL, tokenURL := derefString(p.AuthURL), derefString(p.TokenURL)
+	hint := discovery.Hint{AuthURL: authURL}
+	if p.EnableDiscovery {
+		hint.Issuer = derefString(p.Issuer)
+	}
+	client, err := v.clients.discoveryClient(p.CABundle)
+	if err != nil {
+		report.add("ca_bundle", CheckFail, "%v", err)
+		return authURL, true
+	}
+	md, err := discovery.Discover(ctx, client, hint)
+	switch {
+	case err != nil && p.EnableDiscovery:
+		report.add("discovery", CheckFail, "OIDC discovery failed for issuer %s: %v", hint.Issuer, err)
+	case err != nil:
+		report.add("discovery", CheckSkip, "no OIDC discovery document found; using configured endpoints")
+	default:
+		report.add("discovery", CheckPass, "resolved issuer %s", md.Issuer)
+		if p.EnableDiscovery {
+			authURL, tokenURL = md.AuthorizationEndpoint, md.TokenEndpoint
+		} else if md.TokenEndpoint != "" && md.TokenEndpoint != tokenURL {
+			report.add("token_endpoint_mismatch", CheckWarn, "discovered token endpoint %s differs from token_url %s; code exchange falls back between them on 4xx", md.TokenEndpoint, tokenURL)
+		}
+	}
+
+	v.checkEndpoint(ctx, report, p, "auth_endpoint", http.MethodGet, authURL, nil)
+	form := url.Values{
+		"grant_type":   {"authorization_code"},
+		"code":         {"nexus-preflight"},
+		"redirect_uri": {v.redirectURI},
+	}
+	v.checkEndpoint(ctx, report, p, "token_endpoint", http.MethodPost, tokenURL, form)
+	return authURL, true
+}
+
+// checkScopes reports scope entries that would be sent malformed. Scopes
+// are joined with spaces, so an entry that already contains a separator
+// usually means the list was pasted as a single string.
+func checkScopes(report *ValidationReport, scopes []string) {
+	if len(scopes) == 0 {
+		report.add("scopes", CheckWarn, "no scopes configured; the provider's defaults apply")
+		return
+	}
+	var problems []string
+	status := CheckPass
+	seen := make(map[string]bool, len(scopes))
+	for _, s := range scopes {
+		switch {
+		case strings.TrimSpace(s) == "":
+			problems = append(problems, "empty scope entry")
+			status = CheckFail
+		case strings.ContainsAny(s, " ,\t\n"):
+			problems = append(problems, fmt.Sprintf("scope %q contains a separator; list each scope as its own entry", s))
+			status = CheckFail
+		case seen[s]:
+			problems = append(problems, fmt.Sprintf("scope %q is listed twice", s))
+			if status == CheckPass {
+				status = CheckWarn
+			}
+		}
+		seen[s] = true
+	}
+	if len(problems) == 0 {
+		report.add("scopes", CheckPass, "%d scopes", len(scopes))
+		return
+	}
+	report.add("scopes", status, "%s", strings.Join(problems, "; "))
+}
+
+// checkEndpoint verifies that an endpoint answers. Any response other than
+// 404, 405 or 5xx counts: without valid parameters an error is expected.
+func (v *ProviderValidator) checkEndpoint(ctx context.Context, report *ValidationReport, p *provider.Profile, name, method, endpoint string, form url.Values) {
+	if endpoint == "" {
+		report.add(name, CheckFail, "no endpoint configured or discovered")
+		return
+	}
+	status, _, err := v.probe(ctx, p, method, endpoint, form)
+	switch {
+	case err != nil:
+		report.add(name, CheckFail, "%s unreachable: %v", endpoint, err)
+	case status == http.StatusNotFound || status == http.StatusMethodNotAllowed || status >= 500:
+		report.add(name, CheckFail, "%s responded with HTTP %d", endpoint, status)
+	default:
+		report.add(name, CheckPass, "%s responded with HTTP %d", endpoint, status)
+	}
+}
+
+// checkRedirectURI sends the authorization request a real consent would
+// send. Providers reject unregistered redirect URIs before showing a login
+// page, and report scope errors by redirecting back with an error.
+func (v *ProviderValidator) checkRedirectURI(ctx context.Context, report *ValidationReport, p *provider.Profile, authURL string) {
+	u, err := url.Parse(v.redirectURI)
+	if err != nil || u.Host == "" {
+		report.add("redirect_uri", CheckFail, "broker redirect URI %q is not absolute; check BASE_URL", v.redirectURI)
+		return
+	}
+	if u.Scheme != "https" && u.Hostname() != "localhost" && u.Hostname() != "127.0.0.1" {
+		report.add("redirect_uri_scheme", CheckWarn, "redirect URI %s is not https; most providers reject it", v.redirectURI)
+	}
+	if authURL == "" {
+		report.add("redirect_uri", CheckSkip, "no authorization endpoint to test against")
+		return
+	}
+
+	_, challenge, err := auth.GeneratePKCE()
+	if err != nil {
+		report.add("redirect_uri", CheckFail, "generate PKCE: %v", err)
+		return
+	}
+	testURL, err := buildAuthURL(v.redirectURI, authURL, derefString(p.ClientID), "nexus-preflight", challenge, p.Scopes, nil, p.Params)
+	if err != nil {
+		report.add("redirect_uri", CheckFail, "build test authorization URL: %v", err)
+		return
+	}
+	report.TestAuthURL = testURL
+
+	status, location, err := v.probe(ctx, p, http.MethodGet, testURL, nil)
+	switch {
+	case err != nil:
+		report.add("redirect_uri", CheckFail, "test authorization request failed: %v", err)
+	case status >= 300 && status < 400 && strings.HasPrefix(location, v.redirectURI):
+		loc, _ := url.Parse(location)
+		if e := loc.Query().Get("error"); e != "" {
+			report.add("redirect_uri", CheckFail, "provider redirected back with error=%s: %s", e, loc.Query().Get("error_description"))
+		} else {
+			report.add("redirect_uri", CheckPass, "provider redirected to the registered redirect URI")
+		}
+	case status < 400:
+		report.add("redirect_uri", CheckPass, "provider accepted the test authorization request (HTTP %d)", status)
+	default:
+		report.add("redirect_uri", CheckWarn, "provider rejected the test authorization request (HTTP %d); confirm %s is registered for this client and open test_auth_url to see the provider's error", status, v.redirectURI)
+	}
+}
+
+// probe issues a single request without following redirects and returns the
+// status code and Location header. Probes must see the provider's live
+// response, so they bypass the caching discovery client.
+func (v *ProviderValidator) probe(ctx context.Context, p *provider.Profile, method, endpoint string, form url.Values) (int, string, error) {
+	client, err := v.clients.client(p.CABundle, v.probeTimeout)
+	if err != nil {
+		return 0, "", err
+	}
+	client.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	var body io.Reader
+	if form != nil {
+		body = strings.NewReader(form.Encode())
+	}
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, body)
+	if err != nil {
+		return 0, "", err
+	}
+	req.Header.Set("Accept", "application/json")
+	if form != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	return resp.StatusCode, resp.Header.Get("Location"), nil
+}
+
+func derefString(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return strings.TrimSpace(*s)
+}
@@ -0,0 +1,184 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const preflightRedirectURI = "http://localhost:8080/auth/callback"
+
+// newFakeIDP serves an OIDC provider that accepts only preflightRedirectURI.
+func newFakeIDP(t *testing.T, withDiscovery bool) *httptest.Server {
+	t.Helper()
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			if !withDiscovery {
+				http.NotFound(w, r)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]string{
+				"issuer":                 srv.URL,
+				"authorization_endpoint": srv.URL + "/authorize",
+				"token_endpoint":         srv.URL + "/token",
+				"jwks_uri":               srv.URL + "/jwks",
+			})
+		case "/authorize":
+			redirect := r.URL.Query().Get("redirect_uri")
+			if redirect == "" {
+				http.Error(w, "missing parameters", http.StatusBadRequest)
+				return
+			}
+			if redirect != preflightRedirectURI {
+				http.Error(w, "redirect_uri_mismatch", http.StatusBadRequest)
+				return
+			}
+			if strings.Contains(r.URL.Query().Get("scope"), "admin") {
+				http.Redirect(w, r, redirect+"?error=invalid_scope&error_description=unknown+scope", http.StatusFound)
+				return
+			}
+			http.Redirect(w, r, "/login", http.StatusFound)
+		case "/token":
+			if r.Method != http.MethodPost {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"invalid_grant"}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func checkStatuses(report *ValidationReport) map[string]string {
+	out := make(map[string]string, len(report.Checks))
+	for _, c := range report.Checks {
+		out[c.Name] = c.Status
+	}
+	return out
+}
+
+func newTestValidator(redirectBase string) *ProviderValidator {
+	return NewProviderValidator(ProviderValidatorConfig{
+		BaseURL:      redirectBase,
+		RedirectPath: "/auth/callback",
+		HTTPClient:   http.DefaultClient,
+	})
+}
+
+func TestValidatorCheck_DiscoveryProfilePasses(t *testing.T) {
+	idp := newFakeIDP(t, true)
+	v := newTestValidator("http://localhost:8080")
+
+	report := v.Check(context.Background(), `{
+		"name": "acme", "client_id": "cid", "client_secret": "secret",
+		"enable_discovery": true, "issuer": "`+idp.URL+`",
+		"scopes": ["openid", "email"]
+	}`)
+
+	assert.True(t, report.Valid, "%+v", report.Checks)
+	assert.Equal(t, preflightRedirectURI, report.RedirectURI)
+	assert.Contains(t, report.TestAuthURL, idp.URL+"/authorize?")
+	assert.Equal(t, map[string]string{
+		"profile":        CheckPass,
+		"scopes":         CheckPass,
+		"discovery":      CheckPass,
+		"auth_endpoint":  CheckPass,
+		"token_endpoint": CheckPass,
+		"redirect_uri":   CheckPass,
+	}, checkStatuses(report))
+}
+
+func TestValidatorCheck_UnregisteredRedirectURIWarns(t *testing.T) {
+	idp := newFakeIDP(t, false)
+	v := newTestValidator("https://broker.example.com")
+
+	report := v.Check(context.Background(), `{
+		"name": "acme", "client_id": "cid", "client_secret": "secret",
+		"auth_url": "`+idp.URL+`/authorize", "token_url": "`+idp.URL+`/token",
+		"scopes": ["read"]
+	}`)
+
+	statuses := checkStatuses(report)
+	assert.True(t, report.Valid)
+	assert.Equal(t, CheckSkip, statuses["discovery"])
+	assert.Equal(t, CheckWarn, statuses["redirect_uri"])
+}
+
+func TestValidatorCheck_ScopeErrorRedirectFails(t *testing.T) {
+	idp := newFakeIDP(t, false)
+	v := newTestValidator("http://localhost:8080")
+
+	report := v.Check(context.Background(), `{
+		"name": "acme", "client_id": "cid", "client_secret": "secret",
+		"auth_url": "`+idp.URL+`/authorize", "token_url": "`+idp.URL+`/token",
+		"scopes": ["admin"]
+	}`)
+
+	assert.False(t, report.Valid)
+	assert.Equal(t, CheckFail, checkStatuses(report)["redirect_uri"])
+}
+
+func TestValidatorCheck_BadEndpointsAndScopes(t *testing.T) {
+	idp := newFakeIDP(t, false)
+	v := newTestValidator("http://localhost:8080")
+
+	report := v.Check(context.Background(), `{
+		"name": "acme", "client_id": "cid", "client_secret": "secret",
+		"auth_url": "`+idp.URL+`/authorize", "token_url": "`+idp.URL+`/oauth/token",
+		"scopes": ["read write", "read", "read"]
+	}`)
+
+	statuses := checkStatuses(report)
+	assert.False(t, report.Valid)
+	assert.Equal(t, CheckFail, statuses["scopes"])
+	assert.Equal(t, CheckPass, statuses["auth_endpoint"])
+	assert.Equal(t, CheckFail, statuses["token_endpoint"])
+}
+
+func TestValidatorCheck_InvalidProfileStopsEarly(t *testing.T) {
+	v := newTestValidator("http://localhost:8080")
+
+	report := v.Check(context.Background(), `{"name": "acme"}`)
+
+	assert.False(t, report.Valid)
+	require.Len(t, report.Checks, 1)
+	assert.Equal(t, "profile", report.Checks[0].Name)
+	assert.Contains(t, report.Checks[0].Message, "client_id")
+}
+
+func TestValidatorCheck_StaticAuthSkipsEndpoints(t *testing.T) {
+	v := newTestValidator("http://localhost:8080")
+
+	report := v.Check(context.Background(), `{"name": "acme", "auth_type": "api_key"}`)
+
+	assert.True(t, report.Valid)
+	assert.Equal(t, map[string]string{"profile": CheckPass, "endpoints": CheckSkip}, checkStatuses(report))
+}
+
+func TestValidate_HTTP(t *testing.T) {
+	v := newTestValidator("http://localhost:8080")
+
+	rr := httptest.NewRecorder()
+	v.Validate(rr, httptest.NewRequest(http.MethodPost, "/providers/validate", strings.NewReader(`{}`)))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), "missing_profile")
+
+	rr = httptest.NewRecorder()
+	v.Validate(rr, httptest.NewRequest(http.MethodPost, "/providers/validate", strings.NewReader(`{"profile":{"name":"Bad Name"}}`)))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var report ValidationReport
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &report))
+	assert.False(t, report.Valid)
+}
@@ -0,0 +1,233 @@
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// minEgressRefresh is how often a host missing from the allowlist may
+// trigger a reload of the provider hosts, so a newly registered provider
+// is reachable within seconds without every denied request hitting the
+// database.
+const minEgressRefresh = 5 * time.Second
+
+var (
+	egressDenied = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "http_egress_denied_total",
+		Help: "Outbound provider requests refused because the host is not on the egress allowlist",
+	})
+	blockedDials = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "http_egress_blocked_addresses_total",
+		Help: "Outbound provider connections refused because the address is private, loopback or link-local",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(egressDenied, blockedDials)
+}
+
+// EgressDeniedError is returned for a request to a host that is not on the
+// egress allowlist.
+type EgressDeniedError struct {
+	Host string
+}
+
+func (e *EgressDeniedError) Error() string {
+	return fmt.Sprintf("egress to %s is not allowed (register it as a provider endpoint or add it to EGRESS_ALLOWED_HOSTS)", e.Host)
+}
+
+// Allowlist is the set of hosts the broker may call. It combines fixed
+// patterns with hosts loaded from the registered providers, reloading them
+// when an unknown host is requested. It is safe for concurrent use.
+type Allowlist struct {
+	static []string
+	load   func(ctx context.Context) ([]string, error)
+
+	mu       sync.RWMutex
+	hosts    map[string]bool
+	loadedAt time.Time
+}
+
+// NewAllowlist creates an allowlist of patterns plus the hosts returned by
+// load, which may be nil. A pattern is a host name or IP ("login.acme.com")
+// or a wildcard matching any subdomain ("*.okta.com").
+func NewAllowlist(patterns []string, load func(ctx context.Context) ([]string, error)) *Allowlist {
+	static := make([]string, 0, len(patterns))
+	for _, p := range patterns {
+		if p = normalizeHost(p); p != "" {
+			static = append(static, p)
+		}
+	}
+	return &Allowlist{static: static, load: load, hosts: map[string]bool{}}
+}
+
+// Refresh reloads the provider hosts.
+func (a *Allowlist) Refresh(ctx context.Context) error {
+	if a.load == nil {
+		return nil
+	}
+	hosts, err := a.load(ctx)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.loadedAt = time.Now()
+	if err != nil {
+		return err
+	}
+	a.hosts = make(map[string]bool, len(hosts))
+	for _, h := range hosts {
+		if h = normalizeHost(h); h != "" {
+			a.hosts[h] = true
+		}
+	}
+	return nil
+}
+
+// Allowed reports whether host may be called. A host that is not on the
+// list triggers a reload, at most once per minEgressRefresh.
+func (a *Allowlist) Allowed(ctx context.Context, host string) bool {
+	host = normalizeHost(host)
+	if a.match(host) {
+		return true
+	}
+	a.mu.RLock()
+	stale := a.load != nil && time.Since(a.loadedAt) >= minEgressRefresh
+	a.mu.RUnlock()
+	if !stale {
+		return false
+	}
+	if err := a.Refresh(ctx); err != nil {
+		log.Printf("egress: reload provider hosts: %v", err)
+	}
+	return a.match(host)
+}
+
+func (a *Allowlist) match(host string) bool {
+	if host == "" {
+		return false
+	}
+	a.mu.RLock()
+	ok := a.hosts[host]
+	a.mu.RUnlock()
+	if ok {
+		return true
+	}
+	for _, p := range a.static {
+		if p == host {
+			return true
+		}
+		if strings.HasPrefix(p, "*.") && strings.HasSuffix(host, p[1:]) {
+			return true
+		}
+	}
+	return false
+}
+
+// check returns an EgressDeniedError if host is not allowed.
+func (a *Allowlist) check(ctx context.Context, host string) error {
+	if a.Allowed(ctx, host) {
+		return nil
+	}
+	egressDenied.Inc()
+	return &EgressDeniedError{Host: normalizeHost(host)}
+}
+
+// HostOf returns the host name of rawURL, or "" if it has none.
+func HostOf(rawURL string) string {
+	u, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil {
+		return ""
+	}
+	return normalizeHost(u.Hostname())
+}
+
+func normalizeHost(h string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(h)), ".")
+}
+
+// proxyFunc returns the transport's Proxy function: proxyURL if set, else
+// HTTP_PROXY, HTTPS_PROXY and NO_PROXY from the environment. With an
+// allowlist, requests to other hosts fail before a proxy is chosen.
+func proxyFunc(proxyURL *url.URL, allow *Allowlist) func(*http.Request) (*url.URL, error) {
+	choose := http.ProxyFromEnvironment
+	if proxyURL != nil {
+		choose = http.ProxyURL(proxyURL)
+	}
+	if allow == nil {
+		return choose
+	}
+	return func(req *http.Request) (*url.URL, error) {
+		if err := allow.check(req.Context(), req.URL.Hostname()); err != nil {
+			return nil, err
+		}
+		return choose(req)
+	}
+}
+
+// dialFunc returns a DialContext that connects to a proxy freely, and to
+// any other host only if allow (when set) lists it and guard (when set)
+// accepts every address it resolves to. The allowlist check backs up the
+// one in proxyFunc for connections opened outside a request's proxy
+// decision.
+func dialFunc(proxyURL *url.URL, allow *Allowlist, guard *AddressGuard) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	proxies := proxyHosts(proxyURL)
+	plain := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	guarded := plain
+	if guard != nil {
+		guarded = &net.Dialer{Timeout: plain.Timeout, KeepAlive: plain.KeepAlive, Control: guard.control}
+	}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			host = addr
+		}
+		if proxies[normalizeHost(host)] {
+			return plain.DialContext(ctx, network, addr)
+		}
+		if allow != nil {
+			if err := allow.check(ctx, host); err != nil {
+				return nil, err
+			}
+		}
+		conn, err := guarded.DialContext(ctx, network, addr)
+		var blocked *BlockedAddressError
+		if errors.As(err, &blocked) {
+			blocked.Host = normalizeHost(host)
+			blockedDials.Inc()
+		}
+		return conn, err
+	}
+}
+
+// proxyHosts returns the hosts of the proxies requests may be sent
+// through, which the dialer must reach whatever the allowlist says.
+func proxyHosts(proxyURL *url.URL) map[string]bool {
+	hosts := map[string]bool{}
+	if proxyURL != nil {
+		hosts[normalizeHost(proxyURL.Hostname())] = true
+		return hosts
+	}
+	for _, env := range []string{"HTTPS_PROXY", "https_proxy", "HTTP_PROXY", "http_proxy"} {
+		v := os.Getenv(env)
+		if v == "" {
+			continue
+		}
+		if !strings.Contains(v, "://") {
+			v = "http://" + v
+		}
+		if h := HostOf(v); h != "" {
+			hosts[h] = true
+		}
+	}
+	return hosts
+}
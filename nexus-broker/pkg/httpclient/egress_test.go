@@ -0,0 +1,100 @@
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAllowlist_Match(t *testing.T) {
+	a := NewAllowlist([]string{"login.acme.com", "*.okta.com", " "}, nil)
+	ctx := context.Background()
+
+	assert.True(t, a.Allowed(ctx, "login.acme.com"))
+	assert.True(t, a.Allowed(ctx, "LOGIN.acme.com."))
+	assert.True(t, a.Allowed(ctx, "dev-1.okta.com"))
+	assert.False(t, a.Allowed(ctx, "okta.com"), "a wildcard only matches subdomains")
+	assert.False(t, a.Allowed(ctx, "evilokta.com"))
+	assert.False(t, a.Allowed(ctx, "169.254.169.254"))
+	assert.False(t, a.Allowed(ctx, ""))
+}
+
+func TestAllowlist_ReloadsOnMiss(t *testing.T) {
+	hosts := []string{"accounts.google.com"}
+	loads := 0
+	a := NewAllowlist(nil, func(context.Context) ([]string, error) {
+		loads++
+		return hosts, nil
+	})
+	ctx := context.Background()
+
+	require.NoError(t, a.Refresh(ctx))
+	assert.True(t, a.Allowed(ctx, "accounts.google.com"))
+	assert.Equal(t, 1, loads)
+
+	// A newly registered provider is picked up once the last load is old
+	// enough; misses in between do not reload.
+	hosts = append(hosts, "login.acme.com")
+	assert.False(t, a.Allowed(ctx, "login.acme.com"))
+	assert.Equal(t, 1, loads)
+
+	a.loadedAt = time.Now().Add(-minEgressRefresh)
+	assert.True(t, a.Allowed(ctx, "login.acme.com"))
+	assert.Equal(t, 2, loads)
+
+	a.loadedAt = time.Now().Add(-minEgressRefresh)
+	a.load = func(context.Context) ([]string, error) { return nil, errors.New("db down") }
+	assert.True(t, a.Allowed(ctx, "accounts.google.com"), "a failed reload keeps the loaded hosts")
+}
+
+func TestPool_EgressDeniesUnlistedHosts(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	denied, err := NewPool(Config{Egress: NewAllowlist([]string{"login.acme.com"}, nil)}).Client("", time.Second)
+	require.NoError(t, err)
+	_, err = denied.Get(srv.URL)
+	var deniedErr *EgressDeniedError
+	require.ErrorAs(t, err, &deniedErr)
+	assert.Equal(t, "127.0.0.1", deniedErr.Host)
+
+	allowed, err := NewPool(Config{Egress: NewAllowlist([]string{"127.0.0.1"}, nil)}).Client("", time.Second)
+	require.NoError(t, err)
+	resp, err := allowed.Get(srv.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestPool_EgressThroughProxy(t *testing.T) {
+	var proxied string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = r.URL.String()
+	}))
+	defer proxy.Close()
+	proxyURL, err := url.Parse(proxy.URL)
+	require.NoError(t, err)
+
+	// The proxy's own address is not on the allowlist but must be dialled.
+	client, err := NewPool(Config{
+		Proxy:  proxyURL,
+		Egress: NewAllowlist([]string{"login.acme.com"}, nil),
+	}).Client("", time.Second)
+	require.NoError(t, err)
+
+	resp, err := client.Get("http://login.acme.com/token")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, "http://login.acme.com/token", proxied)
+
+	_, err = client.Get("http://169.254.169.254/latest/meta-data/")
+	var deniedErr *EgressDeniedError
+	assert.ErrorAs(t, err, &deniedErr, "the allowlist applies before the proxy is used")
+}
@@ -0,0 +1,129 @@
+package httpclient
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"strings"
+	"syscall"
+)
+
+// blockedRanges are the non-public ranges not covered by netip.Addr's
+// predicates: shared address space (RFC 6598, where some clouds serve
+// instance metadata) and the IPv4 "this network" block.
+var blockedRanges = []netip.Prefix{
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("0.0.0.0/8"),
+}
+
+// BlockedAddressError is returned for a URL or connection whose address is
+// in a private, loopback or link-local range.
+type BlockedAddressError struct {
+	Host string
+	IP   netip.Addr
+}
+
+func (e *BlockedAddressError) Error() string {
+	if e.Host == "" || e.Host == e.IP.String() {
+		return fmt.Sprintf("address %s is in a blocked private range", e.IP)
+	}
+	return fmt.Sprintf("host %s resolves to %s, which is in a blocked private range", e.Host, e.IP)
+}
+
+// AddressGuard keeps provider calls off internal networks: loopback,
+// private (RFC 1918 and fc00::/7), link-local (including the
+// 169.254.169.254 metadata service), shared and unspecified addresses are
+// refused unless they fall in an allowed prefix. It checks URLs when a
+// provider is saved and every connection when it is made, so a host that
+// later resolves to an internal address is still refused.
+type AddressGuard struct {
+	allowPrivate bool
+	allowed      []netip.Prefix
+	resolver     *net.Resolver
+}
+
+// NewAddressGuard creates a guard. allowPrivate disables it entirely;
+// allowedCIDRs exempt specific ranges, e.g. an on-premises identity
+// provider.
+func NewAddressGuard(allowPrivate bool, allowedCIDRs []string) (*AddressGuard, error) {
+	g := &AddressGuard{allowPrivate: allowPrivate, resolver: net.DefaultResolver}
+	for _, c := range allowedCIDRs {
+		p, err := netip.ParsePrefix(strings.TrimSpace(c))
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q: %w", c, err)
+		}
+		g.allowed = append(g.allowed, p.Masked())
+	}
+	return g, nil
+}
+
+// CheckIP returns a BlockedAddressError if ip may not be connected to.
+func (g *AddressGuard) CheckIP(ip netip.Addr) error {
+	if g == nil || g.allowPrivate {
+		return nil
+	}
+	ip = ip.Unmap()
+	for _, p := range g.allowed {
+		if p.Contains(ip) {
+			return nil
+		}
+	}
+	blocked := ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsUnspecified() || ip.IsMulticast()
+	for _, p := range blockedRanges {
+		blocked = blocked || p.Contains(ip)
+	}
+	if blocked {
+		return &BlockedAddressError{IP: ip}
+	}
+	return nil
+}
+
+// ValidateURL checks that rawURL is an absolute http(s) URL whose host
+// resolves only to allowed addresses. An empty URL is valid.
+func (g *AddressGuard) ValidateURL(ctx context.Context, rawURL string) error {
+	rawURL = strings.TrimSpace(rawURL)
+	if rawURL == "" {
+		return nil
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("invalid URL %q: %w", rawURL, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("URL %q must use http or https", rawURL)
+	}
+	host := u.Hostname()
+	if host == "" {
+		return fmt.Errorf("URL %q has no host", rawURL)
+	}
+	if g == nil || g.allowPrivate {
+		return nil
+	}
+	if ip, err := netip.ParseAddr(host); err == nil {
+		return g.CheckIP(ip)
+	}
+	ips, err := g.resolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return fmt.Errorf("resolve %s: %w", host, err)
+	}
+	for _, ip := range ips {
+		if err := g.CheckIP(ip); err != nil {
+			return &BlockedAddressError{Host: host, IP: ip.Unmap()}
+		}
+	}
+	return nil
+}
+
+// control is a net.Dialer Control function that refuses connections to
+// blocked addresses. It sees the address actually dialled, after DNS
+// resolution, so DNS rebinding cannot slip past ValidateURL.
+func (g *AddressGuard) control(network, address string, _ syscall.RawConn) error {
+	ap, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return fmt.Errorf("unexpected dial address %q: %w", address, err)
+	}
+	return g.CheckIP(ap.Addr())
+}
@@ -0,0 +1,77 @@
+package httpclient
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAddressGuard_CheckIP(t *testing.T) {
+	g, err := NewAddressGuard(false, []string{"10.20.0.0/16"})
+	require.NoError(t, err)
+
+	for _, ip := range []string{
+		"127.0.0.1", "::1", "10.0.0.5", "172.16.4.1", "192.168.1.1", "169.254.169.254",
+		"fe80::1", "fd00::1", "100.100.100.200", "0.0.0.0", "::ffff:127.0.0.1", "224.0.0.1",
+	} {
+		var blocked *BlockedAddressError
+		assert.ErrorAs(t, g.CheckIP(netip.MustParseAddr(ip)), &blocked, ip)
+	}
+	for _, ip := range []string{"8.8.8.8", "2606:4700::1111", "10.20.3.4"} {
+		assert.NoError(t, g.CheckIP(netip.MustParseAddr(ip)), ip)
+	}
+
+	open, err := NewAddressGuard(true, nil)
+	require.NoError(t, err)
+	assert.NoError(t, open.CheckIP(netip.MustParseAddr("169.254.169.254")))
+
+	_, err = NewAddressGuard(false, []string{"10.0.0.0/33"})
+	assert.Error(t, err)
+}
+
+func TestAddressGuard_ValidateURL(t *testing.T) {
+	g, err := NewAddressGuard(false, nil)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	assert.NoError(t, g.ValidateURL(ctx, ""))
+	assert.NoError(t, g.ValidateURL(ctx, "https://8.8.8.8/token"))
+
+	var blocked *BlockedAddressError
+	require.ErrorAs(t, g.ValidateURL(ctx, "http://169.254.169.254/latest/meta-data/"), &blocked)
+	require.ErrorAs(t, g.ValidateURL(ctx, "http://[::1]:8080/"), &blocked)
+	require.ErrorAs(t, g.ValidateURL(ctx, "http://localhost:8080/"), &blocked, "names are resolved before they are accepted")
+	assert.Equal(t, "localhost", blocked.Host)
+
+	assert.Error(t, g.ValidateURL(ctx, "file:///etc/passwd"))
+	assert.Error(t, g.ValidateURL(ctx, "gopher://10.0.0.1/"))
+	assert.Error(t, g.ValidateURL(ctx, "https:///no-host"))
+}
+
+func TestPool_GuardBlocksPrivateAddresses(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	strict, err := NewAddressGuard(false, nil)
+	require.NoError(t, err)
+	client, err := NewPool(Config{Guard: strict}).Client("", time.Second)
+	require.NoError(t, err)
+	_, err = client.Get(srv.URL)
+	var blocked *BlockedAddressError
+	require.ErrorAs(t, err, &blocked, "checked at connect time, not only when the URL is saved")
+	assert.Equal(t, "127.0.0.1", blocked.IP.String())
+
+	exempt, err := NewAddressGuard(false, []string{"127.0.0.0/8"})
+	require.NoError(t, err)
+	client, err = NewPool(Config{Guard: exempt}).Client("", time.Second)
+	require.NoError(t, err)
+	resp, err := client.Get(srv.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+}
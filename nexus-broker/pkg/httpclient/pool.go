@@ -0,0 +1,156 @@
+// Package httpclient provides the shared HTTP transports the broker uses to
+// talk to identity providers: token exchange and refresh, credential checks,
+// OIDC discovery and JWKS, and provider probes.
+//
+// Transports are shared so connections to a provider are reused across
+// requests. Providers that present certificates from a private CA supply a
+// PEM bundle in their profile; each distinct bundle gets its own transport
+// that trusts the system roots plus that bundle.
+//
+// Deployments that must route provider traffic through an egress proxy set
+// Config.Proxy, and those that must restrict where the broker connects set
+// Config.Egress, so a provider registered with an internal URL cannot be
+// used to reach it.
+package httpclient
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Config tunes the transports handed out by a Pool. Zero values use the
+// defaults noted on each field.
+type Config struct {
+	// MaxConnsPerHost caps concurrent connections to one provider host.
+	// Defaults to 32.
+	MaxConnsPerHost int
+	// MaxIdleConnsPerHost caps idle keep-alive connections kept per host.
+	// Defaults to 8.
+	MaxIdleConnsPerHost int
+	// IdleConnTimeout closes idle connections after this long. Defaults to 90s.
+	IdleConnTimeout time.Duration
+	// Cache, if set, wraps the transport used by CachedClient, e.g. with
+	// a response cache for discovery documents and JWKS.
+	Cache func(http.RoundTripper) http.RoundTripper
+	// Proxy, if set, is the proxy every provider request goes through.
+	// Nil uses HTTP_PROXY, HTTPS_PROXY and NO_PROXY from the environment.
+	Proxy *url.URL
+	// Egress, if set, restricts connections to its hosts (and the proxy).
+	// Nil allows any host.
+	Egress *Allowlist
+	// Guard, if set, refuses connections to private, loopback and
+	// link-local addresses. Nil allows any address.
+	Guard *AddressGuard
+}
+
+// Pool hands out http.Clients backed by shared transports, one per CA
+// bundle. It is safe for concurrent use.
+type Pool struct {
+	cfg Config
+
+	mu         sync.Mutex
+	transports map[[sha256.Size]byte]*http.Transport
+}
+
+// NewPool creates a new transport pool
+func NewPool(cfg Config) *Pool {
+	if cfg.MaxConnsPerHost <= 0 {
+		cfg.MaxConnsPerHost = 32
+	}
+	if cfg.MaxIdleConnsPerHost <= 0 {
+		cfg.MaxIdleConnsPerHost = 8
+	}
+	if cfg.IdleConnTimeout <= 0 {
+		cfg.IdleConnTimeout = 90 * time.Second
+	}
+	return &Pool{cfg: cfg, transports: make(map[[sha256.Size]byte]*http.Transport)}
+}
+
+// ErrInvalidCABundle is returned for a CA bundle with no PEM certificates.
+var ErrInvalidCABundle = errors.New("no PEM certificates found in CA bundle")
+
+// ValidateCABundle reports whether caBundle holds at least one PEM
+// certificate. An empty bundle is valid and means system roots only.
+func ValidateCABundle(caBundle string) error {
+	if strings.TrimSpace(caBundle) == "" {
+		return nil
+	}
+	if !x509.NewCertPool().AppendCertsFromPEM([]byte(caBundle)) {
+		return ErrInvalidCABundle
+	}
+	return nil
+}
+
+// Transport returns the shared transport for caBundle, creating it on first
+// use. An empty bundle selects the system roots.
+func (p *Pool) Transport(caBundle string) (*http.Transport, error) {
+	caBundle = strings.TrimSpace(caBundle)
+	key := sha256.Sum256([]byte(caBundle))
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if t, ok := p.transports[key]; ok {
+		return t, nil
+	}
+
+	// Clone keeps DefaultTransport's dialer and HTTP/2 settings.
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.MaxConnsPerHost = p.cfg.MaxConnsPerHost
+	t.MaxIdleConnsPerHost = p.cfg.MaxIdleConnsPerHost
+	t.IdleConnTimeout = p.cfg.IdleConnTimeout
+	t.Proxy = proxyFunc(p.cfg.Proxy, p.cfg.Egress)
+	if p.cfg.Egress != nil || p.cfg.Guard != nil {
+		t.DialContext = dialFunc(p.cfg.Proxy, p.cfg.Egress, p.cfg.Guard)
+	}
+	if caBundle != "" {
+		roots, err := x509.SystemCertPool()
+		if err != nil || roots == nil {
+			roots = x509.NewCertPool()
+		}
+		if !roots.AppendCertsFromPEM([]byte(caBundle)) {
+			return nil, ErrInvalidCABundle
+		}
+		t.TLSClientConfig = &tls.Config{RootCAs: roots, MinVersion: tls.VersionTLS12}
+	}
+	p.transports[key] = t
+	return t, nil
+}
+
+// Client returns a client for caBundle with the given overall timeout.
+func (p *Pool) Client(caBundle string, timeout time.Duration) (*http.Client, error) {
+	t, err := p.Transport(caBundle)
+	if err != nil {
+		return nil, err
+	}
+	return &http.Client{Transport: t, Timeout: timeout}, nil
+}
+
+// CachedClient is Client wrapped with Config.Cache, for GETs whose
+// responses may be reused (discovery documents, JWKS).
+func (p *Pool) CachedClient(caBundle string) (*http.Client, error) {
+	t, err := p.Transport(caBundle)
+	if err != nil {
+		return nil, err
+	}
+	var rt http.RoundTripper = t
+	if p.cfg.Cache != nil {
+		rt = p.cfg.Cache(rt)
+	}
+	return &http.Client{Transport: rt}, nil
+}
+
+// CloseIdleConnections closes idle connections on every pooled transport.
+func (p *Pool) CloseIdleConnections() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, t := range p.transports {
+		t.CloseIdleConnections()
+	}
+}
@@ -0,0 +1,77 @@
+package httpclient
+
+import (
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func serverCAPEM(srv *httptest.Server) string {
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}))
+}
+
+func TestPool_SharesTransportPerBundle(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	ca := serverCAPEM(srv)
+
+	p := NewPool(Config{MaxConnsPerHost: 4})
+	a, err := p.Transport("")
+	require.NoError(t, err)
+	b, err := p.Transport("  ")
+	require.NoError(t, err)
+	assert.Same(t, a, b)
+	assert.Equal(t, 4, a.MaxConnsPerHost)
+
+	c, err := p.Transport(ca)
+	require.NoError(t, err)
+	assert.NotSame(t, a, c)
+	d, err := p.Transport(ca)
+	require.NoError(t, err)
+	assert.Same(t, c, d)
+}
+
+func TestPool_CABundleTrustsPrivateCA(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	p := NewPool(Config{})
+
+	system, err := p.Client("", 5*time.Second)
+	require.NoError(t, err)
+	_, err = system.Get(srv.URL)
+	assert.Error(t, err, "system roots must not trust the test server")
+
+	private, err := p.Client(serverCAPEM(srv), 5*time.Second)
+	require.NoError(t, err)
+	resp, err := private.Get(srv.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestPool_InvalidBundle(t *testing.T) {
+	p := NewPool(Config{})
+	_, err := p.Client("not a certificate", time.Second)
+	assert.ErrorIs(t, err, ErrInvalidCABundle)
+	assert.ErrorIs(t, ValidateCABundle("not a certificate"), ErrInvalidCABundle)
+	assert.NoError(t, ValidateCABundle(""))
+}
+
+func TestPool_CachedClientWraps(t *testing.T) {
+	wrapped := 0
+	p := NewPool(Config{Cache: func(rt http.RoundTripper) http.RoundTripper {
+		wrapped++
+		return rt
+	}})
+	_, err := p.CachedClient("")
+	require.NoError(t, err)
+	_, err = p.Client("", time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, 1, wrapped)
+}
@@ -0,0 +1,44 @@
+package httputil
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ClientIP returns the address of the client that sent r through
+// trustedProxies reverse proxies. Each trusted proxy appends the address it
+// received the request from to X-Forwarded-For, so the client is the entry
+// trustedProxies hops to the left of RemoteAddr; entries further left were
+// supplied by the client and are ignored. With no trusted proxies,
+// X-Forwarded-For is ignored altogether. ClientIP returns nil if RemoteAddr
+// is not an IP address.
+func ClientIP(r *http.Request, trustedProxies int) net.IP {
+	ip := RemoteIP(r)
+	if ip == nil || trustedProxies <= 0 {
+		return ip
+	}
+	var hops []string
+	for _, v := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(v, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0 && trustedProxies > 0; i, trustedProxies = i-1, trustedProxies-1 {
+		hop := net.ParseIP(strings.TrimSpace(hops[i]))
+		if hop == nil {
+			// A trusted proxy would not write garbage; stop at the last
+			// address known to be genuine.
+			break
+		}
+		ip = hop
+	}
+	return ip
+}
+
+// RemoteIP parses r.RemoteAddr, with or without a port.
+func RemoteIP(r *http.Request) net.IP {
+	host := r.RemoteAddr
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return net.ParseIP(host)
+}
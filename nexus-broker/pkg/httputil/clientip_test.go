@@ -0,0 +1,44 @@
+package httputil
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	testCases := []struct {
+		name           string
+		remoteAddr     string
+		forwardedFor   []string
+		trustedProxies int
+		want           string
+	}{
+		{"no proxies ignores X-Forwarded-For", "10.0.0.2:4000", []string{"203.0.113.9"}, 0, "10.0.0.2"},
+		{"one proxy", "10.0.0.2:4000", []string{"198.51.100.66, 203.0.113.9"}, 1, "203.0.113.9"},
+		{"two proxies", "10.0.0.2:4000", []string{"198.51.100.66, 203.0.113.9, 10.0.0.1"}, 2, "203.0.113.9"},
+		{"repeated headers are one list", "10.0.0.2:4000", []string{"198.51.100.66", "203.0.113.9"}, 1, "203.0.113.9"},
+		{"fewer hops than proxies", "10.0.0.2:4000", []string{"203.0.113.9"}, 3, "203.0.113.9"},
+		{"no header", "10.0.0.2:4000", nil, 1, "10.0.0.2"},
+		{"garbage stops at last genuine hop", "10.0.0.2:4000", []string{"203.0.113.9, not-an-ip"}, 2, "10.0.0.2"},
+		{"RemoteAddr without port", "10.0.0.2", nil, 0, "10.0.0.2"},
+		{"IPv6", "[2001:db8::2]:4000", []string{"2001:db8::9"}, 1, "2001:db8::9"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.RemoteAddr = tc.remoteAddr
+			for _, v := range tc.forwardedFor {
+				req.Header.Add("X-Forwarded-For", v)
+			}
+			if got := ClientIP(req, tc.trustedProxies); got.String() != tc.want {
+				t.Errorf("expected %s, got %s", tc.want, got)
+			}
+		})
+	}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "pipe"
+	if got := ClientIP(req, 1); got != nil {
+		t.Errorf("expected nil for a non-IP RemoteAddr, got %s", got)
+	}
+}
@@ -4,11 +4,23 @@ import (
 	"encoding/json"
 	"log"
 	"net/http"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-common/problem"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/redact"
 )
 
-// APIError is the standard JSON error body returned by all broker endpoints.
-// Every failure path must use this shape so clients can parse errors uniformly.
+// APIError is the problem details body (RFC 9457) returned by all broker
+// endpoints on failure, as written by nexus-common/problem. Every failure
+// path must use WriteError so clients can parse errors uniformly.
 type APIError struct {
+	Type      string `json:"type"`
+	Title     string `json:"title"`
+	Status    int    `json:"status"`
+	Detail    string `json:"detail,omitempty"`
+	Code      string `json:"code"`
+	RequestID string `json:"request_id,omitempty"`
+	// Error and Message repeat Code and Detail for older clients.
 	Error   string `json:"error"`
 	Message string `json:"message"`
 	Details any    `json:"details,omitempty"`
@@ -32,13 +44,16 @@ func WriteJSON(w http.ResponseWriter, status int, v any) {
 	}
 }
 
-// WriteError writes a structured JSON error response. It replaces http.Error
-// across all handler code to ensure clients always receive application/json.
+// WriteError writes a problem details error response. It replaces
+// http.Error across all handler code to ensure clients always receive a
+// structured body. The message is redacted, since it often wraps an upstream
+// error body.
 func WriteError(w http.ResponseWriter, status int, code, message string) {
-	WriteJSON(w, status, APIError{Error: code, Message: message})
+	problem.Write(w, problem.New(status, code, redact.String(message)))
 }
 
-// WriteErrorWithDetails writes a structured JSON error response with extra detail.
+// WriteErrorWithDetails writes a problem details error response with extra
+// detail in its details member.
 func WriteErrorWithDetails(w http.ResponseWriter, status int, code, message string, details any) {
-	WriteJSON(w, status, APIError{Error: code, Message: message, Details: details})
+	problem.Write(w, problem.New(status, code, redact.String(message)).With("details", details))
 }
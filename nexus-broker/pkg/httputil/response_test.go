@@ -86,18 +86,21 @@ func TestWriteError_StructuredJSON(t *testing.T) {
 	if resp.StatusCode != http.StatusBadRequest {
 		t.Fatalf("expected 400, got %d", resp.StatusCode)
 	}
-	if ct := resp.Header.Get("Content-Type"); ct != "application/json" {
-		t.Fatalf("expected application/json, got %q", ct)
+	if ct := resp.Header.Get("Content-Type"); ct != "application/problem+json" {
+		t.Fatalf("expected application/problem+json, got %q", ct)
 	}
 
 	var got APIError
 	if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
 		t.Fatalf("failed to decode: %v", err)
 	}
-	if got.Error != "invalid_id" {
-		t.Errorf("expected error code invalid_id, got %q", got.Error)
+	if got.Error != "invalid_id" || got.Code != "invalid_id" {
+		t.Errorf("expected error code invalid_id, got %q / %q", got.Code, got.Error)
+	}
+	if got.Type != "https://prescott-data.github.io/nexus-framework/reference/errors/#invalid_id" || got.Status != http.StatusBadRequest || got.Title != "Bad Request" {
+		t.Errorf("unexpected problem members %+v", got)
 	}
-	if got.Message != "Invalid provider ID" {
+	if got.Message != "Invalid provider ID" || got.Detail != "Invalid provider ID" {
 		t.Errorf("expected message 'Invalid provider ID', got %q", got.Message)
 	}
 	if got.Details != nil {
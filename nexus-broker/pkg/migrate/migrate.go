@@ -0,0 +1,437 @@
+// Package migrate applies the broker's versioned SQL migrations and records
+// them in schema_migrations.
+//
+// Migrations are files named <version>_<name>.sql and are applied in version
+// order; files sharing a version are ordered by name. An optional
+// <version>_<name>.down.sql reverses one. Each migration runs in its own
+// transaction together with its schema_migrations row, and a Postgres
+// advisory lock serializes runners, so several replicas can start with
+// AUTO_MIGRATE at once.
+//
+// NewForDialect applies migrations written for MySQL or SQLite instead.
+// MySQL holds a named lock (GET_LOCK) while migrating, commits DDL
+// implicitly, and runs a script one statement at a time, so its migration
+// files must not use semicolons other than as statement terminators.
+// SQLite allows one writer at a time and takes no further lock.
+//
+// The checksum of every applied migration is recorded. Up refuses to run if
+// an applied file has since been edited.
+package migrate
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// lockKey is the pg_advisory_lock key held while migrating.
+const lockKey = 7236011840524001
+
+// mysqlLockName is the GET_LOCK name held while migrating MySQL.
+const mysqlLockName = "nexus_broker_migrate"
+
+// Dialect is what differs between the databases a Migrator supports.
+type Dialect struct {
+	name string
+	// bind is the placeholder style of the bookkeeping statements.
+	bind int
+	// lock takes the migration lock on conn and returns its release.
+	lock func(ctx context.Context, conn *sql.Conn) (func(), error)
+	// schemaMigrations creates the bookkeeping table if it is missing.
+	schemaMigrations string
+	// hasSchema reports whether provider_profiles exists.
+	hasSchema string
+	// split runs a script one statement at a time.
+	split bool
+}
+
+// Postgres is the dialect of the broker's migrations package.
+var Postgres = Dialect{
+	name: "postgres",
+	bind: sqlx.DOLLAR,
+	lock: func(ctx context.Context, conn *sql.Conn) (func(), error) {
+		if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, lockKey); err != nil {
+			return nil, err
+		}
+		return func() { conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, lockKey) }, nil
+	},
+	schemaMigrations: `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			name TEXT PRIMARY KEY,
+			version INTEGER NOT NULL,
+			checksum TEXT NOT NULL,
+			applied_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+		)`,
+	hasSchema: `SELECT to_regclass('provider_profiles') IS NOT NULL`,
+}
+
+// MySQL is the dialect of migrations.MySQL. The database must be opened
+// with parseTime=true.
+var MySQL = Dialect{
+	name: "mysql",
+	bind: sqlx.QUESTION,
+	lock: func(ctx context.Context, conn *sql.Conn) (func(), error) {
+		var got sql.NullInt64
+		if err := conn.QueryRowContext(ctx, `SELECT GET_LOCK(?, -1)`, mysqlLockName).Scan(&got); err != nil {
+			return nil, err
+		}
+		if got.Int64 != 1 {
+			return nil, errors.New("GET_LOCK failed")
+		}
+		return func() { conn.ExecContext(context.Background(), `SELECT RELEASE_LOCK(?)`, mysqlLockName) }, nil
+	},
+	schemaMigrations: `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			name VARCHAR(255) PRIMARY KEY,
+			version INTEGER NOT NULL,
+			checksum VARCHAR(64) NOT NULL,
+			applied_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6)
+		)`,
+	hasSchema: `SELECT COUNT(*) > 0 FROM information_schema.tables WHERE table_schema = DATABASE() AND table_name = 'provider_profiles'`,
+	split:     true,
+}
+
+// SQLite is the dialect of migrations.SQLite.
+var SQLite = Dialect{
+	name: "sqlite",
+	bind: sqlx.QUESTION,
+	lock: func(context.Context, *sql.Conn) (func(), error) { return func() {}, nil },
+	schemaMigrations: `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			name TEXT PRIMARY KEY,
+			version INTEGER NOT NULL,
+			checksum TEXT NOT NULL,
+			applied_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)`,
+	hasSchema: `SELECT COUNT(*) > 0 FROM sqlite_master WHERE type = 'table' AND name = 'provider_profiles'`,
+}
+
+var (
+	// ErrChecksumMismatch means an applied migration file was edited.
+	ErrChecksumMismatch = errors.New("applied migration has been modified")
+	// ErrUntracked means the database already has the broker schema but no
+	// migration history, e.g. it was set up by hand. Run Baseline first.
+	ErrUntracked = errors.New("database has a schema but no migration history; baseline it first")
+	// ErrIrreversible means a migration to roll back has no down file.
+	ErrIrreversible = errors.New("migration has no down file")
+)
+
+var fileName = regexp.MustCompile(`^(\d+)_([a-z0-9_]+?)(\.down)?\.sql$`)
+
+// Migration is one versioned schema change.
+type Migration struct {
+	Version int
+	// Name is the file name without ".sql" and identifies the migration in
+	// schema_migrations, e.g. "11_add_provider_description".
+	Name     string
+	Up       string
+	Down     string
+	Checksum string
+}
+
+// Status reports whether a migration has been applied.
+type Status struct {
+	Migration
+	AppliedAt *time.Time
+	// Modified is set when the applied checksum differs from the file.
+	Modified bool
+}
+
+type record struct {
+	version   int
+	checksum  string
+	appliedAt time.Time
+}
+
+// Load reads the migrations in the root of fsys, in apply order.
+func Load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byName := map[string]*Migration{}
+	downs := map[string]string{}
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".sql") {
+			continue
+		}
+		m := fileName.FindStringSubmatch(e.Name())
+		if m == nil {
+			return nil, fmt.Errorf("migration %q: name must be <version>_<name>.sql", e.Name())
+		}
+		body, err := fs.ReadFile(fsys, e.Name())
+		if err != nil {
+			return nil, err
+		}
+		name := m[1] + "_" + m[2]
+		if m[3] != "" {
+			downs[name] = string(body)
+			continue
+		}
+		version, err := strconv.Atoi(m[1])
+		if err != nil {
+			return nil, fmt.Errorf("migration %q: %w", e.Name(), err)
+		}
+		sum := sha256.Sum256(body)
+		byName[name] = &Migration{
+			Version:  version,
+			Name:     name,
+			Up:       string(body),
+			Checksum: hex.EncodeToString(sum[:]),
+		}
+	}
+
+	for name, down := range downs {
+		m, ok := byName[name]
+		if !ok {
+			return nil, fmt.Errorf("migration %q: down file has no matching up file", name+".down.sql")
+		}
+		m.Down = down
+	}
+
+	out := make([]Migration, 0, len(byName))
+	for _, m := range byName {
+		out = append(out, *m)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Version != out[j].Version {
+			return out[i].Version < out[j].Version
+		}
+		return out[i].Name < out[j].Name
+	})
+	return out, nil
+}
+
+// Migrator applies a fixed set of migrations to one database.
+type Migrator struct {
+	db         *sql.DB
+	dialect    Dialect
+	migrations []Migration
+}
+
+// New loads the Postgres migrations in fsys for db.
+func New(db *sql.DB, fsys fs.FS) (*Migrator, error) {
+	return NewForDialect(db, fsys, Postgres)
+}
+
+// NewForDialect loads the migrations in fsys, written for d, for db.
+func NewForDialect(db *sql.DB, fsys fs.FS, d Dialect) (*Migrator, error) {
+	ms, err := Load(fsys)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, dialect: d, migrations: ms}, nil
+}
+
+// Up applies every pending migration and returns the names applied.
+func (m *Migrator) Up(ctx context.Context) ([]string, error) {
+	var done []string
+	err := m.locked(ctx, func(conn *sql.Conn, applied map[string]record) error {
+		if len(applied) == 0 {
+			var exists bool
+			if err := conn.QueryRowContext(ctx, m.dialect.hasSchema).Scan(&exists); err != nil {
+				return err
+			}
+			if exists {
+				return ErrUntracked
+			}
+		}
+		for _, mg := range m.migrations {
+			if r, ok := applied[mg.Name]; ok && r.checksum != mg.Checksum {
+				return fmt.Errorf("%w: %s", ErrChecksumMismatch, mg.Name)
+			}
+		}
+
+		for _, mg := range m.migrations {
+			if _, ok := applied[mg.Name]; ok {
+				continue
+			}
+			if err := m.inTx(ctx, conn, mg.Up,
+				m.bind(`INSERT INTO schema_migrations (name, version, checksum) VALUES (?, ?, ?)`),
+				mg.Name, mg.Version, mg.Checksum); err != nil {
+				return fmt.Errorf("%s: %w", mg.Name, err)
+			}
+			done = append(done, mg.Name)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// Down rolls back the last steps applied migrations, newest first, and
+// returns the names rolled back. Nothing is rolled back if any of them has
+// no down file.
+func (m *Migrator) Down(ctx context.Context, steps int) ([]string, error) {
+	var done []string
+	err := m.locked(ctx, func(conn *sql.Conn, applied map[string]record) error {
+		known := map[string]Migration{}
+		for _, mg := range m.migrations {
+			known[mg.Name] = mg
+		}
+
+		names := make([]string, 0, len(applied))
+		for name := range applied {
+			names = append(names, name)
+		}
+		sort.Slice(names, func(i, j int) bool {
+			a, b := applied[names[i]], applied[names[j]]
+			if a.version != b.version {
+				return a.version > b.version
+			}
+			return names[i] > names[j]
+		})
+		if steps < len(names) {
+			names = names[:steps]
+		}
+
+		for _, name := range names {
+			if mg, ok := known[name]; !ok || mg.Down == "" {
+				return fmt.Errorf("%w: %s", ErrIrreversible, name)
+			}
+		}
+		for _, name := range names {
+			if err := m.inTx(ctx, conn, known[name].Down,
+				m.bind(`DELETE FROM schema_migrations WHERE name = ?`), name); err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			done = append(done, name)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// Baseline records every migration up to and including version as applied
+// without running it. Use it once on a database whose schema was created
+// before migrations were tracked.
+func (m *Migrator) Baseline(ctx context.Context, version int) ([]string, error) {
+	var done []string
+	err := m.locked(ctx, func(conn *sql.Conn, applied map[string]record) error {
+		for _, mg := range m.migrations {
+			if mg.Version > version {
+				break
+			}
+			if _, ok := applied[mg.Name]; ok {
+				continue
+			}
+			if _, err := conn.ExecContext(ctx,
+				m.bind(`INSERT INTO schema_migrations (name, version, checksum) VALUES (?, ?, ?)`),
+				mg.Name, mg.Version, mg.Checksum); err != nil {
+				return fmt.Errorf("%s: %w", mg.Name, err)
+			}
+			done = append(done, mg.Name)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// Status lists every known migration and whether it has been applied.
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	var out []Status
+	err := m.locked(ctx, func(conn *sql.Conn, applied map[string]record) error {
+		for _, mg := range m.migrations {
+			s := Status{Migration: mg}
+			if r, ok := applied[mg.Name]; ok {
+				at := r.appliedAt
+				s.AppliedAt = &at
+				s.Modified = r.checksum != mg.Checksum
+			}
+			out = append(out, s)
+		}
+		return nil
+	})
+	return out, err
+}
+
+// locked runs fn on a single connection holding the migration lock, with
+// schema_migrations created and its rows loaded.
+func (m *Migrator) locked(ctx context.Context, fn func(*sql.Conn, map[string]record) error) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	unlock, err := m.dialect.lock(ctx, conn)
+	if err != nil {
+		return fmt.Errorf("acquire migration lock: %w", err)
+	}
+	defer unlock()
+
+	if _, err := conn.ExecContext(ctx, m.dialect.schemaMigrations); err != nil {
+		return fmt.Errorf("create schema_migrations: %w", err)
+	}
+
+	rows, err := conn.QueryContext(ctx, `SELECT name, version, checksum, applied_at FROM schema_migrations`)
+	if err != nil {
+		return err
+	}
+	applied := map[string]record{}
+	for rows.Next() {
+		var name string
+		var r record
+		if err := rows.Scan(&name, &r.version, &r.checksum, &r.appliedAt); err != nil {
+			rows.Close()
+			return err
+		}
+		applied[name] = r
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	return fn(conn, applied)
+}
+
+// bind rewrites the ? placeholders of query for the dialect.
+func (m *Migrator) bind(query string) string {
+	return sqlx.Rebind(m.dialect.bind, query)
+}
+
+// inTx runs script and then the bookkeeping statement in one transaction.
+func (m *Migrator) inTx(ctx context.Context, conn *sql.Conn, script, bookkeeping string, args ...interface{}) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, stmt := range m.statements(script) {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, bookkeeping, args...); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// statements splits script at semicolons when the dialect runs one
+// statement at a time.
+func (m *Migrator) statements(script string) []string {
+	if !m.dialect.split {
+		return []string{script}
+	}
+	var out []string
+	for _, stmt := range strings.Split(script, ";") {
+		if stmt = strings.TrimSpace(stmt); stmt != "" {
+			out = append(out, stmt)
+		}
+	}
+	return out
+}
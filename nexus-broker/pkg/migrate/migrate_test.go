@@ -0,0 +1,249 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/migrations"
+)
+
+func testFS() fstest.MapFS {
+	return fstest.MapFS{
+		"00_init.sql":   {Data: []byte("CREATE TABLE a (id INT);")},
+		"02_b.sql":      {Data: []byte("ALTER TABLE a ADD COLUMN b INT;")},
+		"02_b.down.sql": {Data: []byte("ALTER TABLE a DROP COLUMN b;")},
+		"01_y.sql":      {Data: []byte("SELECT 1;")},
+		"01_x.sql":      {Data: []byte("SELECT 2;")},
+		"10_c.sql":      {Data: []byte("SELECT 3;")},
+		"migrations.go": {Data: []byte("package migrations")},
+		"README.md":     {Data: []byte("not a migration")},
+	}
+}
+
+func newMigrator(t *testing.T, fsys fstest.MapFS) (*Migrator, sqlmock.Sqlmock) {
+	t.Helper()
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	m, err := New(db, fsys)
+	require.NoError(t, err)
+	return m, mock
+}
+
+// expectLocked sets up the expectations shared by every Migrator method.
+func expectLocked(mock sqlmock.Sqlmock, applied *sqlmock.Rows) {
+	mock.ExpectExec(`SELECT pg_advisory_lock\(\$1\)`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`CREATE TABLE IF NOT EXISTS schema_migrations`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`SELECT name, version, checksum, applied_at FROM schema_migrations`).WillReturnRows(applied)
+}
+
+func appliedRows() *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"name", "version", "checksum", "applied_at"})
+}
+
+func checksumOf(t *testing.T, fsys fstest.MapFS, name string) string {
+	t.Helper()
+	ms, err := Load(fsys)
+	require.NoError(t, err)
+	for _, m := range ms {
+		if m.Name == name {
+			return m.Checksum
+		}
+	}
+	t.Fatalf("no migration %s", name)
+	return ""
+}
+
+func TestLoad_OrdersByVersionThenName(t *testing.T) {
+	ms, err := Load(testFS())
+	require.NoError(t, err)
+
+	var names []string
+	for _, m := range ms {
+		names = append(names, m.Name)
+	}
+	assert.Equal(t, []string{"00_init", "01_x", "01_y", "02_b", "10_c"}, names)
+	assert.Equal(t, "ALTER TABLE a DROP COLUMN b;", ms[3].Down)
+	assert.Equal(t, 10, ms[4].Version)
+}
+
+func TestLoad_RejectsBadFiles(t *testing.T) {
+	_, err := Load(fstest.MapFS{"add_thing.sql": {Data: []byte("SELECT 1;")}})
+	assert.Error(t, err)
+
+	_, err = Load(fstest.MapFS{"03_orphan.down.sql": {Data: []byte("SELECT 1;")}})
+	assert.Error(t, err)
+}
+
+func TestLoad_EmbeddedMigrations(t *testing.T) {
+	ms, err := Load(migrations.FS)
+	require.NoError(t, err)
+	require.NotEmpty(t, ms)
+	assert.Equal(t, "00_create_tables", ms[0].Name)
+}
+
+func TestLoad_EmbeddedDialectMigrations(t *testing.T) {
+	for name, fsys := range map[string]fs.FS{"mysql": migrations.MySQL, "sqlite": migrations.SQLite} {
+		ms, err := Load(fsys)
+		require.NoError(t, err, name)
+		require.NotEmpty(t, ms, name)
+		assert.Equal(t, "00_create_tables", ms[0].Name, name)
+	}
+}
+
+func TestUp_MySQLSplitsStatements(t *testing.T) {
+	fsys := fstest.MapFS{"00_init.sql": {Data: []byte("CREATE TABLE a (id INT);\nCREATE INDEX idx_a ON a (id);\n")}}
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	m, err := NewForDialect(db, fsys, MySQL)
+	require.NoError(t, err)
+
+	mock.ExpectQuery(`SELECT GET_LOCK\(\?, -1\)`).WithArgs(mysqlLockName).
+		WillReturnRows(sqlmock.NewRows([]string{"lock"}).AddRow(1))
+	mock.ExpectExec(`CREATE TABLE IF NOT EXISTS schema_migrations`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`SELECT name, version, checksum, applied_at FROM schema_migrations`).WillReturnRows(appliedRows())
+	mock.ExpectQuery(`FROM information_schema.tables`).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(0))
+	mock.ExpectBegin()
+	mock.ExpectExec(`^CREATE TABLE a \(id INT\)$`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`^CREATE INDEX idx_a ON a \(id\)$`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`INSERT INTO schema_migrations \(name, version, checksum\) VALUES \(\?, \?, \?\)`).
+		WithArgs("00_init", 0, checksumOf(t, fsys, "00_init")).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectExec(`SELECT RELEASE_LOCK\(\?\)`).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	done, err := m.Up(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"00_init"}, done)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUp_AppliesPendingInOrder(t *testing.T) {
+	fsys := testFS()
+	m, mock := newMigrator(t, fsys)
+
+	expectLocked(mock, appliedRows().
+		AddRow("00_init", 0, checksumOf(t, fsys, "00_init"), time.Now()).
+		AddRow("01_x", 1, checksumOf(t, fsys, "01_x"), time.Now()))
+	for _, name := range []string{"01_y", "02_b", "10_c"} {
+		mock.ExpectBegin()
+		mock.ExpectExec(`.+`).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(`INSERT INTO schema_migrations`).
+			WithArgs(name, sqlmock.AnyArg(), checksumOf(t, fsys, name)).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+	}
+	mock.ExpectExec(`SELECT pg_advisory_unlock\(\$1\)`).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	done, err := m.Up(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"01_y", "02_b", "10_c"}, done)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUp_FailedMigrationRollsBack(t *testing.T) {
+	fsys := fstest.MapFS{"00_init.sql": {Data: []byte("CREATE TABLE a (id INT);")}}
+	m, mock := newMigrator(t, fsys)
+
+	expectLocked(mock, appliedRows())
+	mock.ExpectQuery(`SELECT to_regclass`).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	mock.ExpectBegin()
+	mock.ExpectExec(`CREATE TABLE a`).WillReturnError(sql.ErrConnDone)
+	mock.ExpectRollback()
+	mock.ExpectExec(`SELECT pg_advisory_unlock`).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	done, err := m.Up(context.Background())
+	assert.ErrorIs(t, err, sql.ErrConnDone)
+	assert.Empty(t, done)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUp_ChecksumMismatch(t *testing.T) {
+	m, mock := newMigrator(t, testFS())
+
+	expectLocked(mock, appliedRows().AddRow("00_init", 0, "stale", time.Now()))
+	mock.ExpectExec(`SELECT pg_advisory_unlock`).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	_, err := m.Up(context.Background())
+	assert.ErrorIs(t, err, ErrChecksumMismatch)
+	assert.Contains(t, err.Error(), "00_init")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUp_UntrackedSchemaNeedsBaseline(t *testing.T) {
+	m, mock := newMigrator(t, testFS())
+
+	expectLocked(mock, appliedRows())
+	mock.ExpectQuery(`SELECT to_regclass`).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectExec(`SELECT pg_advisory_unlock`).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	_, err := m.Up(context.Background())
+	assert.ErrorIs(t, err, ErrUntracked)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDown_RollsBackNewestFirst(t *testing.T) {
+	fsys := testFS()
+	fsys["10_c.down.sql"] = &fstest.MapFile{Data: []byte("SELECT 4;")}
+	m, mock := newMigrator(t, fsys)
+
+	expectLocked(mock, appliedRows().
+		AddRow("02_b", 2, checksumOf(t, fsys, "02_b"), time.Now()).
+		AddRow("10_c", 10, checksumOf(t, fsys, "10_c"), time.Now()).
+		AddRow("01_x", 1, checksumOf(t, fsys, "01_x"), time.Now()))
+	for _, step := range []struct{ name, script string }{{"10_c", `SELECT 4;`}, {"02_b", `ALTER TABLE a DROP COLUMN b;`}} {
+		mock.ExpectBegin()
+		mock.ExpectExec(step.script).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(`DELETE FROM schema_migrations WHERE name = \$1`).WithArgs(step.name).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+	}
+	mock.ExpectExec(`SELECT pg_advisory_unlock`).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	done, err := m.Down(context.Background(), 2)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"10_c", "02_b"}, done)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDown_IrreversibleRunsNothing(t *testing.T) {
+	fsys := testFS()
+	m, mock := newMigrator(t, fsys)
+
+	expectLocked(mock, appliedRows().
+		AddRow("02_b", 2, checksumOf(t, fsys, "02_b"), time.Now()).
+		AddRow("10_c", 10, checksumOf(t, fsys, "10_c"), time.Now()))
+	mock.ExpectExec(`SELECT pg_advisory_unlock`).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	done, err := m.Down(context.Background(), 2)
+	assert.ErrorIs(t, err, ErrIrreversible)
+	assert.Empty(t, done)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestBaseline_RecordsWithoutRunning(t *testing.T) {
+	fsys := testFS()
+	m, mock := newMigrator(t, fsys)
+
+	expectLocked(mock, appliedRows())
+	for _, name := range []string{"00_init", "01_x", "01_y"} {
+		mock.ExpectExec(`INSERT INTO schema_migrations`).
+			WithArgs(name, sqlmock.AnyArg(), checksumOf(t, fsys, name)).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+	mock.ExpectExec(`SELECT pg_advisory_unlock`).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	done, err := m.Baseline(context.Background(), 1)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"00_init", "01_x", "01_y"}, done)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -97,11 +97,47 @@ func VerifyIDToken(ctx context.Context, client *http.Client, rawIDToken, clientI
 		return nil, err
 	}
 	verifier := prov.Verifier(&gooidc.Config{ClientID: clientID})
+	return verify(ctx, verifier, rawIDToken, expectedNonce, nil, start)
+}
+
+// IssuerCheck validates the iss claim of an ID token whose signature and
+// audience have been verified.
+type IssuerCheck func(idt *gooidc.IDToken) error
+
+// VerifyIDTokenWithKeys verifies the ID token like VerifyIDToken, but
+// against the keys at jwksURL rather than those of the issuer the token
+// names, and with checkIssuer in place of go-oidc's exact iss match. It
+// serves providers such as Azure AD whose multi-tenant metadata publishes a
+// templated issuer.
+func VerifyIDTokenWithKeys(ctx context.Context, client *http.Client, rawIDToken, clientID, expectedNonce, jwksURL string, checkIssuer IssuerCheck) (*gooidc.IDToken, error) {
+	start := time.Now()
+	if strings.TrimSpace(rawIDToken) == "" {
+		verifyTotal.WithLabelValues("error").Inc()
+		return nil, errors.New("id_token empty")
+	}
+	if strings.TrimSpace(jwksURL) == "" {
+		verifyTotal.WithLabelValues("error").Inc()
+		return nil, errors.New("jwks_uri is empty")
+	}
+	keys := gooidc.NewRemoteKeySet(gooidc.ClientContext(ctx, client), jwksURL)
+	verifier := gooidc.NewVerifier("", keys, &gooidc.Config{ClientID: clientID, SkipIssuerCheck: true})
+	return verify(ctx, verifier, rawIDToken, expectedNonce, checkIssuer, start)
+}
+
+// verify runs verifier on the ID token, then checks its issuer with
+// checkIssuer if set, and its iat and nonce.
+func verify(ctx context.Context, verifier *gooidc.IDTokenVerifier, rawIDToken, expectedNonce string, checkIssuer IssuerCheck, start time.Time) (*gooidc.IDToken, error) {
 	idt, err := verifier.Verify(ctx, rawIDToken)
 	if err != nil {
 		verifyTotal.WithLabelValues("error").Inc()
 		return nil, err
 	}
+	if checkIssuer != nil {
+		if err := checkIssuer(idt); err != nil {
+			verifyTotal.WithLabelValues("error").Inc()
+			return nil, err
+		}
+	}
 	// iat check (allow small clock skew)
 	var claims struct {
 		IAT   int64  `json:"iat"`
@@ -0,0 +1,239 @@
+// Package pages renders the pages the Broker shows end users' browsers, the
+// credential capture form and the error page, in the language their
+// Accept-Language header prefers.
+//
+// Templates (templates/<page>.html) and message catalogs
+// (locales/<BCP 47 tag>.json, flat objects of key to message) are embedded.
+// A directory with the same layout, given to New, replaces templates and
+// adds or overrides messages and languages. Messages missing from a
+// language fall back to English.
+package pages
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"golang.org/x/text/language"
+)
+
+//go:embed templates/*.html locales/*.json
+var embedded embed.FS
+
+// Page names.
+const (
+	Error   = "error"
+	Capture = "capture"
+)
+
+// ErrorPage is the data of the error page. MessageKey names the catalog
+// message that explains Code to the user.
+type ErrorPage struct {
+	Code         string
+	MessageKey   string
+	ConnectionID string
+}
+
+// CaptureForm is the data of the credential capture form, which posts State
+// and one value per field to capture-credential next to the page.
+type CaptureForm struct {
+	State        string
+	ProviderName string
+	Fields       []Field
+}
+
+// Field is one input of the capture form.
+type Field struct {
+	Name        string
+	Label       string
+	Description string
+	// Type is the input type: text, password, email or url.
+	Type     string
+	Required bool
+	// Value prefills the input with a default that is not secret.
+	Value string
+	// HasDefault is set on secret fields with a configured default, which
+	// the user may leave empty.
+	HasDefault bool
+}
+
+// Pages holds the page templates and the message catalogs.
+type Pages struct {
+	templates map[string]*template.Template
+	catalogs  map[language.Tag]map[string]string
+	// tags lists the catalog languages, English first.
+	tags    []language.Tag
+	matcher language.Matcher
+}
+
+// New loads the embedded pages and then the overrides in dir, if dir is not
+// empty.
+func New(dir string) (*Pages, error) {
+	p := &Pages{templates: map[string]*template.Template{}, catalogs: map[language.Tag]map[string]string{}}
+	if err := p.load(embedded); err != nil {
+		return nil, err
+	}
+	if dir != "" {
+		if _, err := os.Stat(dir); err != nil {
+			return nil, fmt.Errorf("pages: %w", err)
+		}
+		if err := p.load(os.DirFS(dir)); err != nil {
+			return nil, err
+		}
+	}
+	for _, name := range []string{Error, Capture} {
+		if p.templates[name] == nil {
+			return nil, fmt.Errorf("pages: no %s template", name)
+		}
+	}
+
+	p.tags = []language.Tag{language.English}
+	for tag := range p.catalogs {
+		if tag != language.English {
+			p.tags = append(p.tags, tag)
+		}
+	}
+	sort.Slice(p.tags[1:], func(i, j int) bool { return p.tags[i+1].String() < p.tags[j+1].String() })
+	p.matcher = language.NewMatcher(p.tags)
+	return p, nil
+}
+
+var (
+	defaultOnce  sync.Once
+	defaultPages *Pages
+)
+
+// Default returns the embedded pages without overrides.
+func Default() *Pages {
+	defaultOnce.Do(func() {
+		p, err := New("")
+		if err != nil {
+			panic(err)
+		}
+		defaultPages = p
+	})
+	return defaultPages
+}
+
+// load reads the templates and catalogs in fsys over those already loaded.
+func (p *Pages) load(fsys fs.FS) error {
+	files, err := fs.Glob(fsys, "templates/*.html")
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		src, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return err
+		}
+		name := strings.TrimSuffix(path.Base(file), ".html")
+		// The functions are bound to the request's language in Render.
+		t, err := template.New(name).Funcs(template.FuncMap{
+			"t":    func(string, ...any) string { return "" },
+			"lang": func() string { return "" },
+		}).Parse(string(src))
+		if err != nil {
+			return fmt.Errorf("pages: %s: %w", file, err)
+		}
+		p.templates[name] = t
+	}
+
+	files, err = fs.Glob(fsys, "locales/*.json")
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		tag, err := language.Parse(strings.TrimSuffix(path.Base(file), ".json"))
+		if err != nil {
+			return fmt.Errorf("pages: %s: %w", file, err)
+		}
+		src, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return err
+		}
+		var messages map[string]string
+		if err := json.Unmarshal(src, &messages); err != nil {
+			return fmt.Errorf("pages: %s: %w", file, err)
+		}
+		if p.catalogs[tag] == nil {
+			p.catalogs[tag] = map[string]string{}
+		}
+		for key, msg := range messages {
+			p.catalogs[tag][key] = msg
+		}
+	}
+	return nil
+}
+
+// Language returns the catalog language that best matches r's
+// Accept-Language header, or English.
+func (p *Pages) Language(r *http.Request) language.Tag {
+	accepted, _, _ := language.ParseAcceptLanguage(r.Header.Get("Accept-Language"))
+	_, i, confidence := p.matcher.Match(accepted...)
+	if confidence == language.No {
+		return language.English
+	}
+	return p.tags[i]
+}
+
+// Lookup returns the message key in tag's catalog, or in the English one.
+func (p *Pages) Lookup(tag language.Tag, key string) (string, bool) {
+	if msg, ok := p.catalogs[tag][key]; ok {
+		return msg, true
+	}
+	msg, ok := p.catalogs[language.English][key]
+	return msg, ok
+}
+
+// Message returns the message key in tag's language formatted with args,
+// or key itself when no catalog has it.
+func (p *Pages) Message(tag language.Tag, key string, args ...any) string {
+	msg, ok := p.Lookup(tag, key)
+	if !ok {
+		return key
+	}
+	if len(args) > 0 {
+		return fmt.Sprintf(msg, args...)
+	}
+	return msg
+}
+
+// Render writes page name with data in r's language. The page may load
+// nothing from elsewhere and may not be framed.
+func (p *Pages) Render(w http.ResponseWriter, r *http.Request, status int, name string, data any) error {
+	t, ok := p.templates[name]
+	if !ok {
+		return fmt.Errorf("pages: no %s template", name)
+	}
+	tag := p.Language(r)
+	t, err := t.Clone()
+	if err != nil {
+		return err
+	}
+	t.Funcs(template.FuncMap{
+		"t":    func(key string, args ...any) string { return p.Message(tag, key, args...) },
+		"lang": tag.String,
+	})
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		return fmt.Errorf("pages: %s: %w", name, err)
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Language", tag.String())
+	w.Header().Add("Vary", "Accept, Accept-Language")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'; frame-ancestors 'none'")
+	w.WriteHeader(status)
+	_, err = w.Write(buf.Bytes())
+	return err
+}
@@ -0,0 +1,87 @@
+package pages
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/text/language"
+)
+
+func TestCatalogsAreComplete(t *testing.T) {
+	p := Default()
+	for tag, catalog := range p.catalogs {
+		for key := range p.catalogs[language.English] {
+			assert.Contains(t, catalog, key, "%s is missing %s", tag, key)
+		}
+	}
+}
+
+func TestLanguage(t *testing.T) {
+	p := Default()
+	for header, want := range map[string]string{
+		"":                        "en",
+		"de-CH,de;q=0.9,en;q=0.8": "de",
+		"fr":                      "fr",
+		"pt-BR":                   "pt",
+		"ja,en-GB;q=0.5":          "en",
+		"ja":                      "en",
+	} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept-Language", header)
+		assert.Equal(t, want, p.Language(req).String(), header)
+	}
+}
+
+func TestRender(t *testing.T) {
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Language", "de")
+	rr := httptest.NewRecorder()
+	require.NoError(t, Default().Render(rr, req, http.StatusBadRequest, Error, ErrorPage{Code: "consent_denied", MessageKey: "error.consent_denied"}))
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Equal(t, "de", rr.Header().Get("Content-Language"))
+	assert.Equal(t, "text/html; charset=utf-8", rr.Header().Get("Content-Type"))
+	assert.Contains(t, rr.Body.String(), `<html lang="de">`)
+	assert.Contains(t, rr.Body.String(), "Verbindung fehlgeschlagen")
+	assert.Contains(t, rr.Body.String(), "<code>consent_denied</code>")
+}
+
+func TestOverrides(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) {
+		require.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644))
+	}
+	write("templates/error.html", `<p lang="{{lang}}">{{t "error.title"}}: {{.Code}}</p>`)
+	write("locales/de.json", `{"error.title": "Hoppla"}`)
+	write("locales/nl.json", `{"error.title": "Verbinding mislukt"}`)
+
+	p, err := New(dir)
+	require.NoError(t, err)
+	render := func(lang string) string {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept-Language", lang)
+		rr := httptest.NewRecorder()
+		require.NoError(t, p.Render(rr, req, http.StatusOK, Error, ErrorPage{Code: "oauth_error"}))
+		return rr.Body.String()
+	}
+	assert.Equal(t, `<p lang="de">Hoppla: oauth_error</p>`, render("de"))
+	assert.Equal(t, `<p lang="nl">Verbinding mislukt: oauth_error</p>`, render("nl"))
+	assert.Equal(t, `<p lang="en">Connection failed: oauth_error</p>`, render("en"))
+
+	// Overridden catalogs keep the embedded messages they do not replace,
+	// and new languages fall back to English.
+	assert.Equal(t, "Verbinden", p.Message(language.German, "capture.submit"))
+	assert.Equal(t, "Connect", p.Message(language.Dutch, "capture.submit"))
+
+	_, err = New(filepath.Join(dir, "missing"))
+	assert.Error(t, err)
+	write("templates/capture.html", `{{.Missing`)
+	_, err = New(dir)
+	assert.Error(t, err)
+}
@@ -1,13 +1,28 @@
 package provider
 
 import (
+	"time"
+
 	"github.com/google/uuid"
 )
 
-// ProfileList is the (id, name) struct for the List method
+// ProfileList is the (id, name) struct for the List method. DeletedAt is
+// only set by ListProfilesWithDeleted.
 type ProfileList struct {
-	ID   string `db:"id" json:"id"`
-	Name string `db:"name" json:"name"`
+	ID        string     `db:"id" json:"id"`
+	Name      string     `db:"name" json:"name"`
+	DeletedAt *time.Time `db:"deleted_at" json:"deleted_at,omitempty"`
+}
+
+// Metadata is a provider's entry in GetMetadata, which groups providers by
+// auth type and then by name.
+type Metadata struct {
+	ID               string   `json:"id"`
+	APIBaseURL       string   `json:"api_base_url"`
+	UserInfoEndpoint string   `json:"user_info_endpoint"`
+	Scopes           []string `json:"scopes"`
+	Description      string   `json:"description"`
+	Category         string   `json:"category"`
 }
 
 // ProfileStorer defines the store's behavior for the provider handler.
@@ -16,11 +31,23 @@ type ProfileStorer interface {
 	GetProfile(id uuid.UUID) (*Profile, error)
 	GetProfileByName(name string) (*Profile, error)
 	// ...
-	UpdateProfile(p *Profile) error
-	PatchProfile(id uuid.UUID, updates map[string]interface{}) error
+	UpdateProfile(p *Profile, ifMatch string) error
+	PatchProfile(id uuid.UUID, patch map[string]interface{}, ifMatch string) (*Profile, error)
 	DeleteProfile(id uuid.UUID) error
 	// ...
 	DeleteProfileByName(name string) (int64, error)
-	ListProfiles() ([]ProfileList, error)
-	GetMetadata() (map[string]map[string]interface{}, error)
+	ListProfiles(workspaceID string) ([]ProfileList, error)
+	ListProfilesWithDeleted(workspaceID string) ([]ProfileList, error)
+	RestoreProfile(id uuid.UUID) error
+	PurgeProfile(id uuid.UUID) (int64, error)
+	GetMetadata(workspaceID string) (map[string]map[string]Metadata, error)
+}
+
+// RegistrationStorer defines the store's behavior for registering and
+// rotating provider clients with Dynamic Client Registration.
+type RegistrationStorer interface {
+	RegisterProfileWithClient(profileJSON string, reg *Registration) (*Profile, error)
+	GetProfile(id uuid.UUID) (*Profile, error)
+	GetRegistration(providerID uuid.UUID) (*Registration, error)
+	RotateRegistration(reg *Registration, clientID, clientSecret string) error
 }
@@ -0,0 +1,146 @@
+package provider
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/vault"
+)
+
+// Registration is the OAuth client the broker registered for a provider
+// with Dynamic Client Registration (RFC 7591). The client ID and secret are
+// stored on the profile; the registration holds what is needed to manage
+// the client through its configuration endpoint (RFC 7592).
+type Registration struct {
+	ProviderID uuid.UUID `json:"provider_id" db:"provider_id"`
+	ClientURI  string    `json:"registration_client_uri,omitempty" db:"registration_client_uri"`
+	// AccessToken authorizes calls to ClientURI. It is sealed at rest and
+	// never returned by the API.
+	AccessToken           string     `json:"-" db:"registration_access_token"`
+	ClientSecretExpiresAt *time.Time `json:"client_secret_expires_at,omitempty" db:"client_secret_expires_at"`
+	RegisteredAt          time.Time  `json:"registered_at" db:"registered_at"`
+	RotatedAt             *time.Time `json:"rotated_at,omitempty" db:"rotated_at"`
+}
+
+// registrationTokenColumn is the column registration access tokens are
+// stored in, bound into their ciphertext.
+const registrationTokenColumn = "registration_access_token"
+
+// RegisterProfileWithClient registers a provider profile whose OAuth client
+// was just registered with the provider, and records reg for it in the same
+// transaction. reg.ProviderID and reg.RegisteredAt are set on success.
+func (s *Store) RegisterProfileWithClient(profileJSON string, reg *Registration) (*Profile, error) {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return nil, fmt.Errorf("database: failed to begin: %w", err)
+	}
+	defer tx.Rollback()
+
+	p, err := s.registerProfile(tx, profileJSON)
+	if err != nil {
+		return nil, err
+	}
+	token, err := s.sealRegistrationToken(reg.AccessToken)
+	if err != nil {
+		return nil, err
+	}
+	err = tx.QueryRow(`
+		INSERT INTO provider_registrations (provider_id, registration_client_uri, registration_access_token, client_secret_expires_at)
+		VALUES ($1, $2, $3, $4)
+		RETURNING registered_at`,
+		p.ID, reg.ClientURI, token, reg.ClientSecretExpiresAt,
+	).Scan(&reg.RegisteredAt)
+	if err != nil {
+		return nil, fmt.Errorf("database: failed to record client registration: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("database: failed to commit: %w", err)
+	}
+	reg.ProviderID = p.ID
+	return p, nil
+}
+
+// GetRegistration returns the client registration of an active provider,
+// or ErrNotFound if its client was not registered by the broker.
+func (s *Store) GetRegistration(providerID uuid.UUID) (*Registration, error) {
+	var reg Registration
+	err := s.db.Get(&reg, `
+		SELECT r.provider_id, r.registration_client_uri, r.registration_access_token, r.client_secret_expires_at, r.registered_at, r.rotated_at
+		FROM provider_registrations r JOIN provider_profiles p ON p.id = r.provider_id
+		WHERE r.provider_id = $1 AND p.deleted_at IS NULL`, providerID)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get client registration: %w", err)
+	}
+	if reg.AccessToken, err = vault.OpenSecret(s.secretKey, reg.AccessToken, registrationTokenColumn); err != nil {
+		return nil, fmt.Errorf("failed to decrypt registration access token of provider %s: %w", providerID, err)
+	}
+	return &reg, nil
+}
+
+// RotateRegistration stores the credentials a provider issued when its
+// client registration was updated: the client ID and secret on the profile
+// and the rest in reg. reg.RotatedAt is set on success.
+func (s *Store) RotateRegistration(reg *Registration, clientID, clientSecret string) error {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return fmt.Errorf("failed to begin rotation: %w", err)
+	}
+	defer tx.Rollback()
+
+	p, err := s.lockProfile(tx, reg.ProviderID, "")
+	if err != nil {
+		return err
+	}
+	p.ClientID, p.ClientSecret = &clientID, &clientSecret
+	if err := s.writeProfile(tx, p); err != nil {
+		return err
+	}
+	if err := s.updateRegistration(tx, reg); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit rotation: %w", err)
+	}
+	return nil
+}
+
+func (s *Store) updateRegistration(tx *sqlx.Tx, reg *Registration) error {
+	token, err := s.sealRegistrationToken(reg.AccessToken)
+	if err != nil {
+		return err
+	}
+	err = tx.QueryRow(`
+		UPDATE provider_registrations
+		SET registration_client_uri = $1, registration_access_token = $2, client_secret_expires_at = $3, rotated_at = NOW()
+		WHERE provider_id = $4
+		RETURNING rotated_at`,
+		reg.ClientURI, token, reg.ClientSecretExpiresAt, reg.ProviderID,
+	).Scan(&reg.RotatedAt)
+	if err == sql.ErrNoRows {
+		return ErrNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update client registration: %w", err)
+	}
+	return nil
+}
+
+// sealRegistrationToken seals a registration access token with the store's
+// key; without a key it is stored as is.
+func (s sealer) sealRegistrationToken(token string) (string, error) {
+	if s.secretKey == nil {
+		return token, nil
+	}
+	sealed, err := vault.SealSecret(s.secretKey, token, registrationTokenColumn)
+	if err != nil {
+		return "", fmt.Errorf("failed to encrypt registration access token: %w", err)
+	}
+	return sealed, nil
+}
@@ -0,0 +1,226 @@
+package provider
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/authrequest"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/serviceaccount"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/vault"
+)
+
+// A credential_schema property is secret when it is marked "writeOnly": true
+// or has "format": "password". The string default of a secret property is a
+// static credential shared by every connection, so it is sealed at rest like
+// the client secret. So are the secretParams, credentials of the profile
+// itself.
+
+// secretParams are the top-level params sealed at rest.
+var secretParams = []string{authrequest.SigningKeyParam, serviceaccount.KeyParam}
+
+// ParamColumn is the column the secret param name is bound to when sealed.
+func ParamColumn(name string) string {
+	return "params." + name
+}
+
+// SchemaDefaultColumn is the column a secret credential_schema default of
+// field is bound to when sealed (see vault.SecretAAD).
+func SchemaDefaultColumn(field string) string {
+	return "params.credential_schema.properties." + field + ".default"
+}
+
+// SealParams returns params with the secret params and the default of every
+// secret credential_schema property sealed with key. Values that are already
+// sealed are kept, and params without secrets are returned unchanged.
+func SealParams(key []byte, params *json.RawMessage) (*json.RawMessage, error) {
+	params, err := rewriteSecretParams(params, func(name, value string) (string, error) {
+		if vault.IsSealedSecret(value) {
+			return value, nil
+		}
+		sealed, err := vault.SealSecret(key, value, ParamColumn(name))
+		if err != nil {
+			return "", fmt.Errorf("failed to encrypt %s: %w", name, err)
+		}
+		return sealed, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rewriteSecretDefaults(params, func(field, value string) (string, bool, error) {
+		if vault.IsSealedSecret(value) {
+			return value, true, nil
+		}
+		sealed, err := vault.SealSecret(key, value, SchemaDefaultColumn(field))
+		if err != nil {
+			return "", false, fmt.Errorf("failed to encrypt default of %s: %w", field, err)
+		}
+		return sealed, true, nil
+	})
+}
+
+// OpenParams decrypts the secrets sealed by SealParams. Values written
+// before encryption are plaintext and left as is.
+func OpenParams(key []byte, params *json.RawMessage) (*json.RawMessage, error) {
+	params, err := rewriteSecretParams(params, func(name, value string) (string, error) {
+		opened, err := vault.OpenSecret(key, value, ParamColumn(name))
+		if err != nil {
+			return "", fmt.Errorf("failed to decrypt %s: %w", name, err)
+		}
+		return opened, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rewriteSecretDefaults(params, func(field, value string) (string, bool, error) {
+		opened, err := vault.OpenSecret(key, value, SchemaDefaultColumn(field))
+		if err != nil {
+			return "", false, fmt.Errorf("failed to decrypt default of %s: %w", field, err)
+		}
+		return opened, true, nil
+	})
+}
+
+// HasPlaintextSecrets reports whether params holds a secret param or
+// credential_schema default that is not sealed yet.
+func HasPlaintextSecrets(params *json.RawMessage) bool {
+	found := false
+	_, _ = rewriteSecretParams(params, func(name, value string) (string, error) {
+		found = found || !vault.IsSealedSecret(value)
+		return value, nil
+	})
+	_, _ = rewriteSecretDefaults(params, func(field, value string) (string, bool, error) {
+		found = found || !vault.IsSealedSecret(value)
+		return value, true, nil
+	})
+	return found
+}
+
+// SplitSecretDefaults removes the secret defaults from an opened
+// credential_schema so that it can be shown to end users, and returns them
+// by field alongside the redacted schema.
+func SplitSecretDefaults(schema json.RawMessage) (json.RawMessage, map[string]string, error) {
+	defaults := map[string]string{}
+	params := json.RawMessage(`{"credential_schema":` + string(schema) + `}`)
+	redacted, err := rewriteSecretDefaults(&params, func(field, value string) (string, bool, error) {
+		defaults[field] = value
+		return "", false, nil
+	})
+	if err != nil || redacted == &params {
+		return schema, defaults, err
+	}
+	var out struct {
+		Schema json.RawMessage `json:"credential_schema"`
+	}
+	if err := json.Unmarshal(*redacted, &out); err != nil {
+		return nil, nil, err
+	}
+	return out.Schema, defaults, nil
+}
+
+// rewriteSecretDefaults calls rewrite with the string default of each secret
+// credential_schema property in params and stores the value it returns, or
+// drops the default when keep is false. Parts of params that are not JSON
+// objects are left alone. When nothing changes params itself is returned.
+func rewriteSecretDefaults(params *json.RawMessage, rewrite func(field, value string) (next string, keep bool, err error)) (*json.RawMessage, error) {
+	if params == nil {
+		return nil, nil
+	}
+	var top map[string]json.RawMessage
+	if json.Unmarshal(*params, &top) != nil {
+		return params, nil
+	}
+	var schema map[string]json.RawMessage
+	if json.Unmarshal(top["credential_schema"], &schema) != nil {
+		return params, nil
+	}
+	var properties map[string]map[string]json.RawMessage
+	if json.Unmarshal(schema["properties"], &properties) != nil {
+		return params, nil
+	}
+
+	changed := false
+	for field, prop := range properties {
+		if !isSecretProperty(prop) {
+			continue
+		}
+		var value string
+		if json.Unmarshal(prop["default"], &value) != nil || value == "" {
+			continue
+		}
+		next, keep, err := rewrite(field, value)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case !keep:
+			delete(prop, "default")
+		case next != value:
+			prop["default"], _ = json.Marshal(next)
+		default:
+			continue
+		}
+		changed = true
+	}
+	if !changed {
+		return params, nil
+	}
+
+	var err error
+	if schema["properties"], err = json.Marshal(properties); err != nil {
+		return nil, err
+	}
+	if top["credential_schema"], err = json.Marshal(schema); err != nil {
+		return nil, err
+	}
+	out, err := json.Marshal(top)
+	if err != nil {
+		return nil, err
+	}
+	raw := json.RawMessage(out)
+	return &raw, nil
+}
+
+// rewriteSecretParams replaces the value of each non-empty string secret
+// param in params with the one rewrite returns. When nothing changes params
+// itself is returned.
+func rewriteSecretParams(params *json.RawMessage, rewrite func(name, value string) (string, error)) (*json.RawMessage, error) {
+	if params == nil {
+		return nil, nil
+	}
+	var top map[string]json.RawMessage
+	if json.Unmarshal(*params, &top) != nil {
+		return params, nil
+	}
+	changed := false
+	for _, name := range secretParams {
+		var value string
+		if json.Unmarshal(top[name], &value) != nil || value == "" {
+			continue
+		}
+		next, err := rewrite(name, value)
+		if err != nil {
+			return nil, err
+		}
+		if next != value {
+			top[name], _ = json.Marshal(next)
+			changed = true
+		}
+	}
+	if !changed {
+		return params, nil
+	}
+	out, err := json.Marshal(top)
+	if err != nil {
+		return nil, err
+	}
+	raw := json.RawMessage(out)
+	return &raw, nil
+}
+
+func isSecretProperty(prop map[string]json.RawMessage) bool {
+	var writeOnly bool
+	var format string
+	_ = json.Unmarshal(prop["writeOnly"], &writeOnly)
+	_ = json.Unmarshal(prop["format"], &format)
+	return writeOnly || format == "password"
+}
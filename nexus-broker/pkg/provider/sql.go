@@ -0,0 +1,602 @@
+package provider
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/vault"
+)
+
+// SQLStore provides provider profile management on MySQL or SQLite, in the
+// schema of migrations.MySQL and migrations.SQLite. It behaves as Store:
+// string lists are stored as JSON arrays, timestamps are written by the
+// store in UTC, and workspace visibility is checked in Go.
+type SQLStore struct {
+	db *sqlx.DB
+	sealer
+	// forUpdate locks the rows a SELECT in a transaction reads. SQLite
+	// locks the whole database when the transaction begins instead.
+	forUpdate string
+}
+
+var (
+	_ ProfileStorer      = (*SQLStore)(nil)
+	_ RegistrationStorer = (*SQLStore)(nil)
+)
+
+// NewSQLStore creates a provider store on a database opened with
+// store.OpenMySQL or store.OpenSQLite. secretKey works as for NewStore.
+func NewSQLStore(db *sqlx.DB, secretKey []byte) *SQLStore {
+	s := &SQLStore{db: db, sealer: sealer{secretKey: secretKey}}
+	if db.DriverName() == "mysql" {
+		s.forUpdate = " FOR UPDATE"
+	}
+	return s
+}
+
+// now returns the time written to the store. MySQL keeps microseconds, so
+// the time is truncated to them for ETags to survive a round trip.
+func (s *SQLStore) now() time.Time { return time.Now().UTC().Truncate(time.Microsecond) }
+
+// jsonList stores a []string as a JSON array.
+type jsonList []string
+
+func (l *jsonList) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*l = nil
+		return nil
+	case string:
+		return json.Unmarshal([]byte(v), (*[]string)(l))
+	case []byte:
+		return json.Unmarshal(v, (*[]string)(l))
+	}
+	return fmt.Errorf("provider: cannot scan %T into a string list", src)
+}
+
+func (l jsonList) Value() (driver.Value, error) {
+	if l == nil {
+		return "[]", nil
+	}
+	b, err := json.Marshal([]string(l))
+	return string(b), err
+}
+
+// isUniqueViolation reports whether err is MySQL's duplicate key error or
+// SQLite's unique constraint error.
+func isUniqueViolation(err error) bool {
+	var myErr *mysql.MySQLError
+	if errors.As(err, &myErr) {
+		return myErr.Number == 1062
+	}
+	return err != nil && strings.Contains(err.Error(), "UNIQUE constraint failed")
+}
+
+// sqlProfileColumns selects a provider profile in the order scanSQLProfile
+// reads it.
+const sqlProfileColumns = `id, name, client_id, client_secret, auth_url, token_url, issuer, enable_discovery, scopes, auth_type, COALESCE(auth_header, ''), COALESCE(api_base_url, ''), COALESCE(user_info_endpoint, ''), params, COALESCE(description, ''), COALESCE(category, ''), workspace_ids, COALESCE(ca_bundle, ''), updated_at, deleted_at`
+
+// scanSQLProfile reads a row selected with sqlProfileColumns.
+func scanSQLProfile(row rowScanner) (*Profile, error) {
+	var (
+		p      Profile
+		params sql.NullString
+	)
+	err := row.Scan(&p.ID, &p.Name, &p.ClientID, &p.ClientSecret, &p.AuthURL, &p.TokenURL, &p.Issuer, &p.EnableDiscovery,
+		(*jsonList)(&p.Scopes), &p.AuthType, &p.AuthHeader, &p.APIBaseURL, &p.UserInfoEndpoint, &params,
+		&p.Description, &p.Category, (*jsonList)(&p.WorkspaceIDs), &p.CABundle, &p.UpdatedAt, &p.DeletedAt)
+	if err != nil {
+		return nil, err
+	}
+	if params.Valid {
+		raw := json.RawMessage(params.String)
+		p.Params = &raw
+	}
+	return &p, nil
+}
+
+// sealedParams returns the params column of p, with its secret defaults
+// sealed.
+func (s *SQLStore) sealedParams(p *Profile) (sql.NullString, error) {
+	params, err := s.sealParams(p.Params)
+	if err != nil || params == nil {
+		return sql.NullString{}, err
+	}
+	return sql.NullString{String: string(*params), Valid: true}, nil
+}
+
+// RegisterProfile registers a new provider profile from JSON.
+func (s *SQLStore) RegisterProfile(profileJSON string) (*Profile, error) {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return nil, fmt.Errorf("database: failed to begin: %w", err)
+	}
+	defer tx.Rollback()
+
+	p, err := s.registerProfile(tx, profileJSON)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("database: failed to commit: %w", err)
+	}
+	return p, nil
+}
+
+func (s *SQLStore) registerProfile(tx *sqlx.Tx, profileJSON string) (*Profile, error) {
+	p, err := ParseProfile(profileJSON)
+	if err != nil {
+		return nil, err
+	}
+
+	var existingID uuid.UUID
+	err = tx.QueryRow(`SELECT id FROM provider_profiles WHERE name = ? AND deleted_at IS NULL LIMIT 1`, p.Name).Scan(&existingID)
+	if err == nil {
+		return nil, fmt.Errorf("name: provider with name '%s' already exists", p.Name)
+	}
+	if err != sql.ErrNoRows {
+		return nil, fmt.Errorf("database: error checking provider existence: %w", err)
+	}
+
+	if p.EnableDiscovery {
+		p.AuthURL, p.TokenURL = nil, nil
+	}
+	clientSecret, err := s.sealSecret(p.ClientSecret)
+	if err != nil {
+		return nil, err
+	}
+	params, err := s.sealedParams(p)
+	if err != nil {
+		return nil, err
+	}
+
+	p.ID = uuid.New()
+	p.UpdatedAt = s.now()
+	_, err = tx.Exec(`
+		INSERT INTO provider_profiles
+		(id, name, client_id, client_secret, auth_url, token_url, issuer, enable_discovery, scopes, auth_type, auth_header, api_base_url, user_info_endpoint, params, description, category, workspace_ids, ca_bundle, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		p.ID, p.Name, p.ClientID, clientSecret, p.AuthURL, p.TokenURL, p.Issuer,
+		p.EnableDiscovery, jsonList(p.Scopes), p.AuthType, p.AuthHeader,
+		p.APIBaseURL, p.UserInfoEndpoint, params, p.Description, p.Category, jsonList(p.WorkspaceIDs), p.CABundle,
+		p.UpdatedAt, p.UpdatedAt)
+	if isUniqueViolation(err) {
+		return nil, fmt.Errorf("name: provider with name '%s' already exists", p.Name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("database: failed to create provider profile: %w", err)
+	}
+	return p, nil
+}
+
+// RegisterProfileWithClient registers a provider profile whose OAuth client
+// was just registered with the provider, and records reg for it in the same
+// transaction. reg.ProviderID and reg.RegisteredAt are set on success.
+func (s *SQLStore) RegisterProfileWithClient(profileJSON string, reg *Registration) (*Profile, error) {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return nil, fmt.Errorf("database: failed to begin: %w", err)
+	}
+	defer tx.Rollback()
+
+	p, err := s.registerProfile(tx, profileJSON)
+	if err != nil {
+		return nil, err
+	}
+	token, err := s.sealRegistrationToken(reg.AccessToken)
+	if err != nil {
+		return nil, err
+	}
+	registeredAt := s.now()
+	if _, err := tx.Exec(`
+		INSERT INTO provider_registrations (provider_id, registration_client_uri, registration_access_token, client_secret_expires_at, registered_at)
+		VALUES (?, ?, ?, ?, ?)`,
+		p.ID, reg.ClientURI, token, utc(reg.ClientSecretExpiresAt), registeredAt); err != nil {
+		return nil, fmt.Errorf("database: failed to record client registration: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("database: failed to commit: %w", err)
+	}
+	reg.ProviderID, reg.RegisteredAt = p.ID, registeredAt
+	return p, nil
+}
+
+// utc converts t for a query parameter. SQLite compares timestamps as
+// text, which only orders them when they share a zone.
+func utc(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	u := t.UTC()
+	return &u
+}
+
+// GetProfile retrieves a provider profile by ID.
+func (s *SQLStore) GetProfile(id uuid.UUID) (*Profile, error) {
+	p, err := scanSQLProfile(s.db.QueryRow(`SELECT `+sqlProfileColumns+` FROM provider_profiles WHERE id = ? AND deleted_at IS NULL`, id))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get provider profile: %w", err)
+	}
+	if err := s.openSecrets(p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// GetProfileByName retrieves an active provider profile by name, ignoring
+// case.
+func (s *SQLStore) GetProfileByName(name string) (*Profile, error) {
+	p, err := scanSQLProfile(s.db.QueryRow(`SELECT `+sqlProfileColumns+` FROM provider_profiles WHERE LOWER(name) = ? AND deleted_at IS NULL`, strings.ToLower(name)))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("provider '%s' not found", name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get provider profile by name: %w", err)
+	}
+	if err := s.openSecrets(p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// UpdateProfile works as Store.UpdateProfile.
+func (s *SQLStore) UpdateProfile(p *Profile, ifMatch string) error {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return fmt.Errorf("failed to begin update: %w", err)
+	}
+	defer tx.Rollback()
+
+	current, err := s.lockProfile(tx, p.ID, ifMatch)
+	if err != nil {
+		return err
+	}
+	if p.ClientSecret == nil {
+		p.ClientSecret = current.ClientSecret
+	}
+	if err := s.writeProfile(tx, p); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit update: %w", err)
+	}
+	return nil
+}
+
+// PatchProfile works as Store.PatchProfile.
+func (s *SQLStore) PatchProfile(id uuid.UUID, patch map[string]interface{}, ifMatch string) (*Profile, error) {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin patch: %w", err)
+	}
+	defer tx.Rollback()
+
+	current, err := s.lockProfile(tx, id, ifMatch)
+	if err != nil {
+		return nil, err
+	}
+	p, err := applyPatch(current, patch)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.writeProfile(tx, p); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit patch: %w", err)
+	}
+	return p, nil
+}
+
+// lockProfile loads an active provider profile for update and checks it
+// against ifMatch, if set.
+func (s *SQLStore) lockProfile(tx *sqlx.Tx, id uuid.UUID, ifMatch string) (*Profile, error) {
+	p, err := scanSQLProfile(tx.QueryRow(`SELECT `+sqlProfileColumns+` FROM provider_profiles WHERE id = ? AND deleted_at IS NULL`+s.forUpdate, id))
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load provider profile: %w", err)
+	}
+	if ifMatch != "" && !p.MatchesETag(ifMatch) {
+		return nil, ErrPreconditionFailed
+	}
+	if err := s.openSecrets(p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// writeProfile overwrites every column of the profile with p and sets
+// p.UpdatedAt. An empty client secret is stored as NULL. Names are checked
+// here as well as by the unique index, which MySQL and SQLite report
+// differently.
+func (s *SQLStore) writeProfile(tx *sqlx.Tx, p *Profile) error {
+	var taken int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM provider_profiles WHERE name = ? AND id <> ? AND deleted_at IS NULL`, p.Name, p.ID).Scan(&taken); err != nil {
+		return fmt.Errorf("failed to check provider name: %w", err)
+	}
+	if taken > 0 {
+		return ErrNameTaken
+	}
+
+	clientSecret, err := s.sealSecret(p.ClientSecret)
+	if err != nil {
+		return err
+	}
+	params, err := s.sealedParams(p)
+	if err != nil {
+		return err
+	}
+	updatedAt := s.now()
+	_, err = tx.Exec(`
+		UPDATE provider_profiles
+		SET name = ?, client_id = ?, client_secret = ?, auth_url = ?, token_url = ?, issuer = ?, enable_discovery = ?,
+			scopes = ?, auth_type = ?, auth_header = ?, api_base_url = ?, user_info_endpoint = ?, params = ?,
+			description = ?, category = ?, workspace_ids = ?, ca_bundle = ?, updated_at = ?
+		WHERE id = ? AND deleted_at IS NULL`,
+		p.Name, p.ClientID, clientSecret, p.AuthURL, p.TokenURL, p.Issuer, p.EnableDiscovery,
+		jsonList(p.Scopes), p.AuthType, p.AuthHeader, p.APIBaseURL, p.UserInfoEndpoint, params,
+		p.Description, p.Category, jsonList(p.WorkspaceIDs), p.CABundle, updatedAt, p.ID)
+	if isUniqueViolation(err) {
+		return ErrNameTaken
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update provider profile: %w", err)
+	}
+	p.UpdatedAt = updatedAt
+	return nil
+}
+
+// DeleteProfile soft-deletes a provider profile by ID.
+func (s *SQLStore) DeleteProfile(id uuid.UUID) error {
+	if _, err := s.db.Exec(`UPDATE provider_profiles SET deleted_at = ? WHERE id = ?`, s.now(), id); err != nil {
+		return fmt.Errorf("failed to delete provider profile: %w", err)
+	}
+	return nil
+}
+
+// DeleteProfileByName soft-deletes ALL provider profiles with the given name.
+func (s *SQLStore) DeleteProfileByName(name string) (int64, error) {
+	result, err := s.db.Exec(`UPDATE provider_profiles SET deleted_at = ? WHERE name = ? AND deleted_at IS NULL`, s.now(), name)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete provider profiles: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	return rowsAffected, nil
+}
+
+// RestoreProfile undoes a soft delete.
+func (s *SQLStore) RestoreProfile(id uuid.UUID) error {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return fmt.Errorf("failed to begin restore: %w", err)
+	}
+	defer tx.Rollback()
+
+	var name string
+	var deletedAt *time.Time
+	err = tx.QueryRow(`SELECT name, deleted_at FROM provider_profiles WHERE id = ?`+s.forUpdate, id).Scan(&name, &deletedAt)
+	if err == sql.ErrNoRows {
+		return ErrNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load provider profile: %w", err)
+	}
+	if deletedAt == nil {
+		return ErrNotDeleted
+	}
+
+	// Names are only unique among active providers, so a new provider may
+	// have taken this one's name since it was deleted.
+	_, err = tx.Exec(`UPDATE provider_profiles SET deleted_at = NULL, updated_at = ? WHERE id = ?`, s.now(), id)
+	if isUniqueViolation(err) {
+		return ErrNameTaken
+	}
+	if err != nil {
+		return fmt.Errorf("failed to restore provider profile: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit restore: %w", err)
+	}
+	return nil
+}
+
+// PurgeProfile works as Store.PurgeProfile.
+func (s *SQLStore) PurgeProfile(id uuid.UUID) (int64, error) {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin purge: %w", err)
+	}
+	defer tx.Rollback()
+
+	var deletedAt *time.Time
+	err = tx.QueryRow(`SELECT deleted_at FROM provider_profiles WHERE id = ?`+s.forUpdate, id).Scan(&deletedAt)
+	if err == sql.ErrNoRows {
+		return 0, ErrNotFound
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to load provider profile: %w", err)
+	}
+	if deletedAt == nil {
+		return 0, ErrNotDeleted
+	}
+
+	query, args, err := sqlx.In(`SELECT COUNT(*) FROM connections WHERE provider_id = ? AND status IN (?)`, id, liveStatuses())
+	if err != nil {
+		return 0, fmt.Errorf("failed to count connections: %w", err)
+	}
+	var live int
+	if err := tx.QueryRow(query, args...).Scan(&live); err != nil {
+		return 0, fmt.Errorf("failed to count connections: %w", err)
+	}
+	if live > 0 {
+		return 0, &ConnectionsInUseError{Count: live}
+	}
+
+	const providerConnections = `SELECT id FROM connections WHERE provider_id = ?`
+	if _, err := tx.Exec(`UPDATE audit_events SET connection_id = NULL WHERE connection_id IN (`+providerConnections+`)`, id); err != nil {
+		return 0, fmt.Errorf("failed to detach audit events: %w", err)
+	}
+	if _, err := tx.Exec(`DELETE FROM tokens WHERE connection_id IN (`+providerConnections+`)`, id); err != nil {
+		return 0, fmt.Errorf("failed to delete tokens: %w", err)
+	}
+	res, err := tx.Exec(`DELETE FROM connections WHERE provider_id = ?`, id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete connections: %w", err)
+	}
+	connections, _ := res.RowsAffected()
+	// SQLite only cascades with foreign keys enabled; delete the
+	// registration explicitly.
+	if _, err := tx.Exec(`DELETE FROM provider_registrations WHERE provider_id = ?`, id); err != nil {
+		return 0, fmt.Errorf("failed to delete client registration: %w", err)
+	}
+	if _, err := tx.Exec(`DELETE FROM provider_profiles WHERE id = ?`, id); err != nil {
+		return 0, fmt.Errorf("failed to purge provider profile: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit purge: %w", err)
+	}
+	return connections, nil
+}
+
+// visibleProfiles returns the profiles visible to workspaceID, newest
+// first. The workspace lists are JSON, so visibility is checked in Go.
+func (s *SQLStore) visibleProfiles(workspaceID string, withDeleted bool) ([]*Profile, error) {
+	query := `SELECT ` + sqlProfileColumns + ` FROM provider_profiles`
+	if !withDeleted {
+		query += ` WHERE deleted_at IS NULL`
+	}
+	rows, err := s.db.Query(query + ` ORDER BY created_at DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*Profile
+	for rows.Next() {
+		p, err := scanSQLProfile(rows)
+		if err != nil {
+			return nil, err
+		}
+		if p.VisibleTo(workspaceID) {
+			out = append(out, p)
+		}
+	}
+	return out, rows.Err()
+}
+
+// ListProfiles retrieves non-deleted provider names and IDs visible to
+// workspaceID.
+func (s *SQLStore) ListProfiles(workspaceID string) ([]ProfileList, error) {
+	return s.listProfiles(workspaceID, false)
+}
+
+// ListProfilesWithDeleted is ListProfiles including soft-deleted providers,
+// which carry their DeletedAt.
+func (s *SQLStore) ListProfilesWithDeleted(workspaceID string) ([]ProfileList, error) {
+	return s.listProfiles(workspaceID, true)
+}
+
+func (s *SQLStore) listProfiles(workspaceID string, withDeleted bool) ([]ProfileList, error) {
+	profiles, err := s.visibleProfiles(workspaceID, withDeleted)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list profiles: %w", err)
+	}
+	var out []ProfileList
+	for _, p := range profiles {
+		out = append(out, ProfileList{ID: p.ID.String(), Name: p.Name, DeletedAt: p.DeletedAt})
+	}
+	return out, nil
+}
+
+// GetMetadata retrieves integration metadata for providers visible to
+// workspaceID, grouped by auth_type.
+func (s *SQLStore) GetMetadata(workspaceID string) (map[string]map[string]Metadata, error) {
+	profiles, err := s.visibleProfiles(workspaceID, false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query metadata: %w", err)
+	}
+	result := make(map[string]map[string]Metadata)
+	for _, p := range profiles {
+		addMetadata(result, p)
+	}
+	return result, nil
+}
+
+// GetRegistration returns the client registration of an active provider,
+// or ErrNotFound if its client was not registered by the broker.
+func (s *SQLStore) GetRegistration(providerID uuid.UUID) (*Registration, error) {
+	var reg Registration
+	err := s.db.Get(&reg, `
+		SELECT r.provider_id, r.registration_client_uri, r.registration_access_token, r.client_secret_expires_at, r.registered_at, r.rotated_at
+		FROM provider_registrations r JOIN provider_profiles p ON p.id = r.provider_id
+		WHERE r.provider_id = ? AND p.deleted_at IS NULL`, providerID)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get client registration: %w", err)
+	}
+	if reg.AccessToken, err = vault.OpenSecret(s.secretKey, reg.AccessToken, registrationTokenColumn); err != nil {
+		return nil, fmt.Errorf("failed to decrypt registration access token of provider %s: %w", providerID, err)
+	}
+	return &reg, nil
+}
+
+// RotateRegistration works as Store.RotateRegistration.
+func (s *SQLStore) RotateRegistration(reg *Registration, clientID, clientSecret string) error {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return fmt.Errorf("failed to begin rotation: %w", err)
+	}
+	defer tx.Rollback()
+
+	p, err := s.lockProfile(tx, reg.ProviderID, "")
+	if err != nil {
+		return err
+	}
+	p.ClientID, p.ClientSecret = &clientID, &clientSecret
+	if err := s.writeProfile(tx, p); err != nil {
+		return err
+	}
+	token, err := s.sealRegistrationToken(reg.AccessToken)
+	if err != nil {
+		return err
+	}
+	rotatedAt := s.now()
+	res, err := tx.Exec(`
+		UPDATE provider_registrations
+		SET registration_client_uri = ?, registration_access_token = ?, client_secret_expires_at = ?, rotated_at = ?
+		WHERE provider_id = ?`,
+		reg.ClientURI, token, utc(reg.ClientSecretExpiresAt), rotatedAt, reg.ProviderID)
+	if err != nil {
+		return fmt.Errorf("failed to update client registration: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit rotation: %w", err)
+	}
+	reg.RotatedAt = &rotatedAt
+	return nil
+}
+
+// EgressHosts works as Store.EgressHosts.
+func (s *SQLStore) EgressHosts(ctx context.Context) ([]string, error) {
+	return egressHosts(ctx, s.db)
+}
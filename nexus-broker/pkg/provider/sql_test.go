@@ -0,0 +1,144 @@
+package provider_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/internal/store"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/config"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/migrate"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/provider"
+)
+
+// sqlStores runs fn against a migrated SQLite database in memory, in cgo
+// builds, and against MySQL when NEXUS_TEST_MYSQL_DSN names a database.
+// Provider names are unique, so each test names its providers after a
+// fresh suffix.
+func sqlStores(t *testing.T, fn func(t *testing.T, s *provider.SQLStore, suffix string)) {
+	run := func(t *testing.T, db *sqlx.DB, b store.Backend) {
+		t.Cleanup(func() { db.Close() })
+		fsys, dialect := b.Migrations()
+		m, err := migrate.NewForDialect(db.DB, fsys, dialect)
+		require.NoError(t, err)
+		_, err = m.Up(context.Background())
+		require.NoError(t, err)
+		fn(t, provider.NewSQLStore(db, make([]byte, 32)), uuid.NewString()[:8])
+	}
+	t.Run("sqlite", func(t *testing.T) {
+		db, err := store.OpenSQLite(":memory:")
+		if errors.Is(err, store.ErrSQLiteUnavailable) {
+			t.Skip(err)
+		}
+		require.NoError(t, err)
+		run(t, db, store.BackendSQLite)
+	})
+	t.Run("mysql", func(t *testing.T) {
+		dsn := os.Getenv("NEXUS_TEST_MYSQL_DSN")
+		if dsn == "" {
+			t.Skip("NEXUS_TEST_MYSQL_DSN is not set")
+		}
+		db, err := store.OpenMySQL(dsn, config.DBPoolConfig{MaxOpenConns: 4})
+		require.NoError(t, err)
+		run(t, db, store.BackendMySQL)
+	})
+}
+
+func TestSQLStore_ProfileLifecycle(t *testing.T) {
+	sqlStores(t, func(t *testing.T, s *provider.SQLStore, suffix string) {
+		name := "idp-" + suffix
+		p, err := s.RegisterProfile(`{"name":"` + name + `","client_id":"c","client_secret":"shh","auth_url":"https://idp.example.com/auth","token_url":"https://idp.example.com/token","scopes":["openid"]}`)
+		require.NoError(t, err)
+
+		_, err = s.RegisterProfile(`{"name":"` + name + `","auth_type":"api_key"}`)
+		assert.ErrorContains(t, err, "already exists")
+
+		got, err := s.GetProfileByName(name)
+		require.NoError(t, err)
+		assert.Equal(t, p.ID, got.ID)
+		assert.Equal(t, "shh", *got.ClientSecret)
+		assert.Equal(t, []string{"openid"}, got.Scopes)
+		assert.Equal(t, p.ETag(), got.ETag())
+
+		patched, err := s.PatchProfile(p.ID, map[string]interface{}{"scopes": []interface{}{"openid", "email"}}, got.ETag())
+		require.NoError(t, err)
+		assert.Equal(t, "shh", *patched.ClientSecret)
+		_, err = s.PatchProfile(p.ID, map[string]interface{}{"description": "stale"}, got.ETag())
+		assert.ErrorIs(t, err, provider.ErrPreconditionFailed)
+
+		require.NoError(t, s.DeleteProfile(p.ID))
+		_, err = s.GetProfile(p.ID)
+		assert.Error(t, err)
+
+		// The name is free again once the provider is deleted, so restoring
+		// it fails while another provider holds it.
+		other, err := s.RegisterProfile(`{"name":"` + name + `","auth_type":"api_key"}`)
+		require.NoError(t, err)
+		assert.ErrorIs(t, s.RestoreProfile(p.ID), provider.ErrNameTaken)
+		require.NoError(t, s.DeleteProfile(other.ID))
+		require.NoError(t, s.RestoreProfile(p.ID))
+		assert.ErrorIs(t, s.RestoreProfile(p.ID), provider.ErrNotDeleted)
+
+		_, err = s.PurgeProfile(p.ID)
+		assert.ErrorIs(t, err, provider.ErrNotDeleted)
+		require.NoError(t, s.DeleteProfile(p.ID))
+		_, err = s.PurgeProfile(p.ID)
+		require.NoError(t, err)
+		_, err = s.PurgeProfile(p.ID)
+		assert.ErrorIs(t, err, provider.ErrNotFound)
+	})
+}
+
+func TestSQLStore_WorkspaceVisibility(t *testing.T) {
+	sqlStores(t, func(t *testing.T, s *provider.SQLStore, suffix string) {
+		global, err := s.RegisterProfile(`{"name":"global-` + suffix + `","auth_type":"api_key"}`)
+		require.NoError(t, err)
+		team, err := s.RegisterProfile(`{"name":"team-` + suffix + `","auth_type":"api_key","workspace_ids":["ws-` + suffix + `"]}`)
+		require.NoError(t, err)
+
+		ids := func(workspaceID string) []string {
+			list, err := s.ListProfiles(workspaceID)
+			require.NoError(t, err)
+			var out []string
+			for _, p := range list {
+				out = append(out, p.ID)
+			}
+			return out
+		}
+		assert.Contains(t, ids(""), global.ID.String())
+		assert.NotContains(t, ids(""), team.ID.String())
+		assert.Contains(t, ids("ws-"+suffix), team.ID.String())
+		assert.Contains(t, ids(provider.AllWorkspaces), team.ID.String())
+
+		meta, err := s.GetMetadata("ws-" + suffix)
+		require.NoError(t, err)
+		assert.Contains(t, meta["api_key"], "team-"+suffix)
+	})
+}
+
+func TestSQLStore_Registration(t *testing.T) {
+	sqlStores(t, func(t *testing.T, s *provider.SQLStore, suffix string) {
+		reg := &provider.Registration{ClientURI: "https://idp.example.com/register/c", AccessToken: "rat"}
+		p, err := s.RegisterProfileWithClient(`{"name":"dcr-`+suffix+`","client_id":"c","client_secret":"s","auth_url":"https://idp.example.com/auth","token_url":"https://idp.example.com/token"}`, reg)
+		require.NoError(t, err)
+		assert.Equal(t, p.ID, reg.ProviderID)
+
+		got, err := s.GetRegistration(p.ID)
+		require.NoError(t, err)
+		assert.Equal(t, "rat", got.AccessToken)
+
+		got.AccessToken = "rat-2"
+		require.NoError(t, s.RotateRegistration(got, "c2", "s2"))
+		assert.NotNil(t, got.RotatedAt)
+		rotated, err := s.GetProfile(p.ID)
+		require.NoError(t, err)
+		assert.Equal(t, "c2", *rotated.ClientID)
+		assert.Equal(t, "s2", *rotated.ClientSecret)
+	})
+}
@@ -1,26 +1,47 @@
 package provider
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"regexp"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
 	"github.com/lib/pq"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/authrequest"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/connstate"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/httpclient"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/scopepolicy"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/serviceaccount"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/vault"
 )
 
-// Store provides provider profile management
+// Store provides provider profile management on Postgres. SQLStore is its
+// counterpart on MySQL and SQLite.
 type Store struct {
 	db *sqlx.DB
+	sealer
+}
+
+// NewStore creates a new provider store. Client secrets are sealed with
+// secretKey (see vault.SealSecret) before they are written and opened when
+// read; a nil secretKey stores new secrets in plaintext.
+func NewStore(db *sqlx.DB, secretKey []byte) *Store {
+	return &Store{db: db, sealer: sealer{secretKey: secretKey}}
 }
 
-// NewStore creates a new provider store
-func NewStore(db *sqlx.DB) *Store {
-	return &Store{db: db}
+// sealer seals the secrets of the profiles a store writes and opens those
+// it reads.
+type sealer struct {
+	secretKey []byte
 }
 
 // Profile represents a provider profile
@@ -41,11 +62,54 @@ type Profile struct {
 	APIBaseURL       string           `json:"api_base_url,omitempty" db:"api_base_url"`
 	UserInfoEndpoint string           `json:"user_info_endpoint,omitempty" db:"user_info_endpoint"`
 	Params           *json.RawMessage `json:"params,omitempty" db:"params"`
-	DeletedAt        *time.Time       `json:"-" db:"deleted_at"`
+	// WorkspaceIDs restricts visibility to the listed workspaces; empty means global.
+	WorkspaceIDs []string `json:"workspace_ids,omitempty" db:"workspace_ids"`
+	// CABundle holds PEM certificates trusted, in addition to the system
+	// roots, when calling this provider's endpoints.
+	CABundle  string     `json:"ca_bundle,omitempty" db:"ca_bundle"`
+	DeletedAt *time.Time `json:"-" db:"deleted_at"`
+	// UpdatedAt is the time of the last change; ETag is derived from it.
+	UpdatedAt time.Time `json:"updated_at,omitzero" db:"updated_at"`
 }
 
-// RegisterProfile registers a new provider profile from JSON
-func (s *Store) RegisterProfile(profileJSON string) (*Profile, error) {
+// PublicProfile is a Profile as the API returns it. The client secret is
+// write-only, so it is left out and ClientSecretSet reports whether one is
+// stored.
+type PublicProfile struct {
+	*Profile
+	ClientSecret    *string `json:"client_secret,omitempty"`
+	ClientSecretSet bool    `json:"client_secret_set"`
+}
+
+// Public returns p without its client secret.
+func (p *Profile) Public() PublicProfile {
+	return PublicProfile{Profile: p, ClientSecretSet: p.ClientSecret != nil && *p.ClientSecret != ""}
+}
+
+// ETag returns the entity tag of the profile's current version. It changes
+// whenever the profile is updated.
+func (p *Profile) ETag() string {
+	return `"` + strconv.FormatInt(p.UpdatedAt.UnixMicro(), 36) + `"`
+}
+
+// MatchesETag reports whether the If-Match header value ifMatch is "*" or
+// lists the profile's ETag. Weak tags never match, as RFC 9110 requires
+// strong comparison for If-Match.
+func (p *Profile) MatchesETag(ifMatch string) bool {
+	etag := p.ETag()
+	for _, tag := range strings.Split(ifMatch, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || tag == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseProfile decodes a provider profile from JSON and checks the fields
+// required for its auth type. It does not touch the database; use it to
+// validate a profile before registering it.
+func ParseProfile(profileJSON string) (*Profile, error) {
 	var p Profile
 	if err := json.Unmarshal([]byte(profileJSON), &p); err != nil {
 		return nil, fmt.Errorf("profile: invalid JSON: %w", err)
@@ -91,6 +155,12 @@ func (s *Store) RegisterProfile(profileJSON string) (*Profile, error) {
 			}
 		}
 
+	case "service_account":
+		// Tokens are minted with the key; token_url defaults to the key's.
+		if _, err := serviceaccount.FromParams(p.Params); err != nil {
+			return nil, fmt.Errorf("params.%s: %w", serviceaccount.KeyParam, err)
+		}
+
 	case "api_key", "basic_auth", "header", "query_param", "hmac_payload", "aws_sigv4":
 		// Only name is required for static auth types
 
@@ -98,10 +168,39 @@ func (s *Store) RegisterProfile(profileJSON string) (*Profile, error) {
 		return nil, fmt.Errorf("auth_type: unsupported value '%s'", p.AuthType)
 	}
 
+	if err := httpclient.ValidateCABundle(p.CABundle); err != nil {
+		return nil, fmt.Errorf("ca_bundle: %w", err)
+	}
+	if _, err := authrequest.ParseOptions(p.Params).Signer(); err != nil {
+		return nil, fmt.Errorf("params.%s: %w", authrequest.SigningKeyParam, err)
+	}
+	if _, err := scopepolicy.Parse(p.Params); err != nil {
+		return nil, fmt.Errorf("params.%s: %w", scopepolicy.Param, err)
+	}
+
+	return &p, nil
+}
+
+// RegisterProfile registers a new provider profile from JSON
+func (s *Store) RegisterProfile(profileJSON string) (*Profile, error) {
+	return s.registerProfile(s.db, profileJSON)
+}
+
+// rowQuerier is a *sqlx.DB or *sqlx.Tx.
+type rowQuerier interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+func (s *Store) registerProfile(q rowQuerier, profileJSON string) (*Profile, error) {
+	p, err := ParseProfile(profileJSON)
+	if err != nil {
+		return nil, err
+	}
+
 	// Check for duplicate provider
 	var existingID uuid.UUID
 	checkQuery := `SELECT id FROM provider_profiles WHERE name = $1 AND deleted_at IS NULL LIMIT 1`
-	err := s.db.QueryRow(checkQuery, p.Name).Scan(&existingID)
+	err = q.QueryRow(checkQuery, p.Name).Scan(&existingID)
 	if err == nil {
 		return nil, fmt.Errorf("name: provider with name '%s' already exists", p.Name)
 	}
@@ -129,39 +228,119 @@ func (s *Store) RegisterProfile(profileJSON string) (*Profile, error) {
 		scopes = pq.Array([]string{})
 	}
 
+	workspaceIDs := pq.Array(p.WorkspaceIDs)
+	if p.WorkspaceIDs == nil {
+		workspaceIDs = pq.Array([]string{})
+	}
+
+	clientSecret, err := s.sealSecret(p.ClientSecret)
+	if err != nil {
+		return nil, err
+	}
+	params, err := s.sealParams(p.Params)
+	if err != nil {
+		return nil, err
+	}
+
 	// Insert into DB
 	query := `
 		INSERT INTO provider_profiles
-		(name, client_id, client_secret, auth_url, token_url, issuer, enable_discovery, scopes, auth_type, auth_header, api_base_url, user_info_endpoint, params, description, category)
-		VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15)
+		(name, client_id, client_secret, auth_url, token_url, issuer, enable_discovery, scopes, auth_type, auth_header, api_base_url, user_info_endpoint, params, description, category, workspace_ids, ca_bundle)
+		VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15,$16,$17)
 		RETURNING id`
 
 	var id uuid.UUID
-	err = s.db.QueryRow(query,
-		p.Name, p.ClientID, p.ClientSecret, authURL, tokenURL, issuer,
+	err = q.QueryRow(query,
+		p.Name, p.ClientID, clientSecret, authURL, tokenURL, issuer,
 		p.EnableDiscovery, scopes, p.AuthType, p.AuthHeader,
-		p.APIBaseURL, p.UserInfoEndpoint, p.Params, p.Description, p.Category,
+		p.APIBaseURL, p.UserInfoEndpoint, params, p.Description, p.Category, workspaceIDs, p.CABundle,
 	).Scan(&id)
 	if err != nil {
 		return nil, fmt.Errorf("database: failed to create provider profile: %w", err)
 	}
 
 	p.ID = id
+	return p, nil
+}
+
+// profileColumns selects a provider profile in the order scanProfile reads it.
+const profileColumns = `id, name, client_id, client_secret, auth_url, token_url, issuer, enable_discovery, scopes, auth_type, COALESCE(auth_header, ''), COALESCE(api_base_url, ''), COALESCE(user_info_endpoint, ''), params, COALESCE(description, ''), COALESCE(category, ''), workspace_ids, COALESCE(ca_bundle, ''), COALESCE(updated_at, to_timestamp(0))`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanProfile reads a row selected with profileColumns.
+func scanProfile(row rowScanner) (*Profile, error) {
+	var p Profile
+	err := row.Scan(&p.ID, &p.Name, &p.ClientID, &p.ClientSecret, &p.AuthURL, &p.TokenURL, &p.Issuer, &p.EnableDiscovery, pq.Array(&p.Scopes), &p.AuthType, &p.AuthHeader, &p.APIBaseURL, &p.UserInfoEndpoint, &p.Params, &p.Description, &p.Category, pq.Array(&p.WorkspaceIDs), &p.CABundle, &p.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
 	return &p, nil
 }
 
+// secretColumn is the column client secrets are stored in, bound into
+// their ciphertext.
+const secretColumn = "client_secret"
+
+// sealSecret returns the column value storing secret: NULL when it is
+// unset or empty, otherwise the secret sealed with the store's key.
+func (s sealer) sealSecret(secret *string) (interface{}, error) {
+	if secret == nil || *secret == "" {
+		return nil, nil
+	}
+	if s.secretKey == nil {
+		return *secret, nil
+	}
+	sealed, err := vault.SealSecret(s.secretKey, *secret, secretColumn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt client secret: %w", err)
+	}
+	return sealed, nil
+}
+
+// sealParams returns params with its secret credential_schema defaults
+// sealed with the store's key (see SealParams).
+func (s sealer) sealParams(params *json.RawMessage) (*json.RawMessage, error) {
+	if s.secretKey == nil {
+		return params, nil
+	}
+	return SealParams(s.secretKey, params)
+}
+
+// openSecrets decrypts the client secret and the secret credential_schema
+// defaults of a profile read from the database. Secrets written before
+// encryption are plaintext and left as is.
+func (s sealer) openSecrets(p *Profile) error {
+	if p.ClientSecret != nil && vault.IsSealedSecret(*p.ClientSecret) {
+		secret, err := vault.OpenSecret(s.secretKey, *p.ClientSecret, secretColumn)
+		if err != nil {
+			return fmt.Errorf("failed to decrypt client secret of provider %s: %w", p.ID, err)
+		}
+		p.ClientSecret = &secret
+	}
+	params, err := OpenParams(s.secretKey, p.Params)
+	if err != nil {
+		return fmt.Errorf("failed to open params of provider %s: %w", p.ID, err)
+	}
+	p.Params = params
+	return nil
+}
+
 // GetProfile retrieves a provider profile by ID
 func (s *Store) GetProfile(id uuid.UUID) (*Profile, error) {
-	var p Profile
-	query := `SELECT id, name, client_id, client_secret, auth_url, token_url, issuer, enable_discovery, scopes, auth_type, COALESCE(auth_header, ''), COALESCE(api_base_url, ''), COALESCE(user_info_endpoint, ''), params, COALESCE(description, ''), COALESCE(category, '') FROM provider_profiles WHERE id = $1 AND deleted_at IS NULL`
+	query := `SELECT ` + profileColumns + ` FROM provider_profiles WHERE id = $1 AND deleted_at IS NULL`
 
-	row := s.db.QueryRow(query, id)
-	err := row.Scan(&p.ID, &p.Name, &p.ClientID, &p.ClientSecret, &p.AuthURL, &p.TokenURL, &p.Issuer, &p.EnableDiscovery, pq.Array(&p.Scopes), &p.AuthType, &p.AuthHeader, &p.APIBaseURL, &p.UserInfoEndpoint, &p.Params, &p.Description, &p.Category)
+	p, err := scanProfile(s.db.QueryRow(query, id))
 	if err != nil {
 		return nil, fmt.Errorf("failed to get provider profile: %w", err)
 	}
+	if err := s.openSecrets(p); err != nil {
+		return nil, err
+	}
 
-	return &p, nil
+	return p, nil
 }
 
 // GetProfileByName retrieves a provider profile by name
@@ -170,14 +349,7 @@ func (s *Store) GetProfileByName(name string) (*Profile, error) {
 	nameLower := strings.ToLower(name)
 
 	// Use LOWER(name) in SQL for case-insensitive match
-	query := `
-		SELECT id, name, client_id, client_secret, auth_url, token_url, issuer,
-		       enable_discovery, scopes, auth_type, COALESCE(auth_header, ''),
-		       COALESCE(api_base_url, ''), COALESCE(user_info_endpoint, ''), params,
-		       COALESCE(description, ''), COALESCE(category, '')
-		FROM provider_profiles
-		WHERE LOWER(name) = $1 AND deleted_at IS NULL
-	`
+	query := `SELECT ` + profileColumns + ` FROM provider_profiles WHERE LOWER(name) = $1 AND deleted_at IS NULL`
 
 	rows, err := s.db.Query(query, nameLower)
 	if err != nil {
@@ -187,16 +359,14 @@ func (s *Store) GetProfileByName(name string) (*Profile, error) {
 
 	var profiles []Profile
 	for rows.Next() {
-		var p Profile
-		err := rows.Scan(
-			&p.ID, &p.Name, &p.ClientID, &p.ClientSecret, &p.AuthURL, &p.TokenURL,
-			&p.Issuer, &p.EnableDiscovery, pq.Array(&p.Scopes), &p.AuthType,
-			&p.AuthHeader, &p.APIBaseURL, &p.UserInfoEndpoint, &p.Params, &p.Description, &p.Category,
-		)
+		p, err := scanProfile(rows)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan provider profile: %w", err)
 		}
-		profiles = append(profiles, p)
+		if err := s.openSecrets(p); err != nil {
+			return nil, err
+		}
+		profiles = append(profiles, *p)
 	}
 
 	if err = rows.Err(); err != nil {
@@ -215,8 +385,131 @@ func (s *Store) GetProfileByName(name string) (*Profile, error) {
 	return &profiles[0], nil
 }
 
-// UpdateProfile updates an existing provider profile
-func (s *Store) UpdateProfile(p *Profile) error {
+// UpdateProfile replaces an existing provider profile and sets p.UpdatedAt
+// to the time of the change. The client secret is write-only, so a nil
+// p.ClientSecret keeps the stored one; set it to "" to clear it. A
+// non-empty ifMatch makes the update conditional: it fails with
+// ErrPreconditionFailed unless the stored profile matches it (see
+// MatchesETag).
+func (s *Store) UpdateProfile(p *Profile, ifMatch string) error {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return fmt.Errorf("failed to begin update: %w", err)
+	}
+	defer tx.Rollback()
+
+	current, err := s.lockProfile(tx, p.ID, ifMatch)
+	if err != nil {
+		return err
+	}
+	if p.ClientSecret == nil {
+		p.ClientSecret = current.ClientSecret
+	}
+	if err := s.writeProfile(tx, p); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit update: %w", err)
+	}
+	return nil
+}
+
+// PatchProfile applies a JSON merge patch (RFC 7396) to a provider profile
+// and returns the result: members of patch replace those of the profile,
+// null members clear them, and nested objects such as params are merged
+// the same way. The stored client secret is kept unless the patch sets or
+// clears it. The patched profile must pass ParseProfile, otherwise the
+// error wraps ErrInvalidProfile. ifMatch works as for UpdateProfile.
+func (s *Store) PatchProfile(id uuid.UUID, patch map[string]interface{}, ifMatch string) (*Profile, error) {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin patch: %w", err)
+	}
+	defer tx.Rollback()
+
+	current, err := s.lockProfile(tx, id, ifMatch)
+	if err != nil {
+		return nil, err
+	}
+	p, err := applyPatch(current, patch)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.writeProfile(tx, p); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit patch: %w", err)
+	}
+	return p, nil
+}
+
+// applyPatch returns current with the JSON merge patch applied, checked
+// with ParseProfile.
+func applyPatch(current *Profile, patch map[string]interface{}) (*Profile, error) {
+	doc, err := json.Marshal(current)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode provider profile: %w", err)
+	}
+	var target map[string]interface{}
+	if err := json.Unmarshal(doc, &target); err != nil {
+		return nil, fmt.Errorf("failed to encode provider profile: %w", err)
+	}
+	merged, err := json.Marshal(mergePatch(target, patch))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidProfile, err)
+	}
+	p, err := ParseProfile(string(merged))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidProfile, err)
+	}
+	p.ID = current.ID
+	return p, nil
+}
+
+// mergePatch applies the JSON merge patch patch to target (RFC 7396).
+func mergePatch(target, patch interface{}) interface{} {
+	members, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	doc, ok := target.(map[string]interface{})
+	if !ok {
+		doc = map[string]interface{}{}
+	}
+	for k, v := range members {
+		if v == nil {
+			delete(doc, k)
+			continue
+		}
+		doc[k] = mergePatch(doc[k], v)
+	}
+	return doc
+}
+
+// lockProfile loads an active provider profile for update and checks it
+// against ifMatch, if set.
+func (s *Store) lockProfile(tx *sqlx.Tx, id uuid.UUID, ifMatch string) (*Profile, error) {
+	p, err := scanProfile(tx.QueryRow(`SELECT `+profileColumns+` FROM provider_profiles WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`, id))
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load provider profile: %w", err)
+	}
+	if ifMatch != "" && !p.MatchesETag(ifMatch) {
+		return nil, ErrPreconditionFailed
+	}
+	if err := s.openSecrets(p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// writeProfile overwrites every column of the profile with p and sets
+// p.UpdatedAt. An empty client secret is stored as NULL.
+func (s *Store) writeProfile(tx *sqlx.Tx, p *Profile) error {
 	query := `
 		UPDATE provider_profiles
 		SET
@@ -235,103 +528,36 @@ func (s *Store) UpdateProfile(p *Profile) error {
 			params = $13,
 			description = $14,
 			category = $15,
+			workspace_ids = $16,
+			ca_bundle = $17,
 			updated_at = NOW()
-		WHERE id = $16 AND deleted_at IS NULL`
+		WHERE id = $18 AND deleted_at IS NULL
+		RETURNING updated_at`
 
-	_, err := s.db.Exec(query, p.Name, p.ClientID, p.ClientSecret, p.AuthURL, p.TokenURL, p.Issuer, p.EnableDiscovery, pq.Array(p.Scopes), p.AuthType, p.AuthHeader, p.APIBaseURL, p.UserInfoEndpoint, p.Params, p.Description, p.Category, p.ID)
+	scopes := p.Scopes
+	if scopes == nil {
+		scopes = []string{}
+	}
+	workspaceIDs := p.WorkspaceIDs
+	if workspaceIDs == nil {
+		workspaceIDs = []string{}
+	}
+	clientSecret, err := s.sealSecret(p.ClientSecret)
 	if err != nil {
-		return fmt.Errorf("failed to update provider profile: %w", err)
+		return err
 	}
-
-	return nil
-}
-
-// PatchProfile updates specific fields of a provider profile
-func (s *Store) PatchProfile(id uuid.UUID, updates map[string]interface{}) error {
-	if len(updates) == 0 {
-		return nil
-	}
-
-	query := "UPDATE provider_profiles SET "
-	args := []interface{}{}
-	i := 1
-
-	for key, value := range updates {
-		// Whitelist all allowed columns and map them to snake_case if coming from JSON
-		var column string
-		switch key {
-		case "name":
-			column = "name"
-		case "client_id":
-			column = "client_id"
-		case "client_secret":
-			column = "client_secret"
-		case "auth_url":
-			column = "auth_url"
-		case "token_url":
-			column = "token_url"
-		case "issuer":
-			column = "issuer"
-		case "enable_discovery":
-			column = "enable_discovery"
-		case "scopes":
-			column = "scopes"
-			// Handle array conversion for pq
-			if slice, ok := value.([]interface{}); ok {
-				strSlice := make([]string, len(slice))
-				for j, v := range slice {
-					strSlice[j] = fmt.Sprint(v)
-				}
-				value = pq.Array(strSlice)
-			} else if slice, ok := value.([]string); ok {
-				value = pq.Array(slice)
-			}
-		case "auth_type":
-			column = "auth_type"
-		case "auth_header":
-			column = "auth_header"
-		case "api_base_url":
-			column = "api_base_url"
-		case "user_info_endpoint":
-			column = "user_info_endpoint"
-		case "params":
-			column = "params"
-			// Handle JSON RawMessage or map conversion if needed
-			if m, ok := value.(map[string]interface{}); ok {
-				b, _ := json.Marshal(m)
-				value = b
-			}
-		case "description":
-			column = "description"
-		case "category":
-			column = "category"
-		default:
-			// Ignore unknown fields
-			continue
-		}
-
-		if i > 1 {
-			query += ", "
-		}
-		query += fmt.Sprintf("%s = $%d", column, i)
-		args = append(args, value)
-		i++
+	params, err := s.sealParams(p.Params)
+	if err != nil {
+		return err
 	}
 
-	// Always update updated_at
-	if i > 1 {
-		query += ", "
+	err = tx.QueryRow(query, p.Name, p.ClientID, clientSecret, p.AuthURL, p.TokenURL, p.Issuer, p.EnableDiscovery, pq.Array(scopes), p.AuthType, p.AuthHeader, p.APIBaseURL, p.UserInfoEndpoint, params, p.Description, p.Category, pq.Array(workspaceIDs), p.CABundle, p.ID).Scan(&p.UpdatedAt)
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+		return ErrNameTaken
 	}
-	query += "updated_at = NOW()"
-
-	query += fmt.Sprintf(" WHERE id = $%d AND deleted_at IS NULL", i)
-	args = append(args, id)
-
-	_, err := s.db.Exec(query, args...)
 	if err != nil {
-		return fmt.Errorf("failed to patch provider profile: %w", err)
+		return fmt.Errorf("failed to update provider profile: %w", err)
 	}
-
 	return nil
 }
 
@@ -359,18 +585,167 @@ func (s *Store) DeleteProfileByName(name string) (int64, error) {
 	return rowsAffected, nil
 }
 
-// ListProfiles retrieves all non-deleted provider names and IDs
-func (s *Store) ListProfiles() ([]ProfileList, error) {
+var (
+	// ErrNotFound is returned when no provider exists with the given ID.
+	ErrNotFound = errors.New("provider not found")
+	// ErrNotDeleted is returned when restoring or purging a provider that
+	// has not been soft-deleted.
+	ErrNotDeleted = errors.New("provider is not deleted")
+	// ErrNameTaken is returned when restoring or renaming a provider to a
+	// name used by another active provider.
+	ErrNameTaken = errors.New("provider name is in use")
+	// ErrPreconditionFailed is returned by UpdateProfile and PatchProfile
+	// when the provider no longer matches the caller's If-Match value.
+	ErrPreconditionFailed = errors.New("provider was modified")
+	// ErrInvalidProfile is returned by PatchProfile when the patched
+	// profile fails validation.
+	ErrInvalidProfile = errors.New("invalid provider profile")
+)
+
+// ConnectionsInUseError is returned by PurgeProfile when the provider still
+// has connections that are pending, active or awaiting re-authentication.
+type ConnectionsInUseError struct {
+	Count int
+}
+
+func (e *ConnectionsInUseError) Error() string {
+	return fmt.Sprintf("provider has %d live connection(s)", e.Count)
+}
+
+// RestoreProfile undoes a soft delete.
+func (s *Store) RestoreProfile(id uuid.UUID) error {
+	var name string
+	var deletedAt *time.Time
+	err := s.db.QueryRow(`SELECT name, deleted_at FROM provider_profiles WHERE id = $1`, id).Scan(&name, &deletedAt)
+	if err == sql.ErrNoRows {
+		return ErrNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load provider profile: %w", err)
+	}
+	if deletedAt == nil {
+		return ErrNotDeleted
+	}
+
+	// Names are only unique among active providers, so a new provider may
+	// have taken this one's name since it was deleted.
+	_, err = s.db.Exec(`UPDATE provider_profiles SET deleted_at = NULL, updated_at = NOW() WHERE id = $1`, id)
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+		return ErrNameTaken
+	}
+	if err != nil {
+		return fmt.Errorf("failed to restore provider profile: %w", err)
+	}
+	return nil
+}
+
+// PurgeProfile permanently deletes a soft-deleted provider together with its
+// finished connections and their tokens, returning how many connections were
+// removed. Audit events for those connections are kept but detached. It
+// refuses while any connection is live (see connstate.Live), including
+// suspended ones and those awaiting approval.
+func (s *Store) PurgeProfile(id uuid.UUID) (int64, error) {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin purge: %w", err)
+	}
+	defer tx.Rollback()
+
+	var deletedAt *time.Time
+	err = tx.QueryRow(`SELECT deleted_at FROM provider_profiles WHERE id = $1 FOR UPDATE`, id).Scan(&deletedAt)
+	if err == sql.ErrNoRows {
+		return 0, ErrNotFound
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to load provider profile: %w", err)
+	}
+	if deletedAt == nil {
+		return 0, ErrNotDeleted
+	}
+
+	var live int
+	if err := tx.QueryRow(`
+		SELECT COUNT(*) FROM connections
+		WHERE provider_id = $1 AND status = ANY($2)`, id, pq.Array(liveStatuses())).Scan(&live); err != nil {
+		return 0, fmt.Errorf("failed to count connections: %w", err)
+	}
+	if live > 0 {
+		return 0, &ConnectionsInUseError{Count: live}
+	}
+
+	const providerConnections = `SELECT id FROM connections WHERE provider_id = $1`
+	if _, err := tx.Exec(`UPDATE audit_events SET connection_id = NULL WHERE connection_id IN (`+providerConnections+`)`, id); err != nil {
+		return 0, fmt.Errorf("failed to detach audit events: %w", err)
+	}
+	if _, err := tx.Exec(`DELETE FROM tokens WHERE connection_id IN (`+providerConnections+`)`, id); err != nil {
+		return 0, fmt.Errorf("failed to delete tokens: %w", err)
+	}
+	res, err := tx.Exec(`DELETE FROM connections WHERE provider_id = $1`, id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete connections: %w", err)
+	}
+	connections, _ := res.RowsAffected()
+	if _, err := tx.Exec(`DELETE FROM provider_profiles WHERE id = $1`, id); err != nil {
+		return 0, fmt.Errorf("failed to purge provider profile: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit purge: %w", err)
+	}
+	return connections, nil
+}
+
+// liveStatuses returns connstate.Live as the strings stored in
+// connections.status.
+func liveStatuses() []string {
+	live := connstate.Live()
+	out := make([]string, len(live))
+	for i, st := range live {
+		out[i] = string(st)
+	}
+	return out
+}
+
+// AllWorkspaces, passed as the workspace to ListProfiles,
+// ListProfilesWithDeleted or GetMetadata, includes the providers restricted
+// to any workspace. It is the operator view; callers acting for a workspace
+// pass its ID, and an empty one sees only global providers.
+const AllWorkspaces = "*"
+
+// VisibleTo reports whether p is listed for workspaceID, as
+// visibleToWorkspace matches it in SQL.
+func (p *Profile) VisibleTo(workspaceID string) bool {
+	return workspaceID == AllWorkspaces || len(p.WorkspaceIDs) == 0 || slices.Contains(p.WorkspaceIDs, workspaceID)
+}
+
+// visibleToWorkspace matches global providers and those restricted to the
+// workspace bound as $1, or every provider when $1 is AllWorkspaces.
+const visibleToWorkspace = `($1 = '` + AllWorkspaces + `' OR cardinality(workspace_ids) = 0 OR $1 = ANY(workspace_ids))`
+
+// ListProfiles retrieves non-deleted provider names and IDs visible to
+// workspaceID.
+func (s *Store) ListProfiles(workspaceID string) ([]ProfileList, error) {
 	var rows []ProfileList
-	query := `SELECT id, name FROM provider_profiles WHERE deleted_at IS NULL ORDER BY created_at DESC`
-	if err := s.db.Select(&rows, query); err != nil {
+	query := `SELECT id, name FROM provider_profiles WHERE deleted_at IS NULL AND ` + visibleToWorkspace + ` ORDER BY created_at DESC`
+	if err := s.db.Select(&rows, query, workspaceID); err != nil {
 		return nil, fmt.Errorf("failed to list profiles: %w", err)
 	}
 	return rows, nil
 }
 
-// GetMetadata retrieves integration metadata for all providers, grouped by auth_type
-func (s *Store) GetMetadata() (map[string]map[string]interface{}, error) {
+// ListProfilesWithDeleted is ListProfiles including soft-deleted providers,
+// which carry their DeletedAt.
+func (s *Store) ListProfilesWithDeleted(workspaceID string) ([]ProfileList, error) {
+	var rows []ProfileList
+	query := `SELECT id, name, deleted_at FROM provider_profiles WHERE ` + visibleToWorkspace + ` ORDER BY created_at DESC`
+	if err := s.db.Select(&rows, query, workspaceID); err != nil {
+		return nil, fmt.Errorf("failed to list profiles: %w", err)
+	}
+	return rows, nil
+}
+
+// GetMetadata retrieves integration metadata for providers visible to
+// workspaceID, grouped by auth_type.
+func (s *Store) GetMetadata(workspaceID string) (map[string]map[string]Metadata, error) {
 	query := `
 		SELECT
 			id,
@@ -382,16 +757,16 @@ func (s *Store) GetMetadata() (map[string]map[string]interface{}, error) {
 			COALESCE(description, '') as description,
 			COALESCE(category, '') as category
 		FROM provider_profiles
-		WHERE deleted_at IS NULL
+		WHERE deleted_at IS NULL AND ` + visibleToWorkspace + `
 		ORDER BY name`
 
-	rows, err := s.db.Query(query)
+	rows, err := s.db.Query(query, workspaceID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query metadata: %w", err)
 	}
 	defer rows.Close()
 
-	result := make(map[string]map[string]interface{})
+	result := make(map[string]map[string]Metadata)
 
 	for rows.Next() {
 		var id uuid.UUID
@@ -404,23 +779,71 @@ func (s *Store) GetMetadata() (map[string]map[string]interface{}, error) {
 			return nil, fmt.Errorf("failed to scan metadata: %w", err)
 		}
 
-		if authType == "" {
-			authType = "oauth2" // Default fallback
-		}
+		addMetadata(result, &Profile{
+			ID:               id,
+			Name:             name,
+			AuthType:         authType,
+			APIBaseURL:       apiBaseURL,
+			UserInfoEndpoint: userInfoEndpoint,
+			Scopes:           scopes,
+			Description:      description,
+			Category:         category,
+		})
+	}
 
-		if _, ok := result[authType]; !ok {
-			result[authType] = make(map[string]interface{})
-		}
+	return result, nil
+}
 
-		result[authType][name] = map[string]interface{}{
-			"id":                 id.String(),
-			"api_base_url":       apiBaseURL,
-			"user_info_endpoint": userInfoEndpoint,
-			"scopes":             scopes,
-			"description":        description,
-			"category":           category,
-		}
+// addMetadata adds the metadata of p to result, under its auth type.
+func addMetadata(result map[string]map[string]Metadata, p *Profile) {
+	authType := p.AuthType
+	if authType == "" {
+		authType = "oauth2" // Default fallback
 	}
 
-	return result, nil
+	if _, ok := result[authType]; !ok {
+		result[authType] = make(map[string]Metadata)
+	}
+
+	result[authType][p.Name] = Metadata{
+		ID:               p.ID.String(),
+		APIBaseURL:       p.APIBaseURL,
+		UserInfoEndpoint: p.UserInfoEndpoint,
+		Scopes:           p.Scopes,
+		Description:      p.Description,
+		Category:         p.Category,
+	}
+}
+
+// EgressHosts returns the distinct hosts of every non-deleted provider's
+// auth, token, issuer and API URLs: the hosts the broker needs to reach
+// when egress is restricted to registered providers.
+func (s *Store) EgressHosts(ctx context.Context) ([]string, error) {
+	return egressHosts(ctx, s.db)
+}
+
+// egressHosts implements EgressHosts; the query is the same in every
+// dialect.
+func egressHosts(ctx context.Context, db *sqlx.DB) ([]string, error) {
+	var rows []struct {
+		AuthURL    sql.NullString `db:"auth_url"`
+		TokenURL   sql.NullString `db:"token_url"`
+		Issuer     sql.NullString `db:"issuer"`
+		APIBaseURL sql.NullString `db:"api_base_url"`
+	}
+	query := `SELECT auth_url, token_url, issuer, api_base_url FROM provider_profiles WHERE deleted_at IS NULL`
+	if err := db.SelectContext(ctx, &rows, query); err != nil {
+		return nil, fmt.Errorf("failed to list provider hosts: %w", err)
+	}
+	seen := map[string]bool{}
+	var hosts []string
+	for _, r := range rows {
+		for _, u := range []sql.NullString{r.AuthURL, r.TokenURL, r.Issuer, r.APIBaseURL} {
+			if h := httpclient.HostOf(u.String); h != "" && !seen[h] {
+				seen[h] = true
+				hosts = append(hosts, h)
+			}
+		}
+	}
+	return hosts, nil
 }
@@ -1,15 +1,21 @@
 package provider
 
 import (
+	"context"
 	"database/sql"
+	"database/sql/driver"
 	"encoding/json"
+	"regexp"
 	"testing"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
 	"github.com/lib/pq"
 	"github.com/stretchr/testify/assert"
 	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/vault"
 )
 
 func ptr(s string) *string {
@@ -22,7 +28,7 @@ func TestRegisterProfile_OAuth2(t *testing.T) {
 	defer db.Close()
 
 	sqlxDB := sqlx.NewDb(db, "sqlmock")
-	store := NewStore(sqlxDB)
+	store := NewStore(sqlxDB, nil)
 
 	// Duplicate check: no rows found
 	mock.ExpectQuery(`SELECT id FROM provider_profiles WHERE name = \$1`).
@@ -46,6 +52,10 @@ func TestRegisterProfile_OAuth2(t *testing.T) {
 			"",                          // api_base_url (empty string)
 			"",                          // user_info_endpoint (empty string)
 			sqlmock.AnyArg(),            // params
+			"",                          // description
+			"",                          // category
+			pq.Array([]string{}),        // workspace_ids (empty = global)
+			"",                          // ca_bundle (system roots)
 		).
 		WillReturnRows(rows)
 
@@ -81,7 +91,7 @@ func TestRegisterProfile_StaticKey(t *testing.T) {
 	defer db.Close()
 
 	sqlxDB := sqlx.NewDb(db, "sqlmock")
-	store := NewStore(sqlxDB)
+	store := NewStore(sqlxDB, nil)
 
 	mock.ExpectQuery(`SELECT id FROM provider_profiles WHERE name`).
 		WithArgs("test-api-key-provider").
@@ -103,6 +113,10 @@ func TestRegisterProfile_StaticKey(t *testing.T) {
 			"",                      // api_base_url
 			"",                      // user_info_endpoint
 			sqlmock.AnyArg(),        // params
+			"",                      // description
+			"",                      // category
+			pq.Array([]string{}),    // workspace_ids (empty = global)
+			"",                      // ca_bundle (system roots)
 		).
 		WillReturnRows(rows)
 
@@ -124,7 +138,7 @@ func TestRegisterProfile_InvalidOAuth2(t *testing.T) {
 	defer db.Close()
 
 	sqlxDB := sqlx.NewDb(db, "sqlmock")
-	store := NewStore(sqlxDB)
+	store := NewStore(sqlxDB, nil)
 
 	profile := Profile{
 		Name:     "test-invalid-provider",
@@ -145,7 +159,7 @@ func TestRegisterProfile_InvalidJSON(t *testing.T) {
 	defer db.Close()
 
 	sqlxDB := sqlx.NewDb(db, "sqlmock")
-	store := NewStore(sqlxDB)
+	store := NewStore(sqlxDB, nil)
 
 	_, err = store.RegisterProfile("invalid json")
 	assert.Error(t, err)
@@ -158,7 +172,7 @@ func TestRegisterProfile_NameCapitalLetters(t *testing.T) {
 	defer db.Close()
 
 	sqlxDB := sqlx.NewDb(db, "sqlmock")
-	store := NewStore(sqlxDB)
+	store := NewStore(sqlxDB, nil)
 
 	profile := Profile{
 		Name:         "TestWithCapital",
@@ -182,15 +196,17 @@ func TestGetProfile_NullValues(t *testing.T) {
 	defer db.Close()
 
 	sqlxDB := sqlx.NewDb(db, "sqlmock")
-	store := NewStore(sqlxDB)
+	store := NewStore(sqlxDB, nil)
 
 	providerID := uuid.New()
 	rows := sqlmock.NewRows([]string{
 		"id", "name", "client_id", "client_secret", "auth_url", "token_url", "issuer",
 		"enable_discovery", "scopes", "auth_type", "auth_header", "api_base_url", "user_info_endpoint", "params",
+		"description", "category", "workspace_ids", "ca_bundle", "updated_at",
 	}).AddRow(
 		providerID.String(), "null-provider", nil, nil, nil, nil, nil,
 		false, []byte("{}"), "api_key", "", "", "", nil,
+		"", "", []byte("{}"), "", time.Now(),
 	)
 
 	mock.ExpectQuery(`SELECT .* FROM provider_profiles WHERE id = \$1`).
@@ -204,3 +220,442 @@ func TestGetProfile_NullValues(t *testing.T) {
 		assert.Equal(t, "null-provider", profile.Name)
 	}
 }
+
+func TestParseProfile_RejectsInvalidCABundle(t *testing.T) {
+	_, err := ParseProfile(`{"name": "internal-idp", "auth_type": "api_key", "ca_bundle": "not a certificate"}`)
+	assert.ErrorContains(t, err, "ca_bundle")
+}
+
+func TestParseProfile_ServiceAccountRequiresKey(t *testing.T) {
+	_, err := ParseProfile(`{"name": "google-workspace", "auth_type": "service_account"}`)
+	assert.ErrorContains(t, err, "params.service_account_key")
+	_, err = ParseProfile(`{"name": "google-workspace", "auth_type": "service_account", "params": {"service_account_key": "{\"type\": \"service_account\", \"client_email\": \"a@b\"}"}}`)
+	assert.ErrorContains(t, err, "private_key")
+}
+
+// profileRow returns the row lockProfile reads for an api_key provider
+// last updated at updatedAt.
+func profileRow(id uuid.UUID, updatedAt time.Time) *sqlmock.Rows {
+	return sqlmock.NewRows([]string{
+		"id", "name", "client_id", "client_secret", "auth_url", "token_url", "issuer",
+		"enable_discovery", "scopes", "auth_type", "auth_header", "api_base_url", "user_info_endpoint", "params",
+		"description", "category", "workspace_ids", "ca_bundle", "updated_at",
+	}).AddRow(
+		id.String(), "acme", nil, nil, nil, nil, nil,
+		false, []byte("{read}"), "api_key", "X-API-Key", "https://api.acme.example", "", []byte(`{"region": "eu", "tier": "gold"}`),
+		"Acme API", "crm", []byte("{}"), "", updatedAt,
+	)
+}
+
+func TestPatchProfile_MergesPatch(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+	store := NewStore(sqlx.NewDb(db, "sqlmock"), nil)
+
+	id := uuid.New()
+	updatedAt := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT .* FROM provider_profiles WHERE id = \$1 AND deleted_at IS NULL FOR UPDATE`).
+		WithArgs(id).
+		WillReturnRows(profileRow(id, updatedAt))
+	mock.ExpectQuery(`UPDATE provider_profiles`).
+		WithArgs(
+			"acme", nil, nil, nil, nil, nil, false,
+			pq.Array([]string{"read"}), "api_key", "X-API-Key", "https://api.acme.example", "",
+			sqlmock.AnyArg(), "", "crm", pq.Array([]string{}), "", id,
+		).
+		WillReturnRows(sqlmock.NewRows([]string{"updated_at"}).AddRow(updatedAt.Add(time.Second)))
+	mock.ExpectCommit()
+
+	p, err := store.PatchProfile(id, map[string]interface{}{
+		"description": nil,
+		"params":      map[string]interface{}{"tier": nil, "sandbox": true},
+	}, `"other", `+(&Profile{UpdatedAt: updatedAt}).ETag())
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	assert.Equal(t, id, p.ID)
+	assert.Equal(t, "", p.Description)
+	assert.Equal(t, "X-API-Key", p.AuthHeader)
+	assert.JSONEq(t, `{"region": "eu", "sandbox": true}`, string(*p.Params))
+	assert.Equal(t, updatedAt.Add(time.Second), p.UpdatedAt)
+}
+
+func TestPatchProfile_PreconditionFailed(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+	store := NewStore(sqlx.NewDb(db, "sqlmock"), nil)
+
+	id := uuid.New()
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT .* FROM provider_profiles WHERE id = \$1 AND deleted_at IS NULL FOR UPDATE`).
+		WithArgs(id).
+		WillReturnRows(profileRow(id, time.Now()))
+	mock.ExpectRollback()
+
+	_, err = store.PatchProfile(id, map[string]interface{}{"category": "erp"}, `"stale"`)
+	assert.Equal(t, ErrPreconditionFailed, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPatchProfile_RejectsInvalidResult(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+	store := NewStore(sqlx.NewDb(db, "sqlmock"), nil)
+
+	id := uuid.New()
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT .* FROM provider_profiles`).
+		WithArgs(id).
+		WillReturnRows(profileRow(id, time.Now()))
+	mock.ExpectRollback()
+
+	_, err = store.PatchProfile(id, map[string]interface{}{"name": nil}, "")
+	assert.ErrorIs(t, err, ErrInvalidProfile)
+	assert.ErrorContains(t, err, "name: missing required field")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUpdateProfile_NotFound(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+	store := NewStore(sqlx.NewDb(db, "sqlmock"), nil)
+
+	id := uuid.New()
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT .* FROM provider_profiles`).
+		WithArgs(id).
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectRollback()
+
+	assert.Equal(t, ErrNotFound, store.UpdateProfile(&Profile{ID: id, Name: "acme"}, "*"))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRegisterProfile_SealsClientSecret(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+	key := []byte("01234567890123456789012345678901")
+	store := NewStore(sqlx.NewDb(db, "sqlmock"), key)
+
+	var stored string
+	sealed := sqlmock.Argument(argFunc(func(v driver.Value) bool {
+		stored, _ = v.(string)
+		return vault.IsSealedSecret(stored)
+	}))
+	mock.ExpectQuery(`SELECT id FROM provider_profiles WHERE name = \$1`).WillReturnError(sql.ErrNoRows)
+	mock.ExpectQuery(`INSERT INTO provider_profiles`).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), sealed, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(),
+			sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(),
+			sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(uuid.New().String()))
+
+	p, err := store.RegisterProfile(`{"name": "acme", "client_id": "c", "client_secret": "s3cret", "auth_url": "https://acme.example/auth", "token_url": "https://acme.example/token"}`)
+	assert.NoError(t, err)
+	assert.Equal(t, "s3cret", *p.ClientSecret)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	// The stored value opens again when the profile is read.
+	mock.ExpectQuery(`SELECT .* FROM provider_profiles WHERE id = \$1`).WithArgs(p.ID).
+		WillReturnRows(sqlmock.NewRows([]string{
+			"id", "name", "client_id", "client_secret", "auth_url", "token_url", "issuer",
+			"enable_discovery", "scopes", "auth_type", "auth_header", "api_base_url", "user_info_endpoint", "params",
+			"description", "category", "workspace_ids", "ca_bundle", "updated_at",
+		}).AddRow(
+			p.ID.String(), "acme", "c", stored, nil, nil, nil,
+			false, []byte("{}"), "oauth2", "", "", "", nil,
+			"", "", []byte("{}"), "", time.Now(),
+		))
+	got, err := store.GetProfile(p.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "s3cret", *got.ClientSecret)
+}
+
+func TestRegisterProfile_SealsSecretSchemaDefaults(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+	key := []byte("01234567890123456789012345678901")
+	store := NewStore(sqlx.NewDb(db, "sqlmock"), key)
+
+	var stored []byte
+	sealed := sqlmock.Argument(argFunc(func(v driver.Value) bool {
+		stored, _ = v.([]byte)
+		return len(stored) > 0 && !HasPlaintextSecrets(rawParams(string(stored)))
+	}))
+	mock.ExpectQuery(`SELECT id FROM provider_profiles WHERE name = \$1`).WillReturnError(sql.ErrNoRows)
+	mock.ExpectQuery(`INSERT INTO provider_profiles`).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(),
+			sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(),
+			sealed, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(uuid.New().String()))
+
+	p, err := store.RegisterProfile(`{"name": "acme", "auth_type": "basic_auth", "params": {"credential_schema": {"properties": {"password": {"type": "string", "format": "password", "default": "hunter2"}}}}}`)
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+	assert.NotContains(t, string(stored), "hunter2")
+
+	// The stored default opens again when the profile is read.
+	mock.ExpectQuery(`SELECT .* FROM provider_profiles WHERE id = \$1`).WithArgs(p.ID).
+		WillReturnRows(sqlmock.NewRows([]string{
+			"id", "name", "client_id", "client_secret", "auth_url", "token_url", "issuer",
+			"enable_discovery", "scopes", "auth_type", "auth_header", "api_base_url", "user_info_endpoint", "params",
+			"description", "category", "workspace_ids", "ca_bundle", "updated_at",
+		}).AddRow(
+			p.ID.String(), "acme", nil, nil, nil, nil, nil,
+			false, []byte("{}"), "basic_auth", "", "", "", stored,
+			"", "", []byte("{}"), "", time.Now(),
+		))
+	got, err := store.GetProfile(p.ID)
+	assert.NoError(t, err)
+	assert.Contains(t, string(*got.Params), "hunter2")
+}
+
+func TestRegisterProfileWithClient_SealsRegistrationToken(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+	key := []byte("01234567890123456789012345678901")
+	store := NewStore(sqlx.NewDb(db, "sqlmock"), key)
+
+	id := uuid.New()
+	registeredAt := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	var stored string
+	sealed := sqlmock.Argument(argFunc(func(v driver.Value) bool {
+		stored, _ = v.(string)
+		return vault.IsSealedSecret(stored)
+	}))
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT id FROM provider_profiles WHERE name = \$1`).WillReturnError(sql.ErrNoRows)
+	mock.ExpectQuery(`INSERT INTO provider_profiles`).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(id.String()))
+	mock.ExpectQuery(`INSERT INTO provider_registrations`).
+		WithArgs(id, "https://acme.example/register/c-1", sealed, nil).
+		WillReturnRows(sqlmock.NewRows([]string{"registered_at"}).AddRow(registeredAt))
+	mock.ExpectCommit()
+
+	reg := &Registration{ClientURI: "https://acme.example/register/c-1", AccessToken: "rat-1"}
+	p, err := store.RegisterProfileWithClient(`{"name": "acme", "client_id": "c-1", "client_secret": "s-1", "auth_url": "https://acme.example/auth", "token_url": "https://acme.example/token"}`, reg)
+	assert.NoError(t, err)
+	assert.Equal(t, id, p.ID)
+	assert.Equal(t, id, reg.ProviderID)
+	assert.Equal(t, registeredAt, reg.RegisteredAt)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	// The stored token opens again when the registration is read.
+	mock.ExpectQuery(`SELECT .* FROM provider_registrations`).WithArgs(id).
+		WillReturnRows(sqlmock.NewRows([]string{"provider_id", "registration_client_uri", "registration_access_token", "client_secret_expires_at", "registered_at", "rotated_at"}).
+			AddRow(id.String(), reg.ClientURI, stored, nil, registeredAt, nil))
+	got, err := store.GetRegistration(id)
+	assert.NoError(t, err)
+	assert.Equal(t, "rat-1", got.AccessToken)
+}
+
+func TestUpdateProfile_KeepsOmittedClientSecret(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+	store := NewStore(sqlx.NewDb(db, "sqlmock"), nil)
+
+	id := uuid.New()
+	current := sqlmock.NewRows([]string{
+		"id", "name", "client_id", "client_secret", "auth_url", "token_url", "issuer",
+		"enable_discovery", "scopes", "auth_type", "auth_header", "api_base_url", "user_info_endpoint", "params",
+		"description", "category", "workspace_ids", "ca_bundle", "updated_at",
+	}).AddRow(
+		id.String(), "acme", "c", "kept-secret", nil, nil, nil,
+		false, []byte("{}"), "oauth2", "", "", "", nil,
+		"", "", []byte("{}"), "", time.Now(),
+	)
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT .* FROM provider_profiles`).WithArgs(id).WillReturnRows(current)
+	mock.ExpectQuery(`UPDATE provider_profiles`).
+		WithArgs("acme", sqlmock.AnyArg(), "kept-secret", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(),
+			sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(),
+			sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), id).
+		WillReturnRows(sqlmock.NewRows([]string{"updated_at"}).AddRow(time.Now()))
+	mock.ExpectCommit()
+
+	assert.NoError(t, store.UpdateProfile(&Profile{ID: id, Name: "acme"}, ""))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestProfilePublic_HidesClientSecret(t *testing.T) {
+	p := &Profile{Name: "acme", ClientID: ptr("c"), ClientSecret: ptr("s3cret")}
+	b, err := json.Marshal(p.Public())
+	assert.NoError(t, err)
+	assert.NotContains(t, string(b), "s3cret")
+	assert.Contains(t, string(b), `"client_secret_set":true`)
+	assert.Contains(t, string(b), `"client_id":"c"`)
+
+	b, _ = json.Marshal((&Profile{Name: "acme"}).Public())
+	assert.Contains(t, string(b), `"client_secret_set":false`)
+}
+
+// argFunc matches a query argument with a predicate.
+type argFunc func(driver.Value) bool
+
+func (f argFunc) Match(v driver.Value) bool { return f(v) }
+
+func TestProfileMatchesETag(t *testing.T) {
+	p := &Profile{UpdatedAt: time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)}
+	assert.True(t, p.MatchesETag("*"))
+	assert.True(t, p.MatchesETag(p.ETag()))
+	assert.True(t, p.MatchesETag(`"x", `+p.ETag()))
+	assert.False(t, p.MatchesETag("W/"+p.ETag()))
+	assert.False(t, p.MatchesETag(`"x"`))
+
+	next := &Profile{UpdatedAt: p.UpdatedAt.Add(time.Microsecond)}
+	assert.NotEqual(t, p.ETag(), next.ETag())
+}
+
+func TestRestoreProfile(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+	store := NewStore(sqlx.NewDb(db, "sqlmock"), nil)
+
+	id := uuid.New()
+	mock.ExpectQuery(`SELECT name, deleted_at FROM provider_profiles WHERE id = \$1`).
+		WithArgs(id).
+		WillReturnRows(sqlmock.NewRows([]string{"name", "deleted_at"}).AddRow("google", time.Now()))
+	mock.ExpectExec(`UPDATE provider_profiles SET deleted_at = NULL`).
+		WithArgs(id).
+		WillReturnError(&pq.Error{Code: "23505"})
+
+	assert.Equal(t, ErrNameTaken, store.RestoreProfile(id))
+
+	mock.ExpectQuery(`SELECT name, deleted_at FROM provider_profiles`).
+		WithArgs(id).
+		WillReturnRows(sqlmock.NewRows([]string{"name", "deleted_at"}).AddRow("google", nil))
+
+	assert.Equal(t, ErrNotDeleted, store.RestoreProfile(id))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// TestListProfiles_NoWorkspaceHidesRestricted verifies a caller that names
+// no workspace only matches global providers, and the operator view every
+// provider.
+func TestListProfiles_NoWorkspaceHidesRestricted(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+	store := NewStore(sqlx.NewDb(db, "sqlmock"), nil)
+
+	filter := regexp.QuoteMeta(`WHERE deleted_at IS NULL AND ($1 = '*' OR cardinality(workspace_ids) = 0 OR $1 = ANY(workspace_ids))`)
+	for _, ws := range []string{"", AllWorkspaces} {
+		mock.ExpectQuery(`SELECT id, name FROM provider_profiles ` + filter).
+			WithArgs(ws).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "name"}))
+		_, err = store.ListProfiles(ws)
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPurgeProfile_RefusesLiveConnections(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+	store := NewStore(sqlx.NewDb(db, "sqlmock"), nil)
+
+	id := uuid.New()
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT deleted_at FROM provider_profiles WHERE id = \$1 FOR UPDATE`).
+		WithArgs(id).
+		WillReturnRows(sqlmock.NewRows([]string{"deleted_at"}).AddRow(time.Now()))
+	mock.ExpectQuery(`SELECT COUNT\(\*\) FROM connections`).
+		WithArgs(id, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+	mock.ExpectRollback()
+
+	_, err = store.PurgeProfile(id)
+	var inUse *ConnectionsInUseError
+	assert.ErrorAs(t, err, &inUse)
+	assert.Equal(t, 2, inUse.Count)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// TestPurgeProfile_CountsSuspendedAsLive verifies suspended connections and
+// those awaiting approval, which may still become active, hold off a purge.
+func TestPurgeProfile_CountsSuspendedAsLive(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+	store := NewStore(sqlx.NewDb(db, "sqlmock"), nil)
+
+	id := uuid.New()
+	live := sqlmock.Argument(argFunc(func(v driver.Value) bool {
+		return v == `{"active","awaiting_approval","needs_reauth","pending","suspended"}`
+	}))
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT deleted_at FROM provider_profiles`).
+		WithArgs(id).
+		WillReturnRows(sqlmock.NewRows([]string{"deleted_at"}).AddRow(time.Now()))
+	mock.ExpectQuery(`SELECT COUNT\(\*\) FROM connections WHERE provider_id = \$1 AND status = ANY\(\$2\)`).
+		WithArgs(id, live).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectRollback()
+
+	_, err = store.PurgeProfile(id)
+	var inUse *ConnectionsInUseError
+	assert.ErrorAs(t, err, &inUse)
+	assert.Equal(t, 1, inUse.Count)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPurgeProfile_DeletesFinishedConnections(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+	store := NewStore(sqlx.NewDb(db, "sqlmock"), nil)
+
+	id := uuid.New()
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT deleted_at FROM provider_profiles`).
+		WithArgs(id).
+		WillReturnRows(sqlmock.NewRows([]string{"deleted_at"}).AddRow(time.Now()))
+	mock.ExpectQuery(`SELECT COUNT\(\*\) FROM connections`).
+		WithArgs(id, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectExec(`UPDATE audit_events SET connection_id = NULL`).WithArgs(id).WillReturnResult(sqlmock.NewResult(0, 4))
+	mock.ExpectExec(`DELETE FROM tokens`).WithArgs(id).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`DELETE FROM connections WHERE provider_id = \$1`).WithArgs(id).WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectExec(`DELETE FROM provider_profiles WHERE id = \$1`).WithArgs(id).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	n, err := store.PurgeProfile(id)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), n)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestEgressHosts(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+	store := NewStore(sqlx.NewDb(db, "sqlmock"), nil)
+
+	rows := sqlmock.NewRows([]string{"auth_url", "token_url", "issuer", "api_base_url"}).
+		AddRow("https://accounts.google.com/o/oauth2/auth", "https://oauth2.googleapis.com/token", nil, "").
+		AddRow(nil, nil, "https://Login.Acme.com/", "https://api.acme.com").
+		AddRow("https://accounts.google.com/o/oauth2/v2/auth", "https://oauth2.googleapis.com/token", nil, nil)
+	mock.ExpectQuery(`SELECT auth_url, token_url, issuer, api_base_url FROM provider_profiles WHERE deleted_at IS NULL`).
+		WillReturnRows(rows)
+
+	hosts, err := store.EgressHosts(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"accounts.google.com", "oauth2.googleapis.com", "login.acme.com", "api.acme.com"}, hosts)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestParseProfile_ValidatesScopePolicy(t *testing.T) {
+	_, err := ParseProfile(`{"name": "acme", "auth_type": "api_key", "params": {"scope_policy": {"required": ["admin"], "denied": ["admin"]}}}`)
+	assert.ErrorContains(t, err, "params.scope_policy")
+	_, err = ParseProfile(`{"name": "acme", "auth_type": "api_key", "params": {"scope_policy": {"allowed": ["read:*"], "required": ["read:user"]}}}`)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,185 @@
+// Package registration registers OAuth clients with identity providers using
+// Dynamic Client Registration (RFC 7591) and manages them through the client
+// configuration endpoint (RFC 7592).
+package registration
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Client is a registered client as the provider describes it.
+type Client struct {
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret,omitempty"`
+	// ClientSecretExpiresAt is the expiry of ClientSecret in seconds since
+	// the epoch; 0 means it does not expire.
+	ClientSecretExpiresAt   int64  `json:"client_secret_expires_at,omitempty"`
+	RegistrationAccessToken string `json:"registration_access_token,omitempty"`
+	RegistrationClientURI   string `json:"registration_client_uri,omitempty"`
+	// Metadata holds every member of the response, including the client
+	// metadata the provider registered.
+	Metadata map[string]interface{} `json:"-"`
+}
+
+// Error is an error response of a registration or management endpoint.
+type Error struct {
+	Status      int
+	Code        string
+	Description string
+}
+
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("client registration failed with status %d", e.Status)
+	if e.Code != "" {
+		msg += ": " + e.Code
+	}
+	if e.Description != "" {
+		msg += ": " + e.Description
+	}
+	return msg
+}
+
+// serverManaged are the response members RFC 7592 forbids sending back in
+// an update request.
+var serverManaged = []string{"registration_access_token", "registration_client_uri", "client_secret_expires_at", "client_id_issued_at"}
+
+// Register registers a client at endpoint with the given client metadata.
+// initialAccessToken, if set, is sent as a bearer token for providers that
+// only allow authorized registrations.
+func Register(ctx context.Context, client *http.Client, endpoint, initialAccessToken string, metadata map[string]interface{}) (*Client, error) {
+	return do(ctx, client, http.MethodPost, endpoint, initialAccessToken, metadata, http.StatusCreated)
+}
+
+// Read fetches the current registration of a client from its
+// configuration endpoint.
+func Read(ctx context.Context, client *http.Client, clientURI, accessToken string) (*Client, error) {
+	return do(ctx, client, http.MethodGet, clientURI, accessToken, nil, http.StatusOK)
+}
+
+// Update replaces the metadata of a registered client. metadata must carry
+// the client_id. Providers may issue a new client secret or registration
+// access token in the response.
+func Update(ctx context.Context, client *http.Client, clientURI, accessToken string, metadata map[string]interface{}) (*Client, error) {
+	return do(ctx, client, http.MethodPut, clientURI, accessToken, metadata, http.StatusOK)
+}
+
+// Rotate reads a client's registration and writes it back with Update,
+// which providers that rotate credentials answer with a new client secret
+// and registration access token. The returned client keeps the old ones
+// when the provider issued none.
+func Rotate(ctx context.Context, client *http.Client, clientURI, accessToken string) (*Client, error) {
+	current, err := Read(ctx, client, clientURI, accessToken)
+	if err != nil {
+		return nil, err
+	}
+	metadata := make(map[string]interface{}, len(current.Metadata))
+	for k, v := range current.Metadata {
+		metadata[k] = v
+	}
+	for _, k := range serverManaged {
+		delete(metadata, k)
+	}
+	delete(metadata, "client_secret")
+
+	updated, err := Update(ctx, client, clientURI, accessToken, metadata)
+	if err != nil {
+		return nil, err
+	}
+	if updated.ClientSecret == "" {
+		updated.ClientSecret = current.ClientSecret
+		updated.ClientSecretExpiresAt = current.ClientSecretExpiresAt
+	}
+	if updated.RegistrationAccessToken == "" {
+		updated.RegistrationAccessToken = accessToken
+	}
+	if updated.RegistrationClientURI == "" {
+		updated.RegistrationClientURI = clientURI
+	}
+	return updated, nil
+}
+
+// Delete deregisters a client. A client the provider no longer knows is
+// not an error.
+func Delete(ctx context.Context, client *http.Client, clientURI, accessToken string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, clientURI, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusNoContent, http.StatusOK, http.StatusNotFound, http.StatusUnauthorized:
+		return nil
+	}
+	return readError(resp)
+}
+
+func do(ctx context.Context, client *http.Client, method, url, bearer string, metadata map[string]interface{}, want int) (*Client, error) {
+	var body io.Reader
+	if metadata != nil {
+		b, err := json.Marshal(metadata)
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if bearer != "" {
+		req.Header.Set("Authorization", "Bearer "+bearer)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	// Some providers answer a registration with 200 instead of 201.
+	if resp.StatusCode != want && resp.StatusCode != http.StatusOK {
+		return nil, readError(resp)
+	}
+
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	var c Client
+	if err := json.Unmarshal(raw, &c); err != nil {
+		return nil, fmt.Errorf("invalid client registration response: %w", err)
+	}
+	if err := json.Unmarshal(raw, &c.Metadata); err != nil {
+		return nil, fmt.Errorf("invalid client registration response: %w", err)
+	}
+	if strings.TrimSpace(c.ClientID) == "" {
+		return nil, fmt.Errorf("invalid client registration response: missing client_id")
+	}
+	return &c, nil
+}
+
+func readError(resp *http.Response) error {
+	e := &Error{Status: resp.StatusCode}
+	var body struct {
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&body) == nil {
+		e.Code, e.Description = body.Error, body.ErrorDescription
+	}
+	return e
+}
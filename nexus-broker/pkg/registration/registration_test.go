@@ -0,0 +1,89 @@
+package registration
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegister(t *testing.T) {
+	var got map[string]interface{}
+	var auth string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		_ = json.NewDecoder(r.Body).Decode(&got)
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(`{"client_id": "c-1", "client_secret": "s-1", "client_secret_expires_at": 0,
+			"registration_access_token": "rat-1", "registration_client_uri": "https://idp.example/register/c-1",
+			"redirect_uris": ["https://broker.example/auth/callback"]}`))
+	}))
+	defer srv.Close()
+
+	c, err := Register(context.Background(), srv.Client(), srv.URL, "iat", map[string]interface{}{
+		"redirect_uris": []string{"https://broker.example/auth/callback"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "Bearer iat", auth)
+	assert.Equal(t, []interface{}{"https://broker.example/auth/callback"}, got["redirect_uris"])
+	assert.Equal(t, "c-1", c.ClientID)
+	assert.Equal(t, "s-1", c.ClientSecret)
+	assert.Equal(t, "rat-1", c.RegistrationAccessToken)
+	assert.Equal(t, "https://idp.example/register/c-1", c.RegistrationClientURI)
+	assert.Contains(t, c.Metadata, "redirect_uris")
+}
+
+func TestRegister_ErrorResponse(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error": "invalid_redirect_uri", "error_description": "not allowed"}`))
+	}))
+	defer srv.Close()
+
+	_, err := Register(context.Background(), srv.Client(), srv.URL, "", map[string]interface{}{})
+	var regErr *Error
+	require.True(t, errors.As(err, &regErr))
+	assert.Equal(t, http.StatusBadRequest, regErr.Status)
+	assert.Equal(t, "invalid_redirect_uri", regErr.Code)
+	assert.Equal(t, "not allowed", regErr.Description)
+}
+
+func TestRotate(t *testing.T) {
+	var put map[string]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer rat-1", r.Header.Get("Authorization"))
+		switch r.Method {
+		case http.MethodGet:
+			_, _ = w.Write([]byte(`{"client_id": "c-1", "client_secret": "s-1", "client_id_issued_at": 1,
+				"registration_access_token": "rat-1", "registration_client_uri": "` + "http://" + r.Host + r.URL.Path + `",
+				"redirect_uris": ["https://broker.example/auth/callback"]}`))
+		case http.MethodPut:
+			_ = json.NewDecoder(r.Body).Decode(&put)
+			_, _ = w.Write([]byte(`{"client_id": "c-1", "client_secret": "s-2", "client_secret_expires_at": 1900000000}`))
+		default:
+			t.Errorf("unexpected %s", r.Method)
+		}
+	}))
+	defer srv.Close()
+
+	c, err := Rotate(context.Background(), srv.Client(), srv.URL+"/register/c-1", "rat-1")
+	require.NoError(t, err)
+
+	// The update carries the client metadata but nothing the server manages.
+	assert.Equal(t, "c-1", put["client_id"])
+	assert.Contains(t, put, "redirect_uris")
+	for _, k := range []string{"client_secret", "client_id_issued_at", "registration_access_token", "registration_client_uri"} {
+		assert.NotContains(t, put, k)
+	}
+
+	assert.Equal(t, "s-2", c.ClientSecret)
+	assert.Equal(t, int64(1900000000), c.ClientSecretExpiresAt)
+	// Not reissued, so the current ones are kept.
+	assert.Equal(t, "rat-1", c.RegistrationAccessToken)
+	assert.Equal(t, srv.URL+"/register/c-1", c.RegistrationClientURI)
+}
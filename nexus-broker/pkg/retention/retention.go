@@ -0,0 +1,203 @@
+// Package retention deletes rows the broker no longer needs: connections
+// that never became usable and, optionally, old audit events.
+//
+// Tokens are not swept separately. The tokens table holds a single row per
+// connection (see migrations/10_unique_token_per_connection.sql), so there is
+// no version history to trim; a connection's token row is removed with it.
+package retention
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/connstate"
+)
+
+// batchSize bounds each delete so a large backlog does not hold long locks.
+const batchSize = 500
+
+// Kinds of rows reported in a Result and in metrics.
+const (
+	KindFailedConnections  = "failed_connections"
+	KindExpiredConnections = "expired_connections"
+	KindAuditEvents        = "audit_events"
+)
+
+// Policy sets how long each kind of row is kept. A zero TTL keeps rows
+// forever.
+type Policy struct {
+	// FailedConnectionTTL applies to failed and cancelled connections.
+	FailedConnectionTTL time.Duration
+	// ExpiredConnectionTTL applies to expired connections.
+	ExpiredConnectionTTL time.Duration
+	// AuditEventTTL applies to audit_events.
+	AuditEventTTL time.Duration
+}
+
+// Result counts the rows deleted (or, in a dry run, eligible) per kind.
+type Result map[string]int64
+
+var (
+	metricDeleted = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "retention_rows_deleted_total",
+		Help: "Rows deleted by the retention sweep, by kind",
+	}, []string{"kind"})
+	metricLastRun = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "retention_last_run_timestamp_seconds",
+		Help: "Unix time the last retention sweep completed",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(metricDeleted, metricLastRun)
+}
+
+// Start sweeps every interval until ctx is cancelled.
+func Start(ctx context.Context, db *sqlx.DB, p Policy, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			res, err := Sweep(ctx, db, p, false)
+			if err != nil {
+				log.Printf("retention sweep failed: %v", err)
+				continue
+			}
+			for kind, n := range res {
+				if n > 0 {
+					log.Printf("retention sweep: deleted %d %s", n, kind)
+				}
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Sweep applies p once. With dryRun it only counts the eligible rows.
+func Sweep(ctx context.Context, db *sqlx.DB, p Policy, dryRun bool) (Result, error) {
+	now := time.Now()
+	res := Result{}
+
+	connections := []struct {
+		kind     string
+		ttl      time.Duration
+		statuses []string
+	}{
+		{KindFailedConnections, p.FailedConnectionTTL, []string{string(connstate.StateFailed), string(connstate.StateCancelled)}},
+		{KindExpiredConnections, p.ExpiredConnectionTTL, []string{string(connstate.StateExpired)}},
+	}
+	for _, c := range connections {
+		if c.ttl <= 0 {
+			continue
+		}
+		n, err := sweepConnections(ctx, db, c.statuses, now.Add(-c.ttl), dryRun)
+		res[c.kind] = n
+		if err != nil {
+			return res, fmt.Errorf("%s: %w", c.kind, err)
+		}
+	}
+
+	if p.AuditEventTTL > 0 {
+		n, err := sweepAuditEvents(ctx, db, now.Add(-p.AuditEventTTL), dryRun)
+		res[KindAuditEvents] = n
+		if err != nil {
+			return res, fmt.Errorf("%s: %w", KindAuditEvents, err)
+		}
+	}
+
+	if !dryRun {
+		for kind, n := range res {
+			metricDeleted.WithLabelValues(kind).Add(float64(n))
+		}
+		metricLastRun.SetToCurrentTime()
+	}
+	return res, nil
+}
+
+// sweepConnections deletes connections in statuses whose last status change
+// is older than cutoff, along with their token rows. Audit events that
+// reference them are kept and detached.
+func sweepConnections(ctx context.Context, db *sqlx.DB, statuses []string, cutoff time.Time, dryRun bool) (int64, error) {
+	const eligible = `status = ANY($1) AND COALESCE(status_changed_at, updated_at) < $2`
+	if dryRun {
+		var n int64
+		err := db.GetContext(ctx, &n, `SELECT COUNT(*) FROM connections WHERE `+eligible, pq.Array(statuses), cutoff)
+		return n, err
+	}
+
+	var total int64
+	for {
+		n, err := deleteConnectionBatch(ctx, db, eligible, pq.Array(statuses), cutoff)
+		total += n
+		if err != nil || n < batchSize {
+			return total, err
+		}
+	}
+}
+
+func deleteConnectionBatch(ctx context.Context, db *sqlx.DB, eligible string, args ...interface{}) (int64, error) {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var ids []string
+	if err := tx.SelectContext(ctx, &ids,
+		`SELECT id FROM connections WHERE `+eligible+fmt.Sprintf(` LIMIT %d FOR UPDATE SKIP LOCKED`, batchSize),
+		args...); err != nil {
+		return 0, err
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	batch := pq.Array(ids)
+	if _, err := tx.ExecContext(ctx, `UPDATE audit_events SET connection_id = NULL WHERE connection_id = ANY($1)`, batch); err != nil {
+		return 0, err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM tokens WHERE connection_id = ANY($1)`, batch); err != nil {
+		return 0, err
+	}
+	res, err := tx.ExecContext(ctx, `DELETE FROM connections WHERE id = ANY($1)`, batch)
+	if err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func sweepAuditEvents(ctx context.Context, db *sqlx.DB, cutoff time.Time, dryRun bool) (int64, error) {
+	if dryRun {
+		var n int64
+		err := db.GetContext(ctx, &n, `SELECT COUNT(*) FROM audit_events WHERE created_at < $1`, cutoff)
+		return n, err
+	}
+
+	var total int64
+	for {
+		res, err := db.ExecContext(ctx, fmt.Sprintf(`
+			DELETE FROM audit_events WHERE id IN (
+				SELECT id FROM audit_events WHERE created_at < $1 LIMIT %d
+			)`, batchSize), cutoff)
+		if err != nil {
+			return total, err
+		}
+		n, _ := res.RowsAffected()
+		total += n
+		if n < batchSize {
+			return total, nil
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package retention
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+func newMockDB(t *testing.T) (*sqlx.DB, sqlmock.Sqlmock) {
+	t.Helper()
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	return sqlx.NewDb(db, "sqlmock"), mock
+}
+
+func TestSweep_DryRunOnlyCounts(t *testing.T) {
+	db, mock := newMockDB(t)
+
+	mock.ExpectQuery(`SELECT COUNT\(\*\) FROM connections WHERE status = ANY\(\$1\)`).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(4))
+	mock.ExpectQuery(`SELECT COUNT\(\*\) FROM audit_events WHERE created_at < \$1`).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(10))
+
+	res, err := Sweep(context.Background(), db, Policy{
+		FailedConnectionTTL: time.Hour,
+		AuditEventTTL:       time.Hour,
+	}, true)
+	require.NoError(t, err)
+	assert.Equal(t, Result{KindFailedConnections: 4, KindAuditEvents: 10}, res)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSweep_DeletesConnectionsAndDetachesAudit(t *testing.T) {
+	db, mock := newMockDB(t)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT id FROM connections WHERE status = ANY\(\$1\).* FOR UPDATE SKIP LOCKED`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("c1").AddRow("c2"))
+	mock.ExpectExec(`UPDATE audit_events SET connection_id = NULL`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`DELETE FROM tokens WHERE connection_id = ANY\(\$1\)`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`DELETE FROM connections WHERE id = ANY\(\$1\)`).WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+
+	res, err := Sweep(context.Background(), db, Policy{ExpiredConnectionTTL: time.Hour}, false)
+	require.NoError(t, err)
+	assert.Equal(t, Result{KindExpiredConnections: 2}, res)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSweep_ZeroPolicyDoesNothing(t *testing.T) {
+	db, mock := newMockDB(t)
+
+	res, err := Sweep(context.Background(), db, Policy{}, false)
+	require.NoError(t, err)
+	assert.Empty(t, res)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,290 @@
+// Package router builds the Broker's HTTP API: its handlers, routes and
+// middleware. cmd/nexus-broker serves it over Postgres and Redis;
+// pkg/brokertest serves the same router over in-memory stores.
+package router
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/jmoiron/sqlx"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/api"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/internal/audit"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/internal/lock"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/internal/quota"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/internal/ratelimit"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/internal/store"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/internal/usage"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/internal/webhook"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/config"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/handlers"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/httpclient"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/pages"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/provider"
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/server"
+	"github.com/Prescott-Data/nexus-framework/nexus-common/health"
+)
+
+// ProfileStore is the provider registry behind /providers.
+type ProfileStore interface {
+	provider.ProfileStorer
+	provider.RegistrationStorer
+}
+
+// Deps are the stores and clients the routes are served from. Store and
+// Profiles are required; every other field is optional.
+type Deps struct {
+	// Store holds connections, tokens, audit events and workspace settings.
+	Store store.Store
+	// Profiles is the provider registry.
+	Profiles ProfileStore
+	// DB backs /admin/runtime and, on Postgres, the periodic provider
+	// audits. Without it /admin/runtime is not served, and without a
+	// Postgres DB neither is /providers/{id}/audit.
+	DB *sqlx.DB
+
+	// Audit records audit events. Defaults to writing them to Store.
+	Audit *audit.Service
+	// Webhook receives lifecycle events. Nil disables them.
+	Webhook *webhook.Notifier
+	// Quota enforces workspace limits. Defaults to cfg.WorkspaceLimits
+	// counted in process.
+	Quota *quota.Enforcer
+	// Usage counts token retrievals. Defaults to writing them on each call.
+	Usage *usage.Recorder
+	// RefreshLock and ProviderLimiter default to in-process implementations.
+	RefreshLock     lock.Locker
+	ProviderLimiter ratelimit.Limiter
+
+	// HTTPClient and Transports reach identity providers. They default to
+	// http.DefaultClient and a private pool.
+	HTTPClient *http.Client
+	Transports *httpclient.Pool
+	// Guard vets provider URLs at registration. Nil allows any address.
+	Guard *httpclient.AddressGuard
+	// Pages renders the hosted consent result pages. Nil uses the defaults.
+	Pages *pages.Pages
+
+	// Build is reported by /version; its Features are taken from cfg.
+	Build server.BuildInfo
+	// Reload serves POST /admin/reload. Nil leaves the route out.
+	Reload server.Reloader
+	// Checks are the dependencies /readyz probes, after the drain state.
+	Checks []health.Check
+}
+
+// API holds the handlers whose background jobs the caller runs, and those
+// the gRPC BrokerService calls.
+type API struct {
+	Callback *handlers.CallbackHandler
+	// ProviderAuditor is nil unless Deps.DB is a Postgres database.
+	ProviderAuditor *handlers.ProviderAuditor
+
+	Consent     *handlers.ConsentHandler
+	Connections *handlers.ConnectionsHandler
+	Providers   *handlers.ProvidersHandler
+	// Protect is the middleware of the routes that need the Broker API
+	// key: API key, IP allowlist and caller attribution.
+	Protect func(http.Handler) http.Handler
+}
+
+// New registers the Broker's routes on srv's router.
+func New(srv *server.Server, cfg *config.BrokerConfig, d Deps) (*API, error) {
+	if d.Audit == nil {
+		d.Audit = audit.NewServiceWithStore(d.Store)
+	}
+	if d.Quota == nil {
+		d.Quota = quota.New(d.Store, quota.Limits{
+			MaxPendingConnections: cfg.WorkspaceLimits.MaxPendingConnections,
+			MaxActiveConnections:  cfg.WorkspaceLimits.MaxActiveConnections,
+			MaxRefreshesPerMinute: cfg.WorkspaceLimits.MaxRefreshesPerMinute,
+		}, quota.NewLocal())
+	}
+	if d.HTTPClient == nil {
+		d.HTTPClient = http.DefaultClient
+	}
+
+	providersHandler := handlers.NewProvidersHandler(d.Profiles, d.Audit, d.Guard)
+	consentHandler := handlers.NewConsentHandler(handlers.ConsentHandlerConfig{
+		DB:                   d.DB,
+		Store:                d.Store,
+		BaseURL:              cfg.BaseURL,
+		RedirectPath:         cfg.RedirectPath,
+		States:               cfg.StateKeys,
+		HTTPClient:           d.HTTPClient,
+		Transports:           d.Transports,
+		EnforceReturnURL:     cfg.EnforceReturnURL,
+		AllowedReturnDomains: cfg.AllowedReturnDomains,
+		Quota:                d.Quota,
+	})
+	callbackHandler := handlers.NewCallbackHandler(handlers.CallbackHandlerConfig{
+		DB:                   d.DB,
+		Store:                d.Store,
+		Audit:                d.Audit,
+		BaseURL:              cfg.BaseURL,
+		RedirectPath:         cfg.RedirectPath,
+		EncryptionKey:        cfg.EncryptionKey,
+		States:               cfg.StateKeys,
+		HTTPClient:           d.HTTPClient,
+		Transports:           d.Transports,
+		EnforceReturnURL:     cfg.EnforceReturnURL,
+		AllowedReturnDomains: cfg.AllowedReturnDomains,
+		RequireBoundTokens:   cfg.RequireBoundTokens,
+		RefreshLock:          d.RefreshLock,
+		Webhook:              d.Webhook,
+		TokenRequests:        cfg.TokenRequests,
+		Usage:                d.Usage,
+		Quota:                d.Quota,
+		ProviderLimiter:      d.ProviderLimiter,
+		RefreshRetry:         cfg.RefreshRetry,
+		Pages:                d.Pages,
+	})
+	auditHandler := handlers.NewAuditHandlerWithStore(d.Store)
+	deprovisionHandler := handlers.NewDeprovisionHandler(handlers.DeprovisionHandlerConfig{
+		Store:   d.Store,
+		Audit:   d.Audit,
+		Webhook: d.Webhook,
+	})
+	connectionsHandler := handlers.NewConnectionsHandler(handlers.ConnectionsHandlerConfig{
+		Store: d.Store,
+		Audit: d.Audit,
+	})
+	providerValidator := handlers.NewProviderValidator(handlers.ProviderValidatorConfig{
+		BaseURL:      cfg.BaseURL,
+		RedirectPath: cfg.RedirectPath,
+		HTTPClient:   d.HTTPClient,
+		Transports:   d.Transports,
+	})
+	var providerAuditor *handlers.ProviderAuditor
+	if d.DB != nil && d.DB.DriverName() == "postgres" {
+		providerAuditor = handlers.NewProviderAuditor(d.DB, d.Profiles, providerValidator)
+	}
+	clientRegistrar := handlers.NewClientRegistrar(handlers.ClientRegistrarConfig{
+		Store:        d.Profiles,
+		Audit:        d.Audit,
+		Guard:        d.Guard,
+		BaseURL:      cfg.BaseURL,
+		RedirectPath: cfg.RedirectPath,
+		HTTPClient:   d.HTTPClient,
+		Transports:   d.Transports,
+	})
+	providersHandler.WithRegistrar(clientRegistrar)
+
+	router := srv.Router()
+	router.Get("/auth/callback", callbackHandler.Handle)
+	router.Method("GET", "/metrics", server.MetricsHandler())
+	router.Get("/auth/capture-schema", callbackHandler.GetCaptureSchema)
+	router.Post("/auth/capture-credential", callbackHandler.SaveCredential)
+	router.Get("/auth/delegate", callbackHandler.Delegate)
+
+	protect := chi.Chain(
+		server.ApiKeyMiddleware(cfg.RequireAPIKey, cfg.APIKeys),
+		server.AllowlistMiddleware(cfg.RequireAllowlist, cfg.AllowedCIDRs, cfg.APIKeyCIDRs),
+		audit.CallerMiddleware(server.APIKeyAuthenticated),
+	)
+	protected := router.With(protect...)
+	protected.Get("/audit", auditHandler.List)
+	protected.Get("/audit-events", auditHandler.Query)
+	protected.Route("/providers", func(r chi.Router) {
+		r.Post("/", providersHandler.Register)
+		r.Get("/", providersHandler.List)
+		r.Post("/validate", providerValidator.Validate)
+		r.Get("/metadata", providersHandler.Metadata)
+		r.Get("/by-name/{name}", providersHandler.GetByName)
+		r.Delete("/by-name/{name}", providersHandler.DeleteByName)
+		r.Get("/{id}", providersHandler.Get)
+		if providerAuditor != nil {
+			r.Get("/{id}/audit", providerAuditor.Get)
+		}
+		r.Get("/{id}/registration", clientRegistrar.GetRegistration)
+		r.Post("/{id}/registration/rotate", clientRegistrar.Rotate)
+		r.Put("/{id}", providersHandler.Update)
+		r.Patch("/{id}", providersHandler.Patch)
+		r.Delete("/{id}", providersHandler.Delete)
+		r.Post("/{id}/restore", providersHandler.Restore)
+		r.Delete("/{id}/purge", providersHandler.Purge)
+	})
+	protected.With(srv.RejectWhileDraining).Post("/auth/consent-spec", consentHandler.GetSpec)
+	protected.Get("/connections/stale", connectionsHandler.Stale)
+	protected.Get("/connections/{connectionID}", connectionsHandler.Get)
+	protected.Get("/connections/{connectionID}/status", connectionsHandler.Status)
+	protected.Get("/connections/{connectionID}/token", callbackHandler.GetToken)
+	protected.Post("/connections/{connectionID}/refresh", callbackHandler.Refresh)
+	protected.Post("/connections/{connectionID}/exchange", callbackHandler.Exchange)
+	protected.Post("/connections/{connectionID}/grants", callbackHandler.CreateGrant)
+	protected.Post("/grants/redeem", callbackHandler.RedeemGrant)
+	protected.Post("/connections/{connectionID}/revoke", connectionsHandler.Revoke)
+	protected.Post("/connections/{connectionID}/suspend", connectionsHandler.Suspend)
+	protected.Post("/connections/{connectionID}/resume", connectionsHandler.Resume)
+	protected.With(srv.RejectWhileDraining).Post("/connections/{connectionID}/reauthorize", consentHandler.Reauthorize)
+	protected.Get("/workspaces/{workspaceID}/users/{user}/connections", connectionsHandler.ListUserConnections)
+	protected.Post("/workspaces/{workspaceID}/users/{user}/deprovision", deprovisionHandler.Deprovision)
+
+	if cfg.AdminAPIKey != "" {
+		limitsHandler := handlers.NewLimitsHandler(d.Quota, d.Store, d.Audit)
+		scopeApprovalsHandler := handlers.NewScopeApprovalsHandler(d.Store, d.Audit)
+		approvalsHandler := handlers.NewApprovalsHandler(handlers.ApprovalsHandlerConfig{
+			Store:   d.Store,
+			Audit:   d.Audit,
+			Webhook: d.Webhook,
+		})
+		router.Group(func(r chi.Router) {
+			r.Use(server.AdminKeyMiddleware(cfg.AdminAPIKey))
+			if d.Reload != nil {
+				r.Post("/admin/reload", server.ReloadHandler(d.Reload))
+			}
+			r.Get("/admin/workspaces/{workspaceID}/limits", limitsHandler.Get)
+			r.Put("/admin/workspaces/{workspaceID}/limits", limitsHandler.Put)
+			r.Delete("/admin/workspaces/{workspaceID}/limits", limitsHandler.Delete)
+			r.Get("/admin/workspaces/{workspaceID}/providers/{providerID}/scope-approvals", scopeApprovalsHandler.Get)
+			r.Put("/admin/workspaces/{workspaceID}/providers/{providerID}/scope-approvals", scopeApprovalsHandler.Put)
+			r.Delete("/admin/workspaces/{workspaceID}/providers/{providerID}/scope-approvals", scopeApprovalsHandler.Delete)
+			r.Get("/admin/approvals", approvalsHandler.List)
+			r.Post("/admin/approvals/{connectionID}/approve", approvalsHandler.Approve)
+			r.Post("/admin/approvals/{connectionID}/reject", approvalsHandler.Reject)
+			r.Get("/admin/refresh-failures", callbackHandler.ListRefreshFailures)
+			r.Post("/admin/refresh-failures/{connectionID}/retry", callbackHandler.RetryRefreshFailure)
+		})
+	}
+	if cfg.EnableDebugEndpoints {
+		router.Group(func(r chi.Router) {
+			r.Use(server.AdminKeyMiddleware(cfg.AdminAPIKey))
+			r.Mount("/debug", middleware.Profiler())
+			if d.DB != nil {
+				r.Get("/admin/runtime", server.RuntimeStatsHandler(d.DB.Stats))
+			}
+		})
+		log.Println("Debug endpoints enabled: /debug/pprof, /admin/runtime")
+	}
+
+	build := d.Build
+	build.Features = cfg.Features()
+	router.Get("/health", server.VersionedHealthHandler(build.Version))
+	router.Get("/version", server.VersionHandler(build))
+	router.Get("/healthz", health.LivenessHandler)
+	if cfg.EnableAPIDocs {
+		openAPI, err := server.OpenAPIHandler(api.Spec)
+		if err != nil {
+			return nil, fmt.Errorf("API docs: %w", err)
+		}
+		router.Get("/openapi.json", openAPI)
+		router.Get("/docs", server.SwaggerUIHandler("Nexus Broker API", "openapi.json"))
+	}
+	checks := append([]health.Check{
+		{Name: "shutdown", Critical: true, Check: srv.DrainCheck},
+	}, d.Checks...)
+	router.Get("/readyz", health.ReadinessHandler(cfg.HealthCheckTimeout, checks...))
+
+	return &API{
+		Callback:        callbackHandler,
+		ProviderAuditor: providerAuditor,
+		Consent:         consentHandler,
+		Connections:     connectionsHandler,
+		Providers:       providersHandler,
+		Protect:         protect.Handler,
+	}, nil
+}
@@ -0,0 +1,51 @@
+package router_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/brokertest"
+)
+
+func get(t *testing.T, url, apiKey string) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	require.NoError(t, err)
+	if apiKey != "" {
+		req.Header.Set("X-API-Key", apiKey)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func TestRouter_ProviderByName(t *testing.T) {
+	b := brokertest.New(t, brokertest.Config{APIKey: "broker-key"})
+	global := b.AddProvider(brokertest.Provider{Name: "Global-IdP"})
+	b.AddProvider(brokertest.Provider{Name: "team-idp", WorkspaceIDs: []string{"ws-1"}})
+
+	assert.Equal(t, http.StatusUnauthorized, get(t, b.URL+"/providers/by-name/global-idp", "").StatusCode)
+
+	resp := get(t, b.URL+"/providers/by-name/global-idp", "broker-key")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var body struct {
+		ID string `json:"id"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	assert.Equal(t, global, body.ID)
+
+	assert.Equal(t, http.StatusNotFound, get(t, b.URL+"/providers/by-name/team-idp", "broker-key").StatusCode)
+	assert.Equal(t, http.StatusOK, get(t, b.URL+"/providers/by-name/team-idp?workspace_id=ws-1", "broker-key").StatusCode)
+}
+
+func TestRouter_Health(t *testing.T) {
+	b := brokertest.New(t, brokertest.Config{})
+	for _, path := range []string{"/healthz", "/readyz", "/version"} {
+		assert.Equal(t, http.StatusOK, get(t, b.URL+path, "").StatusCode, path)
+	}
+}
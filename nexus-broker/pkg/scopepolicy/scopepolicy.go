@@ -0,0 +1,196 @@
+// Package scopepolicy restricts the scopes a consent may request from a
+// provider. A policy is the provider's scope_policy param:
+//
+//	"scope_policy": {
+//	  "allowed":  ["openid", "email", "https://www.googleapis.com/auth/drive.*"],
+//	  "denied":   ["*.full_access"],
+//	  "required": ["openid"],
+//	  "approval": ["https://www.googleapis.com/auth/drive"]
+//	}
+//
+// Every entry is a pattern in which "*" matches any run of characters,
+// "/" and "." included; scopes are otherwise compared exactly. Denied
+// scopes are refused even when allowed. With an allowlist, only the scopes
+// it matches and the required ones may be requested. Required scopes are
+// added to every consent. Scopes matching approval need an administrator's
+// approval for the workspace first.
+package scopepolicy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Param is the provider param holding the policy.
+const Param = "scope_policy"
+
+// Codes of the violations.
+const (
+	CodeDenied           = "scope_denied"
+	CodeNotAllowed       = "scope_not_allowed"
+	CodeApprovalRequired = "scope_approval_required"
+)
+
+// Policy is a provider's scope policy. Empty lists impose nothing.
+type Policy struct {
+	Allowed  []string `json:"allowed,omitempty"`
+	Denied   []string `json:"denied,omitempty"`
+	Required []string `json:"required,omitempty"`
+	Approval []string `json:"approval,omitempty"`
+}
+
+// Violation is a consent refused by a policy.
+type Violation struct {
+	// Code is one of the Code constants.
+	Code string
+	// Scopes are the requested scopes at fault.
+	Scopes []string
+}
+
+func (v *Violation) Error() string {
+	list := strings.Join(v.Scopes, ", ")
+	switch v.Code {
+	case CodeDenied:
+		return "scope policy denies " + list
+	case CodeNotAllowed:
+		return "scope policy does not allow " + list
+	default:
+		return list + " require an administrator's approval for this workspace"
+	}
+}
+
+// Parse returns the policy in a provider's params, or nil if it has none.
+func Parse(params *json.RawMessage) (*Policy, error) {
+	if params == nil {
+		return nil, nil
+	}
+	var p map[string]json.RawMessage
+	if err := json.Unmarshal(*params, &p); err != nil {
+		return nil, nil
+	}
+	raw, ok := p[Param]
+	if !ok || string(raw) == "null" {
+		return nil, nil
+	}
+	var policy Policy
+	dec := json.NewDecoder(strings.NewReader(string(raw)))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&policy); err != nil {
+		return nil, fmt.Errorf("invalid policy: %w", err)
+	}
+	if err := policy.validate(); err != nil {
+		return nil, err
+	}
+	return &policy, nil
+}
+
+// validate rejects empty patterns and required scopes the policy itself
+// refuses.
+func (p *Policy) validate() error {
+	for name, list := range map[string][]string{"allowed": p.Allowed, "denied": p.Denied, "required": p.Required, "approval": p.Approval} {
+		for _, pattern := range list {
+			if strings.TrimSpace(pattern) == "" || strings.ContainsAny(pattern, " \t\n") {
+				return fmt.Errorf("%s: %q is not a scope pattern", name, pattern)
+			}
+		}
+	}
+	for _, scope := range p.Required {
+		if strings.Contains(scope, "*") {
+			return fmt.Errorf("required: %q must be a scope, not a pattern", scope)
+		}
+		if matchAny(p.Denied, scope) {
+			return fmt.Errorf("required: %q is denied", scope)
+		}
+		if matchAny(p.Approval, scope) {
+			return fmt.Errorf("required: %q needs approval", scope)
+		}
+	}
+	return nil
+}
+
+// Apply checks the scopes of a consent against p and returns them with
+// the required scopes added. approved are the scopes (or patterns) an
+// administrator approved for the workspace. A refusal is a *Violation;
+// denied scopes are reported first, then those not allowed, then those
+// needing approval.
+func (p *Policy) Apply(requested, approved []string) ([]string, error) {
+	var denied, notAllowed, unapproved []string
+	for _, scope := range requested {
+		switch {
+		case matchAny(p.Denied, scope):
+			denied = append(denied, scope)
+		case len(p.Allowed) > 0 && !matchAny(p.Allowed, scope) && !contains(p.Required, scope):
+			notAllowed = append(notAllowed, scope)
+		case matchAny(p.Approval, scope) && !matchAny(approved, scope):
+			unapproved = append(unapproved, scope)
+		}
+	}
+	switch {
+	case len(denied) > 0:
+		return nil, &Violation{Code: CodeDenied, Scopes: denied}
+	case len(notAllowed) > 0:
+		return nil, &Violation{Code: CodeNotAllowed, Scopes: notAllowed}
+	case len(unapproved) > 0:
+		return nil, &Violation{Code: CodeApprovalRequired, Scopes: unapproved}
+	}
+	scopes := append([]string(nil), requested...)
+	for _, scope := range p.Required {
+		if !contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	return scopes, nil
+}
+
+// ValidApprovals checks the scopes an administrator approves, which may be
+// patterns too.
+func ValidApprovals(scopes []string) error {
+	for _, s := range scopes {
+		if strings.TrimSpace(s) == "" || strings.ContainsAny(s, " \t\n") {
+			return errors.New("approved scopes must be scopes or patterns without whitespace")
+		}
+	}
+	return nil
+}
+
+func matchAny(patterns []string, scope string) bool {
+	for _, pattern := range patterns {
+		if Match(pattern, scope) {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Match reports whether scope matches pattern, in which "*" matches any
+// run of characters.
+func Match(pattern, scope string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == scope
+	}
+	if !strings.HasPrefix(scope, parts[0]) {
+		return false
+	}
+	scope = scope[len(parts[0]):]
+	last := parts[len(parts)-1]
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(scope, part)
+		if i < 0 {
+			return false
+		}
+		scope = scope[i+len(part):]
+	}
+	return len(scope) >= len(last) && strings.HasSuffix(scope, last)
+}
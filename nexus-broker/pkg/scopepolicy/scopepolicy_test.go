@@ -0,0 +1,106 @@
+package scopepolicy
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func parse(t *testing.T, params string) *Policy {
+	t.Helper()
+	raw := json.RawMessage(params)
+	p, err := Parse(&raw)
+	require.NoError(t, err)
+	return p
+}
+
+func TestMatch(t *testing.T) {
+	for _, tc := range []struct {
+		pattern, scope string
+		want           bool
+	}{
+		{"openid", "openid", true},
+		{"openid", "openid2", false},
+		{"*", "anything", true},
+		{"https://www.googleapis.com/auth/drive.*", "https://www.googleapis.com/auth/drive.readonly", true},
+		{"https://www.googleapis.com/auth/drive.*", "https://www.googleapis.com/auth/drive", false},
+		{"*.full_access", "repo.full_access", true},
+		{"*.full_access", "repo.read", false},
+		{"a*b*c", "aXbYc", true},
+		{"a*b*c", "acb", false},
+		{"ab*ba", "aba", false},
+	} {
+		assert.Equal(t, tc.want, Match(tc.pattern, tc.scope), "%s ~ %s", tc.pattern, tc.scope)
+	}
+}
+
+func TestParse(t *testing.T) {
+	assert.Nil(t, parse(t, `{"region": "eu"}`))
+	assert.Nil(t, parse(t, `{"scope_policy": null}`))
+	p, err := Parse(nil)
+	require.NoError(t, err)
+	assert.Nil(t, p)
+
+	for name, params := range map[string]string{
+		"unknown field":     `{"scope_policy": {"allow": ["openid"]}}`,
+		"not a list":        `{"scope_policy": {"denied": "admin"}}`,
+		"empty pattern":     `{"scope_policy": {"allowed": [""]}}`,
+		"whitespace":        `{"scope_policy": {"allowed": ["openid email"]}}`,
+		"required pattern":  `{"scope_policy": {"required": ["read:*"]}}`,
+		"required denied":   `{"scope_policy": {"required": ["admin"], "denied": ["adm*"]}}`,
+		"required approval": `{"scope_policy": {"required": ["admin"], "approval": ["admin"]}}`,
+	} {
+		raw := json.RawMessage(params)
+		_, err := Parse(&raw)
+		assert.Error(t, err, name)
+	}
+}
+
+func TestApply(t *testing.T) {
+	p := parse(t, `{"scope_policy": {
+		"allowed": ["openid", "email", "drive.*"],
+		"denied": ["*.admin"],
+		"required": ["openid"],
+		"approval": ["drive.write"]
+	}}`)
+
+	scopes, err := p.Apply([]string{"email", "drive.read"}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"email", "drive.read", "openid"}, scopes)
+
+	scopes, err = p.Apply([]string{"openid"}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"openid"}, scopes)
+
+	_, err = p.Apply([]string{"email", "drive.admin", "calendar"}, nil)
+	assert.Equal(t, &Violation{Code: CodeDenied, Scopes: []string{"drive.admin"}}, err)
+
+	_, err = p.Apply([]string{"email", "calendar", "contacts"}, nil)
+	assert.Equal(t, &Violation{Code: CodeNotAllowed, Scopes: []string{"calendar", "contacts"}}, err)
+	assert.EqualError(t, err, "scope policy does not allow calendar, contacts")
+
+	_, err = p.Apply([]string{"drive.write"}, nil)
+	assert.Equal(t, &Violation{Code: CodeApprovalRequired, Scopes: []string{"drive.write"}}, err)
+
+	scopes, err = p.Apply([]string{"drive.write"}, []string{"drive.*"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"drive.write", "openid"}, scopes)
+}
+
+func TestApply_DeniedOnly(t *testing.T) {
+	p := parse(t, `{"scope_policy": {"denied": ["admin:*"]}}`)
+	scopes, err := p.Apply([]string{"read:user", "repo"}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"read:user", "repo"}, scopes)
+	_, err = p.Apply([]string{"admin:org"}, nil)
+	assert.Error(t, err)
+}
+
+func TestValidApprovals(t *testing.T) {
+	assert.NoError(t, ValidApprovals([]string{"drive.*", "openid"}))
+	assert.NoError(t, ValidApprovals(nil))
+	assert.Error(t, ValidApprovals([]string{" "}))
+	assert.Error(t, ValidApprovals([]string{"a b"}))
+}
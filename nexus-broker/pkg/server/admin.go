@@ -0,0 +1,119 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"net/http"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/httputil"
+)
+
+// AdminKeyMiddleware guards operator-only routes (diagnostics, reloads) with
+// the X-Admin-Key header. An empty adminKey rejects every request.
+func AdminKeyMiddleware(adminKey string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := strings.TrimSpace(r.Header.Get("X-Admin-Key"))
+			if key == "" {
+				httputil.WriteError(w, http.StatusUnauthorized, "missing_admin_key", "missing admin key")
+				return
+			}
+			if adminKey == "" || subtle.ConstantTimeCompare([]byte(key), []byte(adminKey)) != 1 {
+				httputil.WriteError(w, http.StatusForbidden, "invalid_admin_key", "invalid admin key")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RuntimeStats is the body returned by the runtime diagnostics endpoint.
+type RuntimeStats struct {
+	Goroutines  int          `json:"goroutines"`
+	HeapAlloc   uint64       `json:"heap_alloc_bytes"`
+	HeapInuse   uint64       `json:"heap_inuse_bytes"`
+	HeapObjects uint64       `json:"heap_objects"`
+	Sys         uint64       `json:"sys_bytes"`
+	NumGC       uint32       `json:"num_gc"`
+	GCPauseMS   float64      `json:"gc_pause_total_ms"`
+	RecentGCMS  []float64    `json:"gc_recent_pauses_ms"`
+	DB          *DBPoolStats `json:"db,omitempty"`
+	CollectedAt time.Time    `json:"collected_at"`
+}
+
+// DBPoolStats is the subset of sql.DBStats useful for spotting pool exhaustion.
+type DBPoolStats struct {
+	MaxOpen        int     `json:"max_open"`
+	Open           int     `json:"open"`
+	InUse          int     `json:"in_use"`
+	Idle           int     `json:"idle"`
+	WaitCount      int64   `json:"wait_count"`
+	WaitDurationMS float64 `json:"wait_duration_ms"`
+}
+
+// CollectRuntimeStats snapshots Go runtime and (optionally) DB pool stats.
+func CollectRuntimeStats(dbStats func() sql.DBStats) RuntimeStats {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+
+	stats := RuntimeStats{
+		Goroutines:  runtime.NumGoroutine(),
+		HeapAlloc:   m.HeapAlloc,
+		HeapInuse:   m.HeapInuse,
+		HeapObjects: m.HeapObjects,
+		Sys:         m.Sys,
+		NumGC:       m.NumGC,
+		GCPauseMS:   float64(m.PauseTotalNs) / 1e6,
+		CollectedAt: time.Now().UTC(),
+	}
+
+	// PauseNs is a circular buffer; walk back from the most recent GC.
+	n := int(m.NumGC)
+	if n > 10 {
+		n = 10
+	}
+	for i := 0; i < n; i++ {
+		idx := (int(m.NumGC) - 1 - i + len(m.PauseNs)) % len(m.PauseNs)
+		stats.RecentGCMS = append(stats.RecentGCMS, float64(m.PauseNs[idx])/1e6)
+	}
+
+	if dbStats != nil {
+		s := dbStats()
+		stats.DB = &DBPoolStats{
+			MaxOpen:        s.MaxOpenConnections,
+			Open:           s.OpenConnections,
+			InUse:          s.InUse,
+			Idle:           s.Idle,
+			WaitCount:      s.WaitCount,
+			WaitDurationMS: float64(s.WaitDuration) / float64(time.Millisecond),
+		}
+	}
+	return stats
+}
+
+// RuntimeStatsHandler serves CollectRuntimeStats as JSON.
+func RuntimeStatsHandler(dbStats func() sql.DBStats) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		httputil.WriteJSON(w, http.StatusOK, CollectRuntimeStats(dbStats))
+	}
+}
+
+// Reloader drops cached provider data so registry changes take effect
+// without a restart. It reports how many entries each cache dropped.
+type Reloader func(ctx context.Context) (map[string]int, error)
+
+// ReloadHandler runs reload for POST /admin/reload.
+func ReloadHandler(reload Reloader) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		invalidated, err := reload(r.Context())
+		if err != nil {
+			httputil.WriteError(w, http.StatusInternalServerError, "reload_failed", err.Error())
+			return
+		}
+		httputil.WriteJSON(w, http.StatusOK, map[string]any{"status": "reloaded", "invalidated": invalidated})
+	}
+}
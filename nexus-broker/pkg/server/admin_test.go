@@ -0,0 +1,94 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAdminKeyMiddleware(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	tests := []struct {
+		name     string
+		adminKey string
+		header   string
+		want     int
+	}{
+		{name: "missing header", adminKey: "secret", want: http.StatusUnauthorized},
+		{name: "wrong key", adminKey: "secret", header: "nope", want: http.StatusForbidden},
+		{name: "no key configured", adminKey: "", header: "anything", want: http.StatusForbidden},
+		{name: "valid key", adminKey: "secret", header: "secret", want: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/admin/runtime", nil)
+			if tt.header != "" {
+				req.Header.Set("X-Admin-Key", tt.header)
+			}
+			rr := httptest.NewRecorder()
+			AdminKeyMiddleware(tt.adminKey)(next).ServeHTTP(rr, req)
+			if rr.Code != tt.want {
+				t.Errorf("expected %d, got %d", tt.want, rr.Code)
+			}
+		})
+	}
+}
+
+func TestRuntimeStatsHandler(t *testing.T) {
+	dbStats := func() sql.DBStats {
+		return sql.DBStats{MaxOpenConnections: 10, OpenConnections: 3, InUse: 2, Idle: 1}
+	}
+
+	rr := httptest.NewRecorder()
+	RuntimeStatsHandler(dbStats)(rr, httptest.NewRequest("GET", "/admin/runtime", nil))
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rr.Code)
+	}
+	var stats RuntimeStats
+	if err := json.NewDecoder(rr.Body).Decode(&stats); err != nil {
+		t.Fatal(err)
+	}
+	if stats.Goroutines <= 0 {
+		t.Errorf("expected positive goroutine count, got %d", stats.Goroutines)
+	}
+	if stats.DB == nil || stats.DB.Open != 3 || stats.DB.InUse != 2 {
+		t.Errorf("unexpected db stats: %+v", stats.DB)
+	}
+}
+
+func TestReloadHandler(t *testing.T) {
+	rr := httptest.NewRecorder()
+	ReloadHandler(func(ctx context.Context) (map[string]int, error) {
+		return map[string]int{"http_cache": 3}, nil
+	}).ServeHTTP(rr, httptest.NewRequest("POST", "/admin/reload", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rr.Code)
+	}
+	var body struct {
+		Status      string         `json:"status"`
+		Invalidated map[string]int `json:"invalidated"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Status != "reloaded" || body.Invalidated["http_cache"] != 3 {
+		t.Errorf("unexpected body: %+v", body)
+	}
+
+	rr = httptest.NewRecorder()
+	ReloadHandler(func(ctx context.Context) (map[string]int, error) {
+		return nil, errors.New("redis down")
+	}).ServeHTTP(rr, httptest.NewRequest("POST", "/admin/reload", nil))
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("expected 500 on reload failure, got %d", rr.Code)
+	}
+}
@@ -8,36 +8,32 @@ import (
 	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/httputil"
 )
 
-// AllowlistMiddleware restricts access to the specified CIDRs when require is true.
-func AllowlistMiddleware(require bool, allowedCIDRs string) func(http.Handler) http.Handler {
-	var nets []*net.IPNet
-	for _, cidr := range strings.Split(allowedCIDRs, ",") {
-		cidr = strings.TrimSpace(cidr)
-		if cidr == "" {
-			continue
-		}
-		if _, network, err := net.ParseCIDR(cidr); err == nil {
-			nets = append(nets, network)
-		}
+// AllowlistMiddleware restricts access by client IP. When require is true,
+// callers must come from allowedCIDRs. A caller presenting an API key listed
+// in keyCIDRs must come from that key's ranges instead, whether or not
+// require is set. Entries may be CIDRs or bare IP addresses; invalid entries
+// are skipped (config.Load rejects them).
+//
+// The client IP is r.RemoteAddr, which the server derives from trusted
+// proxies only (see httputil.ClientIP).
+func AllowlistMiddleware(require bool, allowedCIDRs []string, keyCIDRs map[string][]string) func(http.Handler) http.Handler {
+	global := ParseCIDRs(allowedCIDRs)
+	perKey := make(map[string][]*net.IPNet, len(keyCIDRs))
+	for key, cidrs := range keyCIDRs {
+		perKey[key] = ParseCIDRs(cidrs)
 	}
 
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			if !require {
-				next.ServeHTTP(w, r)
-				return
-			}
-			clientIP := getClientIP(r)
-
-			allowed := false
-			for _, network := range nets {
-				if network.Contains(clientIP) {
-					allowed = true
-					break
+			nets, restricted := perKey[strings.TrimSpace(r.Header.Get("X-API-Key"))]
+			if !restricted {
+				if !require {
+					next.ServeHTTP(w, r)
+					return
 				}
+				nets = global
 			}
-
-			if !allowed {
+			if !contains(nets, httputil.RemoteIP(r)) {
 				httputil.WriteError(w, http.StatusForbidden, "access_denied", "Access denied")
 				return
 			}
@@ -47,20 +43,40 @@ func AllowlistMiddleware(require bool, allowedCIDRs string) func(http.Handler) h
 	}
 }
 
-func getClientIP(r *http.Request) net.IP {
-	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
-		ips := strings.Split(xff, ",")
-		if len(ips) > 0 {
-			ip := net.ParseIP(strings.TrimSpace(ips[0]))
-			if ip != nil {
-				return ip
-			}
+// ParseCIDRs parses CIDRs and bare IP addresses, which stand for a single
+// host, skipping invalid entries.
+func ParseCIDRs(entries []string) []*net.IPNet {
+	var nets []*net.IPNet
+	for _, entry := range entries {
+		if network, err := ParseCIDR(entry); err == nil {
+			nets = append(nets, network)
 		}
 	}
+	return nets
+}
 
-	host, _, err := net.SplitHostPort(r.RemoteAddr)
-	if err != nil {
-		return net.ParseIP("127.0.0.1")
+// ParseCIDR parses a CIDR or a bare IP address.
+func ParseCIDR(entry string) (*net.IPNet, error) {
+	entry = strings.TrimSpace(entry)
+	if ip := net.ParseIP(entry); ip != nil {
+		bits := 128
+		if ip4 := ip.To4(); ip4 != nil {
+			ip, bits = ip4, 32
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+	_, network, err := net.ParseCIDR(entry)
+	return network, err
+}
+
+func contains(nets []*net.IPNet, ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, network := range nets {
+		if network.Contains(ip) {
+			return true
+		}
 	}
-	return net.ParseIP(host)
+	return false
 }
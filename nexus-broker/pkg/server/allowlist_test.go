@@ -10,53 +10,73 @@ func TestAllowlistMiddleware(t *testing.T) {
 	nextHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 	})
+	keyCIDRs := map[string][]string{"partner-key": {"203.0.113.0/24"}}
 
 	testCases := []struct {
 		name           string
 		require        bool
-		cidrs          string
+		cidrs          []string
 		remoteAddr     string
 		forwardedFor   string
+		apiKey         string
 		expectedStatus int
 	}{
 		{
 			name:           "Not required",
 			require:        false,
-			cidrs:          "",
 			remoteAddr:     "1.1.1.1:12345",
-			forwardedFor:   "",
 			expectedStatus: http.StatusOK,
 		},
 		{
 			name:           "Allowed from RemoteAddr",
 			require:        true,
-			cidrs:          "192.168.1.0/24",
+			cidrs:          []string{"192.168.1.0/24"},
 			remoteAddr:     "192.168.1.10:12345",
-			forwardedFor:   "",
 			expectedStatus: http.StatusOK,
 		},
 		{
 			name:           "Disallowed from RemoteAddr",
 			require:        true,
-			cidrs:          "192.168.1.0/24",
+			cidrs:          []string{"192.168.1.0/24"},
 			remoteAddr:     "10.0.0.5:12345",
-			forwardedFor:   "",
 			expectedStatus: http.StatusForbidden,
 		},
 		{
-			name:           "Allowed from X-Forwarded-For",
+			name:           "Allowed bare IP without port",
 			require:        true,
-			cidrs:          "10.0.0.0/16",
+			cidrs:          []string{"10.0.5.1"},
+			remoteAddr:     "10.0.5.1",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "X-Forwarded-For is not trusted",
+			require:        true,
+			cidrs:          []string{"10.0.0.0/16"},
 			remoteAddr:     "1.1.1.1:12345",
 			forwardedFor:   "10.0.5.1",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "Key allowlist applies without require",
+			require:        false,
+			remoteAddr:     "10.0.0.5:12345",
+			apiKey:         "partner-key",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "Key allowlist replaces the global one",
+			require:        true,
+			cidrs:          []string{"10.0.0.0/8"},
+			remoteAddr:     "203.0.113.9:12345",
+			apiKey:         "partner-key",
 			expectedStatus: http.StatusOK,
 		},
 		{
-			name:           "Disallowed from X-Forwarded-For",
+			name:           "Other keys use the global allowlist",
 			require:        true,
-			cidrs:          "10.0.0.0/16",
-			remoteAddr:     "1.1.1.1:12345",
-			forwardedFor:   "172.16.0.1",
+			cidrs:          []string{"10.0.0.0/8"},
+			remoteAddr:     "203.0.113.9:12345",
+			apiKey:         "internal-key",
 			expectedStatus: http.StatusForbidden,
 		},
 	}
@@ -68,9 +88,12 @@ func TestAllowlistMiddleware(t *testing.T) {
 			if tc.forwardedFor != "" {
 				req.Header.Set("X-Forwarded-For", tc.forwardedFor)
 			}
+			if tc.apiKey != "" {
+				req.Header.Set("X-API-Key", tc.apiKey)
+			}
 
 			rr := httptest.NewRecorder()
-			handler := AllowlistMiddleware(tc.require, tc.cidrs)(nextHandler)
+			handler := AllowlistMiddleware(tc.require, tc.cidrs, keyCIDRs)(nextHandler)
 			handler.ServeHTTP(rr, req)
 
 			if status := rr.Code; status != tc.expectedStatus {
@@ -79,3 +102,22 @@ func TestAllowlistMiddleware(t *testing.T) {
 		})
 	}
 }
+
+func TestParseCIDR(t *testing.T) {
+	for entry, want := range map[string]string{
+		"10.0.0.0/8":  "10.0.0.0/8",
+		"192.0.2.7":   "192.0.2.7/32",
+		"2001:db8::1": "2001:db8::1/128",
+	} {
+		network, err := ParseCIDR(entry)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", entry, err)
+		}
+		if network.String() != want {
+			t.Errorf("%s: expected %s, got %s", entry, want, network)
+		}
+	}
+	if _, err := ParseCIDR("10.0.0.0/33"); err == nil {
+		t.Error("expected error for an invalid CIDR")
+	}
+}
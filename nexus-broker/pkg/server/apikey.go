@@ -1,21 +1,29 @@
 package server
 
 import (
+	"context"
 	"net/http"
 	"strings"
 
 	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/httputil"
 )
 
-// ApiKeyMiddleware enforces X-API-Key header when requireKey is true.
+type apiKeyAuthenticatedKey struct{}
+
+// ApiKeyMiddleware enforces X-API-Key header when requireKey is true. A
+// request carrying a valid key is marked as such for APIKeyAuthenticated,
+// whether or not a key is required.
 func ApiKeyMiddleware(requireKey bool, allowedKeys map[string]struct{}) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := strings.TrimSpace(r.Header.Get("X-API-Key"))
 			if !requireKey {
+				if _, ok := allowedKeys[key]; ok && key != "" {
+					r = r.WithContext(context.WithValue(r.Context(), apiKeyAuthenticatedKey{}, true))
+				}
 				next.ServeHTTP(w, r)
 				return
 			}
-			key := strings.TrimSpace(r.Header.Get("X-API-Key"))
 			if key == "" {
 				httputil.WriteError(w, http.StatusUnauthorized, "missing_api_key", "missing api key")
 				return
@@ -24,7 +32,13 @@ func ApiKeyMiddleware(requireKey bool, allowedKeys map[string]struct{}) func(htt
 				httputil.WriteError(w, http.StatusForbidden, "invalid_api_key", "invalid api key")
 				return
 			}
-			next.ServeHTTP(w, r)
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), apiKeyAuthenticatedKey{}, true)))
 		})
 	}
 }
+
+// APIKeyAuthenticated reports whether ApiKeyMiddleware accepted r's API key.
+func APIKeyAuthenticated(r *http.Request) bool {
+	ok, _ := r.Context().Value(apiKeyAuthenticatedKey{}).(bool)
+	return ok
+}
@@ -70,3 +70,33 @@ func TestApiKeyMiddleware(t *testing.T) {
 		})
 	}
 }
+
+func TestApiKeyMiddleware_MarksAuthenticated(t *testing.T) {
+	keys := map[string]struct{}{"valid-key": {}}
+	for _, tc := range []struct {
+		name    string
+		require bool
+		key     string
+		want    bool
+	}{
+		{name: "required, valid key", require: true, key: "valid-key", want: true},
+		{name: "optional, valid key", require: false, key: "valid-key", want: true},
+		{name: "optional, invalid key", require: false, key: "other-key", want: false},
+		{name: "optional, no key", require: false, want: false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var got bool
+			handler := ApiKeyMiddleware(tc.require, keys)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = APIKeyAuthenticated(r)
+			}))
+			req := httptest.NewRequest("GET", "/", nil)
+			if tc.key != "" {
+				req.Header.Set("X-API-Key", tc.key)
+			}
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+			if got != tc.want {
+				t.Errorf("APIKeyAuthenticated = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
@@ -1,28 +1,44 @@
 package server
 
 import (
+	"context"
+	"errors"
 	"log"
 	"net/http"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-common/problem"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/httputil"
 )
 
 // Server wraps the HTTP server
 type Server struct {
-	router *chi.Mux
-	port   string
+	router     *chi.Mux
+	port       string
+	httpServer *http.Server
+	draining   atomic.Bool
+
+	trustedProxies int
 }
 
-// NewServer creates a new HTTP server
-func NewServer(port string) *Server {
+// NewServer creates a new HTTP server. trustedProxies is the number of
+// reverse proxies in front of it whose X-Forwarded-For entries are believed
+// (see httputil.ClientIP).
+func NewServer(port string, trustedProxies int) *Server {
 	s := &Server{
-		router: chi.NewRouter(),
-		port:   port,
+		router:         chi.NewRouter(),
+		port:           port,
+		trustedProxies: trustedProxies,
 	}
 
+	s.httpServer = &http.Server{Addr: ":" + port, Handler: s.router}
+
 	s.setupMiddleware()
 	return s
 }
@@ -32,8 +48,29 @@ func (s *Server) setupMiddleware() {
 	s.router.Use(middleware.Logger)
 	s.router.Use(middleware.Recoverer)
 	s.router.Use(middleware.Timeout(30 * time.Second))
-	s.router.Use(middleware.RealIP)
+	s.router.Use(s.clientIP)
 	s.router.Use(middleware.RequestID)
+	s.router.Use(problem.RequestID(func(r *http.Request) string { return middleware.GetReqID(r.Context()) }))
+	s.router.NotFound(func(w http.ResponseWriter, r *http.Request) {
+		httputil.WriteError(w, http.StatusNotFound, "not_found", "No route for "+r.URL.Path)
+	})
+	s.router.MethodNotAllowed(func(w http.ResponseWriter, r *http.Request) {
+		httputil.WriteError(w, http.StatusMethodNotAllowed, "method_not_allowed", r.Method+" is not allowed on "+r.URL.Path)
+	})
+}
+
+// clientIP replaces r.RemoteAddr with the client address derived from the
+// trusted proxies' X-Forwarded-For entries, so logs, the allowlist and the
+// audit log agree on who called.
+func (s *Server) clientIP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.trustedProxies > 0 {
+			if ip := httputil.ClientIP(r, s.trustedProxies); ip != nil {
+				r.RemoteAddr = ip.String()
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
 }
 
 // Router returns the chi router for adding routes
@@ -41,10 +78,54 @@ func (s *Server) Router() *chi.Mux {
 	return s.router
 }
 
-// Start starts the HTTP server
+// Start starts the HTTP server and blocks until it stops. It returns nil
+// when the server was stopped by Shutdown.
 func (s *Server) Start() error {
 	log.Printf("Starting OAuth Broker server on port %s", s.port)
-	return http.ListenAndServe(":"+s.port, s.router)
+	if err := s.httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// BeginDrain marks the server as draining: readiness fails and routes wrapped
+// in RejectWhileDraining answer 503, while all other requests keep being served.
+func (s *Server) BeginDrain() {
+	s.draining.Store(true)
+}
+
+// Draining reports whether BeginDrain has been called.
+func (s *Server) Draining() bool {
+	return s.draining.Load()
+}
+
+// Shutdown begins draining and gracefully stops the HTTP server, waiting for
+// in-flight requests (e.g. callback token exchanges) until ctx expires.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.BeginDrain()
+	return s.httpServer.Shutdown(ctx)
+}
+
+// DrainCheck is a readiness check that fails once the server is draining, so
+// load balancers stop routing new traffic before the listener closes.
+func (s *Server) DrainCheck(ctx context.Context) error {
+	if s.Draining() {
+		return errors.New("shutting down")
+	}
+	return nil
+}
+
+// RejectWhileDraining refuses new requests with 503 once the server is
+// draining. Use it for endpoints that start new work, such as consent flows.
+func (s *Server) RejectWhileDraining(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.Draining() {
+			w.Header().Set("Retry-After", "5")
+			httputil.WriteError(w, http.StatusServiceUnavailable, "shutting_down", "Server is shutting down, retry shortly")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
 }
 
 // HealthHandler for health checks
@@ -54,6 +135,34 @@ func HealthHandler(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte(`{"status": "healthy"}`))
 }
 
+// VersionedHealthHandler is HealthHandler that also reports the build
+// version, which the Gateway surfaces in its composite /v1/healthz.
+func VersionedHealthHandler(version string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		httputil.WriteJSON(w, http.StatusOK, map[string]string{"status": "healthy", "version": version})
+	}
+}
+
+// BuildInfo identifies a running binary. Version, GitCommit and BuildDate
+// are set at link time; Features lists the optional behaviour enabled by
+// configuration.
+type BuildInfo struct {
+	Version   string   `json:"version"`
+	GitCommit string   `json:"git_commit"`
+	BuildDate string   `json:"build_date"`
+	Features  []string `json:"features"`
+}
+
+// VersionHandler serves GET /version.
+func VersionHandler(info BuildInfo) http.HandlerFunc {
+	if info.Features == nil {
+		info.Features = []string{}
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		httputil.WriteJSON(w, http.StatusOK, info)
+	}
+}
+
 // MetricsHandler exposes Prometheus metrics
 func MetricsHandler() http.Handler {
 	return promhttp.Handler()
@@ -0,0 +1,112 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/httputil"
+)
+
+func TestRejectWhileDraining(t *testing.T) {
+	s := NewServer("0", 0)
+	h := s.RejectWhileDraining(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("POST", "/auth/consent-spec", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200 before drain, got %d", rr.Code)
+	}
+	if err := s.DrainCheck(context.Background()); err != nil {
+		t.Fatalf("expected ready before drain, got %v", err)
+	}
+
+	s.BeginDrain()
+
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("POST", "/auth/consent-spec", nil))
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 while draining, got %d", rr.Code)
+	}
+	if rr.Header().Get("Retry-After") == "" {
+		t.Error("expected Retry-After header while draining")
+	}
+	if err := s.DrainCheck(context.Background()); err == nil {
+		t.Error("expected drain check to fail while draining")
+	}
+}
+
+func TestShutdownBeforeStart(t *testing.T) {
+	s := NewServer("0", 0)
+	if err := s.Shutdown(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !s.Draining() {
+		t.Error("expected server to be draining after Shutdown")
+	}
+}
+
+func TestClientIPFromTrustedProxy(t *testing.T) {
+	var got string
+	handler := func(w http.ResponseWriter, r *http.Request) { got = r.RemoteAddr }
+	for _, tc := range []struct {
+		trustedProxies int
+		want           string
+	}{
+		{0, "10.0.0.2:4000"},
+		{1, "198.51.100.7"},
+	} {
+		s := NewServer("0", tc.trustedProxies)
+		s.Router().Get("/", handler)
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = "10.0.0.2:4000"
+		req.Header.Set("X-Forwarded-For", "203.0.113.9, 198.51.100.7")
+		s.Router().ServeHTTP(httptest.NewRecorder(), req)
+		if got != tc.want {
+			t.Errorf("trustedProxies=%d: expected RemoteAddr %s, got %s", tc.trustedProxies, tc.want, got)
+		}
+	}
+}
+
+func TestErrorsCarryRequestID(t *testing.T) {
+	s := NewServer("0", 0)
+	s.Router().Get("/providers/{id}", func(w http.ResponseWriter, r *http.Request) {
+		httputil.WriteError(w, http.StatusNotFound, "provider_not_found", "Provider not found")
+	})
+	for path, code := range map[string]string{"/providers/p1": "provider_not_found", "/nope": "not_found"} {
+		rr := httptest.NewRecorder()
+		s.Router().ServeHTTP(rr, httptest.NewRequest("GET", path, nil))
+		var body httputil.APIError
+		if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+			t.Fatalf("%s: decode: %v", path, err)
+		}
+		if rr.Code != http.StatusNotFound || body.Code != code || body.Status != http.StatusNotFound {
+			t.Errorf("%s: unexpected response %d %+v", path, rr.Code, body)
+		}
+		if id := rr.Header().Get("X-Request-ID"); id == "" || body.RequestID != id {
+			t.Errorf("%s: expected request_id %q to match the X-Request-ID header", path, body.RequestID)
+		}
+	}
+}
+
+func TestVersionHandler(t *testing.T) {
+	rr := httptest.NewRecorder()
+	VersionHandler(BuildInfo{Version: "1.4.0", GitCommit: "abc123", BuildDate: "2026-01-02T03:04:05Z"})(rr, httptest.NewRequest("GET", "/version", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rr.Code)
+	}
+	var got map[string]interface{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if got["version"] != "1.4.0" || got["git_commit"] != "abc123" || got["build_date"] != "2026-01-02T03:04:05Z" {
+		t.Errorf("unexpected body: %s", rr.Body.String())
+	}
+	if features, ok := got["features"].([]interface{}); !ok || len(features) != 0 {
+		t.Errorf("expected an empty features list, got %v", got["features"])
+	}
+}
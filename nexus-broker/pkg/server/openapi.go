@@ -0,0 +1,90 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+
+	"gopkg.in/yaml.v3"
+)
+
+// OpenAPIHandler serves spec, an OpenAPI document in YAML, as JSON. The
+// document is converted once, so a malformed spec fails at startup rather
+// than on the first request.
+func OpenAPIHandler(spec []byte) (http.HandlerFunc, error) {
+	body, err := SpecJSON(spec)
+	if err != nil {
+		return nil, err
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(body)
+	}, nil
+}
+
+// SpecJSON converts a YAML OpenAPI document to JSON.
+func SpecJSON(spec []byte) ([]byte, error) {
+	var doc any
+	if err := yaml.Unmarshal(spec, &doc); err != nil {
+		return nil, fmt.Errorf("openapi: parse spec: %w", err)
+	}
+	body, err := json.Marshal(jsonValue(doc))
+	if err != nil {
+		return nil, fmt.Errorf("openapi: encode spec: %w", err)
+	}
+	return body, nil
+}
+
+// jsonValue rewrites the map[any]any values yaml produces for mappings with
+// non-string keys (unquoted status codes) into JSON objects.
+func jsonValue(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, e := range v {
+			v[k] = jsonValue(e)
+		}
+		return v
+	case map[any]any:
+		m := make(map[string]any, len(v))
+		for k, e := range v {
+			m[fmt.Sprint(k)] = jsonValue(e)
+		}
+		return m
+	case []any:
+		for i, e := range v {
+			v[i] = jsonValue(e)
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+var swaggerUI = template.Must(template.New("docs").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+<div id="swagger-ui"></div>
+<script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js" crossorigin></script>
+<script>
+window.onload = function () {
+  window.ui = SwaggerUIBundle({ url: {{.SpecURL}}, dom_id: "#swagger-ui" });
+};
+</script>
+</body>
+</html>
+`))
+
+// SwaggerUIHandler serves a Swagger UI page for the spec at specURL. The
+// page loads Swagger UI from a public CDN; nothing is bundled.
+func SwaggerUIHandler(title, specURL string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_ = swaggerUI.Execute(w, struct{ Title, SpecURL string }{title, specURL})
+	}
+}
@@ -0,0 +1,55 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/api"
+)
+
+func TestOpenAPIHandler(t *testing.T) {
+	h, err := OpenAPIHandler(api.Spec)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	rr := httptest.NewRecorder()
+	h(rr, httptest.NewRequest("GET", "/openapi.json", nil))
+
+	if ct := rr.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("unexpected content type %q", ct)
+	}
+	var doc struct {
+		OpenAPI string                    `json:"openapi"`
+		Paths   map[string]map[string]any `json:"paths"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("body is not JSON: %v", err)
+	}
+	if !strings.HasPrefix(doc.OpenAPI, "3.0.") || doc.Paths["/providers"]["get"] == nil {
+		t.Errorf("unexpected document: %s", doc.OpenAPI)
+	}
+}
+
+func TestSpecJSON(t *testing.T) {
+	body, err := SpecJSON([]byte("openapi: 3.0.3\npaths:\n  /x:\n    get:\n      responses:\n        200: {description: ok}\n"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(string(body), `"200":{"description":"ok"}`) {
+		t.Errorf("numeric keys not converted: %s", body)
+	}
+	if _, err := SpecJSON([]byte("openapi: [")); err == nil {
+		t.Error("expected an error for malformed YAML")
+	}
+}
+
+func TestSwaggerUIHandler(t *testing.T) {
+	rr := httptest.NewRecorder()
+	SwaggerUIHandler("Nexus Broker API", "openapi.json")(rr, httptest.NewRequest("GET", "/docs", nil))
+	body := rr.Body.String()
+	if !strings.Contains(body, "<title>Nexus Broker API</title>") || !strings.Contains(body, `url: "openapi.json"`) {
+		t.Errorf("unexpected page: %s", body)
+	}
+}
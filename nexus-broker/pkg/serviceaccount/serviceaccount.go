@@ -0,0 +1,139 @@
+// Package serviceaccount mints Google access tokens from a service account
+// key with the JWT bearer grant (RFC 7523). With domain-wide delegation
+// enabled for the service account in a Workspace domain, the assertion's
+// sub claim names the user the token acts as, so no consent of that user is
+// needed.
+package serviceaccount
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+)
+
+// KeyParam is the provider param holding the service account's JSON key
+// file. It is a secret of the provider profile.
+const KeyParam = "service_account_key"
+
+// GrantType is the grant_type of a token request carrying an assertion.
+const GrantType = "urn:ietf:params:oauth:grant-type:jwt-bearer"
+
+// DefaultTokenURL is Google's token endpoint, used when neither the provider
+// nor the key names one.
+const DefaultTokenURL = "https://oauth2.googleapis.com/token"
+
+// assertionLifetime is how long an assertion is valid. Google accepts at
+// most an hour.
+const assertionLifetime = time.Hour
+
+// Key is a parsed service account key.
+type Key struct {
+	// ClientEmail is the service account, the issuer of the assertions.
+	ClientEmail string
+	// TokenURI is the token endpoint the key was issued for.
+	TokenURI string
+	signer   jose.Signer
+}
+
+// ParseKey parses the JSON key file Google issues for a service account.
+func ParseKey(data []byte) (*Key, error) {
+	var f struct {
+		Type         string `json:"type"`
+		ClientEmail  string `json:"client_email"`
+		PrivateKeyID string `json:"private_key_id"`
+		PrivateKey   string `json:"private_key"`
+		TokenURI     string `json:"token_uri"`
+	}
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("invalid key file: %w", err)
+	}
+	if f.Type != "" && f.Type != "service_account" {
+		return nil, fmt.Errorf("key type %q is not service_account", f.Type)
+	}
+	if f.ClientEmail == "" {
+		return nil, errors.New("key file has no client_email")
+	}
+	block, _ := pem.Decode([]byte(f.PrivateKey))
+	if block == nil {
+		return nil, errors.New("key file has no PEM private_key")
+	}
+	key, err := parseRSAKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	if f.TokenURI != "" {
+		if u, err := url.Parse(f.TokenURI); err != nil || u.Scheme != "https" || u.Host == "" {
+			return nil, fmt.Errorf("token_uri %q is not an https URL", f.TokenURI)
+		}
+	}
+	signer, err := jose.NewSigner(
+		jose.SigningKey{Algorithm: jose.RS256, Key: jose.JSONWebKey{Key: key, KeyID: f.PrivateKeyID}},
+		(&jose.SignerOptions{}).WithType("JWT"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &Key{ClientEmail: f.ClientEmail, TokenURI: f.TokenURI, signer: signer}, nil
+}
+
+func parseRSAKey(der []byte) (*rsa.PrivateKey, error) {
+	if key, err := x509.ParsePKCS8PrivateKey(der); err == nil {
+		if rsaKey, ok := key.(*rsa.PrivateKey); ok {
+			return rsaKey, nil
+		}
+		return nil, fmt.Errorf("unsupported key type %T", key)
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return key, nil
+	}
+	return nil, errors.New("unrecognized private key")
+}
+
+// Assertion returns an assertion for a token with scopes from the token
+// endpoint audience. A non-empty subject is the user the token acts as;
+// without one the token is the service account's own.
+func (k *Key) Assertion(subject string, scopes []string, audience string, now time.Time) (string, error) {
+	claims := map[string]interface{}{
+		"iss":   k.ClientEmail,
+		"scope": strings.Join(scopes, " "),
+		"aud":   audience,
+		"iat":   now.Unix(),
+		"exp":   now.Add(assertionLifetime).Unix(),
+	}
+	if subject != "" {
+		claims["sub"] = subject
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	obj, err := k.signer.Sign(payload)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign assertion: %w", err)
+	}
+	return obj.CompactSerialize()
+}
+
+// FromParams parses the key in the KeyParam of a provider's params. The key
+// file is stored as a string, so that it is sealed at rest.
+func FromParams(params *json.RawMessage) (*Key, error) {
+	var p map[string]json.RawMessage
+	if params != nil {
+		if err := json.Unmarshal(*params, &p); err != nil {
+			return nil, err
+		}
+	}
+	var raw string
+	if err := json.Unmarshal(p[KeyParam], &raw); err != nil || raw == "" {
+		return nil, errors.New("missing JSON key file string")
+	}
+	return ParseKey([]byte(raw))
+}
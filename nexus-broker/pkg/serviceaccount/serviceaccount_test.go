@@ -0,0 +1,94 @@
+package serviceaccount
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testKeyFile(t *testing.T) ([]byte, *rsa.PrivateKey) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	data, err := json.Marshal(map[string]string{
+		"type":           "service_account",
+		"client_email":   "automation@project.iam.gserviceaccount.com",
+		"private_key_id": "kid-1",
+		"private_key":    string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		"token_uri":      DefaultTokenURL,
+	})
+	require.NoError(t, err)
+	return data, key
+}
+
+func TestAssertion(t *testing.T) {
+	data, priv := testKeyFile(t)
+	key, err := ParseKey(data)
+	require.NoError(t, err)
+	assert.Equal(t, "automation@project.iam.gserviceaccount.com", key.ClientEmail)
+	assert.Equal(t, DefaultTokenURL, key.TokenURI)
+
+	now := time.Unix(1700000000, 0)
+	raw, err := key.Assertion("jane@example.com", []string{"https://www.googleapis.com/auth/gmail.readonly", "openid"}, DefaultTokenURL, now)
+	require.NoError(t, err)
+	jws, err := jose.ParseSigned(raw, []jose.SignatureAlgorithm{jose.RS256})
+	require.NoError(t, err)
+	assert.Equal(t, "kid-1", jws.Signatures[0].Header.KeyID)
+	payload, err := jws.Verify(&priv.PublicKey)
+	require.NoError(t, err)
+	var claims map[string]interface{}
+	require.NoError(t, json.Unmarshal(payload, &claims))
+	assert.Equal(t, "automation@project.iam.gserviceaccount.com", claims["iss"])
+	assert.Equal(t, "jane@example.com", claims["sub"])
+	assert.Equal(t, "https://www.googleapis.com/auth/gmail.readonly openid", claims["scope"])
+	assert.Equal(t, DefaultTokenURL, claims["aud"])
+	assert.EqualValues(t, now.Add(time.Hour).Unix(), claims["exp"])
+
+	// Without a subject the token is the service account's own.
+	raw, err = key.Assertion("", []string{"openid"}, DefaultTokenURL, now)
+	require.NoError(t, err)
+	jws, err = jose.ParseSigned(raw, []jose.SignatureAlgorithm{jose.RS256})
+	require.NoError(t, err)
+	payload, err = jws.Verify(&priv.PublicKey)
+	require.NoError(t, err)
+	assert.NotContains(t, string(payload), `"sub"`)
+}
+
+func TestParseKey_Invalid(t *testing.T) {
+	for name, data := range map[string]string{
+		"not json":        `key`,
+		"wrong type":      `{"type": "authorized_user", "client_email": "a@b"}`,
+		"no client_email": `{"type": "service_account"}`,
+		"no private_key":  `{"type": "service_account", "client_email": "a@b"}`,
+	} {
+		_, err := ParseKey([]byte(data))
+		assert.Error(t, err, name)
+	}
+}
+
+func TestFromParams(t *testing.T) {
+	data, _ := testKeyFile(t)
+	params, err := json.Marshal(map[string]string{KeyParam: string(data)})
+	require.NoError(t, err)
+	raw := json.RawMessage(params)
+	key, err := FromParams(&raw)
+	require.NoError(t, err)
+	assert.Equal(t, "automation@project.iam.gserviceaccount.com", key.ClientEmail)
+
+	// The key file must be a string to be sealed at rest.
+	raw = json.RawMessage(`{"service_account_key": {"type": "service_account"}}`)
+	_, err = FromParams(&raw)
+	assert.Error(t, err)
+	_, err = FromParams(nil)
+	assert.Error(t, err)
+}
@@ -1,6 +1,7 @@
 package storage
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"fmt"
@@ -10,6 +11,8 @@ import (
 	"github.com/jmoiron/sqlx"
 	"github.com/lib/pq"
 	_ "github.com/lib/pq"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/connstate"
 )
 
 type DB struct {
@@ -128,8 +131,7 @@ func (db *DB) GetConnection(id uuid.UUID) (*Connection, error) {
 }
 
 func (db *DB) UpdateConnectionStatus(id uuid.UUID, status string) error {
-	query := `UPDATE connections SET status = $1, updated_at = NOW() WHERE id = $2`
-	_, err := db.Exec(query, status, id)
+	_, err := connstate.Transition(context.Background(), db.DB, id, connstate.State(status))
 	return err
 }
 
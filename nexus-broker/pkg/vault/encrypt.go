@@ -7,51 +7,138 @@ import (
 	"encoding/base64"
 	"errors"
 	"io"
+	"strings"
 )
 
+// tokenPrefix marks token ciphertexts sealed with SealToken. Unprefixed
+// values were written by Encrypt before tokens were bound to a connection.
+const tokenPrefix = "v2:"
+
+// secretPrefix marks provider secrets sealed with SealSecret. Column values
+// without it are plaintext written before secrets were encrypted.
+const secretPrefix = "enc:v1:"
+
+// ErrLegacyCiphertext is returned by OpenToken when a ciphertext predates
+// connection binding and legacy values are not allowed.
+var ErrLegacyCiphertext = errors.New("token ciphertext is not bound to a connection")
+
 // Encrypt encrypts plaintext using AES-GCM with the provided key
 // Returns base64-encoded ciphertext that includes nonce
 func Encrypt(key []byte, plaintext []byte) (string, error) {
-	if len(key) != 32 {
-		return "", errors.New("encryption key must be 32 bytes")
-	}
+	return seal(key, plaintext, nil)
+}
 
-	block, err := aes.NewCipher(key)
+// Decrypt decrypts base64-encoded ciphertext using AES-GCM
+func Decrypt(key []byte, ciphertext string) ([]byte, error) {
+	return open(key, ciphertext, nil)
+}
+
+// TokenAAD is the additional authenticated data binding a token ciphertext
+// to its connection and workspace.
+func TokenAAD(connectionID, workspaceID string) []byte {
+	return []byte("nexus-token|" + connectionID + "|" + workspaceID)
+}
+
+// SealToken encrypts token JSON for storage. The ciphertext only decrypts
+// for the same connection and workspace, so a row copied to another
+// connection fails authentication instead of leaking credentials.
+func SealToken(key, plaintext []byte, connectionID, workspaceID string) (string, error) {
+	ct, err := seal(key, plaintext, TokenAAD(connectionID, workspaceID))
 	if err != nil {
 		return "", err
 	}
+	return tokenPrefix + ct, nil
+}
 
-	gcm, err := cipher.NewGCM(block)
+// OpenToken decrypts a stored token ciphertext for the given connection.
+// Ciphertexts written before connection binding are accepted only when
+// allowLegacy is true; see cmd/migrate-token-aad for re-encrypting them.
+func OpenToken(key []byte, ciphertext, connectionID, workspaceID string, allowLegacy bool) ([]byte, error) {
+	if IsConnectionBound(ciphertext) {
+		return open(key, strings.TrimPrefix(ciphertext, tokenPrefix), TokenAAD(connectionID, workspaceID))
+	}
+	if !allowLegacy {
+		return nil, ErrLegacyCiphertext
+	}
+	return open(key, ciphertext, nil)
+}
+
+// IsConnectionBound reports whether ciphertext was written by SealToken.
+func IsConnectionBound(ciphertext string) bool {
+	return strings.HasPrefix(ciphertext, tokenPrefix)
+}
+
+// SecretAAD is the additional authenticated data binding a provider secret
+// ciphertext to the column it is stored in.
+func SecretAAD(column string) []byte {
+	return []byte("nexus-provider-secret|" + column)
+}
+
+// SealSecret encrypts a provider secret for storage in column. An empty
+// plaintext is returned unchanged, so that a cleared secret stays empty.
+func SealSecret(key []byte, plaintext, column string) (string, error) {
+	if plaintext == "" {
+		return "", nil
+	}
+	ct, err := seal(key, []byte(plaintext), SecretAAD(column))
 	if err != nil {
 		return "", err
 	}
+	return secretPrefix + ct, nil
+}
 
-	nonce := make([]byte, gcm.NonceSize())
-	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+// OpenSecret decrypts a provider secret read from column. Values that were
+// not sealed by SealSecret are returned as they are.
+func OpenSecret(key []byte, stored, column string) (string, error) {
+	if !IsSealedSecret(stored) {
+		return stored, nil
+	}
+	pt, err := open(key, strings.TrimPrefix(stored, secretPrefix), SecretAAD(column))
+	if err != nil {
 		return "", err
 	}
+	return string(pt), nil
+}
 
-	ciphertext := gcm.Seal(nonce, nonce, plaintext, nil)
-	return base64.StdEncoding.EncodeToString(ciphertext), nil
+// IsSealedSecret reports whether stored was written by SealSecret.
+func IsSealedSecret(stored string) bool {
+	return strings.HasPrefix(stored, secretPrefix)
 }
 
-// Decrypt decrypts base64-encoded ciphertext using AES-GCM
-func Decrypt(key []byte, ciphertext string) ([]byte, error) {
+func newGCM(key []byte) (cipher.AEAD, error) {
 	if len(key) != 32 {
 		return nil, errors.New("encryption key must be 32 bytes")
 	}
 
-	data, err := base64.StdEncoding.DecodeString(ciphertext)
+	block, err := aes.NewCipher(key)
 	if err != nil {
 		return nil, err
 	}
+	return cipher.NewGCM(block)
+}
 
-	block, err := aes.NewCipher(key)
+func seal(key, plaintext, aad []byte) (string, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+
+	ciphertext := gcm.Seal(nonce, nonce, plaintext, aad)
+	return base64.StdEncoding.EncodeToString(ciphertext), nil
+}
+
+func open(key []byte, ciphertext string, aad []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
 	if err != nil {
 		return nil, err
 	}
 
-	gcm, err := cipher.NewGCM(block)
+	data, err := base64.StdEncoding.DecodeString(ciphertext)
 	if err != nil {
 		return nil, err
 	}
@@ -64,10 +151,5 @@ func Decrypt(key []byte, ciphertext string) ([]byte, error) {
 	nonce := data[:nonceSize]
 	ciphertextBytes := data[nonceSize:]
 
-	plaintext, err := gcm.Open(nil, nonce, ciphertextBytes, nil)
-	if err != nil {
-		return nil, err
-	}
-
-	return plaintext, nil
+	return gcm.Open(nil, nonce, ciphertextBytes, aad)
 }
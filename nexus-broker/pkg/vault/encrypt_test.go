@@ -0,0 +1,82 @@
+package vault
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+var testKey = []byte("01234567890123456789012345678901")
+
+func TestSealToken_RoundTrip(t *testing.T) {
+	ct, err := SealToken(testKey, []byte(`{"access_token":"a"}`), "conn-1", "ws-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsConnectionBound(ct) {
+		t.Fatalf("expected connection-bound ciphertext, got %q", ct)
+	}
+	pt, err := OpenToken(testKey, ct, "conn-1", "ws-1", false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(pt) != `{"access_token":"a"}` {
+		t.Errorf("unexpected plaintext %q", pt)
+	}
+}
+
+func TestOpenToken_RejectsOtherConnection(t *testing.T) {
+	ct, err := SealToken(testKey, []byte("secret"), "conn-1", "ws-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenToken(testKey, ct, "conn-2", "ws-1", true); err == nil {
+		t.Error("expected failure when ciphertext is moved to another connection")
+	}
+	if _, err := OpenToken(testKey, ct, "conn-1", "ws-2", true); err == nil {
+		t.Error("expected failure when ciphertext is moved to another workspace")
+	}
+}
+
+func TestOpenToken_Legacy(t *testing.T) {
+	ct, err := Encrypt(testKey, []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pt, err := OpenToken(testKey, ct, "conn-1", "ws-1", true)
+	if err != nil || string(pt) != "secret" {
+		t.Fatalf("expected legacy ciphertext to open, got %q, %v", pt, err)
+	}
+	if _, err := OpenToken(testKey, ct, "conn-1", "ws-1", false); !errors.Is(err, ErrLegacyCiphertext) {
+		t.Errorf("expected ErrLegacyCiphertext, got %v", err)
+	}
+}
+
+func TestSealSecret_RoundTrip(t *testing.T) {
+	ct, err := SealSecret(testKey, "s3cret", "client_secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsSealedSecret(ct) || strings.Contains(ct, "s3cret") {
+		t.Fatalf("expected sealed secret, got %q", ct)
+	}
+	pt, err := OpenSecret(testKey, ct, "client_secret")
+	if err != nil || pt != "s3cret" {
+		t.Fatalf("expected secret to open, got %q, %v", pt, err)
+	}
+	if _, err := OpenSecret(testKey, ct, "params"); err == nil {
+		t.Error("expected failure when ciphertext is moved to another column")
+	}
+}
+
+func TestOpenSecret_Plaintext(t *testing.T) {
+	for _, v := range []string{"", "legacy-plaintext"} {
+		pt, err := OpenSecret(testKey, v, "client_secret")
+		if err != nil || pt != v {
+			t.Errorf("OpenSecret(%q) = %q, %v; want it unchanged", v, pt, err)
+		}
+	}
+	if ct, _ := SealSecret(testKey, "", "client_secret"); ct != "" {
+		t.Errorf("expected empty secret to stay empty, got %q", ct)
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// apiError is a non-2xx answer from the Broker or Gateway.
+type apiError struct {
+	Status    int
+	Code      string
+	Message   string
+	RequestID string
+}
+
+func (e *apiError) Error() string {
+	msg := fmt.Sprintf("status %d", e.Status)
+	if e.Code != "" {
+		msg += ": " + e.Code
+	}
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	if e.RequestID != "" {
+		msg += " (request " + e.RequestID + ")"
+	}
+	return msg
+}
+
+// broker calls the Broker with the API key.
+func (c *cliContext) broker(method, path string, body, out interface{}) error {
+	return call(method, c.BrokerURL+path, c.APIKey, body, out)
+}
+
+// gateway calls the Gateway with the caller API key, if any.
+func (c *cliContext) gateway(method, path string, body, out interface{}) error {
+	return call(method, c.GatewayURL+path, c.GatewayAPIKey, body, out)
+}
+
+// call sends body as JSON and decodes a 2xx response into out. Error bodies,
+// problem details or the older {"error", "message"|"detail"}, become an
+// *apiError.
+func call(method, url, apiKey string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, url, reader)
+	if err != nil {
+		return err
+	}
+	setAPIKey(req, apiKey)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		e := &apiError{Status: resp.StatusCode, RequestID: resp.Header.Get("X-Request-ID")}
+		var env struct {
+			Code      string `json:"code"`
+			Error     string `json:"error"`
+			Detail    string `json:"detail"`
+			Message   string `json:"message"`
+			RequestID string `json:"request_id"`
+		}
+		if json.Unmarshal(data, &env) == nil {
+			e.Code, e.Message = env.Code, env.Detail
+			if e.Code == "" {
+				e.Code = env.Error
+			}
+			if e.Message == "" {
+				e.Message = env.Message
+			}
+			if env.RequestID != "" {
+				e.RequestID = env.RequestID
+			}
+		} else {
+			e.Message = string(bytes.TrimSpace(data))
+		}
+		return e
+	}
+	if out == nil || len(data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, out)
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strconv"
+	"time"
+)
+
+type auditEvent struct {
+	ID           string    `json:"id"`
+	ConnectionID string    `json:"connection_id,omitempty"`
+	EventType    string    `json:"event_type"`
+	EventData    string    `json:"event_data,omitempty"`
+	IPAddress    string    `json:"ip_address,omitempty"`
+	UserAgent    string    `json:"user_agent,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+func runAudit(args []string) error {
+	if len(args) == 0 || args[0] != "tail" {
+		return usageError("audit tail [-n 20] [--type <event_type>] [--follow]")
+	}
+	var n int
+	var eventType string
+	var follow bool
+	var interval time.Duration
+	c, _, err := parseCommand("audit tail", args[1:], func(fs *flag.FlagSet) {
+		fs.IntVar(&n, "n", 20, "Number of recent events to show (max 1000)")
+		fs.StringVar(&eventType, "type", "", "Only events of this type")
+		fs.BoolVar(&follow, "follow", false, "Keep polling for new events")
+		fs.DurationVar(&interval, "interval", 2*time.Second, "Polling interval with --follow")
+	})
+	if err != nil {
+		return err
+	}
+
+	fetch := func(since time.Time, limit int) ([]auditEvent, error) {
+		q := url.Values{"limit": {strconv.Itoa(limit)}}
+		if eventType != "" {
+			q.Set("event_type", eventType)
+		}
+		if !since.IsZero() {
+			q.Set("since", since.Format(time.RFC3339))
+		}
+		var events []auditEvent
+		if err := c.broker(http.MethodGet, "/audit?"+q.Encode(), nil, &events); err != nil {
+			return nil, err
+		}
+		// The Broker returns newest first; print oldest first like tail.
+		for i, j := 0, len(events)-1; i < j; i, j = i+1, j-1 {
+			events[i], events[j] = events[j], events[i]
+		}
+		return events, nil
+	}
+
+	events, err := fetch(time.Time{}, n)
+	if err != nil {
+		return err
+	}
+	if !follow {
+		var rows [][]string
+		for _, e := range events {
+			rows = append(rows, auditRow(e))
+		}
+		return c.render(events, []string{"TIME", "EVENT", "CONNECTION", "IP", "DATA"}, rows)
+	}
+
+	// Following prints one event per line (NDJSON with -o json). since has
+	// second precision, so events at the last second seen are fetched
+	// again and skipped by ID.
+	print := func(e auditEvent) {
+		if c.Output == "json" {
+			_ = json.NewEncoder(os.Stdout).Encode(e)
+			return
+		}
+		r := auditRow(e)
+		fmt.Printf("%s  %-28s %-36s %-15s %s\n", r[0], r[1], r[2], r[3], r[4])
+	}
+	var last time.Time
+	seen := map[string]bool{}
+	advance := func(events []auditEvent) {
+		for _, e := range events {
+			if seen[e.ID] {
+				continue
+			}
+			print(e)
+			if sec := e.CreatedAt.Truncate(time.Second); sec.After(last) {
+				last, seen = sec, map[string]bool{}
+			}
+			seen[e.ID] = true
+		}
+	}
+	advance(events)
+	if last.IsZero() {
+		last = time.Now().UTC().Truncate(time.Second)
+	}
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return nil
+		case <-ticker.C:
+		}
+		events, err := fetch(last, 1000)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "audit tail: %v\n", err)
+			continue
+		}
+		advance(events)
+	}
+}
+
+func auditRow(e auditEvent) []string {
+	data := e.EventData
+	if len(data) > 80 {
+		data = data[:77] + "..."
+	}
+	return []string{e.CreatedAt.Local().Format(time.RFC3339), e.EventType, e.ConnectionID, e.IPAddress, data}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Profile is a named set of endpoints and keys in the config file.
+type Profile struct {
+	Gateway       string `yaml:"gateway,omitempty"`
+	Broker        string `yaml:"broker,omitempty"`
+	APIKey        string `yaml:"api_key,omitempty"`
+	GatewayAPIKey string `yaml:"gateway_api_key,omitempty"`
+}
+
+// Config is the nexusctl config file:
+//
+//	current_profile: prod
+//	profiles:
+//	  prod:
+//	    gateway: https://gateway.example.com
+//	    broker: https://broker.internal
+//	    api_key: ${NEXUS_PROD_API_KEY}
+//
+// Values may reference environment variables, so keys need not be stored in
+// the file.
+type Config struct {
+	CurrentProfile string             `yaml:"current_profile,omitempty"`
+	Profiles       map[string]Profile `yaml:"profiles"`
+}
+
+// configPath is $NEXUSCTL_CONFIG, or nexusctl/config.yaml in the user's
+// config directory.
+func configPath() string {
+	if p := os.Getenv("NEXUSCTL_CONFIG"); p != "" {
+		return p
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "nexusctl", "config.yaml")
+}
+
+// loadConfig reads path; a missing file is an empty config.
+func loadConfig(path string) (*Config, error) {
+	cfg := &Config{}
+	if path == "" {
+		return cfg, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal([]byte(os.ExpandEnv(string(data))), cfg); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// globalFlags are accepted by every subcommand.
+type globalFlags struct {
+	config        string
+	profile       string
+	gateway       string
+	broker        string
+	apiKey        string
+	gatewayAPIKey string
+	output        string
+}
+
+func addGlobalFlags(fs *flag.FlagSet) *globalFlags {
+	g := &globalFlags{}
+	fs.StringVar(&g.config, "config", "", "Config file (default $NEXUSCTL_CONFIG or <user config dir>/nexusctl/config.yaml)")
+	fs.StringVar(&g.profile, "profile", "", "Profile from the config file (default current_profile)")
+	fs.StringVar(&g.gateway, "gateway", "", "Gateway base URL")
+	fs.StringVar(&g.broker, "broker", "", "Broker base URL")
+	fs.StringVar(&g.apiKey, "api-key", "", "Broker API key")
+	fs.StringVar(&g.gatewayAPIKey, "gateway-api-key", "", "Gateway caller API key")
+	fs.StringVar(&g.output, "o", "table", "Output format: table or json")
+	fs.StringVar(&g.output, "output", "table", "Output format: table or json")
+	return g
+}
+
+// cliContext is the resolved target of a command.
+type cliContext struct {
+	GatewayURL    string
+	BrokerURL     string
+	APIKey        string
+	GatewayAPIKey string
+	Output        string
+}
+
+// resolve applies, in order of precedence, the flags, the environment
+// (GATEWAY_BASE_URL, BROKER_BASE_URL, API_KEY, GATEWAY_API_KEY), the
+// selected profile and the local defaults.
+func (g *globalFlags) resolve() (*cliContext, error) {
+	path := g.config
+	if path == "" {
+		path = configPath()
+	}
+	cfg, err := loadConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	name := g.profile
+	if name == "" {
+		name = cfg.CurrentProfile
+	}
+	var p Profile
+	if name != "" {
+		var ok bool
+		if p, ok = cfg.Profiles[name]; !ok {
+			return nil, fmt.Errorf("profile %q not found in %s", name, path)
+		}
+	}
+	pick := func(flagVal, env, profileVal, def string) string {
+		for _, v := range []string{flagVal, os.Getenv(env), profileVal} {
+			if v != "" {
+				return v
+			}
+		}
+		return def
+	}
+	ctx := &cliContext{
+		GatewayURL:    strings.TrimRight(pick(g.gateway, "GATEWAY_BASE_URL", p.Gateway, "http://localhost:8090"), "/"),
+		BrokerURL:     strings.TrimRight(pick(g.broker, "BROKER_BASE_URL", p.Broker, "http://localhost:8080"), "/"),
+		APIKey:        pick(g.apiKey, "API_KEY", p.APIKey, ""),
+		GatewayAPIKey: pick(g.gatewayAPIKey, "GATEWAY_API_KEY", p.GatewayAPIKey, ""),
+		Output:        g.output,
+	}
+	if ctx.Output != "table" && ctx.Output != "json" {
+		return nil, fmt.Errorf("unknown output format %q (want table or json)", ctx.Output)
+	}
+	return ctx, nil
+}
+
+// parseCommand parses args for the subcommand name with its own flags and
+// the global ones, returning the resolved context and positional args.
+func parseCommand(name string, args []string, define func(fs *flag.FlagSet)) (*cliContext, []string, error) {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	g := addGlobalFlags(fs)
+	if define != nil {
+		define(fs)
+	}
+	// Flags may follow positional arguments: nexusctl token get <id> -o json.
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, nil, err
+		}
+		if args = fs.Args(); len(args) == 0 {
+			break
+		}
+		positional, args = append(positional, args[0]), args[1:]
+	}
+	ctx, err := g.resolve()
+	if err != nil {
+		return nil, nil, err
+	}
+	return ctx, positional, nil
+}
@@ -28,6 +28,11 @@ func computeDrift(desired Provider, live map[string]interface{}) (bool, map[stri
 		if k == "name" || k == "id" || k == "created_at" || k == "updated_at" {
 			continue
 		}
+		// The broker never returns the client secret, only whether one is
+		// set, so a stored secret cannot drift.
+		if k == "client_secret" && live["client_secret_set"] == true {
+			continue
+		}
 
 		liveVal, exists := live[k]
 
@@ -10,6 +10,7 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"path/filepath"
 	"sort"
 	"strings"
 	"sync"
@@ -33,6 +34,9 @@ type Provider struct {
 	EnableDiscovery bool                   `yaml:"enable_discovery" json:"enable_discovery"`
 	Scopes          []string               `yaml:"scopes" json:"scopes"`
 	APIBaseURL      string                 `yaml:"api_base_url,omitempty" json:"api_base_url,omitempty"`
+	UserInfoURL     string                 `yaml:"user_info_endpoint,omitempty" json:"user_info_endpoint,omitempty"`
+	Description     string                 `yaml:"description,omitempty" json:"description,omitempty"`
+	Category        string                 `yaml:"category,omitempty" json:"category,omitempty"`
 	Params          map[string]interface{} `yaml:"params,omitempty" json:"params,omitempty"`
 }
 
@@ -40,26 +44,66 @@ type Manifest struct {
 	Providers []Provider `yaml:"providers"`
 }
 
+const usage = `Usage: nexusctl <command> [options]
+
+Commands:
+  providers list                       List registered providers
+  providers register -f <file>         Register a provider profile
+  providers delete <name|id>           Delete a provider
+  providers validate -f <file>         Run preflight checks on a provider profile
+  providers plan|apply -f <manifest>   Reconcile providers with a manifest
+  connections list --workspace --user  List a user's connections
+  connections inspect <id>             Show a connection's token status (no secrets)
+  connections refresh <id>             Force a token refresh
+  connections revoke <id>              Revoke a connection and delete its token
+  token get <id> [--reveal]            Print a connection's token (masked by default)
+  audit tail [-n 20] [--follow]        Show recent audit events
+  version [--remote]                   Print the CLI version (--remote checks Gateway/Broker skew)
+
+Every command accepts --profile, --config, --gateway, --broker, --api-key,
+--gateway-api-key and -o table|json. plan and apply remain available at the
+top level.
+`
+
+// usageError reports a malformed command line.
+type usageError string
+
+func (e usageError) Error() string { return "usage: nexusctl " + string(e) }
+
 func main() {
 	if len(os.Args) < 2 {
-		fmt.Println("Usage: nexus-cli <command> [options]")
-		fmt.Println("Commands:")
-		fmt.Println("  plan     Show execution plan without making changes")
-		fmt.Println("  apply    Apply provider configurations from a manifest")
+		fmt.Print(usage)
 		os.Exit(1)
 	}
 
-	command := os.Args[1]
+	command, args := os.Args[1], os.Args[2:]
 
+	var err error
 	switch command {
 	case "plan":
-		runCommand(true)
+		runCommand(true, command, args)
 	case "apply":
-		runCommand(false)
+		runCommand(false, command, args)
+	case "version":
+		runVersion(args)
+	case "providers":
+		err = runProviders(args)
+	case "connections":
+		err = runConnections(args)
+	case "token":
+		err = runToken(args)
+	case "audit":
+		err = runAudit(args)
+	case "help", "-h", "--help":
+		fmt.Print(usage)
 	default:
 		fmt.Printf("Unknown command: %s\n", command)
 		os.Exit(1)
 	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
 }
 
 // setAPIKey sets the X-API-Key header on a request, matching the Broker's ApiKeyMiddleware.
@@ -69,44 +113,30 @@ func setAPIKey(req *http.Request, apiKey string) {
 	}
 }
 
-func runCommand(isPlanOnly bool) {
-	cmdFlags := flag.NewFlagSet(os.Args[1], flag.ExitOnError)
-	fileFlag := cmdFlags.String("file", "nexus-providers.yaml", "Path to the providers manifest file")
-	pruneFlag := cmdFlags.Bool("prune", false, "Delete providers not in the manifest")
-
-	if err := cmdFlags.Parse(os.Args[2:]); err != nil {
+func runCommand(isPlanOnly bool, name string, args []string) {
+	var fileFlag string
+	var pruneFlag, autoApprove bool
+	c, _, err := parseCommand(name, args, func(fs *flag.FlagSet) {
+		fs.StringVar(&fileFlag, "file", "nexus-providers.yaml", "Path to the providers manifest file")
+		fs.StringVar(&fileFlag, "f", "nexus-providers.yaml", "Shorthand for --file")
+		fs.BoolVar(&pruneFlag, "prune", false, "Delete providers not in the manifest")
+		fs.BoolVar(&autoApprove, "auto-approve", false, "Apply without asking for confirmation")
+	})
+	if err != nil {
 		log.Fatalf("Failed to parse flags: %v", err)
 	}
-
-	brokerURL := os.Getenv("BROKER_BASE_URL")
-	if brokerURL == "" {
-		brokerURL = "http://localhost:8080"
-	}
-	apiKey := os.Getenv("API_KEY")
+	brokerURL, apiKey := c.BrokerURL, c.APIKey
 
 	// Read Manifest
-	data, err := os.ReadFile(*fileFlag)
+	data, err := os.ReadFile(fileFlag)
 	if err != nil {
-		log.Fatalf("Failed to read manifest file %s: %v", *fileFlag, err)
+		log.Fatalf("Failed to read manifest file %s: %v", fileFlag, err)
 	}
 
-	// Expand environment variables
-	var missingVars []string
-	missingSet := make(map[string]bool)
-
-	expandedData := os.Expand(string(data), func(envVar string) string {
-		val, exists := os.LookupEnv(envVar)
-		if !exists {
-			if !missingSet[envVar] {
-				missingSet[envVar] = true
-				missingVars = append(missingVars, envVar)
-			}
-		}
-		return val
-	})
-
-	if len(missingVars) > 0 {
-		log.Fatalf("Failed to process manifest. The following environment variables are unset: %s", strings.Join(missingVars, ", "))
+	// Expand secret references
+	expandedData, err := expandRefs(string(data), filepath.Dir(fileFlag))
+	if err != nil {
+		log.Fatalf("Failed to process manifest. %v", err)
 	}
 
 	var manifest Manifest
@@ -114,10 +144,22 @@ func runCommand(isPlanOnly bool) {
 		log.Fatalf("Failed to parse YAML manifest: %v", err)
 	}
 
-	fmt.Printf("Read %d providers from %s\n", len(manifest.Providers), *fileFlag)
+	fmt.Printf("Read %d providers from %s\n", len(manifest.Providers), fileFlag)
+
+	// Providers are matched by name, so a duplicate would be applied twice.
+	seenNames := make(map[string]bool)
+	for _, p := range manifest.Providers {
+		if p.Name == "" {
+			log.Fatalf("Manifest %s has a provider without a name", fileFlag)
+		}
+		if seenNames[p.Name] {
+			log.Fatalf("Manifest %s declares provider %s more than once", fileFlag, p.Name)
+		}
+		seenNames[p.Name] = true
+	}
 
 	// Fetch current live state
-	req, err := http.NewRequest("GET", brokerURL+"/providers", nil)
+	req, err := http.NewRequest("GET", brokerURL+"/providers?all_workspaces=true", nil)
 	if err != nil {
 		log.Fatalf("Failed to create request: %v", err)
 	}
@@ -149,6 +191,7 @@ func runCommand(isPlanOnly bool) {
 	var fetchErr error
 
 	liveProviderMap := make(map[string]map[string]interface{})
+	liveETags := make(map[string]string) // map ID to the ETag the plan is based on
 
 	for _, lp := range liveProviders {
 		name, nameOk := lp["name"].(string)
@@ -199,6 +242,7 @@ func runCommand(isPlanOnly bool) {
 
 			mu.Lock()
 			liveProviderMap[name] = fullProfile
+			liveETags[id] = respProfile.Header.Get("ETag")
 			mu.Unlock()
 		}(name, id)
 	}
@@ -261,7 +305,7 @@ func runCommand(isPlanOnly bool) {
 
 	for name, live := range liveProviderMap {
 		if _, exists := manifestProviderMap[name]; !exists {
-			if *pruneFlag {
+			if pruneFlag {
 				id, ok := live["id"].(string)
 				if !ok {
 					log.Fatalf("Provider %s has invalid or missing 'id' in live state", name)
@@ -281,21 +325,23 @@ func runCommand(isPlanOnly bool) {
 	}
 
 	if isPlanOnly {
-		fmt.Println("\nPlan complete. Run 'nexus-cli apply' to perform these actions.")
+		fmt.Println("\nPlan complete. Run 'nexusctl providers apply' to perform these actions.")
 		return
 	}
 
-	fmt.Print("\nDo you want to perform these actions?\n  Nexus will perform the actions described above.\n  Only 'yes' will be accepted to approve.\n\n  Enter a value: ")
+	if !autoApprove {
+		fmt.Print("\nDo you want to perform these actions?\n  Nexus will perform the actions described above.\n  Only 'yes' will be accepted to approve.\n\n  Enter a value: ")
 
-	reader := bufio.NewReader(os.Stdin)
-	confirmation, err := reader.ReadString('\n')
-	if err != nil {
-		log.Fatalf("Failed to read input: %v", err)
-	}
+		reader := bufio.NewReader(os.Stdin)
+		confirmation, err := reader.ReadString('\n')
+		if err != nil {
+			log.Fatalf("Failed to read input: %v", err)
+		}
 
-	if strings.TrimSpace(confirmation) != "yes" {
-		fmt.Println("\nApply cancelled.")
-		return
+		if strings.TrimSpace(confirmation) != "yes" {
+			fmt.Println("\nApply cancelled.")
+			return
+		}
 	}
 
 	fmt.Println("\n--- Applying Changes ---")
@@ -361,7 +407,11 @@ func runCommand(isPlanOnly bool) {
 			continue
 		}
 		setAPIKey(req, apiKey)
-		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Content-Type", "application/merge-patch+json")
+		// Refuse the update if the provider changed after the plan was made.
+		if etag := liveETags[id]; etag != "" {
+			req.Header.Set("If-Match", etag)
+		}
 
 		resp, err := httpClient.Do(req)
 		if err != nil {
@@ -373,6 +423,10 @@ func runCommand(isPlanOnly bool) {
 		if resp.StatusCode == http.StatusOK {
 			resp.Body.Close()
 			fmt.Println("OK")
+		} else if resp.StatusCode == http.StatusPreconditionFailed {
+			resp.Body.Close()
+			fmt.Println("FAILED: changed by someone else since the plan was made; run sync again")
+			hadFailures = true
 		} else {
 			errBody, _ := io.ReadAll(resp.Body)
 			resp.Body.Close()
@@ -417,7 +471,6 @@ func runCommand(isPlanOnly bool) {
 	}
 }
 
-
 // isSecretField returns true for fields that should be masked in plan output.
 func isSecretField(field string) bool {
 	switch field {
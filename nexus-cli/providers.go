@@ -0,0 +1,259 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+func runProviders(args []string) error {
+	if len(args) == 0 {
+		return usageError("providers list|register|delete|validate|plan|apply")
+	}
+	switch args[0] {
+	case "list":
+		return providersList(args[1:])
+	case "register":
+		return providersRegister(args[1:])
+	case "delete":
+		return providersDelete(args[1:])
+	case "validate":
+		return providersValidate(args[1:])
+	case "plan":
+		runCommand(true, "providers plan", args[1:])
+		return nil
+	case "apply":
+		runCommand(false, "providers apply", args[1:])
+		return nil
+	}
+	return fmt.Errorf("unknown providers command %q", args[0])
+}
+
+func providersList(args []string) error {
+	var workspace string
+	var includeDeleted bool
+	c, _, err := parseCommand("providers list", args, func(fs *flag.FlagSet) {
+		fs.StringVar(&workspace, "workspace", "", "Only global providers and those restricted to this workspace")
+		fs.BoolVar(&includeDeleted, "include-deleted", false, "Also list soft-deleted providers")
+	})
+	if err != nil {
+		return err
+	}
+	q := url.Values{}
+	if workspace != "" {
+		q.Set("workspace_id", workspace)
+	} else {
+		q.Set("all_workspaces", "true")
+	}
+	var metadata map[string]map[string]map[string]interface{}
+	if err := c.broker(http.MethodGet, "/providers/metadata?"+q.Encode(), nil, &metadata); err != nil {
+		return err
+	}
+	if includeDeleted {
+		q.Set("include_deleted", "true")
+	}
+	var providers []map[string]interface{}
+	if err := c.broker(http.MethodGet, "/providers?"+q.Encode(), nil, &providers); err != nil {
+		return err
+	}
+
+	// The metadata groups providers by auth type; index it by ID.
+	byID := map[string]map[string]interface{}{}
+	for authType, group := range metadata {
+		for _, m := range group {
+			m["auth_type"] = authType
+			byID[str(m["id"])] = m
+		}
+	}
+	sort.Slice(providers, func(i, j int) bool { return str(providers[i]["name"]) < str(providers[j]["name"]) })
+	var rows [][]string
+	for _, p := range providers {
+		m := byID[str(p["id"])]
+		if m != nil {
+			p["auth_type"], p["scopes"], p["api_base_url"] = m["auth_type"], m["scopes"], m["api_base_url"]
+		}
+		rows = append(rows, []string{str(p["name"]), str(p["id"]), str(p["auth_type"]), str(p["scopes"]), str(p["deleted_at"])})
+	}
+	return c.render(providers, []string{"NAME", "ID", "AUTH TYPE", "SCOPES", "DELETED AT"}, rows)
+}
+
+func providersRegister(args []string) error {
+	var file string
+	c, _, err := parseCommand("providers register", args, func(fs *flag.FlagSet) {
+		fs.StringVar(&file, "f", "", "Provider profile (YAML or JSON); ${VAR} references are expanded")
+	})
+	if err != nil {
+		return err
+	}
+	profile, err := readProfileFile(file)
+	if err != nil {
+		return err
+	}
+	var out map[string]interface{}
+	if err := c.broker(http.MethodPost, "/providers", map[string]interface{}{"profile": profile}, &out); err != nil {
+		return err
+	}
+	return c.render(out, []string{"NAME", "ID"}, [][]string{{str(profile["name"]), str(out["id"])}})
+}
+
+func providersDelete(args []string) error {
+	c, rest, err := parseCommand("providers delete", args, nil)
+	if err != nil {
+		return err
+	}
+	if len(rest) != 1 {
+		return usageError("providers delete <name|id>")
+	}
+	id, err := c.providerID(rest[0])
+	if err != nil {
+		return err
+	}
+	var out map[string]interface{}
+	if err := c.broker(http.MethodDelete, "/providers/"+url.PathEscape(id), nil, &out); err != nil {
+		return err
+	}
+	return c.render(out, []string{"ID", "RESULT"}, [][]string{{id, "deleted"}})
+}
+
+func providersValidate(args []string) error {
+	var file string
+	c, _, err := parseCommand("providers validate", args, func(fs *flag.FlagSet) {
+		fs.StringVar(&file, "f", "", "Provider profile (YAML or JSON) to check before registering it")
+	})
+	if err != nil {
+		return err
+	}
+	profile, err := readProfileFile(file)
+	if err != nil {
+		return err
+	}
+	var report struct {
+		Valid       bool   `json:"valid"`
+		RedirectURI string `json:"redirect_uri"`
+		TestAuthURL string `json:"test_auth_url,omitempty"`
+		Checks      []struct {
+			Name    string `json:"name"`
+			Status  string `json:"status"`
+			Message string `json:"message"`
+		} `json:"checks"`
+	}
+	if err := c.broker(http.MethodPost, "/providers/validate", map[string]interface{}{"profile": profile}, &report); err != nil {
+		return err
+	}
+	var rows [][]string
+	for _, check := range report.Checks {
+		rows = append(rows, []string{check.Name, check.Status, check.Message})
+	}
+	if err := c.render(report, []string{"CHECK", "STATUS", "MESSAGE"}, rows); err != nil {
+		return err
+	}
+	if !report.Valid {
+		return errors.New("provider profile is not valid")
+	}
+	return nil
+}
+
+// providerID returns nameOrID if it is a provider ID, else looks the name up.
+func (c *cliContext) providerID(nameOrID string) (string, error) {
+	if isUUID(nameOrID) {
+		return nameOrID, nil
+	}
+	var out struct {
+		ID string `json:"id"`
+	}
+	if err := c.broker(http.MethodGet, "/providers/by-name/"+url.PathEscape(nameOrID)+"?all_workspaces=true", nil, &out); err != nil {
+		return "", fmt.Errorf("provider %s: %w", nameOrID, err)
+	}
+	return out.ID, nil
+}
+
+// readProfileFile reads a single provider profile, expanding its secret
+// references (see expandRefs).
+func readProfileFile(path string) (map[string]interface{}, error) {
+	if path == "" {
+		return nil, errors.New("-f <profile file> is required")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	expanded, err := expandRefs(string(data), filepath.Dir(path))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	var profile map[string]interface{}
+	if err := yaml.Unmarshal([]byte(expanded), &profile); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return profile, nil
+}
+
+// expandRefs expands the secret references of a manifest or profile:
+// ${VAR} and ${env:VAR} are replaced by the environment variable and
+// ${file:path} by the contents of the file, without the trailing newline,
+// so mounted secrets (Kubernetes, Docker) can be used directly. Relative
+// paths are resolved against baseDir. Every unset variable and unreadable
+// file is reported.
+func expandRefs(data, baseDir string) (string, error) {
+	var missing, unreadable []string
+	seen := map[string]bool{}
+	expanded := os.Expand(data, func(ref string) string {
+		if path, ok := strings.CutPrefix(ref, "file:"); ok {
+			if !filepath.IsAbs(path) {
+				path = filepath.Join(baseDir, path)
+			}
+			b, err := os.ReadFile(path)
+			if err != nil {
+				if !seen[ref] {
+					seen[ref] = true
+					unreadable = append(unreadable, err.Error())
+				}
+				return ""
+			}
+			return strings.TrimRight(string(b), "\r\n")
+		}
+		name := strings.TrimPrefix(ref, "env:")
+		val, ok := os.LookupEnv(name)
+		if !ok && !seen[ref] {
+			seen[ref] = true
+			missing = append(missing, name)
+		}
+		return val
+	})
+	var errs []string
+	if len(missing) > 0 {
+		errs = append(errs, "the following environment variables are unset: "+strings.Join(missing, ", "))
+	}
+	if len(unreadable) > 0 {
+		errs = append(errs, "the following secret files cannot be read: "+strings.Join(unreadable, "; "))
+	}
+	if len(errs) > 0 {
+		return "", errors.New(strings.Join(errs, "; "))
+	}
+	return expanded, nil
+}
+
+func isUUID(s string) bool {
+	if len(s) != 36 {
+		return false
+	}
+	for i, r := range s {
+		switch {
+		case i == 8 || i == 13 || i == 18 || i == 23:
+			if r != '-' {
+				return false
+			}
+		case !strings.ContainsRune("0123456789abcdefABCDEF", r):
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+	"net/url"
+)
+
+// secretFields are masked in token output unless --reveal is given.
+var secretFields = map[string]bool{
+	"access_token":  true,
+	"refresh_token": true,
+	"id_token":      true,
+	"api_key":       true,
+	"password":      true,
+	"client_secret": true,
+}
+
+func runToken(args []string) error {
+	if len(args) == 0 || args[0] != "get" {
+		return usageError("token get <connection_id> [--reveal]")
+	}
+	var reveal bool
+	c, rest, err := parseCommand("token get", args[1:], func(fs *flag.FlagSet) {
+		fs.BoolVar(&reveal, "reveal", false, "Print secrets instead of masking them")
+	})
+	if err != nil {
+		return err
+	}
+	if len(rest) != 1 {
+		return usageError("token get <connection_id> [--reveal]")
+	}
+	var tok map[string]interface{}
+	if err := c.broker(http.MethodGet, "/connections/"+url.PathEscape(rest[0])+"/token", nil, &tok); err != nil {
+		return err
+	}
+	if !reveal {
+		maskSecrets(tok)
+	}
+	var strategy interface{}
+	if s, ok := tok["strategy"].(map[string]interface{}); ok {
+		strategy = s["type"]
+	}
+	rows := [][]string{{rest[0], str(strategy), str(tok["access_token"]), str(tok["expires_at"])}}
+	return c.render(tok, []string{"CONNECTION", "STRATEGY", "ACCESS TOKEN", "EXPIRES AT"}, rows)
+}
+
+// publicCredentialFields are the only string credentials shown unmasked:
+// a static credential's other fields (username, custom headers) may be
+// secret too.
+var publicCredentialFields = map[string]bool{"token_type": true, "scope": true, "expires_at": true}
+
+// maskSecrets masks secret fields in tok and in its credentials map.
+func maskSecrets(tok map[string]interface{}) {
+	for k, v := range tok {
+		if s, ok := v.(string); ok && secretFields[k] {
+			tok[k] = mask(s)
+		}
+	}
+	if creds, ok := tok["credentials"].(map[string]interface{}); ok {
+		for k, v := range creds {
+			if s, ok := v.(string); ok && !publicCredentialFields[k] {
+				creds[k] = mask(s)
+			}
+		}
+	}
+}
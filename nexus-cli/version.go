@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Version is the CLI release, set at build time with
+// -ldflags "-X main.Version=...".
+var Version = "dev"
+
+// buildInfo mirrors the Gateway's GET /version response.
+type buildInfo struct {
+	Version     string     `json:"version"`
+	GitCommit   string     `json:"git_commit"`
+	BuildDate   string     `json:"build_date"`
+	Features    []string   `json:"features"`
+	Broker      *buildInfo `json:"broker,omitempty"`
+	BrokerError string     `json:"broker_error,omitempty"`
+}
+
+func runVersion(args []string) {
+	var remote bool
+	c, _, err := parseCommand("version", args, func(fs *flag.FlagSet) {
+		fs.BoolVar(&remote, "remote", false, "Also report the Gateway and Broker versions and warn about skew")
+	})
+	if err != nil {
+		log.Fatalf("Failed to parse flags: %v", err)
+	}
+
+	fmt.Printf("nexusctl %s\n", Version)
+	if !remote {
+		return
+	}
+
+	resp, err := httpClient.Get(c.GatewayURL + "/version")
+	if err != nil {
+		log.Fatalf("Failed to fetch Gateway version: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		log.Fatalf("Failed to fetch Gateway version: status %d", resp.StatusCode)
+	}
+
+	var info buildInfo
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		log.Fatalf("Failed to decode Gateway version: %v", err)
+	}
+
+	printBuildInfo("gateway", &info)
+	if info.Broker == nil {
+		fmt.Printf("WARNING: Gateway could not reach the Broker: %s\n", info.BrokerError)
+		os.Exit(1)
+	}
+	printBuildInfo("broker", info.Broker)
+
+	g, gok := majorMinor(info.Version)
+	b, bok := majorMinor(info.Broker.Version)
+	if gok && bok && g != b {
+		fmt.Printf("WARNING: version skew: Gateway %s and Broker %s differ in major.minor version\n", info.Version, info.Broker.Version)
+		os.Exit(1)
+	}
+}
+
+func printBuildInfo(name string, info *buildInfo) {
+	fmt.Printf("%-8s %s (commit %s, built %s)\n", name, info.Version, info.GitCommit, info.BuildDate)
+	if len(info.Features) > 0 {
+		fmt.Printf("         features: %s\n", strings.Join(info.Features, ", "))
+	}
+}
+
+// majorMinor returns "1.4" for "v1.4.2" or "1.4.0-rc.1". Versions such as
+// "dev" are not comparable and report false.
+func majorMinor(v string) (string, bool) {
+	parts := strings.SplitN(strings.TrimPrefix(strings.TrimSpace(v), "v"), ".", 3)
+	if len(parts) < 2 {
+		return "", false
+	}
+	for _, p := range parts[:2] {
+		if p == "" || strings.Trim(p, "0123456789") != "" {
+			return "", false
+		}
+	}
+	return parts[0] + "." + parts[1], true
+}
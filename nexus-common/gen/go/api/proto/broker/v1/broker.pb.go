@@ -0,0 +1,1030 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.11
+// 	protoc        (unknown)
+// source: api/proto/broker/v1/broker.proto
+
+package brokerpb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	structpb "google.golang.org/protobuf/types/known/structpb"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type ConsentSpecRequest struct {
+	state       protoimpl.MessageState `protogen:"open.v1"`
+	WorkspaceId string                 `protobuf:"bytes,1,opt,name=workspace_id,json=workspaceId,proto3" json:"workspace_id,omitempty"`
+	ProviderId  string                 `protobuf:"bytes,2,opt,name=provider_id,json=providerId,proto3" json:"provider_id,omitempty"`
+	Scopes      []string               `protobuf:"bytes,3,rep,name=scopes,proto3" json:"scopes,omitempty"`
+	ReturnUrl   string                 `protobuf:"bytes,4,opt,name=return_url,json=returnUrl,proto3" json:"return_url,omitempty"`
+	// Azure AD tenant to authorize against: common, organizations, consumers,
+	// a tenant ID or a domain. Empty uses the provider's configured tenant.
+	Tenant string `protobuf:"bytes,5,opt,name=tenant,proto3" json:"tenant,omitempty"`
+	// Email of the user a service account connection acts as through
+	// domain-wide delegation. Empty makes tokens the service account's own.
+	Subject string `protobuf:"bytes,6,opt,name=subject,proto3" json:"subject,omitempty"`
+	// Hash of the browser session the flow is bound to (see
+	// state.HashSession). The callback then completes only for that session.
+	SessionHash   string `protobuf:"bytes,7,opt,name=session_hash,json=sessionHash,proto3" json:"session_hash,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ConsentSpecRequest) Reset() {
+	*x = ConsentSpecRequest{}
+	mi := &file_api_proto_broker_v1_broker_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ConsentSpecRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ConsentSpecRequest) ProtoMessage() {}
+
+func (x *ConsentSpecRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_api_proto_broker_v1_broker_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ConsentSpecRequest.ProtoReflect.Descriptor instead.
+func (*ConsentSpecRequest) Descriptor() ([]byte, []int) {
+	return file_api_proto_broker_v1_broker_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *ConsentSpecRequest) GetWorkspaceId() string {
+	if x != nil {
+		return x.WorkspaceId
+	}
+	return ""
+}
+
+func (x *ConsentSpecRequest) GetProviderId() string {
+	if x != nil {
+		return x.ProviderId
+	}
+	return ""
+}
+
+func (x *ConsentSpecRequest) GetScopes() []string {
+	if x != nil {
+		return x.Scopes
+	}
+	return nil
+}
+
+func (x *ConsentSpecRequest) GetReturnUrl() string {
+	if x != nil {
+		return x.ReturnUrl
+	}
+	return ""
+}
+
+func (x *ConsentSpecRequest) GetTenant() string {
+	if x != nil {
+		return x.Tenant
+	}
+	return ""
+}
+
+func (x *ConsentSpecRequest) GetSubject() string {
+	if x != nil {
+		return x.Subject
+	}
+	return ""
+}
+
+func (x *ConsentSpecRequest) GetSessionHash() string {
+	if x != nil {
+		return x.SessionHash
+	}
+	return ""
+}
+
+type ConsentSpecResponse struct {
+	state   protoimpl.MessageState `protogen:"open.v1"`
+	AuthUrl string                 `protobuf:"bytes,1,opt,name=auth_url,json=authUrl,proto3" json:"auth_url,omitempty"`
+	// Signed state; its nonce is the new connection's ID.
+	State         string   `protobuf:"bytes,2,opt,name=state,proto3" json:"state,omitempty"`
+	Scopes        []string `protobuf:"bytes,3,rep,name=scopes,proto3" json:"scopes,omitempty"`
+	ProviderId    string   `protobuf:"bytes,4,opt,name=provider_id,json=providerId,proto3" json:"provider_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ConsentSpecResponse) Reset() {
+	*x = ConsentSpecResponse{}
+	mi := &file_api_proto_broker_v1_broker_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ConsentSpecResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ConsentSpecResponse) ProtoMessage() {}
+
+func (x *ConsentSpecResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_api_proto_broker_v1_broker_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ConsentSpecResponse.ProtoReflect.Descriptor instead.
+func (*ConsentSpecResponse) Descriptor() ([]byte, []int) {
+	return file_api_proto_broker_v1_broker_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *ConsentSpecResponse) GetAuthUrl() string {
+	if x != nil {
+		return x.AuthUrl
+	}
+	return ""
+}
+
+func (x *ConsentSpecResponse) GetState() string {
+	if x != nil {
+		return x.State
+	}
+	return ""
+}
+
+func (x *ConsentSpecResponse) GetScopes() []string {
+	if x != nil {
+		return x.Scopes
+	}
+	return nil
+}
+
+func (x *ConsentSpecResponse) GetProviderId() string {
+	if x != nil {
+		return x.ProviderId
+	}
+	return ""
+}
+
+type GetTokenRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	ConnectionId  string                 `protobuf:"bytes,1,opt,name=connection_id,json=connectionId,proto3" json:"connection_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetTokenRequest) Reset() {
+	*x = GetTokenRequest{}
+	mi := &file_api_proto_broker_v1_broker_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetTokenRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetTokenRequest) ProtoMessage() {}
+
+func (x *GetTokenRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_api_proto_broker_v1_broker_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetTokenRequest.ProtoReflect.Descriptor instead.
+func (*GetTokenRequest) Descriptor() ([]byte, []int) {
+	return file_api_proto_broker_v1_broker_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *GetTokenRequest) GetConnectionId() string {
+	if x != nil {
+		return x.ConnectionId
+	}
+	return ""
+}
+
+type RefreshRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	ConnectionId  string                 `protobuf:"bytes,1,opt,name=connection_id,json=connectionId,proto3" json:"connection_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RefreshRequest) Reset() {
+	*x = RefreshRequest{}
+	mi := &file_api_proto_broker_v1_broker_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RefreshRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RefreshRequest) ProtoMessage() {}
+
+func (x *RefreshRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_api_proto_broker_v1_broker_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RefreshRequest.ProtoReflect.Descriptor instead.
+func (*RefreshRequest) Descriptor() ([]byte, []int) {
+	return file_api_proto_broker_v1_broker_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *RefreshRequest) GetConnectionId() string {
+	if x != nil {
+		return x.ConnectionId
+	}
+	return ""
+}
+
+type GetConnectionStatusRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	ConnectionId  string                 `protobuf:"bytes,1,opt,name=connection_id,json=connectionId,proto3" json:"connection_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetConnectionStatusRequest) Reset() {
+	*x = GetConnectionStatusRequest{}
+	mi := &file_api_proto_broker_v1_broker_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetConnectionStatusRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetConnectionStatusRequest) ProtoMessage() {}
+
+func (x *GetConnectionStatusRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_api_proto_broker_v1_broker_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetConnectionStatusRequest.ProtoReflect.Descriptor instead.
+func (*GetConnectionStatusRequest) Descriptor() ([]byte, []int) {
+	return file_api_proto_broker_v1_broker_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *GetConnectionStatusRequest) GetConnectionId() string {
+	if x != nil {
+		return x.ConnectionId
+	}
+	return ""
+}
+
+type ConnectionStatusResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// pending, active, needs_reauth, suspended, revoked, ...
+	Status string `protobuf:"bytes,1,opt,name=status,proto3" json:"status,omitempty"`
+	// Why the connection is in status, e.g. refresh_failing (retry later) or
+	// reauth_required (the user must consent again).
+	Reason string `protobuf:"bytes,2,opt,name=reason,proto3" json:"reason,omitempty"`
+	// Why a failed consent failed, e.g. token_exchange_failed.
+	ErrorCode     string `protobuf:"bytes,3,opt,name=error_code,json=errorCode,proto3" json:"error_code,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ConnectionStatusResponse) Reset() {
+	*x = ConnectionStatusResponse{}
+	mi := &file_api_proto_broker_v1_broker_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ConnectionStatusResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ConnectionStatusResponse) ProtoMessage() {}
+
+func (x *ConnectionStatusResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_api_proto_broker_v1_broker_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ConnectionStatusResponse.ProtoReflect.Descriptor instead.
+func (*ConnectionStatusResponse) Descriptor() ([]byte, []int) {
+	return file_api_proto_broker_v1_broker_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *ConnectionStatusResponse) GetStatus() string {
+	if x != nil {
+		return x.Status
+	}
+	return ""
+}
+
+func (x *ConnectionStatusResponse) GetReason() string {
+	if x != nil {
+		return x.Reason
+	}
+	return ""
+}
+
+func (x *ConnectionStatusResponse) GetErrorCode() string {
+	if x != nil {
+		return x.ErrorCode
+	}
+	return ""
+}
+
+type TokenResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Token         *Token                 `protobuf:"bytes,1,opt,name=token,proto3" json:"token,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *TokenResponse) Reset() {
+	*x = TokenResponse{}
+	mi := &file_api_proto_broker_v1_broker_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *TokenResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TokenResponse) ProtoMessage() {}
+
+func (x *TokenResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_api_proto_broker_v1_broker_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TokenResponse.ProtoReflect.Descriptor instead.
+func (*TokenResponse) Descriptor() ([]byte, []int) {
+	return file_api_proto_broker_v1_broker_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *TokenResponse) GetToken() *Token {
+	if x != nil {
+		return x.Token
+	}
+	return nil
+}
+
+// Token is a connection's token: the fields of the REST TokenResponse.
+type Token struct {
+	state        protoimpl.MessageState `protogen:"open.v1"`
+	AccessToken  string                 `protobuf:"bytes,1,opt,name=access_token,json=accessToken,proto3" json:"access_token,omitempty"`
+	TokenType    string                 `protobuf:"bytes,2,opt,name=token_type,json=tokenType,proto3" json:"token_type,omitempty"`
+	RefreshToken string                 `protobuf:"bytes,3,opt,name=refresh_token,json=refreshToken,proto3" json:"refresh_token,omitempty"`
+	IdToken      string                 `protobuf:"bytes,4,opt,name=id_token,json=idToken,proto3" json:"id_token,omitempty"`
+	// When the access token expires. Unset when the provider did not say.
+	Expiry *timestamppb.Timestamp `protobuf:"bytes,5,opt,name=expiry,proto3" json:"expiry,omitempty"`
+	// How the credentials are attached to API requests. Set by GetToken only.
+	Strategy *AuthStrategy `protobuf:"bytes,6,opt,name=strategy,proto3" json:"strategy,omitempty"`
+	// The decrypted credentials, whose fields depend on the provider's auth
+	// type. Set by GetToken only.
+	Credentials   *structpb.Struct `protobuf:"bytes,7,opt,name=credentials,proto3" json:"credentials,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Token) Reset() {
+	*x = Token{}
+	mi := &file_api_proto_broker_v1_broker_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Token) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Token) ProtoMessage() {}
+
+func (x *Token) ProtoReflect() protoreflect.Message {
+	mi := &file_api_proto_broker_v1_broker_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Token.ProtoReflect.Descriptor instead.
+func (*Token) Descriptor() ([]byte, []int) {
+	return file_api_proto_broker_v1_broker_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *Token) GetAccessToken() string {
+	if x != nil {
+		return x.AccessToken
+	}
+	return ""
+}
+
+func (x *Token) GetTokenType() string {
+	if x != nil {
+		return x.TokenType
+	}
+	return ""
+}
+
+func (x *Token) GetRefreshToken() string {
+	if x != nil {
+		return x.RefreshToken
+	}
+	return ""
+}
+
+func (x *Token) GetIdToken() string {
+	if x != nil {
+		return x.IdToken
+	}
+	return ""
+}
+
+func (x *Token) GetExpiry() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Expiry
+	}
+	return nil
+}
+
+func (x *Token) GetStrategy() *AuthStrategy {
+	if x != nil {
+		return x.Strategy
+	}
+	return nil
+}
+
+func (x *Token) GetCredentials() *structpb.Struct {
+	if x != nil {
+		return x.Credentials
+	}
+	return nil
+}
+
+type AuthStrategy struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// oauth2, header, basic_auth, ...
+	Type string `protobuf:"bytes,1,opt,name=type,proto3" json:"type,omitempty"`
+	// Settings of the strategy, e.g. header_name for header, or dpop_jwk (the
+	// connection's DPoP key as a private JWK) for a DPoP-bound oauth2 token.
+	Config        *structpb.Struct `protobuf:"bytes,2,opt,name=config,proto3" json:"config,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AuthStrategy) Reset() {
+	*x = AuthStrategy{}
+	mi := &file_api_proto_broker_v1_broker_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AuthStrategy) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AuthStrategy) ProtoMessage() {}
+
+func (x *AuthStrategy) ProtoReflect() protoreflect.Message {
+	mi := &file_api_proto_broker_v1_broker_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AuthStrategy.ProtoReflect.Descriptor instead.
+func (*AuthStrategy) Descriptor() ([]byte, []int) {
+	return file_api_proto_broker_v1_broker_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *AuthStrategy) GetType() string {
+	if x != nil {
+		return x.Type
+	}
+	return ""
+}
+
+func (x *AuthStrategy) GetConfig() *structpb.Struct {
+	if x != nil {
+		return x.Config
+	}
+	return nil
+}
+
+type ResolveProviderRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Matched case-insensitively.
+	Name string `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	// Matches only global providers and those restricted to this workspace.
+	// Empty matches global providers only.
+	WorkspaceId   string `protobuf:"bytes,2,opt,name=workspace_id,json=workspaceId,proto3" json:"workspace_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ResolveProviderRequest) Reset() {
+	*x = ResolveProviderRequest{}
+	mi := &file_api_proto_broker_v1_broker_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ResolveProviderRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ResolveProviderRequest) ProtoMessage() {}
+
+func (x *ResolveProviderRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_api_proto_broker_v1_broker_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ResolveProviderRequest.ProtoReflect.Descriptor instead.
+func (*ResolveProviderRequest) Descriptor() ([]byte, []int) {
+	return file_api_proto_broker_v1_broker_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *ResolveProviderRequest) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *ResolveProviderRequest) GetWorkspaceId() string {
+	if x != nil {
+		return x.WorkspaceId
+	}
+	return ""
+}
+
+type ResolveProviderResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	ProviderId    string                 `protobuf:"bytes,1,opt,name=provider_id,json=providerId,proto3" json:"provider_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ResolveProviderResponse) Reset() {
+	*x = ResolveProviderResponse{}
+	mi := &file_api_proto_broker_v1_broker_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ResolveProviderResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ResolveProviderResponse) ProtoMessage() {}
+
+func (x *ResolveProviderResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_api_proto_broker_v1_broker_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ResolveProviderResponse.ProtoReflect.Descriptor instead.
+func (*ResolveProviderResponse) Descriptor() ([]byte, []int) {
+	return file_api_proto_broker_v1_broker_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *ResolveProviderResponse) GetProviderId() string {
+	if x != nil {
+		return x.ProviderId
+	}
+	return ""
+}
+
+type GetMetadataRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Limits the result to global providers and those restricted to this
+	// workspace. Empty returns global providers only.
+	WorkspaceId   string `protobuf:"bytes,1,opt,name=workspace_id,json=workspaceId,proto3" json:"workspace_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetMetadataRequest) Reset() {
+	*x = GetMetadataRequest{}
+	mi := &file_api_proto_broker_v1_broker_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetMetadataRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetMetadataRequest) ProtoMessage() {}
+
+func (x *GetMetadataRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_api_proto_broker_v1_broker_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetMetadataRequest.ProtoReflect.Descriptor instead.
+func (*GetMetadataRequest) Descriptor() ([]byte, []int) {
+	return file_api_proto_broker_v1_broker_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *GetMetadataRequest) GetWorkspaceId() string {
+	if x != nil {
+		return x.WorkspaceId
+	}
+	return ""
+}
+
+type GetMetadataResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Sorted by auth type, then name.
+	Providers     []*ProviderMetadata `protobuf:"bytes,1,rep,name=providers,proto3" json:"providers,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetMetadataResponse) Reset() {
+	*x = GetMetadataResponse{}
+	mi := &file_api_proto_broker_v1_broker_proto_msgTypes[12]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetMetadataResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetMetadataResponse) ProtoMessage() {}
+
+func (x *GetMetadataResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_api_proto_broker_v1_broker_proto_msgTypes[12]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetMetadataResponse.ProtoReflect.Descriptor instead.
+func (*GetMetadataResponse) Descriptor() ([]byte, []int) {
+	return file_api_proto_broker_v1_broker_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *GetMetadataResponse) GetProviders() []*ProviderMetadata {
+	if x != nil {
+		return x.Providers
+	}
+	return nil
+}
+
+// ProviderMetadata is a provider's entry in the GET /providers/metadata
+// document, which groups them by auth type and then by name.
+type ProviderMetadata struct {
+	state            protoimpl.MessageState `protogen:"open.v1"`
+	Id               string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Name             string                 `protobuf:"bytes,2,opt,name=name,proto3" json:"name,omitempty"`
+	AuthType         string                 `protobuf:"bytes,3,opt,name=auth_type,json=authType,proto3" json:"auth_type,omitempty"`
+	ApiBaseUrl       string                 `protobuf:"bytes,4,opt,name=api_base_url,json=apiBaseUrl,proto3" json:"api_base_url,omitempty"`
+	UserInfoEndpoint string                 `protobuf:"bytes,5,opt,name=user_info_endpoint,json=userInfoEndpoint,proto3" json:"user_info_endpoint,omitempty"`
+	Scopes           []string               `protobuf:"bytes,6,rep,name=scopes,proto3" json:"scopes,omitempty"`
+	Description      string                 `protobuf:"bytes,7,opt,name=description,proto3" json:"description,omitempty"`
+	Category         string                 `protobuf:"bytes,8,opt,name=category,proto3" json:"category,omitempty"`
+	unknownFields    protoimpl.UnknownFields
+	sizeCache        protoimpl.SizeCache
+}
+
+func (x *ProviderMetadata) Reset() {
+	*x = ProviderMetadata{}
+	mi := &file_api_proto_broker_v1_broker_proto_msgTypes[13]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ProviderMetadata) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ProviderMetadata) ProtoMessage() {}
+
+func (x *ProviderMetadata) ProtoReflect() protoreflect.Message {
+	mi := &file_api_proto_broker_v1_broker_proto_msgTypes[13]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ProviderMetadata.ProtoReflect.Descriptor instead.
+func (*ProviderMetadata) Descriptor() ([]byte, []int) {
+	return file_api_proto_broker_v1_broker_proto_rawDescGZIP(), []int{13}
+}
+
+func (x *ProviderMetadata) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *ProviderMetadata) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *ProviderMetadata) GetAuthType() string {
+	if x != nil {
+		return x.AuthType
+	}
+	return ""
+}
+
+func (x *ProviderMetadata) GetApiBaseUrl() string {
+	if x != nil {
+		return x.ApiBaseUrl
+	}
+	return ""
+}
+
+func (x *ProviderMetadata) GetUserInfoEndpoint() string {
+	if x != nil {
+		return x.UserInfoEndpoint
+	}
+	return ""
+}
+
+func (x *ProviderMetadata) GetScopes() []string {
+	if x != nil {
+		return x.Scopes
+	}
+	return nil
+}
+
+func (x *ProviderMetadata) GetDescription() string {
+	if x != nil {
+		return x.Description
+	}
+	return ""
+}
+
+func (x *ProviderMetadata) GetCategory() string {
+	if x != nil {
+		return x.Category
+	}
+	return ""
+}
+
+var File_api_proto_broker_v1_broker_proto protoreflect.FileDescriptor
+
+const file_api_proto_broker_v1_broker_proto_rawDesc = "" +
+	"\n" +
+	" api/proto/broker/v1/broker.proto\x12\x0fnexus.broker.v1\x1a\x1cgoogle/protobuf/struct.proto\x1a\x1fgoogle/protobuf/timestamp.proto\"\xe4\x01\n" +
+	"\x12ConsentSpecRequest\x12!\n" +
+	"\fworkspace_id\x18\x01 \x01(\tR\vworkspaceId\x12\x1f\n" +
+	"\vprovider_id\x18\x02 \x01(\tR\n" +
+	"providerId\x12\x16\n" +
+	"\x06scopes\x18\x03 \x03(\tR\x06scopes\x12\x1d\n" +
+	"\n" +
+	"return_url\x18\x04 \x01(\tR\treturnUrl\x12\x16\n" +
+	"\x06tenant\x18\x05 \x01(\tR\x06tenant\x12\x18\n" +
+	"\asubject\x18\x06 \x01(\tR\asubject\x12!\n" +
+	"\fsession_hash\x18\a \x01(\tR\vsessionHash\"\x7f\n" +
+	"\x13ConsentSpecResponse\x12\x19\n" +
+	"\bauth_url\x18\x01 \x01(\tR\aauthUrl\x12\x14\n" +
+	"\x05state\x18\x02 \x01(\tR\x05state\x12\x16\n" +
+	"\x06scopes\x18\x03 \x03(\tR\x06scopes\x12\x1f\n" +
+	"\vprovider_id\x18\x04 \x01(\tR\n" +
+	"providerId\"6\n" +
+	"\x0fGetTokenRequest\x12#\n" +
+	"\rconnection_id\x18\x01 \x01(\tR\fconnectionId\"5\n" +
+	"\x0eRefreshRequest\x12#\n" +
+	"\rconnection_id\x18\x01 \x01(\tR\fconnectionId\"A\n" +
+	"\x1aGetConnectionStatusRequest\x12#\n" +
+	"\rconnection_id\x18\x01 \x01(\tR\fconnectionId\"i\n" +
+	"\x18ConnectionStatusResponse\x12\x16\n" +
+	"\x06status\x18\x01 \x01(\tR\x06status\x12\x16\n" +
+	"\x06reason\x18\x02 \x01(\tR\x06reason\x12\x1d\n" +
+	"\n" +
+	"error_code\x18\x03 \x01(\tR\terrorCode\"=\n" +
+	"\rTokenResponse\x12,\n" +
+	"\x05token\x18\x01 \x01(\v2\x16.nexus.broker.v1.TokenR\x05token\"\xb3\x02\n" +
+	"\x05Token\x12!\n" +
+	"\faccess_token\x18\x01 \x01(\tR\vaccessToken\x12\x1d\n" +
+	"\n" +
+	"token_type\x18\x02 \x01(\tR\ttokenType\x12#\n" +
+	"\rrefresh_token\x18\x03 \x01(\tR\frefreshToken\x12\x19\n" +
+	"\bid_token\x18\x04 \x01(\tR\aidToken\x122\n" +
+	"\x06expiry\x18\x05 \x01(\v2\x1a.google.protobuf.TimestampR\x06expiry\x129\n" +
+	"\bstrategy\x18\x06 \x01(\v2\x1d.nexus.broker.v1.AuthStrategyR\bstrategy\x129\n" +
+	"\vcredentials\x18\a \x01(\v2\x17.google.protobuf.StructR\vcredentials\"S\n" +
+	"\fAuthStrategy\x12\x12\n" +
+	"\x04type\x18\x01 \x01(\tR\x04type\x12/\n" +
+	"\x06config\x18\x02 \x01(\v2\x17.google.protobuf.StructR\x06config\"O\n" +
+	"\x16ResolveProviderRequest\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\x12!\n" +
+	"\fworkspace_id\x18\x02 \x01(\tR\vworkspaceId\":\n" +
+	"\x17ResolveProviderResponse\x12\x1f\n" +
+	"\vprovider_id\x18\x01 \x01(\tR\n" +
+	"providerId\"7\n" +
+	"\x12GetMetadataRequest\x12!\n" +
+	"\fworkspace_id\x18\x01 \x01(\tR\vworkspaceId\"V\n" +
+	"\x13GetMetadataResponse\x12?\n" +
+	"\tproviders\x18\x01 \x03(\v2!.nexus.broker.v1.ProviderMetadataR\tproviders\"\xf9\x01\n" +
+	"\x10ProviderMetadata\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x12\n" +
+	"\x04name\x18\x02 \x01(\tR\x04name\x12\x1b\n" +
+	"\tauth_type\x18\x03 \x01(\tR\bauthType\x12 \n" +
+	"\fapi_base_url\x18\x04 \x01(\tR\n" +
+	"apiBaseUrl\x12,\n" +
+	"\x12user_info_endpoint\x18\x05 \x01(\tR\x10userInfoEndpoint\x12\x16\n" +
+	"\x06scopes\x18\x06 \x03(\tR\x06scopes\x12 \n" +
+	"\vdescription\x18\a \x01(\tR\vdescription\x12\x1a\n" +
+	"\bcategory\x18\b \x01(\tR\bcategory2\xb2\x04\n" +
+	"\rBrokerService\x12X\n" +
+	"\vConsentSpec\x12#.nexus.broker.v1.ConsentSpecRequest\x1a$.nexus.broker.v1.ConsentSpecResponse\x12L\n" +
+	"\bGetToken\x12 .nexus.broker.v1.GetTokenRequest\x1a\x1e.nexus.broker.v1.TokenResponse\x12J\n" +
+	"\aRefresh\x12\x1f.nexus.broker.v1.RefreshRequest\x1a\x1e.nexus.broker.v1.TokenResponse\x12m\n" +
+	"\x13GetConnectionStatus\x12+.nexus.broker.v1.GetConnectionStatusRequest\x1a).nexus.broker.v1.ConnectionStatusResponse\x12d\n" +
+	"\x0fResolveProvider\x12'.nexus.broker.v1.ResolveProviderRequest\x1a(.nexus.broker.v1.ResolveProviderResponse\x12X\n" +
+	"\vGetMetadata\x12#.nexus.broker.v1.GetMetadataRequest\x1a$.nexus.broker.v1.GetMetadataResponseB[ZYgithub.com/Prescott-Data/nexus-framework/nexus-common/gen/go/api/proto/broker/v1;brokerpbb\x06proto3"
+
+var (
+	file_api_proto_broker_v1_broker_proto_rawDescOnce sync.Once
+	file_api_proto_broker_v1_broker_proto_rawDescData []byte
+)
+
+func file_api_proto_broker_v1_broker_proto_rawDescGZIP() []byte {
+	file_api_proto_broker_v1_broker_proto_rawDescOnce.Do(func() {
+		file_api_proto_broker_v1_broker_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_api_proto_broker_v1_broker_proto_rawDesc), len(file_api_proto_broker_v1_broker_proto_rawDesc)))
+	})
+	return file_api_proto_broker_v1_broker_proto_rawDescData
+}
+
+var file_api_proto_broker_v1_broker_proto_msgTypes = make([]protoimpl.MessageInfo, 14)
+var file_api_proto_broker_v1_broker_proto_goTypes = []any{
+	(*ConsentSpecRequest)(nil),         // 0: nexus.broker.v1.ConsentSpecRequest
+	(*ConsentSpecResponse)(nil),        // 1: nexus.broker.v1.ConsentSpecResponse
+	(*GetTokenRequest)(nil),            // 2: nexus.broker.v1.GetTokenRequest
+	(*RefreshRequest)(nil),             // 3: nexus.broker.v1.RefreshRequest
+	(*GetConnectionStatusRequest)(nil), // 4: nexus.broker.v1.GetConnectionStatusRequest
+	(*ConnectionStatusResponse)(nil),   // 5: nexus.broker.v1.ConnectionStatusResponse
+	(*TokenResponse)(nil),              // 6: nexus.broker.v1.TokenResponse
+	(*Token)(nil),                      // 7: nexus.broker.v1.Token
+	(*AuthStrategy)(nil),               // 8: nexus.broker.v1.AuthStrategy
+	(*ResolveProviderRequest)(nil),     // 9: nexus.broker.v1.ResolveProviderRequest
+	(*ResolveProviderResponse)(nil),    // 10: nexus.broker.v1.ResolveProviderResponse
+	(*GetMetadataRequest)(nil),         // 11: nexus.broker.v1.GetMetadataRequest
+	(*GetMetadataResponse)(nil),        // 12: nexus.broker.v1.GetMetadataResponse
+	(*ProviderMetadata)(nil),           // 13: nexus.broker.v1.ProviderMetadata
+	(*timestamppb.Timestamp)(nil),      // 14: google.protobuf.Timestamp
+	(*structpb.Struct)(nil),            // 15: google.protobuf.Struct
+}
+var file_api_proto_broker_v1_broker_proto_depIdxs = []int32{
+	7,  // 0: nexus.broker.v1.TokenResponse.token:type_name -> nexus.broker.v1.Token
+	14, // 1: nexus.broker.v1.Token.expiry:type_name -> google.protobuf.Timestamp
+	8,  // 2: nexus.broker.v1.Token.strategy:type_name -> nexus.broker.v1.AuthStrategy
+	15, // 3: nexus.broker.v1.Token.credentials:type_name -> google.protobuf.Struct
+	15, // 4: nexus.broker.v1.AuthStrategy.config:type_name -> google.protobuf.Struct
+	13, // 5: nexus.broker.v1.GetMetadataResponse.providers:type_name -> nexus.broker.v1.ProviderMetadata
+	0,  // 6: nexus.broker.v1.BrokerService.ConsentSpec:input_type -> nexus.broker.v1.ConsentSpecRequest
+	2,  // 7: nexus.broker.v1.BrokerService.GetToken:input_type -> nexus.broker.v1.GetTokenRequest
+	3,  // 8: nexus.broker.v1.BrokerService.Refresh:input_type -> nexus.broker.v1.RefreshRequest
+	4,  // 9: nexus.broker.v1.BrokerService.GetConnectionStatus:input_type -> nexus.broker.v1.GetConnectionStatusRequest
+	9,  // 10: nexus.broker.v1.BrokerService.ResolveProvider:input_type -> nexus.broker.v1.ResolveProviderRequest
+	11, // 11: nexus.broker.v1.BrokerService.GetMetadata:input_type -> nexus.broker.v1.GetMetadataRequest
+	1,  // 12: nexus.broker.v1.BrokerService.ConsentSpec:output_type -> nexus.broker.v1.ConsentSpecResponse
+	6,  // 13: nexus.broker.v1.BrokerService.GetToken:output_type -> nexus.broker.v1.TokenResponse
+	6,  // 14: nexus.broker.v1.BrokerService.Refresh:output_type -> nexus.broker.v1.TokenResponse
+	5,  // 15: nexus.broker.v1.BrokerService.GetConnectionStatus:output_type -> nexus.broker.v1.ConnectionStatusResponse
+	10, // 16: nexus.broker.v1.BrokerService.ResolveProvider:output_type -> nexus.broker.v1.ResolveProviderResponse
+	12, // 17: nexus.broker.v1.BrokerService.GetMetadata:output_type -> nexus.broker.v1.GetMetadataResponse
+	12, // [12:18] is the sub-list for method output_type
+	6,  // [6:12] is the sub-list for method input_type
+	6,  // [6:6] is the sub-list for extension type_name
+	6,  // [6:6] is the sub-list for extension extendee
+	0,  // [0:6] is the sub-list for field type_name
+}
+
+func init() { file_api_proto_broker_v1_broker_proto_init() }
+func file_api_proto_broker_v1_broker_proto_init() {
+	if File_api_proto_broker_v1_broker_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_api_proto_broker_v1_broker_proto_rawDesc), len(file_api_proto_broker_v1_broker_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   14,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_api_proto_broker_v1_broker_proto_goTypes,
+		DependencyIndexes: file_api_proto_broker_v1_broker_proto_depIdxs,
+		MessageInfos:      file_api_proto_broker_v1_broker_proto_msgTypes,
+	}.Build()
+	File_api_proto_broker_v1_broker_proto = out.File
+	file_api_proto_broker_v1_broker_proto_goTypes = nil
+	file_api_proto_broker_v1_broker_proto_depIdxs = nil
+}
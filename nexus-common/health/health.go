@@ -0,0 +1,109 @@
+// Package health serves the /healthz and /readyz probes of the Broker and
+// Gateway. /healthz only reports that the process is up; /readyz runs the
+// service's dependency checks:
+//
+//	{
+//	  "status": "degraded",
+//	  "dependencies": {
+//	    "postgres": {"status": "ok", "critical": true, "latency_ms": 2},
+//	    "redis": {"status": "error", "critical": false, "latency_ms": 2000, "error": "context deadline exceeded"}
+//	  }
+//	}
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Check is a named dependency probe evaluated by ReadinessHandler. A
+// failing Critical check marks the service unavailable (503); a failing
+// non-critical check only marks it degraded (200).
+type Check struct {
+	Name     string
+	Critical bool
+	Check    func(ctx context.Context) error
+}
+
+// DependencyStatus is the per-dependency entry in a readiness response.
+type DependencyStatus struct {
+	Status    string `json:"status"`
+	Critical  bool   `json:"critical"`
+	LatencyMS int64  `json:"latency_ms"`
+	Error     string `json:"error,omitempty"`
+}
+
+// ReadinessReport is the body returned by /readyz.
+type ReadinessReport struct {
+	Status       string                      `json:"status"`
+	Dependencies map[string]DependencyStatus `json:"dependencies"`
+}
+
+// LivenessHandler reports that the process is up and serving requests. It
+// deliberately checks no dependencies so that an outage of one does not
+// cause the orchestrator to restart healthy pods.
+func LivenessHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "alive"})
+}
+
+// ReadinessHandler runs all checks concurrently, each bounded by timeout, and
+// reports "ready", "degraded" (non-critical failures) or "unavailable".
+func ReadinessHandler(timeout time.Duration, checks ...Check) http.HandlerFunc {
+	if timeout <= 0 {
+		timeout = 2 * time.Second
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		report := Run(r.Context(), timeout, checks...)
+		status := http.StatusOK
+		if report.Status == "unavailable" {
+			status = http.StatusServiceUnavailable
+		}
+		writeJSON(w, status, report)
+	}
+}
+
+// Run evaluates checks concurrently and aggregates the result.
+func Run(ctx context.Context, timeout time.Duration, checks ...Check) ReadinessReport {
+	report := ReadinessReport{Status: "ready", Dependencies: make(map[string]DependencyStatus, len(checks))}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, c := range checks {
+		wg.Add(1)
+		go func(c Check) {
+			defer wg.Done()
+			cctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+
+			start := time.Now()
+			err := c.Check(cctx)
+			ds := DependencyStatus{Status: "ok", Critical: c.Critical, LatencyMS: time.Since(start).Milliseconds()}
+			if err != nil {
+				ds.Status = "error"
+				ds.Error = err.Error()
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			report.Dependencies[c.Name] = ds
+			if err != nil {
+				if c.Critical {
+					report.Status = "unavailable"
+				} else if report.Status == "ready" {
+					report.Status = "degraded"
+				}
+			}
+		}(c)
+	}
+	wg.Wait()
+	return report
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
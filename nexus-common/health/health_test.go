@@ -0,0 +1,77 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func okCheck(ctx context.Context) error   { return nil }
+func failCheck(ctx context.Context) error { return errors.New("boom") }
+
+func TestReadinessHandler(t *testing.T) {
+	tests := []struct {
+		name       string
+		checks     []Check
+		wantCode   int
+		wantStatus string
+	}{
+		{
+			name:       "all healthy",
+			checks:     []Check{{Name: "postgres", Critical: true, Check: okCheck}, {Name: "redis", Check: okCheck}},
+			wantCode:   http.StatusOK,
+			wantStatus: "ready",
+		},
+		{
+			name:       "non-critical failure degrades",
+			checks:     []Check{{Name: "postgres", Critical: true, Check: okCheck}, {Name: "redis", Check: failCheck}},
+			wantCode:   http.StatusOK,
+			wantStatus: "degraded",
+		},
+		{
+			name:       "critical failure is unavailable",
+			checks:     []Check{{Name: "postgres", Critical: true, Check: failCheck}, {Name: "redis", Check: okCheck}},
+			wantCode:   http.StatusServiceUnavailable,
+			wantStatus: "unavailable",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			ReadinessHandler(time.Second, tt.checks...)(rr, httptest.NewRequest("GET", "/readyz", nil))
+
+			if rr.Code != tt.wantCode {
+				t.Fatalf("expected %d, got %d", tt.wantCode, rr.Code)
+			}
+			var report ReadinessReport
+			if err := json.NewDecoder(rr.Body).Decode(&report); err != nil {
+				t.Fatal(err)
+			}
+			if report.Status != tt.wantStatus {
+				t.Errorf("expected status %q, got %q", tt.wantStatus, report.Status)
+			}
+			if len(report.Dependencies) != len(tt.checks) {
+				t.Errorf("expected %d dependencies, got %d", len(tt.checks), len(report.Dependencies))
+			}
+		})
+	}
+}
+
+func TestReadinessHandler_Timeout(t *testing.T) {
+	slow := Check{Name: "postgres", Critical: true, Check: func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}}
+
+	rr := httptest.NewRecorder()
+	ReadinessHandler(20*time.Millisecond, slow)(rr, httptest.NewRequest("GET", "/readyz", nil))
+
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,148 @@
+// Package problem writes RFC 9457 problem details, the error body of every
+// Broker and Gateway HTTP endpoint:
+//
+//	HTTP/1.1 404 Not Found
+//	Content-Type: application/problem+json
+//	X-Request-ID: host/abc-000042
+//
+//	{
+//	  "type": "https://prescott-data.github.io/nexus-framework/reference/errors/#provider_not_found",
+//	  "title": "Not Found",
+//	  "status": 404,
+//	  "detail": "provider not found",
+//	  "code": "provider_not_found",
+//	  "request_id": "host/abc-000042",
+//	  "error": "provider_not_found",
+//	  "message": "provider not found"
+//	}
+//
+// type is derived from code, which is the stable, machine-readable error
+// code clients should match on. error and message repeat code and detail
+// for clients written against the earlier {"error", "message"} body. Other
+// members are extensions specific to the error (e.g. fields on
+// validation_failed).
+package problem
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+// ContentType is the media type of a problem details body.
+const ContentType = "application/problem+json"
+
+// TypeBase prefixes the error code in a problem's type URI. It points at the
+// error reference in the documentation, where each code has an anchor.
+const TypeBase = "https://prescott-data.github.io/nexus-framework/reference/errors/#"
+
+// RequestIDHeader is the response header Write takes the request ID from.
+// The services' request ID middleware sets it before any handler runs.
+const RequestIDHeader = "X-Request-ID"
+
+// Problem is an RFC 9457 problem details object.
+type Problem struct {
+	// Type identifies the kind of problem; see TypeURI.
+	Type string
+	// Title is the short summary of the status code.
+	Title string
+	// Status is the HTTP status code.
+	Status int
+	// Detail explains this occurrence of the problem.
+	Detail string
+	// Instance identifies this occurrence, if set.
+	Instance string
+	// Code is the machine-readable error code, e.g. provider_not_found.
+	Code string
+	// RequestID is the ID of the request that failed. Write fills it in
+	// from the X-Request-ID response header when empty.
+	RequestID string
+	// Extensions are additional members. They cannot replace the ones
+	// above.
+	Extensions map[string]any
+}
+
+// New returns the problem for code answered with status.
+func New(status int, code, detail string) *Problem {
+	return &Problem{
+		Type:   TypeURI(code),
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+		Code:   code,
+	}
+}
+
+// TypeURI returns the type URI of the error code.
+func TypeURI(code string) string {
+	if code == "" {
+		return "about:blank"
+	}
+	return TypeBase + code
+}
+
+// With sets the extension member key to value and returns p.
+func (p *Problem) With(key string, value any) *Problem {
+	if p.Extensions == nil {
+		p.Extensions = map[string]any{}
+	}
+	p.Extensions[key] = value
+	return p
+}
+
+// MarshalJSON flattens the extensions into the object next to the standard
+// members.
+func (p *Problem) MarshalJSON() ([]byte, error) {
+	m := make(map[string]any, len(p.Extensions)+9)
+	for k, v := range p.Extensions {
+		m[k] = v
+	}
+	m["type"] = p.Type
+	m["title"] = p.Title
+	m["status"] = p.Status
+	m["code"] = p.Code
+	m["error"] = p.Code
+	m["message"] = p.Detail
+	if p.Detail != "" {
+		m["detail"] = p.Detail
+	}
+	if p.Instance != "" {
+		m["instance"] = p.Instance
+	}
+	if p.RequestID != "" {
+		m["request_id"] = p.RequestID
+	}
+	return json.Marshal(m)
+}
+
+// Write sends p as the response. If an extension cannot be encoded, the
+// problem is sent without extensions rather than not at all.
+func Write(w http.ResponseWriter, p *Problem) {
+	if p.RequestID == "" {
+		p.RequestID = w.Header().Get(RequestIDHeader)
+	}
+	data, err := json.Marshal(p)
+	if err != nil {
+		log.Printf("problem: dropping extensions of %s: %v", p.Code, err)
+		bare := *p
+		bare.Extensions = nil
+		data, _ = json.Marshal(&bare)
+	}
+	w.Header().Set("Content-Type", ContentType)
+	w.WriteHeader(p.Status)
+	_, _ = w.Write(data)
+}
+
+// RequestID returns middleware that sets the X-Request-ID response header
+// to the ID id reports for the request, so that clients and Write see it.
+// Install it after the middleware that assigns request IDs.
+func RequestID(id func(*http.Request) string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if v := id(r); v != "" {
+				w.Header().Set(RequestIDHeader, v)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package problem
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWrite(t *testing.T) {
+	w := httptest.NewRecorder()
+	w.Header().Set(RequestIDHeader, "req-1")
+	Write(w, New(http.StatusBadRequest, "validation_failed", "request validation failed").
+		With("fields", map[string]string{"return_url": "must be https"}).
+		With("status", "ignored"))
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != ContentType {
+		t.Errorf("expected %s, got %s", ContentType, ct)
+	}
+	var body map[string]any
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]any{
+		"type":       TypeBase + "validation_failed",
+		"title":      "Bad Request",
+		"status":     float64(400),
+		"detail":     "request validation failed",
+		"code":       "validation_failed",
+		"request_id": "req-1",
+		"error":      "validation_failed",
+		"message":    "request validation failed",
+	}
+	for k, v := range want {
+		if body[k] != v {
+			t.Errorf("%s: expected %v, got %v", k, v, body[k])
+		}
+	}
+	if fields, _ := body["fields"].(map[string]any); fields["return_url"] != "must be https" {
+		t.Errorf("expected the fields extension, got %v", body["fields"])
+	}
+	if _, ok := body["instance"]; ok {
+		t.Error("an empty instance should be omitted")
+	}
+}
+
+func TestWrite_UnencodableExtension(t *testing.T) {
+	w := httptest.NewRecorder()
+	Write(w, New(http.StatusInternalServerError, "internal_error", "boom").With("bad", make(chan int)))
+	var body map[string]any
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("expected a problem without extensions, got %q", w.Body.String())
+	}
+	if body["code"] != "internal_error" {
+		t.Errorf("unexpected body %v", body)
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	h := RequestID(func(r *http.Request) string { return "req-7" })(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Write(w, New(http.StatusNotFound, "not_found", ""))
+	}))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if w.Header().Get(RequestIDHeader) != "req-7" || !json.Valid(w.Body.Bytes()) {
+		t.Fatalf("unexpected response %v %s", w.Header(), w.Body.String())
+	}
+	var body map[string]any
+	_ = json.Unmarshal(w.Body.Bytes(), &body)
+	if body["request_id"] != "req-7" {
+		t.Errorf("expected the request ID in the body, got %v", body)
+	}
+}
+
+func TestTypeURI(t *testing.T) {
+	if got := TypeURI(""); got != "about:blank" {
+		t.Errorf("expected about:blank for no code, got %s", got)
+	}
+}
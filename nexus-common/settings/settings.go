@@ -0,0 +1,142 @@
+// Package settings resolves the configuration of a Nexus service. Each
+// service documents its keys in a table of Setting; a key is read from the
+// environment first, then from an optional YAML config file, then from the
+// table's default.
+//
+// Keys are environment variable names. In a config file the same key is
+// written in lower case (e.g. BROKER_BASE_URL -> broker_base_url), and a
+// YAML list stands for the comma-separated form of the variable.
+package settings
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Setting documents one configuration key.
+type Setting struct {
+	Key         string
+	Default     string
+	Description string
+	Secret      bool
+}
+
+// Lookup returns the entry for key in table.
+func Lookup(table []Setting, key string) (Setting, bool) {
+	for _, st := range table {
+		if st.Key == key {
+			return st, true
+		}
+	}
+	return Setting{}, false
+}
+
+// Source resolves the keys of a settings table.
+type Source struct {
+	table []Setting
+	file  map[string]string
+}
+
+// Load reads the YAML config file at path (optional; empty skips it) for the
+// keys in table. A file key missing from the table is an error.
+func Load(table []Setting, path string) (Source, error) {
+	s := Source{table: table, file: map[string]string{}}
+	if path == "" {
+		return s, nil
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return s, fmt.Errorf("config file: %w", err)
+	}
+	var doc map[string]interface{}
+	if err := yaml.Unmarshal(raw, &doc); err != nil {
+		return s, fmt.Errorf("config file %s: %w", path, err)
+	}
+	for k, v := range doc {
+		key := strings.ToUpper(k)
+		if _, ok := Lookup(table, key); !ok {
+			return s, fmt.Errorf("config file %s: unknown key %q", path, k)
+		}
+		s.file[key] = fileValue(v)
+	}
+	return s, nil
+}
+
+// fileValue flattens a YAML scalar or list into the string form used by the
+// equivalent environment variable. Lists become comma-separated.
+func fileValue(v interface{}) string {
+	switch t := v.(type) {
+	case nil:
+		return ""
+	case []interface{}:
+		parts := make([]string, 0, len(t))
+		for _, item := range t {
+			parts = append(parts, fmt.Sprint(item))
+		}
+		return strings.Join(parts, ",")
+	default:
+		return fmt.Sprint(t)
+	}
+}
+
+// Get returns the value of key: the environment variable if set, else the
+// config file's value, else the documented default.
+func (s Source) Get(key string) string {
+	if v := strings.TrimSpace(os.Getenv(key)); v != "" {
+		return v
+	}
+	if v := strings.TrimSpace(s.file[key]); v != "" {
+		return v
+	}
+	st, _ := Lookup(s.table, key)
+	return st.Default
+}
+
+// List splits a comma-separated value, dropping empty entries.
+func (s Source) List(key string) []string {
+	var out []string
+	for _, v := range strings.Split(s.Get(key), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// Print writes the effective configuration of table (env > file > default)
+// as a YAML document annotated with each key's description and default.
+// Secrets are redacted. The output can be used as a starting config file.
+func Print(w io.Writer, table []Setting, path string) error {
+	src, err := Load(table, path)
+	if err != nil {
+		return err
+	}
+	for _, st := range table {
+		val := quoteYAML(src.Get(st.Key))
+		if st.Secret && val != `""` {
+			val = "<redacted>"
+		}
+		def := st.Default
+		if def == "" {
+			def = "none"
+		}
+		fmt.Fprintf(w, "# %s (default: %s)\n", st.Description, def)
+		fmt.Fprintf(w, "%s: %s\n", strings.ToLower(st.Key), val)
+	}
+	return nil
+}
+
+func quoteYAML(v string) string {
+	if v == "" {
+		return `""`
+	}
+	out, err := yaml.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("%q", v)
+	}
+	return strings.TrimSpace(string(out))
+}
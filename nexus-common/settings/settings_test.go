@@ -0,0 +1,80 @@
+package settings
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var testTable = []Setting{
+	{Key: "NEXUS_TEST_PORT", Default: "8080", Description: "Listen port"},
+	{Key: "NEXUS_TEST_HOSTS", Description: "Allowed hosts"},
+	{Key: "NEXUS_TEST_SECRET", Description: "Shared secret", Secret: true},
+}
+
+func writeFile(t *testing.T, body string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+	return path
+}
+
+func TestSource_Precedence(t *testing.T) {
+	path := writeFile(t, "nexus_test_port: 9000\nnexus_test_hosts:\n  - a.example\n  - b.example\n")
+	src, err := Load(testTable, path)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if got := src.Get("NEXUS_TEST_PORT"); got != "9000" {
+		t.Errorf("file value = %q, want 9000", got)
+	}
+	if got := src.List("NEXUS_TEST_HOSTS"); len(got) != 2 || got[0] != "a.example" || got[1] != "b.example" {
+		t.Errorf("file list = %v, want [a.example b.example]", got)
+	}
+
+	t.Setenv("NEXUS_TEST_PORT", "7000")
+	if got := src.Get("NEXUS_TEST_PORT"); got != "7000" {
+		t.Errorf("env value = %q, want 7000", got)
+	}
+
+	src, err = Load(testTable, "")
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	t.Setenv("NEXUS_TEST_PORT", "")
+	if got := src.Get("NEXUS_TEST_PORT"); got != "8080" {
+		t.Errorf("default = %q, want 8080", got)
+	}
+}
+
+func TestLoad_UnknownKey(t *testing.T) {
+	path := writeFile(t, "nexus_test_prot: 9000\n")
+	if _, err := Load(testTable, path); err == nil || !strings.Contains(err.Error(), "unknown key") {
+		t.Fatalf("err = %v, want unknown key", err)
+	}
+}
+
+func TestPrint_RedactsSecrets(t *testing.T) {
+	t.Setenv("NEXUS_TEST_SECRET", "hunter2")
+	var buf bytes.Buffer
+	if err := Print(&buf, testTable, ""); err != nil {
+		t.Fatalf("Print: %v", err)
+	}
+	out := buf.String()
+	if strings.Contains(out, "hunter2") {
+		t.Fatalf("secret printed:\n%s", out)
+	}
+	for _, want := range []string{
+		"# Listen port (default: 8080)\nnexus_test_port: \"8080\"\n",
+		"nexus_test_hosts: \"\"\n",
+		"nexus_test_secret: <redacted>\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q:\n%s", want, out)
+		}
+	}
+}
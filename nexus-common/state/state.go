@@ -0,0 +1,413 @@
+// Package state signs and verifies the OAuth state parameter shared by the
+// Broker, which issues it, and the Gateway, which checks it on callback.
+//
+// A state has the form
+//
+//	v1.<key id>.<base64url payload>.<base64url HMAC-SHA256>
+//
+// where the MAC covers everything before the last dot, so the version and
+// key ID cannot be swapped without invalidating it. States are signed with
+// the current key of a Keyring and verified against every key it holds,
+// which lets STATE_KEY be rotated without failing flows already in
+// progress. The unversioned "<payload>.<mac>" form issued before key IDs
+// existed is still accepted and checked against every key.
+//
+// Signatures are compared in constant time, and every service applies the
+// same lifetime (DefaultTTL unless configured) measured from the IAT the
+// issuer recorded.
+//
+// A flow started from a browser can be bound to it: the state then carries
+// the hash of a random session that the Gateway keeps in a cookie signed
+// with the same keys (SignSession), and the Broker completes the flow only
+// when it is shown the session with that hash.
+package state
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Version is the prefix of states produced by Sign.
+const Version = "v1"
+
+const (
+	// DefaultTTL is how long a state is accepted after it is issued.
+	DefaultTTL = 10 * time.Minute
+	// MaxClockSkew is how far in the future an IAT may lie, to allow for
+	// clock drift between the Broker and Gateway hosts.
+	MaxClockSkew = time.Minute
+	// SecretSize is the length, in bytes, of a key secret.
+	SecretSize = 32
+)
+
+// Errors returned by Verify, wrapped with detail. Callers should report
+// all of them to the client as a single "invalid state".
+var (
+	ErrMalformed  = errors.New("malformed state")
+	ErrUnknownKey = errors.New("state signed with unknown key")
+	ErrSignature  = errors.New("invalid state signature")
+	ErrExpired    = errors.New("state has expired")
+	// ErrSession is returned by VerifySession for a cookie that is
+	// malformed or not signed by any key.
+	ErrSession = errors.New("invalid session")
+)
+
+// Data is the payload of a state. Nonce carries the connection ID.
+// SessionHash, when set, binds the flow to the browser session it hashes
+// (see HashSession).
+type Data struct {
+	WorkspaceID string    `json:"workspace_id"`
+	ProviderID  string    `json:"provider_id"`
+	Nonce       string    `json:"nonce"`
+	IAT         time.Time `json:"iat"`
+	SessionHash string    `json:"session_hash,omitempty"`
+}
+
+// Key is one HMAC key. An empty ID is replaced by KeyID(Secret).
+type Key struct {
+	ID     string
+	Secret []byte
+}
+
+var validKeyID = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// KeyID derives a key ID from a secret: the first 8 hex digits of its
+// SHA-256. It identifies the key without revealing it.
+func KeyID(secret []byte) string {
+	sum := sha256.Sum256(secret)
+	return hex.EncodeToString(sum[:4])
+}
+
+// Keyring signs with one current key and verifies with it and any
+// previous keys. It is safe for concurrent use.
+type Keyring struct {
+	current Key
+	keys    []Key
+	ttl     time.Duration
+	now     func() time.Time
+}
+
+// Option configures a Keyring.
+type Option func(*Keyring)
+
+// WithTTL sets how long states are accepted after they are issued.
+// Non-positive values leave DefaultTTL in place.
+func WithTTL(ttl time.Duration) Option {
+	return func(k *Keyring) {
+		if ttl > 0 {
+			k.ttl = ttl
+		}
+	}
+}
+
+// WithClock replaces time.Now, for tests.
+func WithClock(now func() time.Time) Option {
+	return func(k *Keyring) { k.now = now }
+}
+
+// NewKeyring returns a Keyring that signs with current and also verifies
+// states signed with previous. Secrets must be non-empty and key IDs
+// unique.
+func NewKeyring(current Key, previous []Key, opts ...Option) (*Keyring, error) {
+	k := &Keyring{ttl: DefaultTTL, now: time.Now}
+	for _, key := range append([]Key{current}, previous...) {
+		if len(key.Secret) == 0 {
+			return nil, fmt.Errorf("state key %q has an empty secret", key.ID)
+		}
+		if key.ID == "" {
+			key.ID = KeyID(key.Secret)
+		}
+		if !validKeyID.MatchString(key.ID) {
+			return nil, fmt.Errorf("state key ID %q must be 1-64 letters, digits, '-' or '_'", key.ID)
+		}
+		for _, seen := range k.keys {
+			if seen.ID == key.ID {
+				return nil, fmt.Errorf("duplicate state key ID %q", key.ID)
+			}
+		}
+		k.keys = append(k.keys, key)
+	}
+	k.current = k.keys[0]
+	for _, opt := range opts {
+		opt(k)
+	}
+	return k, nil
+}
+
+// CurrentKeyID is the ID of the key new states are signed with.
+func (k *Keyring) CurrentKeyID() string { return k.current.ID }
+
+// KeyIDs lists the IDs of all keys accepted by Verify, current first.
+func (k *Keyring) KeyIDs() []string {
+	ids := make([]string, len(k.keys))
+	for i, key := range k.keys {
+		ids[i] = key.ID
+	}
+	return ids
+}
+
+// TTL is how long a state is accepted after it is issued. Records whose
+// lifetime is tied to a state, such as pending connections, should use it
+// too.
+func (k *Keyring) TTL() time.Duration { return k.ttl }
+
+// Sign returns the signed state for data. A zero IAT is set to now.
+func (k *Keyring) Sign(data Data) (string, error) {
+	if data.IAT.IsZero() {
+		data.IAT = k.now()
+	}
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return "", err
+	}
+	signed := Version + "." + k.current.ID + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(mac(k.current.Secret, []byte(signed))), nil
+}
+
+// Verify checks the signature and lifetime of a state and returns its
+// payload. A state without a nonce is rejected as malformed.
+func (k *Keyring) Verify(state string) (*Data, error) {
+	parts := strings.Split(state, ".")
+	var payload []byte
+	switch {
+	case len(parts) == 4 && parts[0] == Version:
+		key, ok := k.key(parts[1])
+		if !ok {
+			return nil, fmt.Errorf("%w %q", ErrUnknownKey, parts[1])
+		}
+		sig, err := base64.RawURLEncoding.DecodeString(parts[3])
+		if err != nil {
+			return nil, fmt.Errorf("%w: signature: %v", ErrMalformed, err)
+		}
+		signed := state[:len(state)-len(parts[3])-1]
+		if !hmac.Equal(sig, mac(key.Secret, []byte(signed))) {
+			return nil, ErrSignature
+		}
+		if payload, err = base64.RawURLEncoding.DecodeString(parts[2]); err != nil {
+			return nil, fmt.Errorf("%w: payload: %v", ErrMalformed, err)
+		}
+	case len(parts) == 2:
+		var err error
+		if payload, err = base64.RawURLEncoding.DecodeString(parts[0]); err != nil {
+			return nil, fmt.Errorf("%w: payload: %v", ErrMalformed, err)
+		}
+		sig, err := base64.RawURLEncoding.DecodeString(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("%w: signature: %v", ErrMalformed, err)
+		}
+		if !k.verifyAny(payload, sig) {
+			return nil, ErrSignature
+		}
+	default:
+		return nil, fmt.Errorf("%w: unrecognised format", ErrMalformed)
+	}
+
+	var data Data
+	if err := json.Unmarshal(payload, &data); err != nil {
+		return nil, fmt.Errorf("%w: payload: %v", ErrMalformed, err)
+	}
+	if data.Nonce == "" {
+		return nil, fmt.Errorf("%w: missing nonce", ErrMalformed)
+	}
+	if data.IAT.IsZero() {
+		return nil, fmt.Errorf("%w: missing iat", ErrMalformed)
+	}
+	now := k.now()
+	if now.Sub(data.IAT) > k.ttl {
+		return nil, ErrExpired
+	}
+	if data.IAT.Sub(now) > MaxClockSkew {
+		return nil, fmt.Errorf("%w: issued in the future", ErrExpired)
+	}
+	return &data, nil
+}
+
+func (k *Keyring) key(id string) (Key, bool) {
+	for _, key := range k.keys {
+		if key.ID == id {
+			return key, true
+		}
+	}
+	return Key{}, false
+}
+
+// verifyAny reports whether sig is the MAC of payload under any key. Every
+// key is tried so the time taken does not depend on which one matched.
+func (k *Keyring) verifyAny(payload, sig []byte) bool {
+	ok := false
+	for _, key := range k.keys {
+		if hmac.Equal(sig, mac(key.Secret, payload)) {
+			ok = true
+		}
+	}
+	return ok
+}
+
+func mac(secret, msg []byte) []byte {
+	h := hmac.New(sha256.New, secret)
+	h.Write(msg)
+	return h.Sum(nil)
+}
+
+// SessionHeader carries the browser session of a bound flow from the
+// Gateway, which holds it in a cookie, to the Broker, which compares its
+// hash with the state's SessionHash.
+const SessionHeader = "X-Nexus-Session"
+
+// sessionSize is the length, in bytes, of a random session.
+const sessionSize = 32
+
+// NewSession returns a random browser session identifier.
+func NewSession() (string, error) {
+	b := make([]byte, sessionSize)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// HashSession returns the value a state's SessionHash holds for session:
+// its base64url SHA-256. Only the hash travels in the auth URL, so a
+// leaked link does not reveal the session.
+func HashSession(session string) string {
+	sum := sha256.Sum256([]byte(session))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// ValidSessionHash reports whether h has the form HashSession returns.
+func ValidSessionHash(h string) bool {
+	b, err := base64.RawURLEncoding.DecodeString(h)
+	return err == nil && len(b) == sha256.Size
+}
+
+// SignSession returns session signed with the current key, as
+// "<session>.<key id>.<base64url HMAC-SHA256>", for a cookie.
+func (k *Keyring) SignSession(session string) string {
+	signed := session + "." + k.current.ID
+	return signed + "." + base64.RawURLEncoding.EncodeToString(mac(k.current.Secret, []byte("session:"+signed)))
+}
+
+// VerifySession checks a cookie made by SignSession with any key of the
+// ring and returns the session.
+func (k *Keyring) VerifySession(cookie string) (string, error) {
+	parts := strings.Split(cookie, ".")
+	if len(parts) != 3 {
+		return "", fmt.Errorf("%w: unrecognised format", ErrSession)
+	}
+	key, ok := k.key(parts[1])
+	if !ok {
+		return "", fmt.Errorf("%w: unknown key %q", ErrSession, parts[1])
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || !hmac.Equal(sig, mac(key.Secret, []byte("session:"+parts[0]+"."+parts[1]))) {
+		return "", fmt.Errorf("%w: bad signature", ErrSession)
+	}
+	return parts[0], nil
+}
+
+// ParseKeys parses a comma-separated list of keys, each either
+// "<id>:<base64 secret>" or a bare base64 secret whose ID is derived with
+// KeyID. Secrets must decode to SecretSize bytes. name is used in errors.
+func ParseKeys(name, list string) ([]Key, error) {
+	var keys []Key
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		var key Key
+		encoded := entry
+		if id, secret, ok := strings.Cut(entry, ":"); ok {
+			key.ID, encoded = strings.TrimSpace(id), strings.TrimSpace(secret)
+		}
+		secret, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("%s: entry %d is not valid base64", name, len(keys)+1)
+		}
+		if len(secret) != SecretSize {
+			return nil, fmt.Errorf("%s: entry %d decoded to %d bytes, expected exactly %d", name, len(keys)+1, len(secret), SecretSize)
+		}
+		key.Secret = secret
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// KeyringFromEnv builds the keyring configured by the STATE_* settings,
+// read through get (os.Getenv, or a config loader that applies defaults),
+// and returns it with its current secret. STATE_KEYS lists every accepted
+// key, the first one signing. Without it STATE_KEY, named STATE_KEY_ID,
+// signs and STATE_PREVIOUS_KEYS are accepted too. STATE_TTL, if set, is the
+// keyring's TTL. The Broker and the Gateway must be given the same keys and
+// TTL.
+func KeyringFromEnv(get func(key string) string) ([]byte, *Keyring, error) {
+	var ttl time.Duration
+	if v := get("STATE_TTL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return nil, nil, fmt.Errorf("STATE_TTL must be a positive duration (e.g. 10m), got %q", v)
+		}
+		ttl = d
+	}
+	var keys []Key
+	name := "STATE_KEYS"
+	if list := get("STATE_KEYS"); list != "" {
+		for _, other := range []string{"STATE_KEY", "STATE_KEY_ID", "STATE_PREVIOUS_KEYS"} {
+			if get(other) != "" {
+				return nil, nil, fmt.Errorf("STATE_KEYS and %s are both set; use one or the other", other)
+			}
+		}
+		var err error
+		if keys, err = ParseKeys("STATE_KEYS", list); err != nil {
+			return nil, nil, err
+		}
+		if len(keys) == 0 {
+			return nil, nil, fmt.Errorf("STATE_KEYS lists no keys")
+		}
+	} else {
+		current, err := decodeStateKey(get("STATE_KEY"))
+		if err != nil {
+			return nil, nil, err
+		}
+		previous, err := ParseKeys("STATE_PREVIOUS_KEYS", get("STATE_PREVIOUS_KEYS"))
+		if err != nil {
+			return nil, nil, err
+		}
+		keys = append([]Key{{ID: get("STATE_KEY_ID"), Secret: current}}, previous...)
+		name = "STATE_KEY_ID / STATE_PREVIOUS_KEYS"
+	}
+	ring, err := NewKeyring(keys[0], keys[1:], WithTTL(ttl))
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return keys[0].Secret, ring, nil
+}
+
+// decodeStateKey decodes STATE_KEY, with errors that say how to make one.
+func decodeStateKey(v string) ([]byte, error) {
+	if v == "" {
+		return nil, fmt.Errorf("STATE_KEY is not set. " +
+			"The Broker and the Gateway must share it (or STATE_KEYS), and it must be stable across restarts. " +
+			"Generate one with: openssl rand -base64 32")
+	}
+	key, err := base64.StdEncoding.DecodeString(v)
+	if err != nil {
+		return nil, fmt.Errorf("STATE_KEY is not valid base64: %w. "+
+			"Expected a base64-encoded %d-byte key. "+
+			"Generate one with: openssl rand -base64 32", err, SecretSize)
+	}
+	if len(key) != SecretSize {
+		return nil, fmt.Errorf("STATE_KEY decoded to %d bytes, expected exactly %d. "+
+			"Generate one with: openssl rand -base64 32", len(key), SecretSize)
+	}
+	return key, nil
+}
@@ -0,0 +1,308 @@
+package state
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func secret(b byte) []byte { return bytes.Repeat([]byte{b}, SecretSize) }
+
+func mustKeyring(t *testing.T, current Key, previous []Key, opts ...Option) *Keyring {
+	t.Helper()
+	k, err := NewKeyring(current, previous, opts...)
+	if err != nil {
+		t.Fatalf("NewKeyring: %v", err)
+	}
+	return k
+}
+
+func testData(iat time.Time) Data {
+	return Data{WorkspaceID: "ws-1", ProviderID: "prov-1", Nonce: "conn-1", IAT: iat}
+}
+
+func TestSignVerify_RoundTrip(t *testing.T) {
+	k := mustKeyring(t, Key{ID: "k1", Secret: secret(1)}, nil)
+	s, err := k.Sign(testData(time.Now()))
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	if !strings.HasPrefix(s, "v1.k1.") || strings.Count(s, ".") != 3 {
+		t.Fatalf("state %q is not v1.<kid>.<payload>.<sig>", s)
+	}
+	d, err := k.Verify(s)
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if d.WorkspaceID != "ws-1" || d.ProviderID != "prov-1" || d.Nonce != "conn-1" {
+		t.Errorf("payload = %+v", d)
+	}
+}
+
+func TestSign_ZeroIATIsNow(t *testing.T) {
+	now := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	k := mustKeyring(t, Key{ID: "k1", Secret: secret(1)}, nil, WithClock(func() time.Time { return now }))
+	s, _ := k.Sign(Data{Nonce: "conn-1"})
+	d, err := k.Verify(s)
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if !d.IAT.Equal(now) {
+		t.Errorf("IAT = %v, want %v", d.IAT, now)
+	}
+}
+
+func TestVerify_Rotation(t *testing.T) {
+	old := mustKeyring(t, Key{ID: "old", Secret: secret(1)}, nil)
+	s, _ := old.Sign(testData(time.Now()))
+
+	rotated := mustKeyring(t, Key{ID: "new", Secret: secret(2)}, []Key{{ID: "old", Secret: secret(1)}})
+	if _, err := rotated.Verify(s); err != nil {
+		t.Fatalf("state signed with previous key rejected: %v", err)
+	}
+	if got := rotated.KeyIDs(); len(got) != 2 || got[0] != "new" || got[1] != "old" {
+		t.Errorf("KeyIDs = %v", got)
+	}
+
+	retired := mustKeyring(t, Key{ID: "new", Secret: secret(2)}, nil)
+	if _, err := retired.Verify(s); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("retired key: err = %v, want ErrUnknownKey", err)
+	}
+}
+
+// TestRotation_ZeroDowntime walks the three-step rotation across a Broker
+// and a Gateway that are reconfigured at different times: no state signed
+// at any point is rejected by either service.
+func TestRotation_ZeroDowntime(t *testing.T) {
+	oldKey := Key{ID: "old", Secret: secret(1)}
+	newKey := Key{ID: "new", Secret: secret(2)}
+	steps := [][]Key{
+		{oldKey},         // before
+		{oldKey, newKey}, // 1: accept the new key everywhere
+		{newKey, oldKey}, // 2: sign with it everywhere
+		{newKey},         // 3: drop the old key after the TTL
+	}
+	// Each step is rolled out service by service, so a state signed under
+	// one step must verify under the previous and next steps.
+	for i := 1; i < len(steps); i++ {
+		prev := mustKeyring(t, steps[i-1][0], steps[i-1][1:])
+		next := mustKeyring(t, steps[i][0], steps[i][1:])
+		if i == len(steps)-1 {
+			// Only states from step 2 remain once the TTL has passed.
+			s, _ := prev.Sign(testData(time.Now()))
+			if _, err := next.Verify(s); err != nil {
+				t.Errorf("step %d: state from step %d rejected: %v", i, i-1, err)
+			}
+			continue
+		}
+		for _, pair := range [][2]*Keyring{{prev, next}, {next, prev}} {
+			s, _ := pair[0].Sign(testData(time.Now()))
+			if _, err := pair[1].Verify(s); err != nil {
+				t.Errorf("step %d: state signed with %s rejected: %v", i, pair[0].CurrentKeyID(), err)
+			}
+		}
+	}
+}
+
+func TestVerify_Rejects(t *testing.T) {
+	k := mustKeyring(t, Key{ID: "k1", Secret: secret(1)}, nil)
+	valid, _ := k.Sign(testData(time.Now()))
+	parts := strings.Split(valid, ".")
+
+	other := mustKeyring(t, Key{ID: "k1", Secret: secret(2)}, nil)
+	forged, _ := other.Sign(testData(time.Now()))
+	expired, _ := k.Sign(testData(time.Now().Add(-DefaultTTL - time.Second)))
+	future, _ := k.Sign(testData(time.Now().Add(MaxClockSkew + time.Minute)))
+	noNonce, _ := k.Sign(Data{IAT: time.Now()})
+
+	cases := []struct {
+		name  string
+		state string
+		want  error
+	}{
+		{"empty", "", ErrMalformed},
+		{"three parts", "a.b.c", ErrMalformed},
+		{"wrong version", "v9." + strings.Join(parts[1:], "."), ErrMalformed},
+		{"bad signature encoding", strings.Join(parts[:3], ".") + ".!!", ErrMalformed},
+		{"wrong secret", forged, ErrSignature},
+		{"key ID swapped", "v1.k2." + strings.Join(parts[2:], "."), ErrUnknownKey},
+		{"payload swapped", strings.Join([]string{parts[0], parts[1], strings.Split(forged, ".")[2], parts[3]}, "."), ErrSignature},
+		{"expired", expired, ErrExpired},
+		{"issued in the future", future, ErrExpired},
+		{"no nonce", noNonce, ErrMalformed},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := k.Verify(tc.state); !errors.Is(err, tc.want) {
+				t.Errorf("err = %v, want %v", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestVerify_TTL(t *testing.T) {
+	now := time.Now()
+	k := mustKeyring(t, Key{Secret: secret(1)}, nil, WithTTL(time.Minute), WithClock(func() time.Time { return now }))
+	if k.TTL() != time.Minute {
+		t.Fatalf("TTL = %v", k.TTL())
+	}
+	s, _ := k.Sign(testData(now.Add(-59 * time.Second)))
+	if _, err := k.Verify(s); err != nil {
+		t.Errorf("within TTL: %v", err)
+	}
+	s, _ = k.Sign(testData(now.Add(-61 * time.Second)))
+	if _, err := k.Verify(s); !errors.Is(err, ErrExpired) {
+		t.Errorf("past TTL: err = %v, want ErrExpired", err)
+	}
+	if d := mustKeyring(t, Key{Secret: secret(1)}, nil, WithTTL(0)).TTL(); d != DefaultTTL {
+		t.Errorf("WithTTL(0) = %v, want DefaultTTL", d)
+	}
+}
+
+// legacyState builds the unversioned "<payload>.<mac>" form.
+func legacyState(secret []byte, d Data) string {
+	payload, _ := json.Marshal(d)
+	h := hmac.New(sha256.New, secret)
+	h.Write(payload)
+	return base64.RawURLEncoding.EncodeToString(payload) + "." + base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+}
+
+func TestVerify_LegacyFormat(t *testing.T) {
+	k := mustKeyring(t, Key{ID: "new", Secret: secret(2)}, []Key{{ID: "old", Secret: secret(1)}})
+	for _, s := range [][]byte{secret(1), secret(2)} {
+		if _, err := k.Verify(legacyState(s, testData(time.Now()))); err != nil {
+			t.Errorf("legacy state rejected: %v", err)
+		}
+	}
+	if _, err := k.Verify(legacyState(secret(3), testData(time.Now()))); !errors.Is(err, ErrSignature) {
+		t.Errorf("legacy state with unknown secret: err = %v, want ErrSignature", err)
+	}
+}
+
+func TestNewKeyring(t *testing.T) {
+	k := mustKeyring(t, Key{Secret: secret(1)}, nil)
+	if k.CurrentKeyID() != KeyID(secret(1)) || len(k.CurrentKeyID()) != 8 {
+		t.Errorf("derived key ID = %q", k.CurrentKeyID())
+	}
+
+	bad := []struct {
+		name     string
+		current  Key
+		previous []Key
+	}{
+		{"empty secret", Key{ID: "k1"}, nil},
+		{"dot in ID", Key{ID: "k.1", Secret: secret(1)}, nil},
+		{"duplicate ID", Key{ID: "k1", Secret: secret(1)}, []Key{{ID: "k1", Secret: secret(2)}}},
+		{"duplicate derived ID", Key{Secret: secret(1)}, []Key{{Secret: secret(1)}}},
+	}
+	for _, tc := range bad {
+		if _, err := NewKeyring(tc.current, tc.previous); err == nil {
+			t.Errorf("%s: expected error", tc.name)
+		}
+	}
+}
+
+func TestParseKeys(t *testing.T) {
+	a := base64.StdEncoding.EncodeToString(secret(1))
+	b := base64.StdEncoding.EncodeToString(secret(2))
+	keys, err := ParseKeys("STATE_PREVIOUS_KEYS", "2024-q4:"+a+", "+b+",")
+	if err != nil {
+		t.Fatalf("ParseKeys: %v", err)
+	}
+	if len(keys) != 2 || keys[0].ID != "2024-q4" || !bytes.Equal(keys[0].Secret, secret(1)) ||
+		keys[1].ID != "" || !bytes.Equal(keys[1].Secret, secret(2)) {
+		t.Errorf("keys = %+v", keys)
+	}
+	if keys, err := ParseKeys("X", ""); err != nil || len(keys) != 0 {
+		t.Errorf("empty list: %v, %v", keys, err)
+	}
+	for _, bad := range []string{"not base64!", "id:" + base64.StdEncoding.EncodeToString([]byte("short"))} {
+		if _, err := ParseKeys("STATE_PREVIOUS_KEYS", bad); err == nil || !strings.Contains(err.Error(), "STATE_PREVIOUS_KEYS") {
+			t.Errorf("ParseKeys(%q): err = %v", bad, err)
+		}
+	}
+}
+
+func TestKeyringFromEnv(t *testing.T) {
+	a := base64.StdEncoding.EncodeToString(secret(1))
+	b := base64.StdEncoding.EncodeToString(secret(2))
+	env := func(vars map[string]string) func(string) string {
+		return func(key string) string { return vars[key] }
+	}
+
+	current, ring, err := KeyringFromEnv(env(map[string]string{"STATE_KEY": a, "STATE_KEY_ID": "2025-06", "STATE_PREVIOUS_KEYS": "2025-01:" + b, "STATE_TTL": "5m"}))
+	if err != nil {
+		t.Fatalf("KeyringFromEnv: %v", err)
+	}
+	if !bytes.Equal(current, secret(1)) || strings.Join(ring.KeyIDs(), ",") != "2025-06,2025-01" || ring.TTL() != 5*time.Minute {
+		t.Errorf("STATE_KEY keyring: current %x, keys %v, TTL %s", current, ring.KeyIDs(), ring.TTL())
+	}
+
+	current, ring, err = KeyringFromEnv(env(map[string]string{"STATE_KEYS": "2025-01:" + b + "," + a}))
+	if err != nil {
+		t.Fatalf("KeyringFromEnv: %v", err)
+	}
+	if !bytes.Equal(current, secret(2)) || ring.CurrentKeyID() != "2025-01" || ring.TTL() != DefaultTTL {
+		t.Errorf("STATE_KEYS keyring: current %x, key %s, TTL %s", current, ring.CurrentKeyID(), ring.TTL())
+	}
+
+	for _, tc := range []struct {
+		vars map[string]string
+		want string
+	}{
+		{map[string]string{}, "STATE_KEY is not set"},
+		{map[string]string{"STATE_KEY": "short"}, "STATE_KEY"},
+		{map[string]string{"STATE_KEYS": a, "STATE_KEY": b}, "STATE_KEYS and STATE_KEY"},
+		{map[string]string{"STATE_KEYS": ","}, "STATE_KEYS"},
+		{map[string]string{"STATE_KEY": a, "STATE_KEY_ID": "2025.06"}, "STATE_KEY_ID"},
+		{map[string]string{"STATE_KEY": a, "STATE_TTL": "0"}, "STATE_TTL"},
+	} {
+		if _, _, err := KeyringFromEnv(env(tc.vars)); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("KeyringFromEnv(%v): err = %v, want %q", tc.vars, err, tc.want)
+		}
+	}
+}
+
+func TestSession(t *testing.T) {
+	k := mustKeyring(t, Key{ID: "k1", Secret: secret(1)}, nil)
+	session, err := NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookie := k.SignSession(session)
+	if got, err := k.VerifySession(cookie); err != nil || got != session {
+		t.Fatalf("VerifySession = %q, %v", got, err)
+	}
+
+	// A session signed before a rotation is still accepted.
+	rotated := mustKeyring(t, Key{ID: "k2", Secret: secret(2)}, []Key{{ID: "k1", Secret: secret(1)}})
+	if got, err := rotated.VerifySession(cookie); err != nil || got != session {
+		t.Errorf("after rotation: VerifySession = %q, %v", got, err)
+	}
+
+	other, _ := NewSession()
+	parts := strings.Split(cookie, ".")
+	for name, c := range map[string]string{
+		"empty":           "",
+		"session swapped": other + "." + parts[1] + "." + parts[2],
+		"unknown key":     parts[0] + ".k9." + parts[2],
+		"state MAC":       parts[0] + "." + parts[1] + "." + base64.RawURLEncoding.EncodeToString(mac(secret(1), []byte(parts[0]+"."+parts[1]))),
+	} {
+		if _, err := k.VerifySession(c); !errors.Is(err, ErrSession) {
+			t.Errorf("%s: err = %v, want ErrSession", name, err)
+		}
+	}
+
+	if h := HashSession(session); !ValidSessionHash(h) || h == HashSession(other) {
+		t.Errorf("HashSession(%q) = %q", session, h)
+	}
+	if ValidSessionHash(session[:20]) || ValidSessionHash("not base64!") {
+		t.Error("ValidSessionHash accepted a malformed hash")
+	}
+}
@@ -0,0 +1,586 @@
+// Package fakeidp is an in-memory OAuth 2.0 and OpenID Connect provider for
+// tests. It serves discovery, authorization, token, JWKS, userinfo and
+// introspection endpoints on an httptest server. The authorization endpoint
+// consents at once as the configured user, so a test follows its redirect
+// instead of filling in a login page.
+//
+// Misbehave makes the provider fail the way real ones do: refuse consent,
+// reject or rate-limit token requests, answer with garbage, or issue
+// id_tokens that must not verify.
+package fakeidp
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Endpoint paths, relative to the issuer URL.
+const (
+	DiscoveryPath     = "/.well-known/openid-configuration"
+	AuthorizePath     = "/authorize"
+	TokenPath         = "/token"
+	JWKSPath          = "/jwks"
+	UserInfoPath      = "/userinfo"
+	IntrospectionPath = "/introspect"
+)
+
+// Behavior is how the provider misbehaves. The zero value behaves.
+type Behavior string
+
+const (
+	// Normal answers every request correctly.
+	Normal Behavior = ""
+	// DenyConsent redirects authorization requests back with
+	// error=access_denied, as when the user declines.
+	DenyConsent Behavior = "deny_consent"
+	// InvalidGrant rejects token requests with 400 invalid_grant, as when
+	// a code was used or a refresh token revoked.
+	InvalidGrant Behavior = "invalid_grant"
+	// ServerError answers token requests with 500.
+	ServerError Behavior = "server_error"
+	// RateLimited answers token requests with 429 and Retry-After: 1.
+	RateLimited Behavior = "rate_limited"
+	// MalformedResponse answers token requests with 200 and a body that is
+	// not JSON.
+	MalformedResponse Behavior = "malformed_response"
+	// BadSignature signs id_tokens with a key the JWKS does not publish.
+	BadSignature Behavior = "bad_signature"
+	// WrongAudience issues id_tokens to another client.
+	WrongAudience Behavior = "wrong_audience"
+	// WrongNonce issues id_tokens with a nonce the client did not send.
+	WrongNonce Behavior = "wrong_nonce"
+	// ExpiredIDToken issues id_tokens that expired an hour ago.
+	ExpiredIDToken Behavior = "expired_id_token"
+)
+
+// Config configures the provider. Zero fields take the defaults noted.
+type Config struct {
+	// ClientID and ClientSecret are the one client's credentials
+	// ("client-id" and "client-secret"). The secret may be sent with HTTP
+	// Basic auth or in the form.
+	ClientID     string
+	ClientSecret string
+	// Subject and Email identify the user who consents ("user-1" and
+	// "user@example.com").
+	Subject string
+	Email   string
+	// AccessTokenTTL is the lifetime of access tokens (1h).
+	AccessTokenTTL time.Duration
+	// RotateRefreshTokens issues a new refresh token on every refresh and
+	// invalidates the one used.
+	RotateRefreshTokens bool
+	// RequirePKCE rejects authorization requests without an S256
+	// code_challenge.
+	RequirePKCE bool
+}
+
+// IdP is a running fake provider.
+type IdP struct {
+	// URL is the issuer: the server's base URL.
+	URL string
+
+	cfg    Config
+	server *httptest.Server
+	key    *rsa.PrivateKey
+	keyID  string
+
+	mu       sync.Mutex
+	behavior Behavior
+	rogueKey *rsa.PrivateKey
+	codes    map[string]*grant
+	access   map[string]*grant
+	refresh  map[string]*grant
+	requests map[string]int
+	tokenLog []url.Values
+}
+
+// grant is what a code, access token or refresh token was issued for.
+type grant struct {
+	subject, email  string
+	clientID, scope string
+	redirectURI     string
+	nonce           string
+	challenge       string
+	expiresAt       time.Time
+}
+
+// New starts a provider that is closed when t ends.
+func New(t testing.TB, cfg Config) *IdP {
+	t.Helper()
+	if cfg.ClientID == "" {
+		cfg.ClientID = "client-id"
+	}
+	if cfg.ClientSecret == "" {
+		cfg.ClientSecret = "client-secret"
+	}
+	if cfg.Subject == "" {
+		cfg.Subject = "user-1"
+	}
+	if cfg.Email == "" {
+		cfg.Email = "user@example.com"
+	}
+	if cfg.AccessTokenTTL <= 0 {
+		cfg.AccessTokenTTL = time.Hour
+	}
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("fakeidp: %v", err)
+	}
+	p := &IdP{
+		cfg:      cfg,
+		key:      key,
+		keyID:    randomString(8),
+		codes:    map[string]*grant{},
+		access:   map[string]*grant{},
+		refresh:  map[string]*grant{},
+		requests: map[string]int{},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(DiscoveryPath, p.discovery)
+	mux.HandleFunc(AuthorizePath, p.authorize)
+	mux.HandleFunc(TokenPath, p.token)
+	mux.HandleFunc(JWKSPath, p.jwks)
+	mux.HandleFunc(UserInfoPath, p.userinfo)
+	mux.HandleFunc(IntrospectionPath, p.introspect)
+	p.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p.mu.Lock()
+		p.requests[r.URL.Path]++
+		p.mu.Unlock()
+		mux.ServeHTTP(w, r)
+	}))
+	p.URL = p.server.URL
+	t.Cleanup(p.server.Close)
+	return p
+}
+
+// ClientID returns the client's ID.
+func (p *IdP) ClientID() string { return p.cfg.ClientID }
+
+// ClientSecret returns the client's secret.
+func (p *IdP) ClientSecret() string { return p.cfg.ClientSecret }
+
+// AuthURL returns the authorization endpoint.
+func (p *IdP) AuthURL() string { return p.URL + AuthorizePath }
+
+// TokenURL returns the token endpoint.
+func (p *IdP) TokenURL() string { return p.URL + TokenPath }
+
+// Misbehave makes the provider behave as b until it is called again.
+// Misbehave(Normal) restores it.
+func (p *IdP) Misbehave(b Behavior) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.behavior = b
+}
+
+// Requests returns how many requests reached the endpoint at path, e.g.
+// TokenPath.
+func (p *IdP) Requests(path string) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.requests[path]
+}
+
+// TokenRequests returns the form of every token request, oldest first,
+// with client_secret removed.
+func (p *IdP) TokenRequests() []url.Values {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]url.Values(nil), p.tokenLog...)
+}
+
+// RevokeTokens invalidates every access and refresh token issued, as when
+// the user withdraws the client's access.
+func (p *IdP) RevokeTokens() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.access = map[string]*grant{}
+	p.refresh = map[string]*grant{}
+}
+
+func (p *IdP) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"issuer":                                p.URL,
+		"authorization_endpoint":                p.URL + AuthorizePath,
+		"token_endpoint":                        p.URL + TokenPath,
+		"jwks_uri":                              p.URL + JWKSPath,
+		"userinfo_endpoint":                     p.URL + UserInfoPath,
+		"introspection_endpoint":                p.URL + IntrospectionPath,
+		"response_types_supported":              []string{"code"},
+		"grant_types_supported":                 []string{"authorization_code", "refresh_token", "client_credentials"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+		"token_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post"},
+		"scopes_supported":                      []string{"openid", "email", "profile", "offline_access"},
+	})
+}
+
+// authorize consents as the configured user and redirects back with a
+// code. Requests it cannot redirect back to get a 400.
+func (p *IdP) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	redirectURI, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil || !redirectURI.IsAbs() {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+	if q.Get("client_id") != p.cfg.ClientID {
+		http.Error(w, "unknown client_id", http.StatusBadRequest)
+		return
+	}
+	back := redirectURI.Query()
+	back.Set("state", q.Get("state"))
+	redirect := func() {
+		redirectURI.RawQuery = back.Encode()
+		http.Redirect(w, r, redirectURI.String(), http.StatusFound)
+	}
+
+	switch {
+	case q.Get("response_type") != "code":
+		back.Set("error", "unsupported_response_type")
+	case q.Get("code_challenge") != "" && q.Get("code_challenge_method") != "S256":
+		back.Set("error", "invalid_request")
+		back.Set("error_description", "code_challenge_method must be S256")
+	case p.cfg.RequirePKCE && q.Get("code_challenge") == "":
+		back.Set("error", "invalid_request")
+		back.Set("error_description", "code_challenge required")
+	case p.currentBehavior() == DenyConsent:
+		back.Set("error", "access_denied")
+		back.Set("error_description", "The user declined")
+	default:
+		code := randomString(16)
+		p.mu.Lock()
+		p.codes[code] = &grant{
+			subject:     p.cfg.Subject,
+			email:       p.cfg.Email,
+			clientID:    p.cfg.ClientID,
+			scope:       q.Get("scope"),
+			redirectURI: q.Get("redirect_uri"),
+			nonce:       q.Get("nonce"),
+			challenge:   q.Get("code_challenge"),
+			expiresAt:   time.Now().Add(time.Minute),
+		}
+		p.mu.Unlock()
+		back.Set("code", code)
+	}
+	redirect()
+}
+
+func (p *IdP) token(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		tokenError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+	logged := url.Values{}
+	for k, v := range r.PostForm {
+		if k != "client_secret" {
+			logged[k] = v
+		}
+	}
+	p.mu.Lock()
+	p.tokenLog = append(p.tokenLog, logged)
+	p.mu.Unlock()
+
+	switch p.currentBehavior() {
+	case InvalidGrant:
+		tokenError(w, http.StatusBadRequest, "invalid_grant", "The grant is invalid or was revoked")
+		return
+	case ServerError:
+		tokenError(w, http.StatusInternalServerError, "server_error", "Internal error")
+		return
+	case RateLimited:
+		w.Header().Set("Retry-After", "1")
+		tokenError(w, http.StatusTooManyRequests, "slow_down", "Too many requests")
+		return
+	case MalformedResponse:
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte("<html>upstream error</html>"))
+		return
+	}
+
+	if !p.authenticateClient(r) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="fakeidp"`)
+		tokenError(w, http.StatusUnauthorized, "invalid_client", "Client authentication failed")
+		return
+	}
+
+	switch r.PostForm.Get("grant_type") {
+	case "authorization_code":
+		p.mu.Lock()
+		g, ok := p.codes[r.PostForm.Get("code")]
+		delete(p.codes, r.PostForm.Get("code"))
+		p.mu.Unlock()
+		if !ok || time.Now().After(g.expiresAt) {
+			tokenError(w, http.StatusBadRequest, "invalid_grant", "Unknown or expired code")
+			return
+		}
+		if r.PostForm.Get("redirect_uri") != g.redirectURI {
+			tokenError(w, http.StatusBadRequest, "invalid_grant", "redirect_uri does not match the authorization request")
+			return
+		}
+		if g.challenge != "" && !verifierMatches(r.PostForm.Get("code_verifier"), g.challenge) {
+			tokenError(w, http.StatusBadRequest, "invalid_grant", "code_verifier does not match code_challenge")
+			return
+		}
+		p.issue(w, g, true, true)
+	case "refresh_token":
+		p.mu.Lock()
+		g, ok := p.refresh[r.PostForm.Get("refresh_token")]
+		if ok && p.cfg.RotateRefreshTokens {
+			delete(p.refresh, r.PostForm.Get("refresh_token"))
+		}
+		p.mu.Unlock()
+		if !ok {
+			tokenError(w, http.StatusBadRequest, "invalid_grant", "Unknown refresh token")
+			return
+		}
+		p.issue(w, g, p.cfg.RotateRefreshTokens, false)
+	case "client_credentials":
+		p.issue(w, &grant{subject: p.cfg.ClientID, clientID: p.cfg.ClientID, scope: r.PostForm.Get("scope")}, false, false)
+	default:
+		tokenError(w, http.StatusBadRequest, "unsupported_grant_type", "grant_type "+r.PostForm.Get("grant_type")+" is not supported")
+	}
+}
+
+// issue answers a token request for g with a new access token, and a new
+// refresh token and an id_token when asked for and g's scope allows.
+func (p *IdP) issue(w http.ResponseWriter, g *grant, withRefresh, withIDToken bool) {
+	now := time.Now()
+	access := *g
+	access.expiresAt = now.Add(p.cfg.AccessTokenTTL)
+	accessToken, err := p.sign(p.key, map[string]any{
+		"iss":       p.URL,
+		"sub":       g.subject,
+		"aud":       g.clientID,
+		"client_id": g.clientID,
+		"scope":     g.scope,
+		"iat":       now.Unix(),
+		"exp":       access.expiresAt.Unix(),
+		"jti":       randomString(8),
+	})
+	if err != nil {
+		tokenError(w, http.StatusInternalServerError, "server_error", err.Error())
+		return
+	}
+	resp := map[string]any{
+		"access_token": accessToken,
+		"token_type":   "Bearer",
+		"expires_in":   int(p.cfg.AccessTokenTTL.Seconds()),
+		"scope":        g.scope,
+	}
+
+	p.mu.Lock()
+	p.access[accessToken] = &access
+	if withRefresh && g.subject != g.clientID {
+		refreshToken := randomString(24)
+		p.refresh[refreshToken] = g
+		resp["refresh_token"] = refreshToken
+	}
+	behavior := p.behavior
+	p.mu.Unlock()
+
+	if withIDToken && hasScope(g.scope, "openid") {
+		claims := map[string]any{
+			"iss":   p.URL,
+			"sub":   g.subject,
+			"aud":   g.clientID,
+			"iat":   now.Unix(),
+			"exp":   now.Add(time.Hour).Unix(),
+			"email": g.email,
+		}
+		if g.nonce != "" {
+			claims["nonce"] = g.nonce
+		}
+		key := p.key
+		switch behavior {
+		case BadSignature:
+			if key, err = p.rogue(); err != nil {
+				tokenError(w, http.StatusInternalServerError, "server_error", err.Error())
+				return
+			}
+		case WrongAudience:
+			claims["aud"] = "another-client"
+		case WrongNonce:
+			claims["nonce"] = "another-nonce"
+		case ExpiredIDToken:
+			claims["iat"] = now.Add(-2 * time.Hour).Unix()
+			claims["exp"] = now.Add(-time.Hour).Unix()
+		}
+		idToken, err := p.sign(key, claims)
+		if err != nil {
+			tokenError(w, http.StatusInternalServerError, "server_error", err.Error())
+			return
+		}
+		resp["id_token"] = idToken
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (p *IdP) jwks(w http.ResponseWriter, r *http.Request) {
+	pub := p.key.PublicKey
+	writeJSON(w, http.StatusOK, map[string]any{"keys": []map[string]string{{
+		"kty": "RSA",
+		"use": "sig",
+		"alg": "RS256",
+		"kid": p.keyID,
+		"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+	}}})
+}
+
+func (p *IdP) userinfo(w http.ResponseWriter, r *http.Request) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	g := p.lookupAccess(token)
+	if !ok || g == nil {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		http.Error(w, "invalid token", http.StatusUnauthorized)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"sub": g.subject, "email": g.email, "email_verified": true})
+}
+
+// introspect answers RFC 7662 introspection requests from the client.
+func (p *IdP) introspect(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil || r.Method != http.MethodPost {
+		tokenError(w, http.StatusBadRequest, "invalid_request", "POST a form with token")
+		return
+	}
+	if !p.authenticateClient(r) {
+		tokenError(w, http.StatusUnauthorized, "invalid_client", "Client authentication failed")
+		return
+	}
+	token := r.PostForm.Get("token")
+	if g := p.lookupAccess(token); g != nil {
+		writeJSON(w, http.StatusOK, map[string]any{
+			"active": true, "token_type": "Bearer", "scope": g.scope, "client_id": g.clientID,
+			"sub": g.subject, "exp": g.expiresAt.Unix(),
+		})
+		return
+	}
+	p.mu.Lock()
+	g, ok := p.refresh[token]
+	p.mu.Unlock()
+	if ok {
+		writeJSON(w, http.StatusOK, map[string]any{
+			"active": true, "token_type": "refresh_token", "scope": g.scope, "client_id": g.clientID, "sub": g.subject,
+		})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"active": false})
+}
+
+// lookupAccess returns the grant of an unexpired access token, or nil.
+func (p *IdP) lookupAccess(token string) *grant {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	g, ok := p.access[token]
+	if !ok || time.Now().After(g.expiresAt) {
+		return nil
+	}
+	return g
+}
+
+func (p *IdP) authenticateClient(r *http.Request) bool {
+	id, secret, ok := r.BasicAuth()
+	if ok {
+		// RFC 6749 2.3.1: Basic credentials are form-urlencoded.
+		id, _ = url.QueryUnescape(id)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		id, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	return id == p.cfg.ClientID && subtle.ConstantTimeCompare([]byte(secret), []byte(p.cfg.ClientSecret)) == 1
+}
+
+func (p *IdP) currentBehavior() Behavior {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.behavior
+}
+
+// rogue returns the key BadSignature signs with, which the JWKS never
+// publishes.
+func (p *IdP) rogue() (*rsa.PrivateKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.rogueKey == nil {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return nil, err
+		}
+		p.rogueKey = key
+	}
+	return p.rogueKey, nil
+}
+
+// sign returns an RS256 JWT of claims under the published key ID.
+func (p *IdP) sign(key *rsa.PrivateKey, claims map[string]any) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": p.keyID})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signingInput))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", fmt.Errorf("fakeidp: sign: %w", err)
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// verifierMatches reports whether verifier hashes to the S256 challenge.
+func verifierMatches(verifier, challenge string) bool {
+	sum := sha256.Sum256([]byte(verifier))
+	return verifier != "" && base64.RawURLEncoding.EncodeToString(sum[:]) == challenge
+}
+
+func hasScope(scope, want string) bool {
+	for _, s := range strings.Fields(scope) {
+		if s == want {
+			return true
+		}
+	}
+	return false
+}
+
+func tokenError(w http.ResponseWriter, status int, code, description string) {
+	writeJSON(w, status, map[string]string{"error": code, "error_description": description})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func randomString(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,275 @@
+package fakeidp
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// noRedirect is a client that returns redirects instead of following them.
+var noRedirect = &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+
+// authorize runs an authorization request and returns the callback query.
+func authorize(t *testing.T, p *IdP, params url.Values) url.Values {
+	t.Helper()
+	q := url.Values{"response_type": {"code"}, "client_id": {p.ClientID()}, "redirect_uri": {"http://app.test/cb"}, "state": {"st"}}
+	for k, v := range params {
+		q[k] = v
+	}
+	resp, err := noRedirect.Get(p.AuthURL() + "?" + q.Encode())
+	if err != nil {
+		t.Fatalf("authorize: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("authorize = %d", resp.StatusCode)
+	}
+	loc, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil || loc.Host != "app.test" {
+		t.Fatalf("Location = %q", resp.Header.Get("Location"))
+	}
+	if loc.Query().Get("state") != "st" {
+		t.Errorf("state = %q", loc.Query().Get("state"))
+	}
+	return loc.Query()
+}
+
+// exchange posts form to the token endpoint with the client's secret.
+func exchange(t *testing.T, p *IdP, form url.Values) (int, map[string]any) {
+	t.Helper()
+	form.Set("client_id", p.ClientID())
+	form.Set("client_secret", p.ClientSecret())
+	resp, err := http.PostForm(p.TokenURL(), form)
+	if err != nil {
+		t.Fatalf("token: %v", err)
+	}
+	defer resp.Body.Close()
+	var body map[string]any
+	json.NewDecoder(resp.Body).Decode(&body)
+	return resp.StatusCode, body
+}
+
+// claims verifies jwt against the provider's JWKS and returns its claims.
+func claims(t *testing.T, p *IdP, jwt string) (map[string]any, bool) {
+	t.Helper()
+	resp, err := http.Get(p.URL + JWKSPath)
+	if err != nil {
+		t.Fatalf("jwks: %v", err)
+	}
+	defer resp.Body.Close()
+	var set struct {
+		Keys []struct{ N, E string } `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil || len(set.Keys) != 1 {
+		t.Fatalf("jwks = %+v, %v", set, err)
+	}
+	n, _ := base64.RawURLEncoding.DecodeString(set.Keys[0].N)
+	e, _ := base64.RawURLEncoding.DecodeString(set.Keys[0].E)
+	pub := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+
+	parts := strings.Split(jwt, ".")
+	if len(parts) != 3 {
+		t.Fatalf("jwt %q", jwt)
+	}
+	payload, _ := base64.RawURLEncoding.DecodeString(parts[1])
+	sig, _ := base64.RawURLEncoding.DecodeString(parts[2])
+	var c map[string]any
+	if err := json.Unmarshal(payload, &c); err != nil {
+		t.Fatalf("claims: %v", err)
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	return c, rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], sig) == nil
+}
+
+func challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func TestDiscovery(t *testing.T) {
+	p := New(t, Config{})
+	resp, err := http.Get(p.URL + DiscoveryPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var doc map[string]any
+	json.NewDecoder(resp.Body).Decode(&doc)
+	if doc["issuer"] != p.URL || doc["token_endpoint"] != p.TokenURL() || doc["jwks_uri"] != p.URL+JWKSPath {
+		t.Errorf("discovery = %v", doc)
+	}
+}
+
+func TestAuthorizationCodeFlow(t *testing.T) {
+	p := New(t, Config{Subject: "alice", RequirePKCE: true})
+	cb := authorize(t, p, url.Values{
+		"scope":                 {"openid email"},
+		"nonce":                 {"n-1"},
+		"code_challenge":        {challenge("verifier")},
+		"code_challenge_method": {"S256"},
+	})
+	code := cb.Get("code")
+	if code == "" {
+		t.Fatalf("callback = %v", cb)
+	}
+
+	// A wrong verifier spends the code.
+	status, body := exchange(t, p, url.Values{"grant_type": {"authorization_code"}, "code": {code}, "redirect_uri": {"http://app.test/cb"}, "code_verifier": {"wrong"}})
+	if status != http.StatusBadRequest || body["error"] != "invalid_grant" {
+		t.Fatalf("wrong verifier = %d %v", status, body)
+	}
+	code = authorize(t, p, url.Values{"scope": {"openid email"}, "nonce": {"n-1"}, "code_challenge": {challenge("verifier")}, "code_challenge_method": {"S256"}}).Get("code")
+	status, body = exchange(t, p, url.Values{"grant_type": {"authorization_code"}, "code": {code}, "redirect_uri": {"http://app.test/cb"}, "code_verifier": {"verifier"}})
+	if status != http.StatusOK || body["refresh_token"] == nil {
+		t.Fatalf("exchange = %d %v", status, body)
+	}
+	id, ok := claims(t, p, body["id_token"].(string))
+	if !ok || id["sub"] != "alice" || id["aud"] != p.ClientID() || id["nonce"] != "n-1" || id["iss"] != p.URL {
+		t.Errorf("id_token = %v, verified %v", id, ok)
+	}
+	if p.TokenRequests()[0].Get("client_secret") != "" {
+		t.Error("TokenRequests kept client_secret")
+	}
+
+	// The code is single use.
+	status, _ = exchange(t, p, url.Values{"grant_type": {"authorization_code"}, "code": {code}, "redirect_uri": {"http://app.test/cb"}, "code_verifier": {"verifier"}})
+	if status != http.StatusBadRequest {
+		t.Errorf("code reuse = %d", status)
+	}
+
+	// The access token works at userinfo and introspection.
+	req, _ := http.NewRequest("GET", p.URL+UserInfoPath, nil)
+	req.Header.Set("Authorization", "Bearer "+body["access_token"].(string))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var info map[string]any
+	json.NewDecoder(resp.Body).Decode(&info)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || info["sub"] != "alice" {
+		t.Errorf("userinfo = %d %v", resp.StatusCode, info)
+	}
+	introspect := func(token string) map[string]any {
+		form := url.Values{"token": {token}}
+		req, _ := http.NewRequest("POST", p.URL+IntrospectionPath, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth(p.ClientID(), p.ClientSecret())
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var out map[string]any
+		json.NewDecoder(resp.Body).Decode(&out)
+		return out
+	}
+	if got := introspect(body["access_token"].(string)); got["active"] != true || got["sub"] != "alice" {
+		t.Errorf("introspect = %v", got)
+	}
+
+	p.RevokeTokens()
+	if got := introspect(body["access_token"].(string)); got["active"] != false {
+		t.Errorf("introspect after revoke = %v", got)
+	}
+	if p.Requests(TokenPath) != 3 || p.Requests(IntrospectionPath) != 2 {
+		t.Errorf("requests: token %d, introspect %d", p.Requests(TokenPath), p.Requests(IntrospectionPath))
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	p := New(t, Config{RotateRefreshTokens: true})
+	code := authorize(t, p, url.Values{"scope": {"offline_access"}}).Get("code")
+	_, first := exchange(t, p, url.Values{"grant_type": {"authorization_code"}, "code": {code}, "redirect_uri": {"http://app.test/cb"}})
+	if first["id_token"] != nil {
+		t.Error("id_token issued without openid")
+	}
+	status, second := exchange(t, p, url.Values{"grant_type": {"refresh_token"}, "refresh_token": {first["refresh_token"].(string)}})
+	if status != http.StatusOK || second["access_token"] == first["access_token"] || second["refresh_token"] == first["refresh_token"] {
+		t.Fatalf("refresh = %d %v", status, second)
+	}
+	status, body := exchange(t, p, url.Values{"grant_type": {"refresh_token"}, "refresh_token": {first["refresh_token"].(string)}})
+	if status != http.StatusBadRequest || body["error"] != "invalid_grant" {
+		t.Errorf("rotated refresh token = %d %v", status, body)
+	}
+}
+
+func TestClientCredentials(t *testing.T) {
+	p := New(t, Config{})
+	status, body := exchange(t, p, url.Values{"grant_type": {"client_credentials"}, "scope": {"agents"}})
+	if status != http.StatusOK || body["refresh_token"] != nil {
+		t.Fatalf("client_credentials = %d %v", status, body)
+	}
+	c, ok := claims(t, p, body["access_token"].(string))
+	if !ok || c["sub"] != p.ClientID() || c["scope"] != "agents" {
+		t.Errorf("access token = %v, verified %v", c, ok)
+	}
+
+	form := url.Values{"grant_type": {"client_credentials"}, "client_id": {p.ClientID()}, "client_secret": {"wrong"}}
+	resp, err := http.PostForm(p.TokenURL(), form)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("wrong secret = %d", resp.StatusCode)
+	}
+}
+
+func TestMisbehave(t *testing.T) {
+	p := New(t, Config{})
+
+	p.Misbehave(DenyConsent)
+	if cb := authorize(t, p, nil); cb.Get("error") != "access_denied" || cb.Get("code") != "" {
+		t.Errorf("DenyConsent callback = %v", cb)
+	}
+
+	for b, want := range map[Behavior]int{
+		InvalidGrant: http.StatusBadRequest,
+		ServerError:  http.StatusInternalServerError,
+		RateLimited:  http.StatusTooManyRequests,
+	} {
+		p.Misbehave(b)
+		if status, _ := exchange(t, p, url.Values{"grant_type": {"client_credentials"}}); status != want {
+			t.Errorf("%s = %d, want %d", b, status, want)
+		}
+	}
+	p.Misbehave(MalformedResponse)
+	resp, err := http.PostForm(p.TokenURL(), url.Values{"grant_type": {"client_credentials"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v any
+	if resp.StatusCode != http.StatusOK || json.NewDecoder(resp.Body).Decode(&v) == nil {
+		t.Errorf("MalformedResponse = %d, decodes", resp.StatusCode)
+	}
+	resp.Body.Close()
+
+	idToken := func(b Behavior) (map[string]any, bool) {
+		p.Misbehave(Normal)
+		code := authorize(t, p, url.Values{"scope": {"openid"}, "nonce": {"n-1"}}).Get("code")
+		p.Misbehave(b)
+		_, body := exchange(t, p, url.Values{"grant_type": {"authorization_code"}, "code": {code}, "redirect_uri": {"http://app.test/cb"}})
+		return claims(t, p, body["id_token"].(string))
+	}
+	if _, ok := idToken(BadSignature); ok {
+		t.Error("BadSignature id_token verified")
+	}
+	if c, ok := idToken(WrongAudience); !ok || c["aud"] == p.ClientID() {
+		t.Errorf("WrongAudience id_token = %v", c)
+	}
+	if c, _ := idToken(WrongNonce); c["nonce"] == "n-1" {
+		t.Errorf("WrongNonce id_token = %v", c)
+	}
+	if c, _ := idToken(ExpiredIDToken); c["exp"].(float64) > float64(time.Now().Unix()) {
+		t.Errorf("ExpiredIDToken id_token = %v", c)
+	}
+}
@@ -0,0 +1,101 @@
+package api
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+// TestSpec_IsValid checks what client generators trip over: every $ref
+// resolves, every operation has an operationId (unique) and responses, and
+// every path parameter is declared. pkg/server checks the spec against the
+// routes.
+func TestSpec_IsValid(t *testing.T) {
+	var doc map[string]any
+	if err := yaml.Unmarshal(Spec, &doc); err != nil {
+		t.Fatalf("spec does not parse: %v", err)
+	}
+	if v, _ := doc["openapi"].(string); !strings.HasPrefix(v, "3.0.") {
+		t.Fatalf("expected an OpenAPI 3.0 document, got %q", v)
+	}
+
+	pathParam := regexp.MustCompile(`\{([^}]+)\}`)
+	operationIDs := map[string]string{}
+	paths, _ := doc["paths"].(map[string]any)
+	for path, item := range paths {
+		item, _ := item.(map[string]any)
+		shared := pathParams(item["parameters"])
+		for method, op := range item {
+			if method == "parameters" {
+				continue
+			}
+			op, _ := op.(map[string]any)
+			name := strings.ToUpper(method) + " " + path
+			if id, _ := op["operationId"].(string); id == "" {
+				t.Errorf("%s has no operationId", name)
+			} else if other, dup := operationIDs[id]; dup {
+				t.Errorf("%s and %s share operationId %s", name, other, id)
+			} else {
+				operationIDs[id] = name
+			}
+			if responses, _ := op["responses"].(map[string]any); len(responses) == 0 {
+				t.Errorf("%s has no responses", name)
+			}
+			declared := pathParams(op["parameters"])
+			for _, m := range pathParam.FindAllStringSubmatch(path, -1) {
+				if !declared[m[1]] && !shared[m[1]] {
+					t.Errorf("%s does not declare path parameter %s", name, m[1])
+				}
+			}
+		}
+	}
+
+	var walk func(v any)
+	walk = func(v any) {
+		switch v := v.(type) {
+		case map[string]any:
+			if ref, ok := v["$ref"].(string); ok && resolve(doc, ref) == nil {
+				t.Errorf("unresolved $ref %s", ref)
+			}
+			for _, e := range v {
+				walk(e)
+			}
+		case []any:
+			for _, e := range v {
+				walk(e)
+			}
+		}
+	}
+	walk(doc)
+}
+
+// pathParams returns the names of the in: path entries of a parameters list.
+func pathParams(v any) map[string]bool {
+	names := map[string]bool{}
+	list, _ := v.([]any)
+	for _, p := range list {
+		if p, ok := p.(map[string]any); ok && p["in"] == "path" {
+			name, _ := p["name"].(string)
+			names[name] = true
+		}
+	}
+	return names
+}
+
+// resolve follows a local JSON pointer such as #/components/schemas/Name.
+func resolve(doc map[string]any, ref string) any {
+	if !strings.HasPrefix(ref, "#/") {
+		return nil
+	}
+	var cur any = doc
+	for _, part := range strings.Split(ref[2:], "/") {
+		m, ok := cur.(map[string]any)
+		if !ok {
+			return nil
+		}
+		cur = m[strings.ReplaceAll(strings.ReplaceAll(part, "~1", "/"), "~0", "~")]
+	}
+	return cur
+}
@@ -0,0 +1,231 @@
+// Command genclients generates the TypeScript and Python clients of the
+// Gateway REST API from api/openapi.yaml with openapi-generator, and
+// TypeScript and Python protobuf stubs for nexus-grpc from api/proto with buf.
+// Run it from nexus-gateway:
+//
+//	go run ./cmd/genclients [-version 0.1.5] [-lang typescript,python] [-out gen/clients]
+//
+// Each client is written to its own package directory under -out, ready for
+// npm publish or python -m build; the protobuf stubs go to -out/proto and are
+// not part of the packages. Hand-written helpers that mirror the Go SDK
+// (waitForActive / wait_for_active) are copied from overlay/ into each
+// package.
+//
+// Before generating, the spec is checked for every operation the Go SDK
+// calls, so the generated clients cannot silently fall behind it.
+package main
+
+import (
+	"embed"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// overlay holds the files copied into each generated package, by language.
+//
+//go:embed overlay
+var overlay embed.FS
+
+// sdkOperations maps the nexus-sdk Client methods that wrap a single call
+// to the operationId the generated clients expose for it.
+var sdkOperations = map[string]string{
+	"RequestConnection": "requestConnection",
+	"CheckConnection":   "checkConnection",
+	"GetToken":          "getToken",
+	"RefreshConnection": "refreshConnection",
+	"ExchangeToken":     "exchangeToken",
+	"CreateGrant":       "createGrant",
+	"RedeemGrant":       "redeemGrant",
+	"ListProviders":     "getProviders",
+	"ServerInfo":        "getServerInfo",
+}
+
+// client describes one generated package.
+type client struct {
+	// generator is the openapi-generator generator name.
+	generator string
+	// properties are its additional properties, given the package version.
+	properties func(version string) []string
+	// exports is appended to the package entry point to export the
+	// overlay helpers.
+	entryPoint, exports string
+}
+
+var clients = map[string]client{
+	"typescript": {
+		generator: "typescript-fetch",
+		properties: func(version string) []string {
+			return []string{"npmName=@prescott-data/nexus-gateway-client", "npmVersion=" + version, "supportsES6=true"}
+		},
+		entryPoint: "src/index.ts",
+		exports:    "export * from './nexus';\n",
+	},
+	"python": {
+		generator: "python",
+		properties: func(version string) []string {
+			return []string{"packageName=nexus_gateway_client", "projectName=nexus-gateway-client", "packageVersion=" + version}
+		},
+		entryPoint: "nexus_gateway_client/__init__.py",
+		exports:    "from nexus_gateway_client.nexus import wait_for_active\n",
+	},
+}
+
+func main() {
+	out := flag.String("out", "gen/clients", "output directory")
+	spec := flag.String("spec", "api/openapi.yaml", "OpenAPI document of the REST API")
+	version := flag.String("version", "", "package version (default: the repository's VERSION file)")
+	langs := flag.String("lang", "typescript,python", "comma-separated clients to generate")
+	generator := flag.String("openapi-generator", "npx --yes @openapitools/openapi-generator-cli", "openapi-generator command")
+	buf := flag.String("buf", "buf", "buf command")
+	skipProto := flag.Bool("skip-proto", false, "do not generate protobuf stubs")
+	flag.Parse()
+
+	if *version == "" {
+		v, err := readVersion("../VERSION")
+		if err != nil {
+			log.Fatal(err)
+		}
+		*version = v
+	}
+	*version = strings.TrimPrefix(strings.TrimSpace(*version), "v")
+
+	doc, err := os.ReadFile(*spec)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if missing, err := missingOperations(doc); err != nil {
+		log.Fatal(err)
+	} else if len(missing) > 0 {
+		log.Fatalf("%s lacks operations the Go SDK calls: %s", *spec, strings.Join(missing, ", "))
+	}
+
+	for _, lang := range strings.Split(*langs, ",") {
+		lang = strings.TrimSpace(lang)
+		c, ok := clients[lang]
+		if !ok {
+			log.Fatalf("unknown client %q", lang)
+		}
+		dir := filepath.Join(*out, lang)
+		if err := os.RemoveAll(dir); err != nil {
+			log.Fatal(err)
+		}
+		if err := run(strings.Fields(*generator), c.args(*spec, dir, *version)...); err != nil {
+			log.Fatalf("%s: %v", lang, err)
+		}
+		if err := c.applyOverlay(lang, dir); err != nil {
+			log.Fatalf("%s: %v", lang, err)
+		}
+		log.Printf("%s client %s written to %s", lang, *version, dir)
+	}
+
+	if !*skipProto {
+		dir := filepath.Join(*out, "proto")
+		if err := os.RemoveAll(dir); err != nil {
+			log.Fatal(err)
+		}
+		if err := run(strings.Fields(*buf), "generate", "--template", "buf.gen.clients.yaml", "--output", dir); err != nil {
+			log.Fatalf("proto: %v", err)
+		}
+		log.Printf("protobuf stubs written to %s", dir)
+	}
+}
+
+// args are the openapi-generator arguments that write c to dir.
+func (c client) args(spec, dir, version string) []string {
+	return []string{
+		"generate",
+		"-i", spec,
+		"-g", c.generator,
+		"-o", dir,
+		"--additional-properties", strings.Join(c.properties(version), ","),
+	}
+}
+
+// applyOverlay copies the overlay files for lang into dir and exports them
+// from the package entry point.
+func (c client) applyOverlay(lang, dir string) error {
+	root := "overlay/" + lang
+	err := fs.WalkDir(overlay, root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, err := overlay.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		dst := filepath.Join(dir, filepath.FromSlash(strings.TrimPrefix(path, root+"/")))
+		if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+			return err
+		}
+		return os.WriteFile(dst, data, 0o644)
+	})
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filepath.Join(dir, c.entryPoint), os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(c.exports); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// missingOperations returns the sdkOperations entries, as
+// "Method (operationId)", that the spec does not define.
+func missingOperations(spec []byte) ([]string, error) {
+	var doc struct {
+		Paths map[string]map[string]yaml.Node `yaml:"paths"`
+	}
+	if err := yaml.Unmarshal(spec, &doc); err != nil {
+		return nil, fmt.Errorf("parse spec: %w", err)
+	}
+	ids := map[string]bool{}
+	for _, item := range doc.Paths {
+		for _, op := range item {
+			var o struct {
+				OperationID string `yaml:"operationId"`
+			}
+			if op.Kind == yaml.MappingNode && op.Decode(&o) == nil {
+				ids[o.OperationID] = true
+			}
+		}
+	}
+	var missing []string
+	for method, id := range sdkOperations {
+		if !ids[id] {
+			missing = append(missing, method+" ("+id+")")
+		}
+	}
+	sort.Strings(missing)
+	return missing, nil
+}
+
+func readVersion(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("read version: %w (pass -version)", err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+func run(command []string, args ...string) error {
+	if len(command) == 0 {
+		return fmt.Errorf("empty command")
+	}
+	cmd := exec.Command(command[0], append(command[1:], args...)...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-gateway/api"
+)
+
+func TestSpecCoversSDK(t *testing.T) {
+	missing, err := missingOperations(api.Spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(missing) > 0 {
+		t.Errorf("spec lacks operations the Go SDK calls: %v", missing)
+	}
+
+	missing, err = missingOperations([]byte("paths:\n  /v1/token/{id}:\n    get:\n      operationId: getToken\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if slices.Contains(missing, "GetToken (getToken)") || !slices.Contains(missing, "CheckConnection (checkConnection)") {
+		t.Errorf("unexpected missing operations %v", missing)
+	}
+}
+
+func TestApplyOverlay(t *testing.T) {
+	for lang, c := range clients {
+		t.Run(lang, func(t *testing.T) {
+			dir := t.TempDir()
+			entry := filepath.Join(dir, filepath.FromSlash(c.entryPoint))
+			if err := os.MkdirAll(filepath.Dir(entry), 0o755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(entry, []byte("// generated\n"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if err := c.applyOverlay(lang, dir); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			data, _ := os.ReadFile(entry)
+			if !strings.HasSuffix(string(data), c.exports) {
+				t.Errorf("helpers not exported: %q", data)
+			}
+			helpers, _ := filepath.Glob(filepath.Join(filepath.Dir(entry), "nexus.*"))
+			if len(helpers) != 1 {
+				t.Errorf("expected the overlay helper next to %s, got %v", c.entryPoint, helpers)
+			}
+		})
+	}
+}
+
+func TestClientArgs(t *testing.T) {
+	args := strings.Join(clients["python"].args("api/openapi.yaml", "gen/clients/python", "0.1.5"), " ")
+	want := "generate -i api/openapi.yaml -g python -o gen/clients/python --additional-properties packageName=nexus_gateway_client,projectName=nexus-gateway-client,packageVersion=0.1.5"
+	if args != want {
+		t.Errorf("got  %s\nwant %s", args, want)
+	}
+}
@@ -0,0 +1,27 @@
+"""Helpers the Go SDK (nexus-sdk) offers on top of single API calls.
+
+This file is copied into the generated client by cmd/genclients; edit it there.
+"""
+
+import time
+from typing import Optional
+
+from nexus_gateway_client.api.default_api import DefaultApi
+
+
+def wait_for_active(api: DefaultApi, connection_id: str, interval: float = 1.5, timeout: Optional[float] = None) -> str:
+    """Poll check-connection until the connection is no longer pending.
+
+    Returns that status, like Client.WaitForActive in Go. Raises TimeoutError
+    if the connection is still pending after timeout seconds.
+    """
+    if interval <= 0:
+        interval = 1.5
+    deadline = None if timeout is None else time.monotonic() + timeout
+    while True:
+        status = api.check_connection(connection_id).status
+        if status != "pending":
+            return status
+        if deadline is not None and time.monotonic() + interval > deadline:
+            raise TimeoutError(f"connection {connection_id} is still {status}")
+        time.sleep(interval)
@@ -0,0 +1,31 @@
+// Helpers the Go SDK (nexus-sdk) offers on top of single API calls. This
+// file is copied into the generated client by cmd/genclients; edit it there.
+
+import { DefaultApi } from './apis/index';
+
+export interface WaitForActiveOptions {
+    // Poll interval in milliseconds (default 1500).
+    intervalMs?: number;
+    // Aborts the wait and any request in flight.
+    signal?: AbortSignal;
+}
+
+// waitForActive polls check-connection until the connection is no longer
+// pending (active, failed, expired, ...) and returns that status, like
+// Client.WaitForActive in Go.
+export async function waitForActive(api: DefaultApi, connectionId: string, opts: WaitForActiveOptions = {}): Promise<string> {
+    const intervalMs = opts.intervalMs && opts.intervalMs > 0 ? opts.intervalMs : 1500;
+    for (;;) {
+        const { status } = await api.checkConnection({ connectionId }, { signal: opts.signal });
+        if (status !== 'pending') {
+            return status;
+        }
+        await new Promise<void>((resolve, reject) => {
+            const timer = setTimeout(resolve, intervalMs);
+            opts.signal?.addEventListener('abort', () => {
+                clearTimeout(timer);
+                reject(opts.signal?.reason);
+            }, { once: true });
+        });
+    }
+}
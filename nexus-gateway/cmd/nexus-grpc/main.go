@@ -2,7 +2,7 @@ package main
 
 import (
 	"context"
-	"encoding/base64"
+	"flag"
 	"log"
 	"net/http"
 	"os"
@@ -10,47 +10,45 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/Prescott-Data/nexus-framework/nexus-sdk/redact"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-gateway/pkg/auth"
+	"github.com/Prescott-Data/nexus-framework/nexus-gateway/pkg/config"
 	grpcsrv "github.com/Prescott-Data/nexus-framework/nexus-gateway/pkg/grpc"
 	"github.com/Prescott-Data/nexus-framework/nexus-gateway/pkg/usecase"
 )
 
-var Version = "dev"
+// Build metadata, set with -ldflags "-X main.Version=... -X main.GitCommit=...
+// -X main.BuildDate=...".
+var (
+	Version   = "dev"
+	GitCommit = "unknown"
+	BuildDate = "unknown"
+)
 
 func main() {
+	// Scrub tokens and secrets that reach the log through error messages.
+	log.SetOutput(redact.NewWriter(os.Stderr))
+
 	if len(os.Args) > 1 && (os.Args[1] == "-v" || os.Args[1] == "--version") {
 		log.Printf("Nexus Gateway (gRPC) version: %s", Version)
 		os.Exit(0)
 	}
 
-	portHTTP := getEnv("PORT_HTTP", "8090")
-	portGRPC := getEnv("PORT_GRPC", "9090")
-	brokerBaseURL := getEnv("BROKER_BASE_URL", "http://localhost:8080")
-	stateKeyStr := getEnv("STATE_KEY", "")
+	configPath := flag.String("config", os.Getenv("CONFIG_FILE"), "path to a YAML config file (env vars override its values)")
+	printConfig := flag.Bool("print-config", false, "print the effective configuration with defaults and exit")
+	flag.Parse()
 
-	if brokerBaseURL == "" {
-		log.Fatal("BROKER_BASE_URL is required")
+	if *printConfig {
+		if err := config.PrintConfig(os.Stdout, *configPath); err != nil {
+			log.Fatal(err)
+		}
+		os.Exit(0)
 	}
 
-	if stateKeyStr == "" {
-		log.Fatal(
-			"STATE_KEY is not set. " +
-				"This key must match the Broker's STATE_KEY for HMAC state verification. " +
-				"Generate one with: openssl rand -base64 32",
-		)
-	}
-	stateKey, err := base64.StdEncoding.DecodeString(stateKeyStr)
+	cfg, err := config.LoadFile(*configPath)
 	if err != nil {
-		log.Fatal(
-			"STATE_KEY is not valid base64. " +
-				"Expected a base64-encoded 32-byte key. " +
-				"Generate one with: openssl rand -base64 32",
-		)
-	}
-	if len(stateKey) != 32 {
-		log.Fatalf(
-			"STATE_KEY decoded to %d bytes, expected exactly 32. "+
-				"Generate one with: openssl rand -base64 32", len(stateKey),
-		)
+		log.Fatal(err)
 	}
 
 	transport := &http.Transport{
@@ -59,13 +57,48 @@ func main() {
 		IdleConnTimeout:     90 * time.Second,
 		DisableCompression:  false,
 	}
-	httpClient := &http.Client{Timeout: 30 * time.Second, Transport: transport}
-	handler := usecase.NewHandler(brokerBaseURL, stateKey, httpClient)
+	// Requests bound Broker calls by their route's timeout; the client's
+	// only needs to cover the longest.
+	httpClient := &http.Client{Timeout: cfg.Timeouts.Max(), Transport: transport}
+	opts := []usecase.HandlerOption{
+		usecase.WithBrokerAPIKey(cfg.BrokerAPIKey),
+		usecase.WithMetadataCache(cfg.MetadataCacheTTL, cfg.MetadataCacheStale),
+	}
+	if cfg.BrokerGRPC.Addr != "" {
+		brokerConns, err := usecase.DialBroker(cfg.BrokerGRPC.Addr, cfg.BrokerGRPC.PoolSize, cfg.BrokerGRPC.TLS)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer brokerConns.Close()
+		opts = append(opts, usecase.WithBrokerGRPC(brokerConns))
+		log.Printf("Calling the Broker over gRPC at %s (%d connections)", cfg.BrokerGRPC.Addr, cfg.BrokerGRPC.PoolSize)
+	}
+	handler := usecase.NewHandler(cfg.BrokerBaseURL, cfg.StateKeys, httpClient, opts...)
 
 	srv, err := grpcsrv.NewServer(grpcsrv.Options{
-		GRPCAddress: ":" + portGRPC,
-		HTTPAddress: ":" + portHTTP,
-		Handler:     handler,
+		GRPCAddress:        ":" + cfg.PortGRPC,
+		HTTPAddress:        ":" + cfg.PortHTTP,
+		Handler:            handler,
+		AllowedOrigins:     cfg.AllowedOrigins,
+		HealthCheckTimeout: cfg.HealthCheckTimeout,
+
+		AdminAPIKey:          cfg.AdminAPIKey,
+		EnableDebugEndpoints: cfg.EnableDebugEndpoints,
+
+		Authenticator:     auth.New(cfg.Auth, httpClient),
+		AuthExemptMethods: cfg.Auth.ExemptMethods,
+		RateLimit:         cfg.GRPCRateLimit,
+		RateBurst:         cfg.GRPCRateBurst,
+		Timeouts:          cfg.Timeouts,
+		TLS:               cfg.TLS,
+		SinglePort:        cfg.GRPCSinglePort,
+
+		Build: usecase.BuildInfo{
+			Version:   Version,
+			GitCommit: GitCommit,
+			BuildDate: BuildDate,
+			Features:  cfg.Features(),
+		},
 	})
 	if err != nil {
 		log.Fatal(err)
@@ -74,25 +107,28 @@ func main() {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	log.Printf("Starting Nexus gRPC on %s and HTTP gateway on %s, broker=%s", ":"+portGRPC, ":"+portHTTP, brokerBaseURL)
+	log.Printf("Starting Nexus gRPC on %s and HTTP gateway on %s, broker=%s", ":"+cfg.PortGRPC, ":"+cfg.PortHTTP, cfg.BrokerBaseURL)
 	log.Printf("Version: %s", Version)
 	if err := srv.Start(ctx); err != nil {
 		log.Fatal(err)
 	}
 
-	// Graceful shutdown on SIGINT/SIGTERM
+	// SIGHUP drops cached provider lookups; SIGINT/SIGTERM shut down.
 	sigCh := make(chan os.Signal, 1)
-	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
-	<-sigCh
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	for sig := range sigCh {
+		if sig != syscall.SIGHUP {
+			break
+		}
+		invalidated, err := srv.Reload(context.Background())
+		if err != nil {
+			log.Printf("SIGHUP: cache reload failed: %v", err)
+			continue
+		}
+		log.Printf("SIGHUP: caches reloaded %v", invalidated)
+	}
 	log.Println("Shutting down gRPC and HTTP gateway...")
-	shutdownCtx, cancel2 := context.WithTimeout(context.Background(), 10*time.Second)
+	shutdownCtx, cancel2 := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
 	defer cancel2()
 	_ = srv.Shutdown(shutdownCtx)
 }
-
-func getEnv(key, fallback string) string {
-	if val := os.Getenv(key); val != "" {
-		return val
-	}
-	return fallback
-}
@@ -1,55 +1,57 @@
 package main
 
 import (
-	"encoding/base64"
+	"context"
+	"flag"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
+	"github.com/Prescott-Data/nexus-framework/nexus-sdk/redact"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-gateway/pkg/config"
 	"github.com/Prescott-Data/nexus-framework/nexus-gateway/pkg/server"
+	"github.com/Prescott-Data/nexus-framework/nexus-gateway/pkg/usecase"
 )
 
-var Version = "dev"
+// Build metadata, set with -ldflags "-X main.Version=... -X main.GitCommit=...
+// -X main.BuildDate=...".
+var (
+	Version   = "dev"
+	GitCommit = "unknown"
+	BuildDate = "unknown"
+)
 
 func main() {
+	// Scrub tokens and secrets that reach the log through error messages.
+	log.SetOutput(redact.NewWriter(os.Stderr))
+
 	if len(os.Args) > 1 && (os.Args[1] == "-v" || os.Args[1] == "--version") {
 		log.Printf("Nexus Gateway (REST) version: %s", Version)
 		os.Exit(0)
 	}
 
-	// Config
-	port := getEnv("PORT", "8090")
-	brokerBaseURL := getEnv("BROKER_BASE_URL", "http://localhost:8080")
-	stateKeyStr := getEnv("STATE_KEY", "")
+	configPath := flag.String("config", os.Getenv("CONFIG_FILE"), "path to a YAML config file (env vars override its values)")
+	printConfig := flag.Bool("print-config", false, "print the effective configuration with defaults and exit")
+	flag.Parse()
 
-	if brokerBaseURL == "" {
-		log.Fatal("BROKER_BASE_URL is required")
+	if *printConfig {
+		if err := config.PrintConfig(os.Stdout, *configPath); err != nil {
+			log.Fatal(err)
+		}
+		os.Exit(0)
 	}
 
-	if stateKeyStr == "" {
-		log.Fatal(
-			"STATE_KEY is not set. " +
-				"This key must match the Broker's STATE_KEY for HMAC state verification. " +
-				"Generate one with: openssl rand -base64 32",
-		)
-	}
-	stateKey, err := base64.StdEncoding.DecodeString(stateKeyStr)
+	cfg, err := config.LoadFile(*configPath)
 	if err != nil {
-		log.Fatal(
-			"STATE_KEY is not valid base64. " +
-				"Expected a base64-encoded 32-byte key. " +
-				"Generate one with: openssl rand -base64 32",
-		)
-	}
-	if len(stateKey) != 32 {
-		log.Fatalf(
-			"STATE_KEY decoded to %d bytes, expected exactly 32. "+
-				"Generate one with: openssl rand -base64 32", len(stateKey),
-		)
+		log.Fatal(err)
 	}
 
-	// HTTP client with sane timeouts and connection reuse
+	// HTTP client with connection reuse. Requests bound Broker calls by
+	// their route's timeout; the client's only needs to cover the longest.
 	transport := &http.Transport{
 		MaxIdleConns:        100,
 		MaxIdleConnsPerHost: 20,
@@ -58,21 +60,55 @@ func main() {
 	}
 	httpClient := &http.Client{
 		Transport: transport,
-		Timeout:   30 * time.Second,
+		Timeout:   cfg.Timeouts.Max(),
+	}
+
+	var opts []usecase.HandlerOption
+	if cfg.BrokerGRPC.Addr != "" {
+		brokerConns, err := usecase.DialBroker(cfg.BrokerGRPC.Addr, cfg.BrokerGRPC.PoolSize, cfg.BrokerGRPC.TLS)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer brokerConns.Close()
+		opts = append(opts, usecase.WithBrokerGRPC(brokerConns))
+		log.Printf("Calling the Broker over gRPC at %s (%d connections)", cfg.BrokerGRPC.Addr, cfg.BrokerGRPC.PoolSize)
 	}
 
-	srv := server.New(port, brokerBaseURL, stateKey, httpClient)
+	srv := server.New(cfg, httpClient, usecase.BuildInfo{Version: Version, GitCommit: GitCommit, BuildDate: BuildDate}, opts...)
 
-	log.Printf("Starting Nexus on port %s, broker=%s", port, brokerBaseURL)
+	log.Printf("Starting Nexus on port %s, broker=%s", cfg.Port, cfg.BrokerBaseURL)
 	log.Printf("Version: %s", Version)
-	if err := srv.Start(); err != nil {
-		log.Fatal(err)
+	go func() {
+		if err := srv.Start(); err != nil {
+			log.Fatal(err)
+		}
+	}()
+
+	// SIGHUP drops cached provider lookups. Graceful shutdown on
+	// SIGINT/SIGTERM: fail readiness and refuse new connection requests, wait
+	// for load balancers to notice, then let in-flight requests such as
+	// callback proxies finish.
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	for sig := range sigCh {
+		if sig != syscall.SIGHUP {
+			break
+		}
+		invalidated, err := srv.Reload(context.Background())
+		if err != nil {
+			log.Printf("SIGHUP: cache reload failed: %v", err)
+			continue
+		}
+		log.Printf("SIGHUP: caches reloaded %v", invalidated)
 	}
-}
+	log.Printf("Shutting down: draining for %s", cfg.ShutdownDrainDelay)
+	srv.BeginDrain()
+	time.Sleep(cfg.ShutdownDrainDelay)
 
-func getEnv(key, fallback string) string {
-	if val := os.Getenv(key); val != "" {
-		return val
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Graceful shutdown incomplete: %v", err)
 	}
-	return fallback
+	log.Println("Server stopped")
 }
@@ -24,13 +24,19 @@ const (
 )
 
 type RequestConnectionRequest struct {
-	state         protoimpl.MessageState `protogen:"open.v1"`
-	UserId        string                 `protobuf:"bytes,1,opt,name=user_id,json=userId,proto3" json:"user_id,omitempty"`
-	ProviderId    string                 `protobuf:"bytes,2,opt,name=provider_id,json=providerId,proto3" json:"provider_id,omitempty"`
-	ProviderName  string                 `protobuf:"bytes,3,opt,name=provider_name,json=providerName,proto3" json:"provider_name,omitempty"`
-	Scopes        []string               `protobuf:"bytes,4,rep,name=scopes,proto3" json:"scopes,omitempty"`
-	ReturnUrl     string                 `protobuf:"bytes,5,opt,name=return_url,json=returnUrl,proto3" json:"return_url,omitempty"`
-	Action        string                 `protobuf:"bytes,6,opt,name=action,proto3" json:"action,omitempty"`
+	state        protoimpl.MessageState `protogen:"open.v1"`
+	UserId       string                 `protobuf:"bytes,1,opt,name=user_id,json=userId,proto3" json:"user_id,omitempty"`
+	ProviderId   string                 `protobuf:"bytes,2,opt,name=provider_id,json=providerId,proto3" json:"provider_id,omitempty"`
+	ProviderName string                 `protobuf:"bytes,3,opt,name=provider_name,json=providerName,proto3" json:"provider_name,omitempty"`
+	Scopes       []string               `protobuf:"bytes,4,rep,name=scopes,proto3" json:"scopes,omitempty"`
+	ReturnUrl    string                 `protobuf:"bytes,5,opt,name=return_url,json=returnUrl,proto3" json:"return_url,omitempty"`
+	Action       string                 `protobuf:"bytes,6,opt,name=action,proto3" json:"action,omitempty"`
+	// Azure AD tenant to authorize against: common, organizations, consumers,
+	// a tenant ID or a domain. Empty uses the provider's configured tenant.
+	Tenant string `protobuf:"bytes,7,opt,name=tenant,proto3" json:"tenant,omitempty"`
+	// Email of the user a service account connection acts as through
+	// domain-wide delegation. Empty makes tokens the service account's own.
+	Subject       string `protobuf:"bytes,8,opt,name=subject,proto3" json:"subject,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -107,6 +113,20 @@ func (x *RequestConnectionRequest) GetAction() string {
 	return ""
 }
 
+func (x *RequestConnectionRequest) GetTenant() string {
+	if x != nil {
+		return x.Tenant
+	}
+	return ""
+}
+
+func (x *RequestConnectionRequest) GetSubject() string {
+	if x != nil {
+		return x.Subject
+	}
+	return ""
+}
+
 type RequestConnectionResponse struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	AuthUrl       string                 `protobuf:"bytes,1,opt,name=auth_url,json=authUrl,proto3" json:"auth_url,omitempty"`
@@ -228,8 +248,17 @@ func (x *CheckConnectionRequest) GetConnectionId() string {
 }
 
 type CheckConnectionResponse struct {
-	state         protoimpl.MessageState `protogen:"open.v1"`
-	Status        string                 `protobuf:"bytes,1,opt,name=status,proto3" json:"status,omitempty"` // pending | active | failed
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// pending | active | failed | cancelled | expired | needs_reauth |
+	// compromised | suspended | revoked | archived
+	Status string `protobuf:"bytes,1,opt,name=status,proto3" json:"status,omitempty"`
+	// Why the connection is in status: refresh_failing and suspended clear on
+	// their own or by an operator; reauth_required, consent_expired and the
+	// like need the user to consent again. not_found for an unknown ID.
+	Reason string `protobuf:"bytes,2,opt,name=reason,proto3" json:"reason,omitempty"`
+	// Why a failed consent failed, the code its return_url was given, e.g.
+	// token_exchange_failed.
+	ErrorCode     string `protobuf:"bytes,3,opt,name=error_code,json=errorCode,proto3" json:"error_code,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -271,6 +300,20 @@ func (x *CheckConnectionResponse) GetStatus() string {
 	return ""
 }
 
+func (x *CheckConnectionResponse) GetReason() string {
+	if x != nil {
+		return x.Reason
+	}
+	return ""
+}
+
+func (x *CheckConnectionResponse) GetErrorCode() string {
+	if x != nil {
+		return x.ErrorCode
+	}
+	return ""
+}
+
 type GetTokenRequest struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	ConnectionId  string                 `protobuf:"bytes,1,opt,name=connection_id,json=connectionId,proto3" json:"connection_id,omitempty"`
@@ -447,11 +490,200 @@ func (x *RefreshConnectionResponse) GetToken() *structpb.Struct {
 	return nil
 }
 
+type ServerInfoRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ServerInfoRequest) Reset() {
+	*x = ServerInfoRequest{}
+	mi := &file_api_proto_nexus_v1_nexus_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ServerInfoRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ServerInfoRequest) ProtoMessage() {}
+
+func (x *ServerInfoRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_api_proto_nexus_v1_nexus_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ServerInfoRequest.ProtoReflect.Descriptor instead.
+func (*ServerInfoRequest) Descriptor() ([]byte, []int) {
+	return file_api_proto_nexus_v1_nexus_proto_rawDescGZIP(), []int{8}
+}
+
+// BuildInfo identifies a running binary.
+type BuildInfo struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Version       string                 `protobuf:"bytes,1,opt,name=version,proto3" json:"version,omitempty"`
+	GitCommit     string                 `protobuf:"bytes,2,opt,name=git_commit,json=gitCommit,proto3" json:"git_commit,omitempty"`
+	BuildDate     string                 `protobuf:"bytes,3,opt,name=build_date,json=buildDate,proto3" json:"build_date,omitempty"`
+	Features      []string               `protobuf:"bytes,4,rep,name=features,proto3" json:"features,omitempty"` // enabled feature flags
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *BuildInfo) Reset() {
+	*x = BuildInfo{}
+	mi := &file_api_proto_nexus_v1_nexus_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *BuildInfo) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BuildInfo) ProtoMessage() {}
+
+func (x *BuildInfo) ProtoReflect() protoreflect.Message {
+	mi := &file_api_proto_nexus_v1_nexus_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BuildInfo.ProtoReflect.Descriptor instead.
+func (*BuildInfo) Descriptor() ([]byte, []int) {
+	return file_api_proto_nexus_v1_nexus_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *BuildInfo) GetVersion() string {
+	if x != nil {
+		return x.Version
+	}
+	return ""
+}
+
+func (x *BuildInfo) GetGitCommit() string {
+	if x != nil {
+		return x.GitCommit
+	}
+	return ""
+}
+
+func (x *BuildInfo) GetBuildDate() string {
+	if x != nil {
+		return x.BuildDate
+	}
+	return ""
+}
+
+func (x *BuildInfo) GetFeatures() []string {
+	if x != nil {
+		return x.Features
+	}
+	return nil
+}
+
+type ServerInfoResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Version       string                 `protobuf:"bytes,1,opt,name=version,proto3" json:"version,omitempty"`
+	GitCommit     string                 `protobuf:"bytes,2,opt,name=git_commit,json=gitCommit,proto3" json:"git_commit,omitempty"`
+	BuildDate     string                 `protobuf:"bytes,3,opt,name=build_date,json=buildDate,proto3" json:"build_date,omitempty"`
+	Features      []string               `protobuf:"bytes,4,rep,name=features,proto3" json:"features,omitempty"`                          // enabled feature flags
+	Broker        *BuildInfo             `protobuf:"bytes,5,opt,name=broker,proto3" json:"broker,omitempty"`                              // unset if the broker could not be reached
+	BrokerError   string                 `protobuf:"bytes,6,opt,name=broker_error,json=brokerError,proto3" json:"broker_error,omitempty"` // why broker is unset
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ServerInfoResponse) Reset() {
+	*x = ServerInfoResponse{}
+	mi := &file_api_proto_nexus_v1_nexus_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ServerInfoResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ServerInfoResponse) ProtoMessage() {}
+
+func (x *ServerInfoResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_api_proto_nexus_v1_nexus_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ServerInfoResponse.ProtoReflect.Descriptor instead.
+func (*ServerInfoResponse) Descriptor() ([]byte, []int) {
+	return file_api_proto_nexus_v1_nexus_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *ServerInfoResponse) GetVersion() string {
+	if x != nil {
+		return x.Version
+	}
+	return ""
+}
+
+func (x *ServerInfoResponse) GetGitCommit() string {
+	if x != nil {
+		return x.GitCommit
+	}
+	return ""
+}
+
+func (x *ServerInfoResponse) GetBuildDate() string {
+	if x != nil {
+		return x.BuildDate
+	}
+	return ""
+}
+
+func (x *ServerInfoResponse) GetFeatures() []string {
+	if x != nil {
+		return x.Features
+	}
+	return nil
+}
+
+func (x *ServerInfoResponse) GetBroker() *BuildInfo {
+	if x != nil {
+		return x.Broker
+	}
+	return nil
+}
+
+func (x *ServerInfoResponse) GetBrokerError() string {
+	if x != nil {
+		return x.BrokerError
+	}
+	return ""
+}
+
 var File_api_proto_nexus_v1_nexus_proto protoreflect.FileDescriptor
 
 const file_api_proto_nexus_v1_nexus_proto_rawDesc = "" +
 	"\n" +
-	"\x1eapi/proto/nexus/v1/nexus.proto\x12\bnexus.v1\x1a\x1cgoogle/api/annotations.proto\x1a\x1cgoogle/protobuf/struct.proto\"\xc8\x01\n" +
+	"\x1eapi/proto/nexus/v1/nexus.proto\x12\bnexus.v1\x1a\x1cgoogle/api/annotations.proto\x1a\x1cgoogle/protobuf/struct.proto\"\xfa\x01\n" +
 	"\x18RequestConnectionRequest\x12\x17\n" +
 	"\auser_id\x18\x01 \x01(\tR\x06userId\x12\x1f\n" +
 	"\vprovider_id\x18\x02 \x01(\tR\n" +
@@ -460,7 +692,9 @@ const file_api_proto_nexus_v1_nexus_proto_rawDesc = "" +
 	"\x06scopes\x18\x04 \x03(\tR\x06scopes\x12\x1d\n" +
 	"\n" +
 	"return_url\x18\x05 \x01(\tR\treturnUrl\x12\x16\n" +
-	"\x06action\x18\x06 \x01(\tR\x06action\"\xaa\x01\n" +
+	"\x06action\x18\x06 \x01(\tR\x06action\x12\x16\n" +
+	"\x06tenant\x18\a \x01(\tR\x06tenant\x12\x18\n" +
+	"\asubject\x18\b \x01(\tR\asubject\"\xaa\x01\n" +
 	"\x19RequestConnectionResponse\x12\x19\n" +
 	"\bauth_url\x18\x01 \x01(\tR\aauthUrl\x12\x14\n" +
 	"\x05state\x18\x02 \x01(\tR\x05state\x12\x16\n" +
@@ -469,9 +703,12 @@ const file_api_proto_nexus_v1_nexus_proto_rawDesc = "" +
 	"providerId\x12#\n" +
 	"\rconnection_id\x18\x05 \x01(\tR\fconnectionId\"=\n" +
 	"\x16CheckConnectionRequest\x12#\n" +
-	"\rconnection_id\x18\x01 \x01(\tR\fconnectionId\"1\n" +
+	"\rconnection_id\x18\x01 \x01(\tR\fconnectionId\"h\n" +
 	"\x17CheckConnectionResponse\x12\x16\n" +
-	"\x06status\x18\x01 \x01(\tR\x06status\"6\n" +
+	"\x06status\x18\x01 \x01(\tR\x06status\x12\x16\n" +
+	"\x06reason\x18\x02 \x01(\tR\x06reason\x12\x1d\n" +
+	"\n" +
+	"error_code\x18\x03 \x01(\tR\terrorCode\"6\n" +
 	"\x0fGetTokenRequest\x12#\n" +
 	"\rconnection_id\x18\x01 \x01(\tR\fconnectionId\"A\n" +
 	"\x10GetTokenResponse\x12-\n" +
@@ -479,12 +716,32 @@ const file_api_proto_nexus_v1_nexus_proto_rawDesc = "" +
 	"\x18RefreshConnectionRequest\x12#\n" +
 	"\rconnection_id\x18\x01 \x01(\tR\fconnectionId\"J\n" +
 	"\x19RefreshConnectionResponse\x12-\n" +
-	"\x05token\x18\x01 \x01(\v2\x17.google.protobuf.StructR\x05token2\x80\x04\n" +
+	"\x05token\x18\x01 \x01(\v2\x17.google.protobuf.StructR\x05token\"\x13\n" +
+	"\x11ServerInfoRequest\"\x7f\n" +
+	"\tBuildInfo\x12\x18\n" +
+	"\aversion\x18\x01 \x01(\tR\aversion\x12\x1d\n" +
+	"\n" +
+	"git_commit\x18\x02 \x01(\tR\tgitCommit\x12\x1d\n" +
+	"\n" +
+	"build_date\x18\x03 \x01(\tR\tbuildDate\x12\x1a\n" +
+	"\bfeatures\x18\x04 \x03(\tR\bfeatures\"\xd8\x01\n" +
+	"\x12ServerInfoResponse\x12\x18\n" +
+	"\aversion\x18\x01 \x01(\tR\aversion\x12\x1d\n" +
+	"\n" +
+	"git_commit\x18\x02 \x01(\tR\tgitCommit\x12\x1d\n" +
+	"\n" +
+	"build_date\x18\x03 \x01(\tR\tbuildDate\x12\x1a\n" +
+	"\bfeatures\x18\x04 \x03(\tR\bfeatures\x12+\n" +
+	"\x06broker\x18\x05 \x01(\v2\x13.nexus.v1.BuildInfoR\x06broker\x12!\n" +
+	"\fbroker_error\x18\x06 \x01(\tR\vbrokerError2\xdb\x04\n" +
 	"\fNexusService\x12\x7f\n" +
 	"\x11RequestConnection\x12\".nexus.v1.RequestConnectionRequest\x1a#.nexus.v1.RequestConnectionResponse\"!\x82\xd3\xe4\x93\x02\x1b:\x01*\"\x16/v1/request-connection\x12\x84\x01\n" +
 	"\x0fCheckConnection\x12 .nexus.v1.CheckConnectionRequest\x1a!.nexus.v1.CheckConnectionResponse\",\x82\xd3\xe4\x93\x02&\x12$/v1/check-connection/{connection_id}\x12d\n" +
 	"\bGetToken\x12\x19.nexus.v1.GetTokenRequest\x1a\x1a.nexus.v1.GetTokenResponse\"!\x82\xd3\xe4\x93\x02\x1b\x12\x19/v1/token/{connection_id}\x12\x81\x01\n" +
-	"\x11RefreshConnection\x12\".nexus.v1.RefreshConnectionRequest\x1a#.nexus.v1.RefreshConnectionResponse\"#\x82\xd3\xe4\x93\x02\x1d\"\x1b/v1/refresh/{connection_id}B\xb5\x01\n" +
+	"\x11RefreshConnection\x12\".nexus.v1.RefreshConnectionRequest\x1a#.nexus.v1.RefreshConnectionResponse\"#\x82\xd3\xe4\x93\x02\x1d\"\x1b/v1/refresh/{connection_id}\x12Y\n" +
+	"\n" +
+	"ServerInfo\x12\x1b.nexus.v1.ServerInfoRequest\x1a\x1c.nexus.v1.ServerInfoResponse\"\x10\x82\xd3\xe4\x93\x02\n" +
+	"\x12\b/versionB\xb5\x01\n" +
 	"\fcom.nexus.v1B\n" +
 	"NexusProtoP\x01ZXgithub.com/Prescott-Data/nexus-framework/nexus-gateway/gen/go/api/proto/nexus/v1;nexuspb\xa2\x02\x03NXX\xaa\x02\bNexus.V1\xca\x02\bNexus\\V1\xe2\x02\x14Nexus\\V1\\GPBMetadata\xea\x02\tNexus::V1b\x06proto3"
 
@@ -500,7 +757,7 @@ func file_api_proto_nexus_v1_nexus_proto_rawDescGZIP() []byte {
 	return file_api_proto_nexus_v1_nexus_proto_rawDescData
 }
 
-var file_api_proto_nexus_v1_nexus_proto_msgTypes = make([]protoimpl.MessageInfo, 8)
+var file_api_proto_nexus_v1_nexus_proto_msgTypes = make([]protoimpl.MessageInfo, 11)
 var file_api_proto_nexus_v1_nexus_proto_goTypes = []any{
 	(*RequestConnectionRequest)(nil),  // 0: nexus.v1.RequestConnectionRequest
 	(*RequestConnectionResponse)(nil), // 1: nexus.v1.RequestConnectionResponse
@@ -510,24 +767,30 @@ var file_api_proto_nexus_v1_nexus_proto_goTypes = []any{
 	(*GetTokenResponse)(nil),          // 5: nexus.v1.GetTokenResponse
 	(*RefreshConnectionRequest)(nil),  // 6: nexus.v1.RefreshConnectionRequest
 	(*RefreshConnectionResponse)(nil), // 7: nexus.v1.RefreshConnectionResponse
-	(*structpb.Struct)(nil),           // 8: google.protobuf.Struct
+	(*ServerInfoRequest)(nil),         // 8: nexus.v1.ServerInfoRequest
+	(*BuildInfo)(nil),                 // 9: nexus.v1.BuildInfo
+	(*ServerInfoResponse)(nil),        // 10: nexus.v1.ServerInfoResponse
+	(*structpb.Struct)(nil),           // 11: google.protobuf.Struct
 }
 var file_api_proto_nexus_v1_nexus_proto_depIdxs = []int32{
-	8, // 0: nexus.v1.GetTokenResponse.token:type_name -> google.protobuf.Struct
-	8, // 1: nexus.v1.RefreshConnectionResponse.token:type_name -> google.protobuf.Struct
-	0, // 2: nexus.v1.NexusService.RequestConnection:input_type -> nexus.v1.RequestConnectionRequest
-	2, // 3: nexus.v1.NexusService.CheckConnection:input_type -> nexus.v1.CheckConnectionRequest
-	4, // 4: nexus.v1.NexusService.GetToken:input_type -> nexus.v1.GetTokenRequest
-	6, // 5: nexus.v1.NexusService.RefreshConnection:input_type -> nexus.v1.RefreshConnectionRequest
-	1, // 6: nexus.v1.NexusService.RequestConnection:output_type -> nexus.v1.RequestConnectionResponse
-	3, // 7: nexus.v1.NexusService.CheckConnection:output_type -> nexus.v1.CheckConnectionResponse
-	5, // 8: nexus.v1.NexusService.GetToken:output_type -> nexus.v1.GetTokenResponse
-	7, // 9: nexus.v1.NexusService.RefreshConnection:output_type -> nexus.v1.RefreshConnectionResponse
-	6, // [6:10] is the sub-list for method output_type
-	2, // [2:6] is the sub-list for method input_type
-	2, // [2:2] is the sub-list for extension type_name
-	2, // [2:2] is the sub-list for extension extendee
-	0, // [0:2] is the sub-list for field type_name
+	11, // 0: nexus.v1.GetTokenResponse.token:type_name -> google.protobuf.Struct
+	11, // 1: nexus.v1.RefreshConnectionResponse.token:type_name -> google.protobuf.Struct
+	9,  // 2: nexus.v1.ServerInfoResponse.broker:type_name -> nexus.v1.BuildInfo
+	0,  // 3: nexus.v1.NexusService.RequestConnection:input_type -> nexus.v1.RequestConnectionRequest
+	2,  // 4: nexus.v1.NexusService.CheckConnection:input_type -> nexus.v1.CheckConnectionRequest
+	4,  // 5: nexus.v1.NexusService.GetToken:input_type -> nexus.v1.GetTokenRequest
+	6,  // 6: nexus.v1.NexusService.RefreshConnection:input_type -> nexus.v1.RefreshConnectionRequest
+	8,  // 7: nexus.v1.NexusService.ServerInfo:input_type -> nexus.v1.ServerInfoRequest
+	1,  // 8: nexus.v1.NexusService.RequestConnection:output_type -> nexus.v1.RequestConnectionResponse
+	3,  // 9: nexus.v1.NexusService.CheckConnection:output_type -> nexus.v1.CheckConnectionResponse
+	5,  // 10: nexus.v1.NexusService.GetToken:output_type -> nexus.v1.GetTokenResponse
+	7,  // 11: nexus.v1.NexusService.RefreshConnection:output_type -> nexus.v1.RefreshConnectionResponse
+	10, // 12: nexus.v1.NexusService.ServerInfo:output_type -> nexus.v1.ServerInfoResponse
+	8,  // [8:13] is the sub-list for method output_type
+	3,  // [3:8] is the sub-list for method input_type
+	3,  // [3:3] is the sub-list for extension type_name
+	3,  // [3:3] is the sub-list for extension extendee
+	0,  // [0:3] is the sub-list for field type_name
 }
 
 func init() { file_api_proto_nexus_v1_nexus_proto_init() }
@@ -541,7 +804,7 @@ func file_api_proto_nexus_v1_nexus_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_api_proto_nexus_v1_nexus_proto_rawDesc), len(file_api_proto_nexus_v1_nexus_proto_rawDesc)),
 			NumEnums:      0,
-			NumMessages:   8,
+			NumMessages:   11,
 			NumExtensions: 0,
 			NumServices:   1,
 		},
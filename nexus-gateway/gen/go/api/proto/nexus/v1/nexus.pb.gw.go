@@ -179,6 +179,27 @@ func local_request_NexusService_RefreshConnection_0(ctx context.Context, marshal
 	return msg, metadata, err
 }
 
+func request_NexusService_ServerInfo_0(ctx context.Context, marshaler runtime.Marshaler, client NexusServiceClient, req *http.Request, pathParams map[string]string) (proto.Message, runtime.ServerMetadata, error) {
+	var (
+		protoReq ServerInfoRequest
+		metadata runtime.ServerMetadata
+	)
+	if req.Body != nil {
+		_, _ = io.Copy(io.Discard, req.Body)
+	}
+	msg, err := client.ServerInfo(ctx, &protoReq, grpc.Header(&metadata.HeaderMD), grpc.Trailer(&metadata.TrailerMD))
+	return msg, metadata, err
+}
+
+func local_request_NexusService_ServerInfo_0(ctx context.Context, marshaler runtime.Marshaler, server NexusServiceServer, req *http.Request, pathParams map[string]string) (proto.Message, runtime.ServerMetadata, error) {
+	var (
+		protoReq ServerInfoRequest
+		metadata runtime.ServerMetadata
+	)
+	msg, err := server.ServerInfo(ctx, &protoReq)
+	return msg, metadata, err
+}
+
 // RegisterNexusServiceHandlerServer registers the http handlers for service NexusService to "mux".
 // UnaryRPC     :call NexusServiceServer directly.
 // StreamingRPC :currently unsupported pending https://github.com/grpc/grpc-go/issues/906.
@@ -265,6 +286,26 @@ func RegisterNexusServiceHandlerServer(ctx context.Context, mux *runtime.ServeMu
 		}
 		forward_NexusService_RefreshConnection_0(annotatedContext, mux, outboundMarshaler, w, req, resp, mux.GetForwardResponseOptions()...)
 	})
+	mux.Handle(http.MethodGet, pattern_NexusService_ServerInfo_0, func(w http.ResponseWriter, req *http.Request, pathParams map[string]string) {
+		ctx, cancel := context.WithCancel(req.Context())
+		defer cancel()
+		var stream runtime.ServerTransportStream
+		ctx = grpc.NewContextWithServerTransportStream(ctx, &stream)
+		inboundMarshaler, outboundMarshaler := runtime.MarshalerForRequest(mux, req)
+		annotatedContext, err := runtime.AnnotateIncomingContext(ctx, mux, req, "/nexus.v1.NexusService/ServerInfo", runtime.WithHTTPPathPattern("/version"))
+		if err != nil {
+			runtime.HTTPError(ctx, mux, outboundMarshaler, w, req, err)
+			return
+		}
+		resp, md, err := local_request_NexusService_ServerInfo_0(annotatedContext, inboundMarshaler, server, req, pathParams)
+		md.HeaderMD, md.TrailerMD = metadata.Join(md.HeaderMD, stream.Header()), metadata.Join(md.TrailerMD, stream.Trailer())
+		annotatedContext = runtime.NewServerMetadataContext(annotatedContext, md)
+		if err != nil {
+			runtime.HTTPError(annotatedContext, mux, outboundMarshaler, w, req, err)
+			return
+		}
+		forward_NexusService_ServerInfo_0(annotatedContext, mux, outboundMarshaler, w, req, resp, mux.GetForwardResponseOptions()...)
+	})
 
 	return nil
 }
@@ -373,6 +414,23 @@ func RegisterNexusServiceHandlerClient(ctx context.Context, mux *runtime.ServeMu
 		}
 		forward_NexusService_RefreshConnection_0(annotatedContext, mux, outboundMarshaler, w, req, resp, mux.GetForwardResponseOptions()...)
 	})
+	mux.Handle(http.MethodGet, pattern_NexusService_ServerInfo_0, func(w http.ResponseWriter, req *http.Request, pathParams map[string]string) {
+		ctx, cancel := context.WithCancel(req.Context())
+		defer cancel()
+		inboundMarshaler, outboundMarshaler := runtime.MarshalerForRequest(mux, req)
+		annotatedContext, err := runtime.AnnotateContext(ctx, mux, req, "/nexus.v1.NexusService/ServerInfo", runtime.WithHTTPPathPattern("/version"))
+		if err != nil {
+			runtime.HTTPError(ctx, mux, outboundMarshaler, w, req, err)
+			return
+		}
+		resp, md, err := request_NexusService_ServerInfo_0(annotatedContext, inboundMarshaler, client, req, pathParams)
+		annotatedContext = runtime.NewServerMetadataContext(annotatedContext, md)
+		if err != nil {
+			runtime.HTTPError(annotatedContext, mux, outboundMarshaler, w, req, err)
+			return
+		}
+		forward_NexusService_ServerInfo_0(annotatedContext, mux, outboundMarshaler, w, req, resp, mux.GetForwardResponseOptions()...)
+	})
 	return nil
 }
 
@@ -381,6 +439,7 @@ var (
 	pattern_NexusService_CheckConnection_0   = runtime.MustPattern(runtime.NewPattern(1, []int{2, 0, 2, 1, 1, 0, 4, 1, 5, 2}, []string{"v1", "check-connection", "connection_id"}, ""))
 	pattern_NexusService_GetToken_0          = runtime.MustPattern(runtime.NewPattern(1, []int{2, 0, 2, 1, 1, 0, 4, 1, 5, 2}, []string{"v1", "token", "connection_id"}, ""))
 	pattern_NexusService_RefreshConnection_0 = runtime.MustPattern(runtime.NewPattern(1, []int{2, 0, 2, 1, 1, 0, 4, 1, 5, 2}, []string{"v1", "refresh", "connection_id"}, ""))
+	pattern_NexusService_ServerInfo_0        = runtime.MustPattern(runtime.NewPattern(1, []int{2, 0}, []string{"version"}, ""))
 )
 
 var (
@@ -388,4 +447,5 @@ var (
 	forward_NexusService_CheckConnection_0   = runtime.ForwardResponseMessage
 	forward_NexusService_GetToken_0          = runtime.ForwardResponseMessage
 	forward_NexusService_RefreshConnection_0 = runtime.ForwardResponseMessage
+	forward_NexusService_ServerInfo_0        = runtime.ForwardResponseMessage
 )
@@ -23,6 +23,7 @@ const (
 	NexusService_CheckConnection_FullMethodName   = "/nexus.v1.NexusService/CheckConnection"
 	NexusService_GetToken_FullMethodName          = "/nexus.v1.NexusService/GetToken"
 	NexusService_RefreshConnection_FullMethodName = "/nexus.v1.NexusService/RefreshConnection"
+	NexusService_ServerInfo_FullMethodName        = "/nexus.v1.NexusService/ServerInfo"
 )
 
 // NexusServiceClient is the client API for NexusService service.
@@ -33,6 +34,9 @@ type NexusServiceClient interface {
 	CheckConnection(ctx context.Context, in *CheckConnectionRequest, opts ...grpc.CallOption) (*CheckConnectionResponse, error)
 	GetToken(ctx context.Context, in *GetTokenRequest, opts ...grpc.CallOption) (*GetTokenResponse, error)
 	RefreshConnection(ctx context.Context, in *RefreshConnectionRequest, opts ...grpc.CallOption) (*RefreshConnectionResponse, error)
+	// ServerInfo reports the Gateway's build and enabled features, and those
+	// of the Broker behind it.
+	ServerInfo(ctx context.Context, in *ServerInfoRequest, opts ...grpc.CallOption) (*ServerInfoResponse, error)
 }
 
 type nexusServiceClient struct {
@@ -83,6 +87,16 @@ func (c *nexusServiceClient) RefreshConnection(ctx context.Context, in *RefreshC
 	return out, nil
 }
 
+func (c *nexusServiceClient) ServerInfo(ctx context.Context, in *ServerInfoRequest, opts ...grpc.CallOption) (*ServerInfoResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ServerInfoResponse)
+	err := c.cc.Invoke(ctx, NexusService_ServerInfo_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // NexusServiceServer is the server API for NexusService service.
 // All implementations must embed UnimplementedNexusServiceServer
 // for forward compatibility.
@@ -91,6 +105,9 @@ type NexusServiceServer interface {
 	CheckConnection(context.Context, *CheckConnectionRequest) (*CheckConnectionResponse, error)
 	GetToken(context.Context, *GetTokenRequest) (*GetTokenResponse, error)
 	RefreshConnection(context.Context, *RefreshConnectionRequest) (*RefreshConnectionResponse, error)
+	// ServerInfo reports the Gateway's build and enabled features, and those
+	// of the Broker behind it.
+	ServerInfo(context.Context, *ServerInfoRequest) (*ServerInfoResponse, error)
 	mustEmbedUnimplementedNexusServiceServer()
 }
 
@@ -113,6 +130,9 @@ func (UnimplementedNexusServiceServer) GetToken(context.Context, *GetTokenReques
 func (UnimplementedNexusServiceServer) RefreshConnection(context.Context, *RefreshConnectionRequest) (*RefreshConnectionResponse, error) {
 	return nil, status.Error(codes.Unimplemented, "method RefreshConnection not implemented")
 }
+func (UnimplementedNexusServiceServer) ServerInfo(context.Context, *ServerInfoRequest) (*ServerInfoResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method ServerInfo not implemented")
+}
 func (UnimplementedNexusServiceServer) mustEmbedUnimplementedNexusServiceServer() {}
 func (UnimplementedNexusServiceServer) testEmbeddedByValue()                      {}
 
@@ -206,6 +226,24 @@ func _NexusService_RefreshConnection_Handler(srv interface{}, ctx context.Contex
 	return interceptor(ctx, in, info, handler)
 }
 
+func _NexusService_ServerInfo_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ServerInfoRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(NexusServiceServer).ServerInfo(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: NexusService_ServerInfo_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(NexusServiceServer).ServerInfo(ctx, req.(*ServerInfoRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // NexusService_ServiceDesc is the grpc.ServiceDesc for NexusService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -229,6 +267,10 @@ var NexusService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "RefreshConnection",
 			Handler:    _NexusService_RefreshConnection_Handler,
 		},
+		{
+			MethodName: "ServerInfo",
+			Handler:    _NexusService_ServerInfo_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "api/proto/nexus/v1/nexus.proto",
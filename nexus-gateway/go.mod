@@ -1,21 +1,32 @@
 module github.com/Prescott-Data/nexus-framework/nexus-gateway
 
-go 1.25.0
+go 1.25.3
 
 require (
+	github.com/Prescott-Data/nexus-framework/nexus-bridge v0.0.0-local
+	github.com/Prescott-Data/nexus-framework/nexus-common v0.0.0-local
+	github.com/Prescott-Data/nexus-framework/nexus-sdk v0.0.0-local
+	github.com/coreos/go-oidc/v3 v3.10.0
 	github.com/go-chi/chi/v5 v5.2.5
 	github.com/go-chi/cors v1.2.2
+	github.com/go-jose/go-jose/v4 v4.1.4
 	github.com/google/uuid v1.6.0
-	github.com/grpc-ecosystem/grpc-gateway/v2 v2.22.0
+	github.com/gorilla/websocket v1.5.3
+	github.com/grpc-ecosystem/grpc-gateway/v2 v2.27.3
 	github.com/oapi-codegen/runtime v1.1.2
 	github.com/prometheus/client_golang v1.23.2
 	google.golang.org/genproto/googleapis/api v0.0.0-20260120221211-b8f7ae30c516
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20260120221211-b8f7ae30c516
 	google.golang.org/grpc v1.80.0
 	google.golang.org/protobuf v1.36.11
+	gopkg.in/yaml.v3 v3.0.1
+	golang.org/x/crypto v0.50.0
 )
 
 require (
 	github.com/apapsch/go-jsonmerge/v2 v2.0.0 // indirect
+	github.com/aws/aws-sdk-go-v2 v1.41.0 // indirect
+	github.com/aws/smithy-go v1.24.0 // indirect
 	github.com/beorn7/perks v1.0.1 // indirect
 	github.com/cespare/xxhash/v2 v2.3.0 // indirect
 	github.com/kr/text v0.2.0 // indirect
@@ -25,7 +36,15 @@ require (
 	github.com/prometheus/procfs v0.16.1 // indirect
 	go.yaml.in/yaml/v2 v2.4.2 // indirect
 	golang.org/x/net v0.52.0 // indirect
+	golang.org/x/oauth2 v0.36.0 // indirect
 	golang.org/x/sys v0.43.0 // indirect
 	golang.org/x/text v0.36.0 // indirect
-	google.golang.org/genproto/googleapis/rpc v0.0.0-20260120221211-b8f7ae30c516 // indirect
+)
+
+// The WebSocket proxy reuses the bridge's auth strategies. Docker builds use
+// the repository root as context so these paths resolve.
+replace (
+	github.com/Prescott-Data/nexus-framework/nexus-bridge => ../nexus-bridge
+	github.com/Prescott-Data/nexus-framework/nexus-common => ../nexus-common
+	github.com/Prescott-Data/nexus-framework/nexus-sdk => ../nexus-sdk
 )
@@ -0,0 +1,30 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-gateway/pkg/config"
+)
+
+// APIKeys authenticates the X-API-Key header against a map of key to
+// caller name.
+type APIKeys map[string]string
+
+// Authenticate implements Authenticator. Every key is compared so the time
+// taken does not reveal which one matched.
+func (k APIKeys) Authenticate(_ context.Context, creds Credentials) (*Caller, error) {
+	if creds.APIKey == "" {
+		return nil, ErrNoCredentials
+	}
+	var caller string
+	for key, name := range k {
+		if subtle.ConstantTimeCompare([]byte(creds.APIKey), []byte(key)) == 1 {
+			caller = name
+		}
+	}
+	if caller == "" {
+		return nil, ErrInvalidCredentials
+	}
+	return &Caller{ID: caller, Method: config.AuthAPIKey}, nil
+}
@@ -0,0 +1,217 @@
+// Package auth authenticates callers of the Gateway's /v1 REST routes and
+// gRPC methods with static API keys, JWTs from a trusted issuer or client
+// certificates, and carries the resulting caller identity through the
+// request context so it can be forwarded to the Broker's audit log.
+package auth
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-common/problem"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-gateway/pkg/config"
+)
+
+// CallerHeader carries the authenticated caller to the Broker, which records
+// it in audit events.
+const CallerHeader = "X-Nexus-Caller"
+
+var (
+	// ErrNoCredentials means the request carried no credentials the
+	// authenticator understands; a Chain moves on to the next method.
+	ErrNoCredentials = errors.New("missing credentials")
+	// ErrInvalidCredentials means credentials were presented but rejected.
+	ErrInvalidCredentials = errors.New("invalid credentials")
+	// ErrWorkspaceForbidden means a caller named a workspace it does not
+	// act for.
+	ErrWorkspaceForbidden = errors.New("workspace not allowed for caller")
+)
+
+// Caller identifies an authenticated client.
+type Caller struct {
+	// ID is the API key name, JWT subject (or configured claim) or
+	// certificate common name.
+	ID string
+	// Method is the config.Auth* method that accepted the caller.
+	Method string
+	// Workspaces are the workspaces the caller acts for; AllWorkspaces
+	// allows any. See Workspace.
+	Workspaces []string
+}
+
+// AllWorkspaces, bound to a caller, lets it act for any workspace.
+const AllWorkspaces = "*"
+
+// Credentials are what a request presented, gathered from HTTP headers or
+// gRPC metadata.
+type Credentials struct {
+	APIKey      string
+	BearerToken string
+	// VerifiedChains are the client certificate chains the TLS handshake
+	// verified.
+	VerifiedChains [][]*x509.Certificate
+}
+
+// Authenticator identifies the caller behind a set of credentials. It
+// returns ErrNoCredentials when none of its kind are present.
+type Authenticator interface {
+	Authenticate(ctx context.Context, creds Credentials) (*Caller, error)
+}
+
+// Chain tries each authenticator in turn; the first that finds its kind of
+// credentials decides.
+type Chain []Authenticator
+
+// Authenticate implements Authenticator.
+func (c Chain) Authenticate(ctx context.Context, creds Credentials) (*Caller, error) {
+	for _, a := range c {
+		caller, err := a.Authenticate(ctx, creds)
+		if errors.Is(err, ErrNoCredentials) {
+			continue
+		}
+		return caller, err
+	}
+	return nil, ErrNoCredentials
+}
+
+// New builds the authenticator for cfg, or returns nil when caller
+// authentication is disabled. client fetches JWT signing keys.
+func New(cfg config.AuthConfig, client *http.Client) Authenticator {
+	if !cfg.Enabled() {
+		return nil
+	}
+	var chain Chain
+	for _, m := range cfg.Methods {
+		switch m {
+		case config.AuthAPIKey:
+			chain = append(chain, APIKeys(cfg.APIKeys))
+		case config.AuthJWT:
+			chain = append(chain, NewJWT(cfg.JWTIssuer, cfg.JWTAudience, cfg.JWTJWKSURL, cfg.JWTCallerClaim, client).WithWorkspacesClaim(cfg.JWTWorkspacesClaim))
+		case config.AuthMTLS:
+			chain = append(chain, NewMTLS(cfg.MTLSAllowedSubjects))
+		}
+	}
+	if len(cfg.CallerWorkspaces) == 0 {
+		return chain
+	}
+	return BindWorkspaces(chain, cfg.CallerWorkspaces)
+}
+
+// BindWorkspaces adds to each caller next authenticates the workspaces
+// bound to its name in workspaces.
+func BindWorkspaces(next Authenticator, workspaces map[string][]string) Authenticator {
+	return boundWorkspaces{next: next, workspaces: workspaces}
+}
+
+type boundWorkspaces struct {
+	next       Authenticator
+	workspaces map[string][]string
+}
+
+// Authenticate implements Authenticator.
+func (b boundWorkspaces) Authenticate(ctx context.Context, creds Credentials) (*Caller, error) {
+	caller, err := b.next.Authenticate(ctx, creds)
+	if err != nil {
+		return nil, err
+	}
+	caller.Workspaces = append(caller.Workspaces, b.workspaces[caller.ID]...)
+	return caller, nil
+}
+
+// Workspace returns the workspace a request made with ctx acts for, given
+// the one it names (empty for none). Without an authenticated caller, as
+// when authentication is disabled, the named workspace is used as is. A
+// caller may name only a workspace it is bound to, else ErrWorkspaceForbidden;
+// naming none selects its workspace when it has exactly one, and otherwise
+// none, which leaves only global providers visible.
+func Workspace(ctx context.Context, requested string) (string, error) {
+	caller, ok := CallerFrom(ctx)
+	if !ok || slices.Contains(caller.Workspaces, AllWorkspaces) {
+		return requested, nil
+	}
+	switch {
+	case requested == "" && len(caller.Workspaces) == 1:
+		return caller.Workspaces[0], nil
+	case requested == "", slices.Contains(caller.Workspaces, requested):
+		return requested, nil
+	}
+	return "", ErrWorkspaceForbidden
+}
+
+type callerKey struct{}
+
+// WithCaller returns a copy of ctx carrying caller.
+func WithCaller(ctx context.Context, caller *Caller) context.Context {
+	return context.WithValue(ctx, callerKey{}, caller)
+}
+
+// CallerFrom returns the caller authenticated for ctx, if any.
+func CallerFrom(ctx context.Context) (*Caller, bool) {
+	c, ok := ctx.Value(callerKey{}).(*Caller)
+	return c, ok && c != nil
+}
+
+// Exempt reports whether path skips authentication. An entry ending in "/"
+// matches every path below it; any other entry must match exactly.
+func Exempt(path string, exempt []string) bool {
+	for _, e := range exempt {
+		if e == path || (strings.HasSuffix(e, "/") && strings.HasPrefix(path, e)) {
+			return true
+		}
+	}
+	return false
+}
+
+// CredentialsFromRequest reads the X-API-Key header, an Authorization
+// bearer token and any verified client certificates.
+func CredentialsFromRequest(r *http.Request) Credentials {
+	creds := Credentials{
+		APIKey:      strings.TrimSpace(r.Header.Get("X-API-Key")),
+		BearerToken: bearerToken(r.Header.Get("Authorization")),
+	}
+	if r.TLS != nil {
+		creds.VerifiedChains = r.TLS.VerifiedChains
+	}
+	return creds
+}
+
+func bearerToken(header string) string {
+	scheme, token, ok := strings.Cut(strings.TrimSpace(header), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
+// Middleware authenticates requests to /v1 routes other than the exempt
+// paths, answering 401 when that fails. A nil authn leaves every route
+// open.
+func Middleware(authn Authenticator, exempt []string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if authn == nil {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !strings.HasPrefix(r.URL.Path, "/v1/") || r.Method == http.MethodOptions || Exempt(r.URL.Path, exempt) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			caller, err := authn.Authenticate(r.Context(), CredentialsFromRequest(r))
+			if err != nil {
+				code, msg := "unauthenticated", "missing caller credentials"
+				if !errors.Is(err, ErrNoCredentials) {
+					code, msg = "invalid_credentials", "invalid caller credentials"
+				}
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				problem.Write(w, problem.New(http.StatusUnauthorized, code, msg))
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(WithCaller(r.Context(), caller)))
+		})
+	}
+}
@@ -0,0 +1,159 @@
+package auth
+
+import (
+	"context"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-gateway/pkg/config"
+)
+
+func TestMiddleware(t *testing.T) {
+	authn := New(config.AuthConfig{
+		Methods: []string{config.AuthAPIKey},
+		APIKeys: map[string]string{"k1": "billing"},
+	}, nil)
+	var seen *Caller
+	h := Middleware(authn, []string{"/v1/connection-result", "/v1/ws/"})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen, _ = CallerFrom(r.Context())
+	}))
+
+	cases := []struct {
+		name, path, key string
+		want            int
+		wantCaller      string
+	}{
+		{"valid key", "/v1/token/abc", "k1", http.StatusOK, "billing"},
+		{"missing key", "/v1/token/abc", "", http.StatusUnauthorized, ""},
+		{"wrong key", "/v1/token/abc", "k2", http.StatusUnauthorized, ""},
+		{"exact exemption", "/v1/connection-result", "", http.StatusOK, ""},
+		{"prefix exemption", "/v1/ws/abc", "", http.StatusOK, ""},
+		{"outside /v1", "/readyz", "", http.StatusOK, ""},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			seen = nil
+			req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+			if tc.key != "" {
+				req.Header.Set("X-API-Key", tc.key)
+			}
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, req)
+			if rr.Code != tc.want {
+				t.Fatalf("expected %d, got %d: %s", tc.want, rr.Code, rr.Body.String())
+			}
+			if got := ""; seen != nil {
+				got = seen.ID
+				if got != tc.wantCaller {
+					t.Errorf("expected caller %q, got %q", tc.wantCaller, got)
+				}
+			} else if tc.wantCaller != "" {
+				t.Errorf("expected caller %q, got none", tc.wantCaller)
+			}
+		})
+	}
+
+	// Without an authenticator every route stays open.
+	rr := httptest.NewRecorder()
+	Middleware(nil, nil)(http.NotFoundHandler()).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/v1/token/abc", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected the handler to run, got %d", rr.Code)
+	}
+}
+
+func TestChain_FallsThroughMissingCredentials(t *testing.T) {
+	authn := Chain{APIKeys{"k1": "billing"}, NewMTLS([]string{"svc-reports"})}
+	cert := func(cn string) [][]*x509.Certificate {
+		return [][]*x509.Certificate{{{Subject: pkix.Name{CommonName: cn}}}}
+	}
+	ctx := context.Background()
+
+	c, err := authn.Authenticate(ctx, Credentials{VerifiedChains: cert("svc-reports")})
+	if err != nil || c.ID != "svc-reports" || c.Method != config.AuthMTLS {
+		t.Fatalf("expected the certificate to authenticate, got %+v, %v", c, err)
+	}
+	if _, err := authn.Authenticate(ctx, Credentials{VerifiedChains: cert("svc-other")}); err != ErrInvalidCredentials {
+		t.Errorf("expected an unlisted subject to be rejected, got %v", err)
+	}
+	if _, err := authn.Authenticate(ctx, Credentials{APIKey: "bad", VerifiedChains: cert("svc-reports")}); err != ErrInvalidCredentials {
+		t.Errorf("a rejected key should not fall through to the next method, got %v", err)
+	}
+	if _, err := authn.Authenticate(ctx, Credentials{}); err != ErrNoCredentials {
+		t.Errorf("expected ErrNoCredentials, got %v", err)
+	}
+}
+
+func TestWorkspace(t *testing.T) {
+	authn := BindWorkspaces(APIKeys{"k1": "billing", "k2": "reports", "k3": "ops", "k4": "batch"}, map[string][]string{
+		"billing": {"ws-a"},
+		"reports": {"ws-a", "ws-b"},
+		"ops":     {AllWorkspaces},
+	})
+	callerCtx := func(key string) context.Context {
+		c, err := authn.Authenticate(context.Background(), Credentials{APIKey: key})
+		if err != nil {
+			t.Fatalf("authenticate %s: %v", key, err)
+		}
+		return WithCaller(context.Background(), c)
+	}
+
+	tests := []struct {
+		name      string
+		ctx       context.Context
+		requested string
+		want      string
+		wantErr   bool
+	}{
+		{"no caller uses the request", context.Background(), "ws-z", "ws-z", false},
+		{"single workspace is implied", callerCtx("k1"), "", "ws-a", false},
+		{"bound workspace", callerCtx("k1"), "ws-a", "ws-a", false},
+		{"other tenant", callerCtx("k1"), "ws-b", "", true},
+		{"several workspaces, none named", callerCtx("k2"), "", "", false},
+		{"one of several", callerCtx("k2"), "ws-b", "ws-b", false},
+		{"any workspace", callerCtx("k3"), "ws-z", "ws-z", false},
+		{"unbound caller", callerCtx("k4"), "ws-a", "", true},
+		{"unbound caller, none named", callerCtx("k4"), "", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Workspace(tt.ctx, tt.requested)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("Workspace(%q) = %q, %v; want %q, error %v", tt.requested, got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	intercept := UnaryServerInterceptor(APIKeys{"k1": "billing"}, []string{"/nexus.v1.NexusService/ServerInfo"})
+	var seen string
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		if c, ok := CallerFrom(ctx); ok {
+			seen = c.ID
+		}
+		return "ok", nil
+	}
+	call := func(method string, md metadata.MD) error {
+		ctx := metadata.NewIncomingContext(context.Background(), md)
+		_, err := intercept(ctx, nil, &grpc.UnaryServerInfo{FullMethod: method}, handler)
+		return err
+	}
+
+	if err := call("/nexus.v1.NexusService/GetToken", metadata.Pairs("x-api-key", "k1")); err != nil || seen != "billing" {
+		t.Fatalf("expected billing to be authenticated, got caller %q, err %v", seen, err)
+	}
+	if err := call("/nexus.v1.NexusService/GetToken", metadata.MD{}); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("expected Unauthenticated, got %v", err)
+	}
+	if err := call("/nexus.v1.NexusService/ServerInfo", metadata.MD{}); err != nil {
+		t.Errorf("exempt method should not require credentials: %v", err)
+	}
+}
@@ -0,0 +1,110 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-gateway/pkg/config"
+)
+
+// JWT authenticates bearer tokens signed by a trusted issuer.
+type JWT struct {
+	issuer          string
+	audience        string
+	jwksURL         string
+	claim           string
+	workspacesClaim string
+	client          *http.Client
+
+	mu       sync.Mutex
+	verifier *oidc.IDTokenVerifier
+}
+
+// NewJWT verifies tokens from issuer with the keys at jwksURL, or those
+// named by the issuer's discovery document when jwksURL is empty. An empty
+// audience skips the aud check. The caller is named by claim ("sub" when
+// empty).
+func NewJWT(issuer, audience, jwksURL, claim string, client *http.Client) *JWT {
+	if claim == "" {
+		claim = "sub"
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &JWT{issuer: issuer, audience: audience, jwksURL: jwksURL, claim: claim, client: client}
+}
+
+// WithWorkspacesClaim reads the workspaces a caller acts for from claim, a
+// string or a list of strings.
+func (j *JWT) WithWorkspacesClaim(claim string) *JWT {
+	j.workspacesClaim = claim
+	return j
+}
+
+// Authenticate implements Authenticator.
+func (j *JWT) Authenticate(ctx context.Context, creds Credentials) (*Caller, error) {
+	if creds.BearerToken == "" {
+		return nil, ErrNoCredentials
+	}
+	v, err := j.getVerifier(ctx)
+	if err != nil {
+		log.Printf("auth: jwt issuer %s: %v", j.issuer, err)
+		return nil, ErrInvalidCredentials
+	}
+	tok, err := v.Verify(oidc.ClientContext(ctx, j.client), creds.BearerToken)
+	if err != nil {
+		return nil, ErrInvalidCredentials
+	}
+	var claims map[string]any
+	if err := tok.Claims(&claims); err != nil {
+		return nil, ErrInvalidCredentials
+	}
+	id, _ := claims[j.claim].(string)
+	if id == "" {
+		return nil, ErrInvalidCredentials
+	}
+	caller := &Caller{ID: id, Method: config.AuthJWT}
+	if j.workspacesClaim != "" {
+		switch v := claims[j.workspacesClaim].(type) {
+		case string:
+			caller.Workspaces = []string{v}
+		case []any:
+			for _, item := range v {
+				if ws, ok := item.(string); ok {
+					caller.Workspaces = append(caller.Workspaces, ws)
+				}
+			}
+		}
+	}
+	return caller, nil
+}
+
+// getVerifier builds the verifier on first use, so an issuer that is down
+// at startup does not stop the Gateway; a failed discovery is retried on
+// the next request.
+func (j *JWT) getVerifier(ctx context.Context) (*oidc.IDTokenVerifier, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.verifier != nil {
+		return j.verifier, nil
+	}
+	cfg := &oidc.Config{ClientID: j.audience, SkipClientIDCheck: j.audience == ""}
+	if j.jwksURL != "" {
+		// The key set fetches lazily with the context it is created with,
+		// so it must outlive this request.
+		keys := oidc.NewRemoteKeySet(oidc.ClientContext(context.Background(), j.client), j.jwksURL)
+		j.verifier = oidc.NewVerifier(j.issuer, keys, cfg)
+		return j.verifier, nil
+	}
+	prov, err := oidc.NewProvider(oidc.ClientContext(ctx, j.client), j.issuer)
+	if err != nil {
+		return nil, fmt.Errorf("discovery: %w", err)
+	}
+	j.verifier = prov.Verifier(cfg)
+	return j.verifier, nil
+}
@@ -0,0 +1,80 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+)
+
+func TestJWT_Authenticate(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwks := jose.JSONWebKeySet{Keys: []jose.JSONWebKey{{Key: &key.PublicKey, KeyID: "k1", Algorithm: "RS256", Use: "sig"}}}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(jwks)
+	}))
+	defer srv.Close()
+
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: key}, (&jose.SignerOptions{}).WithHeader("kid", "k1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sign := func(claims map[string]any) string {
+		payload, _ := json.Marshal(claims)
+		obj, err := signer.Sign(payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		raw, err := obj.CompactSerialize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return raw
+	}
+	exp := time.Now().Add(time.Hour).Unix()
+	j := NewJWT("https://idp.example.com", "nexus-gateway", srv.URL, "client_id", srv.Client())
+	ctx := context.Background()
+
+	c, err := j.Authenticate(ctx, Credentials{BearerToken: sign(map[string]any{
+		"iss": "https://idp.example.com", "aud": "nexus-gateway", "exp": exp, "sub": "u1", "client_id": "billing",
+	})})
+	if err != nil || c.ID != "billing" {
+		t.Fatalf("expected caller billing, got %+v, %v", c, err)
+	}
+	if len(c.Workspaces) != 0 {
+		t.Errorf("expected no workspaces without a workspaces claim, got %v", c.Workspaces)
+	}
+
+	j.WithWorkspacesClaim("workspaces")
+	c, err = j.Authenticate(ctx, Credentials{BearerToken: sign(map[string]any{
+		"iss": "https://idp.example.com", "aud": "nexus-gateway", "exp": exp, "client_id": "billing", "workspaces": []string{"ws-a", "ws-b"},
+	})})
+	if err != nil || !slices.Equal(c.Workspaces, []string{"ws-a", "ws-b"}) {
+		t.Fatalf("expected workspaces [ws-a ws-b], got %+v, %v", c, err)
+	}
+
+	rejected := map[string]map[string]any{
+		"wrong audience": {"iss": "https://idp.example.com", "aud": "other", "exp": exp, "client_id": "billing"},
+		"wrong issuer":   {"iss": "https://evil.example.com", "aud": "nexus-gateway", "exp": exp, "client_id": "billing"},
+		"expired":        {"iss": "https://idp.example.com", "aud": "nexus-gateway", "exp": time.Now().Add(-time.Hour).Unix(), "client_id": "billing"},
+		"no caller":      {"iss": "https://idp.example.com", "aud": "nexus-gateway", "exp": exp},
+	}
+	for name, claims := range rejected {
+		if _, err := j.Authenticate(ctx, Credentials{BearerToken: sign(claims)}); err != ErrInvalidCredentials {
+			t.Errorf("%s: expected ErrInvalidCredentials, got %v", name, err)
+		}
+	}
+	if _, err := j.Authenticate(ctx, Credentials{}); err != ErrNoCredentials {
+		t.Errorf("expected ErrNoCredentials without a bearer token, got %v", err)
+	}
+}
@@ -0,0 +1,38 @@
+package auth
+
+import (
+	"context"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-gateway/pkg/config"
+)
+
+// MTLS authenticates callers by the client certificate the TLS handshake
+// verified, naming them by its common name.
+type MTLS struct {
+	allowed map[string]bool
+}
+
+// NewMTLS accepts certificates whose common name is in allowedSubjects, or
+// any verified certificate when it is empty.
+func NewMTLS(allowedSubjects []string) *MTLS {
+	m := &MTLS{}
+	if len(allowedSubjects) > 0 {
+		m.allowed = make(map[string]bool, len(allowedSubjects))
+		for _, s := range allowedSubjects {
+			m.allowed[s] = true
+		}
+	}
+	return m
+}
+
+// Authenticate implements Authenticator.
+func (m *MTLS) Authenticate(_ context.Context, creds Credentials) (*Caller, error) {
+	if len(creds.VerifiedChains) == 0 || len(creds.VerifiedChains[0]) == 0 {
+		return nil, ErrNoCredentials
+	}
+	cn := creds.VerifiedChains[0][0].Subject.CommonName
+	if cn == "" || (m.allowed != nil && !m.allowed[cn]) {
+		return nil, ErrInvalidCredentials
+	}
+	return &Caller{ID: cn, Method: config.AuthMTLS}, nil
+}
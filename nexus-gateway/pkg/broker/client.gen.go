@@ -50,10 +50,19 @@ const (
 
 // ConsentSpecRequest defines model for ConsentSpecRequest.
 type ConsentSpecRequest struct {
-	ProviderId  *string   `json:"provider_id,omitempty"`
-	ReturnUrl   string    `json:"return_url"`
-	Scopes      *[]string `json:"scopes,omitempty"`
-	WorkspaceId string    `json:"workspace_id"`
+	ProviderId *string   `json:"provider_id,omitempty"`
+	ReturnUrl  string    `json:"return_url"`
+	Scopes     *[]string `json:"scopes,omitempty"`
+
+	// SessionHash Base64url SHA-256 of the browser session the flow is bound to, carried in the state. The callback and credential capture then complete only when the X-Nexus-Session header holds that session. Rejected with `invalid_session_hash` when malformed and `session_binding_unsupported` for service account providers.
+	SessionHash *string `json:"session_hash,omitempty"`
+
+	// Subject Email of the user a service account connection acts as through domain-wide delegation. Rejected with `subject_not_supported` for other providers and `invalid_subject` when not an email address.
+	Subject *string `json:"subject,omitempty"`
+
+	// Tenant Azure AD tenant to authorize against in place of the one the provider's endpoints name: common, organizations, consumers, a tenant ID or a domain. Rejected with `tenant_not_supported` for other providers and `invalid_tenant` when malformed.
+	Tenant      *string `json:"tenant,omitempty"`
+	WorkspaceId string  `json:"workspace_id"`
 }
 
 // ConsentSpecResponse defines model for ConsentSpecResponse.
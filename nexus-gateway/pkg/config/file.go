@@ -0,0 +1,93 @@
+package config
+
+import (
+	"io"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-common/settings"
+)
+
+// Setting documents one configuration key. Keys are environment variable
+// names; in a config file the same key is written in lower case
+// (e.g. BROKER_BASE_URL -> broker_base_url).
+type Setting = settings.Setting
+
+// Settings lists every key understood by Load, in the order printed by
+// PrintConfig.
+var Settings = []Setting{
+	{Key: "PORT", Default: "8090", Description: "HTTP listen port (nexus-rest)"},
+	{Key: "PORT_HTTP", Default: "8090", Description: "grpc-gateway HTTP listen port (nexus-grpc)"},
+	{Key: "PORT_GRPC", Default: "9090", Description: "gRPC listen port (nexus-grpc)"},
+	{Key: "BROKER_BASE_URL", Default: "http://localhost:8080", Description: "Base URL of the Nexus Broker"},
+	{Key: "BROKER_API_KEY", Description: "X-API-Key sent to the Broker", Secret: true},
+	{Key: "BROKER_GRPC_ADDR", Description: "host:port of the Broker's gRPC listener (its GRPC_PORT); when set, consent, token, refresh, connection status and provider lookups use gRPC instead of REST"},
+	{Key: "BROKER_GRPC_POOL_SIZE", Default: "4", Description: "gRPC connections opened to the Broker; calls are spread across them"},
+	{Key: "BROKER_GRPC_TLS", Default: "false", Description: "Use TLS, verified against the system roots, for the Broker gRPC connections"},
+	{Key: "STATE_KEY", Description: "Base64 32-byte HMAC key; must match the Broker's STATE_KEY (required unless STATE_KEYS is set)", Secret: true},
+	{Key: "STATE_KEYS", Description: "Ordered, comma-separated state keys (id:base64), all accepted; must match the Broker's. Replaces STATE_KEY, STATE_KEY_ID and STATE_PREVIOUS_KEYS", Secret: true},
+	{Key: "STATE_KEY_ID", Description: "ID of STATE_KEY in signed state; must match the Broker's STATE_KEY_ID (default: derived from the key)"},
+	{Key: "STATE_PREVIOUS_KEYS", Description: "Comma-separated retired state keys still accepted, each id:base64 or base64; must match the Broker's", Secret: true},
+	{Key: "STATE_TTL", Default: "10m", Description: "How long a signed state is accepted; must match the Broker's STATE_TTL"},
+	{Key: "ADMIN_API_KEY", Description: "X-Admin-Key required by /admin and /debug routes", Secret: true},
+	{Key: "ENABLE_DEBUG_ENDPOINTS", Default: "false", Description: "Expose /debug/pprof and /admin/runtime (requires ADMIN_API_KEY)"},
+	{Key: "ENABLE_API_DOCS", Default: "true", Description: "Serve the OpenAPI document at /openapi.json and Swagger UI (loaded from a public CDN) at /docs (nexus-rest)"},
+	{Key: "CORS_ALLOWED_ORIGINS", Default: "http://localhost:3000,http://localhost:5173", Description: "Comma-separated CORS origins (defaults are for local development only)"},
+	{Key: "WS_PROXY_ALLOWED_HOSTS", Description: "Comma-separated upstream hosts /v1/ws may connect to (empty disables the WebSocket proxy)"},
+	{Key: "WS_PROXY_ALLOW_NO_ORIGIN", Default: "false", Description: "Accept /v1/ws upgrades without an Origin header, from non-browser clients"},
+	{Key: "SESSION_COOKIE_INSECURE", Default: "false", Description: "Set the nexus_session cookie without the Secure attribute, so browsers keep it over plain HTTP (development only)"},
+	{Key: "AUTH_METHODS", Description: "Comma-separated caller authentication methods for /v1 and gRPC: api_key, jwt, mtls (empty leaves them open)"},
+	{Key: "AUTH_API_KEYS", Description: "Comma-separated caller:key pairs accepted in the X-API-Key header (api_key method)", Secret: true},
+	{Key: "AUTH_JWT_ISSUER", Description: "Issuer whose signed JWTs are accepted as Authorization: Bearer tokens (jwt method)"},
+	{Key: "AUTH_JWT_AUDIENCE", Description: "Required aud claim of caller JWTs (empty skips the audience check)"},
+	{Key: "AUTH_JWT_JWKS_URL", Description: "JWKS URL for caller JWTs (empty uses the issuer's OIDC discovery document)"},
+	{Key: "AUTH_JWT_CALLER_CLAIM", Default: "sub", Description: "JWT claim that names the caller"},
+	{Key: "AUTH_JWT_WORKSPACES_CLAIM", Description: "JWT claim (a string or list of strings) naming the workspaces the caller acts for, in addition to AUTH_CALLER_WORKSPACES"},
+	{Key: "AUTH_MTLS_ALLOWED_SUBJECTS", Description: "Comma-separated client certificate common names accepted (mtls method; empty accepts any verified certificate)"},
+	{Key: "AUTH_CALLER_WORKSPACES", Description: "Comma-separated caller:workspace pairs binding callers to the workspaces they act for (repeat a caller for several; workspace * allows any). An unbound caller sees only global providers"},
+	{Key: "AUTH_EXEMPT_PATHS", Default: "/v1/healthz,/v1/connection-result,/v1/capture-schema,/v1/capture-credential,/v1/redeem-grant", Description: "REST paths served without caller authentication (a trailing / exempts the prefix); browser-facing and grant redemption by default"},
+	{Key: "AUTH_EXEMPT_METHODS", Default: "/nexus.v1.NexusService/ServerInfo", Description: "Full gRPC method names served without caller authentication"},
+	{Key: "GRPC_RATE_LIMIT", Default: "0", Description: "Sustained gRPC requests per second allowed per caller (0 disables rate limiting)"},
+	{Key: "GRPC_RATE_BURST", Default: "20", Description: "gRPC requests a caller may make at once before GRPC_RATE_LIMIT applies"},
+	{Key: "TLS_CERT_FILE", Description: "PEM certificate chain served on TLS listeners (empty serves plain HTTP and gRPC); reloaded when the file changes"},
+	{Key: "TLS_KEY_FILE", Description: "PEM private key for TLS_CERT_FILE"},
+	{Key: "TLS_ACME_HOSTS", Description: "Comma-separated host names to obtain certificates for from an ACME CA, instead of TLS_CERT_FILE; certificates are issued only for these hosts"},
+	{Key: "TLS_ACME_CACHE_DIR", Description: "Directory holding ACME certificates and the account key (required with TLS_ACME_HOSTS; keep it across restarts to stay within CA rate limits)"},
+	{Key: "TLS_ACME_EMAIL", Description: "Contact email registered with the ACME account (optional)"},
+	{Key: "TLS_ACME_DIRECTORY_URL", Description: "ACME directory URL (empty uses Let's Encrypt production)"},
+	{Key: "TLS_ACME_HTTP_PORT", Default: "80", Description: "Port serving ACME HTTP-01 challenges and redirecting other requests to HTTPS (empty leaves only TLS-ALPN-01 challenges on the TLS listeners)"},
+	{Key: "TLS_CLIENT_CA_FILE", Description: "PEM CA bundle used to verify client certificates"},
+	{Key: "TLS_CLIENT_AUTH", Default: "none", Description: "Client certificate policy on TLS listeners: none, optional or require (optional and require need TLS_CLIENT_CA_FILE)"},
+	{Key: "TLS_LISTENERS", Default: "rest,grpc,http", Description: "Listeners that terminate TLS when TLS_CERT_FILE or TLS_ACME_HOSTS is set: rest (nexus-rest), grpc and http (nexus-grpc)"},
+	{Key: "RESPONSE_SIGNING_KEY_FILE", Description: "PEM private key (RSA, P-256 or Ed25519) that signs token responses with a detached JWS in X-Nexus-Signature (nexus-rest; empty sends them unsigned)"},
+	{Key: "RESPONSE_SIGNING_KEY_ID", Description: "kid written in response signatures (default: the key's RFC 7638 thumbprint)"},
+	{Key: "GRPC_SINGLE_PORT", Default: "false", Description: "Serve gRPC and the REST gateway together on PORT_GRPC, selected per request (nexus-grpc); PORT_HTTP is not opened"},
+	{Key: "HEALTH_CHECK_TIMEOUT", Default: "2s", Description: "Per-dependency timeout for /readyz"},
+	{Key: "REQUEST_TIMEOUT", Default: "30s", Description: "Time a REST request or RPC may take, Broker calls included, unless ROUTE_TIMEOUTS sets its route's"},
+	{Key: "ROUTE_TIMEOUTS", Default: "check_connection:10s,token:10s", Description: "Comma-separated route:duration overrides of REQUEST_TIMEOUT; routes are request_connection, check_connection, token, refresh, providers (including provider management) and capture_credential"},
+	{Key: "METADATA_CACHE_TTL", Default: "30s", Description: "How long provider metadata (GET /v1/providers) is cached per workspace (0 disables the cache)"},
+	{Key: "METADATA_CACHE_STALE", Default: "5m", Description: "How long after METADATA_CACHE_TTL cached provider metadata is still served while it is refreshed, or while the Broker fails"},
+	{Key: "SHUTDOWN_DRAIN_DELAY", Default: "5s", Description: "Time readiness reports draining before the listener closes"},
+	{Key: "SHUTDOWN_TIMEOUT", Default: "20s", Description: "Time in-flight requests may take to finish on shutdown"},
+}
+
+// source resolves a key from the environment first, then the config file,
+// then the default documented in Settings.
+type source struct {
+	settings.Source
+}
+
+func newSource(path string) (source, error) {
+	s, err := settings.Load(Settings, path)
+	return source{s}, err
+}
+
+func (s source) get(key string) string { return s.Get(key) }
+
+// list splits a comma-separated value, dropping empty entries.
+func (s source) list(key string) []string { return s.List(key) }
+
+// PrintConfig writes the effective configuration (env > file > default) as a
+// YAML document annotated with each key's description and default. Secrets
+// are redacted. The output can be used as a starting config file.
+func PrintConfig(w io.Writer, path string) error {
+	return settings.Print(w, Settings, path)
+}
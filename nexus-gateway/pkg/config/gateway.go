@@ -0,0 +1,502 @@
+package config
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-common/settings"
+	"github.com/Prescott-Data/nexus-framework/nexus-common/state"
+)
+
+// GatewayConfig holds all configuration for the nexus-rest and nexus-grpc
+// binaries. It is loaded once at startup and passed to the servers and
+// handlers; no other package should read os.Getenv directly.
+type GatewayConfig struct {
+	Port     string
+	PortHTTP string
+	PortGRPC string
+
+	BrokerBaseURL string
+	BrokerAPIKey  string
+
+	// Optional gRPC connection to the Broker for the calls its
+	// BrokerService covers
+	BrokerGRPC BrokerGRPCConfig
+
+	// Operator access: admin key and optional pprof/runtime diagnostics
+	AdminAPIKey          string
+	EnableDebugEndpoints bool
+
+	// Serve the OpenAPI document at /openapi.json and Swagger UI at /docs
+	// (nexus-rest)
+	EnableAPIDocs bool
+
+	StateKey []byte
+
+	// Verifies Broker-issued OAuth state with StateKey and the other keys
+	// configured for a rotation
+	StateKeys *state.Keyring
+
+	AllowedOrigins []string
+
+	// Upstream hosts the browser WebSocket proxy may dial, and whether it
+	// accepts upgrades without an Origin header
+	WSProxyAllowedHosts  []string
+	WSProxyAllowNoOrigin bool
+
+	// Sends the browser session cookie without Secure, for a Gateway
+	// served over plain HTTP
+	SessionCookieInsecure bool
+
+	// Caller authentication for /v1 routes and gRPC methods
+	Auth AuthConfig
+
+	// TLS termination for the listeners, and serving gRPC and REST on one
+	// port
+	TLS            TLSConfig
+	GRPCSinglePort bool
+
+	// Key that signs token responses (nexus-rest)
+	ResponseSigning ResponseSigningConfig
+
+	// Per-caller gRPC rate limit in requests per second (0 disables it)
+	// and the burst allowed above it
+	GRPCRateLimit float64
+	GRPCRateBurst int
+
+	// Readiness probe timeout applied to each dependency check
+	HealthCheckTimeout time.Duration
+
+	// How long a request may take, Broker calls included, by route
+	Timeouts Timeouts
+
+	// Provider metadata cache: how long metadata is fresh (0 disables the
+	// cache) and how much longer it may be served while being refreshed
+	MetadataCacheTTL   time.Duration
+	MetadataCacheStale time.Duration
+
+	// Graceful shutdown: how long readiness reports draining before the
+	// listener closes, and how long in-flight requests may take to finish.
+	ShutdownDrainDelay time.Duration
+	ShutdownTimeout    time.Duration
+}
+
+// BrokerGRPCConfig locates the Broker's gRPC listener. An empty Addr keeps
+// every Broker call on REST.
+type BrokerGRPCConfig struct {
+	Addr     string
+	PoolSize int
+	TLS      bool
+}
+
+// Routes whose timeout ROUTE_TIMEOUTS can set. Each names a REST route and
+// the RPC that serves it.
+const (
+	RouteRequestConnection = "request_connection"
+	RouteCheckConnection   = "check_connection"
+	RouteToken             = "token"
+	RouteRefresh           = "refresh"
+	RouteProviders         = "providers"
+	RouteCaptureCredential = "capture_credential"
+)
+
+var routes = []string{RouteRequestConnection, RouteCheckConnection, RouteToken, RouteRefresh, RouteProviders, RouteCaptureCredential}
+
+// Timeouts bounds requests. Default applies to every route without an
+// entry in Routes; zero leaves requests unbounded.
+type Timeouts struct {
+	Default time.Duration
+	Routes  map[string]time.Duration
+}
+
+// For returns the timeout of route.
+func (t Timeouts) For(route string) time.Duration {
+	if d, ok := t.Routes[route]; ok {
+		return d
+	}
+	return t.Default
+}
+
+// Max returns the longest timeout of any route.
+func (t Timeouts) Max() time.Duration {
+	longest := t.Default
+	for _, d := range t.Routes {
+		longest = max(longest, d)
+	}
+	return longest
+}
+
+// Caller authentication methods accepted in AUTH_METHODS.
+const (
+	AuthAPIKey = "api_key"
+	AuthJWT    = "jwt"
+	AuthMTLS   = "mtls"
+)
+
+// AuthConfig selects how callers of the Gateway's API authenticate. With no
+// Methods every caller is accepted, as before caller authentication existed.
+type AuthConfig struct {
+	// Methods are tried in order; the first that recognises the caller's
+	// credentials decides.
+	Methods []string
+	// APIKeys maps each accepted key to the caller name it identifies.
+	APIKeys map[string]string
+
+	JWTIssuer      string
+	JWTAudience    string
+	JWTJWKSURL     string
+	JWTCallerClaim string
+	// JWTWorkspacesClaim names the claim listing a JWT caller's
+	// workspaces. Empty reads none.
+	JWTWorkspacesClaim string
+
+	// CallerWorkspaces maps a caller name to the workspaces it acts for,
+	// whichever method authenticated it.
+	CallerWorkspaces map[string][]string
+
+	// MTLSAllowedSubjects restricts accepted client certificates by common
+	// name. Empty accepts any certificate that verified.
+	MTLSAllowedSubjects []string
+
+	// ExemptPaths and ExemptMethods are REST paths (a trailing slash
+	// matches a prefix) and full gRPC method names that skip
+	// authentication.
+	ExemptPaths   []string
+	ExemptMethods []string
+}
+
+// Enabled reports whether callers must authenticate.
+func (a AuthConfig) Enabled() bool { return len(a.Methods) > 0 }
+
+// Listener names used in TLS_LISTENERS.
+const (
+	ListenerREST = "rest"
+	ListenerGRPC = "grpc"
+	ListenerHTTP = "http"
+)
+
+// Client certificate policies accepted in TLS_CLIENT_AUTH.
+const (
+	ClientAuthNone     = "none"
+	ClientAuthOptional = "optional"
+	ClientAuthRequire  = "require"
+)
+
+// TLSConfig locates the serving certificate, or obtains one with ACME, and
+// the client CA, and selects the listeners that use them.
+type TLSConfig struct {
+	CertFile     string
+	KeyFile      string
+	ACME         ACMEConfig
+	ClientCAFile string
+	ClientAuth   string
+	Listeners    []string
+}
+
+// ACMEConfig obtains and renews the serving certificate from an ACME CA
+// (Let's Encrypt by default) for Hosts, instead of reading it from
+// TLS_CERT_FILE. Certificates and the account key are kept in CacheDir.
+// HTTPPort serves HTTP-01 challenges and redirects other requests to
+// HTTPS; when it is empty only TLS-ALPN-01 challenges, answered on the TLS
+// listeners, are used.
+type ACMEConfig struct {
+	Hosts        []string
+	CacheDir     string
+	Email        string
+	DirectoryURL string
+	HTTPPort     string
+}
+
+// Configured reports whether a certificate is configured, from files or
+// ACME.
+func (t TLSConfig) Configured() bool {
+	return t.CertFile != "" || len(t.ACME.Hosts) > 0
+}
+
+// Enabled reports whether listener terminates TLS.
+func (t TLSConfig) Enabled(listener string) bool {
+	return t.Configured() && slices.Contains(t.Listeners, listener)
+}
+
+// ResponseSigningConfig locates the private key that signs token
+// responses. An empty KeyFile sends them unsigned; an empty KeyID defaults
+// to the key's thumbprint.
+type ResponseSigningConfig struct {
+	KeyFile string
+	KeyID   string
+}
+
+// Features lists the optional behaviour this configuration enables, sorted,
+// for GET /version.
+func (c *GatewayConfig) Features() []string {
+	features := []string{}
+	for name, on := range map[string]bool{
+		"admin_api":        c.AdminAPIKey != "",
+		"api_docs":         c.EnableAPIDocs,
+		"broker_api_key":   c.BrokerAPIKey != "",
+		"broker_grpc":      c.BrokerGRPC.Addr != "",
+		"caller_auth":      c.Auth.Enabled(),
+		"debug_endpoints":  c.EnableDebugEndpoints,
+		"grpc_rate_limit":  c.GRPCRateLimit > 0,
+		"metadata_cache":   c.MetadataCacheTTL > 0,
+		"response_signing": c.ResponseSigning.KeyFile != "",
+		"tls":              c.TLS.Configured(),
+		"tls_acme":         len(c.TLS.ACME.Hosts) > 0,
+		"tls_client_auth":  c.TLS.Configured() && c.TLS.ClientAuth != ClientAuthNone,
+		"websocket_proxy":  len(c.WSProxyAllowedHosts) > 0,
+	} {
+		if on {
+			features = append(features, name)
+		}
+	}
+	sort.Strings(features)
+	return features
+}
+
+// Load reads configuration from the file named by CONFIG_FILE (if any) and
+// the environment. See LoadFile.
+func Load() (*GatewayConfig, error) {
+	return LoadFile(os.Getenv("CONFIG_FILE"))
+}
+
+// LoadFile reads configuration from the YAML file at path (optional; empty
+// skips it), applies environment overrides and the defaults documented in
+// Settings, and validates the result.
+func LoadFile(path string) (*GatewayConfig, error) {
+	src, err := newSource(path)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &GatewayConfig{
+		Port:          src.get("PORT"),
+		PortHTTP:      src.get("PORT_HTTP"),
+		PortGRPC:      src.get("PORT_GRPC"),
+		BrokerBaseURL: strings.TrimRight(src.get("BROKER_BASE_URL"), "/"),
+		BrokerAPIKey:  src.get("BROKER_API_KEY"),
+
+		AdminAPIKey:          src.get("ADMIN_API_KEY"),
+		EnableDebugEndpoints: strings.EqualFold(src.get("ENABLE_DEBUG_ENDPOINTS"), "true"),
+		EnableAPIDocs:        strings.EqualFold(src.get("ENABLE_API_DOCS"), "true"),
+	}
+
+	origins := src.get("CORS_ALLOWED_ORIGINS")
+	for _, o := range strings.Split(origins, ",") {
+		if o = strings.TrimSpace(o); o != "" {
+			cfg.AllowedOrigins = append(cfg.AllowedOrigins, o)
+		}
+	}
+	for _, h := range strings.Split(src.get("WS_PROXY_ALLOWED_HOSTS"), ",") {
+		if h = strings.TrimSpace(h); h != "" {
+			cfg.WSProxyAllowedHosts = append(cfg.WSProxyAllowedHosts, h)
+		}
+	}
+	cfg.WSProxyAllowNoOrigin = strings.EqualFold(src.get("WS_PROXY_ALLOW_NO_ORIGIN"), "true")
+	cfg.SessionCookieInsecure = strings.EqualFold(src.get("SESSION_COOKIE_INSECURE"), "true")
+	if st, _ := settings.Lookup(Settings, "CORS_ALLOWED_ORIGINS"); origins == st.Default {
+		log.Printf("CORS: CORS_ALLOWED_ORIGINS not set. Using permissive dev defaults: %v", cfg.AllowedOrigins)
+		log.Printf("CORS: WARNING: Do not use these defaults in production.")
+	}
+
+	cfg.BrokerGRPC = BrokerGRPCConfig{
+		Addr: src.get("BROKER_GRPC_ADDR"),
+		TLS:  strings.EqualFold(src.get("BROKER_GRPC_TLS"), "true"),
+	}
+	if cfg.BrokerGRPC.PoolSize, err = strconv.Atoi(src.get("BROKER_GRPC_POOL_SIZE")); err != nil || cfg.BrokerGRPC.PoolSize < 1 {
+		return nil, fmt.Errorf("BROKER_GRPC_POOL_SIZE must be a positive integer, got %q", src.get("BROKER_GRPC_POOL_SIZE"))
+	}
+
+	if cfg.Auth, err = src.auth(); err != nil {
+		return nil, err
+	}
+
+	if cfg.TLS, err = src.tls(); err != nil {
+		return nil, err
+	}
+	cfg.GRPCSinglePort = strings.EqualFold(src.get("GRPC_SINGLE_PORT"), "true")
+	cfg.ResponseSigning = ResponseSigningConfig{
+		KeyFile: src.get("RESPONSE_SIGNING_KEY_FILE"),
+		KeyID:   src.get("RESPONSE_SIGNING_KEY_ID"),
+	}
+	if cfg.ResponseSigning.KeyID != "" && cfg.ResponseSigning.KeyFile == "" {
+		return nil, fmt.Errorf("RESPONSE_SIGNING_KEY_ID requires RESPONSE_SIGNING_KEY_FILE")
+	}
+
+	if cfg.GRPCRateLimit, err = strconv.ParseFloat(src.get("GRPC_RATE_LIMIT"), 64); err != nil || cfg.GRPCRateLimit < 0 {
+		return nil, fmt.Errorf("GRPC_RATE_LIMIT must be a non-negative number, got %q", src.get("GRPC_RATE_LIMIT"))
+	}
+	if cfg.GRPCRateBurst, err = strconv.Atoi(src.get("GRPC_RATE_BURST")); err != nil || cfg.GRPCRateBurst < 1 {
+		return nil, fmt.Errorf("GRPC_RATE_BURST must be a positive integer, got %q", src.get("GRPC_RATE_BURST"))
+	}
+
+	if cfg.HealthCheckTimeout, err = src.duration("HEALTH_CHECK_TIMEOUT"); err != nil {
+		return nil, err
+	}
+	if cfg.Timeouts, err = src.timeouts(); err != nil {
+		return nil, err
+	}
+	if cfg.MetadataCacheTTL, err = src.optionalDuration("METADATA_CACHE_TTL"); err != nil {
+		return nil, err
+	}
+	if cfg.MetadataCacheStale, err = src.optionalDuration("METADATA_CACHE_STALE"); err != nil {
+		return nil, err
+	}
+	if cfg.ShutdownDrainDelay, err = src.duration("SHUTDOWN_DRAIN_DELAY"); err != nil {
+		return nil, err
+	}
+	if cfg.ShutdownTimeout, err = src.duration("SHUTDOWN_TIMEOUT"); err != nil {
+		return nil, err
+	}
+
+	if cfg.BrokerBaseURL == "" {
+		return nil, fmt.Errorf("BROKER_BASE_URL is required")
+	}
+	if cfg.EnableDebugEndpoints && cfg.AdminAPIKey == "" {
+		return nil, fmt.Errorf("ENABLE_DEBUG_ENDPOINTS is true but ADMIN_API_KEY is not set")
+	}
+
+	if cfg.StateKey, cfg.StateKeys, err = state.KeyringFromEnv(src.get); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+func (s source) auth() (AuthConfig, error) {
+	a := AuthConfig{
+		JWTIssuer:           strings.TrimRight(s.get("AUTH_JWT_ISSUER"), "/"),
+		JWTAudience:         s.get("AUTH_JWT_AUDIENCE"),
+		JWTJWKSURL:          s.get("AUTH_JWT_JWKS_URL"),
+		JWTCallerClaim:      s.get("AUTH_JWT_CALLER_CLAIM"),
+		JWTWorkspacesClaim:  s.get("AUTH_JWT_WORKSPACES_CLAIM"),
+		MTLSAllowedSubjects: s.list("AUTH_MTLS_ALLOWED_SUBJECTS"),
+		ExemptPaths:         s.list("AUTH_EXEMPT_PATHS"),
+		ExemptMethods:       s.list("AUTH_EXEMPT_METHODS"),
+	}
+	for _, m := range s.list("AUTH_METHODS") {
+		m = strings.ToLower(m)
+		switch m {
+		case AuthAPIKey, AuthJWT, AuthMTLS:
+			a.Methods = append(a.Methods, m)
+		default:
+			return a, fmt.Errorf("AUTH_METHODS: unknown method %q (want api_key, jwt or mtls)", m)
+		}
+	}
+	for _, pair := range s.list("AUTH_API_KEYS") {
+		caller, key, ok := strings.Cut(pair, ":")
+		caller, key = strings.TrimSpace(caller), strings.TrimSpace(key)
+		if !ok || caller == "" || key == "" {
+			return a, fmt.Errorf("AUTH_API_KEYS entries must be caller:key")
+		}
+		if a.APIKeys == nil {
+			a.APIKeys = map[string]string{}
+		}
+		a.APIKeys[key] = caller
+	}
+	for _, pair := range s.list("AUTH_CALLER_WORKSPACES") {
+		caller, workspace, ok := strings.Cut(pair, ":")
+		caller, workspace = strings.TrimSpace(caller), strings.TrimSpace(workspace)
+		if !ok || caller == "" || workspace == "" {
+			return a, fmt.Errorf("AUTH_CALLER_WORKSPACES entries must be caller:workspace")
+		}
+		if a.CallerWorkspaces == nil {
+			a.CallerWorkspaces = map[string][]string{}
+		}
+		a.CallerWorkspaces[caller] = append(a.CallerWorkspaces[caller], workspace)
+	}
+	for _, m := range a.Methods {
+		switch {
+		case m == AuthAPIKey && len(a.APIKeys) == 0:
+			return a, fmt.Errorf("AUTH_METHODS includes api_key but AUTH_API_KEYS is empty")
+		case m == AuthJWT && a.JWTIssuer == "":
+			return a, fmt.Errorf("AUTH_METHODS includes jwt but AUTH_JWT_ISSUER is empty")
+		}
+	}
+	return a, nil
+}
+
+func (s source) tls() (TLSConfig, error) {
+	t := TLSConfig{
+		CertFile:     s.get("TLS_CERT_FILE"),
+		KeyFile:      s.get("TLS_KEY_FILE"),
+		ClientCAFile: s.get("TLS_CLIENT_CA_FILE"),
+		ClientAuth:   strings.ToLower(s.get("TLS_CLIENT_AUTH")),
+		ACME: ACMEConfig{
+			Hosts:        s.list("TLS_ACME_HOSTS"),
+			CacheDir:     s.get("TLS_ACME_CACHE_DIR"),
+			Email:        s.get("TLS_ACME_EMAIL"),
+			DirectoryURL: s.get("TLS_ACME_DIRECTORY_URL"),
+			HTTPPort:     s.get("TLS_ACME_HTTP_PORT"),
+		},
+	}
+	for _, l := range s.list("TLS_LISTENERS") {
+		l = strings.ToLower(l)
+		switch l {
+		case ListenerREST, ListenerGRPC, ListenerHTTP:
+			t.Listeners = append(t.Listeners, l)
+		default:
+			return t, fmt.Errorf("TLS_LISTENERS: unknown listener %q (want rest, grpc or http)", l)
+		}
+	}
+	switch {
+	case (t.CertFile == "") != (t.KeyFile == ""):
+		return t, fmt.Errorf("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	case t.ClientAuth != ClientAuthNone && t.ClientAuth != ClientAuthOptional && t.ClientAuth != ClientAuthRequire:
+		return t, fmt.Errorf("TLS_CLIENT_AUTH must be none, optional or require, got %q", t.ClientAuth)
+	case t.ClientAuth != ClientAuthNone && t.ClientCAFile == "":
+		return t, fmt.Errorf("TLS_CLIENT_AUTH=%s requires TLS_CLIENT_CA_FILE", t.ClientAuth)
+	case t.CertFile != "" && len(t.ACME.Hosts) > 0:
+		return t, fmt.Errorf("TLS_CERT_FILE and TLS_ACME_HOSTS are mutually exclusive")
+	case len(t.ACME.Hosts) > 0 && t.ACME.CacheDir == "":
+		return t, fmt.Errorf("TLS_ACME_HOSTS requires TLS_ACME_CACHE_DIR")
+	case t.ClientCAFile != "" && !t.Configured():
+		return t, fmt.Errorf("TLS_CLIENT_CA_FILE requires TLS_CERT_FILE or TLS_ACME_HOSTS")
+	}
+	return t, nil
+}
+
+func (s source) timeouts() (Timeouts, error) {
+	def, err := s.duration("REQUEST_TIMEOUT")
+	if err != nil {
+		return Timeouts{}, err
+	}
+	t := Timeouts{Default: def, Routes: map[string]time.Duration{}}
+	for _, pair := range s.list("ROUTE_TIMEOUTS") {
+		route, v, ok := strings.Cut(pair, ":")
+		route, v = strings.ToLower(strings.TrimSpace(route)), strings.TrimSpace(v)
+		if !ok || !slices.Contains(routes, route) {
+			return t, fmt.Errorf("ROUTE_TIMEOUTS entries must be route:duration with route one of %s, got %q", strings.Join(routes, ", "), pair)
+		}
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return t, fmt.Errorf("ROUTE_TIMEOUTS: %s must be a positive duration (e.g. 10s), got %q", route, v)
+		}
+		t.Routes[route] = d
+	}
+	return t, nil
+}
+
+func (s source) duration(key string) (time.Duration, error) {
+	v := s.get(key)
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("%s must be a positive duration (e.g. 2s), got %q", key, v)
+	}
+	return d, nil
+}
+
+// optionalDuration is duration for a key where 0 turns a feature off.
+func (s source) optionalDuration(key string) (time.Duration, error) {
+	v := s.get(key)
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("%s must be a non-negative duration (e.g. 30s, 0 to disable), got %q", key, v)
+	}
+	return d, nil
+}
@@ -0,0 +1,344 @@
+package config
+
+import (
+	"bytes"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testKey() string {
+	return base64.StdEncoding.EncodeToString(make([]byte, 32))
+}
+
+func TestLoadFile_EnvOverridesFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "gateway.yaml")
+	body := "port: 9100\nbroker_base_url: http://broker:8080/\nstate_key: " + testKey() + "\ncors_allowed_origins: [https://app.example.com]\nws_proxy_allowed_hosts: [realtime.example.com, stream.example.com:8443]\n"
+	if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PORT", "")
+	t.Setenv("BROKER_BASE_URL", "")
+	t.Setenv("STATE_KEY", "")
+	t.Setenv("CORS_ALLOWED_ORIGINS", "")
+	t.Setenv("WS_PROXY_ALLOWED_HOSTS", "")
+	t.Setenv("BROKER_API_KEY", "env-key")
+
+	cfg, err := LoadFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.Port != "9100" || cfg.BrokerBaseURL != "http://broker:8080" {
+		t.Errorf("expected file values, got port=%s broker=%s", cfg.Port, cfg.BrokerBaseURL)
+	}
+	if cfg.BrokerAPIKey != "env-key" {
+		t.Errorf("expected BROKER_API_KEY from env, got %q", cfg.BrokerAPIKey)
+	}
+	if len(cfg.AllowedOrigins) != 1 || cfg.AllowedOrigins[0] != "https://app.example.com" {
+		t.Errorf("unexpected origins: %v", cfg.AllowedOrigins)
+	}
+	if len(cfg.WSProxyAllowedHosts) != 2 || cfg.WSProxyAllowedHosts[1] != "stream.example.com:8443" {
+		t.Errorf("unexpected ws proxy hosts: %v", cfg.WSProxyAllowedHosts)
+	}
+	if cfg.ShutdownTimeout != 20*time.Second {
+		t.Errorf("expected default shutdown timeout, got %s", cfg.ShutdownTimeout)
+	}
+	if !cfg.EnableAPIDocs || !slices.Contains(cfg.Features(), "api_docs") {
+		t.Error("expected API docs to be served by default")
+	}
+}
+
+func TestLoadFile_Validation(t *testing.T) {
+	t.Setenv("STATE_KEY", "")
+	if _, err := LoadFile(""); err == nil {
+		t.Error("expected error for missing STATE_KEY")
+	}
+
+	t.Setenv("STATE_KEY", testKey())
+	t.Setenv("HEALTH_CHECK_TIMEOUT", "soon")
+	if _, err := LoadFile(""); err == nil {
+		t.Error("expected error for invalid HEALTH_CHECK_TIMEOUT")
+	}
+	t.Setenv("HEALTH_CHECK_TIMEOUT", "")
+
+	t.Setenv("GRPC_RATE_LIMIT", "-1")
+	if _, err := LoadFile(""); err == nil {
+		t.Error("expected error for negative GRPC_RATE_LIMIT")
+	}
+	t.Setenv("GRPC_RATE_LIMIT", "")
+	t.Setenv("GRPC_RATE_BURST", "0")
+	if _, err := LoadFile(""); err == nil {
+		t.Error("expected error for zero GRPC_RATE_BURST")
+	}
+	t.Setenv("GRPC_RATE_BURST", "")
+
+	t.Setenv("RESPONSE_SIGNING_KEY_ID", "k1")
+	if _, err := LoadFile(""); err == nil {
+		t.Error("expected error for RESPONSE_SIGNING_KEY_ID without RESPONSE_SIGNING_KEY_FILE")
+	}
+	t.Setenv("RESPONSE_SIGNING_KEY_ID", "")
+
+	path := filepath.Join(t.TempDir(), "gateway.yaml")
+	if err := os.WriteFile(path, []byte("brokr_base_url: x\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadFile(path); err == nil {
+		t.Error("expected error for unknown key")
+	}
+}
+
+func TestLoadFile_StateKeys(t *testing.T) {
+	t.Setenv("STATE_KEY", testKey())
+	t.Setenv("STATE_KEY_ID", "2025-06")
+	t.Setenv("STATE_PREVIOUS_KEYS", "2025-01:"+base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32)))
+	t.Setenv("STATE_TTL", "5m")
+
+	cfg, err := LoadFile("")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ids := cfg.StateKeys.KeyIDs(); !slices.Equal(ids, []string{"2025-06", "2025-01"}) {
+		t.Errorf("key IDs = %v", ids)
+	}
+	if cfg.StateKeys.TTL() != 5*time.Minute {
+		t.Errorf("TTL = %s, want 5m", cfg.StateKeys.TTL())
+	}
+
+	t.Setenv("STATE_PREVIOUS_KEYS", "2025-01:short")
+	if _, err := LoadFile(""); err == nil || !strings.Contains(err.Error(), "STATE_PREVIOUS_KEYS") {
+		t.Errorf("expected STATE_PREVIOUS_KEYS error, got %v", err)
+	}
+
+	// STATE_KEYS replaces the single-key settings and keeps its order.
+	t.Setenv("STATE_KEYS", "b:"+testKey()+",a:"+base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32)))
+	if _, err := LoadFile(""); err == nil || !strings.Contains(err.Error(), "STATE_KEYS and STATE_KEY") {
+		t.Errorf("expected conflict between STATE_KEYS and STATE_KEY, got %v", err)
+	}
+	t.Setenv("STATE_KEY", "")
+	t.Setenv("STATE_KEY_ID", "")
+	t.Setenv("STATE_PREVIOUS_KEYS", "")
+	cfg, err = LoadFile("")
+	if err != nil {
+		t.Fatalf("STATE_KEYS: unexpected error: %v", err)
+	}
+	if ids := cfg.StateKeys.KeyIDs(); !slices.Equal(ids, []string{"b", "a"}) {
+		t.Errorf("STATE_KEYS key IDs = %v", ids)
+	}
+}
+
+func TestLoadFile_DebugEndpointsRequireAdminKey(t *testing.T) {
+	t.Setenv("STATE_KEY", testKey())
+	t.Setenv("ENABLE_DEBUG_ENDPOINTS", "true")
+	t.Setenv("ADMIN_API_KEY", "")
+	if _, err := LoadFile(""); err == nil {
+		t.Error("expected error when debug endpoints are enabled without ADMIN_API_KEY")
+	}
+
+	t.Setenv("ADMIN_API_KEY", "ops-secret")
+	cfg, err := LoadFile("")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !cfg.EnableDebugEndpoints || cfg.AdminAPIKey != "ops-secret" {
+		t.Errorf("unexpected admin config: enabled=%v key=%q", cfg.EnableDebugEndpoints, cfg.AdminAPIKey)
+	}
+}
+
+func TestPrintConfig_RedactsSecrets(t *testing.T) {
+	t.Setenv("STATE_KEY", testKey())
+
+	var buf bytes.Buffer
+	if err := PrintConfig(&buf, ""); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(buf.String(), testKey()) {
+		t.Error("secret value leaked in printed config")
+	}
+	if !strings.Contains(buf.String(), "state_key: <redacted>") {
+		t.Errorf("expected redacted state_key, got:\n%s", buf.String())
+	}
+}
+
+func TestLoadFile_Auth(t *testing.T) {
+	t.Setenv("STATE_KEY", testKey())
+	t.Setenv("AUTH_METHODS", "")
+
+	cfg, err := LoadFile("")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.Auth.Enabled() || slices.Contains(cfg.Features(), "caller_auth") {
+		t.Error("caller auth should be off by default")
+	}
+	if !slices.Contains(cfg.Auth.ExemptPaths, "/v1/connection-result") {
+		t.Errorf("browser-facing routes should be exempt by default, got %v", cfg.Auth.ExemptPaths)
+	}
+	if slices.Contains(cfg.Auth.ExemptPaths, "/v1/ws/") {
+		t.Errorf("the WebSocket proxy must not be exempt by default, got %v", cfg.Auth.ExemptPaths)
+	}
+
+	t.Setenv("AUTH_METHODS", "api_key, JWT")
+	t.Setenv("AUTH_API_KEYS", "billing:k1, reports:k2")
+	t.Setenv("AUTH_JWT_ISSUER", "https://idp.example.com/")
+	t.Setenv("AUTH_CALLER_WORKSPACES", "billing:ws-a, billing:ws-b, reports:*")
+	cfg, err = LoadFile("")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !slices.Equal(cfg.Auth.Methods, []string{AuthAPIKey, AuthJWT}) || !slices.Contains(cfg.Features(), "caller_auth") {
+		t.Errorf("unexpected methods %v", cfg.Auth.Methods)
+	}
+	if cfg.Auth.APIKeys["k2"] != "reports" || cfg.Auth.JWTIssuer != "https://idp.example.com" || cfg.Auth.JWTCallerClaim != "sub" {
+		t.Errorf("unexpected auth config %+v", cfg.Auth)
+	}
+	if !slices.Equal(cfg.Auth.CallerWorkspaces["billing"], []string{"ws-a", "ws-b"}) || !slices.Equal(cfg.Auth.CallerWorkspaces["reports"], []string{"*"}) {
+		t.Errorf("unexpected caller workspaces %v", cfg.Auth.CallerWorkspaces)
+	}
+
+	for env, value := range map[string]string{
+		"AUTH_METHODS":           "basic",
+		"AUTH_API_KEYS":          "no-caller-name",
+		"AUTH_JWT_ISSUER":        "",
+		"AUTH_CALLER_WORKSPACES": "billing",
+	} {
+		t.Run(env, func(t *testing.T) {
+			t.Setenv(env, value)
+			if _, err := LoadFile(""); err == nil {
+				t.Errorf("expected an error for %s=%q", env, value)
+			}
+		})
+	}
+}
+
+func TestLoadFile_TLS(t *testing.T) {
+	t.Setenv("STATE_KEY", testKey())
+	t.Setenv("TLS_CERT_FILE", "/etc/nexus/tls.crt")
+	t.Setenv("TLS_KEY_FILE", "/etc/nexus/tls.key")
+	t.Setenv("TLS_LISTENERS", "grpc")
+
+	cfg, err := LoadFile("")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !cfg.TLS.Enabled(ListenerGRPC) || cfg.TLS.Enabled(ListenerHTTP) || cfg.TLS.ClientAuth != ClientAuthNone {
+		t.Errorf("unexpected TLS config %+v", cfg.TLS)
+	}
+
+	for env, value := range map[string]string{
+		"TLS_KEY_FILE":    "",
+		"TLS_CLIENT_AUTH": "require",
+		"TLS_LISTENERS":   "admin",
+	} {
+		t.Run(env, func(t *testing.T) {
+			t.Setenv(env, value)
+			if _, err := LoadFile(""); err == nil {
+				t.Errorf("expected an error for %s=%q", env, value)
+			}
+		})
+	}
+}
+
+func TestLoadFile_ACME(t *testing.T) {
+	t.Setenv("STATE_KEY", testKey())
+	t.Setenv("TLS_ACME_HOSTS", "gateway.example.com, api.example.com")
+	t.Setenv("TLS_ACME_CACHE_DIR", "/var/lib/nexus/acme")
+
+	cfg, err := LoadFile("")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !cfg.TLS.Enabled(ListenerREST) || cfg.TLS.ACME.HTTPPort != "80" || len(cfg.TLS.ACME.Hosts) != 2 {
+		t.Errorf("unexpected TLS config %+v", cfg.TLS)
+	}
+
+	t.Run("with a certificate file", func(t *testing.T) {
+		t.Setenv("TLS_CERT_FILE", "/etc/nexus/tls.crt")
+		t.Setenv("TLS_KEY_FILE", "/etc/nexus/tls.key")
+		if _, err := LoadFile(""); err == nil {
+			t.Error("expected TLS_CERT_FILE and TLS_ACME_HOSTS to be rejected together")
+		}
+	})
+	t.Run("without a cache dir", func(t *testing.T) {
+		t.Setenv("TLS_ACME_CACHE_DIR", "")
+		if _, err := LoadFile(""); err == nil {
+			t.Error("expected TLS_ACME_HOSTS without TLS_ACME_CACHE_DIR to be rejected")
+		}
+	})
+}
+
+func TestLoadFile_Timeouts(t *testing.T) {
+	t.Setenv("STATE_KEY", testKey())
+	t.Setenv("REQUEST_TIMEOUT", "")
+	t.Setenv("ROUTE_TIMEOUTS", "")
+
+	cfg, err := LoadFile("")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := cfg.Timeouts.For(RouteRequestConnection); got != 30*time.Second {
+		t.Errorf("expected the 30s default for request_connection, got %s", got)
+	}
+	if got := cfg.Timeouts.For(RouteToken); got != 10*time.Second {
+		t.Errorf("expected the 10s default for token, got %s", got)
+	}
+
+	t.Setenv("REQUEST_TIMEOUT", "20s")
+	t.Setenv("ROUTE_TIMEOUTS", "request_connection:45s, Token:5s")
+	if cfg, err = LoadFile(""); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := map[string]time.Duration{
+		RouteRequestConnection: 45 * time.Second,
+		RouteToken:             5 * time.Second,
+		RouteCheckConnection:   20 * time.Second,
+		"":                     20 * time.Second,
+	}
+	for route, d := range want {
+		if got := cfg.Timeouts.For(route); got != d {
+			t.Errorf("timeout for %q = %s, want %s", route, got, d)
+		}
+	}
+	if got := cfg.Timeouts.Max(); got != 45*time.Second {
+		t.Errorf("Max() = %s, want 45s", got)
+	}
+
+	for _, value := range []string{"tokens:5s", "token", "token:0s", "token:soon"} {
+		t.Run(value, func(t *testing.T) {
+			t.Setenv("ROUTE_TIMEOUTS", value)
+			if _, err := LoadFile(""); err == nil {
+				t.Errorf("expected an error for ROUTE_TIMEOUTS=%q", value)
+			}
+		})
+	}
+}
+
+func TestLoadFile_MetadataCache(t *testing.T) {
+	t.Setenv("STATE_KEY", testKey())
+	t.Setenv("METADATA_CACHE_TTL", "")
+	t.Setenv("METADATA_CACHE_STALE", "")
+
+	cfg, err := LoadFile("")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.MetadataCacheTTL != 30*time.Second || cfg.MetadataCacheStale != 5*time.Minute || !slices.Contains(cfg.Features(), "metadata_cache") {
+		t.Errorf("expected the metadata cache on by default, got ttl=%s stale=%s", cfg.MetadataCacheTTL, cfg.MetadataCacheStale)
+	}
+
+	t.Setenv("METADATA_CACHE_TTL", "0")
+	if cfg, err = LoadFile(""); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.MetadataCacheTTL != 0 || slices.Contains(cfg.Features(), "metadata_cache") {
+		t.Error("expected METADATA_CACHE_TTL=0 to disable the cache")
+	}
+
+	t.Setenv("METADATA_CACHE_STALE", "-1s")
+	if _, err := LoadFile(""); err == nil {
+		t.Error("expected an error for a negative METADATA_CACHE_STALE")
+	}
+}
@@ -0,0 +1,177 @@
+package grpcsrv
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-common/problem"
+	"github.com/Prescott-Data/nexus-framework/nexus-sdk/redact"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-gateway/pkg/usecase"
+)
+
+// errorDomain is the ErrorInfo domain of gateway errors.
+const errorDomain = "nexus-gateway"
+
+// statusFromError maps a usecase error to a gRPC status carrying an
+// ErrorInfo detail whose reason matches the REST API's error code
+// (provider_not_found, broker_error, ...). Errors that already carry a
+// status are returned unchanged.
+func statusFromError(err error) error {
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	var be *usecase.BrokerStatusError
+	var ve *usecase.ValidationError
+	switch {
+	case errors.As(err, &ve):
+		return validationStatus(ve)
+	case errors.Is(err, usecase.ErrProviderNotFound):
+		return withReason(codes.NotFound, "provider_not_found", err, nil)
+	case errors.Is(err, usecase.ErrMissingFields):
+		return withReason(codes.InvalidArgument, "missing_fields", err, nil)
+	case errors.Is(err, usecase.ErrInvalidState):
+		return withReason(codes.InvalidArgument, "invalid_state", err, nil)
+	case errors.Is(err, usecase.ErrInvalidJSON):
+		return withReason(codes.InvalidArgument, "invalid_json", err, nil)
+	case errors.Is(err, usecase.ErrProviderAmbiguous):
+		return withReason(codes.FailedPrecondition, "provider_ambiguous", err, nil)
+	case errors.As(err, &be) && be.Status == http.StatusLocked:
+		return withReason(codes.FailedPrecondition, "connection_suspended", err, map[string]string{"broker_status": strconv.Itoa(be.Status)})
+	case errors.As(err, &be):
+		return withReason(brokerStatusCode(be.Status), "broker_error", err, map[string]string{"broker_status": strconv.Itoa(be.Status)})
+	case errors.Is(err, usecase.ErrBrokerUnavailable):
+		return withReason(codes.Unavailable, "broker_unavailable", err, nil)
+	case errors.Is(err, usecase.ErrBrokerInvalidResponse):
+		return withReason(codes.Unavailable, "broker_invalid_response", err, nil)
+	case errors.Is(err, context.DeadlineExceeded):
+		return withReason(codes.DeadlineExceeded, "deadline_exceeded", err, nil)
+	case errors.Is(err, context.Canceled):
+		return withReason(codes.Canceled, "canceled", err, nil)
+	default:
+		return withReason(codes.Internal, "internal_error", err, nil)
+	}
+}
+
+// brokerStatusCode maps a Broker HTTP status. The caller can act on 4xx
+// answers (unknown connection, revoked consent), so they are reported as
+// such; 5xx answers mean the Broker cannot serve the call right now.
+func brokerStatusCode(httpStatus int) codes.Code {
+	switch {
+	case httpStatus == http.StatusNotFound:
+		return codes.NotFound
+	case httpStatus == http.StatusUnauthorized || httpStatus == http.StatusForbidden:
+		return codes.PermissionDenied
+	case httpStatus == http.StatusTooManyRequests:
+		return codes.ResourceExhausted
+	case httpStatus >= 400 && httpStatus < 500:
+		return codes.FailedPrecondition
+	default:
+		return codes.Unavailable
+	}
+}
+
+// validationStatus reports a ValidationError as InvalidArgument with one
+// BadRequest field violation per invalid field, next to the usual
+// ErrorInfo.
+func validationStatus(ve *usecase.ValidationError) error {
+	names := make([]string, 0, len(ve.Fields))
+	for name := range ve.Fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	violations := make([]*errdetails.BadRequest_FieldViolation, len(names))
+	for i, name := range names {
+		violations[i] = &errdetails.BadRequest_FieldViolation{Field: name, Description: ve.Fields[name]}
+	}
+	st := status.New(codes.InvalidArgument, ve.Error())
+	if detailed, err := st.WithDetails(
+		&errdetails.ErrorInfo{Reason: "validation_failed", Domain: errorDomain},
+		&errdetails.BadRequest{FieldViolations: violations},
+	); err == nil {
+		st = detailed
+	}
+	return st.Err()
+}
+
+func withReason(code codes.Code, reason string, err error, metadata map[string]string) error {
+	st := status.New(code, redact.Error(err))
+	if detailed, derr := st.WithDetails(&errdetails.ErrorInfo{Reason: reason, Domain: errorDomain, Metadata: metadata}); derr == nil {
+		st = detailed
+	}
+	return st.Err()
+}
+
+// problemErrorHandler answers failed calls on the HTTP port with the
+// problem details body of the REST API: the ErrorInfo reason is the code and
+// BadRequest field violations become the fields member.
+func problemErrorHandler(ctx context.Context, _ *runtime.ServeMux, _ runtime.Marshaler, w http.ResponseWriter, r *http.Request, err error) {
+	httpStatus := 0
+	var custom *runtime.HTTPStatusError
+	if errors.As(err, &custom) {
+		httpStatus, err = custom.HTTPStatus, custom.Err
+	}
+	st := status.Convert(err)
+	if httpStatus == 0 {
+		httpStatus = runtime.HTTPStatusFromCode(st.Code())
+	}
+
+	code := snakeCase(st.Code().String())
+	var fields map[string]string
+	for _, d := range st.Details() {
+		switch d := d.(type) {
+		case *errdetails.ErrorInfo:
+			code = d.Reason
+		case *errdetails.BadRequest:
+			fields = make(map[string]string, len(d.FieldViolations))
+			for _, v := range d.FieldViolations {
+				fields[v.Field] = v.Description
+			}
+		}
+	}
+
+	if md, ok := runtime.ServerMetadataFromContext(ctx); ok {
+		for k, vs := range md.HeaderMD {
+			for _, v := range vs {
+				w.Header().Add(runtime.MetadataHeaderPrefix+k, v)
+			}
+		}
+		if id := md.HeaderMD.Get(requestIDKey); len(id) > 0 {
+			w.Header().Set(problem.RequestIDHeader, id[0])
+		}
+	}
+	if st.Code() == codes.Unauthenticated {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+	}
+
+	p := problem.New(httpStatus, code, st.Message())
+	if fields != nil {
+		p.With("fields", fields)
+	}
+	problem.Write(w, p)
+}
+
+// snakeCase turns a code name such as InvalidArgument into invalid_argument.
+func snakeCase(name string) string {
+	var b strings.Builder
+	for i, r := range name {
+		if unicode.IsUpper(r) {
+			if i > 0 {
+				b.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,126 @@
+package grpcsrv
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-gateway/pkg/usecase"
+)
+
+func TestStatusFromError(t *testing.T) {
+	cases := []struct {
+		err        error
+		wantCode   codes.Code
+		wantReason string
+	}{
+		{fmt.Errorf("%w: acme", usecase.ErrProviderNotFound), codes.NotFound, "provider_not_found"},
+		{fmt.Errorf("%w: provider_id or provider_name is required", usecase.ErrMissingFields), codes.InvalidArgument, "missing_fields"},
+		{&usecase.ValidationError{Fields: map[string]string{"return_url": "must be https"}}, codes.InvalidArgument, "validation_failed"},
+		{fmt.Errorf("%w: bad signature", usecase.ErrInvalidState), codes.InvalidArgument, "invalid_state"},
+		{fmt.Errorf("%w: acme", usecase.ErrProviderAmbiguous), codes.FailedPrecondition, "provider_ambiguous"},
+		{&usecase.BrokerStatusError{Status: 404}, codes.NotFound, "broker_error"},
+		{&usecase.BrokerStatusError{Status: 409}, codes.FailedPrecondition, "broker_error"},
+		{&usecase.BrokerStatusError{Status: 423}, codes.FailedPrecondition, "connection_suspended"},
+		{&usecase.BrokerStatusError{Status: 503}, codes.Unavailable, "broker_error"},
+		{fmt.Errorf("%w: dial tcp: refused", usecase.ErrBrokerUnavailable), codes.Unavailable, "broker_unavailable"},
+		{context.DeadlineExceeded, codes.DeadlineExceeded, "deadline_exceeded"},
+		{errors.New("boom"), codes.Internal, "internal_error"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.err.Error(), func(t *testing.T) {
+			st := status.Convert(statusFromError(tc.err))
+			if st.Code() != tc.wantCode {
+				t.Errorf("expected %v, got %v", tc.wantCode, st.Code())
+			}
+			var info *errdetails.ErrorInfo
+			for _, d := range st.Details() {
+				if i, ok := d.(*errdetails.ErrorInfo); ok {
+					info = i
+				}
+			}
+			if info == nil || info.Reason != tc.wantReason || info.Domain != errorDomain {
+				t.Fatalf("expected ErrorInfo reason %q, got %+v", tc.wantReason, info)
+			}
+		})
+	}
+
+	st := status.Convert(statusFromError(&usecase.BrokerStatusError{Status: 409}))
+	if info := st.Details()[0].(*errdetails.ErrorInfo); info.Metadata["broker_status"] != "409" {
+		t.Errorf("expected the broker status in metadata, got %v", info.Metadata)
+	}
+	already := status.Error(codes.InvalidArgument, "missing connection_id")
+	if statusFromError(already) != already {
+		t.Error("an existing status should pass through")
+	}
+}
+
+func TestStatusFromError_FieldViolations(t *testing.T) {
+	err := &usecase.ValidationError{Fields: map[string]string{
+		"return_url": "must be https",
+		"scopes[1]":  "must not be empty",
+	}}
+	st := status.Convert(statusFromError(err))
+	var br *errdetails.BadRequest
+	for _, d := range st.Details() {
+		if b, ok := d.(*errdetails.BadRequest); ok {
+			br = b
+		}
+	}
+	if br == nil || len(br.FieldViolations) != 2 {
+		t.Fatalf("expected two field violations, got %+v", br)
+	}
+	if v := br.FieldViolations[0]; v.Field != "return_url" || v.Description != "must be https" {
+		t.Errorf("unexpected first violation %+v", v)
+	}
+	if v := br.FieldViolations[1]; v.Field != "scopes[1]" || v.Description != "must not be empty" {
+		t.Errorf("unexpected second violation %+v", v)
+	}
+}
+
+func TestProblemErrorHandler(t *testing.T) {
+	ctx := runtime.NewServerMetadataContext(context.Background(), runtime.ServerMetadata{HeaderMD: metadata.Pairs(requestIDKey, "req-1")})
+	err := statusFromError(&usecase.ValidationError{Fields: map[string]string{"return_url": "must be https"}})
+	w := httptest.NewRecorder()
+	problemErrorHandler(ctx, nil, nil, w, httptest.NewRequest("POST", "/v1/request-connection", nil), err)
+
+	if w.Code != http.StatusBadRequest || w.Header().Get("Content-Type") != "application/problem+json" {
+		t.Fatalf("unexpected response %d %v", w.Code, w.Header())
+	}
+	if w.Header().Get("X-Request-ID") != "req-1" || w.Header().Get("Grpc-Metadata-X-Request-Id") != "req-1" {
+		t.Errorf("expected the request ID headers, got %v", w.Header())
+	}
+	var body struct {
+		Code      string            `json:"code"`
+		Status    int               `json:"status"`
+		RequestID string            `json:"request_id"`
+		Fields    map[string]string `json:"fields"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Code != "validation_failed" || body.Status != 400 || body.RequestID != "req-1" || body.Fields["return_url"] != "must be https" {
+		t.Errorf("unexpected body %+v", body)
+	}
+
+	w = httptest.NewRecorder()
+	problemErrorHandler(context.Background(), nil, nil, w, httptest.NewRequest("GET", "/", nil), status.Error(codes.Unauthenticated, "no credentials"))
+	if w.Code != http.StatusUnauthorized || !json.Valid(w.Body.Bytes()) {
+		t.Fatalf("unexpected response %d %s", w.Code, w.Body.String())
+	}
+	var plain map[string]any
+	_ = json.Unmarshal(w.Body.Bytes(), &plain)
+	if plain["code"] != "unauthenticated" {
+		t.Errorf("expected the code name without ErrorInfo, got %v", plain["code"])
+	}
+}
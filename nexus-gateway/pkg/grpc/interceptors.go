@@ -0,0 +1,228 @@
+package grpcsrv
+
+import (
+	"context"
+	"log"
+	"net"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	nexuspb "github.com/Prescott-Data/nexus-framework/nexus-gateway/gen/go/api/proto/nexus/v1"
+	"github.com/Prescott-Data/nexus-framework/nexus-gateway/pkg/auth"
+	"github.com/Prescott-Data/nexus-framework/nexus-gateway/pkg/config"
+	"github.com/Prescott-Data/nexus-framework/nexus-gateway/pkg/logging"
+)
+
+// requestIDKey is the metadata key carrying the request ID. grpc-gateway
+// forwards it from the HTTP Grpc-Metadata-X-Request-ID header and returns
+// it in the response headers.
+const requestIDKey = "x-request-id"
+
+var (
+	grpcRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "grpc_server_requests_total",
+		Help: "gRPC requests handled by the gateway by method and status code",
+	}, []string{"method", "code"})
+	grpcDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "grpc_server_request_duration_seconds",
+		Help:    "Duration of gRPC requests handled by the gateway",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method"})
+	grpcPanics = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "grpc_server_panics_total",
+		Help: "gRPC handler panics recovered by the gateway",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(grpcRequests, grpcDuration, grpcPanics)
+}
+
+// unaryInterceptors returns the standard chain, outermost first: request
+// ID, logging, metrics, panic recovery, authentication, the route timeout
+// and rate limiting,
+// then opts.UnaryInterceptors, then mapping of usecase errors to status
+// codes. Recovery sits inside logging and metrics so a panic is reported as
+// the Internal error the client sees.
+func unaryInterceptors(opts Options) []grpc.UnaryServerInterceptor {
+	chain := []grpc.UnaryServerInterceptor{
+		requestIDInterceptor,
+		loggingInterceptor,
+		metricsInterceptor,
+		recoveryInterceptor,
+		auth.UnaryServerInterceptor(opts.Authenticator, opts.AuthExemptMethods),
+		timeoutInterceptor(opts.Timeouts),
+	}
+	if opts.RateLimit > 0 {
+		chain = append(chain, newRateLimiter(opts.RateLimit, opts.RateBurst).intercept)
+	}
+	chain = append(chain, opts.UnaryInterceptors...)
+	return append(chain, usecaseErrorInterceptor)
+}
+
+// methodRoutes names the route of each RPC for ROUTE_TIMEOUTS.
+var methodRoutes = map[string]string{
+	nexuspb.NexusService_RequestConnection_FullMethodName: config.RouteRequestConnection,
+	nexuspb.NexusService_CheckConnection_FullMethodName:   config.RouteCheckConnection,
+	nexuspb.NexusService_GetToken_FullMethodName:          config.RouteToken,
+	nexuspb.NexusService_RefreshConnection_FullMethodName: config.RouteRefresh,
+}
+
+// timeoutInterceptor bounds each RPC by the timeout configured for its
+// route. A shorter deadline set by the client is kept. Either way the
+// deadline reaches the Broker calls made for the RPC.
+func timeoutInterceptor(t config.Timeouts) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		d := t.For(methodRoutes[info.FullMethod])
+		if d <= 0 {
+			return handler(ctx, req)
+		}
+		ctx, cancel := context.WithTimeout(ctx, d)
+		defer cancel()
+		return handler(ctx, req)
+	}
+}
+
+// requestIDInterceptor takes the request ID from incoming metadata, or
+// generates one, stores it where logging.Info finds it and echoes it in the
+// response headers.
+func requestIDInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	var id string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if v := md.Get(requestIDKey); len(v) > 0 {
+			id = v[0]
+		}
+	}
+	if id == "" {
+		id = uuid.NewString()
+	}
+	_ = grpc.SetHeader(ctx, metadata.Pairs(requestIDKey, id))
+	return handler(context.WithValue(ctx, middleware.RequestIDKey, id), req)
+}
+
+func loggingInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	fields := map[string]any{
+		"method":      info.FullMethod,
+		"code":        status.Code(err).String(),
+		"duration_ms": time.Since(start).Milliseconds(),
+	}
+	if c, ok := auth.CallerFrom(ctx); ok {
+		fields["caller"] = c.ID
+	}
+	if err != nil {
+		fields["error"] = status.Convert(err).Message()
+		logging.Error(ctx, "grpc request", fields)
+	} else {
+		logging.Info(ctx, "grpc request", fields)
+	}
+	return resp, err
+}
+
+func metricsInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	grpcDuration.WithLabelValues(info.FullMethod).Observe(time.Since(start).Seconds())
+	grpcRequests.WithLabelValues(info.FullMethod, status.Code(err).String()).Inc()
+	return resp, err
+}
+
+func recoveryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			grpcPanics.Inc()
+			log.Printf("grpc: panic in %s: %v\n%s", info.FullMethod, p, debug.Stack())
+			resp, err = nil, status.Error(codes.Internal, "internal error")
+		}
+	}()
+	return handler(ctx, req)
+}
+
+// maxRateBuckets bounds the per-caller buckets kept; beyond it, buckets
+// idle long enough to have refilled are dropped.
+const maxRateBuckets = 10000
+
+// rateLimiter is a token bucket per caller: the authenticated caller ID, or
+// the peer IP for unauthenticated calls (which, for calls proxied by the
+// HTTP gateway, is the gateway itself).
+type rateLimiter struct {
+	rate  float64
+	burst float64
+	now   func() time.Time
+
+	mu      sync.Mutex
+	buckets map[string]*rateBucket
+}
+
+type rateBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &rateLimiter{rate: rate, burst: float64(burst), now: time.Now, buckets: map[string]*rateBucket{}}
+}
+
+func (l *rateLimiter) allow(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	b, ok := l.buckets[key]
+	if !ok {
+		if len(l.buckets) >= maxRateBuckets {
+			l.sweep(now)
+		}
+		b = &rateBucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens += now.Sub(b.last).Seconds() * l.rate
+	if b.tokens > l.burst {
+		b.tokens = l.burst
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+func (l *rateLimiter) sweep(now time.Time) {
+	full := time.Duration(l.burst / l.rate * float64(time.Second))
+	for k, b := range l.buckets {
+		if now.Sub(b.last) >= full {
+			delete(l.buckets, k)
+		}
+	}
+}
+
+func (l *rateLimiter) intercept(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	key := "anonymous"
+	if c, ok := auth.CallerFrom(ctx); ok {
+		key = "caller:" + c.ID
+	} else if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		host, _, err := net.SplitHostPort(p.Addr.String())
+		if err != nil {
+			host = p.Addr.String()
+		}
+		key = "peer:" + host
+	}
+	if !l.allow(key) {
+		return nil, status.Error(codes.ResourceExhausted, "rate limit exceeded")
+	}
+	return handler(ctx, req)
+}
@@ -0,0 +1,111 @@
+package grpcsrv
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-gateway/pkg/auth"
+	"github.com/Prescott-Data/nexus-framework/nexus-gateway/pkg/config"
+)
+
+var testInfo = &grpc.UnaryServerInfo{FullMethod: "/nexus.v1.NexusService/GetToken"}
+
+func TestRecoveryInterceptor(t *testing.T) {
+	_, err := recoveryInterceptor(context.Background(), nil, testInfo, func(ctx context.Context, req interface{}) (interface{}, error) {
+		panic("boom")
+	})
+	if status.Code(err) != codes.Internal {
+		t.Fatalf("expected Internal, got %v", err)
+	}
+}
+
+func TestRequestIDInterceptor(t *testing.T) {
+	var seen string
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		seen = middleware.GetReqID(ctx)
+		return nil, nil
+	}
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(requestIDKey, "req-1"))
+	if _, err := requestIDInterceptor(ctx, nil, testInfo, handler); err != nil {
+		t.Fatal(err)
+	}
+	if seen != "req-1" {
+		t.Errorf("expected the incoming request ID, got %q", seen)
+	}
+
+	if _, err := requestIDInterceptor(context.Background(), nil, testInfo, handler); err != nil {
+		t.Fatal(err)
+	}
+	if seen == "" || seen == "req-1" {
+		t.Errorf("expected a generated request ID, got %q", seen)
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Unix(0, 0)
+	l := newRateLimiter(1, 2)
+	l.now = func() time.Time { return now }
+	ok := func(ctx context.Context) bool {
+		_, err := l.intercept(ctx, nil, testInfo, func(ctx context.Context, req interface{}) (interface{}, error) { return nil, nil })
+		if err != nil && status.Code(err) != codes.ResourceExhausted {
+			t.Fatalf("unexpected error %v", err)
+		}
+		return err == nil
+	}
+	billing := auth.WithCaller(context.Background(), &auth.Caller{ID: "billing"})
+	reports := auth.WithCaller(context.Background(), &auth.Caller{ID: "reports"})
+
+	if !ok(billing) || !ok(billing) {
+		t.Fatal("the burst should be allowed")
+	}
+	if ok(billing) {
+		t.Error("expected the third call to be limited")
+	}
+	if !ok(reports) {
+		t.Error("callers are limited independently")
+	}
+	now = now.Add(time.Second)
+	if !ok(billing) {
+		t.Error("expected a token after one second")
+	}
+}
+
+func TestTimeoutInterceptor(t *testing.T) {
+	intercept := timeoutInterceptor(config.Timeouts{
+		Default: time.Minute,
+		Routes:  map[string]time.Duration{config.RouteToken: time.Second},
+	})
+	remaining := func(ctx context.Context, info *grpc.UnaryServerInfo) time.Duration {
+		var d time.Duration
+		intercept(ctx, nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+			deadline, ok := ctx.Deadline()
+			if !ok {
+				t.Fatalf("%s: no deadline", info.FullMethod)
+			}
+			d = time.Until(deadline)
+			return nil, nil
+		})
+		return d
+	}
+
+	if d := remaining(context.Background(), testInfo); d > time.Second {
+		t.Errorf("GetToken deadline %s away, want the token route's 1s", d)
+	}
+	serverInfo := &grpc.UnaryServerInfo{FullMethod: "/nexus.v1.NexusService/ServerInfo"}
+	if d := remaining(context.Background(), serverInfo); d < 50*time.Second {
+		t.Errorf("ServerInfo deadline %s away, want the 1m default", d)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if d := remaining(ctx, testInfo); d > 100*time.Millisecond {
+		t.Errorf("GetToken deadline %s away, want the client's shorter deadline kept", d)
+	}
+}
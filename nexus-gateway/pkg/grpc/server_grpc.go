@@ -2,19 +2,26 @@ package grpcsrv
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"log"
 	"net"
 	"net/http"
+	"strings"
 	"time"
 
+	"github.com/Prescott-Data/nexus-framework/nexus-common/health"
 	nexuspb "github.com/Prescott-Data/nexus-framework/nexus-gateway/gen/go/api/proto/nexus/v1"
+	"github.com/Prescott-Data/nexus-framework/nexus-gateway/pkg/auth"
 	"github.com/Prescott-Data/nexus-framework/nexus-gateway/pkg/config"
+	"github.com/Prescott-Data/nexus-framework/nexus-gateway/pkg/server"
 	"github.com/Prescott-Data/nexus-framework/nexus-gateway/pkg/usecase"
 
+	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/cors"
 	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
@@ -24,6 +31,7 @@ import (
 
 type Service struct {
 	usecaseHandler *usecase.Handler
+	build          usecase.BuildInfo
 	nexuspb.UnimplementedNexusServiceServer
 }
 
@@ -34,21 +42,7 @@ func NewService(handler *usecase.Handler) *Service {
 func usecaseErrorInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
 	resp, err := handler(ctx, req)
 	if err != nil {
-		switch {
-		case errors.Is(err, usecase.ErrProviderNotFound):
-			return nil, status.Errorf(codes.NotFound, "%v", err)
-		case errors.Is(err, usecase.ErrInvalidState):
-			return nil, status.Errorf(codes.InvalidArgument, "%v", err)
-		case errors.Is(err, usecase.ErrProviderAmbiguous):
-			return nil, status.Errorf(codes.FailedPrecondition, "%v", err)
-		case errors.Is(err, usecase.ErrBrokerUnavailable):
-			return nil, status.Errorf(codes.Unavailable, "%v", err)
-		default:
-			if _, ok := status.FromError(err); ok {
-				return nil, err
-			}
-			return nil, status.Errorf(codes.Internal, "%v", err)
-		}
+		return nil, statusFromError(err)
 	}
 	return resp, nil
 }
@@ -65,6 +59,8 @@ func (s *Service) RequestConnection(ctx context.Context, req *nexuspb.RequestCon
 		Scopes:       req.GetScopes(),
 		ReturnURL:    req.GetReturnUrl(),
 		Action:       req.GetAction(),
+		Tenant:       req.GetTenant(),
+		Subject:      req.GetSubject(),
 	})
 	if err != nil {
 		return nil, err
@@ -84,11 +80,11 @@ func (s *Service) CheckConnection(ctx context.Context, req *nexuspb.CheckConnect
 	if req == nil || req.GetConnectionId() == "" {
 		return nil, status.Error(codes.InvalidArgument, "missing connection_id")
 	}
-	statusStr, err := s.usecaseHandler.CheckConnectionCore(ctx, req.GetConnectionId())
+	st, err := s.usecaseHandler.CheckConnectionCore(ctx, req.GetConnectionId())
 	if err != nil {
 		return nil, err
 	}
-	return &nexuspb.CheckConnectionResponse{Status: statusStr}, nil
+	return &nexuspb.CheckConnectionResponse{Status: st.Status, Reason: st.Reason, ErrorCode: st.ErrorCode}, nil
 }
 
 // GetToken implements NexusServiceServer.GetToken.
@@ -97,8 +93,10 @@ func (s *Service) GetToken(ctx context.Context, req *nexuspb.GetTokenRequest) (*
 		return nil, status.Error(codes.InvalidArgument, "missing connection_id")
 	}
 	data, code, err := s.usecaseHandler.GetTokenCore(ctx, req.GetConnectionId())
+	if err == nil && code != http.StatusOK {
+		err = &usecase.BrokerStatusError{Status: code}
+	}
 	if err != nil {
-		_ = code // keep the HTTP status for potential mapping if needed later
 		return nil, err
 	}
 	st, err := structpb.NewStruct(data)
@@ -114,8 +112,10 @@ func (s *Service) RefreshConnection(ctx context.Context, req *nexuspb.RefreshCon
 		return nil, status.Error(codes.InvalidArgument, "missing connection_id")
 	}
 	data, code, err := s.usecaseHandler.RefreshConnectionCore(ctx, req.GetConnectionId())
+	if err == nil && code != http.StatusOK {
+		err = &usecase.BrokerStatusError{Status: code}
+	}
 	if err != nil {
-		_ = code // unused
 		return nil, err
 	}
 	st, err := structpb.NewStruct(data)
@@ -125,6 +125,23 @@ func (s *Service) RefreshConnection(ctx context.Context, req *nexuspb.RefreshCon
 	return &nexuspb.RefreshConnectionResponse{Token: st}, nil
 }
 
+// ServerInfo implements NexusServiceServer.ServerInfo. An unreachable
+// Broker is reported in BrokerError rather than failing the call.
+func (s *Service) ServerInfo(ctx context.Context, _ *nexuspb.ServerInfoRequest) (*nexuspb.ServerInfoResponse, error) {
+	info := s.usecaseHandler.ServerInfo(ctx, s.build)
+	resp := &nexuspb.ServerInfoResponse{
+		Version:     info.Version,
+		GitCommit:   info.GitCommit,
+		BuildDate:   info.BuildDate,
+		Features:    info.Features,
+		BrokerError: info.BrokerError,
+	}
+	if b := info.Broker; b != nil {
+		resp.Broker = &nexuspb.BuildInfo{Version: b.Version, GitCommit: b.GitCommit, BuildDate: b.BuildDate, Features: b.Features}
+	}
+	return resp, nil
+}
+
 type Server struct {
 	grpcAddress string
 	httpAddress string
@@ -132,12 +149,55 @@ type Server struct {
 	httpServer  *http.Server
 	listener    net.Listener
 	service     *Service
+
+	// grpcHTTPServer serves the gRPC port through net/http when it
+	// terminates TLS or is shared with the REST gateway.
+	grpcHTTPServer *http.Server
+	tls            config.TLSConfig
+	singlePort     bool
+
+	allowedOrigins     []string
+	healthCheckTimeout time.Duration
+	writeTimeout       time.Duration
+	adminAPIKey        string
+	enableDebug        bool
 }
 
 type Options struct {
 	GRPCAddress string
 	HTTPAddress string
 	Handler     *usecase.Handler
+
+	// AllowedOrigins configures CORS on the HTTP gateway.
+	AllowedOrigins []string
+	// HealthCheckTimeout bounds each /readyz dependency check.
+	HealthCheckTimeout time.Duration
+	// AdminAPIKey guards /admin/reload and, when EnableDebugEndpoints is
+	// true, /debug/pprof and /admin/runtime.
+	AdminAPIKey          string
+	EnableDebugEndpoints bool
+	// Build is returned by the ServerInfo RPC and GET /version.
+	Build usecase.BuildInfo
+	// Authenticator, when set, must accept the caller of every RPC other
+	// than the AuthExemptMethods (full method names).
+	Authenticator     auth.Authenticator
+	AuthExemptMethods []string
+	// RateLimit, when positive, allows each caller RateLimit requests per
+	// second with bursts of RateBurst; excess calls get ResourceExhausted.
+	RateLimit float64
+	RateBurst int
+	// Timeouts bounds each RPC by its route's timeout; the zero value
+	// leaves RPCs bounded only by the client's deadline.
+	Timeouts config.Timeouts
+	// TLS selects the listeners that terminate TLS. With SinglePort the
+	// REST gateway is served on GRPCAddress alongside gRPC, chosen per
+	// request, and HTTPAddress is not used.
+	TLS        config.TLSConfig
+	SinglePort bool
+	// UnaryInterceptors run after the standard chain (request ID,
+	// logging, metrics, recovery, auth, rate limiting) and before usecase
+	// errors are mapped to status codes.
+	UnaryInterceptors []grpc.UnaryServerInterceptor
 }
 
 func NewServer(opts Options) (*Server, error) {
@@ -151,58 +211,144 @@ func NewServer(opts Options) (*Server, error) {
 		opts.HTTPAddress = ":8090"
 	}
 	service := NewService(opts.Handler)
-	grpcSrv := grpc.NewServer(grpc.UnaryInterceptor(usecaseErrorInterceptor))
+	service.build = opts.Build
+	grpcSrv := grpc.NewServer(grpc.ChainUnaryInterceptor(unaryInterceptors(opts)...))
 	nexuspb.RegisterNexusServiceServer(grpcSrv, service)
 	return &Server{
 		grpcAddress: opts.GRPCAddress,
 		httpAddress: opts.HTTPAddress,
 		grpcServer:  grpcSrv,
 		service:     service,
+
+		allowedOrigins:     opts.AllowedOrigins,
+		healthCheckTimeout: opts.HealthCheckTimeout,
+		writeTimeout:       max(30*time.Second, opts.Timeouts.Max()+5*time.Second),
+		adminAPIKey:        opts.AdminAPIKey,
+		enableDebug:        opts.EnableDebugEndpoints,
+		tls:                opts.TLS,
+		singlePort:         opts.SinglePort,
 	}, nil
 }
 
+// Start opens the gRPC and HTTP gateway listeners. A gRPC port that
+// terminates TLS or is shared with the REST gateway is served through
+// net/http, which negotiates HTTP/2 by ALPN (or h2c without TLS); the
+// grpc-gateway then reaches the gRPC server over a plain loopback listener.
 func (s *Server) Start(ctx context.Context) error {
+	grpcTLS := s.tls.Enabled(config.ListenerGRPC)
+	httpTLS := !s.singlePort && s.tls.Enabled(config.ListenerHTTP)
+	var grpcTLSConfig, httpTLSConfig *tls.Config
+	var err error
+	if grpcTLS {
+		if grpcTLSConfig, err = server.NewTLSConfig(s.tls); err != nil {
+			return err
+		}
+	}
+	if httpTLS {
+		if httpTLSConfig, err = server.NewTLSConfig(s.tls); err != nil {
+			return err
+		}
+	}
+
 	l, err := net.Listen("tcp", s.grpcAddress)
 	if err != nil {
 		return fmt.Errorf("listen gRPC: %w", err)
 	}
 	s.listener = l
 
+	gatewayTarget := s.grpcAddress
+	native := l
+	if grpcTLS || s.singlePort {
+		if native, err = net.Listen("tcp", "127.0.0.1:0"); err != nil {
+			return fmt.Errorf("listen gRPC loopback: %w", err)
+		}
+		gatewayTarget = native.Addr().String()
+	}
 	go func() {
-		log.Printf("gRPC listening on %s", s.grpcAddress)
-		if err := s.grpcServer.Serve(l); err != nil && !errors.Is(err, grpc.ErrServerStopped) {
+		log.Printf("gRPC listening on %s", native.Addr())
+		if err := s.grpcServer.Serve(native); err != nil && !errors.Is(err, grpc.ErrServerStopped) {
 			log.Printf("gRPC serve error: %v", err)
 		}
 	}()
 
-	gwMux := runtime.NewServeMux()
+	gwMux := runtime.NewServeMux(runtime.WithIncomingHeaderMatcher(incomingHeaderMatcher), runtime.WithErrorHandler(problemErrorHandler))
 	dialOpts := []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}
-	if err := nexuspb.RegisterNexusServiceHandlerFromEndpoint(ctx, gwMux, s.grpcAddress, dialOpts); err != nil {
+	if err := nexuspb.RegisterNexusServiceHandlerFromEndpoint(ctx, gwMux, gatewayTarget, dialOpts); err != nil {
 		return fmt.Errorf("register gateway: %w", err)
 	}
 
 	// CORS Setup
 	corsMiddleware := cors.Handler(cors.Options{
-		AllowedOrigins:   config.GetAllowedOrigins(),
+		AllowedOrigins:   s.allowedOrigins,
 		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token", "Grpc-Metadata-X-Request-ID"},
+		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token", "X-API-Key", "Grpc-Metadata-X-Request-ID"},
 		ExposedHeaders:   []string{"Link", "Grpc-Metadata-X-Request-ID"},
 		AllowCredentials: true,
 		MaxAge:           300,
 	})
 
+	// Probes are served alongside the grpc-gateway routes on the HTTP port.
+	rootMux := http.NewServeMux()
+	rootMux.HandleFunc("/healthz", health.LivenessHandler)
+	rootMux.Handle("/metrics", promhttp.Handler())
+	rootMux.Handle("/readyz", health.ReadinessHandler(s.healthCheckTimeout, server.BrokerHealthCheck(s.service.usecaseHandler.PingBroker)))
+	if s.adminAPIKey != "" {
+		adminMux := chi.NewRouter()
+		server.RegisterAdminRoutes(adminMux, s.adminAPIKey, s.enableDebug, s.Reload)
+		rootMux.Handle("/debug/", adminMux)
+		rootMux.Handle("/admin/", adminMux)
+	}
+	rootMux.Handle("/", gwMux)
+	restHandler := corsMiddleware(server.MetricsMiddleware(rootMux))
+
+	if grpcTLS || s.singlePort {
+		var rest http.Handler
+		if s.singlePort {
+			rest = restHandler
+		}
+		s.grpcHTTPServer = &http.Server{
+			Handler:           grpcOrREST(s.grpcServer, rest),
+			TLSConfig:         grpcTLSConfig,
+			Protocols:         server.HTTPProtocols(),
+			ReadHeaderTimeout: 5 * time.Second,
+		}
+		go func() {
+			log.Printf("gRPC (TLS=%v, REST on same port=%v) listening on %s", grpcTLS, s.singlePort, s.grpcAddress)
+			var err error
+			if grpcTLS {
+				err = s.grpcHTTPServer.ServeTLS(l, "", "")
+			} else {
+				err = s.grpcHTTPServer.Serve(l)
+			}
+			if err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Printf("gRPC serve error: %v", err)
+			}
+		}()
+	}
+	if s.singlePort {
+		return nil
+	}
+
 	httpSrv := &http.Server{
 		Addr:              s.httpAddress,
-		Handler:           corsMiddleware(gwMux),
+		Handler:           restHandler,
+		TLSConfig:         httpTLSConfig,
+		Protocols:         server.HTTPProtocols(),
 		ReadHeaderTimeout: 5 * time.Second,
 		ReadTimeout:       10 * time.Second,
-		WriteTimeout:      30 * time.Second,
+		WriteTimeout:      s.writeTimeout,
 	}
 	s.httpServer = httpSrv
 
 	go func() {
-		log.Printf("HTTP gateway listening on %s", s.httpAddress)
-		if err := httpSrv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Printf("HTTP gateway (TLS=%v) listening on %s", httpTLS, s.httpAddress)
+		var err error
+		if httpTLS {
+			err = httpSrv.ListenAndServeTLS("", "")
+		} else {
+			err = httpSrv.ListenAndServe()
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
 			log.Printf("gateway serve error: %v", err)
 		}
 	}()
@@ -210,7 +356,50 @@ func (s *Server) Start(ctx context.Context) error {
 	return nil
 }
 
+// grpcOrREST sends gRPC requests (HTTP/2 with an application/grpc content
+// type) to grpcSrv and everything else to rest, or answers 404 when rest is
+// nil.
+func grpcOrREST(grpcSrv *grpc.Server, rest http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor == 2 && strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+			grpcSrv.ServeHTTP(w, r)
+			return
+		}
+		if rest == nil {
+			http.NotFound(w, r)
+			return
+		}
+		rest.ServeHTTP(w, r)
+	})
+}
+
+// incomingHeaderMatcher forwards X-API-Key (caller credentials) and
+// X-Request-ID to the gRPC server, alongside the headers grpc-gateway
+// forwards by default (Authorization among them).
+func incomingHeaderMatcher(key string) (string, bool) {
+	switch http.CanonicalHeaderKey(key) {
+	case "X-Api-Key":
+		return "x-api-key", true
+	case "X-Request-Id":
+		return requestIDKey, true
+	}
+	return runtime.DefaultHeaderMatcher(key)
+}
+
+// Reload invalidates the provider name -> ID cache and the provider
+// metadata cache. It backs POST /admin/reload and SIGHUP.
+func (s *Server) Reload(ctx context.Context) (map[string]int, error) {
+	h := s.service.usecaseHandler
+	return map[string]int{
+		"provider_cache": h.InvalidateProviderCache(),
+		"metadata_cache": h.InvalidateMetadataCache(),
+	}, nil
+}
+
 func (s *Server) Shutdown(ctx context.Context) error {
+	if s.grpcHTTPServer != nil {
+		_ = s.grpcHTTPServer.Shutdown(ctx)
+	}
 	s.grpcServer.GracefulStop()
 	if s.httpServer != nil {
 		return s.httpServer.Shutdown(ctx)
@@ -0,0 +1,57 @@
+package grpcsrv
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+
+	nexuspb "github.com/Prescott-Data/nexus-framework/nexus-gateway/gen/go/api/proto/nexus/v1"
+	"github.com/Prescott-Data/nexus-framework/nexus-gateway/pkg/usecase"
+)
+
+func TestServer_SinglePortServesGRPCAndREST(t *testing.T) {
+	srv, err := NewServer(Options{
+		GRPCAddress: "127.0.0.1:0",
+		Handler:     usecase.NewHandler("http://127.0.0.1:1", nil, &http.Client{Timeout: time.Second}),
+		SinglePort:  true,
+		Build:       usecase.BuildInfo{Version: "test"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := srv.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Shutdown(context.Background())
+	addr := srv.listener.Addr().String()
+
+	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	callCtx, callCancel := context.WithTimeout(ctx, 5*time.Second)
+	defer callCancel()
+	info, err := nexuspb.NewNexusServiceClient(conn).ServerInfo(callCtx, &nexuspb.ServerInfoRequest{})
+	if err != nil {
+		t.Fatalf("gRPC over the shared port: %v", err)
+	}
+	if info.GetVersion() != "test" {
+		t.Errorf("unexpected version %q", info.GetVersion())
+	}
+
+	resp, err := http.Get("http://" + addr + "/healthz")
+	if err != nil {
+		t.Fatalf("REST over the shared port: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected 200 from /healthz, got %d", resp.StatusCode)
+	}
+}
@@ -8,6 +8,8 @@ import (
 	"strings"
 
 	"github.com/go-chi/chi/v5/middleware"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-sdk/redact"
 )
 
 var sensitiveKeys = map[string]struct{}{
@@ -28,11 +30,18 @@ func RedactValue(key string, value any) any {
 	return value
 }
 
-// RedactMap returns a shallow redacted copy of the map.
+// RedactMap returns a shallow redacted copy of the map. Values of
+// sensitive keys are replaced outright; other string values are scrubbed of
+// anything that looks like a credential (see redact.String), since error
+// messages may quote provider responses.
 func RedactMap(m map[string]any) map[string]any {
 	out := make(map[string]any, len(m))
 	for k, v := range m {
-		out[k] = RedactValue(k, v)
+		v = RedactValue(k, v)
+		if s, ok := v.(string); ok {
+			v = redact.String(s)
+		}
+		out[k] = v
 	}
 	return out
 }
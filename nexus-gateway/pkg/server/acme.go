@@ -0,0 +1,73 @@
+package server
+
+import (
+	"crypto/tls"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-gateway/pkg/config"
+)
+
+// acmeManagers holds one certificate manager per cache directory, so the
+// listeners of a process share an account, its certificates and the
+// HTTP-01 challenge listener.
+var (
+	acmeMu       sync.Mutex
+	acmeManagers = map[string]*autocert.Manager{}
+)
+
+// acmeManager returns the certificate manager for cfg, creating it and
+// starting its HTTP-01 challenge listener on first use.
+func acmeManager(cfg config.ACMEConfig) *autocert.Manager {
+	acmeMu.Lock()
+	defer acmeMu.Unlock()
+	if m, ok := acmeManagers[cfg.CacheDir]; ok {
+		return m
+	}
+	m := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      autocert.DirCache(cfg.CacheDir),
+		HostPolicy: autocert.HostWhitelist(cfg.Hosts...),
+		Email:      cfg.Email,
+	}
+	if cfg.DirectoryURL != "" {
+		m.Client = &acme.Client{DirectoryURL: cfg.DirectoryURL}
+	}
+	acmeManagers[cfg.CacheDir] = m
+	if cfg.HTTPPort != "" {
+		go serveACMEChallenges(net.JoinHostPort("", cfg.HTTPPort), m)
+	}
+	return m
+}
+
+// serveACMEChallenges answers HTTP-01 challenges on addr and redirects
+// every other request to HTTPS.
+func serveACMEChallenges(addr string, m *autocert.Manager) {
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           m.HTTPHandler(nil),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	log.Printf("acme: serving HTTP-01 challenges on %s", addr)
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Printf("acme: HTTP-01 listener stopped: %v", err)
+	}
+}
+
+// acmeTLSConfig serves certificates issued for cfg.Hosts, answering
+// TLS-ALPN-01 challenges on the TLS listener itself.
+func acmeTLSConfig(cfg config.ACMEConfig) *tls.Config {
+	m := acmeManager(cfg)
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		NextProtos:     []string{"h2", "http/1.1", acme.ALPNProto},
+		GetCertificate: m.GetCertificate,
+	}
+}
@@ -0,0 +1,121 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-common/problem"
+)
+
+// AdminKeyMiddleware guards operator-only routes (diagnostics, reloads) with
+// the X-Admin-Key header. An empty adminKey rejects every request.
+func AdminKeyMiddleware(adminKey string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := strings.TrimSpace(r.Header.Get("X-Admin-Key"))
+			if key == "" {
+				writeAdminError(w, http.StatusUnauthorized, "missing_admin_key", "missing admin key")
+				return
+			}
+			if adminKey == "" || subtle.ConstantTimeCompare([]byte(key), []byte(adminKey)) != 1 {
+				writeAdminError(w, http.StatusForbidden, "invalid_admin_key", "invalid admin key")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func writeAdminError(w http.ResponseWriter, status int, code, message string) {
+	problem.Write(w, problem.New(status, code, message))
+}
+
+// RuntimeStats is the body returned by /admin/runtime.
+type RuntimeStats struct {
+	Goroutines  int       `json:"goroutines"`
+	HeapAlloc   uint64    `json:"heap_alloc_bytes"`
+	HeapInuse   uint64    `json:"heap_inuse_bytes"`
+	HeapObjects uint64    `json:"heap_objects"`
+	Sys         uint64    `json:"sys_bytes"`
+	NumGC       uint32    `json:"num_gc"`
+	GCPauseMS   float64   `json:"gc_pause_total_ms"`
+	RecentGCMS  []float64 `json:"gc_recent_pauses_ms"`
+	CollectedAt time.Time `json:"collected_at"`
+}
+
+// CollectRuntimeStats snapshots goroutine, heap and GC pause statistics.
+func CollectRuntimeStats() RuntimeStats {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+
+	stats := RuntimeStats{
+		Goroutines:  runtime.NumGoroutine(),
+		HeapAlloc:   m.HeapAlloc,
+		HeapInuse:   m.HeapInuse,
+		HeapObjects: m.HeapObjects,
+		Sys:         m.Sys,
+		NumGC:       m.NumGC,
+		GCPauseMS:   float64(m.PauseTotalNs) / 1e6,
+		CollectedAt: time.Now().UTC(),
+	}
+
+	// PauseNs is a circular buffer; walk back from the most recent GC.
+	n := int(m.NumGC)
+	if n > 10 {
+		n = 10
+	}
+	for i := 0; i < n; i++ {
+		idx := (int(m.NumGC) - 1 - i + len(m.PauseNs)) % len(m.PauseNs)
+		stats.RecentGCMS = append(stats.RecentGCMS, float64(m.PauseNs[idx])/1e6)
+	}
+	return stats
+}
+
+// RuntimeStatsHandler serves CollectRuntimeStats as JSON.
+func RuntimeStatsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(CollectRuntimeStats())
+}
+
+// Reloader drops cached provider data so changes made on the broker take
+// effect without a restart. It reports how many entries each cache dropped.
+type Reloader func(ctx context.Context) (map[string]int, error)
+
+// ReloadHandler runs reload for POST /admin/reload.
+func ReloadHandler(reload Reloader) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		invalidated, err := reload(r.Context())
+		if err != nil {
+			writeAdminError(w, http.StatusInternalServerError, "reload_failed", err.Error())
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{"status": "reloaded", "invalidated": invalidated})
+	}
+}
+
+// RegisterAdminRoutes mounts operator routes on r behind the admin key:
+// POST /admin/reload always, plus /debug/pprof and /admin/runtime when debug
+// is true. Nothing is mounted without an admin key. Both the REST server and
+// the gRPC HTTP gateway use it.
+func RegisterAdminRoutes(r chi.Router, adminKey string, debug bool, reload Reloader) {
+	if adminKey == "" {
+		return
+	}
+	r.Group(func(r chi.Router) {
+		r.Use(AdminKeyMiddleware(adminKey))
+		r.Post("/admin/reload", ReloadHandler(reload))
+		if debug {
+			r.Mount("/debug", middleware.Profiler())
+			r.Get("/admin/runtime", RuntimeStatsHandler)
+		}
+	})
+}
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-common/health"
+)
+
+// BrokerHealthCheck returns the standard critical readiness check for the
+// Broker dependency.
+func BrokerHealthCheck(ping func(ctx context.Context) error) health.Check {
+	return health.Check{Name: "broker", Critical: true, Check: ping}
+}
+
+// Component status values in a SystemHealth document.
+const (
+	ComponentOK       = "ok"
+	ComponentDegraded = "degraded"
+	ComponentDown     = "down"
+)
+
+// Component is a named part of the system reported by SystemHealthHandler.
+// Probe fills in Status and any detail; latency and criticality are set by
+// RunSystemHealth.
+type Component struct {
+	Name     string
+	Critical bool
+	Probe    func(ctx context.Context) ComponentStatus
+}
+
+// ComponentStatus is the per-component entry in a SystemHealth document.
+type ComponentStatus struct {
+	Status        string                             `json:"status"`
+	Critical      bool                               `json:"critical"`
+	Version       string                             `json:"version,omitempty"`
+	UptimeSeconds int64                              `json:"uptime_seconds,omitempty"`
+	LatencyMS     int64                              `json:"latency_ms"`
+	Error         string                             `json:"error,omitempty"`
+	Dependencies  map[string]health.DependencyStatus `json:"dependencies,omitempty"`
+}
+
+// SystemHealth is the body returned by /v1/healthz.
+type SystemHealth struct {
+	Status     string                     `json:"status"`
+	CheckedAt  time.Time                  `json:"checked_at"`
+	Components map[string]ComponentStatus `json:"components"`
+}
+
+// SystemHealthHandler probes every component and reports "healthy",
+// "degraded" (a component is degraded, or a non-critical one is down) or
+// "unhealthy" (a critical component is down, answered with 503). Unlike
+// /readyz it describes the whole deployment, for load balancers and
+// operator dashboards.
+func SystemHealthHandler(timeout time.Duration, components ...Component) http.HandlerFunc {
+	if timeout <= 0 {
+		timeout = 2 * time.Second
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		report := RunSystemHealth(r.Context(), timeout, components...)
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		if report.Status == "unhealthy" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		_ = json.NewEncoder(w).Encode(report)
+	}
+}
+
+// RunSystemHealth probes components concurrently and aggregates the result.
+func RunSystemHealth(ctx context.Context, timeout time.Duration, components ...Component) SystemHealth {
+	report := SystemHealth{Status: "healthy", Components: make(map[string]ComponentStatus, len(components))}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, c := range components {
+		wg.Add(1)
+		go func(c Component) {
+			defer wg.Done()
+			cctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+
+			start := time.Now()
+			cs := c.Probe(cctx)
+			cs.Critical = c.Critical
+			cs.LatencyMS = time.Since(start).Milliseconds()
+
+			mu.Lock()
+			defer mu.Unlock()
+			report.Components[c.Name] = cs
+			switch {
+			case cs.Status == ComponentDown && c.Critical:
+				report.Status = "unhealthy"
+			case cs.Status != ComponentOK && report.Status == "healthy":
+				report.Status = "degraded"
+			}
+		}(c)
+	}
+	wg.Wait()
+	report.CheckedAt = time.Now().UTC()
+	return report
+}
@@ -0,0 +1,104 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-gateway/pkg/config"
+	"github.com/Prescott-Data/nexus-framework/nexus-gateway/pkg/usecase"
+)
+
+func probe(status string) func(context.Context) ComponentStatus {
+	return func(context.Context) ComponentStatus { return ComponentStatus{Status: status} }
+}
+
+func TestSystemHealthHandler(t *testing.T) {
+	tests := []struct {
+		name       string
+		components []Component
+		wantStatus string
+		wantCode   int
+	}{
+		{"all ok", []Component{
+			{Name: "gateway", Critical: true, Probe: probe(ComponentOK)},
+			{Name: "broker", Critical: true, Probe: probe(ComponentOK)},
+		}, "healthy", http.StatusOK},
+		{"degraded component", []Component{
+			{Name: "gateway", Critical: true, Probe: probe(ComponentOK)},
+			{Name: "broker", Critical: true, Probe: probe(ComponentDegraded)},
+		}, "degraded", http.StatusOK},
+		{"non-critical down", []Component{
+			{Name: "gateway", Critical: true, Probe: probe(ComponentOK)},
+			{Name: "cache", Probe: probe(ComponentDown)},
+		}, "degraded", http.StatusOK},
+		{"critical down", []Component{
+			{Name: "gateway", Critical: true, Probe: probe(ComponentOK)},
+			{Name: "broker", Critical: true, Probe: probe(ComponentDown)},
+			{Name: "cache", Probe: probe(ComponentDegraded)},
+		}, "unhealthy", http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			SystemHealthHandler(time.Second, tt.components...)(rr, httptest.NewRequest("GET", "/v1/healthz", nil))
+			if rr.Code != tt.wantCode {
+				t.Fatalf("expected %d, got %d", tt.wantCode, rr.Code)
+			}
+			var report SystemHealth
+			if err := json.Unmarshal(rr.Body.Bytes(), &report); err != nil {
+				t.Fatal(err)
+			}
+			if report.Status != tt.wantStatus {
+				t.Errorf("expected %s, got %s", tt.wantStatus, report.Status)
+			}
+			if len(report.Components) != len(tt.components) {
+				t.Errorf("expected %d components, got %d", len(tt.components), len(report.Components))
+			}
+		})
+	}
+}
+
+func TestSystemHealth_BrokerComponent(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"status":"healthy","version":"1.4.0"}`))
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"status":"degraded","dependencies":{"postgres":{"status":"ok","critical":true},"redis":{"status":"error","error":"timeout"}}}`))
+	})
+	broker := httptest.NewServer(mux)
+	defer broker.Close()
+
+	s := New(&config.GatewayConfig{Port: "0", BrokerBaseURL: broker.URL, HealthCheckTimeout: time.Second}, nil, usecase.BuildInfo{Version: "2.0.0"})
+
+	rr := httptest.NewRecorder()
+	s.mux.ServeHTTP(rr, httptest.NewRequest("GET", "/v1/healthz", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var report SystemHealth
+	if err := json.Unmarshal(rr.Body.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	if report.Status != "degraded" {
+		t.Errorf("expected degraded while broker redis is down, got %s", report.Status)
+	}
+	b := report.Components["broker"]
+	if b.Version != "1.4.0" || b.Dependencies["redis"].Status != "error" {
+		t.Errorf("unexpected broker component %+v", b)
+	}
+	if g := report.Components["gateway"]; g.Version != "2.0.0" || g.Status != ComponentOK {
+		t.Errorf("unexpected gateway component %+v", g)
+	}
+
+	s.BeginDrain()
+	rr = httptest.NewRecorder()
+	s.mux.ServeHTTP(rr, httptest.NewRequest("GET", "/v1/healthz", nil))
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 while draining, got %d", rr.Code)
+	}
+}
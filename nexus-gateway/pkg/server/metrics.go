@@ -0,0 +1,53 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_server_requests_total",
+		Help: "HTTP requests handled by the gateway by method, route and status",
+	}, []string{"method", "route", "status"})
+	httpDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_server_request_duration_seconds",
+		Help:    "Duration of HTTP requests handled by the gateway by method and route",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route"})
+)
+
+func init() {
+	prometheus.MustRegister(httpRequests, httpDuration)
+}
+
+// MetricsMiddleware records the count and latency of each request. Routes
+// are labelled by their pattern (/v1/token/{connectionID}), never the raw
+// path, so IDs do not create new series; unmatched requests share the
+// "unmatched" label.
+func MetricsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+
+		route := r.Pattern
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			route = rctx.RoutePattern()
+		}
+		if route == "" {
+			route = "unmatched"
+		}
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		httpDuration.WithLabelValues(r.Method, route).Observe(time.Since(start).Seconds())
+		httpRequests.WithLabelValues(r.Method, route, strconv.Itoa(status)).Inc()
+	})
+}
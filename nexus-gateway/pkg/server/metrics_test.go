@@ -0,0 +1,38 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+func TestMetricsMiddleware_LabelsByRoutePattern(t *testing.T) {
+	mux := chi.NewRouter()
+	mux.Use(MetricsMiddleware)
+	mux.Get("/v1/token/{connectionID}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+	mux.Handle("/metrics", promhttp.Handler())
+
+	mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/v1/token/8c1e2a", nil))
+	mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/nope/123", nil))
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := rr.Body.String()
+	for _, want := range []string{
+		`http_server_requests_total{method="GET",route="/v1/token/{connectionID}",status="418"}`,
+		`http_server_requests_total{method="GET",route="unmatched",status="404"}`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected %s in metrics output", want)
+		}
+	}
+	if strings.Contains(body, "8c1e2a") {
+		t.Error("raw path IDs must not appear in labels")
+	}
+}
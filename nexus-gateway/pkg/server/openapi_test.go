@@ -0,0 +1,125 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"gopkg.in/yaml.v3"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-gateway/api"
+	"github.com/Prescott-Data/nexus-framework/nexus-gateway/pkg/config"
+	"github.com/Prescott-Data/nexus-framework/nexus-gateway/pkg/usecase"
+)
+
+var pathParam = regexp.MustCompile(`\{[^}]*\}`)
+
+// TestOpenAPI_MatchesRoutes checks that every REST route is documented and
+// every documented operation is routed. Operator routes (/metrics, /admin,
+// /debug) are not part of the spec.
+func TestOpenAPI_MatchesRoutes(t *testing.T) {
+	var spec struct {
+		Paths map[string]map[string]any `yaml:"paths"`
+	}
+	if err := yaml.Unmarshal(api.Spec, &spec); err != nil {
+		t.Fatalf("spec does not parse: %v", err)
+	}
+	documented := map[string]map[string]bool{}
+	for path, ops := range spec.Paths {
+		methods := map[string]bool{}
+		for m := range ops {
+			if m != "parameters" {
+				methods[strings.ToUpper(m)] = true
+			}
+		}
+		documented[pathParam.ReplaceAllString(path, "{}")] = methods
+	}
+
+	s := New(&config.GatewayConfig{Port: "0", BrokerBaseURL: "http://broker.invalid", EnableAPIDocs: true}, nil, usecase.BuildInfo{})
+	routed := map[string]map[string]bool{}
+	err := chi.Walk(s.mux, func(method, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+		if route == "/metrics" || strings.HasPrefix(route, "/admin/") || strings.HasPrefix(route, "/debug") {
+			return nil
+		}
+		path := pathParam.ReplaceAllString(route, "{}")
+		if routed[path] == nil {
+			routed[path] = map[string]bool{}
+		}
+		routed[path][method] = true
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for path, methods := range routed {
+		if documented[path] == nil {
+			t.Errorf("%s is not documented", path)
+			continue
+		}
+		// Only Handle registers TRACE; such routes accept any method and
+		// document the ones callers use.
+		if methods[http.MethodTrace] {
+			continue
+		}
+		for m := range methods {
+			if !documented[path][m] {
+				t.Errorf("%s %s is not documented", m, path)
+			}
+		}
+	}
+	for path, methods := range documented {
+		for m := range methods {
+			if !routed[path][m] {
+				t.Errorf("%s %s is documented but not routed", m, path)
+			}
+		}
+	}
+}
+
+func TestOpenAPI_Served(t *testing.T) {
+	s := New(&config.GatewayConfig{Port: "0", BrokerBaseURL: "http://broker.invalid", EnableAPIDocs: true}, nil, usecase.BuildInfo{})
+
+	rr := httptest.NewRecorder()
+	s.mux.ServeHTTP(rr, httptest.NewRequest("GET", "/openapi.json", nil))
+	var doc struct {
+		OpenAPI string                    `json:"openapi"`
+		Paths   map[string]map[string]any `json:"paths"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("body is not JSON: %v", err)
+	}
+	if !strings.HasPrefix(doc.OpenAPI, "3.0.") || doc.Paths["/v1/request-connection"]["post"] == nil {
+		t.Errorf("unexpected document: %s", doc.OpenAPI)
+	}
+
+	rr = httptest.NewRecorder()
+	s.mux.ServeHTTP(rr, httptest.NewRequest("GET", "/docs", nil))
+	if body := rr.Body.String(); !strings.Contains(body, "<title>Nexus Gateway API</title>") || !strings.Contains(body, `url: "openapi.json"`) {
+		t.Errorf("unexpected page: %s", body)
+	}
+
+	s = New(&config.GatewayConfig{Port: "0", BrokerBaseURL: "http://broker.invalid"}, nil, usecase.BuildInfo{})
+	rr = httptest.NewRecorder()
+	s.mux.ServeHTTP(rr, httptest.NewRequest("GET", "/openapi.json", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected 404 with API docs disabled, got %d", rr.Code)
+	}
+}
+
+func TestSpecJSON(t *testing.T) {
+	body, err := SpecJSON([]byte("openapi: 3.0.3\npaths:\n  /x:\n    get:\n      responses:\n        200: {description: ok}\n"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(string(body), `"200":{"description":"ok"}`) {
+		t.Errorf("numeric keys not converted: %s", body)
+	}
+	if _, err := SpecJSON([]byte("openapi: [")); err == nil {
+		t.Error("expected an error for malformed YAML")
+	}
+}
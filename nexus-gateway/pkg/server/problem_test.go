@@ -0,0 +1,52 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-gateway/pkg/config"
+	"github.com/Prescott-Data/nexus-framework/nexus-gateway/pkg/usecase"
+)
+
+func TestErrorsAreProblemDetails(t *testing.T) {
+	s := New(&config.GatewayConfig{Port: "0", BrokerBaseURL: "http://broker.invalid"}, nil, usecase.BuildInfo{})
+
+	cases := []struct {
+		method, path, body string
+		status             int
+		code               string
+	}{
+		{"POST", "/v1/request-connection", "{", http.StatusBadRequest, "invalid_json"},
+		{"GET", "/v1/nope", "", http.StatusNotFound, "not_found"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.path, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			s.mux.ServeHTTP(rr, httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body)))
+			if rr.Code != tc.status {
+				t.Fatalf("expected %d, got %d", tc.status, rr.Code)
+			}
+			if ct := rr.Header().Get("Content-Type"); ct != "application/problem+json" {
+				t.Errorf("expected application/problem+json, got %q", ct)
+			}
+			var body struct {
+				Type      string `json:"type"`
+				Status    int    `json:"status"`
+				Code      string `json:"code"`
+				RequestID string `json:"request_id"`
+			}
+			if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if body.Code != tc.code || body.Status != tc.status || !strings.HasSuffix(body.Type, "#"+tc.code) {
+				t.Errorf("unexpected body %+v", body)
+			}
+			if id := rr.Header().Get("X-Request-ID"); id == "" || body.RequestID != id {
+				t.Errorf("expected request_id %q to match the X-Request-ID header", body.RequestID)
+			}
+		})
+	}
+}
@@ -1,66 +1,147 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/cors"
+	"github.com/gorilla/websocket"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 
+	"github.com/Prescott-Data/nexus-framework/nexus-common/health"
+	"github.com/Prescott-Data/nexus-framework/nexus-common/problem"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-gateway/api"
+	"github.com/Prescott-Data/nexus-framework/nexus-gateway/pkg/auth"
 	"github.com/Prescott-Data/nexus-framework/nexus-gateway/pkg/config"
 	"github.com/Prescott-Data/nexus-framework/nexus-gateway/pkg/usecase"
 )
 
 type Server struct {
-	mux     *chi.Mux
-	port    string
-	handler *usecase.Handler
+	mux        *chi.Mux
+	port       string
+	handler    *usecase.Handler
+	httpServer *http.Server
+	draining   atomic.Bool
+	build      usecase.BuildInfo
+	startedAt  time.Time
+
+	healthCheckTimeout time.Duration
+	tls                config.TLSConfig
+	apiDocs            bool
+
+	// Signs token responses; loaded by Start from signing
+	signing config.ResponseSigningConfig
+	signer  *ResponseSigner
 }
 
-func New(port, brokerBaseURL string, stateKey []byte, httpClient *http.Client) *Server {
+// New builds the REST gateway. build is served by /version, with Features
+// taken from cfg, and its version is reported by /v1/healthz. opts are
+// passed on to the usecase handler.
+func New(cfg *config.GatewayConfig, httpClient *http.Client, build usecase.BuildInfo, opts ...usecase.HandlerOption) *Server {
 	mux := chi.NewRouter()
 
 	// CORS Setup
 	mux.Use(cors.Handler(cors.Options{
-		AllowedOrigins:   config.GetAllowedOrigins(),
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
-		ExposedHeaders:   []string{"Link"},
+		AllowedOrigins:   cfg.AllowedOrigins,
+		AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token", "X-API-Key", "If-Match"},
+		ExposedHeaders:   []string{"Link", "ETag", SignatureHeader},
 		AllowCredentials: true,
 		MaxAge:           300,
 	}))
 
 	mux.Use(middleware.RequestID)
+	mux.Use(problem.RequestID(func(r *http.Request) string { return middleware.GetReqID(r.Context()) }))
+	mux.Use(MetricsMiddleware)
 	mux.Use(middleware.Logger)
 	mux.Use(middleware.Recoverer)
-	mux.Use(middleware.Timeout(30 * time.Second))
+	mux.Use(exceptWebSocket(RouteTimeout(cfg.Timeouts)))
 	mux.Use(middleware.RealIP)
+	mux.Use(exceptWebSocketTicket(auth.Middleware(auth.New(cfg.Auth, httpClient), cfg.Auth.ExemptPaths)))
 
-	h := usecase.NewHandler(brokerBaseURL, stateKey, httpClient)
+	opts = append([]usecase.HandlerOption{
+		usecase.WithBrokerAPIKey(cfg.BrokerAPIKey),
+		usecase.WithWebSocketProxy(cfg.WSProxyAllowedHosts, cfg.AllowedOrigins, cfg.WSProxyAllowNoOrigin),
+		usecase.WithMetadataCache(cfg.MetadataCacheTTL, cfg.MetadataCacheStale),
+		usecase.WithInsecureSessionCookie(cfg.SessionCookieInsecure),
+	}, opts...)
+	h := usecase.NewHandler(cfg.BrokerBaseURL, cfg.StateKeys, httpClient, opts...)
 
-	s := &Server{mux: mux, port: port, handler: h}
+	build.Features = cfg.Features()
+	s := &Server{mux: mux, port: cfg.Port, handler: h, build: build, startedAt: time.Now(), healthCheckTimeout: cfg.HealthCheckTimeout, tls: cfg.TLS, apiDocs: cfg.EnableAPIDocs, signing: cfg.ResponseSigning}
+	s.httpServer = &http.Server{Addr: ":" + cfg.Port, Handler: mux, Protocols: HTTPProtocols()}
 	s.routes()
+	mux.NotFound(func(w http.ResponseWriter, r *http.Request) {
+		problem.Write(w, problem.New(http.StatusNotFound, "not_found", "no route for "+r.URL.Path))
+	})
+	mux.MethodNotAllowed(func(w http.ResponseWriter, r *http.Request) {
+		problem.Write(w, problem.New(http.StatusMethodNotAllowed, "method_not_allowed", r.Method+" is not allowed on "+r.URL.Path))
+	})
+	RegisterAdminRoutes(s.mux, cfg.AdminAPIKey, cfg.EnableDebugEndpoints, s.Reload)
 	return s
 }
 
+// Handler returns the REST routes, for serving them from a listener other
+// than the one Start opens, such as an httptest.Server.
+func (s *Server) Handler() http.Handler { return s.mux }
+
+// Reload invalidates the provider name -> ID cache and the provider
+// metadata cache. It backs POST /admin/reload and SIGHUP.
+func (s *Server) Reload(ctx context.Context) (map[string]int, error) {
+	return map[string]int{
+		"provider_cache": s.handler.InvalidateProviderCache(),
+		"metadata_cache": s.handler.InvalidateMetadataCache(),
+	}, nil
+}
+
 func (s *Server) routes() {
 	s.mux.Get("/health", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
 		_ = json.NewEncoder(w).Encode(map[string]string{"status": "healthy"})
 	})
+	s.mux.Get("/healthz", health.LivenessHandler)
+	s.mux.Get("/version", s.serverInfo)
+	s.mux.Get("/readyz", health.ReadinessHandler(s.healthCheckTimeout,
+		health.Check{Name: "shutdown", Critical: true, Check: s.DrainCheck},
+		BrokerHealthCheck(s.handler.PingBroker),
+	))
+	s.mux.Get("/v1/healthz", SystemHealthHandler(s.healthCheckTimeout,
+		Component{Name: "gateway", Critical: true, Probe: s.probeGateway},
+		Component{Name: "broker", Critical: true, Probe: s.probeBroker},
+	))
 
 	// Prometheus metrics
 	s.mux.Handle("/metrics", promhttp.Handler())
 
-	s.mux.Post("/v1/request-connection", s.handler.RequestConnection)
+	if s.apiDocs {
+		if openAPI, err := OpenAPIHandler(api.Spec); err != nil {
+			log.Printf("API docs disabled: %v", err)
+		} else {
+			s.mux.Get("/openapi.json", openAPI)
+			s.mux.Get("/docs", SwaggerUIHandler("Nexus Gateway API", "openapi.json"))
+		}
+	}
+
+	s.mux.With(s.RejectWhileDraining).Post("/v1/request-connection", s.handler.RequestConnection)
 	s.mux.Get("/v1/check-connection/{connectionID}", s.handler.CheckConnection)
-	s.mux.Get("/v1/token/{connectionID}", s.handler.GetToken)
-	s.mux.Post("/v1/refresh/{connectionID}", s.handler.RefreshConnection)
+	s.mux.Get("/v1/connection-result", s.handler.ConnectionResult)
+	s.mux.With(s.signResponses).Get("/v1/token/{connectionID}", s.handler.GetToken)
+	s.mux.With(s.signResponses).Post("/v1/refresh/{connectionID}", s.handler.RefreshConnection)
+	s.mux.With(s.signResponses).Post("/v1/exchange/{connectionID}", s.handler.ExchangeToken)
+	s.mux.Post("/v1/grant/{connectionID}", s.handler.CreateGrant)
+	s.mux.With(s.signResponses).Post("/v1/redeem-grant", s.handler.RedeemGrant)
 	s.mux.Get("/v1/providers", s.handler.GetProviders)
+	s.mux.Get("/v1/ws/{connectionID}", s.handler.ProxyWebSocket)
+	s.mux.Post("/v1/ws-ticket/{connectionID}", s.handler.CreateWebSocketTicket)
 	s.mux.Get("/v1/providers/metadata", s.handler.GetProviders)
 	s.mux.Post("/v1/providers", s.handler.CreateProvider)
 	s.mux.Get("/v1/providers/{id}", s.handler.GetProvider)
@@ -76,7 +157,178 @@ func (s *Server) routes() {
 	s.mux.Post("/v1/capture-credential", s.handler.CaptureCredential)
 }
 
+// serverInfo serves GET /version: this Gateway's build and the Broker's.
+func (s *Server) serverInfo(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), s.healthCheckTimeout)
+	defer cancel()
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(s.handler.ServerInfo(ctx, s.build))
+}
+
+// Start serves HTTP, or HTTPS when TLS is enabled for the rest listener,
+// until the server is stopped. It returns nil when the server was stopped by
+// Shutdown. Token responses are signed when a signing key is configured.
 func (s *Server) Start() error {
-	log.Printf("HTTP server listening on :%s", s.port)
-	return http.ListenAndServe(":"+s.port, s.mux)
+	var err error
+	if s.signing.KeyFile != "" {
+		if s.signer, err = LoadResponseSigner(s.signing); err != nil {
+			return err
+		}
+		log.Printf("Signing token responses with key %s", s.signer.KeyID())
+	}
+	if s.tls.Enabled(config.ListenerREST) {
+		if s.httpServer.TLSConfig, err = NewTLSConfig(s.tls); err != nil {
+			return err
+		}
+		log.Printf("HTTPS server listening on :%s", s.port)
+		err = s.httpServer.ListenAndServeTLS("", "")
+	} else {
+		log.Printf("HTTP server listening on :%s", s.port)
+		err = s.httpServer.ListenAndServe()
+	}
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// BeginDrain fails readiness and refuses new connection requests while
+// continuing to serve everything else (notably callback proxies).
+func (s *Server) BeginDrain() {
+	s.draining.Store(true)
+}
+
+// Draining reports whether BeginDrain has been called.
+func (s *Server) Draining() bool {
+	return s.draining.Load()
+}
+
+// Shutdown begins draining and gracefully stops the HTTP server, waiting for
+// in-flight requests until ctx expires.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.BeginDrain()
+	return s.httpServer.Shutdown(ctx)
+}
+
+// DrainCheck is a readiness check that fails once the server is draining.
+func (s *Server) DrainCheck(ctx context.Context) error {
+	if s.Draining() {
+		return errors.New("shutting down")
+	}
+	return nil
+}
+
+func (s *Server) probeGateway(ctx context.Context) ComponentStatus {
+	cs := ComponentStatus{Status: ComponentOK, Version: s.build.Version, UptimeSeconds: int64(time.Since(s.startedAt).Seconds())}
+	if s.Draining() {
+		cs.Status = ComponentDown
+		cs.Error = "shutting down"
+	}
+	return cs
+}
+
+// probeBroker maps the Broker's readiness onto a component status: ready is
+// ok, degraded (e.g. Redis down) is degraded, and unavailable or unreachable
+// is down.
+func (s *Server) probeBroker(ctx context.Context) ComponentStatus {
+	bh, err := s.handler.BrokerHealth(ctx)
+	if err != nil {
+		return ComponentStatus{Status: ComponentDown, Error: err.Error()}
+	}
+	cs := ComponentStatus{Status: ComponentOK, Version: bh.Version}
+	switch bh.Readiness {
+	case "degraded":
+		cs.Status = ComponentDegraded
+	case "unavailable":
+		cs.Status = ComponentDown
+		cs.Error = "broker reports unavailable"
+	}
+	if len(bh.Dependencies) > 0 {
+		cs.Dependencies = make(map[string]health.DependencyStatus, len(bh.Dependencies))
+		for name, d := range bh.Dependencies {
+			cs.Dependencies[name] = health.DependencyStatus(d)
+		}
+	}
+	return cs
+}
+
+// RejectWhileDraining answers 503 for requests that would start new consent
+// flows once the server is draining.
+func (s *Server) RejectWhileDraining(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.Draining() {
+			w.Header().Set("Retry-After", "5")
+			problem.Write(w, problem.New(http.StatusServiceUnavailable, "shutting_down", "server is shutting down, retry shortly"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RouteTimeout bounds each request by the timeout configured for its route.
+// The deadline is set on the request context, so it reaches every Broker
+// call made for the request; those calls are also cancelled if the client
+// goes away first. Requests still running at the deadline get a 504.
+func RouteTimeout(t config.Timeouts) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			d := t.For(restRoute(r.URL.Path))
+			if d <= 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+			middleware.Timeout(d)(next).ServeHTTP(w, r)
+		})
+	}
+}
+
+// restRoute names the route of a REST path for ROUTE_TIMEOUTS, or returns
+// "" for a path without one.
+func restRoute(path string) string {
+	switch {
+	case path == "/v1/request-connection":
+		return config.RouteRequestConnection
+	case strings.HasPrefix(path, "/v1/check-connection/"):
+		return config.RouteCheckConnection
+	case strings.HasPrefix(path, "/v1/token/"):
+		return config.RouteToken
+	case strings.HasPrefix(path, "/v1/refresh/"):
+		return config.RouteRefresh
+	case path == "/v1/providers" || strings.HasPrefix(path, "/v1/providers/"):
+		return config.RouteProviders
+	case path == "/v1/capture-credential":
+		return config.RouteCaptureCredential
+	}
+	return ""
+}
+
+// exceptWebSocketTicket applies authn to every request except WebSocket
+// upgrades to /v1/ws/ that carry a ticket, which ProxyWebSocket redeems in
+// place of caller credentials.
+func exceptWebSocketTicket(authn func(http.Handler) http.Handler) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		wrapped := authn(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if strings.HasPrefix(r.URL.Path, "/v1/ws/") && websocket.IsWebSocketUpgrade(r) && r.URL.Query().Get(usecase.WSTicketParam) != "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			wrapped.ServeHTTP(w, r)
+		})
+	}
+}
+
+// exceptWebSocket applies mw to every request except WebSocket upgrades,
+// which are long-lived and must not inherit the per-request timeout.
+func exceptWebSocket(mw func(http.Handler) http.Handler) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		wrapped := mw(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if websocket.IsWebSocketUpgrade(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			wrapped.ServeHTTP(w, r)
+		})
+	}
 }
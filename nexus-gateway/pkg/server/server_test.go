@@ -0,0 +1,69 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-gateway/pkg/config"
+	"github.com/Prescott-Data/nexus-framework/nexus-gateway/pkg/usecase"
+)
+
+func TestWebSocketUpgradesAreAuthenticated(t *testing.T) {
+	s := New(&config.GatewayConfig{
+		Port:                "0",
+		BrokerBaseURL:       "http://broker.invalid",
+		AllowedOrigins:      []string{"https://app.example.com"},
+		WSProxyAllowedHosts: []string{"stream.example.com"},
+		Auth:                config.AuthConfig{Methods: []string{config.AuthAPIKey}, APIKeys: map[string]string{"k1": "svc-a"}},
+	}, nil, usecase.BuildInfo{})
+
+	upgrade := func(path string) *http.Request {
+		r := httptest.NewRequest("GET", path, nil)
+		r.Header.Set("Connection", "Upgrade")
+		r.Header.Set("Upgrade", "websocket")
+		r.Header.Set("Origin", "https://app.example.com")
+		return r
+	}
+	code := func(rr *httptest.ResponseRecorder) string {
+		var body struct {
+			Code string `json:"code"`
+		}
+		json.NewDecoder(rr.Body).Decode(&body)
+		return body.Code
+	}
+
+	cases := []struct {
+		name   string
+		req    *http.Request
+		status int
+		code   string
+	}{
+		{"no credentials", upgrade("/v1/ws/conn-1?target=wss://stream.example.com/"), http.StatusUnauthorized, "unauthenticated"},
+		{"unknown ticket", upgrade("/v1/ws/conn-1?target=wss://stream.example.com/&ticket=nope"), http.StatusUnauthorized, "invalid_ticket"},
+		{"ticket on another route", upgrade("/v1/token/conn-1?ticket=nope"), http.StatusUnauthorized, "unauthenticated"},
+		{"ticket without credentials", httptest.NewRequest("POST", "/v1/ws-ticket/conn-1", nil), http.StatusUnauthorized, "unauthenticated"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			s.mux.ServeHTTP(rr, tc.req)
+			if rr.Code != tc.status || code(rr) != tc.code {
+				t.Errorf("got %d %q, want %d %q", rr.Code, code(rr), tc.status, tc.code)
+			}
+		})
+	}
+
+	req := httptest.NewRequest("POST", "/v1/ws-ticket/conn-1", nil)
+	req.Header.Set("X-API-Key", "k1")
+	rr := httptest.NewRecorder()
+	s.mux.ServeHTTP(rr, req)
+	var ticket struct {
+		Ticket    string `json:"ticket"`
+		ExpiresIn int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&ticket); rr.Code != http.StatusCreated || err != nil || ticket.Ticket == "" || ticket.ExpiresIn <= 0 {
+		t.Errorf("ticket = %d %+v, %v", rr.Code, ticket, err)
+	}
+}
@@ -0,0 +1,167 @@
+package server
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-common/problem"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-gateway/pkg/config"
+)
+
+// SignatureHeader carries the detached JWS over a signed response body.
+const SignatureHeader = "X-Nexus-Signature"
+
+// ResponseSigner signs response bodies with the Gateway's signing key.
+// Each signature is a compact JWS with a detached payload (RFC 7515
+// appendix F): the payload is the exact body bytes, and the protected
+// header names the key (kid), the signing time (iat) and the request path
+// (htu), so a body cannot be replayed for another connection.
+type ResponseSigner struct {
+	key crypto.Signer
+	alg jose.SignatureAlgorithm
+	kid string
+}
+
+// LoadResponseSigner reads the PEM private key named by cfg.KeyFile.
+func LoadResponseSigner(cfg config.ResponseSigningConfig) (*ResponseSigner, error) {
+	raw, err := os.ReadFile(cfg.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("read RESPONSE_SIGNING_KEY_FILE: %w", err)
+	}
+	s, err := NewResponseSigner(raw, cfg.KeyID)
+	if err != nil {
+		return nil, fmt.Errorf("RESPONSE_SIGNING_KEY_FILE %s: %w", cfg.KeyFile, err)
+	}
+	return s, nil
+}
+
+// NewResponseSigner parses a PEM private key (PKCS#8, PKCS#1 or SEC 1):
+// RSA of at least 2048 bits signs with RS256, P-256 with ES256 and Ed25519
+// with EdDSA. An empty kid defaults to the key's RFC 7638 thumbprint.
+func NewResponseSigner(keyPEM []byte, kid string) (*ResponseSigner, error) {
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM private key found")
+	}
+	var key any
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse private key: %w", err)
+	}
+
+	s := &ResponseSigner{kid: kid}
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		if k.N.BitLen() < 2048 {
+			return nil, fmt.Errorf("RSA key has %d bits, want at least 2048", k.N.BitLen())
+		}
+		s.key, s.alg = k, jose.RS256
+	case *ecdsa.PrivateKey:
+		if k.Curve != elliptic.P256() {
+			return nil, fmt.Errorf("EC key must use P-256")
+		}
+		s.key, s.alg = k, jose.ES256
+	case ed25519.PrivateKey:
+		s.key, s.alg = k, jose.EdDSA
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", key)
+	}
+	if s.kid == "" {
+		thumb, err := (&jose.JSONWebKey{Key: s.key.Public()}).Thumbprint(crypto.SHA256)
+		if err != nil {
+			return nil, fmt.Errorf("key thumbprint: %w", err)
+		}
+		s.kid = base64.RawURLEncoding.EncodeToString(thumb)
+	}
+	return s, nil
+}
+
+// KeyID returns the kid written in every signature.
+func (s *ResponseSigner) KeyID() string { return s.kid }
+
+// Sign returns the detached compact JWS over body for a response to a
+// request for path, signed at now.
+func (s *ResponseSigner) Sign(body []byte, path string, now time.Time) (string, error) {
+	opts := (&jose.SignerOptions{}).
+		WithHeader("kid", s.kid).
+		WithHeader("iat", now.Unix()).
+		WithHeader("htu", path)
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: s.alg, Key: s.key}, opts)
+	if err != nil {
+		return "", err
+	}
+	obj, err := signer.Sign(body)
+	if err != nil {
+		return "", err
+	}
+	return obj.DetachedCompactSerialize()
+}
+
+// SignResponses buffers successful responses and signs them with signer
+// into SignatureHeader. Errors are sent unsigned.
+func SignResponses(signer *ResponseSigner) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			sw := &signingWriter{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(sw, r)
+			if sw.status >= 200 && sw.status < 300 {
+				sig, err := signer.Sign(sw.body.Bytes(), r.URL.EscapedPath(), time.Now())
+				if err != nil {
+					// Verifying clients would reject the body unsigned.
+					log.Printf("response signing failed for %s: %v", r.URL.Path, err)
+					problem.Write(w, problem.New(http.StatusInternalServerError, "signing_failed", "response signing failed"))
+					return
+				}
+				w.Header().Set(SignatureHeader, sig)
+			}
+			w.WriteHeader(sw.status)
+			_, _ = w.Write(sw.body.Bytes())
+		})
+	}
+}
+
+// signingWriter holds back the status and body until they are signed.
+type signingWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *signingWriter) WriteHeader(status int) { w.status = status }
+
+func (w *signingWriter) Write(p []byte) (int, error) { return w.body.Write(p) }
+
+// signResponses signs the responses of next once Start has loaded the
+// signing key; without one they are sent as they are.
+func (s *Server) signResponses(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.signer == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		SignResponses(s.signer)(next).ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,97 @@
+package server
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-jose/go-jose/v4"
+)
+
+func pemKey(t *testing.T, key any) []byte {
+	t.Helper()
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+}
+
+func TestSignResponses(t *testing.T) {
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	for name, key := range map[string]any{"ed25519": edKey, "p256": ecKey} {
+		signer, err := NewResponseSigner(pemKey(t, key), "")
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		h := SignResponses(signer)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/v1/token/missing" {
+				http.Error(w, "not found", http.StatusNotFound)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"access_token": "at"}`))
+		}))
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/v1/token/conn-1", nil))
+		sig := w.Header().Get(SignatureHeader)
+		if w.Code != http.StatusOK || sig == "" {
+			t.Fatalf("%s: response = %d, signature %q", name, w.Code, sig)
+		}
+		obj, err := jose.ParseDetached(sig, w.Body.Bytes(), []jose.SignatureAlgorithm{jose.EdDSA, jose.ES256})
+		if err != nil {
+			t.Fatalf("%s: parse signature: %v", name, err)
+		}
+		public := key.(crypto.Signer).Public()
+		if _, err := obj.Verify(public); err != nil {
+			t.Errorf("%s: signature does not verify: %v", name, err)
+		}
+		header := obj.Signatures[0].Protected
+		if header.KeyID != signer.KeyID() || header.ExtraHeaders["htu"] != "/v1/token/conn-1" || header.ExtraHeaders["iat"] == nil {
+			t.Errorf("%s: protected header = %+v", name, header)
+		}
+		forged, err := jose.ParseDetached(sig, []byte(`{"access_token": "forged"}`), []jose.SignatureAlgorithm{signer.alg})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := forged.Verify(public); err == nil {
+			t.Errorf("%s: signature verifies a different body", name)
+		}
+
+		w = httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/v1/token/missing", nil))
+		if w.Code != http.StatusNotFound || w.Header().Get(SignatureHeader) != "" {
+			t.Errorf("%s: error response = %d, signature %q", name, w.Code, w.Header().Get(SignatureHeader))
+		}
+	}
+}
+
+func TestNewResponseSigner_RejectsWeakKeys(t *testing.T) {
+	small, _ := rsa.GenerateKey(rand.Reader, 1024)
+	p384, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	for name, keyPEM := range map[string][]byte{
+		"rsa-1024": pemKey(t, small),
+		"p-384":    pemKey(t, p384),
+		"not pem":  []byte("secret"),
+	} {
+		if _, err := NewResponseSigner(keyPEM, ""); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	signer, err := NewResponseSigner(pemKey(t, ecKey), "gw-2026")
+	if err != nil || signer.KeyID() != "gw-2026" {
+		t.Errorf("signer = %v, %v", signer, err)
+	}
+}
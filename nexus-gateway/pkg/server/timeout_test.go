@@ -0,0 +1,65 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-gateway/pkg/config"
+)
+
+func TestRouteTimeout(t *testing.T) {
+	timeouts := config.Timeouts{
+		Default: time.Minute,
+		Routes:  map[string]time.Duration{config.RouteToken: 20 * time.Millisecond},
+	}
+	var deadline time.Time
+	handler := RouteTimeout(timeouts)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deadline, _ = r.Context().Deadline()
+		<-r.Context().Done()
+		if r.Context().Err() == context.DeadlineExceeded {
+			return // the middleware answers
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	rr := httptest.NewRecorder()
+	start := time.Now()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/v1/token/conn-1", nil))
+	if rr.Code != http.StatusGatewayTimeout {
+		t.Errorf("expected 504 once the token timeout passed, got %d", rr.Code)
+	}
+	if d := deadline.Sub(start); d > time.Second {
+		t.Errorf("expected the token route's deadline, got one %s away", d)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel() // the client went away
+	rr = httptest.NewRecorder()
+	start = time.Now()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/v1/request-connection", nil).WithContext(ctx))
+	if d := deadline.Sub(start); d < 50*time.Second {
+		t.Errorf("expected the default deadline for request_connection, got one %s away", d)
+	}
+}
+
+func TestRestRoute(t *testing.T) {
+	for path, want := range map[string]string{
+		"/v1/request-connection":   config.RouteRequestConnection,
+		"/v1/check-connection/abc": config.RouteCheckConnection,
+		"/v1/token/abc":            config.RouteToken,
+		"/v1/refresh/abc":          config.RouteRefresh,
+		"/v1/providers":            config.RouteProviders,
+		"/v1/providers/metadata":   config.RouteProviders,
+		"/v1/capture-credential":   config.RouteCaptureCredential,
+		"/v1/connection-result":    "",
+		"/v1/tokens":               "",
+		"/readyz":                  "",
+	} {
+		if got := restRoute(path); got != want {
+			t.Errorf("restRoute(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
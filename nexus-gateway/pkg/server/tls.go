@@ -0,0 +1,131 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-gateway/pkg/config"
+)
+
+// certCheckInterval is how often the certificate files are checked for
+// changes, so a renewed certificate is served without a restart.
+const certCheckInterval = 30 * time.Second
+
+// NewTLSConfig builds the server TLS configuration for cfg: the
+// certificate (reloaded when its files change, or obtained with ACME when
+// cfg.ACME lists hosts), HTTP/2 and HTTP/1.1 via ALPN, and client
+// certificate verification per cfg.ClientAuth.
+func NewTLSConfig(cfg config.TLSConfig) (*tls.Config, error) {
+	var tc *tls.Config
+	if len(cfg.ACME.Hosts) > 0 {
+		tc = acmeTLSConfig(cfg.ACME)
+	} else {
+		certs := &certReloader{certFile: cfg.CertFile, keyFile: cfg.KeyFile}
+		if err := certs.load(); err != nil {
+			return nil, err
+		}
+		tc = &tls.Config{
+			MinVersion:     tls.VersionTLS12,
+			NextProtos:     []string{"h2", "http/1.1"},
+			GetCertificate: certs.getCertificate,
+		}
+	}
+	if cfg.ClientCAFile != "" {
+		pem, err := os.ReadFile(cfg.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("read TLS_CLIENT_CA_FILE: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("TLS_CLIENT_CA_FILE %s contains no certificates", cfg.ClientCAFile)
+		}
+		tc.ClientCAs = pool
+	}
+	switch cfg.ClientAuth {
+	case config.ClientAuthOptional:
+		tc.ClientAuth = tls.VerifyClientCertIfGiven
+	case config.ClientAuthRequire:
+		tc.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return tc, nil
+}
+
+// HTTPProtocols enables HTTP/1.1 and HTTP/2, including HTTP/2 without TLS
+// (h2c with prior knowledge) for plain listeners behind an HTTP/2 proxy.
+func HTTPProtocols() *http.Protocols {
+	p := new(http.Protocols)
+	p.SetHTTP1(true)
+	p.SetHTTP2(true)
+	p.SetUnencryptedHTTP2(true)
+	return p
+}
+
+// certReloader serves a key pair from disk, reloading it when either file's
+// modification time changes. A failed reload keeps the previous pair.
+type certReloader struct {
+	certFile, keyFile string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+	checked time.Time
+}
+
+func (c *certReloader) load() error {
+	modTime, err := c.latestModTime()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return fmt.Errorf("load TLS certificate: %w", err)
+	}
+	c.mu.Lock()
+	c.cert, c.modTime, c.checked = &cert, modTime, time.Now()
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *certReloader) latestModTime() (time.Time, error) {
+	var latest time.Time
+	for _, f := range []string{c.certFile, c.keyFile} {
+		fi, err := os.Stat(f)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("TLS certificate: %w", err)
+		}
+		if fi.ModTime().After(latest) {
+			latest = fi.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+func (c *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.Lock()
+	due := time.Since(c.checked) >= certCheckInterval
+	if due {
+		c.checked = time.Now()
+	}
+	cert, modTime := c.cert, c.modTime
+	c.mu.Unlock()
+
+	if due {
+		if latest, err := c.latestModTime(); err == nil && latest.After(modTime) {
+			if err := c.load(); err != nil {
+				log.Printf("tls: keeping the current certificate: %v", err)
+			} else {
+				log.Printf("tls: reloaded certificate %s", c.certFile)
+				c.mu.Lock()
+				cert = c.cert
+				c.mu.Unlock()
+			}
+		}
+	}
+	return cert, nil
+}
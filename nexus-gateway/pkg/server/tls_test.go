@@ -0,0 +1,157 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-gateway/pkg/config"
+)
+
+// writeCert writes a self-signed certificate for cn into dir and returns
+// the parsed certificate with its cert and key file paths.
+func writeCert(t *testing.T, dir, cn string) (*x509.Certificate, string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: cn},
+		DNSNames:              []string{"localhost"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile := filepath.Join(dir, cn+".crt"), filepath.Join(dir, cn+".key")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return cert, certFile, keyFile
+}
+
+func TestNewTLSConfig_HTTP2AndClientCerts(t *testing.T) {
+	dir := t.TempDir()
+	serverCert, certFile, keyFile := writeCert(t, dir, "localhost")
+	_, clientCertFile, clientKeyFile := writeCert(t, dir, "svc-billing")
+
+	tc, err := NewTLSConfig(config.TLSConfig{
+		CertFile: certFile, KeyFile: keyFile,
+		ClientCAFile: clientCertFile, ClientAuth: config.ClientAuthRequire,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var peer string
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		peer = r.TLS.VerifiedChains[0][0].Subject.CommonName
+		w.Write([]byte(r.Proto))
+	}))
+	srv.TLS = tc
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	defer srv.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(serverCert)
+	client := func(certs ...tls.Certificate) *http.Client {
+		tr := &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots, ServerName: "localhost", Certificates: certs}, ForceAttemptHTTP2: true}
+		return &http.Client{Transport: tr, Timeout: 5 * time.Second}
+	}
+
+	if _, err := client().Get(srv.URL); err == nil {
+		t.Fatal("expected the handshake to fail without a client certificate")
+	}
+	clientCert, err := tls.LoadX509KeyPair(clientCertFile, clientKeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client(clientCert).Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.ProtoMajor != 2 {
+		t.Errorf("expected HTTP/2 via ALPN, got %s", resp.Proto)
+	}
+	if peer != "svc-billing" {
+		t.Errorf("expected the verified client certificate, got %q", peer)
+	}
+}
+
+func TestCertReloader_PicksUpRenewal(t *testing.T) {
+	dir := t.TempDir()
+	_, certFile, keyFile := writeCert(t, dir, "localhost")
+	r := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := r.load(); err != nil {
+		t.Fatal(err)
+	}
+	first, _ := r.getCertificate(nil)
+
+	renewed, newCert, newKey := writeCert(t, t.TempDir(), "localhost")
+	for src, dst := range map[string]string{newCert: certFile, newKey: keyFile} {
+		b, _ := os.ReadFile(src)
+		if err := os.WriteFile(dst, b, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		future := time.Now().Add(time.Minute)
+		os.Chtimes(dst, future, future)
+	}
+	if same, _ := r.getCertificate(nil); same != first {
+		t.Error("files should not be checked again before certCheckInterval")
+	}
+	r.checked = time.Now().Add(-certCheckInterval)
+	got, _ := r.getCertificate(nil)
+	if leaf, _ := x509.ParseCertificate(got.Certificate[0]); !leaf.Equal(renewed) {
+		t.Error("expected the renewed certificate")
+	}
+}
+
+func TestNewTLSConfig_ACME(t *testing.T) {
+	acmeCfg := config.ACMEConfig{Hosts: []string{"gateway.example.com"}, CacheDir: t.TempDir()}
+	tc, err := NewTLSConfig(config.TLSConfig{ACME: acmeCfg})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Contains(tc.NextProtos, "acme-tls/1") || !slices.Contains(tc.NextProtos, "h2") {
+		t.Errorf("expected TLS-ALPN-01 and HTTP/2 in NextProtos, got %v", tc.NextProtos)
+	}
+	if _, err := tc.GetCertificate(&tls.ClientHelloInfo{ServerName: "other.example.com"}); err == nil {
+		t.Error("expected a host outside TLS_ACME_HOSTS to be refused")
+	}
+
+	// The HTTP-01 listener redirects requests that are not challenges.
+	rec := httptest.NewRecorder()
+	acmeManager(acmeCfg).HTTPHandler(nil).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://gateway.example.com/healthz", nil))
+	if rec.Code != http.StatusFound || rec.Header().Get("Location") != "https://gateway.example.com/healthz" {
+		t.Errorf("expected a redirect to HTTPS, got %d %q", rec.Code, rec.Header().Get("Location"))
+	}
+}
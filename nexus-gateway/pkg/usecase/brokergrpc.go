@@ -0,0 +1,297 @@
+package usecase
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	brokerpb "github.com/Prescott-Data/nexus-framework/nexus-common/gen/go/api/proto/broker/v1"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-gateway/pkg/auth"
+)
+
+// brokerErrorDomain is the ErrorInfo domain of errors returned by the
+// Broker's gRPC API. Their http_status metadata is the status the
+// equivalent REST call would have answered with.
+const brokerErrorDomain = "nexus-broker"
+
+// WithBrokerGRPC sends consent, token, refresh, connection status, provider
+// lookup and provider metadata calls to the Broker's gRPC BrokerService over
+// conn instead of REST. Every other Broker call stays on REST.
+func WithBrokerGRPC(conn grpc.ClientConnInterface) HandlerOption {
+	return func(o *handlerOptions) { o.brokerConn = conn }
+}
+
+// BrokerConnPool spreads Broker gRPC calls over several HTTP/2 connections,
+// so a busy Gateway is not limited by one connection's stream limit.
+type BrokerConnPool struct {
+	conns []*grpc.ClientConn
+	next  atomic.Uint32
+}
+
+// DialBroker opens size connections to the Broker's gRPC listener at addr,
+// with TLS when useTLS is set. Connections are established lazily.
+func DialBroker(addr string, size int, useTLS bool) (*BrokerConnPool, error) {
+	if size < 1 {
+		size = 1
+	}
+	creds := insecure.NewCredentials()
+	if useTLS {
+		creds = credentials.NewTLS(&tls.Config{MinVersion: tls.VersionTLS12})
+	}
+	p := &BrokerConnPool{}
+	for i := 0; i < size; i++ {
+		conn, err := grpc.NewClient(addr,
+			grpc.WithTransportCredentials(creds),
+			grpc.WithUnaryInterceptor(brokerCallMetrics),
+		)
+		if err != nil {
+			p.Close()
+			return nil, fmt.Errorf("dial broker gRPC %s: %w", addr, err)
+		}
+		p.conns = append(p.conns, conn)
+	}
+	return p, nil
+}
+
+func (p *BrokerConnPool) pick() *grpc.ClientConn {
+	return p.conns[int(p.next.Add(1))%len(p.conns)]
+}
+
+// Invoke implements grpc.ClientConnInterface.
+func (p *BrokerConnPool) Invoke(ctx context.Context, method string, args, reply any, opts ...grpc.CallOption) error {
+	return p.pick().Invoke(ctx, method, args, reply, opts...)
+}
+
+// NewStream implements grpc.ClientConnInterface.
+func (p *BrokerConnPool) NewStream(ctx context.Context, desc *grpc.StreamDesc, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	return p.pick().NewStream(ctx, desc, method, opts...)
+}
+
+// Close closes every connection in the pool.
+func (p *BrokerConnPool) Close() error {
+	var errs []error
+	for _, c := range p.conns {
+		errs = append(errs, c.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// brokerCallMetrics records Broker gRPC calls in the same metrics as REST
+// calls, with the outcome taken from the REST status the Broker reports.
+func brokerCallMetrics(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	op := "grpc " + method
+	start := time.Now()
+	err := invoker(ctx, method, req, reply, cc, opts...)
+	brokerDuration.WithLabelValues(op).Observe(time.Since(start).Seconds())
+	outcome := "2xx"
+	if err != nil {
+		outcome = "error"
+		if code, ok := brokerHTTPStatus(err); ok {
+			outcome = fmt.Sprintf("%dxx", code/100)
+		}
+	}
+	brokerRequests.WithLabelValues(op, outcome).Inc()
+	return err
+}
+
+// brokerRPCContext adds the Broker API key and the authenticated caller to
+// ctx as outgoing metadata. The caller's deadline propagates to the Broker;
+// without one the call gets the HTTP client's timeout, as REST calls do.
+func (h *Handler) brokerRPCContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	var md []string
+	if h.brokerAPIKey != "" {
+		md = append(md, "x-api-key", h.brokerAPIKey)
+	}
+	if c, ok := auth.CallerFrom(ctx); ok {
+		md = append(md, "x-nexus-caller", c.ID)
+	}
+	if len(md) > 0 {
+		ctx = metadata.AppendToOutgoingContext(ctx, md...)
+	}
+	if _, ok := ctx.Deadline(); ok || h.httpClient.Timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, h.httpClient.Timeout)
+}
+
+// brokerHTTPStatus returns the REST status carried by a Broker gRPC error.
+func brokerHTTPStatus(err error) (int, bool) {
+	st, ok := status.FromError(err)
+	if !ok {
+		return 0, false
+	}
+	for _, d := range st.Details() {
+		info, ok := d.(*errdetails.ErrorInfo)
+		if !ok || info.GetDomain() != brokerErrorDomain {
+			continue
+		}
+		if code, err := strconv.Atoi(info.GetMetadata()["http_status"]); err == nil {
+			return code, true
+		}
+	}
+	return 0, false
+}
+
+// brokerRPCError converts a Broker gRPC error into the error the REST path
+// returns for the same failure: a BrokerStatusError when the Broker
+// answered, ErrBrokerUnavailable when it could not be reached.
+func brokerRPCError(err error) error {
+	if code, ok := brokerHTTPStatus(err); ok {
+		return &BrokerStatusError{Status: code}
+	}
+	return fmt.Errorf("%w: %v", ErrBrokerUnavailable, err)
+}
+
+// rpcConsentSpec is the gRPC form of POST /auth/consent-spec.
+func (h *Handler) rpcConsentSpec(ctx context.Context, workspaceID, providerID string, scopes []string, returnURL, tenant, subject, sessionHash string) (*brokerpb.ConsentSpecResponse, error) {
+	ctx, cancel := h.brokerRPCContext(ctx)
+	defer cancel()
+	resp, err := h.brokerRPC.ConsentSpec(ctx, &brokerpb.ConsentSpecRequest{
+		WorkspaceId: workspaceID,
+		ProviderId:  providerID,
+		Scopes:      scopes,
+		ReturnUrl:   returnURL,
+		Tenant:      tenant,
+		Subject:     subject,
+		SessionHash: sessionHash,
+	})
+	if err != nil {
+		return nil, brokerRPCError(err)
+	}
+	return resp, nil
+}
+
+// rpcToken is the gRPC form of the token and refresh endpoints. Like
+// GetTokenCore it reports a Broker answer other than 200 as a status with a
+// nil error.
+func (h *Handler) rpcToken(ctx context.Context, connectionID string, refresh bool) (map[string]any, int, error) {
+	ctx, cancel := h.brokerRPCContext(ctx)
+	defer cancel()
+	var (
+		resp *brokerpb.TokenResponse
+		err  error
+	)
+	if refresh {
+		resp, err = h.brokerRPC.Refresh(ctx, &brokerpb.RefreshRequest{ConnectionId: connectionID})
+	} else {
+		resp, err = h.brokerRPC.GetToken(ctx, &brokerpb.GetTokenRequest{ConnectionId: connectionID})
+	}
+	if err != nil {
+		if code, ok := brokerHTTPStatus(err); ok {
+			return nil, code, nil
+		}
+		return nil, http.StatusBadGateway, fmt.Errorf("%w: broker request failed: %v", ErrBrokerUnavailable, err)
+	}
+	if resp.GetToken() == nil {
+		return nil, http.StatusOK, fmt.Errorf("%w: empty response", ErrBrokerInvalidResponse)
+	}
+	return tokenMap(resp.GetToken()), http.StatusOK, nil
+}
+
+// tokenMap is token in the form the REST endpoints' TokenResponse decodes
+// to, leaving out the fields the Broker did not set.
+func tokenMap(token *brokerpb.Token) map[string]any {
+	out := map[string]any{}
+	for key, v := range map[string]string{
+		"access_token":  token.GetAccessToken(),
+		"token_type":    token.GetTokenType(),
+		"refresh_token": token.GetRefreshToken(),
+		"id_token":      token.GetIdToken(),
+	} {
+		if v != "" {
+			out[key] = v
+		}
+	}
+	if token.GetExpiry() != nil {
+		out["expiry"] = token.GetExpiry().AsTime().Format(time.RFC3339Nano)
+	}
+	if strategy := token.GetStrategy(); strategy != nil {
+		m := map[string]any{"type": strategy.GetType()}
+		if strategy.GetConfig() != nil {
+			m["config"] = strategy.GetConfig().AsMap()
+		}
+		out["strategy"] = m
+	}
+	if token.GetCredentials() != nil {
+		out["credentials"] = token.GetCredentials().AsMap()
+	}
+	return out
+}
+
+// rpcResolveProvider is the gRPC form of GET /providers/by-name/{name}.
+func (h *Handler) rpcResolveProvider(ctx context.Context, workspaceID, name string) (string, error) {
+	ctx, cancel := h.brokerRPCContext(ctx)
+	defer cancel()
+	resp, err := h.brokerRPC.ResolveProvider(ctx, &brokerpb.ResolveProviderRequest{Name: name, WorkspaceId: workspaceID})
+	if err != nil {
+		err = brokerRPCError(err)
+		var be *BrokerStatusError
+		if errors.As(err, &be) && be.Status == http.StatusNotFound {
+			return "", fmt.Errorf("%w: %s", ErrProviderNotFound, name)
+		}
+		return "", err
+	}
+	if resp.GetProviderId() == "" {
+		return "", fmt.Errorf("%w: empty provider id", ErrBrokerInvalidResponse)
+	}
+	return resp.GetProviderId(), nil
+}
+
+// rpcMetadata is the gRPC form of GET /providers/metadata.
+func (h *Handler) rpcMetadata(ctx context.Context, workspaceID string) (map[string]any, error) {
+	ctx, cancel := h.brokerRPCContext(ctx)
+	defer cancel()
+	resp, err := h.brokerRPC.GetMetadata(ctx, &brokerpb.GetMetadataRequest{WorkspaceId: workspaceID})
+	if err != nil {
+		return nil, brokerRPCError(err)
+	}
+	// The REST document groups providers by auth type, then by name.
+	out := map[string]any{}
+	for _, p := range resp.GetProviders() {
+		byName, _ := out[p.GetAuthType()].(map[string]any)
+		if byName == nil {
+			byName = map[string]any{}
+			out[p.GetAuthType()] = byName
+		}
+		scopes := make([]any, len(p.GetScopes()))
+		for i, scope := range p.GetScopes() {
+			scopes[i] = scope
+		}
+		byName[p.GetName()] = map[string]any{
+			"id":                 p.GetId(),
+			"api_base_url":       p.GetApiBaseUrl(),
+			"user_info_endpoint": p.GetUserInfoEndpoint(),
+			"scopes":             scopes,
+			"description":        p.GetDescription(),
+			"category":           p.GetCategory(),
+		}
+	}
+	return out, nil
+}
+
+// rpcConnectionStatus is the gRPC form of GET /connections/{id}/status.
+func (h *Handler) rpcConnectionStatus(ctx context.Context, connectionID string) (ConnectionStatus, error) {
+	ctx, cancel := h.brokerRPCContext(ctx)
+	defer cancel()
+	resp, err := h.brokerRPC.GetConnectionStatus(ctx, &brokerpb.GetConnectionStatusRequest{ConnectionId: connectionID})
+	if err != nil {
+		if code, ok := brokerHTTPStatus(err); ok && (code == http.StatusNotFound || code == http.StatusBadRequest) {
+			return connectionNotFound, nil
+		}
+		return ConnectionStatus{}, brokerRPCError(err)
+	}
+	return ConnectionStatus{Status: resp.GetStatus(), Reason: resp.GetReason(), ErrorCode: resp.GetErrorCode()}, nil
+}
@@ -0,0 +1,201 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	brokerpb "github.com/Prescott-Data/nexus-framework/nexus-common/gen/go/api/proto/broker/v1"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-gateway/pkg/auth"
+)
+
+// fakeBrokerService answers like the Broker's gRPC API and records the
+// metadata and deadline of the last call.
+type fakeBrokerService struct {
+	brokerpb.UnimplementedBrokerServiceServer
+	t        *testing.T
+	key      []byte
+	md       metadata.MD
+	deadline bool
+}
+
+func (f *fakeBrokerService) record(ctx context.Context) {
+	f.md, _ = metadata.FromIncomingContext(ctx)
+	_, f.deadline = ctx.Deadline()
+}
+
+func (f *fakeBrokerService) ConsentSpec(ctx context.Context, req *brokerpb.ConsentSpecRequest) (*brokerpb.ConsentSpecResponse, error) {
+	f.record(ctx)
+	return &brokerpb.ConsentSpecResponse{
+		AuthUrl:    "https://idp.example/auth",
+		State:      generateState(f.t, f.key, req.GetWorkspaceId(), req.GetProviderId(), "conn-rpc"),
+		Scopes:     req.GetScopes(),
+		ProviderId: req.GetProviderId(),
+	}, nil
+}
+
+func (f *fakeBrokerService) GetToken(ctx context.Context, req *brokerpb.GetTokenRequest) (*brokerpb.TokenResponse, error) {
+	f.record(ctx)
+	if req.GetConnectionId() == "suspended" {
+		st, _ := status.New(codes.FailedPrecondition, "connection is suspended").WithDetails(&errdetails.ErrorInfo{
+			Reason: "connection_suspended", Domain: brokerErrorDomain, Metadata: map[string]string{"http_status": "423"},
+		})
+		return nil, st.Err()
+	}
+	if req.GetConnectionId() != "conn-1" {
+		st, _ := status.New(codes.NotFound, "connection not found").WithDetails(&errdetails.ErrorInfo{
+			Reason: "connection_not_found", Domain: brokerErrorDomain, Metadata: map[string]string{"http_status": "404"},
+		})
+		return nil, st.Err()
+	}
+	creds, _ := structpb.NewStruct(map[string]any{"access_token": "at-1"})
+	return &brokerpb.TokenResponse{Token: &brokerpb.Token{
+		AccessToken: "at-1",
+		Expiry:      timestamppb.New(time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)),
+		Strategy:    &brokerpb.AuthStrategy{Type: "oauth2"},
+		Credentials: creds,
+	}}, nil
+}
+
+func (f *fakeBrokerService) GetConnectionStatus(ctx context.Context, req *brokerpb.GetConnectionStatusRequest) (*brokerpb.ConnectionStatusResponse, error) {
+	f.record(ctx)
+	if req.GetConnectionId() != "conn-1" {
+		st, _ := status.New(codes.NotFound, "connection not found").WithDetails(&errdetails.ErrorInfo{
+			Reason: "connection_not_found", Domain: brokerErrorDomain, Metadata: map[string]string{"http_status": "404"},
+		})
+		return nil, st.Err()
+	}
+	return &brokerpb.ConnectionStatusResponse{Status: "active", Reason: "refresh_failing"}, nil
+}
+
+func (f *fakeBrokerService) ResolveProvider(ctx context.Context, req *brokerpb.ResolveProviderRequest) (*brokerpb.ResolveProviderResponse, error) {
+	f.record(ctx)
+	return &brokerpb.ResolveProviderResponse{ProviderId: "id-" + req.GetName()}, nil
+}
+
+func (f *fakeBrokerService) GetMetadata(ctx context.Context, req *brokerpb.GetMetadataRequest) (*brokerpb.GetMetadataResponse, error) {
+	f.record(ctx)
+	return &brokerpb.GetMetadataResponse{Providers: []*brokerpb.ProviderMetadata{
+		{Id: "p-1", Name: "google", AuthType: "oauth2", Scopes: []string{"openid"}, Category: req.GetWorkspaceId()},
+	}}, nil
+}
+
+// newRPCHandler returns a Handler whose BrokerService calls reach fake and
+// whose REST base URL is unusable, so any REST fallback fails the test.
+func newRPCHandler(t *testing.T, fake *fakeBrokerService) *Handler {
+	t.Helper()
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer()
+	brokerpb.RegisterBrokerServiceServer(srv, fake)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithUnaryInterceptor(brokerCallMetrics),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return NewHandler("http://127.0.0.1:1", testStates(t, fake.key), &http.Client{Timeout: 5 * time.Second},
+		WithBrokerAPIKey("k1"), WithBrokerGRPC(conn))
+}
+
+func TestBrokerGRPC_CoreCalls(t *testing.T) {
+	fake := &fakeBrokerService{t: t, key: []byte("12345678901234567890123456789012")}
+	h := newRPCHandler(t, fake)
+	ctx := auth.WithCaller(context.Background(), &auth.Caller{ID: "svc-a"})
+
+	out, err := h.RequestConnectionCore(ctx, RequestConnectionInput{UserID: "ws1", ProviderName: "google", Scopes: []string{"email"}})
+	if err != nil {
+		t.Fatalf("RequestConnectionCore: %v", err)
+	}
+	if out.ConnectionID != "conn-rpc" || out.ProviderID != "id-google" || out.AuthURL != "https://idp.example/auth" {
+		t.Errorf("RequestConnectionCore = %+v", out)
+	}
+	if got := fake.md.Get("x-api-key"); len(got) != 1 || got[0] != "k1" {
+		t.Errorf("x-api-key = %v", got)
+	}
+	if got := fake.md.Get("x-nexus-caller"); len(got) != 1 || got[0] != "svc-a" {
+		t.Errorf("x-nexus-caller = %v", got)
+	}
+	if !fake.deadline {
+		t.Error("call without a deadline did not get the client timeout")
+	}
+
+	tok, code, err := h.GetTokenCore(ctx, "conn-1")
+	if err != nil || code != http.StatusOK || tok["access_token"] != "at-1" || tok["expiry"] != "2030-01-02T03:04:05Z" {
+		t.Errorf("GetTokenCore = %v, %d, %v", tok, code, err)
+	}
+	if tok["strategy"].(map[string]any)["type"] != "oauth2" || tok["credentials"].(map[string]any)["access_token"] != "at-1" {
+		t.Errorf("GetTokenCore strategy, credentials = %v, %v", tok["strategy"], tok["credentials"])
+	}
+	if _, ok := tok["refresh_token"]; ok {
+		t.Errorf("GetTokenCore refresh_token = %v, want none", tok["refresh_token"])
+	}
+	if _, code, err := h.GetTokenCore(ctx, "missing"); err != nil || code != http.StatusNotFound {
+		t.Errorf("GetTokenCore(missing) = %d, %v; want 404 and no error", code, err)
+	}
+	if st, err := h.CheckConnectionCore(ctx, "conn-1"); err != nil || st != (ConnectionStatus{Status: "active", Reason: "refresh_failing"}) {
+		t.Errorf("CheckConnectionCore = %+v, %v", st, err)
+	}
+	if st, err := h.CheckConnectionCore(ctx, "missing"); err != nil || st != connectionNotFound {
+		t.Errorf("CheckConnectionCore(missing) = %+v, %v", st, err)
+	}
+
+	md, err := h.GetProvidersCore(ctx, "ws1")
+	if err != nil {
+		t.Fatalf("GetProvidersCore: %v", err)
+	}
+	google := md["oauth2"].(map[string]any)["google"].(map[string]any)
+	if google["id"] != "p-1" || google["category"] != "ws1" || google["scopes"].([]any)[0] != "openid" {
+		t.Errorf("GetProvidersCore google = %v", google)
+	}
+}
+
+func TestBrokerGRPC_GetTokenForwardsStatus(t *testing.T) {
+	h := newRPCHandler(t, &fakeBrokerService{t: t, key: []byte("12345678901234567890123456789012")})
+
+	w := httptest.NewRecorder()
+	h.GetToken(w, httptest.NewRequest(http.MethodGet, "/v1/token/missing", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want 404", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	h.GetToken(w, httptest.NewRequest(http.MethodGet, "/v1/token/suspended", nil))
+	if w.Code != http.StatusLocked || !strings.Contains(w.Body.String(), "connection_suspended") {
+		t.Errorf("suspended: status = %d body = %s, want 423 connection_suspended", w.Code, w.Body.String())
+	}
+}
+
+func TestBrokerRPCError(t *testing.T) {
+	answered, _ := status.New(codes.PermissionDenied, "invalid api key").WithDetails(&errdetails.ErrorInfo{
+		Reason: "invalid_api_key", Domain: brokerErrorDomain, Metadata: map[string]string{"http_status": "403"},
+	})
+	var be *BrokerStatusError
+	if err := brokerRPCError(answered.Err()); !errors.As(err, &be) || be.Status != http.StatusForbidden {
+		t.Errorf("answered error = %v, want BrokerStatusError 403", err)
+	}
+	if err := brokerRPCError(status.Error(codes.Unavailable, "connection refused")); !errors.Is(err, ErrBrokerUnavailable) {
+		t.Errorf("transport error = %v, want ErrBrokerUnavailable", err)
+	}
+}
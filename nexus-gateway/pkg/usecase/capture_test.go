@@ -0,0 +1,62 @@
+package usecase
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCaptureCredential(t *testing.T) {
+	var gotType, gotLanguage, gotBody string
+	broker := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		gotType, gotLanguage, gotBody = r.Header.Get("Content-Type"), r.Header.Get("Accept-Language"), string(body)
+		if strings.Contains(gotBody, "bad") {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Header().Set("Content-Language", "de")
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("<p>Ungültig</p>"))
+			return
+		}
+		http.Redirect(w, r, "http://localhost:3000/cb?status=success&connection_id=conn-1", http.StatusFound)
+	}))
+	defer broker.Close()
+	h := NewHandler(broker.URL, testStates(t, []byte("12345678901234567890123456789012")), nil)
+
+	post := func(contentType, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/v1/capture-credential", strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		req.Header.Set("Accept-Language", "de")
+		w := httptest.NewRecorder()
+		h.CaptureCredential(w, req)
+		return w
+	}
+
+	// API clients get the result as JSON.
+	w := post("application/json", `{"state":"s","credentials":{"api_key":"k"}}`)
+	var result map[string]any
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil || w.Code != http.StatusOK || result["connection_id"] != "conn-1" {
+		t.Fatalf("json post = %d %s", w.Code, w.Body.String())
+	}
+	if gotType != "application/json" {
+		t.Errorf("broker Content-Type = %q", gotType)
+	}
+
+	// The hosted form's browser follows the redirect itself.
+	w = post("application/x-www-form-urlencoded", "state=s&api_key=k")
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "http://localhost:3000/cb?status=success&connection_id=conn-1" {
+		t.Fatalf("form post = %d %v", w.Code, w.Header())
+	}
+	if gotType != "application/x-www-form-urlencoded" || gotLanguage != "de" || gotBody != "state=s&api_key=k" {
+		t.Errorf("broker got %q %q %q", gotType, gotLanguage, gotBody)
+	}
+
+	// and sees the error page.
+	w = post("application/x-www-form-urlencoded", "state=bad")
+	if w.Code != http.StatusBadRequest || w.Header().Get("Content-Language") != "de" || w.Body.String() != "<p>Ungültig</p>" {
+		t.Errorf("form error = %d %v %s", w.Code, w.Header(), w.Body.String())
+	}
+}
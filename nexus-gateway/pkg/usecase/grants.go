@@ -0,0 +1,146 @@
+package usecase
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-gateway/pkg/logging"
+)
+
+// CreateGrantCore asks the broker for a single-use grant to a connection's
+// access token. body is the broker's grant request. A non-201 broker answer
+// is returned with its status and body.
+func (h *Handler) CreateGrantCore(ctx context.Context, connectionID string, body []byte) (map[string]any, int, []byte, error) {
+	return h.postBroker(ctx, "/connections/"+url.PathEscape(connectionID)+"/grants", body, http.StatusCreated)
+}
+
+// RedeemGrantCore redeems a grant at the broker for the access token it
+// stands for. A non-200 broker answer is returned with its status and body.
+func (h *Handler) RedeemGrantCore(ctx context.Context, grant string) (map[string]any, int, []byte, error) {
+	body, err := json.Marshal(map[string]string{"grant": grant})
+	if err != nil {
+		return nil, http.StatusInternalServerError, nil, err
+	}
+	return h.postBroker(ctx, "/grants/redeem", body, http.StatusOK)
+}
+
+// postBroker posts a JSON body to the broker and decodes a JSON object
+// answered with the expected status.
+func (h *Handler) postBroker(ctx context.Context, path string, body []byte, expected int) (map[string]any, int, []byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.brokerBaseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, http.StatusInternalServerError, nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	setCaller(ctx, req)
+	if h.brokerAPIKey != "" {
+		req.Header.Set("X-API-Key", h.brokerAPIKey)
+	}
+	resp, err := h.httpClient.Do(req)
+	if err != nil {
+		return nil, http.StatusBadGateway, nil, fmt.Errorf("%w: broker request failed: %v", ErrBrokerUnavailable, err)
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, http.StatusBadGateway, nil, fmt.Errorf("%w: broker request failed: %v", ErrBrokerUnavailable, err)
+	}
+	if resp.StatusCode != expected {
+		return nil, resp.StatusCode, respBody, nil
+	}
+	var out map[string]any
+	if err := json.Unmarshal(respBody, &out); err != nil || out == nil {
+		return nil, resp.StatusCode, nil, fmt.Errorf("%w: %s", ErrBrokerInvalidResponse, path)
+	}
+	return out, resp.StatusCode, nil, nil
+}
+
+// CreateGrant issues a short-lived grant to a connection's access token,
+// which a downstream service redeems once with RedeemGrant. The agent
+// keeps the connection; the service only ever sees an access token. The
+// body is optional; when present it is passed to the broker as is.
+func (h *Handler) CreateGrant(w http.ResponseWriter, r *http.Request) {
+	connectionID := strings.TrimSpace(chi.URLParam(r, "connectionID"))
+	if connectionID == "" {
+		writeError(w, http.StatusBadRequest, "missing_fields", "missing connection id", nil)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	var members map[string]json.RawMessage
+	switch {
+	case err != nil:
+		writeError(w, http.StatusBadRequest, "read_error", "failed to read request body", nil)
+		return
+	case len(bytes.TrimSpace(body)) == 0:
+		body = []byte("{}")
+	case json.Unmarshal(body, &members) != nil || members == nil:
+		writeError(w, http.StatusBadRequest, "invalid_json", "body must be a JSON object", nil)
+		return
+	}
+
+	logging.Info(r.Context(), "create_grant.start", map[string]any{"connection_id": connectionID})
+
+	grant, status, brokerBody, err := h.CreateGrantCore(r.Context(), connectionID, body)
+	if err != nil {
+		logging.Error(r.Context(), "create_grant.broker_error", map[string]any{"error": err.Error()})
+		writeError(w, status, "broker_unavailable", "broker request failed", nil)
+		return
+	}
+	if status != http.StatusCreated {
+		logging.Error(r.Context(), "create_grant.broker_status", map[string]any{"status": status})
+		if status == http.StatusLocked {
+			writeError(w, status, "connection_suspended", "connection is suspended", nil)
+			return
+		}
+		writeBrokerError(w, status, brokerBody)
+		return
+	}
+
+	logging.Info(r.Context(), "create_grant.success", map[string]any{"connection_id": connectionID, "grant_id": grant["grant_id"]})
+	writeJSON(w, http.StatusCreated, grant)
+}
+
+// RedeemGrant trades a grant for the access token it stands for, once.
+// The grant authenticates the request, so the route is exempt from caller
+// authentication by default.
+func (h *Handler) RedeemGrant(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Grant string `json:"grant"`
+	}
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1<<16)).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_json", "invalid JSON body", nil)
+		return
+	}
+	if strings.TrimSpace(req.Grant) == "" {
+		writeError(w, http.StatusBadRequest, "missing_fields", "grant is required", nil)
+		return
+	}
+
+	token, status, brokerBody, err := h.RedeemGrantCore(r.Context(), strings.TrimSpace(req.Grant))
+	if err != nil {
+		logging.Error(r.Context(), "redeem_grant.broker_error", map[string]any{"error": err.Error()})
+		writeError(w, status, "broker_unavailable", "broker request failed", nil)
+		return
+	}
+	if status != http.StatusOK {
+		logging.Error(r.Context(), "redeem_grant.broker_status", map[string]any{"status": status})
+		if status == http.StatusLocked {
+			writeError(w, status, "connection_suspended", "connection is suspended", nil)
+			return
+		}
+		writeBrokerError(w, status, brokerBody)
+		return
+	}
+
+	logging.Info(r.Context(), "redeem_grant.success", map[string]any{"connection_id": token["connection_id"], "grant_id": token["grant_id"]})
+	writeJSON(w, http.StatusOK, token)
+}
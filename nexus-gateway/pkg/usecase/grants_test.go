@@ -0,0 +1,80 @@
+package usecase
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func TestGrants(t *testing.T) {
+	var got map[string]any
+	redeemed := false
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /connections/conn-1/grants", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-API-Key") != "k1" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		got = nil
+		json.NewDecoder(r.Body).Decode(&got)
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]any{"grant": "nxg.g-1.mac", "grant_id": "g-1", "connection_id": "conn-1"})
+	})
+	mux.HandleFunc("POST /grants/redeem", func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]string
+		json.NewDecoder(r.Body).Decode(&body)
+		if body["grant"] != "nxg.g-1.mac" || redeemed {
+			w.Header().Set("Content-Type", "application/problem+json")
+			w.WriteHeader(http.StatusConflict)
+			w.Write([]byte(`{"code": "grant_already_redeemed", "detail": "The grant was already redeemed"}`))
+			return
+		}
+		redeemed = true
+		json.NewEncoder(w).Encode(map[string]any{"access_token": "at", "connection_id": "conn-1", "grant_id": "g-1"})
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	h := NewHandler(server.URL, testStates(t, []byte("12345678901234567890123456789012")), nil, WithBrokerAPIKey("k1"))
+	router := chi.NewRouter()
+	router.Post("/v1/grant/{connectionID}", h.CreateGrant)
+	router.Post("/v1/redeem-grant", h.RedeemGrant)
+
+	post := func(path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("POST", path, bytes.NewBufferString(body)))
+		return w
+	}
+
+	w := post("/v1/grant/conn-1", `{"scopes": ["read"], "ttl_seconds": 60}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create grant = %d %s", w.Code, w.Body.String())
+	}
+	var grant map[string]any
+	json.NewDecoder(w.Body).Decode(&grant)
+	if grant["grant"] != "nxg.g-1.mac" || got["ttl_seconds"] != float64(60) {
+		t.Errorf("grant = %v, broker received %v", grant, got)
+	}
+	if w := post("/v1/grant/conn-1", "[]"); w.Code != http.StatusBadRequest {
+		t.Errorf("create grant with array body = %d", w.Code)
+	}
+
+	if w := post("/v1/redeem-grant", `{}`); w.Code != http.StatusBadRequest {
+		t.Errorf("redeem without grant = %d", w.Code)
+	}
+	w = post("/v1/redeem-grant", `{"grant": "nxg.g-1.mac"}`)
+	var token map[string]any
+	json.NewDecoder(w.Body).Decode(&token)
+	if w.Code != http.StatusOK || token["access_token"] != "at" {
+		t.Fatalf("redeem = %d %v", w.Code, token)
+	}
+	w = post("/v1/redeem-grant", `{"grant": "nxg.g-1.mac"}`)
+	var problem map[string]any
+	json.NewDecoder(w.Body).Decode(&problem)
+	if w.Code != http.StatusConflict || problem["code"] != "grant_already_redeemed" {
+		t.Errorf("second redeem = %d %v", w.Code, problem)
+	}
+}
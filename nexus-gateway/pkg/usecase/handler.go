@@ -2,22 +2,30 @@ package usecase
 
 import (
 	"bytes"
+	"cmp"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"mime"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
-	"os"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
+	"google.golang.org/grpc"
 
+	brokerpb "github.com/Prescott-Data/nexus-framework/nexus-common/gen/go/api/proto/broker/v1"
+	"github.com/Prescott-Data/nexus-framework/nexus-common/problem"
+	"github.com/Prescott-Data/nexus-framework/nexus-common/state"
+	"github.com/Prescott-Data/nexus-framework/nexus-sdk/redact"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-gateway/pkg/auth"
 	"github.com/Prescott-Data/nexus-framework/nexus-gateway/pkg/broker"
 	"github.com/Prescott-Data/nexus-framework/nexus-gateway/pkg/logging"
 )
@@ -43,8 +51,8 @@ func (e *BrokerStatusError) Error() string { return fmt.Sprintf("broker status %
 func writeJSON(w http.ResponseWriter, status int, v any) {
 	data, err := json.Marshal(v)
 	if err != nil {
-		http.Error(w, `{"error":"internal server error"}`, http.StatusInternalServerError)
 		logging.Error(context.Background(), "writeJSON.marshal_failed", map[string]any{"error": err.Error()})
+		writeError(w, http.StatusInternalServerError, "internal_error", "internal server error", nil)
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
@@ -52,25 +60,55 @@ func writeJSON(w http.ResponseWriter, status int, v any) {
 	_, _ = w.Write(data)
 }
 
-// writeError writes a structured error body
+// writeError writes the standard error body, RFC 9457 problem details with
+// fields as extension members. The message and string fields often carry
+// broker or provider error text, so they are scrubbed of credentials first.
 func writeError(w http.ResponseWriter, status int, code, message string, fields map[string]any) {
-	body := map[string]any{
-		"error":   code,
-		"message": message,
-	}
+	p := problem.New(status, code, redact.String(message))
 	for k, v := range fields {
-		body[k] = v
+		if s, ok := v.(string); ok {
+			v = redact.String(s)
+		}
+		p.With(k, v)
 	}
-	writeJSON(w, status, body)
+	problem.Write(w, p)
+}
+
+// writeBrokerError answers with the Broker's error status, keeping the code
+// and detail of its problem details body. Bodies without a code are
+// reported as broker_error.
+func writeBrokerError(w http.ResponseWriter, status int, body []byte) {
+	var be struct {
+		Code    string `json:"code"`
+		Error   string `json:"error"`
+		Detail  string `json:"detail"`
+		Message string `json:"message"`
+	}
+	code, msg := "broker_error", fmt.Sprintf("broker returned status %d", status)
+	if err := json.Unmarshal(body, &be); err == nil {
+		if c := cmp.Or(be.Code, be.Error); c != "" {
+			code = c
+		}
+		msg = cmp.Or(be.Detail, be.Message, msg)
+	} else if len(body) > 0 {
+		msg = string(body)
+	}
+	writeError(w, status, code, msg, nil)
 }
 
 type Handler struct {
 	brokerBaseURL string
-	stateKey      []byte
+	states        *state.Keyring
 	brokerClient  *broker.ClientWithResponses
+	brokerRPC     brokerpb.BrokerServiceClient // nil uses REST
+	httpClient    *http.Client
 	providerCache map[string]providerCacheEntry
 	cacheMu       sync.RWMutex
 	brokerAPIKey  string
+	wsProxy       wsProxyConfig
+	metadataCache *metadataCache // nil when disabled
+	// insecureSessionCookie drops Secure from the session cookie.
+	insecureSessionCookie bool
 }
 
 type providerCacheEntry struct {
@@ -78,13 +116,50 @@ type providerCacheEntry struct {
 	expiresAt  time.Time
 }
 
-func NewHandler(brokerBaseURL string, stateKey []byte, httpClient *http.Client) *Handler {
+// providerCacheTTL bounds how long a resolved provider name is reused before
+// the broker is asked again. InvalidateProviderCache clears it early.
+const providerCacheTTL = 5 * time.Minute
+
+// HandlerOption configures optional Handler settings.
+type HandlerOption func(*handlerOptions)
+
+type handlerOptions struct {
+	brokerAPIKey  string
+	brokerConn    grpc.ClientConnInterface
+	wsProxy       wsProxyConfig
+	metadataCache *metadataCache
+	// insecureSessionCookie drops Secure from the session cookie.
+	insecureSessionCookie bool
+}
+
+// WithBrokerAPIKey sets the X-API-Key sent on every Broker request.
+func WithBrokerAPIKey(key string) HandlerOption {
+	return func(o *handlerOptions) { o.brokerAPIKey = strings.TrimSpace(key) }
+}
+
+// setCaller forwards the authenticated caller, if any, so the Broker can
+// attribute audit events to it.
+func setCaller(ctx context.Context, req *http.Request) {
+	if c, ok := auth.CallerFrom(ctx); ok {
+		req.Header.Set(auth.CallerHeader, c.ID)
+	}
+}
+
+// NewHandler returns a Handler for the Broker at brokerBaseURL that checks
+// Broker-issued state with states.
+func NewHandler(brokerBaseURL string, states *state.Keyring, httpClient *http.Client, opts ...HandlerOption) *Handler {
 	if httpClient == nil {
 		httpClient = &http.Client{Timeout: 30 * time.Second}
 	}
+	httpClient = instrumentClient(httpClient)
+
+	var o handlerOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
 
 	baseURL := strings.TrimRight(brokerBaseURL, "/")
-	apiKey := strings.TrimSpace(getEnv("BROKER_API_KEY", ""))
+	apiKey := o.brokerAPIKey
 
 	// Create the generated client
 	client, err := broker.NewClientWithResponses(baseURL,
@@ -93,6 +168,7 @@ func NewHandler(brokerBaseURL string, stateKey []byte, httpClient *http.Client)
 			if apiKey != "" {
 				req.Header.Set("X-API-Key", apiKey)
 			}
+			setCaller(ctx, req)
 			return nil
 		}),
 	)
@@ -102,20 +178,22 @@ func NewHandler(brokerBaseURL string, stateKey []byte, httpClient *http.Client)
 		panic(fmt.Errorf("failed to create broker client: %w", err))
 	}
 
-	return &Handler{
+	h := &Handler{
 		brokerBaseURL: baseURL,
-		stateKey:      stateKey,
+		states:        states,
 		brokerClient:  client,
+		httpClient:    httpClient,
 		providerCache: make(map[string]providerCacheEntry),
 		brokerAPIKey:  apiKey,
-	}
-}
+		wsProxy:       o.wsProxy,
+		metadataCache: o.metadataCache,
 
-func getEnv(key, fallback string) string {
-	if v := strings.TrimSpace(os.Getenv(key)); v != "" {
-		return v
+		insecureSessionCookie: o.insecureSessionCookie,
 	}
-	return fallback
+	if o.brokerConn != nil {
+		h.brokerRPC = brokerpb.NewBrokerServiceClient(o.brokerConn)
+	}
+	return h
 }
 
 // requestConnectionRequest is input for initiating a connection
@@ -126,6 +204,9 @@ type requestConnectionRequest struct {
 	Scopes       []string `json:"scopes"`
 	ReturnURL    string   `json:"return_url"`
 	Action       string   `json:"action"`
+	Tenant       string   `json:"tenant,omitempty"`
+	Subject      string   `json:"subject,omitempty"`
+	BindSession  bool     `json:"bind_session,omitempty"`
 }
 
 // requestConnectionResponse mirrors broker consentSpec plus connection_id
@@ -146,6 +227,16 @@ type RequestConnectionInput struct {
 	Scopes       []string
 	ReturnURL    string
 	Action       string
+	// Tenant is the Azure AD tenant to authorize against (common,
+	// organizations, consumers, a tenant ID or a domain). Empty uses the
+	// provider's configured tenant.
+	Tenant string
+	// Subject is the email of the user a service account connection acts
+	// as through domain-wide delegation.
+	Subject string
+	// SessionHash binds the flow to the browser session it hashes (see
+	// state.HashSession): only that browser can complete it.
+	SessionHash string
 }
 
 type RequestConnectionOutput struct {
@@ -164,15 +255,28 @@ func (h *Handler) RequestConnectionCore(ctx context.Context, in RequestConnectio
 		"scopes":        in.Scopes,
 		"return_url":    in.ReturnURL,
 		"user_id":       in.UserID,
+		"tenant":        in.Tenant,
+		"subject":       in.Subject,
 	})
 
+	if err := validateRequestConnection(in); err != nil {
+		return RequestConnectionOutput{}, err
+	}
+
 	// Resolve provider_id when only provider_name is provided
 	providerID := strings.TrimSpace(in.ProviderID)
 	if providerID == "" {
 		if strings.TrimSpace(in.ProviderName) == "" {
 			return RequestConnectionOutput{}, fmt.Errorf("%w: provider_id or provider_name is required", ErrMissingFields)
 		}
-		id, err := h.resolveProviderID(ctx, in.ProviderName)
+		// A caller resolves the names of the providers visible to the
+		// connection's workspace only if it acts for that workspace, and
+		// otherwise those of global providers.
+		workspaceID, err := auth.Workspace(ctx, in.UserID)
+		if err != nil {
+			workspaceID = ""
+		}
+		id, err := h.resolveProviderID(ctx, workspaceID, in.ProviderName)
 		if err != nil {
 			return RequestConnectionOutput{}, err
 		}
@@ -197,31 +301,11 @@ func (h *Handler) RequestConnectionCore(ctx context.Context, in RequestConnectio
 		}
 	}
 
-	// Call Broker using generated client
-	reqBody := broker.ConsentSpecRequest{
-		WorkspaceId: in.UserID,
-		ProviderId:  &providerID,
-		Scopes:      &in.Scopes,
-		ReturnUrl:   in.ReturnURL,
-	}
-
-	resp, err := h.brokerClient.PostAuthConsentSpecWithResponse(ctx, reqBody)
+	spec, err := h.consentSpec(ctx, in.UserID, providerID, in.Scopes, in.ReturnURL, in.Tenant, in.Subject, in.SessionHash)
 	if err != nil {
-		logging.Error(ctx, "request_connection.core_broker_error", map[string]any{"error": err.Error()})
-		return RequestConnectionOutput{}, fmt.Errorf("%w: %v", ErrBrokerUnavailable, err)
+		return RequestConnectionOutput{}, err
 	}
 
-	if resp.StatusCode() != http.StatusOK {
-		logging.Error(ctx, "request_connection.core_broker_status", map[string]any{"status": resp.StatusCode()})
-		return RequestConnectionOutput{}, &BrokerStatusError{Status: resp.StatusCode()}
-	}
-
-	if resp.JSON200 == nil {
-		logging.Error(ctx, "request_connection.core_empty_response", nil)
-		return RequestConnectionOutput{}, fmt.Errorf("%w: empty response", ErrBrokerInvalidResponse)
-	}
-	spec := resp.JSON200
-
 	// The generated struct fields might be pointers if nullable in YAML.
 	// In our YAML, they are strings (not nullable). oapi-codegen usually generates pointers for optional fields.
 	// Checking yaml: fields are not 'required' in the response schema?
@@ -239,11 +323,12 @@ func (h *Handler) RequestConnectionCore(ctx context.Context, in RequestConnectio
 		authURL = *spec.AuthUrl
 	}
 
-	connectionID, err := VerifyAndExtractConnectionID(h.stateKey, state)
+	verified, err := h.states.Verify(state)
 	if err != nil {
 		logging.Error(ctx, "request_connection.core_state_invalid", map[string]any{"error": err.Error()})
 		return RequestConnectionOutput{}, fmt.Errorf("%w: %v", ErrInvalidState, err)
 	}
+	connectionID := verified.Nonce
 
 	var scopes []string
 	if spec.Scopes != nil {
@@ -270,21 +355,112 @@ func (h *Handler) RequestConnectionCore(ctx context.Context, in RequestConnectio
 	return out, nil
 }
 
-// resolveProviderID looks up the provider_id by a human-friendly provider name via the broker.
-func (h *Handler) resolveProviderID(ctx context.Context, providerName string) (string, error) {
+// consentSpec asks the broker to start a consent, over gRPC when configured.
+func (h *Handler) consentSpec(ctx context.Context, workspaceID, providerID string, scopes []string, returnURL, tenant, subject, sessionHash string) (*broker.ConsentSpecResponse, error) {
+	if h.brokerRPC != nil {
+		resp, err := h.rpcConsentSpec(ctx, workspaceID, providerID, scopes, returnURL, tenant, subject, sessionHash)
+		if err != nil {
+			logging.Error(ctx, "request_connection.core_broker_error", map[string]any{"error": err.Error()})
+			return nil, err
+		}
+		return &broker.ConsentSpecResponse{
+			AuthUrl:    &resp.AuthUrl,
+			State:      &resp.State,
+			Scopes:     &resp.Scopes,
+			ProviderId: &resp.ProviderId,
+		}, nil
+	}
+
+	// Call Broker using generated client
+	reqBody := broker.ConsentSpecRequest{
+		WorkspaceId: workspaceID,
+		ProviderId:  &providerID,
+		Scopes:      &scopes,
+		ReturnUrl:   returnURL,
+	}
+	if tenant != "" {
+		reqBody.Tenant = &tenant
+	}
+	if subject != "" {
+		reqBody.Subject = &subject
+	}
+	if sessionHash != "" {
+		reqBody.SessionHash = &sessionHash
+	}
+
+	resp, err := h.brokerClient.PostAuthConsentSpecWithResponse(ctx, reqBody)
+	if err != nil {
+		logging.Error(ctx, "request_connection.core_broker_error", map[string]any{"error": err.Error()})
+		return nil, fmt.Errorf("%w: %v", ErrBrokerUnavailable, err)
+	}
+
+	if resp.StatusCode() != http.StatusOK {
+		logging.Error(ctx, "request_connection.core_broker_status", map[string]any{"status": resp.StatusCode()})
+		return nil, &BrokerStatusError{Status: resp.StatusCode()}
+	}
+
+	if resp.JSON200 == nil {
+		logging.Error(ctx, "request_connection.core_empty_response", nil)
+		return nil, fmt.Errorf("%w: empty response", ErrBrokerInvalidResponse)
+	}
+	return resp.JSON200, nil
+}
+
+// resolveProviderID looks up the provider_id by a human-friendly provider name
+// among the providers visible to workspaceID (global ones only when empty),
+// serving from the provider cache when possible.
+func (h *Handler) resolveProviderID(ctx context.Context, workspaceID, providerName string) (string, error) {
 	name := strings.TrimSpace(providerName)
 	if name == "" {
 		return "", fmt.Errorf("empty provider_name")
 	}
+	key := workspaceID + "/" + strings.ToLower(name)
+
+	h.cacheMu.RLock()
+	entry, ok := h.providerCache[key]
+	h.cacheMu.RUnlock()
+	if ok && time.Now().Before(entry.expiresAt) {
+		providerCacheLookups.WithLabelValues("hit").Inc()
+		return entry.providerID, nil
+	}
+	providerCacheLookups.WithLabelValues("miss").Inc()
+
+	id, err := h.lookupProviderID(ctx, workspaceID, name)
+	if err != nil {
+		return "", err
+	}
+	h.cacheMu.Lock()
+	h.providerCache[key] = providerCacheEntry{providerID: id, expiresAt: time.Now().Add(providerCacheTTL)}
+	h.cacheMu.Unlock()
+	return id, nil
+}
+
+// InvalidateProviderCache drops every cached provider name -> ID mapping so
+// renamed or re-registered providers are picked up immediately. It returns
+// the number of entries removed.
+func (h *Handler) InvalidateProviderCache() int {
+	h.cacheMu.Lock()
+	defer h.cacheMu.Unlock()
+	n := len(h.providerCache)
+	h.providerCache = make(map[string]providerCacheEntry)
+	return n
+}
+
+// lookupProviderID asks the broker for the provider_id matching name among
+// the providers visible to workspaceID.
+func (h *Handler) lookupProviderID(ctx context.Context, workspaceID, name string) (string, error) {
+	if h.brokerRPC != nil {
+		return h.rpcResolveProvider(ctx, workspaceID, name)
+	}
 
 	// Try canonical by-name endpoint
-	resp, err := h.brokerClient.GetProvidersByNameNameWithResponse(ctx, name)
+	resp, err := h.brokerClient.GetProvidersByNameNameWithResponse(ctx, name, withWorkspaceID(workspaceID))
 	if err == nil && resp.StatusCode() == http.StatusOK && resp.JSON200 != nil && resp.JSON200.Id != nil {
 		return *resp.JSON200.Id, nil
 	}
 
 	// Fallback: list and filter
-	listResp, err := h.brokerClient.GetProvidersWithResponse(ctx)
+	listResp, err := h.brokerClient.GetProvidersWithResponse(ctx, withWorkspaceID(workspaceID))
 	if err != nil {
 		return "", fmt.Errorf("%w: %v", ErrBrokerUnavailable, err)
 	}
@@ -317,21 +493,172 @@ func (h *Handler) resolveProviderID(ctx context.Context, providerName string) (s
 	return matchedID, nil
 }
 
-// CheckConnectionCore probes broker token endpoint to infer status.
-func (h *Handler) CheckConnectionCore(ctx context.Context, connectionID string) (string, error) {
-	// We use the GetToken endpoint to check existence
-	resp, err := h.brokerClient.GetConnectionsConnectionIDTokenWithResponse(ctx, connectionID)
+// ConnectionStatus is a connection's status as reported by the broker:
+// pending, active, needs_reauth, suspended, revoked, ... Reason tells a
+// temporary problem (refresh_failing) from one the user must fix
+// (reauth_required, consent_expired, ...).
+type ConnectionStatus struct {
+	Status string `json:"status"`
+	Reason string `json:"reason"`
+	// ErrorCode is why a failed consent failed, the code its return_url
+	// was given, e.g. token_exchange_failed.
+	ErrorCode string `json:"error_code,omitempty"`
+}
+
+// connectionNotFound is reported for a connection the broker does not know.
+var connectionNotFound = ConnectionStatus{Status: "failed", Reason: "not_found"}
+
+// CheckConnectionCore asks the broker for a connection's status. An unknown
+// or malformed connection ID reports failed with reason not_found.
+func (h *Handler) CheckConnectionCore(ctx context.Context, connectionID string) (ConnectionStatus, error) {
+	if h.brokerRPC != nil {
+		return h.rpcConnectionStatus(ctx, connectionID)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, h.brokerBaseURL+"/connections/"+url.PathEscape(connectionID)+"/status", nil)
+	if err != nil {
+		return ConnectionStatus{}, err
+	}
+	setCaller(ctx, req)
+	if h.brokerAPIKey != "" {
+		req.Header.Set("X-API-Key", h.brokerAPIKey)
+	}
+	resp, err := h.httpClient.Do(req)
+	if err != nil {
+		return ConnectionStatus{}, fmt.Errorf("%w: %v", ErrBrokerUnavailable, err)
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusBadRequest:
+		return connectionNotFound, nil
+	case resp.StatusCode != http.StatusOK:
+		return ConnectionStatus{}, &BrokerStatusError{Status: resp.StatusCode}
+	}
+	var out ConnectionStatus
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil || out.Status == "" {
+		return ConnectionStatus{}, fmt.Errorf("%w: connection status", ErrBrokerInvalidResponse)
+	}
+	return out, nil
+}
+
+// PingBroker checks that the broker is reachable and reports healthy.
+func (h *Handler) PingBroker(ctx context.Context) error {
+	resp, err := h.brokerClient.GetHealthWithResponse(ctx)
 	if err != nil {
-		return "", fmt.Errorf("broker request failed: %w", err)
+		return fmt.Errorf("%w: %v", ErrBrokerUnavailable, err)
 	}
+	if resp.StatusCode() != http.StatusOK {
+		return &BrokerStatusError{Status: resp.StatusCode()}
+	}
+	return nil
+}
+
+// BrokerDependency is one entry of the Broker's /readyz report.
+type BrokerDependency struct {
+	Status    string `json:"status"`
+	Critical  bool   `json:"critical"`
+	LatencyMS int64  `json:"latency_ms"`
+	Error     string `json:"error,omitempty"`
+}
 
-	status := "pending"
-	if resp.StatusCode() == http.StatusOK {
-		status = "active"
-	} else if resp.StatusCode() >= 400 && resp.StatusCode() < 500 {
-		status = "failed"
+// BrokerHealth is the Broker's self-reported state: its build version and
+// readiness ("ready", "degraded" or "unavailable") with per-dependency
+// status such as postgres and redis.
+type BrokerHealth struct {
+	Version      string                      `json:"version,omitempty"`
+	Readiness    string                      `json:"status"`
+	Dependencies map[string]BrokerDependency `json:"dependencies"`
+}
+
+// BrokerHealth fetches the Broker's version from /health and its dependency
+// status from /readyz. An error means the Broker is unreachable or /health
+// did not return 200; a 503 from /readyz is reported in Readiness instead.
+func (h *Handler) BrokerHealth(ctx context.Context) (BrokerHealth, error) {
+	resp, err := h.brokerClient.GetHealthWithResponse(ctx)
+	if err != nil {
+		return BrokerHealth{}, fmt.Errorf("%w: %v", ErrBrokerUnavailable, err)
+	}
+	if resp.StatusCode() != http.StatusOK {
+		return BrokerHealth{}, &BrokerStatusError{Status: resp.StatusCode()}
+	}
+	var health BrokerHealth
+	var body struct {
+		Version string `json:"version"`
+	}
+	if err := json.Unmarshal(resp.Body, &body); err == nil {
+		health.Version = body.Version
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, h.brokerBaseURL+"/readyz", nil)
+	if err != nil {
+		return health, err
+	}
+	ready, err := h.httpClient.Do(req)
+	if err != nil {
+		return health, fmt.Errorf("%w: %v", ErrBrokerUnavailable, err)
 	}
-	return status, nil
+	defer ready.Body.Close()
+	if err := json.NewDecoder(ready.Body).Decode(&health); err != nil || health.Readiness == "" {
+		// Brokers predating /readyz: /health answered, so treat it as ready.
+		health.Readiness = "ready"
+		health.Dependencies = nil
+	}
+	return health, nil
+}
+
+// BuildInfo identifies a running Gateway or Broker binary, as served by
+// GET /version.
+type BuildInfo struct {
+	Version   string   `json:"version"`
+	GitCommit string   `json:"git_commit"`
+	BuildDate string   `json:"build_date"`
+	Features  []string `json:"features"`
+}
+
+// ServerInfo is the Gateway's GET /version response: its own BuildInfo and
+// the Broker's. Broker is nil, and BrokerError set, when the Broker could
+// not be asked.
+type ServerInfo struct {
+	BuildInfo
+	Broker      *BuildInfo `json:"broker,omitempty"`
+	BrokerError string     `json:"broker_error,omitempty"`
+}
+
+// BrokerBuildInfo fetches the Broker's GET /version. Brokers predating the
+// endpoint answer 404, reported as a *BrokerStatusError.
+func (h *Handler) BrokerBuildInfo(ctx context.Context) (BuildInfo, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, h.brokerBaseURL+"/version", nil)
+	if err != nil {
+		return BuildInfo{}, err
+	}
+	resp, err := h.httpClient.Do(req)
+	if err != nil {
+		return BuildInfo{}, fmt.Errorf("%w: %v", ErrBrokerUnavailable, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return BuildInfo{}, &BrokerStatusError{Status: resp.StatusCode}
+	}
+	var info BuildInfo
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return BuildInfo{}, fmt.Errorf("decode broker version: %w", err)
+	}
+	return info, nil
+}
+
+// ServerInfo pairs self, the calling Gateway's BuildInfo, with the Broker's.
+func (h *Handler) ServerInfo(ctx context.Context, self BuildInfo) ServerInfo {
+	if self.Features == nil {
+		self.Features = []string{}
+	}
+	info := ServerInfo{BuildInfo: self}
+	broker, err := h.BrokerBuildInfo(ctx)
+	if err != nil {
+		info.BrokerError = err.Error()
+		return info
+	}
+	info.Broker = &broker
+	return info
 }
 
 func (h *Handler) RequestConnection(w http.ResponseWriter, r *http.Request) {
@@ -345,18 +672,36 @@ func (h *Handler) RequestConnection(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	outCore, err := h.RequestConnectionCore(r.Context(), RequestConnectionInput{
+	in := RequestConnectionInput{
 		UserID:       req.UserID,
 		ProviderID:   req.ProviderID,
 		ProviderName: req.ProviderName,
 		Scopes:       req.Scopes,
 		ReturnURL:    req.ReturnURL,
 		Action:       req.Action,
-	})
+		Tenant:       req.Tenant,
+		Subject:      req.Subject,
+	}
+	// A browser asking for a bound flow gets a session cookie, and only a
+	// request carrying it can complete the flow.
+	if req.BindSession {
+		session, err := h.browserSession(w, r)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "internal_error", "failed to create session", nil)
+			return
+		}
+		in.SessionHash = state.HashSession(session)
+	}
+
+	outCore, err := h.RequestConnectionCore(r.Context(), in)
 	if err != nil {
 		// Map error types to HTTP statuses
 		var be *BrokerStatusError
+		var ve *ValidationError
 		switch {
+		case errors.As(err, &ve):
+			writeError(w, http.StatusBadRequest, "validation_failed", "request validation failed", map[string]any{"fields": ve.Fields})
+			return
 		case errors.Is(err, ErrInvalidState):
 			writeError(w, http.StatusBadRequest, "invalid_state", "state verification failed", nil)
 			return
@@ -367,7 +712,7 @@ func (h *Handler) RequestConnection(w http.ResponseWriter, r *http.Request) {
 			writeError(w, http.StatusConflict, "provider_ambiguous", "multiple providers matched", map[string]any{"provider_name": req.ProviderName})
 			return
 		case errors.As(err, &be):
-			writeError(w, http.StatusBadGateway, "broker_error", fmt.Sprintf("broker returned status %d", be.Status), map[string]any{"status": be.Status})
+			writeError(w, http.StatusBadGateway, "broker_error", fmt.Sprintf("broker returned status %d", be.Status), map[string]any{"broker_status": be.Status})
 			return
 		case errors.Is(err, ErrBrokerUnavailable):
 			writeError(w, http.StatusBadGateway, "broker_unavailable", "broker request failed", nil)
@@ -395,19 +740,75 @@ func (h *Handler) RequestConnection(w http.ResponseWriter, r *http.Request) {
 func (h *Handler) CheckConnection(w http.ResponseWriter, r *http.Request) {
 	connectionID := strings.TrimSpace(strings.TrimPrefix(r.URL.Path, "/v1/check-connection/"))
 	if connectionID == "" {
-		http.Error(w, "missing connection id", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, "missing_fields", "missing connection id", nil)
 		return
 	}
 
 	logging.Info(r.Context(), "check_connection.start", map[string]any{"connection_id": connectionID})
 	status, err := h.CheckConnectionCore(r.Context(), connectionID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadGateway)
+		writeError(w, http.StatusBadGateway, "broker_unavailable", err.Error(), nil)
 		return
 	}
-	logging.Info(r.Context(), "check_connection.result", map[string]any{"connection_id": connectionID, "status": status})
+	logging.Info(r.Context(), "check_connection.result", map[string]any{"connection_id": connectionID, "status": status.Status, "reason": status.Reason})
+
+	writeJSON(w, http.StatusOK, status)
+}
+
+// ConnectionResultOutput is the final outcome of a consent flow, keyed by the
+// signed state rather than the redirect query parameters.
+type ConnectionResultOutput struct {
+	ConnectionID string `json:"connection_id"`
+	ProviderID   string `json:"provider_id"`
+	Status       string `json:"status"`
+	Reason       string `json:"reason"`
+	ErrorCode    string `json:"error_code,omitempty"`
+}
 
-	writeJSON(w, http.StatusOK, map[string]string{"status": status})
+// ConnectionResultCore verifies the signed state and resolves the connection's
+// current status from the broker.
+func (h *Handler) ConnectionResultCore(ctx context.Context, state string) (ConnectionResultOutput, error) {
+	data, err := h.states.Verify(state)
+	if err != nil {
+		return ConnectionResultOutput{}, fmt.Errorf("%w: %v", ErrInvalidState, err)
+	}
+	status, err := h.CheckConnectionCore(ctx, data.Nonce)
+	if err != nil {
+		return ConnectionResultOutput{}, err
+	}
+	return ConnectionResultOutput{
+		ConnectionID: data.Nonce,
+		ProviderID:   data.ProviderID,
+		Status:       status.Status,
+		Reason:       status.Reason,
+		ErrorCode:    status.ErrorCode,
+	}, nil
+}
+
+// ConnectionResult handles GET /v1/connection-result?state=<state>. Frontends
+// call it after the consent popup closes to learn the outcome when the
+// redirect's query parameters were lost (blocked popups, mobile webviews).
+func (h *Handler) ConnectionResult(w http.ResponseWriter, r *http.Request) {
+	state := strings.TrimSpace(r.URL.Query().Get("state"))
+	if state == "" {
+		writeError(w, http.StatusBadRequest, "missing_fields", "missing state", nil)
+		return
+	}
+
+	out, err := h.ConnectionResultCore(r.Context(), state)
+	if err != nil {
+		if errors.Is(err, ErrInvalidState) {
+			logging.Error(r.Context(), "connection_result.state_invalid", map[string]any{"error": err.Error()})
+			writeError(w, http.StatusBadRequest, "invalid_state", "state verification failed", nil)
+			return
+		}
+		logging.Error(r.Context(), "connection_result.broker_error", map[string]any{"error": err.Error()})
+		writeError(w, http.StatusBadGateway, "broker_unavailable", "broker request failed", nil)
+		return
+	}
+
+	logging.Info(r.Context(), "connection_result.result", map[string]any{"connection_id": out.ConnectionID, "status": out.Status})
+	writeJSON(w, http.StatusOK, out)
 }
 
 func (h *Handler) GetToken(w http.ResponseWriter, r *http.Request) {
@@ -419,36 +820,37 @@ func (h *Handler) GetToken(w http.ResponseWriter, r *http.Request) {
 
 	logging.Info(r.Context(), "get_token.start", map[string]any{"connection_id": connectionID})
 
-	// Using generated client
-	resp, err := h.brokerClient.GetConnectionsConnectionIDTokenWithResponse(r.Context(), connectionID)
-	if err != nil {
+	tokenMap, status, err := h.GetTokenCore(r.Context(), connectionID)
+	if errors.Is(err, ErrBrokerUnavailable) {
 		logging.Error(r.Context(), "get_token.broker_error", map[string]any{"error": err.Error()})
 		writeError(w, http.StatusBadGateway, "broker_unavailable", "broker request failed", nil)
 		return
 	}
 
-	logging.Info(r.Context(), "get_token.proxy", map[string]any{"connection_id": connectionID, "status": resp.StatusCode()})
+	logging.Info(r.Context(), "get_token.proxy", map[string]any{"connection_id": connectionID, "status": status})
 
-	if resp.StatusCode() == http.StatusOK && resp.JSON200 != nil {
-		writeJSON(w, http.StatusOK, resp.JSON200)
+	if status == http.StatusOK && tokenMap != nil {
+		writeJSON(w, http.StatusOK, tokenMap)
 		return
 	}
 
-	// If not 200 OK or error body, just forward the status and generic error
-	if resp.StatusCode() >= 400 {
-		w.WriteHeader(resp.StatusCode())
+	if status == http.StatusLocked {
+		writeError(w, status, "connection_suspended", "connection is suspended", nil)
 		return
 	}
-
-	w.WriteHeader(resp.StatusCode())
+	// Any other broker answer is forwarded with its status
+	writeError(w, status, "broker_error", fmt.Sprintf("broker returned status %d", status), map[string]any{"broker_status": status})
 }
 
 // GetTokenCore fetches the decrypted token JSON from the broker and returns it as a generic map.
 // Refactored to use generated client and convert struct back to map for backwards compat or generic usage.
 func (h *Handler) GetTokenCore(ctx context.Context, connectionID string) (map[string]any, int, error) {
+	if h.brokerRPC != nil {
+		return h.rpcToken(ctx, connectionID, false)
+	}
 	resp, err := h.brokerClient.GetConnectionsConnectionIDTokenWithResponse(ctx, connectionID)
 	if err != nil {
-		return nil, http.StatusBadGateway, fmt.Errorf("broker request failed: %w", err)
+		return nil, http.StatusBadGateway, fmt.Errorf("%w: broker request failed: %v", ErrBrokerUnavailable, err)
 	}
 
 	if resp.StatusCode() != http.StatusOK {
@@ -456,7 +858,7 @@ func (h *Handler) GetTokenCore(ctx context.Context, connectionID string) (map[st
 	}
 
 	if resp.JSON200 == nil {
-		return nil, resp.StatusCode(), fmt.Errorf("empty response")
+		return nil, resp.StatusCode(), fmt.Errorf("%w: empty response", ErrBrokerInvalidResponse)
 	}
 
 	// Convert TokenResponse struct back to map[string]any
@@ -469,9 +871,12 @@ func (h *Handler) GetTokenCore(ctx context.Context, connectionID string) (map[st
 
 // RefreshConnectionCore forces a token refresh via the broker.
 func (h *Handler) RefreshConnectionCore(ctx context.Context, connectionID string) (map[string]any, int, error) {
+	if h.brokerRPC != nil {
+		return h.rpcToken(ctx, connectionID, true)
+	}
 	resp, err := h.brokerClient.PostConnectionsConnectionIDRefreshWithResponse(ctx, connectionID)
 	if err != nil {
-		return nil, http.StatusBadGateway, fmt.Errorf("broker request failed: %w", err)
+		return nil, http.StatusBadGateway, fmt.Errorf("%w: broker request failed: %v", ErrBrokerUnavailable, err)
 	}
 
 	if resp.StatusCode() != http.StatusOK {
@@ -479,7 +884,7 @@ func (h *Handler) RefreshConnectionCore(ctx context.Context, connectionID string
 	}
 
 	if resp.JSON200 == nil {
-		return nil, resp.StatusCode(), fmt.Errorf("empty response")
+		return nil, resp.StatusCode(), fmt.Errorf("%w: empty response", ErrBrokerInvalidResponse)
 	}
 
 	// Convert TokenResponse struct back to map[string]any
@@ -508,7 +913,11 @@ func (h *Handler) RefreshConnection(w http.ResponseWriter, r *http.Request) {
 
 	if status != http.StatusOK {
 		logging.Error(r.Context(), "refresh_connection.broker_status", map[string]any{"status": status})
-		w.WriteHeader(status)
+		if status == http.StatusLocked {
+			writeError(w, status, "connection_suspended", "connection is suspended", nil)
+			return
+		}
+		writeError(w, status, "broker_error", fmt.Sprintf("broker returned status %d", status), map[string]any{"broker_status": status})
 		return
 	}
 
@@ -516,9 +925,101 @@ func (h *Handler) RefreshConnection(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, tokenMap)
 }
 
-// GetProvidersCore fetches provider metadata from the broker
-func (h *Handler) GetProvidersCore(ctx context.Context) (map[string]any, error) {
-	resp, err := h.brokerClient.GetProvidersMetadataWithResponse(ctx)
+// ExchangeTokenCore asks the broker to trade a connection's token for a
+// down-scoped one (RFC 8693 token exchange). body is the broker's exchange
+// request. A non-200 broker answer is returned with its status and body.
+func (h *Handler) ExchangeTokenCore(ctx context.Context, connectionID string, body []byte) (map[string]any, int, []byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.brokerBaseURL+"/connections/"+url.PathEscape(connectionID)+"/exchange", bytes.NewReader(body))
+	if err != nil {
+		return nil, http.StatusInternalServerError, nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	setCaller(ctx, req)
+	if h.brokerAPIKey != "" {
+		req.Header.Set("X-API-Key", h.brokerAPIKey)
+	}
+	resp, err := h.httpClient.Do(req)
+	if err != nil {
+		return nil, http.StatusBadGateway, nil, fmt.Errorf("%w: broker request failed: %v", ErrBrokerUnavailable, err)
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, http.StatusBadGateway, nil, fmt.Errorf("%w: broker request failed: %v", ErrBrokerUnavailable, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, resp.StatusCode, respBody, nil
+	}
+	var tokenMap map[string]any
+	if err := json.Unmarshal(respBody, &tokenMap); err != nil || tokenMap == nil {
+		return nil, resp.StatusCode, nil, fmt.Errorf("%w: token exchange", ErrBrokerInvalidResponse)
+	}
+	return tokenMap, http.StatusOK, nil, nil
+}
+
+// ExchangeToken trades a connection's token for another one, typically
+// narrower in scope or for another audience, so that an agent can hand a
+// subprocess only what it needs. The body is optional; when present it is
+// passed to the broker as is.
+func (h *Handler) ExchangeToken(w http.ResponseWriter, r *http.Request) {
+	connectionID := strings.TrimSpace(chi.URLParam(r, "connectionID"))
+	if connectionID == "" {
+		writeError(w, http.StatusBadRequest, "missing_fields", "missing connection id", nil)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	var members map[string]json.RawMessage
+	switch {
+	case err != nil:
+		writeError(w, http.StatusBadRequest, "read_error", "failed to read request body", nil)
+		return
+	case len(bytes.TrimSpace(body)) == 0:
+		body = []byte("{}")
+	case json.Unmarshal(body, &members) != nil || members == nil:
+		writeError(w, http.StatusBadRequest, "invalid_json", "body must be a JSON object", nil)
+		return
+	}
+
+	logging.Info(r.Context(), "exchange_token.start", map[string]any{"connection_id": connectionID})
+
+	tokenMap, status, brokerBody, err := h.ExchangeTokenCore(r.Context(), connectionID, body)
+	if err != nil {
+		logging.Error(r.Context(), "exchange_token.broker_error", map[string]any{"error": err.Error()})
+		writeError(w, status, "broker_unavailable", "broker request failed", nil)
+		return
+	}
+	if status != http.StatusOK {
+		logging.Error(r.Context(), "exchange_token.broker_status", map[string]any{"status": status})
+		if status == http.StatusLocked {
+			writeError(w, status, "connection_suspended", "connection is suspended", nil)
+			return
+		}
+		writeBrokerError(w, status, brokerBody)
+		return
+	}
+
+	logging.Info(r.Context(), "exchange_token.success", map[string]any{"connection_id": connectionID})
+	writeJSON(w, http.StatusOK, tokenMap)
+}
+
+// GetProvidersCore returns provider metadata, from the metadata cache if
+// WithMetadataCache enabled it or else from the broker. A non-empty
+// workspaceID limits the result to global providers and those restricted to
+// that workspace.
+func (h *Handler) GetProvidersCore(ctx context.Context, workspaceID string) (map[string]any, error) {
+	if h.metadataCache != nil {
+		return h.metadataCache.get(ctx, workspaceID, h.fetchMetadata)
+	}
+	return h.fetchMetadata(ctx, workspaceID)
+}
+
+// fetchMetadata fetches provider metadata from the broker.
+func (h *Handler) fetchMetadata(ctx context.Context, workspaceID string) (map[string]any, error) {
+	if h.brokerRPC != nil {
+		return h.rpcMetadata(ctx, workspaceID)
+	}
+	resp, err := h.brokerClient.GetProvidersMetadataWithResponse(ctx, withWorkspaceID(workspaceID))
 	if err != nil {
 		return nil, fmt.Errorf("%w: %v", ErrBrokerUnavailable, err)
 	}
@@ -552,33 +1053,91 @@ func (h *Handler) GetProvidersCore(ctx context.Context) (map[string]any, error)
 	return metadata, nil
 }
 
+// withWorkspaceID adds the workspace_id query parameter used by the broker to
+// scope provider listings and lookups. It is a no-op for an empty workspaceID.
+func withWorkspaceID(workspaceID string) broker.RequestEditorFn {
+	return func(ctx context.Context, req *http.Request) error {
+		if workspaceID == "" {
+			return nil
+		}
+		q := req.URL.Query()
+		q.Set("workspace_id", workspaceID)
+		req.URL.RawQuery = q.Encode()
+		return nil
+	}
+}
+
+// withIfMatch forwards the caller's If-Match header, which makes provider
+// updates conditional on the version the caller last read.
+func withIfMatch(r *http.Request) broker.RequestEditorFn {
+	return func(ctx context.Context, req *http.Request) error {
+		if v := r.Header.Get("If-Match"); v != "" {
+			req.Header.Set("If-Match", v)
+		}
+		return nil
+	}
+}
+
+// readJSONObject reads a request body that must be a JSON object, answering
+// 400 invalid_json otherwise.
+func readJSONObject(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	var members map[string]json.RawMessage
+	body, err := io.ReadAll(r.Body)
+	if err == nil {
+		err = json.Unmarshal(body, &members)
+	}
+	if err != nil || members == nil {
+		writeError(w, http.StatusBadRequest, "invalid_json", "body must be a JSON object", nil)
+		return nil, false
+	}
+	return body, true
+}
+
+// copyETag relays the broker's ETag for a provider profile to the caller.
+func copyETag(w http.ResponseWriter, resp *http.Response) {
+	if resp == nil {
+		return
+	}
+	if etag := resp.Header.Get("ETag"); etag != "" {
+		w.Header().Set("ETag", etag)
+	}
+}
+
 func (h *Handler) GetProviders(w http.ResponseWriter, r *http.Request) {
 	logging.Info(r.Context(), "get_providers.start", nil)
-	metadata, err := h.GetProvidersCore(r.Context())
+	workspaceID, err := auth.Workspace(r.Context(), strings.TrimSpace(r.URL.Query().Get("workspace_id")))
+	if err != nil {
+		writeError(w, http.StatusForbidden, "workspace_forbidden", "caller does not act for this workspace", nil)
+		return
+	}
+	metadata, err := h.GetProvidersCore(r.Context(), workspaceID)
 	if err != nil {
 		var be *BrokerStatusError
 		if errors.As(err, &be) {
-			writeError(w, http.StatusBadGateway, "broker_error", fmt.Sprintf("broker returned status %d", be.Status), map[string]any{"status": be.Status})
+			writeError(w, http.StatusBadGateway, "broker_error", fmt.Sprintf("broker returned status %d", be.Status), map[string]any{"broker_status": be.Status})
 			return
 		}
-		writeError(w, http.StatusBadGateway, "broker_unavailable", "failed to fetch providers", map[string]any{"error": err.Error()})
+		logging.Error(r.Context(), "get_providers.broker_error", map[string]any{"error": err.Error()})
+		writeError(w, http.StatusBadGateway, "broker_unavailable", "failed to fetch providers", nil)
 		return
 	}
 
 	writeJSON(w, http.StatusOK, metadata)
 }
 
-// CreateProvider registers a new provider via the broker
+// CreateProvider registers a new provider via the broker. The body and the
+// broker's answer are passed as is, so that members the generated client
+// does not know, such as the "registration" request for Dynamic Client
+// Registration, get through.
 func (h *Handler) CreateProvider(w http.ResponseWriter, r *http.Request) {
 	logging.Info(r.Context(), "create_provider.start", nil)
 
-	var body broker.PostProvidersJSONRequestBody
-	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
-		writeError(w, http.StatusBadRequest, "invalid_json", "invalid json body", nil)
+	body, ok := readJSONObject(w, r)
+	if !ok {
 		return
 	}
 
-	resp, err := h.brokerClient.PostProvidersWithResponse(r.Context(), body)
+	resp, err := h.brokerClient.PostProvidersWithBodyWithResponse(r.Context(), "application/json", bytes.NewReader(body))
 	if err != nil {
 		logging.Error(r.Context(), "create_provider.broker_error", map[string]any{"error": err.Error()})
 		writeError(w, http.StatusBadGateway, "broker_unavailable", "broker request failed", nil)
@@ -586,44 +1145,18 @@ func (h *Handler) CreateProvider(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if resp.StatusCode() != http.StatusCreated && resp.StatusCode() != http.StatusOK {
-		// Parse the broker's error response
-		var brokerError struct {
-			Error   string `json:"error"`
-			Message string `json:"message"`
-		}
-
-		var errCode, errMsg string
-
-		if resp.Body != nil && len(resp.Body) > 0 {
-			// Try to parse as JSON first
-			if err := json.Unmarshal(resp.Body, &brokerError); err == nil {
-				errCode = brokerError.Error
-				errMsg = brokerError.Message
-			} else {
-				// Fall back to raw body if not JSON
-				errCode = "broker_error"
-				errMsg = string(resp.Body)
-			}
-		} else {
-			errCode = "broker_error"
-			errMsg = fmt.Sprintf("broker returned status %d", resp.StatusCode())
-		}
-
-		logging.Error(r.Context(), "create_provider.broker_error", map[string]any{
-			"status":  resp.StatusCode(),
-			"error":   errCode,
-			"message": errMsg,
+		logging.Error(r.Context(), "create_provider.broker_status", map[string]any{
+			"status": resp.StatusCode(),
+			"body":   string(resp.Body),
 		})
-
-		writeError(w, resp.StatusCode(), errCode, errMsg, nil)
+		writeBrokerError(w, resp.StatusCode(), resp.Body)
 		return
 	}
 
-	if resp.JSON201 != nil {
-		writeJSON(w, http.StatusCreated, resp.JSON201)
-	} else {
-		w.WriteHeader(http.StatusCreated)
-	}
+	h.InvalidateMetadataCache()
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_, _ = w.Write(resp.Body)
 }
 
 // GetProvider retrieves a single provider profile by ID
@@ -645,18 +1178,22 @@ func (h *Handler) GetProvider(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if resp.StatusCode() != http.StatusOK {
-		w.WriteHeader(resp.StatusCode())
+		writeBrokerError(w, resp.StatusCode(), resp.Body)
 		return
 	}
 
-	if resp.JSON200 != nil {
-		writeJSON(w, http.StatusOK, resp.JSON200)
-	} else {
-		w.WriteHeader(http.StatusOK)
-	}
+	// The broker's body is relayed as is so that fields the generated
+	// client does not know, such as client_secret_set, reach the caller.
+	copyETag(w, resp.HTTPResponse)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(resp.Body)
 }
 
-// UpdateProvider updates an existing provider by ID
+// UpdateProvider replaces an existing provider by ID. The body is passed to
+// the broker as is, so that "client_secret": null (clear) stays distinct
+// from an omitted secret (keep). If-Match is forwarded to the broker, which
+// answers 412 when the provider has changed since.
 func (h *Handler) UpdateProvider(w http.ResponseWriter, r *http.Request) {
 	idStr := chi.URLParam(r, "id")
 	providerID, err := uuid.Parse(idStr)
@@ -667,13 +1204,12 @@ func (h *Handler) UpdateProvider(w http.ResponseWriter, r *http.Request) {
 
 	logging.Info(r.Context(), "update_provider.start", map[string]any{"id": idStr})
 
-	var body broker.PutProvidersIdJSONRequestBody
-	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
-		writeError(w, http.StatusBadRequest, "invalid_json", "invalid json body", nil)
+	body, ok := readJSONObject(w, r)
+	if !ok {
 		return
 	}
 
-	resp, err := h.brokerClient.PutProvidersIdWithResponse(r.Context(), providerID, body)
+	resp, err := h.brokerClient.PutProvidersIdWithBodyWithResponse(r.Context(), providerID, "application/json", bytes.NewReader(body), withIfMatch(r))
 	if err != nil {
 		logging.Error(r.Context(), "update_provider.broker_error", map[string]any{"error": err.Error()})
 		writeError(w, http.StatusBadGateway, "broker_unavailable", "broker request failed", nil)
@@ -681,14 +1217,18 @@ func (h *Handler) UpdateProvider(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if resp.StatusCode() != http.StatusOK {
-		w.WriteHeader(resp.StatusCode())
+		writeBrokerError(w, resp.StatusCode(), resp.Body)
 		return
 	}
 
+	h.InvalidateMetadataCache()
+	copyETag(w, resp.HTTPResponse)
 	w.WriteHeader(http.StatusOK)
 }
 
-// PatchProvider updates specific fields of a provider by ID
+// PatchProvider applies a JSON merge patch to a provider by ID. The body is
+// passed to the broker as is, so that null members clear fields, and
+// If-Match is forwarded.
 func (h *Handler) PatchProvider(w http.ResponseWriter, r *http.Request) {
 	idStr := chi.URLParam(r, "id")
 	providerID, err := uuid.Parse(idStr)
@@ -699,13 +1239,12 @@ func (h *Handler) PatchProvider(w http.ResponseWriter, r *http.Request) {
 
 	logging.Info(r.Context(), "patch_provider.start", map[string]any{"id": idStr})
 
-	var body broker.PatchProvidersIdJSONRequestBody
-	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
-		writeError(w, http.StatusBadRequest, "invalid_json", "invalid json body", nil)
+	body, ok := readJSONObject(w, r)
+	if !ok {
 		return
 	}
 
-	resp, err := h.brokerClient.PatchProvidersIdWithResponse(r.Context(), providerID, body)
+	resp, err := h.brokerClient.PatchProvidersIdWithBodyWithResponse(r.Context(), providerID, "application/merge-patch+json", bytes.NewReader(body), withIfMatch(r))
 	if err != nil {
 		logging.Error(r.Context(), "patch_provider.broker_error", map[string]any{"error": err.Error()})
 		writeError(w, http.StatusBadGateway, "broker_unavailable", "broker request failed", nil)
@@ -717,10 +1256,12 @@ func (h *Handler) PatchProvider(w http.ResponseWriter, r *http.Request) {
 			"status": resp.StatusCode(),
 			"body":   string(resp.Body),
 		})
-		w.WriteHeader(resp.StatusCode())
+		writeBrokerError(w, resp.StatusCode(), resp.Body)
 		return
 	}
 
+	h.InvalidateMetadataCache()
+	copyETag(w, resp.HTTPResponse)
 	w.WriteHeader(http.StatusOK)
 }
 
@@ -743,10 +1284,11 @@ func (h *Handler) DeleteProvider(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if resp.StatusCode() != http.StatusOK {
-		w.WriteHeader(resp.StatusCode())
+		writeBrokerError(w, resp.StatusCode(), resp.Body)
 		return
 	}
 
+	h.InvalidateMetadataCache()
 	w.WriteHeader(http.StatusOK)
 }
 
@@ -757,7 +1299,7 @@ func (h *Handler) CaptureSchema(w http.ResponseWriter, r *http.Request) {
 	target, err := url.Parse(h.brokerBaseURL)
 	if err != nil {
 		logging.Error(r.Context(), "capture_schema.parse_error", map[string]any{"error": err.Error()})
-		http.Error(w, "invalid broker url", http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, "internal_error", "invalid broker url", nil)
 		return
 	}
 
@@ -777,8 +1319,12 @@ func (h *Handler) CaptureSchema(w http.ResponseWriter, r *http.Request) {
 // /auth/capture-credential endpoint. The broker responds with a 302 redirect
 // to the return_url containing ?status=success&connection_id=<id>. This handler
 // intercepts that redirect and returns the connection_id as JSON so the client
-// never needs to talk to the broker directly.
+// never needs to talk to the broker directly. Posts of the broker's hosted
+// capture form come from the user's browser, which instead gets the broker's
+// redirect or error page as is.
 func (h *Handler) CaptureCredential(w http.ResponseWriter, r *http.Request) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	hostedForm := mediaType == "application/x-www-form-urlencoded"
 	brokerURL := h.brokerBaseURL + "/auth/capture-credential"
 
 	body, err := io.ReadAll(r.Body)
@@ -792,16 +1338,26 @@ func (h *Handler) CaptureCredential(w http.ResponseWriter, r *http.Request) {
 		writeError(w, http.StatusInternalServerError, "request_error", "failed to build broker request", nil)
 		return
 	}
-	req.Header.Set("Content-Type", "application/json")
+	if hostedForm {
+		// The broker answers in the browser's language.
+		for _, name := range []string{"Content-Type", "Accept", "Accept-Language"} {
+			if v := r.Header.Get(name); v != "" {
+				req.Header.Set(name, v)
+			}
+		}
+	} else {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	setCaller(r.Context(), req)
 	if h.brokerAPIKey != "" {
 		req.Header.Set("X-API-Key", h.brokerAPIKey)
 	}
+	h.forwardSession(r, req)
 
 	// Use a client that does NOT follow redirects so we can inspect the 302
-	noRedirectClient := &http.Client{
-		CheckRedirect: func(req *http.Request, via []*http.Request) error {
-			return http.ErrUseLastResponse
-		},
+	noRedirectClient := *h.httpClient
+	noRedirectClient.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
 	}
 
 	logging.Info(r.Context(), "capture_credential.start", nil)
@@ -814,6 +1370,18 @@ func (h *Handler) CaptureCredential(w http.ResponseWriter, r *http.Request) {
 	}
 	defer resp.Body.Close()
 
+	if hostedForm {
+		for _, name := range []string{"Location", "Content-Type", "Content-Language", "Cache-Control", "Content-Security-Policy", "Vary"} {
+			if v := resp.Header.Get(name); v != "" {
+				w.Header().Set(name, v)
+			}
+		}
+		logging.Info(r.Context(), "capture_credential.form", map[string]any{"status": resp.StatusCode})
+		w.WriteHeader(resp.StatusCode)
+		io.Copy(w, resp.Body)
+		return
+	}
+
 	if resp.StatusCode != http.StatusFound {
 		respBody, _ := io.ReadAll(resp.Body)
 		logging.Error(r.Context(), "capture_credential.unexpected_status", map[string]any{
@@ -857,7 +1425,7 @@ func (h *Handler) ProxyCallback(w http.ResponseWriter, r *http.Request) {
 	target, err := url.Parse(h.brokerBaseURL)
 	if err != nil {
 		logging.Error(r.Context(), "proxy_callback.parse_error", map[string]any{"error": err.Error()})
-		http.Error(w, "invalid broker url", http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, "internal_error", "invalid broker url", nil)
 		return
 	}
 
@@ -870,6 +1438,7 @@ func (h *Handler) ProxyCallback(w http.ResponseWriter, r *http.Request) {
 		originalDirector(req)
 		req.URL.Path = "/auth/callback" // Force path to broker's callback
 		req.Host = target.Host          // Set host header to broker's host
+		h.forwardSession(r, req)
 
 		// Pass query params (code, state) as is
 		// originalDirector already copies URL, so query params are preserved
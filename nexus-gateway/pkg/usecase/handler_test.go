@@ -2,33 +2,38 @@ package usecase
 
 import (
 	"bytes"
-	"crypto/hmac"
-	"crypto/sha256"
-	"encoding/base64"
+	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"testing"
 	"time"
 
+	"github.com/go-chi/chi/v5"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-common/state"
+	"github.com/Prescott-Data/nexus-framework/nexus-gateway/pkg/auth"
 	"github.com/Prescott-Data/nexus-framework/nexus-gateway/pkg/broker"
 )
 
+// testStates returns a keyring holding only key, as the Broker would have.
+func testStates(t testing.TB, key []byte) *state.Keyring {
+	t.Helper()
+	k, err := state.NewKeyring(state.Key{Secret: key}, nil)
+	if err != nil {
+		t.Fatalf("NewKeyring: %v", err)
+	}
+	return k
+}
+
 // generateState creates a valid signed state string for testing
-func generateState(key []byte, wsID, provID, nonce string) string {
-	data := map[string]interface{}{
-		"workspace_id": wsID,
-		"provider_id":  provID,
-		"nonce":        nonce,
-		"iat":          time.Now(),
-	}
-	dataBytes, _ := json.Marshal(data)
-	
-	mac := hmac.New(sha256.New, key)
-	mac.Write(dataBytes)
-	sig := mac.Sum(nil)
-	
-	return base64.RawURLEncoding.EncodeToString(dataBytes) + "." + base64.RawURLEncoding.EncodeToString(sig)
+func generateState(t testing.TB, key []byte, wsID, provID, nonce string) string {
+	s, err := testStates(t, key).Sign(state.Data{WorkspaceID: wsID, ProviderID: provID, Nonce: nonce, IAT: time.Now()})
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	return s
 }
 
 func ptr[T any](v T) *T { return &v }
@@ -56,7 +61,7 @@ func mockBrokerServer(t *testing.T, key []byte) *httptest.Server {
 		}
 
 		// Generate valid state
-		state := generateState(key, req.WorkspaceId, *req.ProviderId, "test-nonce")
+		state := generateState(t, key, req.WorkspaceId, *req.ProviderId, "test-nonce")
 
 		// Return success response
 		resp := broker.ConsentSpecResponse{
@@ -114,8 +119,7 @@ func TestGetProviders(t *testing.T) {
 	defer server.Close()
 
 	// Setup handler pointing to mock server
-	t.Setenv("BROKER_API_KEY", "test-api-key")
-	h := NewHandler(server.URL, []byte("test-secret-key"), nil)
+	h := NewHandler(server.URL, testStates(t, []byte("test-secret-key")), nil, WithBrokerAPIKey("test-api-key"))
 
 	// Create request
 	req := httptest.NewRequest("GET", "/v1/providers", nil)
@@ -154,8 +158,7 @@ func TestRequestConnection(t *testing.T) {
 	server := mockBrokerServer(t, key)
 	defer server.Close()
 
-	t.Setenv("BROKER_API_KEY", "test-api-key")
-	h := NewHandler(server.URL, key, nil)
+	h := NewHandler(server.URL, testStates(t, key), nil, WithBrokerAPIKey("test-api-key"))
 
 	// Request body
 	body := map[string]interface{}{
@@ -182,4 +185,356 @@ func TestRequestConnection(t *testing.T) {
 	if resp["connection_id"] != "test-nonce" {
 		t.Errorf("expected connection_id 'test-nonce', got '%v'", resp["connection_id"])
 	}
-}
\ No newline at end of file
+}
+func TestRequestConnection_ForwardsTenant(t *testing.T) {
+	key := []byte("12345678901234567890123456789012")
+	var got broker.ConsentSpecRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Fatal(err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(broker.ConsentSpecResponse{
+			AuthUrl: ptr("https://login.microsoftonline.com/organizations/oauth2/v2.0/authorize"),
+			State:   ptr(generateState(t, key, got.WorkspaceId, *got.ProviderId, "conn-1")),
+		})
+	}))
+	defer server.Close()
+	h := NewHandler(server.URL, testStates(t, key), nil)
+
+	body, _ := json.Marshal(map[string]any{
+		"user_id":     "ws",
+		"provider_id": "microsoft",
+		"scopes":      []string{"openid", "User.Read"},
+		"return_url":  "http://localhost:3000/cb",
+		"tenant":      "organizations",
+	})
+	w := httptest.NewRecorder()
+	h.RequestConnection(w, httptest.NewRequest("POST", "/v1/request-connection", bytes.NewReader(body)))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d. Body: %s", w.Code, w.Body.String())
+	}
+	if got.Tenant == nil || *got.Tenant != "organizations" {
+		t.Errorf("expected tenant organizations in the consent-spec request, got %v", got.Tenant)
+	}
+}
+
+// TestConnectionResult verifies the late-callback outcome lookup by state
+func TestConnectionResult(t *testing.T) {
+	key := []byte("12345678901234567890123456789012")
+	mux := http.NewServeMux()
+	mux.HandleFunc("/connections/conn-1/status", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{"connection_id": "conn-1", "status": "active", "reason": "ok"})
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	h := NewHandler(server.URL, testStates(t, key), nil)
+
+	state := generateState(t, key, "ws", "prov-1", "conn-1")
+	req := httptest.NewRequest("GET", "/v1/connection-result?state="+state, nil)
+	w := httptest.NewRecorder()
+	h.ConnectionResult(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d. Body: %s", w.Code, w.Body.String())
+	}
+	var resp ConnectionResultOutput
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.ConnectionID != "conn-1" || resp.ProviderID != "prov-1" || resp.Status != "active" || resp.Reason != "ok" {
+		t.Errorf("unexpected result: %+v", resp)
+	}
+
+	// Tampered state is rejected
+	req = httptest.NewRequest("GET", "/v1/connection-result?state="+generateState(t, []byte("wrong-key"), "ws", "prov-1", "conn-1"), nil)
+	w = httptest.NewRecorder()
+	h.ConnectionResult(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 for bad signature, got %d", w.Code)
+	}
+}
+
+// TestCheckConnection verifies the status, reason and error code come from the broker
+func TestCheckConnection(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/connections/conn-1/status", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-API-Key") != "k1" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{"connection_id": "conn-1", "status": "needs_reauth", "reason": "reauth_required"})
+	})
+	mux.HandleFunc("/connections/conn-3/status", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"connection_id": "conn-3", "status": "failed", "reason": "consent_failed", "error_code": "consent_denied"})
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	h := NewHandler(server.URL, testStates(t, []byte("12345678901234567890123456789012")), nil, WithBrokerAPIKey("k1"))
+
+	check := func(id string) (int, ConnectionStatus) {
+		w := httptest.NewRecorder()
+		h.CheckConnection(w, httptest.NewRequest("GET", "/v1/check-connection/"+id, nil))
+		var out ConnectionStatus
+		json.NewDecoder(w.Body).Decode(&out)
+		return w.Code, out
+	}
+	if code, out := check("conn-1"); code != http.StatusOK || out != (ConnectionStatus{Status: "needs_reauth", Reason: "reauth_required"}) {
+		t.Errorf("check-connection = %d %+v", code, out)
+	}
+	if code, out := check("conn-3"); code != http.StatusOK || out.ErrorCode != "consent_denied" {
+		t.Errorf("check-connection(failed) = %d %+v", code, out)
+	}
+	if code, out := check("conn-2"); code != http.StatusOK || out != connectionNotFound {
+		t.Errorf("check-connection(unknown) = %d %+v", code, out)
+	}
+}
+
+// TestGetProviders_ForwardsWorkspaceID verifies the workspace filter reaches the broker
+func TestGetProviders_ForwardsWorkspaceID(t *testing.T) {
+	var got string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.URL.Query().Get("workspace_id")
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	h := NewHandler(server.URL, testStates(t, []byte("test-secret-key")), nil)
+
+	req := httptest.NewRequest("GET", "/v1/providers?workspace_id=ws-a", nil)
+	w := httptest.NewRecorder()
+	h.GetProviders(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+	if got != "ws-a" {
+		t.Errorf("expected broker to receive workspace_id ws-a, got %q", got)
+	}
+}
+
+// TestGetProviders_WorkspaceFromCaller verifies an authenticated caller lists
+// only the workspaces it is bound to
+func TestGetProviders_WorkspaceFromCaller(t *testing.T) {
+	var got []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = append(got, r.URL.Query().Get("workspace_id"))
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	h := NewHandler(server.URL, testStates(t, []byte("test-secret-key")), nil)
+	ctx := auth.WithCaller(context.Background(), &auth.Caller{ID: "billing", Workspaces: []string{"ws-a"}})
+
+	req := httptest.NewRequest("GET", "/v1/providers", nil).WithContext(ctx)
+	w := httptest.NewRecorder()
+	h.GetProviders(w, req)
+	if w.Code != http.StatusOK || len(got) != 1 || got[0] != "ws-a" {
+		t.Fatalf("expected the caller's workspace ws-a, got %d %v", w.Code, got)
+	}
+
+	req = httptest.NewRequest("GET", "/v1/providers?workspace_id=ws-b", nil).WithContext(ctx)
+	w = httptest.NewRecorder()
+	h.GetProviders(w, req)
+	if w.Code != http.StatusForbidden || !bytes.Contains(w.Body.Bytes(), []byte("workspace_forbidden")) {
+		t.Fatalf("expected 403 workspace_forbidden for another tenant's workspace, got %d %s", w.Code, w.Body.String())
+	}
+	if len(got) != 1 {
+		t.Errorf("the broker should not be asked for another tenant's providers, got %v", got)
+	}
+}
+
+// TestResolveProviderID_ScopedToWorkspace verifies name lookups are made, and
+// cached, per workspace
+func TestResolveProviderID_ScopedToWorkspace(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/providers/by-name/internal", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("workspace_id") != "ws-a" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"id": "internal-uuid"})
+	})
+	mux.HandleFunc("/providers", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`[]`))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	h := NewHandler(server.URL, testStates(t, []byte("test-secret-key")), nil)
+	ctx := context.Background()
+
+	if got, err := h.resolveProviderID(ctx, "ws-a", "internal"); err != nil || got != "internal-uuid" {
+		t.Fatalf("expected internal-uuid in ws-a, got %q, %v", got, err)
+	}
+	if _, err := h.resolveProviderID(ctx, "ws-b", "internal"); !errors.Is(err, ErrProviderNotFound) {
+		t.Fatalf("expected ErrProviderNotFound in ws-b, got %v", err)
+	}
+}
+
+// TestResolveProviderID_CacheAndInvalidate verifies name lookups are cached until invalidated
+func TestResolveProviderID_CacheAndInvalidate(t *testing.T) {
+	hits := 0
+	id := "google-uuid"
+	mux := http.NewServeMux()
+	mux.HandleFunc("/providers/by-name/google", func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"id": id})
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	h := NewHandler(server.URL, testStates(t, []byte("test-secret-key")), nil)
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		got, err := h.resolveProviderID(ctx, "", "google")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got != "google-uuid" {
+			t.Errorf("expected google-uuid, got %q", got)
+		}
+	}
+	if hits != 1 {
+		t.Fatalf("expected one broker lookup, got %d", hits)
+	}
+
+	id = "google-uuid-2"
+	if n := h.InvalidateProviderCache(); n != 1 {
+		t.Errorf("expected 1 entry invalidated, got %d", n)
+	}
+	got, err := h.resolveProviderID(ctx, "", "google")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got != "google-uuid-2" || hits != 2 {
+		t.Errorf("expected fresh lookup after invalidation, got %q after %d hits", got, hits)
+	}
+}
+
+// TestBrokerHealth verifies the broker's version and /readyz dependencies
+// are combined, and that a 503 from /readyz is reported rather than failing.
+func TestBrokerHealth(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"status":"healthy","version":"1.4.0"}`))
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(`{"status":"unavailable","dependencies":{"postgres":{"status":"error","critical":true,"error":"refused"},"redis":{"status":"ok"}}}`))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	h := NewHandler(server.URL, testStates(t, []byte("test-secret-key")), nil)
+	bh, err := h.BrokerHealth(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if bh.Version != "1.4.0" || bh.Readiness != "unavailable" {
+		t.Errorf("unexpected broker health %+v", bh)
+	}
+	if pg := bh.Dependencies["postgres"]; pg.Status != "error" || !pg.Critical {
+		t.Errorf("expected failing critical postgres dependency, got %+v", pg)
+	}
+
+	server.Close()
+	if _, err := h.BrokerHealth(context.Background()); !errors.Is(err, ErrBrokerUnavailable) {
+		t.Errorf("expected ErrBrokerUnavailable once the broker is gone, got %v", err)
+	}
+}
+
+// TestServerInfo verifies the broker's /version is attached, and that a
+// broker without the endpoint is reported in BrokerError.
+func TestServerInfo(t *testing.T) {
+	versionStatus := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/version" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		w.WriteHeader(versionStatus)
+		w.Write([]byte(`{"version":"1.4.0","git_commit":"abc123","build_date":"2026-01-02","features":["webhooks"]}`))
+	}))
+	defer server.Close()
+
+	h := NewHandler(server.URL, testStates(t, []byte("test-secret-key")), nil)
+	info := h.ServerInfo(context.Background(), BuildInfo{Version: "1.5.0"})
+	if info.Version != "1.5.0" || info.Broker == nil || info.Broker.Version != "1.4.0" || info.Broker.GitCommit != "abc123" {
+		t.Errorf("unexpected server info %+v", info)
+	}
+	if len(info.Broker.Features) != 1 || info.Broker.Features[0] != "webhooks" {
+		t.Errorf("unexpected broker features %v", info.Broker.Features)
+	}
+
+	versionStatus = http.StatusNotFound
+	info = h.ServerInfo(context.Background(), BuildInfo{Version: "1.5.0"})
+	if info.Broker != nil || info.BrokerError != "broker status 404" {
+		t.Errorf("expected broker error for a broker without /version, got %+v", info)
+	}
+}
+
+func TestExchangeToken(t *testing.T) {
+	var got map[string]any
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /connections/conn-1/exchange", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-API-Key") != "k1" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		got = nil
+		json.NewDecoder(r.Body).Decode(&got)
+		json.NewEncoder(w).Encode(map[string]any{"access_token": "narrow", "issued_token_type": "urn:ietf:params:oauth:token-type:access_token"})
+	})
+	mux.HandleFunc("POST /connections/conn-2/exchange", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/problem+json")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"code": "token_exchange_unsupported", "detail": "The provider does not support token exchange"}`))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	h := NewHandler(server.URL, testStates(t, []byte("12345678901234567890123456789012")), nil, WithBrokerAPIKey("k1"))
+	router := chi.NewRouter()
+	router.Post("/v1/exchange/{connectionID}", h.ExchangeToken)
+
+	exchange := func(id, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("POST", "/v1/exchange/"+id, bytes.NewBufferString(body)))
+		return w
+	}
+
+	w := exchange("conn-1", `{"audience": "billing", "scopes": ["read"]}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("exchange = %d %s", w.Code, w.Body.String())
+	}
+	var out map[string]any
+	json.NewDecoder(w.Body).Decode(&out)
+	if out["access_token"] != "narrow" {
+		t.Errorf("access_token = %v", out["access_token"])
+	}
+	if got["audience"] != "billing" {
+		t.Errorf("broker received %v", got)
+	}
+
+	if w := exchange("conn-1", ""); w.Code != http.StatusOK || got == nil {
+		t.Errorf("exchange without body = %d, broker received %v", w.Code, got)
+	}
+	if w := exchange("conn-1", "[]"); w.Code != http.StatusBadRequest {
+		t.Errorf("exchange with array body = %d", w.Code)
+	}
+
+	w = exchange("conn-2", "")
+	var problem map[string]any
+	json.NewDecoder(w.Body).Decode(&problem)
+	if w.Code != http.StatusBadRequest || problem["code"] != "token_exchange_unsupported" {
+		t.Errorf("unsupported exchange = %d %v", w.Code, problem)
+	}
+}
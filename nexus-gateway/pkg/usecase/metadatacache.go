@@ -0,0 +1,148 @@
+package usecase
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-gateway/pkg/logging"
+)
+
+// metadataRefreshTimeout bounds a background refresh, which outlives the
+// request that started it.
+const metadataRefreshTimeout = 30 * time.Second
+
+// WithMetadataCache caches provider metadata per workspace for ttl. For
+// stale after that, cached metadata is still served while one background
+// request to the Broker refreshes it; a failed refresh keeps the cached
+// copy. Older metadata is fetched before answering, but is still served if
+// the Broker cannot be reached. A zero ttl disables the cache.
+func WithMetadataCache(ttl, stale time.Duration) HandlerOption {
+	return func(o *handlerOptions) {
+		if ttl > 0 {
+			o.metadataCache = newMetadataCache(ttl, stale)
+		}
+	}
+}
+
+// metadataCache holds provider metadata by workspace ID ("" for all
+// workspaces). Cached maps are shared by every caller and never modified.
+type metadataCache struct {
+	ttl   time.Duration
+	stale time.Duration
+	now   func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*metadataEntry
+	// generation is bumped by invalidate, so fetches that started before
+	// it do not store what they got.
+	generation uint64
+}
+
+type metadataEntry struct {
+	metadata   map[string]any
+	fetchedAt  time.Time
+	refreshing bool
+}
+
+type metadataFetch func(ctx context.Context, workspaceID string) (map[string]any, error)
+
+func newMetadataCache(ttl, stale time.Duration) *metadataCache {
+	return &metadataCache{ttl: ttl, stale: stale, now: time.Now, entries: map[string]*metadataEntry{}}
+}
+
+// get returns the metadata for workspaceID from the cache, or from fetch
+// when there is none fresh enough to serve. If fetch fails, metadata
+// cached since the last invalidation is served however old it is.
+func (c *metadataCache) get(ctx context.Context, workspaceID string, fetch metadataFetch) (map[string]any, error) {
+	c.mu.Lock()
+	if e, ok := c.entries[workspaceID]; ok {
+		age := c.now().Sub(e.fetchedAt)
+		if age < c.ttl {
+			c.mu.Unlock()
+			metadataCacheLookups.WithLabelValues("hit").Inc()
+			return e.metadata, nil
+		}
+		if age < c.ttl+c.stale {
+			if !e.refreshing {
+				e.refreshing = true
+				go c.refresh(ctx, workspaceID, c.generation, fetch)
+			}
+			c.mu.Unlock()
+			metadataCacheLookups.WithLabelValues("stale").Inc()
+			return e.metadata, nil
+		}
+	}
+	generation := c.generation
+	c.mu.Unlock()
+	metadataCacheLookups.WithLabelValues("miss").Inc()
+
+	metadata, err := fetch(ctx, workspaceID)
+	if err != nil {
+		if cached, ok := c.cached(workspaceID, generation); ok {
+			logging.Error(ctx, "provider_metadata.serving_stale", map[string]any{"workspace_id": workspaceID, "error": err.Error()})
+			return cached, nil
+		}
+		return nil, err
+	}
+	c.store(workspaceID, generation, metadata)
+	return metadata, nil
+}
+
+// cached returns the entry for workspaceID however old it is, unless the
+// cache was invalidated since generation.
+func (c *metadataCache) cached(workspaceID string, generation uint64) (map[string]any, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[workspaceID]
+	if !ok || generation != c.generation {
+		return nil, false
+	}
+	return e.metadata, true
+}
+
+// refresh replaces a stale entry in the background.
+func (c *metadataCache) refresh(ctx context.Context, workspaceID string, generation uint64, fetch metadataFetch) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), metadataRefreshTimeout)
+	defer cancel()
+	metadata, err := fetch(ctx, workspaceID)
+	if err != nil {
+		logging.Error(ctx, "provider_metadata.refresh_failed", map[string]any{"workspace_id": workspaceID, "error": err.Error()})
+		c.mu.Lock()
+		if e, ok := c.entries[workspaceID]; ok {
+			e.refreshing = false
+		}
+		c.mu.Unlock()
+		return
+	}
+	c.store(workspaceID, generation, metadata)
+}
+
+func (c *metadataCache) store(workspaceID string, generation uint64, metadata map[string]any) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if generation != c.generation {
+		return
+	}
+	c.entries[workspaceID] = &metadataEntry{metadata: metadata, fetchedAt: c.now()}
+}
+
+// invalidate drops every entry and returns how many there were.
+func (c *metadataCache) invalidate() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := len(c.entries)
+	c.entries = map[string]*metadataEntry{}
+	c.generation++
+	return n
+}
+
+// InvalidateMetadataCache drops all cached provider metadata, so the next
+// request for it goes to the Broker. It returns the number of workspaces
+// whose metadata was cached.
+func (h *Handler) InvalidateMetadataCache() int {
+	if h.metadataCache == nil {
+		return 0
+	}
+	return h.metadataCache.invalidate()
+}
@@ -0,0 +1,166 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeMetadata is a metadata fetch that counts calls and can be failed.
+type fakeMetadata struct {
+	mu      sync.Mutex
+	calls   int
+	version string
+	err     error
+	fetched chan struct{}
+}
+
+func (f *fakeMetadata) fetch(ctx context.Context, workspaceID string) (map[string]any, error) {
+	f.mu.Lock()
+	defer func() {
+		f.mu.Unlock()
+		if f.fetched != nil {
+			f.fetched <- struct{}{}
+		}
+	}()
+	f.calls++
+	if f.err != nil {
+		return nil, f.err
+	}
+	return map[string]any{"google": map[string]any{"version": f.version, "workspace": workspaceID}}, nil
+}
+
+func (f *fakeMetadata) set(version string, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.version, f.err = version, err
+}
+
+func version(t *testing.T, m map[string]any) string {
+	t.Helper()
+	return m["google"].(map[string]any)["version"].(string)
+}
+
+func TestMetadataCache_StaleWhileRevalidate(t *testing.T) {
+	now := time.Unix(0, 0)
+	c := newMetadataCache(time.Minute, 5*time.Minute)
+	c.now = func() time.Time { return now }
+	f := &fakeMetadata{version: "v1", fetched: make(chan struct{}, 1)}
+	ctx := context.Background()
+
+	get := func() map[string]any {
+		t.Helper()
+		m, err := c.get(ctx, "ws-a", f.fetch)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return m
+	}
+
+	get()
+	<-f.fetched
+	if v := version(t, get()); v != "v1" || f.calls != 1 {
+		t.Fatalf("expected a cached v1 after one fetch, got %s after %d", v, f.calls)
+	}
+
+	// Stale: served at once while a background fetch fails and then succeeds.
+	now = now.Add(2 * time.Minute)
+	f.set("v2", errors.New("broker down"))
+	if v := version(t, get()); v != "v1" {
+		t.Errorf("expected stale v1 while revalidating, got %s", v)
+	}
+	<-f.fetched
+	waitRefreshed(t, c, "ws-a")
+	if v := version(t, get()); v != "v1" {
+		t.Errorf("expected v1 kept after a failed refresh, got %s", v)
+	}
+	<-f.fetched
+	waitRefreshed(t, c, "ws-a")
+	f.set("v2", nil)
+	get()
+	<-f.fetched
+	waitRefreshed(t, c, "ws-a")
+	if v := version(t, get()); v != "v2" {
+		t.Errorf("expected refreshed v2, got %s", v)
+	}
+
+	// Past the stale window the Broker is asked before answering.
+	now = now.Add(10 * time.Minute)
+	f.set("v3", nil)
+	if v := version(t, get()); v != "v3" {
+		t.Errorf("expected v3 fetched once the stale window passed, got %s", v)
+	}
+	<-f.fetched
+}
+
+// TestMetadataCache_StaleIfError verifies cached metadata is served while
+// the Broker is down past the stale window, but not after an invalidation.
+func TestMetadataCache_StaleIfError(t *testing.T) {
+	now := time.Unix(0, 0)
+	c := newMetadataCache(time.Minute, 5*time.Minute)
+	c.now = func() time.Time { return now }
+	f := &fakeMetadata{version: "v1"}
+	ctx := context.Background()
+
+	if _, err := c.get(ctx, "ws-a", f.fetch); err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(time.Hour)
+	f.set("v2", errors.New("broker down"))
+	m, err := c.get(ctx, "ws-a", f.fetch)
+	if err != nil {
+		t.Fatalf("expected cached metadata while the broker is down, got %v", err)
+	}
+	if v := version(t, m); v != "v1" || f.calls != 2 {
+		t.Errorf("expected v1 after asking the broker, got %s after %d fetches", v, f.calls)
+	}
+
+	c.invalidate()
+	if _, err := c.get(ctx, "ws-a", f.fetch); err == nil {
+		t.Error("expected the broker error once the cache was invalidated")
+	}
+}
+
+func TestMetadataCache_Invalidate(t *testing.T) {
+	c := newMetadataCache(time.Minute, time.Minute)
+	f := &fakeMetadata{version: "v1"}
+	ctx := context.Background()
+
+	c.get(ctx, "", f.fetch)
+	c.get(ctx, "ws-a", f.fetch)
+	if n := c.invalidate(); n != 2 {
+		t.Errorf("expected 2 entries invalidated, got %d", n)
+	}
+	f.set("v2", nil)
+	m, _ := c.get(ctx, "", f.fetch)
+	if v := version(t, m); v != "v2" || f.calls != 3 {
+		t.Errorf("expected a fresh fetch after invalidation, got %s after %d", v, f.calls)
+	}
+
+	// A fetch that started before an invalidation is not cached.
+	generation := c.generation
+	c.invalidate()
+	c.store("ws-b", generation, map[string]any{})
+	if _, ok := c.entries["ws-b"]; ok {
+		t.Error("expected a fetch from before the invalidation to be dropped")
+	}
+}
+
+// waitRefreshed waits for the background refresh of workspaceID to finish.
+func waitRefreshed(t *testing.T, c *metadataCache, workspaceID string) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		c.mu.Lock()
+		e := c.entries[workspaceID]
+		refreshing := e != nil && e.refreshing
+		c.mu.Unlock()
+		if !refreshing {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatal("background refresh did not finish")
+}
@@ -0,0 +1,82 @@
+package usecase
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	brokerRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "broker_requests_total",
+		Help: "Gateway requests to the Broker by operation and outcome (2xx, 4xx, 5xx or error)",
+	}, []string{"operation", "outcome"})
+	brokerDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "broker_request_duration_seconds",
+		Help:    "Duration of gateway requests to the Broker by operation",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"operation"})
+	providerCacheLookups = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "provider_cache_lookups_total",
+		Help: "Provider name to ID lookups by result (hit or miss)",
+	}, []string{"result"})
+	metadataCacheLookups = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "provider_metadata_cache_lookups_total",
+		Help: "Provider metadata requests by result (hit, stale or miss)",
+	}, []string{"result"})
+)
+
+func init() {
+	prometheus.MustRegister(brokerRequests, brokerDuration, providerCacheLookups, metadataCacheLookups)
+}
+
+// brokerTransport records the outcome and latency of every Broker call.
+type brokerTransport struct {
+	next http.RoundTripper
+}
+
+func (t brokerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	op := req.Method + " " + brokerOperation(req.URL.Path)
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	brokerDuration.WithLabelValues(op).Observe(time.Since(start).Seconds())
+	outcome := "error"
+	if err == nil {
+		outcome = fmt.Sprintf("%dxx", resp.StatusCode/100)
+	}
+	brokerRequests.WithLabelValues(op, outcome).Inc()
+	return resp, err
+}
+
+// brokerOperation reduces a Broker path to its route, replacing connection
+// and provider IDs and names with placeholders:
+// /connections/8c1e.../token -> /connections/{id}/token.
+func brokerOperation(path string) string {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	for i := 1; i < len(parts); i++ {
+		switch parts[i-1] {
+		case "connections", "by-name":
+			parts[i] = "{id}"
+		case "providers":
+			if parts[i] != "metadata" && parts[i] != "by-name" && parts[i] != "validate" {
+				parts[i] = "{id}"
+			}
+		}
+	}
+	return "/" + strings.Join(parts, "/")
+}
+
+// instrumentClient returns a copy of client whose requests are recorded by
+// brokerTransport.
+func instrumentClient(client *http.Client) *http.Client {
+	next := client.Transport
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	c := *client
+	c.Transport = brokerTransport{next: next}
+	return &c
+}
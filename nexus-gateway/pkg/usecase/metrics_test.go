@@ -0,0 +1,20 @@
+package usecase
+
+import "testing"
+
+func TestBrokerOperation(t *testing.T) {
+	cases := map[string]string{
+		"/connections/8c1e2a/token":   "/connections/{id}/token",
+		"/connections/8c1e2a/refresh": "/connections/{id}/refresh",
+		"/providers/metadata":         "/providers/metadata",
+		"/providers/1f2e":             "/providers/{id}",
+		"/providers/by-name/acme":     "/providers/by-name/{id}",
+		"/auth/consent-spec":          "/auth/consent-spec",
+		"/readyz":                     "/readyz",
+	}
+	for path, want := range cases {
+		if got := brokerOperation(path); got != want {
+			t.Errorf("brokerOperation(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
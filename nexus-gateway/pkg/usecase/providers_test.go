@@ -0,0 +1,152 @@
+package usecase
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// withProviderID sets the chi {id} route parameter on req.
+func withProviderID(req *http.Request, id string) *http.Request {
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("id", id)
+	return req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+}
+
+func TestPatchProvider_ForwardsMergePatchAndIfMatch(t *testing.T) {
+	const id = "7d8f0c4e-3c1a-4b52-9d7e-2f6a1b0c9e11"
+	var gotBody, gotType, gotIfMatch string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		gotBody, gotType, gotIfMatch = string(b), r.Header.Get("Content-Type"), r.Header.Get("If-Match")
+		w.Header().Set("ETag", `"v2"`)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	h := NewHandler(server.URL, testStates(t, []byte("test-secret-key")), nil)
+
+	body := `{"description": null, "params": {"prompt": null}}`
+	req := httptest.NewRequest("PATCH", "/v1/providers/"+id, strings.NewReader(body))
+	req.Header.Set("If-Match", `"v1"`)
+	w := httptest.NewRecorder()
+	h.PatchProvider(w, withProviderID(req, id))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if gotBody != body {
+		t.Errorf("broker received %q, want the patch unchanged", gotBody)
+	}
+	if gotType != "application/merge-patch+json" {
+		t.Errorf("Content-Type = %q", gotType)
+	}
+	if gotIfMatch != `"v1"` {
+		t.Errorf("If-Match = %q", gotIfMatch)
+	}
+	if etag := w.Header().Get("ETag"); etag != `"v2"` {
+		t.Errorf("ETag = %q", etag)
+	}
+}
+
+func TestPatchProvider_PreconditionFailed(t *testing.T) {
+	const id = "7d8f0c4e-3c1a-4b52-9d7e-2f6a1b0c9e11"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/problem+json")
+		w.WriteHeader(http.StatusPreconditionFailed)
+		w.Write([]byte(`{"code": "precondition_failed", "detail": "Provider was modified since it was read"}`))
+	}))
+	defer server.Close()
+
+	h := NewHandler(server.URL, testStates(t, []byte("test-secret-key")), nil)
+
+	req := httptest.NewRequest("PATCH", "/v1/providers/"+id, strings.NewReader(`{"category": "crm"}`))
+	req.Header.Set("If-Match", `"stale"`)
+	w := httptest.NewRecorder()
+	h.PatchProvider(w, withProviderID(req, id))
+
+	if w.Code != http.StatusPreconditionFailed {
+		t.Fatalf("expected status 412, got %d", w.Code)
+	}
+	var got map[string]any
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got["code"] != "precondition_failed" {
+		t.Errorf("code = %v", got["code"])
+	}
+}
+
+func TestPatchProvider_RejectsNonObject(t *testing.T) {
+	h := NewHandler("http://broker.invalid", testStates(t, []byte("test-secret-key")), nil)
+
+	for _, body := range []string{`null`, `["a"]`, `{`} {
+		req := httptest.NewRequest("PATCH", "/v1/providers/x", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		h.PatchProvider(w, withProviderID(req, "7d8f0c4e-3c1a-4b52-9d7e-2f6a1b0c9e11"))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status 400, got %d", body, w.Code)
+		}
+	}
+}
+
+func TestUpdateProvider_PassesClientSecretNull(t *testing.T) {
+	const id = "7d8f0c4e-3c1a-4b52-9d7e-2f6a1b0c9e11"
+	var got map[string]json.RawMessage
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&got)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	h := NewHandler(server.URL, testStates(t, []byte("test-secret-key")), nil)
+
+	req := httptest.NewRequest("PUT", "/v1/providers/"+id, strings.NewReader(`{"name": "acme", "client_secret": null, "workspace_ids": ["ws-1"]}`))
+	w := httptest.NewRecorder()
+	h.UpdateProvider(w, withProviderID(req, id))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if secret, ok := got["client_secret"]; !ok || string(secret) != "null" {
+		t.Errorf("broker received client_secret %q, want null", secret)
+	}
+	if _, ok := got["workspace_ids"]; !ok {
+		t.Error("broker did not receive workspace_ids")
+	}
+}
+
+func TestGetProvider_RelaysClientSecretSet(t *testing.T) {
+	const id = "7d8f0c4e-3c1a-4b52-9d7e-2f6a1b0c9e11"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte(`{"id": "` + id + `", "name": "acme", "client_secret_set": true}`))
+	}))
+	defer server.Close()
+
+	h := NewHandler(server.URL, testStates(t, []byte("test-secret-key")), nil)
+
+	w := httptest.NewRecorder()
+	h.GetProvider(w, withProviderID(httptest.NewRequest("GET", "/v1/providers/"+id, nil), id))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+	var got map[string]any
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got["client_secret_set"] != true {
+		t.Errorf("client_secret_set = %v", got["client_secret_set"])
+	}
+	if etag := w.Header().Get("ETag"); etag != `"v1"` {
+		t.Errorf("ETag = %q", etag)
+	}
+}
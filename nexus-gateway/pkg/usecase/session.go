@@ -0,0 +1,68 @@
+package usecase
+
+import (
+	"net/http"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-common/state"
+)
+
+// SessionCookie holds the signed browser session that consent flows
+// requested with bind_session are bound to.
+const SessionCookie = "nexus_session"
+
+// browserSession returns the session of the browser making r, taken from
+// its cookie or created, and sets the cookie on w again so it outlives any
+// flow started now. One session serves every flow the browser starts, so
+// concurrent flows do not overwrite each other's cookie.
+func (h *Handler) browserSession(w http.ResponseWriter, r *http.Request) (string, error) {
+	session := h.cookieSession(r)
+	if session == "" {
+		var err error
+		if session, err = state.NewSession(); err != nil {
+			return "", err
+		}
+	}
+	// Lax lets the cookie follow the provider's top-level redirect to the
+	// callback while keeping it off cross-site subrequests.
+	http.SetCookie(w, &http.Cookie{
+		Name:     SessionCookie,
+		Value:    h.states.SignSession(session),
+		Path:     "/",
+		MaxAge:   int(h.states.TTL().Seconds()),
+		HttpOnly: true,
+		Secure:   !h.insecureSessionCookie,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return session, nil
+}
+
+// WithInsecureSessionCookie sets the session cookie without the Secure
+// attribute when insecure is true, so browsers keep it for a Gateway served
+// over plain HTTP. Use it only in development.
+func WithInsecureSessionCookie(insecure bool) HandlerOption {
+	return func(o *handlerOptions) { o.insecureSessionCookie = insecure }
+}
+
+// cookieSession returns the session in r's session cookie, or "" when there
+// is none or its signature does not verify.
+func (h *Handler) cookieSession(r *http.Request) string {
+	c, err := r.Cookie(SessionCookie)
+	if err != nil {
+		return ""
+	}
+	session, err := h.states.VerifySession(c.Value)
+	if err != nil {
+		return ""
+	}
+	return session
+}
+
+// forwardSession passes the session in from's cookie to the Broker request
+// out, which checks it against a bound flow's state. A session header sent
+// by the browser itself is never passed on.
+func (h *Handler) forwardSession(from, out *http.Request) {
+	out.Header.Del(state.SessionHeader)
+	if session := h.cookieSession(from); session != "" {
+		out.Header.Set(state.SessionHeader, session)
+	}
+}
@@ -0,0 +1,117 @@
+package usecase
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-common/state"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-gateway/pkg/broker"
+)
+
+func TestSessionBinding(t *testing.T) {
+	key := []byte("12345678901234567890123456789012")
+	var got broker.ConsentSpecRequest
+	var callbackSession string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/auth/consent-spec", func(w http.ResponseWriter, r *http.Request) {
+		got = broker.ConsentSpecRequest{}
+		json.NewDecoder(r.Body).Decode(&got)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(broker.ConsentSpecResponse{
+			AuthUrl: ptr("https://provider.example.com/authorize"),
+			State:   ptr(generateState(t, key, got.WorkspaceId, *got.ProviderId, "conn-1")),
+		})
+	})
+	mux.HandleFunc("/auth/callback", func(w http.ResponseWriter, r *http.Request) {
+		callbackSession = r.Header.Get(state.SessionHeader)
+		w.WriteHeader(http.StatusFound)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	h := NewHandler(server.URL, testStates(t, key), nil)
+
+	request := func(bind bool, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(map[string]any{
+			"user_id": "ws", "provider_id": "files", "return_url": "http://localhost:3000/cb", "bind_session": bind,
+		})
+		req := httptest.NewRequest("POST", "/v1/request-connection", bytes.NewReader(body))
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		w := httptest.NewRecorder()
+		h.RequestConnection(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("request-connection = %d %s", w.Code, w.Body.String())
+		}
+		return w
+	}
+
+	// Unbound flows set no cookie and send no hash.
+	if w := request(false); len(w.Result().Cookies()) != 0 || got.SessionHash != nil {
+		t.Errorf("unbound flow: cookies %v, session_hash %v", w.Result().Cookies(), got.SessionHash)
+	}
+
+	w := request(true)
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != SessionCookie || !cookies[0].HttpOnly || !cookies[0].Secure {
+		t.Fatalf("bound flow cookies = %+v", cookies)
+	}
+	session, err := testStates(t, key).VerifySession(cookies[0].Value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.SessionHash == nil || *got.SessionHash != state.HashSession(session) {
+		t.Fatalf("session_hash = %v, want the hash of the cookie's session", got.SessionHash)
+	}
+
+	// A second flow from the same browser keeps its session.
+	request(true, cookies[0])
+	if got.SessionHash == nil || *got.SessionHash != state.HashSession(session) {
+		t.Errorf("second flow session_hash = %v, want the first session's", got.SessionHash)
+	}
+
+	// The callback forwards the cookie's session, never a header the
+	// browser made up or a cookie that does not verify.
+	callback := func(header string, c *http.Cookie) string {
+		req := httptest.NewRequest("GET", "/auth/callback?code=abc&state=s", nil)
+		if header != "" {
+			req.Header.Set(state.SessionHeader, header)
+		}
+		if c != nil {
+			req.AddCookie(c)
+		}
+		callbackSession = "unset"
+		h.ProxyCallback(httptest.NewRecorder(), req)
+		return callbackSession
+	}
+	if s := callback("", cookies[0]); s != session {
+		t.Errorf("callback forwarded %q, want the cookie's session", s)
+	}
+	if s := callback("forged", nil); s != "" {
+		t.Errorf("callback forwarded %q from a request header", s)
+	}
+	if s := callback("", &http.Cookie{Name: SessionCookie, Value: session + ".k.bad"}); s != "" {
+		t.Errorf("callback forwarded %q from an unsigned cookie", s)
+	}
+}
+
+// TestSessionCookie_Insecure verifies the Secure attribute is dropped only
+// when the Gateway is configured for plain HTTP.
+func TestSessionCookie_Insecure(t *testing.T) {
+	states := testStates(t, []byte("12345678901234567890123456789012"))
+	for _, insecure := range []bool{false, true} {
+		h := NewHandler("http://broker.invalid", states, nil, WithInsecureSessionCookie(insecure))
+		w := httptest.NewRecorder()
+		if _, err := h.browserSession(w, httptest.NewRequest("POST", "/v1/request-connection", nil)); err != nil {
+			t.Fatal(err)
+		}
+		cookies := w.Result().Cookies()
+		if len(cookies) != 1 || cookies[0].Secure == insecure {
+			t.Errorf("insecure=%v: cookies %v", insecure, cookies)
+		}
+	}
+}
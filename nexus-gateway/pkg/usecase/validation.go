@@ -0,0 +1,204 @@
+package usecase
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// ErrValidation is matched by every *ValidationError.
+var ErrValidation = errors.New("validation_failed")
+
+// ValidationError reports the input fields that failed validation, keyed by
+// field name (user_id, return_url, scopes[2]) with a short reason each. The
+// REST API returns Fields as is; the gRPC API turns them into BadRequest
+// field violations.
+type ValidationError struct {
+	Fields map[string]string
+}
+
+func (e *ValidationError) Error() string {
+	names := make([]string, 0, len(e.Fields))
+	for name := range e.Fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	parts := make([]string, len(names))
+	for i, name := range names {
+		parts[i] = name + ": " + e.Fields[name]
+	}
+	return "validation_failed: " + strings.Join(parts, "; ")
+}
+
+func (e *ValidationError) Unwrap() error { return ErrValidation }
+
+// Input limits. They are well above anything a real caller sends and only
+// keep oversized values from reaching the Broker and its database.
+const (
+	maxUserIDLength    = 255
+	maxScopeLength     = 256
+	maxScopes          = 100
+	maxReturnURLLength = 2048
+	maxTenantLength    = 253
+	maxSubjectLength   = 254
+)
+
+// validator collects field errors; the first error for a field wins.
+type validator map[string]string
+
+func (v validator) add(field, format string, args ...any) {
+	if _, ok := v[field]; !ok {
+		v[field] = fmt.Sprintf(format, args...)
+	}
+}
+
+func (v validator) err() error {
+	if len(v) == 0 {
+		return nil
+	}
+	return &ValidationError{Fields: v}
+}
+
+// validateRequestConnection checks the caller-supplied fields of a consent
+// request before anything is sent to the Broker.
+func validateRequestConnection(in RequestConnectionInput) error {
+	v := validator{}
+	validateUserID(v, in.UserID)
+	validateScopes(v, in.Scopes)
+	validateReturnURL(v, in.ReturnURL)
+	validateTenant(v, in.Tenant)
+	validateSubject(v, in.Subject)
+	return v.err()
+}
+
+// validateTenant accepts the characters of Azure AD tenant names, IDs and
+// domains. Whether the value names a tenant is left to the Broker.
+func validateTenant(v validator, tenant string) {
+	if tenant == "" {
+		return
+	}
+	if len(tenant) > maxTenantLength {
+		v.add("tenant", "must be at most %d characters", maxTenantLength)
+		return
+	}
+	for _, r := range tenant {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '-') {
+			v.add("tenant", "may only contain letters, digits, dots and hyphens")
+			return
+		}
+	}
+}
+
+// validateSubject accepts a single email address without whitespace or
+// control characters. Whether delegation to it is allowed is left to the
+// provider.
+func validateSubject(v validator, subject string) {
+	if subject == "" {
+		return
+	}
+	if len(subject) > maxSubjectLength {
+		v.add("subject", "must be at most %d characters", maxSubjectLength)
+		return
+	}
+	for _, r := range subject {
+		if r <= ' ' || r == 0x7f {
+			v.add("subject", "must not contain whitespace or control characters")
+			return
+		}
+	}
+	if at := strings.LastIndex(subject, "@"); at <= 0 || at == len(subject)-1 {
+		v.add("subject", "must be an email address")
+	}
+}
+
+// validateUserID accepts the characters of common identity-provider
+// subjects and emails (auth0|123, jane+ci@example.com). User IDs end up in
+// logs, metric labels and URLs, so whitespace and control characters are
+// rejected.
+func validateUserID(v validator, userID string) {
+	if userID == "" {
+		return
+	}
+	if len(userID) > maxUserIDLength {
+		v.add("user_id", "must be at most %d characters", maxUserIDLength)
+		return
+	}
+	for _, r := range userID {
+		if !isUserIDRune(r) {
+			v.add("user_id", "may only contain letters, digits and . _ ~ : @ + | -")
+			return
+		}
+	}
+}
+
+func isUserIDRune(r rune) bool {
+	switch {
+	case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		return true
+	}
+	return strings.ContainsRune("._~:@+|-", r)
+}
+
+// validateScopes checks each scope against the RFC 6749 scope-token syntax.
+// Commas are rejected too: an entry that contains a separator usually means
+// the list was passed as a single string.
+func validateScopes(v validator, scopes []string) {
+	if len(scopes) > maxScopes {
+		v.add("scopes", "must list at most %d scopes", maxScopes)
+		return
+	}
+	for i, s := range scopes {
+		field := fmt.Sprintf("scopes[%d]", i)
+		switch {
+		case s == "":
+			v.add(field, "must not be empty")
+		case len(s) > maxScopeLength:
+			v.add(field, "must be at most %d characters", maxScopeLength)
+		case strings.ContainsAny(s, " ,\t\r\n"):
+			v.add(field, "must be a single scope; list each scope as its own entry")
+		default:
+			for _, r := range s {
+				if r < 0x21 || r > 0x7e || r == '"' || r == '\\' {
+					v.add(field, "contains a character not allowed in a scope")
+					break
+				}
+			}
+		}
+	}
+}
+
+// validateReturnURL requires an absolute https URL. Plain http is accepted
+// for loopback hosts so local development and CLI callbacks keep working.
+func validateReturnURL(v validator, raw string) {
+	if raw == "" {
+		return
+	}
+	if len(raw) > maxReturnURLLength {
+		v.add("return_url", "must be at most %d characters", maxReturnURLLength)
+		return
+	}
+	u, err := url.Parse(raw)
+	if err != nil || !u.IsAbs() || u.Host == "" {
+		v.add("return_url", "must be an absolute URL")
+		return
+	}
+	switch {
+	case u.User != nil:
+		v.add("return_url", "must not contain credentials")
+	case u.Scheme == "https":
+	case u.Scheme == "http" && isLoopback(u.Hostname()):
+	default:
+		v.add("return_url", "must be https")
+	}
+}
+
+func isLoopback(host string) bool {
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
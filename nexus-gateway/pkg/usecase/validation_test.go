@@ -0,0 +1,93 @@
+package usecase
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestValidateRequestConnection(t *testing.T) {
+	cases := []struct {
+		name string
+		in   RequestConnectionInput
+		want map[string]string
+	}{
+		{"valid", RequestConnectionInput{UserID: "auth0|jane+ci@example.com", Scopes: []string{"openid", "https://www.googleapis.com/auth/drive"}, ReturnURL: "https://app.example.com/cb"}, nil},
+		{"loopback http", RequestConnectionInput{ReturnURL: "http://127.0.0.1:8765/cb"}, nil},
+		{"localhost http", RequestConnectionInput{ReturnURL: "http://localhost:3000/cb"}, nil},
+		{"http", RequestConnectionInput{ReturnURL: "http://app.example.com/cb"}, map[string]string{"return_url": "must be https"}},
+		{"custom scheme", RequestConnectionInput{ReturnURL: "javascript:alert(1)"}, map[string]string{"return_url": "must be an absolute URL"}},
+		{"relative", RequestConnectionInput{ReturnURL: "/cb"}, map[string]string{"return_url": "must be an absolute URL"}},
+		{"credentials", RequestConnectionInput{ReturnURL: "https://user:pw@app.example.com/cb"}, map[string]string{"return_url": "must not contain credentials"}},
+		{"user id charset", RequestConnectionInput{UserID: "jane doe", ReturnURL: "https://a"}, map[string]string{"user_id": "may only contain letters, digits and . _ ~ : @ + | -"}},
+		{"user id length", RequestConnectionInput{UserID: strings.Repeat("a", maxUserIDLength+1)}, map[string]string{"user_id": "must be at most 255 characters"}},
+		{"tenant", RequestConnectionInput{Tenant: "organizations"}, nil},
+		{"tenant domain", RequestConnectionInput{Tenant: "contoso.onmicrosoft.com"}, nil},
+		{"tenant charset", RequestConnectionInput{Tenant: "../common"}, map[string]string{"tenant": "may only contain letters, digits, dots and hyphens"}},
+		{"subject", RequestConnectionInput{Subject: "jane@example.com"}, nil},
+		{"subject not an email", RequestConnectionInput{Subject: "jane"}, map[string]string{"subject": "must be an email address"}},
+		{"subject whitespace", RequestConnectionInput{Subject: "jane @example.com"}, map[string]string{"subject": "must not contain whitespace or control characters"}},
+		{"scopes", RequestConnectionInput{Scopes: []string{"openid", "", "email profile", "a\"b"}}, map[string]string{
+			"scopes[1]": "must not be empty",
+			"scopes[2]": "must be a single scope; list each scope as its own entry",
+			"scopes[3]": "contains a character not allowed in a scope",
+		}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := validateRequestConnection(tc.in)
+			if tc.want == nil {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			var ve *ValidationError
+			if !errors.As(err, &ve) || !errors.Is(err, ErrValidation) {
+				t.Fatalf("expected a ValidationError, got %v", err)
+			}
+			if len(ve.Fields) != len(tc.want) {
+				t.Fatalf("expected fields %v, got %v", tc.want, ve.Fields)
+			}
+			for k, v := range tc.want {
+				if ve.Fields[k] != v {
+					t.Errorf("field %s: expected %q, got %q", k, v, ve.Fields[k])
+				}
+			}
+		})
+	}
+}
+
+func TestRequestConnection_ValidationFailed(t *testing.T) {
+	broker := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("invalid input reached the broker: %s", r.URL.Path)
+	}))
+	defer broker.Close()
+	h := NewHandler(broker.URL, testStates(t, []byte("12345678901234567890123456789012")), nil)
+
+	body, _ := json.Marshal(map[string]any{
+		"user_id":     "ws 1",
+		"provider_id": "p1",
+		"return_url":  "http://app.example.com/cb",
+	})
+	w := httptest.NewRecorder()
+	h.RequestConnection(w, httptest.NewRequest("POST", "/v1/request-connection", bytes.NewReader(body)))
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d: %s", w.Code, w.Body.String())
+	}
+	var resp struct {
+		Error  string            `json:"error"`
+		Fields map[string]string `json:"fields"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Error != "validation_failed" || resp.Fields["return_url"] != "must be https" || resp.Fields["user_id"] == "" {
+		t.Errorf("unexpected body %+v", resp)
+	}
+}
@@ -0,0 +1,292 @@
+package usecase
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/gorilla/websocket"
+
+	bridgeauth "github.com/Prescott-Data/nexus-framework/nexus-bridge/pkg/auth"
+	"github.com/Prescott-Data/nexus-framework/nexus-gateway/pkg/auth"
+	"github.com/Prescott-Data/nexus-framework/nexus-gateway/pkg/logging"
+)
+
+// WSTicketParam is the query parameter of a /v1/ws upgrade that carries a
+// ticket from CreateWebSocketTicket, for browsers, which cannot set an
+// Authorization header on a WebSocket.
+const WSTicketParam = "ticket"
+
+// wsTicketTTL is how long a ticket may wait to be redeemed.
+const wsTicketTTL = 30 * time.Second
+
+// wsProxyConfig restricts where ProxyWebSocket may connect and which browser
+// origins may use it. An empty host list disables the proxy.
+type wsProxyConfig struct {
+	allowedHosts   map[string]bool
+	allowedOrigins map[string]bool
+	allowNoOrigin  bool
+	tickets        *wsTickets
+}
+
+// WithWebSocketProxy enables ProxyWebSocket for upstream hosts in
+// allowedHosts (host or host:port) and browser origins in allowedOrigins.
+// Upgrades without an Origin header are refused unless allowNoOrigin is
+// set.
+func WithWebSocketProxy(allowedHosts, allowedOrigins []string, allowNoOrigin bool) HandlerOption {
+	return func(o *handlerOptions) {
+		o.wsProxy.allowNoOrigin = allowNoOrigin
+		o.wsProxy.tickets = &wsTickets{tickets: map[string]wsTicket{}}
+		o.wsProxy.allowedHosts = make(map[string]bool, len(allowedHosts))
+		for _, host := range allowedHosts {
+			if host = strings.ToLower(strings.TrimSpace(host)); host != "" {
+				o.wsProxy.allowedHosts[host] = true
+			}
+		}
+		o.wsProxy.allowedOrigins = make(map[string]bool, len(allowedOrigins))
+		for _, origin := range allowedOrigins {
+			o.wsProxy.allowedOrigins[strings.TrimSpace(origin)] = true
+		}
+	}
+}
+
+// targetAllowed reports whether u may be dialed by the proxy. Credentials are
+// injected into the upstream request, so an open target would hand them to
+// whoever controls the URL.
+func (c wsProxyConfig) targetAllowed(u *url.URL) bool {
+	if u.Scheme != "wss" && u.Scheme != "ws" {
+		return false
+	}
+	host := strings.ToLower(u.Host)
+	return c.allowedHosts[host] || c.allowedHosts[strings.ToLower(u.Hostname())]
+}
+
+// originAllowed guards against cross-site WebSocket hijacking. Requests
+// without an Origin header come from non-browser clients and are allowed
+// only when allowNoOrigin is set.
+func (c wsProxyConfig) originAllowed(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return c.allowNoOrigin
+	}
+	return c.allowedOrigins["*"] || c.allowedOrigins[origin]
+}
+
+// wsTickets holds the tickets issued and not yet redeemed. A ticket is
+// redeemed at the Gateway instance that issued it.
+type wsTickets struct {
+	mu      sync.Mutex
+	tickets map[string]wsTicket
+}
+
+type wsTicket struct {
+	connectionID string
+	caller       *auth.Caller // nil when caller authentication is off
+	expiresAt    time.Time
+}
+
+// issue returns a new ticket to connectionID for caller, dropping the
+// expired ones.
+func (t *wsTickets) issue(connectionID string, caller *auth.Caller) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	ticket := base64.RawURLEncoding.EncodeToString(b)
+	now := time.Now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for k, v := range t.tickets {
+		if now.After(v.expiresAt) {
+			delete(t.tickets, k)
+		}
+	}
+	t.tickets[ticket] = wsTicket{connectionID: connectionID, caller: caller, expiresAt: now.Add(wsTicketTTL)}
+	return ticket, nil
+}
+
+// redeem spends ticket and returns the caller it was issued to. It fails
+// for a ticket that is unknown, expired, already spent or issued for
+// another connection.
+func (t *wsTickets) redeem(ticket, connectionID string) (*auth.Caller, bool) {
+	t.mu.Lock()
+	v, ok := t.tickets[ticket]
+	delete(t.tickets, ticket)
+	t.mu.Unlock()
+	if !ok || v.connectionID != connectionID || time.Now().After(v.expiresAt) {
+		return nil, false
+	}
+	return v.caller, true
+}
+
+// CreateWebSocketTicket issues a ticket that opens
+// /v1/ws/{connectionID} once, within wsTicketTTL, without caller
+// credentials. The request for it is authenticated like any other /v1 call;
+// the ticket then goes in the upgrade URL as WSTicketParam.
+func (h *Handler) CreateWebSocketTicket(w http.ResponseWriter, r *http.Request) {
+	if len(h.wsProxy.allowedHosts) == 0 {
+		writeError(w, http.StatusNotFound, "ws_proxy_disabled", "websocket proxy is not enabled", nil)
+		return
+	}
+	connectionID := strings.TrimSpace(chi.URLParam(r, "connectionID"))
+	if connectionID == "" {
+		writeError(w, http.StatusBadRequest, "missing_fields", "connection id is required", nil)
+		return
+	}
+	caller, _ := auth.CallerFrom(r.Context())
+	ticket, err := h.wsProxy.tickets.issue(connectionID, caller)
+	if err != nil {
+		logging.Error(r.Context(), "ws_ticket.error", map[string]any{"connection_id": connectionID, "error": err.Error()})
+		writeError(w, http.StatusInternalServerError, "internal_error", "could not issue ticket", nil)
+		return
+	}
+	writeJSON(w, http.StatusCreated, map[string]any{"ticket": ticket, "expires_in": int(wsTicketTTL.Seconds())})
+}
+
+// ProxyWebSocket upgrades a browser WebSocket on
+// GET /v1/ws/{connectionID}?target=wss://... and relays frames to the target,
+// applying the connection's auth strategy to the upstream handshake. The
+// browser never sees the credentials. The caller authenticates with its
+// usual credentials or, from a browser, with a ticket.
+func (h *Handler) ProxyWebSocket(w http.ResponseWriter, r *http.Request) {
+	if len(h.wsProxy.allowedHosts) == 0 {
+		writeError(w, http.StatusNotFound, "ws_proxy_disabled", "websocket proxy is not enabled", nil)
+		return
+	}
+	if !websocket.IsWebSocketUpgrade(r) {
+		writeError(w, http.StatusBadRequest, "websocket_required", "expected a websocket upgrade request", nil)
+		return
+	}
+	if !h.wsProxy.originAllowed(r) {
+		writeError(w, http.StatusForbidden, "origin_not_allowed", "origin not allowed", nil)
+		return
+	}
+
+	connectionID := strings.TrimSpace(chi.URLParam(r, "connectionID"))
+	target, err := url.Parse(r.URL.Query().Get("target"))
+	if connectionID == "" || err != nil || target.Host == "" {
+		writeError(w, http.StatusBadRequest, "missing_fields", "connection id and absolute target URL are required", nil)
+		return
+	}
+	ctx := r.Context()
+	if ticket := r.URL.Query().Get(WSTicketParam); ticket != "" {
+		caller, ok := h.wsProxy.tickets.redeem(ticket, connectionID)
+		if !ok {
+			writeError(w, http.StatusUnauthorized, "invalid_ticket", "websocket ticket is invalid, expired or already used", nil)
+			return
+		}
+		if caller != nil {
+			ctx = auth.WithCaller(ctx, caller)
+		}
+	}
+	if !h.wsProxy.targetAllowed(target) {
+		writeError(w, http.StatusForbidden, "target_not_allowed", "target host is not allowed", map[string]any{"host": target.Host})
+		return
+	}
+
+	tokenMap, status, err := h.GetTokenCore(ctx, connectionID)
+	if err != nil {
+		logging.Error(ctx, "ws_proxy.token_error", map[string]any{"connection_id": connectionID, "error": err.Error()})
+		writeError(w, http.StatusBadGateway, "broker_unavailable", "broker request failed", nil)
+		return
+	}
+	if status != http.StatusOK {
+		writeError(w, status, "token_unavailable", "connection token unavailable", map[string]any{"broker_status": status})
+		return
+	}
+	token := bridgeToken(tokenMap)
+
+	upstreamReq, err := bridgeauth.NewAuthenticatedRequest(http.MethodGet, target.String(), token)
+	if err != nil {
+		logging.Error(ctx, "ws_proxy.auth_error", map[string]any{"connection_id": connectionID, "error": err.Error()})
+		writeError(w, http.StatusBadGateway, "auth_injection_failed", "could not apply connection credentials", nil)
+		return
+	}
+	for _, proto := range r.Header.Values("Sec-WebSocket-Protocol") {
+		upstreamReq.Header.Add("Sec-WebSocket-Protocol", proto)
+	}
+
+	// Dial uses the headers and the potentially modified URL (for query params).
+	upstream, resp, err := websocket.DefaultDialer.DialContext(ctx, upstreamReq.URL.String(), upstreamReq.Header)
+	if err != nil {
+		fields := map[string]any{"connection_id": connectionID, "host": target.Host, "error": err.Error()}
+		if resp != nil {
+			fields["status"] = resp.StatusCode
+		}
+		logging.Error(ctx, "ws_proxy.dial_failed", fields)
+		writeError(w, http.StatusBadGateway, "upstream_dial_failed", "could not connect to target", nil)
+		return
+	}
+
+	respHeader := http.Header{}
+	if proto := upstream.Subprotocol(); proto != "" {
+		respHeader.Set("Sec-WebSocket-Protocol", proto)
+	}
+	upgrader := websocket.Upgrader{CheckOrigin: h.wsProxy.originAllowed}
+	client, err := upgrader.Upgrade(w, r, respHeader)
+	if err != nil {
+		// Upgrade has already written an error response.
+		upstream.Close()
+		return
+	}
+
+	logging.Info(ctx, "ws_proxy.open", map[string]any{"connection_id": connectionID, "host": target.Host})
+	errc := make(chan error, 2)
+	go relayFrames(client, upstream, errc)
+	go relayFrames(upstream, client, errc)
+	err = <-errc
+	client.Close()
+	upstream.Close()
+	<-errc
+	logging.Info(ctx, "ws_proxy.closed", map[string]any{"connection_id": connectionID, "reason": err.Error()})
+}
+
+// relayFrames copies messages from src to dst until src fails, then forwards
+// the close code so each side sees why the session ended.
+func relayFrames(dst, src *websocket.Conn, errc chan<- error) {
+	for {
+		msgType, msg, err := src.ReadMessage()
+		if err != nil {
+			code, text := websocket.CloseGoingAway, ""
+			var closeErr *websocket.CloseError
+			if errors.As(err, &closeErr) && closeErr.Code != websocket.CloseAbnormalClosure {
+				code, text = closeErr.Code, closeErr.Text
+			}
+			_ = dst.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, text), time.Now().Add(time.Second))
+			errc <- err
+			return
+		}
+		if err := dst.WriteMessage(msgType, msg); err != nil {
+			errc <- err
+			return
+		}
+	}
+}
+
+// bridgeToken converts the broker token payload into the bridge's Token so
+// its auth strategies can be reused.
+func bridgeToken(tokenMap map[string]any) *bridgeauth.Token {
+	token := &bridgeauth.Token{Strategy: bridgeauth.AuthStrategy{Type: "oauth2"}, Credentials: bridgeauth.Credentials{}}
+	if s, ok := tokenMap["strategy"].(map[string]any); ok {
+		if t, _ := s["type"].(string); t != "" {
+			token.Strategy.Type = t
+		}
+		token.Strategy.Config, _ = s["config"].(map[string]any)
+	}
+	if c, ok := tokenMap["credentials"].(map[string]any); ok {
+		token.Credentials = c
+	}
+	// Older broker responses flatten OAuth2 credentials into the root.
+	if _, ok := token.Credentials["access_token"]; !ok {
+		if at, ok := tokenMap["access_token"]; ok {
+			token.Credentials["access_token"] = at
+		}
+	}
+	return token
+}
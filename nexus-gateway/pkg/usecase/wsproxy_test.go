@@ -0,0 +1,191 @@
+package usecase
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/gorilla/websocket"
+)
+
+// newWSProxyFixture starts an upstream echo server that records the
+// Authorization header it receives, a mock broker serving a header-strategy
+// token, and a gateway router exposing ProxyWebSocket.
+func newWSProxyFixture(t *testing.T, allowedOrigins []string, allowNoOrigin bool) (gateway, upstream *httptest.Server, gotAuth *string) {
+	t.Helper()
+	gotAuth = new(string)
+
+	upstream = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*gotAuth = r.Header.Get("Authorization")
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			mt, msg, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if err := conn.WriteMessage(mt, msg); err != nil {
+				return
+			}
+		}
+	}))
+	t.Cleanup(upstream.Close)
+
+	broker := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/connections/conn-1/token" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"strategy":    map[string]any{"type": "header", "config": map[string]any{"header_name": "Authorization", "value_prefix": "Bearer ", "credential_field": "api_key"}},
+			"credentials": map[string]any{"api_key": "server-side-secret"},
+		})
+	}))
+	t.Cleanup(broker.Close)
+
+	upstreamHost := strings.TrimPrefix(upstream.URL, "http://")
+	h := NewHandler(broker.URL, testStates(t, []byte("test-secret-key")), nil, WithWebSocketProxy([]string{upstreamHost}, allowedOrigins, allowNoOrigin))
+	r := chi.NewRouter()
+	r.Get("/v1/ws/{connectionID}", h.ProxyWebSocket)
+	r.Post("/v1/ws-ticket/{connectionID}", h.CreateWebSocketTicket)
+	gateway = httptest.NewServer(r)
+	t.Cleanup(gateway.Close)
+	return gateway, upstream, gotAuth
+}
+
+func wsProxyURL(gateway *httptest.Server, connectionID, target string) string {
+	return "ws" + strings.TrimPrefix(gateway.URL, "http") + "/v1/ws/" + connectionID + "?target=" + url.QueryEscape(target)
+}
+
+// TestProxyWebSocket_InjectsCredentials verifies frames are relayed and the
+// upstream handshake carries the connection's credentials.
+func TestProxyWebSocket_InjectsCredentials(t *testing.T) {
+	gateway, upstream, gotAuth := newWSProxyFixture(t, []string{"https://app.example.com"}, false)
+	target := "ws" + strings.TrimPrefix(upstream.URL, "http") + "/stream"
+
+	header := http.Header{"Origin": {"https://app.example.com"}}
+	conn, _, err := websocket.DefaultDialer.Dial(wsProxyURL(gateway, "conn-1", target), header)
+	if err != nil {
+		t.Fatalf("dial proxy: %v", err)
+	}
+	defer conn.Close()
+
+	if err := conn.WriteMessage(websocket.TextMessage, []byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	_, msg, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(msg) != "ping" {
+		t.Errorf("expected echo, got %q", msg)
+	}
+	if *gotAuth != "Bearer server-side-secret" {
+		t.Errorf("expected injected Authorization header, got %q", *gotAuth)
+	}
+}
+
+// TestProxyWebSocket_Rejects verifies target and origin restrictions are
+// enforced before any credentials are fetched.
+func TestProxyWebSocket_Rejects(t *testing.T) {
+	gateway, upstream, _ := newWSProxyFixture(t, []string{"https://app.example.com"}, false)
+	allowed := "ws" + strings.TrimPrefix(upstream.URL, "http") + "/stream"
+
+	tests := []struct {
+		name   string
+		target string
+		origin string
+		want   int
+	}{
+		{name: "host not allowed", target: "wss://evil.example.com/stream", origin: "https://app.example.com", want: http.StatusForbidden},
+		{name: "non websocket scheme", target: strings.Replace(allowed, "ws://", "http://", 1), origin: "https://app.example.com", want: http.StatusForbidden},
+		{name: "origin not allowed", target: allowed, origin: "https://evil.example.com", want: http.StatusForbidden},
+		{name: "no origin", target: allowed, want: http.StatusForbidden},
+		{name: "unknown connection", target: allowed, origin: "https://app.example.com", want: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			connectionID := "conn-1"
+			if tt.want == http.StatusNotFound {
+				connectionID = "missing"
+			}
+			header := http.Header{}
+			if tt.origin != "" {
+				header.Set("Origin", tt.origin)
+			}
+			_, resp, err := websocket.DefaultDialer.Dial(wsProxyURL(gateway, connectionID, tt.target), header)
+			if err == nil {
+				t.Fatal("expected handshake to fail")
+			}
+			if resp == nil || resp.StatusCode != tt.want {
+				t.Fatalf("expected status %d, got %v", tt.want, resp)
+			}
+		})
+	}
+}
+
+// TestProxyWebSocket_NoOrigin verifies a client without an Origin header is
+// served once the proxy allows it.
+func TestProxyWebSocket_NoOrigin(t *testing.T) {
+	gateway, upstream, _ := newWSProxyFixture(t, []string{"https://app.example.com"}, true)
+	target := "ws" + strings.TrimPrefix(upstream.URL, "http") + "/stream"
+
+	conn, _, err := websocket.DefaultDialer.Dial(wsProxyURL(gateway, "conn-1", target), nil)
+	if err != nil {
+		t.Fatalf("dial proxy: %v", err)
+	}
+	conn.Close()
+}
+
+// TestProxyWebSocket_Ticket verifies a ticket opens its connection once.
+func TestProxyWebSocket_Ticket(t *testing.T) {
+	gateway, upstream, _ := newWSProxyFixture(t, []string{"https://app.example.com"}, false)
+	target := "ws" + strings.TrimPrefix(upstream.URL, "http") + "/stream"
+	header := http.Header{"Origin": {"https://app.example.com"}}
+
+	issue := func() string {
+		resp, err := http.Post(gateway.URL+"/v1/ws-ticket/conn-1", "application/json", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var body struct {
+			Ticket string `json:"ticket"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&body); resp.StatusCode != http.StatusCreated || err != nil || body.Ticket == "" {
+			t.Fatalf("ticket = %d %+v, %v", resp.StatusCode, body, err)
+		}
+		return body.Ticket
+	}
+	dial := func(connectionID, ticket string) int {
+		conn, resp, err := websocket.DefaultDialer.Dial(wsProxyURL(gateway, connectionID, target)+"&ticket="+ticket, header)
+		if err == nil {
+			conn.Close()
+			return http.StatusSwitchingProtocols
+		}
+		if resp == nil {
+			t.Fatalf("dial: %v", err)
+		}
+		return resp.StatusCode
+	}
+
+	ticket := issue()
+	if got := dial("conn-1", ticket); got != http.StatusSwitchingProtocols {
+		t.Fatalf("first use = %d", got)
+	}
+	if got := dial("conn-1", ticket); got != http.StatusUnauthorized {
+		t.Errorf("second use = %d, want 401", got)
+	}
+	if got := dial("missing", issue()); got != http.StatusUnauthorized {
+		t.Errorf("ticket for another connection = %d, want 401", got)
+	}
+}
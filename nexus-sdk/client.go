@@ -2,6 +2,7 @@ package oauthsdk
 
 import (
     "context"
+    "crypto"
     "encoding/json"
     "errors"
     "fmt"
@@ -11,10 +12,13 @@ import (
     "net/http"
     "net/url"
     "strings"
+    "sync"
     "time"
+
+    "github.com/Prescott-Data/nexus-framework/nexus-sdk/redact"
 )
 
-// Client is a thin HTTP client for the Dromos OAuth Gateway.
+// Client is a thin HTTP client for the Nexus Gateway.
 type Client struct {
     GatewayBaseURL string
     HTTPClient     *http.Client
@@ -23,11 +27,16 @@ type Client struct {
     BrokerBaseURL string
     BrokerAPIKey  string
 
-    // Optional logger and retry policy
+    // Optional logger, retry policy and metrics
     Logger      Logger
     RetryPolicy RetryPolicy
+    Metrics     Metrics
 
-    randSource *rand.Rand
+    retryBudget  *retryBudget
+    responseKeys []crypto.PublicKey
+    providers    providerCache
+    randMu       sync.Mutex
+    randSource   *rand.Rand
 }
 
 // New creates a new Client with sane defaults.
@@ -36,6 +45,7 @@ func New(gatewayBaseURL string, opts ...Option) *Client {
         GatewayBaseURL: strings.TrimRight(gatewayBaseURL, "/"),
         HTTPClient:     &http.Client{Timeout: 30 * time.Second},
         randSource:     rand.New(rand.NewSource(time.Now().UnixNano())),
+        providers:      providerCache{ttl: DefaultProviderCacheTTL},
     }
     for _, o := range opts {
         o(c)
@@ -61,6 +71,20 @@ type Logger interface {
     Errorf(format string, args ...any)
 }
 
+// infof and errorf log through c.Logger, if set, with credentials such as
+// tokens in URLs or error bodies scrubbed (see package redact).
+func (c *Client) infof(format string, args ...any) {
+    if c.Logger != nil {
+        c.Logger.Infof("%s", redact.String(fmt.Sprintf(format, args...)))
+    }
+}
+
+func (c *Client) errorf(format string, args ...any) {
+    if c.Logger != nil {
+        c.Logger.Errorf("%s", redact.String(fmt.Sprintf(format, args...)))
+    }
+}
+
 // RetryPolicy configures basic retry behavior.
 type RetryPolicy struct {
     Retries    int           // total attempts = Retries + 1
@@ -83,6 +107,13 @@ type RequestConnectionInput struct {
     ProviderName string   `json:"provider_name"`
     Scopes       []string `json:"scopes"`
     ReturnURL    string   `json:"return_url"`
+    // Tenant is the Azure AD tenant to sign in with: "common",
+    // "organizations", "consumers", a tenant ID or a domain. Leave it empty
+    // for other providers.
+    Tenant       string   `json:"tenant,omitempty"`
+    // Subject is the email of the user a service account connection acts
+    // as through domain-wide delegation. Leave it empty for other providers.
+    Subject      string   `json:"subject,omitempty"`
     Metadata     any      `json:"metadata,omitempty"`
 }
 
@@ -94,7 +125,16 @@ type RequestConnectionResponse struct {
     ProviderID   string   `json:"provider_id,omitempty"`
 }
 
-type ConnectionStatusResponse struct { Status string `json:"status"` }
+// ConnectionStatusResponse is a connection's status and the reason for it.
+// Reason tells a temporary problem (refresh_failing, suspended) from one the
+// user must fix by consenting again (reauth_required, consent_expired, ...);
+// Gateways predating reason codes leave it empty. ErrorCode says why a
+// failed consent failed, e.g. token_exchange_failed.
+type ConnectionStatusResponse struct {
+    Status    string `json:"status"`
+    Reason    string `json:"reason,omitempty"`
+    ErrorCode string `json:"error_code,omitempty"`
+}
 
 // TokenResponse is minimally typed; extra fields are retained in Raw.
 type TokenResponse struct {
@@ -111,6 +151,10 @@ type TokenResponse struct {
     Raw          map[string]any         `json:"-"`
 }
 
+// ErrorEnvelope is the code and message of a Gateway error.
+//
+// Deprecated: errors are now *APIError, which errors.As also matches to an
+// ErrorEnvelope when the response carried a code.
 type ErrorEnvelope struct {
     Code    string `json:"code"`
     Message string `json:"message"`
@@ -130,15 +174,23 @@ func (c *Client) RequestConnection(ctx context.Context, in RequestConnectionInpu
     return &out, nil
 }
 
-// CheckConnection wraps GET /v1/check-connection/{connection_id}
+// CheckConnection wraps GET /v1/check-connection/{connection_id}, returning
+// only the status. ConnectionStatus also returns the reason.
 func (c *Client) CheckConnection(ctx context.Context, connectionID string) (string, error) {
-    if strings.TrimSpace(connectionID) == "" { return "", errors.New("missing connection_id") }
-    resp, err := c.do(ctx, http.MethodGet, c.GatewayBaseURL+"/v1/check-connection/"+url.PathEscape(connectionID), nil, nil)
+    out, err := c.ConnectionStatus(ctx, connectionID)
     if err != nil { return "", err }
+    return out.Status, nil
+}
+
+// ConnectionStatus wraps GET /v1/check-connection/{connection_id}.
+func (c *Client) ConnectionStatus(ctx context.Context, connectionID string) (*ConnectionStatusResponse, error) {
+    if strings.TrimSpace(connectionID) == "" { return nil, errors.New("missing connection_id") }
+    resp, err := c.do(ctx, http.MethodGet, c.GatewayBaseURL+"/v1/check-connection/"+url.PathEscape(connectionID), nil, nil)
+    if err != nil { return nil, err }
     defer resp.Body.Close()
     var out ConnectionStatusResponse
-    if err := json.NewDecoder(resp.Body).Decode(&out); err != nil { return "", err }
-    return out.Status, nil
+    if err := json.NewDecoder(resp.Body).Decode(&out); err != nil { return nil, err }
+    return &out, nil
 }
 
 // GetToken wraps GET /v1/token/{connection_id}
@@ -148,7 +200,7 @@ func (c *Client) GetToken(ctx context.Context, connectionID string) (*TokenRespo
     if err != nil { return nil, err }
     defer resp.Body.Close()
     var out TokenResponse
-    if err := json.NewDecoder(resp.Body).Decode(&out); err != nil { return nil, err }
+    if err := c.decodeTokenResponse(resp, &out); err != nil { return nil, err }
     return &out, nil
 }
 
@@ -159,7 +211,7 @@ func (c *Client) RefreshConnection(ctx context.Context, connectionID string) (*T
     if err != nil { return nil, err }
     defer resp.Body.Close()
     var out TokenResponse
-    if err := json.NewDecoder(resp.Body).Decode(&out); err != nil { return nil, err }
+    if err := c.decodeTokenResponse(resp, &out); err != nil { return nil, err }
     return &out, nil
 }
 
@@ -176,40 +228,75 @@ func (t *TokenResponse) UnmarshalJSON(data []byte) error {
     return nil
 }
 
-// RefreshViaBroker calls RefreshConnection (Gateway Proxy).
-// Deprecated: Use RefreshConnection instead. This method no longer calls the Broker directly.
-func (c *Client) RefreshViaBroker(ctx context.Context, connectionID string) (*TokenResponse, error) {
-    return c.RefreshConnection(ctx, connectionID)
+// BuildInfo identifies a Gateway or Broker build.
+type BuildInfo struct {
+    Version   string   `json:"version"`
+    GitCommit string   `json:"git_commit"`
+    BuildDate string   `json:"build_date"`
+    Features  []string `json:"features"`
 }
 
-func readGatewayError(r io.Reader, status int) error {
-    var e ErrorEnvelope
-    b, _ := io.ReadAll(r)
-    if err := json.Unmarshal(b, &e); err == nil && e.Code != "" {
-        return e
+// ServerInfo is the Gateway's build together with the Broker's. Broker is
+// nil, and BrokerError set, when the Gateway could not reach the Broker.
+type ServerInfo struct {
+    BuildInfo
+    Broker      *BuildInfo `json:"broker,omitempty"`
+    BrokerError string     `json:"broker_error,omitempty"`
+}
+
+// ServerInfo wraps GET /version
+func (c *Client) ServerInfo(ctx context.Context) (*ServerInfo, error) {
+    resp, err := c.do(ctx, http.MethodGet, c.GatewayBaseURL+"/version", nil, nil)
+    if err != nil { return nil, err }
+    defer resp.Body.Close()
+    var out ServerInfo
+    if err := json.NewDecoder(resp.Body).Decode(&out); err != nil { return nil, err }
+    return &out, nil
+}
+
+// VersionSkew reports whether the Gateway and Broker run different
+// major.minor releases. Unknown or non-semver versions (such as "dev") are
+// never reported as skew.
+func (s *ServerInfo) VersionSkew() bool {
+    if s.Broker == nil { return false }
+    g, ok1 := majorMinor(s.Version)
+    b, ok2 := majorMinor(s.Broker.Version)
+    return ok1 && ok2 && g != b
+}
+
+// majorMinor returns "1.4" for "v1.4.2" or "1.4.0-rc.1".
+func majorMinor(v string) (string, bool) {
+    parts := strings.SplitN(strings.TrimPrefix(strings.TrimSpace(v), "v"), ".", 3)
+    if len(parts) < 2 { return "", false }
+    for _, p := range parts[:2] {
+        if p == "" || strings.Trim(p, "0123456789") != "" { return "", false }
     }
-    if len(b) > 0 { return fmt.Errorf("gateway error %d: %s", status, strings.TrimSpace(string(b))) }
-    return fmt.Errorf("gateway error %d", status)
+    return parts[0] + "." + parts[1], true
 }
 
-// WaitForActive polls check-connection until active/failed or timeout.
+// WaitForActive polls check-connection until the connection is neither
+// pending nor awaiting_approval (active, failed, expired, ...) or ctx ends,
+// and returns its status. A connection that needs an administrator's
+// approval after consent is waited for too, so bound ctx to how long an
+// approval may take.
 func (c *Client) WaitForActive(ctx context.Context, connectionID string, interval time.Duration) (string, error) {
+    return c.waitForActive(ctx, connectionID, interval, nil)
+}
+
+// waitForActive is WaitForActive that also polls whenever wake fires.
+func (c *Client) waitForActive(ctx context.Context, connectionID string, interval time.Duration, wake <-chan struct{}) (string, error) {
     if interval <= 0 { interval = 1500 * time.Millisecond }
     ticker := time.NewTicker(interval)
     defer ticker.Stop()
     for {
         status, err := c.CheckConnection(ctx, connectionID)
         if err != nil { return "", err }
-        switch status {
-        case "active":
-            return status, nil
-        case "failed":
-            return status, nil
-        }
+        if status != "pending" && status != "awaiting_approval" { return status, nil }
         select {
         case <-ctx.Done():
             return "", ctx.Err()
         case <-ticker.C:
+        case <-wake:
         }
     }
 }
@@ -226,44 +313,61 @@ func (c *Client) do(ctx context.Context, method, urlStr string, headers map[stri
             req.Header.Set(k, v)
         }
         resp, err := c.HTTPClient.Do(req)
-        if err != nil { return nil, err }
+        if err != nil {
+            if ctx.Err() != nil { return nil, err }
+            return nil, fmt.Errorf("%w: %w", ErrGatewayUnavailable, err)
+        }
         if resp.StatusCode >= 200 && resp.StatusCode < 300 {
             return resp, nil
         }
-        // classify retryable statuses
-        retryable := resp.StatusCode == http.StatusBadGateway || resp.StatusCode == http.StatusServiceUnavailable || resp.StatusCode == http.StatusGatewayTimeout
-        if c.RetryPolicy.RetryOn429 && resp.StatusCode == http.StatusTooManyRequests {
-            retryable = true
-        }
-        if !retryable {
-            // return immediate error with body preserved
-            return nil, readGatewayError(resp.Body, resp.StatusCode)
-        }
-        // drain body before retry
-        io.Copy(io.Discard, resp.Body)
-        resp.Body.Close()
-        return nil, fmt.Errorf("retryable status: %d", resp.StatusCode)
+        defer resp.Body.Close()
+        return nil, readGatewayError(resp)
+    }
+    // shouldRetry classifies failures: transport errors and temporary
+    // unavailability always, throttling only when the policy asks for it
+    shouldRetry := func(err error) bool {
+        var apiErr *APIError
+        if !errors.As(err, &apiErr) { return true }
+        if apiErr.Is(ErrRateLimited) { return c.RetryPolicy.RetryOn429 }
+        return apiErr.Retryable()
     }
 
     pol := c.RetryPolicy.normalized()
+    if c.retryBudget != nil {
+        tokens := c.retryBudget.deposit()
+        if c.Metrics != nil { c.Metrics.SetRetryBudget(tokens) }
+    }
     var resp *http.Response
     var err error
     for i := 0; i <= pol.Retries; i++ {
-        if c.Logger != nil { c.Logger.Infof("http %s %s attempt %d", method, urlStr, i+1) }
+        c.infof("http %s %s attempt %d", method, urlStr, i+1)
         resp, err = attempt()
         if err == nil && resp != nil {
             return resp, nil
         }
-        // last attempt
-        if i == pol.Retries {
+        // last attempt, or a failure retrying cannot fix
+        if i == pol.Retries || !shouldRetry(err) {
             if err == nil {
                 return resp, nil
             }
             return nil, err
         }
+        // shared budget: stop retrying once concurrent callers have spent it
+        if c.retryBudget != nil {
+            tokens, ok := c.retryBudget.withdraw()
+            if c.Metrics != nil { c.Metrics.SetRetryBudget(tokens) }
+            if !ok {
+                if c.Metrics != nil { c.Metrics.IncRetriesSuppressed() }
+                c.errorf("retry budget exhausted, not retrying: %v", err)
+                return nil, err
+            }
+        }
+        if c.Metrics != nil { c.Metrics.IncRetries() }
         // backoff with jitter
         delay := c.backoff(i, pol.MinDelay, pol.MaxDelay)
-        if c.Logger != nil { c.Logger.Infof("retrying in %s: %v", delay, err) }
+        var apiErr *APIError
+        if errors.As(err, &apiErr) && apiErr.RetryAfter > delay { delay = apiErr.RetryAfter }
+        c.infof("retrying in %s: %v", delay, err)
         select {
         case <-ctx.Done():
             return nil, ctx.Err()
@@ -280,7 +384,9 @@ func (c *Client) backoff(attempt int, minDelay, maxDelay time.Duration) time.Dur
     factor := 1 << uint(attempt)
     base := float64(minDelay) * float64(factor)
     if base > float64(maxDelay) { base = float64(maxDelay) }
+    c.randMu.Lock() // rand.Rand is not safe for concurrent callers
     jitter := 0.2 + c.randSource.Float64()*0.6 // 0.2..0.8
+    c.randMu.Unlock()
     return time.Duration(base * jitter)
 }
 
@@ -95,3 +95,80 @@ func TestWaitForActive(t *testing.T) {
 		t.Fatalf("want active, got %s", status)
 	}
 }
+
+func TestWaitForActive_StopsOnTerminalStatus(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/check-connection/abc", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]any{"status": "expired", "reason": "consent_expired"})
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	c := New(srv.URL)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	status, err := c.WaitForActive(ctx, "abc", 10*time.Millisecond)
+	if err != nil || status != "expired" {
+		t.Fatalf("WaitForActive = %q, %v; want expired", status, err)
+	}
+	st, err := c.ConnectionStatus(ctx, "abc")
+	if err != nil || st.Reason != "consent_expired" {
+		t.Fatalf("ConnectionStatus = %+v, %v", st, err)
+	}
+}
+
+func TestWaitForActive_WaitsForApproval(t *testing.T) {
+	mux := http.NewServeMux()
+	statuses := []string{"pending", "awaiting_approval", "awaiting_approval", "active"}
+	mux.HandleFunc("/v1/check-connection/abc", func(w http.ResponseWriter, r *http.Request) {
+		status := statuses[0]
+		if len(statuses) > 1 {
+			statuses = statuses[1:]
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"status": status})
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	c := New(srv.URL)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	status, err := c.WaitForActive(ctx, "abc", 10*time.Millisecond)
+	if err != nil || status != "active" {
+		t.Fatalf("WaitForActive = %q, %v; want active", status, err)
+	}
+}
+
+func TestServerInfo(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/version", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"version":  "v1.4.0",
+			"features": []string{"admin_api"},
+			"broker":   map[string]any{"version": "1.3.2"},
+		})
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	c := New(srv.URL)
+	info, err := c.ServerInfo(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Version != "v1.4.0" || info.Broker == nil || info.Broker.Version != "1.3.2" {
+		t.Fatalf("unexpected info: %+v", info)
+	}
+	if !info.VersionSkew() {
+		t.Fatal("want skew between 1.4 and 1.3")
+	}
+	info.Broker.Version = "1.4.7"
+	if info.VersionSkew() {
+		t.Fatal("patch releases should not count as skew")
+	}
+	info.Broker.Version = "dev"
+	if info.VersionSkew() {
+		t.Fatal("dev builds should not count as skew")
+	}
+}
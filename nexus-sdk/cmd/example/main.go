@@ -28,6 +28,9 @@ func main() {
 	var retryMaxMs int
 	var retry429 bool
 	var enableLog bool
+	var listProviders bool
+	var open bool
+	var localCallback bool
 	flag.StringVar(&gateway, "gateway", "http://localhost:8090", "Gateway base URL")
 	flag.StringVar(&userID, "user", "ws-123", "User or workspace ID")
 	flag.StringVar(&provider, "provider", "Google", "Provider name")
@@ -44,6 +47,9 @@ func main() {
 	flag.IntVar(&retryMaxMs, "retry-max-ms", 2000, "Maximum backoff in ms")
 	flag.BoolVar(&retry429, "retry-429", false, "Retry on 429 status code as well")
 	flag.BoolVar(&enableLog, "log", false, "Enable simple logging")
+	flag.BoolVar(&listProviders, "list-providers", false, "List the provider catalog and exit")
+	flag.BoolVar(&open, "open", false, "Open the auth URL in the system browser")
+	flag.BoolVar(&localCallback, "local-callback", false, "Serve the return URL on a local listener (ignores -return)")
 	flag.Parse()
 
 	var opts []oauthsdk.Option
@@ -65,6 +71,48 @@ func main() {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Minute)
 	defer cancel()
 
+	if listProviders {
+		catalog, err := client.ListProviders(ctx, "")
+		if err != nil {
+			log.Fatalf("list-providers: %v", err)
+		}
+		for _, p := range catalog.Providers() {
+			fmt.Printf("%-24s %-12s %s  scopes=%s\n", p.Name, p.AuthType, p.ID, strings.Join(p.Scopes, " "))
+		}
+		return
+	}
+
+	if !useExisting && !noWait && !noToken {
+		in := oauthsdk.RequestConnectionInput{
+			UserID:       userID,
+			ProviderName: provider,
+			Scopes:       splitCSV(scopes),
+			ReturnURL:    returnURL,
+		}
+		if localCallback {
+			in.ReturnURL = ""
+		}
+		res, err := client.Connect(ctx, in, oauthsdk.ConnectOptions{
+			OnAuthURL: func(authURL, connectionID string) {
+				fmt.Println("Auth URL:", authURL)
+				fmt.Println("Connection ID:", connectionID)
+			},
+			PollInterval:  2 * time.Second,
+			OpenBrowser:   open,
+			LocalCallback: localCallback,
+		})
+		if err != nil {
+			log.Fatalf("connect: %v", err)
+		}
+		tok, err := res.TokenSource.Token(ctx)
+		if err != nil {
+			log.Fatalf("token: %v", err)
+		}
+		fmt.Println("Status: active")
+		fmt.Println("Access token length:", len(tok.AccessToken))
+		return
+	}
+
 	var id string
 	if useExisting && connectionID != "" {
 		id = connectionID
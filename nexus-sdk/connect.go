@@ -0,0 +1,200 @@
+package oauthsdk
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os/exec"
+	"runtime"
+	"time"
+)
+
+// ErrConnectionFailed is returned by Connect when the gateway reports the
+// connection as failed (e.g. the user denied consent or the exchange failed).
+var ErrConnectionFailed = errors.New("connection failed")
+
+// ConnectOptions configures the Connect convenience flow.
+type ConnectOptions struct {
+	// OnAuthURL is invoked with the authorization URL once the connection has
+	// been requested. Callers use it to present the URL to the user (open a
+	// browser, print it, send it to a frontend).
+	OnAuthURL func(authURL string, connectionID string)
+
+	// AuthURLs, if non-nil, receives the authorization URL. The send never
+	// blocks: if the channel is not ready the URL is dropped, so callers
+	// should use a buffered channel.
+	AuthURLs chan<- string
+
+	// PollInterval is the delay between status checks. Defaults to 1.5s.
+	PollInterval time.Duration
+
+	// Timeout bounds the whole flow when ctx has no earlier deadline.
+	// Zero means the flow runs until ctx is cancelled.
+	Timeout time.Duration
+
+	// OpenBrowser opens the authorization URL in the system browser.
+	OpenBrowser bool
+
+	// LocalCallback serves the return URL on a loopback listener for the
+	// duration of the flow, so the browser lands on a "you can close this
+	// window" page and the status is checked as soon as it does. It is used
+	// only when the input has no ReturnURL; the Broker must allow
+	// http://127.0.0.1 return URLs.
+	LocalCallback bool
+
+	// TokenSourceOptions configure the TokenSource of the result.
+	TokenSourceOptions []TokenSourceOption
+}
+
+// ConnectResult is the outcome of a successful Connect call.
+type ConnectResult struct {
+	ConnectionID string
+	AuthURL      string
+	Token        *TokenResponse
+	// TokenSource serves Token until it nears expiry, then refreshes it.
+	TokenSource *TokenSource
+}
+
+// Connect chains RequestConnection, WaitForActive and GetToken into a single
+// call. The authorization URL is surfaced via opts.OnAuthURL / opts.AuthURLs
+// (or opened in the browser with opts.OpenBrowser) so the user can consent,
+// then Connect blocks until the connection is active, failed, or the
+// deadline passes. A connection awaiting an administrator's approval is
+// waited for like a pending one.
+func (c *Client) Connect(ctx context.Context, in RequestConnectionInput, opts ConnectOptions) (*ConnectResult, error) {
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+
+	var returned <-chan struct{}
+	if opts.LocalCallback && in.ReturnURL == "" {
+		cb, err := startLocalCallback()
+		if err != nil {
+			return nil, fmt.Errorf("local callback: %w", err)
+		}
+		defer cb.close()
+		in.ReturnURL, returned = cb.url, cb.returned
+	}
+
+	spec, err := c.RequestConnection(ctx, in)
+	if err != nil {
+		return nil, fmt.Errorf("request connection: %w", err)
+	}
+	if spec.ConnectionID == "" {
+		return nil, errors.New("request connection: gateway returned no connection_id")
+	}
+
+	if opts.OnAuthURL != nil {
+		opts.OnAuthURL(spec.AuthURL, spec.ConnectionID)
+	}
+	if opts.AuthURLs != nil {
+		select {
+		case opts.AuthURLs <- spec.AuthURL:
+		default:
+			c.errorf("connect: auth url channel not ready, dropping url for %s", spec.ConnectionID)
+		}
+	}
+
+	if opts.OpenBrowser {
+		if err := openBrowser(spec.AuthURL); err != nil {
+			// The URL was still handed to OnAuthURL / AuthURLs.
+			c.errorf("connect: open browser: %v", err)
+		}
+	}
+
+	status, err := c.waitForActive(ctx, spec.ConnectionID, pollInterval(ctx, opts.PollInterval), returned)
+	if err != nil {
+		return nil, fmt.Errorf("wait for connection %s: %w", spec.ConnectionID, err)
+	}
+	if status != "active" {
+		return nil, fmt.Errorf("%w: connection %s status %s", ErrConnectionFailed, spec.ConnectionID, status)
+	}
+
+	tok, err := c.GetToken(ctx, spec.ConnectionID)
+	if err != nil {
+		return nil, fmt.Errorf("get token: %w", err)
+	}
+
+	ts := c.TokenSource(spec.ConnectionID, opts.TokenSourceOptions...)
+	ts.tok, ts.expiry = tok, tokenExpiry(tok, ts.now())
+	return &ConnectResult{
+		ConnectionID: spec.ConnectionID,
+		AuthURL:      spec.AuthURL,
+		Token:        tok,
+		TokenSource:  ts,
+	}, nil
+}
+
+// openBrowser opens url in the system browser; tests replace it.
+var openBrowser = func(url string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", url)
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", url)
+	default:
+		cmd = exec.Command("xdg-open", url)
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	go cmd.Wait()
+	return nil
+}
+
+// localCallback is a loopback HTTP server used as the return URL. Each
+// request to it is signalled on returned.
+type localCallback struct {
+	url      string
+	returned chan struct{}
+	srv      *http.Server
+}
+
+func startLocalCallback() (*localCallback, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	cb := &localCallback{url: "http://" + ln.Addr().String() + "/callback", returned: make(chan struct{}, 1)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/callback", func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case cb.returned <- struct{}{}:
+		default:
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		if r.URL.Query().Get("status") == "success" {
+			fmt.Fprintln(w, "Connected. You can close this window.")
+		} else {
+			fmt.Fprintln(w, "The connection was not completed. You can close this window.")
+		}
+	})
+	cb.srv = &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go cb.srv.Serve(ln)
+	return cb, nil
+}
+
+func (cb *localCallback) close() {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_ = cb.srv.Shutdown(ctx)
+}
+
+// pollInterval applies the default interval and shrinks it so that at least
+// a few polls happen before a short context deadline expires.
+func pollInterval(ctx context.Context, interval time.Duration) time.Duration {
+	if interval <= 0 {
+		interval = 1500 * time.Millisecond
+	}
+	if dl, ok := ctx.Deadline(); ok {
+		if remaining := time.Until(dl) / 4; remaining > 0 && remaining < interval {
+			interval = remaining
+		}
+	}
+	return interval
+}
@@ -0,0 +1,153 @@
+package oauthsdk
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func connectServer(t *testing.T, finalStatus string) *httptest.Server {
+	t.Helper()
+	var polls int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/request-connection", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"authUrl":       "http://example/auth",
+			"connection_id": "abc",
+		})
+	})
+	mux.HandleFunc("/v1/check-connection/abc", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if atomic.AddInt32(&polls, 1) < 3 {
+			_ = json.NewEncoder(w).Encode(map[string]any{"status": "pending"})
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"status": finalStatus})
+	})
+	mux.HandleFunc("/v1/token/abc", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{"access_token": "xyz"})
+	})
+	return httptest.NewServer(mux)
+}
+
+func TestConnect(t *testing.T) {
+	srv := connectServer(t, "active")
+	defer srv.Close()
+
+	urls := make(chan string, 1)
+	var gotURL, gotID string
+	c := New(srv.URL)
+	res, err := c.Connect(context.Background(), RequestConnectionInput{UserID: "u", ProviderName: "p", ReturnURL: "http://x"}, ConnectOptions{
+		OnAuthURL:    func(u, id string) { gotURL, gotID = u, id },
+		AuthURLs:     urls,
+		PollInterval: 10 * time.Millisecond,
+		Timeout:      5 * time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotURL != "http://example/auth" || gotID != "abc" {
+		t.Fatalf("callback got %q %q", gotURL, gotID)
+	}
+	if u := <-urls; u != "http://example/auth" {
+		t.Fatalf("channel got %q", u)
+	}
+	if res.ConnectionID != "abc" || res.Token.AccessToken != "xyz" {
+		t.Fatalf("unexpected result %+v", res)
+	}
+}
+
+func TestConnectFailed(t *testing.T) {
+	srv := connectServer(t, "failed")
+	defer srv.Close()
+
+	c := New(srv.URL)
+	_, err := c.Connect(context.Background(), RequestConnectionInput{UserID: "u", ProviderName: "p", ReturnURL: "http://x"}, ConnectOptions{PollInterval: 10 * time.Millisecond})
+	if !errors.Is(err, ErrConnectionFailed) {
+		t.Fatalf("want ErrConnectionFailed, got %v", err)
+	}
+}
+
+func TestConnectTimeout(t *testing.T) {
+	srv := connectServer(t, "pending")
+	defer srv.Close()
+
+	c := New(srv.URL)
+	_, err := c.Connect(context.Background(), RequestConnectionInput{UserID: "u", ProviderName: "p", ReturnURL: "http://x"}, ConnectOptions{
+		PollInterval: time.Hour,
+		Timeout:      100 * time.Millisecond,
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("want deadline exceeded, got %v", err)
+	}
+}
+
+func TestConnectLocalCallbackAndBrowser(t *testing.T) {
+	var returnURL string
+	var returned atomic.Bool
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/request-connection", func(w http.ResponseWriter, r *http.Request) {
+		var in RequestConnectionInput
+		_ = json.NewDecoder(r.Body).Decode(&in)
+		returnURL = in.ReturnURL
+		_ = json.NewEncoder(w).Encode(map[string]any{"authUrl": "http://example/auth", "connection_id": "abc"})
+	})
+	mux.HandleFunc("/v1/check-connection/abc", func(w http.ResponseWriter, r *http.Request) {
+		status := "pending"
+		if returned.Load() {
+			status = "active"
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"status": status})
+	})
+	mux.HandleFunc("/v1/token/abc", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]any{"access_token": "xyz", "expires_in": 3600})
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	// The "browser" completes consent and follows the redirect to the
+	// return URL.
+	var opened string
+	defer func(orig func(string) error) { openBrowser = orig }(openBrowser)
+	openBrowser = func(u string) error {
+		opened = u
+		go func() {
+			returned.Store(true)
+			resp, err := http.Get(returnURL + "?status=success&connection_id=abc")
+			if err == nil {
+				resp.Body.Close()
+			}
+		}()
+		return nil
+	}
+
+	// A poll interval longer than the timeout: only the callback can
+	// trigger the status check that sees the connection active.
+	res, err := New(srv.URL).Connect(context.Background(), RequestConnectionInput{UserID: "u", ProviderName: "p"}, ConnectOptions{
+		OpenBrowser:   true,
+		LocalCallback: true,
+		PollInterval:  time.Minute,
+		Timeout:       5 * time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opened != "http://example/auth" {
+		t.Fatalf("browser opened %q", opened)
+	}
+	if !strings.HasPrefix(returnURL, "http://127.0.0.1:") {
+		t.Fatalf("return URL %q is not the local callback", returnURL)
+	}
+	tok, err := res.TokenSource.Token(context.Background())
+	if err != nil || tok.AccessToken != "xyz" {
+		t.Fatalf("TokenSource.Token() = %v, %v", tok, err)
+	}
+}
@@ -0,0 +1,183 @@
+package oauthsdk
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-sdk/redact"
+)
+
+// Failure classes of Gateway errors. Match them with errors.Is; use
+// errors.As with *APIError for the status code, error code and RetryAfter.
+var (
+	// ErrNotFound: the connection, token or provider does not exist (404).
+	ErrNotFound = errors.New("not found")
+	// ErrConnectionNotActive: the connection exists but cannot serve tokens
+	// (pending, revoked, needing re-authentication, compromised or
+	// suspended; 403/409/423).
+	ErrConnectionNotActive = errors.New("connection not active")
+	// ErrConnectionSuspended: an operator suspended the connection (423).
+	// Tokens are withheld until it is resumed; the Message may say why.
+	ErrConnectionSuspended = errors.New("connection suspended")
+	// ErrRateLimited: the caller is being throttled (429); see
+	// APIError.RetryAfter.
+	ErrRateLimited = errors.New("rate limited")
+	// ErrGrantUnavailable: the grant given to RedeemGrant is invalid,
+	// expired or already redeemed (invalid_grant, grant_expired,
+	// grant_already_redeemed).
+	ErrGrantUnavailable = errors.New("grant unavailable")
+	// ErrGatewayUnavailable: the Gateway or the Broker behind it cannot serve
+	// the request right now (502, 503, 504), or the Gateway is unreachable.
+	ErrGatewayUnavailable = errors.New("gateway unavailable")
+)
+
+// ErrResponseSignature: a client built WithResponseVerification received a
+// token response that is unsigned, or whose signature does not verify, is
+// for another request or is too old. It is not an *APIError.
+var ErrResponseSignature = errors.New("response signature invalid")
+
+// APIError is a non-2xx response from the Gateway.
+type APIError struct {
+	// StatusCode is the HTTP status of the response.
+	StatusCode int
+	// Code is the machine-readable error code from the body (e.g.
+	// "provider_not_found"), if any.
+	Code string
+	// Message is the human-readable detail from the body, if any.
+	Message string
+	// Type is the problem type URI, which identifies Code in the error
+	// reference.
+	Type string
+	// RequestID identifies the failed request in the Gateway's logs; quote
+	// it when reporting a problem.
+	RequestID string
+	// RetryAfter is the delay requested by a Retry-After header.
+	RetryAfter time.Duration
+	// Fields maps each invalid input field to the reason it was rejected
+	// when Code is "validation_failed" (e.g. "return_url": "must be https").
+	Fields map[string]string
+}
+
+func (e *APIError) Error() string {
+	msg := fmt.Sprintf("gateway error %d", e.StatusCode)
+	if e.Code != "" {
+		msg += " (" + e.Code + ")"
+	}
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	return msg
+}
+
+// Is reports whether e belongs to the failure class target.
+func (e *APIError) Is(target error) bool {
+	switch target {
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrConnectionNotActive:
+		if e.Is(ErrGrantUnavailable) {
+			return false
+		}
+		return e.StatusCode == http.StatusForbidden || e.StatusCode == http.StatusConflict || e.Is(ErrConnectionSuspended) ||
+			e.Code == "connection_not_active" || e.Code == "attention_required" || e.Code == "connection_compromised"
+	case ErrGrantUnavailable:
+		return e.Code == "invalid_grant" || e.Code == "grant_expired" || e.Code == "grant_already_redeemed"
+	case ErrConnectionSuspended:
+		return e.StatusCode == http.StatusLocked || e.Code == "connection_suspended"
+	case ErrRateLimited:
+		return e.StatusCode == http.StatusTooManyRequests
+	case ErrGatewayUnavailable:
+		return e.StatusCode == http.StatusBadGateway || e.StatusCode == http.StatusServiceUnavailable || e.StatusCode == http.StatusGatewayTimeout
+	}
+	return false
+}
+
+// As lets callers that matched the older ErrorEnvelope keep doing so.
+func (e *APIError) As(target any) bool {
+	if env, ok := target.(*ErrorEnvelope); ok && e.Code != "" {
+		*env = ErrorEnvelope{Code: e.Code, Message: e.Message}
+		return true
+	}
+	return false
+}
+
+// Retryable reports whether repeating the request may succeed: the caller
+// was throttled or the Gateway was temporarily unavailable.
+func (e *APIError) Retryable() bool {
+	return e.Is(ErrRateLimited) || e.Is(ErrGatewayUnavailable)
+}
+
+// IsRetryable reports whether err is worth retrying: a retryable APIError
+// or a failure to reach the Gateway at all.
+func IsRetryable(err error) bool {
+	return errors.Is(err, ErrRateLimited) || errors.Is(err, ErrGatewayUnavailable)
+}
+
+// readGatewayError builds an APIError from resp. Gateway errors are RFC 9457
+// problem details ({"type", "code", "detail", "request_id", ...}); bodies
+// of older Gateways ({"error"|"code": ..., "message"|"detail": ...}) and
+// plain-text bodies are read too.
+func readGatewayError(resp *http.Response) error {
+	e := &APIError{
+		StatusCode: resp.StatusCode,
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+		RequestID:  resp.Header.Get("X-Request-ID"),
+	}
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	var body struct {
+		Type      string            `json:"type"`
+		Error     string            `json:"error"`
+		Code      string            `json:"code"`
+		Message   string            `json:"message"`
+		Detail    string            `json:"detail"`
+		RequestID string            `json:"request_id"`
+		Fields    map[string]string `json:"fields"`
+	}
+	if err := json.Unmarshal(b, &body); err == nil {
+		e.Code = firstNonEmpty(body.Code, body.Error)
+		e.Message = firstNonEmpty(body.Detail, body.Message)
+		e.Fields = body.Fields
+		if body.Type != "about:blank" {
+			e.Type = body.Type
+		}
+		e.RequestID = firstNonEmpty(body.RequestID, e.RequestID)
+	} else {
+		e.Message = strings.TrimSpace(string(b))
+	}
+	// The message may quote a provider's response; keep credentials in it
+	// out of callers' logs.
+	e.Message = redact.String(e.Message)
+	return e
+}
+
+// parseRetryAfter reads delay-seconds or an HTTP date.
+func parseRetryAfter(v string) time.Duration {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+func firstNonEmpty(vals ...string) string {
+	for _, v := range vals {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,171 @@
+package oauthsdk
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAPIErrorClasses(t *testing.T) {
+	cases := []struct {
+		err       *APIError
+		class     error
+		retryable bool
+	}{
+		{&APIError{StatusCode: 404, Code: "provider_not_found"}, ErrNotFound, false},
+		{&APIError{StatusCode: 403}, ErrConnectionNotActive, false},
+		{&APIError{StatusCode: 409, Code: "attention_required"}, ErrConnectionNotActive, false},
+		{&APIError{StatusCode: 400, Code: "connection_not_active"}, ErrConnectionNotActive, false},
+		{&APIError{StatusCode: 423, Code: "connection_suspended"}, ErrConnectionSuspended, false},
+		{&APIError{StatusCode: 423}, ErrConnectionNotActive, false},
+		{&APIError{StatusCode: 409, Code: "grant_already_redeemed"}, ErrGrantUnavailable, false},
+		{&APIError{StatusCode: 410, Code: "grant_expired"}, ErrGrantUnavailable, false},
+		{&APIError{StatusCode: 429}, ErrRateLimited, true},
+		{&APIError{StatusCode: 502}, ErrGatewayUnavailable, true},
+		{&APIError{StatusCode: 503}, ErrGatewayUnavailable, true},
+		{&APIError{StatusCode: 504}, ErrGatewayUnavailable, true},
+	}
+	for _, tc := range cases {
+		if !errors.Is(tc.err, tc.class) {
+			t.Errorf("%v: want errors.Is %v", tc.err, tc.class)
+		}
+		if tc.err.Retryable() != tc.retryable || IsRetryable(tc.err) != tc.retryable {
+			t.Errorf("%v: want retryable %v", tc.err, tc.retryable)
+		}
+	}
+	if errors.Is(&APIError{StatusCode: 409, Code: "grant_already_redeemed"}, ErrConnectionNotActive) {
+		t.Error("a redeemed grant must not match ErrConnectionNotActive")
+	}
+	if errors.Is(&APIError{StatusCode: 400}, ErrNotFound) {
+		t.Error("400 must not match ErrNotFound")
+	}
+	if errors.Is(&APIError{StatusCode: 409, Code: "attention_required"}, ErrConnectionSuspended) {
+		t.Error("409 must not match ErrConnectionSuspended")
+	}
+}
+
+func TestGetToken_TypedErrors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/token/missing":
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"error":"connection_not_found","message":"no such connection"}`))
+		case "/v1/token/stale":
+			w.WriteHeader(http.StatusConflict)
+			_, _ = w.Write([]byte(`{"error":"attention_required","detail":"re-authenticate"}`))
+		case "/v1/token/busy":
+			w.Header().Set("Retry-After", "7")
+			w.WriteHeader(http.StatusTooManyRequests)
+		}
+	}))
+	defer srv.Close()
+	c := New(srv.URL, WithRetry(RetryPolicy{Retries: 0}))
+	ctx := context.Background()
+
+	_, err := c.GetToken(ctx, "missing")
+	var apiErr *APIError
+	if !errors.Is(err, ErrNotFound) || !errors.As(err, &apiErr) {
+		t.Fatalf("want ErrNotFound APIError, got %v", err)
+	}
+	if apiErr.Code != "connection_not_found" || apiErr.Message != "no such connection" {
+		t.Fatalf("unexpected body fields: %+v", apiErr)
+	}
+	var env ErrorEnvelope
+	if !errors.As(err, &env) || env.Code != "connection_not_found" {
+		t.Fatalf("want ErrorEnvelope match, got %+v", env)
+	}
+
+	_, err = c.GetToken(ctx, "stale")
+	if !errors.Is(err, ErrConnectionNotActive) || !errors.As(err, &apiErr) || apiErr.Message != "re-authenticate" {
+		t.Fatalf("want ErrConnectionNotActive with detail, got %v", err)
+	}
+
+	_, err = c.GetToken(ctx, "busy")
+	if !errors.Is(err, ErrRateLimited) || !errors.As(err, &apiErr) || apiErr.RetryAfter != 7*time.Second {
+		t.Fatalf("want ErrRateLimited with RetryAfter, got %v", err)
+	}
+}
+
+func TestReadGatewayError_RedactsMessage(t *testing.T) {
+	for _, body := range []string{
+		`{"error":"upstream_error","message":"token refresh failed: {\"refresh_token\":\"r-1\"}"}`,
+		`token refresh failed: refresh_token=r-1`,
+	} {
+		rec := httptest.NewRecorder()
+		rec.WriteHeader(http.StatusBadGateway)
+		rec.WriteString(body)
+		err := readGatewayError(rec.Result())
+		if strings.Contains(err.Error(), "r-1") {
+			t.Errorf("refresh token leaked into %q", err)
+		}
+	}
+}
+
+func TestReadGatewayError_Fields(t *testing.T) {
+	rec := httptest.NewRecorder()
+	rec.WriteHeader(http.StatusBadRequest)
+	rec.WriteString(`{"error":"validation_failed","message":"request validation failed","fields":{"return_url":"must be https"}}`)
+	var apiErr *APIError
+	if !errors.As(readGatewayError(rec.Result()), &apiErr) {
+		t.Fatal("expected an APIError")
+	}
+	if apiErr.Code != "validation_failed" || apiErr.Fields["return_url"] != "must be https" {
+		t.Errorf("unexpected error %+v", apiErr)
+	}
+}
+
+func TestReadGatewayError_ProblemDetails(t *testing.T) {
+	rec := httptest.NewRecorder()
+	rec.Header().Set("Content-Type", "application/problem+json")
+	rec.Header().Set("X-Request-ID", "req-header")
+	rec.WriteHeader(http.StatusNotFound)
+	rec.WriteString(`{"type":"https://prescott-data.github.io/nexus-framework/reference/errors/#provider_not_found","title":"Not Found","status":404,` +
+		`"detail":"provider not found","code":"provider_not_found","request_id":"req-1"}`)
+	var apiErr *APIError
+	if !errors.As(readGatewayError(rec.Result()), &apiErr) {
+		t.Fatal("expected an APIError")
+	}
+	if apiErr.Code != "provider_not_found" || apiErr.Message != "provider not found" || apiErr.RequestID != "req-1" ||
+		!strings.HasSuffix(apiErr.Type, "#provider_not_found") || !errors.Is(apiErr, ErrNotFound) {
+		t.Errorf("unexpected error %+v", apiErr)
+	}
+
+	rec = httptest.NewRecorder()
+	rec.Header().Set("X-Request-ID", "req-2")
+	rec.WriteHeader(http.StatusBadGateway)
+	rec.WriteString("bad gateway")
+	if !errors.As(readGatewayError(rec.Result()), &apiErr) || apiErr.RequestID != "req-2" {
+		t.Errorf("expected the request ID from the header, got %+v", apiErr)
+	}
+}
+
+func TestDo_DoesNotRetryClientErrors(t *testing.T) {
+	var calls int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer srv.Close()
+	c := New(srv.URL, WithRetry(RetryPolicy{Retries: 3, MinDelay: time.Millisecond, MaxDelay: time.Millisecond}))
+	if _, err := c.GetToken(context.Background(), "x"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("want ErrNotFound, got %v", err)
+	}
+	if calls != 1 {
+		t.Fatalf("want 1 call, got %d", calls)
+	}
+}
+
+func TestDo_UnreachableGatewayIsUnavailable(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	url := srv.URL
+	srv.Close()
+	c := New(url, WithRetry(RetryPolicy{Retries: 0}))
+	_, err := c.GetToken(context.Background(), "x")
+	if !errors.Is(err, ErrGatewayUnavailable) || !IsRetryable(err) {
+		t.Fatalf("want retryable ErrGatewayUnavailable, got %v", err)
+	}
+}
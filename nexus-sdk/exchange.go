@@ -0,0 +1,54 @@
+package oauthsdk
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// TokenExchangeInput describes the token ExchangeToken asks for. Every field
+// is optional.
+type TokenExchangeInput struct {
+	// SubjectTokenType names the connection's token to exchange:
+	// "access_token" (the default) or "id_token".
+	SubjectTokenType string `json:"subject_token_type,omitempty"`
+	// Audience and Resource name the service the new token is for.
+	Audience string `json:"audience,omitempty"`
+	Resource string `json:"resource,omitempty"`
+	// Scopes narrows the new token to at most the connection's scopes.
+	Scopes []string `json:"scopes,omitempty"`
+	// RequestedTokenType is a token type URI, or its short name such as
+	// "access_token" or "jwt".
+	RequestedTokenType string `json:"requested_token_type,omitempty"`
+	// ActorToken and ActorTokenType make the exchange a delegation: the new
+	// token says the actor acts on behalf of the connection's user.
+	ActorToken     string `json:"actor_token,omitempty"`
+	ActorTokenType string `json:"actor_token_type,omitempty"`
+}
+
+// ExchangeToken wraps POST /v1/exchange/{connection_id}: it trades the
+// connection's token for another one (RFC 8693 token exchange), typically
+// down-scoped before it is handed to a subprocess. The new token is not
+// stored; GetToken keeps returning the connection's own.
+func (c *Client) ExchangeToken(ctx context.Context, connectionID string, in TokenExchangeInput) (*TokenResponse, error) {
+	if strings.TrimSpace(connectionID) == "" {
+		return nil, errors.New("missing connection_id")
+	}
+	body, err := json.Marshal(in)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.do(ctx, http.MethodPost, c.GatewayBaseURL+"/v1/exchange/"+url.PathEscape(connectionID), map[string]string{"Content-Type": "application/json"}, body)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var out TokenResponse
+	if err := c.decodeTokenResponse(resp, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
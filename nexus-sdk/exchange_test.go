@@ -0,0 +1,55 @@
+package oauthsdk
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestExchangeToken(t *testing.T) {
+	var got map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/exchange/conn-1":
+			if r.Method != http.MethodPost {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+			_ = json.NewDecoder(r.Body).Decode(&got)
+			_, _ = w.Write([]byte(`{"access_token":"narrow","token_type":"Bearer","scope":"read","issued_token_type":"urn:ietf:params:oauth:token-type:access_token"}`))
+		case "/v1/exchange/conn-2":
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"code":"token_exchange_unsupported","detail":"The provider does not support token exchange"}`))
+		}
+	}))
+	defer srv.Close()
+	c := New(srv.URL, WithRetry(RetryPolicy{Retries: 0}))
+	ctx := context.Background()
+
+	tok, err := c.ExchangeToken(ctx, "conn-1", TokenExchangeInput{Audience: "billing", Scopes: []string{"read"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tok.AccessToken != "narrow" || tok.Raw["issued_token_type"] == nil {
+		t.Fatalf("unexpected token: %+v", tok)
+	}
+	if got["audience"] != "billing" || len(got["scopes"].([]any)) != 1 {
+		t.Fatalf("gateway received %v", got)
+	}
+	if _, ok := got["actor_token"]; ok {
+		t.Fatalf("empty fields should be omitted, got %v", got)
+	}
+
+	_, err = c.ExchangeToken(ctx, "conn-2", TokenExchangeInput{})
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.Code != "token_exchange_unsupported" {
+		t.Fatalf("want token_exchange_unsupported, got %v", err)
+	}
+
+	if _, err := c.ExchangeToken(ctx, " ", TokenExchangeInput{}); err == nil {
+		t.Fatal("want an error for a missing connection ID")
+	}
+}
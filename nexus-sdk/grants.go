@@ -0,0 +1,81 @@
+package oauthsdk
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// GrantInput describes the grant CreateGrant asks for. Every field is
+// optional.
+type GrantInput struct {
+	// Scopes limits the grant to some of the connection's scopes; all of
+	// them by default. Fewer scopes need a provider supporting token
+	// exchange, which down-scopes the token on redemption.
+	Scopes []string `json:"scopes,omitempty"`
+	// TTLSeconds is how long the grant can be redeemed: 300 by default, at
+	// most 3600.
+	TTLSeconds int `json:"ttl_seconds,omitempty"`
+}
+
+// Grant is a single-use grant to a connection's access token.
+type Grant struct {
+	// Grant is the signed token to hand to the downstream service.
+	Grant        string    `json:"grant"`
+	GrantID      string    `json:"grant_id"`
+	ConnectionID string    `json:"connection_id"`
+	Scopes       []string  `json:"scopes"`
+	ExpiresAt    time.Time `json:"expires_at"`
+}
+
+// CreateGrant wraps POST /v1/grant/{connection_id}: it issues a short-lived
+// grant that a downstream service redeems once with RedeemGrant for the
+// connection's access token, so the connection itself never leaves the
+// agent.
+func (c *Client) CreateGrant(ctx context.Context, connectionID string, in GrantInput) (*Grant, error) {
+	if strings.TrimSpace(connectionID) == "" {
+		return nil, errors.New("missing connection_id")
+	}
+	body, err := json.Marshal(in)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.do(ctx, http.MethodPost, c.GatewayBaseURL+"/v1/grant/"+url.PathEscape(connectionID), map[string]string{"Content-Type": "application/json"}, body)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var out Grant
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// RedeemGrant wraps POST /v1/redeem-grant: it trades a grant from
+// CreateGrant for the access token it stands for. A grant is redeemed only
+// once, so a retried request whose first attempt reached the Gateway fails
+// with ErrGrantUnavailable. The route needs no Gateway credentials.
+func (c *Client) RedeemGrant(ctx context.Context, grant string) (*TokenResponse, error) {
+	if strings.TrimSpace(grant) == "" {
+		return nil, errors.New("missing grant")
+	}
+	body, err := json.Marshal(map[string]string{"grant": grant})
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.do(ctx, http.MethodPost, c.GatewayBaseURL+"/v1/redeem-grant", map[string]string{"Content-Type": "application/json"}, body)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var out TokenResponse
+	if err := c.decodeTokenResponse(resp, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
@@ -0,0 +1,61 @@
+package oauthsdk
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGrants(t *testing.T) {
+	var got map[string]any
+	redeemed := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/grant/conn-1":
+			_ = json.NewDecoder(r.Body).Decode(&got)
+			w.WriteHeader(http.StatusCreated)
+			_, _ = w.Write([]byte(`{"grant":"nxg.g-1.mac","grant_id":"g-1","connection_id":"conn-1","scopes":["read"],"expires_at":"2030-01-01T00:00:00Z"}`))
+		case "/v1/redeem-grant":
+			if redeemed {
+				w.WriteHeader(http.StatusConflict)
+				_, _ = w.Write([]byte(`{"code":"grant_already_redeemed","detail":"The grant was already redeemed"}`))
+				return
+			}
+			redeemed = true
+			_, _ = w.Write([]byte(`{"access_token":"at","token_type":"Bearer","scope":"read","connection_id":"conn-1","grant_id":"g-1"}`))
+		}
+	}))
+	defer srv.Close()
+	c := New(srv.URL, WithRetry(RetryPolicy{Retries: 0}))
+	ctx := context.Background()
+
+	grant, err := c.CreateGrant(ctx, "conn-1", GrantInput{Scopes: []string{"read"}, TTLSeconds: 60})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if grant.Grant != "nxg.g-1.mac" || grant.ExpiresAt.Year() != 2030 {
+		t.Fatalf("unexpected grant: %+v", grant)
+	}
+	if got["ttl_seconds"] != float64(60) || len(got["scopes"].([]any)) != 1 {
+		t.Fatalf("gateway received %v", got)
+	}
+
+	tok, err := c.RedeemGrant(ctx, grant.Grant)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tok.AccessToken != "at" || tok.Raw["grant_id"] != "g-1" {
+		t.Fatalf("unexpected token: %+v", tok)
+	}
+	_, err = c.RedeemGrant(ctx, grant.Grant)
+	if !errors.Is(err, ErrGrantUnavailable) || errors.Is(err, ErrConnectionNotActive) {
+		t.Fatalf("want ErrGrantUnavailable, got %v", err)
+	}
+
+	if _, err := c.RedeemGrant(ctx, ""); err == nil {
+		t.Fatal("want an error for a missing grant")
+	}
+}
@@ -0,0 +1,154 @@
+package oauthsdk
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Rate-limited page fetches are retried this many times, backing off from
+// pageMinDelay up to pageMaxDelay unless the Gateway sent Retry-After.
+const (
+	pageRateLimitRetries = 5
+	pageMinDelay         = 500 * time.Millisecond
+	pageMaxDelay         = 30 * time.Second
+)
+
+// Page is one page of a list endpoint. An empty NextCursor marks the last
+// page.
+type Page[T any] struct {
+	Items      []T    `json:"items"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// PageFunc fetches the page starting at cursor ("" for the first page).
+type PageFunc[T any] func(ctx context.Context, cursor string) (*Page[T], error)
+
+// Iterator walks the items of a paginated list, fetching pages as needed:
+//
+//	it := oauthsdk.Paginate[Item](client, "/v1/things", nil)
+//	for it.Next(ctx) {
+//		use(it.Item())
+//	}
+//	if err := it.Err(); err != nil { ... }
+//
+// A page fetch that is rate limited is retried after a backoff. An
+// Iterator is not safe for concurrent use.
+type Iterator[T any] struct {
+	fetch    PageFunc[T]
+	minDelay time.Duration
+	items    []T
+	cursor   string
+	done     bool
+	item     T
+	err      error
+}
+
+// NewIterator returns an Iterator over the pages returned by fetch.
+func NewIterator[T any](fetch PageFunc[T]) *Iterator[T] {
+	return &Iterator[T]{fetch: fetch, minDelay: pageMinDelay}
+}
+
+// Paginate iterates GET path on the Gateway, passing query and the cursor
+// parameter and decoding {"items": [...], "next_cursor": "..."} pages.
+func Paginate[T any](c *Client, path string, query url.Values) *Iterator[T] {
+	return NewIterator(func(ctx context.Context, cursor string) (*Page[T], error) {
+		q := url.Values{}
+		for k, v := range query {
+			q[k] = v
+		}
+		if cursor != "" {
+			q.Set("cursor", cursor)
+		}
+		u := c.GatewayBaseURL + path
+		if len(q) > 0 {
+			u += "?" + q.Encode()
+		}
+		resp, err := c.do(ctx, http.MethodGet, u, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		var page Page[T]
+		if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+			return nil, err
+		}
+		return &page, nil
+	})
+}
+
+// Next advances to the next item, fetching the next page when the current
+// one is used up. It returns false at the end of the list or on error; check
+// Err to tell them apart.
+func (it *Iterator[T]) Next(ctx context.Context) bool {
+	for len(it.items) == 0 {
+		if it.done || it.err != nil {
+			return false
+		}
+		page, err := it.fetchPage(ctx)
+		if err != nil {
+			it.err = err
+			return false
+		}
+		it.items, it.cursor = page.Items, page.NextCursor
+		it.done = page.NextCursor == ""
+	}
+	it.item, it.items = it.items[0], it.items[1:]
+	return true
+}
+
+// Item returns the current item.
+func (it *Iterator[T]) Item() T { return it.item }
+
+// Err returns the error that stopped the iteration, if any.
+func (it *Iterator[T]) Err() error { return it.err }
+
+// All collects the remaining items.
+func (it *Iterator[T]) All(ctx context.Context) ([]T, error) {
+	var out []T
+	for it.Next(ctx) {
+		out = append(out, it.Item())
+	}
+	return out, it.Err()
+}
+
+func (it *Iterator[T]) fetchPage(ctx context.Context) (*Page[T], error) {
+	delay := it.minDelay
+	for attempt := 0; ; attempt++ {
+		page, err := it.fetch(ctx, it.cursor)
+		if err == nil {
+			return page, nil
+		}
+		var apiErr *APIError
+		if attempt == pageRateLimitRetries || !errors.As(err, &apiErr) || !apiErr.Is(ErrRateLimited) {
+			return nil, err
+		}
+		wait := delay
+		if apiErr.RetryAfter > 0 {
+			wait = apiErr.RetryAfter
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(wait):
+		}
+		if delay *= 2; delay > pageMaxDelay {
+			delay = pageMaxDelay
+		}
+	}
+}
+
+// IterateProviders iterates the provider catalog (see ListProviders) in
+// name order.
+func (c *Client) IterateProviders(workspaceID string) *Iterator[ProviderMetadata] {
+	return NewIterator(func(ctx context.Context, _ string) (*Page[ProviderMetadata], error) {
+		catalog, err := c.ListProviders(ctx, workspaceID)
+		if err != nil {
+			return nil, err
+		}
+		return &Page[ProviderMetadata]{Items: catalog.Providers()}, nil
+	})
+}
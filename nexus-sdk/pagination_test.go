@@ -0,0 +1,81 @@
+package oauthsdk
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPaginate_FollowsCursors(t *testing.T) {
+	pages := map[string]Page[string]{
+		"":   {Items: []string{"a", "b"}, NextCursor: "p2"},
+		"p2": {Items: nil, NextCursor: "p3"},
+		"p3": {Items: []string{"c"}},
+	}
+	limited := true
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("kind") != "x" {
+			t.Errorf("query not forwarded: %s", r.URL.RawQuery)
+		}
+		cursor := r.URL.Query().Get("cursor")
+		if cursor == "p3" && limited {
+			limited = false
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(pages[cursor])
+	}))
+	defer srv.Close()
+
+	it := Paginate[string](New(srv.URL), "/v1/things", map[string][]string{"kind": {"x"}})
+	it.minDelay = time.Millisecond
+	got, err := it.All(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 3 || got[0] != "a" || got[2] != "c" {
+		t.Fatalf("got %v", got)
+	}
+	if it.Next(context.Background()) {
+		t.Fatal("Next after the last page should be false")
+	}
+}
+
+func TestIterator_StopsOnError(t *testing.T) {
+	calls := 0
+	it := NewIterator(func(ctx context.Context, cursor string) (*Page[int], error) {
+		calls++
+		if cursor == "" {
+			return &Page[int]{Items: []int{1}, NextCursor: "next"}, nil
+		}
+		return nil, &APIError{StatusCode: http.StatusNotFound}
+	})
+	ctx := context.Background()
+	if !it.Next(ctx) || it.Item() != 1 {
+		t.Fatal("want the first item")
+	}
+	if it.Next(ctx) || !errors.Is(it.Err(), ErrNotFound) {
+		t.Fatalf("want ErrNotFound, got %v", it.Err())
+	}
+	if it.Next(ctx) || calls != 2 {
+		t.Fatalf("iteration should stay stopped, %d calls", calls)
+	}
+}
+
+func TestIterateProviders(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		_, _ = w.Write([]byte(catalogJSON))
+	}))
+	defer srv.Close()
+	got, err := New(srv.URL).IterateProviders("").All(context.Background())
+	if err != nil || len(got) != 5 || got[0].Name != "Github" {
+		t.Fatalf("got %+v, %v", got, err)
+	}
+}
@@ -0,0 +1,246 @@
+package oauthsdk
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultProviderCacheTTL is how long ResolveProviderID trusts a cached
+// provider name -> ID mapping.
+const DefaultProviderCacheTTL = 5 * time.Minute
+
+// ErrProviderAmbiguous is returned when several providers share a name
+// (compared case-insensitively), so the name cannot identify one.
+var ErrProviderAmbiguous = errors.New("provider name is ambiguous")
+
+// Auth types of the provider catalog groupings.
+const (
+	AuthTypeOAuth2    = "oauth2"
+	AuthTypeAPIKey    = "api_key"
+	AuthTypeBasicAuth = "basic_auth"
+)
+
+// WithProviderCacheTTL sets how long provider name -> ID mappings are
+// cached; zero or negative disables the cache.
+func WithProviderCacheTTL(d time.Duration) Option {
+	return func(c *Client) { c.providers.ttl = d }
+}
+
+// ProviderMetadata is a provider's public configuration from the catalog.
+type ProviderMetadata struct {
+	ID               string   `json:"id"`
+	APIBaseURL       string   `json:"api_base_url,omitempty"`
+	UserInfoEndpoint string   `json:"user_info_endpoint,omitempty"`
+	Scopes           []string `json:"scopes,omitempty"`
+	Description      string   `json:"description,omitempty"`
+	Category         string   `json:"category,omitempty"`
+
+	// Name and AuthType are the catalog keys the entry was listed under.
+	Name     string `json:"-"`
+	AuthType string `json:"-"`
+}
+
+// ProviderCatalog is the response of GET /v1/providers: providers keyed by
+// name and grouped by auth type.
+type ProviderCatalog struct {
+	OAuth2    map[string]ProviderMetadata
+	APIKey    map[string]ProviderMetadata
+	BasicAuth map[string]ProviderMetadata
+	// Other holds the remaining auth types (header, query_param,
+	// hmac_payload, aws_sigv4, ...) keyed by auth type.
+	Other map[string]map[string]ProviderMetadata
+}
+
+func (pc *ProviderCatalog) UnmarshalJSON(data []byte) error {
+	var groups map[string]map[string]ProviderMetadata
+	if err := json.Unmarshal(data, &groups); err != nil {
+		return err
+	}
+	*pc = ProviderCatalog{}
+	for authType, providers := range groups {
+		for name, p := range providers {
+			p.Name, p.AuthType = name, authType
+			providers[name] = p
+		}
+		switch authType {
+		case AuthTypeOAuth2:
+			pc.OAuth2 = providers
+		case AuthTypeAPIKey:
+			pc.APIKey = providers
+		case AuthTypeBasicAuth:
+			pc.BasicAuth = providers
+		default:
+			if pc.Other == nil {
+				pc.Other = map[string]map[string]ProviderMetadata{}
+			}
+			pc.Other[authType] = providers
+		}
+	}
+	return nil
+}
+
+func (pc *ProviderCatalog) MarshalJSON() ([]byte, error) {
+	groups := map[string]map[string]ProviderMetadata{}
+	for authType, providers := range pc.Other {
+		groups[authType] = providers
+	}
+	for authType, providers := range map[string]map[string]ProviderMetadata{
+		AuthTypeOAuth2: pc.OAuth2, AuthTypeAPIKey: pc.APIKey, AuthTypeBasicAuth: pc.BasicAuth,
+	} {
+		if providers != nil {
+			groups[authType] = providers
+		}
+	}
+	return json.Marshal(groups)
+}
+
+// Providers returns every provider in the catalog, sorted by name.
+func (pc *ProviderCatalog) Providers() []ProviderMetadata {
+	var out []ProviderMetadata
+	add := func(group map[string]ProviderMetadata) {
+		for _, p := range group {
+			out = append(out, p)
+		}
+	}
+	add(pc.OAuth2)
+	add(pc.APIKey)
+	add(pc.BasicAuth)
+	for _, group := range pc.Other {
+		add(group)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Name != out[j].Name {
+			return out[i].Name < out[j].Name
+		}
+		return out[i].AuthType < out[j].AuthType
+	})
+	return out
+}
+
+// Lookup finds the provider called name, compared case-insensitively like
+// the Gateway does. It fails with an error matching ErrNotFound when no
+// provider has the name and ErrProviderAmbiguous when several do.
+func (pc *ProviderCatalog) Lookup(name string) (*ProviderMetadata, error) {
+	want := strings.ToLower(strings.TrimSpace(name))
+	var match *ProviderMetadata
+	for _, p := range pc.Providers() {
+		if strings.ToLower(strings.TrimSpace(p.Name)) != want {
+			continue
+		}
+		if match != nil {
+			return nil, fmt.Errorf("%w: %s", ErrProviderAmbiguous, name)
+		}
+		p := p
+		match = &p
+	}
+	if match == nil {
+		return nil, fmt.Errorf("provider %s: %w", name, ErrNotFound)
+	}
+	return match, nil
+}
+
+// ListProviders wraps GET /v1/providers. A non-empty workspaceID limits the
+// catalog to global providers and those restricted to that workspace. The
+// provider name -> ID cache is updated from the result.
+func (c *Client) ListProviders(ctx context.Context, workspaceID string) (*ProviderCatalog, error) {
+	u := c.GatewayBaseURL + "/v1/providers"
+	if workspaceID != "" {
+		u += "?" + url.Values{"workspace_id": {workspaceID}}.Encode()
+	}
+	resp, err := c.do(ctx, http.MethodGet, u, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var out ProviderCatalog
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, err
+	}
+	if workspaceID == "" {
+		c.providers.store(out.Providers())
+	}
+	return &out, nil
+}
+
+// GetProviderMetadata returns the catalog entry of the provider called name.
+func (c *Client) GetProviderMetadata(ctx context.Context, name string) (*ProviderMetadata, error) {
+	if strings.TrimSpace(name) == "" {
+		return nil, errors.New("missing provider name")
+	}
+	catalog, err := c.ListProviders(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	return catalog.Lookup(name)
+}
+
+// ResolveProviderID returns the ID of the provider called name, from the
+// cache when a mapping younger than the cache TTL exists.
+func (c *Client) ResolveProviderID(ctx context.Context, name string) (string, error) {
+	if id, ok := c.providers.get(name); ok {
+		return id, nil
+	}
+	p, err := c.GetProviderMetadata(ctx, name)
+	if err != nil {
+		return "", err
+	}
+	return p.ID, nil
+}
+
+// InvalidateProviderCache drops every cached provider name -> ID mapping,
+// for example after providers were renamed or re-registered.
+func (c *Client) InvalidateProviderCache() {
+	c.providers.mu.Lock()
+	c.providers.ids = nil
+	c.providers.mu.Unlock()
+}
+
+// providerCache maps lower-cased provider names to IDs. Names shared by
+// several providers are left out so they are always resolved (and reported
+// as ambiguous) from a fresh catalog.
+type providerCache struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	ids     map[string]string
+	fetched time.Time
+}
+
+func (pc *providerCache) get(name string) (string, bool) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	if pc.ttl <= 0 || pc.ids == nil || time.Since(pc.fetched) >= pc.ttl {
+		return "", false
+	}
+	id, ok := pc.ids[strings.ToLower(strings.TrimSpace(name))]
+	return id, ok
+}
+
+func (pc *providerCache) store(providers []ProviderMetadata) {
+	if pc.ttl <= 0 {
+		return
+	}
+	ids := make(map[string]string, len(providers))
+	seen := make(map[string]int, len(providers))
+	for _, p := range providers {
+		key := strings.ToLower(strings.TrimSpace(p.Name))
+		seen[key]++
+		ids[key] = p.ID
+	}
+	for key, n := range seen {
+		if n > 1 {
+			delete(ids, key)
+		}
+	}
+	pc.mu.Lock()
+	pc.ids, pc.fetched = ids, time.Now()
+	pc.mu.Unlock()
+}
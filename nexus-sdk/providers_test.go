@@ -0,0 +1,111 @@
+package oauthsdk
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+const catalogJSON = `{
+  "oauth2": {
+    "Google": {"id": "g-1", "api_base_url": "https://www.googleapis.com", "user_info_endpoint": "https://openidconnect.googleapis.com/v1/userinfo", "scopes": ["openid", "email"]},
+    "github": {"id": "gh-1", "scopes": ["repo"]}
+  },
+  "api_key": {"OpenAI": {"id": "o-1", "api_base_url": "https://api.openai.com"}},
+  "basic_auth": {"Jira": {"id": "j-1"}},
+  "header": {"Github": {"id": "gh-2"}}
+}`
+
+func catalogServer(t *testing.T, calls *atomic.Int32) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/providers" {
+			http.NotFound(w, r)
+			return
+		}
+		calls.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(catalogJSON))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestListProviders(t *testing.T) {
+	var calls atomic.Int32
+	c := New(catalogServer(t, &calls).URL)
+	catalog, err := c.ListProviders(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := catalog.OAuth2["Google"]
+	if g.ID != "g-1" || g.AuthType != AuthTypeOAuth2 || g.Name != "Google" || len(g.Scopes) != 2 || g.UserInfoEndpoint == "" {
+		t.Fatalf("unexpected oauth2 entry: %+v", g)
+	}
+	if catalog.APIKey["OpenAI"].APIBaseURL != "https://api.openai.com" || catalog.BasicAuth["Jira"].ID != "j-1" {
+		t.Fatalf("unexpected groupings: %+v", catalog)
+	}
+	if catalog.Other["header"]["Github"].ID != "gh-2" {
+		t.Fatalf("other auth types should be kept, got %+v", catalog.Other)
+	}
+	if n := len(catalog.Providers()); n != 5 {
+		t.Fatalf("want 5 providers, got %d", n)
+	}
+}
+
+func TestGetProviderMetadata(t *testing.T) {
+	var calls atomic.Int32
+	c := New(catalogServer(t, &calls).URL)
+	ctx := context.Background()
+
+	p, err := c.GetProviderMetadata(ctx, "google")
+	if err != nil || p.ID != "g-1" {
+		t.Fatalf("GetProviderMetadata(google) = %+v, %v", p, err)
+	}
+	if _, err := c.GetProviderMetadata(ctx, "nope"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("want ErrNotFound, got %v", err)
+	}
+	if _, err := c.GetProviderMetadata(ctx, "GITHUB"); !errors.Is(err, ErrProviderAmbiguous) {
+		t.Fatalf("want ErrProviderAmbiguous, got %v", err)
+	}
+}
+
+func TestResolveProviderID_Caches(t *testing.T) {
+	var calls atomic.Int32
+	c := New(catalogServer(t, &calls).URL)
+	ctx := context.Background()
+
+	for _, name := range []string{"Google", "openai", "Jira"} {
+		if _, err := c.ResolveProviderID(ctx, name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if calls.Load() != 1 {
+		t.Fatalf("want 1 catalog fetch, got %d", calls.Load())
+	}
+	if _, err := c.ResolveProviderID(ctx, "github"); !errors.Is(err, ErrProviderAmbiguous) {
+		t.Fatalf("ambiguous names must not be cached, got %v", err)
+	}
+	c.InvalidateProviderCache()
+	if id, err := c.ResolveProviderID(ctx, "google"); err != nil || id != "g-1" {
+		t.Fatalf("ResolveProviderID = %q, %v", id, err)
+	}
+	if calls.Load() != 3 {
+		t.Fatalf("want a fetch after invalidation, got %d", calls.Load())
+	}
+}
+
+func TestResolveProviderID_CacheDisabled(t *testing.T) {
+	var calls atomic.Int32
+	c := New(catalogServer(t, &calls).URL, WithProviderCacheTTL(0))
+	for i := 0; i < 2; i++ {
+		if _, err := c.ResolveProviderID(context.Background(), "Google"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if calls.Load() != 2 {
+		t.Fatalf("want 2 fetches with the cache disabled, got %d", calls.Load())
+	}
+}
@@ -0,0 +1,79 @@
+package oauthsdk
+
+import "sync"
+
+// Metrics receives retry events from a Client. Implementations must be safe
+// for concurrent use; a Client may be shared by many goroutines.
+type Metrics interface {
+	// IncRetries counts retries that were attempted.
+	IncRetries()
+	// IncRetriesSuppressed counts retries skipped because the retry budget
+	// was exhausted.
+	IncRetriesSuppressed()
+	// SetRetryBudget reports the retry tokens currently available.
+	SetRetryBudget(tokens float64)
+}
+
+// WithMetrics sets a metrics collector for retry and retry budget events.
+func WithMetrics(m Metrics) Option { return func(c *Client) { c.Metrics = m } }
+
+// RetryBudget caps retries across every caller sharing a Client. Each
+// request earns Ratio tokens (up to MaxTokens) and each retry spends one.
+// While the Gateway is healthy the budget stays full; when it degrades and
+// most requests fail, the budget drains and failures are returned at once
+// instead of multiplying load with retries. Retries resume as new requests
+// refill the budget.
+type RetryBudget struct {
+	// Ratio is the retry tokens earned per request. 0.1 allows retries for
+	// roughly 10% of requests once the initial tokens are spent.
+	Ratio float64
+	// MaxTokens caps the tokens that can accumulate, bounding the burst of
+	// retries allowed when failures begin. The budget starts full.
+	MaxTokens float64
+}
+
+// WithRetryBudget shares a retry budget across all requests made by the
+// Client. Without it every request retries independently per RetryPolicy.
+func WithRetryBudget(b RetryBudget) Option {
+	return func(c *Client) { c.retryBudget = newRetryBudget(b) }
+}
+
+type retryBudget struct {
+	mu     sync.Mutex
+	tokens float64
+	max    float64
+	ratio  float64
+}
+
+func newRetryBudget(b RetryBudget) *retryBudget {
+	if b.Ratio < 0 {
+		b.Ratio = 0
+	}
+	if b.MaxTokens <= 0 {
+		b.MaxTokens = 10
+	}
+	return &retryBudget{tokens: b.MaxTokens, max: b.MaxTokens, ratio: b.Ratio}
+}
+
+// deposit credits one request and returns the tokens now available.
+func (b *retryBudget) deposit() float64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens += b.ratio
+	if b.tokens > b.max {
+		b.tokens = b.max
+	}
+	return b.tokens
+}
+
+// withdraw spends a token for one retry. It reports false, leaving the
+// budget unchanged, when less than a whole token is available.
+func (b *retryBudget) withdraw() (float64, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.tokens < 1 {
+		return b.tokens, false
+	}
+	b.tokens--
+	return b.tokens, true
+}
@@ -0,0 +1,95 @@
+package oauthsdk
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type countingMetrics struct {
+	retries    atomic.Int64
+	suppressed atomic.Int64
+	mu         sync.Mutex
+	budget     float64
+}
+
+func (m *countingMetrics) IncRetries()           { m.retries.Add(1) }
+func (m *countingMetrics) IncRetriesSuppressed() { m.suppressed.Add(1) }
+func (m *countingMetrics) SetRetryBudget(tokens float64) {
+	m.mu.Lock()
+	m.budget = tokens
+	m.mu.Unlock()
+}
+
+func TestRetryBudget_SuppressesRetriesAcrossCallers(t *testing.T) {
+	var hits atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	metrics := &countingMetrics{}
+	c := New(srv.URL,
+		WithRetry(RetryPolicy{Retries: 3, MinDelay: time.Millisecond, MaxDelay: 2 * time.Millisecond}),
+		WithRetryBudget(RetryBudget{Ratio: 0, MaxTokens: 5}),
+		WithMetrics(metrics),
+	)
+
+	const callers = 20
+	var wg sync.WaitGroup
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := c.CheckConnection(context.Background(), "abc"); err == nil {
+				t.Error("expected error from degraded gateway")
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Without a budget this would be callers*4 requests.
+	if got := hits.Load(); got != callers+5 {
+		t.Fatalf("want %d requests, got %d", callers+5, got)
+	}
+	if got := metrics.retries.Load(); got != 5 {
+		t.Fatalf("want 5 retries, got %d", got)
+	}
+	if got := metrics.suppressed.Load(); got != callers {
+		t.Fatalf("want %d suppressed retries, got %d", callers, got)
+	}
+	if metrics.budget != 0 {
+		t.Fatalf("want empty budget, got %v", metrics.budget)
+	}
+}
+
+func TestRetryBudget_RefillsFromRequests(t *testing.T) {
+	b := newRetryBudget(RetryBudget{Ratio: 0.5, MaxTokens: 2})
+	for i := 0; i < 2; i++ {
+		if _, ok := b.withdraw(); !ok {
+			t.Fatalf("withdraw %d: want ok from initial tokens", i)
+		}
+	}
+	if _, ok := b.withdraw(); ok {
+		t.Fatal("want budget exhausted")
+	}
+	b.deposit()
+	if _, ok := b.withdraw(); ok {
+		t.Fatal("half a token must not allow a retry")
+	}
+	b.deposit()
+	if _, ok := b.withdraw(); !ok {
+		t.Fatal("want retry allowed after two requests")
+	}
+	for i := 0; i < 10; i++ {
+		b.deposit()
+	}
+	if tokens := b.deposit(); tokens != 2 {
+		t.Fatalf("want tokens capped at 2, got %v", tokens)
+	}
+}
@@ -0,0 +1,145 @@
+package oauthsdk
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// SignatureHeader carries the Gateway's detached JWS over a token response
+// body when the Gateway has a RESPONSE_SIGNING_KEY_FILE.
+const SignatureHeader = "X-Nexus-Signature"
+
+// MaxSignatureAge bounds how old, or how far in the future, a response
+// signature's iat may be before a verifying client rejects the response.
+const MaxSignatureAge = 5 * time.Minute
+
+// WithResponseVerification makes GetToken, RefreshConnection,
+// ExchangeToken and RedeemGrant require a Gateway signature made by one of
+// keys (*rsa.PublicKey, *ecdsa.PublicKey on P-256 or ed25519.PublicKey)
+// over the response, so an intermediary cannot alter a token. Pass the old
+// and the new key while the Gateway's key is rotated. Responses without a
+// valid signature fail with ErrResponseSignature.
+func WithResponseVerification(keys ...crypto.PublicKey) Option {
+	return func(c *Client) { c.responseKeys = append(c.responseKeys, keys...) }
+}
+
+// ParsePublicKeyPEM parses a PEM "PUBLIC KEY" block, such as the public half
+// of the Gateway's signing key, for WithResponseVerification.
+func ParsePublicKeyPEM(data []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PUBLIC KEY" {
+		return nil, errors.New("no PEM public key found")
+	}
+	return x509.ParsePKIXPublicKey(block.Bytes)
+}
+
+// decodeTokenResponse reads a token response into out, first verifying its
+// signature when the client has verification keys.
+func (c *Client) decodeTokenResponse(resp *http.Response, out any) error {
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if len(c.responseKeys) > 0 {
+		path := ""
+		if resp.Request != nil {
+			path = resp.Request.URL.EscapedPath()
+		}
+		if err := verifyResponse(c.responseKeys, resp.Header.Get(SignatureHeader), body, path, time.Now()); err != nil {
+			return err
+		}
+	}
+	return json.Unmarshal(body, out)
+}
+
+// signatureHeader is the protected header the Gateway writes.
+type signatureHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	Iat *int64 `json:"iat"`
+	Htu string `json:"htu"`
+	B64 *bool  `json:"b64"`
+}
+
+// verifyResponse checks sig, a compact JWS with a detached payload, against
+// body: one of keys must have signed it for a request to path (the Gateway
+// may sit below a path prefix, so path need only end with the signed one)
+// within MaxSignatureAge of now.
+func verifyResponse(keys []crypto.PublicKey, sig string, body []byte, path string, now time.Time) error {
+	if sig == "" {
+		return fmt.Errorf("%w: response is not signed", ErrResponseSignature)
+	}
+	parts := strings.Split(sig, ".")
+	if len(parts) != 3 || parts[1] != "" {
+		return fmt.Errorf("%w: malformed signature", ErrResponseSignature)
+	}
+	rawHeader, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return fmt.Errorf("%w: malformed signature header", ErrResponseSignature)
+	}
+	var h signatureHeader
+	if err := json.Unmarshal(rawHeader, &h); err != nil {
+		return fmt.Errorf("%w: malformed signature header", ErrResponseSignature)
+	}
+	rawSig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return fmt.Errorf("%w: malformed signature", ErrResponseSignature)
+	}
+	if h.B64 != nil && !*h.B64 {
+		return fmt.Errorf("%w: unencoded payloads are not supported", ErrResponseSignature)
+	}
+
+	signed := []byte(parts[0] + "." + base64.RawURLEncoding.EncodeToString(body))
+	verified := false
+	for _, key := range keys {
+		if verifySignature(h.Alg, key, signed, rawSig) {
+			verified = true
+			break
+		}
+	}
+	switch {
+	case !verified:
+		return fmt.Errorf("%w: no verification key matches (alg %s, kid %q)", ErrResponseSignature, h.Alg, h.Kid)
+	case h.Htu == "" || !strings.HasSuffix(path, h.Htu):
+		return fmt.Errorf("%w: signed for %q, not %q", ErrResponseSignature, h.Htu, path)
+	case h.Iat == nil:
+		return fmt.Errorf("%w: signature has no iat", ErrResponseSignature)
+	}
+	if age := now.Sub(time.Unix(*h.Iat, 0)); age > MaxSignatureAge || age < -MaxSignatureAge {
+		return fmt.Errorf("%w: signed %s ago", ErrResponseSignature, age.Round(time.Second))
+	}
+	return nil
+}
+
+// verifySignature reports whether key made sig over signed with alg.
+func verifySignature(alg string, key crypto.PublicKey, signed, sig []byte) bool {
+	digest := sha256.Sum256(signed)
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		return alg == "RS256" && rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], sig) == nil
+	case *ecdsa.PublicKey:
+		if alg != "ES256" || k.Curve != elliptic.P256() || len(sig) != 64 {
+			return false
+		}
+		r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+		return ecdsa.Verify(k, digest[:], r, s)
+	case ed25519.PublicKey:
+		return alg == "EdDSA" && ed25519.Verify(k, signed, sig)
+	}
+	return false
+}
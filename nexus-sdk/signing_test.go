@@ -0,0 +1,99 @@
+package oauthsdk
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// signBody makes the detached JWS the Gateway sends in SignatureHeader.
+func signBody(t *testing.T, key crypto.Signer, body []byte, htu string, iat time.Time) string {
+	t.Helper()
+	alg := "EdDSA"
+	if _, ok := key.(*ecdsa.PrivateKey); ok {
+		alg = "ES256"
+	}
+	header, _ := json.Marshal(map[string]any{"alg": alg, "kid": "k1", "iat": iat.Unix(), "htu": htu})
+	protected := base64.RawURLEncoding.EncodeToString(header)
+	signed := []byte(protected + "." + base64.RawURLEncoding.EncodeToString(body))
+	var sig []byte
+	switch k := key.(type) {
+	case ed25519.PrivateKey:
+		sig = ed25519.Sign(k, signed)
+	case *ecdsa.PrivateKey:
+		digest := sha256.Sum256(signed)
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig = make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+	}
+	return protected + ".." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestResponseVerification(t *testing.T) {
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	_, otherKey, _ := ed25519.GenerateKey(rand.Reader)
+	body := []byte(`{"access_token":"at","token_type":"Bearer"}`)
+
+	var signature string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if signature != "" {
+			w.Header().Set(SignatureHeader, signature)
+		}
+		_, _ = w.Write(body)
+	}))
+	defer srv.Close()
+	ctx := context.Background()
+
+	der, _ := x509.MarshalPKIXPublicKey(ecKey.Public())
+	ecPublic, err := ParsePublicKeyPEM(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := New(srv.URL, WithRetry(RetryPolicy{Retries: 0}), WithResponseVerification(edKey.Public(), ecPublic))
+
+	for name, key := range map[string]crypto.Signer{"ed25519": edKey, "p256": ecKey} {
+		signature = signBody(t, key, body, "/v1/token/conn-1", time.Now())
+		tok, err := c.GetToken(ctx, "conn-1")
+		if err != nil || tok.AccessToken != "at" {
+			t.Errorf("%s: GetToken = %+v, %v", name, tok, err)
+		}
+	}
+
+	rejected := map[string]string{
+		"unsigned":         "",
+		"unknown key":      signBody(t, otherKey, body, "/v1/token/conn-1", time.Now()),
+		"other connection": signBody(t, edKey, body, "/v1/token/conn-2", time.Now()),
+		"too old":          signBody(t, edKey, body, "/v1/token/conn-1", time.Now().Add(-time.Hour)),
+		"other body":       signBody(t, edKey, []byte(`{"access_token":"forged"}`), "/v1/token/conn-1", time.Now()),
+	}
+	for name, sig := range rejected {
+		signature = sig
+		if _, err := c.GetToken(ctx, "conn-1"); !errors.Is(err, ErrResponseSignature) {
+			t.Errorf("%s: want ErrResponseSignature, got %v", name, err)
+		}
+	}
+
+	// Without keys the signature is not looked at.
+	signature = ""
+	if _, err := New(srv.URL).GetToken(ctx, "conn-1"); err != nil {
+		t.Errorf("unverified client: %v", err)
+	}
+}
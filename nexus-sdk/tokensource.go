@@ -0,0 +1,177 @@
+package oauthsdk
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultExpiryBuffer is how long before expiry a TokenSource refreshes
+// its token.
+const DefaultExpiryBuffer = time.Minute
+
+// TokenSourceOption configures a TokenSource.
+type TokenSourceOption func(*TokenSource)
+
+// WithExpiryBuffer sets how long before expiry the token is refreshed.
+func WithExpiryBuffer(d time.Duration) TokenSourceOption {
+	return func(ts *TokenSource) { ts.buffer = d }
+}
+
+// TokenSource caches a connection's token in memory, in the manner of
+// oauth2.TokenSource. The first call fetches the token with GetToken; once
+// the token is within the expiry buffer, it is refreshed through the
+// Gateway in the background while callers keep getting the cached token,
+// and only an expired token makes callers wait. Concurrent callers share
+// a single fetch. Tokens without an expiry (static credentials) are cached
+// until Invalidate is called.
+//
+// A TokenSource is safe for concurrent use.
+type TokenSource struct {
+	client       *Client
+	connectionID string
+	buffer       time.Duration
+	now          func() time.Time
+
+	mu       sync.Mutex
+	tok      *TokenResponse
+	expiry   time.Time
+	inflight *tokenFetch
+}
+
+// tokenFetch is a fetch in progress, shared by every caller waiting for it.
+type tokenFetch struct {
+	done chan struct{}
+	tok  *TokenResponse
+	err  error
+}
+
+// TokenSource returns a caching TokenSource for connectionID.
+func (c *Client) TokenSource(connectionID string, opts ...TokenSourceOption) *TokenSource {
+	ts := &TokenSource{client: c, connectionID: connectionID, buffer: DefaultExpiryBuffer, now: time.Now}
+	for _, o := range opts {
+		o(ts)
+	}
+	return ts
+}
+
+// Token returns the cached token, fetching or refreshing it as needed. If
+// a refresh fails while the cached token is still valid, the cached token
+// is returned and the refresh is retried on the next call.
+func (ts *TokenSource) Token(ctx context.Context) (*TokenResponse, error) {
+	ts.mu.Lock()
+	tok, expiry, now := ts.tok, ts.expiry, ts.now()
+	valid := tok != nil && (expiry.IsZero() || now.Before(expiry))
+	if valid && (expiry.IsZero() || now.Before(expiry.Add(-ts.buffer))) {
+		ts.mu.Unlock()
+		return tok, nil
+	}
+	f := ts.inflight
+	if f == nil {
+		f = &tokenFetch{done: make(chan struct{})}
+		ts.inflight = f
+		// The fetch outlives a cancelled caller so the others sharing it
+		// still get a token.
+		go ts.fetch(context.WithoutCancel(ctx), f, tok != nil)
+	}
+	ts.mu.Unlock()
+
+	if valid {
+		return tok, nil
+	}
+	select {
+	case <-f.done:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return f.tok, f.err
+}
+
+// Invalidate drops the cached token, for example after the upstream API
+// rejected it, so the next call fetches a fresh one.
+func (ts *TokenSource) Invalidate() {
+	ts.mu.Lock()
+	ts.tok, ts.expiry = nil, time.Time{}
+	ts.mu.Unlock()
+}
+
+func (ts *TokenSource) fetch(ctx context.Context, f *tokenFetch, refresh bool) {
+	fetched := ts.now()
+	if refresh {
+		f.tok, f.err = ts.client.RefreshConnection(ctx, ts.connectionID)
+	} else {
+		f.tok, f.err = ts.client.GetToken(ctx, ts.connectionID)
+	}
+	ts.mu.Lock()
+	if f.err == nil {
+		ts.tok, ts.expiry = f.tok, tokenExpiry(f.tok, fetched)
+	} else {
+		ts.client.errorf("token source %s: fetch failed: %v", ts.connectionID, f.err)
+	}
+	ts.inflight = nil
+	ts.mu.Unlock()
+	close(f.done)
+}
+
+// tokenExpiry prefers the absolute expires_at (RFC 3339 or Unix seconds)
+// the Gateway adds to stored tokens, whose expires_in is as old as the
+// token; fresh tokens from a refresh carry only expires_in. The zero time
+// means the token does not expire.
+func tokenExpiry(t *TokenResponse, fetched time.Time) time.Time {
+	switch v := t.ExpiresAt.(type) {
+	case string:
+		if at, err := time.Parse(time.RFC3339, v); err == nil {
+			return at
+		}
+	case float64:
+		if v > 0 {
+			return time.Unix(int64(v), 0)
+		}
+	}
+	if t.ExpiresIn != nil && *t.ExpiresIn > 0 {
+		return fetched.Add(time.Duration(*t.ExpiresIn) * time.Second)
+	}
+	return time.Time{}
+}
+
+// Transport is an http.RoundTripper that authorizes requests with the
+// access token from Source, in the manner of oauth2.Transport:
+//
+//	httpClient := &http.Client{Transport: &oauthsdk.Transport{Source: client.TokenSource(connID)}}
+type Transport struct {
+	Source *TokenSource
+	// Base is the underlying RoundTripper; http.DefaultTransport if nil.
+	Base http.RoundTripper
+}
+
+// RoundTrip sets the Authorization header on a copy of req and sends it.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	closeBody := func() {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+	}
+	tok, err := t.Source.Token(req.Context())
+	if err != nil {
+		closeBody()
+		return nil, err
+	}
+	if tok.AccessToken == "" {
+		closeBody()
+		return nil, errors.New("oauthsdk: token has no access_token")
+	}
+	tokenType := "Bearer"
+	if tok.TokenType != nil && *tok.TokenType != "" && !strings.EqualFold(*tok.TokenType, "bearer") {
+		tokenType = *tok.TokenType
+	}
+	r := req.Clone(req.Context())
+	r.Header.Set("Authorization", tokenType+" "+tok.AccessToken)
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return base.RoundTrip(r)
+}
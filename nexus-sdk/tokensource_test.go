@@ -0,0 +1,166 @@
+package oauthsdk
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// tokenGateway serves GET /v1/token/c1 and POST /v1/refresh/c1, counting
+// calls; each refresh returns a new access token valid for an hour.
+type tokenGateway struct {
+	gets, refreshes atomic.Int32
+	expiresAt       string
+	refreshStatus   int
+	release         chan struct{}
+}
+
+func (g *tokenGateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/v1/token/c1":
+		g.gets.Add(1)
+		if g.release != nil {
+			<-g.release
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"access_token": "initial", "token_type": "bearer", "expires_in": 3600, "expires_at": g.expiresAt})
+	case "/v1/refresh/c1":
+		n := g.refreshes.Add(1)
+		if g.refreshStatus != 0 {
+			w.WriteHeader(g.refreshStatus)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"access_token": fmt.Sprintf("refreshed-%d", n), "expires_in": 3600})
+	}
+}
+
+func newTokenSourceForTest(t *testing.T, g *tokenGateway, now *time.Time) *TokenSource {
+	srv := httptest.NewServer(g)
+	t.Cleanup(srv.Close)
+	ts := New(srv.URL, WithRetry(RetryPolicy{Retries: 0})).TokenSource("c1", WithExpiryBuffer(time.Minute))
+	ts.now = func() time.Time { return *now }
+	return ts
+}
+
+// waitIdle waits for a background refresh to finish.
+func waitIdle(ts *TokenSource) {
+	for {
+		ts.mu.Lock()
+		busy := ts.inflight != nil
+		ts.mu.Unlock()
+		if !busy {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestTokenSource_CachesUntilBuffer(t *testing.T) {
+	now := time.Now()
+	g := &tokenGateway{expiresAt: now.Add(10 * time.Minute).UTC().Format(time.RFC3339)}
+	ts := newTokenSourceForTest(t, g, &now)
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		tok, err := ts.Token(ctx)
+		if err != nil || tok.AccessToken != "initial" {
+			t.Fatalf("Token() = %v, %v", tok, err)
+		}
+	}
+	if g.gets.Load() != 1 || g.refreshes.Load() != 0 {
+		t.Fatalf("want 1 get and no refresh, got %d/%d", g.gets.Load(), g.refreshes.Load())
+	}
+
+	// Within the buffer: the cached token is served while refreshing.
+	now = now.Add(9*time.Minute + 30*time.Second)
+	tok, err := ts.Token(ctx)
+	if err != nil || tok.AccessToken != "initial" {
+		t.Fatalf("refresh-ahead should serve the cached token, got %v, %v", tok, err)
+	}
+	waitIdle(ts)
+	tok, _ = ts.Token(ctx)
+	if tok.AccessToken != "refreshed-1" || g.refreshes.Load() != 1 {
+		t.Fatalf("want refreshed-1 after one refresh, got %s (%d)", tok.AccessToken, g.refreshes.Load())
+	}
+}
+
+func TestTokenSource_ExpiredWaitsForRefresh(t *testing.T) {
+	now := time.Now()
+	g := &tokenGateway{expiresAt: now.Add(time.Minute).UTC().Format(time.RFC3339)}
+	ts := newTokenSourceForTest(t, g, &now)
+	if _, err := ts.Token(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(2 * time.Minute)
+	tok, err := ts.Token(context.Background())
+	if err != nil || tok.AccessToken != "refreshed-1" {
+		t.Fatalf("want refreshed token, got %v, %v", tok, err)
+	}
+}
+
+func TestTokenSource_RefreshFailureKeepsValidToken(t *testing.T) {
+	now := time.Now()
+	g := &tokenGateway{expiresAt: now.Add(2 * time.Minute).UTC().Format(time.RFC3339), refreshStatus: http.StatusBadGateway}
+	ts := newTokenSourceForTest(t, g, &now)
+	if _, err := ts.Token(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(90 * time.Second)
+	tok, err := ts.Token(context.Background())
+	waitIdle(ts)
+	if err != nil || tok.AccessToken != "initial" {
+		t.Fatalf("want cached token, got %v, %v", tok, err)
+	}
+	now = now.Add(time.Minute)
+	if _, err := ts.Token(context.Background()); err == nil {
+		t.Fatal("want an error once the token has expired")
+	}
+}
+
+func TestTokenSource_DeduplicatesConcurrentFetches(t *testing.T) {
+	now := time.Now()
+	g := &tokenGateway{release: make(chan struct{})}
+	ts := newTokenSourceForTest(t, g, &now)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := ts.Token(context.Background()); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(g.release)
+	wg.Wait()
+	if g.gets.Load() != 1 {
+		t.Fatalf("want 1 fetch, got %d", g.gets.Load())
+	}
+}
+
+func TestTransport_SetsAuthorization(t *testing.T) {
+	now := time.Now()
+	ts := newTokenSourceForTest(t, &tokenGateway{}, &now)
+	var got string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get("Authorization")
+	}))
+	defer upstream.Close()
+
+	hc := &http.Client{Transport: &Transport{Source: ts}}
+	resp, err := hc.Get(upstream.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if got != "Bearer initial" {
+		t.Fatalf("Authorization = %q", got)
+	}
+}
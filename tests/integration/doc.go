@@ -0,0 +1,5 @@
+// Package integration tests the Broker, Gateway and SDK together against a
+// fake identity provider, all in-process: no Docker, Postgres or Redis.
+// It is a module of its own so the services' modules do not depend on one
+// another.
+package integration
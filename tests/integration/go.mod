@@ -0,0 +1,57 @@
+module github.com/Prescott-Data/nexus-framework/tests/integration
+
+go 1.25.3
+
+require (
+	github.com/Prescott-Data/nexus-framework/nexus-broker v0.0.0-local
+	github.com/Prescott-Data/nexus-framework/nexus-common v0.0.0-local
+	github.com/Prescott-Data/nexus-framework/nexus-gateway v0.0.0-local
+	github.com/Prescott-Data/nexus-framework/nexus-sdk v0.0.0-local
+)
+
+require (
+	filippo.io/edwards25519 v1.1.0 // indirect
+	github.com/Prescott-Data/nexus-framework/nexus-bridge v0.0.0-local // indirect
+	github.com/apapsch/go-jsonmerge/v2 v2.0.0 // indirect
+	github.com/aws/aws-sdk-go-v2 v1.41.0 // indirect
+	github.com/aws/smithy-go v1.24.0 // indirect
+	github.com/beorn7/perks v1.0.1 // indirect
+	github.com/cespare/xxhash/v2 v2.3.0 // indirect
+	github.com/coreos/go-oidc/v3 v3.10.0 // indirect
+	github.com/dgryski/go-rendezvous v0.0.0-20200823014737-9f7001d12a5f // indirect
+	github.com/go-chi/chi/v5 v5.2.5 // indirect
+	github.com/go-chi/cors v1.2.2 // indirect
+	github.com/go-jose/go-jose/v4 v4.1.4 // indirect
+	github.com/go-redis/redis/v8 v8.11.5 // indirect
+	github.com/go-sql-driver/mysql v1.8.1 // indirect
+	github.com/google/uuid v1.6.0 // indirect
+	github.com/gorilla/websocket v1.5.3 // indirect
+	github.com/jmoiron/sqlx v1.4.0 // indirect
+	github.com/lib/pq v1.10.9 // indirect
+	github.com/mattn/go-sqlite3 v1.14.22 // indirect
+	github.com/munnerz/goautoneg v0.0.0-20191010083416-a7dc8b61c822 // indirect
+	github.com/oapi-codegen/runtime v1.1.2 // indirect
+	github.com/prometheus/client_golang v1.23.2 // indirect
+	github.com/prometheus/client_model v0.6.2 // indirect
+	github.com/prometheus/common v0.66.1 // indirect
+	github.com/prometheus/procfs v0.16.1 // indirect
+	go.yaml.in/yaml/v2 v2.4.2 // indirect
+	golang.org/x/crypto v0.50.0 // indirect
+	golang.org/x/net v0.52.0 // indirect
+	golang.org/x/oauth2 v0.36.0 // indirect
+	golang.org/x/sys v0.43.0 // indirect
+	golang.org/x/text v0.36.0 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20260120221211-b8f7ae30c516 // indirect
+	google.golang.org/grpc v1.80.0 // indirect
+	google.golang.org/protobuf v1.36.11 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
+)
+
+// The services are tested as they are in this checkout.
+replace (
+	github.com/Prescott-Data/nexus-framework/nexus-bridge => ../../nexus-bridge
+	github.com/Prescott-Data/nexus-framework/nexus-broker => ../../nexus-broker
+	github.com/Prescott-Data/nexus-framework/nexus-common => ../../nexus-common
+	github.com/Prescott-Data/nexus-framework/nexus-gateway => ../../nexus-gateway
+	github.com/Prescott-Data/nexus-framework/nexus-sdk => ../../nexus-sdk
+)
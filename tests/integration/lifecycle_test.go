@@ -0,0 +1,292 @@
+package integration
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/Prescott-Data/nexus-framework/nexus-broker/pkg/brokertest"
+	"github.com/Prescott-Data/nexus-framework/nexus-common/testutil/fakeidp"
+	"github.com/Prescott-Data/nexus-framework/nexus-gateway/pkg/config"
+	"github.com/Prescott-Data/nexus-framework/nexus-gateway/pkg/server"
+	"github.com/Prescott-Data/nexus-framework/nexus-gateway/pkg/usecase"
+	nexus "github.com/Prescott-Data/nexus-framework/nexus-sdk"
+)
+
+// returnURL is where the Broker sends the user back. Nothing listens
+// there: the browser stops at the redirect.
+const returnURL = "https://app.test/connected"
+
+// stack is a fake IdP, a Broker whose provider is that IdP, and a Gateway
+// in front of the Broker that authenticates agents with the IdP's tokens.
+type stack struct {
+	idp        *fakeidp.IdP
+	broker     *brokertest.Broker
+	gatewayURL string
+	providerID string
+	// agent calls the Gateway with an access token from the IdP.
+	agent *nexus.Client
+}
+
+func newStack(t *testing.T, idpConfig fakeidp.Config) *stack {
+	t.Helper()
+	idp := fakeidp.New(t, idpConfig)
+
+	// The Gateway proxies /auth/callback, so the Broker's redirect URI is
+	// on the Gateway, whose URL must be known before the Broker starts.
+	gatewaySrv := httptest.NewUnstartedServer(nil)
+	gatewayURL := "http://" + gatewaySrv.Listener.Addr().String()
+	broker := brokertest.New(t, brokertest.Config{BaseURL: gatewayURL, APIKey: "broker-key"})
+	gateway := server.New(&config.GatewayConfig{
+		Port:          "0",
+		BrokerBaseURL: broker.URL,
+		BrokerAPIKey:  "broker-key",
+		StateKeys:     broker.States,
+		Auth: config.AuthConfig{
+			Methods:     []string{config.AuthJWT},
+			JWTIssuer:   idp.URL,
+			JWTAudience: idp.ClientID(),
+			ExemptPaths: []string{"/v1/capture-schema", "/v1/capture-credential"},
+		},
+	}, nil, usecase.BuildInfo{})
+	gatewaySrv.Config.Handler = gateway.Handler()
+	gatewaySrv.Start()
+	t.Cleanup(gatewaySrv.Close)
+
+	providerID := broker.AddProvider(brokertest.Provider{
+		Name:         "fake-idp",
+		AuthURL:      idp.AuthURL(),
+		TokenURL:     idp.TokenURL(),
+		ClientID:     idp.ClientID(),
+		ClientSecret: idp.ClientSecret(),
+		Params:       json.RawMessage(`{"token_max_retries":0}`),
+	})
+
+	resp, err := http.PostForm(idp.TokenURL(), url.Values{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {idp.ClientID()},
+		"client_secret": {idp.ClientSecret()},
+	})
+	if err != nil {
+		t.Fatalf("agent token: %v", err)
+	}
+	defer resp.Body.Close()
+	var tok struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tok); err != nil || tok.AccessToken == "" {
+		t.Fatalf("agent token: %d %v", resp.StatusCode, err)
+	}
+	agent := nexus.New(gatewayURL, nexus.WithHTTPClient(&http.Client{
+		Transport: bearer{token: tok.AccessToken, next: http.DefaultTransport},
+	}))
+
+	return &stack{idp: idp, broker: broker, gatewayURL: gatewayURL, providerID: providerID, agent: agent}
+}
+
+// bearer adds an Authorization header to each request.
+type bearer struct {
+	token string
+	next  http.RoundTripper
+}
+
+func (b bearer) RoundTrip(r *http.Request) (*http.Response, error) {
+	r = r.Clone(r.Context())
+	r.Header.Set("Authorization", "Bearer "+b.token)
+	return b.next.RoundTrip(r)
+}
+
+// consent requests a connection for scopes as the agent, then follows its
+// auth URL as the user's browser would, through the IdP and the Gateway's
+// callback, and returns the connection ID and the query the user is sent
+// back to returnURL with.
+func (s *stack) consent(t *testing.T, scopes ...string) (string, url.Values) {
+	t.Helper()
+	ctx := context.Background()
+	req, err := s.agent.RequestConnection(ctx, nexus.RequestConnectionInput{
+		UserID:       "ws-1",
+		ProviderName: "fake-idp",
+		Scopes:       scopes,
+		ReturnURL:    returnURL,
+	})
+	if err != nil {
+		t.Fatalf("RequestConnection: %v", err)
+	}
+	if status, err := s.agent.CheckConnection(ctx, req.ConnectionID); err != nil || status != "pending" {
+		t.Fatalf("status before consent = %q, %v", status, err)
+	}
+
+	jar, _ := cookiejar.New(nil)
+	browser := &http.Client{Jar: jar, CheckRedirect: func(r *http.Request, via []*http.Request) error {
+		if r.URL.Host == "app.test" {
+			return http.ErrUseLastResponse
+		}
+		return nil
+	}}
+	resp, err := browser.Get(req.AuthURL)
+	if err != nil {
+		t.Fatalf("consent: %v", err)
+	}
+	resp.Body.Close()
+	back, err := url.Parse(resp.Header.Get("Location"))
+	if resp.StatusCode != http.StatusFound || err != nil || back.Host != "app.test" {
+		t.Fatalf("consent ended with %d at %q", resp.StatusCode, resp.Header.Get("Location"))
+	}
+	if back.Query().Get("connection_id") != req.ConnectionID {
+		t.Errorf("returned connection_id = %q, want %q", back.Query().Get("connection_id"), req.ConnectionID)
+	}
+	return req.ConnectionID, back.Query()
+}
+
+func TestConnectionLifecycle(t *testing.T) {
+	s := newStack(t, fakeidp.Config{RotateRefreshTokens: true})
+	ctx := context.Background()
+
+	connectionID, back := s.consent(t, "openid", "email", "offline_access")
+	if back.Get("status") != "success" {
+		t.Fatalf("consent returned %v", back)
+	}
+	if status, err := s.agent.CheckConnection(ctx, connectionID); err != nil || status != "active" {
+		t.Fatalf("status after consent = %q, %v", status, err)
+	}
+
+	tok, err := s.agent.GetToken(ctx, connectionID)
+	if err != nil || tok.AccessToken == "" {
+		t.Fatalf("GetToken = %+v, %v", tok, err)
+	}
+
+	// Each refresh gets a new access token, and the rotated refresh token
+	// is the one used next.
+	previous := tok.AccessToken
+	for i := 0; i < 2; i++ {
+		refreshed, err := s.agent.RefreshConnection(ctx, connectionID)
+		if err != nil || refreshed.AccessToken == "" || refreshed.AccessToken == previous {
+			t.Fatalf("refresh %d = %+v, %v", i+1, refreshed, err)
+		}
+		previous = refreshed.AccessToken
+	}
+	if tok, err := s.agent.GetToken(ctx, connectionID); err != nil || tok.AccessToken != previous {
+		t.Errorf("GetToken after refresh = %+v, %v", tok, err)
+	}
+
+	var grants []string
+	for _, form := range s.idp.TokenRequests() {
+		if form.Get("grant_type") != "client_credentials" {
+			grants = append(grants, form.Get("grant_type"))
+		}
+	}
+	if len(grants) != 3 || grants[0] != "authorization_code" || grants[1] != "refresh_token" || grants[2] != "refresh_token" {
+		t.Errorf("IdP token grants = %v", grants)
+	}
+	if code := s.idp.TokenRequests()[1]; code.Get("code_verifier") == "" || code.Get("redirect_uri") != s.gatewayURL+"/auth/callback" {
+		t.Errorf("code exchange = %v", code)
+	}
+}
+
+func TestConsentDenied(t *testing.T) {
+	s := newStack(t, fakeidp.Config{})
+	s.idp.Misbehave(fakeidp.DenyConsent)
+
+	connectionID, back := s.consent(t, "email")
+	if back.Get("status") != "error" || back.Get("code") != "consent_denied" {
+		t.Fatalf("consent returned %v", back)
+	}
+	status, err := s.agent.ConnectionStatus(context.Background(), connectionID)
+	if err != nil || status.Status != "failed" || status.ErrorCode != "consent_denied" {
+		t.Errorf("status = %+v, %v", status, err)
+	}
+}
+
+func TestInvalidIDToken(t *testing.T) {
+	for _, b := range []fakeidp.Behavior{fakeidp.BadSignature, fakeidp.WrongAudience, fakeidp.WrongNonce, fakeidp.ExpiredIDToken} {
+		t.Run(string(b), func(t *testing.T) {
+			s := newStack(t, fakeidp.Config{})
+			s.idp.Misbehave(b)
+
+			connectionID, back := s.consent(t, "openid", "email")
+			if back.Get("status") != "error" || back.Get("code") != "invalid_id_token" {
+				t.Fatalf("consent returned %v", back)
+			}
+			if _, err := s.agent.GetToken(context.Background(), connectionID); err == nil {
+				t.Error("GetToken succeeded for a connection whose id_token failed verification")
+			}
+		})
+	}
+}
+
+func TestTokenExchangeFailure(t *testing.T) {
+	for _, b := range []fakeidp.Behavior{fakeidp.ServerError, fakeidp.RateLimited, fakeidp.MalformedResponse, fakeidp.InvalidGrant} {
+		t.Run(string(b), func(t *testing.T) {
+			s := newStack(t, fakeidp.Config{})
+			s.idp.Misbehave(b)
+
+			connectionID, back := s.consent(t, "email")
+			if back.Get("status") != "error" || back.Get("code") != "token_exchange_failed" {
+				t.Fatalf("consent returned %v", back)
+			}
+			// A code is never redeemed twice, even after a 5xx.
+			if n := s.idp.Requests(fakeidp.TokenPath); n != 2 {
+				t.Errorf("IdP token requests = %d, want the agent's and one exchange", n)
+			}
+			if status, _ := s.agent.CheckConnection(context.Background(), connectionID); status != "failed" {
+				t.Errorf("status = %q", status)
+			}
+		})
+	}
+}
+
+func TestRefreshRevoked(t *testing.T) {
+	s := newStack(t, fakeidp.Config{})
+	ctx := context.Background()
+	connectionID, back := s.consent(t, "email", "offline_access")
+	if back.Get("status") != "success" {
+		t.Fatalf("consent returned %v", back)
+	}
+
+	// A provider that is down leaves the connection as it is.
+	s.idp.Misbehave(fakeidp.ServerError)
+	if _, err := s.agent.RefreshConnection(ctx, connectionID); err == nil {
+		t.Fatal("refresh succeeded while the IdP was down")
+	}
+	if status, _ := s.agent.CheckConnection(ctx, connectionID); status != "active" {
+		t.Errorf("status after a failed refresh = %q", status)
+	}
+
+	// One that revoked the grant needs the user to consent again.
+	s.idp.Misbehave(fakeidp.Normal)
+	s.idp.RevokeTokens()
+	_, err := s.agent.RefreshConnection(ctx, connectionID)
+	var apiErr *nexus.APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusConflict {
+		t.Fatalf("refresh after revocation = %v", err)
+	}
+	if status, _ := s.agent.CheckConnection(ctx, connectionID); status != "needs_reauth" {
+		t.Errorf("status after revocation = %q", status)
+	}
+}
+
+func TestUnauthenticatedAgent(t *testing.T) {
+	s := newStack(t, fakeidp.Config{})
+	body, _ := json.Marshal(map[string]any{"user_id": "ws-1", "provider_id": s.providerID, "return_url": returnURL})
+	for _, token := range []string{"", "not-a-jwt"} {
+		req, _ := http.NewRequest("POST", s.gatewayURL+"/v1/request-connection", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("token %q: status = %d", token, resp.StatusCode)
+		}
+	}
+}